@@ -1,152 +1,172 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"time"
+	"net/url"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
 	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
-	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/tiflow/pkg/logutil"
 	"github.com/spf13/cobra"
-	"github.com/thediveo/enumflag"
-	"go.uber.org/zap"
 )
 
 func NewBigQueryCmd() *cobra.Command {
 	var (
-		tidbConfigFromCli     tidbsql.TiDBConfig
+		opts = ReplicationOptions{warehouse: Warehouse{
+			Name:              "bigquery",
+			Title:             "BigQuery",
+			StorageSchemes:    []string{"gs", "gcs"},
+			StorageUsage:      "gs://<bucket>/<path> or gcs://<bucket>/<path>",
+			Compressions:      []utils.Compression{utils.CompressionGzip},
+			SnapshotFileSize:  "1GiB",
+			IdentifierCase:    identcase.Preserve,
+			JSONType:          "JSON",
+			RouteSchema:       "bigquery dataset",
+			RouteTarget:       "<dataset>[.<table>]",
+			SchemaRouteTarget: "{source_db}=>raw_{source_db}",
+			Namespaces:        1,
+		}}
 		bigqueryConfigFromCli bigquerysql.BigQueryConfig
-		tables                []string
-		snapshotConcurrency   int
-		storagePath           string
-		cdcHost               string
-		cdcPort               int
-		cdcFlushInterval      time.Duration
-		cdcFileSize           int64
-		logFile               string
-		logLevel              string
-
-		mode          RunMode
-		apiListenHost string
-		apiListenPort int
+		maxBadRows            int64
+		maxMergeRows          int64
+		partitionByValues     []string
+		clusterByValues       []string
 	)
 
-	run := func() error {
-		err := logutil.InitLogger(&logutil.Config{
-			Level: logLevel,
-			File:  logFile,
-		})
+	run := func(ctx context.Context, status *apiservice.APIInfo) error {
+		if maxBadRows < 0 {
+			return errors.Errorf("--max-bad-rows must not be negative, got %d", maxBadRows)
+		}
+		if maxBadRows > 0 && bigqueryConfigFromCli.MaxStaleness > 0 {
+			// the external table is queried by MERGE without a load job
+			return errors.New("--max-bad-rows is not supported with --bq.max-staleness")
+		}
+		if maxMergeRows < 0 {
+			return errors.Errorf("--max-merge-rows must not be negative, got %d", maxMergeRows)
+		}
+		opts.storageCredentials.GCSCredentialsFile = bigqueryConfigFromCli.CredentialsFilePath
+		cfg, err := opts.pipelineConfig(status, routing.Target{Schema: bigqueryConfigFromCli.DatasetID})
 		if err != nil {
 			return errors.Trace(err)
 		}
+		defer opts.close()
 
-		storageURI, err := getGCSURIWithCredentials(storagePath, bigqueryConfigFromCli.CredentialsFilePath)
-		if err != nil {
+		if err = checkBigQueryTimeZone(opts.tidbConfig.TimeZone); err != nil {
 			return errors.Trace(err)
 		}
-
-		snapshotURI, incrementURI, err := genSnapshotAndIncrementURIs(storageURI)
+		layouts, err := loadTableLayouts("bq.partition-by", partitionByValues, "bq.cluster-by", clusterByValues, cfg.Tables, opts.findsNewTables())
 		if err != nil {
 			return errors.Trace(err)
 		}
 
-		snapConnectorMap := make(map[string]coreinterfaces.Connector)
-		increConnectorMap := make(map[string]coreinterfaces.Connector)
-		for _, tableFQN := range tables {
-			_, sourceTable := utils.SplitTableFQN(tableFQN)
-			bqClient, err := bigqueryConfigFromCli.NewClient()
-			if err != nil {
-				return errors.Trace(err)
+		// the external tables are named after the tables in the dataset, which are unique
+		targetTableName := func(tableFQN string, target routing.Target) string {
+			if target.Table != "" {
+				return target.Table
 			}
-			snapConnector, err := bigquerysql.NewBigQueryConnector(
+			_, sourceTable := utils.SplitTableFQN(tableFQN)
+			return sourceTable
+		}
+		// the client is nil in dry run, the connectors record the queries and the jobs instead
+		newConnector := func(bqClient *bigquery.Client, tableFQN string, target routing.Target, kind string, uri *url.URL, compression utils.Compression) (*bigquerysql.BigQueryConnector, error) {
+			_, sourceTable := utils.SplitTableFQN(tableFQN)
+			connector, err := bigquerysql.NewBigQueryConnector(
 				bqClient,
-				fmt.Sprintf("snapshot_external_%s", sourceTable),
-				bigqueryConfigFromCli.DatasetID,
+				opts.identifierCase,
+				fmt.Sprintf("%s_external_%s", kind, targetTableName(tableFQN, target)),
+				target.Schema,
 				sourceTable,
-				snapshotURI,
+				uri,
+				compression,
+				&bigqueryConfigFromCli,
 			)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			snapConnectorMap[tableFQN] = snapConnector
-			if err != nil {
-				return errors.Trace(err)
+			connector.SetColumnTypes(cfg.ColumnMapping.Table(tableFQN))
+			connector.SetColumnFilter(cfg.ColumnFilter.Table(tableFQN))
+			connector.SetDeleteMode(opts.deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			// the statements are rendered in dry run, and logged with --sql-audit-log when they are run
+			if opts.recorder != nil {
+				connector.EnableDryRun(func(statement string) { opts.recorder.Record(tableFQN, statement) })
 			}
-
-			increConnector, err := bigquerysql.NewBigQueryConnector(
-				bqClient,
-				fmt.Sprintf("increment_external_%s", sourceTable),
-				bigqueryConfigFromCli.DatasetID,
-				sourceTable,
-				incrementURI,
-			)
-			if err != nil {
-				return errors.Trace(err)
+			if opts.auditLogger != nil {
+				connector.EnableAuditLog(opts.auditLogger, tableFQN)
 			}
-			increConnectorMap[tableFQN] = increConnector
+			return connector, nil
 		}
-
-		defer func() {
-			for _, connector := range snapConnectorMap {
-				connector.Close()
-			}
-			for _, connector := range increConnectorMap {
-				connector.Close()
-			}
-		}()
-
-		return Replicate(
-			&tidbConfigFromCli, tables, storageURI, snapshotConcurrency,
-			cdcHost, cdcPort, cdcFlushInterval, cdcFileSize,
-			snapConnectorMap, increConnectorMap, mode,
-		)
-	}
-
-	cmd := &cobra.Command{
-		Use:   "bigquery",
-		Short: "Replicate snapshot and incremental data from TiDB to BigQuery",
-		Run: func(_ *cobra.Command, _ []string) {
-			runWithServer(mode == RunModeCloud, fmt.Sprintf("%s:%d", apiListenHost, apiListenPort), func() {
-				if err := run(); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusFatalError(err)
-					log.Error("Fatal error running bigquery replication", zap.Error(err))
-				} else {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusIdle()
+		newConnectors := func(tableFQN string, target routing.Target) (tableConnectors, error) {
+			var bqClient *bigquery.Client
+			if opts.recorder == nil {
+				var err error
+				if bqClient, err = bigqueryConfigFromCli.NewClient(); err != nil {
+					return tableConnectors{}, errors.Trace(err)
 				}
-			})
-		},
+			}
+			return tableConnectors{
+				snapshot: func(uri *url.URL) (coreinterfaces.Connector, error) {
+					return newConnector(bqClient, tableFQN, target, "snapshot", uri, cfg.SnapshotCompression)
+				},
+				increment: func() (coreinterfaces.Connector, error) {
+					connector, err := newConnector(bqClient, tableFQN, target, "increment", opts.incrementURI, cfg.IncrementCompression)
+					if err != nil {
+						return nil, errors.Trace(err)
+					}
+					connector.SetWhere(cfg.Where[tableFQN])
+					connector.SetMaxBadRows(maxBadRows)
+					connector.SetMaxMergeRows(maxMergeRows)
+					return connector, nil
+				},
+			}, nil
+		}
+		return opts.runPipeline(ctx, cfg, newConnectors)
 	}
 
-	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
-	cmd.Flags().Var(enumflag.New(&mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive), "mode", "replication mode: full, snapshot-only, incremental-only, cloud")
-	cmd.Flags().StringVar(&apiListenHost, "api.host", "0.0.0.0", "API service listen host, only available in --mode=cloud")
-	cmd.Flags().IntVar(&apiListenPort, "api.port", 8185, "API service listen port, only available in --mode=cloud")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
-	cmd.Flags().IntVarP(&tidbConfigFromCli.Port, "tidb.port", "P", 4000, "TiDB port")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.User, "tidb.user", "u", "root", "TiDB user")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Pass, "tidb.pass", "p", "", "TiDB password")
-	cmd.Flags().StringVar(&tidbConfigFromCli.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
-	cmd.Flags().StringVarP(&bigqueryConfigFromCli.ProjectID, "bq.project-id", "", "", "BigQuery project id")
-	cmd.Flags().StringVarP(&bigqueryConfigFromCli.DatasetID, "bq.dataset-id", "", "", "BigQuery dataset id")
-	cmd.Flags().StringVarP(&bigqueryConfigFromCli.CredentialsFilePath, "credentials-file-path", "", "", "Google application credentials file path")
-	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
-	cmd.Flags().IntVar(&snapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
-	cmd.Flags().StringVarP(&storagePath, "storage", "s", "", "storage path: gs://<bucket>/<path>")
-	cmd.Flags().StringVar(&cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
-	cmd.Flags().IntVar(&cdcPort, "cdc.port", 8300, "TiCDC server port")
-	cmd.Flags().DurationVar(&cdcFlushInterval, "cdc.flush-interval", 60*time.Second, "")
-	cmd.Flags().Int64Var(&cdcFileSize, "cdc.file-size", 64*1024*1024, "")
-	cmd.Flags().StringVar(&logFile, "log.file", "", "log file path")
-	cmd.Flags().StringVar(&logLevel, "log.level", "info", "log level")
-
-	cmd.MarkFlagRequired("storage")
+	cmd := opts.newCommand(run)
+	addBigQueryFlags(cmd, &bigqueryConfigFromCli)
+	cmd.Flags().BoolVar(&bigqueryConfigFromCli.CreateDataset, "create-target-schema", false, "create the bigquery dataset of the tables if it does not exist, otherwise a missing dataset fails the replication")
+	cmd.Flags().DurationVar(&bigqueryConfigFromCli.MergeInterval, "bq.merge-interval", 0, "minimal interval between two merges of the same table, increment files are staged until it elapses")
+	cmd.Flags().BoolVar(&bigqueryConfigFromCli.PartitionPruning, "bq.partition-pruning", false, "restrict merges to the partitions touched by the batch, the partitioning column must never be updated")
+	cmd.Flags().DurationVar(&bigqueryConfigFromCli.MaxStaleness, "bq.max-staleness", 0, "read increment files through a BigLake external table with metadata caching and this max staleness, between 30m and 168h")
+	cmd.Flags().StringVar(&bigqueryConfigFromCli.ConnectionID, "bq.connection", "", "BigLake connection used by the external table, required by --bq.max-staleness")
+	cmd.Flags().StringArrayVar(&partitionByValues, "bq.partition-by", []string{}, "partition a table created in BigQuery by a DATE, DATETIME or TIMESTAMP column by day, or an INT64 column with its range, e.g. --bq.partition-by 'db.t=created_at' or 'db.t=id:0:1000000:1000'")
+	cmd.Flags().StringArrayVar(&clusterByValues, "bq.cluster-by", []string{}, "cluster a table created in BigQuery by up to 4 columns, e.g. --bq.cluster-by 'db.t=tenant_id,kind'")
+	cmd.Flags().Int64Var(&maxMergeRows, "max-merge-rows", 0, "max staged rows of the increment merged by one MERGE, more rows are merged by a MERGE per partition of the primary key so no MERGE runs into the timeout of BigQuery, 0 merges all the staged rows by one MERGE")
+	addMaxBadRowsFlag(cmd, &maxBadRows, "BigQuery", "a string not matching its column type")
+	opts.addDeleteModeFlag(cmd)
+	opts.addStagingFormatFlag(cmd)
 	cmd.MarkFlagRequired("bq.project-id")
 	cmd.MarkFlagRequired("bq.dataset-id")
-
 	return cmd
 }
+
+// checkBigQueryTimeZone checks the TIMESTAMP values are written in UTC, BigQuery reads a TIMESTAMP value without
+// offset in UTC while a DATETIME value has no time zone
+func checkBigQueryTimeZone(timeZone string) error {
+	switch timeZone {
+	case "":
+		log.Warn("BigQuery reads the TIMESTAMP values in UTC, they are shifted unless TiDB and TiCDC run in UTC, set --tz=UTC")
+		return nil
+	case "UTC", "Etc/UTC":
+		return nil
+	}
+	return errors.Errorf("--tz %s shifts the TIMESTAMP values, which BigQuery reads in UTC, set --tz=UTC and run TiCDC with --tz=UTC", timeZone)
+}
+
+// addBigQueryFlags adds the flags of the connection to BigQuery
+func addBigQueryFlags(cmd *cobra.Command, cfg *bigquerysql.BigQueryConfig) {
+	cmd.Flags().StringVarP(&cfg.ProjectID, "bq.project-id", "", "", "BigQuery project id")
+	cmd.Flags().StringVarP(&cfg.DatasetID, "bq.dataset-id", "", "", "BigQuery dataset id")
+	cmd.Flags().StringVarP(&cfg.CredentialsFilePath, "credentials-file-path", "", "", "Google application credentials file path of BigQuery and the GCS storage, GOOGLE_APPLICATION_CREDENTIALS or the application default credentials by default")
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// NewCleanupCmd returns the command deleting the increment files merged into the data warehouse as recorded
+// by the increment checkpoint, e.g. those kept by --cleanup-retain or --cleanup-consumed-files=false
+func NewCleanupCmd() *cobra.Command {
+	var (
+		storagePath      string
+		s3Options        S3Options
+		awsAccessKey     string
+		awsSecretKey     string
+		awsProfile       string
+		gcsCredentials   string
+		azureAccountName string
+		azureAccountKey  string
+		logFile          string
+		logLevel         string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: logLevel, File: logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		storagePath, err := applyS3Options(storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err = normalizeStoragePath(storagePath, "s3", "gs", "gcs", "azure", "azblob")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{
+			AWSAccessKey:       awsAccessKey,
+			AWSSecretKey:       awsSecretKey,
+			AWSProfile:         awsProfile,
+			GCSCredentialsFile: gcsCredentials,
+			AzureAccountName:   azureAccountName,
+			AzureAccountKey:    azureAccountKey,
+		}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, incrementURI, err := engine.GenSnapshotAndIncrementURIs(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// each shard of --increment-shards has its own checkpoint
+		shardURIs, err := engine.FindIncrementShardURIs(ctx, incrementURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, shardURI := range shardURIs {
+			incrementStorage, err := utils.GetExternalStorageFromURI(ctx, shardURI.String())
+			if err != nil {
+				return errors.Trace(err)
+			}
+			checkpoint, err := replicate.LoadIncrementCheckpoint(ctx, incrementStorage)
+			if err != nil {
+				return errors.Annotate(err, "Failed to load increment checkpoint")
+			}
+			deleted, err := replicate.CleanupMergedFiles(ctx, incrementStorage, checkpoint)
+			log.Info("Deleted merged increment files", zap.String("storage", utils.RedactStorageURI(shardURI)), zap.Int("files", deleted))
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Delete the increment files merged into the data warehouse from the storage",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	cmd.Flags().StringVarP(&storagePath, "storage", "s", "", "storage path of the replication: s3://<bucket>/<path>, gs://<bucket>/<path> or azure://<container>/<path>")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+	cmd.Flags().StringVar(&gcsCredentials, "gcs.credentials-file", "", "gcs service account key file, GOOGLE_APPLICATION_CREDENTIALS by default")
+	cmd.Flags().StringVar(&azureAccountName, "azure.account-name", "", "azure storage account name, AZURE_STORAGE_ACCOUNT by default")
+	cmd.Flags().StringVar(&azureAccountKey, "azure.account-key", "", "azure storage account key, AZURE_STORAGE_KEY or Azure AD by default")
+	cmd.Flags().StringVar(&logFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&logLevel, "log.level", "info", "log level")
+
+	cmd.MarkFlagRequired("storage")
+
+	return cmd
+}
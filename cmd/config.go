@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/configfile"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+)
+
+// addConfigFlag adds --config, the file gives the values of the flags not given on the command line and the
+// per-table overrides of the incremental workers
+func addConfigFlag(cmd *cobra.Command, path *string) {
+	cmd.Flags().StringVar(path, configfile.FlagName, "", "TOML file of the flags, e.g. host = \"127.0.0.1\" in section [tidb] for --tidb.host, ${ENV_VAR} in a string is replaced by the environment variable, and of the per-table overrides, e.g. [tables.\"db.events\"] increment_workers = 4, see `tidb2dw config example`")
+	// run before the required flags are checked, so that they can be given by the file
+	cmd.PreRunE = func(cmd *cobra.Command, _ []string) error {
+		if *path == "" {
+			return nil
+		}
+		return errors.Trace(configfile.Apply(cmd.Flags(), *path))
+	}
+}
+
+// tablesExample is the per-table overrides of the incremental workers in the example config files
+const tablesExample = `
+# the per-table overrides of the incremental workers
+# [tables."db.events"]
+# increment_workers = 4
+# merge_interval = "30s"
+`
+
+// routesExample is the routes of the tables in the example config files, the same as --route
+const routesExample = `
+# the tables replicated into other schemas or tables, the same as --route
+# [routes]
+# "app.orders" = "%s"
+`
+
+// routeTargetExamples are the targets of the routes in the example config files
+var routeTargetExamples = map[string]string{
+	"snowflake":  "ANALYTICS.PUBLIC.ORDERS_V2",
+	"redshift":   "analytics.orders_v2",
+	"bigquery":   "analytics.orders_v2",
+	"databricks": "main.analytics.orders_v2",
+	"postgres":   "analytics.orders_v2",
+}
+
+// configTargets are the commands with an example config file
+var configTargets = map[string]func() *cobra.Command{
+	"snowflake":  NewSnowflakeCmd,
+	"redshift":   NewRedshiftCmd,
+	"bigquery":   NewBigQueryCmd,
+	"databricks": NewDatabricksCmd,
+	"postgres":   NewPostgresCmd,
+}
+
+// NewConfigCmd returns the command of the config files given by --config
+func NewConfigCmd() *cobra.Command {
+	targets := make([]string, 0, len(configTargets))
+	for target := range configTargets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Config files given by --config",
+	}
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+
+	exampleCmd := &cobra.Command{
+		Use:       "example <" + strings.Join(targets, "|") + ">",
+		Short:     "Print a config file of the data warehouse, every field commented out with its default value",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: targets,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			newTargetCmd, ok := configTargets[args[0]]
+			if !ok {
+				return errors.Errorf("unknown target %s, expected one of %s", args[0], strings.Join(targets, ", "))
+			}
+			if err := configfile.WriteExample(cmd.OutOrStdout(), "tidb2dw "+args[0], newTargetCmd().Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			_, err := fmt.Fprint(cmd.OutOrStdout(), tablesExample, fmt.Sprintf(routesExample, routeTargetExamples[args[0]]))
+			return errors.Trace(err)
+		},
+	}
+	cmd.AddCommand(exampleCmd)
+	return cmd
+}
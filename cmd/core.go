@@ -5,240 +5,1243 @@ import (
 	"fmt"
 	"net"
 	"net/url"
-	"sync"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
 	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
 	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/notify"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap-inc/tidb2dw/replicate"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/tidb/br/pkg/storage"
-	putil "github.com/pingcap/tiflow/pkg/util"
+	"github.com/spf13/cobra"
 	"github.com/thediveo/enumflag"
 	"go.uber.org/zap"
 )
 
-type RunMode enumflag.Flag
+// mergeTables merges the tables given by --table and --tables, duplicates are removed. No table is given if
+// withPatterns, the tables are given by --table-pattern or --database.
+func mergeTables(tables, tableList []string, withPatterns bool) ([]string, error) {
+	merged := make([]string, 0, len(tables)+len(tableList))
+	for _, table := range append(slices.Clone(tables), tableList...) {
+		table = strings.TrimSpace(table)
+		if table == "" {
+			continue
+		}
+		if sourceDatabase, sourceTable := utils.SplitTableFQN(table); sourceDatabase == "" || sourceTable == "" {
+			return nil, errors.Errorf("invalid table %s, expected <db>.<table>", table)
+		}
+		if !slices.Contains(merged, table) {
+			merged = append(merged, table)
+		}
+	}
+	if len(merged) == 0 && !withPatterns {
+		return nil, errors.New("no table to replicate, specify tables by --table, --tables, --table-pattern or --database")
+	}
+	return merged, nil
+}
 
-const (
-	RunModeFull RunMode = iota
-	RunModeSnapshotOnly
-	RunModeIncrementalOnly
-	RunModeCloud
-)
+// TablePatternOptions are the flags of the tables replicated by the patterns of their names, and of the databases
+// replicated as a whole
+type TablePatternOptions struct {
+	Patterns  []string
+	Interval  time.Duration
+	OnRemoved string
+	Databases []string
+	// ExcludeTables is the comma separated patterns of the tables never replicated
+	ExcludeTables string
+	// patterns, removedTablePolicy, databases and excludes are parsed by resolve
+	patterns           []tidbsql.TablePattern
+	removedTablePolicy replicate.RemovedTablePolicy
+	databases          []string
+	excludes           []tidbsql.TablePattern
+}
 
-var RunModeIds = map[RunMode][]string{
-	RunModeFull:            {"full"},
-	RunModeSnapshotOnly:    {"snapshot-only"},
-	RunModeIncrementalOnly: {"incremental-only"},
-	RunModeCloud:           {"cloud"},
-}
-
-// o => create changefeed =>   dump snapshot   => load snapshot => incremental load
-//
-//	^                     ^                    ^ 				 ^
-//	|			          |				       |				 |
-//	+------ init ---------+ changefeed created + snapshot dumped + snapshot loaded --
-type Stage string
-
-const (
-	StageInit              Stage = "init"
-	StageChangefeedCreated Stage = "changefeed-created"
-	StageSnapshotDumped    Stage = "snapshot-dumped"
-	StageSnapshotLoaded    Stage = "snapshot-loaded"
-)
+func (opts *TablePatternOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&opts.Patterns, "table-pattern", []string{}, "replicate the tables matching a pattern, e.g. --table-pattern 'mydb.events_*', the tables matching later are bootstrapped as they are found in TiDB")
+	cmd.Flags().DurationVar(&opts.Interval, "table-pattern-interval", time.Minute, "how often the tables of TiDB are checked against --table-pattern")
+	cmd.Flags().StringArrayVar(&opts.Databases, "database", []string{}, "replicate all the tables of a database found when the replication starts, e.g. --database mydb, the tables created later are not replicated unless they match --table-pattern")
+	cmd.Flags().StringVar(&opts.ExcludeTables, "exclude-tables", "", "comma separated patterns of the tables never replicated, e.g. 'mydb.tmp_*,mydb.audit_log', they are excluded from the changefeed too")
+	cmd.Flags().StringVar(&opts.OnRemoved, "on-table-removed", string(replicate.RemovedTableKeep), "what is done to the table in the data warehouse when a table matching --table-pattern is dropped in TiDB: keep or drop, its replication stops either way")
+}
+
+// enabled tells whether tables are given by --table-pattern, the tables found later are unknown to the config files
+func (opts *TablePatternOptions) enabled() bool {
+	return len(opts.Patterns) > 0
+}
+
+// givesTables tells whether tables are given by --table-pattern or --database besides --table
+func (opts *TablePatternOptions) givesTables() bool {
+	return opts.enabled() || len(opts.Databases) > 0
+}
 
-func checkStage(storage storage.ExternalStorage) (Stage, error) {
-	stage := StageInit
-	ctx := context.Background()
-	if exist, err := storage.FileExists(ctx, "increment/metadata"); err != nil || !exist {
-		return stage, errors.Wrap(err, "Failed to check increment metadata")
-	} else {
-		stage = StageChangefeedCreated
+// resolve parses the patterns and adds the tables of TiDB matching them or in the databases to the tables given by
+// --table, the tables excluded by --exclude-tables are left out
+func (opts *TablePatternOptions) resolve(tidbConfig *tidbsql.TiDBConfig, tables []string) ([]string, error) {
+	var err error
+	if opts.excludes, err = parseTablePatterns(strings.Split(opts.ExcludeTables, ",")); err != nil {
+		return nil, errors.Annotate(err, "invalid --exclude-tables")
 	}
-	if exist, err := storage.FileExists(ctx, "snapshot/metadata"); err != nil || !exist {
-		return stage, errors.Wrap(err, "Failed to check snapshot metadata")
-	} else {
-		stage = StageSnapshotDumped
+	if !opts.givesTables() {
+		if len(opts.excludes) > 0 {
+			return nil, errors.New("--exclude-tables requires --database or --table-pattern")
+		}
+		return tables, nil
 	}
-	if exist, err := storage.FileExists(ctx, "snapshot/loadinfo"); err != nil || !exist {
-		return stage, errors.Wrap(err, "Failed to check snapshot loadinfo")
-	} else {
-		stage = StageSnapshotLoaded
+	for _, table := range tables {
+		if tidbsql.MatchAnyTablePattern(opts.excludes, table) {
+			return nil, errors.Errorf("table %s given by --table is excluded by --exclude-tables", table)
+		}
+	}
+	if opts.patterns, err = parseTablePatterns(opts.Patterns); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.enabled() {
+		if opts.removedTablePolicy, err = replicate.ParseRemovedTablePolicy(opts.OnRemoved); err != nil {
+			return nil, errors.Trace(err)
+		}
 	}
-	return stage, nil
+	patterns := slices.Clone(opts.patterns)
+	opts.databases = make([]string, 0, len(opts.Databases))
+	for _, database := range opts.Databases {
+		if database = strings.TrimSpace(database); database == "" || strings.Contains(database, ".") {
+			return nil, errors.Errorf("invalid --database %s, expected the name of a database", database)
+		}
+		opts.databases = append(opts.databases, database)
+		patterns = append(patterns, tidbsql.TablePattern{Database: database, Table: "*"})
+	}
+	db, err := tidbConfig.OpenDB()
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer db.Close()
+	matched, err := tidbsql.GetTiDBTablesMatching(db, patterns)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to find the tables matching --table-pattern or --database")
+	}
+	var excluded []string
+	for _, table := range matched {
+		if tidbsql.MatchAnyTablePattern(opts.excludes, table) {
+			excluded = append(excluded, table)
+		} else if !slices.Contains(tables, table) {
+			tables = append(tables, table)
+		}
+	}
+	log.Info("Found tables matching --table-pattern or --database", zap.Strings("patterns", opts.Patterns), zap.Strings("databases", opts.Databases),
+		zap.Strings("tables", tables), zap.Strings("excluded", excluded))
+	if len(tables) == 0 {
+		return nil, errors.Errorf("no table to replicate, no table matches --table-pattern or --database %s", strings.Join(append(slices.Clone(opts.Patterns), opts.Databases...), ", "))
+	}
+	return tables, nil
 }
 
-func getGCSURIWithCredentials(storagePath string, credentialsFilePath string) (*url.URL, error) {
+// parseTablePatterns parses the patterns, the empty ones are skipped
+func parseTablePatterns(values []string) ([]tidbsql.TablePattern, error) {
+	patterns := make([]tidbsql.TablePattern, 0, len(values))
+	for _, s := range values {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		pattern, err := tidbsql.ParseTablePattern(s)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// S3Options are the options of S3-compatible storage such as MinIO. They are carried by the
+// query string of the storage URI, following the conventions of BR and TiCDC.
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	ForcePathStyle  bool
+	InsecureSkipTLS bool
+}
+
+func (opts *S3Options) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.Endpoint, "s3.endpoint", "", "endpoint of S3-compatible storage, e.g. http://minio:9000")
+	cmd.Flags().StringVar(&opts.Region, "s3.region", "", "region of the S3 bucket")
+	cmd.Flags().BoolVar(&opts.ForcePathStyle, "s3.force-path-style", false, "use path-style addressing, usually required by S3-compatible storage")
+	cmd.Flags().BoolVar(&opts.InsecureSkipTLS, "s3.insecure-skip-tls", false, "skip TLS certificate verification of the S3 endpoint, TiCDC must trust the certificate by itself")
+}
+
+// applyS3Options merges the options into the query string of the storage path,
+// the flags take precedence over the query parameters of the storage path.
+func applyS3Options(storagePath string, opts *S3Options) (string, error) {
 	uri, err := url.Parse(storagePath)
 	if err != nil {
-		return nil, errors.Annotate(err, "Failed to parse workspace path")
+		return "", errors.Annotate(err, "Failed to parse workspace path")
+	}
+	values := uri.Query()
+	if opts.Endpoint != "" {
+		values.Set("endpoint", opts.Endpoint)
+	}
+	if opts.Region != "" {
+		values.Set("region", opts.Region)
 	}
+	if opts.ForcePathStyle {
+		values.Set("force-path-style", "true")
+	}
+	if opts.InsecureSkipTLS {
+		values.Set(utils.InsecureSkipTLSParam, "true")
+	}
+	uri.RawQuery = values.Encode()
+	return uri.String(), nil
+}
+
+// RateLimitOptions cap the bandwidth of the files written into and read from the storage by tidb2dw, e.g. the snapshot
+// dumped and the increment files merged. The data warehouses loading the files from the storage are not limited.
+type RateLimitOptions struct {
+	Upload   string
+	Download string
+}
 
-	if uri.Scheme != "gcs" && uri.Scheme != "gs" {
-		return nil, errors.New("Not a gcs storage")
+func (opts *RateLimitOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.Upload, "upload-rate-limit", "0", "bytes per second written into the storage at most across the tables, e.g. 200MiB, 0 means no limit, adjustable by POST /api/v1/ratelimit")
+	cmd.Flags().StringVar(&opts.Download, "download-rate-limit", "0", "bytes per second read from the storage at most across the tables, e.g. 200MiB, 0 means no limit, adjustable by POST /api/v1/ratelimit")
+}
+
+// limiters returns the limiters of the storages of the pipeline
+func (opts *RateLimitOptions) limiters() (*ratelimit.Limiters, error) {
+	upload, err := ratelimit.ParseRate(opts.Upload)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid --upload-rate-limit")
+	}
+	download, err := ratelimit.ParseRate(opts.Download)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid --download-rate-limit")
 	}
+	if upload > 0 || download > 0 {
+		log.Info("Limited storage bandwidth", zap.String("upload", ratelimit.FormatRate(upload)), zap.String("download", ratelimit.FormatRate(download)))
+	}
+	return ratelimit.NewLimiters(upload, download), nil
+}
+
+// NotifyOptions is the webhook sent the lifecycle events of the replication, e.g. the snapshot loaded
+type NotifyOptions struct {
+	WebhookURL   string
+	Format       string
+	Events       []string
+	LagThreshold time.Duration
+	LagDuration  time.Duration
+}
+
+func (opts *NotifyOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.WebhookURL, "notify-webhook-url", "", "URL POSTed the lifecycle events of the replication, no event is sent by default")
+	cmd.Flags().StringVar(&opts.Format, "notify-format", string(notify.FormatJSON), "payload of the events: json, or slack for the incoming webhooks of Slack")
+	cmd.Flags().StringSliceVar(&opts.Events, "notify-events", nil, "events sent, some of snapshot-dumped, snapshot-loaded, lag, changefeed-failed and fatal-error, all by default")
+	cmd.Flags().DurationVar(&opts.LagThreshold, "notify-lag-threshold", 10*time.Minute, "lag of a table over which the lag event is sent, 0 disables it")
+	cmd.Flags().DurationVar(&opts.LagDuration, "notify-lag-duration", 5*time.Minute, "how long the lag of a table stays over --notify-lag-threshold before the lag event is sent")
+}
 
-	// bigquery does not support gcs scheme
-	if uri.Scheme == "gcs" {
-		uri.Scheme = "gs"
+// notifier starts the notifier of the webhook, nil if none is set
+func (opts *NotifyOptions) notifier() (*notify.Notifier, error) {
+	if opts.WebhookURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(opts.WebhookURL); err != nil {
+		return nil, errors.New("invalid --notify-webhook-url")
+	}
+	format, err := notify.ParseFormat(opts.Format)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid --notify-format")
+	}
+	events, err := notify.ParseEvents(opts.Events)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid --notify-events")
 	}
+	if opts.LagThreshold < 0 || opts.LagDuration < 0 {
+		return nil, errors.New("--notify-lag-threshold and --notify-lag-duration must not be negative")
+	}
+	return notify.New(notify.Config{
+		WebhookURL:   opts.WebhookURL,
+		Format:       format,
+		Events:       events,
+		LagThreshold: opts.LagThreshold,
+		LagDuration:  opts.LagDuration,
+	}), nil
+}
 
-	// append credentials file path to query string
-	values := url.Values{}
-	values.Add("credentials-file", credentialsFilePath)
-	uri.RawQuery = values.Encode()
-	return uri, nil
+// addCDCServerFlag adds --cdc.server, the TiCDC servers given by their addresses instead of --cdc.host and --cdc.port
+func addCDCServerFlag(cmd *cobra.Command, server *string) {
+	cmd.Flags().StringVar(server, "cdc.server", "", "comma separated addresses of the TiCDC servers, [http://|https://]<host>[:<port>] with an IPv6 host in brackets, or srv+http://<name> for the servers of its DNS SRV records, the first healthy one is requested and another one once it is unreachable, replaces --cdc.host and --cdc.port")
 }
 
-func getS3URIWithCredentials(storagePath string, cred *credentials.Value) (*url.URL, error) {
-	uri, err := url.Parse(storagePath)
+// addCDCServerCredentialsFlag adds --cdc.server-credentials, the TiCDC servers write the increment files by their
+// own credentials instead of the keys of the storage URI
+func addCDCServerCredentialsFlag(cmd *cobra.Command, serverCredentials *bool) {
+	cmd.Flags().BoolVar(serverCredentials, "cdc.server-credentials", false, "create the changefeed with no credentials in its sink URI, the TiCDC servers access the storage by their own, e.g. the IAM role of their instances or the env of their processes, so that no long-lived key is kept by TiCDC")
+}
+
+// newCDCClient returns the client of the HTTP API of the TiCDC servers of --cdc.server, or of the server of
+// --cdc.host and --cdc.port if it is not set
+func newCDCClient(server string, tlsOptions *CDCTLSOptions, cdcHost string, cdcPort int) (*cdc.Client, error) {
+	if server == "" {
+		client, err := cdc.NewClient(cdcHost, cdcPort, tlsOptions.config())
+		return client, diag.CDC(errors.Trace(err))
+	}
+	servers, err := cdc.ParseServers(server)
 	if err != nil {
-		return nil, errors.Annotate(err, "Failed to parse workspace path")
+		return nil, errors.Trace(err)
 	}
+	client, err := cdc.NewServersClient(servers, tlsOptions.config())
+	return client, diag.CDC(errors.Trace(err))
+}
+
+// CDCTLSOptions is how the HTTP API of TiCDC is requested over https
+type CDCTLSOptions struct {
+	HTTPS bool
+	TLS   utils.TLSConfig
+}
 
-	if uri.Scheme != "s3" {
-		return nil, errors.New("Not a s3 storage")
+func (opts *CDCTLSOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&opts.HTTPS, "cdc.https", false, "request the TiCDC API over https, implied by the other --cdc.ssl-* flags")
+	cmd.Flags().StringVar(&opts.TLS.CA, "cdc.ssl-ca", "", "CA verifying the certificate of the TiCDC API, the CAs of the system by default")
+	cmd.Flags().StringVar(&opts.TLS.Cert, "cdc.ssl-cert", "", "client certificate of the TiCDC API")
+	cmd.Flags().StringVar(&opts.TLS.Key, "cdc.ssl-key", "", "client key of the TiCDC API")
+	cmd.Flags().BoolVar(&opts.TLS.SkipVerify, "cdc.ssl-skip-verify", false, "skip verifying the certificate of the TiCDC API")
+}
+
+// config returns the TLS of the TiCDC API, nil if it is requested over http
+func (opts *CDCTLSOptions) config() *utils.TLSConfig {
+	if !opts.HTTPS && !opts.TLS.Enabled() {
+		return nil
 	}
+	return &opts.TLS
+}
+
+// TiDBCloudOptions is how the changefeed is created through the TiDB Cloud API in --mode=cloud
+type TiDBCloudOptions struct {
+	Config tidbcloud.Config
+	// ExportSnapshot exports the snapshot by TiDB Cloud, only for the replication commands
+	ExportSnapshot bool
+}
 
-	// append credentials to query string
-	values := url.Values{}
-	values.Add("access-key", cred.AccessKeyID)
-	values.Add("secret-access-key", cred.SecretAccessKey)
-	if cred.SessionToken != "" {
-		values.Add("session-token", cred.SessionToken)
+func (opts *TiDBCloudOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.Config.PublicKey, "tidbcloud.public-key", "", "public key of the TiDB Cloud API, in --mode=cloud the changefeed is created through the API instead of by hand")
+	cmd.Flags().StringVar(&opts.Config.PrivateKey, "tidbcloud.private-key", "", "private key of the TiDB Cloud API")
+	cmd.Flags().StringVar(&opts.Config.ClusterID, "tidbcloud.cluster-id", "", "ID of the TiDB Cloud cluster replicated")
+	cmd.Flags().StringVar(&opts.Config.APIURL, "tidbcloud.api-url", tidbcloud.DefaultAPIURL, "base URL of the TiDB Cloud API")
+}
+
+func (opts *TiDBCloudOptions) addExportFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&opts.ExportSnapshot, "tidbcloud.export-snapshot", false, "export the snapshot by TiDB Cloud at the start of the changefeed created through the API instead of loading the files exported by hand")
+}
+
+// config returns the TiDB Cloud API, nil if no key or cluster is set
+func (opts *TiDBCloudOptions) config() *tidbcloud.Config {
+	if opts.Config.PublicKey == "" && opts.Config.PrivateKey == "" && opts.Config.ClusterID == "" {
+		return nil
 	}
-	uri.RawQuery = values.Encode()
-	return uri, nil
+	return &opts.Config
 }
 
-func genSnapshotAndIncrementURIs(storageURI *url.URL) (*url.URL, *url.URL, error) {
-	// create snapshot and increment uri from storage uri, append snapshot and increment path to path
-	snapshotURI := *storageURI
-	incrementURI := *storageURI
+// addIncrementFlags adds the flags of the global settings of the incremental workers, --increment-merge-interval is
+// the former name of --merge-interval
+func addIncrementFlags(cmd *cobra.Command, opts *engine.IncrementOptions) {
+	addConfigFlag(cmd, &opts.ConfigFile)
+	cmd.Flags().IntVar(&opts.Workers, "increment-workers", 0, "total number of incremental workers, the tables without dedicated workers share the rest, 0 means no limit")
+	cmd.Flags().DurationVar(&opts.MergeInterval, "merge-interval", 0, "interval between two rounds of merging the increment files of a table, all files found since the last round are merged at once, e.g. 10m with --cdc.flush-interval=30s, 0 means a fifth of --cdc.flush-interval")
+	cmd.Flags().DurationVar(&opts.MergeInterval, "increment-merge-interval", 0, "")
+	cmd.Flags().MarkDeprecated("increment-merge-interval", "use --merge-interval instead")
+	cmd.Flags().IntVar(&opts.Concurrency, "increment-concurrency", 4, "number of increment files loaded into the data warehouse concurrently across the tables, the files of a table are loaded in order, 0 means no limit")
+	cmd.Flags().BoolVar(&opts.Cleanup.Enabled, "cleanup-consumed-files", true, "delete the increment files from the storage after they are merged into the data warehouse, a failed deletion is retried without blocking the replication")
+	cmd.Flags().Int64Var(&opts.Batch.MinRows, "min-batch-rows", 0, "merge the new increment files of a table once they have the rows, or once they wait for --max-batch-interval, 0 merges them by every round")
+	cmd.Flags().DurationVar(&opts.Batch.MaxInterval, "max-batch-interval", 0, "longest time the new increment files of a table wait for --min-batch-rows before they are merged, e.g. 15m, 0 merges them by every round unless --min-batch-rows is set")
+	cmd.Flags().DurationVar(&opts.SchemaDrift.Interval, "schema-check-interval", 10*time.Minute, "shortest interval between two checks of a table in the data warehouse against the columns replicated, e.g. it is altered by hand, before merging the new increment files, 0 disables the check")
+	cmd.Flags().BoolVar(&opts.SchemaDrift.AutoReconcile, "auto-reconcile", false, "alter the table drifted in the data warehouse back to the columns replicated instead of failing the replication")
+	cmd.Flags().DurationVar(&opts.Cleanup.Retain, "cleanup-retain", 0, "keep the merged increment files for the duration before deleting them with --cleanup-consumed-files, e.g. 24h, 0 deletes them once merged")
+	cmd.Flags().IntVar(&opts.Shards, "increment-shards", 1, "split the changes of each table by the hash of its primary key across the changefeeds, whose files are merged concurrently, for the tables written faster than a changefeed can sink")
+}
 
-	var err error
+// addSnapshotValidationFlags adds the flags of how the loaded snapshot is validated
+func addSnapshotValidationFlags(cmd *cobra.Command, opts *engine.SnapshotValidationOptions) {
+	cmd.Flags().BoolVar(&opts.Enabled, "validate-snapshot", false, "compare the row count of each table loaded with TiDB at the snapshot TSO, a table not matching fails the replication, the results are written to snapshot/validation.json")
+	cmd.Flags().BoolVar(&opts.Checksum, "validate-snapshot-checksum", false, "also compare the sums of the primary key and up to 4 integer and decimal columns with --validate-snapshot")
+}
+
+// addSnapshotOrderFlags adds the flags of the order the snapshots are loaded in
+func addSnapshotOrderFlags(cmd *cobra.Command, opts *engine.SnapshotOrderOptions) {
+	cmd.Flags().StringVar(&opts.LoadOrder, "load-order", "", "load the snapshots one table at a time in order, small-first or large-first by the sizes of the tables in TiDB, or the comma separated tables in <db>.<table> loaded first, by default all are loaded at once")
+	cmd.Flags().StringVar(&opts.PostSnapshotSQL, "post-snapshot-sql", "", "file of the statements separated by semicolons executed in the data warehouse once the snapshots of all tables are loaded")
+}
+
+// addRetryFlags adds the flags of how the operations of the data warehouse failed with a transient error are retried
+func addRetryFlags(cmd *cobra.Command, policy *retry.Policy) {
+	cmd.Flags().IntVar(&policy.MaxRetries, "max-retries", retry.DefaultPolicy.MaxRetries, "times an operation of the data warehouse failed with a transient error is retried, e.g. an expired session or a rate limit, 0 disables the retry")
+	cmd.Flags().DurationVar(&policy.MaxBackoff, "max-backoff", retry.DefaultPolicy.MaxBackoff, "longest backoff between two retries, which doubles from 1s with a random jitter")
+}
+
+// DDLPolicyOptions are the flags of how the DDLs which tidb2dw can not apply as they are in the data warehouse are
+// handled
+type DDLPolicyOptions struct {
+	UnknownDDL       string
+	OnRename         string
+	OnUnsupportedDDL string
+}
+
+func (opts *DDLPolicyOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.UnknownDDL, "unknown-ddl", "pause", "how to handle the DDL of a type unknown to tidb2dw, e.g. introduced by a newer TiDB: pause, skip, error")
+	cmd.Flags().StringVar(&opts.OnRename, "on-rename", "follow", "how to handle a table renamed within its database: follow renames the table in the data warehouse and replicates it by the new name, error fails the replication")
+	cmd.Flags().StringVar(&opts.OnUnsupportedDDL, "on-unsupported-ddl", "error", "how to handle a DDL which the data warehouse can not apply, e.g. a lossy modify column: error fails the replication, skip records it in skipped-ddl.json of the increment storage and continues, pause pauses the table until it is resumed by POST /api/v1/tables/{table}/resume-after-ddl")
+}
 
-	snapshotURI.Path, err = url.JoinPath(storageURI.Path, "snapshot")
+// resolve parses the policies of --unknown-ddl, --on-rename and --on-unsupported-ddl
+func (opts *DDLPolicyOptions) resolve() (tidbsql.UnknownDDLPolicy, tidbsql.RenamePolicy, tidbsql.UnsupportedDDLPolicy, error) {
+	unknownDDLPolicy, err := tidbsql.ParseUnknownDDLPolicy(opts.UnknownDDL)
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "Failed to join workspace path")
+		return "", "", "", errors.Trace(err)
 	}
-	incrementURI.Path, err = url.JoinPath(storageURI.Path, "increment")
+	renamePolicy, err := tidbsql.ParseRenamePolicy(opts.OnRename)
 	if err != nil {
-		return nil, nil, errors.Annotate(err, "Failed to join workspace path")
+		return "", "", "", errors.Trace(err)
 	}
-	return &snapshotURI, &incrementURI, nil
+	unsupportedDDLPolicy, err := tidbsql.ParseUnsupportedDDLPolicy(opts.OnUnsupportedDDL)
+	if err != nil {
+		return "", "", "", errors.Trace(err)
+	}
+	return unknownDDLPolicy, renamePolicy, unsupportedDDLPolicy, nil
 }
 
-func resolveAWSCredential(storagePath string) (*credentials.Value, error) {
-	uri, err := url.Parse(storagePath)
+// addDeleteModeFlag adds the flag of how the rows deleted in TiDB are deleted in the data warehouse
+func addDeleteModeFlag(cmd *cobra.Command, deleteMode *string) {
+	cmd.Flags().StringVar(deleteMode, "delete-mode", string(deletemode.Hard), "how the rows deleted in TiDB are deleted in the data warehouse: hard deletes them, soft keeps them with _tidb_deleted set to true and _tidb_deleted_at to the time of the merge")
+}
+
+// addMaxBadRowsFlag adds the flag of how many rows of a batch rejected by the data warehouse are skipped, rejection
+// is an example of a row the data warehouse rejects
+func addMaxBadRowsFlag(cmd *cobra.Command, maxBadRows *int64, warehouse, rejection string) {
+	cmd.Flags().Int64Var(maxBadRows, "max-bad-rows", 0, fmt.Sprintf("max rows of a batch of increment files rejected by %s that are skipped, e.g. %s, they are written into errors/<batch-id>.json of the storage path, 0 fails the batch on any rejected row", warehouse, rejection))
+}
+
+// addTiDBFlags adds the flags of the connection to TiDB
+func addTiDBFlags(cmd *cobra.Command, cfg *tidbsql.TiDBConfig) {
+	cmd.Flags().StringVarP(&cfg.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
+	cmd.Flags().IntVarP(&cfg.Port, "tidb.port", "P", 4000, "TiDB port")
+	cmd.Flags().StringVarP(&cfg.User, "tidb.user", "u", "root", "TiDB user")
+	cmd.Flags().StringVarP(&cfg.Pass, "tidb.pass", "p", "", "TiDB password")
+	cmd.Flags().StringVar(&cfg.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
+	cmd.Flags().StringVar(&cfg.SSLCert, "tidb.ssl-cert", "", "TiDB SSL client certificate")
+	cmd.Flags().StringVar(&cfg.SSLKey, "tidb.ssl-key", "", "TiDB SSL client key")
+}
+
+// addTimeZoneFlag adds the flag of the time zone of the TiDB sessions, the TIMESTAMP values of the snapshot are dumped
+// in it, and TiCDC writes those of the increment in the time zone of its server
+func addTimeZoneFlag(cmd *cobra.Command, timezone *string) {
+	cmd.Flags().StringVar(timezone, "tz", utils.TimeZoneSystem, "IANA time zone the TIMESTAMP values are dumped in, e.g. UTC, the TiCDC server must run with the same --tz, System keeps the time zone of TiDB")
+}
+
+// addIdentifierCaseFlag adds the flag of the case the names of the tables and the columns are written in the data
+// warehouse, the default is the convention of the data warehouse
+func addIdentifierCaseFlag(cmd *cobra.Command, identifierCase *string, convention identcase.Case) {
+	cmd.Flags().StringVar(identifierCase, "identifier-case", string(convention), "case the names of the tables and the columns are written in the data warehouse, always quoted: preserve, upper or lower")
+}
+
+// addSyncCommentsFlag adds the flag of whether the comments of the tables and the columns are replicated
+func addSyncCommentsFlag(cmd *cobra.Command, syncComments *bool) {
+	cmd.Flags().BoolVar(syncComments, "sync-comments", true, "replicate the comments of the tables and the columns in TiDB, false leaves the tables in the data warehouse without comments, e.g. if the comments contain sensitive text")
+}
+
+// addDumpChunkFlags adds the flags of how the snapshot is split into files, defaultFileSize is preferred by the data warehouse.
+// --dump-filesize and --dump-rows are the former names of the flags.
+func addDumpChunkFlags(cmd *cobra.Command, cfg *dumpling.ChunkConfig, defaultFileSize string) {
+	cmd.Flags().StringVar(&cfg.FileSize, "snapshot-file-size", defaultFileSize, "target size of the snapshot files after compression, e.g. 256MiB")
+	cmd.Flags().Uint64Var(&cfg.Rows, "snapshot-rows-per-file", 0, "split a table into chunks of the number of rows and dump them concurrently, 0 disables the split")
+	cmd.Flags().StringVar(&cfg.FileSize, "dump-filesize", defaultFileSize, "")
+	cmd.Flags().Uint64Var(&cfg.Rows, "dump-rows", 0, "")
+	cmd.Flags().MarkDeprecated("dump-filesize", "use --snapshot-file-size instead")
+	cmd.Flags().MarkDeprecated("dump-rows", "use --snapshot-rows-per-file instead")
+	cmd.Flags().StringVar(&cfg.OutputFilenameTemplate, "dump-output-filename-template", "", "dumpling template of the snapshot file names, must contain {{.DB}}, {{.Table}} and {{.Index}}")
+	cmd.Flags().StringVar((*string)(&cfg.Method), "snapshot-method", string(dumpling.SnapshotMethodDumpling), "how the snapshot is dumped, dumpling or query, which exports the small tables by SELECT instead of dumpling")
+	cmd.Flags().Int64Var(&cfg.QueryMaxRows, "snapshot-query-max-rows", dumpling.DefaultQueryMaxRows, "most rows of a table exported by --snapshot-method=query, the larger tables are dumped by dumpling")
+}
+
+// parseCompressions parses the codecs of snapshot and increment files and checks they are supported by the warehouse
+func parseCompressions(warehouse, snapshotCompression, incrementCompression string, supported ...utils.Compression) (utils.Compression, utils.Compression, error) {
+	compressions := make([]utils.Compression, 0, 2)
+	for _, name := range []string{snapshotCompression, incrementCompression} {
+		compression, err := utils.ParseCompression(name)
+		if err != nil {
+			return "", "", errors.Trace(err)
+		}
+		if compression != utils.CompressionNone && !slices.Contains(supported, compression) {
+			return "", "", errors.Errorf("%s does not support %s compressed CSV files, supported compressions: %v", warehouse, compression, supported)
+		}
+		compressions = append(compressions, compression)
+	}
+	return compressions[0], compressions[1], nil
+}
+
+// newFieldLimitConfig returns the config to check the field limits of the warehouse, nil if the check is disabled
+func newFieldLimitConfig(warehouse string, enabled bool, policy string) (*fieldlimit.Config, error) {
+	if !enabled {
+		return nil, nil
+	}
+	parsedPolicy, err := fieldlimit.ParsePolicy(policy)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	if uri.Scheme == "s3" {
-		creds := credentials.NewEnvCredentials()
-		credValue, err := creds.Get()
-		return &credValue, err
+	limits, ok := fieldlimit.WarehouseLimits[warehouse]
+	if !ok || (limits.MaxFieldSize == 0 && limits.MaxRowSize == 0) {
+		log.Warn("No field limit is known for the data warehouse, skip checking", zap.String("warehouse", warehouse))
+		return nil, nil
 	}
-	return nil, errors.New("Not a s3 storage")
+	return &fieldlimit.Config{Limits: limits, Policy: parsedPolicy}, nil
 }
 
-func Replicate(
-	tidbConfig *tidbsql.TiDBConfig,
-	tables []string,
-	storageURI *url.URL,
-	snapshotConcurrency int,
-	cdcHost string,
-	cdcPort int,
-	cdcFlushInterval time.Duration,
-	cdcFileSize int64,
-	snapConnectorMap map[string]coreinterfaces.Connector,
-	increConnectorMap map[string]coreinterfaces.Connector,
-	mode RunMode,
-) error {
-	ctx := context.Background()
-	storage, err := putil.GetExternalStorageFromURI(ctx, storageURI.String())
+// loadColumnMapping reads the column types of --column-mapping, nil if it is not set
+func loadColumnMapping(path string, tables []string, allowNewTables bool) (columnmapping.Mapping, error) {
+	if path == "" {
+		return nil, nil
+	}
+	mapping, err := columnmapping.Load(path)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	for tableFQN := range mapping {
+		// the table may be created later with --allow-new-tables
+		if !slices.Contains(tables, tableFQN) && !allowNewTables {
+			log.Warn("Ignored the column mapping of a table not replicated", zap.String("table", tableFQN))
+		}
+	}
+	return mapping, nil
+}
+
+// loadChangefeedConfig reads the changefeed options of --changefeed-config, nil if it is not set
+func loadChangefeedConfig(path string) (cdc.ReplicaOverrides, error) {
+	if path == "" {
+		return nil, nil
 	}
-	stage, err := checkStage(storage)
+	overrides, err := cdc.LoadReplicaOverrides(path)
+	return overrides, errors.Trace(err)
+}
+
+// loadColumnFilter reads the column filters of --column-filter, nil if it is not set
+func loadColumnFilter(path string, tables []string, allowNewTables bool) (columnfilter.Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+	config, err := columnfilter.Load(path)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	log.Info("Start Replicate", zap.String("stage", string(stage)), zap.String("mode", RunModeIds[mode][0]))
+	for tableFQN := range config {
+		// the table may be created later with --allow-new-tables
+		if !slices.Contains(tables, tableFQN) && !allowNewTables {
+			log.Warn("Ignored the column filter of a table not replicated", zap.String("table", tableFQN))
+		}
+	}
+	return config, nil
+}
+
+// loadWhere parses the predicates of --where, nil if it is not set
+func loadWhere(values []string, tables []string, allowNewTables bool) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	where, err := tidbsql.ParseWhere(values)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for tableFQN := range where {
+		// the table may be created later with --allow-new-tables
+		if !slices.Contains(tables, tableFQN) && !allowNewTables {
+			log.Warn("Ignored the where of a table not replicated", zap.String("table", tableFQN))
+		}
+	}
+	return where, nil
+}
+
+// PKLessOptions are the flags of the tables without a primary key
+type PKLessOptions struct {
+	Mode      string
+	DedupKeys []string
+}
+
+// addFlags adds --dedup-key, and --pkless-mode if the data warehouse can append the changes to a changelog table
+func (opts *PKLessOptions) addFlags(cmd *cobra.Command, appends bool) {
+	cmd.Flags().StringArrayVar(&opts.DedupKeys, "dedup-key", []string{}, "merge the rows of a table without a primary key by other unique-enough columns, e.g. --dedup-key 'db.t=order_id,line_no', or --dedup-key 'order_id,line_no' for all such tables")
+	if appends {
+		cmd.Flags().StringVar(&opts.Mode, "pkless-mode", string(pkless.Error), "what is done to a table without a primary key or --dedup-key: error refuses to replicate it, append appends its changes to the <table>_changelog table like --increment-mode=append")
+	}
+}
 
-	startTSO := uint64(0)
-	if mode == RunModeFull {
-		startTSO, err = tidbsql.GetCurrentTSO(tidbConfig)
+// resolve parses the flags, the dedup keys of the tables not replicated are ignored
+func (opts *PKLessOptions) resolve(tables []string, allowNewTables bool) (pkless.Policy, error) {
+	policy := pkless.Policy{Mode: pkless.Error}
+	if opts.Mode != "" {
+		mode, err := pkless.Parse(opts.Mode)
 		if err != nil {
-			return errors.Annotate(err, "Failed to get current TSO")
+			return pkless.Policy{}, errors.Trace(err)
 		}
+		policy.Mode = mode
 	}
-	snapshotURI, incrementURI, err := genSnapshotAndIncrementURIs(storageURI)
+	keys, err := pkless.ParseDedupKeys(opts.DedupKeys)
 	if err != nil {
-		return errors.Trace(err)
+		return pkless.Policy{}, errors.Trace(err)
 	}
-
-	onSnapshotDumpProgress := func(dumpedRows, totalRows int64) {
-		log.Info("Snapshot dump progress", zap.Int64("dumpedRows", dumpedRows), zap.Int64("estimatedTotalRows", totalRows))
+	for tableFQN := range keys {
+		// the table may be created later with --allow-new-tables
+		if tableFQN != "" && !slices.Contains(tables, tableFQN) && !allowNewTables {
+			log.Warn("Ignored the dedup key of a table not replicated", zap.String("table", tableFQN))
+		}
 	}
+	policy.DedupKeys = keys
+	return policy, nil
+}
 
-	switch stage {
-	case StageInit:
-		if mode != RunModeSnapshotOnly && mode != RunModeCloud {
-			cdcConnector, err := cdc.NewCDCConnector(cdcHost, cdcPort, tables, startTSO, incrementURI, cdcFlushInterval, cdcFileSize)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			if err = cdcConnector.CreateChangefeed(); err != nil {
-				return errors.Trace(err)
-			}
+// loadTableLayouts parses the partitioning and clustering columns of the tables given by the flags, nil if neither
+// is set. The flags not supported by the data warehouse are empty.
+func loadTableLayouts(partitionFlag string, partitionValues []string, clusterFlag string, clusterValues []string, tables []string, allowNewTables bool) (tablelayout.Layouts, error) {
+	if len(partitionValues) == 0 && len(clusterValues) == 0 {
+		return nil, nil
+	}
+	partitionBy, err := tablelayout.ParseColumns(partitionFlag, partitionValues)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	clusterBy, err := tablelayout.ParseColumns(clusterFlag, clusterValues)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	layouts := tablelayout.NewLayouts(partitionBy, clusterBy)
+	for tableFQN := range layouts {
+		// the table may be created later with --allow-new-tables
+		if !slices.Contains(tables, tableFQN) && !allowNewTables {
+			log.Warn("Ignored the partitioning and clustering of a table not replicated", zap.String("table", tableFQN))
 		}
-		fallthrough
-	case StageChangefeedCreated:
-		if mode != RunModeIncrementalOnly && mode != RunModeCloud {
-			if err := dumpling.RunDump(tidbConfig, snapshotConcurrency, snapshotURI, fmt.Sprint(startTSO), tables, onSnapshotDumpProgress); err != nil {
-				return errors.Trace(err)
-			}
+	}
+	return layouts, nil
+}
+
+// normalizeStoragePath validates the storage path given by user and returns its canonical form
+func normalizeStoragePath(storagePath string, supportedSchemes ...string) (string, error) {
+	uri, err := utils.NormalizeStorageURI(storagePath, supportedSchemes...)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return uri.String(), nil
+}
+
+// RouteOptions are the flags of the tables replicated into other schemas or tables of the data warehouse
+type RouteOptions struct {
+	Routes       []string
+	SchemaRoutes []string
+	// router, defaultTarget and routed are set by resolve
+	router        *routing.Router
+	defaultTarget routing.Target
+	// routed is the targets of the tables replicated so far, the tables found later are checked against it
+	routed *routing.TargetSet
+	// routingTable is the routes of the tables resolved before the replication starts
+	routingTable []string
+}
+
+// addFlags adds the flags, schema is the namespace of the tables in the data warehouse, e.g. schema or dataset,
+// and the targets are examples of the targets of the routes
+func (opts *RouteOptions) addFlags(cmd *cobra.Command, schema, routeTarget, schemaRouteTarget string) {
+	cmd.Flags().StringArrayVar(&opts.Routes, "route", []string{}, fmt.Sprintf("replicate a table into another %s or table, takes precedence over --schema-route, e.g. --route '<db>.<table>=>%s'", schema, routeTarget))
+	cmd.Flags().StringArrayVar(&opts.SchemaRoutes, "schema-route", []string{}, fmt.Sprintf("replicate the tables matching a pattern into the %s given by a template, the first matching rule wins, e.g. --schema-route '%s'", schema, schemaRouteTarget))
+}
+
+// resolve parses the routes and resolves the targets of the tables, the fields of the targets not given by the
+// rules are filled by defaultTarget. namespaces is the number of the levels above the tables in the data warehouse.
+// Two tables replicated to the same table fail.
+func (opts *RouteOptions) resolve(tables []string, namespaces int, defaultTarget routing.Target) (map[string]routing.Target, error) {
+	router, err := routing.NewRouter(opts.Routes, opts.SchemaRoutes, namespaces)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts.router, opts.defaultTarget = router, defaultTarget
+	targets, routingTable := opts.targets(tables)
+	opts.routingTable = routingTable
+	if err = routing.CheckCollisions(targets); err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts.routed = routing.NewTargetSet()
+	for table, target := range targets {
+		if err = opts.routed.Add(table, target); err != nil {
+			return nil, errors.Trace(err)
 		}
 	}
+	return targets, nil
+}
+
+// target returns the target of a table found after the replication starts, e.g. by a pattern in watch mode or
+// created after the changefeed starts. It fails if another table is already replicated to the same table.
+func (opts *RouteOptions) target(table string) (routing.Target, error) {
+	targets, _ := opts.targets([]string{table})
+	target := targets[table]
+	if err := opts.routed.Add(table, target); err != nil {
+		return routing.Target{}, errors.Trace(err)
+	}
+	return target, nil
+}
+
+// rename moves a table followed by --on-rename=follow to its new name, a table following its source name fails
+// if another table is already replicated to the table of the new name
+func (opts *RouteOptions) rename(from, to string) error {
+	return errors.Trace(opts.routed.Rename(from, to))
+}
+
+// recordRoutingTable writes the routing table resolved before the replication starts into the output of --dry-run,
+// so that the targets can be confirmed before any data moves
+func (opts *RouteOptions) recordRoutingTable(recorder *dryrun.Recorder) {
+	if recorder != nil {
+		recorder.Comment("routing table", opts.routingTable...)
+	}
+}
 
-	var wg sync.WaitGroup
+// targets resolves the targets of the tables and logs the routing table before any data moves, the lines of the
+// routing table are returned in the order of the tables
+func (opts *RouteOptions) targets(tables []string) (map[string]routing.Target, []string) {
+	targets := make(map[string]routing.Target, len(tables))
+	lines := make([]string, 0, len(tables))
 	for _, table := range tables {
-		wg.Add(1)
-		go func(table string) {
-			defer wg.Done()
-			ctx := context.Background()
-			if mode != RunModeIncrementalOnly && stage != StageSnapshotLoaded {
-				apiservice.GlobalInstance.APIInfo.SetTableStage(table, apiservice.TableStageLoadingSnapshot)
-				if err = replicate.StartReplicateSnapshot(ctx, snapConnectorMap[table], table, tidbConfig, snapshotURI); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetTableFatalError(table, err)
-					return
-				}
-			}
-			if mode != RunModeSnapshotOnly {
-				apiservice.GlobalInstance.APIInfo.SetTableStage(table, apiservice.TableStageLoadingIncremental)
-				if err = replicate.StartReplicateIncrement(ctx, increConnectorMap[table], table, incrementURI, cdcFlushInterval/5); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetTableFatalError(table, err)
-					return
-				}
-			}
-			apiservice.GlobalInstance.APIInfo.SetTableStage(table, apiservice.TableStageFinished)
-		}(table)
-	}
-
-	wg.Wait()
-	return nil
-}
-
-func runWithServer(startServer bool, addr string, body func()) {
+		route := opts.router.Resolve(table)
+		target := route.Target
+		if target.Database == "" {
+			target.Database = opts.defaultTarget.Database
+		}
+		if target.Schema == "" {
+			target.Schema = opts.defaultTarget.Schema
+		}
+		targets[table] = target
+		rule := route.Rule
+		if rule == "" {
+			rule = "default"
+		}
+		lines = append(lines, fmt.Sprintf("%s => %s (%s)", table, target, rule))
+	}
+	log.Info("Resolved routing table", zap.Strings("routes", lines))
+	return targets, lines
+}
+
+// apiServiceEnabled tells whether the API service is started, it is always started in cloud mode
+// and is opt-in by --api.host or --api.port in other modes
+func apiServiceEnabled(cmd *cobra.Command, mode engine.RunMode) bool {
+	return mode == engine.RunModeCloud || cmd.Flags().Changed("api.host") || cmd.Flags().Changed("api.port")
+}
+
+// Warehouse is what the replication into a data warehouse differs in, besides the flags and the connectors of the
+// data warehouse
+type Warehouse struct {
+	// Name is the name of the command and of the data warehouse in the field limits, e.g. snowflake
+	Name string
+	// Title is the name of the data warehouse in the messages, e.g. Snowflake
+	Title string
+	// StorageSchemes are the schemes of the storage paths the files are loaded from, StorageUsage is the usage of
+	// --storage
+	StorageSchemes []string
+	StorageUsage   string
+	// Compressions are the compressions of the files the data warehouse loads besides none
+	Compressions []utils.Compression
+	// SnapshotFileSize is the size of the snapshot files preferred by the data warehouse
+	SnapshotFileSize string
+	// IdentifierCase is the convention of the names in the data warehouse, the command adds --identifier-case itself
+	// if it is empty
+	IdentifierCase identcase.Case
+	// JSONType is the type of the JSON columns in the data warehouse, shown by the example of --column-mapping
+	JSONType string
+	// RouteSchema, RouteTarget and SchemaRouteTarget are the usages of --route, see RouteOptions.addFlags
+	RouteSchema       string
+	RouteTarget       string
+	SchemaRouteTarget string
+	// Namespaces is the number of the namespaces of a table in the data warehouse, e.g. 2 of the database and the
+	// schema of Snowflake
+	Namespaces int
+	// PKLessAppends tells whether the changes of a table without a primary key can be appended
+	PKLessAppends bool
+}
+
+// ReplicationOptions are the flags of the replication shared by the commands of all the data warehouses, a command
+// adds the flags of its data warehouse and builds the connectors of the tables
+type ReplicationOptions struct {
+	warehouse Warehouse
+
+	tidbConfig            tidbsql.TiDBConfig
+	tables                []string
+	tableList             []string
+	routeOptions          RouteOptions
+	snapshotConcurrency   int
+	snapshotCompression   string
+	incrementCompression  string
+	dumpChunkConfig       dumpling.ChunkConfig
+	pipelinedSnapshot     bool
+	forceRedump           bool
+	snapshotValidation    engine.SnapshotValidationOptions
+	snapshotOrder         engine.SnapshotOrderOptions
+	retryPolicy           retry.Policy
+	incrementOptions      engine.IncrementOptions
+	checkFieldLimits      bool
+	fieldLimitPolicy      string
+	ddlPolicyOptions      DDLPolicyOptions
+	deleteModeValue       string
+	identifierCaseName    string
+	allowNewTables        bool
+	tablePatternOptions   TablePatternOptions
+	pklessOptions         PKLessOptions
+	startTSO              uint64
+	pauseChangefeedOnExit bool
+	cleanWorkspace        bool
+	forceUnlock           bool
+	changefeedRecovery    string
+	statusFileInterval    time.Duration
+	dryRunOptions         DryRunOptions
+	sqlAuditOptions       SQLAuditOptions
+	columnMappingPath     string
+	columnFilterPath      string
+	whereValues           []string
+	storagePath           string
+	storageCredentials    StorageCredentials
+	s3Options             S3Options
+	rateLimitOptions      RateLimitOptions
+	notifyOptions         NotifyOptions
+	cdcTLSOptions         CDCTLSOptions
+	tidbcloudOptions      TiDBCloudOptions
+	cdcHost               string
+	cdcPort               int
+	cdcServer             string
+	cdcServerCredentials  bool
+	syncComments          bool
+	cdcFlushInterval      time.Duration
+	cdcFileSize           int64
+	changefeedConfigPath  string
+	cdcProtocolName       string
+	stagingFormatName     string
+	timezone              string
+	logFile               string
+	logLevel              string
+	diagnostics           Diagnostics
+
+	mode          engine.RunMode
+	apiListenHost string
+	apiListenPort int
+
+	// deleteMode, identifierCase, targets, credentials, snapshotURI, incrementURI, recorder and auditLogger are
+	// resolved by pipelineConfig for the connectors
+	deleteMode     deletemode.Mode
+	identifierCase identcase.Case
+	targets        map[string]routing.Target
+	credentials    *StorageCredentials
+	snapshotURI    *url.URL
+	incrementURI   *url.URL
+	recorder       *dryrun.Recorder
+	auditLogger    *sqlaudit.Logger
+}
+
+// newCommand returns the command replicating into the data warehouse with the flags of the replication, run resolves
+// the config of the pipeline by pipelineConfig and runs it by runPipeline with the connectors of the data warehouse
+func (opts *ReplicationOptions) newCommand(run func(ctx context.Context, status *apiservice.APIInfo) error) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   opts.warehouse.Name,
+		Short: fmt.Sprintf("Replicate snapshot and incremental data from TiDB to %s", opts.warehouse.Title),
+		Run: func(cmd *cobra.Command, _ []string) {
+			runReplication(opts.warehouse.Name, apiServiceEnabled(cmd, opts.mode), net.JoinHostPort(opts.apiListenHost, strconv.Itoa(opts.apiListenPort)), &opts.diagnostics, run)
+		},
+	}
+	opts.addFlags(cmd)
+	cmd.MarkFlagRequired("storage")
+	return cmd
+}
+
+func (opts *ReplicationOptions) addFlags(cmd *cobra.Command) {
+	w := &opts.warehouse
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	cmd.Flags().Var(enumflag.New(&opts.mode, "mode", engine.RunModeIds, enumflag.EnumCaseInsensitive), "mode", "replication mode: full, snapshot-only, incremental-only, cloud")
+	cmd.Flags().StringVar(&opts.apiListenHost, "api.host", "0.0.0.0", "API service listen host, the API service is started in --mode=cloud or if --api.host or --api.port is set")
+	cmd.Flags().IntVar(&opts.apiListenPort, "api.port", 8185, "API service listen port, the API service is started in --mode=cloud or if --api.host or --api.port is set")
+	addTiDBFlags(cmd, &opts.tidbConfig)
+	cmd.Flags().StringVar(&opts.columnMappingPath, "column-mapping", "", fmt.Sprintf("TOML file overriding the types of the columns in the data warehouse, e.g. [tables.\"db.t\".columns] payload = \"%s\"", w.JSONType))
+	cmd.Flags().StringVar(&opts.columnFilterPath, "column-filter", "", "TOML file of the columns replicated to the data warehouse, e.g. [tables.\"db.t\"] exclude = [\"email\"]")
+	cmd.Flags().StringArrayVar(&opts.whereValues, "where", []string{}, "replicate only the rows of a table matching a predicate, which must be valid in both TiDB and the data warehouse, e.g. --where 'db.t=status <> 0'")
+	cmd.Flags().StringArrayVarP(&opts.tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&opts.tableList, "tables", []string{}, "comma-separated tables full qualified name, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	opts.routeOptions.addFlags(cmd, w.RouteSchema, w.RouteTarget, w.SchemaRouteTarget)
+	cmd.Flags().IntVar(&opts.snapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
+	cmd.Flags().StringVar(&opts.snapshotCompression, "snapshot-compression", "none", "compression of snapshot CSV files: none, gzip, snappy, zstd")
+	cmd.Flags().StringVar(&opts.incrementCompression, "increment-compression", "none", "compression of increment CSV files written by a changefeed managed outside of tidb2dw, only available in --mode=cloud")
+	addDumpChunkFlags(cmd, &opts.dumpChunkConfig, w.SnapshotFileSize)
+	cmd.Flags().BoolVar(&opts.pipelinedSnapshot, "pipelined-snapshot", false, "load the snapshot files of each table as soon as they are dumped instead of after the whole dump, a dump interrupted is dumped and loaded again")
+	cmd.Flags().BoolVar(&opts.forceRedump, "force-redump", false, "dump all the tables of the snapshot again instead of resuming the unfinished dump")
+	addSnapshotValidationFlags(cmd, &opts.snapshotValidation)
+	addSnapshotOrderFlags(cmd, &opts.snapshotOrder)
+	addRetryFlags(cmd, &opts.retryPolicy)
+	addIncrementFlags(cmd, &opts.incrementOptions)
+	cmd.Flags().BoolVar(&opts.checkFieldLimits, "check-field-limits", false, "scan the files before loading and report the fields and rows exceeding the limits of the data warehouse")
+	cmd.Flags().StringVar(&opts.fieldLimitPolicy, "field-limit-policy", "error", "policy applied on the fields exceeding the limits with --check-field-limits: error, truncate, null, dead-letter")
+	opts.ddlPolicyOptions.addFlags(cmd)
+	if w.IdentifierCase != "" {
+		addIdentifierCaseFlag(cmd, &opts.identifierCaseName, w.IdentifierCase)
+	}
+	cmd.Flags().BoolVar(&opts.allowNewTables, "allow-new-tables", false, "replicate the tables created in the databases of --table after the changefeed starts, the changefeed filter must match them")
+	opts.tablePatternOptions.addFlags(cmd)
+	opts.pklessOptions.addFlags(cmd, w.PKLessAppends)
+	cmd.Flags().Uint64Var(&opts.startTSO, "start-tso", 0, "TSO to start the changefeed from in incremental-only mode, the current TSO by default")
+	cmd.Flags().BoolVar(&opts.pauseChangefeedOnExit, "pause-changefeed-on-exit", false, "pause the changefeed on SIGINT or SIGTERM and resume it on restart")
+	cmd.Flags().BoolVar(&opts.cleanWorkspace, "clean-workspace", false, "remove the changefeeds writing into the storage and the snapshot and increment files left by a previous replication before starting fresh")
+	cmd.Flags().BoolVar(&opts.forceUnlock, "force-unlock", false, "take the lock of the storage held by another tidb2dw whose heartbeat is stale, make sure it is gone first")
+	cmd.Flags().StringVar(&opts.changefeedRecovery, "changefeed-recovery", "none", "what to do when the changefeed is found stopped or failed: none only reports it by the API service, resume resumes it, fail fails the replication")
+	cmd.Flags().DurationVar(&opts.statusFileInterval, "status-file-interval", 10*time.Second, "how often the status of the replication is written into status.json of the storage path, 0 to disable")
+	opts.dryRunOptions.addFlags(cmd)
+	opts.sqlAuditOptions.addFlags(cmd)
+	cmd.Flags().StringVarP(&opts.storagePath, "storage", "s", "", "storage path: "+w.StorageUsage)
+	opts.rateLimitOptions.addFlags(cmd)
+	opts.notifyOptions.addFlags(cmd)
+	cmd.Flags().StringVar(&opts.cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
+	cmd.Flags().IntVar(&opts.cdcPort, "cdc.port", 8300, "TiCDC server port")
+	addCDCServerFlag(cmd, &opts.cdcServer)
+	addCDCServerCredentialsFlag(cmd, &opts.cdcServerCredentials)
+	addSyncCommentsFlag(cmd, &opts.syncComments)
+	opts.cdcTLSOptions.addFlags(cmd)
+	opts.tidbcloudOptions.addFlags(cmd)
+	opts.tidbcloudOptions.addExportFlag(cmd)
+	cmd.Flags().DurationVar(&opts.cdcFlushInterval, "cdc.flush-interval", 60*time.Second, "")
+	cmd.Flags().Int64Var(&opts.cdcFileSize, "cdc.file-size", 64*1024*1024, "")
+	cmd.Flags().StringVar(&opts.changefeedConfigPath, "changefeed-config", "", "TiCDC changefeed config file merged into the changefeed created by tidb2dw, e.g. event filters or memory-quota, the options tidb2dw loads the files by can not be changed")
+	cmd.Flags().StringVar(&opts.cdcProtocolName, "cdc-protocol", "", "protocol of the increment files written by TiCDC: csv, canal-json (converted into CSV files before loading), empty to use the protocol of the files already written or csv")
+	addTimeZoneFlag(cmd, &opts.timezone)
+	cmd.Flags().StringVar(&opts.logFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&opts.logLevel, "log.level", "info", "log level")
+	opts.diagnostics.addFlags(cmd)
+}
+
+// addDeleteModeFlag adds --delete-mode, the rows are hard deleted in the data warehouses without it
+func (opts *ReplicationOptions) addDeleteModeFlag(cmd *cobra.Command) {
+	addDeleteModeFlag(cmd, &opts.deleteModeValue)
+}
+
+// addStagingFormatFlag adds --staging-format, the files are loaded as CSV files into the data warehouses without it
+func (opts *ReplicationOptions) addStagingFormatFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.stagingFormatName, "staging-format", string(stagingformat.CSV), "format of the snapshot and increment files loaded into the data warehouse: csv, parquet (converted from the CSV files before loading, requires --tz)")
+}
+
+// addS3Flags adds the flags of the S3-compatible storage and of the credentials of AWS
+func (opts *ReplicationOptions) addS3Flags(cmd *cobra.Command) {
+	opts.s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&opts.storageCredentials.AWSAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&opts.storageCredentials.AWSSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&opts.storageCredentials.AWSProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+}
+
+// addGCSFlags adds the flag of the credentials of GCS
+func (opts *ReplicationOptions) addGCSFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.storageCredentials.GCSCredentialsFile, "gcs.credentials-file", "", "gcs service account key file, GOOGLE_APPLICATION_CREDENTIALS by default")
+}
+
+// addAzureFlags adds the flags of the shared key of Azure Blob Storage
+func (opts *ReplicationOptions) addAzureFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.storageCredentials.AzureAccountName, "azure.account-name", "", "azure storage account name, AZURE_STORAGE_ACCOUNT by default")
+	cmd.Flags().StringVar(&opts.storageCredentials.AzureAccountKey, "azure.account-key", "", "azure storage account key, AZURE_STORAGE_KEY or Azure AD by default")
+}
+
+// findsNewTables tells whether tables unknown when the replication starts are replicated, by --allow-new-tables or
+// --table-pattern, so the config files may name tables not replicated yet
+func (opts *ReplicationOptions) findsNewTables() bool {
+	return opts.allowNewTables || opts.tablePatternOptions.enabled()
+}
+
+// pipelineConfig initializes the logger and resolves the config of the pipeline from the flags, defaultTarget is
+// where the tables are replicated into without --route. The connectors are left to the command, which builds them
+// from the options resolved besides, and closes the options by close once the pipeline stops.
+func (opts *ReplicationOptions) pipelineConfig(status *apiservice.APIInfo, defaultTarget routing.Target) (*engine.PipelineConfig, error) {
+	w := &opts.warehouse
+	if err := opts.diagnostics.initLogger(opts.logFile, opts.logLevel); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	tables, err := mergeTables(opts.tables, opts.tableList, opts.tablePatternOptions.givesTables())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	storagePath, err := applyS3Options(opts.storagePath, &opts.s3Options)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	rateLimiters, err := opts.rateLimitOptions.limiters()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	notifier, err := opts.notifyOptions.notifier()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	storagePath, err = normalizeStoragePath(storagePath, w.StorageSchemes...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	snapCompression, increCompression, err := parseCompressions(w.Title, opts.snapshotCompression, opts.incrementCompression, w.Compressions...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	fieldLimitConfig, err := newFieldLimitConfig(w.Name, opts.checkFieldLimits, opts.fieldLimitPolicy)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	unknownDDLPolicy, renamePolicy, unsupportedDDLPolicy, err := opts.ddlPolicyOptions.resolve()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts.deleteMode = deletemode.Hard
+	if opts.deleteModeValue != "" {
+		if opts.deleteMode, err = deletemode.Parse(opts.deleteModeValue); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if opts.identifierCase, err = identcase.Parse(opts.identifierCaseName, w.IdentifierCase); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if opts.tidbConfig.TimeZone, err = utils.ParseTimeZone(opts.timezone); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if tables, err = opts.tablePatternOptions.resolve(&opts.tidbConfig, tables); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cdcClient, err := newCDCClient(opts.cdcServer, &opts.cdcTLSOptions, opts.cdcHost, opts.cdcPort)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	recoveryPolicy, err := cdc.ParseRecoveryPolicy(opts.changefeedRecovery)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	changefeedConfig, err := loadChangefeedConfig(opts.changefeedConfigPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cdcProtocol, err := cdcreader.ParseProtocol(opts.cdcProtocolName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	stagingFormat, err := stagingformat.ParseFormat(opts.stagingFormatName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	storageURI, credentials, err := resolveStorageURI(storagePath, opts.storageCredentials)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts.credentials = credentials
+	if opts.snapshotURI, opts.incrementURI, err = engine.GenSnapshotAndIncrementURIs(storageURI); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	columnMapping, err := loadColumnMapping(opts.columnMappingPath, tables, opts.findsNewTables())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	columnFilter, err := loadColumnFilter(opts.columnFilterPath, tables, opts.findsNewTables())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	where, err := loadWhere(opts.whereValues, tables, opts.findsNewTables())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pklessPolicy, err := opts.pklessOptions.resolve(tables, opts.findsNewTables())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if pklessPolicy.Mode == pkless.Append && opts.deleteMode == deletemode.Soft {
+		return nil, errors.New("--delete-mode=soft is not supported with --pkless-mode=append")
+	}
+
+	if opts.targets, err = opts.routeOptions.resolve(tables, w.Namespaces, defaultTarget); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.recorder, err = opts.dryRunOptions.newRecorder(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts.routeOptions.recordRoutingTable(opts.recorder)
+	if opts.auditLogger, err = opts.sqlAuditOptions.newLogger(storageURI, opts.dryRunOptions.Enabled); err != nil {
+		opts.close()
+		return nil, errors.Trace(err)
+	}
+
+	return &engine.PipelineConfig{
+		TiDBConfig:            &opts.tidbConfig,
+		Tables:                tables,
+		StorageURI:            storageURI,
+		SnapshotConcurrency:   opts.snapshotConcurrency,
+		CDC:                   cdcClient,
+		TiDBCloud:             opts.tidbcloudOptions.config(),
+		CloudExport:           opts.tidbcloudOptions.ExportSnapshot,
+		CDCFlushInterval:      opts.cdcFlushInterval,
+		CDCFileSize:           opts.cdcFileSize,
+		CDCServerCredentials:  opts.cdcServerCredentials,
+		RateLimiters:          rateLimiters,
+		CDCProtocol:           cdcProtocol,
+		StagingFormat:         stagingFormat,
+		ChangefeedConfig:      changefeedConfig,
+		SnapshotCompression:   snapCompression,
+		IncrementCompression:  increCompression,
+		DumpChunkConfig:       &opts.dumpChunkConfig,
+		PipelinedSnapshot:     opts.pipelinedSnapshot,
+		ForceRedump:           opts.forceRedump,
+		SnapshotValidation:    opts.snapshotValidation,
+		SnapshotOrder:         opts.snapshotOrder,
+		RetryPolicy:           opts.retryPolicy,
+		IncrementOptions:      opts.incrementOptions,
+		ColumnMapping:         columnMapping,
+		ColumnFilter:          columnFilter,
+		Where:                 where,
+		PKLess:                pklessPolicy,
+		FieldLimitConfig:      fieldLimitConfig,
+		UnknownDDLPolicy:      unknownDDLPolicy,
+		RenamePolicy:          renamePolicy,
+		RenameCheck:           opts.routeOptions.rename,
+		UnsupportedDDLPolicy:  unsupportedDDLPolicy,
+		AllowNewTables:        opts.allowNewTables,
+		TablePatterns:         opts.tablePatternOptions.patterns,
+		TablePatternInterval:  opts.tablePatternOptions.Interval,
+		RemovedTablePolicy:    opts.tablePatternOptions.removedTablePolicy,
+		Databases:             opts.tablePatternOptions.databases,
+		ExcludedTables:        opts.tablePatternOptions.excludes,
+		StartTSO:              opts.startTSO,
+		PauseChangefeedOnExit: opts.pauseChangefeedOnExit,
+		CleanWorkspace:        opts.cleanWorkspace,
+		ForceUnlock:           opts.forceUnlock,
+		ChangefeedRecovery:    recoveryPolicy,
+		StatusFileInterval:    opts.statusFileInterval,
+		Mode:                  opts.mode,
+		DryRun:                opts.dryRunOptions.Enabled,
+		Status:                status,
+		Notifier:              notifier,
+	}, nil
+}
+
+// close writes the statements of the dry run and of the audit log, it is deferred after the connectors are closed
+func (opts *ReplicationOptions) close() {
+	closeAuditLogger(opts.auditLogger)
+	closeRecorder(opts.recorder)
+}
+
+// tableConnectors builds the connectors of a table on one connection to the data warehouse
+type tableConnectors struct {
+	// snapshot returns the connector loading the snapshot of the table from uri
+	snapshot func(uri *url.URL) (coreinterfaces.Connector, error)
+	// increment returns the connector loading the increment of the table
+	increment func() (coreinterfaces.Connector, error)
+}
+
+// connectorFactory connects to the data warehouse for the table replicated into target
+type connectorFactory func(tableFQN string, target routing.Target) (tableConnectors, error)
+
+// runPipeline runs the replication with the connectors of the tables built by newConnectors until it is finished or
+// ctx is canceled, the connectors are closed after
+func (opts *ReplicationOptions) runPipeline(ctx context.Context, cfg *engine.PipelineConfig, newConnectors connectorFactory) error {
+	cfg.SnapConnectorMap = make(map[string]coreinterfaces.Connector)
+	cfg.IncreConnectorMap = make(map[string]coreinterfaces.Connector)
+	defer func() {
+		for _, connector := range cfg.SnapConnectorMap {
+			connector.Close()
+		}
+		for _, connector := range cfg.IncreConnectorMap {
+			connector.Close()
+		}
+	}()
+	for _, tableFQN := range cfg.Tables {
+		connectors, err := newConnectors(tableFQN, opts.targets[tableFQN])
+		if err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+		snapConnector, err := connectors.snapshot(opts.snapshotURI)
+		if err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+		cfg.SnapConnectorMap[tableFQN] = snapConnector
+		increConnector, err := connectors.increment()
+		if err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+		cfg.IncreConnectorMap[tableFQN] = increConnector
+	}
+
+	// the tables created after the changefeed starts are routed like the tables given by --table
+	cfg.NewIncreConnector = func(tableFQN string) (coreinterfaces.Connector, error) {
+		target, err := opts.routeOptions.target(tableFQN)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		connectors, err := newConnectors(tableFQN, target)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return connectors.increment()
+	}
+	// the snapshot of a table found by --table-pattern is dumped into a directory of its own
+	cfg.NewSnapConnector = func(tableFQN string, uri *url.URL) (coreinterfaces.Connector, error) {
+		target, err := opts.routeOptions.target(tableFQN)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		connectors, err := newConnectors(tableFQN, target)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return connectors.snapshot(uri)
+	}
+
+	opts.diagnostics.setConfig(cfg)
+	pipeline, err := engine.NewPipeline(*cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return pipeline.Run(ctx)
+}
+
+// runWithServer runs body with a context canceled on SIGINT or SIGTERM,
+// the API service serving status is started alongside if startServer is set.
+func runWithServer(startServer bool, addr string, status *apiservice.APIInfo, body func(ctx context.Context)) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			// restore the default behavior so that a second signal kills the program
+			stop()
+			log.Info("Stopping replication after the files being loaded, send the signal again to exit immediately")
+		}
+	}()
+
 	if !startServer {
-		body()
+		body(ctx)
 		return
 	}
 
@@ -251,8 +1254,10 @@ func runWithServer(startServer bool, addr string, body func()) {
 	log.Info("API service started", zap.String("address", addr))
 
 	go func() {
-		body()
+		body(ctx)
+		// restore the default behavior so that the API service can be killed
+		stop()
 	}()
 
-	apiservice.GlobalInstance.Serve(l)
+	apiservice.New(status).Serve(l)
 }
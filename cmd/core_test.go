@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeTables(t *testing.T) {
+	for _, c := range []struct {
+		name         string
+		tables       []string
+		tableList    []string
+		withPatterns bool
+		expected     []string
+		errMsg       string
+	}{
+		{
+			name:      "duplicates",
+			tables:    []string{"db.t1", "db.t2"},
+			tableList: []string{"db.t2", " db.t1 ", "db.t3"},
+			expected:  []string{"db.t1", "db.t2", "db.t3"},
+		},
+		{
+			name:      "blanks",
+			tables:    []string{"", "db.t1"},
+			tableList: []string{"  ", "db.t2"},
+			expected:  []string{"db.t1", "db.t2"},
+		},
+		{
+			name:   "bad fqn",
+			tables: []string{"db.t1", "t2"},
+			errMsg: "invalid table t2, expected <db>.<table>",
+		},
+		{
+			name:      "bad fqn without table",
+			tableList: []string{"db."},
+			errMsg:    "invalid table db., expected <db>.<table>",
+		},
+		{
+			name:   "no table",
+			tables: []string{" "},
+			errMsg: "no table to replicate",
+		},
+		{
+			// the tables are given by --table-pattern or --database
+			name:         "no table with patterns",
+			withPatterns: true,
+			expected:     []string{},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tables, err := mergeTables(c.tables, c.tableList, c.withPatterns)
+			if c.errMsg != "" {
+				require.ErrorContains(t, err, c.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, tables)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+)
+
+// ctlTimeout is the timeout of a request to the API service
+const ctlTimeout = 30 * time.Second
+
+// NewCtlCmd returns the command controlling a running tidb2dw through its API service
+func NewCtlCmd() *cobra.Command {
+	var addr string
+
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Control a running tidb2dw through its API service",
+	}
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	cmd.PersistentFlags().StringVar(&addr, "addr", "127.0.0.1:8185", "address of the API service of tidb2dw, the --api.host and --api.port it is started with")
+
+	post := func(cmd *cobra.Command, path string) error {
+		url := addr
+		if !strings.Contains(url, "://") {
+			url = "http://" + url
+		}
+		url = strings.TrimSuffix(url, "/") + path
+		client := &http.Client{Timeout: ctlTimeout}
+		resp, err := client.Post(url, "application/json", nil)
+		if err != nil {
+			return errors.Annotatef(err, "Failed to request %s", url)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), strings.TrimSpace(string(body)))
+		return errors.Trace(err)
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "pause",
+		Short: "Pause merging the increment files into the data warehouse, the new files are still tracked",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return post(cmd, "/api/v1/pause")
+		},
+	}, &cobra.Command{
+		Use:   "resume",
+		Short: "Resume merging the increment files from where they are paused",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return post(cmd, "/api/v1/resume")
+		},
+	})
+	return cmd
+}
@@ -1,165 +1,166 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
-	"time"
+	"net/url"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
-	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
-	"github.com/pingcap/log"
-	"github.com/pingcap/tiflow/pkg/logutil"
 	"github.com/spf13/cobra"
-	"github.com/thediveo/enumflag"
-	"go.uber.org/zap"
 )
 
 func NewDatabricksCmd() *cobra.Command {
 	var (
-		tidbConfigFromCli       tidbsql.TiDBConfig
+		opts = ReplicationOptions{warehouse: Warehouse{
+			Name:              "databricks",
+			Title:             "Databricks",
+			StorageSchemes:    []string{"s3", "azure"},
+			StorageUsage:      "s3://<bucket>/<path> or azure://<container>/<path>",
+			Compressions:      []utils.Compression{utils.CompressionGzip, utils.CompressionZstd},
+			SnapshotFileSize:  "1GiB",
+			IdentifierCase:    identcase.Lower,
+			JSONType:          "STRING",
+			RouteSchema:       "databricks schema",
+			RouteTarget:       "[<catalog>.]<schema> or <catalog>.<schema>.<table>",
+			SchemaRouteTarget: "{source_db}=>main.{source_db}",
+			Namespaces:        2,
+		}}
 		databricksConfigFromCli databrickssql.DataBricksConfig
-		tables                  []string
-		snapshotConcurrency     int
-		storagePath             string
-		cdcHost                 string
-		cdcPort                 int
-		cdcFlushInterval        time.Duration
-		cdcFileSize             int64
-		timezone                string
-		logFile                 string
-		logLevel                string
-		awsAccessKey            string
-		awsSecretKey            string
+		csvFormat               databrickssql.CSVFormat
+		permissiveLoad          bool
+		partitionByValues       []string
 		credential              string
-		credValue               *credentials.Value
-
-		mode          RunMode
-		apiListenHost string
-		apiListenPort int
 	)
 
-	run := func() error {
-		err := logutil.InitLogger(&logutil.Config{
-			Level: logLevel,
-			File:  logFile,
-		})
-		if err != nil {
+	run := func(ctx context.Context, status *apiservice.APIInfo) error {
+		if err := csvFormat.Validate(); err != nil {
 			return errors.Trace(err)
 		}
-
-		if awsAccessKey != "" && awsSecretKey != "" {
-			credValue = &credentials.Value{
-				AccessKeyID:     awsAccessKey,
-				SecretAccessKey: awsSecretKey,
-			}
-		} else {
-			credValue, err = resolveAWSCredential(storagePath)
-			if err != nil {
-				return errors.Trace(err)
-			}
-		}
-
-		storageURI, err := getS3URIWithCredentials(storagePath, credValue)
+		defaultTarget := routing.Target{Database: databricksConfigFromCli.Catalog, Schema: databricksConfigFromCli.Schema}
+		cfg, err := opts.pipelineConfig(status, defaultTarget)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		defer opts.close()
 
-		snapshotURI, incrementURI, err := genSnapshotAndIncrementURIs(storageURI)
+		databricksConfigFromCli.IdentifierCase = opts.identifierCase
+		// the TIMESTAMP values without offset are read in the time zone of the session
+		databricksConfigFromCli.TimeZone = opts.tidbConfig.TimeZone
+		// COPY INTO of Databricks reads the files from AWS S3 or Azure Data Lake Storage directly
+		if endpoint := cfg.StorageURI.Query().Get("endpoint"); endpoint != "" {
+			return errors.Errorf("Databricks does not support custom storage endpoint %s", endpoint)
+		}
+		layouts, err := loadTableLayouts("databricks.partition-by", partitionByValues, "", nil, cfg.Tables, opts.findsNewTables())
 		if err != nil {
 			return errors.Trace(err)
 		}
 
-		snapConnectorMap := make(map[string]coreinterfaces.Connector)
-		increConnectorMap := make(map[string]coreinterfaces.Connector)
-		for _, tableFQN := range tables {
-			db, err := databricksConfigFromCli.OpenDB()
-			if err != nil {
-				return errors.Trace(err)
+		if opts.recorder != nil && credential != "" {
+			// the credential is checked against the credentials in Databricks
+			opts.recorder.StubQuery("SHOW STORAGE CREDENTIALS", []string{"name", "comment"}, []driver.Value{credential, nil})
+		}
+		// the catalog of the target must exist, and its schema unless --create-target-schema is set
+		openDB := func(tableFQN string, target routing.Target) (*sql.DB, error) {
+			if opts.recorder != nil {
+				return opts.recorder.OpenDB(fmt.Sprintf("%s => %s", tableFQN, target)), nil
 			}
-			snapConnector, err := databrickssql.NewDatabricksConnector(
-				db,
-				credential,
-				snapshotURI,
-			)
+			tableConfig := databricksConfigFromCli
+			tableConfig.Catalog, tableConfig.Schema = target.Database, target.Schema
+			db, err := tableConfig.OpenDB()
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			snapConnectorMap[tableFQN] = snapConnector
-			increConnector, err := databrickssql.NewDatabricksConnector(
+			return auditDB(opts.auditLogger, db, tableFQN), nil
+		}
+		newConnector := func(db *sql.DB, tableFQN string, target routing.Target, uri *url.URL, compression utils.Compression) (*databrickssql.DatabricksConnector, error) {
+			connector, err := databrickssql.NewDatabricksConnector(
 				db,
+				&databricksConfigFromCli,
 				credential,
-				incrementURI,
+				uri,
+				compression,
 			)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			increConnectorMap[tableFQN] = increConnector
+			connector.SetColumnTypes(cfg.ColumnMapping.Table(tableFQN))
+			connector.SetColumnFilter(cfg.ColumnFilter.Table(tableFQN))
+			connector.SetDeleteMode(opts.deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetNamespace(databrickssql.Namespace{Catalog: target.Database, Schema: target.Schema})
+			return connector, nil
 		}
-
-		defer func() {
-			for _, connector := range snapConnectorMap {
-				connector.Close()
-			}
-			for _, connector := range increConnectorMap {
-				connector.Close()
+		newConnectors := func(tableFQN string, target routing.Target) (tableConnectors, error) {
+			db, err := openDB(tableFQN, target)
+			if err != nil {
+				return tableConnectors{}, errors.Trace(err)
 			}
-		}()
-		return Replicate(&tidbConfigFromCli, tables, storageURI, snapshotConcurrency, cdcHost, cdcPort, cdcFlushInterval, cdcFileSize, snapConnectorMap, increConnectorMap, mode)
-	}
-
-	cmd := &cobra.Command{
-		Use:   "databricks",
-		Short: "Replicate snapshot and incremental data from TiDB to Databricks",
-		Run: func(_ *cobra.Command, _ []string) {
-			runWithServer(mode == RunModeCloud, fmt.Sprintf("%s:%d", apiListenHost, apiListenPort), func() {
-				if err := run(); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusFatalError(err)
-					log.Error("Fatal error running databricks replication", zap.Error(err))
-				} else {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusIdle()
-				}
-			})
-		},
+			return tableConnectors{
+				snapshot: func(uri *url.URL) (coreinterfaces.Connector, error) {
+					connector, err := newConnector(db, tableFQN, target, uri, cfg.SnapshotCompression)
+					if err != nil {
+						return nil, errors.Trace(err)
+					}
+					connector.SetSnapshotLoadOptions(csvFormat, permissiveLoad)
+					return connector, nil
+				},
+				increment: func() (coreinterfaces.Connector, error) {
+					connector, err := newConnector(db, tableFQN, target, opts.incrementURI, cfg.IncrementCompression)
+					if err != nil {
+						return nil, errors.Trace(err)
+					}
+					connector.SetWhere(cfg.Where[tableFQN])
+					return connector, nil
+				},
+			}, nil
+		}
+		return opts.runPipeline(ctx, cfg, newConnectors)
 	}
 
-	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
-	cmd.Flags().Var(enumflag.New(&mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive), "mode", "replication mode: full, snapshot-only, incremental-only, cloud")
-	cmd.Flags().StringVar(&apiListenHost, "api.host", "0.0.0.0", "API service listen host, only available in --mode=cloud")
-	cmd.Flags().IntVar(&apiListenPort, "api.port", 8185, "API service listen port, only available in --mode=cloud")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
-	cmd.Flags().IntVarP(&tidbConfigFromCli.Port, "tidb.port", "P", 4000, "TiDB port")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.User, "tidb.user", "u", "root", "TiDB user")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Pass, "tidb.pass", "p", "", "TiDB password")
-	cmd.Flags().StringVar(&tidbConfigFromCli.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
-	cmd.Flags().StringVar(&databricksConfigFromCli.Host, "databricks.host", "", "databricks host")
-	cmd.Flags().IntVar(&databricksConfigFromCli.Port, "databricks.port", 443, "databricks port")
-	cmd.Flags().StringVar(&databricksConfigFromCli.Token, "databricks.token", "", "databricks token")
+	cmd := opts.newCommand(run)
+	addDatabricksFlags(cmd, &databricksConfigFromCli)
 	cmd.Flags().StringVar(&credential, "databricks.credential", "", "databricks storage credential name. \nIf just one credential in databricks, this property is not required. \nYou can use 'SHOW STORAGE CREDENTIALS' in databricks to check what credential names are available.")
-	cmd.Flags().StringVar(&databricksConfigFromCli.Endpoint, "databricks.endpoint", "", "databricks endpoint")
-	cmd.Flags().StringVar(&databricksConfigFromCli.Schema, "databricks.schema", "", "databricks schema")
-	cmd.Flags().StringVar(&databricksConfigFromCli.Catalog, "databricks.catalog", "", "databricks catalog")
-	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
-	cmd.Flags().IntVar(&snapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
-	cmd.Flags().StringVarP(&storagePath, "storage", "s", "", "storage path: s3://<bucket>/<path> or gcs://<bucket>/<path>")
-	cmd.Flags().StringVar(&cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
-	cmd.Flags().IntVar(&cdcPort, "cdc.port", 8300, "TiCDC server port")
-	cmd.Flags().DurationVar(&cdcFlushInterval, "cdc.flush-interval", 60*time.Second, "")
-	cmd.Flags().Int64Var(&cdcFileSize, "cdc.file-size", 64*1024*1024, "")
-	cmd.Flags().StringVar(&timezone, "tz", "System", "specify time zone of storage consumer")
-	cmd.Flags().StringVar(&logFile, "log.file", "", "log file path")
-	cmd.Flags().StringVar(&logLevel, "log.level", "info", "log level")
-	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
-	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().BoolVar(&databricksConfigFromCli.CreateSchema, "create-target-schema", false, "create the databricks schema of the tables if it does not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&csvFormat.Delimiter, "databricks.csv-delimiter", databrickssql.DefaultCSVFormat.Delimiter, "field delimiter of the snapshot CSV files read by COPY INTO")
+	cmd.Flags().StringVar(&csvFormat.Quote, "databricks.csv-quote", databrickssql.DefaultCSVFormat.Quote, "quote character of the snapshot CSV files read by COPY INTO")
+	cmd.Flags().StringVar(&csvFormat.Escape, "databricks.csv-escape", databrickssql.DefaultCSVFormat.Escape, "escape character of the snapshot CSV files read by COPY INTO")
+	cmd.Flags().StringVar(&csvFormat.NullValue, "databricks.csv-null-value", databrickssql.DefaultCSVFormat.NullValue, "string of NULL in the snapshot CSV files read by COPY INTO")
+	cmd.Flags().BoolVar(&permissiveLoad, "permissive-load", false, "load the malformed rows of the snapshot files into the table <table>_quarantine instead of failing the load")
+	cmd.Flags().StringArrayVar(&partitionByValues, "databricks.partition-by", []string{}, "partition a table created in Databricks by the columns, e.g. --databricks.partition-by 'db.t=created_date'")
+	opts.addDeleteModeFlag(cmd)
+	opts.addStagingFormatFlag(cmd)
+	opts.addS3Flags(cmd)
+	opts.addAzureFlags(cmd)
+	markDatabricksFlagsRequired(cmd)
+	return cmd
+}
 
-	cmd.MarkFlagRequired("storage")
+// addDatabricksFlags adds the flags of the connection to Databricks
+func addDatabricksFlags(cmd *cobra.Command, cfg *databrickssql.DataBricksConfig) {
+	cmd.Flags().StringVar(&cfg.Host, "databricks.host", "", "databricks host")
+	cmd.Flags().IntVar(&cfg.Port, "databricks.port", 443, "databricks port")
+	cmd.Flags().StringVar(&cfg.Token, "databricks.token", "", "databricks token")
+	cmd.Flags().StringVar(&cfg.Endpoint, "databricks.endpoint", "", "databricks endpoint")
+	cmd.Flags().StringVar(&cfg.Schema, "databricks.schema", "", "databricks schema")
+	cmd.Flags().StringVar(&cfg.Catalog, "databricks.catalog", "", "databricks catalog")
+}
+
+// markDatabricksFlagsRequired marks the flags of the connection to Databricks required
+func markDatabricksFlagsRequired(cmd *cobra.Command) {
 	cmd.MarkFlagRequired("databricks.host")
 	cmd.MarkFlagRequired("databricks.token")
 	cmd.MarkFlagRequired("databricks.endpoint")
 	cmd.MarkFlagRequired("databricks.schema")
 	cmd.MarkFlagRequired("databricks.catalog")
-
-	return cmd
 }
@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/version"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// diagLogLines is the number of the last log lines kept for the diagnostics bundle
+const diagLogLines = 500
+
+// notifyFlushTimeout is how long the notifications not sent yet are waited for before the process exits
+const notifyFlushTimeout = 30 * time.Second
+
+// Diagnostics writes a bundle of diagnostics when the replication fails, into --diag-dir
+// or the diag directory of the workspace if --diag-dir is not given
+type Diagnostics struct {
+	Dir  string
+	logs *diag.LogBuffer
+	// config is the replication being run, nil if the run fails before the replication starts
+	config *engine.PipelineConfig
+}
+
+func (d *Diagnostics) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&d.Dir, "diag-dir", "", "local directory of the diagnostics bundle written on fatal error, the diag directory of the storage by default")
+}
+
+// initLogger initializes the global logger and keeps its last lines for the bundle
+func (d *Diagnostics) initLogger(logFile, logLevel string) error {
+	if err := logutil.InitLogger(&logutil.Config{
+		Level: logLevel,
+		File:  logFile,
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	d.logs = diag.NewLogBuffer(diagLogLines)
+	if err := diag.CaptureLogs(d.logs); err != nil {
+		return errors.Trace(err)
+	}
+	// the entries are redacted before they are written into both the log file and the buffer
+	diag.RedactLogs()
+	return nil
+}
+
+// setConfig records the replication for the bundle
+func (d *Diagnostics) setConfig(cfg *engine.PipelineConfig) {
+	d.config = cfg
+}
+
+// flushNotifications waits a while for the notifications of the replication to be sent before the process exits
+func (d *Diagnostics) flushNotifications() {
+	if d.config == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyFlushTimeout)
+	defer cancel()
+	d.config.Notifier.Close(ctx)
+}
+
+// writeBundle writes the bundle of the fatal error with the status of the replication, failures are logged
+// since the error is reported anyway
+func (d *Diagnostics) writeBundle(runErr error, apiInfo *apiservice.APIInfo) {
+	if d.Dir == "" && d.config == nil {
+		log.Warn("Skipped writing diagnostics bundle since neither --diag-dir nor the storage is known")
+		return
+	}
+	// the context of the replication may be canceled already
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	bundle := &diag.Bundle{
+		Err:       runErr,
+		Version:   version.NewTiDB2DWVersion().String(),
+		Config:    d.configInfo(),
+		CreatedAt: time.Now(),
+	}
+	if d.logs != nil {
+		bundle.Logs = d.logs.Lines()
+	}
+	status, err := apiInfo.MarshalStatus()
+	if err != nil {
+		log.Warn("Failed to collect status for diagnostics bundle", zap.Error(err))
+	}
+	bundle.Status = status
+	// the storage is not touched in a dry run
+	if d.config != nil && !d.config.DryRun {
+		extStorage, err := utils.GetExternalStorageFromURI(ctx, d.config.StorageURI.String())
+		if err == nil {
+			bundle.Files, bundle.FilesTruncated, err = diag.ListStorageFiles(ctx, extStorage, diag.MaxListedFiles)
+		}
+		bundle.FilesErr = err
+	}
+
+	var buf bytes.Buffer
+	if err = bundle.Write(&buf); err != nil {
+		log.Warn("Failed to generate diagnostics bundle", zap.Error(err))
+		return
+	}
+	if d.Dir != "" {
+		path := filepath.Join(d.Dir, bundle.Name())
+		if err = os.MkdirAll(d.Dir, 0o755); err == nil {
+			err = os.WriteFile(path, buf.Bytes(), 0o600)
+		}
+		if err != nil {
+			log.Warn("Failed to write diagnostics bundle", zap.String("path", path), zap.Error(err))
+			return
+		}
+		log.Info("Diagnostics bundle written", zap.String("path", path))
+		return
+	}
+	if d.config.DryRun {
+		log.Warn("Skipped writing diagnostics bundle into the storage in dry run, set --diag-dir to write it")
+		return
+	}
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, d.config.StorageURI.String())
+	if err == nil {
+		err = extStorage.WriteFile(ctx, "diag/"+bundle.Name(), buf.Bytes())
+	}
+	if err != nil {
+		log.Warn("Failed to write diagnostics bundle to storage", zap.Error(err))
+		return
+	}
+	log.Info("Diagnostics bundle written", zap.String("path", fmt.Sprintf("%s/diag/%s", utils.RedactStorageURI(d.config.StorageURI), bundle.Name())))
+}
+
+// configInfo returns the command line and the replication config without secrets
+func (d *Diagnostics) configInfo() map[string]any {
+	info := map[string]any{"args": redactArgs(os.Args[1:])}
+	cfg := d.config
+	if cfg == nil {
+		return info
+	}
+	if cfg.RateLimiters != nil {
+		if rate := cfg.RateLimiters.Upload.Rate(); rate > 0 {
+			info["upload_rate_limit"] = ratelimit.FormatRate(rate)
+		}
+		if rate := cfg.RateLimiters.Download.Rate(); rate > 0 {
+			info["download_rate_limit"] = ratelimit.FormatRate(rate)
+		}
+	}
+	info["mode"] = engine.RunModeIds[cfg.Mode][0]
+	info["tables"] = cfg.Tables
+	info["storage"] = utils.RedactStorageURI(cfg.StorageURI)
+	info["tidb"] = cfg.TiDBConfig.User + "@" + net.JoinHostPort(cfg.TiDBConfig.Host, strconv.Itoa(cfg.TiDBConfig.Port))
+	info["cdc"] = cfg.CDC.Addr()
+	info["cdc_flush_interval"] = cfg.CDCFlushInterval.String()
+	info["cdc_file_size"] = cfg.CDCFileSize
+	if cfg.ChangefeedConfig != nil {
+		info["changefeed_config"] = cfg.ChangefeedConfig
+	}
+	info["snapshot_concurrency"] = cfg.SnapshotConcurrency
+	info["snapshot_compression"] = cfg.SnapshotCompression
+	info["increment_compression"] = cfg.IncrementCompression
+	info["increment_options"] = cfg.IncrementOptions
+	info["unknown_ddl"] = cfg.UnknownDDLPolicy
+	info["on_rename"] = cfg.RenamePolicy
+	if cfg.UnsupportedDDLPolicy != "" {
+		info["on_unsupported_ddl"] = cfg.UnsupportedDDLPolicy
+	}
+	info["allow_new_tables"] = cfg.AllowNewTables
+	if cfg.StartTSO != 0 {
+		info["start_tso"] = cfg.StartTSO
+	}
+	if cfg.PauseChangefeedOnExit {
+		info["pause_changefeed_on_exit"] = true
+	}
+	if cfg.CleanWorkspace {
+		info["clean_workspace"] = true
+	}
+	if cfg.DryRun {
+		info["dry_run"] = true
+	}
+	if cfg.PipelinedSnapshot {
+		info["pipelined_snapshot"] = true
+	}
+	if cfg.ForceRedump {
+		info["force_redump"] = true
+	}
+	if cfg.CDCProtocol != "" {
+		info["cdc_protocol"] = cfg.CDCProtocol
+	}
+	if cfg.StagingFormat != "" {
+		info["staging_format"] = cfg.StagingFormat
+	}
+	if cfg.SnapshotValidation.Enabled {
+		info["snapshot_validation"] = cfg.SnapshotValidation
+	}
+	if cfg.SnapshotOrder != (engine.SnapshotOrderOptions{}) {
+		info["snapshot_order"] = cfg.SnapshotOrder
+	}
+	if len(cfg.ColumnMapping) > 0 {
+		info["column_mapping"] = cfg.ColumnMapping
+	}
+	if len(cfg.ColumnFilter) > 0 {
+		info["column_filter"] = cfg.ColumnFilter
+	}
+	if len(cfg.Where) > 0 {
+		info["where"] = cfg.Where
+	}
+	if cfg.DumpChunkConfig != nil {
+		info["dump_chunk"] = *cfg.DumpChunkConfig
+	}
+	if cfg.FieldLimitConfig != nil {
+		info["field_limit"] = *cfg.FieldLimitConfig
+	}
+	return info
+}
+
+// redactArgs masks the values of the flags carrying secrets, e.g. --tidb.pass and --aws.secret-key
+func redactArgs(args []string) []string {
+	redacted := make([]string, 0, len(args))
+	maskNext := false
+	for _, arg := range args {
+		switch {
+		case maskNext:
+			arg = "xxxxx"
+			maskNext = false
+		case strings.HasPrefix(arg, "-"):
+			if name, _, ok := strings.Cut(arg, "="); ok {
+				if diag.IsSecretFlag(name) {
+					arg = name + "=xxxxx"
+				}
+			} else {
+				// -p is the shorthand of --tidb.pass
+				maskNext = diag.IsSecretFlag(arg) || arg == "-p"
+			}
+		}
+		redacted = append(redacted, diag.RedactSecrets(arg))
+	}
+	return redacted
+}
+
+// runReplication runs the replication of the data warehouse. On fatal error, the error is reported by the API
+// service, a diagnostics bundle is written, and the process exits with the exit code of the error category.
+// The status of the replication is given to run, which reports into it.
+func runReplication(warehouse string, startServer bool, addr string, diagnostics *Diagnostics, run func(ctx context.Context, status *apiservice.APIInfo) error) {
+	var runErr error
+	status := apiservice.NewAPIInfo()
+	runWithServer(startServer, addr, status, func(ctx context.Context) {
+		if runErr = run(ctx, status); runErr == nil {
+			status.SetServiceStatusIdle()
+			return
+		}
+		status.SetServiceStatusFatalError(runErr)
+		diagnostics.writeBundle(runErr, status)
+		category := diag.CategoryOf(runErr)
+		log.Error(fmt.Sprintf("Fatal error running %s replication", warehouse),
+			zap.String("category", string(category)), zap.Int("exitCode", category.ExitCode()), zap.Error(runErr))
+	})
+	diagnostics.flushNotifications()
+	if runErr != nil {
+		_ = log.Sync()
+		os.Exit(diag.CategoryOf(runErr).ExitCode())
+	}
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// DryRunOptions renders the statements of the data warehouse instead of executing them
+type DryRunOptions struct {
+	Enabled bool
+	// Output is the file the statements are written to, stdout if empty
+	Output string
+}
+
+func (opts *DryRunOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&opts.Enabled, "dry-run", false, "print the statements which would be executed in the data warehouse instead of executing them, the storage and TiCDC are not touched")
+	cmd.Flags().StringVar(&opts.Output, "dry-run-output", "", "file the statements of --dry-run are written to, stdout by default")
+}
+
+// newRecorder returns the recorder of the statements, nil if dry run is disabled
+func (opts *DryRunOptions) newRecorder() (*dryrun.Recorder, error) {
+	if !opts.Enabled {
+		if opts.Output != "" {
+			return nil, errors.New("--dry-run-output is only available with --dry-run")
+		}
+		return nil, nil
+	}
+	recorder, err := dryrun.NewRecorder(opts.Output)
+	return recorder, errors.Trace(err)
+}
+
+// closeRecorder is deferred after the connectors are closed, so that the statements of closing them are recorded
+func closeRecorder(recorder *dryrun.Recorder) {
+	if recorder == nil {
+		return
+	}
+	if err := recorder.Close(); err != nil {
+		log.Error("Failed to write dry run output", zap.Error(err))
+	}
+}
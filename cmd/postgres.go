@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/staging"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewPostgresCmd() *cobra.Command {
+	var (
+		opts = ReplicationOptions{warehouse: Warehouse{
+			Name:  "postgres",
+			Title: "PostgreSQL",
+			// the files are read by tidb2dw and streamed into PostgreSQL, so any storage of dumpling and TiCDC works
+			StorageSchemes:    []string{"s3", "gs", "gcs", "azure", "azblob"},
+			StorageUsage:      "s3://<bucket>/<path>, gs://<bucket>/<path> or azure://<container>/<path>",
+			Compressions:      []utils.Compression{utils.CompressionGzip, utils.CompressionSnappy, utils.CompressionZstd},
+			SnapshotFileSize:  "250MiB",
+			JSONType:          "JSONB",
+			RouteSchema:       "postgres schema",
+			RouteTarget:       "<schema>[.<table>]",
+			SchemaRouteTarget: "{source_db}=>raw_{source_db}",
+			Namespaces:        1,
+			PKLessAppends:     true,
+		}}
+		postgresConfigFromCli postgressql.PostgresConfig
+		stagingDir            string
+		incrementModeValue    string
+		maxStagingBytes       int64
+	)
+
+	run := func(ctx context.Context, status *apiservice.APIInfo) error {
+		if err := checkPostgresIdentifierCase(opts.identifierCaseName); err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.pipelineConfig(status, routing.Target{Schema: postgresConfigFromCli.Schema})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer opts.close()
+
+		incrementMode, err := incrementmode.Parse(incrementModeValue)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		var stagingArea *staging.Area
+		if stagingDir != "" {
+			if stagingArea, err = staging.NewArea(stagingDir, maxStagingBytes); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		openDB := func(tableFQN string) (*sql.DB, error) {
+			if opts.recorder != nil {
+				return opts.recorder.OpenDB(tableFQN), nil
+			}
+			db, err := postgresConfigFromCli.OpenDB()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return auditDB(opts.auditLogger, db, tableFQN), nil
+		}
+		newConnector := func(db *sql.DB, tableFQN string, target routing.Target, uri *url.URL, compression utils.Compression) (*postgressql.PostgresConnector, error) {
+			connector, err := postgressql.NewPostgresConnector(db, target.Schema, uri, compression, postgresConfigFromCli.CreateSchema)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			connector.SetColumnTypes(cfg.ColumnMapping.Table(tableFQN))
+			connector.SetColumnFilter(cfg.ColumnFilter.Table(tableFQN))
+			connector.SetWhere(cfg.Where[tableFQN])
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetIncrementMode(incrementMode)
+			if stagingArea != nil {
+				connector.SetStagingArea(stagingArea)
+			}
+			connector.SetRateLimiters(cfg.RateLimiters)
+			if opts.recorder != nil {
+				connector.EnableDryRun()
+			}
+			return connector, nil
+		}
+		newConnectors := func(tableFQN string, target routing.Target) (tableConnectors, error) {
+			db, err := openDB(tableFQN)
+			if err != nil {
+				return tableConnectors{}, errors.Trace(err)
+			}
+			return tableConnectors{
+				snapshot: func(uri *url.URL) (coreinterfaces.Connector, error) {
+					return newConnector(db, tableFQN, target, uri, cfg.SnapshotCompression)
+				},
+				increment: func() (coreinterfaces.Connector, error) {
+					return newConnector(db, tableFQN, target, opts.incrementURI, cfg.IncrementCompression)
+				},
+			}, nil
+		}
+		return opts.runPipeline(ctx, cfg, newConnectors)
+	}
+
+	cmd := opts.newCommand(run)
+	addPostgresFlags(cmd, &postgresConfigFromCli)
+	cmd.Flags().BoolVar(&postgresConfigFromCli.CreateSchema, "create-target-schema", true, "create the postgres schema of the tables if it does not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&incrementModeValue, "increment-mode", "merge", "how the increment files are applied: merge merges the changes into the table, append appends every change with its tidb2dw_flag and tidb2dw_commit_ts to the <table>_changelog table and keeps the snapshot in the table")
+	cmd.Flags().StringVar(&stagingDir, "staging-dir", "", "local directory the files are downloaded into before they are copied into PostgreSQL, the files are streamed from the storage by default")
+	cmd.Flags().Int64Var(&maxStagingBytes, "max-staging-bytes", 1024*1024*1024, "max bytes of the files downloaded into --staging-dir and not loaded yet, the downloads wait when it is reached")
+	addPostgresIdentifierCaseFlag(cmd, &opts.identifierCaseName)
+	opts.addS3Flags(cmd)
+	opts.addGCSFlags(cmd)
+	opts.addAzureFlags(cmd)
+	cmd.MarkFlagRequired("postgres.host")
+	return cmd
+}
+
+// addPostgresFlags adds the flags of the connection to PostgreSQL
+func addPostgresFlags(cmd *cobra.Command, cfg *postgressql.PostgresConfig) {
+	cmd.Flags().StringVar(&cfg.Host, "postgres.host", "", "postgres host")
+	cmd.Flags().IntVar(&cfg.Port, "postgres.port", 5432, "postgres port")
+	cmd.Flags().StringVar(&cfg.User, "postgres.user", "", "postgres user")
+	cmd.Flags().StringVar(&cfg.Pass, "postgres.pass", "", "postgres password")
+	cmd.Flags().StringVar(&cfg.Database, "postgres.database", "", "postgres database")
+	cmd.Flags().StringVar(&cfg.Schema, "postgres.schema", "public", "postgres schema")
+	cmd.Flags().StringVar(&cfg.SSLMode, "postgres.sslmode", "require", "postgres sslmode: disable, require, verify-ca, verify-full")
+	cmd.Flags().StringVar(&cfg.SSLRootCert, "postgres.ssl-ca", "", "CA verifying the certificate of postgres with --postgres.sslmode=verify-ca or verify-full")
+	cmd.Flags().StringVar(&cfg.SSLCert, "postgres.ssl-cert", "", "postgres SSL client certificate")
+	cmd.Flags().StringVar(&cfg.SSLKey, "postgres.ssl-key", "", "postgres SSL client key")
+}
+
+// addPostgresIdentifierCaseFlag adds the --identifier-case flag of the other data warehouses, which only accepts
+// preserve as the names are written in PostgreSQL as in TiDB
+func addPostgresIdentifierCaseFlag(cmd *cobra.Command, identifierCase *string) {
+	cmd.Flags().StringVar(identifierCase, "identifier-case", string(identcase.Preserve), "case the names of the tables and the columns are written in the data warehouse, only preserve is supported by PostgreSQL")
+}
+
+// checkPostgresIdentifierCase rejects an --identifier-case other than preserve
+func checkPostgresIdentifierCase(name string) error {
+	identifierCase, err := identcase.Parse(name, identcase.Preserve)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if identifierCase != identcase.Preserve {
+		return errors.Errorf("PostgreSQL writes the names as in TiDB, --identifier-case %s is not supported", name)
+	}
+	return nil
+}
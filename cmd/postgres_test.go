@@ -0,0 +1,14 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPostgresIdentifierCase(t *testing.T) {
+	require.NoError(t, checkPostgresIdentifierCase(""))
+	require.NoError(t, checkPostgresIdentifierCase("Preserve"))
+	require.EqualError(t, checkPostgresIdentifierCase("lower"), "PostgreSQL writes the names as in TiDB, --identifier-case lower is not supported")
+	require.ErrorContains(t, checkPostgresIdentifierCase("camel"), "unknown identifier case camel")
+}
@@ -1,169 +1,156 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"time"
+	"net/url"
+	"slices"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
 	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
-	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/tiflow/pkg/logutil"
 	"github.com/spf13/cobra"
-	"github.com/thediveo/enumflag"
 	"go.uber.org/zap"
 )
 
 func NewRedshiftCmd() *cobra.Command {
 	var (
-		tidbConfigFromCli     tidbsql.TiDBConfig
+		opts = ReplicationOptions{warehouse: Warehouse{
+			Name:              "redshift",
+			Title:             "Redshift",
+			StorageSchemes:    []string{"s3"},
+			StorageUsage:      "s3://<bucket>/<path>",
+			Compressions:      []utils.Compression{utils.CompressionGzip, utils.CompressionZstd},
+			SnapshotFileSize:  "250MiB",
+			IdentifierCase:    identcase.Lower,
+			JSONType:          "VARCHAR(65535)",
+			RouteSchema:       "redshift schema",
+			RouteTarget:       "<schema>[.<table>]",
+			SchemaRouteTarget: "{source_db}=>raw_{source_db}",
+			Namespaces:        1,
+		}}
 		redshiftConfigFromCli redshiftsql.RedshiftConfig
-		tables                []string
-		snapshotConcurrency   int
-		storagePath           string
-		cdcHost               string
-		cdcPort               int
-		cdcFlushInterval      time.Duration
-		cdcFileSize           int64
-		timezone              string
-		logFile               string
-		logLevel              string
-		awsAccessKey          string
-		awsSecretKey          string
-		credValue             *credentials.Value
-
-		mode          RunMode
-		apiListenHost string
-		apiListenPort int
+		incrementStrategyName string
+		tableProperties       []string
 	)
 
-	run := func() error {
-		err := logutil.InitLogger(&logutil.Config{
-			Level: logLevel,
-			File:  logFile,
-		})
+	run := func(ctx context.Context, status *apiservice.APIInfo) error {
+		cfg, err := opts.pipelineConfig(status, routing.Target{Schema: redshiftConfigFromCli.Schema})
 		if err != nil {
 			return errors.Trace(err)
 		}
+		defer opts.close()
 
-		if awsAccessKey != "" && awsSecretKey != "" {
-			credValue = &credentials.Value{
-				AccessKeyID:     awsAccessKey,
-				SecretAccessKey: awsSecretKey,
-			}
-		} else {
-			credValue, err = resolveAWSCredential(storagePath)
-			if err != nil {
-				return errors.Trace(err)
-			}
-		}
-
-		storageURI, err := getS3URIWithCredentials(storagePath, credValue)
+		incrementStrategy, err := redshiftsql.ParseIncrementStrategy(incrementStrategyName)
 		if err != nil {
 			return errors.Trace(err)
 		}
-
-		snapshotURI, incrementURI, err := genSnapshotAndIncrementURIs(storageURI)
+		tablePropertiesOverrides, err := redshiftsql.ParseTablePropertiesOverrides(tableProperties)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		for tableFQN := range tablePropertiesOverrides {
+			// the table may be created later with --allow-new-tables
+			if !slices.Contains(cfg.Tables, tableFQN) && !cfg.AllowNewTables {
+				log.Warn("Ignored the table properties of a table not replicated", zap.String("table", tableFQN))
+			}
+		}
 
-		snapConnectorMap := make(map[string]coreinterfaces.Connector)
-		increConnectorMap := make(map[string]coreinterfaces.Connector)
-		for _, tableFQN := range tables {
-			_, sourceTable := utils.SplitTableFQN(tableFQN)
-			db, err := redshiftConfigFromCli.OpenDB()
-			if err != nil {
-				return errors.Trace(err)
+		// the external tables are named after the tables in the data warehouse
+		targetTableName := func(tableFQN string, target routing.Target) string {
+			if target.Table != "" {
+				return target.Table
 			}
-			snapConnector, err := redshiftsql.NewRedshiftConnector(
+			_, sourceTable := utils.SplitTableFQN(tableFQN)
+			return sourceTable
+		}
+		newConnector := func(db *sql.DB, tableFQN string, target routing.Target, kind string, uri *url.URL, compression utils.Compression) (*redshiftsql.RedshiftConnector, error) {
+			connector, err := redshiftsql.NewRedshiftConnector(
 				db,
-				redshiftConfigFromCli.Schema,
-				fmt.Sprintf("snapshot_external_%s", sourceTable),
+				opts.identifierCase,
+				target.Schema,
+				fmt.Sprintf("%s_external_%s", kind, targetTableName(tableFQN, target)),
 				redshiftConfigFromCli.Role,
-				snapshotURI,
-				credValue,
+				uri,
+				opts.credentials.AWS,
+				compression,
+				incrementStrategy,
+				redshiftConfigFromCli.CreateSchema,
 			)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			snapConnectorMap[tableFQN] = snapConnector
-
-			increConnector, err := redshiftsql.NewRedshiftConnector(
-				db,
-				redshiftConfigFromCli.Schema,
-				fmt.Sprintf("increment_external_%s", sourceTable),
-				redshiftConfigFromCli.Role,
-				incrementURI,
-				credValue,
-			)
-			if err != nil {
-				return errors.Trace(err)
+			connector.SetTableProperties(targetTableName(tableFQN, target), tablePropertiesOverrides[tableFQN])
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			connector.SetColumnTypes(cfg.ColumnMapping.Table(tableFQN))
+			connector.SetColumnFilter(cfg.ColumnFilter.Table(tableFQN))
+			connector.SetDeleteMode(opts.deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			if opts.recorder != nil {
+				connector.EnableDryRun()
 			}
-			increConnectorMap[tableFQN] = increConnector
+			return connector, nil
 		}
-
-		defer func() {
-			for _, connector := range snapConnectorMap {
-				connector.Close()
+		openDB := func(tableFQN string) (*sql.DB, error) {
+			if opts.recorder != nil {
+				return opts.recorder.OpenDB(tableFQN), nil
 			}
-			for _, connector := range increConnectorMap {
-				connector.Close()
+			db, err := redshiftConfigFromCli.OpenDB()
+			if err != nil {
+				return nil, errors.Trace(err)
 			}
-		}()
-
-		return Replicate(&tidbConfigFromCli, tables, storageURI, snapshotConcurrency, cdcHost, cdcPort, cdcFlushInterval, cdcFileSize, snapConnectorMap, increConnectorMap, mode)
-	}
-
-	cmd := &cobra.Command{
-		Use:   "redshift",
-		Short: "Replicate snapshot and incremental data from TiDB to Redshift",
-		Run: func(_ *cobra.Command, _ []string) {
-			runWithServer(mode == RunModeCloud, fmt.Sprintf("%s:%d", apiListenHost, apiListenPort), func() {
-				if err := run(); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusFatalError(err)
-					log.Error("Fatal error running redshift replication", zap.Error(err))
-				} else {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusIdle()
-				}
-			})
-		},
+			return auditDB(opts.auditLogger, db, tableFQN), nil
+		}
+		newConnectors := func(tableFQN string, target routing.Target) (tableConnectors, error) {
+			db, err := openDB(tableFQN)
+			if err != nil {
+				return tableConnectors{}, errors.Trace(err)
+			}
+			return tableConnectors{
+				snapshot: func(uri *url.URL) (coreinterfaces.Connector, error) {
+					return newConnector(db, tableFQN, target, "snapshot", uri, cfg.SnapshotCompression)
+				},
+				increment: func() (coreinterfaces.Connector, error) {
+					connector, err := newConnector(db, tableFQN, target, "increment", opts.incrementURI, cfg.IncrementCompression)
+					if err != nil {
+						return nil, errors.Trace(err)
+					}
+					connector.SetWhere(cfg.Where[tableFQN])
+					return connector, nil
+				},
+			}, nil
+		}
+		return opts.runPipeline(ctx, cfg, newConnectors)
 	}
 
-	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
-	cmd.Flags().Var(enumflag.New(&mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive), "mode", "replication mode: full, snapshot-only, incremental-only, cloud")
-	cmd.Flags().StringVar(&apiListenHost, "api.host", "0.0.0.0", "API service listen host, only available in --mode=cloud")
-	cmd.Flags().IntVar(&apiListenPort, "api.port", 8185, "API service listen port, only available in --mode=cloud")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
-	cmd.Flags().IntVarP(&tidbConfigFromCli.Port, "tidb.port", "P", 4000, "TiDB port")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.User, "tidb.user", "u", "root", "TiDB user")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Pass, "tidb.pass", "p", "", "TiDB password")
-	cmd.Flags().StringVar(&tidbConfigFromCli.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
-	cmd.Flags().StringVar(&redshiftConfigFromCli.Host, "redshift.host", "", "redshift host")
-	cmd.Flags().IntVar(&redshiftConfigFromCli.Port, "redshift.port", 5439, "redshift port")
-	cmd.Flags().StringVar(&redshiftConfigFromCli.User, "redshift.user", "", "redshift user")
-	cmd.Flags().StringVar(&redshiftConfigFromCli.Pass, "redshift.pass", "", "redshift password")
-	cmd.Flags().StringVar(&redshiftConfigFromCli.Database, "redshift.database", "", "redshift database")
-	cmd.Flags().StringVar(&redshiftConfigFromCli.Schema, "redshift.schema", "", "redshift schema")
+	cmd := opts.newCommand(run)
+	addRedshiftFlags(cmd, &redshiftConfigFromCli)
+	cmd.Flags().BoolVar(&redshiftConfigFromCli.CreateSchema, "create-target-schema", true, "create the redshift schema of the tables if it does not exist, otherwise a missing schema fails the replication")
 	cmd.Flags().StringVar(&redshiftConfigFromCli.Role, "redshift.role", "", "iam role for redshift")
-	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
-	cmd.Flags().IntVar(&snapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
-	cmd.Flags().StringVarP(&storagePath, "storage", "s", "", "storage path: s3://<bucket>/<path> or gcs://<bucket>/<path>")
-	cmd.Flags().StringVar(&cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
-	cmd.Flags().IntVar(&cdcPort, "cdc.port", 8300, "TiCDC server port")
-	cmd.Flags().DurationVar(&cdcFlushInterval, "cdc.flush-interval", 60*time.Second, "")
-	cmd.Flags().Int64Var(&cdcFileSize, "cdc.file-size", 64*1024*1024, "")
-	cmd.Flags().StringVar(&timezone, "tz", "System", "specify time zone of storage consumer")
-	cmd.Flags().StringVar(&logFile, "log.file", "", "log file path")
-	cmd.Flags().StringVar(&logLevel, "log.level", "info", "log level")
-	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
-	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
-
-	cmd.MarkFlagRequired("storage")
+	cmd.Flags().StringVar(&incrementStrategyName, "redshift.increment-strategy", string(redshiftsql.IncrementStrategyMerge), "how the increment files are merged: merge reads them by external tables of Redshift Spectrum, delete-insert copies them into a temporary table and merges it by DELETE and INSERT in a transaction")
+	cmd.Flags().StringArrayVar(&tableProperties, "redshift.table-properties", []string{}, "distribution and sort keys of a table created by tidb2dw, e.g. --redshift.table-properties 'db.t:distkey=user_id,sortkey=(created_at)'")
+	opts.addDeleteModeFlag(cmd)
+	opts.addStagingFormatFlag(cmd)
+	opts.addS3Flags(cmd)
 	cmd.MarkFlagRequired("redshift.host")
 	return cmd
 }
+
+// addRedshiftFlags adds the flags of the connection to Redshift
+func addRedshiftFlags(cmd *cobra.Command, cfg *redshiftsql.RedshiftConfig) {
+	cmd.Flags().StringVar(&cfg.Host, "redshift.host", "", "redshift host")
+	cmd.Flags().IntVar(&cfg.Port, "redshift.port", 5439, "redshift port")
+	cmd.Flags().StringVar(&cfg.User, "redshift.user", "", "redshift user")
+	cmd.Flags().StringVar(&cfg.Pass, "redshift.pass", "", "redshift password")
+	cmd.Flags().StringVar(&cfg.Database, "redshift.database", "", "redshift database")
+	cmd.Flags().StringVar(&cfg.Schema, "redshift.schema", "", "redshift schema")
+	cmd.Flags().StringVar(&cfg.SSLMode, "redshift.sslmode", "disable", "redshift sslmode: disable, require, verify-ca, verify-full")
+	cmd.Flags().StringVar(&cfg.SSLRootCert, "redshift.ssl-ca", "", "CA verifying the certificate of redshift with --redshift.sslmode=verify-ca or verify-full")
+}
@@ -0,0 +1,684 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"database/sql"
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// removeOptions are the flags of `tidb2dw remove` shared by its commands of the data warehouses
+type removeOptions struct {
+	storagePath      string
+	cdcHost          string
+	cdcPort          int
+	cdcServer        string
+	cdcTLSOptions    CDCTLSOptions
+	tidbcloudOptions TiDBCloudOptions
+	deleteFiles      bool
+	logFile          string
+	logLevel         string
+}
+
+func (opts *removeOptions) addFlags(cmd *cobra.Command, storageUsage string) {
+	cmd.Flags().StringVarP(&opts.storagePath, "storage", "s", "", storageUsage)
+	cmd.Flags().StringVar(&opts.cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
+	cmd.Flags().IntVar(&opts.cdcPort, "cdc.port", 8300, "TiCDC server port")
+	addCDCServerFlag(cmd, &opts.cdcServer)
+	opts.cdcTLSOptions.addFlags(cmd)
+	opts.tidbcloudOptions.addFlags(cmd)
+	cmd.Flags().BoolVar(&opts.deleteFiles, "delete-files", false, "delete the snapshot and increment files of the replication after its changefeeds are removed")
+	cmd.Flags().StringVar(&opts.logFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&opts.logLevel, "log.level", "info", "log level")
+	cmd.MarkFlagRequired("storage")
+}
+
+// config registers the servers and the TLS of TiCDC and returns the configuration of the removal, the storage URI
+// is resolved by the caller with the credentials of the data warehouse
+func (opts *removeOptions) config(storageURI *url.URL) (*engine.RemoveConfig, error) {
+	cdcClient, err := newCDCClient(opts.cdcServer, &opts.cdcTLSOptions, opts.cdcHost, opts.cdcPort)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg := &engine.RemoveConfig{
+		StorageURI:  storageURI,
+		CDC:         cdcClient,
+		DeleteFiles: opts.deleteFiles,
+	}
+	// the changefeeds created through the TiDB Cloud API in --mode=cloud are deleted through it
+	if tidbcloudConfig := opts.tidbcloudOptions.config(); tidbcloudConfig != nil {
+		if err := tidbcloudConfig.Validate(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.TiDBCloud = tidbcloud.NewClient(*tidbcloudConfig, retry.DefaultPolicy)
+	}
+	return cfg, nil
+}
+
+// NewRemoveCmd returns the command tearing down a replication: the changefeeds writing into the workspace are
+// removed, the files and the tables replicated are kept unless asked
+func NewRemoveCmd() *cobra.Command {
+	var (
+		opts             removeOptions
+		s3Options        S3Options
+		awsAccessKey     string
+		awsSecretKey     string
+		awsProfile       string
+		gcsCredentials   string
+		azureAccountName string
+		azureAccountKey  string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err = normalizeStoragePath(storagePath, "s3", "gs", "gcs", "azure", "azblob")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{
+			AWSAccessKey:       awsAccessKey,
+			AWSSecretKey:       awsSecretKey,
+			AWSProfile:         awsProfile,
+			GCSCredentialsFile: gcsCredentials,
+			AzureAccountName:   azureAccountName,
+			AzureAccountKey:    azureAccountKey,
+		}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "remove",
+		Short: "Remove the changefeeds of a replication, and its files and tables in the data warehouse if asked",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path>, gs://<bucket>/<path> or azure://<container>/<path>")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+	cmd.Flags().StringVar(&gcsCredentials, "gcs.credentials-file", "", "gcs service account key file, GOOGLE_APPLICATION_CREDENTIALS by default")
+	cmd.Flags().StringVar(&azureAccountName, "azure.account-name", "", "azure storage account name, AZURE_STORAGE_ACCOUNT by default")
+	cmd.Flags().StringVar(&azureAccountKey, "azure.account-key", "", "azure storage account key, AZURE_STORAGE_KEY or Azure AD by default")
+
+	cmd.AddCommand(newRemoveSnowflakeCmd(), newRemoveRedshiftCmd(), newRemoveBigQueryCmd(), newRemoveDatabricksCmd(), newRemovePostgresCmd())
+	return cmd
+}
+
+// removeTables returns the tables of -t and --tables whose tables in the data warehouse are dropped, dropping
+// them requires --drop-downstream
+func removeTables(warehouse string, tables, tableList []string, dropDownstream bool) ([]string, error) {
+	if !dropDownstream {
+		return nil, errors.Errorf("the tables replicated into %s are dropped only with --drop-downstream, "+
+			"run `tidb2dw remove` to keep them", warehouse)
+	}
+	return mergeTables(tables, tableList, false)
+}
+
+// replicatedTableName returns the name of the table replicated into the data warehouse
+func replicatedTableName(tableFQN string, target routing.Target) string {
+	if target.Table != "" {
+		return target.Table
+	}
+	_, sourceTable := utils.SplitTableFQN(tableFQN)
+	return sourceTable
+}
+
+// dropRedshiftTables drops the tables replicated into Redshift of the tables, each in the schema of its target
+func dropRedshiftTables(db *sql.DB, gen redshiftsql.Generator, tables []string, targets map[string]routing.Target) error {
+	for _, tableFQN := range tables {
+		target := targets[tableFQN]
+		// the schema of the target is set as the search path, it must exist
+		if err := gen.UseSchema(db, target.Schema, false); err != nil {
+			return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+		}
+		tableName := replicatedTableName(tableFQN, target)
+		if err := gen.DropTable(tableName, db); err != nil {
+			return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+		}
+		log.Info("Dropped table in Redshift", zap.String("table", tableFQN),
+			zap.String("target", fmt.Sprintf("%s.%s", target.Schema, tableName)))
+	}
+	return nil
+}
+
+// dropDatabricksTables drops the tables replicated into Databricks of the tables, by a connection to the catalog and
+// the schema of each target opened by openDB
+func dropDatabricksTables(openDB func(target routing.Target) (*sql.DB, error), gen databrickssql.Generator, tables []string, targets map[string]routing.Target) error {
+	for _, tableFQN := range tables {
+		target := targets[tableFQN]
+		db, err := openDB(target)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tableName := replicatedTableName(tableFQN, target)
+		err = gen.DropTable(db, databrickssql.Namespace{Catalog: target.Database, Schema: target.Schema}, tableName)
+		db.Close()
+		if err != nil {
+			return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+		}
+		log.Info("Dropped table in Databricks", zap.String("table", tableFQN),
+			zap.String("target", fmt.Sprintf("%s.%s.%s", target.Database, target.Schema, tableName)))
+	}
+	return nil
+}
+
+// dropPostgresTables drops the tables replicated into PostgreSQL of the tables, in the search path of db
+func dropPostgresTables(db *sql.DB, tables []string, targets map[string]routing.Target) error {
+	for _, tableFQN := range tables {
+		tableName := replicatedTableName(tableFQN, targets[tableFQN])
+		if err := postgressql.DropTable(tableName, db); err != nil {
+			return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+		}
+		log.Info("Dropped table in PostgreSQL", zap.String("table", tableFQN), zap.String("target", tableName))
+	}
+	return nil
+}
+
+func newRemoveSnowflakeCmd() *cobra.Command {
+	var (
+		opts                   removeOptions
+		snowflakeConfigFromCli snowsql.SnowflakeConfig
+		tables                 []string
+		tableList              []string
+		routeOptions           RouteOptions
+		dropDownstream         bool
+		identifierCaseName     string
+		s3Options              S3Options
+		awsAccessKey           string
+		awsSecretKey           string
+		awsProfile             string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Upper)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dropped, err := removeTables("Snowflake", tables, tableList, dropDownstream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if storagePath, err = normalizeStoragePath(storagePath, "s3"); err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{AWSAccessKey: awsAccessKey, AWSSecretKey: awsSecretKey, AWSProfile: awsProfile}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = snowflakeConfigFromCli.CheckAuth(); err != nil {
+			return errors.Trace(err)
+		}
+		defaultTarget := routing.Target{Database: snowflakeConfigFromCli.Database, Schema: snowflakeConfigFromCli.Schema}
+		targets, err := routeOptions.resolve(dropped, 2, defaultTarget)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.DropTables = func(context.Context) error {
+			for _, tableFQN := range dropped {
+				target := targets[tableFQN]
+				tableConfig := snowflakeConfigFromCli
+				tableConfig.Database, tableConfig.Schema = target.Database, target.Schema
+				db, err := tableConfig.OpenDB()
+				if err != nil {
+					return errors.Trace(err)
+				}
+				tableName := replicatedTableName(tableFQN, target)
+				err = snowsql.NewGenerator(identifierCase).DropTable(db, tableName)
+				db.Close()
+				if err != nil {
+					return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+				}
+				log.Info("Dropped table in Snowflake", zap.String("table", tableFQN),
+					zap.String("target", fmt.Sprintf("%s.%s.%s", target.Database, target.Schema, tableName)))
+			}
+			return nil
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "snowflake",
+		Short: "Remove the changefeeds of a replication to Snowflake and drop its tables in Snowflake",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path>")
+	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name dropped in Snowflake, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&tableList, "tables", []string{}, "comma-separated tables full qualified name dropped in Snowflake, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().BoolVar(&dropDownstream, "drop-downstream", false, "confirm dropping the tables replicated into Snowflake, the data in them is lost")
+	addSnowflakeFlags(cmd, &snowflakeConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Upper), "the --identifier-case of the replication")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+
+	return cmd
+}
+
+func newRemoveBigQueryCmd() *cobra.Command {
+	var (
+		opts                  removeOptions
+		bigqueryConfigFromCli bigquerysql.BigQueryConfig
+		tables                []string
+		tableList             []string
+		routeOptions          RouteOptions
+		dropDownstream        bool
+		identifierCaseName    string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Preserve)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dropped, err := removeTables("BigQuery", tables, tableList, dropDownstream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err := normalizeStoragePath(opts.storagePath, "gs", "gcs")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storageURI, _, err := resolveStorageURI(storagePath, StorageCredentials{GCSCredentialsFile: bigqueryConfigFromCli.CredentialsFilePath})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		targets, err := routeOptions.resolve(dropped, 1, routing.Target{Schema: bigqueryConfigFromCli.DatasetID})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.DropTables = func(ctx context.Context) error {
+			bqClient, err := bigqueryConfigFromCli.NewClient()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer bqClient.Close()
+			gen := bigquerysql.NewGenerator(identifierCase)
+			for _, tableFQN := range dropped {
+				target := targets[tableFQN]
+				tableName := replicatedTableName(tableFQN, target)
+				if err = gen.DropTable(ctx, bqClient, target.Schema, tableName); err != nil {
+					return errors.Annotatef(err, "Failed to drop the table of %s", tableFQN)
+				}
+				// the external tables of the files are named after the table
+				for _, prefix := range []string{"snapshot_external_", "increment_external_"} {
+					if err = gen.DropExternalTable(ctx, bqClient, target.Schema, prefix+tableName); err != nil {
+						return errors.Annotatef(err, "Failed to drop the external table of %s", tableFQN)
+					}
+				}
+				log.Info("Dropped table in BigQuery", zap.String("table", tableFQN),
+					zap.String("target", fmt.Sprintf("%s.%s", target.Schema, tableName)))
+			}
+			return nil
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bigquery",
+		Short: "Remove the changefeeds of a replication to BigQuery and drop its tables in BigQuery",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: gs://<bucket>/<path> or gcs://<bucket>/<path>")
+	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name dropped in BigQuery, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&tableList, "tables", []string{}, "comma-separated tables full qualified name dropped in BigQuery, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().BoolVar(&dropDownstream, "drop-downstream", false, "confirm dropping the tables replicated into BigQuery, the data in them is lost")
+	addBigQueryFlags(cmd, &bigqueryConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Preserve), "the --identifier-case of the replication")
+
+	return cmd
+}
+
+func newRemoveRedshiftCmd() *cobra.Command {
+	var (
+		opts                  removeOptions
+		redshiftConfigFromCli redshiftsql.RedshiftConfig
+		tables                []string
+		tableList             []string
+		routeOptions          RouteOptions
+		dropDownstream        bool
+		identifierCaseName    string
+		s3Options             S3Options
+		awsAccessKey          string
+		awsSecretKey          string
+		awsProfile            string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Lower)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dropped, err := removeTables("Redshift", tables, tableList, dropDownstream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if storagePath, err = normalizeStoragePath(storagePath, "s3"); err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{AWSAccessKey: awsAccessKey, AWSSecretKey: awsSecretKey, AWSProfile: awsProfile}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		targets, err := routeOptions.resolve(dropped, 1, routing.Target{Schema: redshiftConfigFromCli.Schema})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.DropTables = func(context.Context) error {
+			db, err := redshiftConfigFromCli.OpenDB()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer db.Close()
+			return errors.Trace(dropRedshiftTables(db, redshiftsql.NewGenerator(identifierCase), dropped, targets))
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "redshift",
+		Short: "Remove the changefeeds of a replication to Redshift and drop its tables in Redshift",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path>")
+	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name dropped in Redshift, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&tableList, "tables", []string{}, "comma-separated tables full qualified name dropped in Redshift, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().BoolVar(&dropDownstream, "drop-downstream", false, "confirm dropping the tables replicated into Redshift, the data in them is lost")
+	addRedshiftFlags(cmd, &redshiftConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Lower), "the --identifier-case of the replication")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+
+	return cmd
+}
+
+func newRemoveDatabricksCmd() *cobra.Command {
+	var (
+		opts                    removeOptions
+		databricksConfigFromCli databrickssql.DataBricksConfig
+		tables                  []string
+		tableList               []string
+		routeOptions            RouteOptions
+		dropDownstream          bool
+		identifierCaseName      string
+		s3Options               S3Options
+		awsAccessKey            string
+		awsSecretKey            string
+		awsProfile              string
+		azureAccountName        string
+		azureAccountKey         string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		var err error
+		databricksConfigFromCli.IdentifierCase, err = identcase.Parse(identifierCaseName, identcase.Lower)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		dropped, err := removeTables("Databricks", tables, tableList, dropDownstream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if storagePath, err = normalizeStoragePath(storagePath, "s3", "azure"); err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{
+			AWSAccessKey:     awsAccessKey,
+			AWSSecretKey:     awsSecretKey,
+			AWSProfile:       awsProfile,
+			AzureAccountName: azureAccountName,
+			AzureAccountKey:  azureAccountKey,
+		}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defaultTarget := routing.Target{Database: databricksConfigFromCli.Catalog, Schema: databricksConfigFromCli.Schema}
+		targets, err := routeOptions.resolve(dropped, 2, defaultTarget)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.DropTables = func(context.Context) error {
+			openDB := func(target routing.Target) (*sql.DB, error) {
+				tableConfig := databricksConfigFromCli
+				tableConfig.Catalog, tableConfig.Schema = target.Database, target.Schema
+				return tableConfig.OpenDB()
+			}
+			return errors.Trace(dropDatabricksTables(openDB, databrickssql.NewGenerator(databricksConfigFromCli.IdentifierCase), dropped, targets))
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "databricks",
+		Short: "Remove the changefeeds of a replication to Databricks and drop its tables in Databricks",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path> or azure://<container>/<path>")
+	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name dropped in Databricks, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&tableList, "tables", []string{}, "comma-separated tables full qualified name dropped in Databricks, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().BoolVar(&dropDownstream, "drop-downstream", false, "confirm dropping the tables replicated into Databricks, the data in them is lost")
+	addDatabricksFlags(cmd, &databricksConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Lower), "the --identifier-case of the replication")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+	cmd.Flags().StringVar(&azureAccountName, "azure.account-name", "", "azure storage account name, AZURE_STORAGE_ACCOUNT by default")
+	cmd.Flags().StringVar(&azureAccountKey, "azure.account-key", "", "azure storage account key, AZURE_STORAGE_KEY or Azure AD by default")
+
+	return cmd
+}
+
+func newRemovePostgresCmd() *cobra.Command {
+	var (
+		opts                  removeOptions
+		postgresConfigFromCli postgressql.PostgresConfig
+		tables                []string
+		tableList             []string
+		routeOptions          RouteOptions
+		dropDownstream        bool
+		identifierCaseName    string
+		s3Options             S3Options
+		awsAccessKey          string
+		awsSecretKey          string
+		awsProfile            string
+		gcsCredentials        string
+		azureAccountName      string
+		azureAccountKey       string
+	)
+
+	run := func(ctx context.Context) error {
+		if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+			return errors.Trace(err)
+		}
+		diag.RedactLogs()
+		if err := checkPostgresIdentifierCase(identifierCaseName); err != nil {
+			return errors.Trace(err)
+		}
+		dropped, err := removeTables("PostgreSQL", tables, tableList, dropDownstream)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if storagePath, err = normalizeStoragePath(storagePath, "s3", "gs", "gcs", "azure", "azblob"); err != nil {
+			return errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{
+			AWSAccessKey:       awsAccessKey,
+			AWSSecretKey:       awsSecretKey,
+			AWSProfile:         awsProfile,
+			GCSCredentialsFile: gcsCredentials,
+			AzureAccountName:   azureAccountName,
+			AzureAccountKey:    azureAccountKey,
+		}
+		storageURI, _, err := resolveStorageURI(storagePath, explicitCredentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		targets, err := routeOptions.resolve(dropped, 1, routing.Target{Schema: postgresConfigFromCli.Schema})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg, err := opts.config(storageURI)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.DropTables = func(context.Context) error {
+			// the tables are dropped by the connection of the replication, whose search path is --postgres.schema
+			db, err := postgresConfigFromCli.OpenDB()
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer db.Close()
+			return errors.Trace(dropPostgresTables(db, dropped, targets))
+		}
+		return errors.Trace(engine.Remove(ctx, cfg))
+	}
+
+	cmd := &cobra.Command{
+		Use:   "postgres",
+		Short: "Remove the changefeeds of a replication to PostgreSQL and drop its tables in PostgreSQL",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path>, gs://<bucket>/<path> or azure://<container>/<path>")
+	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name dropped in PostgreSQL, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&tableList, "tables", []string{}, "comma-separated tables full qualified name dropped in PostgreSQL, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().BoolVar(&dropDownstream, "drop-downstream", false, "confirm dropping the tables replicated into PostgreSQL, the data in them is lost")
+	addPostgresFlags(cmd, &postgresConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	addPostgresIdentifierCaseFlag(cmd, &identifierCaseName)
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+	cmd.Flags().StringVar(&gcsCredentials, "gcs.credentials-file", "", "gcs service account key file, GOOGLE_APPLICATION_CREDENTIALS by default")
+	cmd.Flags().StringVar(&azureAccountName, "azure.account-name", "", "azure storage account name, AZURE_STORAGE_ACCOUNT by default")
+	cmd.Flags().StringVar(&azureAccountKey, "azure.account-key", "", "azure storage account key, AZURE_STORAGE_KEY or Azure AD by default")
+
+	cmd.MarkFlagRequired("postgres.host")
+	return cmd
+}
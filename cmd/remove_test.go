@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// recordDrops runs drop on a dry run recorder and returns the statements recorded
+func recordDrops(t *testing.T, drop func(recorder *dryrun.Recorder) error) string {
+	path := filepath.Join(t.TempDir(), "dryrun.sql")
+	recorder, err := dryrun.NewRecorder(path)
+	require.NoError(t, err)
+	require.NoError(t, drop(recorder))
+	require.NoError(t, recorder.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestDropRedshiftTables(t *testing.T) {
+	// db.Orders is routed, db.users is in the default schema, db.skipped is replicated but not removed
+	routes := RouteOptions{Routes: []string{"db.Orders=>Sales.orders_v2", "db.skipped=>other"}}
+	dropped := []string{"db.Orders", "db.users"}
+	targets, err := routes.resolve(dropped, 1, routing.Target{Schema: "public"})
+	require.NoError(t, err)
+
+	statements := recordDrops(t, func(recorder *dryrun.Recorder) error {
+		return dropRedshiftTables(recorder.OpenDB("redshift"), redshiftsql.NewGenerator(identcase.Lower), dropped, targets)
+	})
+	require.Equal(t, `
+-- redshift
+SELECT HAS_SCHEMA_PRIVILEGE($1, 'USAGE'); -- args: [sales]
+SET search_path TO "sales";
+DROP TABLE IF EXISTS "orders_v2";
+SELECT HAS_SCHEMA_PRIVILEGE($1, 'USAGE'); -- args: [public]
+SET search_path TO "public";
+DROP TABLE IF EXISTS "users";
+`, statements)
+}
+
+func TestDropDatabricksTables(t *testing.T) {
+	routes := RouteOptions{Routes: []string{"db.orders=>main.sales.Orders_V2", "db.skipped=>other"}}
+	dropped := []string{"db.orders", "db.users"}
+	targets, err := routes.resolve(dropped, 2, routing.Target{Database: "main", Schema: "default"})
+	require.NoError(t, err)
+
+	var opened []routing.Target
+	statements := recordDrops(t, func(recorder *dryrun.Recorder) error {
+		openDB := func(target routing.Target) (*sql.DB, error) {
+			opened = append(opened, target)
+			return recorder.OpenDB("databricks"), nil
+		}
+		return dropDatabricksTables(openDB, databrickssql.NewGenerator(identcase.Lower), dropped, targets)
+	})
+	require.Equal(t, `
+-- databricks
+DROP TABLE IF EXISTS `+"`main`.`sales`.`orders_v2`"+`;
+DROP TABLE IF EXISTS `+"`main`.`default`.`users`"+`;
+`, statements)
+	require.Equal(t, []routing.Target{
+		{Database: "main", Schema: "sales", Table: "Orders_V2"},
+		{Database: "main", Schema: "default"},
+	}, opened)
+}
+
+func TestDropPostgresTables(t *testing.T) {
+	routes := RouteOptions{Routes: []string{"db.orders=>sales.Orders_V2", "db.skipped=>other"}}
+	dropped := []string{"db.orders", "db.User Events"}
+	targets, err := routes.resolve(dropped, 1, routing.Target{Schema: "public"})
+	require.NoError(t, err)
+
+	statements := recordDrops(t, func(recorder *dryrun.Recorder) error {
+		return dropPostgresTables(recorder.OpenDB("postgres"), dropped, targets)
+	})
+	// the names are quoted as they are in TiDB or in the route
+	require.Equal(t, `
+-- postgres
+DROP TABLE IF EXISTS "Orders_V2";
+DROP TABLE IF EXISTS "User Events";
+`, statements)
+}
@@ -0,0 +1,587 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// schemaSyncOptions are the flags of `tidb2dw schema sync` shared by the data warehouses
+type schemaSyncOptions struct {
+	tidbConfig        tidbsql.TiDBConfig
+	tables            []string
+	tableList         []string
+	columnMappingPath string
+	columnFilterPath  string
+	pklessOptions     PKLessOptions
+	routeOptions      RouteOptions
+	outputPath        string
+	syncComments      bool
+	logFile           string
+	logLevel          string
+
+	// resolved by config
+	columnMapping columnmapping.Mapping
+	columnFilter  columnfilter.Config
+	recorder      *dryrun.Recorder
+	// readDBs are the connections the recorded databases read the tables of the data warehouse from
+	readDBs []*sql.DB
+}
+
+func (opts *schemaSyncOptions) addFlags(cmd *cobra.Command, schema, routeTarget, schemaRouteTarget string) {
+	addTiDBFlags(cmd, &opts.tidbConfig)
+	cmd.Flags().StringArrayVarP(&opts.tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&opts.tableList, "tables", []string{}, "comma-separated tables full qualified name, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().StringVar(&opts.columnMappingPath, "column-mapping", "", "the --column-mapping of the replication")
+	cmd.Flags().StringVar(&opts.columnFilterPath, "column-filter", "", "the --column-filter of the replication")
+	opts.pklessOptions.addFlags(cmd, false)
+	opts.routeOptions.addFlags(cmd, schema, routeTarget, schemaRouteTarget)
+	cmd.Flags().StringVar(&opts.outputPath, "output", "", "file the statements creating or altering the tables are written to instead of being executed, - for stdout")
+	cmd.Flags().BoolVar(&opts.syncComments, "sync-comments", true, "the --sync-comments of the replication")
+	cmd.Flags().StringVar(&opts.logFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&opts.logLevel, "log.level", "info", "log level")
+}
+
+// config parses the flags into the configuration of the sync, the connectors are added by the caller
+func (opts *schemaSyncOptions) config() (*engine.SchemaSyncConfig, error) {
+	if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	diag.RedactLogs()
+	tables, err := mergeTables(opts.tables, opts.tableList, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.columnMapping, err = loadColumnMapping(opts.columnMappingPath, tables, false); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.columnFilter, err = loadColumnFilter(opts.columnFilterPath, tables, false); err != nil {
+		return nil, errors.Trace(err)
+	}
+	pklessPolicy, err := opts.pklessOptions.resolve(tables, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.outputPath != "" {
+		output := opts.outputPath
+		if output == "-" {
+			output = ""
+		}
+		if opts.recorder, err = dryrun.NewRecorder(output); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return &engine.SchemaSyncConfig{
+		TiDBConfig: &opts.tidbConfig,
+		Tables:     tables,
+		Connectors: make(map[string]coreinterfaces.Connector, len(tables)),
+		PKLess:     pklessPolicy,
+	}, nil
+}
+
+// openDB opens the database of the table in the data warehouse, the statements changing the table are recorded
+// into --output instead of being executed if it is set
+func (opts *schemaSyncOptions) openDB(label string, open func() (*sql.DB, error)) (*sql.DB, error) {
+	db, err := open()
+	if err != nil || opts.recorder == nil {
+		return db, errors.Trace(err)
+	}
+	opts.readDBs = append(opts.readDBs, db)
+	return opts.recorder.OpenReadDB(label, db), nil
+}
+
+// close closes the connections and writes --output, after the connectors are closed
+func (opts *schemaSyncOptions) close() {
+	for _, db := range opts.readDBs {
+		db.Close()
+	}
+	closeRecorder(opts.recorder)
+}
+
+// printSchemaSyncSummary prints a line of each table in the order of the tables, and the columns altered
+func printSchemaSyncSummary(w io.Writer, results map[string]*engine.SchemaSyncResult, tables []string, planned bool) int {
+	counts := make(map[replicate.SchemaSyncAction]int)
+	failed := 0
+	for _, table := range tables {
+		if results[table].Error != "" {
+			failed++
+		} else {
+			counts[results[table].Action]++
+		}
+	}
+	verb := "Synced"
+	if planned {
+		verb = "Planned"
+	}
+	fmt.Fprintf(w, "%s %d tables: %d created, %d altered, %d unchanged, %d failed\n", verb, len(tables),
+		counts[replicate.SchemaSyncCreated], counts[replicate.SchemaSyncAltered], counts[replicate.SchemaSyncUnchanged], failed)
+	for _, table := range tables {
+		result := results[table]
+		if result.Error != "" {
+			fmt.Fprintf(w, "  %-40s ERROR      %s\n", table, result.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %-40s %s\n", table, result.Action)
+		if result.Drift != "" {
+			fmt.Fprintf(w, "    %s\n", result.Drift)
+		}
+	}
+	return failed
+}
+
+// runSchemaSync creates or alters the tables until SIGINT or SIGTERM, the process exits with the exit code of the
+// first table failed
+func runSchemaSync(run func() (*engine.SchemaSyncConfig, error), opts *schemaSyncOptions) {
+	var runErr error
+	runWithServer(false, "", nil, func(ctx context.Context) {
+		defer opts.close()
+		cfg, err := run()
+		if cfg != nil {
+			defer func() {
+				for _, connector := range cfg.Connectors {
+					connector.Close()
+				}
+			}()
+		}
+		if err != nil {
+			runErr = err
+			return
+		}
+		results, err := engine.SyncSchema(ctx, cfg)
+		if err != nil {
+			runErr = err
+			return
+		}
+		summary := os.Stdout
+		if opts.outputPath == "-" {
+			// the statements are the output, the summary goes to stderr
+			summary = os.Stderr
+		}
+		if failed := printSchemaSyncSummary(summary, results, cfg.Tables, opts.recorder != nil); failed > 0 {
+			runErr = errors.Errorf("%d of %d tables failed to be synced", failed, len(cfg.Tables))
+		}
+	})
+	if runErr != nil {
+		log.Error("Failed to sync table schemas", zap.String("category", string(diag.CategoryOf(runErr))), zap.Error(runErr))
+		_ = log.Sync()
+		os.Exit(diag.CategoryOf(runErr).ExitCode())
+	}
+}
+
+// NewSchemaCmd returns the commands managing the tables in the data warehouse without replicating them
+func NewSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Manage the tables in the data warehouse without replicating their rows",
+	}
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Create the tables in the data warehouse from TiDB, or alter them to the columns of TiDB if they exist",
+	}
+	syncCmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	syncCmd.AddCommand(newSchemaSyncSnowflakeCmd(), newSchemaSyncRedshiftCmd(), newSchemaSyncBigQueryCmd(), newSchemaSyncDatabricksCmd(), newSchemaSyncPostgresCmd())
+	cmd.AddCommand(syncCmd)
+	return cmd
+}
+
+func newSchemaSyncSnowflakeCmd() *cobra.Command {
+	var (
+		opts                   schemaSyncOptions
+		snowflakeConfigFromCli snowsql.SnowflakeConfig
+		deleteModeValue        string
+		identifierCaseName     string
+		clusterByValues        []string
+	)
+
+	run := func() (*engine.SchemaSyncConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = snowflakeConfigFromCli.CheckAuth(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		deleteMode, err := deletemode.Parse(deleteModeValue)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Upper)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		layouts, err := loadTableLayouts("", nil, "snowflake.cluster-by", clusterByValues, cfg.Tables, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defaultTarget := routing.Target{Database: snowflakeConfigFromCli.Database, Schema: snowflakeConfigFromCli.Schema}
+		targets, err := opts.routeOptions.resolve(cfg.Tables, 2, defaultTarget)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, tableFQN := range cfg.Tables {
+			target := targets[tableFQN]
+			db, err := opts.openDB(fmt.Sprintf("%s => %s", tableFQN, target), func() (*sql.DB, error) {
+				// the database and schema of the target are created if not exist
+				tableConfig := snowflakeConfigFromCli
+				tableConfig.Database, tableConfig.Schema = target.Database, target.Schema
+				return tableConfig.OpenDB()
+			})
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			connector := snowsql.NewSnowflakeSchemaConnector(db, identifierCase)
+			connector.SetColumnTypes(opts.columnMapping.Table(tableFQN))
+			connector.SetColumnFilter(opts.columnFilter.Table(tableFQN))
+			connector.SetDeleteMode(deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			cfg.Connectors[tableFQN] = connector
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "snowflake",
+		Short: "Create the tables in Snowflake from TiDB, or alter them to the columns of TiDB if they exist",
+		Run: func(_ *cobra.Command, _ []string) {
+			runSchemaSync(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "snowflake schema", "[<database>.]<schema> or <database>.<schema>.<table>", "{source_db}=>ANALYTICS.{source_db_upper}")
+	addSnowflakeFlags(cmd, &snowflakeConfigFromCli)
+	cmd.Flags().BoolVar(&snowflakeConfigFromCli.CreateSchema, "create-target-schema", true, "create the snowflake database and schema of the tables if they do not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&deleteModeValue, "delete-mode", "hard", "the --delete-mode of the replication, soft creates the tombstone columns")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Upper), "the --identifier-case of the replication")
+	cmd.Flags().StringArrayVar(&clusterByValues, "snowflake.cluster-by", []string{}, "the --snowflake.cluster-by of the replication")
+
+	return cmd
+}
+
+func newSchemaSyncPostgresCmd() *cobra.Command {
+	var (
+		opts                  schemaSyncOptions
+		postgresConfigFromCli postgressql.PostgresConfig
+		identifierCaseName    string
+	)
+
+	run := func() (*engine.SchemaSyncConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = checkPostgresIdentifierCase(identifierCaseName); err != nil {
+			return nil, errors.Trace(err)
+		}
+		targets, err := opts.routeOptions.resolve(cfg.Tables, 1, routing.Target{Schema: postgresConfigFromCli.Schema})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, tableFQN := range cfg.Tables {
+			target := targets[tableFQN]
+			db, err := opts.openDB(tableFQN, postgresConfigFromCli.OpenDB)
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			// the schema of the target is created if not exists
+			connector, err := postgressql.NewPostgresConnector(db, target.Schema, nil, "", postgresConfigFromCli.CreateSchema)
+			if err != nil {
+				db.Close()
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			connector.SetColumnTypes(opts.columnMapping.Table(tableFQN))
+			connector.SetColumnFilter(opts.columnFilter.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			connector.SetSyncComments(opts.syncComments)
+			cfg.Connectors[tableFQN] = connector
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "postgres",
+		Short: "Create the tables in PostgreSQL from TiDB, or alter them to the columns of TiDB if they exist",
+		Run: func(_ *cobra.Command, _ []string) {
+			runSchemaSync(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "postgres schema", "<schema>[.<table>]", "{source_db}=>raw_{source_db}")
+	addPostgresFlags(cmd, &postgresConfigFromCli)
+	cmd.Flags().BoolVar(&postgresConfigFromCli.CreateSchema, "create-target-schema", true, "create the postgres schema of the tables if it does not exist, otherwise a missing schema fails the replication")
+	addPostgresIdentifierCaseFlag(cmd, &identifierCaseName)
+
+	cmd.MarkFlagRequired("postgres.host")
+	return cmd
+}
+
+func newSchemaSyncRedshiftCmd() *cobra.Command {
+	var (
+		opts                  schemaSyncOptions
+		redshiftConfigFromCli redshiftsql.RedshiftConfig
+		deleteModeValue       string
+		identifierCaseName    string
+		tableProperties       []string
+	)
+
+	run := func() (*engine.SchemaSyncConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		deleteMode, err := deletemode.Parse(deleteModeValue)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Lower)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tablePropertiesOverrides, err := redshiftsql.ParseTablePropertiesOverrides(tableProperties)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		targets, err := opts.routeOptions.resolve(cfg.Tables, 1, routing.Target{Schema: redshiftConfigFromCli.Schema})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, tableFQN := range cfg.Tables {
+			target := targets[tableFQN]
+			db, err := opts.openDB(fmt.Sprintf("%s => %s", tableFQN, target), redshiftConfigFromCli.OpenDB)
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			// the schema of the target is created if not exists
+			connector, err := redshiftsql.NewRedshiftSchemaConnector(db, identifierCase, target.Schema, redshiftConfigFromCli.CreateSchema)
+			if err != nil {
+				db.Close()
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			targetTable := target.Table
+			if targetTable == "" {
+				_, targetTable = utils.SplitTableFQN(tableFQN)
+			}
+			connector.SetColumnTypes(opts.columnMapping.Table(tableFQN))
+			connector.SetColumnFilter(opts.columnFilter.Table(tableFQN))
+			connector.SetDeleteMode(deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableProperties(targetTable, tablePropertiesOverrides[tableFQN])
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			cfg.Connectors[tableFQN] = connector
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "redshift",
+		Short: "Create the tables in Redshift from TiDB, or alter them to the columns of TiDB if they exist",
+		Run: func(_ *cobra.Command, _ []string) {
+			runSchemaSync(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "redshift schema", "<schema>[.<table>]", "{source_db}=>raw_{source_db}")
+	addRedshiftFlags(cmd, &redshiftConfigFromCli)
+	cmd.Flags().BoolVar(&redshiftConfigFromCli.CreateSchema, "create-target-schema", true, "create the redshift schema of the tables if it does not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&deleteModeValue, "delete-mode", "hard", "the --delete-mode of the replication, soft creates the tombstone columns")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Lower), "the --identifier-case of the replication")
+	cmd.Flags().StringArrayVar(&tableProperties, "redshift.table-properties", []string{}, "the --redshift.table-properties of the replication")
+
+	cmd.MarkFlagRequired("redshift.host")
+	return cmd
+}
+
+func newSchemaSyncBigQueryCmd() *cobra.Command {
+	var (
+		opts                  schemaSyncOptions
+		bigqueryConfigFromCli bigquerysql.BigQueryConfig
+		deleteModeValue       string
+		identifierCaseName    string
+		partitionByValues     []string
+		clusterByValues       []string
+	)
+
+	run := func() (*engine.SchemaSyncConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		deleteMode, err := deletemode.Parse(deleteModeValue)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Preserve)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		layouts, err := loadTableLayouts("bq.partition-by", partitionByValues, "bq.cluster-by", clusterByValues, cfg.Tables, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		targets, err := opts.routeOptions.resolve(cfg.Tables, 1, routing.Target{Schema: bigqueryConfigFromCli.DatasetID})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, tableFQN := range cfg.Tables {
+			target := targets[tableFQN]
+			// the client reads the tables of the dataset, the statements are recorded into --output if it is set
+			bqClient, err := bigqueryConfigFromCli.NewClient()
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			_, sourceTable := utils.SplitTableFQN(tableFQN)
+			connector, err := bigquerysql.NewBigQuerySchemaConnector(bqClient, identifierCase, target.Schema, sourceTable, &bigqueryConfigFromCli)
+			if err != nil {
+				bqClient.Close()
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			if opts.recorder != nil {
+				label := fmt.Sprintf("%s => %s", tableFQN, target)
+				connector.EnableDryRun(func(statement string) { opts.recorder.Record(label, statement) })
+			}
+			connector.SetColumnTypes(opts.columnMapping.Table(tableFQN))
+			connector.SetColumnFilter(opts.columnFilter.Table(tableFQN))
+			connector.SetDeleteMode(deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			cfg.Connectors[tableFQN] = connector
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bigquery",
+		Short: "Create the tables in BigQuery from TiDB, or alter them to the columns of TiDB if they exist",
+		Run: func(_ *cobra.Command, _ []string) {
+			runSchemaSync(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "bigquery dataset", "<dataset>[.<table>]", "{source_db}=>raw_{source_db}")
+	addBigQueryFlags(cmd, &bigqueryConfigFromCli)
+	cmd.Flags().BoolVar(&bigqueryConfigFromCli.CreateDataset, "create-target-schema", false, "create the bigquery dataset of the tables if it does not exist, otherwise a missing dataset fails the replication")
+	cmd.Flags().StringVar(&deleteModeValue, "delete-mode", "hard", "the --delete-mode of the replication, soft creates the tombstone columns")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Preserve), "the --identifier-case of the replication")
+	cmd.Flags().StringArrayVar(&partitionByValues, "bq.partition-by", []string{}, "the --bq.partition-by of the replication")
+	cmd.Flags().StringArrayVar(&clusterByValues, "bq.cluster-by", []string{}, "the --bq.cluster-by of the replication")
+
+	cmd.MarkFlagRequired("bq.project-id")
+	cmd.MarkFlagRequired("bq.dataset-id")
+	return cmd
+}
+
+func newSchemaSyncDatabricksCmd() *cobra.Command {
+	var (
+		opts                    schemaSyncOptions
+		databricksConfigFromCli databrickssql.DataBricksConfig
+		deleteModeValue         string
+		identifierCaseName      string
+		partitionByValues       []string
+	)
+
+	run := func() (*engine.SchemaSyncConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		deleteMode, err := deletemode.Parse(deleteModeValue)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		databricksConfigFromCli.IdentifierCase, err = identcase.Parse(identifierCaseName, identcase.Lower)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		layouts, err := loadTableLayouts("databricks.partition-by", partitionByValues, "", nil, cfg.Tables, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defaultTarget := routing.Target{Database: databricksConfigFromCli.Catalog, Schema: databricksConfigFromCli.Schema}
+		targets, err := opts.routeOptions.resolve(cfg.Tables, 2, defaultTarget)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, tableFQN := range cfg.Tables {
+			target := targets[tableFQN]
+			db, err := opts.openDB(fmt.Sprintf("%s => %s", tableFQN, target), func() (*sql.DB, error) {
+				// the catalog of the target must exist, and its schema unless --create-target-schema is set
+				tableConfig := databricksConfigFromCli
+				tableConfig.Catalog, tableConfig.Schema = target.Database, target.Schema
+				return tableConfig.OpenDB()
+			})
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			connector := databrickssql.NewDatabricksSchemaConnector(db, &databricksConfigFromCli)
+			connector.SetColumnTypes(opts.columnMapping.Table(tableFQN))
+			connector.SetColumnFilter(opts.columnFilter.Table(tableFQN))
+			connector.SetDeleteMode(deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetNamespace(databrickssql.Namespace{Catalog: target.Database, Schema: target.Schema})
+			cfg.Connectors[tableFQN] = connector
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "databricks",
+		Short: "Create the tables in Databricks from TiDB, or alter them to the columns of TiDB if they exist",
+		Run: func(_ *cobra.Command, _ []string) {
+			runSchemaSync(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "databricks schema", "[<catalog>.]<schema> or <catalog>.<schema>.<table>", "{source_db}=>main.{source_db}")
+	addDatabricksFlags(cmd, &databricksConfigFromCli)
+	cmd.Flags().BoolVar(&databricksConfigFromCli.CreateSchema, "create-target-schema", false, "create the databricks schema of the tables if it does not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&deleteModeValue, "delete-mode", "hard", "the --delete-mode of the replication, soft creates the tombstone columns")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Lower), "the --identifier-case of the replication")
+	cmd.Flags().StringArrayVar(&partitionByValues, "databricks.partition-by", []string{}, "the --databricks.partition-by of the replication")
+
+	markDatabricksFlagsRequired(cmd)
+	return cmd
+}
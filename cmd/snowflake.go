@@ -1,164 +1,244 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"net/url"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
 	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
-	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
-	"github.com/pingcap/tiflow/pkg/logutil"
 	"github.com/spf13/cobra"
-	"github.com/thediveo/enumflag"
 	"go.uber.org/zap"
 )
 
 func NewSnowflakeCmd() *cobra.Command {
 	var (
-		tidbConfigFromCli      tidbsql.TiDBConfig
+		opts = ReplicationOptions{warehouse: Warehouse{
+			Name:              "snowflake",
+			Title:             "Snowflake",
+			StorageSchemes:    []string{"s3"},
+			StorageUsage:      "s3://<bucket>/<path>",
+			Compressions:      []utils.Compression{utils.CompressionGzip, utils.CompressionZstd},
+			SnapshotFileSize:  "250MiB",
+			IdentifierCase:    identcase.Upper,
+			JSONType:          "VARIANT",
+			RouteSchema:       "snowflake schema",
+			RouteTarget:       "[<database>.]<schema> or <database>.<schema>.<table>",
+			SchemaRouteTarget: "{source_db}=>ANALYTICS.{source_db_upper}",
+			Namespaces:        2,
+			PKLessAppends:     true,
+		}}
 		snowflakeConfigFromCli snowsql.SnowflakeConfig
-		tables                 []string
-		snapshotConcurrency    int
-		storagePath            string
-		cdcHost                string
-		cdcPort                int
-		cdcFlushInterval       time.Duration
-		cdcFileSize            int64
-		timezone               string
-		logFile                string
-		logLevel               string
-		awsAccessKey           string
-		awsSecretKey           string
-		credValue              *credentials.Value
-
-		mode          RunMode
-		apiListenHost string
-		apiListenPort int
+		incrementModeValue     string
+		maxBadRows             int64
+		maxMergeRows           int64
+		loadMode               string
+		clusterByValues        []string
 	)
 
-	run := func() error {
-		err := logutil.InitLogger(&logutil.Config{
-			Level: logLevel,
-			File:  logFile,
-		})
+	run := func(ctx context.Context, status *apiservice.APIInfo) error {
+		defaultTarget := routing.Target{Database: snowflakeConfigFromCli.Database, Schema: snowflakeConfigFromCli.Schema}
+		cfg, err := opts.pipelineConfig(status, defaultTarget)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		defer opts.close()
 
-		if awsAccessKey != "" && awsSecretKey != "" {
-			credValue = &credentials.Value{
-				AccessKeyID:     awsAccessKey,
-				SecretAccessKey: awsSecretKey,
-			}
-		} else {
-			credValue, err = resolveAWSCredential(storagePath)
-			if err != nil {
-				return errors.Trace(err)
-			}
+		if err = snowflakeConfigFromCli.CheckAuth(); err != nil {
+			return errors.Trace(err)
 		}
-
-		storageURI, err := getS3URIWithCredentials(storagePath, credValue)
+		incrementMode, err := incrementmode.Parse(incrementModeValue)
 		if err != nil {
 			return errors.Trace(err)
 		}
-
-		snapshotURI, incrementURI, err := genSnapshotAndIncrementURIs(storageURI)
+		if incrementMode == incrementmode.Append && opts.deleteMode == deletemode.Soft {
+			// the changelog keeps the deletes as rows, the table keeps the snapshot
+			return errors.New("--delete-mode=soft is not supported with --increment-mode=append")
+		}
+		increLoadMode, err := snowsql.ParseLoadMode(loadMode)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && cfg.FieldLimitConfig != nil && cfg.FieldLimitConfig.Policy != fieldlimit.PolicyError {
+			// the rewritten file would be ingested again by Snowpipe
+			return errors.Errorf("--field-limit-policy=%s is not supported with --snowflake.load-mode=snowpipe", cfg.FieldLimitConfig.Policy)
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && cfg.DryRun {
+			// the files ingested by the pipe are waited for
+			return errors.New("--dry-run is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && incrementMode == incrementmode.Append {
+			// the pipe ingests the files into the staging table merged by tidb2dw
+			return errors.New("--increment-mode=append is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && cfg.CDCProtocol == cdcreader.ProtocolCanalJSON {
+			// Snowpipe ingests the files written by TiCDC, not those converted by tidb2dw
+			return errors.New("--cdc-protocol=canal-json is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && cfg.StagingFormat == stagingformat.Parquet {
+			// Snowpipe ingests the CSV files written by TiCDC as they are
+			return errors.New("--staging-format=parquet is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if increLoadMode == snowsql.LoadModeSnowpipe && cfg.IncrementOptions.Shards > 1 {
+			// the pipe ingests the files of the increment directory only
+			return errors.New("--increment-shards is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if maxBadRows < 0 {
+			return errors.Errorf("--max-bad-rows must not be negative, got %d", maxBadRows)
+		}
+		if maxBadRows > 0 && increLoadMode == snowsql.LoadModeSnowpipe {
+			// the rows rejected by the pipe are not reported to tidb2dw
+			return errors.New("--max-bad-rows is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if maxBadRows > 0 && incrementMode == incrementmode.Append {
+			// the changes are appended from the stage without the staging table
+			return errors.New("--max-bad-rows is not supported with --increment-mode=append")
+		}
+		if maxMergeRows < 0 {
+			return errors.Errorf("--max-merge-rows must not be negative, got %d", maxMergeRows)
+		}
+		if maxMergeRows > 0 && increLoadMode == snowsql.LoadModeSnowpipe {
+			// the files ingested by the pipe are merged one by one
+			return errors.New("--max-merge-rows is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if cfg.PKLess.Mode == pkless.Append && increLoadMode == snowsql.LoadModeSnowpipe {
+			return errors.New("--pkless-mode=append is not supported with --snowflake.load-mode=snowpipe")
+		}
+		if cfg.PKLess.Mode == pkless.Append && maxBadRows > 0 {
+			return errors.New("--pkless-mode=append is not supported with --max-bad-rows")
+		}
+		layouts, err := loadTableLayouts("", nil, "snowflake.cluster-by", clusterByValues, cfg.Tables, opts.findsNewTables())
 		if err != nil {
 			return errors.Trace(err)
 		}
 
-		snapConnectorMap := make(map[string]coreinterfaces.Connector)
-		increConnectorMap := make(map[string]coreinterfaces.Connector)
-		for _, tableFQN := range tables {
-			_, sourceTable := utils.SplitTableFQN(tableFQN)
-			db, err := snowflakeConfigFromCli.OpenDB()
-			if err != nil {
-				return errors.Trace(err)
+		if opts.recorder != nil {
+			// the timestamp is the start of the progress monitoring of COPY
+			opts.recorder.StubQuery("SELECT CURRENT_TIMESTAMP", []string{"CURRENT_TIMESTAMP"}, []driver.Value{time.Now().Format(time.RFC3339)})
+		}
+		openTargetDB := func(tableFQN string, target routing.Target) (*sql.DB, error) {
+			if opts.recorder != nil {
+				return opts.recorder.OpenDB(fmt.Sprintf("%s => %s", tableFQN, target)), nil
 			}
-			snapConnector, err := snowsql.NewSnowflakeConnector(
-				db,
-				fmt.Sprintf("snapshot_external_%s", sourceTable),
-				snapshotURI,
-				credValue,
-			)
+			// the database and schema of the target are created if not exist
+			tableConfig := snowflakeConfigFromCli
+			tableConfig.Database, tableConfig.Schema = target.Database, target.Schema
+			db, err := tableConfig.OpenDB()
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			snapConnectorMap[tableFQN] = snapConnector
-
-			increConnector, err := snowsql.NewSnowflakeConnector(
+			return auditDB(opts.auditLogger, db, tableFQN), nil
+		}
+		newConnector := func(db *sql.DB, tableFQN string, target routing.Target, kind string, uri *url.URL, compression utils.Compression) (*snowsql.SnowflakeConnector, error) {
+			_, sourceTable := utils.SplitTableFQN(tableFQN)
+			connector, err := snowsql.NewSnowflakeConnector(
 				db,
-				fmt.Sprintf("increment_external_%s", sourceTable),
-				incrementURI,
-				credValue,
+				opts.identifierCase,
+				fmt.Sprintf("%s_external_%s", kind, sourceTable),
+				uri,
+				opts.credentials.AWS,
+				compression,
 			)
 			if err != nil {
-				return errors.Trace(err)
+				return nil, errors.Trace(err)
 			}
-			increConnectorMap[tableFQN] = increConnector
+			connector.SetColumnTypes(cfg.ColumnMapping.Table(tableFQN))
+			connector.SetColumnFilter(cfg.ColumnFilter.Table(tableFQN))
+			connector.SetDeleteMode(opts.deleteMode)
+			connector.SetSyncComments(opts.syncComments)
+			connector.SetTableLayout(layouts.Table(tableFQN))
+			connector.SetTargetTable(target.Table)
+			connector.SetDedupKey(cfg.PKLess.DedupKey(tableFQN))
+			return connector, nil
 		}
-
-		defer func() {
-			for _, connector := range snapConnectorMap {
-				connector.Close()
+		newIncreConnector := func(db *sql.DB, tableFQN string, target routing.Target) (*snowsql.SnowflakeConnector, error) {
+			connector, err := newConnector(db, tableFQN, target, "increment", opts.incrementURI, cfg.IncrementCompression)
+			if err != nil {
+				return nil, errors.Trace(err)
 			}
-			for _, connector := range increConnectorMap {
-				connector.Close()
+			connector.SetWhere(cfg.Where[tableFQN])
+			connector.SetIncrementMode(incrementMode)
+			connector.SetMaxBadRows(maxBadRows)
+			connector.SetMaxMergeRows(maxMergeRows)
+			if increLoadMode == snowsql.LoadModeSnowpipe {
+				// the pipe is in the database and the schema of the target
+				sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+				pipeConfig := snowflakeConfigFromCli
+				pipeConfig.Database, pipeConfig.Schema = target.Database, target.Schema
+				if err := connector.EnableSnowpipe(&pipeConfig, sourceDatabase, sourceTable); err != nil {
+					log.Warn("Snowpipe is not available, fall back to COPY", zap.String("table", tableFQN), zap.Error(err))
+				}
 			}
-		}()
-
-		return Replicate(&tidbConfigFromCli, tables, storageURI, snapshotConcurrency, cdcHost, cdcPort, cdcFlushInterval, cdcFileSize, snapConnectorMap, increConnectorMap, mode)
-	}
+			return connector, nil
+		}
+		newConnectors := func(tableFQN string, target routing.Target) (tableConnectors, error) {
+			db, err := openTargetDB(tableFQN, target)
+			if err != nil {
+				return tableConnectors{}, errors.Trace(err)
+			}
+			return tableConnectors{
+				snapshot: func(uri *url.URL) (coreinterfaces.Connector, error) {
+					return newConnector(db, tableFQN, target, "snapshot", uri, cfg.SnapshotCompression)
+				},
+				increment: func() (coreinterfaces.Connector, error) {
+					return newIncreConnector(db, tableFQN, target)
+				},
+			}, nil
+		}
 
-	cmd := &cobra.Command{
-		Use:   "snowflake",
-		Short: "Replicate snapshot and incremental data from TiDB to Snowflake",
-		Run: func(_ *cobra.Command, _ []string) {
-			runWithServer(mode == RunModeCloud, fmt.Sprintf("%s:%d", apiListenHost, apiListenPort), func() {
-				if err := run(); err != nil {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusFatalError(err)
-					log.Error("Fatal error running snowflake replication", zap.Error(err))
-				} else {
-					apiservice.GlobalInstance.APIInfo.SetServiceStatusIdle()
-				}
-			})
-		},
+		if cfg.IncrementOptions.SuspendWarehouseWhenIdle > 0 {
+			db, err := openTargetDB(cfg.Tables[0], opts.targets[cfg.Tables[0]])
+			if err != nil {
+				return diag.Warehouse(errors.Trace(err))
+			}
+			suspender := snowsql.NewWarehouseSuspender(db, opts.identifierCase, snowflakeConfigFromCli.Warehouse)
+			defer suspender.Close()
+			cfg.WarehouseSuspender = suspender
+		}
+		return opts.runPipeline(ctx, cfg, newConnectors)
 	}
 
-	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
-	cmd.Flags().Var(enumflag.New(&mode, "mode", RunModeIds, enumflag.EnumCaseInsensitive), "mode", "replication mode: full, snapshot-only, incremental-only, cloud")
-	cmd.Flags().StringVar(&apiListenHost, "api.host", "0.0.0.0", "API service listen host, only available in --mode=cloud")
-	cmd.Flags().IntVar(&apiListenPort, "api.port", 8185, "API service listen port, only available in --mode=cloud")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Host, "tidb.host", "h", "127.0.0.1", "TiDB host")
-	cmd.Flags().IntVarP(&tidbConfigFromCli.Port, "tidb.port", "P", 4000, "TiDB port")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.User, "tidb.user", "u", "root", "TiDB user")
-	cmd.Flags().StringVarP(&tidbConfigFromCli.Pass, "tidb.pass", "p", "", "TiDB password")
-	cmd.Flags().StringVar(&tidbConfigFromCli.SSLCA, "tidb.ssl-ca", "", "TiDB SSL CA")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.AccountId, "snowflake.account-id", "", "snowflake accound id: <organization>-<account>")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.Warehouse, "snowflake.warehouse", "COMPUTE_WH", "")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.User, "snowflake.user", "", "snowflake user")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.Pass, "snowflake.pass", "", "snowflake password")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.Database, "snowflake.database", "", "snowflake database")
-	cmd.Flags().StringVar(&snowflakeConfigFromCli.Schema, "snowflake.schema", "", "snowflake schema")
-	cmd.Flags().StringArrayVarP(&tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
-	cmd.Flags().IntVar(&snapshotConcurrency, "snapshot-concurrency", 8, "the number of concurrent snapshot workers")
-	cmd.Flags().StringVarP(&storagePath, "storage", "s", "", "storage path: s3://<bucket>/<path> or gcs://<bucket>/<path>")
-	cmd.Flags().StringVar(&cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
-	cmd.Flags().IntVar(&cdcPort, "cdc.port", 8300, "TiCDC server port")
-	cmd.Flags().DurationVar(&cdcFlushInterval, "cdc.flush-interval", 60*time.Second, "")
-	cmd.Flags().Int64Var(&cdcFileSize, "cdc.file-size", 64*1024*1024, "")
-	cmd.Flags().StringVar(&timezone, "tz", "System", "specify time zone of storage consumer")
-	cmd.Flags().StringVar(&logFile, "log.file", "", "log file path")
-	cmd.Flags().StringVar(&logLevel, "log.level", "info", "log level")
-	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
-	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
-
-	cmd.MarkFlagRequired("storage")
-
+	cmd := opts.newCommand(run)
+	addSnowflakeFlags(cmd, &snowflakeConfigFromCli)
+	cmd.Flags().BoolVar(&snowflakeConfigFromCli.CreateSchema, "create-target-schema", true, "create the snowflake database and schema of the tables if they do not exist, otherwise a missing schema fails the replication")
+	cmd.Flags().StringVar(&loadMode, "snowflake.load-mode", "copy", "how the increment files are loaded: copy, snowpipe (ingested by Snowpipe auto-ingest into a staging table and merged by tidb2dw once the Snowpipe REST API reports them loaded, which requires --snowflake.private-key-path or the OAuth token)")
+	cmd.Flags().StringArrayVar(&clusterByValues, "snowflake.cluster-by", []string{}, "cluster a table created in Snowflake by the columns, e.g. --snowflake.cluster-by 'db.t=tenant_id,created_at'")
+	cmd.Flags().StringVar(&incrementModeValue, "increment-mode", "merge", "how the increment files are applied: merge merges the changes into the table, append appends every change with its tidb2dw_flag and tidb2dw_commit_ts to the <table>_changelog table and keeps the snapshot in the table")
+	addMaxBadRowsFlag(cmd, &maxBadRows, "Snowflake", "a string too long for its column")
+	cmd.Flags().Int64Var(&maxMergeRows, "max-merge-rows", 0, "max rows of a batch of increment files merged by one MERGE, a larger batch is merged by a MERGE per partition of the primary key so no MERGE runs into the timeout of the warehouse, 0 merges every batch by one MERGE")
+	cmd.Flags().DurationVar(&opts.incrementOptions.SuspendWarehouseWhenIdle, "suspend-warehouse-when-idle", 0, "suspend --snowflake.warehouse once no increment file is loaded for the duration, e.g. 10m, and resume it before the next merge, 0 never suspends it")
+	opts.addDeleteModeFlag(cmd)
+	opts.addStagingFormatFlag(cmd)
+	opts.addS3Flags(cmd)
 	return cmd
 }
+
+// addSnowflakeFlags adds the flags of the connection to Snowflake
+func addSnowflakeFlags(cmd *cobra.Command, cfg *snowsql.SnowflakeConfig) {
+	cmd.Flags().StringVar(&cfg.AccountId, "snowflake.account-id", "", "snowflake accound id: <organization>-<account>")
+	cmd.Flags().StringVar(&cfg.Warehouse, "snowflake.warehouse", "COMPUTE_WH", "")
+	cmd.Flags().StringVar(&cfg.User, "snowflake.user", "", "snowflake user")
+	cmd.Flags().StringVar(&cfg.Pass, "snowflake.pass", "", "snowflake password, the default auth method")
+	cmd.Flags().StringVar(&cfg.PrivateKeyPath, "snowflake.private-key-path", "", "PEM file of the RSA private key of snowflake key-pair authentication")
+	cmd.Flags().StringVar(&cfg.PrivateKeyPassphrase, "snowflake.private-key-passphrase", "", "passphrase of the encrypted private key of --snowflake.private-key-path")
+	cmd.Flags().StringVar(&cfg.OAuthToken, "snowflake.oauth-token", "", "access token of snowflake external OAuth")
+	cmd.Flags().StringVar(&cfg.OAuthTokenFile, "snowflake.oauth-token-file", "", "file of the access token of snowflake external OAuth, read again for each new connection so that the token can be refreshed by another process")
+	cmd.Flags().StringVar(&cfg.Database, "snowflake.database", "", "snowflake database")
+	cmd.Flags().StringVar(&cfg.Schema, "snowflake.schema", "", "snowflake schema")
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// SQLAuditOptions log the statements executed in the data warehouse for compliance
+type SQLAuditOptions struct {
+	// Path is the local file the statements are appended to
+	Path string
+	// ToStorage writes the statements under sqlaudit.DirName of the storage path instead
+	ToStorage bool
+}
+
+func (opts *SQLAuditOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&opts.Path, "sql-audit-log", "", "local file the statements executed in the data warehouse are appended to as newline-delimited JSON, with their durations, affected rows and increment batches")
+	cmd.Flags().BoolVar(&opts.ToStorage, "sql-audit-log-to-storage", false, "write the statements executed in the data warehouse into an object of each day under "+sqlaudit.DirName+" of the storage path, like --sql-audit-log")
+}
+
+// newLogger returns the audit log of the statements, nil if it is disabled
+func (opts *SQLAuditOptions) newLogger(storageURI *url.URL, dryRun bool) (*sqlaudit.Logger, error) {
+	switch {
+	case opts.Path == "" && !opts.ToStorage:
+		return nil, nil
+	case opts.Path != "" && opts.ToStorage:
+		return nil, errors.New("--sql-audit-log and --sql-audit-log-to-storage are exclusive")
+	case dryRun:
+		return nil, errors.New("--sql-audit-log is not available with --dry-run, no statement is executed")
+	case opts.Path != "":
+		logger, err := sqlaudit.NewFileLogger(opts.Path)
+		return logger, errors.Trace(err)
+	}
+	// the log of the day is written after the replication is canceled
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), storageURI.String())
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open the storage of SQL audit log")
+	}
+	return sqlaudit.NewStorageLogger(context.Background(), storage), nil
+}
+
+// auditDB returns the database logging the statements of the table, db itself if the audit log is disabled
+func auditDB(logger *sqlaudit.Logger, db *sql.DB, table string) *sql.DB {
+	if logger == nil {
+		return db
+	}
+	return logger.WrapDB(db, table)
+}
+
+// closeAuditLogger is deferred after the connectors are closed, so that the statements of closing them are logged
+func closeAuditLogger(logger *sqlaudit.Logger) {
+	if logger == nil {
+		return
+	}
+	if err := logger.Close(); err != nil {
+		log.Error("Failed to write SQL audit log", zap.Error(err))
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// StorageCredentials are the credentials of the workspace storage. They are carried by the query string
+// of the storage URI, so that dumpling, TiCDC and tidb2dw access the storage with the same credentials.
+type StorageCredentials struct {
+	// AWSAccessKey and AWSSecretKey are --aws.access-key and --aws.secret-key, AWSProfile is --aws.profile
+	AWSAccessKey string
+	AWSSecretKey string
+	AWSProfile   string
+	// AWS is the credentials of s3:// resolved from the flags or the default chain of AWS, e.g. the AWS_* environment
+	// variables, the shared config files, or the role of the EC2 instance or the EKS service account. The temporary
+	// credentials of a role are refreshed by themselves, so they are passed down instead of their values.
+	AWS *credentials.Credentials
+	// GCSCredentialsFile is the service account key file of gs://, from the flag or GOOGLE_APPLICATION_CREDENTIALS.
+	// Empty means the application default credentials, e.g. of the GCE metadata server.
+	GCSCredentialsFile string
+	// AzureAccountName and AzureAccountKey are the shared key of azure://, from the flags, the query string of
+	// the storage path or AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY. The key is empty if Azure AD is used.
+	AzureAccountName string
+	AzureAccountKey  string
+}
+
+// resolveStorageURI resolves the credentials of the storage by its scheme and returns the storage URI carrying
+// them, the credentials given by flags take precedence over the environment.
+func resolveStorageURI(storagePath string, explicit StorageCredentials) (*url.URL, *StorageCredentials, error) {
+	uri, err := url.Parse(storagePath)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "Failed to parse workspace path")
+	}
+	resolved := &StorageCredentials{}
+	values := uri.Query()
+	switch uri.Scheme {
+	case "s3":
+		resolved.AWS, err = resolveAWSCredentials(explicit)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		credValue, err := resolved.AWS.Get()
+		if err != nil {
+			return nil, nil, errors.Annotate(err, "Failed to resolve AWS credentials, set --aws.access-key and --aws.secret-key, "+
+				"--aws.profile or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or run with an IAM role")
+		}
+		// keep other options such as endpoint and region
+		values.Del("access-key")
+		values.Del("secret-access-key")
+		values.Del("session-token")
+		if credValue.SessionToken == "" {
+			values.Set("access-key", credValue.AccessKeyID)
+			values.Set("secret-access-key", credValue.SecretAccessKey)
+		} else {
+			// the temporary credentials expire, the storage clients of tidb2dw and dumpling resolve them by the
+			// default chain as well, and TiCDC by its own
+			log.Info("AWS credentials are temporary, they are not put into the storage URI",
+				zap.String("provider", credValue.ProviderName))
+		}
+	case "gs", "gcs":
+		uri.Scheme = "gs"
+		resolved.GCSCredentialsFile = explicit.GCSCredentialsFile
+		if resolved.GCSCredentialsFile == "" {
+			resolved.GCSCredentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		if resolved.GCSCredentialsFile != "" {
+			// TiCDC reads the file by itself, so it must be at the same path on the TiCDC server
+			if _, err := os.Stat(resolved.GCSCredentialsFile); err != nil {
+				return nil, nil, errors.Annotate(err, "Failed to read GCS credentials file")
+			}
+			values.Set("credentials-file", resolved.GCSCredentialsFile)
+		}
+	case "azure", "azblob":
+		uri.Scheme = "azure"
+		if values.Has("sas-token") {
+			return nil, nil, errors.New("SAS tokens are not supported by the storage client of dumpling and TiCDC, " +
+				"use the account key or Azure AD instead")
+		}
+		resolved.AzureAccountName = firstNonEmpty(explicit.AzureAccountName, values.Get("account-name"), os.Getenv("AZURE_STORAGE_ACCOUNT"))
+		if resolved.AzureAccountName == "" {
+			return nil, nil, errors.New("Failed to resolve Azure storage account, set --azure.account-name or AZURE_STORAGE_ACCOUNT")
+		}
+		resolved.AzureAccountKey = firstNonEmpty(explicit.AzureAccountKey, values.Get("account-key"), os.Getenv("AZURE_STORAGE_KEY"))
+		if resolved.AzureAccountKey == "" && !hasAzureADEnv() {
+			return nil, nil, errors.New("Failed to resolve Azure credentials, set --azure.account-key or AZURE_STORAGE_KEY, " +
+				"or AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_CLIENT_SECRET of Azure AD")
+		}
+		values.Set("account-name", resolved.AzureAccountName)
+		// with Azure AD, TiCDC resolves the credentials from its own environment
+		if resolved.AzureAccountKey != "" {
+			values.Set("account-key", resolved.AzureAccountKey)
+		}
+	default:
+		return nil, nil, errors.Errorf("storage scheme %s:// is not supported, expected s3://, gs:// or azure://", uri.Scheme)
+	}
+	uri.RawQuery = values.Encode()
+	return uri, resolved, nil
+}
+
+// resolveAWSCredentials returns the credentials of the flags, or those of the default chain of AWS with the profile
+// of --aws.profile
+func resolveAWSCredentials(explicit StorageCredentials) (*credentials.Credentials, error) {
+	if explicit.AWSAccessKey != "" && explicit.AWSSecretKey != "" {
+		return credentials.NewStaticCredentials(explicit.AWSAccessKey, explicit.AWSSecretKey, ""), nil
+	}
+	if explicit.AWSProfile != "" {
+		// the storage clients of tidb2dw and dumpling resolve the credentials by the default chain by themselves
+		if err := os.Setenv("AWS_PROFILE", explicit.AWSProfile); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := os.Setenv("AWS_SDK_LOAD_CONFIG", "1"); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Profile: explicit.AWSProfile, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to load the AWS config")
+	}
+	return sess.Config.Credentials, nil
+}
+
+func hasAzureADEnv() bool {
+	return os.Getenv("AZURE_CLIENT_ID") != "" && os.Getenv("AZURE_TENANT_ID") != "" && os.Getenv("AZURE_CLIENT_SECRET") != ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
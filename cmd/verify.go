@@ -0,0 +1,365 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/logutil"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// verifyMismatchExitCode is the exit code of `tidb2dw verify` when a table does not match, the errors exit with the
+// exit code of their category
+const verifyMismatchExitCode = 2
+
+// verifyOptions are the flags of `tidb2dw verify` shared by the data warehouses
+type verifyOptions struct {
+	tidbConfig       tidbsql.TiDBConfig
+	tables           []string
+	tableList        []string
+	storagePath      string
+	cdcHost          string
+	cdcPort          int
+	cdcServer        string
+	cdcTLSOptions    CDCTLSOptions
+	tso              string
+	waitTimeout      time.Duration
+	verify           replicate.VerifyOptions
+	columnFilterPath string
+	whereValues      []string
+	timezone         string
+	reportPath       string
+	logFile          string
+	logLevel         string
+}
+
+func (opts *verifyOptions) addFlags(cmd *cobra.Command, storageUsage string) {
+	addTiDBFlags(cmd, &opts.tidbConfig)
+	cmd.Flags().StringArrayVarP(&opts.tables, "table", "t", []string{}, "tables full qualified name, e.g. -t <db1>.<table1> -t <db2>.<table2>")
+	cmd.Flags().StringSliceVar(&opts.tableList, "tables", []string{}, "comma-separated tables full qualified name, e.g. --tables <db1>.<table1>,<db2>.<table2>")
+	cmd.Flags().StringVarP(&opts.storagePath, "storage", "s", "", storageUsage)
+	cmd.Flags().StringVar(&opts.cdcHost, "cdc.host", "127.0.0.1", "TiCDC server host")
+	cmd.Flags().IntVar(&opts.cdcPort, "cdc.port", 8300, "TiCDC server port")
+	addCDCServerFlag(cmd, &opts.cdcServer)
+	opts.cdcTLSOptions.addFlags(cmd)
+	cmd.Flags().StringVar(&opts.tso, "tso", "now", "TSO TiDB is read at, now for the current TSO")
+	cmd.Flags().DurationVar(&opts.waitTimeout, "wait-timeout", 10*time.Minute, "how long to wait for the changefeed to pass the TSO and the increment files written before it to be merged, 0 compares the tables without waiting")
+	cmd.Flags().IntVar(&opts.verify.Concurrency, "concurrency", 4, "the number of buckets compared at the same time")
+	cmd.Flags().IntVar(&opts.verify.Buckets, "buckets", 16, "the max number of ranges of the integer primary key a table is split into")
+	cmd.Flags().IntVar(&opts.verify.ChecksumColumns, "checksum-columns", validation.DefaultChecksumColumns, "the max number of integer and decimal columns summed in each bucket, 0 only counts the rows")
+	cmd.Flags().IntVar(&opts.verify.SampleRows, "sample-rows", 20, "the max number of rows differing reported for a table, 0 disables reading the rows of the buckets differing")
+	cmd.Flags().IntVar(&opts.verify.ScanRows, "scan-rows", 10000, "the max number of rows read from each side to find the rows differing in a bucket")
+	cmd.Flags().StringVar(&opts.columnFilterPath, "column-filter", "", "TOML file of the columns replicated to the data warehouse, the --column-filter of the replication")
+	cmd.Flags().StringArrayVar(&opts.whereValues, "where", []string{}, "the --where of the replication, only the rows of TiDB matching it are compared")
+	addTimeZoneFlag(cmd, &opts.timezone)
+	cmd.Flags().StringVar(&opts.reportPath, "report", validation.VerifyReportFile, "file the JSON report is written into, - for stdout")
+	cmd.Flags().StringVar(&opts.logFile, "log.file", "", "log file path")
+	cmd.Flags().StringVar(&opts.logLevel, "log.level", "info", "log level")
+	cmd.MarkFlagRequired("storage")
+}
+
+// config parses the flags into the configuration of the verification, the storage path is resolved by the caller
+// with the credentials of the data warehouse
+func (opts *verifyOptions) config() (*engine.VerifyConfig, error) {
+	if err := logutil.InitLogger(&logutil.Config{Level: opts.logLevel, File: opts.logFile}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	diag.RedactLogs()
+	tables, err := mergeTables(opts.tables, opts.tableList, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tso, err := parseVerifyTSO(opts.tso)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if opts.verify.Buckets < 1 || opts.verify.Concurrency < 1 || opts.verify.ScanRows < 1 {
+		return nil, errors.New("--buckets, --concurrency and --scan-rows must be positive")
+	}
+	if opts.tidbConfig.TimeZone, err = utils.ParseTimeZone(opts.timezone); err != nil {
+		return nil, errors.Trace(err)
+	}
+	columnFilter, err := loadColumnFilter(opts.columnFilterPath, tables, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	where, err := loadWhere(opts.whereValues, tables, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cdcClient, err := newCDCClient(opts.cdcServer, &opts.cdcTLSOptions, opts.cdcHost, opts.cdcPort)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &engine.VerifyConfig{
+		TiDBConfig:   &opts.tidbConfig,
+		Tables:       tables,
+		CDC:          cdcClient,
+		TSO:          tso,
+		WaitTimeout:  opts.waitTimeout,
+		ColumnFilter: columnFilter,
+		Where:        where,
+		Options:      opts.verify,
+		Verifiers:    make(map[string]coreinterfaces.TableVerifier, len(tables)),
+		TargetTables: make(map[string]string, len(tables)),
+	}, nil
+}
+
+// parseVerifyTSO returns the TSO of --tso, 0 for now
+func parseVerifyTSO(s string) (uint64, error) {
+	if strings.EqualFold(s, "now") {
+		return 0, nil
+	}
+	tso, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || tso == 0 {
+		return 0, errors.Errorf("invalid --tso %s, expected a TSO or now", s)
+	}
+	return tso, nil
+}
+
+// printVerifySummary prints a line of each table in the order of the tables
+func printVerifySummary(w io.Writer, report *validation.VerifyReport, tables []string) {
+	passed := 0
+	for _, table := range tables {
+		if report.Tables[table].Passed {
+			passed++
+		}
+	}
+	fmt.Fprintf(w, "Verified %d tables at TSO %d: %d passed, %d failed\n", len(tables), report.TSO, passed, len(tables)-passed)
+	for _, table := range tables {
+		tableReport := report.Tables[table]
+		switch {
+		case tableReport.Error != "":
+			fmt.Fprintf(w, "  %-40s ERROR     %s\n", table, tableReport.Error)
+		case tableReport.Passed:
+			fmt.Fprintf(w, "  %-40s PASSED    %d rows, %d buckets\n", table, tableReport.Source.Rows, tableReport.Buckets)
+		default:
+			fmt.Fprintf(w, "  %-40s MISMATCH  rows %d in TiDB, %d in data warehouse, %d of %d buckets differ, %d rows differing sampled\n",
+				table, tableReport.Source.Rows, tableReport.Target.Rows, len(tableReport.MismatchedBuckets), tableReport.Buckets, len(tableReport.Mismatches))
+		}
+	}
+}
+
+// runVerify runs the verification until SIGINT or SIGTERM, the process exits with verifyMismatchExitCode if a table
+// does not match
+func runVerify(run func() (*engine.VerifyConfig, error), opts *verifyOptions) {
+	var runErr error
+	passed := true
+	runWithServer(false, "", nil, func(ctx context.Context) {
+		cfg, err := run()
+		if err != nil {
+			runErr = err
+			return
+		}
+		defer func() {
+			for _, verifier := range cfg.Verifiers {
+				verifier.Close()
+			}
+		}()
+		report, err := engine.Verify(ctx, cfg)
+		if err != nil {
+			runErr = err
+			return
+		}
+		runErr = opts.writeReport(report, cfg.Tables)
+		passed = report.Passed
+	})
+	if runErr != nil {
+		log.Error("Failed to verify tables", zap.String("category", string(diag.CategoryOf(runErr))), zap.Error(runErr))
+		_ = log.Sync()
+		os.Exit(diag.CategoryOf(runErr).ExitCode())
+	}
+	if !passed {
+		os.Exit(verifyMismatchExitCode)
+	}
+}
+
+// writeReport writes the report into --report and prints the summary
+func (opts *verifyOptions) writeReport(report *validation.VerifyReport, tables []string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	summary := os.Stdout
+	if opts.reportPath == "-" {
+		// the report is the output, the summary goes to stderr
+		summary = os.Stderr
+		fmt.Println(string(data))
+	} else if err = os.WriteFile(opts.reportPath, data, 0o644); err != nil {
+		return errors.Annotate(err, "Failed to write the report")
+	}
+	printVerifySummary(summary, report, tables)
+	return nil
+}
+
+// NewVerifyCmd returns the command comparing the tables in TiDB at a TSO with the tables replicated to the data warehouse
+func NewVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Compare the tables in TiDB at a TSO with the tables replicated to the data warehouse",
+	}
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	cmd.AddCommand(newVerifySnowflakeCmd(), newVerifyBigQueryCmd())
+	return cmd
+}
+
+func newVerifySnowflakeCmd() *cobra.Command {
+	var (
+		opts                   verifyOptions
+		snowflakeConfigFromCli snowsql.SnowflakeConfig
+		routeOptions           RouteOptions
+		identifierCaseName     string
+		s3Options              S3Options
+		awsAccessKey           string
+		awsSecretKey           string
+		awsProfile             string
+	)
+
+	run := func() (*engine.VerifyConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Upper)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		storagePath, err := applyS3Options(opts.storagePath, &s3Options)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if storagePath, err = normalizeStoragePath(storagePath, "s3"); err != nil {
+			return nil, errors.Trace(err)
+		}
+		explicitCredentials := StorageCredentials{AWSAccessKey: awsAccessKey, AWSSecretKey: awsSecretKey, AWSProfile: awsProfile}
+		if cfg.StorageURI, _, err = resolveStorageURI(storagePath, explicitCredentials); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = snowflakeConfigFromCli.CheckAuth(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		defaultTarget := routing.Target{Database: snowflakeConfigFromCli.Database, Schema: snowflakeConfigFromCli.Schema}
+		targets, err := routeOptions.resolve(cfg.Tables, 2, defaultTarget)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for tableFQN, target := range targets {
+			if target.Table != "" {
+				cfg.TargetTables[tableFQN] = target.Table
+			}
+			tableConfig := snowflakeConfigFromCli
+			tableConfig.Database, tableConfig.Schema = target.Database, target.Schema
+			db, err := tableConfig.OpenDB()
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			cfg.Verifiers[tableFQN] = snowsql.NewTableVerifier(db, identifierCase)
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "snowflake",
+		Short: "Compare the tables in TiDB at a TSO with the tables replicated to Snowflake",
+		Run: func(_ *cobra.Command, _ []string) {
+			runVerify(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: s3://<bucket>/<path>")
+	addSnowflakeFlags(cmd, &snowflakeConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Upper), "the --identifier-case of the replication")
+	s3Options.addFlags(cmd)
+	cmd.Flags().StringVar(&awsAccessKey, "aws.access-key", "", "aws access key")
+	cmd.Flags().StringVar(&awsSecretKey, "aws.secret-key", "", "aws secret key")
+	cmd.Flags().StringVar(&awsProfile, "aws.profile", "", "profile of the AWS shared config files, e.g. ~/.aws/config, whose credentials or IAM role are used without --aws.access-key and --aws.secret-key")
+
+	return cmd
+}
+
+func newVerifyBigQueryCmd() *cobra.Command {
+	var (
+		opts                  verifyOptions
+		bigqueryConfigFromCli bigquerysql.BigQueryConfig
+		routeOptions          RouteOptions
+		identifierCaseName    string
+	)
+
+	run := func() (*engine.VerifyConfig, error) {
+		cfg, err := opts.config()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		identifierCase, err := identcase.Parse(identifierCaseName, identcase.Preserve)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		storagePath, err := normalizeStoragePath(opts.storagePath, "gs", "gcs")
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if cfg.StorageURI, _, err = resolveStorageURI(storagePath, StorageCredentials{GCSCredentialsFile: bigqueryConfigFromCli.CredentialsFilePath}); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = checkBigQueryTimeZone(opts.tidbConfig.TimeZone); err != nil {
+			return nil, errors.Trace(err)
+		}
+		targets, err := routeOptions.resolve(cfg.Tables, 1, routing.Target{Schema: bigqueryConfigFromCli.DatasetID})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for tableFQN, target := range targets {
+			if target.Table != "" {
+				cfg.TargetTables[tableFQN] = target.Table
+			}
+			bqClient, err := bigqueryConfigFromCli.NewClient()
+			if err != nil {
+				return cfg, diag.Warehouse(errors.Trace(err))
+			}
+			cfg.Verifiers[tableFQN] = bigquerysql.NewTableVerifier(bqClient, identifierCase, target.Schema)
+		}
+		return cfg, nil
+	}
+
+	cmd := &cobra.Command{
+		Use:   "bigquery",
+		Short: "Compare the tables in TiDB at a TSO with the tables replicated to BigQuery",
+		Run: func(_ *cobra.Command, _ []string) {
+			runVerify(run, &opts)
+		},
+	}
+
+	cmd.PersistentFlags().BoolP("help", "", false, "help for this command")
+	addConfigFlag(cmd, new(string))
+	opts.addFlags(cmd, "storage path of the replication: gs://<bucket>/<path> or gcs://<bucket>/<path>")
+	addBigQueryFlags(cmd, &bigqueryConfigFromCli)
+	cmd.Flags().StringArrayVar(&routeOptions.Routes, "route", []string{}, "the --route of the replication")
+	cmd.Flags().StringArrayVar(&routeOptions.SchemaRoutes, "schema-route", []string{}, "the --schema-route of the replication")
+	cmd.Flags().StringVar(&identifierCaseName, "identifier-case", string(identcase.Preserve), "the --identifier-case of the replication")
+
+	return cmd
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/pingcap-inc/tidb2dw/cmd"
 	"github.com/pingcap-inc/tidb2dw/version"
@@ -39,9 +40,18 @@ func init() {
 		cmd.NewRedshiftCmd(),
 		cmd.NewBigQueryCmd(),
 		cmd.NewDatabricksCmd(),
+		cmd.NewPostgresCmd(),
+		cmd.NewCleanupCmd(),
+		cmd.NewRemoveCmd(),
+		cmd.NewVerifyCmd(),
+		cmd.NewSchemaCmd(),
+		cmd.NewConfigCmd(),
+		cmd.NewCtlCmd(),
 	)
 }
 
 func main() {
-	rootCmd.Execute()
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
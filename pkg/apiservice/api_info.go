@@ -1,10 +1,17 @@
 package apiservice
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 )
@@ -33,23 +40,239 @@ type TableStatus string
 const (
 	TableStatusNormal     TableStatus = "normal"
 	TableStatusFatalError TableStatus = "fatal_error"
+	// TableStatusPaused is set when the table is paused by a DDL which has to be handled manually, it is resumed by
+	// POST /api/v1/tables/{table}/resume-after-ddl
+	TableStatusPaused TableStatus = "paused"
+)
+
+// LoaderState is whether the increment files of a table are merged, they are not merged while the increment
+// replication is paused by POST /api/v1/pause
+type LoaderState string
+
+const (
+	LoaderStateRunning LoaderState = "running"
+	LoaderStatePaused  LoaderState = "paused"
 )
 
 type TableInfo struct {
-	Stage        TableStage  `json:"stage,omitempty"`
-	Status       TableStatus `json:"status,omitempty"`
+	Stage  TableStage  `json:"stage,omitempty"`
+	Status TableStatus `json:"status,omitempty"`
+	// Loader is empty unless the table is in stage loading_incremental
+	Loader       LoaderState `json:"loader,omitempty"`
 	ErrorMessage string      `json:"error_message,omitempty"`
+	// ErrorCategory is the category of the fatal error, e.g. WarehouseError
+	ErrorCategory diag.Category    `json:"error_category,omitempty"`
+	Backlog       *BacklogInfo     `json:"backlog,omitempty"`
+	Config        *TableConfig     `json:"config,omitempty"`
+	IncrementLoad *LoadStats       `json:"increment_load,omitempty"`
+	Batch         *BatchStats      `json:"batch,omitempty"`
+	SchemaDrift   *SchemaDriftInfo `json:"schema_drift,omitempty"`
+	// SnapshotDumpedRows is the rows of the snapshot dumped from TiDB
+	SnapshotDumpedRows int64 `json:"snapshot_dumped_rows,omitempty"`
+	// SnapshotLoadedRows is the rows of the snapshot loaded into the data warehouse as reported by it
+	SnapshotLoadedRows int64 `json:"snapshot_loaded_rows,omitempty"`
+	// SnapshotQueue is omitted unless the snapshot of the table is waiting or loading by --load-order
+	SnapshotQueue *SnapshotQueueInfo `json:"snapshot_queue,omitempty"`
+}
+
+// SnapshotQueueInfo is the place of the table in the order its snapshot is loaded in by --load-order
+type SnapshotQueueInfo struct {
+	// Position is 0 for the table loading, 1 for the next one
+	Position int `json:"position"`
+	// EstimatedStartAt is estimated by the sizes of the tables ahead and the throughput of the snapshots loaded
+	// before, omitted until one is loaded
+	EstimatedStartAt *time.Time `json:"estimated_start_at,omitempty"`
+}
+
+// SnapshotProgress is the progress of dumping the snapshot of all tables and loading it into the data
+// warehouse, both progress at the same time with --pipelined-snapshot
+type SnapshotProgress struct {
+	SnapshotDumpProgress
+	LoadedRows int64 `json:"loaded_rows"`
+}
+
+// SnapshotDumpProgress is the progress of dumping the snapshot of all tables from TiDB
+type SnapshotDumpProgress struct {
+	DumpedRows int64 `json:"dumped_rows"`
+	// EstimatedTotalRows is refreshed from the statistics of TiDB during the dump, it is never less than DumpedRows
+	EstimatedTotalRows int64 `json:"estimated_total_rows"`
+	// DumpedBytes and DumpedFiles are the bytes written into the storage after compression, and the data files
+	// written completely
+	DumpedBytes int64 `json:"dumped_bytes"`
+	DumpedFiles int64 `json:"dumped_files"`
+	// ChunksCompletedPercent is the chunks completed by the running dumpling instance, the tables with a filter
+	// are dumped by an instance each
+	ChunksCompletedPercent float64 `json:"chunks_completed_percent"`
+	// DumpRowsPerSecond is observed over the recent minutes
+	DumpRowsPerSecond float64 `json:"dump_rows_per_second"`
+	// DumpETASeconds is the estimated time to finish the dump, -1 if unknown
+	DumpETASeconds int64     `json:"dump_eta_seconds"`
+	DumpUpdatedAt  time.Time `json:"dump_updated_at"`
+	// TablesDumpedRows are DumpedRows by table, reported under the tables
+	TablesDumpedRows map[string]int64 `json:"-"`
+}
+
+// ChangefeedInfo is the state of the changefeed writing the increment files as last checked by tidb2dw
+type ChangefeedInfo struct {
+	ID            string `json:"id,omitempty"`
+	State         string `json:"state,omitempty"`
+	CheckpointTSO uint64 `json:"checkpoint_tso,omitempty"`
+	// LagSeconds is how far the checkpoint of the changefeed is behind the time it is checked
+	LagSeconds float64 `json:"lag_seconds"`
+	// Error is the last error of the changefeed reported by TiCDC, or why the changefeed can not be checked
+	Error string `json:"error,omitempty"`
+	// Resumed is how many times the changefeed is resumed with --changefeed-recovery=resume
+	Resumed   int       `json:"resumed,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// LoadStats are the counters of the increment files loaded into the data warehouse since the program starts
+type LoadStats struct {
+	FilesLoaded int64 `json:"files_loaded"`
+	// RowsMerged is the rows changed in the data warehouse as reported by it, 0 if it does not report them
+	RowsMerged int64 `json:"rows_merged"`
+	// LoadSeconds is the total time of loading the files, the time waiting for --increment-concurrency excluded
+	LoadSeconds float64 `json:"load_seconds"`
+	// BadRows is the rows of the files rejected by the data warehouse and skipped by --max-bad-rows
+	BadRows int64 `json:"bad_rows,omitempty"`
+	// BytesBilled is the bytes billed by the merges as reported by the data warehouse, 0 if it does not report them
+	BytesBilled int64 `json:"bytes_billed,omitempty"`
+}
+
+func (s *LoadStats) add(files int, rows int64, elapsed time.Duration) {
+	s.FilesLoaded += int64(files)
+	s.RowsMerged += rows
+	s.LoadSeconds += elapsed.Seconds()
+}
+
+// BatchStats are the batches of increment files merged into the data warehouse since the program starts
+type BatchStats struct {
+	// Merges is the number of rounds merging any file
+	Merges int64 `json:"merges"`
+	// Deferred is the number of rounds the new files wait for --min-batch-rows or --max-batch-interval
+	Deferred  int64 `json:"deferred"`
+	LastFiles int   `json:"last_files"`
+	LastBytes int64 `json:"last_bytes"`
+	// LastRows is counted with --min-batch-rows only
+	LastRows     int64     `json:"last_rows,omitempty"`
+	LastMergedAt time.Time `json:"last_merged_at"`
+}
+
+// SchemaDriftInfo is the checks of the table in the data warehouse against the columns replicated to it
+type SchemaDriftInfo struct {
+	Checks int64 `json:"checks"`
+	// Reconciles is the number of drifts altered back with --auto-reconcile
+	Reconciles    int64     `json:"reconciles"`
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	// LastDrift describes the last drift found, a line per column
+	LastDrift string `json:"last_drift,omitempty"`
+}
+
+// TableConfig is the effective settings of the increment replication of a table
+type TableConfig struct {
+	IncrementWorkers int `json:"increment_workers"`
+	// Dedicated is false if the table shares the pool of workers with other tables
+	Dedicated     bool   `json:"dedicated"`
+	MergeInterval string `json:"merge_interval"`
+	// MinBatchRows and MaxBatchInterval are omitted if the new files are merged by every round
+	MinBatchRows     int64  `json:"min_batch_rows,omitempty"`
+	MaxBatchInterval string `json:"max_batch_interval,omitempty"`
+	// DownloadRateLimit is omitted if the files of the table are only limited by --download-rate-limit
+	DownloadRateLimit string `json:"download_rate_limit,omitempty"`
+}
+
+// WarehouseIdleInfo is the state of the data warehouse suspended with --suspend-warehouse-when-idle
+type WarehouseIdleInfo struct {
+	Suspended    bool   `json:"suspended"`
+	SuspendAfter string `json:"suspend_after"`
+	// Suspends and Resumes are how many times the data warehouse is suspended and resumed by tidb2dw
+	Suspends     int       `json:"suspends"`
+	Resumes      int       `json:"resumes"`
+	LastActiveAt time.Time `json:"last_active_at"`
+}
+
+// TableConfigUpdate is the body of POST /tables/{table}/config, the fields omitted are unchanged
+type TableConfigUpdate struct {
+	IncrementWorkers  *int    `json:"increment_workers"`
+	MergeInterval     *string `json:"merge_interval"`
+	DownloadRateLimit *string `json:"download_rate_limit"`
+}
+
+// IncrementPauser pauses or resumes merging the increment files of all tables
+type IncrementPauser func(paused bool) error
+
+// ErrTableNotFound is returned by the TableConfigUpdater for a table not replicated
+var ErrTableNotFound = errors.New("table not found")
+
+// TableConfigUpdater applies the update of the settings of a table
+type TableConfigUpdater func(table string, update TableConfigUpdate) error
+
+// ErrTableNotPaused is returned by the DDLResumer for a table not paused by a DDL
+var ErrTableNotPaused = errors.New("table is not paused by a DDL")
+
+// DDLResumer resumes the table paused by a DDL once the DDL is handled in the data warehouse by hand
+type DDLResumer func(table string) error
+
+// BacklogInfo is the increment files waiting to be merged into the data warehouse
+type BacklogInfo struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// MergeBytesPerSecond and ArrivalBytesPerSecond are observed over the recent batches
+	MergeBytesPerSecond   float64 `json:"merge_bytes_per_second"`
+	ArrivalBytesPerSecond float64 `json:"arrival_bytes_per_second"`
+	// ETASeconds is the estimated time to catch up, -1 if the backlog is not shrinking
+	ETASeconds int64     `json:"eta_seconds"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// FatalError is a fatal error of the service or of a table
+type FatalError struct {
+	// Table is empty for the fatal error of the service
+	Table    string        `json:"table,omitempty"`
+	Category diag.Category `json:"category"`
+	Message  string        `json:"message"`
+	Time     time.Time     `json:"time"`
 }
 
 type InfoResponse struct {
-	Status       ServiceStatus         `json:"status,omitempty"`
-	ErrorMessage string                `json:"error_message,omitempty"`
-	TablesInfo   map[string]*TableInfo `json:"tables_info,omitempty"`
+	Status        ServiceStatus         `json:"status,omitempty"`
+	ErrorMessage  string                `json:"error_message,omitempty"`
+	ErrorCategory diag.Category         `json:"error_category,omitempty"`
+	TablesInfo    map[string]*TableInfo `json:"tables_info,omitempty"`
+	// LastFatalError is the last fatal error of the service or any table
+	LastFatalError *FatalError `json:"last_fatal_error,omitempty"`
+	// IncrementLoad is the sum of the counters of all tables
+	IncrementLoad *LoadStats `json:"increment_load,omitempty"`
+	// Snapshot is nil until the snapshot is being dumped or loaded
+	Snapshot *SnapshotProgress `json:"snapshot,omitempty"`
+	// Changefeed is nil if the changefeed is not managed by tidb2dw
+	Changefeed *ChangefeedInfo `json:"changefeed,omitempty"`
+	// Warehouse is nil unless --suspend-warehouse-when-idle is set
+	Warehouse *WarehouseIdleInfo `json:"warehouse,omitempty"`
+	// IncrementPaused is set while merging the increment files is paused by POST /api/v1/pause
+	IncrementPaused   bool       `json:"increment_paused,omitempty"`
+	IncrementPausedAt *time.Time `json:"increment_paused_at,omitempty"`
 }
 
 type APIInfo struct {
 	r  InfoResponse
 	mu sync.Mutex
+
+	// tableConfigUpdater is nil until the increment replication is started
+	tableConfigUpdater TableConfigUpdater
+	// incrementPauser is nil until the increment replication is started
+	incrementPauser IncrementPauser
+	// ddlResumer is nil until the increment replication is started
+	ddlResumer DDLResumer
+	// checkpointFetcher is nil if the changefeed is not managed by tidb2dw
+	checkpointFetcher CheckpointFetcher
+	// cdcFlushInterval is added to the merge interval of the tables to tell the lag expected, see expectedWait
+	cdcFlushInterval time.Duration
+	progress         map[string]*tableProgress
+	// events are the recent events served by GET /api/v1/events
+	events *EventBus
+	// rateLimiters are the limiters of the storages served by /api/v1/ratelimit, nil until the pipeline sets them
+	rateLimiters *ratelimit.Limiters
 }
 
 func NewAPIInfo() *APIInfo {
@@ -59,16 +282,159 @@ func NewAPIInfo() *APIInfo {
 			ErrorMessage: "",
 			TablesInfo:   make(map[string]*TableInfo),
 		},
+		progress: make(map[string]*tableProgress),
+		events:   NewEventBus(DefaultEventCapacity),
 	}
 }
 
 func (s *APIInfo) registerRouter(router *gin.Engine) {
-	router.GET("/info", func(c *gin.Context) {
+	handler := func(c *gin.Context) {
 		s.mu.Lock()
 		defer s.mu.Unlock()
 
 		c.JSON(http.StatusOK, s.r)
-	})
+	}
+	router.GET("/info", handler)
+	router.GET("/status", handler)
+	router.POST("/tables/:table/config", s.updateTableConfig)
+	router.GET("/api/v1/progress", s.getProgress)
+	router.GET("/api/v1/events", s.getEvents)
+	router.POST("/api/v1/pause", func(c *gin.Context) { s.pauseIncrement(c, true) })
+	router.POST("/api/v1/resume", func(c *gin.Context) { s.pauseIncrement(c, false) })
+	router.POST("/api/v1/tables/:table/resume-after-ddl", s.resumeAfterDDL)
+	router.GET("/api/v1/ratelimit", s.getRateLimit)
+	router.POST("/api/v1/ratelimit", s.updateRateLimit)
+	router.GET("/metrics", s.getMetrics)
+}
+
+func (s *APIInfo) updateTableConfig(c *gin.Context) {
+	var update TableConfigUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	updater := s.tableConfigUpdater
+	s.mu.Unlock()
+	if updater == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "increment replication is not started"})
+		return
+	}
+	table := c.Param("table")
+	if err := updater(table, update); err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == ErrTableNotFound {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the updater may accept a table whose status is not reported yet
+	info, ok := s.r.TablesInfo[table]
+	if !ok || info.Config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrTableNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, info.Config)
+}
+
+func (s *APIInfo) SetTableConfigUpdater(updater TableConfigUpdater) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tableConfigUpdater = updater
+}
+
+func (s *APIInfo) pauseIncrement(c *gin.Context, paused bool) {
+	s.mu.Lock()
+	pauser := s.incrementPauser
+	s.mu.Unlock()
+	if pauser == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "increment replication is not started"})
+		return
+	}
+	if err := pauser(paused); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"increment_paused": s.r.IncrementPaused, "increment_paused_at": s.r.IncrementPausedAt})
+}
+
+// SetRateLimiters sets the limiters of the storages of the pipeline served and adjusted by /api/v1/ratelimit
+func (s *APIInfo) SetRateLimiters(limiters *ratelimit.Limiters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimiters = limiters
+}
+
+// SetIncrementPauser sets how POST /api/v1/pause and /api/v1/resume pause and resume the increment replication
+func (s *APIInfo) SetIncrementPauser(pauser IncrementPauser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.incrementPauser = pauser
+}
+
+func (s *APIInfo) resumeAfterDDL(c *gin.Context) {
+	s.mu.Lock()
+	resumer := s.ddlResumer
+	s.mu.Unlock()
+	if resumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "increment replication is not started"})
+		return
+	}
+	table := c.Param("table")
+	if err := resumer(table); err != nil {
+		status := http.StatusInternalServerError
+		switch errors.Cause(err) {
+		case ErrTableNotFound:
+			status = http.StatusNotFound
+		case ErrTableNotPaused:
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"table": table, "resumed": true})
+}
+
+// SetDDLResumer sets how POST /api/v1/tables/{table}/resume-after-ddl resumes the table paused by a DDL
+func (s *APIInfo) SetDDLResumer(resumer DDLResumer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ddlResumer = resumer
+}
+
+// SetIncrementPaused records whether merging the increment files is paused, and since when
+func (s *APIInfo) SetIncrementPaused(paused bool, since time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.r.IncrementPaused = paused
+	s.r.IncrementPausedAt = nil
+	if paused {
+		s.r.IncrementPausedAt = &since
+	}
+	for _, info := range s.r.TablesInfo {
+		info.Loader = s.loaderState(info.Stage)
+	}
+}
+
+func (s *APIInfo) loaderState(stage TableStage) LoaderState {
+	switch {
+	case stage != TableStageLoadingIncremental:
+		return ""
+	case s.r.IncrementPaused:
+		return LoaderStatePaused
+	default:
+		return LoaderStateRunning
+	}
 }
 
 func (s *APIInfo) initTableInfoIfNotExist(table string) {
@@ -92,6 +458,40 @@ func (s *APIInfo) SetTableFatalError(table string, err error) {
 	}
 	s.r.TablesInfo[table].Status = TableStatusFatalError
 	s.r.TablesInfo[table].ErrorMessage = err.Error()
+	s.r.TablesInfo[table].ErrorCategory = diag.CategoryOf(err)
+	s.setLastFatalError(table, err)
+	s.events.Publish(ErrorEvent(table, err))
+}
+
+func (s *APIInfo) setLastFatalError(table string, err error) {
+	s.r.LastFatalError = &FatalError{
+		Table:    table,
+		Category: diag.CategoryOf(err),
+		Message:  err.Error(),
+		Time:     time.Now(),
+	}
+}
+
+func (s *APIInfo) SetTablePaused(table string, reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].Status = TableStatusPaused
+	s.r.TablesInfo[table].ErrorMessage = reason.Error()
+}
+
+// SetTableResumed clears the pause of the table once it is resumed after the DDL pausing it
+func (s *APIInfo) SetTableResumed(table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	if s.r.TablesInfo[table].Status != TableStatusPaused {
+		return
+	}
+	s.r.TablesInfo[table].Status = TableStatusNormal
+	s.r.TablesInfo[table].ErrorMessage = ""
 }
 
 func (s *APIInfo) SetTableStage(table string, stage TableStage) {
@@ -99,7 +499,165 @@ func (s *APIInfo) SetTableStage(table string, stage TableStage) {
 	defer s.mu.Unlock()
 
 	s.initTableInfoIfNotExist(table)
+	if s.r.TablesInfo[table].Stage != stage {
+		s.events.Publish(Event{Kind: EventKindStage, Table: table, Stage: string(stage), Message: fmt.Sprintf("Table stage is %s", stage)})
+	}
 	s.r.TablesInfo[table].Stage = stage
+	s.r.TablesInfo[table].Loader = s.loaderState(stage)
+}
+
+func (s *APIInfo) SetTableBacklog(table string, backlog BacklogInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].Backlog = &backlog
+}
+
+func (s *APIInfo) SetTableConfig(table string, config TableConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].Config = &config
+}
+
+// SetTableBatch sets the batches of the table merged
+func (s *APIInfo) SetTableBatch(table string, stats BatchStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].Batch = &stats
+}
+
+// SetTableSchemaDrift sets the checks of the schema drift of the table
+func (s *APIInfo) SetTableSchemaDrift(table string, info SchemaDriftInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].SchemaDrift = &info
+}
+
+// SetWarehouseIdle sets the state of the data warehouse suspended when idle
+func (s *APIInfo) SetWarehouseIdle(info WarehouseIdleInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.r.Warehouse = &info
+}
+
+// SetSnapshotDumpProgress sets the progress of dumping the snapshot of all tables
+func (s *APIInfo) SetSnapshotDumpProgress(progress SnapshotDumpProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r.Snapshot == nil {
+		s.r.Snapshot = &SnapshotProgress{}
+	}
+	s.r.Snapshot.SnapshotDumpProgress = progress
+	for table, dumpedRows := range progress.TablesDumpedRows {
+		s.initTableInfoIfNotExist(table)
+		s.r.TablesInfo[table].SnapshotDumpedRows = dumpedRows
+		metrics.SnapshotDumpedRows.With(metrics.TableLabels(table)).Set(float64(dumpedRows))
+	}
+}
+
+// SetTableSnapshotQueue sets the place of the table in the order of --load-order, nil once its snapshot is loaded
+func (s *APIInfo) SetTableSnapshotQueue(table string, info *SnapshotQueueInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	s.r.TablesInfo[table].SnapshotQueue = info
+}
+
+// SetTableSnapshotLoadedRows sets the rows of the snapshot of the table loaded into the data warehouse
+func (s *APIInfo) SetTableSnapshotLoadedRows(table string, loadedRows int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	if s.r.Snapshot == nil {
+		s.r.Snapshot = &SnapshotProgress{}
+	}
+	s.r.Snapshot.LoadedRows += loadedRows - s.r.TablesInfo[table].SnapshotLoadedRows
+	s.r.TablesInfo[table].SnapshotLoadedRows = loadedRows
+	metrics.SnapshotLoadedRows.With(metrics.TableLabels(table)).Set(float64(loadedRows))
+}
+
+// SetChangefeedInfo records the state of the changefeed
+func (s *APIInfo) SetChangefeedInfo(info ChangefeedInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.r.Changefeed = &info
+	if info.ID != "" && info.State != "" {
+		metrics.SetChangefeedState(info.ID, info.State)
+	}
+}
+
+// SnapshotProgress returns the progress of the snapshot of all tables
+func (s *APIInfo) SnapshotProgress() SnapshotProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r.Snapshot == nil {
+		return SnapshotProgress{}
+	}
+	return *s.r.Snapshot
+}
+
+// AddTableIncrementLoad counts the increment files of the table loaded into the data warehouse together
+func (s *APIInfo) AddTableIncrementLoad(table string, files int, rows int64, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	info := s.r.TablesInfo[table]
+	if info.IncrementLoad == nil {
+		info.IncrementLoad = &LoadStats{}
+	}
+	info.IncrementLoad.add(files, rows, elapsed)
+	if s.r.IncrementLoad == nil {
+		s.r.IncrementLoad = &LoadStats{}
+	}
+	s.r.IncrementLoad.add(files, rows, elapsed)
+}
+
+// AddTableBadRows counts the rows of the increment files of the table skipped by --max-bad-rows
+func (s *APIInfo) AddTableBadRows(table string, rows int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	info := s.r.TablesInfo[table]
+	if info.IncrementLoad == nil {
+		info.IncrementLoad = &LoadStats{}
+	}
+	info.IncrementLoad.BadRows += rows
+	if s.r.IncrementLoad == nil {
+		s.r.IncrementLoad = &LoadStats{}
+	}
+	s.r.IncrementLoad.BadRows += rows
+}
+
+// AddTableBytesBilled counts the bytes billed by the merges of the increment files of the table
+func (s *APIInfo) AddTableBytesBilled(table string, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initTableInfoIfNotExist(table)
+	info := s.r.TablesInfo[table]
+	if info.IncrementLoad == nil {
+		info.IncrementLoad = &LoadStats{}
+	}
+	info.IncrementLoad.BytesBilled += bytes
+	if s.r.IncrementLoad == nil {
+		s.r.IncrementLoad = &LoadStats{}
+	}
+	s.r.IncrementLoad.BytesBilled += bytes
 }
 
 func (s *APIInfo) SetServiceStatusIdle() {
@@ -113,6 +671,7 @@ func (s *APIInfo) SetServiceStatusIdle() {
 
 	s.r.Status = ServiceStatusIdle
 	s.r.ErrorMessage = ""
+	s.r.ErrorCategory = ""
 }
 
 func (s *APIInfo) SetServiceStatusFatalError(err error) {
@@ -126,4 +685,68 @@ func (s *APIInfo) SetServiceStatusFatalError(err error) {
 
 	s.r.Status = ServiceStatusFatalError
 	s.r.ErrorMessage = err.Error()
+	s.r.ErrorCategory = diag.CategoryOf(err)
+	s.setLastFatalError("", err)
+}
+
+// Status returns a copy of the response of GET /status
+func (s *APIInfo) Status() InfoResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.r
+	status.TablesInfo = make(map[string]*TableInfo, len(s.r.TablesInfo))
+	for table, info := range s.r.TablesInfo {
+		copied := *info
+		if info.Backlog != nil {
+			backlog := *info.Backlog
+			copied.Backlog = &backlog
+		}
+		if info.Config != nil {
+			config := *info.Config
+			copied.Config = &config
+		}
+		if info.IncrementLoad != nil {
+			load := *info.IncrementLoad
+			copied.IncrementLoad = &load
+		}
+		if info.Batch != nil {
+			batch := *info.Batch
+			copied.Batch = &batch
+		}
+		if info.SchemaDrift != nil {
+			drift := *info.SchemaDrift
+			copied.SchemaDrift = &drift
+		}
+		status.TablesInfo[table] = &copied
+	}
+	if s.r.LastFatalError != nil {
+		fatalError := *s.r.LastFatalError
+		status.LastFatalError = &fatalError
+	}
+	if s.r.IncrementLoad != nil {
+		load := *s.r.IncrementLoad
+		status.IncrementLoad = &load
+	}
+	if s.r.Snapshot != nil {
+		snapshot := *s.r.Snapshot
+		status.Snapshot = &snapshot
+	}
+	if s.r.Changefeed != nil {
+		changefeed := *s.r.Changefeed
+		status.Changefeed = &changefeed
+	}
+	if s.r.Warehouse != nil {
+		warehouse := *s.r.Warehouse
+		status.Warehouse = &warehouse
+	}
+	return status
+}
+
+// MarshalStatus returns the response of GET /status, e.g. for the diagnostics bundle
+func (s *APIInfo) MarshalStatus() (json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(s.r)
 }
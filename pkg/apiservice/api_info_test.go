@@ -0,0 +1,51 @@
+package apiservice
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateTableConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	status := NewAPIInfo()
+	status.SetTableConfig("db.t", TableConfig{MergeInterval: "1m0s"})
+	status.SetTableConfigUpdater(func(table string, update TableConfigUpdate) error {
+		return nil
+	})
+	router := gin.New()
+	status.registerRouter(router)
+
+	post := func(table string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/tables/"+table+"/config", strings.NewReader(`{}`))
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := post("db.t")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"merge_interval":"1m0s"`)
+	// accepted by the updater but not reported in the status
+	w = post("db.unknown")
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Contains(t, w.Body.String(), ErrTableNotFound.Error())
+}
+
+func TestSetSnapshotDumpProgress(t *testing.T) {
+	// the pipelines of a process report the dumps of their own tables
+	first, second := NewAPIInfo(), NewAPIInfo()
+	first.SetSnapshotDumpProgress(SnapshotDumpProgress{DumpedRows: 30, TablesDumpedRows: map[string]int64{"db.t1": 10, "db.t2": 20}})
+	second.SetSnapshotDumpProgress(SnapshotDumpProgress{DumpedRows: 5, TablesDumpedRows: map[string]int64{"db.t3": 5}})
+	require.Equal(t, int64(30), first.r.Snapshot.DumpedRows)
+	require.Equal(t, int64(20), first.r.TablesInfo["db.t2"].SnapshotDumpedRows)
+	require.NotContains(t, first.r.TablesInfo, "db.t3")
+	require.Equal(t, 10.0, testutil.ToFloat64(metrics.SnapshotDumpedRows.WithLabelValues("db", "t1")))
+	require.Equal(t, 20.0, testutil.ToFloat64(metrics.SnapshotDumpedRows.WithLabelValues("db", "t2")))
+	require.Equal(t, 5.0, testutil.ToFloat64(metrics.SnapshotDumpedRows.WithLabelValues("db", "t3")))
+}
@@ -0,0 +1,179 @@
+package apiservice
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+)
+
+// EventKind is the kind of a significant event of the replication
+type EventKind string
+
+const (
+	// EventKindStage is a stage transition of the replication or of a table
+	EventKindStage       EventKind = "stage"
+	EventKindBatchMerged EventKind = "batch_merged"
+	EventKindDDLApplied  EventKind = "ddl_applied"
+	// EventKindRetry is a failed attempt of an operation of the data warehouse which is retried
+	EventKindRetry EventKind = "retry"
+	EventKindError EventKind = "error"
+)
+
+// DefaultEventCapacity is the number of the recent events kept in memory
+const DefaultEventCapacity = 500
+
+// Event is a significant event of the replication, served by GET /api/v1/events
+type Event struct {
+	Time time.Time `json:"time"`
+	Kind EventKind `json:"kind"`
+	// Table is empty for the events of the whole replication
+	Table   string `json:"table,omitempty"`
+	Message string `json:"message"`
+	Stage   string `json:"stage,omitempty"`
+	// Files and Rows are the increment files merged by a batch and the rows changed as reported by the data
+	// warehouse
+	Files int   `json:"files,omitempty"`
+	Rows  int64 `json:"rows,omitempty"`
+	// Query and TableVersion are of the DDL applied
+	Query        string `json:"query,omitempty"`
+	TableVersion uint64 `json:"table_version,omitempty"`
+	// Operation and Attempt are of the operation retried, the first attempt is 1
+	Operation string        `json:"operation,omitempty"`
+	Attempt   int           `json:"attempt,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Category  diag.Category `json:"category,omitempty"`
+	// Stack is the stack trace where the error is raised, empty if it is unknown
+	Stack string `json:"stack,omitempty"`
+}
+
+// ErrorEvent returns the event of the error of the table, empty for the whole replication, the secrets in the
+// error are redacted
+func ErrorEvent(table string, err error) Event {
+	message := "Replication failed"
+	if table != "" {
+		message = "Replication of the table failed"
+	}
+	return Event{
+		Kind:     EventKindError,
+		Table:    table,
+		Message:  message,
+		Error:    diag.RedactSecrets(err.Error()),
+		Category: diag.CategoryOf(err),
+		Stack:    errorStack(err),
+	}
+}
+
+// errorStack returns the stack trace of the first error of the chain which has one
+func errorStack(err error) string {
+	stacked := errors.GetStackTracer(err)
+	if stacked == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%+v", stacked.StackTrace()))
+}
+
+// EventBus keeps the recent events in a ring buffer of a fixed capacity, and the events not taken by TakePending
+// yet, e.g. to write them into the storage. The pending events are bounded too, the oldest are dropped.
+type EventBus struct {
+	mu       sync.Mutex
+	capacity int
+	// ring holds the events in order from next once it is full
+	ring    []Event
+	next    int
+	pending []Event
+}
+
+// NewEventBus returns the bus keeping the capacity of recent events
+func NewEventBus(capacity int) *EventBus {
+	return &EventBus{capacity: max(capacity, 1)}
+}
+
+// Publish records the event, its time is now if it is not set
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.ring) < b.capacity {
+		b.ring = append(b.ring, event)
+	} else {
+		b.ring[b.next] = event
+		b.next = (b.next + 1) % b.capacity
+	}
+	if len(b.pending) >= b.capacity {
+		b.pending = b.pending[1:]
+	}
+	b.pending = append(b.pending, event)
+}
+
+// Events returns the recent events in order which happen after since, of the table if it is not empty
+func (b *EventBus) Events(since time.Time, table string) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	events := make([]Event, 0, len(b.ring))
+	for i := range b.ring {
+		event := b.ring[(b.next+i)%len(b.ring)]
+		if event.Time.After(since) && (table == "" || event.Table == table) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// TakePending returns the events published since the last call
+func (b *EventBus) TakePending() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pending := b.pending
+	b.pending = nil
+	return pending
+}
+
+// Events returns the bus the events of the replication are published to
+func (s *APIInfo) Events() *EventBus {
+	return s.events
+}
+
+// PublishEvent publishes the event to the bus of the replication, the event is dropped if s is nil, e.g. for a
+// session replicating without the API service
+func (s *APIInfo) PublishEvent(event Event) {
+	if s == nil {
+		return
+	}
+	s.events.Publish(event)
+}
+
+// getEvents serves the recent events, since is a RFC 3339 time or a unix timestamp in seconds
+func (s *APIInfo) getEvents(c *gin.Context) {
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = parseEventTime(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"events": s.events.Events(since, c.Query("table"))})
+}
+
+func parseEventTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid since %s, expected a RFC 3339 time or a unix timestamp", value)
+	}
+	return since, nil
+}
@@ -1,3 +0,0 @@
-package apiservice
-
-var GlobalInstance *APIService = New()
@@ -0,0 +1,29 @@
+package apiservice
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+)
+
+// getMetrics serves the Prometheus metrics, the lag of the tables is refreshed from the checkpoint of the changefeed
+// last checked, so that the scrape does not wait for the TiCDC server
+func (s *APIInfo) getMetrics(c *gin.Context) {
+	s.refreshLagMetrics()
+	metrics.Handler().ServeHTTP(c.Writer, c.Request)
+}
+
+func (s *APIInfo) refreshLagMetrics() {
+	tables, ok := s.TablesProgress()
+	if !ok {
+		return
+	}
+	metrics.ReplicationLag.Reset()
+	metrics.ReplicationLagOverdue.Reset()
+	for table, progress := range tables {
+		if progress.LagSeconds == nil {
+			continue
+		}
+		metrics.ReplicationLag.With(metrics.TableLabels(table)).Set(*progress.LagSeconds)
+		metrics.ReplicationLagOverdue.With(metrics.TableLabels(table)).Set(*progress.OverdueSeconds)
+	}
+}
@@ -0,0 +1,190 @@
+package apiservice
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// CheckpointFetcher returns the checkpoint TSO of the changefeed
+type CheckpointFetcher func() (uint64, error)
+
+// TableProgress is the progress of the increment replication of a table
+type TableProgress struct {
+	// LastLoadedCommitTs is the commit ts of the last row of the last merged file, 0 if no file is merged yet
+	LastLoadedCommitTs uint64     `json:"last_loaded_commit_ts"`
+	LastLoadedAt       *time.Time `json:"last_loaded_at,omitempty"`
+	CheckpointTSO      uint64     `json:"checkpoint_tso,omitempty"`
+	// LagSeconds is how far the data warehouse is behind the checkpoint of the changefeed, it is 0 if no file
+	// is waiting to be merged, and omitted if unknown
+	LagSeconds *float64 `json:"lag_seconds,omitempty"`
+	// LagState tells whether the lag is of the files waiting for the next merge by design or of the merges falling
+	// behind, omitted with LagSeconds
+	LagState LagState `json:"lag_state,omitempty"`
+	// OverdueSeconds is the lag beyond the longest the files wait by design, see expectedWait, omitted with LagSeconds
+	OverdueSeconds *float64 `json:"overdue_seconds,omitempty"`
+}
+
+// LagState is whether the lag of a table is expected by its merge interval
+type LagState string
+
+const (
+	// LagStateWaiting is the lag of the files waiting for the next merge by the merge interval or the batch policy
+	LagStateWaiting LagState = "waiting"
+	// LagStateBehind is the lag beyond it, the data warehouse merges slower than the files arrive or is stuck
+	LagStateBehind LagState = "behind"
+)
+
+type ProgressResponse struct {
+	CheckpointTSO uint64 `json:"checkpoint_tso,omitempty"`
+	// CheckpointError is why the checkpoint is unknown, e.g. the changefeed is managed outside of tidb2dw
+	CheckpointError string                    `json:"checkpoint_error,omitempty"`
+	Tables          map[string]*TableProgress `json:"tables"`
+}
+
+type tableProgress struct {
+	commitTs uint64
+	loadedAt time.Time
+}
+
+func (s *APIInfo) SetCheckpointFetcher(fetcher CheckpointFetcher) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpointFetcher = fetcher
+}
+
+// SetCDCFlushInterval sets the flush interval of the changefeed, the files wait for it before they are found
+func (s *APIInfo) SetCDCFlushInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cdcFlushInterval = interval
+}
+
+// SetTableLoadedCommitTs records the commit ts of the last row of the file merged into the data warehouse
+func (s *APIInfo) SetTableLoadedCommitTs(table string, commitTs uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress[table] = &tableProgress{commitTs: commitTs, loadedAt: time.Now()}
+}
+
+// LoadedCommitTs returns the commit ts of the last row merged into the data warehouse and when it is merged,
+// of the tables having merged an increment file
+func (s *APIInfo) LoadedCommitTs() map[string]TableProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loaded := make(map[string]TableProgress, len(s.progress))
+	for table, progress := range s.progress {
+		loadedAt := progress.loadedAt
+		loaded[table] = TableProgress{LastLoadedCommitTs: progress.commitTs, LastLoadedAt: &loadedAt}
+	}
+	return loaded
+}
+
+func (s *APIInfo) getProgress(c *gin.Context) {
+	s.mu.Lock()
+	fetcher := s.checkpointFetcher
+	s.mu.Unlock()
+
+	// the checkpoint is fetched without the lock, the TiCDC server may be slow
+	var checkpoint uint64
+	var checkpointErr error
+	if fetcher == nil {
+		checkpointErr = errChangefeedUnknown
+	} else {
+		checkpoint, checkpointErr = fetcher()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.JSON(http.StatusOK, s.genProgress(checkpoint, checkpointErr))
+}
+
+var errChangefeedUnknown = errors.New("changefeed is not managed by tidb2dw")
+
+func (s *APIInfo) genProgress(checkpoint uint64, checkpointErr error) ProgressResponse {
+	r := ProgressResponse{CheckpointTSO: checkpoint, Tables: make(map[string]*TableProgress)}
+	if checkpointErr != nil {
+		r.CheckpointError = checkpointErr.Error()
+	}
+	for table, info := range s.r.TablesInfo {
+		if info.Stage != TableStageLoadingIncremental {
+			continue
+		}
+		p := &TableProgress{CheckpointTSO: checkpoint}
+		if loaded, ok := s.progress[table]; ok {
+			p.LastLoadedCommitTs = loaded.commitTs
+			p.LastLoadedAt = &loaded.loadedAt
+		}
+		switch {
+		case checkpoint == 0:
+		case info.Backlog != nil && info.Backlog.Files == 0:
+			// everything written by the changefeed is merged
+			lag := 0.0
+			p.LagSeconds = &lag
+		case p.LastLoadedCommitTs != 0:
+			lag := max(utils.TSOPhysicalTime(checkpoint).Sub(utils.TSOPhysicalTime(p.LastLoadedCommitTs)).Seconds(), 0)
+			p.LagSeconds = &lag
+		}
+		if p.LagSeconds != nil {
+			overdue := max(*p.LagSeconds-s.expectedWait(info.Config).Seconds(), 0)
+			p.OverdueSeconds = &overdue
+			p.LagState = LagStateWaiting
+			if overdue > 0 {
+				p.LagState = LagStateBehind
+			}
+		}
+		r.Tables[table] = p
+	}
+	return r
+}
+
+// expectedWait is the longest the changes of a table wait by design before they are merged: the flush interval of
+// the changefeed, and the merge interval of the table or the max batch interval if longer
+func (s *APIInfo) expectedWait(config *TableConfig) time.Duration {
+	var wait time.Duration
+	if config != nil {
+		// the intervals are reported by the scheduler formatted by time.Duration
+		for _, interval := range []string{config.MergeInterval, config.MaxBatchInterval} {
+			if d, err := time.ParseDuration(interval); err == nil {
+				wait = max(wait, d)
+			}
+		}
+	}
+	return s.cdcFlushInterval + wait
+}
+
+// TableLags returns the lag in seconds of the tables loading the increment whose lag is known, by the checkpoint of
+// the changefeed last checked. ok is false if no changefeed is checked yet.
+func (s *APIInfo) TableLags() (lags map[string]float64, ok bool) {
+	tables, ok := s.TablesProgress()
+	if !ok {
+		return nil, false
+	}
+	lags = make(map[string]float64)
+	for table, progress := range tables {
+		if progress.LagSeconds != nil {
+			lags[table] = *progress.LagSeconds
+		}
+	}
+	return lags, true
+}
+
+// TablesProgress returns the progress of the tables loading the increment by the checkpoint of the changefeed last
+// checked, ok is false if no changefeed is checked yet
+func (s *APIInfo) TablesProgress() (tables map[string]*TableProgress, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.r.Changefeed == nil {
+		// the lag is unknown without the checkpoint
+		return nil, false
+	}
+	return s.genProgress(s.r.Changefeed.CheckpointTSO, nil).Tables, true
+}
@@ -0,0 +1,39 @@
+package apiservice_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLagState(t *testing.T) {
+	tso := func(at time.Time) uint64 {
+		return uint64(at.UnixMilli()) << 18
+	}
+	now := time.Now()
+	status := apiservice.NewAPIInfo()
+	status.SetCDCFlushInterval(30 * time.Second)
+	for _, table := range []string{"db.waiting", "db.behind"} {
+		status.SetTableStage(table, apiservice.TableStageLoadingIncremental)
+		status.SetTableConfig(table, apiservice.TableConfig{MergeInterval: (10 * time.Minute).String()})
+	}
+	// merged 8 minutes ago, the next merge is due within the merge interval
+	status.SetTableLoadedCommitTs("db.waiting", tso(now.Add(-8*time.Minute)))
+	// merged 12 minutes ago, past the merge interval and the flush interval
+	status.SetTableLoadedCommitTs("db.behind", tso(now.Add(-12*time.Minute)))
+	status.SetChangefeedInfo(apiservice.ChangefeedInfo{CheckpointTSO: tso(now)})
+
+	lags, ok := status.TableLags()
+	require.True(t, ok)
+	require.InDelta(t, 480, lags["db.waiting"], 1)
+	require.InDelta(t, 720, lags["db.behind"], 1)
+
+	progress, ok := status.TablesProgress()
+	require.True(t, ok)
+	require.Equal(t, apiservice.LagStateWaiting, progress["db.waiting"].LagState)
+	require.Zero(t, *progress["db.waiting"].OverdueSeconds)
+	require.Equal(t, apiservice.LagStateBehind, progress["db.behind"].LagState)
+	require.InDelta(t, 90, *progress["db.behind"].OverdueSeconds, 1)
+}
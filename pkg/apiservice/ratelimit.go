@@ -0,0 +1,86 @@
+package apiservice
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// RateLimitInfo is the bandwidth of the external storages, the limits are bytes per second and 0 is unlimited
+type RateLimitInfo struct {
+	UploadRateLimit   int64 `json:"upload_rate_limit"`
+	DownloadRateLimit int64 `json:"download_rate_limit"`
+	UploadedBytes     int64 `json:"uploaded_bytes"`
+	DownloadedBytes   int64 `json:"downloaded_bytes"`
+}
+
+// RateLimitUpdate is the body of POST /api/v1/ratelimit, the limits are given like 200MiB and "0" is unlimited.
+// The limits omitted are unchanged.
+type RateLimitUpdate struct {
+	UploadRateLimit   *string `json:"upload_rate_limit"`
+	DownloadRateLimit *string `json:"download_rate_limit"`
+}
+
+func rateLimitInfo(limiters *ratelimit.Limiters) RateLimitInfo {
+	return RateLimitInfo{
+		UploadRateLimit:   limiters.Upload.Rate(),
+		DownloadRateLimit: limiters.Download.Rate(),
+		UploadedBytes:     limiters.Upload.Transferred(),
+		DownloadedBytes:   limiters.Download.Transferred(),
+	}
+}
+
+// limiters returns the limiters of the pipeline, the request is answered with an error if they are not set yet
+func (s *APIInfo) limiters(c *gin.Context) *ratelimit.Limiters {
+	s.mu.Lock()
+	limiters := s.rateLimiters
+	s.mu.Unlock()
+	if limiters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the rate limits are not set yet"})
+	}
+	return limiters
+}
+
+func (s *APIInfo) getRateLimit(c *gin.Context) {
+	limiters := s.limiters(c)
+	if limiters == nil {
+		return
+	}
+	c.JSON(http.StatusOK, rateLimitInfo(limiters))
+}
+
+// updateRateLimit changes the limits of --upload-rate-limit and --download-rate-limit, the transfers in progress
+// take them from their next chunk
+func (s *APIInfo) updateRateLimit(c *gin.Context) {
+	limiters := s.limiters(c)
+	if limiters == nil {
+		return
+	}
+	var update RateLimitUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// both limits are checked before either is changed
+	upload, download := limiters.Upload.Rate(), limiters.Download.Rate()
+	var err error
+	if update.UploadRateLimit != nil {
+		if upload, err = ratelimit.ParseRate(*update.UploadRateLimit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if update.DownloadRateLimit != nil {
+		if download, err = ratelimit.ParseRate(*update.DownloadRateLimit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	limiters.Upload.SetRate(upload)
+	limiters.Download.SetRate(download)
+	log.Info("Updated storage rate limits", zap.String("upload", ratelimit.FormatRate(upload)), zap.String("download", ratelimit.FormatRate(download)))
+	c.JSON(http.StatusOK, rateLimitInfo(limiters))
+}
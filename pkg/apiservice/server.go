@@ -16,13 +16,13 @@ type APIService struct {
 	router  *gin.Engine
 }
 
-func New() *APIService {
+// New creates the API service serving the status in apiInfo
+func New(apiInfo *APIInfo) *APIService {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.New()
 	r.Use(gin.Recovery())
 
-	apiInfo := NewAPIInfo()
 	apiInfo.registerRouter(r)
 
 	return &APIService{
@@ -0,0 +1,103 @@
+// Package appliedbatch records the batches of increment files applied to the tables of the Data Warehouse. The
+// increment checkpoint is written after a batch is applied, so a batch applied right before a crash is replayed
+// after the restart; it is harmless for the upserts of a MERGE, but it duplicates the rows appended in
+// incrementmode.Append and the rows of the tables without a primary key. The connectors record the batches in
+// the TableName table of the Data Warehouse, in the transaction of the MERGE where the Data Warehouse supports it,
+// and the batches found there are skipped instead of being applied again.
+package appliedbatch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TableName is the bookkeeping table of the applied batches, created by each connector in the schema of its table
+const TableName = "_tidb2dw_applied_batches"
+
+// Retention is how long a batch is kept in TableName, by the commit ts of the batches applied to the table after
+// it. A batch is replayed at most once, right after the restart, so it only has to outlive the restart.
+const Retention = 7 * 24 * time.Hour
+
+// Batch is a batch of increment files of a table applied to the Data Warehouse. The connectors applying the files
+// one by one record the batch after each of them, so a batch interrupted in the middle is replayed from the file
+// after LastFile.
+type Batch struct {
+	// ID identifies the batch by its first file, see ID
+	ID string
+	// Table is the table in the Data Warehouse the batch is applied to, set by the connector
+	Table string
+	// LastFile is the path of the last file of the batch applied, set by the connector
+	LastFile string
+	// CommitTs is the commit ts of the last row of the batch, 0 if its files are all empty
+	CommitTs uint64
+}
+
+// ID identifies the batch starting at the file of the commit ts, which is the commit ts of its last row. The path of
+// a file has the table and its table version, and a batch is replayed from the same first file after a restart, even
+// if more files are loaded with it. The commit ts tells the file from a file of the same path written after the
+// merged one is deleted.
+func ID(firstFile string, commitTs uint64) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d", firstFile, commitTs)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// RetentionCutoff returns the commit ts before which the batches are removed once a batch of commitTs is applied
+func RetentionCutoff(commitTs uint64) uint64 {
+	retention := uint64(Retention.Milliseconds()) << 18
+	if commitTs < retention {
+		return 0
+	}
+	return commitTs - retention
+}
+
+// GenCreateTable creates the bookkeeping table if it does not exist, table is the quoted name of the table
+func GenCreateTable(table, stringType, intType, timestampType string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (table_name %s, batch_id %s, last_file %s, commit_ts %s, applied_at %s)",
+		table, stringType, stringType, stringType, intType, timestampType)
+}
+
+// GenFind selects the last file and the commit ts of the batch of the id, quote writes a string literal of the Data
+// Warehouse, e.g. utils.QuoteLiteral
+func GenFind(table, id string, quote func(string) string) string {
+	return fmt.Sprintf("SELECT last_file, commit_ts FROM %s WHERE batch_id = %s", table, quote(id))
+}
+
+// GenRecord records the batch in place of its previous record, and removes the batches of the table older than the
+// retention, now is the current timestamp of the Data Warehouse and quote writes its string literals
+func GenRecord(table string, batch Batch, now string, quote func(string) string) []string {
+	return []string{
+		fmt.Sprintf("DELETE FROM %s WHERE batch_id = %s OR (table_name = %s AND commit_ts < %d)",
+			table, quote(batch.ID), quote(batch.Table), RetentionCutoff(batch.CommitTs)),
+		fmt.Sprintf("INSERT INTO %s (table_name, batch_id, last_file, commit_ts, applied_at) VALUES (%s, %s, %s, %d, %s)",
+			table, quote(batch.Table), quote(batch.ID), quote(batch.LastFile), batch.CommitTs, now),
+	}
+}
+
+// PartitionID identifies the partition of the batch of the id merged by the given number of partitions, the
+// partitions of a batch merged by one MERGE each are recorded one by one, see GenFindPartitions
+func PartitionID(id string, partition, partitions int) string {
+	return fmt.Sprintf("%s/%d/%d", id, partitions, partition)
+}
+
+// ParsePartitionID returns the partition and the number of partitions of a PartitionID of the batch of the id, ok
+// is false if it is not one
+func ParsePartitionID(id, partitionID string) (partition, partitions int, ok bool) {
+	rest, found := strings.CutPrefix(partitionID, id+"/")
+	if !found {
+		return 0, 0, false
+	}
+	if _, err := fmt.Sscanf(rest, "%d/%d", &partitions, &partition); err != nil || partition < 0 || partition >= partitions {
+		return 0, 0, false
+	}
+	return partition, partitions, true
+}
+
+// GenFindPartitions selects the PartitionID of the partitions recorded of the batch of the id, quote writes a string
+// literal of the Data Warehouse
+func GenFindPartitions(table, id string, quote func(string) string) string {
+	return fmt.Sprintf("SELECT batch_id FROM %s WHERE batch_id LIKE %s", table, quote(id+"/%"))
+}
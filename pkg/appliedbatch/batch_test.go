@@ -0,0 +1,50 @@
+package appliedbatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestID(t *testing.T) {
+	id := ID("db/t/446132463224127489/2023-11-08/CDC000001.csv", 446132463224127490)
+	require.Len(t, id, 32)
+	require.Equal(t, id, ID("db/t/446132463224127489/2023-11-08/CDC000001.csv", 446132463224127490))
+	require.NotEqual(t, id, ID("db/t/446132463224127489/2023-11-08/CDC000002.csv", 446132463224127490))
+	// the file of the same path written again
+	require.NotEqual(t, id, ID("db/t/446132463224127489/2023-11-08/CDC000001.csv", 446132463224127600))
+}
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	commitTs := uint64(now.UnixMilli()) << 18
+	require.Equal(t, uint64(now.Add(-Retention).UnixMilli())<<18, RetentionCutoff(commitTs))
+	require.Equal(t, uint64(0), RetentionCutoff(0))
+}
+
+func TestGenRecord(t *testing.T) {
+	require.Equal(t,
+		`CREATE TABLE IF NOT EXISTS "_tidb2dw_applied_batches" (table_name TEXT, batch_id TEXT, last_file TEXT, commit_ts BIGINT, applied_at TIMESTAMP)`,
+		GenCreateTable(`"_tidb2dw_applied_batches"`, "TEXT", "BIGINT", "TIMESTAMP"))
+	require.Equal(t, `SELECT last_file, commit_ts FROM t WHERE batch_id = 'abc'`, GenFind("t", "abc", utils.QuoteLiteral))
+	queries := GenRecord("t", Batch{ID: "abc", Table: "orders", LastFile: "db/orders/1/CDC000003.csv", CommitTs: 1 << 60}, "CURRENT_TIMESTAMP", utils.QuoteLiteral)
+	require.Equal(t, []string{
+		"DELETE FROM t WHERE batch_id = 'abc' OR (table_name = 'orders' AND commit_ts < 1152762959915646976)",
+		"INSERT INTO t (table_name, batch_id, last_file, commit_ts, applied_at) VALUES ('orders', 'abc', 'db/orders/1/CDC000003.csv', 1152921504606846976, CURRENT_TIMESTAMP)",
+	}, queries)
+}
+
+func TestPartitionID(t *testing.T) {
+	id := PartitionID("abc", 2, 5)
+	partition, partitions, ok := ParsePartitionID("abc", id)
+	require.True(t, ok)
+	require.Equal(t, 2, partition)
+	require.Equal(t, 5, partitions)
+	_, _, ok = ParsePartitionID("abd", id)
+	require.False(t, ok)
+	_, _, ok = ParsePartitionID("abc", "abc/2/5")
+	require.False(t, ok)
+	require.Equal(t, `SELECT batch_id FROM t WHERE batch_id LIKE 'abc/%'`, GenFindPartitions("t", "abc", utils.QuoteLiteral))
+}
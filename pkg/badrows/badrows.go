@@ -0,0 +1,92 @@
+// Package badrows tolerates the rows of the increment files the Data Warehouse fails to load, e.g. a string longer
+// than its column or invalid UTF-8, up to --max-bad-rows rows in a batch. The connectors skip the rows by the native
+// error handling of the Data Warehouse and report them, and the rows skipped by a batch are written into Dir of the
+// workspace. A batch with more bad rows than the limit still fails.
+package badrows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// Dir is the directory in the workspace the rows rejected by each batch are written into
+const Dir = "errors"
+
+// maxReportedRows is the maximum number of rows in the error of a batch exceeding the limit
+const maxReportedRows = 10
+
+// Row is a row of a file rejected by the Data Warehouse
+type Row struct {
+	File string `json:"file"`
+	// Line is the 1-based line of the row in the file, 0 if unknown
+	Line   int64  `json:"line,omitempty"`
+	Column string `json:"column,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (r Row) String() string {
+	location := r.File
+	if r.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, r.Line)
+	}
+	if r.Column != "" {
+		location = fmt.Sprintf("%s (column %s)", location, r.Column)
+	}
+	return fmt.Sprintf("%s: %s", location, r.Reason)
+}
+
+// Rejects are the rows rejected by the loads of a batch. Some Data Warehouses only detail a part of the rows, e.g.
+// the first of each file, so Count may be more than the rows detailed.
+type Rejects struct {
+	Count int64 `json:"count"`
+	Rows  []Row `json:"rows"`
+}
+
+// Add adds the rows rejected by another load of the batch
+func (r *Rejects) Add(other Rejects) {
+	r.Count += other.Count
+	r.Rows = append(r.Rows, other.Rows...)
+}
+
+// Check fails if more than maxBadRows rows are rejected
+func (r Rejects) Check(maxBadRows int64) error {
+	if r.Count <= maxBadRows {
+		return nil
+	}
+	reported := make([]string, 0, min(len(r.Rows), maxReportedRows))
+	for i := 0; i < len(r.Rows) && i < maxReportedRows; i++ {
+		reported = append(reported, r.Rows[i].String())
+	}
+	return errors.Errorf("%d rows are rejected by the data warehouse, more than --max-bad-rows=%d:\n%s",
+		r.Count, maxBadRows, strings.Join(reported, "\n"))
+}
+
+// Report is the file of the rows rejected by a batch
+type Report struct {
+	Table string `json:"table"`
+	// BatchID is the appliedbatch.ID of the batch
+	BatchID string    `json:"batch_id"`
+	Time    time.Time `json:"time"`
+	Rejects
+}
+
+// ReportPath returns the path of the report of the batch in the workspace
+func ReportPath(batchID string) string {
+	return path.Join(Dir, batchID+".json")
+}
+
+// WriteReport writes the report into the workspace, a batch loaded again overwrites its report
+func WriteReport(ctx context.Context, workspace storage.ExternalStorage, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(workspace.WriteFile(ctx, ReportPath(report.BatchID), data))
+}
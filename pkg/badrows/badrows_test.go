@@ -0,0 +1,45 @@
+package badrows
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectsCheck(t *testing.T) {
+	var rejects Rejects
+	require.NoError(t, rejects.Check(0))
+
+	rejects.Add(Rejects{Count: 2, Rows: []Row{{File: "a.csv", Line: 3, Column: "v", Reason: "value too long"}}})
+	rejects.Add(Rejects{Count: 1, Rows: []Row{{File: "b.csv", Reason: "invalid UTF-8"}}})
+	require.Equal(t, int64(3), rejects.Count)
+	require.Len(t, rejects.Rows, 2)
+	require.NoError(t, rejects.Check(3))
+	err := rejects.Check(2)
+	require.ErrorContains(t, err, "3 rows are rejected by the data warehouse, more than --max-bad-rows=2")
+	require.ErrorContains(t, err, "a.csv:3 (column v): value too long")
+	require.ErrorContains(t, err, "b.csv: invalid UTF-8")
+}
+
+func TestWriteReport(t *testing.T) {
+	ctx := context.Background()
+	workspace, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	report := &Report{
+		Table:   "db.t",
+		BatchID: "8f14e45fceea167a5a36dedd4bea2543",
+		Time:    time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Rejects: Rejects{Count: 1, Rows: []Row{{File: "a.csv", Line: 3, Reason: "value too long"}}},
+	}
+	require.NoError(t, WriteReport(ctx, workspace, report))
+
+	data, err := workspace.ReadFile(ctx, "errors/8f14e45fceea167a5a36dedd4bea2543.json")
+	require.NoError(t, err)
+	var read Report
+	require.NoError(t, json.Unmarshal(data, &read))
+	require.Equal(t, *report, read)
+}
@@ -0,0 +1,126 @@
+package bigquerysql
+
+import (
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/iterator"
+)
+
+// SetAppliedBatch sets the batch recorded by the next LoadIncrement or LoadIncrementBatch, right after the files are
+// loaded into the increment table, as the load job is not in a transaction. The files staged by --bq.merge-interval
+// are merged from the increment table, which is kept across the restarts.
+func (bc *BigQueryConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	bc.appliedBatch = batch
+}
+
+// FindAppliedBatch returns the batch of the id recorded in the bookkeeping table, nil if it is not applied or the
+// queries are recorded by the dry run
+func (bc *BigQueryConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if bc.dryRun != nil {
+		return nil, nil
+	}
+	if err := bc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFind(bc.gen.quoteTable(bc.datasetID, appliedbatch.TableName), id, utils.QuoteLiteral)
+	it, err := bc.bqClient.Query(query).Read(bc.ctx)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	var row []bigquery.Value
+	if err = it.Next(&row); err != nil {
+		if err == iterator.Done {
+			return nil, nil
+		}
+		return nil, diag.WrapSQL(err, query)
+	}
+	lastFile, _ := row[0].(string)
+	commitTs, _ := row[1].(int64)
+	return &appliedbatch.Batch{ID: id, LastFile: lastFile, CommitTs: uint64(commitTs)}, nil
+}
+
+// ensureAppliedBatchTable creates the bookkeeping table of the applied batches if it does not exist
+func (bc *BigQueryConnector) ensureAppliedBatchTable() error {
+	if bc.appliedBatchTableCreated {
+		return nil
+	}
+	query := appliedbatch.GenCreateTable(bc.gen.quoteTable(bc.datasetID, appliedbatch.TableName), "STRING", "INT64", "TIMESTAMP")
+	if err := bc.runQuery(query); err != nil {
+		return errors.Annotate(err, "Failed to create applied batch table")
+	}
+	bc.appliedBatchTableCreated = true
+	return nil
+}
+
+// recordAppliedBatch records the batch set by SetAppliedBatch as applied to the table up to the file, it is a no-op
+// if no batch is set
+func (bc *BigQueryConnector) recordAppliedBatch(filePath string) error {
+	if bc.appliedBatch == nil {
+		return nil
+	}
+	if err := bc.ensureAppliedBatchTable(); err != nil {
+		return errors.Trace(err)
+	}
+	batch := *bc.appliedBatch
+	batch.Table = bc.tableID
+	batch.LastFile = filePath
+	for _, query := range appliedbatch.GenRecord(bc.gen.quoteTable(bc.datasetID, appliedbatch.TableName), batch, "CURRENT_TIMESTAMP()", utils.QuoteLiteral) {
+		if err := bc.runQuery(query); err != nil {
+			return errors.Annotate(err, "Failed to record the applied batch")
+		}
+	}
+	return nil
+}
+
+// findMergedPartitions returns the partitions of the given number of partitions recorded of the batch set by
+// SetAppliedBatch, none if no batch is set. The partitions of another number of partitions, e.g. --max-merge-rows
+// is changed, are merged again.
+func (bc *BigQueryConnector) findMergedPartitions(partitions int) (map[int]bool, error) {
+	merged := make(map[int]bool)
+	if bc.appliedBatch == nil || bc.dryRun != nil {
+		return merged, nil
+	}
+	if err := bc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFindPartitions(bc.gen.quoteTable(bc.datasetID, appliedbatch.TableName), bc.appliedBatch.ID, utils.QuoteLiteral)
+	it, err := bc.bqClient.Query(query).Read(bc.ctx)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	for {
+		var row []bigquery.Value
+		if err = it.Next(&row); err == iterator.Done {
+			return merged, nil
+		} else if err != nil {
+			return nil, diag.WrapSQL(err, query)
+		}
+		id, _ := row[0].(string)
+		if partition, n, ok := appliedbatch.ParsePartitionID(bc.appliedBatch.ID, id); ok && n == partitions {
+			merged[partition] = true
+		}
+	}
+}
+
+// recordMergedPartition records the partition of the batch set by SetAppliedBatch as merged, it is a no-op if no
+// batch is set
+func (bc *BigQueryConnector) recordMergedPartition(partition, partitions int) error {
+	if bc.appliedBatch == nil {
+		return nil
+	}
+	if err := bc.ensureAppliedBatchTable(); err != nil {
+		return errors.Trace(err)
+	}
+	batch := *bc.appliedBatch
+	batch.ID = appliedbatch.PartitionID(bc.appliedBatch.ID, partition, partitions)
+	batch.Table = bc.tableID
+	for _, query := range appliedbatch.GenRecord(bc.gen.quoteTable(bc.datasetID, appliedbatch.TableName), batch, "CURRENT_TIMESTAMP()", utils.QuoteLiteral) {
+		if err := bc.runQuery(query); err != nil {
+			return errors.Annotate(err, "Failed to record the partition")
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package bigquerysql
 
 import (
 	"context"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/pingcap/errors"
 	"google.golang.org/api/option"
 )
 
@@ -11,6 +13,38 @@ type BigQueryConfig struct {
 	ProjectID           string
 	DatasetID           string
 	CredentialsFilePath string // path to google credentials file
+
+	// MergeInterval is the minimal interval between two MERGE statements on the same table.
+	// Increment files are appended into the increment table and merged together once the
+	// interval elapsed. Zero means every file is merged immediately.
+	MergeInterval time.Duration
+	// PartitionPruning restricts the MERGE to the partitions touched by the batch when the
+	// target table is partitioned on one of its columns. The partitioning column must never
+	// be updated upstream, otherwise the old row is out of range and will not be matched.
+	PartitionPruning bool
+	// MaxStaleness enables reading increment files through a BigLake external table with
+	// metadata caching instead of a load job. It requires ConnectionID, and is between
+	// MinMaxStaleness and MaxMaxStaleness.
+	MaxStaleness time.Duration
+	// ConnectionID is the BigLake connection used by the external table, e.g. `us.my-connection`
+	ConnectionID string
+	// CreateDataset creates the datasets of the tables if they do not exist, otherwise a missing dataset fails the
+	// connectors
+	CreateDataset bool
+}
+
+const (
+	// MinMaxStaleness and MaxMaxStaleness are the range of the max_staleness of the tables in BigQuery
+	MinMaxStaleness = 30 * time.Minute
+	MaxMaxStaleness = 7 * 24 * time.Hour
+)
+
+// ValidateMaxStaleness fails if the max staleness of the external table is out of the range BigQuery accepts
+func ValidateMaxStaleness(maxStaleness time.Duration) error {
+	if maxStaleness < MinMaxStaleness || maxStaleness > MaxMaxStaleness {
+		return errors.Errorf("invalid BigQuery max staleness %s, expected between %s and %s", maxStaleness, MinMaxStaleness, MaxMaxStaleness)
+	}
+	return nil
 }
 
 func (cfg *BigQueryConfig) NewClient() (*bigquery.Client, error) {
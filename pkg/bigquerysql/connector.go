@@ -4,14 +4,29 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"net/url"
+	"path"
+	"slices"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
@@ -19,28 +34,127 @@ import (
 type BigQueryConnector struct {
 	bqClient *bigquery.Client
 	ctx      context.Context
+	// gen writes the names of the tables and the columns in the identifier case of --identifier-case
+	gen Generator
 
-	datasetID        string
-	tableID          string
+	datasetID string
+	tableID   string
+	// routed is true if tableID is set by SetTargetTable, the table keeps its name when the source table is renamed
+	routed bool
+	// dedupKey is the key the table is created with if the source table has no primary key, nil if there is none
+	dedupKey         []string
 	incrementTableID string
 	storageURL       string
+	compression      utils.Compression
+
+	mergeInterval    time.Duration
+	partitionPruning bool
+	maxStaleness     time.Duration
+	connectionID     string
+
+	// stagedTableDef is the table definition of the rows appended to the increment
+	// table but not merged yet, nil if there is nothing to merge.
+	stagedTableDef *cloudstorage.TableDefinition
+	// stagedFormat is the format of the files the staged rows are loaded from
+	stagedFormat stagingformat.Format
+	// stagedFiles are the files read by the external table of --bq.max-staleness and not merged yet, nil if the rows
+	// are loaded into the increment table
+	stagedFiles   []string
+	lastMergeTime time.Time
+	// externalTableURI is the wildcard of the files the external table of --bq.max-staleness is created over, empty
+	// if it is not created yet
+	externalTableURI string
+
+	// partitionColumn is the partitioning column of the target table, loaded lazily.
+	partitionColumn       string
+	partitionColumnLoaded bool
+	totalBytesBilled      int64
+	// mergedRows is the total rows changed by the merges of the increment table
+	mergedRows int64
+	// maxBadRows is the rows of a batch of increment files skipped if they fail to be loaded, 0 fails the batch
+	maxBadRows int64
+	// maxMergeRows is the staged rows merged by one MERGE, 0 merges all the staged rows at once
+	maxMergeRows int64
+	// badRows are the rows skipped by the last batch
+	badRows badrows.Rejects
+
+	// dryRun records the queries instead of running them, nil if they are run
+	dryRun func(statement string)
+	// auditLog logs the queries and the jobs run under auditScope, nil if the SQL audit log is disabled
+	auditLog   *sqlaudit.Logger
+	auditScope *sqlaudit.Scope
 
 	columns []cloudstorage.TableCol
+	// snapshotColumns are the columns copied by CopyTableSchema, nil if the table is not copied by the process
+	snapshotColumns []cloudstorage.TableCol
+	// columnTypes overrides the types of the columns, nil if the default mapping is used
+	columnTypes columnmapping.Columns
+	// columnFilter is the columns replicated, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows replicated, empty if all the rows are replicated
+	where string
+	// layout is the partitioning and clustering of the table created, the zero Layout if there is none
+	layout tablelayout.Layout
+	// deleteMode is how the rows deleted in TiDB are deleted in the table, the zero Mode deletes them
+	deleteMode deletemode.Mode
+	// appliedBatch is the batch recorded by the loads of the increment files, nil if they are not recorded
+	appliedBatch *appliedbatch.Batch
+	// appliedBatchTableCreated is true once the bookkeeping table of the applied batches is created
+	appliedBatchTableCreated bool
 }
 
-func NewBigQueryConnector(bqClient *bigquery.Client, incrementTableID, datasetID, tableID string, storageURI *url.URL) (*BigQueryConnector, error) {
+func NewBigQueryConnector(bqClient *bigquery.Client, identifierCase identcase.Case, incrementTableID, datasetID, tableID string, storageURI *url.URL, compression utils.Compression, cfg *BigQueryConfig) (*BigQueryConnector, error) {
+	if cfg.MaxStaleness > 0 {
+		if err := ValidateMaxStaleness(cfg.MaxStaleness); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if cfg.ConnectionID == "" {
+			return nil, errors.New("BigQuery connection is required to use max staleness external tables")
+		}
+		if cfg.MergeInterval > 0 {
+			// The external table reads the increment file directly, which is deleted once LoadIncrement returns.
+			return nil, errors.New("BigQuery merge interval can not be used together with max staleness external tables")
+		}
+	}
+	// the client is nil in dry run
+	if bqClient != nil {
+		if err := ensureDataset(context.Background(), bqClient, datasetID, cfg.DatasetID, cfg.CreateDataset); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 	storageURL := fmt.Sprintf("%s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
 	return &BigQueryConnector{
 		bqClient:         bqClient,
 		ctx:              context.Background(),
+		gen:              NewGenerator(identifierCase),
 		datasetID:        datasetID,
 		tableID:          tableID,
 		incrementTableID: incrementTableID,
 		storageURL:       storageURL,
+		compression:      compression,
+		mergeInterval:    cfg.MergeInterval,
+		partitionPruning: cfg.PartitionPruning,
+		maxStaleness:     cfg.MaxStaleness,
+		connectionID:     cfg.ConnectionID,
 		columns:          nil,
 	}, nil
 }
 
+// NewBigQuerySchemaConnector returns a connector creating and altering the table of the dataset only, e.g. for
+// `tidb2dw schema sync`, which loads no file
+func NewBigQuerySchemaConnector(bqClient *bigquery.Client, identifierCase identcase.Case, datasetID, tableID string, cfg *BigQueryConfig) (*BigQueryConnector, error) {
+	if err := ensureDataset(context.Background(), bqClient, datasetID, cfg.DatasetID, cfg.CreateDataset); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &BigQueryConnector{
+		bqClient:  bqClient,
+		ctx:       context.Background(),
+		gen:       NewGenerator(identifierCase),
+		datasetID: datasetID,
+		tableID:   tableID,
+	}, nil
+}
+
 func (bc *BigQueryConnector) InitSchema(columns []cloudstorage.TableCol) error {
 	if len(bc.columns) != 0 {
 		return nil
@@ -54,10 +168,20 @@ func (bc *BigQueryConnector) InitSchema(columns []cloudstorage.TableCol) error {
 }
 
 func (bc *BigQueryConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(bc.columns) == 0 {
+	// a table created after the changefeed starts has no columns before its CREATE TABLE
+	if len(bc.columns) == 0 && tableDef.Type != timodel.ActionCreateTable {
 		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
 	}
-	ddls, err := GenDDLViaColumnsDiff(bc.datasetID, bc.tableID, bc.columns, tableDef)
+	if bc.routed && tidbsql.IsRenameTable(tableDef.Type) {
+		log.Info("Skip rename table ddl, the routed table keeps its name", zap.String("ddl", tableDef.Query), zap.String("table", bc.tableID))
+		return nil
+	}
+	// rows staged under the previous schema must be merged before the schema changes
+	if err := bc.mergeStagedIncrement(); err != nil {
+		return errors.Trace(err)
+	}
+	// the changes of the columns filtered out are ignored
+	ddls, err := bc.gen.GenDDLViaColumnsDiff(bc.datasetID, bc.tableID, bc.columnFilter.Columns(bc.columns), bc.columnFilter.TableDef(tableDef), bc.columnTypes, bc.layout, bc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -67,84 +191,584 @@ func (bc *BigQueryConnector) ExecDDL(tableDef cloudstorage.TableDefinition) erro
 	}
 	// One DDL may be rewritten to multiple DDLs
 	for _, ddl := range ddls {
-		if err = runQuery(bc.ctx, bc.bqClient, ddl); err != nil {
+		if err = bc.runQuery(ddl); err != nil {
+			if appliedDDLErrors.IsApplied(ddl, err) {
+				log.Warn("Skip DDL whose effect is already present, it is executed before a failure", zap.String("ddl", ddl), zap.Error(err))
+				continue
+			}
 			log.Error("Failed to execute DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
 			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
 		}
 	}
 	// update columns
 	bc.columns = tableDef.Columns
+	bc.partitionColumnLoaded = false
+	// the external table is created again with the new columns
+	bc.externalTableURI = ""
+	if tidbsql.IsRenameTable(tableDef.Type) {
+		bc.tableID = tableDef.Table
+	}
 	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
 	return nil
 }
 
 // CopyTableSchema copies table schema from TiDB to BigQuery
 // If table exists, delete it first
+// SetColumnTypes overrides the types of the columns of the table in BigQuery, nil means the default mapping
+func (bc *BigQueryConnector) SetColumnTypes(columnTypes columnmapping.Columns) {
+	bc.columnTypes = columnTypes
+}
+
+// SetColumnFilter filters the columns of the table replicated to BigQuery, nil means all the columns
+func (bc *BigQueryConnector) SetColumnFilter(columnFilter *columnfilter.Filter) {
+	bc.columnFilter = columnFilter
+}
+
+// SetWhere filters the rows of the table replicated to BigQuery by the predicate, empty means all the rows
+func (bc *BigQueryConnector) SetWhere(where string) {
+	bc.where = where
+}
+
+// SetTableLayout partitions and clusters the table created in BigQuery by the columns of the layout
+func (bc *BigQueryConnector) SetTableLayout(layout tablelayout.Layout) {
+	bc.layout = layout
+}
+
+// SetDedupKey creates the table in BigQuery with the columns as its primary key if the source table has none, the
+// increment files of such a table are merged by them
+func (bc *BigQueryConnector) SetDedupKey(columns []string) {
+	bc.dedupKey = columns
+}
+
+// SetTargetTable replicates the table to another table of the dataset, empty means the table given when the
+// connector is created. The table keeps its name when the source table is renamed.
+func (bc *BigQueryConnector) SetTargetTable(table string) {
+	if table != "" {
+		bc.tableID = table
+		bc.routed = true
+	}
+}
+
+// SetDeleteMode sets how the rows deleted in TiDB are deleted in the table in BigQuery, soft keeps them with a
+// tombstone
+func (bc *BigQueryConnector) SetDeleteMode(deleteMode deletemode.Mode) {
+	bc.deleteMode = deleteMode
+}
+
+// SetSyncComments sets whether the comments of the tables and the columns in TiDB are replicated into BigQuery as descriptions,
+// they are replicated by default
+func (bc *BigQueryConnector) SetSyncComments(sync bool) {
+	bc.gen = bc.gen.WithSyncComments(sync)
+}
+
+// CheckDeleteMode fails if the table in BigQuery is created in another delete mode, a table not created yet passes
+func (bc *BigQueryConnector) CheckDeleteMode(targetTable string) error {
+	// the table is not read in a dry run
+	if bc.dryRun != nil {
+		return nil
+	}
+	columns, found, err := bc.gen.getTableColumns(bc.ctx, bc.bqClient, bc.datasetID, bc.tableID)
+	if err != nil || !found {
+		return errors.Trace(err)
+	}
+	return bc.deleteMode.CheckTable(bc.tableID, slices.Contains(columns, bc.gen.identifierCase.Apply(deletemode.DeletedColumn)))
+}
+
 func (bc *BigQueryConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
 	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	tableColumns = bc.columnFilter.Columns(tableColumns)
+	bc.snapshotColumns = tableColumns
 
 	pKColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if len(pKColumns) == 0 {
+		pKColumns = bc.dedupKey
+	}
+
+	comments := &tidbsql.TableComments{}
+	if !bc.gen.skipComments {
+		if comments, err = tidbsql.GetTiDBTableComments(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return errors.Trace(err)
+		}
+	}
 
-	createTableSQL, err := GenCreateSchema(tableColumns, pKColumns, bc.datasetID, bc.tableID)
+	createTableSQL, err := bc.gen.GenCreateSchema(tableColumns, pKColumns, bc.datasetID, bc.tableID, comments, bc.columnTypes, bc.layout, bc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err = runQuery(bc.ctx, bc.bqClient, createTableSQL); err != nil {
+	if err = bc.runQuery(createTableSQL); err != nil {
 		return errors.Annotate(err, "Failed to create table")
 	}
 	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
 	return nil
 }
 
-func (bc *BigQueryConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	gcsFilePath := fmt.Sprintf("%s/%s*.csv", bc.storageURL, filePrefix)
-	// FIXME: if source table is empty, bigquery will fail to load (file not found)
-	err := loadGCSFileToBigQuery(bc.ctx, bc.bqClient, bc.datasetID, bc.tableID, gcsFilePath)
+// maxURIsPerLoadJob is the max number of source URIs of a load job
+const maxURIsPerLoadJob = 10000
+
+func (bc *BigQueryConnector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	// the columns of a resumed load are initialized by InitSchema
+	columns := bc.snapshotColumns
+	if columns == nil {
+		columns = bc.columnFilter.Columns(bc.columns)
+	}
+	// BigQuery detects gzip compressed files automatically
+	for start := 0; start < len(files); start += maxURIsPerLoadJob {
+		batch := files[start:min(start+maxURIsPerLoadJob, len(files))]
+		gcsFilePaths := make([]string, 0, len(batch))
+		for _, file := range batch {
+			gcsFilePaths = append(gcsFilePaths, fmt.Sprintf("%s/%s", bc.storageURL, file))
+		}
+		// the batches are appended, the table may have been partially loaded before the program restarts
+		err := bc.loadSnapshotFiles(columns, gcsFilePaths)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = onFilesLoaded(batch); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.Int("files", len(files)))
+	return nil
+}
+
+// loadSnapshotFiles appends the files to the table. The files of a table with BIT columns longer than 1 are
+// loaded into the staging table first, as the BIT columns are dumped as hex in the CSV files, then converted into
+// the table. So are the files in the soft delete mode, the tombstone columns are not in the files.
+func (bc *BigQueryConnector) loadSnapshotFiles(columns []cloudstorage.TableCol, gcsFilePaths []string) error {
+	format := stagingformat.FileFormat(gcsFilePaths[0])
+	hasHexBits := slices.ContainsFunc(columns, func(column cloudstorage.TableCol) bool {
+		return hexBitLength(column, bc.columnTypes, format) > 0
+	})
+	if !hasHexBits && bc.deleteMode != deletemode.Soft {
+		_, err := bc.loadFiles(bc.tableID, gcsFilePaths, 0)
+		return err
+	}
+	createTableSQL, err := bc.gen.GenCreateSchema(StagedColumns(columns, bc.columnTypes, format), []string{}, bc.datasetID, bc.incrementTableID, nil, bc.columnTypes, tablelayout.Layout{}, deletemode.Hard)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
-	return nil
+	if err = bc.runQuery(createTableSQL); err != nil {
+		return errors.Annotate(err, "Failed to create snapshot staging table")
+	}
+	if _, err = bc.loadFiles(bc.incrementTableID, gcsFilePaths, 0); err != nil {
+		return errors.Trace(err)
+	}
+	if err = bc.runQuery(bc.gen.GenInsertFromStaging(columns, bc.datasetID, bc.tableID, bc.incrementTableID, bc.columnTypes, format)); err != nil {
+		return errors.Annotate(err, "Failed to insert snapshot staging table")
+	}
+	return errors.Trace(bc.deleteTable(bc.incrementTableID))
 }
 
 func (bc *BigQueryConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
-	incrementTableID := bc.incrementTableID
+	bc.badRows = badrows.Rejects{}
 	absolutePath := fmt.Sprintf("%s://%s%s/%s", uri.Scheme, uri.Host, uri.Path, filePath)
+	format := stagingformat.FileFormat(filePath)
+	tableColumns := StagedColumns(utils.GenIncrementTableColumns(tableDef.Columns), bc.columnTypes, format)
+
+	if bc.maxStaleness > 0 {
+		if err := bc.ensureExternalTable(tableColumns, absolutePath); err != nil {
+			return errors.Trace(err)
+		}
+		bc.stagedTableDef, bc.stagedFormat, bc.stagedFiles = &tableDef, format, []string{absolutePath}
+		if err := bc.mergeStagedIncrement(); err != nil {
+			return errors.Trace(err)
+		}
+		if err := bc.recordAppliedBatch(filePath); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("Successfully merge file", zap.String("file", filePath))
+		return nil
+	}
 
-	tableColumns := utils.GenIncrementTableColumns(tableDef.Columns)
-	createTableSQL, err := GenCreateSchema(tableColumns, []string{}, bc.datasetID, incrementTableID)
+	merged, err := bc.stageIncrementFiles(tableDef, []string{absolutePath})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err = runQuery(bc.ctx, bc.bqClient, createTableSQL); err != nil {
-		return errors.Annotate(err, "Failed to create increment table")
+	if err = bc.recordAppliedBatch(filePath); err != nil {
+		return errors.Trace(err)
+	}
+	if !merged {
+		log.Info("Staged file, merge is deferred", zap.String("file", filePath), zap.Duration("mergeInterval", bc.mergeInterval))
+		return nil
 	}
+	log.Info("Successfully merge file", zap.String("file", filePath))
+	return nil
+}
+
+// ensureExternalTable creates the external table of --bq.max-staleness over the files of the directory of the file,
+// the table is kept for the following files of the directory and created again for another directory, e.g. of
+// another table version. Its metadata cache is refreshed so that the file is read by the merge.
+func (bc *BigQueryConnector) ensureExternalTable(columns []cloudstorage.TableCol, absolutePath string) error {
+	uri := externalTableURI(absolutePath)
+	if uri != bc.externalTableURI {
+		createTableSQL, err := bc.gen.GenCreateExternalTable(columns, bc.datasetID, bc.incrementTableID, bc.connectionID, uri, bc.maxStaleness, bc.compression, bc.columnTypes)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = bc.runQuery(createTableSQL); err != nil {
+			return errors.Annotate(err, "Failed to create increment external table")
+		}
+		bc.externalTableURI = uri
+	}
+	if err := bc.runQuery(bc.gen.GenRefreshExternalMetadataCache(bc.datasetID, bc.incrementTableID)); err != nil {
+		return errors.Annotate(err, "Failed to refresh the metadata cache of the increment external table")
+	}
+	return nil
+}
+
+// externalTableURI returns the wildcard of the files of the directory of the file with the same extensions, e.g.
+// `gs://bucket/db/t/1/*.csv.gz` of `gs://bucket/db/t/1/CDC000001.csv.gz`
+func externalTableURI(absolutePath string) string {
+	dir, name := path.Split(absolutePath)
+	if i := strings.Index(name, "."); i >= 0 {
+		return dir + "*" + name[i:]
+	}
+	return dir + "*"
+}
 
-	err = loadGCSFileToBigQuery(bc.ctx, bc.bqClient, bc.datasetID, incrementTableID, absolutePath)
+// LoadIncrementBatch loads the files by one load job into the increment table and merges them by one MERGE, so that
+// a round of many files counts as one load job against the daily quota of the table. The files are merged one by
+// one with --bq.max-staleness, which reads each of them by the external table.
+func (bc *BigQueryConnector) LoadIncrementBatch(tableDef cloudstorage.TableDefinition, uri *url.URL, filePaths []string) error {
+	bc.badRows = badrows.Rejects{}
+	if bc.maxStaleness > 0 {
+		for _, filePath := range filePaths {
+			if err := bc.LoadIncrement(tableDef, uri, filePath); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
+	for start := 0; start < len(filePaths); start += maxURIsPerLoadJob {
+		batch := filePaths[start:min(start+maxURIsPerLoadJob, len(filePaths))]
+		absolutePaths := make([]string, 0, len(batch))
+		for _, filePath := range batch {
+			absolutePaths = append(absolutePaths, fmt.Sprintf("%s://%s%s/%s", uri.Scheme, uri.Host, uri.Path, filePath))
+		}
+		merged, err := bc.stageIncrementFiles(tableDef, absolutePaths)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = bc.recordAppliedBatch(batch[len(batch)-1]); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("Successfully load files", zap.Int("files", len(batch)), zap.Bool("merged", merged),
+			zap.String("first", batch[0]), zap.String("last", batch[len(batch)-1]))
+	}
+	return nil
+}
+
+// stageIncrementFiles loads the files into the increment table by one load job, then merges the increment table
+// unless the merge is deferred by --bq.merge-interval. merged is false if it is deferred. The rows staged from the
+// files of another format are merged first, since the BIT columns are staged differently.
+func (bc *BigQueryConnector) stageIncrementFiles(tableDef cloudstorage.TableDefinition, absolutePaths []string) (merged bool, err error) {
+	format := stagingformat.FileFormat(absolutePaths[0])
+	if bc.stagedTableDef != nil && bc.stagedFormat != format {
+		if err = bc.mergeStagedIncrement(); err != nil {
+			return false, errors.Trace(err)
+		}
+	}
+	if bc.stagedTableDef == nil {
+		tableColumns := StagedColumns(utils.GenIncrementTableColumns(tableDef.Columns), bc.columnTypes, format)
+		createTableSQL, err := bc.gen.GenCreateSchema(tableColumns, []string{}, bc.datasetID, bc.incrementTableID, nil, bc.columnTypes, tablelayout.Layout{}, deletemode.Hard)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if bc.mergeInterval > 0 {
+			// Keep the rows staged by the previous run, they will be merged in the next batch.
+			createTableSQL = strings.Replace(createTableSQL, "CREATE OR REPLACE TABLE", "CREATE TABLE IF NOT EXISTS", 1)
+		}
+		if err = bc.runQuery(createTableSQL); err != nil {
+			return false, errors.Annotate(err, "Failed to create increment table")
+		}
+	}
+
+	// the rows skipped by the former load jobs of the batch count against the limit
+	rejects, err := bc.loadFiles(bc.incrementTableID, absolutePaths, bc.maxBadRows-bc.badRows.Count)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	bc.badRows.Add(rejects)
+	bc.stagedTableDef, bc.stagedFormat = &tableDef, format
+
+	if bc.mergeInterval > 0 && time.Since(bc.lastMergeTime) < bc.mergeInterval {
+		return false, nil
+	}
+	if err = bc.mergeStagedIncrement(); err != nil {
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// mergeStagedIncrement merges the staged rows in the increment table into the target table
+// and drops the increment table. It is a no-op if nothing is staged.
+func (bc *BigQueryConnector) mergeStagedIncrement() error {
+	if bc.stagedTableDef == nil {
+		return nil
+	}
+	// the increment table has all the columns of the files
+	tableDef := bc.columnFilter.TableDef(*bc.stagedTableDef)
+
+	partitionRange, err := bc.getPartitionRange(tableDef, func(column string) (string, string, bool, error) {
+		source := bc.gen.genStagedSource(bc.datasetID, bc.incrementTableID, bc.stagedFiles)
+		return bc.gen.queryColumnRange(bc.ctx, bc.bqClient, source, column)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	partitions, err := bc.countMergePartitions(tableDef)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if partitions > 1 {
+		if err = bc.mergePartitions(tableDef, partitionRange, partitions); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		mergeSQL := bc.gen.GenMergeInto(tableDef, bc.datasetID, bc.tableID, bc.incrementTableID, bc.stagedFiles, partitionRange, bc.columnTypes, bc.where, bc.deleteMode, bc.stagedFormat)
+		if err = bc.runMerge(mergeSQL, partitionRange); err != nil {
+			return errors.Annotate(err, "Failed to merge increment table")
+		}
+	}
 
-	mergeSQL := GenMergeInto(tableDef, bc.datasetID, bc.tableID, incrementTableID)
-	if err = runQuery(bc.ctx, bc.bqClient, mergeSQL); err != nil {
-		return errors.Annotate(err, "Failed to merge increment table")
+	// the external table is kept for the following files
+	if bc.stagedFiles == nil {
+		if err = bc.deleteTable(bc.incrementTableID); err != nil {
+			return errors.Trace(err)
+		}
 	}
+	bc.stagedTableDef, bc.stagedFiles = nil, nil
+	bc.lastMergeTime = time.Now()
+	return nil
+}
 
-	err = deleteTable(bc.ctx, bc.bqClient, bc.datasetID, incrementTableID)
+// runMerge runs the MERGE of the staged rows and adds up its statistics
+func (bc *BigQueryConnector) runMerge(mergeSQL string, partitionRange *PartitionRange) error {
+	stats, err := bc.runQueryWithStatistics(mergeSQL)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if stats != nil {
+		bc.totalBytesBilled += stats.TotalBytesBilled
+		bc.mergedRows += stats.NumDMLAffectedRows
+		log.Info("Merged increment table",
+			zap.String("table", bc.tableID),
+			zap.Bool("partitionPruned", partitionRange != nil),
+			zap.Int64("bytesBilled", stats.TotalBytesBilled),
+			zap.Int64("totalBytesBilled", bc.totalBytesBilled))
+	}
+	return nil
+}
 
-	log.Info("Successfully merge file", zap.String("file", filePath))
+// countMergePartitions returns the number of partitions the staged rows are merged by, each of at most about
+// --max-merge-rows rows. The rows of a table without a primary key and the rows of a dry run, which are not
+// counted, are merged at once.
+func (bc *BigQueryConnector) countMergePartitions(tableDef cloudstorage.TableDefinition) (int, error) {
+	if bc.maxMergeRows == 0 || bc.dryRun != nil || !slices.ContainsFunc(tableDef.Columns, func(col cloudstorage.TableCol) bool {
+		return col.IsPK == "true"
+	}) {
+		return 1, nil
+	}
+	query := bc.gen.GenCountStaged(bc.datasetID, bc.incrementTableID, bc.stagedFiles)
+	it, err := bc.bqClient.Query(query).Read(bc.ctx)
+	if err != nil {
+		return 0, diag.WrapSQL(err, query)
+	}
+	var row []bigquery.Value
+	if err = it.Next(&row); err != nil {
+		return 0, diag.WrapSQL(err, query)
+	}
+	staged, _ := row[0].(int64)
+	partitions := int((staged + bc.maxMergeRows - 1) / bc.maxMergeRows)
+	if partitions > 1 {
+		log.Info("Merge the increment table by partitions", zap.String("table", bc.tableID), zap.Int64("rows", staged), zap.Int("partitions", partitions))
+	}
+	return partitions, nil
+}
+
+// mergePartitions merges the staged rows by one MERGE per partition of the keys, so that no MERGE of a very large
+// batch runs into the timeout of BigQuery. The partitions are recorded in the bookkeeping table of the applied
+// batches as they are merged, and the batch set by SetAppliedBatch retried, e.g. after a crash, is resumed from the
+// partitions not merged yet.
+func (bc *BigQueryConnector) mergePartitions(tableDef cloudstorage.TableDefinition, partitionRange *PartitionRange, partitions int) error {
+	merged, err := bc.findMergedPartitions(partitions)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for partition := 0; partition < partitions; partition++ {
+		if merged[partition] {
+			log.Info("Skip the partition merged before", zap.String("table", bc.tableID), zap.Int("partition", partition))
+			continue
+		}
+		mergeSQL := bc.gen.GenMergeIntoPartition(tableDef, bc.datasetID, bc.tableID, bc.incrementTableID, bc.stagedFiles, partitionRange, bc.columnTypes, bc.where, bc.deleteMode, bc.stagedFormat, partition, partitions)
+		if err = bc.runMerge(mergeSQL, partitionRange); err != nil {
+			return errors.Annotatef(err, "Failed to merge partition %d of %d of increment table", partition, partitions)
+		}
+		if err = bc.recordMergedPartition(partition, partitions); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// MergeDeferred merges the rows staged by the loads deferred by --bq.merge-interval once the interval is over since
+// the last merge, so that the rows of a table receiving no new file are merged too
+func (bc *BigQueryConnector) MergeDeferred() error {
+	if bc.stagedTableDef == nil || time.Since(bc.lastMergeTime) < bc.mergeInterval {
+		return nil
+	}
+	log.Info("Merging the deferred increment", zap.String("table", bc.tableID), zap.Duration("mergeInterval", bc.mergeInterval))
+	return errors.Trace(bc.mergeStagedIncrement())
+}
+
+// getPartitionRange returns the range of the partitioning column in the staged rows by queryRange,
+// returns nil if the target table is not partitioned on a column of the batch.
+func (bc *BigQueryConnector) getPartitionRange(tableDef cloudstorage.TableDefinition, queryRange func(column string) (min, max string, ok bool, err error)) (*PartitionRange, error) {
+	// the partitioning of the target table is not read in a dry run
+	if !bc.partitionPruning || bc.dryRun != nil {
+		return nil, nil
+	}
+	if !bc.partitionColumnLoaded {
+		column, err := bc.gen.getPartitionColumn(bc.ctx, bc.bqClient, bc.datasetID, bc.tableID)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		bc.partitionColumn = column
+		bc.partitionColumnLoaded = true
+	}
+	if bc.partitionColumn == "" {
+		return nil, nil
+	}
+
+	for _, col := range tableDef.Columns {
+		// the names of the columns are case-insensitive
+		if !strings.EqualFold(col.Name, bc.partitionColumn) {
+			continue
+		}
+		bqType, err := GetBigQueryColumnTypeString(col, bc.columnTypes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		min, max, ok, err := queryRange(col.Name)
+		if err != nil || !ok {
+			return nil, errors.Trace(err)
+		}
+		return &PartitionRange{
+			Column: col.Name,
+			Lower:  genPartitionLiteral(bqType, min),
+			Upper:  genPartitionLiteral(bqType, max),
+		}, nil
+	}
+	return nil, nil
+}
+
+// AggregateTable returns the row count and the sums of the columns of the table, for the validation of the snapshot.
+// The sums are computed as BIGNUMERIC since NUMERIC has less than 38 digits.
+func (bc *BigQueryConnector) AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error) {
+	return (&TableVerifier{bqClient: bc.bqClient, ctx: bc.ctx, gen: bc.gen, datasetID: bc.datasetID}).AggregateRange(bc.tableID, sumColumns, validation.KeyRange{})
+}
+
+// DiffSchema compares the table in BigQuery with the columns replicated to it, nil if the columns are not
+// initialized yet
+func (bc *BigQueryConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	if len(bc.columns) == 0 {
+		return nil, nil
+	}
+	columns, err := bc.gen.GetWarehouseColumns(bc.ctx, bc.bqClient, bc.datasetID, bc.tableID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the tombstone columns of the soft delete mode are not replicated from TiDB
+	actual := slices.DeleteFunc(columns, func(column tidbsql.WarehouseColumn) bool { return deletemode.IsTombstoneColumn(column.Name) })
+	drift, err := tidbsql.GetSchemaDrift(bc.columnFilter.Columns(bc.columns), actual, func(column cloudstorage.TableCol) (string, error) {
+		return getBigQueryColumnType(column, bc.columnTypes)
+	})
+	return drift, errors.Trace(err)
+}
+
+// ReconcileSchema alters the table in BigQuery back to the columns replicated to it
+func (bc *BigQueryConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	ddls, err := bc.gen.GenDDLViaColumnsDiff(bc.datasetID, bc.tableID, drift.Columns, cloudstorage.TableDefinition{Table: bc.tableID, Columns: drift.Expected}, bc.columnTypes, bc.layout, bc.deleteMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ddl := range ddls {
+		if err := bc.runQuery(ddl); err != nil {
+			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	bc.partitionColumnLoaded = false
+	log.Info("Successfully reconciled table", zap.String("table", bc.tableID), zap.String("ddls", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// IsRetryable retries the jobs failed by a rate limit or a backend error of BigQuery, and the requests answered
+// by 429 or 5xx
+func (bc *BigQueryConnector) IsRetryable(err error) bool {
+	return IsRetryableError(err)
+}
+
+// DDLActionClasses returns the classes of the DDLs in BigQuery
+func (bc *BigQueryConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	return ddlActionClasses
+}
+
+// SetMaxMergeRows merges the staged rows of more than n rows by one MERGE per partition of the primary key, each of
+// at most about n rows, 0 merges all the staged rows by one MERGE
+func (bc *BigQueryConnector) SetMaxMergeRows(n int64) {
+	bc.maxMergeRows = n
+}
+
+// SetMaxBadRows skips up to n rows of a batch of increment files failing to be loaded by the MaxBadRecords of the
+// load jobs, the files read by the external table of --bq.max-staleness are not covered
+func (bc *BigQueryConnector) SetMaxBadRows(n int64) {
+	bc.maxBadRows = n
+}
+
+// TakeBadRows returns the rows skipped by the last batch of increment files
+func (bc *BigQueryConnector) TakeBadRows() badrows.Rejects {
+	rejects := bc.badRows
+	bc.badRows = badrows.Rejects{}
+	return rejects
+}
+
+// MergedRows returns the rows changed by the merges so far, the rows of a deferred merge are counted once merged
+func (bc *BigQueryConnector) MergedRows() int64 {
+	return bc.mergedRows
+}
+
+// BytesBilled returns the bytes billed by the merges so far, as reported by the statistics of their jobs
+func (bc *BigQueryConnector) BytesBilled() int64 {
+	return bc.totalBytesBilled
+}
+
+// ExecStatements executes the statements of --post-snapshot-sql in order
+func (bc *BigQueryConnector) ExecStatements(statements []string) error {
+	for _, statement := range statements {
+		log.Info("Executing statement", zap.String("query", statement))
+		if err := runQuery(bc.ctx, bc.bqClient, statement); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
 
 func (bc *BigQueryConnector) Close() {
-	bc.bqClient.Close()
+	if err := bc.mergeStagedIncrement(); err != nil {
+		log.Error("Failed to merge staged increment", zap.Error(err))
+	}
+	if bc.externalTableURI != "" {
+		if err := bc.deleteTable(bc.incrementTableID); err != nil {
+			log.Warn("Failed to drop increment external table", zap.String("table", bc.incrementTableID), zap.Error(err))
+		}
+	}
+	if bc.bqClient != nil {
+		bc.bqClient.Close()
+	}
 }
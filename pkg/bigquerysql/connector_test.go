@@ -0,0 +1,275 @@
+package bigquerysql_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+func TestLoadIncrementBatch(t *testing.T) {
+	uri, err := url.Parse("gs://bucket/increment")
+	require.NoError(t, err)
+	connector, err := bigquerysql.NewBigQueryConnector(nil, identcase.Preserve, "increment_t", "ds", "t", uri, utils.CompressionNone, &bigquerysql.BigQueryConfig{})
+	require.NoError(t, err)
+	var statements []string
+	connector.EnableDryRun(func(statement string) {
+		statements = append(statements, statement)
+	})
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "db",
+		Table:  "t",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "INT", IsPK: "true"},
+			{Name: "v", Tp: "VARCHAR", Precision: "10"},
+		},
+	}
+	files := []string{"db/t/1/CDC000001.csv", "db/t/1/CDC000002.csv", "db/t/1/CDC000003.csv"}
+	require.NoError(t, connector.LoadIncrementBatch(tableDef, uri, files))
+
+	// the files are loaded by one load job and merged by one MERGE
+	require.Len(t, statements, 4)
+	require.True(t, strings.HasPrefix(statements[0], "CREATE OR REPLACE TABLE"))
+	require.Equal(t, "LOAD DATA INTO `ds`.`increment_t` FROM FILES (format = 'CSV', null_marker = '\\\\N', uris = ["+
+		"'gs://bucket/increment/db/t/1/CDC000001.csv', 'gs://bucket/increment/db/t/1/CDC000002.csv', 'gs://bucket/increment/db/t/1/CDC000003.csv'])", statements[1])
+	require.True(t, strings.HasPrefix(strings.TrimSpace(statements[2]), "MERGE"))
+	require.Equal(t, "DROP TABLE `ds`.`increment_t`", statements[3])
+}
+
+func TestLoadIncrementMaxStaleness(t *testing.T) {
+	uri, err := url.Parse("gs://bucket/increment")
+	require.NoError(t, err)
+	_, err = bigquerysql.NewBigQueryConnector(nil, identcase.Preserve, "increment_t", "ds", "t", uri, utils.CompressionNone,
+		&bigquerysql.BigQueryConfig{MaxStaleness: 10 * time.Minute, ConnectionID: "us.lake"})
+	require.ErrorContains(t, err, "invalid BigQuery max staleness 10m0s")
+
+	connector, err := bigquerysql.NewBigQueryConnector(nil, identcase.Preserve, "increment_t", "ds", "t", uri, utils.CompressionNone,
+		&bigquerysql.BigQueryConfig{MaxStaleness: time.Hour, ConnectionID: "us.lake"})
+	require.NoError(t, err)
+	var statements []string
+	connector.EnableDryRun(func(statement string) {
+		statements = append(statements, statement)
+	})
+	tableDef := cloudstorage.TableDefinition{
+		Schema:  "db",
+		Table:   "t",
+		Columns: []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "v", Tp: "INT"}},
+	}
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000001.csv"))
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000002.csv"))
+
+	// the external table is created once over the files of the table version, and read by the files merged
+	require.Len(t, statements, 5)
+	require.True(t, strings.HasPrefix(statements[0], "CREATE OR REPLACE EXTERNAL TABLE `ds`.`increment_t`"))
+	require.Contains(t, statements[0], "uris = ['gs://bucket/increment/db/t/1/*.csv']")
+	require.Equal(t, "CALL BQ.REFRESH_EXTERNAL_METADATA_CACHE('ds.increment_t')", statements[1])
+	require.Contains(t, statements[2], "WHERE _FILE_NAME IN ('gs://bucket/increment/db/t/1/CDC000001.csv')")
+	require.Equal(t, "CALL BQ.REFRESH_EXTERNAL_METADATA_CACHE('ds.increment_t')", statements[3])
+	require.Contains(t, statements[4], "WHERE _FILE_NAME IN ('gs://bucket/increment/db/t/1/CDC000002.csv')")
+
+	// the files of another table version are read by another external table
+	statements = nil
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/2/CDC000001.csv"))
+	require.Len(t, statements, 3)
+	require.Contains(t, statements[0], "uris = ['gs://bucket/increment/db/t/2/*.csv']")
+
+	statements = nil
+	connector.Close()
+	require.Equal(t, []string{"DROP TABLE `ds`.`increment_t`"}, statements)
+}
+
+func TestMergeDeferred(t *testing.T) {
+	uri, err := url.Parse("gs://bucket/increment")
+	require.NoError(t, err)
+	connector, err := bigquerysql.NewBigQueryConnector(nil, identcase.Preserve, "increment_t", "ds", "t", uri, utils.CompressionNone,
+		&bigquerysql.BigQueryConfig{MergeInterval: 100 * time.Millisecond})
+	require.NoError(t, err)
+	var statements []string
+	connector.EnableDryRun(func(statement string) {
+		statements = append(statements, statement)
+	})
+	tableDef := cloudstorage.TableDefinition{
+		Schema:  "db",
+		Table:   "t",
+		Columns: []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "v", Tp: "INT"}},
+	}
+	isMerge := func(statement string) bool { return strings.HasPrefix(strings.TrimSpace(statement), "MERGE") }
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000001.csv"))
+	require.True(t, slices.ContainsFunc(statements, isMerge))
+
+	// the merge of the next file is deferred by the merge interval, until a round without new files flushes it
+	statements = nil
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000002.csv"))
+	require.NoError(t, connector.MergeDeferred())
+	require.False(t, slices.ContainsFunc(statements, isMerge))
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, connector.MergeDeferred())
+	require.True(t, slices.ContainsFunc(statements, isMerge))
+	require.Equal(t, "DROP TABLE `ds`.`increment_t`", statements[len(statements)-1])
+
+	// nothing is left to merge
+	statements = nil
+	require.NoError(t, connector.MergeDeferred())
+	require.Empty(t, statements)
+}
+
+func TestDDLActionClasses(t *testing.T) {
+	connector := &bigquerysql.BigQueryConnector{}
+	require.Equal(t, ddltest.DefaultHandlings(map[string]tidbsql.DDLHandling{
+		"DROP DATABASE test": tidbsql.DDLHandlingPause,
+	}), ddltest.Handlings(connector.DDLActionClasses()))
+}
+
+// fakeBigQuery serves the query and job APIs used by the connector, the queries run are recorded and the SELECT
+// queries are answered by rows of a single column
+type fakeBigQuery struct {
+	mu      sync.Mutex
+	queries []string
+	jobs    map[string]map[string]any
+	rows    func(query string) []string
+}
+
+func (f *fakeBigQuery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	path := r.URL.Path
+	reply := func(v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/queries"):
+		query := body["query"].(string)
+		f.queries = append(f.queries, query)
+		fieldType := "STRING"
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			fieldType = "INTEGER"
+		}
+		rows := make([]any, 0)
+		for _, value := range f.rows(query) {
+			rows = append(rows, map[string]any{"f": []any{map[string]any{"v": value}}})
+		}
+		reply(map[string]any{
+			"jobComplete":  true,
+			"jobReference": map[string]any{"projectId": "p", "jobId": fmt.Sprintf("q%d", len(f.queries))},
+			"schema":       map[string]any{"fields": []any{map[string]any{"name": "f0_", "type": fieldType}}},
+			"rows":         rows,
+			"totalRows":    strconv.Itoa(len(rows)),
+		})
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/jobs"):
+		if config, ok := body["configuration"].(map[string]any)["query"].(map[string]any); ok {
+			f.queries = append(f.queries, config["query"].(string))
+		}
+		id := body["jobReference"].(map[string]any)["jobId"].(string)
+		body["status"] = map[string]any{"state": "DONE"}
+		body["statistics"] = map[string]any{"query": map[string]any{"numDmlAffectedRows": "1"}}
+		f.jobs[id] = body
+		reply(body)
+	case r.Method == http.MethodGet && strings.Contains(path, "/jobs/"):
+		reply(f.jobs[path[strings.LastIndex(path, "/")+1:]])
+	case r.Method == http.MethodGet && strings.Contains(path, "/queries/"):
+		reply(map[string]any{
+			"jobComplete":  true,
+			"jobReference": map[string]any{"projectId": "p", "jobId": path[strings.LastIndex(path, "/")+1:]},
+			"schema":       map[string]any{"fields": []any{}},
+			"totalRows":    "0",
+		})
+	case r.Method == http.MethodGet && strings.Contains(path, "/datasets/"):
+		reply(map[string]any{"datasetReference": map[string]any{"projectId": "p", "datasetId": "ds"}})
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMergeByPartitions(t *testing.T) {
+	fake := &fakeBigQuery{jobs: make(map[string]map[string]any)}
+	fake.rows = func(query string) []string {
+		switch {
+		case strings.HasPrefix(query, "SELECT COUNT(*)"):
+			return []string{"250"}
+		case strings.HasPrefix(query, "SELECT batch_id"):
+			// partition 0 of 3 is merged before, partition 1 of 2 is of another --max-merge-rows
+			return []string{"b1/3/0", "b1/2/1"}
+		}
+		return nil
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client, err := bigquery.NewClient(context.Background(), "p", option.WithEndpoint(server.URL), option.WithoutAuthentication())
+	require.NoError(t, err)
+	defer client.Close()
+
+	uri, err := url.Parse("gs://bucket/increment")
+	require.NoError(t, err)
+	connector, err := bigquerysql.NewBigQueryConnector(client, identcase.Preserve, "increment_t", "ds", "t", uri, utils.CompressionNone, &bigquerysql.BigQueryConfig{})
+	require.NoError(t, err)
+	connector.SetMaxMergeRows(100)
+	connector.SetAppliedBatch(&appliedbatch.Batch{ID: "b1", CommitTs: 1})
+	tableDef := cloudstorage.TableDefinition{
+		Schema:  "db",
+		Table:   "t",
+		Columns: []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "v", Tp: "INT"}},
+	}
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000001.csv"))
+
+	var merges, records []string
+	for _, query := range fake.queries {
+		if strings.HasPrefix(strings.TrimSpace(query), "MERGE") {
+			merges = append(merges, query)
+		}
+		if strings.HasPrefix(query, "INSERT INTO `ds`.`_tidb2dw_applied_batches`") {
+			records = append(records, query)
+		}
+	}
+	// 250 rows are merged by 3 partitions, the partition merged before is skipped
+	require.Len(t, merges, 2)
+	require.Contains(t, merges[0], "WHERE ABS(MOD(FARM_FINGERPRINT(TO_JSON_STRING(STRUCT(`id`))), 3)) = 1)")
+	require.Contains(t, merges[1], "WHERE ABS(MOD(FARM_FINGERPRINT(TO_JSON_STRING(STRUCT(`id`))), 3)) = 2)")
+	// each partition is recorded after its MERGE, then the batch
+	require.Len(t, records, 3)
+	require.Contains(t, records[0], "'b1/3/1'")
+	require.Contains(t, records[1], "'b1/3/2'")
+	require.Contains(t, records[2], "'b1'")
+	require.Equal(t, int64(2), connector.MergedRows())
+
+	// a batch within the limit is merged at once
+	fake.queries = nil
+	fake.rows = func(query string) []string {
+		if strings.HasPrefix(query, "SELECT COUNT(*)") {
+			return []string{"100"}
+		}
+		return nil
+	}
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "db/t/1/CDC000002.csv"))
+	merges = nil
+	for _, query := range fake.queries {
+		if strings.HasPrefix(strings.TrimSpace(query), "MERGE") {
+			merges = append(merges, query)
+		}
+	}
+	require.Len(t, merges, 1)
+	require.NotContains(t, merges[0], "FARM_FINGERPRINT")
+}
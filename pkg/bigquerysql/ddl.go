@@ -2,10 +2,13 @@ package bigquerysql
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	timodel "github.com/pingcap/tidb/parser/model"
@@ -13,26 +16,39 @@ import (
 	"go.uber.org/zap"
 )
 
-func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
+const (
+	// maxColumnDescriptionLength is the limit of characters of a column description
+	maxColumnDescriptionLength = 1024
+	// maxTableDescriptionLength is the limit of characters of a table description
+	maxTableDescriptionLength = 16384
+)
+
+// genDescriptionOption returns the OPTIONS clause carrying the comment as description, object
+// is the table or column logged when the comment is truncated
+func genDescriptionOption(comment string, limit int, object string) string {
+	return fmt.Sprintf("OPTIONS(description=%s)", utils.QuoteLiteral(tidbsql.TruncateComment(comment, limit, object)))
+}
+
+func (g Generator) GetColumnModifyString(diff *tidbsql.ColumnDiff, columnTypes columnmapping.Columns) (string, error) {
 	strs := make([]string, 0, 3)
 	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
-		colType, err := GetBigQueryColumnTypeString(*diff.After)
+		colType, err := GetBigQueryColumnTypeString(*diff.After, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
 		// https://cloud.google.com/bigquery/docs/reference/standard-sql/conversion_rules
-		strs = append(strs, fmt.Sprintf("%s SET DATA TYPE %s", diff.After.Name, colType))
+		strs = append(strs, fmt.Sprintf("%s SET DATA TYPE %s", g.QuoteIdent(diff.After.Name), colType))
 	}
 	if diff.Before.Default != diff.After.Default {
 		if diff.After.Default == nil {
-			strs = append(strs, fmt.Sprintf("%s DROP DEFAULT", diff.After.Name))
+			strs = append(strs, fmt.Sprintf("%s DROP DEFAULT", g.QuoteIdent(diff.After.Name)))
 		} else {
-			strs = append(strs, fmt.Sprintf("%s SET DEFAULT %s", diff.After.Name, getDefaultString(diff.After.Default)))
+			strs = append(strs, fmt.Sprintf("%s SET DEFAULT %s", g.QuoteIdent(diff.After.Name), getDefaultString(*diff.After)))
 		}
 	}
 	if diff.Before.Nullable != diff.After.Nullable {
 		if diff.After.Nullable == "true" {
-			strs = append(strs, fmt.Sprintf("%s DROP NOT NULL", diff.After.Name))
+			strs = append(strs, fmt.Sprintf("%s DROP NOT NULL", g.QuoteIdent(diff.After.Name)))
 		} else {
 			log.Warn("BigQuery does not support update column required", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Nullable), zap.Any("after", diff.After.Nullable))
 		}
@@ -40,8 +56,23 @@ func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
 	return strings.Join(strs, ", "), nil
 }
 
-func GenDDLViaColumnsDiff(datasetID, tableID string, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
-	tableFullName := fmt.Sprintf("%s.%s", datasetID, tableID)
+// appliedDDLErrors are the errors of BigQuery telling the effect of a column DDL is already present
+var appliedDDLErrors = tidbsql.AppliedDDLErrors{
+	ColumnExists:  []string{"already exists"},
+	ColumnMissing: []string{"not found"},
+}
+
+// ddlActionClasses are the classes of the DDLs in BigQuery, the dataset is not dropped with the database as it
+// may hold other tables, the table is paused instead
+var ddlActionClasses = tidbsql.WithDDLActionClasses(map[timodel.ActionType]tidbsql.DDLActionClass{
+	timodel.ActionDropSchema: tidbsql.DDLActionMustPause,
+})
+
+// GenDDLViaColumnsDiff generates the DDLs changing the columns of the table from prevColumns to the columns of
+// curTableDef, a column partitioning or clustering the table by layout is not dropped. A table created has the
+// tombstone columns of the delete mode.
+func (g Generator) GenDDLViaColumnsDiff(datasetID, tableID string, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) ([]string, error) {
+	tableFullName := g.quoteTable(datasetID, tableID)
 
 	if curTableDef.Type == timodel.ActionTruncateTable {
 		return []string{fmt.Sprintf("TRUNCATE TABLE %s", tableFullName)}, nil
@@ -50,14 +81,20 @@ func GenDDLViaColumnsDiff(datasetID, tableID string, prevColumns []cloudstorage.
 		return []string{fmt.Sprintf("DROP TABLE %s", tableFullName)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
+		// the table is created after the changefeed starts, its columns are given by the schema file
+		ddl, err := g.GenCreateSchema(curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns), datasetID, tableID, nil, columnTypes, layout, deleteMode)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{ddl}, nil
 	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		// the new name of a BigQuery table is not qualified by the dataset
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tableFullName, g.QuoteIdent(curTableDef.Table))}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropSchema {
-		return nil, errors.New("Received drop schema ddl, which does not support") // FIXME: drop schema and create schema
+		// the table is paused by ddlActionClasses before
+		return nil, tidbsql.NewUnsupportedDDLError("Received drop schema ddl, which does not support")
 	}
 	if curTableDef.Type == timodel.ActionCreateSchema {
 		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
@@ -72,69 +109,88 @@ func GenDDLViaColumnsDiff(datasetID, tableID string, prevColumns []cloudstorage.
 		ddl := ""
 		switch item.Action {
 		case tidbsql.ADD_COLUMN:
+			// BigQuery adds no REQUIRED column, the column is added as nullable and the existing rows are filled
+			// with the value filled by TiDB
+			column := tidbsql.WithAddedColumnDefault(curTableDef.Table, *item.After)
+			if column.Nullable == "false" {
+				log.Warn("BigQuery does not add a NOT NULL column, the column is added as nullable",
+					zap.String("table", curTableDef.Table), zap.String("column", column.Name))
+				column.Nullable = "true"
+			}
 			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", tableFullName)
-			colStr, err := GetBigQueryColumnString(*item.After, false)
+			colStr, err := g.GetBigQueryColumnString(column, false, columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			ddl += colStr + ";"
 			ddls = append(ddls, ddl)
 			if item.After.Default != nil {
-				ddls = append(
-					ddls,
-					fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", tableFullName, item.After.Name, getDefaultString(item.After.Default)),
-					fmt.Sprintf("UPDATE %s SET %s = %s WHERE TRUE;", tableFullName, item.After.Name, getDefaultString(item.After.Default)),
-				)
-
+				ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", tableFullName, g.QuoteIdent(item.After.Name), getDefaultString(*item.After)))
 			} else if item.After.Nullable == "true" {
-				ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT NULL;", tableFullName, item.After.Name))
+				ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT NULL;", tableFullName, g.QuoteIdent(item.After.Name)))
+			}
+			if column.Default != nil {
+				ddls = append(ddls, fmt.Sprintf("UPDATE %s SET %s = %s WHERE TRUE;", tableFullName, g.QuoteIdent(column.Name), getDefaultString(column)))
 			}
 		case tidbsql.DROP_COLUMN:
-			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", tableFullName, item.Before.Name))
+			if err := layout.CheckDropColumn(item.Before.Name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", tableFullName, g.QuoteIdent(item.Before.Name)))
 		case tidbsql.MODIFY_COLUMN:
 			ddl += fmt.Sprintf("ALTER TABLE %s ALTER COLUMN ", tableFullName)
-			modifyStr, err := GetColumnModifyString(&item)
+			modifyStr, err := g.GetColumnModifyString(&item, columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			ddl += modifyStr + ";"
 			ddls = append(ddls, ddl)
 		case tidbsql.RENAME_COLUMN:
-			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", tableFullName, item.Before.Name, item.After.Name))
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", tableFullName, g.QuoteIdent(item.Before.Name), g.QuoteIdent(item.After.Name)))
 		default:
 			// UNCHANGE
 		}
 	}
 
+	changes := &tidbsql.CommentChanges{}
+	if !g.skipComments {
+		changes = tidbsql.GetCommentChanges(curTableDef)
+	}
+	if changes.Table != nil {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s SET %s;", tableFullName, genDescriptionOption(*changes.Table, maxTableDescriptionLength, tableFullName)))
+	}
+	for _, column := range curTableDef.Columns {
+		if comment, ok := changes.Columns[column.Name]; ok {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET %s;", tableFullName, g.QuoteIdent(column.Name), genDescriptionOption(comment, maxColumnDescriptionLength, column.Name)))
+		}
+	}
+
 	// TODO: handle primary key
 	return ddls, nil
 }
 
-func getDefaultString(val interface{}) string {
-	_, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
-	if err != nil {
-		return fmt.Sprintf("'%v'", val) // FIXME: escape
-	}
-	return fmt.Sprintf("%v", val)
+// getDefaultString returns the literal of the default value of the column
+func getDefaultString(column cloudstorage.TableCol) string {
+	return tidbsql.DefaultLiteral(column, utils.QuoteLiteral)
 }
 
 // GetBigQueryColumnString returns a string describing the column in BigQuery, e.g.
-// "id INT NOT NULL DEFAULT '0'"
+// "`id` INT NOT NULL DEFAULT '0'"
 // Refer to:
 // https://dev.mysql.com/doc/refman/8.0/en/data-types.html
-func GetBigQueryColumnString(column cloudstorage.TableCol, createTable bool) (string, error) {
+func (g Generator) GetBigQueryColumnString(column cloudstorage.TableCol, createTable bool, columnTypes columnmapping.Columns) (string, error) {
 	var sb strings.Builder
-	colType, err := GetBigQueryColumnTypeString(column)
+	colType, err := GetBigQueryColumnTypeString(column, columnTypes)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
-	sb.WriteString(fmt.Sprintf("%s %s", column.Name, colType))
+	sb.WriteString(fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), colType))
 	if column.Nullable == "false" {
 		sb.WriteString(" NOT NULL")
 	}
 	if createTable {
 		if column.Default != nil {
-			sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column.Default)))
+			sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column)))
 		} else if column.Nullable == "true" {
 			sb.WriteString(" DEFAULT NULL")
 		}
@@ -0,0 +1,86 @@
+package bigquerysql
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"google.golang.org/api/googleapi"
+)
+
+// NormalizeBigQueryType returns the type by its name in GoogleSQL, so that the synonyms and the names of the legacy
+// SQL of the table schemas are equal, e.g. INT64 for INTEGER and BOOL for BOOLEAN
+func NormalizeBigQueryType(tp string) string {
+	name, params := tidbsql.SplitType(tp)
+	if !slices.ContainsFunc(params, func(p string) bool { return p != "" }) {
+		params = nil
+	}
+	switch name {
+	case "INT64", "INTEGER", "INT", "SMALLINT", "BIGINT", "TINYINT", "BYTEINT":
+		name = "INT64"
+	case "FLOAT64", "FLOAT":
+		name = "FLOAT64"
+	case "BOOL", "BOOLEAN":
+		name = "BOOL"
+	case "NUMERIC", "DECIMAL":
+		name = "NUMERIC"
+	case "BIGNUMERIC", "BIGDECIMAL":
+		name = "BIGNUMERIC"
+	case "STRUCT", "RECORD":
+		name = "STRUCT"
+	}
+	if len(params) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// getBigQueryColumnType returns the normalized type of the column in BigQuery, empty if the type is not compared
+func getBigQueryColumnType(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	if _, ok := columnTypes.Lookup(column.Name); !ok && (tp == "enum" || tp == "set") && column.Precision == "" {
+		// the schema files do not give the length of ENUM and SET, which is known when the table is copied
+		return "", nil
+	}
+	typeStr, err := GetBigQueryColumnTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return NormalizeBigQueryType(typeStr), nil
+}
+
+// GetWarehouseColumns returns the columns of the table in the dataset of BigQuery with their normalized types
+func (g Generator) GetWarehouseColumns(ctx context.Context, client *bigquery.Client, datasetID, tableID string) ([]tidbsql.WarehouseColumn, error) {
+	meta, err := client.Dataset(datasetID).Table(g.identifierCase.Apply(tableID)).Metadata(ctx)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if stderrors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil, errors.Annotatef(tidbsql.ErrWarehouseTableNotFound, "table %s is not found in BigQuery", tableID)
+		}
+		return nil, errors.Trace(err)
+	}
+	columns := make([]tidbsql.WarehouseColumn, 0, len(meta.Schema))
+	for _, field := range meta.Schema {
+		tp := string(field.Type)
+		switch {
+		case field.Precision > 0:
+			tp = fmt.Sprintf("%s(%d,%d)", tp, field.Precision, field.Scale)
+		case field.MaxLength > 0:
+			tp = fmt.Sprintf("%s(%d)", tp, field.MaxLength)
+		}
+		columns = append(columns, tidbsql.WarehouseColumn{
+			Name:     field.Name,
+			Type:     NormalizeBigQueryType(tp),
+			Nullable: !field.Required,
+		})
+	}
+	return columns, nil
+}
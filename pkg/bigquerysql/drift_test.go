@@ -0,0 +1,58 @@
+package bigquerysql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeBigQueryType(t *testing.T) {
+	for _, synonyms := range [][]string{
+		{"INT64", "INTEGER", "int"},
+		{"FLOAT64", "FLOAT"},
+		{"BOOL", "BOOLEAN"},
+		{"NUMERIC(10,2)", "numeric(10, 2)", "DECIMAL(10,2)"},
+		{"BIGNUMERIC(40,2)", "BIGDECIMAL(40, 2)"},
+		{"STRING(20)", "string(20)"},
+		{"STRING", "STRING()"},
+	} {
+		for _, tp := range synonyms {
+			require.Equal(t, synonyms[0], bigquerysql.NormalizeBigQueryType(tp), tp)
+		}
+	}
+}
+
+func TestReconcileSchemaDDLs(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "BIGINT", Nullable: "false"},
+		{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "20"},
+		{ID: "3", Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}
+	actual := []tidbsql.WarehouseColumn{
+		{Name: "id", Type: "INT64"},
+		{Name: "name", Type: "INT64", Nullable: true},
+		{Name: "indexed", Type: "STRING", Nullable: true},
+	}
+	drift, err := tidbsql.GetSchemaDrift(expected, actual, func(column cloudstorage.TableCol) (string, error) {
+		tp, err := bigquerysql.GetBigQueryColumnTypeString(column, nil)
+		return bigquerysql.NormalizeBigQueryType(tp), err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "column amount: missing in the data warehouse, expected NUMERIC(10,2)\n"+
+		"column indexed: STRING in the data warehouse, not expected\n"+
+		"column name: INT64 in the data warehouse, expected STRING", drift.String())
+	ddls, err := gen.GenDDLViaColumnsDiff("ds", "t", drift.Columns, cloudstorage.TableDefinition{Table: "t", Columns: drift.Expected}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"ALTER TABLE `ds`.`t` ADD COLUMN `amount` NUMERIC(10, 2);",
+		"ALTER TABLE `ds`.`t` DROP COLUMN `indexed`;",
+		"ALTER TABLE `ds`.`t` ALTER COLUMN `name` SET DATA TYPE STRING;",
+	}, ddls)
+}
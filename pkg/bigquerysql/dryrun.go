@@ -0,0 +1,95 @@
+package bigquerysql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+)
+
+// EnableDryRun renders the queries and the jobs of the connector by record instead of running them,
+// the BigQuery client may be nil. The load and delete jobs are rendered as their SQL equivalents.
+func (bc *BigQueryConnector) EnableDryRun(record func(statement string)) {
+	bc.dryRun = record
+}
+
+// EnableAuditLog logs the queries and the jobs run by the connector under the table, the jobs are logged as their
+// SQL equivalents like in a dry run
+func (bc *BigQueryConnector) EnableAuditLog(logger *sqlaudit.Logger, table string) {
+	bc.auditLog = logger
+	bc.auditScope = sqlaudit.NewScope(table)
+}
+
+// SetAuditScope labels the statements of the connector in the SQL audit log, if it is enabled
+func (bc *BigQueryConnector) SetAuditScope(batchID string, schemaVersion uint64) {
+	if bc.auditScope != nil {
+		bc.auditScope.Set(batchID, schemaVersion)
+	}
+}
+
+func (bc *BigQueryConnector) audit(start time.Time, statement string, rowsAffected int64, err error) {
+	if bc.auditLog != nil {
+		bc.auditLog.LogStatement(bc.auditScope, start, statement, rowsAffected, err)
+	}
+}
+
+func (bc *BigQueryConnector) runQuery(query string) error {
+	_, err := bc.runQueryWithStatistics(query)
+	return err
+}
+
+func (bc *BigQueryConnector) runQueryWithStatistics(query string) (*bigquery.QueryStatistics, error) {
+	if bc.dryRun != nil {
+		bc.dryRun(query)
+		return nil, nil
+	}
+	start := time.Now()
+	stats, err := runQueryWithStatistics(bc.ctx, bc.bqClient, query)
+	rowsAffected := int64(-1)
+	if stats != nil {
+		rowsAffected = stats.NumDMLAffectedRows
+	}
+	bc.audit(start, query, rowsAffected, err)
+	return stats, err
+}
+
+func (bc *BigQueryConnector) loadFiles(tableID string, gcsFilePaths []string, maxBadRecords int64) (badrows.Rejects, error) {
+	if bc.dryRun != nil {
+		bc.dryRun(bc.gen.GenLoadData(bc.datasetID, tableID, gcsFilePaths))
+		return badrows.Rejects{}, nil
+	}
+	start := time.Now()
+	rejects, err := bc.gen.loadGCSFileToBigQuery(bc.ctx, bc.bqClient, bc.datasetID, tableID, gcsFilePaths, bigquery.WriteAppend, maxBadRecords)
+	bc.audit(start, bc.gen.GenLoadData(bc.datasetID, tableID, gcsFilePaths), -1, err)
+	return rejects, err
+}
+
+func (bc *BigQueryConnector) deleteTable(tableID string) error {
+	if bc.dryRun != nil {
+		bc.dryRun(fmt.Sprintf("DROP TABLE %s", bc.gen.quoteTable(bc.datasetID, tableID)))
+		return nil
+	}
+	start := time.Now()
+	err := bc.gen.deleteTable(bc.ctx, bc.bqClient, bc.datasetID, tableID)
+	bc.audit(start, fmt.Sprintf("DROP TABLE %s", bc.gen.quoteTable(bc.datasetID, tableID)), -1, err)
+	return err
+}
+
+// GenLoadData returns the LOAD DATA statement equivalent to the load job appending the CSV or Parquet files to the table
+func (g Generator) GenLoadData(datasetID, tableID string, gcsFilePaths []string) string {
+	uris := make([]string, 0, len(gcsFilePaths))
+	for _, path := range gcsFilePaths {
+		uris = append(uris, utils.QuoteLiteral(path))
+	}
+	options := "format = 'CSV', null_marker = '\\\\N'"
+	if stagingformat.FileFormat(gcsFilePaths[0]) == stagingformat.Parquet {
+		options = "format = 'PARQUET'"
+	}
+	return fmt.Sprintf("LOAD DATA INTO %s FROM FILES (%s, uris = [%s])",
+		g.quoteTable(datasetID, tableID), options, strings.Join(uris, ", "))
+}
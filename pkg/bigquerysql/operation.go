@@ -2,29 +2,63 @@ package bigquerysql
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 )
 
 func runQuery(ctx context.Context, client *bigquery.Client, query string) error {
+	_, err := runQueryWithStatistics(ctx, client, query)
+	return err
+}
+
+// runQueryWithStatistics runs the query and returns the statistics of the finished job,
+// the statistics may be nil if BigQuery does not report them.
+func runQueryWithStatistics(ctx context.Context, client *bigquery.Client, query string) (*bigquery.QueryStatistics, error) {
 	job, err := client.Query(query).Run(ctx)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, diag.WrapSQL(err, query)
 	}
 	status, err := job.Wait(ctx)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, diag.WrapSQL(err, query)
 	}
 	if status.Err() != nil {
-		return errors.Trace(fmt.Errorf("Bigquery job completed with error: %v", status.Err()))
+		return nil, diag.WrapSQL(fmt.Errorf("Bigquery job completed with error: %w", status.Err()), query)
 	}
-	return nil
+	if status.Statistics != nil {
+		if stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+			return stats, nil
+		}
+	}
+	return nil, nil
 }
 
-func deleteTable(ctx context.Context, client *bigquery.Client, datasetID, tableID string) error {
-	tableRef := client.Dataset(datasetID).Table(tableID)
+// DropTable drops the table in the dataset if it exists
+func (g Generator) DropTable(ctx context.Context, client *bigquery.Client, datasetID, tableID string) error {
+	return runQuery(ctx, client, fmt.Sprintf("DROP TABLE IF EXISTS %s", g.quoteTable(datasetID, tableID)))
+}
+
+// DropExternalTable drops the external table in the dataset if it exists
+func (g Generator) DropExternalTable(ctx context.Context, client *bigquery.Client, datasetID, tableID string) error {
+	return runQuery(ctx, client, fmt.Sprintf("DROP EXTERNAL TABLE IF EXISTS %s", g.quoteTable(datasetID, tableID)))
+}
+
+func (g Generator) deleteTable(ctx context.Context, client *bigquery.Client, datasetID, tableID string) error {
+	tableRef := client.Dataset(datasetID).Table(g.identifierCase.Apply(tableID))
 	err := tableRef.Delete(ctx)
 	if err != nil {
 		return errors.Trace(err)
@@ -32,24 +66,173 @@ func deleteTable(ctx context.Context, client *bigquery.Client, datasetID, tableI
 	return nil
 }
 
-func loadGCSFileToBigQuery(ctx context.Context, client *bigquery.Client, datasetID, tableID, gcsFilePath string) error {
-	gcsRef := bigquery.NewGCSReference(gcsFilePath)
-	gcsRef.SourceFormat = bigquery.CSV
-	gcsRef.NullMarker = "\\N"
+// loadGCSFileToBigQuery loads the files into the table by a load job, up to maxBadRecords rows failing to be parsed
+// are skipped and returned. The files are CSV or Parquet by their extension.
+func (g Generator) loadGCSFileToBigQuery(ctx context.Context, client *bigquery.Client, datasetID, tableID string, gcsFilePaths []string, writeDisposition bigquery.TableWriteDisposition, maxBadRecords int64) (badrows.Rejects, error) {
+	gcsRef := bigquery.NewGCSReference(gcsFilePaths...)
+	if stagingformat.FileFormat(gcsFilePaths[0]) == stagingformat.Parquet {
+		gcsRef.SourceFormat = bigquery.Parquet
+	} else {
+		gcsRef.SourceFormat = bigquery.CSV
+		gcsRef.NullMarker = "\\N"
+	}
+	gcsRef.MaxBadRecords = maxBadRecords
 
-	loader := client.Dataset(datasetID).Table(tableID).LoaderFrom(gcsRef)
-	loader.WriteDisposition = bigquery.WriteEmpty
+	loader := client.Dataset(datasetID).Table(g.identifierCase.Apply(tableID)).LoaderFrom(gcsRef)
+	loader.WriteDisposition = writeDisposition
 
 	job, err := loader.Run(ctx)
 	if err != nil {
-		return errors.Trace(err)
+		return badrows.Rejects{}, errors.Trace(err)
 	}
 	status, err := job.Wait(ctx)
 	if err != nil {
-		return errors.Trace(err)
+		return badrows.Rejects{}, errors.Trace(err)
 	}
 	if status.Err() != nil {
-		return errors.Trace(fmt.Errorf("Bigquery load snapshot job completed with error: %v", status.Err()))
+		if detail := formatLoadErrors(status.Errors); detail != "" {
+			return badrows.Rejects{}, errors.Trace(fmt.Errorf("Bigquery load job completed with error: %w, %s", status.Err(), detail))
+		}
+		return badrows.Rejects{}, errors.Trace(fmt.Errorf("Bigquery load job completed with error: %w", status.Err()))
 	}
-	return nil
+	return loadRejects(status.Errors), nil
+}
+
+var (
+	// loadErrorLinePattern and loadErrorFieldPattern find the line and the column of a bad row in its error
+	loadErrorLinePattern  = regexp.MustCompile(`line_number: (\d+)`)
+	loadErrorFieldPattern = regexp.MustCompile(`for field (\w+)`)
+)
+
+// loadRejects returns the rows skipped by a load job succeeded with MaxBadRecords, BigQuery reports an error of each
+// of them with the file it is found in as the location
+func loadRejects(loadErrors []*bigquery.Error) badrows.Rejects {
+	var rejects badrows.Rejects
+	for _, loadErr := range loadErrors {
+		if loadErr == nil || loadErr.Message == "" {
+			continue
+		}
+		row := badrows.Row{File: loadErr.Location, Reason: loadErr.Message}
+		if match := loadErrorLinePattern.FindStringSubmatch(loadErr.Message); match != nil {
+			row.Line, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		if match := loadErrorFieldPattern.FindStringSubmatch(loadErr.Message); match != nil {
+			row.Column = match[1]
+		}
+		rejects.Count++
+		rejects.Rows = append(rejects.Rows, row)
+	}
+	return rejects
+}
+
+// maxReportedLoadErrors is the max number of the errors of the rows of a load job reported
+const maxReportedLoadErrors = 10
+
+// formatLoadErrors returns the errors of the rows of a failed load job, each with the file it is found in as the
+// location and the line as told by the message, empty if there is none
+func formatLoadErrors(loadErrors []*bigquery.Error) string {
+	details := make([]string, 0, min(len(loadErrors), maxReportedLoadErrors))
+	for i, loadErr := range loadErrors {
+		if loadErr == nil || loadErr.Message == "" {
+			continue
+		}
+		if len(details) == maxReportedLoadErrors {
+			details = append(details, fmt.Sprintf("and %d more", len(loadErrors)-i))
+			break
+		}
+		if loadErr.Location != "" {
+			details = append(details, fmt.Sprintf("%s: %s", loadErr.Location, loadErr.Message))
+		} else {
+			details = append(details, loadErr.Message)
+		}
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	return "errors of the rows: " + strings.Join(details, "; ")
+}
+
+// ensureDataset creates the dataset if create and it does not exist, otherwise it fails if the dataset does not
+// exist. The dataset is created in the location of defaultDatasetID, so that its tables can be queried together
+// with the tables of the default dataset.
+func ensureDataset(ctx context.Context, client *bigquery.Client, datasetID, defaultDatasetID string, create bool) error {
+	_, err := client.Dataset(datasetID).Metadata(ctx)
+	var apiErr *googleapi.Error
+	if err == nil || !stderrors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return errors.Trace(err)
+	}
+	if !create {
+		return errors.Errorf("BigQuery dataset %s is not found, create it or set --create-target-schema", datasetID)
+	}
+	var location string
+	if defaultDatasetID != "" && defaultDatasetID != datasetID {
+		meta, err := client.Dataset(defaultDatasetID).Metadata(ctx)
+		if err != nil {
+			return errors.Annotatef(err, "Failed to get the location of BigQuery dataset %s", defaultDatasetID)
+		}
+		location = meta.Location
+	}
+	log.Info("Creating BigQuery dataset", zap.String("dataset", datasetID), zap.String("location", location))
+	err = client.Dataset(datasetID).Create(ctx, &bigquery.DatasetMetadata{Location: location})
+	if stderrors.As(err, &apiErr) && apiErr.Code == http.StatusConflict {
+		// the dataset is created by the connector of another table
+		return nil
+	}
+	return errors.Annotatef(err, "Failed to create BigQuery dataset %s", datasetID)
+}
+
+// getPartitionColumn returns the column the table is partitioned on,
+// returns empty string if the table is not partitioned or partitioned by ingestion time.
+func (g Generator) getPartitionColumn(ctx context.Context, client *bigquery.Client, datasetID, tableID string) (string, error) {
+	meta, err := client.Dataset(datasetID).Table(g.identifierCase.Apply(tableID)).Metadata(ctx)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if meta.TimePartitioning != nil {
+		return meta.TimePartitioning.Field, nil
+	}
+	if meta.RangePartitioning != nil {
+		return meta.RangePartitioning.Field, nil
+	}
+	return "", nil
+}
+
+// getTableColumns returns the names of the columns of the table, found is false if the table does not exist
+func (g Generator) getTableColumns(ctx context.Context, client *bigquery.Client, datasetID, tableID string) (columns []string, found bool, err error) {
+	meta, err := client.Dataset(datasetID).Table(g.identifierCase.Apply(tableID)).Metadata(ctx)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if stderrors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, errors.Trace(err)
+	}
+	for _, field := range meta.Schema {
+		columns = append(columns, field.Name)
+	}
+	return columns, true, nil
+}
+
+// queryColumnRange returns the min and max value of the column in the source as strings, the source is a table or
+// a subquery. ok is false if the source is empty or the column contains NULL values,
+// in which case the range can not be used to prune partitions.
+func (g Generator) queryColumnRange(ctx context.Context, client *bigquery.Client, source, column string) (min, max string, ok bool, err error) {
+	query := fmt.Sprintf(
+		"SELECT COUNTIF(%s IS NULL), CAST(MIN(%s) AS STRING), CAST(MAX(%s) AS STRING) FROM %s",
+		g.QuoteIdent(column), g.QuoteIdent(column), g.QuoteIdent(column), source)
+	it, err := client.Query(query).Read(ctx)
+	if err != nil {
+		return "", "", false, errors.Trace(err)
+	}
+	var row []bigquery.Value
+	if err = it.Next(&row); err != nil {
+		if err == iterator.Done {
+			return "", "", false, nil
+		}
+		return "", "", false, errors.Trace(err)
+	}
+	if nullCount, _ := row[0].(int64); nullCount > 0 || row[1] == nil || row[2] == nil {
+		return "", "", false, nil
+	}
+	return row[1].(string), row[2].(string), true, nil
 }
@@ -0,0 +1,96 @@
+package bigquerysql
+
+import (
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatLoadErrors(t *testing.T) {
+	require.Equal(t, "", formatLoadErrors(nil))
+	require.Equal(t, "errors of the rows: gs://bucket/t/CDC000002.csv: Error while reading data, error message: Could not parse 'x' as INT64; line_number: 3; CSV table encountered too many errors",
+		formatLoadErrors([]*bigquery.Error{
+			{Location: "gs://bucket/t/CDC000002.csv", Message: "Error while reading data, error message: Could not parse 'x' as INT64; line_number: 3"},
+			{Message: "CSV table encountered too many errors"},
+		}))
+
+	loadErrors := make([]*bigquery.Error, 0, maxReportedLoadErrors+5)
+	for i := 0; i < maxReportedLoadErrors+5; i++ {
+		loadErrors = append(loadErrors, &bigquery.Error{Location: "gs://bucket/t/CDC000001.csv", Message: fmt.Sprintf("line_number: %d", i+1)})
+	}
+	formatted := formatLoadErrors(loadErrors)
+	require.Contains(t, formatted, fmt.Sprintf("line_number: %d; and 5 more", maxReportedLoadErrors))
+}
+
+func TestLoadRejects(t *testing.T) {
+	rejects := loadRejects([]*bigquery.Error{
+		{Location: "gs://bucket/t/CDC000002.csv", Message: "Error while reading data, error message: Could not parse 'x' as INT64 for field id (position 4) starting at location 128; line_number: 3"},
+		{Location: "gs://bucket/t/CDC000003.csv", Message: "Error while reading data, error message: CSV table references column position 6, but line contains only 5 columns."},
+		{Message: ""},
+	})
+	require.Equal(t, int64(2), rejects.Count)
+	require.Equal(t, "gs://bucket/t/CDC000002.csv", rejects.Rows[0].File)
+	require.Equal(t, int64(3), rejects.Rows[0].Line)
+	require.Equal(t, "id", rejects.Rows[0].Column)
+	require.Equal(t, int64(0), rejects.Rows[1].Line)
+	require.Equal(t, "", rejects.Rows[1].Column)
+}
+
+func TestGetPartitionRange(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{Columns: []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "Created_At", Tp: "DATE"},
+		{Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}}
+	var queried []string
+	queryRange := func(column string) (string, string, bool, error) {
+		queried = append(queried, column)
+		return "2024-01-01", "2024-01-31", true, nil
+	}
+	bc := &BigQueryConnector{partitionPruning: true, partitionColumnLoaded: true, partitionColumn: "created_at"}
+	// the partitioning column is matched case-insensitively
+	partitionRange, err := bc.getPartitionRange(tableDef, queryRange)
+	require.NoError(t, err)
+	require.Equal(t, &PartitionRange{Column: "Created_At", Lower: "DATE '2024-01-01'", Upper: "DATE '2024-01-31'"}, partitionRange)
+	require.Equal(t, []string{"Created_At"}, queried)
+
+	bc.partitionColumn = "id"
+	partitionRange, err = bc.getPartitionRange(tableDef, func(column string) (string, string, bool, error) { return "3", "42", true, nil })
+	require.NoError(t, err)
+	require.Equal(t, &PartitionRange{Column: "id", Lower: "3", Upper: "42"}, partitionRange)
+	bc.partitionColumn = "amount"
+	partitionRange, err = bc.getPartitionRange(tableDef, func(column string) (string, string, bool, error) { return "1.5", "20", true, nil })
+	require.NoError(t, err)
+	require.Equal(t, &PartitionRange{Column: "amount", Lower: "CAST('1.5' AS NUMERIC(10, 2))", Upper: "CAST('20' AS NUMERIC(10, 2))"}, partitionRange)
+
+	// a batch with NULL in the partitioning column is not pruned
+	partitionRange, err = bc.getPartitionRange(tableDef, func(column string) (string, string, bool, error) { return "", "", false, nil })
+	require.NoError(t, err)
+	require.Nil(t, partitionRange)
+	_, err = bc.getPartitionRange(tableDef, func(column string) (string, string, bool, error) { return "", "", false, errors.New("quota exceeded") })
+	require.ErrorContains(t, err, "quota exceeded")
+
+	// the table is partitioned on a column not in the batch, or not partitioned
+	queried = nil
+	for _, column := range []string{"updated_at", ""} {
+		bc.partitionColumn = column
+		partitionRange, err = bc.getPartitionRange(tableDef, queryRange)
+		require.NoError(t, err)
+		require.Nil(t, partitionRange)
+	}
+	// the pruning is disabled, or the range is not queried in a dry run
+	bc.partitionColumn = "created_at"
+	bc.partitionPruning = false
+	partitionRange, err = bc.getPartitionRange(tableDef, queryRange)
+	require.NoError(t, err)
+	require.Nil(t, partitionRange)
+	bc.partitionPruning, bc.dryRun = true, func(string) {}
+	partitionRange, err = bc.getPartitionRange(tableDef, queryRange)
+	require.NoError(t, err)
+	require.Nil(t, partitionRange)
+	require.Empty(t, queried)
+}
@@ -0,0 +1,41 @@
+package bigquerysql
+
+import (
+	stderrors "errors"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"google.golang.org/api/googleapi"
+)
+
+// retryableReasons are the reasons of the errors of BigQuery which are transient
+var retryableReasons = map[string]bool{
+	"rateLimitExceeded":    true,
+	"jobRateLimitExceeded": true,
+	"backendError":         true,
+	"internalError":        true,
+}
+
+// IsRetryableError tells whether the job which failed with err may succeed if it is run again, e.g. it exceeded a
+// rate limit. A failed job is run again as a new job.
+func IsRetryableError(err error) bool {
+	var apiErr *googleapi.Error
+	if stderrors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		for _, item := range apiErr.Errors {
+			if retryableReasons[item.Reason] {
+				return true
+			}
+		}
+		return false
+	}
+	var jobErr *bigquery.Error
+	if stderrors.As(err, &jobErr) {
+		return retryableReasons[jobErr.Reason]
+	}
+	return retry.IsTransient(err)
+}
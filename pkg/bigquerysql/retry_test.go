@@ -0,0 +1,27 @@
+package bigquerysql_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	rateLimited := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}
+	require.True(t, bigquerysql.IsRetryableError(errors.Trace(rateLimited)))
+	require.True(t, bigquerysql.IsRetryableError(&googleapi.Error{Code: http.StatusServiceUnavailable}))
+	require.False(t, bigquerysql.IsRetryableError(&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "accessDenied"}}}))
+
+	// the error of a finished job
+	jobErr := diag.WrapSQL(fmt.Errorf("Bigquery job completed with error: %w", &bigquery.Error{Reason: "backendError"}), "MERGE INTO t")
+	require.True(t, bigquerysql.IsRetryableError(jobErr))
+	jobErr = diag.WrapSQL(fmt.Errorf("Bigquery job completed with error: %w", &bigquery.Error{Reason: "invalidQuery"}), "MERGE INTO t")
+	require.False(t, bigquerysql.IsRetryableError(jobErr))
+}
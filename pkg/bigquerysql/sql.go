@@ -2,45 +2,162 @@ package bigquerysql
 
 import (
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 )
 
-func GenMergeInto(tableDef cloudstorage.TableDefinition, datasetID, tableID, externalTableID string) string {
+// Generator generates the statements of BigQuery, the names of the tables and the columns are quoted in its
+// identifier case. The datasets and the connections are named as given.
+type Generator struct {
+	identifierCase identcase.Case
+	// skipComments leaves the tables and the columns without comments
+	skipComments bool
+}
+
+// NewGenerator returns the generator writing the names of the tables and the columns in the case,
+// identcase.Preserve by default as the names of the tables are case-sensitive
+func NewGenerator(identifierCase identcase.Case) Generator {
+	return Generator{identifierCase: identifierCase}
+}
+
+// WithSyncComments returns the generator replicating the comments of the tables and the columns or not
+func (g Generator) WithSyncComments(sync bool) Generator {
+	g.skipComments = !sync
+	return g
+}
+
+// QuoteIdent quotes the name of a table or a column by backticks in the identifier case, so that reserved words
+// and any characters can be used. The backticks and backslashes in the name are escaped.
+func (g Generator) QuoteIdent(name string) string {
+	return quoteName(g.identifierCase.Apply(name))
+}
+
+// quoteName quotes the name of a dataset or a connection by backticks as is
+func quoteName(name string) string {
+	return "`" + identReplacer.Replace(name) + "`"
+}
+
+var identReplacer = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+
+// quoteTable returns the quoted name of the table in the dataset
+func (g Generator) quoteTable(datasetID, tableID string) string {
+	return quoteName(datasetID) + "." + g.QuoteIdent(tableID)
+}
+
+// PartitionRange restricts the target table to the partitions touched by a batch,
+// Lower and Upper are BigQuery literals, e.g. DATE '2023-01-01'.
+type PartitionRange struct {
+	Column string
+	Lower  string
+	Upper  string
+}
+
+// genStagedSource returns the rows staged in the increment table, only the rows of the files are read from the
+// external table of --bq.max-staleness if files are given
+func (g Generator) genStagedSource(datasetID, incrementTableID string, files []string) string {
+	if len(files) == 0 {
+		return g.quoteTable(datasetID, incrementTableID)
+	}
+	literals := make([]string, 0, len(files))
+	for _, file := range files {
+		literals = append(literals, utils.QuoteLiteral(file))
+	}
+	return fmt.Sprintf("(SELECT * FROM %s WHERE _FILE_NAME IN (%s))", g.quoteTable(datasetID, incrementTableID), strings.Join(literals, ", "))
+}
+
+// GenMergeInto generates the MERGE statement from the increment table into the target table, only the rows of the
+// files are merged from the external table if files are given. If partitionRange is not nil, the ON clause is
+// restricted to the partition range so that BigQuery only scans the partitions touched by the batch. If where is
+// not empty, only the rows matching it are kept in the target table. The columns of the increment table are given
+// by StagedColumns of the format of the files. The rows deleted are deleted or marked deleted by the delete mode.
+func (g Generator) GenMergeInto(tableDef cloudstorage.TableDefinition, datasetID, tableID, externalTableID string, files []string, partitionRange *PartitionRange, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) string {
+	return g.genMergeInto(tableDef, datasetID, tableID, g.genStagedSource(datasetID, externalTableID, files), partitionRange, columnTypes, where, deleteMode, format)
+}
+
+// GenMergeIntoPartition merges the staged rows like GenMergeInto, but only of the keys whose hash falls into the
+// partition of the given number of partitions. The rows of a key are all in the same partition, so the latest row
+// of each key is the same as the one of all the staged rows.
+func (g Generator) GenMergeIntoPartition(tableDef cloudstorage.TableDefinition, datasetID, tableID, externalTableID string, files []string, partitionRange *PartitionRange, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format, partition, partitions int) string {
+	// the key is hashed as it is staged, a key is staged the same by every change of it
+	pkColumns := make([]string, 0, 1)
+	for _, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkColumns = append(pkColumns, g.QuoteIdent(col.Name))
+		}
+	}
+	// MOD before ABS, ABS overflows on the smallest INT64 returned by FARM_FINGERPRINT
+	source := fmt.Sprintf("(SELECT * FROM %s WHERE ABS(MOD(FARM_FINGERPRINT(TO_JSON_STRING(STRUCT(%s))), %d)) = %d)",
+		g.genStagedSource(datasetID, externalTableID, files), strings.Join(pkColumns, ", "), partitions, partition)
+	return g.genMergeInto(tableDef, datasetID, tableID, source, partitionRange, columnTypes, where, deleteMode, format)
+}
+
+// GenCountStaged counts the rows staged in the increment table, only the rows of the files are counted in the
+// external table if files are given
+func (g Generator) GenCountStaged(datasetID, externalTableID string, files []string) string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s", g.genStagedSource(datasetID, externalTableID, files))
+}
+
+func (g Generator) genMergeInto(tableDef cloudstorage.TableDefinition, datasetID, tableID, source string, partitionRange *PartitionRange, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) string {
+	clauses := deleteMode.MergeClauses(g.QuoteIdent, "CURRENT_TIMESTAMP()")
 	pkColumn := make([]string, 0)
 	onStat := make([]string, 0)
 	for _, col := range tableDef.Columns {
 		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, col.Name, col.Name))
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
+			onStat = append(onStat, fmt.Sprintf(`T.%s = %s`, g.QuoteIdent(col.Name), g.castIncrementField(col, columnTypes, format)))
 		}
 	}
+	if partitionRange != nil {
+		onStat = append(onStat, fmt.Sprintf(`T.%s BETWEEN %s AND %s`, g.QuoteIdent(partitionRange.Column), partitionRange.Lower, partitionRange.Upper))
+	}
 
 	updateStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		updateStat = append(updateStat, fmt.Sprintf(`%s = S.%s`, col.Name, col.Name))
+		updateStat = append(updateStat, fmt.Sprintf(`%s = %s`, g.QuoteIdent(col.Name), g.castIncrementField(col, columnTypes, format)))
 	}
+	updateStat = append(updateStat, clauses.UpdateSets...)
 
 	insertStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		insertStat = append(insertStat, col.Name)
+		insertStat = append(insertStat, g.QuoteIdent(col.Name))
 	}
+	insertStat = append(insertStat, clauses.InsertColumns...)
 
 	valuesStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		valuesStat = append(valuesStat, fmt.Sprintf(`S.%s`, col.Name))
+		valuesStat = append(valuesStat, g.castIncrementField(col, columnTypes, format))
+	}
+	valuesStat = append(valuesStat, clauses.InsertValues...)
+
+	upsertCond := fmt.Sprintf("S.%s != 'D'", utils.CDCFlagColumnName)
+	deleteCond := fmt.Sprintf("S.%s = 'D'", utils.CDCFlagColumnName)
+	matchedStat := ""
+	if where != "" {
+		// the latest row of a key is matched, a row updated to not match any more is deleted
+		matchedStat = fmt.Sprintf(", COALESCE((%s), FALSE) AS %s", where, utils.WhereMatchedColumnName)
+		upsertCond += fmt.Sprintf(" AND S.%s", utils.WhereMatchedColumnName)
+		deleteCond = fmt.Sprintf("(%s OR NOT S.%s)", deleteCond, utils.WhereMatchedColumnName)
 	}
 
 	mergeSQL := fmt.Sprintf(
 		`MERGE INTO %s AS T USING
 	(
-		SELECT * EXCEPT(row_num)
+		SELECT * EXCEPT(row_num)%s
 		FROM (
 			SELECT
-				*, row_number() over (partition by %s order by %s desc) as row_num
+				*, row_number() over (partition by %s order by %s) as row_num
 			FROM %s
 		)
 		WHERE row_num = 1
@@ -49,18 +166,20 @@ func GenMergeInto(tableDef cloudstorage.TableDefinition, datasetID, tableID, ext
 	(
 		%s
 	)
-	WHEN MATCHED AND S.%s != 'D' THEN UPDATE SET %s
-	WHEN MATCHED AND S.%s = 'D' THEN DELETE
-	WHEN NOT MATCHED AND S.%s != 'D' THEN INSERT (%s) VALUES (%s);`,
-		fmt.Sprintf("`%s.%s`", datasetID, tableID),
+	WHEN MATCHED AND %s THEN UPDATE SET %s
+	WHEN MATCHED AND %s THEN %s
+	WHEN NOT MATCHED AND %s THEN INSERT (%s) VALUES (%s);`,
+		g.quoteTable(datasetID, tableID),
+		matchedStat,
 		strings.Join(pkColumn, ", "),
-		utils.CDCCommitTsColumnName,
-		fmt.Sprintf("`%s.%s`", datasetID, externalTableID),
+		utils.LatestChangeOrder(utils.CDCCommitTsColumnName, utils.CDCFlagColumnName),
+		source,
 		strings.Join(onStat, " AND "),
-		utils.CDCFlagColumnName,
+		upsertCond,
 		strings.Join(updateStat, ", "),
-		utils.CDCFlagColumnName,
-		utils.CDCFlagColumnName,
+		deleteCond,
+		clauses.DeleteAction,
+		upsertCond,
 		strings.Join(insertStat, ", "),
 		strings.Join(valuesStat, ", "),
 	)
@@ -68,30 +187,259 @@ func GenMergeInto(tableDef cloudstorage.TableDefinition, datasetID, tableID, ext
 	return mergeSQL
 }
 
-func GenCreateSchema(columns []cloudstorage.TableCol, pkColumns []string, datasetID, tableID string) (string, error) {
+// hexBitLength returns the length of the column if it is a BIT longer than 1 not overridden by columnTypes, 0
+// otherwise. Such a column is staged as a string, since the CSV files have the hex or the unsigned integer of its
+// bytes while BigQuery loads BYTES from base64. The Parquet files have the bytes.
+func hexBitLength(column cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) int {
+	if format == stagingformat.Parquet {
+		return 0
+	}
+	if _, ok := columnTypes.Lookup(column.Name); ok {
+		return 0
+	}
+	if length := tidbsql.BitLength(column); length > 1 {
+		return length
+	}
+	return 0
+}
+
+// StagedColumns returns the columns of the table the files are loaded into before they are converted to the
+// target table, the BIT columns longer than 1 are strings in the CSV files
+func StagedColumns(columns []cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) []cloudstorage.TableCol {
+	staged := make([]cloudstorage.TableCol, 0, len(columns))
+	for _, column := range columns {
+		if hexBitLength(column, columnTypes, format) > 0 {
+			column.Tp, column.Precision = "text", ""
+		}
+		staged = append(staged, column)
+	}
+	return staged
+}
+
+// castIncrementField returns the column of the increment table converted to the column of the target table.
+// TiCDC writes a BIT longer than 1 as an unsigned integer, which is converted to its bytes by the hex of the
+// high and low 32 bits, since the values of BIT(64) do not fit INT64.
+func (g Generator) castIncrementField(col cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) string {
+	field := fmt.Sprintf("S.%s", g.QuoteIdent(col.Name))
+	length := hexBitLength(col, columnTypes, format)
+	if length == 0 {
+		return field
+	}
+	value := fmt.Sprintf("CAST(%s AS NUMERIC)", field)
+	return fmt.Sprintf("FROM_HEX(RIGHT(CONCAT(FORMAT('%%08x', CAST(DIV(%s, 4294967296) AS INT64)), FORMAT('%%08x', CAST(MOD(%s, 4294967296) AS INT64))), %d))",
+		value, value, 2*tidbsql.BitBytes(length))
+}
+
+// GenInsertFromStaging generates the INSERT of the snapshot rows loaded into the staging table into the target
+// table, the BIT columns longer than 1 are dumped as the hex of their bytes in the CSV files. The other columns of
+// the target table, e.g. the tombstone columns of the soft delete mode, get their default values.
+func (g Generator) GenInsertFromStaging(columns []cloudstorage.TableCol, datasetID, tableID, stagingTableID string, columnTypes columnmapping.Columns, format stagingformat.Format) string {
+	names := make([]string, 0, len(columns))
+	values := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, g.QuoteIdent(column.Name))
+		if hexBitLength(column, columnTypes, format) > 0 {
+			values = append(values, fmt.Sprintf("FROM_HEX(%s)", g.QuoteIdent(column.Name)))
+		} else {
+			values = append(values, g.QuoteIdent(column.Name))
+		}
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", g.quoteTable(datasetID, tableID),
+		strings.Join(names, ", "), strings.Join(values, ", "), g.quoteTable(datasetID, stagingTableID))
+}
+
+// genPartitionLiteral converts the string value of a partition column into a BigQuery literal.
+func genPartitionLiteral(bqType, value string) string {
+	switch bqType {
+	case "DATE", "DATETIME", "TIMESTAMP":
+		return fmt.Sprintf("%s '%s'", bqType, value)
+	case "INT64":
+		return value
+	default:
+		return fmt.Sprintf("CAST('%s' AS %s)", value, bqType)
+	}
+}
+
+// GenCreateExternalTable generates the DDL of a BigLake external table over CSV or Parquet files, by the extension
+// of the uri, with metadata caching enabled. The uri is usually a wildcard of the files of a directory, the table is
+// kept for the files written later, whose metadata is cached by GenRefreshExternalMetadataCache.
+func (g Generator) GenCreateExternalTable(columns []cloudstorage.TableCol, datasetID, tableID, connectionID, uri string, maxStaleness time.Duration, compression utils.Compression, columnTypes columnmapping.Columns) (string, error) {
+	columnRows := make([]string, 0, len(columns))
+	for _, column := range columns {
+		colType, err := GetBigQueryColumnTypeString(column, columnTypes)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, fmt.Sprintf("    %s %s", g.QuoteIdent(column.Name), colType))
+	}
+	if err := ValidateMaxStaleness(maxStaleness); err != nil {
+		return "", errors.Trace(err)
+	}
+	// minute granularity is enough
+	staleness := int64(maxStaleness.Round(time.Minute) / time.Minute)
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf("CREATE OR REPLACE EXTERNAL TABLE %s (", g.quoteTable(datasetID, tableID)))
+	sql = append(sql, strings.Join(columnRows, ",\n"))
+	sql = append(sql, ")")
+	sql = append(sql, fmt.Sprintf("WITH CONNECTION %s", quoteName(connectionID)))
+	sql = append(sql, "OPTIONS (")
+	if stagingformat.FileFormat(uri) == stagingformat.Parquet {
+		sql = append(sql, "    format = 'PARQUET',")
+		sql = append(sql, fmt.Sprintf("    uris = ['%s'],", uri))
+	} else {
+		sql = append(sql, "    format = 'CSV',")
+		sql = append(sql, fmt.Sprintf("    uris = ['%s'],", uri))
+		sql = append(sql, `    null_marker = '\\N',`)
+		if compression == utils.CompressionGzip {
+			sql = append(sql, "    compression = 'GZIP',")
+		}
+	}
+	sql = append(sql, fmt.Sprintf("    max_staleness = INTERVAL %d MINUTE,", staleness))
+	// the cache is refreshed before the files written after the last refresh are merged
+	sql = append(sql, "    metadata_cache_mode = 'MANUAL'")
+	sql = append(sql, ")")
+
+	return strings.Join(sql, "\n"), nil
+}
+
+// GenRefreshExternalMetadataCache generates the statement refreshing the metadata cache of the external table, so
+// that the files written since the last refresh are read
+func (g Generator) GenRefreshExternalMetadataCache(datasetID, tableID string) string {
+	return fmt.Sprintf("CALL BQ.REFRESH_EXTERNAL_METADATA_CACHE(%s)", utils.QuoteLiteral(datasetID+"."+g.identifierCase.Apply(tableID)))
+}
+
+// GenCreateSchema generates the DDL of the table, comments are omitted if nil. The table is partitioned and clustered
+// by the columns of the layout. The tombstone columns of the delete mode follow the columns.
+func (g Generator) GenCreateSchema(columns []cloudstorage.TableCol, pkColumns []string, datasetID, tableID string, comments *tidbsql.TableComments, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) (string, error) {
+	if comments == nil {
+		comments = &tidbsql.TableComments{}
+	}
 	columnRows := make([]string, 0, len(columns))
 	for _, column := range columns {
-		row, err := GetBigQueryColumnString(column, true)
+		row, err := g.GetBigQueryColumnString(column, true, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
+		if comment, ok := comments.Columns[column.Name]; ok {
+			row += " " + genDescriptionOption(comment, maxColumnDescriptionLength, column.Name)
+		}
 		columnRows = append(columnRows, row)
 	}
+	columnRows = append(columnRows, deleteMode.ColumnDefs(g.QuoteIdent, "BOOL DEFAULT FALSE", "TIMESTAMP")...)
 
 	sqlRows := make([]string, 0, len(columnRows)+1)
 	sqlRows = append(sqlRows, columnRows...)
 	if len(pkColumns) > 0 {
-		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s) NOT ENFORCED", strings.Join(pkColumns, ", ")))
+		quotedPKColumns := make([]string, 0, len(pkColumns))
+		for _, column := range pkColumns {
+			quotedPKColumns = append(quotedPKColumns, g.QuoteIdent(column))
+		}
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s) NOT ENFORCED", strings.Join(quotedPKColumns, ", ")))
 	}
 	// Add idents
 	for i := 0; i < len(sqlRows); i++ {
 		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
 	}
 
+	layoutClauses, err := g.genLayoutClauses(columns, layout, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s.%s (`, datasetID, tableID)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, g.quoteTable(datasetID, tableID)))
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
 	sql = append(sql, ")")
+	sql = append(sql, layoutClauses...)
+	if comments.Table != "" {
+		sql = append(sql, genDescriptionOption(comments.Table, maxTableDescriptionLength, tableID))
+	}
 
 	return strings.Join(sql, "\n"), nil
 }
+
+// maxClusteringColumns is the max number of the columns clustering a table
+const maxClusteringColumns = 4
+
+// clusteringTypes are the types of the columns which can cluster a table
+var clusteringTypes = []string{"BIGNUMERIC", "BOOL", "DATE", "DATETIME", "INT64", "NUMERIC", "STRING", "TIMESTAMP"}
+
+// genLayoutClauses returns the PARTITION BY and CLUSTER BY clauses of the table. A table is partitioned by a DATE,
+// DATETIME or TIMESTAMP column by day, or by an INT64 column with its range, e.g. id:0:1000000:1000 partitions
+// the table by id into buckets of 1000 from 0 to 1000000.
+func (g Generator) genLayoutClauses(columns []cloudstorage.TableCol, layout tablelayout.Layout, columnTypes columnmapping.Columns) ([]string, error) {
+	var clauses []string
+	if len(layout.PartitionBy) > 1 {
+		return nil, errors.Errorf("A BigQuery table is partitioned by one column, got %s", strings.Join(layout.PartitionBy, ", "))
+	}
+	if len(layout.PartitionBy) == 1 {
+		found, err := tablelayout.Lookup(layout.PartitionBy, columns)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		clause, err := g.genPartitionClause(found[0], layout.PartitionBy[0], columnTypes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		clauses = append(clauses, clause)
+	}
+	if len(layout.ClusterBy) > maxClusteringColumns {
+		return nil, errors.Errorf("A BigQuery table is clustered by at most %d columns, got %s", maxClusteringColumns, strings.Join(layout.ClusterBy, ", "))
+	}
+	found, err := tablelayout.Lookup(layout.ClusterBy, columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	quotedColumns := make([]string, 0, len(found))
+	for _, column := range found {
+		bqType, err := GetBigQueryColumnTypeString(column, columnTypes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if baseType, _, _ := strings.Cut(bqType, "("); !slices.Contains(clusteringTypes, strings.ToUpper(baseType)) {
+			return nil, errors.Errorf("Column %s of type %s can not cluster a BigQuery table", column.Name, bqType)
+		}
+		quotedColumns = append(quotedColumns, g.QuoteIdent(column.Name))
+	}
+	if len(quotedColumns) > 0 {
+		clauses = append(clauses, fmt.Sprintf("CLUSTER BY %s", strings.Join(quotedColumns, ", ")))
+	}
+	return clauses, nil
+}
+
+// genPartitionClause returns the PARTITION BY clause of the column, spec is the column with the range of an INT64 column
+func (g Generator) genPartitionClause(column cloudstorage.TableCol, spec string, columnTypes columnmapping.Columns) (string, error) {
+	bqType, err := GetBigQueryColumnTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	params := strings.Split(spec, ":")[1:]
+	if strings.ToUpper(bqType) == "INT64" {
+		if len(params) != 3 {
+			return "", errors.Errorf("Column %s of type INT64 partitions a BigQuery table by a range, e.g. %s:0:1000000:1000 of the start, end and interval",
+				column.Name, column.Name)
+		}
+		bounds := make([]string, 0, len(params))
+		for _, param := range params {
+			bound, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				return "", errors.Annotatef(err, "Invalid range %s of partitioning column %s", spec, column.Name)
+			}
+			bounds = append(bounds, strconv.FormatInt(bound, 10))
+		}
+		return fmt.Sprintf("PARTITION BY RANGE_BUCKET(%s, GENERATE_ARRAY(%s))", g.QuoteIdent(column.Name), strings.Join(bounds, ", ")), nil
+	}
+	if len(params) > 0 {
+		return "", errors.Errorf("Column %s of type %s partitions a BigQuery table by day without a range", column.Name, bqType)
+	}
+	switch strings.ToUpper(bqType) {
+	case "DATE":
+		return fmt.Sprintf("PARTITION BY %s", g.QuoteIdent(column.Name)), nil
+	case "DATETIME", "TIMESTAMP":
+		return fmt.Sprintf("PARTITION BY DATE(%s)", g.QuoteIdent(column.Name)), nil
+	default:
+		return "", errors.Errorf("Column %s of type %s can not partition a BigQuery table, only DATE, DATETIME, TIMESTAMP and INT64 columns can",
+			column.Name, bqType)
+	}
+}
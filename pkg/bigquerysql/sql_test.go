@@ -0,0 +1,366 @@
+package bigquerysql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/bigquerysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/typetest"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	require.Equal(t, "`select`", gen.QuoteIdent("select"))
+	require.Equal(t, "`名称`", gen.QuoteIdent("名称"))
+	require.Equal(t, "`a\\`b\\\\c`", gen.QuoteIdent("a`b\\c"))
+}
+
+func TestGenQuoteIdent(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "select", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "名称", Tp: "varchar", Precision: "20"},
+	}
+	query, err := gen.GenCreateSchema(columns, []string{"select"}, "app", "order", nil, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE TABLE `app`.`order` (\n    `select` INT64 NOT NULL,\n    `名称` STRING,\n    PRIMARY KEY (`select`) NOT ENFORCED\n)", query)
+
+	query = gen.GenMergeInto(cloudstorage.TableDefinition{Table: "order", Columns: columns}, "app", "order", "incr_order", nil, nil, nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "MERGE INTO `app`.`order` AS T USING")
+	require.Contains(t, query, "FROM `app`.`incr_order`")
+	// the insert of an update split by TiCDC is merged instead of the delete of the same commit ts
+	require.Contains(t, query, "row_number() over (partition by `select` order by tidb2dw_commit_ts desc, CASE WHEN tidb2dw_flag = 'D' THEN 0 ELSE 1 END desc) as row_num")
+	require.Contains(t, query, "T.`select` = S.`select`")
+	require.Contains(t, query, "INSERT (`select`, `名称`) VALUES (S.`select`, S.`名称`)")
+
+	prevColumns := columns
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "order",
+		Schema:  "app",
+		Type:    timodel.ActionModifyColumn,
+		Query:   "ALTER TABLE `order` RENAME COLUMN `名称` TO `group`",
+		Columns: []cloudstorage.TableCol{columns[0], {ID: "2", Name: "group", Tp: "varchar", Precision: "20"}},
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff("app", "order", prevColumns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `app`.`order` RENAME COLUMN `名称` TO `group`;"}, ddls)
+}
+
+func TestIdentifierCase(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "UserID", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "event_name", Tp: "varchar", Precision: "20"},
+	}
+	tableDef := cloudstorage.TableDefinition{Table: "UserEvents", Schema: "App", Type: timodel.ActionAddColumn, Columns: append(columns, cloudstorage.TableCol{ID: "3", Name: "CreatedAt", Tp: "int"})}
+
+	// the names are kept by default, as the names of the tables are case-sensitive
+	query, err := gen.GenCreateSchema(columns, []string{"UserID"}, "App", "UserEvents", nil, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE TABLE `App`.`UserEvents` (\n    `UserID` INT64 NOT NULL,\n    `event_name` STRING,\n    PRIMARY KEY (`UserID`) NOT ENFORCED\n)", query)
+
+	// the datasets are named as given
+	gen = bigquerysql.NewGenerator(identcase.Lower)
+	query, err = gen.GenCreateSchema(columns, []string{"UserID"}, "App", "UserEvents", nil, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE TABLE `App`.`userevents` (\n    `userid` INT64 NOT NULL,\n    `event_name` STRING,\n    PRIMARY KEY (`userid`) NOT ENFORCED\n)", query)
+	ddls, err := gen.GenDDLViaColumnsDiff("App", "UserEvents", columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `App`.`userevents` ADD COLUMN `createdat` INT64;"}, ddls)
+	query = gen.GenMergeInto(cloudstorage.TableDefinition{Table: "UserEvents", Columns: columns}, "App", "UserEvents", "incr_UserEvents", nil, nil, nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "MERGE INTO `App`.`userevents` AS T USING")
+	require.Contains(t, query, "FROM `App`.`incr_userevents`")
+	require.Contains(t, query, "T.`userid` = S.`userid`")
+	require.Contains(t, query, "INSERT (`userid`, `event_name`) VALUES (S.`userid`, S.`event_name`)")
+}
+
+func TestGenSoftDelete(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "note", Tp: "varchar", Precision: "20"},
+	}
+	query, err := gen.GenCreateSchema(columns, []string{"id"}, "app", "notes", nil, nil, tablelayout.Layout{}, deletemode.Soft)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE TABLE `app`.`notes` (\n    `id` INT64 NOT NULL,\n    `note` STRING,\n"+
+		"    `_tidb_deleted` BOOL DEFAULT FALSE,\n    `_tidb_deleted_at` TIMESTAMP,\n    PRIMARY KEY (`id`) NOT ENFORCED\n)", query)
+
+	query = gen.GenMergeInto(cloudstorage.TableDefinition{Table: "notes", Columns: columns}, "app", "notes", "incr_notes", nil, nil, nil, "", deletemode.Soft, stagingformat.CSV)
+	require.Contains(t, query, "THEN UPDATE SET `_tidb_deleted` = TRUE, `_tidb_deleted_at` = CURRENT_TIMESTAMP()")
+	require.Contains(t, query, "`note` = S.`note`, `_tidb_deleted` = FALSE, `_tidb_deleted_at` = NULL")
+	require.Contains(t, query, "INSERT (`id`, `note`, `_tidb_deleted`, `_tidb_deleted_at`) VALUES (S.`id`, S.`note`, FALSE, NULL)")
+	require.NotContains(t, query, "THEN DELETE")
+}
+
+func TestGenCreateSchemaWithLayout(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "bigint", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "tenant_id", Tp: "int"},
+		{ID: "3", Name: "created_at", Tp: "timestamp"},
+		{ID: "4", Name: "score", Tp: "double"},
+	}
+	query, err := gen.GenCreateSchema(columns, []string{"id"}, "app", "events", nil, nil,
+		tablelayout.Layout{PartitionBy: []string{"created_at"}, ClusterBy: []string{"tenant_id", "id"}}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE TABLE `app`.`events` (\n    `id` INT64 NOT NULL,\n    `tenant_id` INT64,\n    `created_at` TIMESTAMP,\n"+
+		"    `score` FLOAT64,\n    PRIMARY KEY (`id`) NOT ENFORCED\n)\nPARTITION BY DATE(`created_at`)\nCLUSTER BY `tenant_id`, `id`", query)
+
+	query, err = gen.GenCreateSchema(columns, []string{"id"}, "app", "events", nil, nil, tablelayout.Layout{PartitionBy: []string{"id:0:1000000:1000"}}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Contains(t, query, "PARTITION BY RANGE_BUCKET(`id`, GENERATE_ARRAY(0, 1000000, 1000))")
+
+	for _, c := range []struct {
+		layout tablelayout.Layout
+		err    string
+	}{
+		{tablelayout.Layout{PartitionBy: []string{"id"}}, "Column id of type INT64 partitions a BigQuery table by a range"},
+		{tablelayout.Layout{PartitionBy: []string{"id:0:x:1"}}, "Invalid range id:0:x:1 of partitioning column id"},
+		{tablelayout.Layout{PartitionBy: []string{"created_at:0:10:1"}}, "partitions a BigQuery table by day without a range"},
+		{tablelayout.Layout{PartitionBy: []string{"score"}}, "Column score of type FLOAT64 can not partition a BigQuery table"},
+		{tablelayout.Layout{PartitionBy: []string{"created_at", "id"}}, "A BigQuery table is partitioned by one column"},
+		{tablelayout.Layout{PartitionBy: []string{"kind"}}, "Column kind partitioning or clustering the table is not a column of the table"},
+		{tablelayout.Layout{ClusterBy: []string{"score"}}, "Column score of type FLOAT64 can not cluster a BigQuery table"},
+		{tablelayout.Layout{ClusterBy: []string{"id", "tenant_id", "created_at", "id", "tenant_id"}}, "clustered by at most 4 columns"},
+	} {
+		_, err = gen.GenCreateSchema(columns, []string{"id"}, "app", "events", nil, nil, c.layout, deletemode.Hard)
+		require.ErrorContains(t, err, c.err)
+	}
+
+	// a partitioning or clustering column is not dropped
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "events",
+		Schema:  "app",
+		Type:    timodel.ActionDropColumn,
+		Query:   "ALTER TABLE `events` DROP COLUMN `created_at`",
+		Columns: []cloudstorage.TableCol{columns[0], columns[1], columns[3]},
+	}
+	_, err = gen.GenDDLViaColumnsDiff("app", "events", columns, tableDef, nil, tablelayout.Layout{PartitionBy: []string{"created_at"}}, deletemode.Hard)
+	require.ErrorContains(t, err, "Can not drop column created_at which partitions or clusters the table")
+}
+
+func TestGenDDLViaColumnsDiffAddColumn(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	// BigQuery adds no REQUIRED column, the existing rows are filled by UPDATE
+	expected := map[string][]string{
+		"add column": {"ALTER TABLE `d`.`t` ADD COLUMN `email` STRING;"},
+		"add not null column": {
+			"ALTER TABLE `d`.`t` ADD COLUMN `score` INT64;",
+			"UPDATE `d`.`t` SET `score` = 0 WHERE TRUE;",
+		},
+		"add column with default": {
+			"ALTER TABLE `d`.`t` ADD COLUMN `level` INT64;",
+			"ALTER TABLE `d`.`t` ALTER COLUMN `level` SET DEFAULT 1;",
+			"UPDATE `d`.`t` SET `level` = 1 WHERE TRUE;",
+		},
+		"add not null date column": {"ALTER TABLE `d`.`t` ADD COLUMN `born` DATE;"},
+		"add column with quoted default": {
+			"ALTER TABLE `d`.`t` ADD COLUMN `note` STRING;",
+			"ALTER TABLE `d`.`t` ALTER COLUMN `note` SET DEFAULT 'it\\'s \\\\ ok';",
+			"UPDATE `d`.`t` SET `note` = 'it\\'s \\\\ ok' WHERE TRUE;",
+		},
+		"add column with numeric string default": {
+			"ALTER TABLE `d`.`t` ADD COLUMN `code` STRING;",
+			"ALTER TABLE `d`.`t` ALTER COLUMN `code` SET DEFAULT '007';",
+			"UPDATE `d`.`t` SET `code` = '007' WHERE TRUE;",
+		},
+	}
+	for _, change := range ddltest.Changes() {
+		if _, ok := expected[change.Name]; !ok {
+			continue
+		}
+		t.Run(change.Name, func(t *testing.T) {
+			ddls, err := gen.GenDDLViaColumnsDiff("d", "t", change.PrevColumns, change.TableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+			require.NoError(t, err)
+			require.Equal(t, expected[change.Name], ddls)
+		})
+	}
+}
+
+func TestGetBigQueryColumnTypeStringUnsigned(t *testing.T) {
+	for tp, expected := range map[string]string{
+		"TINYINT UNSIGNED":   "INT64",
+		"smallint unsigned":  "INT64",
+		"MEDIUMINT UNSIGNED": "INT64",
+		"INT UNSIGNED":       "INT64",
+		// NUMERIC has 29 integer digits, 18446744073709551615 has 20
+		"BIGINT UNSIGNED":  "NUMERIC",
+		"DECIMAL UNSIGNED": "NUMERIC",
+	} {
+		actual, err := bigquerysql.GetBigQueryColumnTypeString(cloudstorage.TableCol{Name: "c", Tp: tp}, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, tp)
+	}
+}
+
+func TestGetBigQueryColumnTypeStringAllTypes(t *testing.T) {
+	expected := map[string]string{
+		"c_tinyint":            "INT64",
+		"c_tinyint_unsigned":   "INT64",
+		"c_smallint":           "INT64",
+		"c_smallint_unsigned":  "INT64",
+		"c_mediumint":          "INT64",
+		"c_mediumint_unsigned": "INT64",
+		"c_int":                "INT64",
+		"c_int_unsigned":       "INT64",
+		"c_bigint":             "INT64",
+		"c_bigint_unsigned":    "NUMERIC",
+		"c_float":              "FLOAT64",
+		"c_double":             "FLOAT64",
+		"c_decimal":            "NUMERIC(20, 6)",
+		"c_decimal_wide":       "BIGNUMERIC(40, 10)",
+		"c_bit":                "BOOL",
+		"c_bit_long":           "BYTES",
+		"c_year":               "INT64",
+		"c_date":               "DATE",
+		"c_datetime":           "DATETIME",
+		"c_timestamp":          "TIMESTAMP",
+		"c_time":               "TIME",
+		"c_char":               "STRING",
+		"c_varchar":            "STRING",
+		"c_binary":             "BYTES",
+		"c_varbinary":          "BYTES",
+		"c_tinytext":           "STRING",
+		"c_text":               "STRING",
+		"c_mediumtext":         "STRING",
+		"c_longtext":           "STRING",
+		"c_tinyblob":           "BYTES",
+		"c_blob":               "BYTES",
+		"c_mediumblob":         "BYTES",
+		"c_longblob":           "BYTES",
+		"c_enum":               "STRING",
+		"c_set":                "STRING",
+		"c_json":               "STRING",
+	}
+	for _, column := range typetest.Columns() {
+		actual, err := bigquerysql.GetBigQueryColumnTypeString(column, nil)
+		require.NoError(t, err, column.Name)
+		require.Equal(t, expected[column.Name], actual, column.Name)
+	}
+	for _, column := range typetest.CopiedColumns()[1:] {
+		actual, err := bigquerysql.GetBigQueryColumnTypeString(column, nil)
+		require.NoError(t, err)
+		require.Equal(t, "STRING("+column.Precision+")", actual)
+	}
+
+	// NUMERIC keeps 9 digits after the decimal point, BIGNUMERIC 38 digits before it
+	actual, err := bigquerysql.GetBigQueryColumnTypeString(cloudstorage.TableCol{Name: "c", Tp: "DECIMAL", Precision: "30", Scale: "20"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "BIGNUMERIC(30, 20)", actual)
+	_, err = bigquerysql.GetBigQueryColumnTypeString(cloudstorage.TableCol{Name: "c", Tp: "DECIMAL", Precision: "65", Scale: "10"}, nil)
+	require.ErrorContains(t, err, "Column c of DECIMAL(65, 10) exceeds the max 38 integer digits of BIGNUMERIC of BigQuery")
+	actual, err = bigquerysql.GetBigQueryColumnTypeString(cloudstorage.TableCol{Name: "c", Tp: "DECIMAL", Precision: "65", Scale: "10"}, columnmapping.Columns{"c": "STRING"})
+	require.NoError(t, err)
+	require.Equal(t, "STRING", actual)
+}
+
+func TestGenMergeIntoBit(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "enabled", Tp: "BIT", Precision: "1"},
+		{Name: "mask", Tp: "BIT", Precision: "12"},
+	}
+	// the longer BIT is staged as a string and converted from the unsigned integer
+	staged := bigquerysql.StagedColumns(columns, nil, stagingformat.CSV)
+	require.Equal(t, "BIT", staged[1].Tp)
+	require.Equal(t, "text", staged[2].Tp)
+	query := gen.GenMergeInto(cloudstorage.TableDefinition{Table: "flags", Columns: columns}, "app", "flags", "incr_flags", nil, nil, nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "`enabled` = S.`enabled`, `mask` = FROM_HEX(RIGHT(CONCAT("+
+		"FORMAT('%08x', CAST(DIV(CAST(S.`mask` AS NUMERIC), 4294967296) AS INT64)), "+
+		"FORMAT('%08x', CAST(MOD(CAST(S.`mask` AS NUMERIC), 4294967296) AS INT64))), 4))")
+
+	// the snapshot is converted from the hex
+	query = gen.GenInsertFromStaging(columns, "app", "flags", "snapshot_external_flags", nil, stagingformat.CSV)
+	require.Equal(t, "INSERT INTO `app`.`flags` (`id`, `enabled`, `mask`) SELECT `id`, `enabled`, FROM_HEX(`mask`) FROM `app`.`snapshot_external_flags`", query)
+
+	// a column overridden is loaded as the type given
+	columnTypes := columnmapping.Columns{"mask": "INT64"}
+	require.Equal(t, "BIT", bigquerysql.StagedColumns(columns, columnTypes, stagingformat.CSV)[2].Tp)
+	query = gen.GenMergeInto(cloudstorage.TableDefinition{Table: "flags", Columns: columns}, "app", "flags", "incr_flags", nil, nil, columnTypes, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "`mask` = S.`mask`")
+
+	// the Parquet files have the bytes of the BIT columns
+	require.Equal(t, "BIT", bigquerysql.StagedColumns(columns, nil, stagingformat.Parquet)[2].Tp)
+	query = gen.GenMergeInto(cloudstorage.TableDefinition{Table: "flags", Columns: columns}, "app", "flags", "incr_flags", nil, nil, nil, "", deletemode.Hard, stagingformat.Parquet)
+	require.Contains(t, query, "`mask` = S.`mask`")
+	query = gen.GenInsertFromStaging(columns, "app", "flags", "snapshot_external_flags", nil, stagingformat.Parquet)
+	require.Equal(t, "INSERT INTO `app`.`flags` (`id`, `enabled`, `mask`) SELECT `id`, `enabled`, `mask` FROM `app`.`snapshot_external_flags`", query)
+}
+
+func TestGenCreateExternalTable(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "name", Tp: "VARCHAR", Precision: "10"},
+	}
+	query, err := gen.GenCreateExternalTable(columns, "app", "incr_users", "us.lake", "gs://bucket/app/users/1/*.csv.gz", 90*time.Minute, utils.CompressionGzip, nil)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE OR REPLACE EXTERNAL TABLE `app`.`incr_users` (\n"+
+		"    `id` INT64,\n"+
+		"    `name` STRING\n"+
+		")\n"+
+		"WITH CONNECTION `us.lake`\n"+
+		"OPTIONS (\n"+
+		"    format = 'CSV',\n"+
+		"    uris = ['gs://bucket/app/users/1/*.csv.gz'],\n"+
+		"    null_marker = '\\\\N',\n"+
+		"    compression = 'GZIP',\n"+
+		"    max_staleness = INTERVAL 90 MINUTE,\n"+
+		"    metadata_cache_mode = 'MANUAL'\n"+
+		")", query)
+
+	// Parquet files are neither compressed by the option nor have a null marker
+	query, err = gen.GenCreateExternalTable(columns, "app", "incr_users", "us.lake", "gs://bucket/app/users/1/*.parquet", 7*24*time.Hour, utils.CompressionNone, nil)
+	require.NoError(t, err)
+	require.Contains(t, query, "    format = 'PARQUET',\n    uris = ['gs://bucket/app/users/1/*.parquet'],\n    max_staleness = INTERVAL 10080 MINUTE,")
+	require.NotContains(t, query, "null_marker")
+
+	// the max staleness is limited by BigQuery
+	for _, maxStaleness := range []time.Duration{0, 29 * time.Minute, 7*24*time.Hour + time.Minute} {
+		_, err = gen.GenCreateExternalTable(columns, "app", "incr_users", "us.lake", "gs://bucket/app/users/1/*.csv", maxStaleness, utils.CompressionNone, nil)
+		require.ErrorContains(t, err, "invalid BigQuery max staleness")
+	}
+
+	require.Equal(t, "CALL BQ.REFRESH_EXTERNAL_METADATA_CACHE('app.incr_users')", gen.GenRefreshExternalMetadataCache("app", "incr_users"))
+	require.Equal(t, "CALL BQ.REFRESH_EXTERNAL_METADATA_CACHE('app.INCR_USERS')", bigquerysql.NewGenerator(identcase.Upper).GenRefreshExternalMetadataCache("app", "incr_users"))
+}
+
+func TestGenMergeIntoFiles(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "v", Tp: "INT"}}
+	tableDef := cloudstorage.TableDefinition{Table: "t", Columns: columns}
+	query := gen.GenMergeInto(tableDef, "app", "t", "incr_t", []string{"gs://bucket/app/t/1/CDC000002.csv"}, nil, nil, "", deletemode.Hard, stagingformat.CSV)
+	// only the rows of the file are read from the external table
+	require.Contains(t, query, "FROM (SELECT * FROM `app`.`incr_t` WHERE _FILE_NAME IN ('gs://bucket/app/t/1/CDC000002.csv'))")
+
+	query = gen.GenMergeInto(tableDef, "app", "t", "incr_t", nil, &bigquerysql.PartitionRange{Column: "v", Lower: "1", Upper: "9"}, nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "FROM `app`.`incr_t`\n")
+	require.Contains(t, query, "T.`id` = S.`id` AND T.`v` BETWEEN 1 AND 9")
+}
+
+func TestGenMergeIntoPartition(t *testing.T) {
+	gen := bigquerysql.NewGenerator(identcase.Preserve)
+	columns := []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "region", Tp: "VARCHAR", IsPK: "true"}, {Name: "v", Tp: "INT"}}
+	tableDef := cloudstorage.TableDefinition{Table: "t", Columns: columns}
+	query := gen.GenMergeIntoPartition(tableDef, "app", "t", "incr_t", nil, nil, nil, "", deletemode.Hard, stagingformat.CSV, 2, 5)
+	// every change of a key is in the same partition
+	require.Contains(t, query, "FROM (SELECT * FROM `app`.`incr_t` WHERE ABS(MOD(FARM_FINGERPRINT(TO_JSON_STRING(STRUCT(`id`, `region`))), 5)) = 2)")
+	require.Contains(t, query, "partition by `id`, `region`")
+
+	files := []string{"gs://bucket/app/t/1/CDC000002.csv"}
+	query = gen.GenMergeIntoPartition(tableDef, "app", "t", "incr_t", files, nil, nil, "", deletemode.Hard, stagingformat.CSV, 0, 2)
+	require.Contains(t, query, "FROM (SELECT * FROM (SELECT * FROM `app`.`incr_t` WHERE _FILE_NAME IN ('gs://bucket/app/t/1/CDC000002.csv')) WHERE ABS(MOD(")
+	require.Equal(t, "SELECT COUNT(*) FROM (SELECT * FROM `app`.`incr_t` WHERE _FILE_NAME IN ('gs://bucket/app/t/1/CDC000002.csv'))", gen.GenCountStaged("app", "incr_t", files))
+	require.Equal(t, "SELECT COUNT(*) FROM `app`.`incr_t`", gen.GenCountStaged("app", "incr_t", nil))
+}
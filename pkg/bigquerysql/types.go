@@ -1,8 +1,13 @@
 package bigquerysql
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"github.com/pkg/errors"
 )
@@ -19,6 +24,7 @@ var TiDB2BigQueryTypeMap map[string]string = map[string]string{
 	"datetime":   "DATETIME",
 	"decimal":    "NUMERIC",
 	"double":     "FLOAT64",
+	"enum":       "STRING",
 	"float":      "FLOAT64",
 	"int":        "INT64",
 	"json":       "STRING",
@@ -40,8 +46,68 @@ var TiDB2BigQueryTypeMap map[string]string = map[string]string{
 	"year":       "INT64",
 }
 
-func GetBigQueryColumnTypeString(column cloudstorage.TableCol) (string, error) {
+// tiDB2BigQueryUnsignedTypeMap widens BIGINT UNSIGNED, whose values above 2^63-1 do not fit INT64. NUMERIC keeps
+// 29 integer digits, the other unsigned integer types fit INT64.
+var tiDB2BigQueryUnsignedTypeMap map[string]string = map[string]string{
+	"bigint": "NUMERIC",
+}
+
+// The max digits of the parameterized NUMERIC and BIGNUMERIC types, e.g. NUMERIC(P, S) has at most 29 digits
+// before the decimal point and 9 after it
+const (
+	numericMaxIntegerDigits    = 29
+	numericMaxScale            = 9
+	bigNumericMaxIntegerDigits = 38
+	bigNumericMaxScale         = 38
+)
+
+// getDecimalType returns NUMERIC of the precision and scale of the DECIMAL column if it fits, BIGNUMERIC otherwise.
+// A DECIMAL of more integer digits than BIGNUMERIC fails, as its values could not be loaded.
+func getDecimalType(column cloudstorage.TableCol) (string, error) {
+	precision, err := strconv.Atoi(column.Precision)
+	if err != nil {
+		return "NUMERIC", nil
+	}
+	scale, _ := strconv.Atoi(column.Scale)
+	switch {
+	case precision-scale <= numericMaxIntegerDigits && scale <= numericMaxScale:
+		return fmt.Sprintf("NUMERIC(%d, %d)", precision, scale), nil
+	case precision-scale <= bigNumericMaxIntegerDigits && scale <= bigNumericMaxScale:
+		return fmt.Sprintf("BIGNUMERIC(%d, %d)", precision, scale), nil
+	default:
+		return "", errors.Errorf("Column %s of DECIMAL(%d, %d) exceeds the max %d integer digits of BIGNUMERIC of BigQuery, override its type by --column-mapping",
+			column.Name, precision, scale, bigNumericMaxIntegerDigits)
+	}
+}
+
+// GetBigQueryColumnTypeString returns the BigQuery type of the column, the type given by columnTypes takes
+// precedence over the default mapping
+func GetBigQueryColumnTypeString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	if tp, ok := columnTypes.Lookup(column.Name); ok {
+		return tp, nil
+	}
 	tp := strings.ToLower(column.Tp)
+	if baseTp, ok := strings.CutSuffix(tp, " unsigned"); ok {
+		if bqType, ok := tiDB2BigQueryUnsignedTypeMap[baseTp]; ok {
+			return bqType, nil
+		}
+		tp = baseTp
+	}
+	switch tp {
+	case "decimal":
+		return getDecimalType(column)
+	case "bit":
+		// BIT(1) is loaded from 0 or 1, a longer BIT from its bytes
+		if tidbsql.BitLength(column) > 1 {
+			return "BYTES", nil
+		}
+		return "BOOL", nil
+	case "enum", "set":
+		// the length of the longest value is known when the table is copied from TiDB, not by the schema files
+		if column.Precision != "" {
+			return fmt.Sprintf("STRING(%s)", column.Precision), nil
+		}
+	}
 	bqType, ok := TiDB2BigQueryTypeMap[tp]
 	if !ok {
 		return bqType, errors.Errorf("Unsupported TiDB type %s", tp)
@@ -0,0 +1,80 @@
+package bigquerysql
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"google.golang.org/api/iterator"
+)
+
+// TableVerifier reads the tables of the dataset in BigQuery for `tidb2dw verify` and the validation of the snapshot
+type TableVerifier struct {
+	bqClient  *bigquery.Client
+	ctx       context.Context
+	gen       Generator
+	datasetID string
+}
+
+func NewTableVerifier(bqClient *bigquery.Client, identifierCase identcase.Case, datasetID string) *TableVerifier {
+	return &TableVerifier{bqClient: bqClient, ctx: context.Background(), gen: NewGenerator(identifierCase), datasetID: datasetID}
+}
+
+// AggregateRange returns the row count and the sums of the columns of the rows in the range.
+// The sums are computed as BIGNUMERIC since NUMERIC has less than 38 digits.
+func (v *TableVerifier) AggregateRange(targetTable string, sumColumns []validation.SumColumn, keyRange validation.KeyRange) (*validation.Aggregates, error) {
+	query := validation.AppendWhere(validation.GenAggregateQuery(v.gen.quoteTable(v.datasetID, targetTable), sumColumns, "BIGNUMERIC", v.gen.QuoteIdent), keyRange.Predicate(v.gen.QuoteIdent))
+	it, err := v.bqClient.Query(query).Read(v.ctx)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	var row []bigquery.Value
+	if err = it.Next(&row); err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	rows, _ := row[0].(int64)
+	aggregates := &validation.Aggregates{Rows: rows}
+	for i, column := range sumColumns {
+		// the sum of an empty table is NULL
+		sum := "0"
+		if value, ok := row[i+1].(*big.Rat); ok && value != nil {
+			sum = value.FloatString(column.Scale)
+		}
+		aggregates.Sums = append(aggregates.Sums, sum)
+	}
+	return aggregates, nil
+}
+
+// ScanRange returns the values of the columns of the rows in the range as strings
+func (v *TableVerifier) ScanRange(targetTable string, columns, pkColumns []string, keyRange validation.KeyRange, limit int) ([]validation.Row, error) {
+	query := validation.GenScanQuery(v.gen.quoteTable(v.datasetID, targetTable), columns, pkColumns, "STRING", limit, v.gen.QuoteIdent, keyRange.Predicate(v.gen.QuoteIdent))
+	it, err := v.bqClient.Query(query).Read(v.ctx)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	var rows []validation.Row
+	for {
+		var values []bigquery.Value
+		if err = it.Next(&values); err == iterator.Done {
+			return rows, nil
+		} else if err != nil {
+			return nil, diag.WrapSQL(err, query)
+		}
+		row := make(validation.Row, len(values))
+		for i, value := range values {
+			// the values are cast to STRING, a NULL is nil
+			if s, ok := value.(string); ok {
+				row[i] = sql.NullString{String: s, Valid: true}
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (v *TableVerifier) Close() {
+	v.bqClient.Close()
+}
@@ -0,0 +1,95 @@
+package cdc
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// Client requests the HTTP API of the TiCDC server of a pipeline
+type Client struct {
+	cdcHost string
+	cdcPort int
+	// transports are the transports of the servers requested over https by address, the API of a server without
+	// one is requested over http
+	transports map[string]*http.Transport
+	// group sends the requests to the healthy server of the TiCDC servers of NewServersClient, nil for a single
+	// server
+	group *serverGroup
+}
+
+// NewClient returns the client of the HTTP API of the TiCDC server, which is requested over https with the TLS
+// config, nil for http
+func NewClient(cdcHost string, cdcPort int, tlsConfig *utils.TLSConfig) (*Client, error) {
+	c := &Client{cdcHost: cdcHost, cdcPort: cdcPort, transports: make(map[string]*http.Transport)}
+	if tlsConfig != nil {
+		if err := c.addTLS(cdcHost, cdcPort, *tlsConfig); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return c, nil
+}
+
+// Addr returns the address of the TiCDC server requested
+func (c *Client) Addr() string {
+	return apiAddr(c.cdcHost, c.cdcPort)
+}
+
+// addTLS requests the HTTP API of the TiCDC server over https with the TLS config
+func (c *Client) addTLS(cdcHost string, cdcPort int, cfg utils.TLSConfig) error {
+	clientTLS, err := cfg.Build(cdcHost)
+	if err != nil {
+		return errors.Annotate(err, "Failed to configure TLS of TiCDC API")
+	}
+	c.transports[apiAddr(cdcHost, cdcPort)] = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: clientTLS,
+	}
+	return nil
+}
+
+func apiAddr(cdcHost string, cdcPort int) string {
+	return net.JoinHostPort(cdcHost, strconv.Itoa(cdcPort))
+}
+
+// baseURL returns the URL of the HTTP API of the TiCDC server, the requests of the servers of NewServersClient are
+// sent to the healthy server by the client of httpClient
+func (c *Client) baseURL() string {
+	return c.serverBaseURL(c.cdcHost, c.cdcPort)
+}
+
+// serverBaseURL returns the URL of the HTTP API of the TiCDC server itself
+func (c *Client) serverBaseURL(cdcHost string, cdcPort int) string {
+	scheme := "http"
+	if _, ok := c.transports[apiAddr(cdcHost, cdcPort)]; ok {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, apiAddr(cdcHost, cdcPort))
+}
+
+// httpClient returns the client of the HTTP API of the TiCDC server, 0 timeout means no timeout
+func (c *Client) httpClient(timeout time.Duration) *http.Client {
+	if c.group != nil {
+		return &http.Client{Timeout: timeout, Transport: c.group}
+	}
+	return c.serverClient(c.cdcHost, c.cdcPort, timeout)
+}
+
+// serverClient returns the client of the HTTP API of the TiCDC server itself
+func (c *Client) serverClient(cdcHost string, cdcPort int, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if transport, ok := c.transports[apiAddr(cdcHost, cdcPort)]; ok {
+		client.Transport = transport
+	}
+	return client
+}
+
+// annotateAPIError explains the failure of verifying the certificate of the TiCDC server
+func annotateAPIError(err error) error {
+	return errors.Trace(utils.AnnotateTLSError(err, "TiCDC API", "cdc"))
+}
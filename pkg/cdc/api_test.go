@@ -0,0 +1,61 @@
+package cdc_test
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITLS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":"v7.5.0"}`))
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	_, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	// the certificate of the server is not signed by the CAs of the system
+	client, err := cdc.NewClient("127.0.0.1", port, &utils.TLSConfig{})
+	require.NoError(t, err)
+	_, err = cdc.GetServerVersion(client)
+	require.ErrorContains(t, err, "TiCDC API")
+	require.ErrorContains(t, err, "--cdc.ssl-ca")
+
+	client, err = cdc.NewClient("127.0.0.1", port, &utils.TLSConfig{CA: caPath})
+	require.NoError(t, err)
+	version, err := cdc.GetServerVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, "v7.5.0", version)
+
+	// the certificate of the server is valid for 127.0.0.1 and example.com only
+	client, err = cdc.NewClient("localhost", port, &utils.TLSConfig{CA: caPath})
+	require.NoError(t, err)
+	_, err = cdc.GetServerVersion(client)
+	require.ErrorContains(t, err, "hostname verification of the TiCDC API connection failed")
+
+	client, err = cdc.NewClient("localhost", port, &utils.TLSConfig{SkipVerify: true})
+	require.NoError(t, err)
+	_, err = cdc.GetServerVersion(client)
+	require.NoError(t, err)
+
+	// the TLS config is of the client, another client of the server requests it over http
+	client, err = cdc.NewClient("127.0.0.1", port, nil)
+	require.NoError(t, err)
+	_, err = cdc.GetServerVersion(client)
+	require.Error(t, err)
+}
@@ -0,0 +1,294 @@
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap/errors"
+)
+
+// changefeedRequestTimeout bounds the requests of the changefeed status, which are made by the API service
+const changefeedRequestTimeout = 10 * time.Second
+
+// Changefeed identifies a changefeed of the TiCDC server
+type Changefeed struct {
+	ID        string
+	Namespace string
+	// Protocol is the protocol of the files written by the changefeed, "" if unknown
+	Protocol cdcreader.Protocol
+	// Config is the effective replica config of the changefeed as reported by TiCDC once it is created, nil if
+	// unknown
+	Config map[string]any
+}
+
+type changefeedDetail struct {
+	ID           string `json:"id"`
+	Namespace    string `json:"namespace"`
+	SinkURI      string `json:"sink_uri"`
+	StartTs      uint64 `json:"start_ts"`
+	CheckpointTs uint64 `json:"checkpoint_ts"`
+	State        string `json:"state"`
+	Error        *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+const (
+	// ChangefeedStateStopped is the state of a paused changefeed
+	ChangefeedStateStopped = "stopped"
+	// ChangefeedStateFailed is the state of a changefeed failed by an unretryable error, e.g. its checkpoint
+	// is behind the GC safe point
+	ChangefeedStateFailed = "failed"
+)
+
+// ChangefeedStatus is the state and the checkpoint of a changefeed
+type ChangefeedStatus struct {
+	State         string
+	CheckpointTSO uint64
+	// Error is the last error of the changefeed, empty if none
+	Error string
+}
+
+// RecoveryPolicy is what to do when the changefeed is stopped or failed
+type RecoveryPolicy string
+
+const (
+	// RecoveryNone only records the state of the changefeed, which is the default
+	RecoveryNone RecoveryPolicy = "none"
+	// RecoveryResume resumes the changefeed
+	RecoveryResume RecoveryPolicy = "resume"
+	// RecoveryFail fails the replication
+	RecoveryFail RecoveryPolicy = "fail"
+)
+
+func ParseRecoveryPolicy(s string) (RecoveryPolicy, error) {
+	switch policy := RecoveryPolicy(strings.ToLower(s)); policy {
+	case RecoveryNone, RecoveryResume, RecoveryFail:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown changefeed recovery policy %s, expected one of none, resume, fail", s)
+	}
+}
+
+// FindChangefeed returns the changefeed writing into the storage, the sink URI is compared without
+// the query string since TiCDC masks the credentials in it.
+func FindChangefeed(client *Client, storageURI *url.URL) (*Changefeed, error) {
+	changefeeds, err := listChangefeeds(client, func(sinkURI *url.URL) bool {
+		return sameStorageLocation(sinkURI, storageURI)
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(changefeeds) == 0 {
+		return nil, errors.Errorf("no changefeed writes into %s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
+	}
+	return changefeeds[0], nil
+}
+
+// CheckChangefeed returns the start TSO of the changefeed, found is false if the changefeed no longer exists or
+// writes into another storage than storageURI
+func CheckChangefeed(client *Client, changefeed *Changefeed, storageURI *url.URL) (startTSO uint64, found bool, err error) {
+	changefeeds, err := listChangefeeds(client, func(sinkURI *url.URL) bool {
+		return sameStorageLocation(sinkURI, storageURI)
+	})
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	for _, item := range changefeeds {
+		if item.ID != changefeed.ID || (changefeed.Namespace != "" && item.Namespace != changefeed.Namespace) {
+			continue
+		}
+		detail, err := getChangefeedDetail(client, item)
+		if err != nil {
+			return 0, false, errors.Trace(err)
+		}
+		return detail.StartTs, true, nil
+	}
+	return 0, false, nil
+}
+
+// FindChangefeedsWithin returns the changefeeds writing into the storage or any path under it, e.g. those left
+// by a previous replication of the workspace
+func FindChangefeedsWithin(client *Client, storageURI *url.URL) ([]*Changefeed, error) {
+	changefeeds, err := listChangefeeds(client, func(sinkURI *url.URL) bool {
+		return withinStorageLocation(sinkURI, storageURI)
+	})
+	return changefeeds, errors.Trace(err)
+}
+
+// listChangefeeds returns the changefeeds whose sink URI matches
+func listChangefeeds(client *Client, match func(sinkURI *url.URL) bool) ([]*Changefeed, error) {
+	var list struct {
+		Items []struct {
+			ID        string `json:"id"`
+			Namespace string `json:"namespace"`
+		} `json:"items"`
+	}
+	if err := getJSON(client, "api/v2/changefeeds", nil, &list); err != nil {
+		return nil, errors.Annotate(err, "list changefeeds failed")
+	}
+	var changefeeds []*Changefeed
+	for _, item := range list.Items {
+		changefeed := &Changefeed{ID: item.ID, Namespace: item.Namespace}
+		detail, err := getChangefeedDetail(client, changefeed)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sinkURI, err := url.Parse(detail.SinkURI)
+		if err != nil {
+			continue
+		}
+		if match(sinkURI) {
+			changefeeds = append(changefeeds, changefeed)
+		}
+	}
+	return changefeeds, nil
+}
+
+// GetChangefeedCheckpoint returns the checkpoint TSO of the changefeed, the changes committed before it are
+// written into the storage
+func GetChangefeedCheckpoint(client *Client, changefeed *Changefeed) (uint64, error) {
+	detail, err := getChangefeedDetail(client, changefeed)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return detail.CheckpointTs, nil
+}
+
+// GetChangefeedState returns the state of the changefeed, e.g. normal or stopped
+func GetChangefeedState(client *Client, changefeed *Changefeed) (string, error) {
+	detail, err := getChangefeedDetail(client, changefeed)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return detail.State, nil
+}
+
+// GetChangefeedStatus returns the state, the checkpoint and the last error of the changefeed
+func GetChangefeedStatus(client *Client, changefeed *Changefeed) (*ChangefeedStatus, error) {
+	detail, err := getChangefeedDetail(client, changefeed)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	status := &ChangefeedStatus{State: detail.State, CheckpointTSO: detail.CheckpointTs}
+	if detail.Error != nil {
+		status.Error = fmt.Sprintf("[%s] %s", detail.Error.Code, detail.Error.Message)
+	}
+	return status, nil
+}
+
+// PauseChangefeed stops the changefeed writing files, the changes are kept by TiCDC until it is resumed
+// as long as the GC TTL of TiCDC is not exceeded
+func PauseChangefeed(client *Client, changefeed *Changefeed) error {
+	err := postChangefeed(client, changefeed, "pause")
+	return errors.Annotatef(err, "pause changefeed %s failed", changefeed.ID)
+}
+
+// ResumeChangefeed resumes the paused changefeed from its checkpoint
+func ResumeChangefeed(client *Client, changefeed *Changefeed) error {
+	err := postChangefeed(client, changefeed, "resume")
+	return errors.Annotatef(err, "resume changefeed %s failed", changefeed.ID)
+}
+
+// RemoveChangefeed removes the changefeed, the files written by it are kept
+func RemoveChangefeed(client *Client, changefeed *Changefeed) error {
+	u, err := changefeedURL(client, changefeed)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := client.httpClient(changefeedRequestTimeout).Do(req)
+	if err != nil {
+		return errors.Annotatef(annotateAPIError(err), "remove changefeed %s failed", changefeed.ID)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("remove changefeed %s failed, status code: %d", changefeed.ID, resp.StatusCode)
+	}
+	return nil
+}
+
+// changefeedURL returns the URL of the changefeed in the API of TiCDC
+func changefeedURL(client *Client, changefeed *Changefeed, elem ...string) (string, error) {
+	u, err := url.JoinPath(client.baseURL(), append([]string{"api/v2/changefeeds", url.PathEscape(changefeed.ID)}, elem...)...)
+	if err != nil {
+		return "", errors.Annotate(err, "join url failed")
+	}
+	if changefeed.Namespace != "" {
+		u += "?" + url.Values{"namespace": []string{changefeed.Namespace}}.Encode()
+	}
+	return u, nil
+}
+
+func postChangefeed(client *Client, changefeed *Changefeed, action string) error {
+	u, err := changefeedURL(client, changefeed, action)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := client.httpClient(changefeedRequestTimeout).Post(u, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return annotateAPIError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func getChangefeedDetail(client *Client, changefeed *Changefeed) (*changefeedDetail, error) {
+	var query url.Values
+	if changefeed.Namespace != "" {
+		query = url.Values{"namespace": []string{changefeed.Namespace}}
+	}
+	var detail changefeedDetail
+	if err := getJSON(client, "api/v2/changefeeds/"+url.PathEscape(changefeed.ID), query, &detail); err != nil {
+		return nil, errors.Annotatef(err, "get changefeed %s failed", changefeed.ID)
+	}
+	return &detail, nil
+}
+
+func getJSON(client *Client, path string, query url.Values, v any) error {
+	u, err := url.JoinPath(client.baseURL(), path)
+	if err != nil {
+		return errors.Annotate(err, "join url failed")
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	resp, err := client.httpClient(changefeedRequestTimeout).Get(u)
+	if err != nil {
+		return annotateAPIError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("status code: %d", resp.StatusCode)
+	}
+	return errors.Trace(json.NewDecoder(resp.Body).Decode(v))
+}
+
+func sameStorageLocation(a, b *url.URL) bool {
+	return a.Scheme == b.Scheme && a.Host == b.Host &&
+		strings.TrimSuffix(a.Path, "/") == strings.TrimSuffix(b.Path, "/")
+}
+
+// withinStorageLocation tells whether a is the location b or under it
+func withinStorageLocation(a, b *url.URL) bool {
+	if sameStorageLocation(a, b) {
+		return true
+	}
+	return a.Scheme == b.Scheme && a.Host == b.Host &&
+		strings.HasPrefix(a.Path, strings.TrimSuffix(b.Path, "/")+"/")
+}
@@ -0,0 +1,210 @@
+package cdc_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindChangefeed(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"total":2,"items":[{"id":"other","namespace":"default"},{"id":"mine","namespace":"default"}]}`))
+	})
+	mux.HandleFunc("/api/v2/changefeeds/other", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"other","sink_uri":"s3://bucket/other/increment?protocol=csv","checkpoint_ts":1}`))
+	})
+	mux.HandleFunc("/api/v2/changefeeds/mine", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "default", r.URL.Query().Get("namespace"))
+		_, _ = w.Write([]byte(`{"id":"mine","sink_uri":"s3://bucket/ws/increment/?access-key=xxxxx&protocol=csv","checkpoint_ts":440000000000000000,"start_ts":430000000000000000}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	storageURI, err := url.Parse("s3://bucket/ws/increment?access-key=AKIA")
+	require.NoError(t, err)
+	changefeed, err := cdc.FindChangefeed(client, storageURI)
+	require.NoError(t, err)
+	require.Equal(t, &cdc.Changefeed{ID: "mine", Namespace: "default"}, changefeed)
+	checkpoint, err := cdc.GetChangefeedCheckpoint(client, changefeed)
+	require.NoError(t, err)
+	require.Equal(t, uint64(440000000000000000), checkpoint)
+
+	// the changefeed recorded in the workspace
+	startTSO, found, err := cdc.CheckChangefeed(client, &cdc.Changefeed{ID: "mine", Namespace: "default"}, storageURI)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(430000000000000000), startTSO)
+	_, found, err = cdc.CheckChangefeed(client, &cdc.Changefeed{ID: "other", Namespace: "default"}, storageURI)
+	require.NoError(t, err)
+	require.False(t, found)
+	_, found, err = cdc.CheckChangefeed(client, &cdc.Changefeed{ID: "removed"}, storageURI)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	storageURI, err = url.Parse("s3://bucket/missing/increment")
+	require.NoError(t, err)
+	_, err = cdc.FindChangefeed(client, storageURI)
+	require.ErrorContains(t, err, "no changefeed writes into s3://bucket/missing/increment")
+
+	// the changefeeds writing into the workspace
+	for path, expected := range map[string][]string{"ws": {"mine"}, "ws/increment": {"mine"}, "": {"other", "mine"}, "w": nil} {
+		storageURI, err = url.Parse("s3://bucket/" + path)
+		require.NoError(t, err)
+		changefeeds, err := cdc.FindChangefeedsWithin(client, storageURI)
+		require.NoError(t, err)
+		var ids []string
+		for _, changefeed := range changefeeds {
+			ids = append(ids, changefeed.ID)
+		}
+		require.Equal(t, expected, ids, path)
+	}
+}
+
+func TestPauseResumeChangefeed(t *testing.T) {
+	state := "normal"
+	removed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds/mine", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			require.Equal(t, "ns", r.URL.Query().Get("namespace"))
+			removed = true
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"mine","state":"` + state + `"}`))
+	})
+	mux.HandleFunc("/api/v2/changefeeds/mine/pause", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "ns", r.URL.Query().Get("namespace"))
+		state = cdc.ChangefeedStateStopped
+	})
+	mux.HandleFunc("/api/v2/changefeeds/mine/resume", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		state = "normal"
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	changefeed := &cdc.Changefeed{ID: "mine", Namespace: "ns"}
+	require.NoError(t, cdc.PauseChangefeed(client, changefeed))
+	got, err := cdc.GetChangefeedState(client, changefeed)
+	require.NoError(t, err)
+	require.Equal(t, cdc.ChangefeedStateStopped, got)
+	require.NoError(t, cdc.ResumeChangefeed(client, changefeed))
+	got, err = cdc.GetChangefeedState(client, changefeed)
+	require.NoError(t, err)
+	require.Equal(t, "normal", got)
+
+	err = cdc.PauseChangefeed(client, &cdc.Changefeed{ID: "missing"})
+	require.ErrorContains(t, err, "pause changefeed missing failed")
+
+	require.NoError(t, cdc.RemoveChangefeed(client, changefeed))
+	require.True(t, removed)
+	err = cdc.RemoveChangefeed(client, &cdc.Changefeed{ID: "missing"})
+	require.ErrorContains(t, err, "remove changefeed missing failed")
+}
+
+func TestGetChangefeedStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds/normal", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"normal","state":"normal","checkpoint_ts":440000000000000000}`))
+	})
+	mux.HandleFunc("/api/v2/changefeeds/failed", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"failed","state":"failed","checkpoint_ts":440000000000000000,` +
+			`"error":{"addr":"127.0.0.1:8300","code":"CDC:ErrGCTTLExceeded","message":"the checkpoint-ts is older than the GC safepoint"}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	status, err := cdc.GetChangefeedStatus(client, &cdc.Changefeed{ID: "normal"})
+	require.NoError(t, err)
+	require.Equal(t, &cdc.ChangefeedStatus{State: "normal", CheckpointTSO: 440000000000000000}, status)
+	status, err = cdc.GetChangefeedStatus(client, &cdc.Changefeed{ID: "failed"})
+	require.NoError(t, err)
+	require.Equal(t, cdc.ChangefeedStateFailed, status.State)
+	require.Equal(t, "[CDC:ErrGCTTLExceeded] the checkpoint-ts is older than the GC safepoint", status.Error)
+	_, err = cdc.GetChangefeedStatus(client, &cdc.Changefeed{ID: "missing"})
+	require.ErrorContains(t, err, "get changefeed missing failed")
+}
+
+func TestParseRecoveryPolicy(t *testing.T) {
+	policy, err := cdc.ParseRecoveryPolicy("Resume")
+	require.NoError(t, err)
+	require.Equal(t, cdc.RecoveryResume, policy)
+	_, err = cdc.ParseRecoveryPolicy("restart")
+	require.ErrorContains(t, err, "expected one of none, resume, fail")
+}
+
+func TestSinkURI(t *testing.T) {
+	storageURI, err := url.Parse("gcs://bucket/ws/increment?credentials-file=%2Fetc%2Fgcs.json")
+	require.NoError(t, err)
+	client, err := cdc.NewClient("127.0.0.1", 8300, nil)
+	require.NoError(t, err)
+	connector, err := cdc.NewCDCConnector(client, []string{"db.t"}, 0, storageURI, time.Minute, 64*1024*1024)
+	require.NoError(t, err)
+	require.Equal(t, "gs", connector.SinkURI.Scheme)
+	require.Equal(t, "bucket", connector.SinkURI.Host)
+	require.Equal(t, "/ws/increment", connector.SinkURI.Path)
+	query := connector.SinkURI.Query()
+	require.Equal(t, "/etc/gcs.json", query.Get("credentials-file"))
+	require.Equal(t, "1m0s", query.Get("flush-interval"))
+	require.Equal(t, "csv", query.Get("protocol"))
+	require.Empty(t, query.Get("enable-tidb-extension"))
+
+	require.NoError(t, connector.SetProtocol(cdcreader.ProtocolCanalJSON))
+	query = connector.SinkURI.Query()
+	require.Equal(t, "canal-json", query.Get("protocol"))
+	require.Equal(t, "true", query.Get("enable-tidb-extension"))
+	require.Equal(t, "/etc/gcs.json", query.Get("credentials-file"))
+}
+
+func TestSinkURIServerCredentials(t *testing.T) {
+	storageURI, err := url.Parse("s3://bucket/ws/increment?access-key=AKIA&secret-access-key=abc&region=us-west-2")
+	require.NoError(t, err)
+	sinkURI, err := cdc.GenSinkURI(storageURI, cdcreader.ProtocolCSV, time.Minute, 64*1024*1024, false)
+	require.NoError(t, err)
+	require.Equal(t, "abc", sinkURI.Query().Get("secret-access-key"))
+
+	// the TiCDC servers access the storage by their own credentials
+	sinkURI, err = cdc.GenSinkURI(storageURI, cdcreader.ProtocolCSV, time.Minute, 64*1024*1024, true)
+	require.NoError(t, err)
+	query := sinkURI.Query()
+	require.False(t, query.Has("access-key"))
+	require.False(t, query.Has("secret-access-key"))
+	require.Equal(t, "us-west-2", query.Get("region"))
+	require.Equal(t, "csv", query.Get("protocol"))
+
+	client, err := cdc.NewClient("127.0.0.1", 8300, nil)
+	require.NoError(t, err)
+	connector, err := cdc.NewCDCConnector(client, []string{"db.t"}, 0, storageURI, time.Minute, 64*1024*1024)
+	require.NoError(t, err)
+	require.Equal(t, "AKIA", connector.SinkURI.Query().Get("access-key"))
+	require.NoError(t, connector.SetServerCredentials(true))
+	require.False(t, connector.SinkURI.Query().Has("access-key"))
+}
@@ -0,0 +1,143 @@
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Compatibility is a TiCDC release tested with this tidb2dw build and the format of its schema files
+type Compatibility struct {
+	// Release is the major and minor version, e.g. v7.5
+	Release string
+	// SchemaFormat is the Version field of the schema files
+	SchemaFormat uint64
+}
+
+// CompatibilityMatrix lists the TiCDC releases tested with this tidb2dw build.
+// The releases write the same schema format, testdata has the schema files written by the cloud storage sink of each
+// release with and without output-column-id, and of v6.5, which writes no column ids.
+var CompatibilityMatrix = []Compatibility{
+	{Release: "v7.1", SchemaFormat: 1},
+	{Release: "v7.5", SchemaFormat: 1},
+	{Release: "v8.1", SchemaFormat: 1},
+}
+
+// minSupportedRelease is the first release supported by tidb2dw, see the known limitations in README
+var minSupportedRelease = release{major: 7, minor: 1}
+
+type release struct {
+	major, minor int
+}
+
+func (r release) String() string {
+	return fmt.Sprintf("v%d.%d", r.major, r.minor)
+}
+
+func (r release) less(other release) bool {
+	return r.major < other.major || (r.major == other.major && r.minor < other.minor)
+}
+
+var releasePattern = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+func parseRelease(version string) (release, error) {
+	matches := releasePattern.FindStringSubmatch(version)
+	if matches == nil {
+		return release{}, errors.Errorf("invalid TiCDC version %s", version)
+	}
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+	return release{major: major, minor: minor}, nil
+}
+
+// CheckServerVersion checks the version reported by the TiCDC server, it returns whether
+// the release is in CompatibilityMatrix, or an error if the release is not supported at all.
+func CheckServerVersion(version string) (bool, error) {
+	r, err := parseRelease(version)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if r.less(minSupportedRelease) {
+		return false, errors.Errorf("TiCDC %s is not supported, TiCDC %s or later is required", version, minSupportedRelease)
+	}
+	for _, c := range CompatibilityMatrix {
+		if c.Release == r.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func isSupportedSchemaFormat(format uint64) bool {
+	for _, c := range CompatibilityMatrix {
+		if c.SchemaFormat == format {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedSchemaFormatError is returned for a schema file written in a format unknown to this tidb2dw build
+type UnsupportedSchemaFormatError struct {
+	// CDCVersion is the version of the TiCDC server, empty if unknown, e.g. the changefeed is managed outside of tidb2dw
+	CDCVersion string
+	Format     uint64
+}
+
+func (e *UnsupportedSchemaFormatError) Error() string {
+	cdcVersion := "(unknown version)"
+	if r, err := parseRelease(e.CDCVersion); err == nil {
+		cdcVersion = r.String()
+	}
+	return fmt.Sprintf("TiCDC %s writes schema format %d which this tidb2dw build does not support", cdcVersion, e.Format)
+}
+
+// ParseTableDefinition parses a schema file written by the cloud storage sink of TiCDC. The format is
+// checked before the content is parsed, and the column flags are validated, so that a file of an unknown
+// format fails the replication instead of being misinterpreted, e.g. every column becomes nullable.
+// Fields added by newer releases are ignored, cdcVersion is only used in the errors.
+func ParseTableDefinition(content []byte, cdcVersion string) (cloudstorage.TableDefinition, error) {
+	var tableDef cloudstorage.TableDefinition
+	var header struct {
+		Version *uint64 `json:"Version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return tableDef, errors.Annotate(err, "Failed to parse schema file")
+	}
+	if header.Version == nil {
+		return tableDef, errors.New("schema file has no Version field, it is not written by the cloud storage sink of a supported TiCDC")
+	}
+	if !isSupportedSchemaFormat(*header.Version) {
+		return tableDef, &UnsupportedSchemaFormatError{CDCVersion: cdcVersion, Format: *header.Version}
+	}
+	if err := json.Unmarshal(content, &tableDef); err != nil {
+		return tableDef, errors.Annotatef(err, "Failed to parse schema file of format %d", *header.Version)
+	}
+	for _, col := range tableDef.Columns {
+		if !isColumnFlag(col.Nullable) || !isColumnFlag(col.IsPK) {
+			return tableDef, errors.Errorf("column %s has unexpected flags ColumnNullable=%q ColumnIsPk=%q in schema format %d",
+				col.Name, col.Nullable, col.IsPK, *header.Version)
+		}
+	}
+	return tableDef, nil
+}
+
+// isColumnFlag returns whether the value is a column flag written by TiCDC, which omits the flag with default value
+func isColumnFlag(value string) bool {
+	return value == "" || value == "true" || value == "false"
+}
+
+// HasColumnIDs returns whether the columns in the schema file have ids, TiCDC writes them only when the changefeed is
+// created with output-column-id, e.g. by tidb2dw, otherwise the columns are paired by name across the schema files
+func HasColumnIDs(tableDef cloudstorage.TableDefinition) bool {
+	for _, col := range tableDef.Columns {
+		if col.ID == "" {
+			return false
+		}
+	}
+	return len(tableDef.Columns) > 0
+}
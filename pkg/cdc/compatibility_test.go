@@ -0,0 +1,157 @@
+package cdc_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tidb/parser/types"
+	"github.com/pingcap/tiflow/cdc/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+// ordersDDLEvent is the DDL event of `CREATE TABLE orders (id INT PRIMARY KEY, note VARCHAR(255) DEFAULT 'none', amount DECIMAL(10,2) NOT NULL)`
+func ordersDDLEvent() *model.DDLEvent {
+	column := func(id int64, name string, tp byte, flen, decimal int, flag uint, defaultValue any) *timodel.ColumnInfo {
+		ft := types.NewFieldType(tp)
+		ft.SetFlen(flen)
+		ft.SetDecimal(decimal)
+		ft.SetFlag(flag)
+		return &timodel.ColumnInfo{ID: id, Name: timodel.NewCIStr(name), FieldType: *ft, DefaultValue: defaultValue}
+	}
+	tableInfo := &model.TableInfo{
+		TableInfo: &timodel.TableInfo{Columns: []*timodel.ColumnInfo{
+			column(1, "id", mysql.TypeLong, 11, 0, mysql.PriKeyFlag|mysql.NotNullFlag, nil),
+			column(2, "note", mysql.TypeVarchar, 255, 0, 0, "none"),
+			column(3, "amount", mysql.TypeNewDecimal, 10, 2, mysql.NotNullFlag, nil),
+		}},
+		Version:   446202347858149382,
+		TableName: model.TableName{Schema: "test", Table: "orders", TableID: 104},
+	}
+	return &model.DDLEvent{
+		TableInfo: tableInfo,
+		CommitTs:  tableInfo.Version,
+		Type:      timodel.ActionCreateTable,
+		Query:     "CREATE TABLE `orders` (`id` INT PRIMARY KEY,`note` VARCHAR(255) DEFAULT 'none',`amount` DECIMAL(10,2) NOT NULL)",
+	}
+}
+
+// schemaFixture is a schema file of the orders table written by the cloud storage sink of a TiCDC release
+type schemaFixture struct {
+	cdcVersion string
+	file       string
+	// hasColumnIDs tells whether the file is written by a changefeed with output-column-id, which is off by default
+	// and not available before v7.1
+	hasColumnIDs bool
+}
+
+var schemaFixtures = []schemaFixture{
+	{cdcVersion: "v6.5.3", file: "v6.5/schema.json", hasColumnIDs: false},
+	{cdcVersion: "v7.1.1", file: "v7.1/schema_column_id.json", hasColumnIDs: true},
+	{cdcVersion: "v7.1.1", file: "v7.1/schema_no_column_id.json", hasColumnIDs: false},
+	{cdcVersion: "v7.5.1", file: "v7.5/schema_column_id.json", hasColumnIDs: true},
+	{cdcVersion: "v7.5.1", file: "v7.5/schema_no_column_id.json", hasColumnIDs: false},
+}
+
+// TestSchemaFixtures checks the fixtures of v7.1 are the schema files written by the cloud storage sink of the TiCDC
+// this build depends on, a commit of release-7.1
+func TestSchemaFixtures(t *testing.T) {
+	for _, fixture := range schemaFixtures {
+		if !strings.HasPrefix(fixture.file, "v7.1/") {
+			continue
+		}
+		var tableDef cloudstorage.TableDefinition
+		tableDef.FromDDLEvent(ordersDDLEvent(), fixture.hasColumnIDs)
+		expected, err := tableDef.MarshalWithQuery()
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join("testdata", fixture.file))
+		require.NoError(t, err)
+		require.JSONEq(t, string(expected), string(content), fixture.file)
+	}
+}
+
+func TestParseTableDefinition(t *testing.T) {
+	for _, fixture := range schemaFixtures {
+		content, err := os.ReadFile(filepath.Join("testdata", fixture.file))
+		require.NoError(t, err)
+		tableDef, err := cdc.ParseTableDefinition(content, fixture.cdcVersion)
+		require.NoError(t, err, fixture.file)
+		require.Equal(t, uint64(1), tableDef.Version)
+		require.Equal(t, "test", tableDef.Schema)
+		require.Equal(t, "orders", tableDef.Table)
+		require.Equal(t, timodel.ActionCreateTable, tableDef.Type)
+		require.Len(t, tableDef.Columns, 3)
+		require.Equal(t, fixture.hasColumnIDs, cdc.HasColumnIDs(tableDef), fixture.file)
+
+		// the flags of the columns are written only when they differ from the defaults
+		require.Equal(t, "true", tableDef.Columns[0].IsPK, fixture.file)
+		require.Equal(t, "false", tableDef.Columns[0].Nullable, fixture.file)
+		require.Equal(t, "", tableDef.Columns[1].Nullable, fixture.file)
+		require.Equal(t, "none", tableDef.Columns[1].Default, fixture.file)
+		require.Equal(t, "false", tableDef.Columns[2].Nullable, fixture.file)
+		require.Equal(t, "2", tableDef.Columns[2].Scale, fixture.file)
+
+		// the checksum in the file name is of the content as written
+		var written cloudstorage.TableDefinition
+		written.FromDDLEvent(ordersDDLEvent(), fixture.hasColumnIDs)
+		expected, err := written.Sum32(nil)
+		require.NoError(t, err)
+		checksum, err := tableDef.Sum32(nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, checksum, fixture.file)
+	}
+}
+
+// TestSchemaFixturesOfReleases checks what the releases differ in: v6.5 writes the same format without the column
+// ids, which is why it is not supported, v7.5 writes them with output-column-id like v7.1
+func TestSchemaFixturesOfReleases(t *testing.T) {
+	v65, err := os.ReadFile(filepath.Join("testdata", "v6.5", "schema.json"))
+	require.NoError(t, err)
+	v75, err := os.ReadFile(filepath.Join("testdata", "v7.5", "schema_column_id.json"))
+	require.NoError(t, err)
+	require.NotContains(t, string(v65), "ColumnId")
+	require.Contains(t, string(v75), `"ColumnId": "1"`)
+}
+
+func TestParseTableDefinitionOfUnsupportedFormat(t *testing.T) {
+	// no TiCDC release writes another format yet, the Version field of a real file is changed
+	content, err := os.ReadFile(filepath.Join("testdata", "v7.5", "schema_column_id.json"))
+	require.NoError(t, err)
+	content = []byte(strings.Replace(string(content), `"Version": 1,`, `"Version": 2,`, 1))
+	_, err = cdc.ParseTableDefinition(content, "v9.1.0")
+	require.EqualError(t, err, "TiCDC v9.1 writes schema format 2 which this tidb2dw build does not support")
+	_, ok := errors.Cause(err).(*cdc.UnsupportedSchemaFormatError)
+	require.True(t, ok)
+
+	_, err = cdc.ParseTableDefinition(content, "")
+	require.EqualError(t, err, "TiCDC (unknown version) writes schema format 2 which this tidb2dw build does not support")
+
+	// unexpected flags are rejected instead of being treated as nullable
+	_, err = cdc.ParseTableDefinition([]byte(`{"Version":1,"TableColumns":[{"ColumnName":"id","ColumnNullable":"NO"}]}`), "v7.5.0")
+	require.ErrorContains(t, err, `column id has unexpected flags ColumnNullable="NO"`)
+	_, err = cdc.ParseTableDefinition([]byte(`{"Table":"orders"}`), "v7.5.0")
+	require.ErrorContains(t, err, "schema file has no Version field")
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	tested, err := cdc.CheckServerVersion("v7.5.1")
+	require.NoError(t, err)
+	require.True(t, tested)
+	tested, err = cdc.CheckServerVersion("v7.1.0-20230601")
+	require.NoError(t, err)
+	require.True(t, tested)
+	tested, err = cdc.CheckServerVersion("v8.5.0")
+	require.NoError(t, err)
+	require.False(t, tested)
+
+	_, err = cdc.CheckServerVersion("v6.5.3")
+	require.ErrorContains(t, err, "TiCDC v6.5.3 is not supported")
+	_, err = cdc.CheckServerVersion("nightly")
+	require.Error(t, err)
+}
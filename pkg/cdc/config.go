@@ -4,10 +4,22 @@ import (
 	"fmt"
 	"net/url"
 	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 )
 
 type FilterConfig struct {
-	Rules []string `json:"rules,omitempty"`
+	Rules        []string          `json:"rules,omitempty"`
+	EventFilters []EventFilterRule `json:"event_filters,omitempty"`
+}
+
+// EventFilterRule ignores the row changes of the tables matched whose values make the expressions true
+type EventFilterRule struct {
+	Matcher                  []string `json:"matcher"`
+	IgnoreInsertValueExpr    string   `json:"ignore_insert_value_expr,omitempty"`
+	IgnoreUpdateNewValueExpr string   `json:"ignore_update_new_value_expr,omitempty"`
+	IgnoreDeleteValueExpr    string   `json:"ignore_delete_value_expr,omitempty"`
 }
 
 type CSVConfig struct {
@@ -23,16 +35,19 @@ type SinkConfig struct {
 	CSVConfig          *CSVConfig          `json:"csv,omitempty"`
 	CloudStorageConfig *CloudStorageConfig `json:"cloud_storage_config,omitempty"`
 	DateSeparator      string              `json:"date_separator,omitempty"`
+	// OutputRawChangeEvent false makes TiCDC split an update of the primary key into a delete and an insert
+	OutputRawChangeEvent *bool `json:"output_raw_change_event,omitempty"`
 }
 
+// ReplicaConfig are the options of the changefeed required by tidb2dw
 type ReplicaConfig struct {
-	EnableOldValue bool          `json:"enable_old_value"`
-	Filter         *FilterConfig `json:"filter"`
-	Sink           *SinkConfig   `json:"sink"`
+	Filter *FilterConfig `json:"filter"`
+	Sink   *SinkConfig   `json:"sink"`
 }
 
 type ChangefeedConfig struct {
-	ReplicaConfig *ReplicaConfig `json:"replica_config"`
+	// ReplicaConfig is the ReplicaConfig merged with the ReplicaOverrides
+	ReplicaConfig map[string]any `json:"replica_config"`
 	SinkURI       string         `json:"sink_uri"`
 	StartTs       uint64         `json:"start_ts"`
 }
@@ -41,14 +56,38 @@ type SinkURIConfig struct {
 	storageUri    *url.URL
 	flushInterval time.Duration
 	fileSize      int64
-	protocol      string
+	protocol      cdcreader.Protocol
+	// serverCredentials is true if the TiCDC servers access the storage by their own credentials
+	serverCredentials bool
 }
 
 func (s *SinkURIConfig) genSinkURI() (*url.URL, error) {
-	values := s.storageUri.Query()
+	// TiCDC does not know tidb2dw specific parameters such as insecure-skip-tls,
+	// the TiCDC server has to trust the certificate of the endpoint by itself.
+	sinkURI := utils.StripTiDB2DWParams(s.storageUri)
+	if s.serverCredentials {
+		sinkURI = utils.StripStorageCredentials(sinkURI)
+	}
+	// TiCDC takes both gs:// and gcs://, gs:// is used for consistency with the workspace
+	if sinkURI.Scheme == "gcs" {
+		sinkURI.Scheme = "gs"
+	}
+	values := sinkURI.Query()
 	values.Add("flush-interval", s.flushInterval.String())
 	values.Add("file-size", fmt.Sprint(s.fileSize))
-	values.Add("protocol", s.protocol)
-	s.storageUri.RawQuery = values.Encode()
-	return s.storageUri, nil
+	values.Add("protocol", string(s.protocol))
+	if s.protocol == cdcreader.ProtocolCanalJSON {
+		// the commit ts of the rows is written by the TiDB extension only
+		values.Add("enable-tidb-extension", "true")
+	}
+	sinkURI.RawQuery = values.Encode()
+	return sinkURI, nil
+}
+
+// GenSinkURI returns the URI of the TiCDC cloud storage sink writing the files of the protocol into the storage, e.g.
+// for a changefeed created outside of the TiCDC API. The sink URI carries no credentials of the storage with
+// serverCredentials.
+func GenSinkURI(storageURI *url.URL, protocol cdcreader.Protocol, flushInterval time.Duration, fileSize int64, serverCredentials bool) (*url.URL, error) {
+	sinkURIConfig := &SinkURIConfig{storageUri: storageURI, flushInterval: flushInterval, fileSize: fileSize, protocol: protocol, serverCredentials: serverCredentials}
+	return sinkURIConfig.genSinkURI()
 }
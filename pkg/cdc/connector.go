@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"time"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tiflow/pkg/config"
@@ -17,26 +18,30 @@ import (
 )
 
 type CDCConnector struct {
-	cdcServer     string
+	client        *Client
 	tables        []string
 	startTSO      uint64
 	sinkURIConfig *SinkURIConfig
 	SinkURI       *url.URL
+	// eventFilters are the row changes ignored by the changefeed, e.g. of the other shards
+	eventFilters []EventFilterRule
+	// overrides are the options of the changefeed given by the user, nil if none
+	overrides ReplicaOverrides
 }
 
-func NewCDCConnector(cdcHost string, cdcPort int, tables []string, startTSO uint64, storageUri *url.URL, flushInterval time.Duration, fileSize int64) (*CDCConnector, error) {
+func NewCDCConnector(client *Client, tables []string, startTSO uint64, storageUri *url.URL, flushInterval time.Duration, fileSize int64) (*CDCConnector, error) {
 	sinkURIConfig := &SinkURIConfig{
 		storageUri:    storageUri,
 		flushInterval: flushInterval,
 		fileSize:      fileSize,
-		protocol:      "csv",
+		protocol:      cdcreader.ProtocolCSV,
 	}
 	sinkURI, err := sinkURIConfig.genSinkURI()
 	if err != nil {
 		return nil, err
 	}
 	return &CDCConnector{
-		cdcServer:     fmt.Sprintf("http://%s:%d", cdcHost, cdcPort),
+		client:        client,
 		tables:        tables,
 		startTSO:      startTSO,
 		sinkURIConfig: sinkURIConfig,
@@ -44,50 +49,121 @@ func NewCDCConnector(cdcHost string, cdcPort int, tables []string, startTSO uint
 	}, nil
 }
 
-func (c *CDCConnector) CreateChangefeed() error {
-	client := &http.Client{}
+// SetEventFilters makes the changefeed ignore the row changes matched by the rules, nil means no change is ignored
+func (c *CDCConnector) SetEventFilters(rules []EventFilterRule) {
+	c.eventFilters = rules
+}
+
+// SetProtocol makes the changefeed write the files in the protocol, which is CSV by default
+func (c *CDCConnector) SetProtocol(protocol cdcreader.Protocol) error {
+	c.sinkURIConfig.protocol = protocol
+	sinkURI, err := c.sinkURIConfig.genSinkURI()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.SinkURI = sinkURI
+	return nil
+}
+
+// SetServerCredentials makes the sink URI carry no credentials of the storage, the TiCDC servers access it by their
+// own, e.g. the IAM role of their instances, instead of the long-lived keys of the storage URI
+func (c *CDCConnector) SetServerCredentials(enabled bool) error {
+	c.sinkURIConfig.serverCredentials = enabled
+	sinkURI, err := c.sinkURIConfig.genSinkURI()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.SinkURI = sinkURI
+	return nil
+}
+
+// SetReplicaOverrides merges the options into the replica config of the changefeed, the options required by tidb2dw
+// take precedence
+func (c *CDCConnector) SetReplicaOverrides(overrides ReplicaOverrides) {
+	c.overrides = overrides
+}
+
+// CreateChangefeed creates the changefeed and returns it with its effective replica config, TiCDC generates its ID
+func (c *CDCConnector) CreateChangefeed() (*Changefeed, error) {
+	if protocol, ok := c.overrides.lookup("sink", "protocol"); ok && fmt.Sprint(protocol) != string(c.sinkURIConfig.protocol) {
+		return nil, errors.Errorf("sink.protocol = %v of the changefeed config conflicts with --cdc-protocol=%s", protocol, c.sinkURIConfig.protocol)
+	}
+	sinkConfig := &SinkConfig{
+		CSVConfig:          &CSVConfig{IncludeCommitTs: true, Quote: ""},
+		CloudStorageConfig: &CloudStorageConfig{OutputColumnID: putil.AddressOf(true)},
+		DateSeparator:      config.DateSeparatorDay.String(),
+	}
+	if len(c.eventFilters) > 0 {
+		// the filters of the shards match the update by its new row, it must be split for the delete of the old row
+		// to be written by the shard of the old primary key
+		sinkConfig.OutputRawChangeEvent = putil.AddressOf(false)
+	}
+	replicaConfig, err := c.overrides.merge(&ReplicaConfig{
+		Filter: &FilterConfig{Rules: c.tables, EventFilters: c.eventFilters},
+		Sink:   sinkConfig,
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	cfCfg := &ChangefeedConfig{
-		SinkURI: c.SinkURI.String(),
-		ReplicaConfig: &ReplicaConfig{
-			Filter: &FilterConfig{Rules: c.tables},
-			Sink: &SinkConfig{
-				CSVConfig:          &CSVConfig{IncludeCommitTs: true, Quote: ""},
-				CloudStorageConfig: &CloudStorageConfig{OutputColumnID: putil.AddressOf(true)},
-				DateSeparator:      config.DateSeparatorDay.String(),
-			},
-			EnableOldValue: false,
-		},
-		StartTs: 0,
+		SinkURI:       c.SinkURI.String(),
+		ReplicaConfig: replicaConfig,
+		StartTs:       0,
 	}
 	if c.startTSO != 0 {
 		cfCfg.StartTs = c.startTSO
 	}
 	bytesData, _ := json.Marshal(cfCfg)
-	url, err := url.JoinPath(c.cdcServer, "api/v2/changefeeds")
+	url, err := url.JoinPath(c.client.baseURL(), "api/v2/changefeeds")
 	if err != nil {
-		return errors.Annotate(err, "join url failed")
+		return nil, errors.Annotate(err, "join url failed")
 	}
 	httpReq, _ := http.NewRequest("POST", url, bytes.NewReader(bytesData))
-	resp, err := client.Do(httpReq)
+	resp, err := c.client.httpClient(0).Do(httpReq)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, annotateAPIError(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("create changefeed failed, status code: %d", resp.StatusCode)
+		return nil, errors.Errorf("create changefeed failed, status code: %d", resp.StatusCode)
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
 	respData := make(map[string]interface{})
 	if err = json.Unmarshal(body, &respData); err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	changefeedID := respData["id"].(string)
-	replicateConfig := respData["config"].(map[string]interface{})
+	changefeedID, _ := respData["id"].(string)
+	namespace, _ := respData["namespace"].(string)
+	replicateConfig, _ := respData["config"].(map[string]interface{})
 	log.Info("create changefeed success", zap.String("changefeed-id", changefeedID), zap.Any("replica-config", replicateConfig))
 
-	return nil
+	return &Changefeed{ID: changefeedID, Namespace: namespace, Protocol: c.sinkURIConfig.protocol, Config: replicateConfig}, nil
+}
+
+// GetServerVersion returns the version reported by the TiCDC server, e.g. v7.5.0
+func GetServerVersion(client *Client) (string, error) {
+	url, err := url.JoinPath(client.baseURL(), "api/v1/status")
+	if err != nil {
+		return "", errors.Annotate(err, "join url failed")
+	}
+	resp, err := client.httpClient(0).Get(url)
+	if err != nil {
+		return "", annotateAPIError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("get TiCDC status failed, status code: %d", resp.StatusCode)
+	}
+	var status struct {
+		Version string `json:"version"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return "", errors.Trace(err)
+	}
+	return status.Version, nil
 }
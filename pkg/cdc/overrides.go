@@ -0,0 +1,161 @@
+package cdc
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/config"
+)
+
+// ReplicaOverrides are the options of the changefeed read from --changefeed-config, which is a changefeed config
+// file of TiCDC, e.g.
+//
+//	memory-quota = 1073741824
+//	[[filter.event-filters]]
+//	matcher = ["db.t"]
+//	ignore-event = ["delete"]
+//	[sink.cloud-storage-config]
+//	file-expiration-days = 7
+//
+// The keys are those of the replica config of the TiCDC API, in which "-" is written as "_".
+type ReplicaOverrides map[string]any
+
+// replicaDefaults are the options set by tidb2dw unless they are overridden
+var replicaDefaults = map[string]any{"enable_old_value": false}
+
+// loaderOptions are the options of the files written by the changefeed which tidb2dw loads them by, an override
+// must keep the value
+var loaderOptions = []struct {
+	path  []string
+	value any
+}{
+	{[]string{"sink", "date_separator"}, config.DateSeparatorDay.String()},
+	{[]string{"sink", "file_index_width"}, config.DefaultFileIndexWidth},
+	{[]string{"sink", "csv", "delimiter"}, ","},
+	{[]string{"sink", "csv", "quote"}, ""},
+	{[]string{"sink", "csv", "null"}, `\N`},
+	{[]string{"sink", "csv", "include_commit_ts"}, true},
+	{[]string{"sink", "csv", "output_old_value"}, false},
+	{[]string{"sink", "csv", "output_handle_key"}, false},
+	{[]string{"sink", "cloud_storage_config", "output_column_id"}, true},
+	{[]string{"sink", "delete_only_output_handle_key_columns"}, false},
+}
+
+// LoadReplicaOverrides reads the changefeed config file and rejects the options the files written by the changefeed
+// would not be loaded with
+func LoadReplicaOverrides(path string) (ReplicaOverrides, error) {
+	var file map[string]any
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse changefeed config file %s", path)
+	}
+	overrides := ReplicaOverrides(normalizeKeys(file).(map[string]any))
+	if err := overrides.validate(); err != nil {
+		return nil, errors.Annotatef(err, "invalid changefeed config file %s", path)
+	}
+	return overrides, nil
+}
+
+func (o ReplicaOverrides) validate() error {
+	if _, ok := o.lookup("filter", "rules"); ok {
+		return errors.New("filter.rules is not supported, the tables are given by --table and --table-pattern")
+	}
+	// the protocol must be the one given by --cdc-protocol, see CDCConnector.CreateChangefeed
+	if protocol, ok := o.lookup("sink", "protocol"); ok {
+		if _, err := cdcreader.ParseProtocol(fmt.Sprint(protocol)); err != nil || fmt.Sprint(protocol) == "" {
+			return errors.Errorf("sink.protocol = %v is not supported, tidb2dw loads the files written with sink.protocol = csv or canal-json", protocol)
+		}
+	}
+	for _, option := range loaderOptions {
+		value, ok := o.lookup(option.path...)
+		if ok && fmt.Sprint(value) != fmt.Sprint(option.value) {
+			key := strings.Join(option.path, ".")
+			return errors.Errorf("%s = %v is not supported, tidb2dw loads the files written with %s = %v", key, value, key, option.value)
+		}
+	}
+	return nil
+}
+
+// lookup returns the option of the path of keys
+func (o ReplicaOverrides) lookup(path ...string) (any, bool) {
+	var value any = map[string]any(o)
+	for _, key := range path {
+		options, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = options[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// merge returns the replica config of the overrides with the options of tidb2dw, which take precedence over them.
+// The event filters of both are kept.
+func (o ReplicaOverrides) merge(replicaConfig *ReplicaConfig) (map[string]any, error) {
+	data, err := json.Marshal(replicaConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var required map[string]any
+	if err = json.Unmarshal(data, &required); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return mergeOptions(mergeOptions(replicaDefaults, o), required), nil
+}
+
+// mergeOptions returns the options of base overridden by those of overrides, the tables of options are merged
+func mergeOptions(base, overrides map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		switch overrideValue := value.(type) {
+		case map[string]any:
+			if baseValue, ok := merged[key].(map[string]any); ok {
+				merged[key] = mergeOptions(baseValue, overrideValue)
+				continue
+			}
+		case []any:
+			// the event filters of the shards are added to those of the config file
+			if baseValue, ok := merged[key].([]any); ok && key == "event_filters" {
+				merged[key] = append(slices.Clone(baseValue), overrideValue...)
+				continue
+			}
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// normalizeKeys replaces "-" in the keys of the TOML tables with "_" of the TiCDC API
+func normalizeKeys(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(value))
+		for key, item := range value {
+			normalized[strings.ReplaceAll(key, "-", "_")] = normalizeKeys(item)
+		}
+		return normalized
+	case []map[string]any:
+		normalized := make([]any, 0, len(value))
+		for _, item := range value {
+			normalized = append(normalized, normalizeKeys(item))
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, 0, len(value))
+		for _, item := range value {
+			normalized = append(normalized, normalizeKeys(item))
+		}
+		return normalized
+	default:
+		return value
+	}
+}
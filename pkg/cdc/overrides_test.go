@@ -0,0 +1,122 @@
+package cdc_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/stretchr/testify/require"
+)
+
+func writeChangefeedConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "changefeed.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadReplicaOverrides(t *testing.T) {
+	for content, expected := range map[string]string{
+		"[sink]\nprotocol = \"avro\"":                           "sink.protocol = avro is not supported",
+		"[sink]\ndelete-only-output-handle-key-columns = true":  "sink.delete_only_output_handle_key_columns = true is not supported",
+		"[sink.csv]\ninclude-commit-ts = false":                 "sink.csv.include_commit_ts = false is not supported",
+		"[sink.csv]\ndelimiter = \"|\"":                         "sink.csv.delimiter = | is not supported",
+		"[sink]\ndate-separator = \"month\"":                    "sink.date_separator = month is not supported",
+		"[sink]\nfile-index-width = 6":                          "sink.file_index_width = 6 is not supported",
+		"[sink.cloud-storage-config]\noutput-column-id = false": "sink.cloud_storage_config.output_column_id = false is not supported",
+		"[filter]\nrules = [\"db.*\"]":                          "filter.rules is not supported",
+		"memory-quota = ":                                       "Failed to parse changefeed config file",
+	} {
+		_, err := cdc.LoadReplicaOverrides(writeChangefeedConfig(t, content))
+		require.ErrorContains(t, err, expected, content)
+	}
+
+	// the options of tidb2dw may be repeated
+	overrides, err := cdc.LoadReplicaOverrides(writeChangefeedConfig(t, "[sink]\nprotocol = \"csv\"\n[sink.csv]\ninclude-commit-ts = true"))
+	require.NoError(t, err)
+	require.Equal(t, cdc.ReplicaOverrides{"sink": map[string]any{"protocol": "csv", "csv": map[string]any{"include_commit_ts": true}}}, overrides)
+}
+
+func TestCreateChangefeedWithOverrides(t *testing.T) {
+	var request map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		config, err := json.Marshal(request["replica_config"])
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(`{"id":"mine","namespace":"default","config":` + string(config) + `}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	overrides, err := cdc.LoadReplicaOverrides(writeChangefeedConfig(t, `
+memory-quota = 1073741824
+enable-old-value = true
+
+[[filter.event-filters]]
+matcher = ["db.t"]
+ignore-event = ["delete"]
+
+[sink.cloud-storage-config]
+file-expiration-days = 7
+`))
+	require.NoError(t, err)
+	storageURI, err := url.Parse("s3://bucket/ws/increment")
+	require.NoError(t, err)
+	connector, err := cdc.NewCDCConnector(client, []string{"db.t"}, 0, storageURI, time.Minute, 64*1024*1024)
+	require.NoError(t, err)
+	connector.SetReplicaOverrides(overrides)
+	connector.SetEventFilters([]cdc.EventFilterRule{{Matcher: []string{"db.t"}, IgnoreInsertValueExpr: "id % 2 != 0"}})
+	changefeed, err := connector.CreateChangefeed()
+	require.NoError(t, err)
+	require.Equal(t, "mine", changefeed.ID)
+
+	// the options of the file are merged with those of tidb2dw, and the event filters of both are kept
+	replicaConfig := request["replica_config"].(map[string]any)
+	require.Equal(t, replicaConfig, changefeed.Config)
+	require.Equal(t, float64(1073741824), replicaConfig["memory_quota"])
+	require.Equal(t, true, replicaConfig["enable_old_value"])
+	filter := replicaConfig["filter"].(map[string]any)
+	require.Equal(t, []any{"db.t"}, filter["rules"])
+	eventFilters := filter["event_filters"].([]any)
+	require.Len(t, eventFilters, 2)
+	require.Equal(t, []any{"delete"}, eventFilters[0].(map[string]any)["ignore_event"])
+	require.Equal(t, "id % 2 != 0", eventFilters[1].(map[string]any)["ignore_insert_value_expr"])
+	sink := replicaConfig["sink"].(map[string]any)
+	require.Equal(t, map[string]any{"file_expiration_days": float64(7), "output_column_id": true}, sink["cloud_storage_config"])
+	require.Equal(t, true, sink["csv"].(map[string]any)["include_commit_ts"])
+
+	// without overrides the old value is disabled
+	connector.SetReplicaOverrides(nil)
+	connector.SetEventFilters(nil)
+	_, err = connector.CreateChangefeed()
+	require.NoError(t, err)
+	replicaConfig = request["replica_config"].(map[string]any)
+	require.Equal(t, false, replicaConfig["enable_old_value"])
+	require.NotContains(t, replicaConfig, "memory_quota")
+
+	// the protocol of the file must be the one of the changefeed
+	overrides, err = cdc.LoadReplicaOverrides(writeChangefeedConfig(t, "[sink]\nprotocol = \"canal-json\""))
+	require.NoError(t, err)
+	connector.SetReplicaOverrides(overrides)
+	_, err = connector.CreateChangefeed()
+	require.ErrorContains(t, err, "conflicts with --cdc-protocol=csv")
+	require.NoError(t, connector.SetProtocol(cdcreader.ProtocolCanalJSON))
+	changefeed, err = connector.CreateChangefeed()
+	require.NoError(t, err)
+	require.Equal(t, cdcreader.ProtocolCanalJSON, changefeed.Protocol)
+}
@@ -0,0 +1,253 @@
+package cdc
+
+import (
+	stderrors "errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// DefaultPort is the port of the HTTP API of a TiCDC server given without one
+const DefaultPort = 8300
+
+// probeTimeout is the timeout of probing whether a TiCDC server is healthy
+const probeTimeout = 5 * time.Second
+
+// Server is a TiCDC server of --cdc.server
+type Server struct {
+	Host  string
+	Port  int
+	HTTPS bool
+	// SRV is the DNS name whose SRV records are the servers, Host and Port are empty if it is set
+	SRV string
+}
+
+// ParseServers parses the comma separated addresses of the TiCDC servers, each is [http://|https://]<host>[:<port>]
+// with an IPv6 host in brackets, e.g. http://[2001:db8::1]:8300, or srv+http://<name> or srv+https://<name> for the
+// servers of the DNS SRV records of the name
+func ParseServers(s string) ([]Server, error) {
+	var servers []Server
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		server, err := parseServer(addr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		return nil, errors.Errorf("invalid TiCDC server %s, no address", s)
+	}
+	return servers, nil
+}
+
+func parseServer(addr string) (Server, error) {
+	raw := addr
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Server{}, errors.Annotatef(err, "invalid TiCDC server %s", addr)
+	}
+	if u.User != nil || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return Server{}, errors.Errorf("invalid TiCDC server %s, expected [http://|https://]<host>[:<port>]", addr)
+	}
+	var server Server
+	switch u.Scheme {
+	case "http", "https":
+		server.HTTPS = u.Scheme == "https"
+	case "srv+http", "srv+https":
+		if u.Port() != "" || u.Hostname() == "" {
+			return Server{}, errors.Errorf("invalid TiCDC server %s, expected %s://<name> without port", addr, u.Scheme)
+		}
+		return Server{SRV: u.Hostname(), HTTPS: u.Scheme == "srv+https"}, nil
+	default:
+		return Server{}, errors.Errorf("invalid TiCDC server %s, unknown scheme %s", addr, u.Scheme)
+	}
+	// an IPv6 host without brackets is split at its last colon as the port
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return Server{}, errors.Errorf("invalid TiCDC server %s, an IPv6 host must be in brackets, e.g. [2001:db8::1]:8300", addr)
+	}
+	if server.Host = u.Hostname(); server.Host == "" {
+		return Server{}, errors.Errorf("invalid TiCDC server %s, no host", addr)
+	}
+	server.Port = DefaultPort
+	if port := u.Port(); port != "" {
+		if server.Port, err = strconv.Atoi(port); err != nil || server.Port <= 0 || server.Port > 65535 {
+			return Server{}, errors.Errorf("invalid TiCDC server %s, invalid port %s", addr, port)
+		}
+	}
+	return server, nil
+}
+
+// resolveServers looks up the servers of the DNS SRV records, in the order of their priorities and weights
+func resolveServers(servers []Server) ([]Server, error) {
+	var resolved []Server
+	for _, server := range servers {
+		if server.SRV == "" {
+			resolved = append(resolved, server)
+			continue
+		}
+		_, records, err := net.LookupSRV("", "", server.SRV)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Failed to look up the SRV records of TiCDC server %s", server.SRV)
+		}
+		for _, record := range records {
+			resolved = append(resolved, Server{Host: strings.TrimSuffix(record.Target, "."), Port: int(record.Port), HTTPS: server.HTTPS})
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, errors.New("no TiCDC server is found by the SRV records")
+	}
+	return resolved, nil
+}
+
+// NewServersClient returns the client of the HTTP API of the TiCDC servers, the TLS config is of the servers over
+// https, nil for none unless the scheme of the server is https. The first healthy server found by GET
+// /api/v2/status is requested, and another one once it becomes unreachable.
+func NewServersClient(servers []Server, tlsConfig *utils.TLSConfig) (*Client, error) {
+	servers, err := resolveServers(servers)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	first := servers[0]
+	client := &Client{cdcHost: first.Host, cdcPort: first.Port, transports: make(map[string]*http.Transport)}
+	for _, server := range servers {
+		if !server.HTTPS && tlsConfig == nil {
+			continue
+		}
+		var serverTLS utils.TLSConfig
+		if tlsConfig != nil {
+			serverTLS = *tlsConfig
+		}
+		if err = client.addTLS(server.Host, server.Port, serverTLS); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if len(servers) > 1 {
+		client.group = &serverGroup{client: client, servers: servers, current: -1}
+	}
+	return client, nil
+}
+
+// serverGroup sends the requests of the API to the healthy server of the TiCDC servers
+type serverGroup struct {
+	// client has the transports of the servers over https
+	client  *Client
+	mu      sync.Mutex
+	servers []Server
+	// current is the server requested, -1 until the servers are probed
+	current int
+}
+
+// pick returns the server requested, the servers are probed on the first request
+func (g *serverGroup) pick() Server {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.current < 0 {
+		g.current = 0
+		for i, server := range g.servers {
+			if err := g.client.probeServer(server); err != nil {
+				log.Warn("TiCDC server is unhealthy", zap.String("server", apiAddr(server.Host, server.Port)), zap.Error(err))
+				continue
+			}
+			g.current = i
+			break
+		}
+		server := g.servers[g.current]
+		log.Info("Requesting TiCDC server", zap.String("server", apiAddr(server.Host, server.Port)))
+	}
+	return g.servers[g.current]
+}
+
+// failover switches from the server failed to the next healthy server, it returns false if none is healthy
+func (g *serverGroup) failover(failed Server) (Server, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// another request has switched already
+	if g.servers[g.current] != failed {
+		return g.servers[g.current], true
+	}
+	for i := 1; i < len(g.servers); i++ {
+		next := (g.current + i) % len(g.servers)
+		if g.client.probeServer(g.servers[next]) == nil {
+			log.Warn("TiCDC server is unreachable, failing over",
+				zap.String("from", apiAddr(failed.Host, failed.Port)),
+				zap.String("to", apiAddr(g.servers[next].Host, g.servers[next].Port)))
+			g.current = next
+			return g.servers[next], true
+		}
+	}
+	return Server{}, false
+}
+
+// RoundTrip sends the request to the server picked, and to the next healthy server if it fails. The request is
+// sent again only if it is a GET or it is not sent at all, so that a changefeed is not created twice.
+func (g *serverGroup) RoundTrip(req *http.Request) (*http.Response, error) {
+	server := g.pick()
+	resp, err := g.client.sendToServer(req, server)
+	if err == nil {
+		return resp, nil
+	}
+	next, ok := g.failover(server)
+	var opErr *net.OpError
+	if !ok || (req.Method != http.MethodGet && !(stderrors.As(err, &opErr) && opErr.Op == "dial")) {
+		return nil, err
+	}
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, err
+		}
+		body, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return g.client.sendToServer(req, next)
+}
+
+// sendToServer sends the request to the server, over https if it has a TLS transport
+func (c *Client) sendToServer(req *http.Request, server Server) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Host = ""
+	req.URL.Host = apiAddr(server.Host, server.Port)
+	req.URL.Scheme = "http"
+	var transport http.RoundTripper = http.DefaultTransport
+	if serverTransport, ok := c.transports[req.URL.Host]; ok {
+		req.URL.Scheme = "https"
+		transport = serverTransport
+	}
+	return transport.RoundTrip(req)
+}
+
+// probeServer checks the server is healthy by its status
+func (c *Client) probeServer(server Server) error {
+	client := c.serverClient(server.Host, server.Port, probeTimeout)
+	resp, err := client.Get(c.serverBaseURL(server.Host, server.Port) + "/api/v2/status")
+	if err != nil {
+		return annotateAPIError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("get TiCDC status failed, status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cdc_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServers(t *testing.T) {
+	servers, err := cdc.ParseServers("http://[2001:db8::1]:8301, https://cdc.example.com,10.0.0.1,[::1]")
+	require.NoError(t, err)
+	require.Equal(t, []cdc.Server{
+		{Host: "2001:db8::1", Port: 8301},
+		{Host: "cdc.example.com", Port: cdc.DefaultPort, HTTPS: true},
+		{Host: "10.0.0.1", Port: cdc.DefaultPort},
+		{Host: "::1", Port: cdc.DefaultPort},
+	}, servers)
+
+	servers, err = cdc.ParseServers("srv+https://_ticdc._tcp.example.com")
+	require.NoError(t, err)
+	require.Equal(t, []cdc.Server{{SRV: "_ticdc._tcp.example.com", HTTPS: true}}, servers)
+
+	for _, invalid := range []string{"", "2001:db8::1", "ftp://cdc", "http://cdc:0", "http://cdc/api", "srv+http://name:8300"} {
+		_, err = cdc.ParseServers(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func newStatusServer(t *testing.T, version string) (*httptest.Server, int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":"` + version + `"}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	_, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	return server, port
+}
+
+func TestServerFailover(t *testing.T) {
+	first, firstPort := newStatusServer(t, "v7.5.0")
+	_, secondPort := newStatusServer(t, "v7.5.1")
+
+	client, err := cdc.NewServersClient([]cdc.Server{{Host: "127.0.0.1", Port: firstPort}, {Host: "127.0.0.1", Port: secondPort}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(firstPort)), client.Addr())
+
+	version, err := cdc.GetServerVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, "v7.5.0", version)
+
+	// the requests are sent to the second server once the first becomes unreachable
+	first.Close()
+	version, err = cdc.GetServerVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, "v7.5.1", version)
+	version, err = cdc.GetServerVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, "v7.5.1", version)
+
+	// the servers are of the client, another pipeline requesting the first server does not fail over
+	single, err := cdc.NewClient("127.0.0.1", firstPort, nil)
+	require.NoError(t, err)
+	_, err = cdc.GetServerVersion(single)
+	require.Error(t, err)
+}
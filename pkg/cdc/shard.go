@@ -0,0 +1,44 @@
+package cdc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// ShardEventFilters returns the event filters of the changefeed of the shard, which writes only the row changes of
+// the tables whose primary key hashes to the shard. tablePKs are the primary key columns of each table by its FQN.
+// A primary key always hashes to the same shard, so the changes of a row stay in order within its shard. An update
+// changing the primary key is split by TiCDC into a delete written by the old shard and an insert by the new one.
+func ShardEventFilters(tablePKs map[string][]string, shard, shards int) ([]EventFilterRule, error) {
+	if shard < 0 || shard >= shards {
+		return nil, errors.Errorf("invalid shard %d of %d shards", shard, shards)
+	}
+	tables := make([]string, 0, len(tablePKs))
+	for table := range tablePKs {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+	rules := make([]EventFilterRule, 0, len(tables))
+	for _, table := range tables {
+		pkColumns := tablePKs[table]
+		if len(pkColumns) == 0 {
+			return nil, errors.Errorf("table %s has no primary key to shard its changes by", table)
+		}
+		quoted := make([]string, 0, len(pkColumns))
+		for _, column := range pkColumns {
+			quoted = append(quoted, "`"+strings.ReplaceAll(column, "`", "``")+"`")
+		}
+		// the changes of the other shards are ignored
+		expr := fmt.Sprintf("crc32(concat_ws(',', %s)) %% %d != %d", strings.Join(quoted, ", "), shards, shard)
+		rules = append(rules, EventFilterRule{
+			Matcher:                  []string{table},
+			IgnoreInsertValueExpr:    expr,
+			IgnoreUpdateNewValueExpr: expr,
+			IgnoreDeleteValueExpr:    expr,
+		})
+	}
+	return rules, nil
+}
@@ -0,0 +1,93 @@
+package cdc_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardEventFilters(t *testing.T) {
+	rules, err := cdc.ShardEventFilters(map[string][]string{"db.t2": {"id"}, "db.t1": {"a", "b"}}, 1, 4)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, []string{"db.t1"}, rules[0].Matcher)
+	require.Equal(t, "crc32(concat_ws(',', `a`, `b`)) % 4 != 1", rules[0].IgnoreInsertValueExpr)
+	require.Equal(t, rules[0].IgnoreInsertValueExpr, rules[0].IgnoreUpdateNewValueExpr)
+	require.Equal(t, rules[0].IgnoreInsertValueExpr, rules[0].IgnoreDeleteValueExpr)
+	require.Equal(t, "crc32(concat_ws(',', `id`)) % 4 != 1", rules[1].IgnoreInsertValueExpr)
+
+	_, err = cdc.ShardEventFilters(map[string][]string{"db.t": nil}, 0, 2)
+	require.ErrorContains(t, err, "no primary key")
+	_, err = cdc.ShardEventFilters(map[string][]string{"db.t": {"id"}}, 2, 2)
+	require.Error(t, err)
+}
+
+func TestShardPrimaryKeyUpdate(t *testing.T) {
+	var request map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = w.Write([]byte(`{"id":"mine","namespace":"default"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+	storageURI, err := url.Parse("s3://bucket/ws/increment")
+	require.NoError(t, err)
+
+	// the update of the primary key from 1 to 4 moves the row across the shards
+	shardOf := func(id string) int { return int(crc32.ChecksumIEEE([]byte(id)) % 2) }
+	require.NotEqual(t, shardOf("1"), shardOf("4"))
+	// written reports whether the changefeed filtered by the expression writes the change of the row of the primary
+	// key, TiDB computes crc32 as IEEE
+	written := func(expr, id string) bool {
+		var shards, shard int
+		_, err := fmt.Sscanf(expr, "crc32(concat_ws(',', `id`)) %% %d != %d", &shards, &shard)
+		require.NoError(t, err)
+		return int(crc32.ChecksumIEEE([]byte(id))%uint32(shards)) == shard
+	}
+	var deletes, inserts []int
+	for shard := 0; shard < 2; shard++ {
+		rules, err := cdc.ShardEventFilters(map[string][]string{"db.t": {"id"}}, shard, 2)
+		require.NoError(t, err)
+		connector, err := cdc.NewCDCConnector(client, []string{"db.t"}, 0, storageURI, time.Minute, 64*1024*1024)
+		require.NoError(t, err)
+		connector.SetEventFilters(rules)
+		_, err = connector.CreateChangefeed()
+		require.NoError(t, err)
+		// the update is split into a delete of the old row and an insert of the new one before the filters
+		sink := request["replica_config"].(map[string]any)["sink"].(map[string]any)
+		require.Equal(t, false, sink["output_raw_change_event"])
+		if written(rules[0].IgnoreDeleteValueExpr, "1") {
+			deletes = append(deletes, shard)
+		}
+		if written(rules[0].IgnoreInsertValueExpr, "4") {
+			inserts = append(inserts, shard)
+		}
+	}
+	// the delete is written by the shard of the old primary key and the insert by the shard of the new one
+	require.Equal(t, []int{shardOf("1")}, deletes)
+	require.Equal(t, []int{shardOf("4")}, inserts)
+
+	// a changefeed not sharded writes the update as it is
+	connector, err := cdc.NewCDCConnector(client, []string{"db.t"}, 0, storageURI, time.Minute, 64*1024*1024)
+	require.NoError(t, err)
+	_, err = connector.CreateChangefeed()
+	require.NoError(t, err)
+	require.NotContains(t, request["replica_config"].(map[string]any)["sink"], "output_raw_change_event")
+}
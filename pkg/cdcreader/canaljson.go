@@ -0,0 +1,85 @@
+package cdcreader
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pingcap/errors"
+)
+
+// Row is a row change of a data file, as a row of the CSV files of TiCDC
+type Row struct {
+	// Flag is the operation of the row, I, U or D
+	Flag     string
+	Schema   string
+	Table    string
+	CommitTs uint64
+	// Values are the values of the columns by name, nil for NULL. A column missing is NULL too.
+	Values map[string]*string
+	// Types are the MySQL types of the columns by name, e.g. "int unsigned" or "blob"
+	Types map[string]string
+}
+
+// canalJSONMessage is a message of the canal-json files, see
+// https://docs.pingcap.com/tidb/stable/ticdc-canal-json
+type canalJSONMessage struct {
+	Database  string               `json:"database"`
+	Table     string               `json:"table"`
+	IsDDL     bool                 `json:"isDdl"`
+	Type      string               `json:"type"`
+	MySQLType map[string]string    `json:"mysqlType"`
+	Data      []map[string]*string `json:"data"`
+	TiDB      *struct {
+		CommitTs uint64 `json:"commitTs"`
+	} `json:"_tidb"`
+}
+
+// canalJSONFlags are the flags of the row changes by the event types of canal-json, the other events are skipped
+var canalJSONFlags = map[string]string{"INSERT": "I", "UPDATE": "U", "DELETE": "D"}
+
+// CanalJSONDecoder decodes the row changes of a canal-json file. The values of the columns are the strings written
+// by TiCDC, e.g. the index of an ENUM value and the bytes of a BLOB decoded as ISO-8859-1.
+type CanalJSONDecoder struct {
+	decoder *json.Decoder
+	// pending are the rows of the last message not returned yet
+	pending []Row
+}
+
+// NewCanalJSONDecoder returns the decoder of the messages read from r, which are separated by the terminator
+func NewCanalJSONDecoder(r io.Reader) *CanalJSONDecoder {
+	return &CanalJSONDecoder{decoder: json.NewDecoder(r)}
+}
+
+// Next returns the next row change, io.EOF if there is none. The DDL and the other events are skipped.
+func (d *CanalJSONDecoder) Next() (Row, error) {
+	for len(d.pending) == 0 {
+		var msg canalJSONMessage
+		if err := d.decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return Row{}, io.EOF
+			}
+			return Row{}, errors.Annotate(err, "Failed to decode canal-json message")
+		}
+		flag, ok := canalJSONFlags[msg.Type]
+		if msg.IsDDL || !ok {
+			continue
+		}
+		if msg.TiDB == nil {
+			return Row{}, errors.Errorf("The canal-json message of table %s.%s has no commit ts, "+
+				"the changefeed must write the files with enable-tidb-extension=true", msg.Database, msg.Table)
+		}
+		for _, values := range msg.Data {
+			d.pending = append(d.pending, Row{
+				Flag:     flag,
+				Schema:   msg.Database,
+				Table:    msg.Table,
+				CommitTs: msg.TiDB.CommitTs,
+				Values:   values,
+				Types:    msg.MySQLType,
+			})
+		}
+	}
+	row := d.pending[0]
+	d.pending = d.pending[1:]
+	return row, nil
+}
@@ -0,0 +1,108 @@
+package cdcreader
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+const canalJSONFile = `{"id":0,"database":"db","table":"t","pkNames":["id"],"isDdl":true,"type":"CREATE","es":0,"ts":0,"sql":"CREATE TABLE t (id INT PRIMARY KEY)","sqlType":null,"mysqlType":null,"data":null,"old":null,"_tidb":{"commitTs":400}}
+{"id":0,"database":"db","table":"t","pkNames":["id"],"isDdl":false,"type":"INSERT","es":0,"ts":0,"sql":"","sqlType":{},"mysqlType":{"id":"int","name":"varchar","e":"enum","s":"set","b":"blob"},"data":[{"id":"1","name":"a,b\nc\\d","e":"2","s":"5","b":"ÿ\u0001"},{"id":"2","name":null,"e":"0","s":"0","b":null}],"old":null,"_tidb":{"commitTs":401}}
+{"id":0,"database":"db","table":"t","pkNames":["id"],"isDdl":false,"type":"UPDATE","es":0,"ts":0,"sql":"","sqlType":{},"mysqlType":{"id":"int","name":"varchar","e":"enum","s":"set","b":"blob"},"data":[{"id":"1","name":"x","e":"1","s":"2","b":""}],"old":[{"name":"a,b\nc\\d"}],"_tidb":{"commitTs":402}}
+{"id":0,"database":"db","table":"t","pkNames":["id"],"isDdl":false,"type":"DELETE","es":0,"ts":0,"sql":"","sqlType":{},"mysqlType":{"id":"int","name":"varchar","e":"enum","s":"set","b":"blob"},"data":[{"id":"2","name":null,"e":"0","s":"0","b":null}],"old":null,"_tidb":{"commitTs":403}}
+`
+
+var (
+	testColumns = []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT"}, {Name: "name", Tp: "VARCHAR"}, {Name: "e", Tp: "ENUM"},
+		{Name: "s", Tp: "SET"}, {Name: "b", Tp: "BLOB"},
+	}
+	testElems = map[string][]string{"e": {"x", "y"}, "s": {"p", "q", "r"}}
+)
+
+func TestCanalJSONDecoder(t *testing.T) {
+	decoder := NewCanalJSONDecoder(strings.NewReader(canalJSONFile))
+	var flags []string
+	var commitTs []uint64
+	for {
+		row, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		require.Equal(t, "db", row.Schema)
+		require.Equal(t, "t", row.Table)
+		flags = append(flags, row.Flag)
+		commitTs = append(commitTs, row.CommitTs)
+	}
+	require.Equal(t, []string{"I", "I", "U", "D"}, flags)
+	require.Equal(t, []uint64{401, 401, 402, 403}, commitTs)
+
+	decoder = NewCanalJSONDecoder(strings.NewReader(
+		`{"database":"db","table":"t","isDdl":false,"type":"INSERT","data":[{"id":"1"}]}`))
+	_, err := decoder.Next()
+	require.ErrorContains(t, err, "enable-tidb-extension=true")
+}
+
+func TestCSVEncoder(t *testing.T) {
+	encoder := NewCSVEncoder(testColumns, testElems)
+	str := func(s string) *string { return &s }
+	line, err := encoder.Encode(Row{
+		Flag: "I", Schema: "db", Table: "t", CommitTs: 401,
+		Values: map[string]*string{"id": str("1"), "name": str("a,b\nc\\d"), "e": str("2"), "s": str("5"), "b": str("ÿ\u0001")},
+		Types:  map[string]string{"id": "int unsigned", "name": "varchar", "e": "enum", "s": "set", "b": "blob"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "I,t,db,401,1,a\\,b\\nc\\\\d,y,p\\,r,/wE=\r\n", string(line))
+
+	// the missing column is NULL
+	line, err = encoder.Encode(Row{
+		Flag: "D", Schema: "db", Table: "t", CommitTs: 403,
+		Values: map[string]*string{"id": str("2"), "e": str("0"), "s": str("0")},
+		Types:  map[string]string{"id": "int", "e": "enum", "s": "set"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "D,t,db,403,2,\\N,,,\\N\r\n", string(line))
+
+	_, err = encoder.Encode(Row{
+		Values: map[string]*string{"e": str("3")},
+		Types:  map[string]string{"e": "enum"},
+	})
+	require.ErrorContains(t, err, "Invalid ENUM value 3")
+
+	_, err = NewCSVEncoder(testColumns, nil).Encode(Row{
+		Values: map[string]*string{"s": str("1")},
+		Types:  map[string]string{"s": "set"},
+	})
+	require.ErrorContains(t, err, "are unknown")
+}
+
+func TestConvertToCSV(t *testing.T) {
+	ctx := context.Background()
+	for _, compression := range []utils.Compression{utils.CompressionNone, utils.CompressionGzip} {
+		extStorage, err := storage.NewLocalStorage(t.TempDir())
+		require.NoError(t, err)
+		compressed := storage.WithCompression(extStorage, compression.CompressType())
+		src := "db/t/400/2024-01-01/CDC000001.json" + compression.FileExtension()
+		dst := "db/t/400/2024-01-01/CDC000001" + compression.CSVFileExtension()
+		require.NoError(t, compressed.WriteFile(ctx, src, []byte(canalJSONFile)))
+
+		size, err := ConvertToCSV(ctx, extStorage, src, dst, NewCSVEncoder(testColumns, testElems), compression)
+		require.NoError(t, err)
+		raw, err := extStorage.ReadFile(ctx, dst)
+		require.NoError(t, err)
+		require.EqualValues(t, len(raw), size)
+		data, err := compressed.ReadFile(ctx, dst)
+		require.NoError(t, err)
+		require.Equal(t, "I,t,db,401,1,a\\,b\\nc\\\\d,y,p\\,r,/wE=\r\n"+
+			"I,t,db,401,2,\\N,,,\\N\r\n"+
+			"U,t,db,402,1,x,x,q,\r\n"+
+			"D,t,db,403,2,\\N,,,\\N\r\n", string(data))
+	}
+}
@@ -0,0 +1,182 @@
+package cdcreader
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+const (
+	// csvNull and csvTerminator are of the CSV files written by the changefeed created by tidb2dw, which quotes no
+	// value and escapes the delimiter, the line breaks and the backslash by a backslash
+	csvNull       = `\N`
+	csvTerminator = "\r\n"
+)
+
+var csvEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, ",", `\,`)
+
+// CSVEncoder writes the row changes as the rows of the CSV files of TiCDC: the flag, the table, the schema, the
+// commit ts and the values of the columns in order. Binary values are encoded by base64, ENUM and SET values by
+// their names.
+type CSVEncoder struct {
+	columns []cloudstorage.TableCol
+	// elems are the elements of the ENUM and SET columns by lowercase name
+	elems map[string][]string
+}
+
+// NewCSVEncoder returns the encoder of the rows of a table with the columns, elems are the elements of its ENUM and
+// SET columns by lowercase name, e.g. tidbsql.ColumnExprs.Elems
+func NewCSVEncoder(columns []cloudstorage.TableCol, elems map[string][]string) *CSVEncoder {
+	return &CSVEncoder{columns: columns, elems: elems}
+}
+
+// Encode returns the CSV row of the row change with the terminator
+func (e *CSVEncoder) Encode(row Row) ([]byte, error) {
+	var sb strings.Builder
+	sb.WriteString(row.Flag)
+	sb.WriteByte(',')
+	sb.WriteString(csvEscaper.Replace(row.Table))
+	sb.WriteByte(',')
+	sb.WriteString(csvEscaper.Replace(row.Schema))
+	sb.WriteByte(',')
+	sb.WriteString(strconv.FormatUint(row.CommitTs, 10))
+	for _, column := range e.columns {
+		sb.WriteByte(',')
+		value := row.Values[column.Name]
+		if value == nil {
+			sb.WriteString(csvNull)
+			continue
+		}
+		formatted, err := e.formatValue(column.Name, *value, row.Types[column.Name])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sb.WriteString(csvEscaper.Replace(formatted))
+	}
+	sb.WriteString(csvTerminator)
+	return []byte(sb.String()), nil
+}
+
+// formatValue returns the value as written by the CSV protocol of TiCDC
+func (e *CSVEncoder) formatValue(name, value, mysqlType string) (string, error) {
+	switch strings.TrimSuffix(strings.ToLower(mysqlType), " unsigned") {
+	case "tinyblob", "blob", "mediumblob", "longblob", "binary", "varbinary":
+		// canal-json decodes the bytes as ISO-8859-1, so every rune is a byte
+		bytes := make([]byte, 0, len(value))
+		for _, r := range value {
+			if r > 0xff {
+				return "", errors.Errorf("Invalid binary value of column %s", name)
+			}
+			bytes = append(bytes, byte(r))
+		}
+		return base64.StdEncoding.EncodeToString(bytes), nil
+	case "enum":
+		elems, err := e.columnElems(name)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		idx, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || idx > uint64(len(elems)) {
+			return "", errors.Errorf("Invalid ENUM value %s of column %s", value, name)
+		}
+		if idx == 0 {
+			// the invalid value inserted in non-strict SQL mode
+			return "", nil
+		}
+		return elems[idx-1], nil
+	case "set":
+		elems, err := e.columnElems(name)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		bits, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return "", errors.Errorf("Invalid SET value %s of column %s", value, name)
+		}
+		names := make([]string, 0, len(elems))
+		for i, elem := range elems {
+			if bits&(1<<i) != 0 {
+				names = append(names, elem)
+			}
+		}
+		return strings.Join(names, ","), nil
+	default:
+		return value, nil
+	}
+}
+
+func (e *CSVEncoder) columnElems(name string) ([]string, error) {
+	elems, ok := e.elems[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.Errorf("The elements of ENUM or SET column %s are unknown", name)
+	}
+	return elems, nil
+}
+
+// ConvertToCSV decodes the canal-json file src and writes its row changes into the CSV file dst by the encoder, both
+// compressed by compression. It returns the size of the CSV file in the storage.
+func ConvertToCSV(ctx context.Context, extStorage storage.ExternalStorage, src, dst string, encoder *CSVEncoder, compression utils.Compression) (int64, error) {
+	compressed := extStorage
+	if compression.CompressType() != storage.NoCompression {
+		compressed = storage.WithCompression(extStorage, compression.CompressType())
+	}
+	reader, err := compressed.Open(ctx, src)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	writer, err := compressed.Create(ctx, dst)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	buffered := bufio.NewWriter(&fileWriter{ctx: ctx, w: writer})
+	decoder := NewCanalJSONDecoder(reader)
+	for err == nil {
+		var row Row
+		if row, err = decoder.Next(); err != nil {
+			break
+		}
+		var line []byte
+		if line, err = encoder.Encode(row); err == nil {
+			_, err = buffered.Write(line)
+		}
+	}
+	if err == io.EOF {
+		err = buffered.Flush()
+	}
+	if closeErr := writer.Close(ctx); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, errors.Annotatef(err, "Failed to convert canal-json file %s", src)
+	}
+	return fileSize(ctx, extStorage, dst)
+}
+
+func fileSize(ctx context.Context, extStorage storage.ExternalStorage, path string) (int64, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	size, err := reader.Seek(0, io.SeekEnd)
+	return size, errors.Trace(err)
+}
+
+// fileWriter adapts storage.ExternalFileWriter to io.Writer
+type fileWriter struct {
+	ctx context.Context
+	w   storage.ExternalFileWriter
+}
+
+func (fw *fileWriter) Write(p []byte) (int, error) {
+	return fw.w.Write(fw.ctx, p)
+}
@@ -0,0 +1,79 @@
+// Package cdcreader reads the files written by the cloud storage sink of TiCDC in the protocols other than CSV,
+// and converts them into the CSV files of TiCDC that the data warehouses load.
+package cdcreader
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/config"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Protocol is the protocol of the files written by the changefeed
+type Protocol string
+
+const (
+	// ProtocolCSV is the CSV files loaded by the data warehouses as they are
+	ProtocolCSV Protocol = "csv"
+	// ProtocolCanalJSON is the canal-json files with the TiDB extension, which are converted into CSV files before
+	// they are loaded
+	ProtocolCanalJSON Protocol = "canal-json"
+)
+
+// ParseProtocol parses --cdc-protocol, empty means the protocol is detected
+func ParseProtocol(s string) (Protocol, error) {
+	switch Protocol(strings.ToLower(s)) {
+	case "":
+		return "", nil
+	case ProtocolCSV:
+		return ProtocolCSV, nil
+	case ProtocolCanalJSON:
+		return ProtocolCanalJSON, nil
+	default:
+		return "", errors.Errorf("unknown cdc protocol %s, expected one of csv, canal-json", s)
+	}
+}
+
+// FileExtension returns the extension of the data files of the protocol before the compression, e.g. `.json` for
+// `CDC000001.json.gz`
+func (p Protocol) FileExtension() string {
+	if p == ProtocolCanalJSON {
+		return ".json"
+	}
+	return ".csv"
+}
+
+// errDetected stops the walk of DetectProtocol
+var errDetected = errors.New("protocol detected")
+
+// DetectProtocol returns the protocol of the data files found in the storage, found is false if the changefeed has
+// written no data file yet. The canal-json files are converted into the CSV files next to them, so the protocol is
+// canal-json if any canal-json file is found.
+func DetectProtocol(ctx context.Context, extStorage storage.ExternalStorage) (protocol Protocol, found bool, err error) {
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		if cloudstorage.IsSchemaFile(path) {
+			return nil
+		}
+		var key cloudstorage.DmlPathKey
+		if _, err := key.ParseDMLFilePath(config.DateSeparatorDay.String(), path); err != nil {
+			return nil
+		}
+		// the compression is the last extension, e.g. CDC000001.json.gz
+		name := path[strings.LastIndex(path, "/")+1:]
+		switch {
+		case strings.Contains(name, ProtocolCanalJSON.FileExtension()):
+			protocol, found = ProtocolCanalJSON, true
+			return errDetected
+		case strings.Contains(name, ProtocolCSV.FileExtension()):
+			protocol, found = ProtocolCSV, true
+		}
+		return nil
+	})
+	if errors.Cause(err) == errDetected {
+		err = nil
+	}
+	return protocol, found, errors.Trace(err)
+}
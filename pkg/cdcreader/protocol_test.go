@@ -0,0 +1,47 @@
+package cdcreader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProtocol(t *testing.T) {
+	for s, expected := range map[string]Protocol{"": "", "csv": ProtocolCSV, "Canal-JSON": ProtocolCanalJSON} {
+		protocol, err := ParseProtocol(s)
+		require.NoError(t, err)
+		require.Equal(t, expected, protocol)
+	}
+	_, err := ParseProtocol("avro")
+	require.ErrorContains(t, err, "unknown cdc protocol avro")
+}
+
+func TestDetectProtocol(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	_, found, err := DetectProtocol(ctx, extStorage)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/meta/schema_400_0123456789.json", []byte("{}")))
+	require.NoError(t, extStorage.WriteFile(ctx, "metadata", []byte("{}")))
+	_, found, err = DetectProtocol(ctx, extStorage)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/400/2024-01-01/CDC000001.csv.gz", []byte("x")))
+	protocol, found, err := DetectProtocol(ctx, extStorage)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, ProtocolCSV, protocol)
+
+	// the CSV files converted from the canal-json files are next to them
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/400/2024-01-01/CDC000002.json.gz", []byte("x")))
+	protocol, found, err = DetectProtocol(ctx, extStorage)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, ProtocolCanalJSON, protocol)
+}
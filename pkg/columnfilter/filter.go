@@ -0,0 +1,147 @@
+package columnfilter
+
+import (
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Filter is which columns of a table are replicated to the data warehouse, either the columns in Include
+// or the columns not in Exclude. A nil Filter retains all the columns.
+type Filter struct {
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+}
+
+func containsFold(names []string, column string) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retains returns whether the column is replicated, the column names are case-insensitive as in TiDB.
+func (f *Filter) Retains(column string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Include) > 0 {
+		return containsFold(f.Include, column)
+	}
+	return !containsFold(f.Exclude, column)
+}
+
+// Columns returns the retained columns in their order in the table
+func (f *Filter) Columns(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+	if f == nil {
+		return columns
+	}
+	retained := make([]cloudstorage.TableCol, 0, len(columns))
+	for _, column := range columns {
+		if f.Retains(column.Name) {
+			retained = append(retained, column)
+		}
+	}
+	return retained
+}
+
+// TableDef returns a copy of tableDef with the retained columns only
+func (f *Filter) TableDef(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	if f == nil {
+		return tableDef
+	}
+	tableDef.Columns = f.Columns(tableDef.Columns)
+	tableDef.TotalColumns = len(tableDef.Columns)
+	return tableDef
+}
+
+// Check returns an error if the filter cannot be applied to the columns of the table: the rows are merged
+// into the data warehouse by the primary key, so it must be retained.
+func (f *Filter) Check(columns []cloudstorage.TableCol) error {
+	if f == nil {
+		return nil
+	}
+	retained := 0
+	for _, column := range columns {
+		if !f.Retains(column.Name) {
+			if column.IsPK == "true" {
+				return errors.Errorf("primary key column %s is filtered out, the primary key must be replicated", column.Name)
+			}
+			continue
+		}
+		retained++
+	}
+	if retained == 0 {
+		return errors.New("all the columns are filtered out")
+	}
+	return nil
+}
+
+// Unknown returns the filtered columns not in the columns of the table
+func (f *Filter) Unknown(columns []cloudstorage.TableCol) []string {
+	if f == nil {
+		return nil
+	}
+	var unknown []string
+	for _, name := range append(append([]string{}, f.Include...), f.Exclude...) {
+		found := false
+		for _, column := range columns {
+			if strings.EqualFold(name, column.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// Config is the column filters of the tables given by --column-filter, by the table full qualified name
+type Config map[string]*Filter
+
+// Table returns the column filter of the table, nil if all its columns are replicated
+func (c Config) Table(tableFQN string) *Filter {
+	return c[tableFQN]
+}
+
+type filterFile struct {
+	Tables map[string]*Filter `toml:"tables"`
+}
+
+// Load reads the column filters from the config file, e.g.
+//
+//	[tables."db.users"]
+//	exclude = ["email", "phone"]
+//
+//	[tables."db.orders"]
+//	include = ["id", "user_id", "amount", "created_at"]
+func Load(path string) (Config, error) {
+	var file filterFile
+	meta, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse column filter file %s", path)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, errors.Errorf("unknown keys %v in column filter file %s", undecoded, path)
+	}
+	config := make(Config, len(file.Tables))
+	for table, filter := range file.Tables {
+		if strings.Count(table, ".") != 1 {
+			return nil, errors.Errorf("invalid table %s in column filter file %s, expected <db>.<table>", table, path)
+		}
+		if len(filter.Include) > 0 && len(filter.Exclude) > 0 {
+			return nil, errors.Errorf("both include and exclude are given for table %s in column filter file %s, only one is allowed", table, path)
+		}
+		if len(filter.Include) == 0 && len(filter.Exclude) == 0 {
+			continue
+		}
+		config[table] = filter
+	}
+	return config, nil
+}
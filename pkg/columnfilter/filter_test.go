@@ -0,0 +1,83 @@
+package columnfilter_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFilterFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "filter.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func columnNames(columns []cloudstorage.TableCol) []string {
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, column.Name)
+	}
+	return names
+}
+
+func TestLoad(t *testing.T) {
+	config, err := columnfilter.Load(writeFilterFile(t, `
+[tables."app.users"]
+exclude = ["Email", "phone"]
+
+[tables."app.orders"]
+include = ["id", "amount"]
+`))
+	require.NoError(t, err)
+
+	columns := []cloudstorage.TableCol{{Name: "id", IsPK: "true"}, {Name: "email"}, {Name: "amount"}, {Name: "phone"}}
+	users := config.Table("app.users")
+	// column names are case-insensitive
+	require.False(t, users.Retains("email"))
+	require.True(t, users.Retains("amount"))
+	require.Equal(t, []string{"id", "amount"}, columnNames(users.Columns(columns)))
+	require.Equal(t, []string{"id", "amount"}, columnNames(config.Table("app.orders").Columns(columns)))
+
+	// a table not in the file retains all the columns
+	require.Nil(t, config.Table("app.events"))
+	require.True(t, config.Table("app.events").Retains("email"))
+	require.Equal(t, columns, config.Table("app.events").Columns(columns))
+
+	tableDef := users.TableDef(cloudstorage.TableDefinition{Columns: columns, TotalColumns: len(columns)})
+	require.Equal(t, 2, tableDef.TotalColumns)
+	require.Len(t, columns, 4)
+
+	require.NoError(t, users.Check(columns))
+	require.Equal(t, []string{"Email", "phone"}, users.Unknown([]cloudstorage.TableCol{{Name: "id"}}))
+
+	_, err = columnfilter.Load(writeFilterFile(t, `
+[tables."users"]
+exclude = ["email"]
+`))
+	require.ErrorContains(t, err, "invalid table users")
+
+	_, err = columnfilter.Load(writeFilterFile(t, `
+[tables."app.users"]
+include = ["id"]
+exclude = ["email"]
+`))
+	require.ErrorContains(t, err, "only one is allowed")
+
+	_, err = columnfilter.Load(writeFilterFile(t, `
+[tables."app.users"]
+excludes = ["email"]
+`))
+	require.ErrorContains(t, err, "unknown keys")
+}
+
+func TestCheck(t *testing.T) {
+	columns := []cloudstorage.TableCol{{Name: "id", IsPK: "true"}, {Name: "email"}}
+	err := (&columnfilter.Filter{Exclude: []string{"ID"}}).Check(columns)
+	require.ErrorContains(t, err, "primary key column id is filtered out")
+	err = (&columnfilter.Filter{Include: []string{"name"}}).Check([]cloudstorage.TableCol{{Name: "email"}})
+	require.ErrorContains(t, err, "all the columns are filtered out")
+}
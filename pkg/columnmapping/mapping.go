@@ -0,0 +1,87 @@
+package columnmapping
+
+import (
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Columns are the types of the columns of a table in the data warehouse overriding the default type mapping,
+// by the column name. A nil Columns overrides nothing.
+type Columns map[string]string
+
+// Lookup returns the type of the column in the data warehouse, ok is false if the column is not overridden.
+// The column names are case-insensitive as in TiDB.
+func (c Columns) Lookup(column string) (string, bool) {
+	for name, tp := range c {
+		if strings.EqualFold(name, column) {
+			return tp, true
+		}
+	}
+	return "", false
+}
+
+// Unknown returns the overridden columns not in the columns of the table
+func (c Columns) Unknown(columns []cloudstorage.TableCol) []string {
+	var unknown []string
+	for name := range c {
+		found := false
+		for _, column := range columns {
+			if strings.EqualFold(name, column.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	return unknown
+}
+
+// Mapping is the column types of the tables given by --column-mapping, by the table full qualified name
+type Mapping map[string]Columns
+
+// Table returns the column types of the table, nil if the table is not overridden
+func (m Mapping) Table(tableFQN string) Columns {
+	return m[tableFQN]
+}
+
+type tableMapping struct {
+	Columns Columns `toml:"columns"`
+}
+
+type mappingFile struct {
+	Tables map[string]tableMapping `toml:"tables"`
+}
+
+// Load reads the column types from the config file, e.g.
+//
+//	[tables."db.events".columns]
+//	payload = "STRING"
+//	id = "NUMBER(20, 0)"
+func Load(path string) (Mapping, error) {
+	var file mappingFile
+	meta, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse column mapping file %s", path)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, errors.Errorf("unknown keys %v in column mapping file %s", undecoded, path)
+	}
+	mapping := make(Mapping, len(file.Tables))
+	for table, tableMapping := range file.Tables {
+		if strings.Count(table, ".") != 1 {
+			return nil, errors.Errorf("invalid table %s in column mapping file %s, expected <db>.<table>", table, path)
+		}
+		for column, tp := range tableMapping.Columns {
+			if strings.TrimSpace(tp) == "" || strings.Contains(tp, ";") {
+				return nil, errors.Errorf("invalid type %q of column %s of table %s in column mapping file %s", tp, column, table, path)
+			}
+		}
+		mapping[table] = tableMapping.Columns
+	}
+	return mapping, nil
+}
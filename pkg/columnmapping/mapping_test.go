@@ -0,0 +1,62 @@
+package columnmapping_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMappingFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "mapping.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	mapping, err := columnmapping.Load(writeMappingFile(t, `
+[tables."app.events".columns]
+payload = "VARIANT"
+Amount = "NUMBER(20, 4)"
+`))
+	require.NoError(t, err)
+
+	columnTypes := mapping.Table("app.events")
+	tp, ok := columnTypes.Lookup("payload")
+	require.True(t, ok)
+	require.Equal(t, "VARIANT", tp)
+	// column names are case-insensitive
+	tp, ok = columnTypes.Lookup("amount")
+	require.True(t, ok)
+	require.Equal(t, "NUMBER(20, 4)", tp)
+	_, ok = columnTypes.Lookup("id")
+	require.False(t, ok)
+
+	// a table not in the file overrides nothing
+	_, ok = mapping.Table("app.users").Lookup("payload")
+	require.False(t, ok)
+
+	columns := []cloudstorage.TableCol{{Name: "id"}, {Name: "payload"}}
+	require.Equal(t, []string{"Amount"}, columnTypes.Unknown(columns))
+
+	_, err = columnmapping.Load(writeMappingFile(t, `
+[tables."events".columns]
+payload = "VARIANT"
+`))
+	require.ErrorContains(t, err, "invalid table events")
+
+	_, err = columnmapping.Load(writeMappingFile(t, `
+[tables."app.events".columns]
+payload = "VARIANT; DROP TABLE users"
+`))
+	require.ErrorContains(t, err, "invalid type")
+
+	_, err = columnmapping.Load(writeMappingFile(t, `
+[tables."app.events"]
+colums = { payload = "VARIANT" }
+`))
+	require.ErrorContains(t, err, "unknown keys")
+}
@@ -0,0 +1,297 @@
+// Package configfile applies a TOML file to the flags of a command. Every flag has a field in the file, a flag
+// `section.key` is the field `key` of the section `[section]`, e.g. `--tidb.host` is `host` of `[tidb]` and
+// `--mode` is `mode` at the top. The flags given on the command line take precedence over the file.
+package configfile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// FlagName is the flag of the config file, it has no field in the file
+const FlagName = "config"
+
+// TablesSection is the section of the per-table overrides in the same file, e.g. [tables."db.events"],
+// which are read by the replication instead of being applied to the flags
+const TablesSection = "tables"
+
+// RoutesSection is the section of the routes of the tables, e.g. "app.orders" = "ANALYTICS.ORDERS" for
+// --route 'app.orders=>ANALYTICS.ORDERS'
+const RoutesSection = "routes"
+
+// routeFlag is the flag given by RoutesSection
+const routeFlag = "route"
+
+// envPattern matches the environment variables interpolated into the strings, e.g. ${TIDB_PASS}
+var envPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Apply sets the flags not given on the command line to the values of the file. An unknown field, an unset
+// environment variable or an invalid value fails with the field and its section.
+func Apply(flags *pflag.FlagSet, path string) error {
+	var file map[string]any
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return errors.Annotatef(err, "Failed to parse config file %s", path)
+	}
+	var values []flagValue
+	if err := collect(flags, "", file, &values); err != nil {
+		return errors.Annotatef(err, "invalid config file %s", path)
+	}
+
+	changed := make(map[string]bool)
+	flags.Visit(func(flag *pflag.Flag) {
+		changed[flag.Name] = true
+	})
+	for _, value := range values {
+		if changed[value.flag.Name] {
+			continue
+		}
+		for _, s := range value.values {
+			if err := flags.Set(value.flag.Name, s); err != nil {
+				if diag.IsSecretFlag(value.flag.Name) {
+					// the error of the flag repeats the value
+					return errors.Errorf("invalid config file %s: invalid value xxxxx of %s", path, value.field)
+				}
+				return errors.Errorf("invalid config file %s: invalid value %q of %s: %v", path, s, value.field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValue is the values of a flag given by the file, a flag taking a list is set once per value
+type flagValue struct {
+	flag   *pflag.Flag
+	field  string
+	values []string
+}
+
+func collect(flags *pflag.FlagSet, section string, table map[string]any, values *[]flagValue) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := key
+		if section != "" {
+			name = section + "." + key
+		}
+		field := describeField(section, key)
+		if name == TablesSection {
+			continue
+		}
+		if name == RoutesSection {
+			if err := collectRoutes(flags, table[key], values); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if subTable, ok := table[key].(map[string]any); ok {
+			if err := collect(flags, name, subTable, values); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		flag := flags.Lookup(name)
+		if flag == nil || flag.Hidden || name == FlagName || name == "help" {
+			return errors.Errorf("unknown %s", field)
+		}
+		strs, err := toStrings(flag, table[key])
+		if err != nil {
+			return errors.Annotate(err, field)
+		}
+		for i, s := range strs {
+			if strs[i], err = interpolate(s); err != nil {
+				return errors.Annotate(err, field)
+			}
+		}
+		*values = append(*values, flagValue{flag: flag, field: field, values: strs})
+	}
+	return nil
+}
+
+// collectRoutes collects the routes of RoutesSection as the values of --route, the sources are the keys, which are
+// the same quoted or dotted, e.g. "app.orders" or app.orders
+func collectRoutes(flags *pflag.FlagSet, section any, values *[]flagValue) error {
+	flag := flags.Lookup(routeFlag)
+	subTable, ok := section.(map[string]any)
+	if flag == nil || !ok {
+		return errors.Errorf("unknown section [%s]", RoutesSection)
+	}
+	routes := make(map[string]string)
+	if err := flattenRoutes("", subTable, routes); err != nil {
+		return errors.Trace(err)
+	}
+	sources := make([]string, 0, len(routes))
+	for source := range routes {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	strs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		target, err := interpolate(routes[source])
+		if err != nil {
+			return errors.Annotate(err, describeField(RoutesSection, source))
+		}
+		strs = append(strs, source+"=>"+target)
+	}
+	*values = append(*values, flagValue{flag: flag, field: fmt.Sprintf("section [%s]", RoutesSection), values: strs})
+	return nil
+}
+
+func flattenRoutes(prefix string, table map[string]any, routes map[string]string) error {
+	for key, value := range table {
+		source := key
+		if prefix != "" {
+			source = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			if err := flattenRoutes(source, v, routes); err != nil {
+				return errors.Trace(err)
+			}
+		case string:
+			routes[source] = v
+		default:
+			return errors.Errorf("unsupported value %v of %s, expected the target as a string", value, describeField(RoutesSection, source))
+		}
+	}
+	return nil
+}
+
+// describeField names the field in the errors, e.g. `host` in section [tidb]
+func describeField(section, key string) string {
+	if section == "" {
+		return fmt.Sprintf("field %s", key)
+	}
+	return fmt.Sprintf("field %s in section [%s]", key, section)
+}
+
+// toStrings returns the value of the field as the values of the flag
+func toStrings(flag *pflag.Flag, value any) ([]string, error) {
+	if list, ok := value.([]any); ok {
+		if _, isSlice := flag.Value.(pflag.SliceValue); !isSlice {
+			return nil, errors.New("takes a single value, not a list")
+		}
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			s, err := scalarString(item)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			strs = append(strs, s)
+		}
+		return strs, nil
+	}
+	s, err := scalarString(value)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{s}, nil
+}
+
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", errors.Errorf("unsupported value %v, expected a string, a number or a boolean", value)
+	}
+}
+
+// interpolate replaces ${ENV_VAR} in the value by the environment variable, which must be set
+func interpolate(s string) (string, error) {
+	var err error
+	result := envPattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := envPattern.FindStringSubmatch(match)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = errors.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return result, errors.Trace(err)
+}
+
+// WriteExample writes a template of the file for the flags of the command, every field commented out with its
+// usage and the default value of its flag. The secrets are given by environment variables.
+func WriteExample(w io.Writer, command string, flags *pflag.FlagSet) error {
+	sections := make(map[string][]*pflag.Flag)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden || flag.Deprecated != "" || flag.Name == FlagName || flag.Name == "help" {
+			return
+		}
+		section := ""
+		if i := strings.LastIndex(flag.Name, "."); i >= 0 {
+			section = flag.Name[:i]
+		}
+		sections[section] = append(sections[section], flag)
+	})
+	names := make([]string, 0, len(sections))
+	for section := range sections {
+		names = append(names, section)
+	}
+	// the fields at the top come first, before any section
+	sort.Strings(names)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Config file of `%s`, given by --%s.\n", command, FlagName)
+	sb.WriteString("# Uncomment the fields to set, the flags given on the command line take precedence.\n")
+	sb.WriteString("# ${ENV_VAR} in a string is replaced by the environment variable, e.g. for the secrets.\n")
+	for _, section := range names {
+		if section != "" {
+			fmt.Fprintf(&sb, "\n[%s]\n", section)
+		}
+		for _, flag := range sections[section] {
+			// a usage of multiple lines is commented line by line
+			lines := strings.Split(strings.TrimSpace(flag.Usage), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimSpace(lines[i])
+			}
+			usage := strings.Join(lines, "\n# ")
+			if _, ok := flag.Annotations[cobra.BashCompOneRequiredFlag]; ok {
+				usage += " (required)"
+			}
+			key := strings.TrimPrefix(flag.Name, section+".")
+			fmt.Fprintf(&sb, "\n# %s\n# %s = %s\n", usage, key, exampleValue(flag))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return errors.Trace(err)
+}
+
+// exampleValue returns the default value of the flag in TOML
+func exampleValue(flag *pflag.Flag) string {
+	if diag.IsSecretFlag(flag.Name) {
+		return strconv.Quote("${" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flag.Name)) + "}")
+	}
+	if slice, ok := flag.Value.(pflag.SliceValue); ok {
+		items := make([]string, 0, len(slice.GetSlice()))
+		for _, item := range slice.GetSlice() {
+			items = append(items, strconv.Quote(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	switch flag.Value.Type() {
+	case "bool", "int", "int32", "int64", "uint", "uint32", "uint64", "float32", "float64":
+		return flag.DefValue
+	}
+	return strconv.Quote(flag.DefValue)
+}
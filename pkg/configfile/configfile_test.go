@@ -0,0 +1,155 @@
+package configfile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/configfile"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/require"
+)
+
+func newFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("mode", "full", "replication mode")
+	flags.String("tidb.host", "127.0.0.1", "TiDB server host")
+	flags.Int("tidb.port", 4000, "TiDB server port")
+	flags.String("tidb.pass", "", "TiDB password")
+	flags.Bool("dry-run", false, "print the statements")
+	flags.Duration("cdc.flush-interval", time.Minute, "flush interval")
+	flags.StringArrayP("table", "t", []string{}, "tables")
+	flags.String(configfile.FlagName, "", "config file")
+	return flags
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "tidb2dw.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestApply(t *testing.T) {
+	t.Setenv("TEST_TIDB_PASS", "secret")
+	path := writeConfig(t, `
+mode = "snapshot-only"
+dry-run = true
+table = ["db.t1", "db.t2"]
+
+[tidb]
+host = "tidb.internal"
+port = 4001
+pass = "${TEST_TIDB_PASS}"
+
+[cdc]
+flush-interval = "30s"
+
+[tables."db.t1"]
+increment_workers = 4
+`)
+	flags := newFlags()
+	// the flags on the command line take precedence
+	require.NoError(t, flags.Parse([]string{"--tidb.host", "10.0.0.1", "--config", path}))
+	require.NoError(t, configfile.Apply(flags, path))
+
+	get := func(name string) string { return flags.Lookup(name).Value.String() }
+	require.Equal(t, "snapshot-only", get("mode"))
+	require.Equal(t, "true", get("dry-run"))
+	require.Equal(t, "[db.t1,db.t2]", get("table"))
+	require.Equal(t, "10.0.0.1", get("tidb.host"))
+	require.Equal(t, "4001", get("tidb.port"))
+	require.Equal(t, "secret", get("tidb.pass"))
+	require.Equal(t, "30s", get("cdc.flush-interval"))
+	require.True(t, flags.Changed("tidb.port"))
+}
+
+func TestApplyInvalid(t *testing.T) {
+	for _, c := range []struct {
+		content string
+		err     string
+	}{
+		{"[tidb]\nhots = \"x\"", "unknown field hots in section [tidb]"},
+		{"[tidb.tls]\nca = \"x\"", "unknown field ca in section [tidb.tls]"},
+		{"config = \"other.toml\"", "unknown field config"},
+		{"[tidb]\nport = \"abc\"", `invalid value "abc" of field port in section [tidb]`},
+		{"[tidb]\nhost = [\"a\", \"b\"]", "field host in section [tidb]: takes a single value, not a list"},
+		{"[tidb]\npass = \"${TEST_UNSET_PASS}\"", "field pass in section [tidb]: environment variable TEST_UNSET_PASS is not set"},
+		{"[cdc]\nflush-interval = \"soon\"", "field flush-interval in section [cdc]"},
+		{"[tidb\nhost = \"x\"", "Failed to parse config file"},
+		{"[routes]\n\"app.orders\" = \"OPS\"", "unknown section [routes]"},
+	} {
+		err := configfile.Apply(newFlags(), writeConfig(t, c.content))
+		require.ErrorContains(t, err, c.err, c.content)
+	}
+
+	// the secrets are not shown
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.Int("tidb.pass", 0, "not a string")
+	err := configfile.Apply(flags, writeConfig(t, "[tidb]\npass = \"hunter2\""))
+	require.ErrorContains(t, err, "invalid value xxxxx of field pass in section [tidb]")
+	require.NotContains(t, err.Error(), "hunter2")
+}
+
+func TestApplyRoutes(t *testing.T) {
+	t.Setenv("TEST_SCHEMA", "RAW")
+	newRouteFlags := func() *pflag.FlagSet {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		flags.StringArray("route", []string{}, "routes")
+		return flags
+	}
+	// the sources are quoted or dotted, and the routes follow the ones of --route in the file
+	flags := newRouteFlags()
+	require.NoError(t, configfile.Apply(flags, writeConfig(t, `
+route = ["crm.users=>CRM"]
+
+[routes]
+"app.user_profile" = "ANALYTICS.RAW_USER_PROFILE"
+app.orders = "${TEST_SCHEMA}.ORDERS"
+`)))
+	routes, err := flags.GetStringArray("route")
+	require.NoError(t, err)
+	require.Equal(t, []string{"crm.users=>CRM", "app.orders=>RAW.ORDERS", "app.user_profile=>ANALYTICS.RAW_USER_PROFILE"}, routes)
+
+	// the routes on the command line take precedence
+	flags = newRouteFlags()
+	require.NoError(t, flags.Parse([]string{"--route", "app.orders=>OPS"}))
+	require.NoError(t, configfile.Apply(flags, writeConfig(t, "[routes]\n\"app.user_profile\" = \"RAW\"")))
+	routes, err = flags.GetStringArray("route")
+	require.NoError(t, err)
+	require.Equal(t, []string{"app.orders=>OPS"}, routes)
+
+	err = configfile.Apply(newRouteFlags(), writeConfig(t, "[routes]\n\"app.orders\" = 1"))
+	require.ErrorContains(t, err, "unsupported value 1 of field app.orders in section [routes]")
+}
+
+func TestWriteExample(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, configfile.WriteExample(&buf, "tidb2dw test", newFlags()))
+	example := buf.String()
+	require.Contains(t, example, "# Config file of `tidb2dw test`, given by --config.\n")
+	require.Contains(t, example, "\n# replication mode\n# mode = \"full\"\n")
+	require.Contains(t, example, "\n# tables\n# table = []\n\n[cdc]\n\n# flush interval\n# flush-interval = \"1m0s\"\n")
+	require.Contains(t, example, "\n[tidb]\n\n# TiDB server host\n# host = \"127.0.0.1\"\n")
+	require.Contains(t, example, "\n# pass = \"${TIDB_PASS}\"\n")
+	require.Contains(t, example, "\n# port = 4000\n")
+	require.NotContains(t, example, "# config =")
+
+	// the example is a valid config file once uncommented
+	path := writeConfig(t, uncomment(example))
+	t.Setenv("TIDB_PASS", "")
+	require.NoError(t, configfile.Apply(newFlags(), path))
+}
+
+func uncomment(example string) string {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split([]byte(example), []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("# ")) && bytes.Contains(line, []byte(" = ")) {
+			line = line[2:]
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -4,6 +4,12 @@ import (
 	"database/sql"
 	"net/url"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 )
 
@@ -17,8 +23,10 @@ type Connector interface {
 	InitSchema(columns []cloudstorage.TableCol) error
 	// CopyTableSchema copies the table schema from the source database to the Data Warehouse
 	CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error
-	// LoadSnapshot loads the snapshot into the Data Warehouse
-	LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error
+	// LoadSnapshot loads the snapshot files into the Data Warehouse, the paths are relative to the snapshot storage.
+	// onFilesLoaded is called with the files of each batch once the Data Warehouse confirms they are loaded,
+	// loading stops at the first error it returns.
+	LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error
 	// ExecDDL executes the DDL statements in Data Warehouse
 	ExecDDL(tableDef cloudstorage.TableDefinition) error
 	// LoadIncrement loads the increment data into the Data Warehouse
@@ -26,3 +34,135 @@ type Connector interface {
 	// Close closes the connection to the Data Warehouse
 	Close()
 }
+
+// IncrementBatchLoader is implemented by the connectors loading the new increment files of a table together, with
+// one load and one merge instead of a merge per file.
+type IncrementBatchLoader interface {
+	// LoadIncrementBatch loads the files of the same table definition in their order, the paths are relative to uri.
+	// The batch is loaded again from its first file after a failure, as a file is after a failure of LoadIncrement.
+	LoadIncrementBatch(tableDef cloudstorage.TableDefinition, uri *url.URL, filePaths []string) error
+}
+
+// AppliedBatchRecorder is implemented by the connectors recording the batches of increment files applied to the
+// table in the appliedbatch.TableName table of the Data Warehouse, so that a batch applied before the increment
+// checkpoint is written is skipped when it is replayed after a restart.
+type AppliedBatchRecorder interface {
+	// FindAppliedBatch returns the batch of the id recorded in the Data Warehouse, nil if it is not applied
+	FindAppliedBatch(id string) (*appliedbatch.Batch, error)
+	// SetAppliedBatch sets the batch recorded by the next LoadIncrement or LoadIncrementBatch up to the last file
+	// applied, in the transaction of the files if the Data Warehouse supports it, or right after they are applied.
+	// The batch loaded file by file is recorded after each file.
+	SetAppliedBatch(batch *appliedbatch.Batch)
+}
+
+// IncrementModeSetter is implemented by the connectors which can append the changes of the table to its changelog
+// table instead of merging them, e.g. for a table without a primary key by --pkless-mode=append.
+type IncrementModeSetter interface {
+	// SetIncrementMode sets how the increment files loaded from now on are applied
+	SetIncrementMode(incrementMode incrementmode.Mode)
+}
+
+// AuditScoper is implemented by the connectors logging their statements in the SQL audit log, so that a row of the
+// Data Warehouse can be traced back to the increment files or the DDL which produce it.
+type AuditScoper interface {
+	// SetAuditScope labels the statements executed from now on, the batch is the appliedbatch.ID of the increment
+	// files loaded, empty for a DDL, and the schema version is the table version of the files or the DDL
+	SetAuditScope(batchID string, schemaVersion uint64)
+}
+
+// BadRowsReporter is implemented by the connectors skipping the rows of the increment files the Data Warehouse fails
+// to load, up to their limit of a batch, see badrows. A batch with more bad rows fails.
+type BadRowsReporter interface {
+	// TakeBadRows returns the rows rejected by the last LoadIncrement or LoadIncrementBatch succeeded, and forgets them
+	TakeBadRows() badrows.Rejects
+}
+
+// MergedRowsReporter is implemented by the connectors counting the rows changed in the table by
+// the merges of the increment files, as reported by the Data Warehouse.
+type MergedRowsReporter interface {
+	// MergedRows returns the total rows merged by the connector so far
+	MergedRows() int64
+}
+
+// BytesBilledReporter is implemented by the connectors billed by the bytes their queries scan, as reported by the
+// Data Warehouse, so that the cost of the merges is measurable
+type BytesBilledReporter interface {
+	// BytesBilled returns the total bytes billed by the merges of the connector so far
+	BytesBilled() int64
+}
+
+// DeferredMerger is implemented by the connectors deferring the merge of the loaded rows, e.g. by
+// --bq.merge-interval, so that the rows of a table receiving no new file are merged on the following rounds too
+type DeferredMerger interface {
+	// MergeDeferred merges the rows whose merge is deferred once their deferral is over, it is a no-op if there is
+	// nothing to merge yet
+	MergeDeferred() error
+}
+
+// TableAggregator is implemented by the connectors able to validate the loaded snapshot, it aggregates
+// the table in the Data Warehouse as validation.GenAggregateQuery does in TiDB.
+type TableAggregator interface {
+	// AggregateTable returns the row count of the table and the sums of the columns
+	AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error)
+}
+
+// TableVerifier reads the tables of a Data Warehouse for `tidb2dw verify`, it aggregates and reads the rows of a
+// range of the primary key as the verification does in TiDB. It only needs to SELECT the tables.
+type TableVerifier interface {
+	// AggregateRange returns the row count and the sums of the columns of the rows in the range
+	AggregateRange(targetTable string, sumColumns []validation.SumColumn, keyRange validation.KeyRange) (*validation.Aggregates, error)
+	// ScanRange returns the values of the columns of the rows in the range as strings, ordered by the primary key
+	// and up to limit rows
+	ScanRange(targetTable string, columns, pkColumns []string, keyRange validation.KeyRange, limit int) ([]validation.Row, error)
+	Close()
+}
+
+// WarehouseSuspender is implemented by the data warehouses billed while their compute is running, so that it
+// can be suspended when no file is loaded for a while.
+type WarehouseSuspender interface {
+	// SuspendWarehouse suspends the compute loading the tables
+	SuspendWarehouse() error
+	// ResumeWarehouse resumes the compute if it is suspended
+	ResumeWarehouse() error
+}
+
+// SchemaReconciler is implemented by the connectors able to detect the drift of the table in the Data Warehouse
+// from the columns replicated to it, e.g. the table is altered by hand.
+type SchemaReconciler interface {
+	// DiffSchema compares the columns of the table in the Data Warehouse with the columns replicated to it,
+	// nil if the columns are not known yet
+	DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error)
+	// ReconcileSchema alters the table in the Data Warehouse back to the columns replicated to it
+	ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error
+}
+
+// DeleteModeChecker is implemented by the connectors able to delete the rows softly with a tombstone, see
+// deletemode.Mode.
+type DeleteModeChecker interface {
+	// CheckDeleteMode fails if the table in the Data Warehouse is created in another delete mode than the
+	// connector's, a table not created yet passes
+	CheckDeleteMode(targetTable string) error
+}
+
+// StatementExecutor is implemented by the connectors executing the statements given by the user in the Data
+// Warehouse, e.g. by --post-snapshot-sql.
+type StatementExecutor interface {
+	// ExecStatements executes the statements in order, it stops at the first failed
+	ExecStatements(statements []string) error
+}
+
+// ErrorClassifier is implemented by the connectors telling the transient errors of their Data Warehouse, e.g. an
+// expired session or a rate limit, from the errors of the statements. The operations failed with a transient error
+// are retried, the connectors not implementing it are retried on the errors of the connection only.
+type ErrorClassifier interface {
+	// IsRetryable tells whether an operation which failed with err may succeed if it is run again
+	IsRetryable(err error) bool
+}
+
+// DDLClassifier is implemented by the connectors classifying the DDLs by their Data Warehouse, e.g. a DDL which the
+// Data Warehouse can not apply pauses the table instead of failing it. The DDLs of the connectors not implementing
+// it are classified by tidbsql.DDLActionClasses.
+type DDLClassifier interface {
+	// DDLActionClasses returns the classes of all action types known by the TiDB parser
+	DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass
+}
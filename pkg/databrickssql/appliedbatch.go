@@ -0,0 +1,65 @@
+package databrickssql
+
+import (
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// SetAppliedBatch sets the batch recorded by the next LoadIncrement, right after the file is merged, as Databricks
+// has no transaction across the statements
+func (dc *DatabricksConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	dc.appliedBatch = batch
+}
+
+// FindAppliedBatch returns the batch of the id recorded in the bookkeeping table, nil if it is not applied
+func (dc *DatabricksConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if err := dc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFind(dc.gen.Table(dc.namespace, appliedbatch.TableName), id, utils.QuoteLiteral)
+	batch := &appliedbatch.Batch{ID: id}
+	if err := dc.db.QueryRow(query).Scan(&batch.LastFile, &batch.CommitTs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, diag.WrapSQL(err, query)
+	}
+	return batch, nil
+}
+
+// ensureAppliedBatchTable creates the bookkeeping table of the applied batches if it does not exist
+func (dc *DatabricksConnector) ensureAppliedBatchTable() error {
+	if dc.appliedBatchTableCreated {
+		return nil
+	}
+	query := appliedbatch.GenCreateTable(dc.gen.Table(dc.namespace, appliedbatch.TableName), "STRING", "BIGINT", "TIMESTAMP")
+	if _, err := dc.db.Exec(query); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, query), "Failed to create applied batch table")
+	}
+	dc.appliedBatchTableCreated = true
+	return nil
+}
+
+// recordAppliedBatch records the batch set by SetAppliedBatch as applied to the table up to the file, it is a no-op
+// if no batch is set
+func (dc *DatabricksConnector) recordAppliedBatch(table, filePath string) error {
+	if dc.appliedBatch == nil {
+		return nil
+	}
+	if err := dc.ensureAppliedBatchTable(); err != nil {
+		return errors.Trace(err)
+	}
+	batch := *dc.appliedBatch
+	batch.Table = table
+	batch.LastFile = filePath
+	for _, query := range appliedbatch.GenRecord(dc.gen.Table(dc.namespace, appliedbatch.TableName), batch, "current_timestamp()", utils.QuoteLiteral) {
+		if _, err := dc.db.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to record the applied batch")
+		}
+	}
+	return nil
+}
@@ -3,6 +3,10 @@ package databrickssql
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 
@@ -16,11 +20,31 @@ type DataBricksConfig struct {
 	Endpoint string
 	Catalog  string
 	Schema   string
+	// CreateSchema creates the schema if it does not exist, otherwise a missing schema fails OpenDB
+	CreateSchema bool
+	// IdentifierCase is the case the names of the catalog and the schema are written in
+	IdentifierCase identcase.Case
+	// TimeZone is the IANA time zone of the sessions, which the TIMESTAMP values without offset are read in,
+	// empty for the time zone of the warehouse
+	TimeZone string
+}
+
+// Namespace returns the catalog and the schema of the tables
+func (config *DataBricksConfig) Namespace() Namespace {
+	return Namespace{Catalog: config.Catalog, Schema: config.Schema}
 }
 
 func (config *DataBricksConfig) OpenDB() (*sql.DB, error) {
+	schema := config.Schema
+	if config.CreateSchema {
+		// the session can not start in a schema not created yet
+		schema = ""
+	}
 	var connStr = fmt.Sprintf("token:%s@%s:%d/sql/1.0/endpoints/%s?catalog=%s&schema=%s",
-		config.Token, config.Host, config.Port, config.Endpoint, config.Catalog, config.Schema)
+		config.Token, config.Host, config.Port, config.Endpoint, url.QueryEscape(config.Catalog), url.QueryEscape(schema))
+	if config.TimeZone != "" {
+		connStr += "&timezone=" + url.QueryEscape(config.TimeZone)
+	}
 
 	db, err := sql.Open("databricks", connStr)
 	if err != nil {
@@ -30,7 +54,46 @@ func (config *DataBricksConfig) OpenDB() (*sql.DB, error) {
 	if err = db.Ping(); err != nil {
 		return nil, errors.Annotate(err, "Failed to ping Databricks Warehouse")
 	}
+	if err = config.useNamespace(db); err != nil {
+		db.Close()
+		return nil, errors.Trace(err)
+	}
 
 	log.Info("Databricks Warehouse connection established")
 	return db, nil
 }
+
+// useNamespace checks the schema exists, or creates it with CreateSchema, and switches the session to the catalog
+// and the schema. The tables are named by three parts anyway, it is a safety net for the names not qualified,
+// e.g. in --where.
+func (config *DataBricksConfig) useNamespace(db *sql.DB) error {
+	ns, gen := config.Namespace(), NewGenerator(config.IdentifierCase)
+	if ns.Catalog != "" {
+		query := "USE CATALOG " + gen.QuoteIdent(ns.Catalog)
+		if _, err := db.Exec(query); err != nil {
+			return errors.Annotatef(diag.WrapSQL(err, query), "Failed to use Databricks catalog %s", ns.Catalog)
+		}
+	}
+	if ns.Schema == "" {
+		return nil
+	}
+	if config.CreateSchema {
+		query := "CREATE SCHEMA IF NOT EXISTS " + gen.QuotedSchema(ns)
+		if _, err := db.Exec(query); err != nil {
+			return errors.Annotatef(diag.WrapSQL(err, query), "Failed to create Databricks schema %s", gen.QuotedSchema(ns))
+		}
+	} else {
+		query := "DESCRIBE SCHEMA " + gen.QuotedSchema(ns)
+		rows, err := db.Query(query)
+		if err != nil {
+			return errors.Annotatef(diag.WrapSQL(err, query),
+				"Databricks schema %s is not found, create it or set --create-target-schema", gen.QuotedSchema(ns))
+		}
+		rows.Close()
+	}
+	query := "USE SCHEMA " + gen.QuotedSchema(ns)
+	if _, err := db.Exec(query); err != nil {
+		return errors.Annotatef(diag.WrapSQL(err, query), "Failed to use Databricks schema %s", gen.QuotedSchema(ns))
+	}
+	return nil
+}
@@ -4,28 +4,84 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 	"net/url"
+	"slices"
 	"strings"
 )
 
 type DatabricksConnector struct {
-	db         *sql.DB
-	ctx        context.Context
+	db  *sql.DB
+	ctx context.Context
+	// gen generates the statements and qualifies the tables by the namespace
+	gen        Generator
+	storageURI *url.URL
 	storageURL string
 	credential string
-	columns    []cloudstorage.TableCol
+	// csvFormat is the format of the snapshot files
+	csvFormat CSVFormat
+	// permissiveLoad writes the malformed rows of the snapshot files into the quarantine table instead of failing
+	permissiveLoad bool
+	// extStorage reads the snapshot files to verify the rows loaded, opened on the first load. The CSV files are
+	// read through the compression.
+	extStorage storage.ExternalStorage
+	// compression is the codec of the CSV files, Databricks detects it by the file extension
+	compression utils.Compression
+	columns     []cloudstorage.TableCol
+	// columnTypes overrides the types of the columns, nil if the default mapping is used
+	columnTypes columnmapping.Columns
+	// columnFilter is the columns replicated, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows replicated, empty if all the rows are replicated
+	where string
+	// layout is the partitioning of the table created, the zero Layout if the table is not partitioned
+	layout tablelayout.Layout
+	// routedTable is the table the source table is replicated to, empty if it is replicated to the table of its name
+	routedTable string
+	// namespace is the catalog and the schema of the tables, the zero Namespace if they are resolved by the session
+	namespace Namespace
+	// deleteMode is how the rows deleted in TiDB are deleted in the table, the zero Mode deletes them
+	deleteMode deletemode.Mode
+	// mergedRows is the total rows changed by the merges of the increment files
+	mergedRows int64
+	// appliedBatch is the batch recorded by the loads of the increment files, nil if they are not recorded
+	appliedBatch *appliedbatch.Batch
+	// appliedBatchTableCreated is true once the bookkeeping table of the applied batches is created
+	appliedBatchTableCreated bool
 }
 
 const incrementTablePrefix = "incr_"
 
-func NewDatabricksConnector(databricksDB *sql.DB, credential string, storageURI *url.URL) (*DatabricksConnector, error) {
-	storageURL := fmt.Sprintf("%s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
+// StorageLocation returns the location of the storage read by Databricks, an azure:// URI is read through
+// ABFS as abfss://<container>@<account>.dfs.core.windows.net/<path>
+func StorageLocation(storageURI *url.URL) string {
+	if storageURI.Scheme == "azure" {
+		account := storageURI.Query().Get("account-name")
+		return fmt.Sprintf("abfss://%s@%s.dfs.core.windows.net%s", storageURI.Host, account, storageURI.Path)
+	}
+	return fmt.Sprintf("%s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
+}
+
+// NewDatabricksConnector returns the connector loading the files in storageURI, the names are written in the
+// IdentifierCase of the config
+func NewDatabricksConnector(databricksDB *sql.DB, config *DataBricksConfig, credential string, storageURI *url.URL, compression utils.Compression) (*DatabricksConnector, error) {
+	storageURL := StorageLocation(storageURI)
 
 	credentialSet, err := GetCredentialNameSet(databricksDB)
 	if err != nil {
@@ -46,14 +102,31 @@ func NewDatabricksConnector(databricksDB *sql.DB, credential string, storageURI
 	}
 
 	return &DatabricksConnector{
-		db:         databricksDB,
-		ctx:        context.Background(),
-		credential: credential,
-		storageURL: storageURL,
-		columns:    nil,
+		db:          databricksDB,
+		ctx:         context.Background(),
+		gen:         NewGenerator(config.IdentifierCase),
+		credential:  credential,
+		storageURI:  storageURI,
+		storageURL:  storageURL,
+		compression: compression,
+		csvFormat:   DefaultCSVFormat,
+		columns:     nil,
 	}, nil
 }
 
+// NewDatabricksSchemaConnector returns a connector creating and altering the tables in Databricks only, e.g. for
+// `tidb2dw schema sync`, which loads no file and so needs no storage credential
+func NewDatabricksSchemaConnector(databricksDB *sql.DB, config *DataBricksConfig) *DatabricksConnector {
+	return &DatabricksConnector{db: databricksDB, ctx: context.Background(), gen: NewGenerator(config.IdentifierCase)}
+}
+
+// SetSnapshotLoadOptions sets the format of the snapshot files and whether their malformed rows are written into the
+// quarantine table of the table, <table>_quarantine, instead of failing the load
+func (dc *DatabricksConnector) SetSnapshotLoadOptions(format CSVFormat, permissive bool) {
+	dc.csvFormat = format
+	dc.permissiveLoad = permissive
+}
+
 func (dc *DatabricksConnector) InitSchema(columns []cloudstorage.TableCol) error {
 	if len(dc.columns) != 0 {
 		return nil
@@ -66,15 +139,102 @@ func (dc *DatabricksConnector) InitSchema(columns []cloudstorage.TableCol) error
 	return nil
 }
 
+// SetColumnTypes overrides the types of the columns of the table in Databricks, nil means the default mapping
+func (dc *DatabricksConnector) SetColumnTypes(columnTypes columnmapping.Columns) {
+	dc.columnTypes = columnTypes
+}
+
+// SetColumnFilter filters the columns of the table replicated to Databricks, nil means all the columns
+func (dc *DatabricksConnector) SetColumnFilter(columnFilter *columnfilter.Filter) {
+	dc.columnFilter = columnFilter
+}
+
+// SetWhere filters the rows of the table replicated to Databricks by the predicate, empty means all the rows
+func (dc *DatabricksConnector) SetWhere(where string) {
+	dc.where = where
+}
+
+// SetTableLayout partitions the table created in Databricks by the partitioning columns of the layout
+func (dc *DatabricksConnector) SetTableLayout(layout tablelayout.Layout) {
+	dc.layout = layout
+}
+
+// SetTargetTable replicates the table to another table in Databricks, empty means the table of the source table name.
+// The table keeps its name when the source table is renamed.
+func (dc *DatabricksConnector) SetTargetTable(table string) {
+	dc.routedTable = table
+}
+
+// SetAuditScope labels the statements of the connector in the SQL audit log, if it is enabled
+func (dc *DatabricksConnector) SetAuditScope(batchID string, schemaVersion uint64) {
+	sqlaudit.SetScope(dc.db, batchID, schemaVersion)
+}
+
+// SetNamespace names the tables by the catalog and the schema of the namespace, the tables of the connector, e.g. the
+// external tables of the increment files, are created in it
+func (dc *DatabricksConnector) SetNamespace(ns Namespace) {
+	dc.namespace = ns
+}
+
+// targetTableName returns the table in Databricks the source table is replicated to
+func (dc *DatabricksConnector) targetTableName(sourceTable string) string {
+	if dc.routedTable != "" {
+		return dc.routedTable
+	}
+	return sourceTable
+}
+
+// routeTableDef returns the table definition of the table in Databricks
+func (dc *DatabricksConnector) routeTableDef(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	tableDef.Table = dc.targetTableName(tableDef.Table)
+	return tableDef
+}
+
+// SetDeleteMode sets how the rows deleted in TiDB are deleted in the table in Databricks, soft keeps them with a
+// tombstone
+func (dc *DatabricksConnector) SetDeleteMode(deleteMode deletemode.Mode) {
+	dc.deleteMode = deleteMode
+}
+
+// SetSyncComments sets whether the comments of the tables and the columns in TiDB are replicated into Databricks,
+// they are replicated by default
+func (dc *DatabricksConnector) SetSyncComments(sync bool) {
+	dc.gen = dc.gen.WithSyncComments(sync)
+}
+
+// CheckDeleteMode fails if the table in Databricks is created in another delete mode, a table not created yet passes
+func (dc *DatabricksConnector) CheckDeleteMode(targetTable string) error {
+	targetTable = dc.targetTableName(targetTable)
+	informationSchema, schema := dc.gen.informationSchema(dc.namespace)
+	query := fmt.Sprintf("SELECT COUNT(*), COUNT_IF(column_name = %s) FROM %s.columns WHERE table_schema = %s AND table_name = %s",
+		utils.QuoteLiteral(dc.gen.identifierCase.Apply(deletemode.DeletedColumn)), informationSchema, schema, utils.QuoteLiteral(strings.ToLower(targetTable)))
+	var columns, tombstones int
+	if err := dc.db.QueryRow(query).Scan(&columns, &tombstones); err != nil {
+		return diag.WrapSQL(err, query)
+	}
+	if columns == 0 {
+		return nil
+	}
+	return dc.deleteMode.CheckTable(targetTable, tombstones > 0)
+}
+
 func (dc *DatabricksConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
-	dropTableSQL := GenDropTableSQL(sourceTable)
+	dropTableSQL := dc.gen.GenDropTableSQL(dc.namespace, dc.targetTableName(sourceTable))
 	_, err := dc.db.Exec(dropTableSQL)
 	if err != nil {
-		return errors.Trace(err)
+		return diag.WrapSQL(err, dropTableSQL)
 	}
 
-	err = dc.setColumns(sourceDatabase, sourceTable, sourceTiDBConn)
-	createTableSQL, err := GenCreateTableSQL(sourceTable, dc.columns)
+	if err = dc.setColumns(sourceDatabase, sourceTable, sourceTiDBConn); err != nil {
+		return errors.Trace(err)
+	}
+	comments := &tidbsql.TableComments{}
+	if !dc.gen.skipComments {
+		if comments, err = tidbsql.GetTiDBTableComments(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	createTableSQL, err := dc.gen.GenCreateTableSQL(dc.namespace, dc.targetTableName(sourceTable), dc.columnFilter.Columns(dc.columns), comments, dc.columnTypes, dc.layout, dc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -82,26 +242,132 @@ func (dc *DatabricksConnector) CopyTableSchema(sourceDatabase string, sourceTabl
 
 	_, err = dc.db.Exec(createTableSQL)
 	if err != nil {
-		return errors.Trace(err)
+		return diag.WrapSQL(err, createTableSQL)
 	}
 
 	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
 	return nil
 }
 
-func (dc *DatabricksConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	if err := LoadCSVFromS3(dc.db, dc.columns, targetTable, dc.storageURL, filePrefix, dc.credential); err != nil {
-		return errors.Trace(err)
+func (dc *DatabricksConnector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	targetTable = dc.targetTableName(targetTable)
+	badRecordsPath := ""
+	if dc.permissiveLoad {
+		badRecordsPath = fmt.Sprintf("%s/%s/%s", dc.storageURL, badRecordsDir, targetTable)
+		createSQL := dc.gen.GenCreateQuarantineTableSQL(dc.namespace, targetTable)
+		if _, err := dc.db.Exec(createSQL); err != nil {
+			return diag.WrapSQL(err, createSQL)
+		}
 	}
-	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	var loadedRows int64
+	for start := 0; start < len(files); start += maxFilesPerCopy {
+		batch := files[start:min(start+maxFilesPerCopy, len(files))]
+		// the snapshot files have the columns replicated only
+		var inserted int64
+		var reported bool
+		var err error
+		if stagingformat.FileFormat(batch[0]) == stagingformat.Parquet {
+			inserted, reported, err = dc.gen.LoadParquetFromS3(dc.db, dc.namespace, dc.columnFilter.Columns(dc.columns), targetTable, dc.storageURL, batch, dc.credential, dc.columnTypes, badRecordsPath, dc.deleteMode)
+		} else {
+			inserted, reported, err = dc.gen.LoadCSVFromS3(dc.db, dc.namespace, dc.columnFilter.Columns(dc.columns), targetTable, dc.storageURL, batch, dc.credential, dc.columnTypes, dc.csvFormat, badRecordsPath, dc.deleteMode)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if reported {
+			if err = dc.verifyLoadedRows(targetTable, batch, inserted, badRecordsPath); err != nil {
+				return errors.Trace(err)
+			}
+			loadedRows += inserted
+			if onSnapshotLoadProgress != nil {
+				onSnapshotLoadProgress(loadedRows)
+			}
+		}
+		if err := onFilesLoaded(batch); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.Int("files", len(files)))
 	return nil
 }
 
+// badRecordsDir is where Databricks writes the malformed rows of the snapshot files under the snapshot storage
+const badRecordsDir = "_bad_records"
+
+// verifyLoadedRows compares the rows inserted by COPY INTO with the rows of the files. The rows missing with
+// --permissive-load are the malformed rows, which are loaded into the quarantine table. No row is inserted if the
+// files are loaded before, e.g. by a process killed before recording them, as COPY INTO skips them.
+func (dc *DatabricksConnector) verifyLoadedRows(targetTable string, files []string, inserted int64, badRecordsPath string) error {
+	if inserted == 0 {
+		log.Info("No row is inserted by COPY INTO, the snapshot files are loaded before", zap.String("table", targetTable), zap.Int("files", len(files)))
+		return nil
+	}
+	expected, err := dc.countRows(files)
+	if err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to count the rows of the snapshot files"))
+	}
+	if inserted == expected {
+		return nil
+	}
+	if badRecordsPath == "" || inserted > expected {
+		return diag.Schema(errors.Errorf("COPY INTO loaded %d rows of the snapshot files of %s, but the files have %d rows, "+
+			"check --databricks.csv-* match the files or set --permissive-load to quarantine the malformed rows", inserted, targetTable, expected))
+	}
+	log.Warn("Malformed rows of the snapshot files are loaded into the quarantine table",
+		zap.String("table", targetTable), zap.String("quarantineTable", targetTable+quarantineTableSuffix), zap.Int64("rows", expected-inserted))
+	loadSQL := dc.gen.GenLoadQuarantineSQL(dc.namespace, targetTable, badRecordsPath, dc.credential)
+	_, err = dc.db.Exec(loadSQL)
+	return diag.WrapSQL(err, loadSQL)
+}
+
+// countRows returns the rows of the snapshot files, the rows of a Parquet file are read from its footer
+func (dc *DatabricksConnector) countRows(files []string) (int64, error) {
+	if dc.extStorage == nil {
+		extStorage, err := utils.GetExternalStorageFromURI(dc.ctx, dc.storageURI.String())
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		dc.extStorage = extStorage
+	}
+	csvStorage := dc.extStorage
+	if dc.compression != utils.CompressionNone {
+		csvStorage = storage.WithCompression(dc.extStorage, dc.compression.CompressType())
+	}
+	var rows int64
+	for _, file := range files {
+		if stagingformat.FileFormat(file) == stagingformat.Parquet {
+			fileRows, err := stagingformat.CountRows(dc.ctx, dc.extStorage, file)
+			if err != nil {
+				return 0, errors.Annotatef(err, "Failed to read %s", file)
+			}
+			rows += fileRows
+			continue
+		}
+		reader, err := csvStorage.Open(dc.ctx, file)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		fileRows, err := dc.csvFormat.countRows(reader)
+		reader.Close()
+		if err != nil {
+			return 0, errors.Annotatef(err, "Failed to read %s", file)
+		}
+		rows += fileRows
+	}
+	return rows, nil
+}
+
 func (dc *DatabricksConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(dc.columns) == 0 {
+	// a table created after the changefeed starts has no columns before its CREATE TABLE
+	if len(dc.columns) == 0 && tableDef.Type != timodel.ActionCreateTable {
 		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
 	}
-	ddls, err := GenDDLViaColumnsDiff(dc.columns, tableDef)
+	if dc.routedTable != "" && tidbsql.IsRenameTable(tableDef.Type) {
+		log.Info("Skip rename table ddl, the routed table keeps its name", zap.String("ddl", tableDef.Query), zap.String("table", dc.routedTable))
+		return nil
+	}
+	// the changes of the columns filtered out are ignored
+	ddls, err := dc.gen.GenDDLViaColumnsDiff(dc.namespace, dc.columnFilter.Columns(dc.columns), dc.columnFilter.TableDef(dc.routeTableDef(tableDef)), dc.columnTypes, dc.layout, dc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -113,8 +379,12 @@ func (dc *DatabricksConnector) ExecDDL(tableDef cloudstorage.TableDefinition) er
 	for _, ddl := range ddls {
 		_, err := dc.db.Exec(ddl)
 		if err != nil {
+			if appliedDDLErrors.IsApplied(ddl, err) {
+				log.Warn("Skip DDL whose effect is already present, it is executed before a failure", zap.String("ddl", ddl), zap.Error(err))
+				continue
+			}
 			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
-			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
 		}
 	}
 	// update columns
@@ -124,33 +394,113 @@ func (dc *DatabricksConnector) ExecDDL(tableDef cloudstorage.TableDefinition) er
 }
 
 func (dc *DatabricksConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
-	absolutePath := fmt.Sprintf("%s://%s%s/%s", uri.Scheme, uri.Host, uri.Path, filePath)
+	tableDef = dc.routeTableDef(tableDef)
+	absolutePath := fmt.Sprintf("%s/%s", StorageLocation(uri), filePath)
 	incrTableColumns := utils.GenIncrementTableColumns(tableDef.Columns)
 	incrTableName := incrementTablePrefix + tableDef.Table
 
-	createExtTableSQL, err := GenCreateExternalTableSQL(incrTableName, incrTableColumns, absolutePath, dc.credential)
+	createExtTableSQL, err := dc.gen.GenCreateExternalTableSQL(dc.namespace, incrTableName, incrTableColumns, absolutePath, dc.credential, dc.columnTypes)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	// the external table of a failed attempt is left, the file is loaded again from the start
+	dropTableSQL := dc.gen.GenDropTableSQL(dc.namespace, incrTableName)
+	if _, err = dc.db.Exec(dropTableSQL); err != nil {
+		return diag.WrapSQL(err, dropTableSQL)
+	}
 	_, err = dc.db.Exec(createExtTableSQL)
 	if err != nil {
-		return errors.Trace(err)
+		return diag.WrapSQL(err, createExtTableSQL)
 	}
 
-	// Merge and delete increase table
-	mergeIntoSQL := GenMergeIntoSQL(tableDef, tableDef.Table, incrTableName)
-	_, err = dc.db.Exec(mergeIntoSQL)
+	// Merge and delete increase table, the increase table has all the columns of the file
+	mergeIntoSQL := dc.gen.GenMergeIntoSQL(dc.namespace, dc.columnFilter.TableDef(tableDef), tableDef.Table, incrTableName, dc.columnTypes, dc.where, dc.deleteMode, stagingformat.FileFormat(filePath))
+	res, err := dc.db.Exec(mergeIntoSQL)
 	if err != nil {
+		return diag.WrapSQL(err, mergeIntoSQL)
+	}
+	dc.mergedRows += utils.RowsAffected(res)
+	if err = dc.recordAppliedBatch(tableDef.Table, filePath); err != nil {
 		return errors.Trace(err)
 	}
 
-	dropTableSQL := GenDropTableSQL(incrTableName)
 	_, err = dc.db.Exec(dropTableSQL)
+	if err != nil {
+		return diag.WrapSQL(err, dropTableSQL)
+	}
+
+	return nil
+}
+
+// AggregateTable returns the row count and the sums of the columns of the table, for the validation of the snapshot
+func (dc *DatabricksConnector) AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error) {
+	query := validation.GenAggregateQuery(dc.gen.Table(dc.namespace, dc.targetTableName(targetTable)), sumColumns, "DECIMAL", dc.gen.QuoteIdent)
+	aggregates, err := validation.QueryAggregates(dc.ctx, dc.db, query, sumColumns)
+	return aggregates, errors.Trace(err)
+}
+
+// DiffSchema compares the table in Databricks with the columns replicated to it, nil if the columns are not
+// initialized yet
+func (dc *DatabricksConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	if len(dc.columns) == 0 {
+		return nil, nil
+	}
+	columns, err := dc.gen.GetWarehouseColumns(dc.db, dc.namespace, dc.targetTableName(targetTable))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the tombstone columns of the soft delete mode are not replicated from TiDB
+	actual := slices.DeleteFunc(columns, func(column tidbsql.WarehouseColumn) bool { return deletemode.IsTombstoneColumn(column.Name) })
+	drift, err := tidbsql.GetSchemaDrift(dc.columnFilter.Columns(dc.columns), actual, func(column cloudstorage.TableCol) (string, error) {
+		return getDatabricksColumnType(column, dc.columnTypes)
+	})
+	return drift, errors.Trace(err)
+}
+
+// ReconcileSchema alters the table in Databricks back to the columns replicated to it
+func (dc *DatabricksConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	targetTable = dc.targetTableName(targetTable)
+	prevColumns, err := DriftedColumns(drift)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ddls, err := dc.gen.GenDDLViaColumnsDiff(dc.namespace, prevColumns, cloudstorage.TableDefinition{Table: targetTable, Columns: drift.Expected}, dc.columnTypes, dc.layout, dc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	for _, ddl := range ddls {
+		if _, err := dc.db.Exec(ddl); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	log.Info("Successfully reconciled table", zap.String("table", targetTable), zap.String("ddls", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// IsRetryable retries the statements flagged retryable by the driver, and those failed while the SQL warehouse
+// is starting or throttled
+func (dc *DatabricksConnector) IsRetryable(err error) bool {
+	return IsRetryableError(err)
+}
 
+// DDLActionClasses returns the classes of the DDLs in Databricks
+func (dc *DatabricksConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	return ddlActionClasses
+}
+
+func (dc *DatabricksConnector) MergedRows() int64 {
+	return dc.mergedRows
+}
+
+// ExecStatements executes the statements of --post-snapshot-sql in order
+func (dc *DatabricksConnector) ExecStatements(statements []string) error {
+	for _, statement := range statements {
+		log.Info("Executing statement", zap.String("query", statement))
+		if _, err := dc.db.Exec(statement); err != nil {
+			return diag.WrapSQL(err, statement)
+		}
+	}
 	return nil
 }
 
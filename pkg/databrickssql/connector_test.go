@@ -0,0 +1,33 @@
+package databrickssql_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageLocation(t *testing.T) {
+	cases := []struct {
+		uri      string
+		expected string
+	}{
+		{"s3://bucket/prefix/snapshot?access-key=a&secret-access-key=b", "s3://bucket/prefix/snapshot"},
+		{"azure://container/prefix/increment?account-name=acct&account-key=k", "abfss://container@acct.dfs.core.windows.net/prefix/increment"},
+	}
+	for _, c := range cases {
+		uri, err := url.Parse(c.uri)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, databrickssql.StorageLocation(uri))
+	}
+}
+
+func TestDDLActionClasses(t *testing.T) {
+	connector := &databrickssql.DatabricksConnector{}
+	require.Equal(t, ddltest.DefaultHandlings(map[string]tidbsql.DDLHandling{
+		"ALTER TABLE t ALTER COLUMN age SET DEFAULT 18": tidbsql.DDLHandlingSkip,
+	}), ddltest.Handlings(connector.DDLActionClasses()))
+}
@@ -0,0 +1,99 @@
+package databrickssql
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// CSVFormat is the format of the snapshot CSV files read by COPY INTO. The options are given explicitly instead of
+// the defaults of Databricks, which split the quoted fields with line breaks and load `\N` as a string.
+type CSVFormat struct {
+	Delimiter string
+	Quote     string
+	Escape    string
+	NullValue string
+}
+
+// DefaultCSVFormat is the format of the CSV files written by dumpling
+var DefaultCSVFormat = CSVFormat{Delimiter: ",", Quote: `"`, Escape: `\`, NullValue: `\N`}
+
+// Validate checks the delimiter, the quote and the escape are single distinct characters
+func (f CSVFormat) Validate() error {
+	for name, value := range map[string]string{"delimiter": f.Delimiter, "quote": f.Quote, "escape": f.Escape} {
+		if len(value) != 1 {
+			return errors.Errorf("invalid --databricks.csv-%s %q, a single ASCII character is required", name, value)
+		}
+	}
+	if f.Delimiter == f.Quote || f.Delimiter == f.Escape {
+		return errors.Errorf("--databricks.csv-delimiter %q must differ from the quote and the escape", f.Delimiter)
+	}
+	return nil
+}
+
+// formatOptions returns the FORMAT_OPTIONS of COPY INTO. A malformed row fails the load, unless badRecordsPath is
+// given, where Databricks writes the malformed rows instead of loading them.
+func (f CSVFormat) formatOptions(badRecordsPath string) string {
+	options := [][2]string{
+		{"header", "false"},
+		{"inferSchema", "false"},
+		{"sep", f.Delimiter},
+		{"quote", f.Quote},
+		{"escape", f.Escape},
+		{"nullValue", f.NullValue},
+		{"multiLine", "true"},
+		{"mode", "FAILFAST"},
+	}
+	if badRecordsPath != "" {
+		options[len(options)-1][1] = "PERMISSIVE"
+		options = append(options, [2]string{"badRecordsPath", badRecordsPath})
+	}
+	quoted := make([]string, 0, len(options))
+	for _, option := range options {
+		quoted = append(quoted, fmt.Sprintf("%s = %s", utils.QuoteLiteral(option[0]), utils.QuoteLiteral(option[1])))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// countRows returns the rows of the CSV file in the format, a line break in a quoted field is part of the row
+func (f CSVFormat) countRows(r io.Reader) (int64, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	var (
+		rows    int64
+		inQuote bool
+		inRow   bool
+	)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			if inRow {
+				rows++
+			}
+			return rows, nil
+		}
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		switch {
+		case b == f.Escape[0] && f.Escape != f.Quote:
+			// the escaped character is never a quote or a line break of the CSV
+			if _, err = br.ReadByte(); err != nil && err != io.EOF {
+				return 0, errors.Trace(err)
+			}
+			inRow = true
+		case b == f.Quote[0]:
+			// a doubled quote in a quoted field toggles twice
+			inQuote = !inQuote
+			inRow = true
+		case b == '\n' && !inQuote:
+			rows++
+			inRow = false
+		default:
+			inRow = true
+		}
+	}
+}
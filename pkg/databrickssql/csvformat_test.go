@@ -0,0 +1,57 @@
+package databrickssql
+
+import (
+	"strings"
+	"testing"
+
+	"context"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVFormat(t *testing.T) {
+	require.NoError(t, DefaultCSVFormat.Validate())
+	require.Error(t, CSVFormat{Delimiter: ",,", Quote: `"`, Escape: `\`}.Validate())
+	require.Error(t, CSVFormat{Delimiter: `"`, Quote: `"`, Escape: `\`}.Validate())
+
+	require.Equal(t,
+		`'header' = 'false', 'inferSchema' = 'false', 'sep' = ',', 'quote' = '"', 'escape' = '\\', 'nullValue' = '\\N', 'multiLine' = 'true', 'mode' = 'FAILFAST'`,
+		DefaultCSVFormat.formatOptions(""))
+	require.Contains(t, DefaultCSVFormat.formatOptions("s3://bucket/snapshot/_bad_records/t"),
+		`'mode' = 'PERMISSIVE', 'badRecordsPath' = 's3://bucket/snapshot/_bad_records/t'`)
+}
+
+func TestCSVFormatCountRows(t *testing.T) {
+	cases := []struct {
+		data string
+		rows int64
+	}{
+		{"", 0},
+		{"1,\"a\"\n2,\\N\n", 2},
+		// no line break at the end
+		{"1,\"a\"\n2,\"b\"", 2},
+		// line breaks in quoted fields, an escaped quote and a doubled quote
+		{"1,\"a\nb\"\n2,\"c\\\"\nd\"\n3,\"e\"\"\nf\"\n", 3},
+		{"1,\"a\"\r\n2,\"b\"\r\n", 2},
+	}
+	for _, c := range cases {
+		rows, err := DefaultCSVFormat.countRows(strings.NewReader(c.data))
+		require.NoError(t, err)
+		require.Equal(t, c.rows, rows, c.data)
+	}
+}
+
+func TestCountCompressedRows(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, storage.WithCompression(extStorage, storage.Gzip).WriteFile(ctx, "db.t.000000000.csv.gz", []byte("1,\"a\nb\"\n2,c\n")))
+	require.NoError(t, storage.WithCompression(extStorage, storage.Gzip).WriteFile(ctx, "db.t.000000001.csv.gz", []byte("3,d\n")))
+
+	// the rows loaded are verified against the rows of the decompressed files
+	dc := &DatabricksConnector{ctx: ctx, extStorage: extStorage, compression: utils.CompressionGzip, csvFormat: DefaultCSVFormat}
+	rows, err := dc.countRows([]string{"db.t.000000000.csv.gz", "db.t.000000001.csv.gz"})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, rows)
+}
@@ -2,29 +2,60 @@ package databrickssql
 
 import (
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"strconv"
 	"strings"
 )
 
-func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+// appliedDDLErrors are the errors of Databricks telling the effect of a column DDL is already present
+var appliedDDLErrors = tidbsql.AppliedDDLErrors{
+	ColumnExists:  []string{"FIELDS_ALREADY_EXISTS", "already exists"},
+	ColumnMissing: []string{"FIELD_NOT_FOUND", "UNRESOLVED_COLUMN", "cannot be resolved"},
+}
+
+// ddlActionClasses are the classes of the DDLs in Databricks, the default of a column is not kept by Delta
+var ddlActionClasses = tidbsql.WithDDLActionClasses(map[timodel.ActionType]tidbsql.DDLActionClass{
+	timodel.ActionSetDefaultValue: tidbsql.DDLActionNoop,
+})
+
+// GenDDLViaColumnsDiff generates the DDLs changing the columns of the table from prevColumns to the columns of
+// curTableDef, a column partitioning the table by layout is not dropped. A table created has the tombstone columns
+// of the delete mode. The table is in the namespace, and a dropped database drops the schema of the namespace.
+func (g Generator) GenDDLViaColumnsDiff(ns Namespace, prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) ([]string, error) {
+	table := g.Table(ns, curTableDef.Table)
 	if curTableDef.Type == timodel.ActionTruncateTable {
-		return []string{fmt.Sprintf("TRUNCATE TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropTable {
-		return []string{fmt.Sprintf("DROP TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("DROP TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
+		// the table is created after the changefeed starts, its columns are given by the schema file
+		ddl, err := g.GenCreateTableSQL(ns, curTableDef.Table, curTableDef.Columns, nil, columnTypes, layout, deleteMode)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{g.GenDropTableSQL(ns, curTableDef.Table), ddl}, nil
 	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		_, oldTable, err := tidbsql.GetRenamedFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", g.Table(ns, oldTable), table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropSchema {
-		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", curTableDef.Schema)}, nil
+		if ns.Schema == "" {
+			ns.Schema = curTableDef.Schema
+		}
+		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", g.QuotedSchema(ns))}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateSchema {
 		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
@@ -34,24 +65,34 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	ddls := make([]string, 0, len(columnDiff))
+	ddls := make([]string, 0, len(columnDiff)+1)
+	// dropsColumns tells whether a column is dropped or renamed, which requires the column mapping
+	dropsColumns := false
 	for _, item := range columnDiff {
 		ddl := ""
 		switch item.Action {
 		case tidbsql.ADD_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", curTableDef.Table)
-			colStr, err := GetDatabricksColumnString(*item.After)
+			addDDLs, err := g.genAddColumnDDLs(table, curTableDef.Table, *item.After, columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
-			ddl += colStr
+			ddls = append(ddls, addDDLs...)
 		case tidbsql.DROP_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", curTableDef.Table, item.Before.Name)
-		// Databricks does not support direct data type modify
+			if err := layout.CheckDropColumn(item.Before.Name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			dropsColumns = true
+			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, g.QuoteIdent(item.Before.Name))
 		case tidbsql.MODIFY_COLUMN:
-			return nil, errors.New("Received modify column ddl, which is not supported by Databricks yet")
+			modifyDDLs, recreated, err := g.genModifyColumnDDLs(table, item, curTableDef.Columns, columnTypes, layout)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			dropsColumns = dropsColumns || recreated
+			ddls = append(ddls, modifyDDLs...)
 		case tidbsql.RENAME_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", curTableDef.Table, item.Before.Name, item.After.Name)
+			dropsColumns = true
+			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, g.QuoteIdent(item.Before.Name), g.QuoteIdent(item.After.Name))
 		default:
 			// UNCHANGE
 		}
@@ -61,25 +102,197 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		}
 	}
 
+	if dropsColumns {
+		// the table is upgraded before any column is changed, so a failed upgrade leaves no column behind
+		ddls = append([]string{genEnableColumnMappingSQL(table)}, ddls...)
+	}
+
+	changes := &tidbsql.CommentChanges{}
+	if !g.skipComments {
+		changes = tidbsql.GetCommentChanges(curTableDef)
+	}
+	if changes.Table != nil {
+		ddls = append(ddls, fmt.Sprintf("COMMENT ON TABLE %s IS %s;", table, genComment(*changes.Table, maxTableCommentLength, curTableDef.Table)))
+	}
+	for _, column := range curTableDef.Columns {
+		if comment, ok := changes.Columns[column.Name]; ok {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s COMMENT %s;", table, g.QuoteIdent(column.Name), genComment(comment, maxColumnCommentLength, column.Name)))
+		}
+	}
+
 	// TODO: handle primary key
 	return ddls, nil
 }
 
 // GetDatabricksColumnString returns a string describing the column in Databricks, e.g.
-// "id INT NOT NULL DEFAULT '0'"
+// "`id` INT NOT NULL"
 // Refer to:
 // https://dev.mysql.com/doc/refman/8.0/en/data-types.html
 // https://docs.databricks.com/en/sql/language-manual/sql-ref-datatypes.html
-func GetDatabricksColumnString(column cloudstorage.TableCol) (string, error) {
+func (g Generator) GetDatabricksColumnString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
 	var sb strings.Builder
-	typeStr, err := GetDatabricksTypeString(column)
+	typeStr, err := GetDatabricksTypeString(column, columnTypes)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
-	sb.WriteString(fmt.Sprintf("%s %s", column.Name, typeStr))
+	sb.WriteString(fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), typeStr))
 	if column.Nullable == "false" {
 		sb.WriteString(" NOT NULL")
 	}
 	// Delta table not support default value yet.
 	return sb.String(), nil
 }
+
+// genAddColumnDDLs returns the DDLs of an added column. Delta neither adds a NOT NULL column nor fills the
+// existing rows with a default, so the column is added as nullable, the existing rows are updated with the
+// value filled by TiDB, then the column is set NOT NULL. tableName is quoted.
+func (g Generator) genAddColumnDDLs(tableName, table string, column cloudstorage.TableCol, columnTypes columnmapping.Columns) ([]string, error) {
+	column = tidbsql.WithAddedColumnDefault(table, column)
+	nullable := column
+	nullable.Nullable = "true"
+	colStr, err := g.GetDatabricksColumnString(nullable, columnTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ddls := []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", tableName, colStr)}
+	if column.Default != nil {
+		ddls = append(ddls, fmt.Sprintf("UPDATE %s SET %s = %s;", tableName, g.QuoteIdent(column.Name), getDefaultString(column)))
+	}
+	if column.Nullable == "false" {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", tableName, g.QuoteIdent(column.Name)))
+	}
+	return ddls, nil
+}
+
+// getDefaultString returns the literal of the default value of the column
+func getDefaultString(column cloudstorage.TableCol) string {
+	return tidbsql.DefaultLiteral(column, utils.QuoteLiteral)
+}
+
+// columnMappingProperties enable the column mapping of Delta by name, without which DROP COLUMN and RENAME COLUMN
+// fail, the reader and writer versions are the least supporting it
+const columnMappingProperties = "'delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5'"
+
+// genEnableColumnMappingSQL upgrades a table created without the column mapping, e.g. by an earlier tidb2dw, setting
+// the properties again changes nothing. tableName is quoted.
+func genEnableColumnMappingSQL(tableName string) string {
+	return fmt.Sprintf("ALTER TABLE %s SET TBLPROPERTIES (%s);", tableName, columnMappingProperties)
+}
+
+// modifyColumnTmpSuffix is the suffix of the column holding the converted data while a column is recreated
+const modifyColumnTmpSuffix = "_tidb2dw_tmp"
+
+// genModifyColumnDDLs returns the DDLs of a modified column. Delta does not change the type of a column
+// directly, so a widening type change recreates the column: the data is copied into a new column with CAST,
+// then the old column is dropped and the new one is renamed and moved back to its position. A narrowing or
+// lossy type change is not supported, nor is recreating a column partitioning the table. An overridden column
+// keeps its type. recreated tells whether the column is recreated, which requires the column mapping. tableName is
+// quoted.
+func (g Generator) genModifyColumnDDLs(tableName string, diff tidbsql.ColumnDiff, columns []cloudstorage.TableCol, columnTypes columnmapping.Columns, layout tablelayout.Layout) ([]string, bool, error) {
+	before, after := diff.Before, diff.After
+	beforeType, err := GetDatabricksTypeString(*before, columnTypes)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	afterType, err := GetDatabricksTypeString(*after, columnTypes)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+
+	ddls := make([]string, 0, 6)
+	if beforeType != afterType {
+		if !isWideningTypeChange(*before, *after) {
+			return nil, false, tidbsql.NewUnsupportedDDLError("Received modify column ddl of column %s from %s to %s, "+
+				"which may lose data and is not supported by Databricks", after.Name, beforeType, afterType)
+		}
+		if err := layout.CheckDropColumn(before.Name); err != nil {
+			return nil, false, errors.Annotatef(err, "Failed to change the type of column %s from %s to %s", after.Name, beforeType, afterType)
+		}
+		tmpName := g.QuoteIdent(after.Name + modifyColumnTmpSuffix)
+		ddls = append(ddls,
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", tableName, tmpName, afterType),
+			fmt.Sprintf("UPDATE %s SET %s = CAST(%s AS %s);", tableName, tmpName, g.QuoteIdent(before.Name), afterType),
+			fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", tableName, g.QuoteIdent(before.Name)),
+			fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", tableName, tmpName, g.QuoteIdent(after.Name)),
+			fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s;", tableName, g.QuoteIdent(after.Name), g.columnPosition(after.Name, columns)),
+		)
+		// the new column is nullable
+		if after.Nullable == "false" {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", tableName, g.QuoteIdent(after.Name)))
+		}
+		return ddls, true, nil
+	}
+
+	if before.Nullable != after.Nullable {
+		if after.Nullable == "false" {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", tableName, g.QuoteIdent(after.Name)))
+		} else {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", tableName, g.QuoteIdent(after.Name)))
+		}
+	}
+	// the other changes, e.g. the length of VARCHAR and the default value, do not change the column of Delta
+	return ddls, false, nil
+}
+
+// columnPosition returns the position clause of ALTER COLUMN placing the column as in TiDB
+func (g Generator) columnPosition(name string, columns []cloudstorage.TableCol) string {
+	for i, column := range columns {
+		if column.Name == name && i > 0 {
+			return "AFTER " + g.QuoteIdent(columns[i-1].Name)
+		}
+	}
+	return "FIRST"
+}
+
+// integerDigits is the number of decimal digits of the integer types of Databricks
+var integerDigits = map[string]int{
+	"TINYINT":  3,
+	"SMALLINT": 5,
+	"INT":      10,
+	"BIGINT":   19,
+}
+
+// isWideningTypeChange tells whether every value of the column before is kept by CAST to the type after
+func isWideningTypeChange(before, after cloudstorage.TableCol) bool {
+	beforeType, err := GetDatabricksTypeString(before, nil)
+	if err != nil {
+		return false
+	}
+	afterType, err := GetDatabricksTypeString(after, nil)
+	if err != nil {
+		return false
+	}
+	beforeDigits, beforeIsInteger := integerDigits[beforeType]
+	afterDigits, afterIsInteger := integerDigits[afterType]
+	afterPrecision, afterScale, afterIsDecimal := decimalPrecisionScale(afterType)
+	switch {
+	case beforeIsInteger && afterIsInteger:
+		return afterDigits >= beforeDigits
+	case beforeIsInteger && afterIsDecimal:
+		return afterPrecision-afterScale >= beforeDigits
+	case beforeType == "FLOAT" && afterType == "DOUBLE":
+		return true
+	}
+	beforePrecision, beforeScale, beforeIsDecimal := decimalPrecisionScale(beforeType)
+	if beforeIsDecimal && afterIsDecimal {
+		return afterScale >= beforeScale && afterPrecision-afterScale >= beforePrecision-beforeScale
+	}
+	return false
+}
+
+// decimalPrecisionScale parses the Databricks type, e.g. DECIMAL(20, 0) of BIGINT UNSIGNED
+func decimalPrecisionScale(tp string) (int, int, bool) {
+	name, params := tidbsql.SplitType(tp)
+	if (name != "DECIMAL" && name != "NUMERIC") || len(params) != 2 {
+		return 0, 0, false
+	}
+	precision, err := strconv.Atoi(params[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	scale, err := strconv.Atoi(params[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return precision, scale, true
+}
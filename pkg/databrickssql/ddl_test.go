@@ -0,0 +1,479 @@
+package databrickssql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/typetest"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiffModifyColumn(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	idColumn := cloudstorage.TableCol{ID: "1", Name: "id", Tp: "bigint", Nullable: "false", IsPK: "true"}
+	cases := []struct {
+		name     string
+		before   cloudstorage.TableCol
+		after    cloudstorage.TableCol
+		expected []string
+		err      string
+	}{
+		{
+			name:   "int to bigint",
+			before: cloudstorage.TableCol{ID: "2", Name: "v", Tp: "int"},
+			after:  cloudstorage.TableCol{ID: "3", Name: "v", Tp: "bigint", Nullable: "false"},
+			expected: []string{
+				"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');",
+				"ALTER TABLE `t` ADD COLUMN `v_tidb2dw_tmp` BIGINT;",
+				"UPDATE `t` SET `v_tidb2dw_tmp` = CAST(`v` AS BIGINT);",
+				"ALTER TABLE `t` DROP COLUMN `v`;",
+				"ALTER TABLE `t` RENAME COLUMN `v_tidb2dw_tmp` TO `v`;",
+				"ALTER TABLE `t` ALTER COLUMN `v` AFTER `id`;",
+				"ALTER TABLE `t` ALTER COLUMN `v` SET NOT NULL;",
+				// MODIFY COLUMN without COMMENT clears the comment
+				"ALTER TABLE `t` ALTER COLUMN `v` COMMENT '';",
+			},
+		},
+		{
+			name:   "decimal precision increase",
+			before: cloudstorage.TableCol{ID: "2", Name: "v", Tp: "decimal", Precision: "10", Scale: "2"},
+			after:  cloudstorage.TableCol{ID: "3", Name: "v", Tp: "decimal", Precision: "12", Scale: "2"},
+			expected: []string{
+				"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');",
+				"ALTER TABLE `t` ADD COLUMN `v_tidb2dw_tmp` DECIMAL(12, 2);",
+				"UPDATE `t` SET `v_tidb2dw_tmp` = CAST(`v` AS DECIMAL(12, 2));",
+				"ALTER TABLE `t` DROP COLUMN `v`;",
+				"ALTER TABLE `t` RENAME COLUMN `v_tidb2dw_tmp` TO `v`;",
+				"ALTER TABLE `t` ALTER COLUMN `v` AFTER `id`;",
+				"ALTER TABLE `t` ALTER COLUMN `v` COMMENT '';",
+			},
+		},
+		{
+			name:     "varchar length and nullability",
+			before:   cloudstorage.TableCol{ID: "2", Name: "v", Tp: "varchar", Precision: "10", Nullable: "false"},
+			after:    cloudstorage.TableCol{ID: "2", Name: "v", Tp: "varchar", Precision: "100"},
+			expected: []string{"ALTER TABLE `t` ALTER COLUMN `v` DROP NOT NULL;", "ALTER TABLE `t` ALTER COLUMN `v` COMMENT '';"},
+		},
+		{
+			name:   "decimal scale decrease",
+			before: cloudstorage.TableCol{ID: "2", Name: "v", Tp: "decimal", Precision: "10", Scale: "4"},
+			after:  cloudstorage.TableCol{ID: "3", Name: "v", Tp: "decimal", Precision: "10", Scale: "2"},
+			err:    "column v from DECIMAL(10, 4) to DECIMAL(10, 2)",
+		},
+		{
+			name:   "varchar to int",
+			before: cloudstorage.TableCol{ID: "2", Name: "v", Tp: "varchar", Precision: "10"},
+			after:  cloudstorage.TableCol{ID: "3", Name: "v", Tp: "int"},
+			err:    "column v from STRING to INT",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tableDef := cloudstorage.TableDefinition{
+				Table:   "t",
+				Schema:  "test",
+				Type:    timodel.ActionModifyColumn,
+				Query:   "ALTER TABLE t MODIFY COLUMN v BIGINT",
+				Columns: []cloudstorage.TableCol{idColumn, c.after},
+			}
+			ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, []cloudstorage.TableCol{idColumn, c.before}, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+			if c.err != "" {
+				require.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, ddls)
+		})
+	}
+}
+
+func TestGenDDLViaColumnsDiffChanges(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	expected := map[string]struct {
+		ddls []string
+		err  string
+	}{
+		"add column": {ddls: []string{"ALTER TABLE `t` ADD COLUMN `email` STRING;"}},
+		"add not null column": {ddls: []string{
+			"ALTER TABLE `t` ADD COLUMN `score` INT;",
+			"UPDATE `t` SET `score` = 0;",
+			"ALTER TABLE `t` ALTER COLUMN `score` SET NOT NULL;",
+		}},
+		"add column with default": {ddls: []string{
+			"ALTER TABLE `t` ADD COLUMN `level` INT;",
+			"UPDATE `t` SET `level` = 1;",
+			"ALTER TABLE `t` ALTER COLUMN `level` SET NOT NULL;",
+		}},
+		"add column with quoted default": {ddls: []string{
+			"ALTER TABLE `t` ADD COLUMN `note` STRING;",
+			"UPDATE `t` SET `note` = 'it\\'s \\\\ ok';",
+			"ALTER TABLE `t` ALTER COLUMN `note` SET NOT NULL;",
+		}},
+		"add column with numeric string default": {ddls: []string{
+			"ALTER TABLE `t` ADD COLUMN `code` STRING;",
+			"UPDATE `t` SET `code` = '007';",
+			"ALTER TABLE `t` ALTER COLUMN `code` SET NOT NULL;",
+		}},
+		// the zero value of DATE in TiDB is not a date of Databricks
+		"add not null date column": {ddls: []string{"ALTER TABLE `t` ADD COLUMN `born` DATE;"}},
+		"drop column":              {ddls: []string{"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');", "ALTER TABLE `t` DROP COLUMN `age`;"}},
+		"rename column":            {ddls: []string{"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');", "ALTER TABLE `t` RENAME COLUMN `name` TO `nickname`;"}},
+		// a VARCHAR is a STRING of any length
+		"widen varchar": {ddls: []string{"ALTER TABLE `t` ALTER COLUMN `name` COMMENT '';"}},
+		"int to bigint": {ddls: []string{
+			"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');",
+			"ALTER TABLE `t` ADD COLUMN `age_tidb2dw_tmp` BIGINT;",
+			"UPDATE `t` SET `age_tidb2dw_tmp` = CAST(`age` AS BIGINT);",
+			"ALTER TABLE `t` DROP COLUMN `age`;",
+			"ALTER TABLE `t` RENAME COLUMN `age_tidb2dw_tmp` TO `age`;",
+			"ALTER TABLE `t` ALTER COLUMN `age` AFTER `name`;",
+			"ALTER TABLE `t` ALTER COLUMN `age` COMMENT '';",
+		}},
+		"varchar to int": {err: "column name from STRING to INT"},
+		"truncate table": {ddls: []string{"TRUNCATE TABLE `t`"}},
+		"drop table":     {ddls: []string{"DROP TABLE `t`"}},
+	}
+	for _, change := range ddltest.Changes() {
+		t.Run(change.Name, func(t *testing.T) {
+			ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, change.PrevColumns, change.TableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+			if expected[change.Name].err != "" {
+				require.ErrorContains(t, err, expected[change.Name].err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, expected[change.Name].ddls, ddls)
+		})
+	}
+}
+
+func TestGenMergeIntoSQLQuoteIdent(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "order",
+		Columns: []cloudstorage.TableCol{
+			{Name: "select", Tp: "int", IsPK: "true"},
+			{Name: "名称", Tp: "varchar"},
+			{Name: "a`b", Tp: "int"},
+		},
+	}
+	query := gen.GenMergeIntoSQL(databrickssql.Namespace{}, tableDef, "order", "incr_order", nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "MERGE INTO `order` AS T USING")
+	require.Contains(t, query, "partition by `select` order by tidb2dw_commit_ts desc, CASE WHEN tidb2dw_flag = 'D' THEN 0 ELSE 1 END desc")
+	require.Contains(t, query, "FROM `incr_order`")
+	require.Contains(t, query, "T.`select` = S.`select`")
+	require.Contains(t, query, "UPDATE SET `select` = S.`select`, `名称` = S.`名称`, `a``b` = S.`a``b`")
+	require.Contains(t, query, "INSERT (`select`, `名称`, `a``b`) VALUES (S.`select`, S.`名称`, S.`a``b`)")
+
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{
+		Table:   "order",
+		Type:    timodel.ActionCreateTable,
+		Columns: tableDef.Columns,
+	}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP TABLE IF EXISTS `order`", "CREATE TABLE `order` (\n    `select` INT,\n    `名称` STRING,\n    `a``b` INT\n)\nTBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')"}, ddls)
+
+	// the rows deleted are marked deleted in the soft delete mode
+	query = gen.GenMergeIntoSQL(databrickssql.Namespace{}, tableDef, "order", "incr_order", nil, "", deletemode.Soft, stagingformat.CSV)
+	require.Contains(t, query, "THEN UPDATE SET `_tidb_deleted` = TRUE, `_tidb_deleted_at` = current_timestamp()")
+	require.Contains(t, query, "`a``b` = S.`a``b`, `_tidb_deleted` = FALSE, `_tidb_deleted_at` = NULL")
+	require.Contains(t, query, "INSERT (`select`, `名称`, `a``b`, `_tidb_deleted`, `_tidb_deleted_at`) VALUES (S.`select`, S.`名称`, S.`a``b`, FALSE, NULL)")
+	require.NotContains(t, query, "THEN DELETE")
+	ddls, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{
+		Table:   "order",
+		Type:    timodel.ActionCreateTable,
+		Columns: tableDef.Columns,
+	}, nil, tablelayout.Layout{}, deletemode.Soft)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE `order` (\n    `select` INT,\n    `名称` STRING,\n    `a``b` INT,\n    `_tidb_deleted` BOOLEAN,\n    `_tidb_deleted_at` TIMESTAMP\n)\nTBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')", ddls[1])
+}
+
+func TestGenDDLViaColumnsDiffWithLayout(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT"},
+		{ID: "2", Name: "created_at", Tp: "DATE"},
+	}
+	layout := tablelayout.Layout{PartitionBy: []string{"created_at"}}
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionCreateTable, Columns: columns,
+	}, nil, layout, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP TABLE IF EXISTS `t`", "CREATE TABLE `t` (\n    `id` INT,\n    `created_at` DATE\n)\nPARTITIONED BY (`created_at`)\nTBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')"}, ddls)
+
+	_, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionCreateTable, Columns: columns,
+	}, nil, tablelayout.Layout{PartitionBy: []string{"id", "created_at"}}, deletemode.Hard)
+	require.ErrorContains(t, err, "can not be partitioned by all its columns")
+
+	// a partitioning column is neither dropped nor recreated by a type change
+	_, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionDropColumn, Columns: columns[:1],
+	}, nil, layout, deletemode.Hard)
+	require.ErrorContains(t, err, "Can not drop column created_at which partitions or clusters the table")
+	_, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionModifyColumn, Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "BIGINT"}, columns[1]},
+	}, nil, tablelayout.Layout{PartitionBy: []string{"id"}}, deletemode.Hard)
+	require.ErrorContains(t, err, "Can not drop column id")
+}
+
+func TestGetDatabricksTypeStringUnsigned(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	for tp, expected := range map[string]string{
+		"TINYINT UNSIGNED":   "SMALLINT",
+		"smallint unsigned":  "INT",
+		"MEDIUMINT UNSIGNED": "INT",
+		"INT UNSIGNED":       "BIGINT",
+		// 18446744073709551615, the max of BIGINT UNSIGNED, has 20 digits
+		"BIGINT UNSIGNED": "DECIMAL(20, 0)",
+		"FLOAT UNSIGNED":  "FLOAT",
+	} {
+		actual, err := databrickssql.GetDatabricksTypeString(cloudstorage.TableCol{Name: "c", Tp: tp}, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, tp)
+	}
+
+	// widening to BIGINT UNSIGNED recreates the column, narrowing it is not supported
+	prev := []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "INT UNSIGNED"}}
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, prev, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionModifyColumn, Columns: []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "BIGINT UNSIGNED"}},
+	}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Contains(t, ddls, "UPDATE `t` SET `v_tidb2dw_tmp` = CAST(`v` AS DECIMAL(20, 0));")
+	_, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "BIGINT UNSIGNED"}}, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionModifyColumn, Columns: []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "BIGINT"}},
+	}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.ErrorContains(t, err, "not supported by Databricks")
+}
+
+func TestGetDatabricksTypeStringAllTypes(t *testing.T) {
+	expected := map[string]string{
+		"c_tinyint":            "TINYINT",
+		"c_tinyint_unsigned":   "SMALLINT",
+		"c_smallint":           "SMALLINT",
+		"c_smallint_unsigned":  "INT",
+		"c_mediumint":          "INT",
+		"c_mediumint_unsigned": "INT",
+		"c_int":                "INT",
+		"c_int_unsigned":       "BIGINT",
+		"c_bigint":             "BIGINT",
+		"c_bigint_unsigned":    "DECIMAL(20, 0)",
+		"c_float":              "FLOAT",
+		"c_double":             "DOUBLE",
+		"c_decimal":            "DECIMAL(20, 6)",
+		"c_bit":                "BOOLEAN",
+		"c_bit_long":           "BINARY",
+		"c_date":               "DATE",
+		"c_datetime":           "TIMESTAMP_NTZ",
+		"c_timestamp":          "TIMESTAMP",
+		"c_time":               "TIMESTAMP_NTZ",
+		"c_char":               "STRING",
+		"c_varchar":            "STRING",
+		"c_tinytext":           "STRING",
+		"c_text":               "STRING",
+		"c_mediumtext":         "STRING",
+		"c_longtext":           "STRING",
+		"c_tinyblob":           "STRING",
+		"c_blob":               "STRING",
+		"c_mediumblob":         "STRING",
+		"c_longblob":           "STRING",
+		"c_enum":               "STRING",
+		"c_set":                "STRING",
+	}
+	for _, column := range typetest.Columns() {
+		actual, err := databrickssql.GetDatabricksTypeString(column, nil)
+		switch column.Name {
+		case "c_decimal_wide":
+			require.ErrorContains(t, err, "Column c_decimal_wide of DECIMAL(40, 10) exceeds the max precision 38 of Databricks")
+		case "c_binary", "c_varbinary", "c_year", "c_json":
+			require.ErrorContains(t, err, "Unsupported data type")
+		default:
+			require.NoError(t, err, column.Name)
+			require.Equal(t, expected[column.Name], actual, column.Name)
+		}
+	}
+}
+
+func TestGenMergeIntoSQLBit(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "flags",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "INT", IsPK: "true"},
+			{Name: "enabled", Tp: "BIT", Precision: "1"},
+			{Name: "mask", Tp: "BIT", Precision: "12"},
+		},
+	}
+	// BIT is read as a string by the external table and converted by the merge
+	query, err := gen.GenCreateExternalTableSQL(databrickssql.Namespace{}, "incr_flags", tableDef.Columns, "s3://bucket/flags", "cred", nil)
+	require.NoError(t, err)
+	require.Contains(t, query, "`enabled` STRING,\n`mask` STRING")
+	query = gen.GenMergeIntoSQL(databrickssql.Namespace{}, tableDef, "flags", "incr_flags", nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "`enabled` = (S.`enabled` = '1'), `mask` = unhex(lpad(conv(S.`mask`, 10, 16), 4, '0'))")
+	require.Contains(t, query, "VALUES (S.`id`, (S.`enabled` = '1'), unhex(lpad(conv(S.`mask`, 10, 16), 4, '0')))")
+
+	// a column overridden is read and merged as the type given
+	columnTypes := columnmapping.Columns{"mask": "BIGINT"}
+	query, err = gen.GenCreateExternalTableSQL(databrickssql.Namespace{}, "incr_flags", tableDef.Columns, "s3://bucket/flags", "cred", columnTypes)
+	require.NoError(t, err)
+	require.Contains(t, query, "`mask` BIGINT")
+	query = gen.GenMergeIntoSQL(databrickssql.Namespace{}, tableDef, "flags", "incr_flags", columnTypes, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "`mask` = S.`mask`")
+
+	// the external table of a Parquet file reads the types of the file, which are cast to the table
+	query, err = gen.GenCreateExternalTableSQL(databrickssql.Namespace{}, "incr_flags", tableDef.Columns, "s3://bucket/flags/a.parquet", "cred", nil)
+	require.NoError(t, err)
+	require.Contains(t, query, "CREATE EXTERNAL TABLE `incr_flags`\n\tUSING PARQUET")
+	query = gen.GenMergeIntoSQL(databrickssql.Namespace{}, tableDef, "flags", "incr_flags", nil, "", deletemode.Hard, stagingformat.Parquet)
+	require.Contains(t, query, "`enabled` = cast(S.`enabled` as BOOLEAN), `mask` = cast(S.`mask` as BINARY)")
+}
+
+func TestNamespace(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	require.Equal(t, "`t`", gen.Table(databrickssql.Namespace{}, "t"))
+	require.Equal(t, "`sales`.`t`", gen.Table(databrickssql.Namespace{Schema: "sales"}, "t"))
+	// the catalog qualifies the table only together with the schema
+	require.Equal(t, "`t`", gen.Table(databrickssql.Namespace{Catalog: "main"}, "t"))
+	ns := databrickssql.Namespace{Catalog: "main", Schema: "sales"}
+	require.Equal(t, "`main`.`sales`.`or``ders`", gen.Table(ns, "or`ders"))
+	require.Equal(t, "`main`.`sales`", gen.QuotedSchema(ns))
+
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "db",
+		Table:  "order",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "int", IsPK: "true"},
+			{ID: "2", Name: "v", Tp: "int"},
+		},
+	}
+	query := gen.GenMergeIntoSQL(ns, tableDef, "order", "incr_order", nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "MERGE INTO `main`.`sales`.`order` AS T USING")
+	require.Contains(t, query, "FROM `main`.`sales`.`incr_order`")
+	query, err := gen.GenCreateExternalTableSQL(ns, "incr_order", tableDef.Columns, "s3://bucket/order", "cred", nil)
+	require.NoError(t, err)
+	require.Contains(t, query, "CREATE EXTERNAL TABLE `main`.`sales`.`incr_order` (")
+
+	ddls, err := gen.GenDDLViaColumnsDiff(ns, tableDef.Columns[:1], tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `main`.`sales`.`order` ADD COLUMN `v` INT;"}, ddls)
+	ddls, err = gen.GenDDLViaColumnsDiff(ns, nil, cloudstorage.TableDefinition{
+		Schema: "db", Table: "order", Type: timodel.ActionCreateTable, Columns: tableDef.Columns,
+	}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "DROP TABLE IF EXISTS `main`.`sales`.`order`", ddls[0])
+	require.True(t, strings.HasPrefix(ddls[1], "CREATE TABLE `main`.`sales`.`order` ("))
+	// the schema of the namespace is dropped with the database
+	ddls, err = gen.GenDDLViaColumnsDiff(ns, nil, cloudstorage.TableDefinition{Schema: "db", Type: timodel.ActionDropSchema}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP SCHEMA `main`.`sales` CASCADE"}, ddls)
+	ddls, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{Schema: "db", Type: timodel.ActionDropSchema}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP SCHEMA `db` CASCADE"}, ddls)
+}
+
+func TestIdentifierCase(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "UserEvents",
+		Type:  timodel.ActionCreateTable,
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "UserID", Tp: "int", IsPK: "true"},
+			{ID: "2", Name: "event_name", Tp: "varchar"},
+		},
+	}
+	namespace := databrickssql.Namespace{Catalog: "Main", Schema: "Analytics"}
+
+	// the names are lowercased by default, as Unity Catalog stores them
+	ddls, err := gen.GenDDLViaColumnsDiff(namespace, nil, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP TABLE IF EXISTS `main`.`analytics`.`userevents`", "CREATE TABLE `main`.`analytics`.`userevents` (\n    `userid` INT,\n    `event_name` STRING\n)\nTBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')"}, ddls)
+	query := gen.GenMergeIntoSQL(namespace, tableDef, "UserEvents", "incr_UserEvents", nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "MERGE INTO `main`.`analytics`.`userevents` AS T USING")
+	require.Contains(t, query, "T.`userid` = S.`userid`")
+	require.NotContains(t, query, "UserID")
+
+	gen = databrickssql.NewGenerator(identcase.Preserve)
+	prevColumns := tableDef.Columns
+	tableDef.Type = timodel.ActionAddColumn
+	tableDef.Columns = append(prevColumns, cloudstorage.TableCol{ID: "3", Name: "CreatedAt", Tp: "int"})
+	ddls, err = gen.GenDDLViaColumnsDiff(namespace, prevColumns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `Main`.`Analytics`.`UserEvents` ADD COLUMN `CreatedAt` INT;"}, ddls)
+	query = gen.GenMergeIntoSQL(namespace, tableDef, "UserEvents", "incr_UserEvents", nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, "T.`UserID` = S.`UserID`")
+	require.Contains(t, query, "INSERT (`UserID`, `event_name`, `CreatedAt`) VALUES (S.`UserID`, S.`event_name`, S.`CreatedAt`)")
+}
+
+func TestGenDDLViaColumnsDiffLongComment(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	// the comments are truncated to the limits of the Hive metastore
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "t",
+		Type:    timodel.ActionModifyColumn,
+		Query:   "ALTER TABLE t MODIFY COLUMN id INT COMMENT '" + strings.Repeat("a", 300) + "'",
+		Columns: columns,
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `t` ALTER COLUMN `id` COMMENT '" + strings.Repeat("a", 256) + "';"}, ddls)
+
+	tableDef.Type, tableDef.Query = timodel.ActionModifyTableComment, "ALTER TABLE t COMMENT = '"+strings.Repeat("a", 5000)+"'"
+	ddls, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"COMMENT ON TABLE `t` IS '" + strings.Repeat("a", 4000) + "';"}, ddls)
+}
+
+func TestGenDDLViaColumnsDiffColumnMapping(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Lower)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", IsPK: "true"},
+		{ID: "2", Name: "v", Tp: "int"},
+		{ID: "3", Name: "note", Tp: "varchar", Precision: "10"},
+	}
+	// Delta drops and renames the columns of a table only with the column mapping, the tables are created with it
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, nil, cloudstorage.TableDefinition{
+		Table: "t", Type: timodel.ActionCreateTable, Columns: columns,
+	}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE `t` (\n    `id` INT,\n    `v` INT,\n    `note` STRING\n)\n"+
+		"TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5')", ddls[1])
+
+	// a table created without it is upgraded once before the first column is changed
+	tableDef := cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionModifyColumn,
+		Query: "ALTER TABLE t MODIFY COLUMN v BIGINT COMMENT 'v'",
+		Columns: []cloudstorage.TableCol{
+			columns[0],
+			{ID: "2", Name: "v", Tp: "bigint"},
+		},
+	}
+	ddls, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');",
+		"ALTER TABLE `t` ADD COLUMN `v_tidb2dw_tmp` BIGINT;",
+		"UPDATE `t` SET `v_tidb2dw_tmp` = CAST(`v` AS BIGINT);",
+		"ALTER TABLE `t` DROP COLUMN `v`;",
+		"ALTER TABLE `t` RENAME COLUMN `v_tidb2dw_tmp` TO `v`;",
+		"ALTER TABLE `t` ALTER COLUMN `v` AFTER `id`;",
+		"ALTER TABLE `t` DROP COLUMN `note`;",
+		"ALTER TABLE `t` ALTER COLUMN `v` COMMENT 'v';",
+	}, ddls)
+
+	// the other changes leave the table as it is
+	tableDef.Columns = append(columns, cloudstorage.TableCol{ID: "4", Name: "email", Tp: "varchar", Precision: "10"})
+	tableDef.Type, tableDef.Query = timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN email VARCHAR(10)"
+	ddls, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ALTER TABLE `t` ADD COLUMN `email` STRING;"}, ddls)
+}
@@ -0,0 +1,148 @@
+package databrickssql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// NormalizeDatabricksType returns the type by its name in Databricks, so that the synonyms are equal, e.g. BIGINT
+// for LONG and DECIMAL(10,0) for DECIMAL. The parameters omitted are the defaults of Databricks.
+func NormalizeDatabricksType(tp string) string {
+	name, params := tidbsql.SplitType(tp)
+	if !slices.ContainsFunc(params, func(p string) bool { return p != "" }) {
+		params = nil
+	}
+	param := func(i int, def string) string {
+		if i < len(params) {
+			return params[i]
+		}
+		return def
+	}
+	switch name {
+	case "INT", "INTEGER":
+		return "INT"
+	case "BIGINT", "LONG":
+		return "BIGINT"
+	case "SMALLINT", "SHORT":
+		return "SMALLINT"
+	case "TINYINT", "BYTE":
+		return "TINYINT"
+	case "FLOAT", "REAL":
+		return "FLOAT"
+	case "BOOLEAN", "BOOL":
+		return "BOOLEAN"
+	case "DECIMAL", "DEC", "NUMERIC":
+		return fmt.Sprintf("DECIMAL(%s,%s)", param(0, "10"), param(1, "0"))
+	}
+	if len(params) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// getDatabricksColumnType returns the normalized type of the column in Databricks
+func getDatabricksColumnType(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	typeStr, err := GetDatabricksTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return NormalizeDatabricksType(typeStr), nil
+}
+
+// databricks2TiDBTypeMap maps the normalized types of Databricks to the TiDB types mapped to them
+var databricks2TiDBTypeMap = map[string]string{
+	"TINYINT":       "tinyint",
+	"SMALLINT":      "smallint",
+	"INT":           "int",
+	"BIGINT":        "bigint",
+	"FLOAT":         "float",
+	"DOUBLE":        "double",
+	"BOOLEAN":       "bool",
+	"DATE":          "date",
+	"TIMESTAMP":     "timestamp",
+	"TIMESTAMP_NTZ": "datetime",
+	"STRING":        "text",
+}
+
+// DriftedColumns returns the columns of the table in Databricks of the drift as TiDB columns, the previous columns
+// of GenDDLViaColumnsDiff, so that a column drifted in type is widened back as by a DDL. A column drifted to a type
+// not mapped from TiDB fails.
+func DriftedColumns(drift *tidbsql.SchemaDrift) ([]cloudstorage.TableCol, error) {
+	expected := make(map[string]cloudstorage.TableCol, len(drift.Expected))
+	for _, column := range drift.Expected {
+		expected[column.ID] = column
+	}
+	columns := make([]cloudstorage.TableCol, 0, len(drift.Columns))
+	for _, column := range drift.Columns {
+		if after, ok := expected[column.ID]; ok && column.Tp != after.Tp {
+			// the column drifted in type has the type of Databricks
+			if precision, scale, ok := decimalPrecisionScale(column.Tp); ok {
+				column.Tp, column.Precision, column.Scale = "decimal", fmt.Sprint(precision), fmt.Sprint(scale)
+			} else if tp, ok := databricks2TiDBTypeMap[column.Tp]; ok {
+				column.Tp = tp
+			} else {
+				return nil, tidbsql.NewUnsupportedDDLError("column %s is %s in Databricks, which can not be altered to %s",
+					column.Name, column.Tp, after.Tp)
+			}
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// informationSchema returns the information schema of the catalog of the namespace and the literal of its schema,
+// the current ones of the session if the namespace is not set
+func (g Generator) informationSchema(ns Namespace) (informationSchema, schema string) {
+	// the information schema of Unity Catalog is per catalog
+	informationSchema, schema = "information_schema", "current_schema()"
+	if ns.Catalog != "" {
+		informationSchema = g.QuoteIdent(ns.Catalog) + ".information_schema"
+	}
+	if ns.Schema != "" {
+		schema = utils.QuoteLiteral(strings.ToLower(ns.Schema))
+	}
+	return informationSchema, schema
+}
+
+// GetWarehouseColumns returns the columns of the table in the namespace of Databricks with their normalized types,
+// the current catalog and schema of the session are read if the namespace is not set
+func (g Generator) GetWarehouseColumns(db *sql.DB, ns Namespace, tableName string) ([]tidbsql.WarehouseColumn, error) {
+	informationSchema, schema := g.informationSchema(ns)
+	query := fmt.Sprintf(`SELECT column_name, full_data_type, is_nullable
+FROM %s.columns
+WHERE table_schema = %s AND table_name = %s
+ORDER BY ordinal_position`, informationSchema, schema, utils.QuoteLiteral(strings.ToLower(tableName)))
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var columns []tidbsql.WarehouseColumn
+	for rows.Next() {
+		var name, dataType, isNullable string
+		if err := rows.Scan(&name, &dataType, &isNullable); err != nil {
+			return nil, errors.Trace(err)
+		}
+		columns = append(columns, tidbsql.WarehouseColumn{
+			Name:     name,
+			Type:     NormalizeDatabricksType(dataType),
+			Nullable: isNullable == "YES",
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(columns) == 0 {
+		return nil, errors.Annotatef(tidbsql.ErrWarehouseTableNotFound, "table %s is not found in Databricks", tableName)
+	}
+	return columns, nil
+}
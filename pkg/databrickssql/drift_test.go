@@ -0,0 +1,83 @@
+package databrickssql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeDatabricksType(t *testing.T) {
+	for _, synonyms := range [][]string{
+		{"INT", "integer"},
+		{"BIGINT", "long"},
+		{"SMALLINT", "short"},
+		{"DECIMAL(10,0)", "decimal", "DEC(10)", "NUMERIC(10, 0)"},
+		{"DECIMAL(20,6)", "decimal(20, 6)"},
+		{"BOOLEAN", "bool"},
+		{"TIMESTAMP_NTZ", "timestamp_ntz"},
+	} {
+		for _, tp := range synonyms {
+			require.Equal(t, synonyms[0], databrickssql.NormalizeDatabricksType(tp), tp)
+		}
+	}
+}
+
+func TestReconcileSchemaDDLs(t *testing.T) {
+	gen := databrickssql.NewGenerator(identcase.Preserve)
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "BIGINT", Nullable: "false"},
+		{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "20"},
+		{ID: "3", Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}
+	actual := []tidbsql.WarehouseColumn{
+		{Name: "id", Type: "INT"},
+		{Name: "name", Type: "STRING", Nullable: true},
+		{Name: "indexed", Type: "STRING", Nullable: true},
+	}
+	diffSchema := func() *tidbsql.SchemaDrift {
+		drift, err := tidbsql.GetSchemaDrift(expected, actual, func(column cloudstorage.TableCol) (string, error) {
+			tp, err := databrickssql.GetDatabricksTypeString(column, nil)
+			return databrickssql.NormalizeDatabricksType(tp), err
+		})
+		require.NoError(t, err)
+		return drift
+	}
+	drift := diffSchema()
+	require.Equal(t, "column amount: missing in the data warehouse, expected DECIMAL(10,2)\n"+
+		"column id: INT in the data warehouse, expected BIGINT\n"+
+		"column indexed: STRING in the data warehouse, not expected", drift.String())
+	prevColumns, err := databrickssql.DriftedColumns(drift)
+	require.NoError(t, err)
+	ddls, err := gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, prevColumns, cloudstorage.TableDefinition{Table: "t", Columns: drift.Expected}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"ALTER TABLE `t` SET TBLPROPERTIES ('delta.columnMapping.mode' = 'name', 'delta.minReaderVersion' = '2', 'delta.minWriterVersion' = '5');",
+		"ALTER TABLE `t` ADD COLUMN `id_tidb2dw_tmp` BIGINT;",
+		"UPDATE `t` SET `id_tidb2dw_tmp` = CAST(`id` AS BIGINT);",
+		"ALTER TABLE `t` DROP COLUMN `id`;",
+		"ALTER TABLE `t` RENAME COLUMN `id_tidb2dw_tmp` TO `id`;",
+		"ALTER TABLE `t` ALTER COLUMN `id` FIRST;",
+		"ALTER TABLE `t` ALTER COLUMN `id` SET NOT NULL;",
+		"ALTER TABLE `t` DROP COLUMN `indexed`;",
+		"ALTER TABLE `t` ADD COLUMN `amount` DECIMAL(10, 2);",
+	}, ddls)
+
+	// a column changed by hand to a type it can not be cast back from is not altered
+	actual[0].Type = "STRING"
+	drift = diffSchema()
+	prevColumns, err = databrickssql.DriftedColumns(drift)
+	require.NoError(t, err)
+	_, err = gen.GenDDLViaColumnsDiff(databrickssql.Namespace{}, prevColumns, cloudstorage.TableDefinition{Table: "t", Columns: drift.Expected}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.True(t, tidbsql.IsUnsupportedDDL(err))
+
+	// a column of a type not mapped from TiDB fails
+	actual[0].Type = "ARRAY<INT>"
+	_, err = databrickssql.DriftedColumns(diffSchema())
+	require.ErrorContains(t, err, "column id is ARRAY<INT> in Databricks, which can not be altered to BIGINT")
+}
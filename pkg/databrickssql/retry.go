@@ -0,0 +1,36 @@
+package databrickssql
+
+import (
+	stderrors "errors"
+	"strings"
+
+	dbsqlerr "github.com/databricks/databricks-sql-go/errors"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+)
+
+// retryableMessages are the messages of the errors of Databricks which are transient, e.g. the SQL warehouse is
+// starting, which are not flagged retryable by the driver
+var retryableMessages = []string{
+	"temporarily_unavailable",
+	"too many requests",
+	"service unavailable",
+}
+
+// IsRetryableError tells whether the statement which failed with err may succeed if it is run again, e.g. the SQL
+// warehouse is unavailable while it is starting
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var dbErr dbsqlerr.DBError
+	if stderrors.As(err, &dbErr) && dbErr.IsRetryable() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range retryableMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return retry.IsTransient(err)
+}
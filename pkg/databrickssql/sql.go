@@ -3,47 +3,135 @@ package databrickssql
 import (
 	"database/sql"
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"gitlab.com/tymonx/go-formatter/formatter"
 	"go.uber.org/zap"
+	"slices"
 	"strings"
 )
 
-func GenMergeIntoSQL(tableDef cloudstorage.TableDefinition, tableName, externalTableName string) string {
+const (
+	// maxColumnCommentLength is the limit of characters of a column comment in the Hive metastore
+	maxColumnCommentLength = 256
+	// maxTableCommentLength is the limit of characters of a table comment in the Hive metastore
+	maxTableCommentLength = 4000
+)
+
+// genComment returns the literal of the comment, object is the table or column logged when the comment is truncated
+func genComment(comment string, limit int, object string) string {
+	return utils.QuoteLiteral(tidbsql.TruncateComment(comment, limit, object))
+}
+
+// Generator generates the statements of Databricks, the names are quoted in its identifier case. The columns keep
+// the case written, though they are resolved case-insensitively.
+type Generator struct {
+	identifierCase identcase.Case
+	// skipComments leaves the tables and the columns without comments
+	skipComments bool
+}
+
+// NewGenerator returns the generator writing the names in the case, identcase.Lower by default as Unity Catalog
+// stores the names of the objects in lower case
+func NewGenerator(identifierCase identcase.Case) Generator {
+	return Generator{identifierCase: identifierCase}
+}
+
+// WithSyncComments returns the generator replicating the comments of the tables and the columns or not
+func (g Generator) WithSyncComments(sync bool) Generator {
+	g.skipComments = !sync
+	return g
+}
+
+// QuoteIdent quotes the name of a schema, a table, a column or a credential by backticks in the identifier case,
+// the backticks in the name are escaped
+func (g Generator) QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(g.identifierCase.Apply(name), "`", "``") + "`"
+}
+
+// Namespace is the catalog and the schema of the tables in Databricks, the tables of Unity Catalog are named by
+// three parts, <catalog>.<schema>.<table>. An empty part is resolved by the session, e.g. the zero Namespace names
+// the tables by their names alone.
+type Namespace struct {
+	Catalog string
+	Schema  string
+}
+
+// Table returns the quoted name of the table qualified by the namespace, e.g. `main`.`sales`.`orders`. The
+// catalog qualifies the table only together with the schema.
+func (g Generator) Table(ns Namespace, name string) string {
+	if ns.Schema == "" {
+		return g.QuoteIdent(name)
+	}
+	return g.QuotedSchema(ns) + "." + g.QuoteIdent(name)
+}
+
+// QuotedSchema returns the quoted name of the schema qualified by the catalog, e.g. `main`.`sales`
+func (g Generator) QuotedSchema(ns Namespace) string {
+	if ns.Catalog == "" {
+		return g.QuoteIdent(ns.Schema)
+	}
+	return g.QuoteIdent(ns.Catalog) + "." + g.QuoteIdent(ns.Schema)
+}
+
+// GenMergeIntoSQL merges the latest rows of the keys in the external table into the table. If where is not empty,
+// only the rows matching it are kept in the table. The rows deleted are deleted or marked deleted by the delete mode.
+// Both tables are in the namespace, the external table reads the files of the format.
+func (g Generator) GenMergeIntoSQL(ns Namespace, tableDef cloudstorage.TableDefinition, tableName, externalTableName string, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) string {
+	clauses := deleteMode.MergeClauses(g.QuoteIdent, "current_timestamp()")
 	pkColumn := make([]string, 0)
 	onStat := make([]string, 0)
 	for _, col := range tableDef.Columns {
 		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, col.Name, col.Name))
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
+			onStat = append(onStat, fmt.Sprintf(`T.%s = %s`, g.QuoteIdent(col.Name), g.castField(col, columnTypes, format)))
 		}
 	}
 
 	updateStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		updateStat = append(updateStat, fmt.Sprintf(`%s = S.%s`, col.Name, col.Name))
+		updateStat = append(updateStat, fmt.Sprintf(`%s = %s`, g.QuoteIdent(col.Name), g.castField(col, columnTypes, format)))
 	}
+	updateStat = append(updateStat, clauses.UpdateSets...)
 
 	insertStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		insertStat = append(insertStat, col.Name)
+		insertStat = append(insertStat, g.QuoteIdent(col.Name))
 	}
+	insertStat = append(insertStat, clauses.InsertColumns...)
 
 	valuesStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		valuesStat = append(valuesStat, fmt.Sprintf(`S.%s`, col.Name))
+		valuesStat = append(valuesStat, g.castField(col, columnTypes, format))
+	}
+	valuesStat = append(valuesStat, clauses.InsertValues...)
+
+	upsertCond := fmt.Sprintf("S.%s != 'D'", utils.CDCFlagColumnName)
+	deleteCond := fmt.Sprintf("S.%s = 'D'", utils.CDCFlagColumnName)
+	matchedStat := ""
+	if where != "" {
+		// the latest row of a key is matched, a row updated to not match any more is deleted
+		matchedStat = fmt.Sprintf(", COALESCE((%s), FALSE) AS %s", where, utils.WhereMatchedColumnName)
+		upsertCond += fmt.Sprintf(" AND S.%s", utils.WhereMatchedColumnName)
+		deleteCond = fmt.Sprintf("(%s OR NOT S.%s)", deleteCond, utils.WhereMatchedColumnName)
 	}
 
 	mergeSQL := fmt.Sprintf(
 		`MERGE INTO %s AS T USING
 	(
-		SELECT * EXCEPT(row_num)
+		SELECT * EXCEPT(row_num)%s
 		FROM (
 			SELECT
-				*, row_number() over (partition by %s order by %s desc) as row_num
+				*, row_number() over (partition by %s order by %s) as row_num
 			FROM %s
 		)
 		WHERE row_num = 1
@@ -52,18 +140,20 @@ func GenMergeIntoSQL(tableDef cloudstorage.TableDefinition, tableName, externalT
 	(
 		%s
 	)
-	WHEN MATCHED AND S.%s != 'D' THEN UPDATE SET %s
-	WHEN MATCHED AND S.%s = 'D' THEN DELETE
-	WHEN NOT MATCHED AND S.%s != 'D' THEN INSERT (%s) VALUES (%s);`,
-		fmt.Sprintf("`%s`", tableName),
+	WHEN MATCHED AND %s THEN UPDATE SET %s
+	WHEN MATCHED AND %s THEN %s
+	WHEN NOT MATCHED AND %s THEN INSERT (%s) VALUES (%s);`,
+		g.Table(ns, tableName),
+		matchedStat,
 		strings.Join(pkColumn, ", "),
-		utils.CDCCommitTsColumnName,
-		fmt.Sprintf("`%s`", externalTableName),
+		utils.LatestChangeOrder(utils.CDCCommitTsColumnName, utils.CDCFlagColumnName),
+		g.Table(ns, externalTableName),
 		strings.Join(onStat, " AND "),
-		utils.CDCFlagColumnName,
+		upsertCond,
 		strings.Join(updateStat, ", "),
-		utils.CDCFlagColumnName,
-		utils.CDCFlagColumnName,
+		deleteCond,
+		clauses.DeleteAction,
+		upsertCond,
 		strings.Join(insertStat, ", "),
 		strings.Join(valuesStat, ", "),
 	)
@@ -71,19 +161,71 @@ func GenMergeIntoSQL(tableDef cloudstorage.TableDefinition, tableName, externalT
 	return mergeSQL
 }
 
-func GenDropTableSQL(sourceTable string) string {
-	return fmt.Sprintf("DROP TABLE IF EXISTS %s", sourceTable)
+// castField returns the value of the column in the external table converted to the column of the table. TiCDC
+// writes BIT as an unsigned integer, which is read as a string: BIT(1) is converted to a boolean, and a longer BIT
+// to its bytes. The columns overridden by columnTypes are typed as the table. The columns of the Parquet files are
+// typed by the files and cast to the column of the table.
+func (g Generator) castField(col cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) string {
+	field := fmt.Sprintf("S.%s", g.QuoteIdent(col.Name))
+	if format == stagingformat.Parquet {
+		// an unsupported type fails the creation of the table before any merge
+		if tp, err := GetDatabricksTypeString(col, columnTypes); err == nil {
+			return fmt.Sprintf("cast(%s as %s)", field, tp)
+		}
+		return field
+	}
+	if _, ok := columnTypes.Lookup(col.Name); ok {
+		return field
+	}
+	switch length := tidbsql.BitLength(col); {
+	case length == 1:
+		return fmt.Sprintf("(%s = '1')", field)
+	case length > 1:
+		return fmt.Sprintf("unhex(lpad(conv(%s, 10, 16), %d, '0'))", field, 2*tidbsql.BitBytes(length))
+	default:
+		return field
+	}
+}
+
+// externalColumn returns the column as it is read by the external table, BIT is read as a string and converted
+// by castField since the external tables of CSV files do not read BINARY
+func externalColumn(column cloudstorage.TableCol, columnTypes columnmapping.Columns) cloudstorage.TableCol {
+	if _, ok := columnTypes.Lookup(column.Name); !ok && tidbsql.BitLength(column) > 0 {
+		column.Tp, column.Precision = "varchar", ""
+	}
+	return column
+}
+
+func (g Generator) GenDropTableSQL(ns Namespace, sourceTable string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s", g.Table(ns, sourceTable))
+}
+
+// DropTable drops the table of the namespace in Databricks if it exists
+func (g Generator) DropTable(db *sql.DB, ns Namespace, tableName string) error {
+	sql := g.GenDropTableSQL(ns, tableName)
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
 }
 
-func GenCreateTableSQL(tableName string, tableColumns []cloudstorage.TableCol) (string, error) {
+// GenCreateTableSQL generates the DDL of the table, comments are omitted if nil. The table is partitioned by the
+// partitioning columns of the layout, Delta tables need a column not partitioning the table. The tombstone columns
+// of the delete mode follow the columns, they have no default as the column defaults are a table feature of Delta.
+func (g Generator) GenCreateTableSQL(ns Namespace, tableName string, tableColumns []cloudstorage.TableCol, comments *tidbsql.TableComments, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) (string, error) {
+	if comments == nil {
+		comments = &tidbsql.TableComments{}
+	}
 	columnRows := make([]string, 0, len(tableColumns))
 	for _, column := range tableColumns {
-		row, err := GetDatabricksColumnString(column)
+		row, err := g.GetDatabricksColumnString(column, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
+		if comment, ok := comments.Columns[column.Name]; ok {
+			row += fmt.Sprintf(" COMMENT %s", genComment(comment, maxColumnCommentLength, column.Name))
+		}
 		columnRows = append(columnRows, row)
 	}
+	columnRows = append(columnRows, deleteMode.ColumnDefs(g.QuoteIdent, "BOOLEAN", "TIMESTAMP")...)
 
 	// TODO: Support unique key
 
@@ -94,18 +236,48 @@ func GenCreateTableSQL(tableName string, tableColumns []cloudstorage.TableCol) (
 		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
 	}
 
+	partitionColumns, err := tablelayout.Lookup(layout.PartitionBy, tableColumns)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(partitionColumns) > 0 && len(partitionColumns) >= len(tableColumns) {
+		return "", errors.Errorf("Table %s can not be partitioned by all its columns", tableName)
+	}
+
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, tableName)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, g.Table(ns, tableName)))
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
 	sql = append(sql, ")")
+	if len(partitionColumns) > 0 {
+		quotedPartitionColumns := make([]string, 0, len(partitionColumns))
+		for _, column := range partitionColumns {
+			quotedPartitionColumns = append(quotedPartitionColumns, g.QuoteIdent(column.Name))
+		}
+		sql = append(sql, fmt.Sprintf("PARTITIONED BY (%s)", strings.Join(quotedPartitionColumns, ", ")))
+	}
+	if comments.Table != "" {
+		sql = append(sql, fmt.Sprintf("COMMENT %s", genComment(comments.Table, maxTableCommentLength, tableName)))
+	}
+	sql = append(sql, fmt.Sprintf("TBLPROPERTIES (%s)", columnMappingProperties))
 
 	return strings.Join(sql, "\n"), nil
 }
 
-func GenCreateExternalTableSQL(tableName string, tableColumns []cloudstorage.TableCol, storageUri string, credential string) (string, error) {
+// GenCreateExternalTableSQL creates the external table of the increment file, CSV or Parquet by its extension. The
+// columns of a Parquet file are read as they are typed in the file, and converted by castField.
+func (g Generator) GenCreateExternalTableSQL(ns Namespace, tableName string, tableColumns []cloudstorage.TableCol, storageUri string, credential string, columnTypes columnmapping.Columns) (string, error) {
+	if stagingformat.FileFormat(storageUri) == stagingformat.Parquet {
+		return fmt.Sprintf(`CREATE EXTERNAL TABLE %s
+	USING PARQUET
+		LOCATION '%s' WITH (
+	    CREDENTIAL %s
+	)`,
+			g.Table(ns, tableName), storageUri, g.QuoteIdent(credential),
+		), nil
+	}
 	columnRows := make([]string, 0, len(tableColumns))
 	for _, column := range tableColumns {
-		row, err := GetDatabricksColumnString(column)
+		row, err := g.GetDatabricksColumnString(externalColumn(column, columnTypes), columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
@@ -118,23 +290,30 @@ func GenCreateExternalTableSQL(tableName string, tableColumns []cloudstorage.Tab
 		LOCATION '%s' WITH (
 	    CREDENTIAL %s
 	)`,
-		tableName, strings.Join(columnRows, ",\n"), storageUri, fmt.Sprintf("`%s`", credential),
+		g.Table(ns, tableName), strings.Join(columnRows, ",\n"), storageUri, g.QuoteIdent(credential),
 	), nil
 }
 
-func LoadCSVFromS3(db *sql.DB, columns []cloudstorage.TableCol, targetTable, storageUri, filePrefix string, credential string) error {
-	columnCastAndRenameSQL, err := buildColumnCastAndRename(columns)
+// maxFilesPerCopy is the max number of files listed by the FILES option of COPY INTO
+const maxFilesPerCopy = 1000
+
+// LoadCSVFromS3 loads the CSV files under storageUri in the format, at most maxFilesPerCopy files can be loaded at
+// once. Databricks detects the codec of compressed files by the file extension. The columns of the table are never
+// evolved by the files. If badRecordsPath is not empty, the malformed rows are written there instead of failing the
+// load. It returns the rows inserted as reported by COPY INTO, reported is false if COPY INTO reports nothing. The
+// rows loaded in the soft delete mode are not deleted.
+func (g Generator) LoadCSVFromS3(db *sql.DB, ns Namespace, columns []cloudstorage.TableCol, targetTable, storageUri string, files []string, credential string, columnTypes columnmapping.Columns, format CSVFormat, badRecordsPath string, deleteMode deletemode.Mode) (inserted int64, reported bool, err error) {
+	columnCastAndRenameSQL, err := g.buildColumnCastAndRename(columns, columnTypes, deleteMode, stagingformat.CSV)
 	if err != nil {
-		return errors.Trace(err)
+		return 0, false, errors.Trace(err)
 	}
 
-	patternSQL := `PATTERN = '*{filePrefix}*.csv'`
-	if filePrefix == "" {
-		// Without prefix, we need to specify the file name
-		patternSQL = ""
+	quotedFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		quotedFiles = append(quotedFiles, utils.QuoteLiteral(file))
 	}
 
-	copyIntoSQL := fmt.Sprintf(`
+	sql, err := formatter.Format(`
 	COPY INTO {targetTable}
 	FROM (
 		SELECT {castAndRenameColumns}
@@ -143,24 +322,124 @@ func LoadCSVFromS3(db *sql.DB, columns []cloudstorage.TableCol, targetTable, sto
 		)
 	)
 	FILEFORMAT = CSV
-	%s
-	FORMAT_OPTIONS ('delimiter' = ',', 'inferSchema' = 'true')
-	COPY_OPTIONS ('mergeSchema' = 'true');
-	`, patternSQL)
-
-	sql, err := formatter.Format(copyIntoSQL, formatter.Named{
-		"targetTable":          utils.EscapeString(targetTable),
+	FILES = ({files})
+	FORMAT_OPTIONS ({formatOptions})
+	COPY_OPTIONS ('mergeSchema' = 'false');
+	`, formatter.Named{
+		"targetTable":          g.Table(ns, targetTable),
 		"castAndRenameColumns": columnCastAndRenameSQL,
 		"storageUrl":           utils.EscapeString(storageUri),
-		"filePrefix":           utils.EscapeString(filePrefix), // glob pattern // TODO: Verify
-		"credential":           fmt.Sprintf("`%s`", credential),
+		"files":                strings.Join(quotedFiles, ", "),
+		"credential":           g.QuoteIdent(credential),
+		"formatOptions":        format.formatOptions(badRecordsPath),
 	})
 	if err != nil {
-		return errors.Trace(err)
+		return 0, false, errors.Trace(err)
 	}
-	log.Info("Loading CSV data from AWS s3", zap.String("query", sql))
-	_, err = db.Exec(sql)
-	return err
+	log.Info("Loading CSV data from storage", zap.String("query", sql))
+	rows, err := db.Query(sql)
+	if err != nil {
+		return 0, false, diag.WrapSQL(err, sql)
+	}
+	defer rows.Close()
+	inserted, reported, err = scanCopyResult(rows)
+	return inserted, reported, diag.WrapSQL(err, sql)
+}
+
+// LoadParquetFromS3 loads the Parquet files under storageUri like LoadCSVFromS3, the columns are read by their names.
+// If badRecordsPath is not empty, the files failing to be read are written there instead of failing the load.
+func (g Generator) LoadParquetFromS3(db *sql.DB, ns Namespace, columns []cloudstorage.TableCol, targetTable, storageUri string, files []string, credential string, columnTypes columnmapping.Columns, badRecordsPath string, deleteMode deletemode.Mode) (inserted int64, reported bool, err error) {
+	columnCastSQL, err := g.buildColumnCastAndRename(columns, columnTypes, deleteMode, stagingformat.Parquet)
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+
+	quotedFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		quotedFiles = append(quotedFiles, utils.QuoteLiteral(file))
+	}
+	formatOptions := ""
+	if badRecordsPath != "" {
+		formatOptions = fmt.Sprintf("FORMAT_OPTIONS ('badRecordsPath' = %s)", utils.QuoteLiteral(badRecordsPath))
+	}
+
+	sql, err := formatter.Format(`
+	COPY INTO {targetTable}
+	FROM (
+		SELECT {castColumns}
+		FROM '{storageUrl}' WITH (
+		  CREDENTIAL {credential}
+		)
+	)
+	FILEFORMAT = PARQUET
+	FILES = ({files})
+	{formatOptions}
+	COPY_OPTIONS ('mergeSchema' = 'false');
+	`, formatter.Named{
+		"targetTable":   g.Table(ns, targetTable),
+		"castColumns":   columnCastSQL,
+		"storageUrl":    utils.EscapeString(storageUri),
+		"files":         strings.Join(quotedFiles, ", "),
+		"credential":    g.QuoteIdent(credential),
+		"formatOptions": formatOptions,
+	})
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	log.Info("Loading Parquet data from storage", zap.String("query", sql))
+	rows, err := db.Query(sql)
+	if err != nil {
+		return 0, false, diag.WrapSQL(err, sql)
+	}
+	defer rows.Close()
+	inserted, reported, err = scanCopyResult(rows)
+	return inserted, reported, diag.WrapSQL(err, sql)
+}
+
+// scanCopyResult returns num_inserted_rows of the result of COPY INTO, reported is false if there is no such column
+func scanCopyResult(rows *sql.Rows) (inserted int64, reported bool, err error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	idx := slices.Index(columns, "num_inserted_rows")
+	if idx < 0 || !rows.Next() {
+		return 0, false, errors.Trace(rows.Err())
+	}
+	values := make([]any, len(columns))
+	for i := range values {
+		values[i] = new(any)
+	}
+	values[idx] = &inserted
+	if err = rows.Scan(values...); err != nil {
+		return 0, false, errors.Trace(err)
+	}
+	return inserted, true, errors.Trace(rows.Err())
+}
+
+// quarantineTableSuffix names the table of the malformed rows of a table loaded with --permissive-load
+const quarantineTableSuffix = "_quarantine"
+
+// GenCreateQuarantineTableSQL creates the table of the malformed rows, with the columns of the bad records written
+// by Databricks: the file, the raw row and the reason it is malformed
+func (g Generator) GenCreateQuarantineTableSQL(ns Namespace, tableName string) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (path STRING, record STRING, reason STRING)", g.Table(ns, tableName+quarantineTableSuffix))
+}
+
+// GenLoadQuarantineSQL loads the bad records under badRecordsPath into the table of the malformed rows, the records
+// loaded before are skipped by COPY INTO
+func (g Generator) GenLoadQuarantineSQL(ns Namespace, tableName, badRecordsPath, credential string) string {
+	return fmt.Sprintf(`COPY INTO %s
+	FROM (
+		SELECT path, record, reason
+		FROM %s WITH (
+		  CREDENTIAL %s
+		)
+	)
+	FILEFORMAT = JSON
+	FORMAT_OPTIONS ('recursiveFileLookup' = 'true')
+	COPY_OPTIONS ('mergeSchema' = 'false')`,
+		g.Table(ns, tableName+quarantineTableSuffix), utils.QuoteLiteral(badRecordsPath), g.QuoteIdent(credential))
 }
 
 // GetCredentialNameSet returns all storage credential names in the database
@@ -187,14 +466,28 @@ func GetCredentialNameSet(db *sql.DB) (map[string]interface{}, error) {
 // buildColumnCastAndRename spark will generate field names as _c0, _c1, _c2, etc. for CSV files without header.
 // Tested 512 columns, the pattern is _c{index} where index starts from 0
 // refer to: https://stackoverflow.com/questions/75459116/databricks-sql-api-load-csv-file-without-header
-func buildColumnCastAndRename(columns []cloudstorage.TableCol) (string, error) {
+// A BIT longer than 1 is dumped as the hex of its bytes, which is not cast to BINARY but decoded. The fields of
+// the Parquet files are named by the columns and have the bytes of BIT. The tombstone columns of the soft delete
+// mode are not in the files.
+func (g Generator) buildColumnCastAndRename(columns []cloudstorage.TableCol, columnTypes columnmapping.Columns, deleteMode deletemode.Mode, format stagingformat.Format) (string, error) {
 	wholeCastPartSQL := make([]string, 0, len(columns))
 	for index, column := range columns {
-		castType, err := GetDatabricksTypeString(column)
+		castType, err := GetDatabricksTypeString(column, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
-		wholeCastPartSQL = append(wholeCastPartSQL, fmt.Sprintf("cast(_c%d as %s) as %s", index, castType, column.Name))
+		field := fmt.Sprintf("_c%d", index)
+		if format == stagingformat.Parquet {
+			field = g.QuoteIdent(column.Name)
+		} else if _, ok := columnTypes.Lookup(column.Name); !ok && tidbsql.BitLength(column) > 1 {
+			wholeCastPartSQL = append(wholeCastPartSQL, fmt.Sprintf("unhex(%s) as %s", field, g.QuoteIdent(column.Name)))
+			continue
+		}
+		wholeCastPartSQL = append(wholeCastPartSQL, fmt.Sprintf("cast(%s as %s) as %s", field, castType, g.QuoteIdent(column.Name)))
+	}
+	if deleteMode == deletemode.Soft {
+		wholeCastPartSQL = append(wholeCastPartSQL, fmt.Sprintf("false as %s", g.QuoteIdent(deletemode.DeletedColumn)),
+			fmt.Sprintf("cast(null as timestamp) as %s", g.QuoteIdent(deletemode.DeletedAtColumn)))
 	}
 
 	return strings.Join(wholeCastPartSQL, ", "), nil
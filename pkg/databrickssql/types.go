@@ -2,6 +2,8 @@ package databrickssql
 
 import (
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"strings"
@@ -37,12 +39,46 @@ var TiDB2DatabricksTypeMap = map[string]string{
 	"datetime":   "TIMESTAMP_NTZ",
 	"timestamp":  "TIMESTAMP",
 	"time":       "TIMESTAMP_NTZ",
+	"enum":       "STRING",
+	"set":        "STRING",
 }
 
-func GetDatabricksTypeString(column cloudstorage.TableCol) (string, error) {
+// databricksMaxDecimalPrecision is the max precision of DECIMAL
+const databricksMaxDecimalPrecision = 38
+
+// tiDB2DatabricksUnsignedTypeMap widens the unsigned integer types, Databricks has no unsigned types
+var tiDB2DatabricksUnsignedTypeMap = map[string]string{
+	"tinyint":   "SMALLINT",
+	"smallint":  "INT",
+	"mediumint": "INT",
+	"int":       "BIGINT",
+	"bigint":    "DECIMAL(20, 0)",
+}
+
+// GetDatabricksTypeString returns the Databricks type of the column, the type given by columnTypes takes
+// precedence over the default mapping
+func GetDatabricksTypeString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	if tp, ok := columnTypes.Lookup(column.Name); ok {
+		return tp, nil
+	}
 	tp := strings.ToLower(column.Tp)
+	if baseTp, ok := strings.CutSuffix(tp, " unsigned"); ok {
+		if databricksTp, ok := tiDB2DatabricksUnsignedTypeMap[baseTp]; ok {
+			return databricksTp, nil
+		}
+		tp = baseTp
+	}
 	switch tp {
+	case "bit":
+		// BIT(1) is loaded from 0 or 1, a longer BIT from the hex of its bytes
+		if tidbsql.BitLength(column) > 1 {
+			return "BINARY", nil
+		}
+		return "BOOLEAN", nil
 	case "decimal", "numeric":
+		if err := tidbsql.CheckDecimalPrecision(column, databricksMaxDecimalPrecision, "Databricks"); err != nil {
+			return "", errors.Trace(err)
+		}
 		return fmt.Sprintf("%s(%s, %s)", TiDB2DatabricksTypeMap[tp], column.Precision, column.Scale), nil
 	default:
 		if databricksTp, exist := TiDB2DatabricksTypeMap[tp]; exist {
@@ -0,0 +1,95 @@
+package deletemode
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+)
+
+// Mode is how a row deleted in TiDB is deleted in the data warehouse, it is the --delete-mode flag. The zero Mode
+// is Hard.
+type Mode string
+
+const (
+	// Hard deletes the row from the table, which is the default
+	Hard Mode = "hard"
+	// Soft keeps the row in the table with a tombstone: DeletedColumn is set to true and DeletedAtColumn to the
+	// time the delete is merged. A row inserted again resets the tombstone.
+	Soft Mode = "soft"
+)
+
+const (
+	// DeletedColumn is the tombstone column of Soft telling whether the row is deleted in TiDB
+	DeletedColumn = "_tidb_deleted"
+	// DeletedAtColumn is the tombstone column of Soft of the time the delete is merged, NULL if not deleted
+	DeletedAtColumn = "_tidb_deleted_at"
+)
+
+// Parse parses the value of --delete-mode, case-insensitive
+func Parse(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(s)); mode {
+	case Hard, Soft:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown delete mode %s, expected one of hard, soft", s)
+	}
+}
+
+// IsTombstoneColumn tells whether the column is a tombstone column of Soft, the name is case-insensitive since
+// Snowflake stores it in upper case
+func IsTombstoneColumn(name string) bool {
+	return strings.EqualFold(name, DeletedColumn) || strings.EqualFold(name, DeletedAtColumn)
+}
+
+// ColumnDefs returns the definitions of the tombstone columns appended to the table created in the mode, none in
+// Hard. The types are of the data warehouse, e.g. BOOLEAN DEFAULT FALSE and TIMESTAMP.
+func (m Mode) ColumnDefs(quote func(string) string, deletedType, deletedAtType string) []string {
+	if m != Soft {
+		return nil
+	}
+	return []string{quote(DeletedColumn) + " " + deletedType, quote(DeletedAtColumn) + " " + deletedAtType}
+}
+
+// MergeClauses are the parts of the MERGE statement of the increment changed by the mode
+type MergeClauses struct {
+	// DeleteAction is the action of WHEN MATCHED of the rows deleted
+	DeleteAction string
+	// UpdateSets are the assignments appended to the rows updated, which reset the tombstone
+	UpdateSets []string
+	// InsertColumns and InsertValues are appended to the rows inserted
+	InsertColumns []string
+	InsertValues  []string
+}
+
+// MergeClauses returns the clauses of the MERGE statement in the mode, now is the current time in the SQL of the
+// data warehouse, e.g. CURRENT_TIMESTAMP()
+func (m Mode) MergeClauses(quote func(string) string, now string) MergeClauses {
+	if m != Soft {
+		return MergeClauses{DeleteAction: "DELETE"}
+	}
+	deleted, deletedAt := quote(DeletedColumn), quote(DeletedAtColumn)
+	return MergeClauses{
+		DeleteAction:  fmt.Sprintf("UPDATE SET %s = TRUE, %s = %s", deleted, deletedAt, now),
+		UpdateSets:    []string{deleted + " = FALSE", deletedAt + " = NULL"},
+		InsertColumns: []string{deleted, deletedAt},
+		InsertValues:  []string{"FALSE", "NULL"},
+	}
+}
+
+// CheckTable fails if the table in the data warehouse is created in another mode, hasTombstone tells whether the
+// table has DeletedColumn. A table not created yet is created in the mode.
+func (m Mode) CheckTable(table string, hasTombstone bool) error {
+	switch {
+	case m == Soft && !hasTombstone:
+		return diag.Schema(errors.Errorf("Table %s in the data warehouse has no %s column, it is replicated with --delete-mode=hard. "+
+			"Keep --delete-mode=hard, or add the columns %s and %s to the table, or recreate it, e.g. by --clean-workspace",
+			table, DeletedColumn, DeletedColumn, DeletedAtColumn))
+	case m != Soft && hasTombstone:
+		return diag.Schema(errors.Errorf("Table %s in the data warehouse has the %s column, it is replicated with --delete-mode=soft. "+
+			"Keep --delete-mode=soft, or drop the columns %s and %s from the table, or recreate it, e.g. by --clean-workspace",
+			table, DeletedColumn, DeletedColumn, DeletedAtColumn))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package deletemode_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	mode, err := deletemode.Parse("Soft")
+	require.NoError(t, err)
+	require.Equal(t, deletemode.Soft, mode)
+	mode, err = deletemode.Parse("hard")
+	require.NoError(t, err)
+	require.Equal(t, deletemode.Hard, mode)
+	_, err = deletemode.Parse("archive")
+	require.ErrorContains(t, err, "unknown delete mode archive")
+}
+
+func TestMergeClauses(t *testing.T) {
+	// the zero Mode deletes the rows
+	require.Equal(t, deletemode.MergeClauses{DeleteAction: "DELETE"}, deletemode.Mode("").MergeClauses(strconv.Quote, "NOW()"))
+	require.Nil(t, deletemode.Hard.ColumnDefs(strconv.Quote, "BOOLEAN", "TIMESTAMP"))
+
+	require.Equal(t, deletemode.MergeClauses{
+		DeleteAction:  `UPDATE SET "_tidb_deleted" = TRUE, "_tidb_deleted_at" = NOW()`,
+		UpdateSets:    []string{`"_tidb_deleted" = FALSE`, `"_tidb_deleted_at" = NULL`},
+		InsertColumns: []string{`"_tidb_deleted"`, `"_tidb_deleted_at"`},
+		InsertValues:  []string{"FALSE", "NULL"},
+	}, deletemode.Soft.MergeClauses(strconv.Quote, "NOW()"))
+	require.Equal(t, []string{`"_tidb_deleted" BOOLEAN`, `"_tidb_deleted_at" TIMESTAMP`}, deletemode.Soft.ColumnDefs(strconv.Quote, "BOOLEAN", "TIMESTAMP"))
+}
+
+func TestCheckTable(t *testing.T) {
+	require.NoError(t, deletemode.Hard.CheckTable("t", false))
+	require.NoError(t, deletemode.Soft.CheckTable("t", true))
+	err := deletemode.Soft.CheckTable("t", false)
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(err))
+	require.ErrorContains(t, err, "Table t in the data warehouse has no _tidb_deleted column")
+	err = deletemode.Mode("").CheckTable("t", true)
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(err))
+	require.ErrorContains(t, err, "drop the columns _tidb_deleted and _tidb_deleted_at")
+
+	require.True(t, deletemode.IsTombstoneColumn("_TIDB_DELETED_AT"))
+	require.False(t, deletemode.IsTombstoneColumn("deleted"))
+}
@@ -0,0 +1,166 @@
+package diag
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// MaxListedFiles is the max number of storage files listed in a bundle
+const MaxListedFiles = 5000
+
+// secretPattern matches the credentials embedded in statements and storage URIs, e.g.
+// `aws_secret_access_key=...` of Redshift COPY, `AWS_SECRET_KEY = '...'` of Snowflake stages,
+// `secret-access-key=...` of the storage query string and `X-Amz-Signature=...` of presigned URLs.
+var secretPattern = regexp.MustCompile(`(?i)\b((?:aws_access_key_id|aws_secret_access_key|aws_session_token|aws_key_id|aws_secret_key|aws_access_key|aws_token|azure_sas_token|secret-access-key|access-key|session-token|account-key|sas-token|x-amz-credential|x-amz-signature|password|token)\s*=\s*'?)([^'"&;,)\s]+)`)
+
+// secretFieldPattern matches the credentials of a struct dumped, e.g. `"SecretAccessKey":"..."` of JSON or
+// `SecretAccessKey:...` of %+v of an aws credentials.Value
+var secretFieldPattern = regexp.MustCompile(`(?i)\b((?:AccessKeyID|SecretAccessKey|SessionToken|secret-access-key|session-token)"?\s*:(?:\s*")?)([^"'&;,)}\s]+)`)
+
+// accessKeyIDPattern matches an AWS access key ID anywhere, of long-lived keys by AKIA and temporary ones by ASIA
+var accessKeyIDPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)
+
+// RedactSecrets masks the credentials in a statement, an error message or a log line
+func RedactSecrets(s string) string {
+	s = secretPattern.ReplaceAllString(s, "${1}xxxxx")
+	s = secretFieldPattern.ReplaceAllString(s, "${1}xxxxx")
+	return accessKeyIDPattern.ReplaceAllString(s, "xxxxx")
+}
+
+// IsSecretFlag tells whether the flag carries a secret, e.g. --tidb.pass, --aws.secret-key, --tidbcloud.private-key
+// and --notify-webhook-url, whose path is the credential of the webhooks like Slack's
+func IsSecretFlag(name string) bool {
+	name = strings.ToLower(strings.TrimLeft(name, "-"))
+	for _, keyword := range []string{"pass", "secret", "token", "access-key", "account-key", "webhook"} {
+		if strings.Contains(name, keyword) {
+			return true
+		}
+	}
+	// the path of a private key, e.g. --snowflake.private-key-path, is not secret
+	return strings.HasSuffix(name, "private-key")
+}
+
+// FileInfo is a file in the storage
+type FileInfo struct {
+	Path string
+	Size int64
+}
+
+// ListStorageFiles lists at most limit files of the storage, truncated is set if there are more
+func ListStorageFiles(ctx context.Context, extStorage storage.ExternalStorage, limit int) (files []FileInfo, truncated bool, err error) {
+	errStop := errors.New("stop walking")
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, size int64) error {
+		if len(files) >= limit {
+			truncated = true
+			return errStop
+		}
+		files = append(files, FileInfo{Path: path, Size: size})
+		return nil
+	})
+	if err != nil && err != errStop {
+		return files, truncated, errors.Trace(err)
+	}
+	return files, truncated, nil
+}
+
+// Bundle is the diagnostics of a failed run, which is written as a tar.gz archive
+type Bundle struct {
+	Err     error
+	Version string
+	// Config and Status are written as JSON, the secrets of Config must be removed by the caller while the
+	// secrets in the strings of Status are redacted
+	Config any
+	Status any
+	Logs   []string
+	// Files is the listing of the storage, FilesErr is why the storage is not listed
+	Files          []FileInfo
+	FilesTruncated bool
+	FilesErr       error
+	CreatedAt      time.Time
+}
+
+type bundleError struct {
+	Category   Category `json:"category"`
+	ExitCode   int      `json:"exit_code"`
+	Message    string   `json:"message"`
+	FailingSQL string   `json:"failing_sql,omitempty"`
+}
+
+// Name returns the file name of the bundle
+func (b *Bundle) Name() string {
+	return fmt.Sprintf("tidb2dw-diag-%s.tar.gz", b.CreatedAt.UTC().Format("20060102T150405Z"))
+}
+
+// Write writes the bundle as a tar.gz archive, the secrets in the error, the SQL, the logs and the status are
+// redacted, e.g. of the statements of the errors reported by the status
+func (b *Bundle) Write(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	category := CategoryOf(b.Err)
+	errorInfo := bundleError{
+		Category:   category,
+		ExitCode:   category.ExitCode(),
+		Message:    RedactSecrets(b.Err.Error()),
+		FailingSQL: RedactSecrets(FailingSQL(b.Err)),
+	}
+	entries := []struct {
+		name    string
+		content func() ([]byte, error)
+	}{
+		{"error.json", func() ([]byte, error) { return json.MarshalIndent(errorInfo, "", "  ") }},
+		{"failing.sql", func() ([]byte, error) { return []byte(errorInfo.FailingSQL), nil }},
+		{"tidb2dw.log", func() ([]byte, error) { return []byte(RedactSecrets(strings.Join(b.Logs, "\n"))), nil }},
+		{"status.json", func() ([]byte, error) {
+			status, err := json.MarshalIndent(b.Status, "", "  ")
+			return []byte(RedactSecrets(string(status))), err
+		}},
+		{"config.json", func() ([]byte, error) { return json.MarshalIndent(b.Config, "", "  ") }},
+		{"version.txt", func() ([]byte, error) { return []byte(b.Version), nil }},
+		{"storage_files.txt", func() ([]byte, error) { return b.storageListing(), nil }},
+	}
+	for _, entry := range entries {
+		content, err := entry.content()
+		if err != nil {
+			return errors.Annotatef(err, "Failed to generate %s", entry.name)
+		}
+		if entry.name == "failing.sql" && len(content) == 0 {
+			continue
+		}
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(content)), ModTime: b.CreatedAt}
+		if err = tw.WriteHeader(header); err != nil {
+			return errors.Trace(err)
+		}
+		if _, err = tw.Write(content); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(gw.Close())
+}
+
+func (b *Bundle) storageListing() []byte {
+	var buf bytes.Buffer
+	if b.FilesErr != nil {
+		fmt.Fprintf(&buf, "# failed to list the storage: %s\n", RedactSecrets(b.FilesErr.Error()))
+	}
+	for _, file := range b.Files {
+		fmt.Fprintf(&buf, "%d\t%s\n", file.Size, file.Path)
+	}
+	if b.FilesTruncated {
+		fmt.Fprintf(&buf, "# truncated after %d files\n", len(b.Files))
+	}
+	return buf.Bytes()
+}
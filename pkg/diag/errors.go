@@ -0,0 +1,120 @@
+package diag
+
+import (
+	stderrors "errors"
+)
+
+// Category tells which part of the pipeline an error comes from
+type Category string
+
+const (
+	// CategorySource is an error of TiDB, e.g. querying the table schema or dumping the snapshot
+	CategorySource Category = "SourceError"
+	// CategoryStorage is an error of the workspace storage, e.g. S3 or GCS
+	CategoryStorage Category = "StorageError"
+	// CategoryCDC is an error of the TiCDC server, e.g. creating the changefeed
+	CategoryCDC Category = "CDCError"
+	// CategoryWarehouse is an error of the data warehouse, e.g. a failed COPY or MERGE
+	CategoryWarehouse Category = "WarehouseError"
+	// CategorySchema is an error of the schema files or DDLs, e.g. an unknown schema format
+	CategorySchema Category = "SchemaError"
+	// CategoryInternal is any error not categorized at a boundary of the pipeline
+	CategoryInternal Category = "InternalError"
+)
+
+// exitCodes are the exit codes of the process failed by an error of each category
+var exitCodes = map[Category]int{
+	CategoryInternal:  1,
+	CategorySource:    10,
+	CategoryStorage:   11,
+	CategoryCDC:       12,
+	CategoryWarehouse: 13,
+	CategorySchema:    14,
+}
+
+// ExitCode returns the exit code of the process failed by an error of the category
+func (c Category) ExitCode() int {
+	if code, ok := exitCodes[c]; ok {
+		return code
+	}
+	return exitCodes[CategoryInternal]
+}
+
+// Error is an error categorized where it enters the pipeline
+type Error struct {
+	Category Category
+	// SQL is the failing statement, empty if the error is not returned by a statement
+	SQL   string
+	cause error
+}
+
+func (e *Error) Error() string {
+	return e.cause.Error()
+}
+
+// Cause makes the error transparent to errors.Cause of pingcap/errors
+func (e *Error) Cause() error {
+	return e.cause
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Wrap categorizes the error, nil is returned for nil. An error categorized by an inner
+// boundary keeps its category, e.g. a storage error returned by a data warehouse connector.
+func Wrap(err error, category Category) error {
+	if err == nil {
+		return nil
+	}
+	var categorized *Error
+	if stderrors.As(err, &categorized) {
+		return err
+	}
+	return &Error{Category: category, cause: err}
+}
+
+// WrapSQL categorizes the error returned by the statement as a warehouse error and records the statement
+func WrapSQL(err error, sql string) error {
+	if err == nil {
+		return nil
+	}
+	var categorized *Error
+	if stderrors.As(err, &categorized) {
+		return err
+	}
+	return &Error{Category: CategoryWarehouse, SQL: sql, cause: err}
+}
+
+// Source categorizes the error as a SourceError
+func Source(err error) error { return Wrap(err, CategorySource) }
+
+// Storage categorizes the error as a StorageError
+func Storage(err error) error { return Wrap(err, CategoryStorage) }
+
+// CDC categorizes the error as a CDCError
+func CDC(err error) error { return Wrap(err, CategoryCDC) }
+
+// Warehouse categorizes the error as a WarehouseError
+func Warehouse(err error) error { return Wrap(err, CategoryWarehouse) }
+
+// Schema categorizes the error as a SchemaError
+func Schema(err error) error { return Wrap(err, CategorySchema) }
+
+// CategoryOf returns the category of the error, CategoryInternal if it is not categorized
+func CategoryOf(err error) Category {
+	var categorized *Error
+	if stderrors.As(err, &categorized) {
+		return categorized.Category
+	}
+	return CategoryInternal
+}
+
+// FailingSQL returns the statement which failed with the error, empty if unknown
+func FailingSQL(err error) string {
+	var categorized *Error
+	if stderrors.As(err, &categorized) {
+		return categorized.SQL
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package diag
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogBuffer keeps the last lines of the log for the diagnostics bundle
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	// next is the position of the next line once the buffer is full
+	next int
+	size int
+}
+
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{lines: make([]string, 0, size), size: size}
+}
+
+// Write implements zapcore.WriteSyncer, an entry may contain several lines, e.g. a stack
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(b.lines) < b.size {
+			b.lines = append(b.lines, line)
+			continue
+		}
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % b.size
+	}
+	return len(p), nil
+}
+
+func (b *LogBuffer) Sync() error {
+	return nil
+}
+
+// Lines returns the lines kept in the buffer, the oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
+
+// CaptureLogs copies the entries of the global logger into the buffer, in the same format as the log file.
+// It must be called after the global logger is initialized.
+func CaptureLogs(b *LogBuffer) error {
+	encoder, err := log.NewTextEncoder(&log.Config{})
+	if err != nil {
+		return err
+	}
+	level := zap.NewAtomicLevelAt(log.GetLevel())
+	capture := zapcore.NewCore(encoder, b, level)
+	logger := log.L().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, capture)
+	}))
+	log.ReplaceGlobals(logger, &log.ZapProperties{
+		Core:   logger.Core(),
+		Syncer: b,
+		Level:  level,
+	})
+	return nil
+}
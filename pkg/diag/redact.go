@@ -0,0 +1,88 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RedactLogs masks the secrets in the entries of the global logger by RedactSecrets, e.g. of a statement logged
+// or a struct dumped with an error. The message and the fields of strings, errors, stringers and values are
+// redacted, a field with no secret is logged as is. It must be called after the global logger is initialized.
+func RedactLogs() {
+	logger := log.L().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &redactingCore{Core: core}
+	}))
+	log.ReplaceGlobals(logger, &log.ZapProperties{
+		Core:  logger.Core(),
+		Level: zap.NewAtomicLevelAt(log.GetLevel()),
+	})
+}
+
+// redactingCore redacts the entries before they are written by the core wrapped
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = RedactSecrets(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns the fields with their secrets masked, a field with a secret is replaced by a string field
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	var redacted []zapcore.Field
+	for i, field := range fields {
+		text, ok := fieldText(field)
+		if !ok {
+			continue
+		}
+		masked := RedactSecrets(text)
+		if masked == text {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]zapcore.Field(nil), fields...)
+		}
+		redacted[i] = zap.String(field.Key, masked)
+	}
+	if redacted == nil {
+		return fields
+	}
+	return redacted
+}
+
+// fieldText returns the text of the field as it is logged, false if the field cannot carry a secret
+func fieldText(field zapcore.Field) (string, bool) {
+	switch field.Type {
+	case zapcore.StringType:
+		return field.String, true
+	case zapcore.ErrorType:
+		if err, ok := field.Interface.(error); ok && err != nil {
+			return err.Error(), true
+		}
+	case zapcore.StringerType:
+		if stringer, ok := field.Interface.(fmt.Stringer); ok && stringer != nil {
+			return stringer.String(), true
+		}
+	case zapcore.ReflectType:
+		if encoded, err := json.Marshal(field.Interface); err == nil {
+			return string(encoded), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,293 @@
+package dryrun
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+)
+
+// Recorder writes the statements which would be executed in the data warehouse instead of executing them.
+// The statements of each connection are labeled, e.g. by the table, and the credentials are masked.
+type Recorder struct {
+	mu     sync.Mutex
+	out    io.Writer
+	file   *os.File
+	stubs  map[string]stub
+	label  string
+	broken error
+}
+
+type stub struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// NewRecorder returns a recorder writing to the file, or to stdout if path is empty
+func NewRecorder(path string) (*Recorder, error) {
+	r := &Recorder{out: os.Stdout, stubs: make(map[string]stub)}
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to create dry run output")
+		}
+		r.out, r.file = file, file
+	}
+	return r, nil
+}
+
+// StubQuery answers the query with the rows, the queries not stubbed have no rows. It is for the queries
+// whose results are required to render the following statements, e.g. the existing storage credentials.
+func (r *Recorder) StubQuery(query string, columns []string, rows ...[]driver.Value) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stubs[strings.TrimSpace(query)] = stub{columns: columns, rows: rows}
+}
+
+// Record writes the statement under the label, the arguments of the placeholders follow as a comment
+func (r *Recorder) Record(label, query string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sb strings.Builder
+	query = strings.TrimSpace(query)
+	sb.WriteString(query)
+	if !strings.HasSuffix(query, ";") {
+		sb.WriteString(";")
+	}
+	if len(args) > 0 {
+		fmt.Fprintf(&sb, " -- args: %v", args)
+	}
+	sb.WriteString("\n")
+	r.write(label, sb.String())
+}
+
+// Comment writes the lines as comments under the label, e.g. the routing table of the tables
+func (r *Recorder) Comment(label string, lines ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sb strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&sb, "-- %s\n", line)
+	}
+	r.write(label, sb.String())
+}
+
+// write writes the text under the label, the label is written once for the text following the same label
+func (r *Recorder) write(label, text string) {
+	if label != r.label {
+		text = fmt.Sprintf("\n-- %s\n%s", label, text)
+		r.label = label
+	}
+	if _, err := io.WriteString(r.out, diag.RedactSecrets(text)); err != nil && r.broken == nil {
+		r.broken = errors.Annotate(err, "Failed to write dry run output")
+	}
+}
+
+// Close closes the output file, the first error of writing it is returned
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file != nil {
+		if err := r.file.Close(); err != nil && r.broken == nil {
+			r.broken = errors.Trace(err)
+		}
+		r.file = nil
+	}
+	return r.broken
+}
+
+// OpenDB returns a database recording the statements under the label. Exec always succeeds without
+// affecting any row, and a query returns the stubbed rows.
+func (r *Recorder) OpenDB(label string) *sql.DB {
+	return sql.OpenDB(&connector{recorder: r, label: label})
+}
+
+// OpenReadDB returns a database recording the statements under the label as OpenDB does, except that the queries
+// are run on db and not recorded, so that the statements rendered depend on the tables in the data warehouse
+func (r *Recorder) OpenReadDB(label string, db *sql.DB) *sql.DB {
+	return sql.OpenDB(&connector{recorder: r, label: label, source: db})
+}
+
+// readRows runs the query on db, the rows are read at once
+func readRows(ctx context.Context, db *sql.DB, query string, args []driver.NamedValue) (driver.Rows, error) {
+	sqlRows, err := db.QueryContext(ctx, query, namedValues(args)...)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer sqlRows.Close()
+	columns, err := sqlRows.Columns()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result := &rows{columns: columns}
+	for sqlRows.Next() {
+		row := make([]driver.Value, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = sqlRows.Scan(dest...); err != nil {
+			return nil, errors.Trace(err)
+		}
+		result.rows = append(result.rows, row)
+	}
+	return result, errors.Trace(sqlRows.Err())
+}
+
+func (r *Recorder) query(label, query string, args []driver.NamedValue) driver.Rows {
+	r.Record(label, query, namedValues(args)...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stubs[strings.TrimSpace(query)]
+	return &rows{columns: s.columns, rows: s.rows}
+}
+
+func namedValues(args []driver.NamedValue) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	return values
+}
+
+type connector struct {
+	recorder *Recorder
+	label    string
+	// source runs the queries, nil if they are answered by the stubs
+	source *sql.DB
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{recorder: c.recorder, label: c.label, source: c.source}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return dryRunDriver{}
+}
+
+type dryRunDriver struct{}
+
+func (dryRunDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("dry run connections are opened by Recorder.OpenDB")
+}
+
+type conn struct {
+	recorder *Recorder
+	label    string
+	source   *sql.DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	c.recorder.Record(c.label, "BEGIN")
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.recorder.Record(c.label, query, namedValues(args)...)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.source != nil {
+		return readRows(ctx, c.source, query, args)
+	}
+	return c.recorder.query(c.label, query, args), nil
+}
+
+// CheckNamedValue passes the arguments as they are, they are only printed
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	t.conn.recorder.Record(t.conn.label, "COMMIT")
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	t.conn.recorder.Record(t.conn.label, "ROLLBACK")
+	return nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.conn.recorder.Record(s.conn.label, s.query, values(args)...)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	named := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		named = append(named, driver.NamedValue{Ordinal: i + 1, Value: arg})
+	}
+	if s.conn.source != nil {
+		return readRows(context.Background(), s.conn.source, s.query, named)
+	}
+	return s.conn.recorder.query(s.conn.label, s.query, named), nil
+}
+
+func values(args []driver.Value) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg)
+	}
+	return values
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,104 @@
+package dryrun_test
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.sql")
+	recorder, err := dryrun.NewRecorder(path)
+	require.NoError(t, err)
+	recorder.StubQuery("SHOW STORAGE CREDENTIALS", []string{"name", "comment"}, []driver.Value{"cred", nil})
+
+	db := recorder.OpenDB("db.t1")
+	_, err = db.Exec("CREATE TABLE t1 (a INT)")
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE STAGE s URL = 's3://bucket/path' CREDENTIALS = (AWS_KEY_ID = 'id' AWS_SECRET_KEY = 'secret');")
+	require.NoError(t, err)
+
+	var name, comment *string
+	require.NoError(t, db.QueryRow("SHOW STORAGE CREDENTIALS").Scan(&name, &comment))
+	require.Equal(t, "cred", *name)
+	require.Nil(t, comment)
+	rows, err := db.Query("SELECT 1 FROM t1 WHERE a = ?", 1)
+	require.NoError(t, err)
+	require.False(t, rows.Next())
+	require.NoError(t, rows.Close())
+
+	_, err = recorder.OpenDB("db.t2").Exec("DROP TABLE t2")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, recorder.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `
+-- db.t1
+CREATE TABLE t1 (a INT);
+CREATE STAGE s URL = 's3://bucket/path' CREDENTIALS = (AWS_KEY_ID = 'xxxxx' AWS_SECRET_KEY = 'xxxxx');
+SHOW STORAGE CREDENTIALS;
+SELECT 1 FROM t1 WHERE a = ?; -- args: [1]
+
+-- db.t2
+DROP TABLE t2;
+`, string(data))
+}
+
+func TestRecorderReadDB(t *testing.T) {
+	// the data warehouse read is stood for by another recorder
+	source, err := dryrun.NewRecorder(filepath.Join(t.TempDir(), "source.sql"))
+	require.NoError(t, err)
+	source.StubQuery("SELECT name FROM columns", []string{"name"}, []driver.Value{"a"}, []driver.Value{"b"})
+	path := filepath.Join(t.TempDir(), "plan.sql")
+	recorder, err := dryrun.NewRecorder(path)
+	require.NoError(t, err)
+
+	db := recorder.OpenReadDB("db.t1", source.OpenDB("source"))
+	rows, err := db.Query("SELECT name FROM columns")
+	require.NoError(t, err)
+	var names []string
+	for rows.Next() {
+		var name string
+		require.NoError(t, rows.Scan(&name))
+		names = append(names, name)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"a", "b"}, names)
+	_, err = db.Exec("ALTER TABLE t1 ADD COLUMN c INT")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	require.NoError(t, recorder.Close())
+	require.NoError(t, source.Close())
+
+	// only the statements executed are recorded
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "\n-- db.t1\nALTER TABLE t1 ADD COLUMN c INT;\n", string(data))
+}
+
+func TestRecorderComment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.sql")
+	recorder, err := dryrun.NewRecorder(path)
+	require.NoError(t, err)
+	recorder.Comment("routing table", "app.orders => ANALYTICS.SRC_APP ({source_db}=>ANALYTICS.SRC_{source_db_upper})", "app.users => ANALYTICS.PUBLIC (default)")
+	_, err = recorder.OpenDB("app.orders").Exec("CREATE TABLE orders (id INT)")
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `
+-- routing table
+-- app.orders => ANALYTICS.SRC_APP ({source_db}=>ANALYTICS.SRC_{source_db_upper})
+-- app.users => ANALYTICS.PUBLIC (default)
+
+-- app.orders
+CREATE TABLE orders (id INT);
+`, string(data))
+}
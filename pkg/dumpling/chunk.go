@@ -0,0 +1,252 @@
+package dumpling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tidb/dumpling/export"
+	"go.uber.org/zap"
+)
+
+// DumpedFilesName is the file in the snapshot storage listing the data files of each table.
+// The metadata file of dumpling only records the snapshot, so the files are recorded by tidb2dw.
+const DumpedFilesName = "tidb2dw-dumped-files.json"
+
+// ChunkConfig is how the snapshot of a table is split into files
+type ChunkConfig struct {
+	// FileSize is the target size of a file in the storage, e.g. 256MiB
+	FileSize string
+	// Rows is the number of rows of a chunk dumped concurrently, 0 dumps a table sequentially
+	Rows uint64
+	// OutputFilenameTemplate is the dumpling template of the data file name, empty means `{{.DB}}.{{.Table}}.{{.Index}}`
+	OutputFilenameTemplate string
+	// Method is how the snapshot of the tables is dumped, empty means SnapshotMethodDumpling
+	Method SnapshotMethod
+	// QueryMaxRows is the most rows of a table exported with SnapshotMethodQuery, 0 means DefaultQueryMaxRows
+	QueryMaxRows int64
+}
+
+// Validate checks the snapshot method and its row threshold
+func (c *ChunkConfig) Validate() error {
+	method, err := ParseSnapshotMethod(string(c.Method))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	c.Method = method
+	if c.QueryMaxRows < 0 {
+		return errors.Errorf("invalid snapshot query max rows %d, must not be negative", c.QueryMaxRows)
+	}
+	return nil
+}
+
+// compressionRatios are the estimated ratios of the CSV files compressed by the codecs. Dumpling limits the
+// size of a file before compression, so the limit is scaled to make the compressed files close to the target.
+var compressionRatios = map[utils.Compression]uint64{
+	utils.CompressionGzip:   3,
+	utils.CompressionSnappy: 2,
+	utils.CompressionZstd:   3,
+}
+
+func (c *ChunkConfig) apply(conf *export.Config, compression utils.Compression) error {
+	if c.FileSize != "" {
+		fileSize, err := export.ParseFileSize(c.FileSize)
+		if err != nil {
+			return errors.Annotatef(err, "Invalid dump file size %s", c.FileSize)
+		}
+		if ratio, ok := compressionRatios[compression]; ok {
+			fileSize *= ratio
+			log.Info("Dump file size is scaled by the estimated compression ratio",
+				zap.String("target", c.FileSize), zap.String("compression", string(compression)), zap.Uint64("uncompressedBytes", fileSize))
+		}
+		conf.FileSize = fileSize
+	}
+	conf.Rows = c.Rows
+	if c.OutputFilenameTemplate != "" {
+		tmpl, err := parseOutputFileTemplate(c.OutputFilenameTemplate)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		conf.OutputFileTemplate = tmpl
+	}
+	return nil
+}
+
+const indexPlaceholder = "\x00"
+
+// fileNamer renders the output file template with a placeholder of the index
+type fileNamer struct {
+	DB    string
+	Table string
+}
+
+func (fileNamer) Index() string {
+	return indexPlaceholder
+}
+
+func parseOutputFileTemplate(text string) (tmpl *template.Template, err error) {
+	// ParseOutputFileTemplate panics on invalid templates
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("Invalid output filename template %s: %v", text, r)
+		}
+	}()
+	tmpl, err = export.ParseOutputFileTemplate(text)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Invalid output filename template %s", text)
+	}
+	// the files of different tables must not collide
+	a, err := renderFileName(tmpl, "a", "b")
+	if err != nil {
+		return nil, errors.Annotatef(err, "Invalid output filename template %s, only {{.DB}}, {{.Table}} and {{.Index}} are supported", text)
+	}
+	b, _ := renderFileName(tmpl, "c", "b")
+	c, _ := renderFileName(tmpl, "a", "d")
+	if a == b || a == c || strings.Count(a, indexPlaceholder) != 1 {
+		return nil, errors.Errorf("Invalid output filename template %s, {{.DB}}, {{.Table}} and {{.Index}} are required", text)
+	}
+	return tmpl, nil
+}
+
+func renderFileName(tmpl *template.Template, db, table string) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "data", fileNamer{DB: db, Table: table}); err != nil {
+		return "", errors.Trace(err)
+	}
+	return buf.String(), nil
+}
+
+// matchTableFile returns whether the file is a data file of the table named by the template
+func matchTableFile(tmpl *template.Template, db, table, fileExtension, path string) bool {
+	name, err := renderFileName(tmpl, db, table)
+	if err != nil {
+		return false
+	}
+	prefix, suffix, _ := strings.Cut(name, indexPlaceholder)
+	suffix += fileExtension
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) || len(path) <= len(prefix)+len(suffix) {
+		return false
+	}
+	index := path[len(prefix) : len(path)-len(suffix)]
+	return strings.Trim(index, "0123456789") == ""
+}
+
+// recordingStorage records the files created by dumpling, and adds the data files to the feed once written
+type recordingStorage struct {
+	storage.ExternalStorage
+
+	mu    sync.Mutex
+	files []string
+
+	// feed is nil if the snapshot is loaded after the dump
+	feed          *FileFeed
+	tmpl          *template.Template
+	tableNames    []string
+	fileExtension string
+
+	// writtenBytes and writtenFiles are the bytes written of the data files, and the data files closed
+	writtenBytes atomic.Int64
+	writtenFiles atomic.Int64
+}
+
+func (s *recordingStorage) Create(ctx context.Context, path string) (storage.ExternalFileWriter, error) {
+	if path == metadataFile {
+		// the dump is finished once all the tables are dumped, see RunDump
+		return discardWriter{}, nil
+	}
+	writer, err := s.ExternalStorage.Create(ctx, path)
+	if err != nil {
+		return writer, err
+	}
+	s.mu.Lock()
+	s.files = append(s.files, path)
+	s.mu.Unlock()
+	for _, tableFQN := range s.tableNames {
+		db, table := utils.SplitTableFQN(tableFQN)
+		if matchTableFile(s.tmpl, db, table, s.fileExtension, path) {
+			return &dataFileWriter{ExternalFileWriter: writer, written: &s.writtenBytes, onClose: func() {
+				s.writtenFiles.Add(1)
+				if s.feed != nil {
+					s.feed.add(tableFQN, path)
+				}
+			}}, nil
+		}
+	}
+	return writer, nil
+}
+
+// tableFiles returns the data files of the table created
+func (s *recordingStorage) tableFiles(tableFQN string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	db, table := utils.SplitTableFQN(tableFQN)
+	files := make([]string, 0)
+	for _, path := range s.files {
+		if matchTableFile(s.tmpl, db, table, s.fileExtension, path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// discardWriter drops the file written
+type discardWriter struct{}
+
+func (discardWriter) Write(_ context.Context, p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardWriter) Close(context.Context) error {
+	return nil
+}
+
+// writeDumpedFiles writes the data files of the tables into DumpedFilesName
+func writeDumpedFiles(ctx context.Context, extStorage storage.ExternalStorage, dumpedFiles map[string][]string) error {
+	data, err := json.Marshal(dumpedFiles)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, DumpedFilesName, data))
+}
+
+// GetDumpedFiles returns the data files of the table. The snapshot dumped before the files are
+// recorded is listed by the prefix of the default file name.
+func GetDumpedFiles(ctx context.Context, extStorage storage.ExternalStorage, tableFQN, fileExtension string) ([]string, error) {
+	exists, err := extStorage.FileExists(ctx, DumpedFilesName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if exists {
+		data, err := extStorage.ReadFile(ctx, DumpedFilesName)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var dumpedFiles map[string][]string
+		if err = json.Unmarshal(data, &dumpedFiles); err != nil {
+			return nil, errors.Annotatef(err, "Failed to parse %s", DumpedFilesName)
+		}
+		if files, ok := dumpedFiles[tableFQN]; ok {
+			return files, nil
+		}
+	}
+
+	db, table := utils.SplitTableFQN(tableFQN)
+	prefix := fmt.Sprintf("%s.%s.", db, table)
+	files := make([]string, 0)
+	err = extStorage.WalkDir(ctx, &storage.WalkOption{ObjPrefix: prefix}, func(path string, _ int64) error {
+		if strings.HasPrefix(path, prefix) && strings.HasSuffix(path, fileExtension) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, errors.Trace(err)
+}
@@ -0,0 +1,96 @@
+package dumpling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tidb/dumpling/export"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkConfigApply(t *testing.T) {
+	conf := export.DefaultConfig()
+	chunkConfig := &ChunkConfig{FileSize: "256MiB", Rows: 100000}
+	require.NoError(t, chunkConfig.apply(conf, utils.CompressionNone))
+	require.Equal(t, uint64(256<<20), conf.FileSize)
+	require.Equal(t, uint64(100000), conf.Rows)
+
+	// the limit of dumpling is before compression
+	require.NoError(t, chunkConfig.apply(conf, utils.CompressionGzip))
+	require.Equal(t, uint64(3*256<<20), conf.FileSize)
+
+	chunkConfig = &ChunkConfig{FileSize: "many"}
+	require.Error(t, chunkConfig.apply(conf, utils.CompressionNone))
+}
+
+func TestParseOutputFileTemplate(t *testing.T) {
+	_, err := parseOutputFileTemplate("{{.DB}}/{{.Table}}/part-{{.Index}}")
+	require.NoError(t, err)
+
+	for _, text := range []string{
+		"{{.Table}}.{{.Index}}",
+		"{{.DB}}.{{.Table}}",
+		"{{.DB}}.{{.Table}}.{{.Index}}.{{.Index}}",
+		"{{.DB}}.{{.Table}}.{{.Index}}.{{.Unknown}}",
+		"{{.DB}.{{.Table}}",
+	} {
+		_, err = parseOutputFileTemplate(text)
+		require.Error(t, err, text)
+	}
+}
+
+func TestMatchTableFile(t *testing.T) {
+	tmpl, err := parseOutputFileTemplate("{{.DB}}/{{.Table}}/part-{{.Index}}")
+	require.NoError(t, err)
+	require.True(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders/part-000000001.csv.gz"))
+	require.True(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders/part-0000000010000.csv.gz"))
+	require.False(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders/part-000000001.csv"))
+	require.False(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders_history/part-000000001.csv.gz"))
+	require.False(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders/part-schema.csv.gz"))
+	require.False(t, matchTableFile(tmpl, "test", "orders", ".csv.gz", "test/orders/part-.csv.gz"))
+}
+
+func TestGetDumpedFiles(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, name := range []string{"test.orders.000000000.csv", "test.orders.000000001.csv", "test.orders-schema.sql", "test.order.000000000.csv"} {
+		require.NoError(t, extStorage.WriteFile(ctx, name, []byte("1\n")))
+	}
+
+	// listed by the default file name without the recorded files
+	files, err := GetDumpedFiles(ctx, extStorage, "test.orders", ".csv")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"test.orders.000000000.csv", "test.orders.000000001.csv"}, files)
+
+	tmpl, err := parseOutputFileTemplate("{{.DB}}-{{.Table}}-part{{.Index}}")
+	require.NoError(t, err)
+	recorder := &recordingStorage{ExternalStorage: extStorage, tmpl: tmpl, fileExtension: ".csv"}
+	for _, name := range []string{"test-orders-part000000000.csv", "test-orders-part000000001.csv", "test-order-part000000000.csv", "metadata"} {
+		writer, err := recorder.Create(ctx, name)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close(ctx))
+	}
+	// the metadata of dumpling is written once all the tables are dumped
+	exists, err := extStorage.FileExists(ctx, "metadata")
+	require.NoError(t, err)
+	require.False(t, exists)
+	dumpedFiles := make(map[string][]string)
+	for _, table := range []string{"test.orders", "test.order", "test.empty"} {
+		dumpedFiles[table] = recorder.tableFiles(table)
+	}
+	require.NoError(t, writeDumpedFiles(ctx, extStorage, dumpedFiles))
+
+	files, err = GetDumpedFiles(ctx, extStorage, "test.orders", ".csv")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-orders-part000000000.csv", "test-orders-part000000001.csv"}, files)
+	files, err = GetDumpedFiles(ctx, extStorage, "test.order", ".csv")
+	require.NoError(t, err)
+	require.Equal(t, []string{"test-order-part000000000.csv"}, files)
+	// an empty table has no files
+	files, err = GetDumpedFiles(ctx, extStorage, "test.empty", ".csv")
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
@@ -3,15 +3,21 @@ package dumpling
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
 	"github.com/pingcap/tidb/dumpling/export"
-	putil "github.com/pingcap/tiflow/pkg/util"
 	"go.uber.org/zap"
 )
 
@@ -20,7 +26,8 @@ func buildDumperConfig(
 	concurrency int,
 	storageURI *url.URL,
 	snapshotTSO string,
-	tableNames []string,
+	compression utils.Compression,
+	chunkConfig *ChunkConfig,
 ) (*export.Config, error) {
 	conf := export.DefaultConfig()
 	conf.Logger = log.L()
@@ -28,6 +35,13 @@ func buildDumperConfig(
 	conf.Password = tidbConfig.Pass
 	conf.Host = tidbConfig.Host
 	conf.Port = tidbConfig.Port
+	conf.Security.CAPath = tidbConfig.SSLCA
+	conf.Security.CertPath = tidbConfig.SSLCert
+	conf.Security.KeyPath = tidbConfig.SSLKey
+	if tidbConfig.TimeZone != "" {
+		// the TIMESTAMP values are dumped in the time zone, as TiCDC writes them in the time zone of its server
+		conf.SessionParams["time_zone"] = tidbConfig.TimeZone
+	}
 	conf.Threads = concurrency
 	conf.NoHeader = true
 	conf.FileType = "csv"
@@ -35,7 +49,7 @@ func buildDumperConfig(
 	conf.CsvDelimiter = "\""
 	conf.EscapeBackslash = true
 	conf.TransactionalConsistency = true
-	conf.OutputDirPath = storageURI.String()
+	conf.OutputDirPath = utils.StripTiDB2DWParams(storageURI).String()
 	if snapshotTSO != "0" {
 		conf.Snapshot = snapshotTSO
 	}
@@ -46,29 +60,74 @@ func buildDumperConfig(
 	}
 	conf.FileSize = filesize
 
-	conf.SpecifiedTables = true
-	tables, err := export.GetConfTables(tableNames)
-	if err != nil {
-		return nil, errors.Trace(err) // Should not happen
+	if compression != utils.CompressionNone {
+		if conf.CompressType, err = export.ParseCompressType(string(compression)); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if chunkConfig != nil {
+		if err = chunkConfig.apply(conf, compression); err != nil {
+			return nil, errors.Trace(err)
+		}
 	}
-	conf.Tables = tables
+	return conf, nil
+}
 
-	externalStorage, err := putil.GetExternalStorageFromURI(context.Background(), storageURI.String())
+// TableFilter is the part of a table dumped, the columns in Columns, all of them if empty,
+// of the rows matching Where, all of them if empty
+type TableFilter struct {
+	Columns []string
+	Where   string
+	// Exprs are the expressions the columns in Columns are dumped by instead of their values, keyed by the
+	// lowercase column names
+	Exprs map[string]string
+}
+
+// filterTable makes conf dump only the part of the table by a SELECT, the files are named
+// as if the whole table is dumped
+func filterTable(conf *export.Config, tableFQN string, filter TableFilter) error {
+	db, table := utils.SplitTableFQN(tableFQN)
+	name, err := renderFileName(conf.OutputFileTemplate, db, table)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return errors.Trace(err)
+	}
+	// the table is unknown to dumpling when dumping a SELECT, so its name is rendered beforehand
+	prefix, suffix, _ := strings.Cut(name, indexPlaceholder)
+	if conf.OutputFileTemplate, err = export.ParseOutputFileTemplate(fmt.Sprintf("{{%q}}{{.Index}}{{%q}}", prefix, suffix)); err != nil {
+		return errors.Trace(err)
 	}
-	conf.ExtStorage = externalStorage
+	conf.SQL = selectFrom(tableFQN, filter)
+	if filter.Where != "" {
+		conf.SQL += fmt.Sprintf(" WHERE %s", filter.Where)
+	}
+	return nil
+}
 
-	return conf, nil
+// selectFrom returns the SELECT of the columns of the filter from the table, without the WHERE
+func selectFrom(tableFQN string, filter TableFilter) string {
+	db, table := utils.SplitTableFQN(tableFQN)
+	projection := "*"
+	if len(filter.Columns) > 0 {
+		quoted := make([]string, 0, len(filter.Columns))
+		for _, column := range filter.Columns {
+			if expr, ok := filter.Exprs[strings.ToLower(column)]; ok {
+				quoted = append(quoted, fmt.Sprintf("%s AS %s", expr, tidbsql.QuoteIdent(column)))
+				continue
+			}
+			quoted = append(quoted, tidbsql.QuoteIdent(column))
+		}
+		projection = strings.Join(quoted, ", ")
+	}
+	return fmt.Sprintf("SELECT %s FROM %s.%s", projection, tidbsql.QuoteIdent(db), tidbsql.QuoteIdent(table))
 }
 
-func buildDumper(conf *export.Config, db *sql.DB) (*export.Dumper, error) {
-	dumper, err := export.NewDumper(context.Background(), conf)
+func buildDumper(ctx context.Context, conf *export.Config, db *sql.DB) (*export.Dumper, error) {
+	dumper, err := export.NewDumper(ctx, conf)
 	if err != nil {
 		return nil, errors.Annotate(err, "Failed to create dumpling instance")
 	}
 
-	_, err = db.ExecContext(context.Background(), "SET SESSION tidb_snapshot = ?", conf.Snapshot)
+	_, err = db.ExecContext(ctx, "SET SESSION tidb_snapshot = ?", conf.Snapshot)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -77,27 +136,270 @@ func buildDumper(conf *export.Config, db *sql.DB) (*export.Dumper, error) {
 	return dumper, nil
 }
 
+// dumpUnit is a table dumped by a dumpling instance of its own, or exported by query
+type dumpUnit struct {
+	table string
+	conf  *export.Config
+	// filtered is whether the table is dumped by a TableFilter, whose rows are not estimated
+	filtered bool
+	filter   TableFilter
+	// query is how the table is exported with SnapshotMethodQuery, nil if it is dumped by dumpling
+	query *queryExport
+}
+
+// RunDump dumps the snapshot of the tables at the TSO into the storage. If feed is not nil, the data files
+// are added to it as soon as they are written, and the caller finishes it after RunDump returns.
+// Each table is dumped by a dumpling instance of its own, the tables in filters with only the columns and the rows
+// given, which are dumped in their order in the filter. The tables are dumped concurrently unless the chunks of a
+// table are, see ChunkConfig.Rows. The progress of all tables is reported periodically. With SnapshotMethodQuery, the
+// small tables are exported by query instead, see ChunkConfig.Method.
+//
+// A table dumped completely is recorded in DumpProgressFile, and the dump interrupted is resumed at its snapshot
+// without dumping the table again. The metadata file is written once the files of all tables are in the storage.
 func RunDump(
+	ctx context.Context,
 	tidbConfig *tidbsql.TiDBConfig,
 	concurrency int,
 	storageURI *url.URL,
 	snapshotTSO string,
 	tableNames []string,
-	onSnapshotDumpProgress func(dumpedRows, totalRows int64),
+	filters map[string]TableFilter,
+	compression utils.Compression,
+	chunkConfig *ChunkConfig,
+	limiters *ratelimit.Limiters,
+	onSnapshotDumpProgress func(progress apiservice.SnapshotDumpProgress),
+	feed *FileFeed,
 ) error {
-	dumpConfig, err := buildDumperConfig(tidbConfig, concurrency, storageURI, snapshotTSO, tableNames)
+	if snapshotTSO == "0" {
+		// the tables dumped separately must be at the same snapshot
+		tso, err := tidbsql.GetCurrentTSO(tidbConfig)
+		if err != nil {
+			return errors.Annotate(err, "Failed to get current TSO")
+		}
+		snapshotTSO = fmt.Sprint(tso)
+	}
+	externalStorage, err := utils.GetExternalStorageFromURI(ctx, storageURI.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	externalStorage = limiters.Wrap(externalStorage)
+	progress, err := resumeDumpProgress(ctx, externalStorage, tidbConfig, snapshotTSO, compression)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	snapshotTSO = progress.SnapshotTSO
+	dumpConfig, err := buildDumperConfig(tidbConfig, concurrency, storageURI, snapshotTSO, compression, chunkConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	recorder := &recordingStorage{
+		ExternalStorage: externalStorage,
+		feed:            feed,
+		tmpl:            dumpConfig.OutputFileTemplate,
+		tableNames:      tableNames,
+		fileExtension:   compression.CSVFileExtension(),
+	}
+
+	// the chunks of a table are dumped concurrently if it is split, otherwise each table is dumped sequentially
+	threads, parallelTables := 1, max(concurrency, 1)
+	if chunkConfig != nil && chunkConfig.Rows > 0 {
+		threads, parallelTables = concurrency, 1
+	}
+	tracker := &dumpTracker{
+		units:       len(tableNames),
+		recorder:    recorder,
+		onProgress:  onSnapshotDumpProgress,
+		running:     make(map[string]apiservice.SnapshotDumpProgress),
+		pendingRows: make(map[string]int64),
+		tableRows:   make(map[string]int64),
+	}
+	var units []*dumpUnit
+	for _, tableFQN := range tableNames {
+		conf, err := buildDumperConfig(tidbConfig, threads, storageURI, snapshotTSO, compression, chunkConfig)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		conf.ExtStorage = recorder
+		filter, filtered := filters[tableFQN]
+		if filtered {
+			if err = filterTable(conf, tableFQN, filter); err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			conf.SpecifiedTables = true
+			if conf.Tables, err = export.GetConfTables([]string{tableFQN}); err != nil {
+				return errors.Trace(err) // Should not happen
+			}
+		}
+		if dumped, ok := progress.Tables[tableFQN]; ok && dumped.SQL == conf.SQL {
+			log.Info("Skipped the table dumped before the dump is interrupted", zap.String("table", tableFQN), zap.Int("files", len(dumped.Files)))
+			if feed != nil {
+				for _, path := range dumped.Files {
+					feed.add(tableFQN, path)
+				}
+			}
+			tracker.finish(tableFQN, dumped.Rows)
+			continue
+		}
+		// the table is dumped by another filter
+		delete(progress.Tables, tableFQN)
+		units = append(units, &dumpUnit{table: tableFQN, conf: conf, filtered: filtered, filter: filter})
+	}
+
 	db, err := tidbConfig.OpenDB()
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer db.Close()
-	dumper, err := buildDumper(dumpConfig, db)
-	if err != nil {
+	for _, unit := range units {
+		if unit.filtered {
+			continue
+		}
+		if rows, err := statsTotalRows(ctx, db, []string{unit.table}); err != nil {
+			log.Warn("Failed to estimate the rows of the dump", zap.String("table", unit.table), zap.Error(err))
+		} else {
+			tracker.pendingRows[unit.table] = rows
+		}
+	}
+
+	var queryDB *sql.DB
+	if chunkConfig != nil && chunkConfig.Method == SnapshotMethodQuery && len(units) > 0 {
+		if queryDB, err = planQueryExports(ctx, tidbConfig, units, snapshotTSO, chunkConfig); err != nil {
+			return errors.Trace(err)
+		}
+		defer queryDB.Close()
+	}
+
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		slots    = make(chan struct{}, parallelTables)
+		// querySlots are the chunks exported by query at a time, of all the tables
+		querySlots = make(chan struct{}, max(concurrency, 1))
+	)
+	finish := func(unit *dumpUnit, rows int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			tracker.finish(unit.table, rows)
+			progress.Tables[unit.table] = &dumpedTable{Files: recorder.tableFiles(unit.table), Rows: rows, SQL: unit.conf.SQL}
+			err = errors.Annotatef(progress.write(ctx, externalStorage), "Failed to record the progress of the dump")
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	for _, unit := range units {
+		if unit.query != nil {
+			wg.Add(1)
+			go func(unit *dumpUnit) {
+				defer wg.Done()
+				tracker.start(unit.table)
+				rows, err := runQueryExport(dumpCtx, queryDB, unit, snapshotTSO, recorder, compression, querySlots)
+				finish(unit, rows, err)
+			}(unit)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-dumpCtx.Done():
+		}
+		if dumpCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(unit *dumpUnit) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var estimateRows func(ctx context.Context) (int64, error)
+			if !unit.filtered {
+				// the rows of a table without a filter are all dumped
+				estimateRows = func(ctx context.Context) (int64, error) {
+					return statsTotalRows(ctx, db, []string{unit.table})
+				}
+			}
+			tracker.start(unit.table)
+			rows, err := runDumper(dumpCtx, unit.conf, db, estimateRows, func(status *export.DumpStatus, estimatedTotalRows int64) {
+				tracker.update(unit.table, status, estimatedTotalRows)
+			})
+			finish(unit, rows, err)
+		}(unit)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return errors.Trace(firstErr)
+	}
+	if err = ctx.Err(); err != nil {
 		return errors.Trace(err)
 	}
+	tracker.done()
+
+	if err = checkDumpedFiles(ctx, externalStorage, progress, tableNames); err != nil {
+		return errors.Trace(err)
+	}
+	dumpedFiles := make(map[string][]string, len(tableNames))
+	for _, tableFQN := range tableNames {
+		dumpedFiles[tableFQN] = progress.Tables[tableFQN].Files
+	}
+	if err = writeDumpedFiles(ctx, externalStorage, dumpedFiles); err != nil {
+		return errors.Annotate(err, "Failed to record dumped files")
+	}
+	tso, err := strconv.ParseUint(snapshotTSO, 10, 64)
+	if err != nil {
+		return errors.Annotatef(err, "Invalid snapshot TSO %s", snapshotTSO)
+	}
+	if err = writeSnapshotMetadata(ctx, externalStorage, tso); err != nil {
+		return errors.Annotate(err, "Failed to write dumpling metadata")
+	}
+	if err = ClearDumpProgress(ctx, externalStorage); err != nil {
+		log.Warn("Failed to remove the progress of the finished dump", zap.Error(err))
+	}
+	return nil
+}
+
+// checkDumpedFiles checks the files of the tables dumped are all in the storage, a table missing a file is
+// removed from the progress to be dumped again
+func checkDumpedFiles(ctx context.Context, extStorage storage.ExternalStorage, progress *dumpProgress, tableNames []string) error {
+	existing := make(map[string]struct{})
+	err := extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		existing[path] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return errors.Annotate(err, "Failed to list the dumped files")
+	}
+	for _, tableFQN := range tableNames {
+		for _, path := range progress.Tables[tableFQN].Files {
+			if _, ok := existing[path]; ok {
+				continue
+			}
+			delete(progress.Tables, tableFQN)
+			if err = progress.write(ctx, extStorage); err != nil {
+				return errors.Annotatef(err, "Failed to record the progress of the dump")
+			}
+			return errors.Errorf("The dumped file %s of table %s is missing in the storage, the table is dumped again by the next run", path, tableFQN)
+		}
+	}
+	return nil
+}
+
+// runDumper runs one dumpling instance and returns the rows dumped. The estimated rows of dumpling are replaced by
+// estimateRows periodically if it is not nil, the error of which is only logged.
+func runDumper(
+	ctx context.Context,
+	conf *export.Config,
+	db *sql.DB,
+	estimateRows func(ctx context.Context) (int64, error),
+	onProgress func(status *export.DumpStatus, estimatedTotalRows int64),
+) (int64, error) {
+	dumper, err := buildDumper(ctx, conf, db)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
 
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -108,21 +410,31 @@ func RunDump(
 		// This is a goroutine to monitor the dump progress.
 		defer wg.Done()
 
-		if onSnapshotDumpProgress == nil {
-			return
-		}
-
-		checkInterval := 10 * time.Second
-		ticker := time.NewTicker(checkInterval)
+		ticker := time.NewTicker(progressCheckInterval)
 		defer ticker.Stop()
 
+		// statsRows is 0 until refreshed
+		var statsRows int64
+		lastRefresh := time.Now()
 		for {
 			select {
 			case <-dumpFinished:
 				return
 			case <-ticker.C:
 				status := dumper.GetStatus()
-				onSnapshotDumpProgress(int64(status.FinishedRows), int64(status.EstimateTotalRows))
+				if estimateRows != nil && time.Since(lastRefresh) >= estimateRefreshInterval {
+					lastRefresh = time.Now()
+					if rows, err := estimateRows(ctx); err != nil {
+						log.Warn("Failed to refresh the estimated rows of the dump", zap.Error(err))
+					} else {
+						statsRows = rows
+					}
+				}
+				estimatedTotalRows := int64(status.EstimateTotalRows)
+				if statsRows > 0 {
+					estimatedTotalRows = statsRows
+				}
+				onProgress(status, estimatedTotalRows)
 			}
 		}
 	}()
@@ -134,9 +446,9 @@ func RunDump(
 
 	_ = dumper.Close()
 	if err != nil {
-		return errors.Annotate(err, "Failed to dump table from TiDB")
+		return 0, errors.Annotate(err, "Failed to dump table from TiDB")
 	}
 	status := dumper.GetStatus()
-	log.Info("Successfully dumped table from TiDB", zap.Any("status", status))
-	return nil
+	log.Info("Successfully dumped table from TiDB", zap.Any("status", status), zap.String("sql", conf.SQL))
+	return int64(status.FinishedRows), nil
 }
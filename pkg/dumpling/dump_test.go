@@ -0,0 +1,33 @@
+package dumpling
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/dumpling/export"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterTable(t *testing.T) {
+	for _, chunkConfig := range []*ChunkConfig{{}, {OutputFilenameTemplate: "{{.DB}}/{{.Table}}/part-{{.Index}}"}} {
+		conf := export.DefaultConfig()
+		require.NoError(t, chunkConfig.apply(conf, utils.CompressionNone))
+		tableName, err := renderFileName(conf.OutputFileTemplate, "test", "user`s")
+		require.NoError(t, err)
+
+		require.NoError(t, filterTable(conf, "test.user`s", TableFilter{Columns: []string{"id", "name"}}))
+		require.Equal(t, "SELECT `id`, `name` FROM `test`.`user``s`", conf.SQL)
+		// the files are named as the files of the table, which dumpling does not know
+		name, err := renderFileName(conf.OutputFileTemplate, "", "")
+		require.NoError(t, err)
+		require.Equal(t, tableName, name)
+
+		require.NoError(t, filterTable(conf, "test.user`s", TableFilter{Where: "age >= 18"}))
+		require.Equal(t, "SELECT * FROM `test`.`user``s` WHERE age >= 18", conf.SQL)
+
+		// the columns with an expression are dumped by it under their names
+		filter := TableFilter{Columns: []string{"id", "Flags"}, Exprs: map[string]string{"flags": "LPAD(HEX(`flags`), 4, '0')"}}
+		require.NoError(t, filterTable(conf, "test.user`s", filter))
+		require.Equal(t, "SELECT `id`, LPAD(HEX(`flags`), 4, '0') AS `Flags` FROM `test`.`user``s`", conf.SQL)
+	}
+}
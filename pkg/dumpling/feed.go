@@ -0,0 +1,91 @@
+package dumpling
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// FileFeed streams the data files of the tables as soon as dumpling finishes writing them, so that the
+// snapshot is loaded while it is being dumped
+type FileFeed struct {
+	mu    sync.Mutex
+	files map[string][]string
+	done  bool
+	err   error
+	// changed is closed and replaced when a file is added or the dump is finished
+	changed chan struct{}
+}
+
+func NewFileFeed() *FileFeed {
+	return &FileFeed{files: make(map[string][]string), changed: make(chan struct{})}
+}
+
+func (f *FileFeed) add(tableFQN, path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.files[tableFQN] = append(f.files[tableFQN], path)
+	f.notify()
+}
+
+// Finish marks the dump is finished, the error of the dump is returned by Next
+func (f *FileFeed) Finish(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.done, f.err = true, err
+	f.notify()
+}
+
+func (f *FileFeed) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Next waits for the files of the table dumped after the first offset files. done is true once the dump is
+// finished and the files returned are the last ones of the table.
+func (f *FileFeed) Next(ctx context.Context, tableFQN string, offset int) (files []string, done bool, err error) {
+	for {
+		f.mu.Lock()
+		files, done, err = slices.Clone(f.files[tableFQN][offset:]), f.done, f.err
+		changed := f.changed
+		f.mu.Unlock()
+		if err != nil {
+			return nil, true, errors.Trace(err)
+		}
+		if len(files) > 0 || done {
+			return files, done, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, false, errors.Trace(ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// dataFileWriter counts the bytes written of a data file, and calls onClose once the file is completely written
+type dataFileWriter struct {
+	storage.ExternalFileWriter
+	written *atomic.Int64
+	onClose func()
+}
+
+func (w *dataFileWriter) Write(ctx context.Context, p []byte) (int, error) {
+	n, err := w.ExternalFileWriter.Write(ctx, p)
+	w.written.Add(int64(n))
+	return n, err
+}
+
+func (w *dataFileWriter) Close(ctx context.Context) error {
+	if err := w.ExternalFileWriter.Close(ctx); err != nil {
+		return err
+	}
+	w.onClose()
+	return nil
+}
@@ -0,0 +1,68 @@
+package dumpling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tidb/dumpling/export"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileFeed(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	feed := NewFileFeed()
+	recorder := &recordingStorage{
+		ExternalStorage: extStorage,
+		feed:            feed,
+		tmpl:            export.DefaultOutputFileTemplate,
+		tableNames:      []string{"test.orders", "test.users"},
+		fileExtension:   ".csv",
+	}
+
+	writer, err := recorder.Create(ctx, "test.orders.000000000.csv")
+	require.NoError(t, err)
+	_, err = writer.Write(ctx, []byte("1,a\n"))
+	require.NoError(t, err)
+	// the file is not fed until it is completely written
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = feed.Next(timeoutCtx, "test.orders", 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, writer.Close(ctx))
+
+	for _, name := range []string{"test.orders-schema.sql", "test.orders.000000001.csv", "test.users.000000000.csv"} {
+		writer, err = recorder.Create(ctx, name)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close(ctx))
+	}
+	files, done, err := feed.Next(ctx, "test.orders", 0)
+	require.NoError(t, err)
+	require.False(t, done)
+	require.Equal(t, []string{"test.orders.000000000.csv", "test.orders.000000001.csv"}, files)
+	// the schema file is not counted in the progress
+	require.Equal(t, int64(4), recorder.writtenBytes.Load())
+	require.Equal(t, int64(3), recorder.writtenFiles.Load())
+
+	// the waiting table is woken up by the finish of the dump
+	result := make(chan bool)
+	go func() {
+		files, done, err := feed.Next(ctx, "test.orders", 2)
+		result <- err == nil && done && len(files) == 0
+	}()
+	feed.Finish(nil)
+	require.True(t, <-result)
+	files, done, err = feed.Next(ctx, "test.users", 0)
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, []string{"test.users.000000000.csv"}, files)
+
+	feed = NewFileFeed()
+	feed.Finish(errors.New("dump failed"))
+	_, _, err = feed.Next(ctx, "test.orders", 0)
+	require.ErrorContains(t, err, "dump failed")
+}
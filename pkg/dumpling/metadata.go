@@ -0,0 +1,61 @@
+package dumpling
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// metadataFile is written in the snapshot storage when the dump is finished. Dumpling writes it at the end of each
+// instance, so tidb2dw writes it instead once all the tables are dumped.
+const metadataFile = "metadata"
+
+// ReadSnapshotTSO returns the TSO of the snapshot dumped, which dumpling records as the position of
+// SHOW MASTER STATUS in the metadata file.
+func ReadSnapshotTSO(ctx context.Context, extStorage storage.ExternalStorage) (uint64, error) {
+	data, err := extStorage.ReadFile(ctx, metadataFile)
+	if err != nil {
+		return 0, errors.Annotate(err, "Failed to read dumpling metadata")
+	}
+	return parseSnapshotTSO(data)
+}
+
+// EnsureSnapshotMetadata writes the metadata file of dumpling recording the TSO of the snapshot unless it is already
+// written, e.g. for the snapshot exported by TiDB Cloud, which marks the snapshot dumped
+func EnsureSnapshotMetadata(ctx context.Context, extStorage storage.ExternalStorage, tso uint64) error {
+	exists, err := extStorage.FileExists(ctx, metadataFile)
+	if err != nil || exists {
+		return errors.Trace(err)
+	}
+	return errors.Trace(writeSnapshotMetadata(ctx, extStorage, tso))
+}
+
+// writeSnapshotMetadata writes the metadata file in the format of dumpling
+func writeSnapshotMetadata(ctx context.Context, extStorage storage.ExternalStorage, tso uint64) error {
+	metadata := fmt.Sprintf("SHOW MASTER STATUS:\n\tLog: tidb-binlog\n\tPos: %d\n\tGTID:\n\n", tso)
+	return errors.Trace(extStorage.WriteFile(ctx, metadataFile, []byte(metadata)))
+}
+
+func parseSnapshotTSO(data []byte) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if pos, ok := strings.CutPrefix(line, "Pos:"); ok {
+			tso, err := strconv.ParseUint(strings.TrimSpace(pos), 10, 64)
+			if err != nil {
+				return 0, errors.Annotatef(err, "Invalid snapshot position %q in dumpling metadata", line)
+			}
+			return tso, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return 0, errors.New("No snapshot position in dumpling metadata")
+}
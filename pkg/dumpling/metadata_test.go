@@ -0,0 +1,45 @@
+package dumpling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSnapshotTSO(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = ReadSnapshotTSO(ctx, extStorage)
+	require.ErrorContains(t, err, "Failed to read dumpling metadata")
+
+	metadata := "Started dump at: 2026-10-15 10:00:00\n" +
+		"SHOW MASTER STATUS:\n" +
+		"\tLog: tidb-binlog\n" +
+		"\tPos: 450123456789012345\n" +
+		"\tGTID:\n\n" +
+		"Finished dump at: 2026-10-15 10:05:00\n"
+	require.NoError(t, extStorage.WriteFile(ctx, "metadata", []byte(metadata)))
+	tso, err := ReadSnapshotTSO(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, uint64(450123456789012345), tso)
+
+	// the metadata written by dumpling is kept
+	require.NoError(t, EnsureSnapshotMetadata(ctx, extStorage, 1))
+	tso, err = ReadSnapshotTSO(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, uint64(450123456789012345), tso)
+	require.NoError(t, extStorage.DeleteFile(ctx, "metadata"))
+	require.NoError(t, EnsureSnapshotMetadata(ctx, extStorage, 450123456789012346))
+	tso, err = ReadSnapshotTSO(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, uint64(450123456789012346), tso)
+
+	_, err = parseSnapshotTSO([]byte("SHOW MASTER STATUS:\n\tLog: tidb-binlog\n"))
+	require.ErrorContains(t, err, "No snapshot position")
+	_, err = parseSnapshotTSO([]byte("\tPos: abc\n"))
+	require.ErrorContains(t, err, "Invalid snapshot position")
+}
@@ -0,0 +1,165 @@
+package dumpling
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/dumpling/export"
+)
+
+const (
+	// progressCheckInterval is the interval of reading the status of dumpling
+	progressCheckInterval = 10 * time.Second
+	// progressWindow is the period the dump speed is observed over
+	progressWindow = 5 * time.Minute
+	// estimateRefreshInterval is the interval of refreshing the estimated rows from the statistics of TiDB
+	estimateRefreshInterval = 5 * time.Minute
+)
+
+type progressSample struct {
+	at   time.Time
+	rows int64
+}
+
+// progressTracker observes the rows dumped over a sliding window and estimates when the dump is finished
+type progressTracker struct {
+	samples []progressSample
+}
+
+// observe records the progress and fills its speed and ETA
+func (t *progressTracker) observe(at time.Time, progress apiservice.SnapshotDumpProgress) apiservice.SnapshotDumpProgress {
+	t.samples = append(t.samples, progressSample{at: at, rows: progress.DumpedRows})
+	// a sample at the beginning of the window is kept
+	for len(t.samples) > 2 && at.Sub(t.samples[1].at) >= progressWindow {
+		t.samples = t.samples[1:]
+	}
+	progress.DumpUpdatedAt = at
+	progress.DumpETASeconds = -1
+	first := t.samples[0]
+	if elapsed := at.Sub(first.at).Seconds(); elapsed > 0 {
+		progress.DumpRowsPerSecond = float64(progress.DumpedRows-first.rows) / elapsed
+	}
+	if remaining := progress.EstimatedTotalRows - progress.DumpedRows; remaining <= 0 {
+		progress.DumpETASeconds = 0
+	} else if progress.DumpRowsPerSecond > 0 {
+		progress.DumpETASeconds = int64(float64(remaining) / progress.DumpRowsPerSecond)
+	}
+	return progress
+}
+
+// dumpTracker sums the progress of the tables dumped concurrently
+type dumpTracker struct {
+	mu sync.Mutex
+	// units are the tables of the dump, finishedUnits those dumped and finishedRows their rows
+	units         int
+	finishedUnits int
+	finishedRows  int64
+	// tableRows are the rows dumped of the finished and the running tables by table
+	tableRows map[string]int64
+	// pendingRows are the estimated rows of the tables not started by table
+	pendingRows map[string]int64
+	// running are the progress of the tables being dumped
+	running    map[string]apiservice.SnapshotDumpProgress
+	tracker    progressTracker
+	recorder   *recordingStorage
+	onProgress func(progress apiservice.SnapshotDumpProgress)
+}
+
+// start moves the estimated rows of the table from pendingRows to the table being dumped
+func (t *dumpTracker) start(table string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running[table] = apiservice.SnapshotDumpProgress{EstimatedTotalRows: t.pendingRows[table]}
+	delete(t.pendingRows, table)
+}
+
+// update records the status of the dumpling instance of the table and reports the progress of all tables
+func (t *dumpTracker) update(table string, status *export.DumpStatus, estimatedTotalRows int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dumpedRows := int64(status.FinishedRows)
+	t.tableRows[table] = dumpedRows
+	t.running[table] = apiservice.SnapshotDumpProgress{
+		DumpedRows:             dumpedRows,
+		EstimatedTotalRows:     max(estimatedTotalRows, dumpedRows),
+		ChunksCompletedPercent: parseChunkProgress(status.Progress),
+	}
+	progress := apiservice.SnapshotDumpProgress{
+		DumpedRows:             t.finishedRows,
+		EstimatedTotalRows:     t.finishedRows,
+		ChunksCompletedPercent: float64(t.finishedUnits) * 100,
+	}
+	for _, rows := range t.pendingRows {
+		progress.EstimatedTotalRows += rows
+	}
+	for _, running := range t.running {
+		progress.DumpedRows += running.DumpedRows
+		progress.EstimatedTotalRows += running.EstimatedTotalRows
+		progress.ChunksCompletedPercent += running.ChunksCompletedPercent
+	}
+	progress.ChunksCompletedPercent /= float64(max(t.units, 1))
+	t.report(progress)
+}
+
+// finish records the rows of the table dumped
+func (t *dumpTracker) finish(table string, rows int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, table)
+	t.finishedUnits++
+	t.finishedRows += rows
+	t.tableRows[table] = rows
+}
+
+// done reports the progress of the finished dump
+func (t *dumpTracker) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report(apiservice.SnapshotDumpProgress{DumpedRows: t.finishedRows, EstimatedTotalRows: t.finishedRows, ChunksCompletedPercent: 100})
+}
+
+func (t *dumpTracker) report(progress apiservice.SnapshotDumpProgress) {
+	if t.onProgress == nil {
+		return
+	}
+	progress.DumpedBytes = t.recorder.writtenBytes.Load()
+	progress.DumpedFiles = t.recorder.writtenFiles.Load()
+	progress.TablesDumpedRows = maps.Clone(t.tableRows)
+	t.onProgress(t.tracker.observe(time.Now(), progress))
+}
+
+// parseChunkProgress parses the chunks completed of the status of dumpling, e.g. "42.00 %"
+func parseChunkProgress(progress string) float64 {
+	percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(progress, "%")), 64)
+	if err != nil {
+		// the chunks are not split yet
+		return 0
+	}
+	return percent
+}
+
+// statsTotalRows returns the rows of the tables in the statistics of TiDB, which follow the DML since the last
+// ANALYZE, while dumpling estimates the rows once at the beginning of the dump
+func statsTotalRows(ctx context.Context, db *sql.DB, tables []string) (int64, error) {
+	conditions := make([]string, 0, len(tables))
+	args := make([]any, 0, 2*len(tables))
+	for _, tableFQN := range tables {
+		database, table := utils.SplitTableFQN(tableFQN)
+		conditions = append(conditions, "(TABLE_SCHEMA = ? AND TABLE_NAME = ?)")
+		args = append(args, database, table)
+	}
+	query := "SELECT COALESCE(SUM(TABLE_ROWS), 0) FROM INFORMATION_SCHEMA.TABLES WHERE " + strings.Join(conditions, " OR ")
+	var rows int64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&rows); err != nil {
+		return 0, errors.Annotate(err, "Failed to query the rows of the tables from the statistics")
+	}
+	return rows, nil
+}
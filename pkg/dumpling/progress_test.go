@@ -0,0 +1,35 @@
+package dumpling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressTracker(t *testing.T) {
+	tracker := &progressTracker{}
+	start := time.Now()
+	progress := tracker.observe(start, apiservice.SnapshotDumpProgress{DumpedRows: 0, EstimatedTotalRows: 1000})
+	require.Equal(t, int64(-1), progress.DumpETASeconds)
+	require.Equal(t, start, progress.DumpUpdatedAt)
+
+	progress = tracker.observe(start.Add(10*time.Second), apiservice.SnapshotDumpProgress{DumpedRows: 100, EstimatedTotalRows: 1000})
+	require.Equal(t, float64(10), progress.DumpRowsPerSecond)
+	require.Equal(t, int64(90), progress.DumpETASeconds)
+
+	// the speed is observed over the window only
+	tracker.observe(start.Add(progressWindow), apiservice.SnapshotDumpProgress{DumpedRows: 6000, EstimatedTotalRows: 10000})
+	progress = tracker.observe(start.Add(progressWindow+10*time.Second), apiservice.SnapshotDumpProgress{DumpedRows: 6100, EstimatedTotalRows: 10000})
+	require.Equal(t, float64(6000)/progressWindow.Seconds(), progress.DumpRowsPerSecond)
+
+	progress = tracker.observe(start.Add(progressWindow+20*time.Second), apiservice.SnapshotDumpProgress{DumpedRows: 10000, EstimatedTotalRows: 10000})
+	require.Equal(t, int64(0), progress.DumpETASeconds)
+}
+
+func TestParseChunkProgress(t *testing.T) {
+	require.Equal(t, 42.5, parseChunkProgress("42.50 %"))
+	require.Equal(t, float64(100), parseChunkProgress("100 %"))
+	require.Equal(t, float64(0), parseChunkProgress(""))
+}
@@ -0,0 +1,391 @@
+package dumpling
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// SnapshotMethod is how the snapshot of the tables is dumped
+type SnapshotMethod string
+
+const (
+	// SnapshotMethodDumpling dumps each table by a dumpling instance of its own
+	SnapshotMethodDumpling SnapshotMethod = "dumpling"
+	// SnapshotMethodQuery exports the tables of at most ChunkConfig.QueryMaxRows rows by SELECT from tidb2dw, which
+	// saves starting a dumpling instance per table. The larger tables and those without a chunk key are dumped by
+	// dumpling.
+	SnapshotMethodQuery SnapshotMethod = "query"
+)
+
+// ParseSnapshotMethod parses the method of --snapshot-method
+func ParseSnapshotMethod(s string) (SnapshotMethod, error) {
+	switch method := SnapshotMethod(strings.ToLower(s)); method {
+	case "", SnapshotMethodDumpling:
+		return SnapshotMethodDumpling, nil
+	case SnapshotMethodQuery:
+		return method, nil
+	}
+	return "", errors.Errorf("invalid snapshot method %s, expected dumpling or query", s)
+}
+
+const (
+	// DefaultQueryMaxRows is the most rows of a table exported with SnapshotMethodQuery by default
+	DefaultQueryMaxRows = 100000
+	// defaultQueryChunkRows is the rows of a file exported with SnapshotMethodQuery unless ChunkConfig.Rows is set
+	defaultQueryChunkRows = 50000
+	// queryFlushBytes is the size of the rows buffered before they are written into the file
+	queryFlushBytes = 1 << 20
+	// tidbRowID is the handle of the tables not clustered by their primary key
+	tidbRowID = "_tidb_rowid"
+)
+
+// numberTypes are the column types written without quotes, the same as dumpling
+var numberTypes = map[string]struct{}{
+	"INTEGER": {}, "BIGINT": {}, "TINYINT": {}, "SMALLINT": {}, "MEDIUMINT": {},
+	"INT": {}, "INT1": {}, "INT2": {}, "INT3": {}, "INT8": {},
+	"UNSIGNED INT": {}, "UNSIGNED BIGINT": {}, "UNSIGNED TINYINT": {}, "UNSIGNED SMALLINT": {},
+	"FLOAT": {}, "REAL": {}, "DOUBLE": {}, "DOUBLE PRECISION": {},
+	"DECIMAL": {}, "NUMERIC": {}, "FIXED": {},
+	"BOOL": {}, "BOOLEAN": {},
+}
+
+// integerTypes are the types of the primary keys which are the handle of a clustered table
+var integerTypes = []string{"tinyint", "smallint", "mediumint", "int", "bigint"}
+
+// queryExport is how a table is exported with SnapshotMethodQuery
+type queryExport struct {
+	// key is the integer column the table is chunked by, _tidb_rowid if the table is not clustered
+	key string
+	// bounds are the lowest keys of the chunks, there is no chunk if the table is empty
+	bounds []string
+}
+
+// chunkKey returns the integer handle of the table the chunks are split by, empty if the table is clustered by
+// another primary key
+func chunkKey(ctx context.Context, db *sql.DB, tableFQN string) (string, error) {
+	database, table := utils.SplitTableFQN(tableFQN)
+	rows, err := db.QueryContext(ctx, "SELECT COLUMN_NAME, DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND COLUMN_KEY = 'PRI'", database, table)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer rows.Close()
+	var pkColumns, pkTypes []string
+	for rows.Next() {
+		var column, dataType string
+		if err = rows.Scan(&column, &dataType); err != nil {
+			return "", errors.Trace(err)
+		}
+		pkColumns, pkTypes = append(pkColumns, column), append(pkTypes, strings.ToLower(dataType))
+	}
+	if err = rows.Err(); err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(pkColumns) == 1 && slices.Contains(integerTypes, pkTypes[0]) {
+		return pkColumns[0], nil
+	}
+	var pkType sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT TIDB_PK_TYPE FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?", database, table).Scan(&pkType)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if pkType.String == "CLUSTERED" {
+		return "", nil
+	}
+	return tidbRowID, nil
+}
+
+// quoteKey quotes the chunk key, _tidb_rowid is not a column to quote
+func quoteKey(key string) string {
+	if key == tidbRowID {
+		return key
+	}
+	return tidbsql.QuoteIdent(key)
+}
+
+// planQueryExport returns how the table is exported with SnapshotMethodQuery at the snapshot of the connection, nil
+// with the reason if it is left to dumpling. The rows are counted up to maxRows+1, so a large table is not scanned.
+func planQueryExport(ctx context.Context, db *sql.DB, conn *sql.Conn, tableFQN string, maxRows, chunkRows int64) (*queryExport, string, error) {
+	key, err := chunkKey(ctx, db, tableFQN)
+	if err != nil {
+		return nil, "", errors.Annotate(err, "Failed to find the chunk key")
+	}
+	if key == "" {
+		return nil, "no integer primary key or _tidb_rowid to chunk the table by", nil
+	}
+	database, table := utils.SplitTableFQN(tableFQN)
+	from := fmt.Sprintf("%s.%s", tidbsql.QuoteIdent(database), tidbsql.QuoteIdent(table))
+	var rows int64
+	if err = conn.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM (SELECT 1 FROM %s LIMIT %d) AS t", from, maxRows+1)).Scan(&rows); err != nil {
+		return nil, "", errors.Annotate(err, "Failed to count the rows")
+	}
+	if rows > maxRows {
+		return nil, fmt.Sprintf("more than %d rows", maxRows), nil
+	}
+	query := fmt.Sprintf("SELECT k FROM (SELECT %s AS k, ROW_NUMBER() OVER (ORDER BY %s) AS rn FROM %s) AS t WHERE MOD(rn, %d) = 1 ORDER BY k",
+		quoteKey(key), quoteKey(key), from, chunkRows)
+	boundRows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, "", errors.Annotate(err, "Failed to split the chunks")
+	}
+	defer boundRows.Close()
+	export := &queryExport{key: key}
+	for boundRows.Next() {
+		var bound string
+		if err = boundRows.Scan(&bound); err != nil {
+			return nil, "", errors.Trace(err)
+		}
+		// the bounds are written into the queries, they must be integers
+		if _, err := strconv.ParseInt(bound, 10, 64); err != nil {
+			if _, err := strconv.ParseUint(bound, 10, 64); err != nil {
+				return nil, "", errors.Errorf("invalid chunk bound %s of %s", bound, key)
+			}
+		}
+		export.bounds = append(export.bounds, bound)
+	}
+	return export, "", errors.Trace(boundRows.Err())
+}
+
+// chunkQueries returns the SELECT of each chunk of the table, the first chunk has no lower bound and the last no
+// upper bound
+func (e *queryExport) chunkQueries(tableFQN string, filter TableFilter) []string {
+	queries := make([]string, 0, len(e.bounds))
+	for i := range e.bounds {
+		conditions := make([]string, 0, 3)
+		if filter.Where != "" {
+			conditions = append(conditions, "("+filter.Where+")")
+		}
+		if i > 0 {
+			conditions = append(conditions, fmt.Sprintf("%s >= %s", quoteKey(e.key), e.bounds[i]))
+		}
+		if i < len(e.bounds)-1 {
+			conditions = append(conditions, fmt.Sprintf("%s < %s", quoteKey(e.key), e.bounds[i+1]))
+		}
+		query := selectFrom(tableFQN, filter)
+		if len(conditions) > 0 {
+			query += " WHERE " + strings.Join(conditions, " AND ")
+		}
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+// exportChunk writes the rows of the query into the file in the CSV dialect of dumpling, the file is not created if
+// there is no row
+func exportChunk(ctx context.Context, conn *sql.Conn, query string, extStorage storage.ExternalStorage, path string) (int64, error) {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	numbers := make([]bool, len(columnTypes))
+	for i, columnType := range columnTypes {
+		_, numbers[i] = numberTypes[columnType.DatabaseTypeName()]
+	}
+	values := make([]sql.RawBytes, len(columnTypes))
+	args := make([]any, len(columnTypes))
+	for i := range values {
+		args[i] = &values[i]
+	}
+
+	var (
+		buf     bytes.Buffer
+		writer  storage.ExternalFileWriter
+		written int64
+	)
+	flush := func() error {
+		if writer == nil {
+			if writer, err = extStorage.Create(ctx, path); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		_, err := writer.Write(ctx, buf.Bytes())
+		buf.Reset()
+		return errors.Trace(err)
+	}
+	for rows.Next() {
+		if err = rows.Scan(args...); err != nil {
+			return 0, errors.Trace(err)
+		}
+		writeCSVRow(&buf, values, numbers)
+		written++
+		if buf.Len() >= queryFlushBytes {
+			if err = flush(); err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	if buf.Len() > 0 {
+		if err = flush(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	if writer != nil {
+		if err = writer.Close(ctx); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return written, nil
+}
+
+// writeCSVRow writes the row as dumpling does with --csv-separator=, --csv-delimiter=" and --escape-backslash, the
+// numbers are not quoted and NULL is \N
+func writeCSVRow(buf *bytes.Buffer, values []sql.RawBytes, numbers []bool) {
+	for i, value := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		switch {
+		case value == nil:
+			buf.WriteString(`\N`)
+		case numbers[i]:
+			buf.Write(value)
+		default:
+			buf.WriteByte('"')
+			for _, b := range value {
+				switch b {
+				case 0:
+					buf.WriteString(`\0`)
+				case '\r':
+					buf.WriteString(`\r`)
+				case '\n':
+					buf.WriteString(`\n`)
+				case '\\', '"':
+					buf.WriteByte('\\')
+					buf.WriteByte(b)
+				default:
+					buf.WriteByte(b)
+				}
+			}
+			buf.WriteByte('"')
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+// runQueryExport exports the chunks of the table at the snapshot into the files named as dumpling names them, at
+// most as many chunks of all the tables at a time as the slots. It returns the rows exported.
+func runQueryExport(
+	ctx context.Context,
+	db *sql.DB,
+	unit *dumpUnit,
+	snapshotTSO string,
+	extStorage storage.ExternalStorage,
+	compression utils.Compression,
+	slots chan struct{},
+) (int64, error) {
+	database, table := utils.SplitTableFQN(unit.table)
+	name, err := renderFileName(unit.conf.OutputFileTemplate, database, table)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	prefix, suffix, _ := strings.Cut(name, indexPlaceholder)
+	if compression != utils.CompressionNone {
+		extStorage = storage.WithCompression(extStorage, compression.CompressType())
+	}
+
+	queries := unit.query.chunkQueries(unit.table, unit.filter)
+	results := make(chan error, len(queries))
+	rows := make([]int64, len(queries))
+	for i, query := range queries {
+		go func(i int, query string) {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				results <- errors.Trace(ctx.Err())
+				return
+			}
+			defer func() { <-slots }()
+			conn, err := snapshotConn(ctx, db, snapshotTSO)
+			if err != nil {
+				results <- errors.Trace(err)
+				return
+			}
+			defer conn.Close()
+			path := fmt.Sprintf("%s%09d%s%s", prefix, i, suffix, compression.CSVFileExtension())
+			rows[i], err = exportChunk(ctx, conn, query, extStorage, path)
+			results <- errors.Annotatef(err, "Failed to export chunk %d of table %s", i, unit.table)
+		}(i, query)
+	}
+	var firstErr error
+	for range queries {
+		if err := <-results; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return 0, errors.Trace(firstErr)
+	}
+	var total int64
+	for _, n := range rows {
+		total += n
+	}
+	log.Info("Successfully exported table from TiDB by query", zap.String("table", unit.table), zap.Int("chunks", len(queries)), zap.Int64("rows", total))
+	return total, nil
+}
+
+// snapshotConn returns a connection reading at the snapshot
+func snapshotConn(ctx context.Context, db *sql.DB, snapshotTSO string) (*sql.Conn, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err = conn.ExecContext(ctx, "SET SESSION tidb_snapshot = ?", snapshotTSO); err != nil {
+		conn.Close()
+		return nil, errors.Trace(err)
+	}
+	return conn, nil
+}
+
+// planQueryExports plans how each of the units is exported by query at the snapshot, the units not exported by query
+// are left dumped by dumpling. It returns the DB the units are exported from.
+func planQueryExports(ctx context.Context, tidbConfig *tidbsql.TiDBConfig, units []*dumpUnit, snapshotTSO string, chunkConfig *ChunkConfig) (*sql.DB, error) {
+	maxRows, chunkRows := chunkConfig.QueryMaxRows, int64(chunkConfig.Rows)
+	if maxRows == 0 {
+		maxRows = DefaultQueryMaxRows
+	}
+	if chunkRows == 0 {
+		chunkRows = defaultQueryChunkRows
+	}
+	db, err := tidbConfig.OpenDB()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	conn, err := snapshotConn(ctx, db, snapshotTSO)
+	if err != nil {
+		db.Close()
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+	for _, unit := range units {
+		query, reason, err := planQueryExport(ctx, db, conn, unit.table, maxRows, chunkRows)
+		if err != nil {
+			log.Warn("Failed to plan the export of the table by query, dump it by dumpling", zap.String("table", unit.table), zap.Error(err))
+			continue
+		}
+		if reason != "" {
+			log.Info("Dump the table by dumpling instead of query", zap.String("table", unit.table), zap.String("reason", reason))
+			continue
+		}
+		unit.query = query
+	}
+	return db, nil
+}
@@ -0,0 +1,51 @@
+package dumpling
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSnapshotMethod(t *testing.T) {
+	method, err := ParseSnapshotMethod("")
+	require.NoError(t, err)
+	require.Equal(t, SnapshotMethodDumpling, method)
+	method, err = ParseSnapshotMethod("Query")
+	require.NoError(t, err)
+	require.Equal(t, SnapshotMethodQuery, method)
+	_, err = ParseSnapshotMethod("select-into")
+	require.ErrorContains(t, err, "invalid snapshot method select-into")
+
+	config := &ChunkConfig{Method: "QUERY"}
+	require.NoError(t, config.Validate())
+	require.Equal(t, SnapshotMethodQuery, config.Method)
+	config.QueryMaxRows = -1
+	require.Error(t, config.Validate())
+}
+
+func TestChunkQueries(t *testing.T) {
+	export := &queryExport{key: "id", bounds: []string{"1", "100", "200"}}
+	require.Equal(t, []string{
+		"SELECT `id`, `name` FROM `test`.`t` WHERE (id > 0) AND `id` < 100",
+		"SELECT `id`, `name` FROM `test`.`t` WHERE (id > 0) AND `id` >= 100 AND `id` < 200",
+		"SELECT `id`, `name` FROM `test`.`t` WHERE (id > 0) AND `id` >= 200",
+	}, export.chunkQueries("test.t", TableFilter{Columns: []string{"id", "name"}, Where: "id > 0"}))
+
+	// a table of a single chunk is exported by a query without bounds
+	export = &queryExport{key: tidbRowID, bounds: []string{"1"}}
+	require.Equal(t, []string{"SELECT * FROM `test`.`t`"}, export.chunkQueries("test.t", TableFilter{}))
+	export.bounds = append(export.bounds, "50001")
+	require.Equal(t, []string{
+		"SELECT * FROM `test`.`t` WHERE _tidb_rowid < 50001",
+		"SELECT * FROM `test`.`t` WHERE _tidb_rowid >= 50001",
+	}, export.chunkQueries("test.t", TableFilter{}))
+}
+
+func TestWriteCSVRow(t *testing.T) {
+	var buf bytes.Buffer
+	writeCSVRow(&buf, []sql.RawBytes{[]byte("1"), nil, []byte("a\"b\\c\r\nd\x00"), []byte("")}, []bool{true, false, false, false})
+	writeCSVRow(&buf, []sql.RawBytes{[]byte("-2.5"), []byte("x"), nil, []byte("2024-01-01")}, []bool{true, false, false, false})
+	require.Equal(t, "1,\\N,\"a\\\"b\\\\c\\r\\nd\\0\",\"\"\r\n-2.5,\"x\",\\N,\"2024-01-01\"\r\n", buf.String())
+}
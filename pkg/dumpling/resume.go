@@ -0,0 +1,107 @@
+package dumpling
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// DumpProgressFile is the file in the snapshot storage recording the tables of an unfinished dump which are dumped
+// completely, they are not dumped again when the dump is resumed. It is removed once the dump is finished.
+const DumpProgressFile = "dump-progress.json"
+
+// dumpProgress is the progress of a dump at a snapshot, the tables in it are dumped completely
+type dumpProgress struct {
+	SnapshotTSO string                  `json:"snapshot_tso"`
+	Compression utils.Compression       `json:"compression"`
+	Tables      map[string]*dumpedTable `json:"tables"`
+}
+
+// dumpedTable is a table dumped completely
+type dumpedTable struct {
+	Files []string `json:"files"`
+	Rows  int64    `json:"rows"`
+	// SQL is the SELECT the table is dumped by with a TableFilter, empty if the whole table is dumped
+	SQL string `json:"sql,omitempty"`
+}
+
+func readDumpProgress(ctx context.Context, extStorage storage.ExternalStorage) (*dumpProgress, error) {
+	exists, err := extStorage.FileExists(ctx, DumpProgressFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, DumpProgressFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var progress dumpProgress
+	if err = json.Unmarshal(data, &progress); err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse %s", DumpProgressFile)
+	}
+	if progress.Tables == nil {
+		progress.Tables = make(map[string]*dumpedTable)
+	}
+	return &progress, nil
+}
+
+func (p *dumpProgress) write(ctx context.Context, extStorage storage.ExternalStorage) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, DumpProgressFile, data))
+}
+
+// resumeDumpProgress returns the progress of the unfinished dump of the tables, which is resumed at its snapshot if
+// it is still after the GC safe point, or a new progress at the snapshot TSO
+func resumeDumpProgress(
+	ctx context.Context,
+	extStorage storage.ExternalStorage,
+	tidbConfig *tidbsql.TiDBConfig,
+	snapshotTSO string,
+	compression utils.Compression,
+) (*dumpProgress, error) {
+	progress, err := readDumpProgress(ctx, extStorage)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	fresh := &dumpProgress{SnapshotTSO: snapshotTSO, Compression: compression, Tables: make(map[string]*dumpedTable)}
+	if progress == nil || len(progress.Tables) == 0 {
+		return fresh, nil
+	}
+	if progress.Compression != compression {
+		log.Warn("The unfinished dump is of another compression, dump all the tables again",
+			zap.String("compression", string(progress.Compression)))
+		return fresh, nil
+	}
+	if progress.SnapshotTSO != snapshotTSO {
+		// the tables are dumped at the same snapshot
+		tso, err := strconv.ParseUint(progress.SnapshotTSO, 10, 64)
+		if err == nil {
+			err = tidbsql.CheckGCSafePoint(tidbConfig, tso)
+		}
+		if err != nil {
+			log.Warn("The snapshot of the unfinished dump can not be read, dump all the tables again",
+				zap.String("snapshotTSO", progress.SnapshotTSO), zap.Error(err))
+			return fresh, nil
+		}
+	}
+	log.Info("Resume the unfinished dump", zap.String("snapshotTSO", progress.SnapshotTSO), zap.Int("dumpedTables", len(progress.Tables)))
+	return progress, nil
+}
+
+// ClearDumpProgress removes the progress of the unfinished dump, so that all the tables are dumped again
+func ClearDumpProgress(ctx context.Context, extStorage storage.ExternalStorage) error {
+	exists, err := extStorage.FileExists(ctx, DumpProgressFile)
+	if err != nil || !exists {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.DeleteFile(ctx, DumpProgressFile))
+}
@@ -0,0 +1,70 @@
+package dumpling
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeDumpProgress(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	progress, err := resumeDumpProgress(ctx, extStorage, nil, "100", utils.CompressionGzip)
+	require.NoError(t, err)
+	require.Equal(t, "100", progress.SnapshotTSO)
+	require.Empty(t, progress.Tables)
+
+	progress.Tables["db.t1"] = &dumpedTable{Files: []string{"db.t1.000000000.csv.gz"}, Rows: 10}
+	progress.Tables["db.t2"] = &dumpedTable{Files: []string{"db.t2.000000000.csv.gz"}, Rows: 5, SQL: "SELECT `id` FROM `db`.`t2`"}
+	require.NoError(t, progress.write(ctx, extStorage))
+
+	// resumed at the same snapshot
+	resumed, err := resumeDumpProgress(ctx, extStorage, nil, "100", utils.CompressionGzip)
+	require.NoError(t, err)
+	require.Equal(t, progress, resumed)
+
+	// the files of another compression are dumped again
+	resumed, err = resumeDumpProgress(ctx, extStorage, nil, "100", utils.CompressionZstd)
+	require.NoError(t, err)
+	require.Empty(t, resumed.Tables)
+
+	// the snapshot of the progress is not readable
+	progress.SnapshotTSO = "invalid"
+	require.NoError(t, progress.write(ctx, extStorage))
+	resumed, err = resumeDumpProgress(ctx, extStorage, nil, "200", utils.CompressionGzip)
+	require.NoError(t, err)
+	require.Equal(t, "200", resumed.SnapshotTSO)
+	require.Empty(t, resumed.Tables)
+
+	require.NoError(t, ClearDumpProgress(ctx, extStorage))
+	require.NoError(t, ClearDumpProgress(ctx, extStorage))
+	cleared, err := readDumpProgress(ctx, extStorage)
+	require.NoError(t, err)
+	require.Nil(t, cleared)
+}
+
+func TestCheckDumpedFiles(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(ctx, "db.t1.000000000.csv", []byte("1\n")))
+	progress := &dumpProgress{SnapshotTSO: "100", Tables: map[string]*dumpedTable{
+		"db.t1": {Files: []string{"db.t1.000000000.csv"}, Rows: 1},
+		"db.t2": {Files: []string{}},
+	}}
+	require.NoError(t, checkDumpedFiles(ctx, extStorage, progress, []string{"db.t1", "db.t2"}))
+
+	// a table missing a file is dumped again
+	progress.Tables["db.t2"] = &dumpedTable{Files: []string{"db.t2.000000000.csv"}, Rows: 1}
+	err = checkDumpedFiles(ctx, extStorage, progress, []string{"db.t1", "db.t2"})
+	require.ErrorContains(t, err, "The dumped file db.t2.000000000.csv of table db.t2 is missing")
+	recorded, err := readDumpProgress(ctx, extStorage)
+	require.NoError(t, err)
+	require.Contains(t, recorded.Tables, "db.t1")
+	require.NotContains(t, recorded.Tables, "db.t2")
+}
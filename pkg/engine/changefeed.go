@@ -0,0 +1,422 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// changefeedCheckInterval is how often the changefeed is checked by the monitor
+const changefeedCheckInterval = 30 * time.Second
+
+// ChangefeedFile is the file in the increment storage of each shard recording the changefeed created to write into
+// it, so that the changefeed is found by its ID on restart and by `tidb2dw remove`
+const ChangefeedFile = "changefeed.json"
+
+type changefeedFileData struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace,omitempty"`
+	// TiDBCloudCluster is the TiDB Cloud cluster of the changefeed created by its API in --mode=cloud, empty for
+	// the changefeed of the TiCDC server
+	TiDBCloudCluster string `json:"tidbcloud_cluster,omitempty"`
+	// Protocol is the protocol of the files written by the changefeed, empty for CSV written by an older tidb2dw
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// writeChangefeedFile records the changefeed writing into the increment storage of the shard
+func writeChangefeedFile(ctx context.Context, shardURI *url.URL, changefeed *cdc.Changefeed) error {
+	return errors.Trace(writeChangefeedRecord(ctx, shardURI, &changefeedFileData{ID: changefeed.ID, Namespace: changefeed.Namespace, Protocol: string(changefeed.Protocol)}))
+}
+
+func writeChangefeedRecord(ctx context.Context, shardURI *url.URL, record *changefeedFileData) error {
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, shardURI.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, ChangefeedFile, data))
+}
+
+// loadChangefeedFile returns the changefeed of the TiCDC server recorded in the increment storage of the shard, found
+// is false if there is none, e.g. of a workspace replicated by an older tidb2dw or through TiDB Cloud
+func loadChangefeedFile(ctx context.Context, shardURI *url.URL) (changefeed *cdc.Changefeed, found bool, err error) {
+	record, found, err := loadChangefeedRecord(ctx, shardURI)
+	if err != nil || !found || record.TiDBCloudCluster != "" {
+		return nil, false, errors.Trace(err)
+	}
+	return &cdc.Changefeed{ID: record.ID, Namespace: record.Namespace, Protocol: cdcreader.Protocol(record.Protocol)}, true, nil
+}
+
+// resolveCDCProtocol returns the protocol of the increment files written into the shards. It is the protocol of the
+// changefeed recorded if it is created by tidb2dw, otherwise --cdc-protocol or the protocol of the files found, and
+// CSV if no file is written yet.
+func resolveCDCProtocol(ctx context.Context, cfg *PipelineConfig, shardURIs []*url.URL) (cdcreader.Protocol, error) {
+	record, found, err := loadChangefeedRecord(ctx, shardURIs[0])
+	if err != nil {
+		return "", diag.Storage(errors.Trace(err))
+	}
+	if found {
+		protocol := cdcreader.Protocol(record.Protocol)
+		if protocol == "" {
+			protocol = cdcreader.ProtocolCSV
+		}
+		if cfg.CDCProtocol != "" && cfg.CDCProtocol != protocol {
+			return "", errors.Errorf("--cdc-protocol=%s conflicts with changefeed %s writing the files in %s, "+
+				"start again with --clean-workspace to replicate in another protocol", cfg.CDCProtocol, record.ID, protocol)
+		}
+		return protocol, nil
+	}
+	if cfg.CDCProtocol != "" {
+		return cfg.CDCProtocol, nil
+	}
+	extStorage, err := openStorage(ctx, cfg, shardURIs[0])
+	if err != nil {
+		return "", diag.Storage(errors.Trace(err))
+	}
+	protocol, found, err := cdcreader.DetectProtocol(ctx, extStorage)
+	if err != nil {
+		return "", diag.Storage(errors.Annotate(err, "Failed to detect the protocol of the increment files"))
+	}
+	if !found {
+		log.Info("No increment file is found, the files are read as CSV unless --cdc-protocol is set")
+		return cdcreader.ProtocolCSV, nil
+	}
+	log.Info("Detected the protocol of the increment files", zap.String("protocol", string(protocol)))
+	return protocol, nil
+}
+
+// loadChangefeedRecord returns the changefeed recorded in the increment storage of the shard, found is false if there
+// is none
+func loadChangefeedRecord(ctx context.Context, shardURI *url.URL) (record *changefeedFileData, found bool, err error) {
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, shardURI.String())
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	if found, err = extStorage.FileExists(ctx, ChangefeedFile); err != nil || !found {
+		return nil, false, errors.Trace(err)
+	}
+	content, err := extStorage.ReadFile(ctx, ChangefeedFile)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	record = new(changefeedFileData)
+	if err = json.Unmarshal(content, record); err != nil || record.ID == "" {
+		return nil, false, errors.Errorf("invalid changefeed file %s", ChangefeedFile)
+	}
+	return record, true, nil
+}
+
+// changefeedMonitor checks the changefeed writing into the increment storage, a stopped or failed changefeed
+// writes no more files and would stall the replication silently otherwise
+type changefeedMonitor struct {
+	client       *cdc.Client
+	incrementURI *url.URL
+	policy       cdc.RecoveryPolicy
+	status       *apiservice.APIInfo
+	// onNotRunning is called with the message once the changefeed is found stopped or failed, nil if not needed
+	onNotRunning func(msg string)
+
+	// changefeed is looked up on the first successful check
+	changefeed *cdc.Changefeed
+	// state is the state of the last check, the changes are logged
+	state   string
+	resumed int
+}
+
+// run checks the changefeed until ctx is done, an error is returned only if the changefeed is stopped or
+// failed with cdc.RecoveryFail
+func (m *changefeedMonitor) run(ctx context.Context) error {
+	ticker := time.NewTicker(changefeedCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.check(); err != nil {
+			return errors.Trace(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// check records the state and the lag of the changefeed in the status and recovers it by the policy,
+// the TiCDC server being unavailable is recorded only
+func (m *changefeedMonitor) check() error {
+	info := apiservice.ChangefeedInfo{Resumed: m.resumed, CheckedAt: time.Now()}
+	defer func() { m.status.SetChangefeedInfo(info) }()
+
+	if m.changefeed == nil {
+		changefeed, err := cdc.FindChangefeed(m.client, m.incrementURI)
+		if err != nil {
+			log.Warn("Failed to check changefeed", zap.Error(err))
+			info.Error = err.Error()
+			return nil
+		}
+		m.changefeed = changefeed
+	}
+	info.ID = m.changefeed.ID
+	cfStatus, err := cdc.GetChangefeedStatus(m.client, m.changefeed)
+	if err != nil {
+		log.Warn("Failed to check changefeed", zap.String("changefeed", m.changefeed.ID), zap.Error(err))
+		info.Error = err.Error()
+		return nil
+	}
+	info.State, info.CheckpointTSO, info.Error = cfStatus.State, cfStatus.CheckpointTSO, cfStatus.Error
+	if cfStatus.CheckpointTSO != 0 {
+		info.LagSeconds = max(info.CheckedAt.Sub(utils.TSOPhysicalTime(cfStatus.CheckpointTSO)).Seconds(), 0)
+	}
+	fields := []zap.Field{zap.String("changefeed", m.changefeed.ID), zap.String("state", cfStatus.State),
+		zap.Uint64("checkpointTSO", cfStatus.CheckpointTSO), zap.Float64("lagSeconds", info.LagSeconds), zap.String("error", cfStatus.Error)}
+	changed := cfStatus.State != m.state
+	if changed {
+		log.Info("Changefeed state changed", append(fields, zap.String("previousState", m.state))...)
+		m.state = cfStatus.State
+	}
+	if cfStatus.State != cdc.ChangefeedStateStopped && cfStatus.State != cdc.ChangefeedStateFailed {
+		return nil
+	}
+	msg := fmt.Sprintf("Changefeed %s is %s, no more increment files are written", m.changefeed.ID, cfStatus.State)
+	if cfStatus.Error != "" {
+		msg += ": " + cfStatus.Error
+	}
+	if changed && m.onNotRunning != nil {
+		m.onNotRunning(msg)
+	}
+
+	switch m.policy {
+	case cdc.RecoveryFail:
+		return diag.CDC(errors.New(msg))
+	case cdc.RecoveryResume:
+		if err := cdc.ResumeChangefeed(m.client, m.changefeed); err != nil {
+			log.Error("Failed to resume changefeed, retry on the next check", append(fields, zap.NamedError("resumeError", err))...)
+			return nil
+		}
+		m.resumed++
+		info.Resumed = m.resumed
+		log.Warn("Resumed changefeed", fields...)
+	default:
+		log.Warn("Changefeed is not running, no more increment files are written, see --changefeed-recovery", fields...)
+	}
+	return nil
+}
+
+// newCheckpointFetcher returns the fetcher of the checkpoint of the changefeeds writing into the increment
+// storage of the shards, which is the smallest of them. The changefeeds are looked up on the first successful fetch.
+func newCheckpointFetcher(client *cdc.Client, incrementURIs []*url.URL) apiservice.CheckpointFetcher {
+	var mu sync.Mutex
+	changefeeds := make([]*cdc.Changefeed, len(incrementURIs))
+	return func() (uint64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		minCheckpoint := uint64(0)
+		for i, incrementURI := range incrementURIs {
+			if changefeeds[i] == nil {
+				found, err := cdc.FindChangefeed(client, incrementURI)
+				if err != nil {
+					return 0, errors.Trace(err)
+				}
+				changefeeds[i] = found
+			}
+			checkpoint, err := cdc.GetChangefeedCheckpoint(client, changefeeds[i])
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			if i == 0 || checkpoint < minCheckpoint {
+				minCheckpoint = checkpoint
+			}
+		}
+		return minCheckpoint, nil
+	}
+}
+
+// checkCDCVersion queries the version of the TiCDC server and checks it against the compatibility matrix,
+// the releases not tested with this tidb2dw build are warned. The version is empty if the status API is unreachable,
+// the schema files are checked before parsing anyway.
+func checkCDCVersion(client *cdc.Client) (string, error) {
+	version, err := cdc.GetServerVersion(client)
+	if err != nil {
+		log.Warn("Failed to get TiCDC version, the version is not checked", zap.Error(err))
+		return "", nil
+	}
+	tested, err := cdc.CheckServerVersion(version)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if !tested {
+		releases := make([]string, 0, len(cdc.CompatibilityMatrix))
+		for _, c := range cdc.CompatibilityMatrix {
+			releases = append(releases, c.Release)
+		}
+		log.Warn("TiCDC version is not tested with this tidb2dw build, the schema files are still checked before parsing",
+			zap.String("version", version), zap.Strings("testedReleases", releases))
+	} else {
+		log.Info("TiCDC version is compatible", zap.String("version", version))
+	}
+	return version, nil
+}
+
+// pauseChangefeed pauses the changefeed writing into the increment storage, so that no more files
+// are written while tidb2dw is stopped
+func pauseChangefeed(client *cdc.Client, incrementURI *url.URL) error {
+	changefeed, err := cdc.FindChangefeed(client, incrementURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cdc.PauseChangefeed(client, changefeed); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Paused changefeed, it is resumed on restart", zap.String("changefeed", changefeed.ID))
+	return nil
+}
+
+// resumeChangefeed resumes the changefeed paused on the last exit
+func resumeChangefeed(client *cdc.Client, incrementURI *url.URL) error {
+	changefeed, err := cdc.FindChangefeed(client, incrementURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	state, err := cdc.GetChangefeedState(client, changefeed)
+	if err != nil || state != cdc.ChangefeedStateStopped {
+		return errors.Trace(err)
+	}
+	if err = cdc.ResumeChangefeed(client, changefeed); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Resumed changefeed", zap.String("changefeed", changefeed.ID))
+	return nil
+}
+
+// createChangefeeds creates the changefeed writing into the increment storage, or a changefeed per shard which filters
+// out the changes of the other shards by the hash of the primary key. The changefeed of the first shard is created
+// last, so its metadata tells all changefeeds are created. The changefeeds left by an interrupted creation are reused
+// if every shard records its changefeed in ChangefeedFile, they are replaced otherwise.
+func createChangefeeds(ctx context.Context, cfg *PipelineConfig, startTSO uint64, shardURIs []*url.URL) error {
+	reused, err := reuseChangefeeds(ctx, cfg, shardURIs)
+	if err != nil || reused {
+		return errors.Trace(err)
+	}
+	if cfg.TiDBConfig.TimeZone != "" {
+		// the changefeed has no time zone of its own
+		log.Info("TiCDC writes the TIMESTAMP values in the time zone of its server, which must run with the same --tz",
+			zap.String("tz", cfg.TiDBConfig.TimeZone))
+	}
+	var tablePKs map[string][]string
+	if len(shardURIs) > 1 {
+		tidbPool, err := cfg.TiDBConfig.OpenDB()
+		if err != nil {
+			return diag.Source(errors.Trace(err))
+		}
+		defer tidbPool.Close()
+		tablePKs = make(map[string][]string, len(cfg.Tables))
+		for _, table := range cfg.Tables {
+			sourceDatabase, sourceTable := utils.SplitTableFQN(table)
+			pkColumns, err := tidbsql.GetTiDBTablePKColumns(tidbPool, sourceDatabase, sourceTable)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			// the changes of a key are merged by one shard, a table without a primary key by its dedup key
+			tablePKs[table] = cfg.PKLess.Key(table, pkColumns)
+		}
+	}
+	// the tables matching --table-pattern later are written by the changefeed since they are created
+	rules := slices.Clone(cfg.Tables)
+	for _, pattern := range cfg.TablePatterns {
+		rules = append(rules, pattern.String())
+	}
+	for _, database := range cfg.Databases {
+		rules = append(rules, database+".*")
+	}
+	// the last rule matching a table decides
+	for _, pattern := range cfg.ExcludedTables {
+		rules = append(rules, "!"+pattern.String())
+	}
+	for i := len(shardURIs) - 1; i >= 0; i-- {
+		stale, err := cdc.FindChangefeedsWithin(cfg.CDC, shardURIs[i])
+		if err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		for _, changefeed := range stale {
+			if err = cdc.RemoveChangefeed(cfg.CDC, changefeed); err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+			log.Warn("Removed changefeed left by an interrupted start", zap.String("changefeed", changefeed.ID))
+		}
+		cdcConnector, err := cdc.NewCDCConnector(cfg.CDC, rules, startTSO, shardURIs[i], cfg.CDCFlushInterval, cfg.CDCFileSize)
+		if err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		if len(shardURIs) > 1 {
+			rules, err := cdc.ShardEventFilters(tablePKs, i, len(shardURIs))
+			if err != nil {
+				return diag.Schema(errors.Trace(err))
+			}
+			cdcConnector.SetEventFilters(rules)
+		}
+		if err = cdcConnector.SetServerCredentials(cfg.CDCServerCredentials); err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		if cfg.CDCProtocol != "" {
+			if err = cdcConnector.SetProtocol(cfg.CDCProtocol); err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+		}
+		cdcConnector.SetReplicaOverrides(cfg.ChangefeedConfig)
+		changefeed, err := cdcConnector.CreateChangefeed()
+		if err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		if err = writeChangefeedFile(ctx, shardURIs[i], changefeed); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to record the changefeed"))
+		}
+	}
+	if len(shardURIs) > 1 {
+		log.Info("Created changefeeds of the shards", zap.Int("shards", len(shardURIs)))
+	}
+	return nil
+}
+
+// reuseChangefeeds tells whether the changefeeds recorded in the increment storage of the shards all still write
+// into it, in which case they are reused instead of created again. They are not reused if --start-tso asks for
+// another start.
+func reuseChangefeeds(ctx context.Context, cfg *PipelineConfig, shardURIs []*url.URL) (bool, error) {
+	changefeeds := make([]*cdc.Changefeed, 0, len(shardURIs))
+	for _, shardURI := range shardURIs {
+		changefeed, found, err := loadChangefeedFile(ctx, shardURI)
+		if err != nil {
+			return false, diag.Storage(errors.Trace(err))
+		}
+		if !found {
+			return false, nil
+		}
+		startTSO, found, err := cdc.CheckChangefeed(cfg.CDC, changefeed, shardURI)
+		if err != nil {
+			return false, diag.CDC(errors.Trace(err))
+		}
+		if !found || (cfg.StartTSO != 0 && startTSO != cfg.StartTSO) {
+			return false, nil
+		}
+		changefeeds = append(changefeeds, changefeed)
+	}
+	for _, changefeed := range changefeeds {
+		log.Info("Reused changefeed recorded in the workspace", zap.String("changefeed", changefeed.ID))
+	}
+	return true, nil
+}
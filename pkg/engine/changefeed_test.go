@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCDCVersion(t *testing.T) {
+	version := "v7.5.1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"version":"` + version + `"}`))
+	}))
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	got, err := checkCDCVersion(client)
+	require.NoError(t, err)
+	require.Equal(t, "v7.5.1", got)
+	version = "v6.5.3"
+	_, err = checkCDCVersion(client)
+	require.ErrorContains(t, err, "TiCDC v6.5.3 is not supported")
+
+	// the replication goes on with the version unknown when the status API is unreachable
+	server.Close()
+	got, err = checkCDCVersion(client)
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
@@ -0,0 +1,358 @@
+package engine
+
+import (
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/notify"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/thediveo/enumflag"
+)
+
+// RunMode is which parts of the replication are run, it is the --mode flag of the commands
+type RunMode enumflag.Flag
+
+const (
+	RunModeFull RunMode = iota
+	RunModeSnapshotOnly
+	RunModeIncrementalOnly
+	RunModeCloud
+)
+
+var RunModeIds = map[RunMode][]string{
+	RunModeFull:            {"full"},
+	RunModeSnapshotOnly:    {"snapshot-only"},
+	RunModeIncrementalOnly: {"incremental-only"},
+	RunModeCloud:           {"cloud"},
+}
+
+// IncrementOptions are the global settings of the incremental workers, a table can override them
+// in the config file and at runtime by POST /tables/{table}/config
+type IncrementOptions struct {
+	ConfigFile string
+	// Workers caps the workers of all tables, 0 means no cap
+	Workers int
+	// MergeInterval is the interval between two rounds of merging a table, independent of the flush interval of
+	// TiCDC, 0 means a fifth of it
+	MergeInterval time.Duration
+	// Concurrency caps the files loaded into the data warehouse at the same time across the tables, 0 means no cap
+	Concurrency int
+	// Cleanup is how the increment files are deleted after they are merged
+	Cleanup replicate.CleanupPolicy
+	// Batch is how the new increment files of a table are accumulated before they are merged
+	Batch replicate.BatchPolicy
+	// SchemaDrift is how the tables in the data warehouse are checked against the columns replicated
+	SchemaDrift replicate.SchemaDriftPolicy
+	// SuspendWarehouseWhenIdle suspends the data warehouse once no file is loaded for the duration, 0 never suspends it
+	SuspendWarehouseWhenIdle time.Duration
+	// Shards splits the changes of each table by the hash of the primary key across the changefeeds, each writing into
+	// a shard of the increment storage merged concurrently, 0 or 1 means a single changefeed
+	Shards int
+}
+
+// SnapshotValidationOptions are how the snapshot loaded into the data warehouse is compared with TiDB at the snapshot TSO
+type SnapshotValidationOptions struct {
+	Enabled bool
+	// Checksum also compares the sums of the primary key and a few other integer and decimal columns
+	Checksum bool
+}
+
+// PipelineConfig is the configuration of a pipeline, the connectors are created by the caller
+// so that the replication can be run against any data warehouse.
+type PipelineConfig struct {
+	TiDBConfig          *tidbsql.TiDBConfig
+	Tables              []string
+	StorageURI          *url.URL
+	SnapshotConcurrency int
+	// CDC is the client of the HTTP API of TiCDC
+	CDC              *cdc.Client
+	CDCFlushInterval time.Duration
+	CDCFileSize      int64
+	// RateLimiters limit the files the pipeline writes into and reads from the storage, nil is unlimited
+	RateLimiters *ratelimit.Limiters
+	// CDCServerCredentials creates the changefeeds with no credentials in their sink URIs, the TiCDC servers access
+	// the storage by their own
+	CDCServerCredentials bool
+	// CDCProtocol is the protocol of the files written by the changefeeds, empty to use the protocol of the changefeed
+	// recorded or of the files found in the storage
+	CDCProtocol cdcreader.Protocol
+	// ChangefeedConfig are the options of --changefeed-config merged into the changefeeds created by tidb2dw, nil
+	// if not set
+	ChangefeedConfig cdc.ReplicaOverrides
+	// SnapshotCompression and IncrementCompression are the codecs of the files in the storage, empty for none
+	SnapshotCompression  utils.Compression
+	IncrementCompression utils.Compression
+	// StagingFormat is the format of the files loaded into the data warehouse, the CSV files are converted into the
+	// Parquet files before they are loaded with stagingformat.Parquet. Empty for CSV.
+	StagingFormat stagingformat.Format
+	// DumpChunkConfig is how the snapshot is split into files
+	DumpChunkConfig *dumpling.ChunkConfig
+	// PipelinedSnapshot loads the snapshot files of each table as soon as they are dumped
+	PipelinedSnapshot bool
+	// ForceRedump dumps all the tables of the snapshot again instead of resuming the unfinished dump
+	ForceRedump bool
+	// SnapshotValidation compares the loaded snapshot with TiDB before the table is recorded loaded
+	SnapshotValidation SnapshotValidationOptions
+	// SnapshotOrder is the order the snapshots are loaded in and the statements executed once all are loaded
+	SnapshotOrder    SnapshotOrderOptions
+	IncrementOptions IncrementOptions
+	// ColumnMapping overrides the types of the columns in the data warehouse, which is applied by the connectors,
+	// nil if --column-mapping is not set
+	ColumnMapping columnmapping.Mapping
+	// ColumnFilter is the columns of the tables replicated, which is applied by the dump and the connectors,
+	// nil if --column-filter is not set
+	ColumnFilter columnfilter.Config
+	// Where is the predicates of the rows of the tables replicated by the table FQN, which is applied by the dump
+	// and the connectors, nil if --where is not set
+	Where map[string]string
+	// PKLess is how the tables without a primary key are replicated, by their --dedup-key or --pkless-mode. The zero
+	// Policy refuses them.
+	PKLess pkless.Policy
+	// FieldLimitConfig is nil if the field limits are not checked
+	FieldLimitConfig *fieldlimit.Config
+	UnknownDDLPolicy tidbsql.UnknownDDLPolicy
+	RenamePolicy     tidbsql.RenamePolicy
+	// RenameCheck checks the source table a table is renamed to before the rename is followed, nil if any rename
+	// is followed
+	RenameCheck func(from, to string) error
+	// UnsupportedDDLPolicy is how the DDL the data warehouse can not apply is handled, "" fails the replication
+	UnsupportedDDLPolicy tidbsql.UnsupportedDDLPolicy
+	// AllowNewTables replicates the tables created in the databases of Tables after the changefeed starts,
+	// their increment connectors are created by NewIncreConnector
+	AllowNewTables    bool
+	NewIncreConnector func(table string) (coreinterfaces.Connector, error)
+	// TablePatterns are the patterns of --table-pattern, the tables matching them when the replication starts are
+	// in Tables, and those found later are replicated with the connectors of NewSnapConnector and NewIncreConnector
+	TablePatterns []tidbsql.TablePattern
+	// TablePatternInterval is how often the tables of TiDB are checked against TablePatterns
+	TablePatternInterval time.Duration
+	// RemovedTablePolicy is what is done to the table in the data warehouse when a table matching TablePatterns is
+	// dropped in TiDB
+	RemovedTablePolicy replicate.RemovedTablePolicy
+	// Databases are the databases of --database, their tables found when the replication starts are in Tables and
+	// recorded in DatabaseTablesFile, so a restart replicates the same tables. The changefeed filter includes the
+	// whole databases.
+	Databases []string
+	// ExcludedTables are the patterns of --exclude-tables, the tables matching them are excluded from the changefeed
+	// filter, and skipped with a warning if their files are found anyway
+	ExcludedTables []tidbsql.TablePattern
+	// NewSnapConnector creates the snapshot connector of a table found by TablePatterns, whose snapshot is dumped
+	// into snapshotURI
+	NewSnapConnector func(table string, snapshotURI *url.URL) (coreinterfaces.Connector, error)
+	// StartTSO is where the changefeed of --mode=incremental-only starts, 0 for now
+	StartTSO uint64
+	// PauseChangefeedOnExit pauses the changefeed on SIGINT or SIGTERM and resumes it on restart
+	PauseChangefeedOnExit bool
+	// CleanWorkspace removes the changefeeds writing into the storage and the files left by a previous replication
+	// before the replication starts fresh
+	CleanWorkspace bool
+	// TiDBCloud creates the changefeed through the TiDB Cloud API in --mode=cloud, nil if the changefeed is managed
+	// outside of tidb2dw
+	TiDBCloud *tidbcloud.Config
+	// CloudExport exports the snapshot by TiDB Cloud in --mode=cloud with TiDBCloud
+	CloudExport bool
+	// ForceUnlock takes the lock of the storage held by another process whose heartbeat is stale
+	ForceUnlock bool
+	// ChangefeedRecovery is what to do when the changefeed is found stopped or failed, empty for cdc.RecoveryNone
+	ChangefeedRecovery cdc.RecoveryPolicy
+	// RetryPolicy is how the operations of the connectors failed with a transient error are retried, zero never
+	// retries
+	RetryPolicy retry.Policy
+	// WarehouseSuspender suspends the data warehouse with IncrementOptions.SuspendWarehouseWhenIdle, nil if the data
+	// warehouse can not be suspended
+	WarehouseSuspender coreinterfaces.WarehouseSuspender
+	// StatusFileInterval is how often the status is written into StatusFileName in the storage, 0 disables it
+	StatusFileInterval time.Duration
+	SnapConnectorMap   map[string]coreinterfaces.Connector
+	IncreConnectorMap  map[string]coreinterfaces.Connector
+	Mode               RunMode
+	// DryRun calls the connectors, which record the statements instead of executing them, without touching
+	// the storage and TiCDC
+	DryRun bool
+	// Status receives the status of the replication, e.g. to serve it by the API service, a new one is
+	// created by NewPipeline if nil. Each pipeline needs its own.
+	Status *apiservice.APIInfo
+	// Notifier is sent the lifecycle events of the replication, nil sends nothing. It is closed by the caller.
+	Notifier *notify.Notifier
+}
+
+// validate checks the options are available in the mode
+func (cfg *PipelineConfig) validate() error {
+	mode := cfg.Mode
+	if len(cfg.Tables) == 0 {
+		return errors.New("no table to replicate")
+	}
+	if cfg.StorageURI == nil {
+		return errors.New("no storage to replicate through")
+	}
+	if cfg.IncrementCompression != utils.CompressionNone && mode != RunModeCloud && mode != RunModeSnapshotOnly {
+		return errors.New("TiCDC cloud storage sink does not compress files, --increment-compression is only available in --mode=cloud")
+	}
+	if cfg.StartTSO != 0 && mode != RunModeIncrementalOnly {
+		return errors.New("--start-tso is only available in --mode=incremental-only")
+	}
+	if cfg.AllowNewTables && mode == RunModeSnapshotOnly {
+		return errors.New("--allow-new-tables is not available in --mode=snapshot-only")
+	}
+	if cfg.PauseChangefeedOnExit && (mode == RunModeSnapshotOnly || mode == RunModeCloud) {
+		return errors.New("--pause-changefeed-on-exit is only available when the changefeed is managed by tidb2dw, in --mode=full or incremental-only")
+	}
+	if cfg.CleanWorkspace && mode == RunModeCloud {
+		return errors.New("--clean-workspace is not available in --mode=cloud, the changefeed is managed outside of tidb2dw")
+	}
+	if cfg.TiDBCloud != nil {
+		if mode != RunModeCloud {
+			return errors.New("--tidbcloud.* are only available in --mode=cloud")
+		}
+		if err := cfg.TiDBCloud.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.CloudExport && cfg.TiDBCloud == nil {
+		return errors.New("--tidbcloud.export-snapshot requires the changefeed created through the TiDB Cloud API by --tidbcloud.public-key, --tidbcloud.private-key and --tidbcloud.cluster-id")
+	}
+	if cfg.CleanWorkspace && cfg.DryRun {
+		return errors.New("--clean-workspace is not available with --dry-run")
+	}
+	if cfg.ChangefeedConfig != nil && (mode == RunModeSnapshotOnly || mode == RunModeCloud) {
+		return errors.New("--changefeed-config is only available when the changefeed is managed by tidb2dw, in --mode=full or incremental-only")
+	}
+	if cfg.CDCProtocol != "" && mode == RunModeSnapshotOnly {
+		return errors.New("--cdc-protocol is not available in --mode=snapshot-only")
+	}
+	if cfg.CDCProtocol == cdcreader.ProtocolCanalJSON && cfg.TiDBCloud != nil {
+		return errors.New("--cdc-protocol=canal-json is not available with the changefeed created through the TiDB Cloud API, which writes CSV files")
+	}
+	if cfg.ChangefeedRecovery != "" && cfg.ChangefeedRecovery != cdc.RecoveryNone && (mode == RunModeSnapshotOnly || mode == RunModeCloud) {
+		return errors.New("--changefeed-recovery is only available when the changefeed is managed by tidb2dw, in --mode=full or incremental-only")
+	}
+	if cfg.PipelinedSnapshot && (mode == RunModeIncrementalOnly || mode == RunModeCloud) {
+		return errors.New("--pipelined-snapshot is only available when the snapshot is dumped by tidb2dw, in --mode=full or snapshot-only")
+	}
+	if cfg.ForceRedump && (mode == RunModeIncrementalOnly || mode == RunModeCloud) {
+		return errors.New("--force-redump is only available when the snapshot is dumped by tidb2dw, in --mode=full or snapshot-only")
+	}
+	if cfg.SnapshotValidation.Checksum && !cfg.SnapshotValidation.Enabled {
+		return errors.New("--validate-snapshot-checksum is only available with --validate-snapshot")
+	}
+	if cfg.SnapshotValidation.Enabled && mode == RunModeIncrementalOnly {
+		return errors.New("--validate-snapshot is not available in --mode=incremental-only")
+	}
+	if err := cfg.validateSnapshotOrder(); err != nil {
+		return errors.Trace(err)
+	}
+	for _, table := range cfg.Tables {
+		if mode != RunModeIncrementalOnly && cfg.SnapConnectorMap[table] == nil {
+			return errors.Errorf("no snapshot connector of table %s", table)
+		}
+		if mode != RunModeSnapshotOnly && cfg.IncreConnectorMap[table] == nil {
+			return errors.Errorf("no increment connector of table %s", table)
+		}
+	}
+	if cfg.IncrementOptions.MergeInterval < 0 {
+		return errors.Errorf("invalid --merge-interval %s", cfg.IncrementOptions.MergeInterval)
+	}
+	if err := cfg.IncrementOptions.Batch.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := cfg.IncrementOptions.SchemaDrift.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := cfg.RetryPolicy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DumpChunkConfig != nil {
+		if err := cfg.DumpChunkConfig.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.IncrementOptions.SuspendWarehouseWhenIdle < 0 {
+		return errors.Errorf("invalid --suspend-warehouse-when-idle %s", cfg.IncrementOptions.SuspendWarehouseWhenIdle)
+	}
+	if cfg.IncrementOptions.SuspendWarehouseWhenIdle > 0 && cfg.WarehouseSuspender == nil {
+		return errors.New("--suspend-warehouse-when-idle is not supported by the data warehouse")
+	}
+	if cfg.AllowNewTables && cfg.NewIncreConnector == nil {
+		return errors.New("no increment connector of the tables created with --allow-new-tables")
+	}
+	if len(cfg.TablePatterns) > 0 {
+		if mode == RunModeCloud {
+			return errors.New("--table-pattern is not available in --mode=cloud, the changefeed managed outside of tidb2dw may not match the tables found")
+		}
+		if mode != RunModeSnapshotOnly {
+			if cfg.TablePatternInterval <= 0 {
+				return errors.Errorf("invalid --table-pattern-interval %s", cfg.TablePatternInterval)
+			}
+			if _, err := replicate.ParseRemovedTablePolicy(string(cfg.RemovedTablePolicy)); err != nil {
+				return errors.Trace(err)
+			}
+			if cfg.NewIncreConnector == nil || (mode == RunModeFull && cfg.NewSnapConnector == nil) {
+				return errors.New("no connector of the tables found by --table-pattern")
+			}
+		}
+	}
+	if cfg.IncrementOptions.Shards < 0 {
+		return errors.Errorf("invalid --increment-shards %d", cfg.IncrementOptions.Shards)
+	}
+	if cfg.IncrementOptions.Shards > 1 {
+		if mode == RunModeCloud {
+			return errors.New("--increment-shards is only available when the changefeeds are managed by tidb2dw, in --mode=full or incremental-only")
+		}
+		if cfg.AllowNewTables {
+			return errors.New("--increment-shards is not available with --allow-new-tables, the primary keys of the tables created are unknown")
+		}
+		if len(cfg.TablePatterns) > 0 {
+			return errors.New("--increment-shards is not available with --table-pattern, the primary keys of the tables found are unknown")
+		}
+	}
+	return nil
+}
+
+// validateSnapshotOrder checks --load-order and --post-snapshot-sql
+func (cfg *PipelineConfig) validateSnapshotOrder() error {
+	order, err := ParseLoadOrder(cfg.SnapshotOrder.LoadOrder)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if order == nil && cfg.SnapshotOrder.PostSnapshotSQL == "" {
+		return nil
+	}
+	if cfg.Mode == RunModeIncrementalOnly {
+		return errors.New("--load-order and --post-snapshot-sql are not available in --mode=incremental-only")
+	}
+	if order != nil {
+		if order.Heuristic != "" && cfg.TiDBConfig == nil {
+			return errors.Errorf("--load-order=%s requires the connection to TiDB for the sizes of the tables", order.Heuristic)
+		}
+		for _, table := range order.Tables {
+			if !slices.Contains(cfg.Tables, table) {
+				return errors.Errorf("table %s of --load-order is not replicated", table)
+			}
+		}
+	}
+	if cfg.SnapshotOrder.PostSnapshotSQL != "" {
+		for _, table := range cfg.Tables {
+			if _, ok := cfg.SnapConnectorMap[table].(coreinterfaces.StatementExecutor); !ok {
+				return errors.New("--post-snapshot-sql is not supported by the data warehouse")
+			}
+		}
+	}
+	return nil
+}
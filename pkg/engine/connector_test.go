@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+)
+
+// fakeConnector is never called, the pipelines of the tests are stopped or driven stage by stage before they
+// touch the data warehouse
+type fakeConnector struct {
+	coreinterfaces.Connector
+}
+
+// newTestConfig returns the config of a full replication of test.t to fake connectors, staged in a temporary
+// directory
+func newTestConfig(t *testing.T) PipelineConfig {
+	return PipelineConfig{
+		Tables:            []string{"test.t"},
+		StorageURI:        &url.URL{Scheme: "file", Path: t.TempDir()},
+		SnapConnectorMap:  map[string]coreinterfaces.Connector{"test.t": fakeConnector{}},
+		IncreConnectorMap: map[string]coreinterfaces.Connector{"test.t": fakeConnector{}},
+		Mode:              RunModeFull,
+	}
+}
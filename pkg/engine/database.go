@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// DatabaseTablesFile is the file in the increment storage recording the tables of --database found when the
+// replication starts, so that a restart replicates the same tables whatever is created or dropped in TiDB meanwhile
+const DatabaseTablesFile = "database_tables.json"
+
+type databaseTablesData struct {
+	Tables []string `json:"tables"`
+}
+
+// freezeDatabaseTables records the tables of --database when the replication starts. On restart, the tables of the
+// databases not recorded are created while tidb2dw was stopped, they are left out unless they match --table-pattern,
+// whose tables are watched instead.
+func freezeDatabaseTables(ctx context.Context, cfg *PipelineConfig, incrementURI *url.URL, stage Stage) error {
+	incrementStorage, err := openStorage(ctx, cfg, incrementURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if stage == StageInit {
+		var data databaseTablesData
+		for _, table := range cfg.Tables {
+			if database, _ := utils.SplitTableFQN(table); slices.Contains(cfg.Databases, database) {
+				data.Tables = append(data.Tables, table)
+			}
+		}
+		content, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return diag.Storage(errors.Annotate(incrementStorage.WriteFile(ctx, DatabaseTablesFile, content), "Failed to record the tables of --database"))
+	}
+	found, err := incrementStorage.FileExists(ctx, DatabaseTablesFile)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if !found {
+		return errors.New("--database is set on a replication started without it, the tables replicated before are unknown, " +
+			"set --clean-workspace to start over")
+	}
+	content, err := incrementStorage.ReadFile(ctx, DatabaseTablesFile)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	var data databaseTablesData
+	if err = json.Unmarshal(content, &data); err != nil {
+		return errors.Annotatef(err, "invalid database tables %s", DatabaseTablesFile)
+	}
+	kept, ignored := splitDatabaseTables(cfg.Tables, cfg.Databases, cfg.TablePatterns, data.Tables)
+	if len(ignored) > 0 {
+		log.Warn("Found tables of --database not replicated when the replication started, they are not replicated, "+
+			"use --table-pattern to replicate the tables created later", zap.Strings("tables", ignored))
+	}
+	cfg.Tables = kept
+	return nil
+}
+
+// splitDatabaseTables splits the tables on restart by the tables of the databases recorded, the tables of the
+// databases not recorded are ignored unless they match the patterns. The tables recorded but dropped since are
+// neither.
+func splitDatabaseTables(tables, databases []string, patterns []tidbsql.TablePattern, recorded []string) (kept, ignored []string) {
+	for _, table := range tables {
+		database, _ := utils.SplitTableFQN(table)
+		if !slices.Contains(databases, database) || slices.Contains(recorded, table) || tidbsql.MatchAnyTablePattern(patterns, table) {
+			kept = append(kept, table)
+		} else {
+			ignored = append(ignored, table)
+		}
+	}
+	return kept, ignored
+}
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreezeDatabaseTables(t *testing.T) {
+	ctx := context.Background()
+	incrementURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	cfg := &PipelineConfig{
+		Tables:    []string{"db.orders", "db.items", "other.t"},
+		Databases: []string{"db"},
+	}
+
+	// a workspace replicated without --database is not adopted
+	err := freezeDatabaseTables(ctx, cfg, incrementURI, StageSnapshotDumped)
+	require.ErrorContains(t, err, "--database is set on a replication started without it")
+
+	require.NoError(t, freezeDatabaseTables(ctx, cfg, incrementURI, StageInit))
+	require.Equal(t, []string{"db.orders", "db.items", "other.t"}, cfg.Tables)
+
+	// on restart, the tables created in the database meanwhile are left out unless they match --table-pattern,
+	// and a table dropped is gone
+	cfg.Tables = []string{"db.orders", "db.new", "db.events_1", "other.t", "other.new"}
+	cfg.TablePatterns = []tidbsql.TablePattern{{Database: "db", Table: "events_*"}}
+	require.NoError(t, freezeDatabaseTables(ctx, cfg, incrementURI, StageSnapshotDumped))
+	require.Equal(t, []string{"db.orders", "db.events_1", "other.t", "other.new"}, cfg.Tables)
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// EventsDir is the directory of the storage path the events of the replication are written into for post-mortems,
+// a file of JSON lines per day, e.g. events/2024-01-01.jsonl, named by the UTC date of the events
+const EventsDir = "events"
+
+const eventsFlushInterval = time.Minute
+
+// EventsFileName returns the file of the events of the UTC date of t
+func EventsFileName(t time.Time) string {
+	return path.Join(EventsDir, t.UTC().Format(time.DateOnly)+".jsonl")
+}
+
+// eventsFileWriter appends the events published to the bus into the files of EventsDir every interval until it
+// is closed
+type eventsFileWriter struct {
+	bus     *apiservice.EventBus
+	storage storage.ExternalStorage
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startEventsFile(bus *apiservice.EventBus, storage storage.ExternalStorage, interval time.Duration) *eventsFileWriter {
+	w := &eventsFileWriter{
+		bus:     bus,
+		storage: storage,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.flush()
+			}
+		}
+	}()
+	return w
+}
+
+// close stops the periodic writes and writes the events left, e.g. the error the pipeline returns
+func (w *eventsFileWriter) close() {
+	close(w.stop)
+	<-w.done
+	w.flush()
+}
+
+// flush appends the events published since the last flush, a failure is only logged and the events are dropped
+// since the replication does not depend on the files
+func (w *eventsFileWriter) flush() {
+	events := w.bus.TakePending()
+	if len(events) == 0 {
+		return
+	}
+	// the events are appended in order, they may span midnight
+	var names []string
+	lines := make(map[string]*bytes.Buffer)
+	for _, event := range events {
+		name := EventsFileName(event.Time)
+		if lines[name] == nil {
+			names = append(names, name)
+			lines[name] = new(bytes.Buffer)
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Warn("Failed to marshal event", zap.Error(err))
+			continue
+		}
+		lines[name].Write(data)
+		lines[name].WriteByte('\n')
+	}
+	// the last events are written after the context of the pipeline is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), statusFileWriteTimeout)
+	defer cancel()
+	for _, name := range names {
+		if err := appendFile(ctx, w.storage, name, lines[name].Bytes()); err != nil {
+			log.Warn("Failed to write events file", zap.String("file", name), zap.Error(err))
+		}
+	}
+}
+
+// appendFile appends the data to the file, the external storages can not append so the file is replaced as a whole
+func appendFile(ctx context.Context, storage storage.ExternalStorage, name string, data []byte) error {
+	exists, err := storage.FileExists(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if exists {
+		existing, err := storage.ReadFile(ctx, name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data = append(existing, data...)
+	}
+	return errors.Trace(storage.WriteFile(ctx, name, data))
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func readEventsFile(t *testing.T, storageURI *url.URL, name string) []apiservice.Event {
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), storageURI.String())
+	require.NoError(t, err)
+	data, err := storage.ReadFile(context.Background(), name)
+	require.NoError(t, err)
+	var events []apiservice.Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event apiservice.Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventBus(t *testing.T) {
+	bus := apiservice.NewEventBus(3)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		bus.Publish(apiservice.Event{Kind: apiservice.EventKindBatchMerged, Table: []string{"test.a", "test.b"}[i%2], Files: i, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	// the oldest events are dropped
+	events := bus.Events(time.Time{}, "")
+	require.Len(t, events, 3)
+	require.Equal(t, []int{2, 3, 4}, []int{events[0].Files, events[1].Files, events[2].Files})
+	events = bus.Events(start.Add(2*time.Second), "test.b")
+	require.Len(t, events, 1)
+	require.Equal(t, 3, events[0].Files)
+	require.Len(t, bus.TakePending(), 3)
+	require.Empty(t, bus.TakePending())
+
+	event := apiservice.ErrorEvent("test.a", diag.Warehouse(errors.New("password=secret is wrong")))
+	require.Equal(t, "password=xxxxx is wrong", event.Error)
+	require.Equal(t, diag.CategoryWarehouse, event.Category)
+	require.Contains(t, event.Stack, "TestEventBus")
+	require.Empty(t, apiservice.ErrorEvent("", diag.Warehouse(stderrors.New("failed"))).Stack)
+}
+
+func TestEventsFile(t *testing.T) {
+	cfg := newTestConfig(t)
+	storageURI := cfg.StorageURI
+	p, err := NewPipeline(cfg)
+	require.NoError(t, err)
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), storageURI.String())
+	require.NoError(t, err)
+
+	p.setStage(StageSnapshotDumped)
+	p.setStage(StageSnapshotDumped)
+	p.status.SetTableStage("test.t", apiservice.TableStageLoadingIncremental)
+	p.status.SetTableStage("test.t", apiservice.TableStageLoadingIncremental)
+	w := startEventsFile(p.status.Events(), storage, time.Hour)
+	w.flush()
+	// the events are appended to the file of the day
+	day := time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC)
+	p.status.PublishEvent(apiservice.Event{Kind: apiservice.EventKindDDLApplied, Table: "test.t", Time: day, Query: "ALTER TABLE t ADD COLUMN c INT"})
+	p.status.PublishEvent(apiservice.Event{Kind: apiservice.EventKindRetry, Table: "test.t", Time: day.Add(time.Minute), Attempt: 1})
+	p.status.SetTableFatalError("test.t", errors.New("failed"))
+	w.close()
+
+	events := readEventsFile(t, storageURI, EventsFileName(time.Now()))
+	require.Len(t, events, 3)
+	require.Equal(t, apiservice.EventKindStage, events[0].Kind)
+	require.Equal(t, string(StageSnapshotDumped), events[0].Stage)
+	require.Empty(t, events[0].Table)
+	require.Equal(t, string(apiservice.TableStageLoadingIncremental), events[1].Stage)
+	require.Equal(t, "test.t", events[1].Table)
+	require.Equal(t, apiservice.EventKindError, events[2].Kind)
+	require.Equal(t, "failed", events[2].Error)
+
+	events = readEventsFile(t, storageURI, "events/2024-01-01.jsonl")
+	require.Len(t, events, 1)
+	require.Equal(t, "ALTER TABLE t ADD COLUMN c INT", events[0].Query)
+	events = readEventsFile(t, storageURI, "events/2024-01-02.jsonl")
+	require.Len(t, events, 1)
+	require.Equal(t, apiservice.EventKindRetry, events[0].Kind)
+}
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// SnapshotOrderOptions are the order the snapshots of the tables are loaded in, and the statements executed in the
+// data warehouse once all of them are loaded, e.g. to swap the views to the new tables
+type SnapshotOrderOptions struct {
+	// LoadOrder is --load-order, see ParseLoadOrder, empty loads the snapshots of all tables at once
+	LoadOrder string
+	// PostSnapshotSQL is the file of the statements separated by semicolons, empty for none
+	PostSnapshotSQL string
+}
+
+const (
+	LoadOrderSmallFirst = "small-first"
+	LoadOrderLargeFirst = "large-first"
+)
+
+// LoadOrder is the order the snapshots of the tables are loaded in, one table at a time
+type LoadOrder struct {
+	// Heuristic is LoadOrderSmallFirst or LoadOrderLargeFirst by the sizes of the tables in the statistics of TiDB,
+	// empty for Tables
+	Heuristic string
+	// Tables are loaded first in order, the other tables after them in the order they are given
+	Tables []string
+}
+
+// ParseLoadOrder parses small-first, large-first or the comma separated tables in <db>.<table>, nil if s is empty
+func ParseLoadOrder(s string) (*LoadOrder, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return nil, nil
+	case LoadOrderSmallFirst, LoadOrderLargeFirst:
+		return &LoadOrder{Heuristic: strings.ToLower(strings.TrimSpace(s))}, nil
+	}
+	order := &LoadOrder{}
+	for _, table := range strings.Split(s, ",") {
+		table = strings.TrimSpace(table)
+		if sourceDatabase, sourceTable := utils.SplitTableFQN(table); sourceDatabase == "" || sourceTable == "" {
+			return nil, errors.Errorf("invalid load order %s, expected small-first, large-first or the tables in <db>.<table>", s)
+		}
+		if slices.Contains(order.Tables, table) {
+			return nil, errors.Errorf("invalid load order %s, table %s is given twice", s, table)
+		}
+		order.Tables = append(order.Tables, table)
+	}
+	return order, nil
+}
+
+// sort returns the tables in the order, sizes are the bytes of the tables
+func (o *LoadOrder) sort(tables []string, sizes map[string]int64) []string {
+	sorted := slices.Clone(tables)
+	switch o.Heuristic {
+	case LoadOrderSmallFirst:
+		slices.SortStableFunc(sorted, func(a, b string) int { return cmp.Compare(sizes[a], sizes[b]) })
+	case LoadOrderLargeFirst:
+		slices.SortStableFunc(sorted, func(a, b string) int { return cmp.Compare(sizes[b], sizes[a]) })
+	default:
+		rank := func(table string) int {
+			if i := slices.Index(o.Tables, table); i >= 0 {
+				return i
+			}
+			return len(o.Tables)
+		}
+		slices.SortStableFunc(sorted, func(a, b string) int { return rank(a) - rank(b) })
+	}
+	return sorted
+}
+
+// snapshotQueue lets the snapshots of the tables be loaded one at a time in the order of --load-order, and
+// reports the position of each table waiting and when it is estimated to start by GET /status. The start is
+// estimated by the sizes of the tables ahead and the throughput of the snapshots loaded so far.
+type snapshotQueue struct {
+	mu     sync.Mutex
+	status *apiservice.APIInfo
+	sizes  map[string]int64
+	// waiting are the tables not loaded yet in order, the first is loading once started is set
+	waiting []string
+	started time.Time
+	// loadedBytes and loadedTime are of the snapshots loaded by the queue
+	loadedBytes int64
+	loadedTime  time.Duration
+	// changed is closed and replaced when a table is done
+	changed chan struct{}
+}
+
+func newSnapshotQueue(tables []string, sizes map[string]int64, status *apiservice.APIInfo) *snapshotQueue {
+	q := &snapshotQueue{status: status, sizes: sizes, waiting: tables, changed: make(chan struct{})}
+	q.report()
+	return q
+}
+
+// wait blocks until it is the turn of the table
+func (q *snapshotQueue) wait(ctx context.Context, table string) error {
+	for {
+		q.mu.Lock()
+		if len(q.waiting) > 0 && q.waiting[0] == table {
+			q.started = time.Now()
+			q.report()
+			q.mu.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// done removes the table from the queue whether its snapshot is loaded or failed, so the next table starts
+func (q *snapshotQueue) done(table string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := slices.Index(q.waiting, table)
+	if i < 0 {
+		return
+	}
+	if i == 0 && !q.started.IsZero() {
+		q.loadedBytes += q.sizes[table]
+		q.loadedTime += time.Since(q.started)
+		q.started = time.Time{}
+	}
+	q.waiting = slices.Delete(q.waiting, i, i+1)
+	q.status.SetTableSnapshotQueue(table, nil)
+	q.report()
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// report sets the position and the estimated start of the tables waiting, it is called with mu held
+func (q *snapshotQueue) report() {
+	var bytesPerSecond float64
+	if q.loadedTime > 0 {
+		bytesPerSecond = float64(q.loadedBytes) / q.loadedTime.Seconds()
+	}
+	now := time.Now()
+	start := q.started
+	if start.IsZero() {
+		start = now
+	}
+	var ahead int64
+	for position, table := range q.waiting {
+		info := &apiservice.SnapshotQueueInfo{Position: position}
+		if position == 0 && !q.started.IsZero() {
+			startedAt := q.started
+			info.EstimatedStartAt = &startedAt
+		} else if bytesPerSecond > 0 {
+			estimated := start.Add(time.Duration(float64(ahead) / bytesPerSecond * float64(time.Second)))
+			if estimated.Before(now) {
+				estimated = now
+			}
+			info.EstimatedStartAt = &estimated
+		}
+		q.status.SetTableSnapshotQueue(table, info)
+		ahead += q.sizes[table]
+	}
+}
+
+// newSnapshotQueueOf orders the tables whose snapshots are not loaded yet by --load-order
+func newSnapshotQueueOf(order *LoadOrder, tidbConfig *tidbsql.TiDBConfig, tables []string, status *apiservice.APIInfo) (*snapshotQueue, error) {
+	db, err := tidbConfig.OpenDB()
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer db.Close()
+	sizes := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		if sizes[table], err = tidbsql.GetTiDBTableSize(db, table); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	sorted := order.sort(tables, sizes)
+	log.Info("Loading the snapshots of the tables in order", zap.Strings("tables", sorted))
+	return newSnapshotQueue(sorted, sizes, status), nil
+}
+
+// readStatements reads the statements of the file separated by semicolons, the semicolons quoted are kept
+func readStatements(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var statements []string
+	var quote rune
+	start := 0
+	text := string(content)
+	for i, c := range text {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == ';':
+			if statement := strings.TrimSpace(text[start:i]); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+	}
+	if statement := strings.TrimSpace(text[start:]); statement != "" {
+		statements = append(statements, statement)
+	}
+	if len(statements) == 0 {
+		return nil, errors.Errorf("no statement in %s", path)
+	}
+	return statements, nil
+}
+
+// execPostSnapshotSQL executes the statements of --post-snapshot-sql by the connector
+func execPostSnapshotSQL(connector coreinterfaces.Connector, statements []string) error {
+	executor, ok := connector.(coreinterfaces.StatementExecutor)
+	if !ok {
+		return errors.New("--post-snapshot-sql is not supported by the data warehouse")
+	}
+	log.Info("Executing the statements of --post-snapshot-sql", zap.Int("statements", len(statements)))
+	return diag.Warehouse(errors.Annotate(executor.ExecStatements(statements), "Failed to execute --post-snapshot-sql"))
+}
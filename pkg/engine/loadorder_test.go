@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadOrder(t *testing.T) {
+	order, err := ParseLoadOrder("")
+	require.NoError(t, err)
+	require.Nil(t, order)
+
+	order, err = ParseLoadOrder("Large-First")
+	require.NoError(t, err)
+	require.Equal(t, &LoadOrder{Heuristic: LoadOrderLargeFirst}, order)
+
+	order, err = ParseLoadOrder("db.items, db.orders")
+	require.NoError(t, err)
+	require.Equal(t, &LoadOrder{Tables: []string{"db.items", "db.orders"}}, order)
+
+	_, err = ParseLoadOrder("items")
+	require.ErrorContains(t, err, "invalid load order")
+	_, err = ParseLoadOrder("db.items,db.items")
+	require.ErrorContains(t, err, "is given twice")
+}
+
+func TestLoadOrderSort(t *testing.T) {
+	tables := []string{"db.a", "db.b", "db.c", "db.d"}
+	sizes := map[string]int64{"db.a": 30, "db.b": 10, "db.c": 20, "db.d": 10}
+
+	small := &LoadOrder{Heuristic: LoadOrderSmallFirst}
+	require.Equal(t, []string{"db.b", "db.d", "db.c", "db.a"}, small.sort(tables, sizes))
+	large := &LoadOrder{Heuristic: LoadOrderLargeFirst}
+	require.Equal(t, []string{"db.a", "db.c", "db.b", "db.d"}, large.sort(tables, sizes))
+	// the tables not given are loaded after in the order they are replicated
+	explicit := &LoadOrder{Tables: []string{"db.c", "db.a"}}
+	require.Equal(t, []string{"db.c", "db.a", "db.b", "db.d"}, explicit.sort(tables, sizes))
+}
+
+func TestSnapshotQueue(t *testing.T) {
+	ctx := context.Background()
+	status := apiservice.NewAPIInfo()
+	q := newSnapshotQueue([]string{"db.a", "db.b", "db.c"}, map[string]int64{"db.a": 100, "db.b": 100, "db.c": 100}, status)
+	position := func(table string) *apiservice.SnapshotQueueInfo {
+		return status.Status().TablesInfo[table].SnapshotQueue
+	}
+	require.Equal(t, 2, position("db.c").Position)
+	// the start is unknown until a snapshot is loaded
+	require.Nil(t, position("db.c").EstimatedStartAt)
+
+	started := make(chan struct{})
+	go func() {
+		require.NoError(t, q.wait(ctx, "db.b"))
+		close(started)
+	}()
+	require.NoError(t, q.wait(ctx, "db.a"))
+	require.NotNil(t, position("db.a").EstimatedStartAt)
+	select {
+	case <-started:
+		t.Fatal("db.b started before db.a is done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	q.done("db.a")
+	<-started
+	require.Nil(t, position("db.a"))
+	require.Equal(t, 0, position("db.b").Position)
+	require.Equal(t, 1, position("db.c").Position)
+	require.NotNil(t, position("db.c").EstimatedStartAt)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, q.wait(canceled, "db.c"), context.Canceled)
+}
+
+func TestReadStatements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "post.sql")
+	content := "CREATE OR REPLACE VIEW v AS SELECT * FROM t WHERE note <> 'a;b';\n\nDROP TABLE t_old;\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	statements, err := readStatements(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"CREATE OR REPLACE VIEW v AS SELECT * FROM t WHERE note <> 'a;b'", "DROP TABLE t_old"}, statements)
+
+	require.NoError(t, os.WriteFile(path, []byte(" ;\n"), 0o644))
+	_, err = readStatements(path)
+	require.ErrorContains(t, err, "no statement")
+}
@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// LockFileName is the file at the root of the storage path held by the process replicating into it, so that two
+// processes pointed at the same storage path do not both create changefeeds and merge the increment files
+const LockFileName = "lock"
+
+const (
+	// lockHeartbeatInterval is how often the holder refreshes the heartbeat of the lock
+	lockHeartbeatInterval = 10 * time.Second
+	// lockStaleAfter is how long after its last heartbeat a lock is stale, its holder is considered dead
+	lockStaleAfter = time.Minute
+	// lockSettleTime is how long the lock written is read back after, the storage has no compare-and-swap so the
+	// last of the processes writing the lock at the same time wins
+	lockSettleTime = 2 * time.Second
+)
+
+// workspaceLock is the content of LockFileName
+type workspaceLock struct {
+	// Owner identifies the process holding the lock, a UUID generated when it starts
+	Owner      string    `json:"owner"`
+	Hostname   string    `json:"hostname"`
+	PID        int       `json:"pid"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	Heartbeat  time.Time `json:"heartbeat"`
+}
+
+// lockHolder holds the lock of the workspace and refreshes its heartbeat until it is released
+type lockHolder struct {
+	storage           storage.ExternalStorage
+	lock              workspaceLock
+	heartbeatInterval time.Duration
+	staleAfter        time.Duration
+	settleTime        time.Duration
+
+	mu sync.Mutex
+	// lostErr is set once the lock is found owned by another process or not refreshed for staleAfter
+	lostErr error
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newLockHolder(storage storage.ExternalStorage) *lockHolder {
+	hostname, _ := os.Hostname()
+	return &lockHolder{
+		storage:           storage,
+		lock:              workspaceLock{Owner: uuid.NewString(), Hostname: hostname, PID: os.Getpid()},
+		heartbeatInterval: lockHeartbeatInterval,
+		staleAfter:        lockStaleAfter,
+		settleTime:        lockSettleTime,
+	}
+}
+
+// acquireWorkspaceLock takes the lock of the workspace. It fails if the lock is held by another process whose
+// heartbeat is fresh, and if its heartbeat is stale unless forceUnlock steals it.
+func acquireWorkspaceLock(ctx context.Context, storage storage.ExternalStorage, forceUnlock bool) (*lockHolder, error) {
+	h := newLockHolder(storage)
+	return h, errors.Trace(h.acquire(ctx, forceUnlock))
+}
+
+func (h *lockHolder) acquire(ctx context.Context, forceUnlock bool) error {
+	held, found, err := h.read(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if found && held.Owner != h.lock.Owner {
+		age := time.Since(held.Heartbeat)
+		if age < h.staleAfter {
+			return errors.Errorf("The storage is locked by another tidb2dw on host %s with pid %d, its heartbeat is %s ago. "+
+				"Stop it before replicating into the same storage, or wait %s after it is gone and restart with --force-unlock",
+				held.Hostname, held.PID, age.Round(time.Second), h.staleAfter)
+		}
+		if !forceUnlock {
+			return errors.Errorf("The storage is locked by another tidb2dw on host %s with pid %d, whose heartbeat is stale since %s. "+
+				"Make sure it is gone and restart with --force-unlock to take the lock",
+				held.Hostname, held.PID, held.Heartbeat.Format(time.RFC3339))
+		}
+		log.Warn("Take the stale lock of the storage", zap.String("hostname", held.Hostname), zap.Int("pid", held.PID),
+			zap.String("owner", held.Owner), zap.Time("heartbeat", held.Heartbeat))
+	}
+	h.lock.AcquiredAt = time.Now()
+	if err = h.write(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	// another process taking the lock at the same time may overwrite it
+	select {
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	case <-time.After(h.settleTime):
+	}
+	held, found, err = h.read(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !found || held.Owner != h.lock.Owner {
+		return errors.New("The lock of the storage is taken by another tidb2dw starting at the same time")
+	}
+	log.Info("Acquired the lock of the storage", zap.String("owner", h.lock.Owner))
+	return nil
+}
+
+func (h *lockHolder) read(ctx context.Context) (lock workspaceLock, found bool, err error) {
+	exists, err := h.storage.FileExists(ctx, LockFileName)
+	if err != nil || !exists {
+		return lock, false, errors.Annotate(err, "Failed to check the lock of the storage")
+	}
+	data, err := h.storage.ReadFile(ctx, LockFileName)
+	if err != nil {
+		return lock, false, errors.Annotate(err, "Failed to read the lock of the storage")
+	}
+	if err = json.Unmarshal(data, &lock); err != nil {
+		return lock, false, errors.Annotatef(err, "Invalid lock of the storage, remove %s if no tidb2dw replicates into it", LockFileName)
+	}
+	return lock, true, nil
+}
+
+func (h *lockHolder) write(ctx context.Context) error {
+	h.lock.Heartbeat = time.Now()
+	data, err := json.MarshalIndent(h.lock, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(h.storage.WriteFile(ctx, LockFileName, data), "Failed to write the lock of the storage")
+}
+
+// start refreshes the heartbeat until release. Once the lock is found owned by another process, or the heartbeat
+// fails for staleAfter so another process may take it, onLost is called and lostErr is set.
+func (h *lockHolder) start(onLost func()) {
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.heartbeatInterval)
+		defer ticker.Stop()
+		lastHeartbeat := time.Now()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+			err := h.heartbeat()
+			if err == nil {
+				lastHeartbeat = time.Now()
+				continue
+			}
+			if errors.Cause(err) != errLockTaken && time.Since(lastHeartbeat) < h.staleAfter {
+				log.Warn("Failed to refresh the lock of the storage", zap.Error(err))
+				continue
+			}
+			log.Error("Lost the lock of the storage, stop replicating", zap.Error(err))
+			h.mu.Lock()
+			h.lostErr = errors.Annotate(err, "Lost the lock of the storage, another tidb2dw may be replicating into it")
+			h.mu.Unlock()
+			onLost()
+			return
+		}
+	}()
+}
+
+var errLockTaken = errors.New("the lock is taken by another tidb2dw")
+
+func (h *lockHolder) heartbeat() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.heartbeatInterval)
+	defer cancel()
+	held, found, err := h.read(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if found && held.Owner != h.lock.Owner {
+		return errors.Annotatef(errLockTaken, "host %s, pid %d", held.Hostname, held.PID)
+	}
+	return errors.Trace(h.write(ctx))
+}
+
+// err returns the error the lock is lost by, nil if it is held
+func (h *lockHolder) err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lostErr
+}
+
+// release stops the heartbeat and removes the lock unless it is owned by another process
+func (h *lockHolder) release() {
+	if h.stop != nil {
+		close(h.stop)
+		<-h.done
+	}
+	if h.err() != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), h.heartbeatInterval)
+	defer cancel()
+	held, found, err := h.read(ctx)
+	if err != nil || !found || held.Owner != h.lock.Owner {
+		return
+	}
+	if err = h.storage.DeleteFile(ctx, LockFileName); err != nil {
+		log.Warn("Failed to remove the lock of the storage", zap.Error(err))
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLockHolder(extStorage storage.ExternalStorage) *lockHolder {
+	h := newLockHolder(extStorage)
+	h.heartbeatInterval = 10 * time.Millisecond
+	h.staleAfter = time.Second
+	h.settleTime = time.Millisecond
+	return h
+}
+
+func writeTestLock(t *testing.T, extStorage storage.ExternalStorage, lock workspaceLock) {
+	data, err := json.Marshal(lock)
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(context.Background(), LockFileName, data))
+}
+
+func TestWorkspaceLock(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	first := newTestLockHolder(extStorage)
+	require.NoError(t, first.acquire(ctx, false))
+	held, found, err := first.read(ctx)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, first.lock.Owner, held.Owner)
+
+	// a fresh lock is never taken, even with --force-unlock
+	second := newTestLockHolder(extStorage)
+	require.ErrorContains(t, second.acquire(ctx, false), "locked by another tidb2dw")
+	require.ErrorContains(t, second.acquire(ctx, true), "locked by another tidb2dw")
+
+	first.release()
+	exists, err := extStorage.FileExists(ctx, LockFileName)
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, second.acquire(ctx, false))
+	second.release()
+}
+
+func TestWorkspaceLockStale(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	writeTestLock(t, extStorage, workspaceLock{Owner: "dead", Hostname: "host", PID: 1, Heartbeat: time.Now().Add(-time.Hour)})
+
+	h := newTestLockHolder(extStorage)
+	require.ErrorContains(t, h.acquire(ctx, false), "--force-unlock")
+	require.NoError(t, h.acquire(ctx, true))
+	held, _, err := h.read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, h.lock.Owner, held.Owner)
+	h.release()
+}
+
+func TestWorkspaceLockLost(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	h := newTestLockHolder(extStorage)
+	require.NoError(t, h.acquire(ctx, false))
+	lost := make(chan struct{})
+	h.start(func() { close(lost) })
+
+	// the heartbeat recreates the lock removed by hand
+	require.NoError(t, extStorage.DeleteFile(ctx, LockFileName))
+	require.Eventually(t, func() bool {
+		held, found, err := h.read(ctx)
+		return err == nil && found && held.Owner == h.lock.Owner
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, h.err())
+
+	writeTestLock(t, extStorage, workspaceLock{Owner: "other", Hostname: "host", PID: 2, Heartbeat: time.Now()})
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the lock taken by another process is not found")
+	}
+	require.ErrorContains(t, h.err(), "Lost the lock")
+	// the lock of the other process is kept
+	h.release()
+	held, found, err := h.read(ctx)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "other", held.Owner)
+}
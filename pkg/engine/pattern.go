@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// ManagedTablesFile is the file in the increment storage recording the tables matching --table-pattern which are
+// replicated, so that a restart picks them back up
+const ManagedTablesFile = "managed_tables.json"
+
+// TableBootstrap is how the replication of a table matching --table-pattern starts
+type TableBootstrap string
+
+const (
+	// BootstrapInitial is of the tables matching when the replication starts, they are replicated as the tables
+	// given by --table
+	BootstrapInitial TableBootstrap = "initial"
+	// BootstrapCreated is of the tables created after the changefeed starts, which are created in the data
+	// warehouse by their CREATE TABLE DDL without a snapshot
+	BootstrapCreated TableBootstrap = "created"
+	// BootstrapSnapshot is of the other tables found later, e.g. renamed into the pattern, whose snapshot is
+	// dumped into a directory of their own before their increment is loaded
+	BootstrapSnapshot TableBootstrap = "snapshot"
+)
+
+// managedTables are the tables matching --table-pattern which are replicated, a table is recorded before it is
+// created in the data warehouse and removed once its replication stops after it is dropped in TiDB
+type managedTables struct {
+	mu         sync.Mutex
+	extStorage storage.ExternalStorage
+	tables     map[string]TableBootstrap
+}
+
+type managedTablesData struct {
+	Tables map[string]TableBootstrap `json:"tables"`
+}
+
+// loadManagedTables reads the managed tables recorded before the restart, found is false if there is none
+func loadManagedTables(ctx context.Context, extStorage storage.ExternalStorage) (m *managedTables, found bool, err error) {
+	m = &managedTables{extStorage: extStorage, tables: make(map[string]TableBootstrap)}
+	if found, err = extStorage.FileExists(ctx, ManagedTablesFile); err != nil || !found {
+		return m, false, errors.Trace(err)
+	}
+	content, err := extStorage.ReadFile(ctx, ManagedTablesFile)
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+	var data managedTablesData
+	if err = json.Unmarshal(content, &data); err != nil {
+		return nil, false, errors.Annotatef(err, "invalid managed tables %s", ManagedTablesFile)
+	}
+	if data.Tables != nil {
+		m.tables = data.Tables
+	}
+	return m, true, nil
+}
+
+// get returns how the table is bootstrapped, ok is false if the table is not managed
+func (m *managedTables) get(table string) (bootstrap TableBootstrap, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bootstrap, ok = m.tables[table]
+	return bootstrap, ok
+}
+
+// all returns the managed tables in the order of the name
+func (m *managedTables) all() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tables := make([]string, 0, len(m.tables))
+	for table := range m.tables {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+func (m *managedTables) add(ctx context.Context, table string, bootstrap TableBootstrap) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tables[table] = bootstrap
+	return errors.Trace(m.write(ctx))
+}
+
+func (m *managedTables) remove(ctx context.Context, table string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tables, table)
+	return errors.Trace(m.write(ctx))
+}
+
+func (m *managedTables) write(ctx context.Context) error {
+	data, err := json.MarshalIndent(managedTablesData{Tables: m.tables}, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(m.extStorage.WriteFile(ctx, ManagedTablesFile, data))
+}
+
+// tableSnapshotURI returns the directory of the snapshot of a table bootstrapped by BootstrapSnapshot, the snapshot
+// shared by the other tables is left untouched
+func tableSnapshotURI(snapshotURI *url.URL, table string) (*url.URL, error) {
+	uri := *snapshotURI
+	var err error
+	if uri.Path, err = url.JoinPath(snapshotURI.Path, "tables", table); err != nil {
+		return nil, errors.Annotate(err, "Failed to join workspace path")
+	}
+	return &uri, nil
+}
+
+// patternWatcher finds the tables matching --table-pattern in TiDB which are not replicated yet. A table found is
+// bootstrapped once the changefeed passes the TSO it is found at, so its CREATE TABLE DDL is written by then if it
+// is created after the changefeed starts.
+type patternWatcher struct {
+	managed *managedTables
+	// listTables returns the tables of TiDB matching the patterns
+	listTables func() ([]string, error)
+	currentTSO func() (uint64, error)
+	// changefeedCheckpoint returns the TSO the changes before which are written by the changefeed
+	changefeedCheckpoint func() (uint64, error)
+	// isCreated tells whether the CREATE TABLE DDL of the table is written by the changefeed
+	isCreated func(ctx context.Context, table string) (bool, error)
+	// start starts the replication of the table, it is called with mu held
+	start func(table string, bootstrap TableBootstrap)
+	// started are the tables replicated, guarded by mu
+	mu      *sync.Mutex
+	started map[string]struct{}
+	// pending are the tables found by the TSO they are found at
+	pending map[string]uint64
+}
+
+// run checks the tables of TiDB by the interval until ctx is canceled, a failed round is retried in the next one
+func (w *patternWatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.round(ctx); err != nil {
+			log.Warn("Failed to find the tables matching --table-pattern, retry in the next round", zap.Error(err))
+		}
+	}
+}
+
+func (w *patternWatcher) round(ctx context.Context) error {
+	tables, err := w.listTables()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the tables dropped before they are bootstrapped are forgotten
+	for table := range w.pending {
+		if !slices.Contains(tables, table) {
+			delete(w.pending, table)
+		}
+	}
+	// the checkpoint of the changefeed is fetched once a round
+	checkpoint, fetched := uint64(0), false
+	for _, table := range tables {
+		w.mu.Lock()
+		_, started := w.started[table]
+		w.mu.Unlock()
+		if _, managed := w.managed.get(table); started || managed {
+			continue
+		}
+		foundTSO, ok := w.pending[table]
+		if !ok {
+			if foundTSO, err = w.currentTSO(); err != nil {
+				return errors.Trace(err)
+			}
+			w.pending[table] = foundTSO
+			log.Info("Found table matching --table-pattern, replicating it once the changefeed passes it",
+				zap.String("table", table), zap.Uint64("tso", foundTSO))
+			continue
+		}
+		if !fetched {
+			if checkpoint, err = w.changefeedCheckpoint(); err != nil {
+				return errors.Annotate(err, "Failed to get changefeed checkpoint")
+			}
+			fetched = true
+		}
+		if checkpoint < foundTSO {
+			continue
+		}
+		created, err := w.isCreated(ctx, table)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		bootstrap := BootstrapSnapshot
+		if created {
+			bootstrap = BootstrapCreated
+		}
+		// recorded before the table is created in the data warehouse, so that it is replicated after restart
+		if err = w.managed.add(ctx, table, bootstrap); err != nil {
+			return errors.Annotatef(err, "Failed to record the table %s matching --table-pattern", table)
+		}
+		delete(w.pending, table)
+		log.Info("Replicating table matching --table-pattern", zap.String("table", table), zap.String("bootstrap", string(bootstrap)))
+		w.mu.Lock()
+		w.start(table, bootstrap)
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// loadPatternTables returns the tables matching --table-pattern which are replicated. The tables of a new replication
+// are those matching when it starts. On restart, the tables matching but not recorded are found while tidb2dw was
+// stopped, they are not in the snapshot shared by the tables and left to the watcher.
+func loadPatternTables(ctx context.Context, cfg *PipelineConfig, incrementURI *url.URL, stage Stage) (*managedTables, error) {
+	incrementStorage, err := openStorage(ctx, cfg, incrementURI)
+	if err != nil {
+		return nil, diag.Storage(errors.Trace(err))
+	}
+	managed, found, err := loadManagedTables(ctx, incrementStorage)
+	if err != nil {
+		return nil, diag.Storage(errors.Annotate(err, "Failed to load the tables matching --table-pattern"))
+	}
+	if stage == StageInit {
+		managed.tables = make(map[string]TableBootstrap)
+		for _, table := range cfg.Tables {
+			if tidbsql.MatchAnyTablePattern(cfg.TablePatterns, table) {
+				managed.tables[table] = BootstrapInitial
+			}
+		}
+		return managed, diag.Storage(errors.Annotate(managed.write(ctx), "Failed to record the tables matching --table-pattern"))
+	}
+	if !found {
+		return nil, errors.New("--table-pattern is set on a replication started without it, the tables replicated before are unknown, " +
+			"set --clean-workspace to start over")
+	}
+	kept, foundTables := splitPatternTables(cfg.Tables, cfg.TablePatterns, managed)
+	if len(foundTables) > 0 {
+		log.Info("Found tables matching --table-pattern while stopped, replicating them once the changefeed passes them", zap.Strings("tables", foundTables))
+	}
+	cfg.Tables = kept
+	return managed, nil
+}
+
+// splitPatternTables splits the tables given by the patterns on restart, the tables matching but not managed are
+// found while tidb2dw was stopped and left to the watcher
+func splitPatternTables(tables []string, patterns []tidbsql.TablePattern, managed *managedTables) (kept, found []string) {
+	for _, table := range tables {
+		if _, ok := managed.get(table); ok || !tidbsql.MatchAnyTablePattern(patterns, table) {
+			kept = append(kept, table)
+		} else {
+			found = append(found, table)
+		}
+	}
+	return kept, found
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPatternTables(t *testing.T) {
+	ctx := context.Background()
+	incrementURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	cfg := &PipelineConfig{
+		Tables:        []string{"db.orders", "db.events_1", "db.events_2"},
+		TablePatterns: []tidbsql.TablePattern{{Database: "db", Table: "events_*"}},
+	}
+
+	// a workspace replicated without --table-pattern is not adopted
+	_, err := loadPatternTables(ctx, cfg, incrementURI, StageSnapshotDumped)
+	require.ErrorContains(t, err, "--table-pattern is set on a replication started without it")
+
+	// a new replication manages the tables matching
+	managed, err := loadPatternTables(ctx, cfg, incrementURI, StageInit)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.events_1", "db.events_2"}, managed.all())
+	require.Equal(t, []string{"db.orders", "db.events_1", "db.events_2"}, cfg.Tables)
+
+	// on restart, a table matching but not managed is left to the watcher
+	require.NoError(t, managed.add(ctx, "db.events_4", BootstrapCreated))
+	require.NoError(t, managed.remove(ctx, "db.events_2"))
+	cfg.Tables = []string{"db.orders", "db.events_1", "db.events_3", "db.events_4"}
+	managed, err = loadPatternTables(ctx, cfg, incrementURI, StageSnapshotDumped)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.events_1", "db.events_4"}, managed.all())
+	bootstrap, ok := managed.get("db.events_4")
+	require.True(t, ok)
+	require.Equal(t, BootstrapCreated, bootstrap)
+	require.Equal(t, []string{"db.orders", "db.events_1", "db.events_4"}, cfg.Tables)
+
+	uri, err := tableSnapshotURI(&url.URL{Scheme: "s3", Host: "bucket", Path: "/prefix/snapshot"}, "db.events_3")
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/prefix/snapshot/tables/db.events_3", uri.String())
+}
+
+func TestPatternWatcher(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, (&url.URL{Scheme: "file", Path: t.TempDir()}).String())
+	require.NoError(t, err)
+	managed, _, err := loadManagedTables(ctx, extStorage)
+	require.NoError(t, err)
+	require.NoError(t, managed.add(ctx, "db.events_1", BootstrapInitial))
+
+	var (
+		mu         sync.Mutex
+		tables     = []string{"db.events_1"}
+		tso        = uint64(100)
+		checkpoint = uint64(0)
+		started    = map[string]struct{}{"db.events_1": {}}
+		bootstraps = make(map[string]TableBootstrap)
+	)
+	w := &patternWatcher{
+		managed:              managed,
+		listTables:           func() ([]string, error) { return tables, nil },
+		currentTSO:           func() (uint64, error) { return tso, nil },
+		changefeedCheckpoint: func() (uint64, error) { return checkpoint, nil },
+		isCreated: func(_ context.Context, table string) (bool, error) {
+			return table == "db.events_2", nil
+		},
+		start: func(table string, bootstrap TableBootstrap) {
+			started[table] = struct{}{}
+			bootstraps[table] = bootstrap
+		},
+		mu:      &mu,
+		started: started,
+		pending: make(map[string]uint64),
+	}
+
+	// the tables found wait for the changefeed to pass the TSO they are found at
+	tables = append(tables, "db.events_2", "db.events_3", "db.events_4")
+	require.NoError(t, w.round(ctx))
+	require.Equal(t, map[string]uint64{"db.events_2": 100, "db.events_3": 100, "db.events_4": 100}, w.pending)
+	checkpoint = 99
+	require.NoError(t, w.round(ctx))
+	require.Empty(t, bootstraps)
+
+	// a table dropped before it is bootstrapped is forgotten
+	tables = []string{"db.events_1", "db.events_2", "db.events_3"}
+	checkpoint = 100
+	require.NoError(t, w.round(ctx))
+	require.Equal(t, map[string]TableBootstrap{"db.events_2": BootstrapCreated, "db.events_3": BootstrapSnapshot}, bootstraps)
+	require.Empty(t, w.pending)
+
+	// recorded so that a restart picks them back up
+	managed, _, err = loadManagedTables(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.events_1", "db.events_2", "db.events_3"}, managed.all())
+	bootstrap, _ := managed.get("db.events_3")
+	require.Equal(t, BootstrapSnapshot, bootstrap)
+
+	// started once
+	require.NoError(t, w.round(ctx))
+	require.Len(t, bootstraps, 2)
+}
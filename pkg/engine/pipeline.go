@@ -0,0 +1,1377 @@
+package engine
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/notify"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// dumpProgressLogInterval is the interval of the log of the snapshot dump progress, which is reported every 10s
+const dumpProgressLogInterval = 30 * time.Second
+
+// Pipeline replicates the tables of a PipelineConfig from TiDB into the data warehouse. Pipelines share
+// no state, so more than one can run in a process as long as their storage paths differ.
+type Pipeline struct {
+	cfg    PipelineConfig
+	status *apiservice.APIInfo
+
+	mu sync.Mutex
+	// stage is the stage shared by all tables, empty until Run checks the storage
+	stage Stage
+	// loadedSnapshots is the number of tables whose snapshot is loaded
+	loadedSnapshots int
+	// cancel stops Run, nil until Run is called
+	cancel context.CancelFunc
+	// stopped is set if Stop is called before Run
+	stopped bool
+	// done is closed when Run returns
+	done chan struct{}
+	// statusFile writes StatusFileName, nil until Run checks the storage or if it is disabled
+	statusFile *statusFileWriter
+	// eventsFile writes the events into EventsDir, nil until Run checks the storage
+	eventsFile *eventsFileWriter
+	// columnExprs are the generated columns and the expression defaults of the tables, set when Run starts
+	columnExprs map[string]*tidbsql.ColumnExprs
+	// converter converts the files into the Parquet files loaded with stagingformat.Parquet, nil for CSV
+	converter *stagingformat.Converter
+	// snapshotQueue loads the snapshots one table at a time by --load-order, nil loads them all at once
+	snapshotQueue *snapshotQueue
+	// postSnapshotStatements are of --post-snapshot-sql, read when Run starts
+	postSnapshotStatements []string
+}
+
+// NewPipeline checks the config and creates the pipeline, nothing is touched until Run
+func NewPipeline(cfg PipelineConfig) (*Pipeline, error) {
+	if cfg.SnapshotCompression == "" {
+		cfg.SnapshotCompression = utils.CompressionNone
+	}
+	if cfg.IncrementCompression == "" {
+		cfg.IncrementCompression = utils.CompressionNone
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var converter *stagingformat.Converter
+	if cfg.StagingFormat == stagingformat.Parquet {
+		var timeZone string
+		if cfg.TiDBConfig != nil {
+			timeZone = cfg.TiDBConfig.TimeZone
+		}
+		var err error
+		if converter, err = stagingformat.NewConverter(timeZone); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if cfg.Status == nil {
+		cfg.Status = apiservice.NewAPIInfo()
+	}
+	if cfg.RateLimiters == nil {
+		cfg.RateLimiters = ratelimit.NewLimiters(0, 0)
+	}
+	cfg.Status.SetRateLimiters(cfg.RateLimiters)
+	return &Pipeline{
+		cfg:       cfg,
+		status:    cfg.Status,
+		done:      make(chan struct{}),
+		converter: converter,
+	}, nil
+}
+
+// Run runs the replication until all tables are finished, ctx is canceled or Stop is called. Cancellation
+// is a graceful stop rather than an error, the first error of the tables is returned otherwise.
+// A pipeline is run once.
+func (p *Pipeline) Run(ctx context.Context) error {
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.mu.Unlock()
+		return errors.New("The pipeline is already run")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
+	stopped := p.stopped
+	p.mu.Unlock()
+	defer close(p.done)
+	defer cancel()
+	if stopped {
+		return nil
+	}
+	err := p.run(ctx)
+	if err != nil {
+		p.status.PublishEvent(apiservice.ErrorEvent("", err))
+	}
+	if p.statusFile != nil {
+		p.statusFile.close(err)
+	}
+	if p.eventsFile != nil {
+		p.eventsFile.close()
+	}
+	if err != nil {
+		p.notify(notify.EventFatalError, "", "Replication failed, tidb2dw exits", err)
+	}
+	return err
+}
+
+// Stop stops Run gracefully, the files being loaded are finished first, and waits for it to return
+// until ctx is done
+func (p *Pipeline) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	cancel := p.cancel
+	p.stopped = true
+	p.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+// Stage returns the stage shared by all tables, it is StageSnapshotLoaded once the snapshot of all tables
+// is loaded. The stage of each table is reported by Progress.
+func (p *Pipeline) Stage() Stage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.stage
+}
+
+// Progress returns the status of the replication and of each table, the same as GET /status of the API service
+func (p *Pipeline) Progress() apiservice.InfoResponse {
+	return p.status.Status()
+}
+
+func (p *Pipeline) setStage(stage Stage) {
+	p.mu.Lock()
+	changed := p.stage != stage
+	p.stage = stage
+	p.mu.Unlock()
+
+	if changed {
+		p.status.PublishEvent(apiservice.Event{Kind: apiservice.EventKindStage, Stage: string(stage), Message: fmt.Sprintf("Replication stage is %s", stage)})
+	}
+
+	if stage == StageSnapshotDumped {
+		p.notify(notify.EventSnapshotDumped, "", "Snapshot of all tables is dumped", nil)
+	}
+}
+
+// onSnapshotLoaded counts the table whose snapshot is loaded, it returns true if the snapshot of all tables is loaded
+// by the table
+func (p *Pipeline) onSnapshotLoaded() bool {
+	p.mu.Lock()
+	p.loadedSnapshots++
+	loaded := p.loadedSnapshots == len(p.cfg.Tables)
+	if loaded {
+		p.stage = StageSnapshotLoaded
+	}
+	p.mu.Unlock()
+
+	if loaded {
+		p.status.PublishEvent(apiservice.Event{Kind: apiservice.EventKindStage, Stage: string(StageSnapshotLoaded), Message: "Snapshot of all tables is loaded"})
+		p.notify(notify.EventSnapshotLoaded, "", "Snapshot of all tables is loaded", nil)
+	}
+	return loaded
+}
+
+// onTableSnapshotLoaded counts the table whose snapshot is loaded by the connector, which executes
+// --post-snapshot-sql if the snapshot of all tables is loaded by the table
+func (p *Pipeline) onTableSnapshotLoaded(connector coreinterfaces.Connector) error {
+	if !p.onSnapshotLoaded() || len(p.postSnapshotStatements) == 0 {
+		return nil
+	}
+	return errors.Trace(execPostSnapshotSQL(connector, p.postSnapshotStatements))
+}
+
+// notify sends the event of the table, empty for all tables, to Notifier. Nothing is sent in a dry run.
+func (p *Pipeline) notify(event notify.Event, table string, message string, err error) {
+	if p.cfg.DryRun || !p.cfg.Notifier.Enabled(event) {
+		return
+	}
+	notification := notify.Notification{
+		Event:     event,
+		Workspace: utils.RedactStorageURI(p.cfg.StorageURI),
+		Table:     table,
+		Stage:     string(p.Stage()),
+		Message:   message,
+	}
+	if err != nil {
+		notification.Error = err.Error()
+	}
+	p.cfg.Notifier.Notify(notification)
+}
+
+// watchLag notifies the tables whose lag stays over the threshold of Notifier until ctx is done
+func (p *Pipeline) watchLag(ctx context.Context) {
+	tracker := p.cfg.Notifier.NewLagTracker()
+	ticker := time.NewTicker(changefeedCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			lags, ok := p.status.TableLags()
+			if !ok {
+				continue
+			}
+			for _, table := range tracker.Observe(lags, now) {
+				lag := time.Duration(lags[table]) * time.Second
+				p.notify(notify.EventLag, table, fmt.Sprintf("Increment of the table lags %s behind the changefeed", lag), nil)
+			}
+		}
+	}
+}
+
+// warnUnknownMappedColumns warns about the columns of --column-mapping which are not in the TiDB tables,
+// they are likely misspelled. The replication goes on even if TiDB can not be queried.
+func warnUnknownMappedColumns(cfg *PipelineConfig) {
+	if len(cfg.ColumnMapping) == 0 {
+		return
+	}
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		log.Warn("Failed to check the columns of the column mapping", zap.Error(err))
+		return
+	}
+	defer tidbPool.Close()
+	for _, tableFQN := range cfg.Tables {
+		columnTypes := cfg.ColumnMapping.Table(tableFQN)
+		if len(columnTypes) == 0 {
+			continue
+		}
+		sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+		columns, err := tidbsql.GetTiDBTableColumn(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			log.Warn("Failed to check the columns of the column mapping", zap.String("table", tableFQN), zap.Error(err))
+			continue
+		}
+		if unknown := columnTypes.Unknown(columns); len(unknown) > 0 {
+			slices.Sort(unknown)
+			log.Warn("Ignored the column mapping of columns not in the table", zap.String("table", tableFQN), zap.Strings("columns", unknown))
+		}
+	}
+}
+
+// loadColumnExprs returns the generated columns and the expression defaults of the TiDB tables. A table
+// which can not be queried is replicated as if it had none, the DDLs replicated later still update them.
+func loadColumnExprs(cfg *PipelineConfig) map[string]*tidbsql.ColumnExprs {
+	columnExprs := make(map[string]*tidbsql.ColumnExprs, len(cfg.Tables))
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		log.Warn("Failed to query the generated columns and the expression defaults", zap.Error(err))
+		return columnExprs
+	}
+	defer tidbPool.Close()
+	for _, tableFQN := range cfg.Tables {
+		sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+		exprs, err := tidbsql.GetTiDBColumnExprs(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			log.Warn("Failed to query the generated columns and the expression defaults", zap.String("table", tableFQN), zap.Error(err))
+			continue
+		}
+		if len(exprs.Virtual) > 0 {
+			log.Info("Skipped the virtual generated columns", zap.String("table", tableFQN), zap.Int("columns", len(exprs.Virtual)))
+		}
+		columnExprs[tableFQN] = exprs
+	}
+	return columnExprs
+}
+
+// checkColumnFilter checks --column-filter against the TiDB tables, the primary key of a table must be replicated.
+// The columns dumped of each filtered table are returned, in the order of the table.
+func checkColumnFilter(cfg *PipelineConfig) (map[string][]string, error) {
+	if len(cfg.ColumnFilter) == 0 {
+		return nil, nil
+	}
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+	projections := make(map[string][]string)
+	for _, tableFQN := range cfg.Tables {
+		columnFilter := cfg.ColumnFilter.Table(tableFQN)
+		if columnFilter == nil {
+			continue
+		}
+		sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+		columns, err := tidbsql.GetTiDBTableColumn(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			return nil, diag.Source(errors.Trace(err))
+		}
+		pkColumns, err := tidbsql.GetTiDBTablePKColumns(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			return nil, diag.Source(errors.Trace(err))
+		}
+		// the dedup key of a table without a primary key must be replicated too
+		pkColumns = cfg.PKLess.Key(tableFQN, pkColumns)
+		for i := range columns {
+			if slices.Contains(pkColumns, columns[i].Name) {
+				columns[i].IsPK = "true"
+			}
+		}
+		if err = columnFilter.Check(columns); err != nil {
+			return nil, diag.Schema(errors.Annotatef(err, "Invalid column filter of table %s", tableFQN))
+		}
+		if unknown := columnFilter.Unknown(columns); len(unknown) > 0 {
+			log.Warn("Ignored the column filter of columns not in the table", zap.String("table", tableFQN), zap.Strings("columns", unknown))
+		}
+		retained := columnFilter.Columns(columns)
+		names := make([]string, 0, len(retained))
+		for _, column := range retained {
+			names = append(names, column.Name)
+		}
+		projections[tableFQN] = names
+		log.Info("Columns filtered", zap.String("table", tableFQN), zap.Strings("columns", names), zap.Int("totalColumns", len(columns)))
+	}
+	return projections, nil
+}
+
+// checkWhere checks the predicates of --where can be evaluated on the TiDB tables by explaining them
+func checkWhere(cfg *PipelineConfig) error {
+	if len(cfg.Where) == 0 {
+		return nil
+	}
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+	for _, tableFQN := range cfg.Tables {
+		where, ok := cfg.Where[tableFQN]
+		if !ok {
+			continue
+		}
+		sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+		if err = tidbsql.CheckWhere(tidbPool, sourceDatabase, sourceTable, where); err != nil {
+			return diag.Schema(errors.Annotatef(err, "Invalid where of table %s", tableFQN))
+		}
+		log.Info("Rows filtered", zap.String("table", tableFQN), zap.String("where", where))
+	}
+	return nil
+}
+
+// checkPKLessTables fails if a table has no primary key and is not replicated by a dedup key or --pkless-mode=append,
+// whose increment files can not be merged. The snapshot is loaded without a key, so the tables are not checked in
+// --mode=snapshot-only. A table which can not be queried is checked by its increment session on its first schema file.
+func checkPKLessTables(cfg *PipelineConfig) error {
+	if cfg.Mode == RunModeSnapshotOnly {
+		return nil
+	}
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		log.Warn("Failed to check the primary keys of the tables", zap.Error(err))
+		return nil
+	}
+	defer tidbPool.Close()
+	for _, tableFQN := range cfg.Tables {
+		sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+		pkColumns, err := tidbsql.GetTiDBTablePKColumns(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			log.Warn("Failed to check the primary key of the table", zap.String("table", tableFQN), zap.Error(err))
+			continue
+		}
+		if len(pkColumns) > 0 {
+			continue
+		}
+		columns, err := tidbsql.GetTiDBTableColumn(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			log.Warn("Failed to check the primary key of the table", zap.String("table", tableFQN), zap.Error(err))
+			continue
+		}
+		_, appends, err := cfg.PKLess.Resolve(tableFQN, columns)
+		if err != nil {
+			return diag.Schema(errors.Trace(err))
+		}
+		if appends {
+			log.Warn("The table has no primary key, its changes are appended to its changelog table", zap.String("table", tableFQN))
+		} else {
+			log.Info("The table has no primary key, its rows are merged by the dedup key", zap.String("table", tableFQN), zap.Strings("key", cfg.PKLess.DedupKey(tableFQN)))
+		}
+	}
+	return nil
+}
+
+// dumpFilters returns the part of each filtered table dumped, by the columns of checkColumnFilter
+// and the predicates of --where. The columns of a table with generated columns or BIT columns are always
+// selected, since dumpling skips the stored generated columns and writes the bytes of BIT otherwise.
+func dumpFilters(cfg *PipelineConfig, projections map[string][]string, columnExprs map[string]*tidbsql.ColumnExprs) map[string]dumpling.TableFilter {
+	filters := make(map[string]dumpling.TableFilter)
+	for _, tableFQN := range cfg.Tables {
+		columns, where := projections[tableFQN], cfg.Where[tableFQN]
+		var exprs map[string]string
+		if columnExpr := columnExprs[tableFQN]; columnExpr != nil {
+			if len(columns) == 0 && (columnExpr.Generated || len(columnExpr.Bits) > 0) {
+				columns = columnExpr.Dumped
+			}
+			for name, length := range columnExpr.Bits {
+				if exprs == nil {
+					exprs = make(map[string]string, len(columnExpr.Bits))
+				}
+				exprs[name] = tidbsql.BitDumpExpr(name, length)
+			}
+		}
+		if len(columns) > 0 || where != "" {
+			filters[tableFQN] = dumpling.TableFilter{Columns: columns, Where: where, Exprs: exprs}
+		}
+	}
+	return filters
+}
+
+// newSnapshotValidator returns the validator of the snapshot in the directory, nil if the snapshot is not validated
+func newSnapshotValidator(ctx context.Context, cfg *PipelineConfig, snapshotURI *url.URL) (*replicate.SnapshotValidator, error) {
+	if !cfg.SnapshotValidation.Enabled {
+		return nil, nil
+	}
+	snapshotStorage, err := openStorage(ctx, cfg, snapshotURI)
+	if err != nil {
+		return nil, diag.Storage(errors.Trace(err))
+	}
+	checksumColumns := 0
+	if cfg.SnapshotValidation.Checksum {
+		checksumColumns = validation.DefaultChecksumColumns
+	}
+	validator, err := replicate.NewSnapshotValidator(ctx, snapshotStorage, checksumColumns)
+	return validator, errors.Trace(err)
+}
+
+func newIncrementScheduler(cfg *PipelineConfig, status *apiservice.APIInfo) (*replicate.IncrementScheduler, error) {
+	opts := cfg.IncrementOptions
+	var overrides map[string]replicate.TableConfig
+	if opts.ConfigFile != "" {
+		var err error
+		if overrides, err = replicate.LoadTableConfigs(opts.ConfigFile); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	mergeInterval := opts.MergeInterval
+	if mergeInterval == 0 {
+		mergeInterval = cfg.CDCFlushInterval / 5
+	}
+	scheduler, err := replicate.NewIncrementScheduler(opts.Workers, opts.Concurrency, mergeInterval, opts.Batch, cfg.Tables, overrides, status)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = scheduler.SetSchemaDriftPolicy(opts.SchemaDrift); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = scheduler.SetRetryPolicy(cfg.RetryPolicy); err != nil {
+		return nil, errors.Trace(err)
+	}
+	scheduler.SetPKLessPolicy(cfg.PKLess)
+	scheduler.SetRateLimiters(cfg.RateLimiters)
+	scheduler.SetUnsupportedDDLPolicy(cfg.UnsupportedDDLPolicy)
+	scheduler.SetRenameCheck(cfg.RenameCheck)
+	return scheduler, nil
+}
+
+// pipelineRun is the state of a run passed from one stage of the run to the next
+type pipelineRun struct {
+	storage storage.ExternalStorage
+	// stage is the stage found in the storage when the run starts, the stage reached since is p.stage
+	stage       Stage
+	tableStages map[string]Stage
+	filters     map[string]dumpling.TableFilter
+	// managed are the tables matching --table-pattern which are replicated, nil if they are not watched
+	managed *managedTables
+	// incrementPaused is recorded by the status file only, the increment replication paused stays paused after a
+	// restart
+	incrementPaused bool
+	// cdcVersion is empty in cloud mode, the changefeed is managed outside of tidb2dw and its version is unknown
+	cdcVersion string
+	// cloud creates the changefeed and exports the snapshot through the TiDB Cloud API in cloud mode
+	cloud                     *tidbcloud.Client
+	startTSO                  uint64
+	snapshotURI, incrementURI *url.URL
+	// shardURIs are written by the changefeeds of the shards, the increment directory itself if not sharded
+	shardURIs []*url.URL
+	// scheduler is nil in --mode=snapshot-only, checkpoints are of the shards in order, each changefeed writes its
+	// files independently
+	scheduler                         *replicate.IncrementScheduler
+	checkpoints                       []*replicate.IncrementCheckpoint
+	validator                         *replicate.SnapshotValidator
+	snapshotChecker, incrementChecker *fieldlimit.Checker
+	// feed streams the files being dumped to the snapshot loads with --pipelined-snapshot
+	feed                *dumpling.FileFeed
+	lastDumpProgressLog time.Time
+}
+
+// run runs the stages of the replication in order. The workspace lock is held until the tables are finished,
+// the replication stops once it is lost.
+func (p *Pipeline) run(ctx context.Context) (err error) {
+	cfg := &p.cfg
+	filters, err := p.checkTables()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.DryRun {
+		p.setStage(StageInit)
+		return dryRunReplicate(ctx, cfg)
+	}
+
+	storage, err := openStorage(ctx, cfg, cfg.StorageURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if err = checkStorageAccess(ctx, storage); err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	lock, err := acquireWorkspaceLock(ctx, storage, cfg.ForceUnlock)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	defer lock.release()
+	// the replication stops once the lock is lost, the changefeeds are left to the process taking it
+	parentCtx := ctx
+	ctx, cancelLocked := context.WithCancel(ctx)
+	defer cancelLocked()
+	lock.start(cancelLocked)
+	defer func() {
+		if lockErr := lock.err(); lockErr != nil {
+			err = diag.Storage(lockErr)
+		}
+	}()
+
+	r, err := p.prepare(ctx, storage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	r.filters = filters
+	if err = p.prepareIncrement(ctx, r); err != nil {
+		return errors.Trace(err)
+	}
+	if err = p.runSnapshot(ctx, r); err != nil {
+		return errors.Trace(err)
+	}
+	err = p.runTables(ctx, r)
+	p.finish(parentCtx, r)
+	return errors.Trace(err)
+}
+
+// checkTables checks the filters of the tables against TiDB and reads --post-snapshot-sql, it returns the part of
+// each filtered table dumped
+func (p *Pipeline) checkTables() (map[string]dumpling.TableFilter, error) {
+	cfg := &p.cfg
+	warnUnknownMappedColumns(cfg)
+	p.columnExprs = loadColumnExprs(cfg)
+	projections, err := checkColumnFilter(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = checkWhere(cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = checkPKLessTables(cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.SnapshotOrder.PostSnapshotSQL != "" {
+		if p.postSnapshotStatements, err = readStatements(cfg.SnapshotOrder.PostSnapshotSQL); err != nil {
+			return nil, errors.Annotate(err, "Failed to read --post-snapshot-sql")
+		}
+	}
+	return dumpFilters(cfg, projections, p.columnExprs), nil
+}
+
+// prepare finds the stage of the replication and of each table in the storage, and starts writing the status file
+// and the events file into it
+func (p *Pipeline) prepare(ctx context.Context, storage storage.ExternalStorage) (*pipelineRun, error) {
+	cfg := &p.cfg
+	mode := cfg.Mode
+	if cfg.CleanWorkspace {
+		if err := cleanWorkspace(ctx, cfg); err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else if mode == RunModeSnapshotOnly {
+		if err := warnStaleIncrement(ctx, storage); err != nil {
+			return nil, diag.Storage(errors.Trace(err))
+		}
+	}
+	r := &pipelineRun{storage: storage}
+	var err error
+	if r.stage, err = checkStage(ctx, storage, mode, cfg.IncrementOptions.Shards, cfg.Tables); err != nil {
+		return nil, diag.Storage(errors.Trace(err))
+	}
+	if r.snapshotURI, r.incrementURI, err = GenSnapshotAndIncrementURIs(cfg.StorageURI); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.Databases) > 0 {
+		if err = freezeDatabaseTables(ctx, cfg, r.incrementURI, r.stage); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if len(cfg.TablePatterns) > 0 && mode != RunModeSnapshotOnly {
+		if r.managed, err = loadPatternTables(ctx, cfg, r.incrementURI, r.stage); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if r.tableStages, err = checkTableStages(ctx, storage, r.stage, cfg.Tables); err != nil {
+		return nil, diag.Storage(errors.Trace(err))
+	}
+	p.setStage(r.stage)
+	for _, tableStage := range r.tableStages {
+		if tableStage == StageSnapshotLoaded {
+			p.onSnapshotLoaded()
+		}
+	}
+	if cfg.StatusFileInterval > 0 {
+		prevStatus, err := readFileStatus(ctx, storage)
+		if err != nil {
+			log.Warn("Failed to read the status file of the former run", zap.Error(err))
+		}
+		r.incrementPaused = prevStatus != nil && prevStatus.IncrementPaused
+		p.statusFile = p.startStatusFile(storage, cfg.StatusFileInterval)
+	}
+	p.eventsFile = startEventsFile(p.status.Events(), storage, eventsFlushInterval)
+	log.Info("Start Replicate", zap.String("stage", string(r.stage)), zap.Any("tableStages", r.tableStages), zap.String("mode", RunModeIds[mode][0]))
+
+	if r.validator, err = newSnapshotValidator(ctx, cfg, r.snapshotURI); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.FieldLimitConfig != nil {
+		checker := fieldlimit.NewChecker(storage, cfg.FieldLimitConfig.Limits, cfg.FieldLimitConfig.Policy)
+		r.snapshotChecker = checker.Sub("snapshot", cfg.SnapshotCompression)
+		r.incrementChecker = checker.Sub("increment", cfg.IncrementCompression)
+	}
+	return r, nil
+}
+
+// prepareIncrement checks TiCDC, finds the TSO the changefeeds start from and loads the checkpoints of the increment
+// files merged. The changefeeds paused by the former run are resumed.
+func (p *Pipeline) prepareIncrement(ctx context.Context, r *pipelineRun) error {
+	cfg := &p.cfg
+	mode := cfg.Mode
+	var err error
+	if mode != RunModeSnapshotOnly && mode != RunModeCloud {
+		if r.cdcVersion, err = checkCDCVersion(cfg.CDC); err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+	}
+	if cfg.TiDBCloud != nil {
+		r.cloud = tidbcloud.NewClient(*cfg.TiDBCloud, cfg.RetryPolicy)
+	}
+
+	if mode == RunModeFull || (cfg.CloudExport && r.stage == StageInit) {
+		if r.startTSO, err = tidbsql.GetCurrentTSO(cfg.TiDBConfig); err != nil {
+			return diag.Source(errors.Annotate(err, "Failed to get current TSO"))
+		}
+	} else if mode == RunModeIncrementalOnly && cfg.StartTSO != 0 {
+		if r.stage != StageInit {
+			log.Warn("Ignored --start-tso since the changefeed is already created", zap.Uint64("startTSO", cfg.StartTSO))
+		} else {
+			if err = tidbsql.CheckGCSafePoint(cfg.TiDBConfig, cfg.StartTSO); err != nil {
+				return errors.Annotate(err, "Failed to check --start-tso")
+			}
+			r.startTSO = cfg.StartTSO
+		}
+	}
+	log.Info("Using storage",
+		zap.String("snapshot", utils.RedactStorageURI(r.snapshotURI)),
+		zap.String("increment", utils.RedactStorageURI(r.incrementURI)))
+	if r.shardURIs, err = IncrementShardURIs(r.incrementURI, cfg.IncrementOptions.Shards); err != nil {
+		return errors.Trace(err)
+	}
+
+	if mode != RunModeSnapshotOnly {
+		scheduler, err := newIncrementScheduler(cfg, p.status)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		p.status.SetTableConfigUpdater(scheduler.UpdateTableConfigFromAPI)
+		p.status.SetDDLResumer(scheduler.ResumeAfterDDL)
+		scheduler.SetBadRowsWorkspace(r.storage)
+		if r.incrementPaused {
+			log.Warn("Merging the increment files is paused by the former run, resume it by POST /api/v1/resume")
+			scheduler.SetPaused(true)
+		}
+		p.status.SetIncrementPauser(func(paused bool) error {
+			scheduler.SetPaused(paused)
+			// recorded at once, so that a restart right after it is paused stays paused
+			if p.statusFile != nil {
+				p.statusFile.flush()
+			}
+			return nil
+		})
+		for _, shardURI := range r.shardURIs {
+			checkpoint, err := loadIncrementCheckpoint(ctx, shardURI, r.stage)
+			if err != nil {
+				return diag.Storage(errors.Trace(err))
+			}
+			r.checkpoints = append(r.checkpoints, checkpoint)
+		}
+		r.scheduler = scheduler
+	}
+	if cfg.PauseChangefeedOnExit && r.stage != StageInit {
+		for _, shardURI := range r.shardURIs {
+			if err = resumeChangefeed(cfg.CDC, shardURI); err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+		}
+	}
+	if mode != RunModeSnapshotOnly && mode != RunModeCloud {
+		p.status.SetCheckpointFetcher(newCheckpointFetcher(cfg.CDC, r.shardURIs))
+	} else if r.cloud != nil {
+		p.status.SetCheckpointFetcher(newCloudCheckpointFetcher(r.cloud, r.incrementURI))
+	}
+	p.status.SetCDCFlushInterval(cfg.CDCFlushInterval)
+	return nil
+}
+
+// runSnapshot creates the changefeeds from StageInit and dumps the snapshot, the dump is left to runTables with
+// --pipelined-snapshot. A snapshot already dumped is not dumped again.
+func (p *Pipeline) runSnapshot(ctx context.Context, r *pipelineRun) error {
+	cfg := &p.cfg
+	mode := cfg.Mode
+	if cfg.ForceRedump && r.stage == StageSnapshotDumped {
+		log.Warn("Ignored --force-redump since the snapshot is already dumped")
+	}
+	switch r.stage {
+	case StageInit:
+		if mode != RunModeSnapshotOnly && mode != RunModeCloud {
+			if err := createChangefeeds(ctx, cfg, r.startTSO, r.shardURIs); err != nil {
+				return errors.Trace(err)
+			}
+			p.setStage(StageChangefeedCreated)
+		} else if r.cloud != nil {
+			if err := createCloudChangefeed(ctx, cfg, r.cloud, r.startTSO, r.incrementURI); err != nil {
+				return errors.Trace(err)
+			}
+			p.setStage(StageChangefeedCreated)
+		}
+		fallthrough
+	case StageChangefeedCreated:
+		if cfg.CloudExport {
+			if err := exportCloudSnapshot(ctx, cfg, r.cloud, r.snapshotURI, r.incrementURI); err != nil {
+				return errors.Trace(err)
+			}
+			p.setStage(StageSnapshotDumped)
+		} else if mode != RunModeIncrementalOnly && mode != RunModeCloud {
+			// the unfinished dump is only resumed by the changefeed created with it, it may be older than the new one
+			if cfg.ForceRedump || (r.stage == StageInit && mode != RunModeSnapshotOnly) {
+				if err := clearDumpProgress(ctx, r.snapshotURI); err != nil {
+					return diag.Storage(errors.Trace(err))
+				}
+				if cfg.ForceRedump {
+					log.Info("All the tables of the snapshot are dumped again by --force-redump")
+				}
+			}
+			if err := resetSnapshotLoadProgress(ctx, r.storage, cfg.Tables); err != nil {
+				return diag.Storage(errors.Trace(err))
+			}
+			if cfg.PipelinedSnapshot {
+				r.feed = dumpling.NewFileFeed()
+			} else if err := p.dumpSnapshot(ctx, r); err != nil {
+				return diag.Source(errors.Trace(err))
+			} else {
+				p.setStage(StageSnapshotDumped)
+			}
+		}
+	}
+	return nil
+}
+
+// dumpSnapshot dumps the snapshot of the tables at the start TSO, into r.feed if it is set
+func (p *Pipeline) dumpSnapshot(ctx context.Context, r *pipelineRun) error {
+	cfg := &p.cfg
+	onProgress := func(progress apiservice.SnapshotDumpProgress) {
+		p.onSnapshotDumpProgress(r, progress)
+	}
+	err := dumpling.RunDump(ctx, cfg.TiDBConfig, cfg.SnapshotConcurrency, r.snapshotURI, fmt.Sprint(r.startTSO), cfg.Tables, r.filters, cfg.SnapshotCompression, cfg.DumpChunkConfig, cfg.RateLimiters, onProgress, r.feed)
+	return errors.Trace(err)
+}
+
+// onSnapshotDumpProgress reports the progress of the dump, and logs it every dumpProgressLogInterval
+func (p *Pipeline) onSnapshotDumpProgress(r *pipelineRun, progress apiservice.SnapshotDumpProgress) {
+	p.status.SetSnapshotDumpProgress(progress)
+	if time.Since(r.lastDumpProgressLog) < dumpProgressLogInterval {
+		return
+	}
+	r.lastDumpProgressLog = time.Now()
+	eta := "unknown"
+	if progress.DumpETASeconds >= 0 {
+		eta = (time.Duration(progress.DumpETASeconds) * time.Second).String()
+	}
+	// the snapshot is loaded while it is dumped with --pipelined-snapshot
+	loadedRows := p.status.SnapshotProgress().LoadedRows
+	log.Info("Snapshot dump progress",
+		zap.Int64("dumpedRows", progress.DumpedRows),
+		zap.Int64("estimatedTotalRows", progress.EstimatedTotalRows),
+		zap.Int64("dumpedBytes", progress.DumpedBytes),
+		zap.Int64("dumpedFiles", progress.DumpedFiles),
+		zap.Float64("chunksCompletedPercent", progress.ChunksCompletedPercent),
+		zap.Float64("rowsPerSecond", progress.DumpRowsPerSecond),
+		zap.String("eta", eta),
+		zap.Int64("loadedRows", loadedRows))
+}
+
+// tableGroup runs the replication of the tables, the first error of a table or a monitor fails the run
+type tableGroup struct {
+	// ctx stops the tables if the changefeed is found stopped or failed with cdc.RecoveryFail
+	ctx    context.Context
+	stop   context.CancelFunc
+	status *apiservice.APIInfo
+	wg     sync.WaitGroup
+	// monitorWg waits for the monitors, which are stopped once the tables are finished
+	monitorWg sync.WaitGroup
+
+	mu       sync.Mutex
+	firstErr error
+	// started are the tables replicated, the created tables are found until none of them is running
+	started map[string]struct{}
+	running int
+}
+
+// start replicates the table in a goroutine, it is called with mu held
+func (g *tableGroup) start(table string, replicate func() error) {
+	g.started[table] = struct{}{}
+	g.running++
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		err := replicate()
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.running--
+		if err != nil {
+			if g.ctx.Err() != nil && stderrors.Is(err, g.ctx.Err()) {
+				log.Info("Replication stopped", zap.String("table", table))
+				return
+			}
+			g.status.SetTableFatalError(table, err)
+			if g.firstErr == nil {
+				g.firstErr = errors.Annotatef(err, "Failed to replicate table %s", table)
+			}
+			return
+		}
+		g.status.SetTableStage(table, apiservice.TableStageFinished)
+	}()
+}
+
+// fail fails the run with the error unless a table failed first
+func (g *tableGroup) fail(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.firstErr == nil {
+		g.firstErr = err
+	}
+}
+
+// runTables replicates the tables until all of them are finished or ctx is done, the tables created or matching
+// --table-pattern later are started as they are found
+func (p *Pipeline) runTables(ctx context.Context, r *pipelineRun) error {
+	cfg := &p.cfg
+	if r.scheduler != nil {
+		protocol, err := resolveCDCProtocol(ctx, cfg, r.shardURIs)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		r.scheduler.SetCDCProtocol(protocol)
+		r.scheduler.SetStagingConverter(p.converter)
+	}
+
+	tablesCtx, stopTables := context.WithCancel(ctx)
+	defer stopTables()
+	g := &tableGroup{ctx: tablesCtx, stop: stopTables, status: p.status, started: make(map[string]struct{})}
+	if r.feed != nil {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			err := p.dumpSnapshot(tablesCtx, r)
+			if err != nil && (tablesCtx.Err() == nil || !stderrors.Is(err, tablesCtx.Err())) {
+				g.fail(diag.Source(errors.Annotate(err, "Failed to dump snapshot")))
+			} else if err == nil {
+				p.setStage(StageSnapshotDumped)
+			}
+			// the tables loading the snapshot fail with the error of the dump
+			r.feed.Finish(err)
+		}()
+	}
+	if err := p.startTables(ctx, g, r); err != nil {
+		return errors.Trace(err)
+	}
+	if err := p.watchNewTables(ctx, g, r); err != nil {
+		return errors.Trace(err)
+	}
+	p.startMonitors(g, r)
+
+	g.wg.Wait()
+	stopTables()
+	g.monitorWg.Wait()
+	return g.firstErr
+}
+
+// startTables starts the tables of the config, the snapshots are queued by --load-order
+func (p *Pipeline) startTables(ctx context.Context, g *tableGroup, r *pipelineRun) error {
+	cfg := &p.cfg
+	if order, _ := ParseLoadOrder(cfg.SnapshotOrder.LoadOrder); order != nil && cfg.Mode != RunModeIncrementalOnly {
+		// the tables bootstrapped otherwise than by replicateTable have no place in the queue
+		var queued []string
+		for _, table := range cfg.Tables {
+			if r.tableStages[table] == StageSnapshotLoaded {
+				continue
+			}
+			if r.managed != nil {
+				if bootstrap, ok := r.managed.get(table); ok && bootstrap != BootstrapInitial {
+					continue
+				}
+			}
+			queued = append(queued, table)
+		}
+		var err error
+		if p.snapshotQueue, err = newSnapshotQueueOf(order, cfg.TiDBConfig, queued, p.status); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, table := range cfg.Tables {
+		table := table
+		if r.managed != nil {
+			if bootstrap, ok := r.managed.get(table); ok {
+				p.startPatternTable(ctx, g, r, table, bootstrap)
+				continue
+			}
+		}
+		g.start(table, func() error {
+			return p.replicateTable(g.ctx, table, r.tableStages[table], r.snapshotURI, r.shardURIs, r.snapshotChecker, r.incrementChecker, r.feed, r.validator, r.scheduler, r.checkpoints, r.cdcVersion)
+		})
+	}
+	if r.managed != nil {
+		for _, table := range r.managed.all() {
+			if _, ok := g.started[table]; !ok {
+				bootstrap, _ := r.managed.get(table)
+				p.startPatternTable(ctx, g, r, table, bootstrap)
+			}
+		}
+	}
+	if cfg.AllowNewTables {
+		for _, table := range r.checkpoints[0].CreatedTables() {
+			if _, ok := g.started[table]; !ok {
+				p.startCreatedTable(g, r, table)
+			}
+		}
+	}
+	return nil
+}
+
+// startCreatedTable starts a table created after the changefeed starts, it is called with g.mu held
+func (p *Pipeline) startCreatedTable(g *tableGroup, r *pipelineRun, table string) {
+	g.start(table, func() error {
+		return p.replicateCreatedTable(g.ctx, table, r.incrementURI, r.incrementChecker, r.scheduler, r.checkpoints[0], r.cdcVersion)
+	})
+}
+
+// startPatternTable starts a table matching --table-pattern, whose replication finishes once it is dropped in TiDB.
+// It is called with g.mu held.
+func (p *Pipeline) startPatternTable(ctx context.Context, g *tableGroup, r *pipelineRun, table string, bootstrap TableBootstrap) {
+	cfg := &p.cfg
+	r.scheduler.ManageTable(table, cfg.RemovedTablePolicy)
+	g.start(table, func() error {
+		var err error
+		switch {
+		case bootstrap == BootstrapInitial && slices.Contains(cfg.Tables, table):
+			err = p.replicateTable(g.ctx, table, r.tableStages[table], r.snapshotURI, r.shardURIs, r.snapshotChecker, r.incrementChecker, r.feed, r.validator, r.scheduler, r.checkpoints, r.cdcVersion)
+		case bootstrap == BootstrapSnapshot:
+			err = p.replicateFoundTable(g.ctx, table, r.snapshotURI, r.incrementURI, r.snapshotChecker, r.incrementChecker, r.scheduler, r.checkpoints[0], r.cdcVersion)
+		default:
+			// a table matching when the replication started but dropped while tidb2dw was stopped is also
+			// replicated from its schema files until its DROP TABLE
+			err = p.replicateCreatedTable(g.ctx, table, r.incrementURI, r.incrementChecker, r.scheduler, r.checkpoints[0], r.cdcVersion)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = r.managed.remove(ctx, table); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to remove the table dropped in TiDB from the managed tables"))
+		}
+		// a table created later by the same name is replicated again
+		g.mu.Lock()
+		delete(g.started, table)
+		g.mu.Unlock()
+		return nil
+	})
+}
+
+// watchNewTables starts the watchers of the tables created after the changefeed starts with --allow-new-tables and
+// of the tables matching --table-pattern, they stop with the tables
+func (p *Pipeline) watchNewTables(ctx context.Context, g *tableGroup, r *pipelineRun) error {
+	cfg := &p.cfg
+	if cfg.AllowNewTables {
+		incrementStorage, err := openStorage(g.ctx, cfg, r.incrementURI)
+		if err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+		databases := make([]string, 0, len(cfg.Tables))
+		for _, table := range cfg.Tables {
+			if database, _ := utils.SplitTableFQN(table); !slices.Contains(databases, database) {
+				databases = append(databases, database)
+			}
+		}
+		finder := replicate.NewCreatedTableFinder(incrementStorage, databases, r.cdcVersion)
+		finder.SetExcludedTables(cfg.ExcludedTables)
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			watchCreatedTables(g.ctx, finder, r.scheduler.MergeInterval(), r.checkpoints[0], &g.mu, g.started, &g.running, func(table string) {
+				p.startCreatedTable(g, r, table)
+			})
+		}()
+	}
+
+	if r.managed != nil {
+		tidbPool, err := cfg.TiDBConfig.OpenDB()
+		if err != nil {
+			return diag.Source(errors.Trace(err))
+		}
+		incrementStorage, err := openStorage(g.ctx, cfg, r.incrementURI)
+		if err != nil {
+			tidbPool.Close()
+			return diag.Storage(errors.Trace(err))
+		}
+		watcher := &patternWatcher{
+			managed: r.managed,
+			listTables: func() ([]string, error) {
+				tables, err := tidbsql.GetTiDBTablesMatching(tidbPool, cfg.TablePatterns)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				// the tables excluded are not written by the changefeed
+				return slices.DeleteFunc(tables, func(table string) bool {
+					return tidbsql.MatchAnyTablePattern(cfg.ExcludedTables, table)
+				}), nil
+			},
+			currentTSO: func() (uint64, error) {
+				return tidbsql.GetCurrentTSO(cfg.TiDBConfig)
+			},
+			changefeedCheckpoint: newCheckpointFetcher(cfg.CDC, r.shardURIs),
+			isCreated: func(ctx context.Context, table string) (bool, error) {
+				return replicate.IsCreatedTable(ctx, incrementStorage, table, r.cdcVersion)
+			},
+			start: func(table string, bootstrap TableBootstrap) {
+				p.startPatternTable(ctx, g, r, table, bootstrap)
+			},
+			mu:      &g.mu,
+			started: g.started,
+			pending: make(map[string]uint64),
+		}
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer tidbPool.Close()
+			watcher.run(g.ctx, cfg.TablePatternInterval)
+		}()
+	}
+	return nil
+}
+
+// startMonitors starts the monitors of the warehouse idleness, of the changefeeds and of the lag, a changefeed found
+// stopped or failed stops the tables
+func (p *Pipeline) startMonitors(g *tableGroup, r *pipelineRun) {
+	cfg := &p.cfg
+	if r.scheduler != nil && cfg.IncrementOptions.SuspendWarehouseWhenIdle > 0 {
+		idler := replicate.NewWarehouseIdler(cfg.WarehouseSuspender, cfg.IncrementOptions.SuspendWarehouseWhenIdle, p.status)
+		r.scheduler.SetWarehouseIdler(idler)
+		g.monitorWg.Add(1)
+		go func() {
+			defer g.monitorWg.Done()
+			idler.Run(g.ctx)
+		}()
+	}
+	if cfg.Mode == RunModeSnapshotOnly || cfg.Mode == RunModeCloud {
+		return
+	}
+	for _, shardURI := range r.shardURIs {
+		monitor := &changefeedMonitor{
+			client:       cfg.CDC,
+			incrementURI: shardURI,
+			policy:       cfg.ChangefeedRecovery,
+			status:       p.status,
+			onNotRunning: func(msg string) {
+				p.notify(notify.EventChangefeedFailed, "", msg, nil)
+			},
+		}
+		g.monitorWg.Add(1)
+		go func() {
+			defer g.monitorWg.Done()
+			if err := monitor.run(g.ctx); err != nil {
+				g.fail(errors.Annotate(err, "Failed to replicate increment"))
+				g.stop()
+			}
+		}()
+	}
+	if cfg.Notifier.Enabled(notify.EventLag) {
+		g.monitorWg.Add(1)
+		go func() {
+			defer g.monitorWg.Done()
+			p.watchLag(g.ctx)
+		}()
+	}
+}
+
+// finish pauses the changefeeds with --pause-changefeed-on-exit if the replication is stopped, parentCtx is the
+// context of Run
+func (p *Pipeline) finish(parentCtx context.Context, r *pipelineRun) {
+	cfg := &p.cfg
+	if parentCtx.Err() == nil || !cfg.PauseChangefeedOnExit {
+		return
+	}
+	for _, shardURI := range r.shardURIs {
+		if err := pauseChangefeed(cfg.CDC, shardURI); err != nil {
+			log.Error("Failed to pause changefeed on exit", zap.Error(err))
+		}
+	}
+}
+
+// dryRunReplicate calls the connectors of the tables one by one as the replication starting from StageInit does.
+// The changefeed is not created and the snapshot is not dumped, the files in the storage are stood for by placeholders.
+func dryRunReplicate(ctx context.Context, cfg *PipelineConfig) error {
+	_, incrementURI, err := GenSnapshotAndIncrementURIs(cfg.StorageURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Start dry run, the stage is simulated", zap.String("stage", string(StageInit)), zap.String("mode", RunModeIds[cfg.Mode][0]))
+	if cfg.AllowNewTables {
+		log.Warn("Ignored --allow-new-tables in dry run, the tables created after the changefeed starts are unknown")
+	}
+	if len(cfg.TablePatterns) > 0 {
+		log.Warn("Only the tables matching --table-pattern now are checked in dry run, the tables found later are unknown")
+	}
+	for _, table := range cfg.Tables {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if cfg.Mode != RunModeIncrementalOnly {
+			if err := replicate.DryRunSnapshot(cfg.SnapConnectorMap[table], table, cfg.TiDBConfig, cfg.SnapshotCompression, cfg.StagingFormat); err != nil {
+				return errors.Annotatef(err, "Failed to render snapshot load of table %s", table)
+			}
+		}
+		if cfg.Mode != RunModeSnapshotOnly {
+			if err := replicate.DryRunIncrement(cfg.IncreConnectorMap[table], table, cfg.TiDBConfig, incrementURI, cfg.IncrementCompression, cfg.StagingFormat, cfg.PKLess); err != nil {
+				return errors.Annotatef(err, "Failed to render increment load of table %s", table)
+			}
+		}
+	}
+	log.Info("Dry run finished", zap.Int("tables", len(cfg.Tables)))
+	return nil
+}
+
+// watchCreatedTables starts the replication of the tables created after the changefeed starts, until ctx
+// is canceled or no table is running. started and running are guarded by mu, and startCreatedTable is called
+// with mu held.
+func watchCreatedTables(
+	ctx context.Context,
+	finder *replicate.CreatedTableFinder,
+	interval time.Duration,
+	checkpoint *replicate.IncrementCheckpoint,
+	mu *sync.Mutex,
+	started map[string]struct{},
+	running *int,
+	startCreatedTable func(table string),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mu.Lock()
+		if *running == 0 {
+			mu.Unlock()
+			return
+		}
+		replicated := make(map[string]struct{}, len(started))
+		for table := range started {
+			replicated[table] = struct{}{}
+		}
+		mu.Unlock()
+
+		created, err := finder.Find(ctx, func(table string) bool {
+			_, ok := replicated[table]
+			return ok
+		})
+		if err != nil {
+			log.Warn("Failed to find the tables created, retry in the next round", zap.Error(err))
+			continue
+		}
+		for _, table := range created {
+			// recorded before the table is created in the data warehouse, so that it is replicated after restart
+			if err = checkpoint.AddCreatedTable(ctx, table); err != nil {
+				log.Warn("Failed to record the table created, retry in the next round", zap.String("table", table), zap.Error(err))
+				break
+			}
+			log.Info("Found table created after the changefeed starts, replicating it", zap.String("table", table))
+			mu.Lock()
+			if *running == 0 {
+				mu.Unlock()
+				return
+			}
+			startCreatedTable(table)
+			mu.Unlock()
+		}
+	}
+}
+
+func (p *Pipeline) replicateTable(
+	ctx context.Context,
+	table string,
+	stage Stage,
+	snapshotURI *url.URL,
+	shardURIs []*url.URL,
+	snapshotChecker, incrementChecker *fieldlimit.Checker,
+	feed *dumpling.FileFeed,
+	validator *replicate.SnapshotValidator,
+	scheduler *replicate.IncrementScheduler,
+	checkpoints []*replicate.IncrementCheckpoint,
+	cdcVersion string,
+) error {
+	cfg := &p.cfg
+	if cfg.Mode != RunModeIncrementalOnly && stage != StageSnapshotLoaded {
+		if p.snapshotQueue != nil {
+			if err := p.snapshotQueue.wait(ctx, table); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		p.status.SetTableStage(table, apiservice.TableStageLoadingSnapshot)
+		err := replicate.StartReplicateSnapshot(ctx, cfg.SnapConnectorMap[table], table, cfg.TiDBConfig, snapshotURI, cfg.SnapshotCompression, p.converter, snapshotChecker, cfg.ColumnFilter.Table(table), cfg.Where[table], feed, validator, cfg.RetryPolicy, cfg.RateLimiters, p.status)
+		if p.snapshotQueue != nil {
+			p.snapshotQueue.done(table)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = p.onTableSnapshotLoaded(cfg.SnapConnectorMap[table]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.Mode != RunModeSnapshotOnly {
+		p.status.SetTableStage(table, apiservice.TableStageLoadingIncremental)
+		if err := replicate.StartReplicateIncrement(ctx, cfg.IncreConnectorMap[table], table, shardURIs, scheduler, cfg.IncrementCompression, incrementChecker, cfg.UnknownDDLPolicy, cfg.RenamePolicy, p.columnExprs[table], cfg.AllowNewTables, cdcVersion, checkpoints, cfg.IncrementOptions.Cleanup, p.status); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// replicateCreatedTable replicates a table created after the changefeed starts, it has no snapshot and
+// is created in the data warehouse by its CREATE TABLE DDL.
+func (p *Pipeline) replicateCreatedTable(
+	ctx context.Context,
+	table string,
+	incrementURI *url.URL,
+	incrementChecker *fieldlimit.Checker,
+	scheduler *replicate.IncrementScheduler,
+	checkpoint *replicate.IncrementCheckpoint,
+	cdcVersion string,
+) error {
+	if err := scheduler.AddTable(table); err != nil {
+		return errors.Trace(err)
+	}
+	cfg := &p.cfg
+	connector, err := cfg.NewIncreConnector(table)
+	if err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	p.status.SetTableStage(table, apiservice.TableStageLoadingIncremental)
+	return errors.Trace(replicate.StartReplicateIncrement(ctx, connector, table, []*url.URL{incrementURI}, scheduler, cfg.IncrementCompression, incrementChecker, cfg.UnknownDDLPolicy, cfg.RenamePolicy, nil, true, cdcVersion, []*replicate.IncrementCheckpoint{checkpoint}, cfg.IncrementOptions.Cleanup, p.status))
+}
+
+// replicateFoundTable replicates a table matching --table-pattern which is found after the replication starts but
+// not created after the changefeed starts, e.g. renamed into the pattern. Its snapshot is dumped into a directory of
+// its own, and dumped again if the load is interrupted. The increment files before the snapshot are merged again,
+// which converges since the rows are merged by the primary key.
+func (p *Pipeline) replicateFoundTable(
+	ctx context.Context,
+	table string,
+	snapshotURI, incrementURI *url.URL,
+	snapshotChecker, incrementChecker *fieldlimit.Checker,
+	scheduler *replicate.IncrementScheduler,
+	checkpoint *replicate.IncrementCheckpoint,
+	cdcVersion string,
+) error {
+	if err := scheduler.AddTable(table); err != nil {
+		return errors.Trace(err)
+	}
+	cfg := &p.cfg
+	tableCfg := *cfg
+	tableCfg.Tables = []string{table}
+	columnExprs := loadColumnExprs(&tableCfg)
+	if cfg.Mode != RunModeIncrementalOnly {
+		uri, err := tableSnapshotURI(snapshotURI, table)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		snapshotStorage, err := openStorage(ctx, cfg, uri)
+		if err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+		sourceDatabase, sourceTable := utils.SplitTableFQN(table)
+		loaded, err := snapshotStorage.FileExists(ctx, replicate.SnapshotLoadInfoFile(sourceDatabase, sourceTable))
+		if err != nil {
+			return diag.Storage(errors.Annotatef(err, "Failed to check snapshot loadinfo of table %s", table))
+		}
+		if !loaded {
+			p.status.SetTableStage(table, apiservice.TableStageLoadingSnapshot)
+			if _, err = deleteAllFiles(ctx, snapshotStorage); err != nil {
+				return diag.Storage(errors.Annotatef(err, "Failed to clean the snapshot of table %s", table))
+			}
+			projections, err := checkColumnFilter(&tableCfg)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			filters := dumpFilters(&tableCfg, projections, columnExprs)
+			if err = dumpling.RunDump(ctx, cfg.TiDBConfig, cfg.SnapshotConcurrency, uri, "0", tableCfg.Tables, filters, cfg.SnapshotCompression, cfg.DumpChunkConfig, cfg.RateLimiters, nil, nil); err != nil {
+				return diag.Source(errors.Trace(err))
+			}
+			validator, err := newSnapshotValidator(ctx, cfg, uri)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			connector, err := cfg.NewSnapConnector(table, uri)
+			if err != nil {
+				return diag.Warehouse(errors.Trace(err))
+			}
+			err = replicate.StartReplicateSnapshot(ctx, connector, table, cfg.TiDBConfig, uri, cfg.SnapshotCompression, p.converter, snapshotChecker, cfg.ColumnFilter.Table(table), cfg.Where[table], nil, validator, cfg.RetryPolicy, cfg.RateLimiters, p.status)
+			if err == nil {
+				err = p.onTableSnapshotLoaded(connector)
+			}
+			connector.Close()
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	connector, err := cfg.NewIncreConnector(table)
+	if err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	p.status.SetTableStage(table, apiservice.TableStageLoadingIncremental)
+	return errors.Trace(replicate.StartReplicateIncrement(ctx, connector, table, []*url.URL{incrementURI}, scheduler, cfg.IncrementCompression, incrementChecker, cfg.UnknownDDLPolicy, cfg.RenamePolicy, columnExprs[table], cfg.AllowNewTables, cdcVersion, []*replicate.IncrementCheckpoint{checkpoint}, cfg.IncrementOptions.Cleanup, p.status))
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPipeline(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.Tables = nil
+	_, err := NewPipeline(cfg)
+	require.ErrorContains(t, err, "no table to replicate")
+
+	cfg = newTestConfig(t)
+	cfg.IncreConnectorMap = nil
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "no increment connector of table test.t")
+
+	// the increment connectors are not needed by the snapshot
+	cfg.Mode = RunModeSnapshotOnly
+	_, err = NewPipeline(cfg)
+	require.NoError(t, err)
+
+	cfg = newTestConfig(t)
+	cfg.StartTSO = 1
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "--start-tso is only available in --mode=incremental-only")
+
+	cfg = newTestConfig(t)
+	cfg.AllowNewTables = true
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "no increment connector of the tables created")
+
+	cfg = newTestConfig(t)
+	cfg.TablePatterns = []tidbsql.TablePattern{{Database: "test", Table: "t_*"}}
+	cfg.TablePatternInterval = time.Minute
+	cfg.RemovedTablePolicy = replicate.RemovedTableKeep
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "no connector of the tables found by --table-pattern")
+	cfg.NewIncreConnector = func(string) (coreinterfaces.Connector, error) { return fakeConnector{}, nil }
+	cfg.NewSnapConnector = func(string, *url.URL) (coreinterfaces.Connector, error) { return fakeConnector{}, nil }
+	_, err = NewPipeline(cfg)
+	require.NoError(t, err)
+	cfg.RemovedTablePolicy = ""
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "unknown removed table policy")
+	cfg.Mode = RunModeCloud
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "--table-pattern is not available in --mode=cloud")
+
+	cfg = newTestConfig(t)
+	cfg.IncrementOptions.Shards = 4
+	_, err = NewPipeline(cfg)
+	require.NoError(t, err)
+	cfg.Mode = RunModeCloud
+	_, err = NewPipeline(cfg)
+	require.ErrorContains(t, err, "--increment-shards is only available when the changefeeds are managed by tidb2dw")
+}
+
+func TestPipelineStop(t *testing.T) {
+	pipeline, err := NewPipeline(newTestConfig(t))
+	require.NoError(t, err)
+	require.Equal(t, Stage(""), pipeline.Stage())
+	require.Empty(t, pipeline.Progress().TablesInfo)
+
+	// Run returns at once after Stop, and only once
+	require.NoError(t, pipeline.Stop(context.Background()))
+	require.NoError(t, pipeline.Run(context.Background()))
+	require.ErrorContains(t, pipeline.Run(context.Background()), "already run")
+	require.NoError(t, pipeline.Stop(context.Background()))
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// RemoveConfig is the configuration of `tidb2dw remove`, which tears down the replication of a workspace
+type RemoveConfig struct {
+	StorageURI *url.URL
+	// CDC is the client of the HTTP API of TiCDC
+	CDC *cdc.Client
+	// TiDBCloud removes the changefeeds created through the TiDB Cloud API in --mode=cloud, the TiCDC server is not
+	// requested if it is set
+	TiDBCloud *tidbcloud.Client
+	// DeleteFiles deletes the snapshot and increment files of the workspace
+	DeleteFiles bool
+	// DropTables drops the tables replicated into the data warehouse, nil keeps them
+	DropTables func(ctx context.Context) error
+}
+
+// Remove removes the changefeeds recorded in the increment storage of the shards and any other changefeed writing
+// into the workspace, then drops the tables and deletes the files if asked. The files are deleted last, so a
+// failed removal is run again with the changefeeds still recorded.
+func Remove(ctx context.Context, cfg *RemoveConfig) error {
+	snapshotURI, incrementURI, err := GenSnapshotAndIncrementURIs(cfg.StorageURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	shardURIs, err := FindIncrementShardURIs(ctx, incrementURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	removed := 0
+	for _, shardURI := range shardURIs {
+		record, found, err := loadChangefeedRecord(ctx, shardURI)
+		if err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+		if !found {
+			continue
+		}
+		if record.TiDBCloudCluster != "" {
+			deleted, err := removeCloudChangefeed(ctx, cfg.TiDBCloud, record)
+			if err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+			if deleted {
+				removed++
+			}
+			continue
+		}
+		if cfg.TiDBCloud != nil {
+			return diag.CDC(errors.Errorf("Changefeed %s recorded in the workspace is of the TiCDC server, remove it without --tidbcloud.*", record.ID))
+		}
+		changefeed := &cdc.Changefeed{ID: record.ID, Namespace: record.Namespace}
+		if _, found, err = cdc.CheckChangefeed(cfg.CDC, changefeed, shardURI); err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		if !found {
+			log.Info("Changefeed recorded in the workspace is already removed", zap.String("changefeed", changefeed.ID))
+			continue
+		}
+		if err = cdc.RemoveChangefeed(cfg.CDC, changefeed); err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		log.Info("Removed changefeed recorded in the workspace", zap.String("changefeed", changefeed.ID))
+		removed++
+	}
+	// the changefeeds of a workspace replicated by an older tidb2dw are not recorded
+	if cfg.TiDBCloud == nil {
+		changefeeds, err := cdc.FindChangefeedsWithin(cfg.CDC, cfg.StorageURI)
+		if err != nil {
+			return diag.CDC(errors.Annotate(err, "Failed to find the changefeeds writing into the workspace"))
+		}
+		for _, changefeed := range changefeeds {
+			if err = cdc.RemoveChangefeed(cfg.CDC, changefeed); err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+			log.Info("Removed changefeed writing into the workspace", zap.String("changefeed", changefeed.ID))
+			removed++
+		}
+	}
+	if removed == 0 {
+		log.Warn("No changefeed writes into the workspace", zap.String("storage", utils.RedactStorageURI(cfg.StorageURI)))
+	}
+
+	if cfg.DropTables != nil {
+		if err = cfg.DropTables(ctx); err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+	}
+	if cfg.DeleteFiles {
+		if err = deleteWorkspaceFiles(ctx, snapshotURI, incrementURI); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// removeCloudChangefeed deletes the changefeed recorded in the workspace through the TiDB Cloud API, deleted is false
+// if it is already gone
+func removeCloudChangefeed(ctx context.Context, client *tidbcloud.Client, record *changefeedFileData) (deleted bool, err error) {
+	if client == nil {
+		return false, errors.Errorf("Changefeed %s recorded in the workspace is created through the TiDB Cloud API of cluster %s, "+
+			"set --tidbcloud.public-key, --tidbcloud.private-key and --tidbcloud.cluster-id to remove it", record.ID, record.TiDBCloudCluster)
+	}
+	if record.TiDBCloudCluster != client.ClusterID() {
+		return false, errors.Errorf("Changefeed %s recorded in the workspace is of TiDB Cloud cluster %s rather than %s",
+			record.ID, record.TiDBCloudCluster, client.ClusterID())
+	}
+	_, found, err := client.GetChangefeed(ctx, record.ID)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if !found {
+		log.Info("TiDB Cloud changefeed recorded in the workspace is already deleted", zap.String("changefeed", record.ID))
+		return false, nil
+	}
+	if err = client.DeleteChangefeed(ctx, record.ID); err != nil {
+		return false, errors.Trace(err)
+	}
+	log.Info("Deleted TiDB Cloud changefeed recorded in the workspace", zap.String("changefeed", record.ID))
+	return true, nil
+}
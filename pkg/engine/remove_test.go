@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangefeedFile(t *testing.T) {
+	ctx := context.Background()
+	shardURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	_, found, err := loadChangefeedFile(ctx, shardURI)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	changefeed := &cdc.Changefeed{ID: "mine", Namespace: "default", Protocol: cdcreader.ProtocolCanalJSON}
+	require.NoError(t, writeChangefeedFile(ctx, shardURI, changefeed))
+	got, found, err := loadChangefeedFile(ctx, shardURI)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, changefeed, got)
+
+	require.NoError(t, os.WriteFile(filepath.Join(shardURI.Path, ChangefeedFile), []byte(`{}`), 0o644))
+	_, _, err = loadChangefeedFile(ctx, shardURI)
+	require.ErrorContains(t, err, "invalid changefeed file")
+}
+
+func TestResolveCDCProtocol(t *testing.T) {
+	ctx := context.Background()
+	shardURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	cfg := &PipelineConfig{}
+	protocol, err := resolveCDCProtocol(ctx, cfg, []*url.URL{shardURI})
+	require.NoError(t, err)
+	require.Equal(t, cdcreader.ProtocolCSV, protocol)
+
+	// the files written by a changefeed managed outside of tidb2dw
+	require.NoError(t, os.MkdirAll(filepath.Join(shardURI.Path, "db", "t", "100", "2024-01-01"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(shardURI.Path, "db", "t", "100", "2024-01-01", "CDC00000000000000000001.json"), []byte("{}"), 0o644))
+	protocol, err = resolveCDCProtocol(ctx, cfg, []*url.URL{shardURI})
+	require.NoError(t, err)
+	require.Equal(t, cdcreader.ProtocolCanalJSON, protocol)
+
+	// the changefeed recorded by an older tidb2dw writes the CSV files
+	require.NoError(t, writeChangefeedFile(ctx, shardURI, &cdc.Changefeed{ID: "mine"}))
+	protocol, err = resolveCDCProtocol(ctx, cfg, []*url.URL{shardURI})
+	require.NoError(t, err)
+	require.Equal(t, cdcreader.ProtocolCSV, protocol)
+	cfg.CDCProtocol = cdcreader.ProtocolCanalJSON
+	_, err = resolveCDCProtocol(ctx, cfg, []*url.URL{shardURI})
+	require.ErrorContains(t, err, "--cdc-protocol=canal-json conflicts with changefeed mine writing the files in csv")
+}
+
+func TestRemove(t *testing.T) {
+	ctx := context.Background()
+	storageURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	_, incrementURI, err := GenSnapshotAndIncrementURIs(storageURI)
+	require.NoError(t, err)
+	require.NoError(t, writeChangefeedFile(ctx, incrementURI, &cdc.Changefeed{ID: "mine", Namespace: "default"}))
+	require.NoError(t, os.MkdirAll(filepath.Join(storageURI.Path, "snapshot"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(storageURI.Path, "snapshot", "db.t.000000000.csv"), []byte("1"), 0o644))
+
+	items := map[string]string{
+		"mine":  incrementURI.String(),
+		"older": storageURI.String() + "/increment/shard-1",
+		"other": "file:///other/increment",
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/changefeeds", func(w http.ResponseWriter, r *http.Request) {
+		list := `{"items":[`
+		for _, id := range []string{"mine", "older", "other"} {
+			if _, ok := items[id]; ok {
+				if list[len(list)-1] != '[' {
+					list += ","
+				}
+				list += `{"id":"` + id + `","namespace":"default"}`
+			}
+		}
+		_, _ = w.Write([]byte(list + `]}`))
+	})
+	mux.HandleFunc("/api/v2/changefeeds/", func(w http.ResponseWriter, r *http.Request) {
+		id := filepath.Base(r.URL.Path)
+		if r.Method == http.MethodDelete {
+			delete(items, id)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"` + id + `","sink_uri":"` + items[id] + `"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host, portStr, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+	client, err := cdc.NewClient(host, port, nil)
+	require.NoError(t, err)
+
+	dropped := false
+	cfg := &RemoveConfig{
+		StorageURI: storageURI,
+		CDC:        client,
+		DropTables: func(context.Context) error {
+			dropped = true
+			return nil
+		},
+	}
+	require.NoError(t, Remove(ctx, cfg))
+	require.Equal(t, map[string]string{"other": "file:///other/increment"}, items)
+	require.True(t, dropped)
+	// the files are kept unless asked
+	_, err = os.Stat(filepath.Join(incrementURI.Path, ChangefeedFile))
+	require.NoError(t, err)
+
+	// removing again finds the changefeed recorded already removed
+	cfg.DropTables, cfg.DeleteFiles = nil, true
+	require.NoError(t, Remove(ctx, cfg))
+	_, err = os.Stat(filepath.Join(incrementURI.Path, ChangefeedFile))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(storageURI.Path, "snapshot", "db.t.000000000.csv"))
+	require.True(t, os.IsNotExist(err))
+}
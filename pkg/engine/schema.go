@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// SchemaSyncConfig is the configuration of `tidb2dw schema sync`
+type SchemaSyncConfig struct {
+	TiDBConfig *tidbsql.TiDBConfig
+	Tables     []string
+	// Connectors create or alter the tables in the data warehouse, with the settings of the replication applied
+	Connectors map[string]coreinterfaces.Connector
+	PKLess     pkless.Policy
+}
+
+// SchemaSyncResult is what is done to a table by `tidb2dw schema sync`
+type SchemaSyncResult struct {
+	Action replicate.SchemaSyncAction
+	// Drift describes the columns altered
+	Drift string
+	// Error is why the table is not synced, empty if it is
+	Error string
+}
+
+// SyncSchema creates the tables in the data warehouse from their schemas in TiDB, or alters them to the columns of
+// TiDB if they exist, without loading any row. A table failed to be synced is reported with its error.
+func SyncSchema(ctx context.Context, cfg *SchemaSyncConfig) (map[string]*SchemaSyncResult, error) {
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+	results := make(map[string]*SchemaSyncResult, len(cfg.Tables))
+	for _, table := range cfg.Tables {
+		if ctx.Err() != nil {
+			return nil, errors.Trace(ctx.Err())
+		}
+		action, drift, err := replicate.SyncTableSchema(cfg.Connectors[table], table, tidbPool, cfg.PKLess)
+		if err != nil {
+			log.Error("Failed to sync table schema", zap.String("table", table), zap.Error(err))
+			results[table] = &SchemaSyncResult{Error: err.Error()}
+			continue
+		}
+		result := &SchemaSyncResult{Action: action}
+		if drift != nil {
+			result.Drift = drift.String()
+		}
+		log.Info("Synced table schema", zap.String("table", table), zap.String("action", string(action)))
+		results[table] = result
+	}
+	return results, nil
+}
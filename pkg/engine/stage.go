@@ -0,0 +1,198 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// o => create changefeed =>   dump snapshot   => load snapshot => incremental load
+//
+//	^                     ^                    ^ 				 ^
+//	|			          |				       |				 |
+//	+------ init ---------+ changefeed created + snapshot dumped + snapshot loaded --
+//
+// The changefeed and the snapshot are shared by all tables, while the snapshot of each table is loaded separately.
+type Stage string
+
+const (
+	StageInit              Stage = "init"
+	StageChangefeedCreated Stage = "changefeed-created"
+	StageSnapshotDumped    Stage = "snapshot-dumped"
+	StageSnapshotLoaded    Stage = "snapshot-loaded"
+)
+
+// legacyLoadInfoFile is written by the versions recording the snapshot loaded for all tables at once
+const legacyLoadInfoFile = "snapshot/loadinfo"
+
+// ErrCorruptedWorkspace is returned when the storage path holds the load info of the snapshot but not the snapshot
+// itself, e.g. the snapshot is deleted by hand, so neither the snapshot nor its load can be resumed
+var ErrCorruptedWorkspace = errors.New("corrupted workspace, clean the storage path, e.g. by --clean-workspace, and start again")
+
+// stageRetryPolicy retries the checks of the marker files failed by a throttled or unavailable storage
+var stageRetryPolicy = retry.Policy{MaxRetries: 3, MaxBackoff: 5 * time.Second}
+
+// fileExists tells whether the file exists in the storage, a missing file is not an error while the transient
+// errors of the storage are retried
+func fileExists(ctx context.Context, storage storage.ExternalStorage, path string) (bool, error) {
+	var exists bool
+	err := retry.Do(ctx, stageRetryPolicy, utils.IsTransientStorageError, func() error {
+		var err error
+		exists, err = storage.FileExists(ctx, path)
+		return err
+	}, func(retry int, backoff time.Duration, err error) {
+		log.Warn("Failed to check file in storage, retrying",
+			zap.String("path", path), zap.Int("retry", retry+1), zap.Duration("backoff", backoff), zap.Error(err))
+	})
+	if err != nil {
+		return false, errors.Annotatef(err, "Failed to check %s in storage", path)
+	}
+	return exists, nil
+}
+
+// checkStage returns the stage shared by all tables, which is StageSnapshotDumped at most. No changefeed is
+// created in --mode=snapshot-only, so the increment files are not checked and the stage goes from StageInit to
+// StageSnapshotDumped directly. The changefeeds of the shards are created in reverse order, so the metadata of
+// the first shard tells all of them are created. The error is only of the storage, or ErrCorruptedWorkspace if
+// the snapshot of the tables is loaded but not dumped.
+func checkStage(ctx context.Context, storage storage.ExternalStorage, mode RunMode, shards int, tables []string) (Stage, error) {
+	var found []string
+	stage := StageInit
+	if mode != RunModeSnapshotOnly {
+		metadata := "increment/metadata"
+		if shards > 1 {
+			metadata = "increment/" + incrementShardDir(0) + "/metadata"
+		}
+		exist, err := fileExists(ctx, storage, metadata)
+		if err != nil {
+			return stage, errors.Trace(err)
+		}
+		if exist {
+			found = append(found, metadata)
+			stage = StageChangefeedCreated
+		}
+	}
+	snapshotDumped, err := fileExists(ctx, storage, "snapshot/metadata")
+	if err != nil {
+		return stage, errors.Trace(err)
+	}
+	if snapshotDumped {
+		found = append(found, "snapshot/metadata")
+	}
+	loadInfos := []string{legacyLoadInfoFile}
+	for _, table := range tables {
+		loadInfos = append(loadInfos, "snapshot/"+replicate.SnapshotLoadInfoFile(utils.SplitTableFQN(table)))
+	}
+	for _, loadInfo := range loadInfos {
+		exist, err := fileExists(ctx, storage, loadInfo)
+		if err != nil {
+			return stage, errors.Trace(err)
+		}
+		if !exist {
+			continue
+		}
+		if !snapshotDumped {
+			return stage, errors.Annotatef(ErrCorruptedWorkspace, "found %s without snapshot/metadata", loadInfo)
+		}
+		found = append(found, loadInfo)
+	}
+	// the snapshot is dumped after the changefeed is created, one found without the changefeed is dumped again
+	if snapshotDumped && (stage == StageChangefeedCreated || mode == RunModeSnapshotOnly) {
+		stage = StageSnapshotDumped
+	}
+	log.Info("Checked the stage of the replication", zap.String("stage", string(stage)), zap.Strings("found", found))
+	return stage, nil
+}
+
+// warnStaleIncrement warns about the increment files left by a replication of another mode in --mode=snapshot-only,
+// the changefeed writing them may still be running
+func warnStaleIncrement(ctx context.Context, storage storage.ExternalStorage) error {
+	exist, err := fileExists(ctx, storage, "increment/metadata")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if exist {
+		log.Warn("Found increment files left by a previous replication, the changefeed writing them may be still running, " +
+			"set --clean-workspace to remove them and the changefeed")
+	}
+	return nil
+}
+
+// checkTableStages returns the stage of each table, a crash after the snapshot of some tables is loaded
+// resumes by loading the snapshot of the other tables only
+func checkTableStages(ctx context.Context, storage storage.ExternalStorage, stage Stage, tables []string) (map[string]Stage, error) {
+	stages := make(map[string]Stage, len(tables))
+	for _, table := range tables {
+		stages[table] = stage
+	}
+	if stage != StageSnapshotDumped {
+		return stages, nil
+	}
+	legacyLoaded, err := fileExists(ctx, storage, legacyLoadInfoFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, table := range tables {
+		sourceDatabase, sourceTable := utils.SplitTableFQN(table)
+		loaded, err := fileExists(ctx, storage, "snapshot/"+replicate.SnapshotLoadInfoFile(sourceDatabase, sourceTable))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if loaded || legacyLoaded {
+			stages[table] = StageSnapshotLoaded
+		}
+	}
+	return stages, nil
+}
+
+// resetSnapshotLoadProgress deletes the load progress of the tables left by an interrupted pipelined snapshot,
+// the snapshot is dumped again so the files recorded are stale
+func resetSnapshotLoadProgress(ctx context.Context, storage storage.ExternalStorage, tables []string) error {
+	for _, table := range tables {
+		path := "snapshot/" + replicate.SnapshotLoadProgressFile(utils.SplitTableFQN(table))
+		exists, err := fileExists(ctx, storage, path)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !exists {
+			continue
+		}
+		if err = storage.DeleteFile(ctx, path); err != nil {
+			return errors.Annotatef(err, "Failed to delete stale snapshot load progress of table %s", table)
+		}
+		log.Info("Deleted stale snapshot load progress, the snapshot is dumped and loaded again", zap.String("table", table))
+	}
+	return nil
+}
+
+// clearDumpProgress deletes the progress of the unfinished dump, so that all the tables of the snapshot are dumped again.
+func clearDumpProgress(ctx context.Context, snapshotURI *url.URL) error {
+	snapshotStorage, err := utils.GetExternalStorageFromURI(ctx, snapshotURI.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(dumpling.ClearDumpProgress(ctx, snapshotStorage), "Failed to delete the progress of the unfinished dump")
+}
+
+// loadIncrementCheckpoint reads the checkpoint of the increment files merged before the restart,
+// a new replication starts with an empty one.
+func loadIncrementCheckpoint(ctx context.Context, incrementURI *url.URL, stage Stage) (*replicate.IncrementCheckpoint, error) {
+	incrementStorage, err := utils.GetExternalStorageFromURI(ctx, incrementURI.String())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if stage == StageInit {
+		return replicate.NewIncrementCheckpoint(incrementStorage), nil
+	}
+	checkpoint, err := replicate.LoadIncrementCheckpoint(ctx, incrementStorage)
+	return checkpoint, errors.Annotate(err, "Failed to load increment checkpoint")
+}
@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckStage(t *testing.T) {
+	ctx := context.Background()
+	storageURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	storage, err := utils.GetExternalStorageFromURI(ctx, storageURI.String())
+	require.NoError(t, err)
+
+	stage, err := checkStage(ctx, storage, RunModeFull, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageInit, stage)
+
+	// the increment files left by a full replication do not block the snapshot of --mode=snapshot-only
+	require.NoError(t, storage.WriteFile(ctx, "increment/metadata", []byte("{}")))
+	stage, err = checkStage(ctx, storage, RunModeFull, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageChangefeedCreated, stage)
+	stage, err = checkStage(ctx, storage, RunModeSnapshotOnly, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageInit, stage)
+	require.NoError(t, warnStaleIncrement(ctx, storage))
+
+	require.NoError(t, storage.WriteFile(ctx, "snapshot/metadata", []byte("{}")))
+	stage, err = checkStage(ctx, storage, RunModeSnapshotOnly, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageSnapshotDumped, stage)
+
+	deleted, err := deleteAllFiles(ctx, storage)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	stage, err = checkStage(ctx, storage, RunModeFull, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageInit, stage)
+
+	// the changefeed of the first shard is created last
+	require.NoError(t, storage.WriteFile(ctx, "increment/shard-1/metadata", []byte("{}")))
+	stage, err = checkStage(ctx, storage, RunModeFull, 2, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageInit, stage)
+	require.NoError(t, storage.WriteFile(ctx, "increment/shard-0/metadata", []byte("{}")))
+	stage, err = checkStage(ctx, storage, RunModeFull, 2, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageChangefeedCreated, stage)
+
+	shardURIs, err := IncrementShardURIs(&url.URL{Scheme: "s3", Host: "bucket", Path: "/prefix/increment"}, 2)
+	require.NoError(t, err)
+	require.Equal(t, "s3://bucket/prefix/increment/shard-1", shardURIs[1].String())
+}
+
+// flakyStorage fails the checks of the files with the errors before it checks them
+type flakyStorage struct {
+	storage.ExternalStorage
+	errs []error
+}
+
+func (s *flakyStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return false, err
+	}
+	return s.ExternalStorage.FileExists(ctx, name)
+}
+
+func TestCheckStageErrors(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	defer func(policy retry.Policy) { stageRetryPolicy = policy }(stageRetryPolicy)
+	stageRetryPolicy = retry.Policy{MaxRetries: 2, MaxBackoff: time.Millisecond}
+
+	require.NoError(t, extStorage.WriteFile(ctx, "increment/metadata", []byte("{}")))
+	require.NoError(t, extStorage.WriteFile(ctx, "snapshot/metadata", []byte("{}")))
+
+	// a throttled storage is retried
+	flaky := &flakyStorage{ExternalStorage: extStorage, errs: []error{
+		errors.New("SlowDown: Please reduce your request rate. status code: 503"),
+	}}
+	stage, err := checkStage(ctx, flaky, RunModeFull, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, StageSnapshotDumped, stage)
+
+	// a failure of the storage is returned with the file checked
+	flaky.errs = []error{errors.New("AccessDenied: Access Denied")}
+	_, err = checkStage(ctx, flaky, RunModeFull, 1, nil)
+	require.ErrorContains(t, err, "Failed to check increment/metadata in storage")
+	require.ErrorContains(t, err, "AccessDenied")
+
+	// the snapshot loaded but not dumped can not be resumed
+	require.NoError(t, extStorage.WriteFile(ctx, "snapshot/"+replicate.SnapshotLoadInfoFile("test", "t1"), []byte("{}")))
+	stage, err = checkStage(ctx, extStorage, RunModeFull, 1, []string{"test.t1"})
+	require.NoError(t, err)
+	require.Equal(t, StageSnapshotDumped, stage)
+	require.NoError(t, extStorage.DeleteFile(ctx, "snapshot/metadata"))
+	_, err = checkStage(ctx, extStorage, RunModeFull, 1, []string{"test.t1"})
+	require.Equal(t, ErrCorruptedWorkspace, errors.Cause(err))
+	require.ErrorContains(t, err, "found snapshot/test.t1")
+	require.NoError(t, extStorage.WriteFile(ctx, legacyLoadInfoFile, []byte("{}")))
+	_, err = checkStage(ctx, extStorage, RunModeSnapshotOnly, 1, nil)
+	require.Equal(t, ErrCorruptedWorkspace, errors.Cause(err))
+	require.ErrorContains(t, err, "found snapshot/loadinfo without snapshot/metadata")
+}
+
+// fakeStorage has the files given, FileExists fails with err if it is set
+type fakeStorage struct {
+	storage.ExternalStorage
+	files map[string]bool
+	err   error
+}
+
+func (s *fakeStorage) FileExists(_ context.Context, name string) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.files[name], nil
+}
+
+func TestCheckTableStages(t *testing.T) {
+	defer func(policy retry.Policy) { stageRetryPolicy = policy }(stageRetryPolicy)
+	stageRetryPolicy = retry.Policy{MaxRetries: 1, MaxBackoff: time.Millisecond}
+	tables := []string{"test.t1", "test.t2"}
+	loadInfo := func(table string) string {
+		return "snapshot/" + replicate.SnapshotLoadInfoFile(utils.SplitTableFQN(table))
+	}
+
+	for _, c := range []struct {
+		name     string
+		stage    Stage
+		files    []string
+		err      error
+		expected map[string]Stage
+		errMsg   string
+	}{
+		{
+			name:     "none loaded",
+			stage:    StageSnapshotDumped,
+			expected: map[string]Stage{"test.t1": StageSnapshotDumped, "test.t2": StageSnapshotDumped},
+		},
+		{
+			name:     "some loaded",
+			stage:    StageSnapshotDumped,
+			files:    []string{loadInfo("test.t2")},
+			expected: map[string]Stage{"test.t1": StageSnapshotDumped, "test.t2": StageSnapshotLoaded},
+		},
+		{
+			name:     "legacy loadinfo",
+			stage:    StageSnapshotDumped,
+			files:    []string{legacyLoadInfoFile},
+			expected: map[string]Stage{"test.t1": StageSnapshotLoaded, "test.t2": StageSnapshotLoaded},
+		},
+		{
+			// the load info is not checked before the snapshot is dumped
+			name:     "not dumped",
+			stage:    StageChangefeedCreated,
+			files:    []string{loadInfo("test.t1")},
+			err:      errors.New("AccessDenied: Access Denied"),
+			expected: map[string]Stage{"test.t1": StageChangefeedCreated, "test.t2": StageChangefeedCreated},
+		},
+		{
+			name:   "file exists error",
+			stage:  StageSnapshotDumped,
+			err:    errors.New("AccessDenied: Access Denied"),
+			errMsg: "Failed to check snapshot/loadinfo in storage: AccessDenied",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			files := make(map[string]bool)
+			for _, file := range c.files {
+				files[file] = true
+			}
+			stages, err := checkTableStages(context.Background(), &fakeStorage{files: files, err: c.err}, c.stage, tables)
+			if c.errMsg != "" {
+				require.ErrorContains(t, err, c.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expected, stages)
+		})
+	}
+}
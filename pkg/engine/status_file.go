@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// StatusFileName is the file at the root of the storage path the status of the pipeline is written into,
+// so that orchestration tools can poll it instead of scraping the logs
+const StatusFileName = "status.json"
+
+const statusFileWriteTimeout = 30 * time.Second
+
+// FileStatus is the content of StatusFileName
+type FileStatus struct {
+	Mode  string `json:"mode"`
+	Stage Stage  `json:"stage"`
+	// Status is idle once the pipeline returns without error
+	Status   apiservice.ServiceStatus     `json:"status"`
+	Snapshot *apiservice.SnapshotProgress `json:"snapshot,omitempty"`
+	Tables   map[string]*TableFileStatus  `json:"tables"`
+	// LastError is the last fatal error of the pipeline or any table
+	LastError *apiservice.FatalError `json:"last_error,omitempty"`
+	// IncrementPaused is set while merging the increment files is paused, the next run starts paused too
+	IncrementPaused   bool       `json:"increment_paused,omitempty"`
+	IncrementPausedAt *time.Time `json:"increment_paused_at,omitempty"`
+	StartedAt         time.Time  `json:"started_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// TableFileStatus is the status of a table in StatusFileName
+type TableFileStatus struct {
+	Stage              apiservice.TableStage  `json:"stage,omitempty"`
+	Status             apiservice.TableStatus `json:"status,omitempty"`
+	Loader             apiservice.LoaderState `json:"loader,omitempty"`
+	SnapshotLoadedRows int64                  `json:"snapshot_loaded_rows,omitempty"`
+	// LastLoadedCommitTs is the commit ts of the last row merged into the data warehouse, 0 if no increment
+	// file is merged since the program starts
+	LastLoadedCommitTs uint64     `json:"last_loaded_commit_ts,omitempty"`
+	LastLoadedAt       *time.Time `json:"last_loaded_at,omitempty"`
+}
+
+// fileStatus returns the status of the pipeline written into StatusFileName. If final is set the pipeline
+// has returned runErr.
+func (p *Pipeline) fileStatus(startedAt time.Time, final bool, runErr error) *FileStatus {
+	info := p.status.Status()
+	status := &FileStatus{
+		Mode:      RunModeIds[p.cfg.Mode][0],
+		Stage:     p.Stage(),
+		Status:    info.Status,
+		Snapshot:  info.Snapshot,
+		Tables:    make(map[string]*TableFileStatus, len(info.TablesInfo)),
+		LastError: info.LastFatalError,
+		// IncrementPaused is kept by the final status, so that the next run is paused too
+		IncrementPaused:   info.IncrementPaused,
+		IncrementPausedAt: info.IncrementPausedAt,
+		StartedAt:         startedAt,
+		UpdatedAt:         time.Now(),
+	}
+	for table, tableInfo := range info.TablesInfo {
+		status.Tables[table] = &TableFileStatus{
+			Stage:              tableInfo.Stage,
+			Status:             tableInfo.Status,
+			Loader:             tableInfo.Loader,
+			SnapshotLoadedRows: tableInfo.SnapshotLoadedRows,
+		}
+	}
+	for table, loaded := range p.status.LoadedCommitTs() {
+		if status.Tables[table] == nil {
+			status.Tables[table] = &TableFileStatus{}
+		}
+		status.Tables[table].LastLoadedCommitTs = loaded.LastLoadedCommitTs
+		status.Tables[table].LastLoadedAt = loaded.LastLoadedAt
+	}
+	if final {
+		// the status of the service is set by the caller of Run, which may not use it
+		if runErr != nil {
+			status.Status = apiservice.ServiceStatusFatalError
+			status.LastError = &apiservice.FatalError{Category: diag.CategoryOf(runErr), Message: runErr.Error(), Time: status.UpdatedAt}
+		} else if status.Status != apiservice.ServiceStatusFatalError {
+			status.Status = apiservice.ServiceStatusIdle
+		}
+	}
+	return status
+}
+
+// readFileStatus returns the status written by the former run, nil if there is none
+func readFileStatus(ctx context.Context, storage storage.ExternalStorage) (*FileStatus, error) {
+	exists, err := storage.FileExists(ctx, StatusFileName)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := storage.ReadFile(ctx, StatusFileName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	status := new(FileStatus)
+	if err = json.Unmarshal(data, status); err != nil {
+		return nil, errors.Annotatef(err, "invalid status file %s", StatusFileName)
+	}
+	return status, nil
+}
+
+// statusFileWriter writes the status of the pipeline into StatusFileName every interval until it is closed.
+// The file is replaced as a whole, which is atomic in the storages.
+type statusFileWriter struct {
+	pipeline  *Pipeline
+	storage   storage.ExternalStorage
+	startedAt time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	// mu orders the writes, so that a status is never replaced by an older one
+	mu sync.Mutex
+	// closed is set once the final status is written
+	closed bool
+}
+
+func (p *Pipeline) startStatusFile(storage storage.ExternalStorage, interval time.Duration) *statusFileWriter {
+	w := &statusFileWriter{
+		pipeline:  p,
+		storage:   storage,
+		startedAt: time.Now(),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			w.flush()
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return w
+}
+
+// close stops the periodic writes and writes the final status with the error returned by the pipeline
+func (w *statusFileWriter) close(runErr error) {
+	close(w.stop)
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.write(w.pipeline.fileStatus(w.startedAt, true, runErr))
+}
+
+// flush writes the current status at once
+func (w *statusFileWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.write(w.pipeline.fileStatus(w.startedAt, false, nil))
+	}
+}
+
+// write writes the status, a failure is only logged since the replication does not depend on the file
+func (w *statusFileWriter) write(status *FileStatus) {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		log.Warn("Failed to marshal status file", zap.Error(err))
+		return
+	}
+	// the final status is written after the context of the pipeline is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), statusFileWriteTimeout)
+	defer cancel()
+	if err = w.storage.WriteFile(ctx, StatusFileName, data); err != nil {
+		log.Warn("Failed to write status file", zap.String("file", StatusFileName), zap.Error(err))
+	}
+}
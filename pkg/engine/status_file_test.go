@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func readStatusFile(t *testing.T, storageURI *url.URL) *FileStatus {
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), storageURI.String())
+	require.NoError(t, err)
+	data, err := storage.ReadFile(context.Background(), StatusFileName)
+	require.NoError(t, err)
+	var status FileStatus
+	require.NoError(t, json.Unmarshal(data, &status))
+	return &status
+}
+
+func TestStatusFile(t *testing.T) {
+	cfg := newTestConfig(t)
+	storageURI := cfg.StorageURI
+	p, err := NewPipeline(cfg)
+	require.NoError(t, err)
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), storageURI.String())
+	require.NoError(t, err)
+
+	p.setStage(StageSnapshotDumped)
+	p.status.SetSnapshotDumpProgress(apiservice.SnapshotDumpProgress{DumpedRows: 100, EstimatedTotalRows: 200, DumpETASeconds: -1})
+	p.status.SetTableStage("test.t", apiservice.TableStageLoadingIncremental)
+	p.status.SetTableLoadedCommitTs("test.t", 42)
+	// the status is written once started
+	w := p.startStatusFile(storage, time.Hour)
+	require.Eventually(t, func() bool {
+		exists, err := storage.FileExists(context.Background(), StatusFileName)
+		return err == nil && exists
+	}, 5*time.Second, 10*time.Millisecond)
+	status := readStatusFile(t, storageURI)
+	require.Equal(t, "full", status.Mode)
+	require.Equal(t, StageSnapshotDumped, status.Stage)
+	require.Equal(t, apiservice.ServiceStatusRunning, status.Status)
+	require.Equal(t, int64(100), status.Snapshot.DumpedRows)
+	require.Equal(t, apiservice.TableStageLoadingIncremental, status.Tables["test.t"].Stage)
+	require.Equal(t, uint64(42), status.Tables["test.t"].LastLoadedCommitTs)
+	require.NotNil(t, status.Tables["test.t"].LastLoadedAt)
+	require.Equal(t, apiservice.LoaderStateRunning, status.Tables["test.t"].Loader)
+
+	// the pause is written at once, and read by the next run
+	p.status.SetIncrementPaused(true, time.Now())
+	w.flush()
+	prev, err := readFileStatus(context.Background(), storage)
+	require.NoError(t, err)
+	require.True(t, prev.IncrementPaused)
+	require.NotNil(t, prev.IncrementPausedAt)
+	require.Equal(t, apiservice.LoaderStatePaused, prev.Tables["test.t"].Loader)
+
+	// the final status has the error returned by the pipeline
+	w.close(diag.Warehouse(errors.New("connection refused")))
+	status = readStatusFile(t, storageURI)
+	require.Equal(t, apiservice.ServiceStatusFatalError, status.Status)
+	require.Equal(t, diag.CategoryWarehouse, status.LastError.Category)
+	require.Contains(t, status.LastError.Message, "connection refused")
+	require.False(t, status.UpdatedAt.Before(status.StartedAt))
+}
+
+func TestReadFileStatusNotExist(t *testing.T) {
+	storage, err := utils.GetExternalStorageFromURI(context.Background(), "file://"+t.TempDir())
+	require.NoError(t, err)
+	status, err := readFileStatus(context.Background(), storage)
+	require.NoError(t, err)
+	require.Nil(t, status)
+}
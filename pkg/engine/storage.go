@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// openStorage opens the external storage of the URI for the pipeline, the files transferred through it are
+// limited by the rate limiters of the pipeline
+func openStorage(ctx context.Context, cfg *PipelineConfig, uri *url.URL) (storage.ExternalStorage, error) {
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, uri.String())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cfg.RateLimiters.Wrap(extStorage), nil
+}
+
+// checkStorageAccess verifies the credentials are able to list and write the storage prefix,
+// so that permission problems are reported at startup instead of in the middle of replication.
+func checkStorageAccess(ctx context.Context, extStorage storage.ExternalStorage) error {
+	const probeFile = ".tidb2dw-access-check"
+	errStop := errors.New("stop walking")
+	err := extStorage.WalkDir(ctx, &storage.WalkOption{ListCount: 1}, func(string, int64) error {
+		return errStop
+	})
+	if err != nil && err != errStop {
+		return errors.Annotate(err, "Failed to list the storage path, please check the credentials have list permission on the prefix")
+	}
+	if err := extStorage.WriteFile(ctx, probeFile, []byte("ok")); err != nil {
+		return errors.Annotate(err, "Failed to write to the storage path, please check the credentials have write permission on the prefix")
+	}
+	if err := extStorage.DeleteFile(ctx, probeFile); err != nil {
+		return errors.Annotate(err, "Failed to delete from the storage path, please check the credentials have delete permission on the prefix")
+	}
+	return nil
+}
+
+// GenSnapshotAndIncrementURIs returns the URIs of the snapshot and increment directories in the workspace
+func GenSnapshotAndIncrementURIs(storageURI *url.URL) (*url.URL, *url.URL, error) {
+	// create snapshot and increment uri from storage uri, append snapshot and increment path to path
+	snapshotURI := *storageURI
+	incrementURI := *storageURI
+
+	var err error
+
+	snapshotURI.Path, err = url.JoinPath(storageURI.Path, "snapshot")
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "Failed to join workspace path")
+	}
+	incrementURI.Path, err = url.JoinPath(storageURI.Path, "increment")
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "Failed to join workspace path")
+	}
+	return &snapshotURI, &incrementURI, nil
+}
+
+// IncrementShardURIs returns the URIs written by the changefeeds of the shards, the increment directory itself
+// if the changes are not sharded
+func IncrementShardURIs(incrementURI *url.URL, shards int) ([]*url.URL, error) {
+	if shards <= 1 {
+		return []*url.URL{incrementURI}, nil
+	}
+	uris := make([]*url.URL, 0, shards)
+	for i := 0; i < shards; i++ {
+		shardURI := *incrementURI
+		var err error
+		if shardURI.Path, err = url.JoinPath(incrementURI.Path, incrementShardDir(i)); err != nil {
+			return nil, errors.Annotate(err, "Failed to join workspace path")
+		}
+		uris = append(uris, &shardURI)
+	}
+	return uris, nil
+}
+
+// FindIncrementShardURIs returns the URIs written by the changefeeds of the shards found in the increment storage,
+// the increment directory itself if the changes are not sharded
+func FindIncrementShardURIs(ctx context.Context, incrementURI *url.URL) ([]*url.URL, error) {
+	incrementStorage, err := utils.GetExternalStorageFromURI(ctx, incrementURI.String())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	shards := 0
+	for ; ; shards++ {
+		exist, err := incrementStorage.FileExists(ctx, incrementShardDir(shards)+"/metadata")
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to check the metadata of the shards")
+		}
+		if !exist {
+			break
+		}
+	}
+	return IncrementShardURIs(incrementURI, shards)
+}
+
+// incrementShardDir is the directory of the shard in the increment directory
+func incrementShardDir(shard int) string {
+	return fmt.Sprintf("shard-%d", shard)
+}
+
+// cleanWorkspace removes the changefeeds writing into the workspace and the snapshot and increment files left by
+// a previous replication, so that the replication starts from StageInit. The changefeeds are not required to be
+// found in --mode=snapshot-only, which may run without TiCDC.
+func cleanWorkspace(ctx context.Context, cfg *PipelineConfig) error {
+	changefeeds, err := cdc.FindChangefeedsWithin(cfg.CDC, cfg.StorageURI)
+	if err != nil {
+		if cfg.Mode != RunModeSnapshotOnly {
+			return diag.CDC(errors.Annotate(err, "Failed to find the changefeeds writing into the workspace"))
+		}
+		log.Warn("Failed to find the changefeeds writing into the workspace, they are not removed", zap.Error(err))
+	}
+	for _, changefeed := range changefeeds {
+		if err = cdc.RemoveChangefeed(cfg.CDC, changefeed); err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		log.Info("Removed changefeed writing into the workspace", zap.String("changefeed", changefeed.ID))
+	}
+
+	snapshotURI, incrementURI, err := GenSnapshotAndIncrementURIs(cfg.StorageURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(deleteWorkspaceFiles(ctx, snapshotURI, incrementURI))
+}
+
+// deleteWorkspaceFiles deletes the snapshot and increment files of the workspace
+func deleteWorkspaceFiles(ctx context.Context, snapshotURI, incrementURI *url.URL) error {
+	for _, uri := range []*url.URL{snapshotURI, incrementURI} {
+		extStorage, err := utils.GetExternalStorageFromURI(ctx, uri.String())
+		if err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+		deleted, err := deleteAllFiles(ctx, extStorage)
+		if err != nil {
+			return diag.Storage(errors.Annotatef(err, "Failed to clean %s", utils.RedactStorageURI(uri)))
+		}
+		log.Info("Cleaned workspace", zap.String("storage", utils.RedactStorageURI(uri)), zap.Int("files", deleted))
+	}
+	return nil
+}
+
+// deleteAllFiles deletes the files in the storage, it returns the number of files deleted
+func deleteAllFiles(ctx context.Context, extStorage storage.ExternalStorage) (int, error) {
+	var files []string
+	err := extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	for i, path := range files {
+		if err = extStorage.DeleteFile(ctx, path); err != nil {
+			return i, errors.Annotatef(err, "Failed to delete %s", path)
+		}
+	}
+	return len(files), nil
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/config"
+	"go.uber.org/zap"
+)
+
+// cloudPollInterval is how often the changefeed being created and the export are checked in TiDB Cloud
+const cloudPollInterval = 10 * time.Second
+
+// createCloudChangefeed creates the changefeed writing into the increment storage through the TiDB Cloud API and
+// waits for it to run. The changefeed recorded in ChangefeedFile by an interrupted creation is reused unless it is
+// failed or deleted.
+func createCloudChangefeed(ctx context.Context, cfg *PipelineConfig, client *tidbcloud.Client, startTSO uint64, incrementURI *url.URL) error {
+	record, found, err := loadChangefeedRecord(ctx, incrementURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if found && record.TiDBCloudCluster == client.ClusterID() {
+		changefeed, found, err := client.GetChangefeed(ctx, record.ID)
+		if err != nil {
+			return diag.CDC(errors.Trace(err))
+		}
+		if found && changefeed.State != tidbcloud.ChangefeedStateFailed {
+			log.Info("Reuse the TiDB Cloud changefeed recorded in the workspace", zap.String("changefeed", record.ID))
+			_, err = client.WaitChangefeedRunning(ctx, record.ID, cloudPollInterval)
+			return diag.CDC(errors.Trace(err))
+		}
+		if found {
+			if err = client.DeleteChangefeed(ctx, record.ID); err != nil {
+				return diag.CDC(errors.Trace(err))
+			}
+			log.Warn("Deleted the failed TiDB Cloud changefeed left by an interrupted start", zap.String("changefeed", record.ID), zap.String("error", changefeed.Error))
+		}
+	}
+
+	sinkURI, err := cdc.GenSinkURI(incrementURI, cdcreader.ProtocolCSV, cfg.CDCFlushInterval, cfg.CDCFileSize, cfg.CDCServerCredentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	spec := &tidbcloud.ChangefeedSpec{
+		DisplayName: "tidb2dw-" + strings.Trim(strings.ReplaceAll(incrementURI.Host+incrementURI.Path, "/", "-"), "-"),
+		StartTSO:    startTSO,
+		Sink: tidbcloud.ChangefeedSink{
+			Type:            "CLOUD_STORAGE",
+			URI:             sinkURI.String(),
+			IncludeCommitTS: true,
+			OutputColumnID:  true,
+			DateSeparator:   config.DateSeparatorDay.String(),
+		},
+		Filter: tidbcloud.ChangefeedFilter{Rules: cfg.Tables},
+	}
+	changefeed, err := client.CreateChangefeed(ctx, spec)
+	if err != nil {
+		return diag.CDC(errors.Trace(err))
+	}
+	if err = writeChangefeedRecord(ctx, incrementURI, &changefeedFileData{ID: changefeed.ID, TiDBCloudCluster: client.ClusterID()}); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to record the changefeed"))
+	}
+	log.Info("Created TiDB Cloud changefeed, waiting for it to run", zap.String("changefeed", changefeed.ID), zap.Uint64("startTSO", startTSO))
+	if _, err = client.WaitChangefeedRunning(ctx, changefeed.ID, cloudPollInterval); err != nil {
+		return diag.CDC(errors.Trace(err))
+	}
+	log.Info("TiDB Cloud changefeed is running", zap.String("changefeed", changefeed.ID))
+	return nil
+}
+
+// exportCloudSnapshot exports the tables into the snapshot storage by TiDB Cloud at the start TSO of the changefeed
+// recorded in the increment storage, instead of dumping them by dumpling
+func exportCloudSnapshot(ctx context.Context, cfg *PipelineConfig, client *tidbcloud.Client, snapshotURI, incrementURI *url.URL) error {
+	record, found, err := loadChangefeedRecord(ctx, incrementURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if !found || record.TiDBCloudCluster == "" {
+		return errors.New("No TiDB Cloud changefeed is recorded in the workspace, the snapshot TSO to export is unknown")
+	}
+	changefeed, found, err := client.GetChangefeed(ctx, record.ID)
+	if err != nil {
+		return diag.CDC(errors.Trace(err))
+	}
+	if !found || changefeed.StartTSO == 0 {
+		return diag.CDC(errors.Errorf("The start TSO of TiDB Cloud changefeed %s is unknown", record.ID))
+	}
+	compression := strings.ToUpper(string(cfg.SnapshotCompression))
+	if compression == "" {
+		compression = strings.ToUpper(string(utils.CompressionNone))
+	}
+	spec := &tidbcloud.ExportSpec{
+		DisplayName: "tidb2dw-" + record.ID,
+		SnapshotTSO: changefeed.StartTSO,
+		Target:      tidbcloud.ExportTarget{URI: utils.StripTiDB2DWParams(snapshotURI).String()},
+		Options: tidbcloud.ExportOptions{
+			FileType:    "CSV",
+			Compression: compression,
+			Tables:      cfg.Tables,
+		},
+	}
+	export, err := client.CreateExport(ctx, spec)
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	log.Info("Exporting snapshot by TiDB Cloud", zap.String("export", export.ID), zap.Uint64("snapshotTSO", changefeed.StartTSO))
+	if err = client.WaitExport(ctx, export.ID, cloudPollInterval); err != nil {
+		return diag.Source(errors.Annotate(err, "Failed to export snapshot"))
+	}
+	extStorage, err := openStorage(ctx, cfg, snapshotURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+	if err = dumpling.EnsureSnapshotMetadata(ctx, extStorage, changefeed.StartTSO); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to mark the snapshot exported"))
+	}
+	log.Info("Exported snapshot by TiDB Cloud", zap.String("export", export.ID))
+	return nil
+}
+
+// newCloudCheckpointFetcher returns the fetcher of the checkpoint of the TiDB Cloud changefeed recorded in the
+// increment storage, which is looked up on the first successful fetch
+func newCloudCheckpointFetcher(client *tidbcloud.Client, incrementURI *url.URL) apiservice.CheckpointFetcher {
+	var (
+		mu sync.Mutex
+		id string
+	)
+	return func() (uint64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), cloudPollInterval)
+		defer cancel()
+		if id == "" {
+			record, found, err := loadChangefeedRecord(ctx, incrementURI)
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+			if !found || record.TiDBCloudCluster == "" {
+				return 0, errors.New("no TiDB Cloud changefeed is recorded in the workspace")
+			}
+			id = record.ID
+		}
+		changefeed, found, err := client.GetChangefeed(ctx, id)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if !found {
+			return 0, errors.Errorf("TiDB Cloud changefeed %s is deleted", id)
+		}
+		return changefeed.CheckpointTSO, nil
+	}
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTiDBCloud serves the changefeeds and the exports of cluster c1, a created changefeed runs at once
+type fakeTiDBCloud struct {
+	mu          sync.Mutex
+	changefeeds map[string]*tidbcloud.Changefeed
+	exports     []*tidbcloud.ExportSpec
+}
+
+func (f *fakeTiDBCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodPost && path == "/clusters/c1/changefeeds":
+		var spec tidbcloud.ChangefeedSpec
+		_ = json.NewDecoder(r.Body).Decode(&spec)
+		id := "cf" + string(rune('0'+len(f.changefeeds)+1))
+		f.changefeeds[id] = &tidbcloud.Changefeed{ID: id, State: tidbcloud.ChangefeedStateRunning, StartTSO: spec.StartTSO, SinkURI: spec.Sink.URI}
+		_ = json.NewEncoder(w).Encode(&tidbcloud.Changefeed{ID: id, State: "CREATING"})
+	case r.Method == http.MethodPost && path == "/clusters/c1/exports":
+		var spec tidbcloud.ExportSpec
+		_ = json.NewDecoder(r.Body).Decode(&spec)
+		f.exports = append(f.exports, &spec)
+		_, _ = w.Write([]byte(`{"exportId":"e1","state":"RUNNING"}`))
+	case r.Method == http.MethodGet && path == "/clusters/c1/exports/e1":
+		_, _ = w.Write([]byte(`{"exportId":"e1","state":"SUCCEEDED"}`))
+	default:
+		for id, changefeed := range f.changefeeds {
+			if path != "/clusters/c1/changefeeds/"+id {
+				continue
+			}
+			if r.Method == http.MethodDelete {
+				delete(f.changefeeds, id)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(changefeed)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestCloudChangefeed(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeTiDBCloud{changefeeds: make(map[string]*tidbcloud.Changefeed)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := tidbcloud.NewClient(tidbcloud.Config{PublicKey: "public", PrivateKey: "private", ClusterID: "c1", APIURL: server.URL}, retry.Policy{})
+
+	storageURI := &url.URL{Scheme: "file", Path: t.TempDir()}
+	snapshotURI, incrementURI, err := GenSnapshotAndIncrementURIs(storageURI)
+	require.NoError(t, err)
+	cfg := &PipelineConfig{Tables: []string{"db.t"}, CDCFlushInterval: time.Minute, CDCFileSize: 64 << 20, SnapshotCompression: utils.CompressionGzip}
+
+	require.NoError(t, createCloudChangefeed(ctx, cfg, client, 440000000000000000, incrementURI))
+	record, found, err := loadChangefeedRecord(ctx, incrementURI)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, &changefeedFileData{ID: "cf1", TiDBCloudCluster: "c1"}, record)
+	require.Contains(t, fake.changefeeds["cf1"].SinkURI, "protocol=csv")
+	// the changefeed of TiDB Cloud is not one of the TiCDC server
+	_, found, err = loadChangefeedFile(ctx, incrementURI)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// reused on restart
+	require.NoError(t, createCloudChangefeed(ctx, cfg, client, 440000000000000001, incrementURI))
+	require.Len(t, fake.changefeeds, 1)
+
+	require.NoError(t, exportCloudSnapshot(ctx, cfg, client, snapshotURI, incrementURI))
+	require.Len(t, fake.exports, 1)
+	require.Equal(t, uint64(440000000000000000), fake.exports[0].SnapshotTSO)
+	require.Equal(t, []string{"db.t"}, fake.exports[0].Options.Tables)
+	require.Equal(t, "GZIP", fake.exports[0].Options.Compression)
+	snapshotStorage, err := utils.GetExternalStorageFromURI(ctx, snapshotURI.String())
+	require.NoError(t, err)
+	tso, err := dumpling.ReadSnapshotTSO(ctx, snapshotStorage)
+	require.NoError(t, err)
+	require.Equal(t, uint64(440000000000000000), tso)
+
+	checkpoint, err := newCloudCheckpointFetcher(client, incrementURI)()
+	require.NoError(t, err)
+	require.Zero(t, checkpoint)
+
+	// the changefeed of TiDB Cloud is removed through its API only
+	require.ErrorContains(t, Remove(ctx, &RemoveConfig{StorageURI: storageURI}), "set --tidbcloud.public-key")
+	require.NoError(t, Remove(ctx, &RemoveConfig{StorageURI: storageURI, TiDBCloud: client}))
+	require.Empty(t, fake.changefeeds)
+}
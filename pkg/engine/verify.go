@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// verifyWaitInterval is how often the replication is checked while waiting for it to catch up with the TSO
+const verifyWaitInterval = 5 * time.Second
+
+// VerifyConfig is the configuration of `tidb2dw verify`
+type VerifyConfig struct {
+	TiDBConfig *tidbsql.TiDBConfig
+	Tables     []string
+	// StorageURI is the storage path of the replication
+	StorageURI *url.URL
+	// CDC is the client of the HTTP API of TiCDC
+	CDC *cdc.Client
+	// TSO is the TSO TiDB is read at, the current TSO if it is 0
+	TSO uint64
+	// WaitTimeout is how long to wait for the replication to catch up with the TSO, 0 does not wait
+	WaitTimeout  time.Duration
+	ColumnFilter columnfilter.Config
+	Where        map[string]string
+	Options      replicate.VerifyOptions
+	// Verifiers read the tables in the data warehouse
+	Verifiers map[string]coreinterfaces.TableVerifier
+	// TargetTables are the tables in the data warehouse the tables are routed to, absent if of the source table name
+	TargetTables map[string]string
+}
+
+// Verify compares the tables in TiDB at the TSO with the tables in the data warehouse, after waiting for the
+// replication to merge the changes before the TSO. A table failed to be compared is reported with its error.
+func Verify(ctx context.Context, cfg *VerifyConfig) (*validation.VerifyReport, error) {
+	tso := cfg.TSO
+	if tso == 0 {
+		var err error
+		if tso, err = tidbsql.GetCurrentTSO(cfg.TiDBConfig); err != nil {
+			return nil, errors.Annotate(err, "Failed to get current TSO")
+		}
+	}
+	if cfg.WaitTimeout > 0 {
+		if err := waitReplicated(ctx, cfg, tso); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	tidbPool, err := cfg.TiDBConfig.OpenDB()
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+	verifier := replicate.NewVerifier(tidbPool, tso, cfg.Options)
+	report := &validation.VerifyReport{
+		TSO:       tso,
+		StartedAt: time.Now(),
+		Tables:    make(map[string]*validation.TableVerification, len(cfg.Tables)),
+		Passed:    true,
+	}
+	for _, table := range cfg.Tables {
+		targetTable := cfg.TargetTables[table]
+		if targetTable == "" {
+			_, targetTable = utils.SplitTableFQN(table)
+		}
+		tableReport, err := verifier.VerifyTable(ctx, cfg.Verifiers[table], table, targetTable, cfg.ColumnFilter.Table(table), cfg.Where[table])
+		if ctx.Err() != nil {
+			return nil, errors.Trace(ctx.Err())
+		}
+		if err != nil {
+			log.Error("Failed to verify table", zap.String("table", table), zap.Error(err))
+			tableReport = &validation.TableVerification{Error: err.Error()}
+		} else {
+			log.Info("Verified table", zap.String("table", table), zap.Bool("passed", tableReport.Passed),
+				zap.Int("buckets", tableReport.Buckets), zap.Int("mismatchedBuckets", len(tableReport.MismatchedBuckets)))
+		}
+		report.Tables[table] = tableReport
+		report.Passed = report.Passed && tableReport.Passed
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// waitReplicated waits until the changefeeds writing into the storage pass the TSO and the increment files of the
+// tables are all merged, so the data warehouse has every change of the tables committed before the TSO
+func waitReplicated(ctx context.Context, cfg *VerifyConfig, tso uint64) error {
+	changefeeds, err := cdc.FindChangefeedsWithin(cfg.CDC, cfg.StorageURI)
+	if err != nil {
+		return diag.CDC(errors.Annotate(err, "Failed to find the changefeeds writing into the storage"))
+	}
+	if len(changefeeds) == 0 {
+		return errors.New("no changefeed writes into the storage, set --wait-timeout=0 to verify without waiting for the replication")
+	}
+	_, incrementURI, err := GenSnapshotAndIncrementURIs(cfg.StorageURI)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	shardURIs, err := FindIncrementShardURIs(ctx, incrementURI)
+	if err != nil {
+		return diag.Storage(errors.Trace(err))
+	}
+
+	deadline := time.Now().Add(cfg.WaitTimeout)
+	ticker := time.NewTicker(verifyWaitInterval)
+	defer ticker.Stop()
+	for {
+		caughtUp, err := replicatedPast(ctx, cfg, changefeeds, shardURIs, tso)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if caughtUp {
+			log.Info("Replication caught up with TSO", zap.Uint64("tso", tso))
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("the replication does not catch up with TSO %d in %s", tso, cfg.WaitTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func replicatedPast(ctx context.Context, cfg *VerifyConfig, changefeeds []*cdc.Changefeed, shardURIs []*url.URL, tso uint64) (bool, error) {
+	for _, changefeed := range changefeeds {
+		checkpoint, err := cdc.GetChangefeedCheckpoint(cfg.CDC, changefeed)
+		if err != nil {
+			return false, diag.CDC(errors.Annotate(err, "Failed to get changefeed checkpoint"))
+		}
+		if checkpoint < tso {
+			log.Info("Waiting for the changefeed to pass TSO", zap.String("changefeed", changefeed.ID),
+				zap.Uint64("checkpoint", checkpoint), zap.Uint64("tso", tso))
+			return false, nil
+		}
+	}
+	for _, shardURI := range shardURIs {
+		incrementStorage, err := utils.GetExternalStorageFromURI(ctx, shardURI.String())
+		if err != nil {
+			return false, diag.Storage(errors.Trace(err))
+		}
+		checkpoint, err := replicate.LoadIncrementCheckpoint(ctx, incrementStorage)
+		if err != nil {
+			return false, diag.Storage(errors.Annotate(err, "Failed to load increment checkpoint"))
+		}
+		for _, table := range cfg.Tables {
+			pending, err := replicate.PendingIncrementFiles(ctx, incrementStorage, checkpoint, table)
+			if err != nil {
+				return false, diag.Storage(errors.Trace(err))
+			}
+			if pending > 0 {
+				log.Info("Waiting for the increment files to be merged", zap.String("table", table), zap.Int("files", pending))
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,311 @@
+// Package fakewarehouse provides a data warehouse emulator keeping the tables in memory, so that the replication
+// can be tested end to end without a data warehouse. It applies the snapshot and increment files as they are
+// written in the storage but none of the SQL generated for a real data warehouse, which is covered by the unit
+// tests of the connectors.
+package fakewarehouse
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Warehouse is a data warehouse emulator keeping the tables in memory. It applies the snapshot
+// and increment files exactly as they are written in the storage, so that the whole pipeline except
+// the SQL dialect of a real data warehouse is exercised. The operations are recorded as statements,
+// e.g. to check what a restart loads again.
+type Warehouse struct {
+	mu      sync.Mutex
+	columns []cloudstorage.TableCol
+	// rows are indexed by the encoded primary key
+	rows map[string][]*string
+	// statements are the operations applied in order: CREATE TABLE, LOAD SNAPSHOT <file>, the DDL query and
+	// MERGE <file>
+	statements []string
+}
+
+// New returns an empty Warehouse
+func New() *Warehouse {
+	return &Warehouse{rows: make(map[string][]*string)}
+}
+
+// Rows returns the rows ordered by the primary key, NULL is represented by nil
+func (w *Warehouse) Rows() [][]*string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	keys := make([]string, 0, len(w.rows))
+	for key := range w.rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rows := make([][]*string, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, w.rows[key])
+	}
+	return rows
+}
+
+// Statements returns the operations applied so far in order
+func (w *Warehouse) Statements() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.statements)
+}
+
+// CountStatements returns the number of the statements starting with prefix
+func (w *Warehouse) CountStatements(prefix string) int {
+	count := 0
+	for _, statement := range w.Statements() {
+		if strings.HasPrefix(statement, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func (w *Warehouse) pkKey(row []*string) string {
+	var parts []string
+	for i, col := range w.columns {
+		if col.IsPK == "true" && i < len(row) && row[i] != nil {
+			parts = append(parts, *row[i])
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+var _ coreinterfaces.Connector = (*Connector)(nil)
+
+// Connector implements coreinterfaces.Connector on a Warehouse
+type Connector struct {
+	warehouse *Warehouse
+	// storage is the snapshot directory, increment files are opened by the URI passed to LoadIncrement
+	storage storage.ExternalStorage
+}
+
+// NewConnector returns a connector of warehouse reading the snapshot files from snapshotURI
+func NewConnector(ctx context.Context, warehouse *Warehouse, snapshotURI *url.URL) (*Connector, error) {
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, snapshotURI.String())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Connector{warehouse: warehouse, storage: extStorage}, nil
+}
+
+func (c *Connector) InitSchema(columns []cloudstorage.TableCol) error {
+	c.warehouse.mu.Lock()
+	defer c.warehouse.mu.Unlock()
+	if len(c.warehouse.columns) == 0 {
+		c.warehouse.columns = columns
+	}
+	return nil
+}
+
+func (c *Connector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	columns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pkColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i := range columns {
+		if slices.Contains(pkColumns, columns[i].Name) {
+			columns[i].IsPK = "true"
+		}
+	}
+	c.warehouse.mu.Lock()
+	defer c.warehouse.mu.Unlock()
+	c.warehouse.columns = columns
+	c.warehouse.statements = append(c.warehouse.statements, "CREATE TABLE "+sourceDatabase+"."+sourceTable)
+	return nil
+}
+
+func (c *Connector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	ctx := context.Background()
+	var loaded int64
+	for _, file := range files {
+		rows, err := readCSVFile(ctx, c.storage, file)
+		if err != nil {
+			return errors.Annotatef(err, "Failed to read snapshot file %s", file)
+		}
+		c.warehouse.mu.Lock()
+		for _, row := range rows {
+			c.warehouse.rows[c.warehouse.pkKey(row)] = row
+		}
+		c.warehouse.statements = append(c.warehouse.statements, "LOAD SNAPSHOT "+file)
+		c.warehouse.mu.Unlock()
+		loaded += int64(len(rows))
+		if onSnapshotLoadProgress != nil {
+			onSnapshotLoadProgress(loaded)
+		}
+		if err = onFilesLoaded([]string{file}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (c *Connector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	c.warehouse.mu.Lock()
+	defer c.warehouse.mu.Unlock()
+	c.warehouse.statements = append(c.warehouse.statements, tableDef.Query)
+	switch tableDef.Type {
+	case timodel.ActionTruncateTable, timodel.ActionDropTable:
+		c.warehouse.rows = make(map[string][]*string)
+		return nil
+	}
+	// columns are matched by name, added columns are NULL in the existing rows
+	prevIndex := make(map[string]int, len(c.warehouse.columns))
+	for i, col := range c.warehouse.columns {
+		prevIndex[col.Name] = i
+	}
+	for key, row := range c.warehouse.rows {
+		newRow := make([]*string, len(tableDef.Columns))
+		for i, col := range tableDef.Columns {
+			if j, ok := prevIndex[col.Name]; ok && j < len(row) {
+				newRow[i] = row[j]
+			}
+		}
+		c.warehouse.rows[key] = newRow
+	}
+	c.warehouse.columns = tableDef.Columns
+	return nil
+}
+
+func (c *Connector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+	ctx := context.Background()
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, uri.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rows, err := readCSVFile(ctx, extStorage, filePath)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to read increment file %s", filePath)
+	}
+	c.warehouse.mu.Lock()
+	defer c.warehouse.mu.Unlock()
+	c.warehouse.statements = append(c.warehouse.statements, "MERGE "+filePath)
+	for _, row := range rows {
+		// the first 4 columns are the operation, table, schema and commit ts
+		if len(row) < 4 || row[0] == nil {
+			return errors.Errorf("malformed increment row in %s", filePath)
+		}
+		values := row[4:]
+		switch *row[0] {
+		case "D":
+			delete(c.warehouse.rows, c.warehouse.pkKey(values))
+		case "I", "U":
+			c.warehouse.rows[c.warehouse.pkKey(values)] = values
+		default:
+			return errors.Errorf("unknown operation %s in %s", *row[0], filePath)
+		}
+	}
+	return nil
+}
+
+func (c *Connector) Close() {}
+
+// readCSVFile decodes the CSV files written by dumpling (quoted) and TiCDC (unquoted),
+// both escape special characters with backslashes and write NULL as `\N`.
+func readCSVFile(ctx context.Context, extStorage storage.ExternalStorage, path string) ([][]*string, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+
+	var (
+		rows  [][]*string
+		row   []*string
+		field strings.Builder
+		// raw is the field as written in the file, NULL is an unquoted `\N`
+		raw     strings.Builder
+		quoted  bool
+		inQuote bool
+		escaped bool
+	)
+	endField := func() {
+		if !quoted && raw.String() == `\N` {
+			row = append(row, nil)
+		} else {
+			value := field.String()
+			row = append(row, &value)
+		}
+		field.Reset()
+		raw.Reset()
+		quoted = false
+	}
+	r := bufio.NewReader(reader)
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if escaped || inQuote || (b != ',' && b != '\n') {
+			raw.WriteByte(b)
+		}
+		switch {
+		case escaped:
+			field.WriteByte(unescape(b))
+			escaped = false
+		case b == '\\':
+			escaped = true
+		case b == '"' && inQuote:
+			if next, err := r.Peek(1); err == nil && next[0] == '"' {
+				_, _ = r.ReadByte()
+				field.WriteByte('"')
+			} else {
+				inQuote = false
+			}
+		case b == '"' && raw.Len() == 1:
+			inQuote, quoted = true, true
+		case b == ',' && !inQuote:
+			endField()
+		case b == '\n' && !inQuote:
+			endField()
+			rows = append(rows, row)
+			row = nil
+		case b == '\r' && !inQuote:
+		default:
+			field.WriteByte(b)
+		}
+	}
+	if raw.Len() > 0 || len(row) > 0 {
+		endField()
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func unescape(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case '0':
+		return 0
+	default:
+		return b
+	}
+}
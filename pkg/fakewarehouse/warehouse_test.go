@@ -0,0 +1,89 @@
+package fakewarehouse_test
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/fakewarehouse"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestConnectorAppliesFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db.t.000000000.csv"),
+		[]byte("\"1\",\"a,b\"\n\"2\",\\N\n\"3\",\"say \"\"hi\"\"\\n\"\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "CDC000001.csv"),
+		[]byte("D,t,db,1,2,\\N\nU,t,db,1,3,x\nI,t,db,1,4,y\\,z\n"), 0o644))
+	uri := &url.URL{Scheme: "file", Path: dir}
+
+	warehouse := fakewarehouse.New()
+	connector, err := fakewarehouse.NewConnector(context.Background(), warehouse, uri)
+	require.NoError(t, err)
+	defer connector.Close()
+	columns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "v", Tp: "VARCHAR"},
+	}
+	require.NoError(t, connector.InitSchema(columns))
+
+	var progress int64
+	var loaded []string
+	require.NoError(t, connector.LoadSnapshot("t", []string{"db.t.000000000.csv"},
+		func(rows int64) { progress = rows },
+		func(files []string) error { loaded = append(loaded, files...); return nil }))
+	require.Equal(t, int64(3), progress)
+	require.Equal(t, []string{"db.t.000000000.csv"}, loaded)
+	require.Equal(t, [][]*string{
+		{strPtr("1"), strPtr("a,b")},
+		{strPtr("2"), nil},
+		{strPtr("3"), strPtr("say \"hi\"\n")},
+	}, warehouse.Rows())
+
+	tableDef := cloudstorage.TableDefinition{Table: "t", Schema: "db", Columns: columns}
+	require.NoError(t, connector.LoadIncrement(tableDef, uri, "CDC000001.csv"))
+	require.Equal(t, [][]*string{
+		{strPtr("1"), strPtr("a,b")},
+		{strPtr("3"), strPtr("x")},
+		{strPtr("4"), strPtr("y,z")},
+	}, warehouse.Rows())
+
+	addColumn := cloudstorage.TableDefinition{
+		Table: "t", Schema: "db", Type: timodel.ActionAddColumn, Query: "ALTER TABLE t ADD COLUMN c INT",
+		Columns: append(columns, cloudstorage.TableCol{Name: "c", Tp: "INT"}),
+	}
+	require.NoError(t, connector.ExecDDL(addColumn))
+	require.Equal(t, [][]*string{
+		{strPtr("1"), strPtr("a,b"), nil},
+		{strPtr("3"), strPtr("x"), nil},
+		{strPtr("4"), strPtr("y,z"), nil},
+	}, warehouse.Rows())
+
+	require.Equal(t, []string{
+		"LOAD SNAPSHOT db.t.000000000.csv",
+		"MERGE CDC000001.csv",
+		"ALTER TABLE t ADD COLUMN c INT",
+	}, warehouse.Statements())
+	require.Equal(t, 1, warehouse.CountStatements("MERGE"))
+}
+
+func TestConnectorRejectsUnknownOperation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "CDC000001.csv"), []byte("X,t,db,1,1\n"), 0o644))
+	uri := &url.URL{Scheme: "file", Path: dir}
+
+	connector, err := fakewarehouse.NewConnector(context.Background(), fakewarehouse.New(), uri)
+	require.NoError(t, err)
+	columns := []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}}
+	require.NoError(t, connector.InitSchema(columns))
+	err = connector.LoadIncrement(cloudstorage.TableDefinition{Columns: columns}, uri, "CDC000001.csv")
+	require.ErrorContains(t, err, "unknown operation X")
+}
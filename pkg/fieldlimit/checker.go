@@ -0,0 +1,256 @@
+package fieldlimit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+const (
+	// DeadLetterDir is the directory in the workspace where the dead-letter rows are written to
+	DeadLetterDir = "dead-letter"
+
+	// maxReportedViolations is the maximum number of violations in the error message
+	maxReportedViolations = 10
+)
+
+// Violation is a field or a row exceeding the limit
+type Violation struct {
+	Table string
+	File  string
+	// Row is the 1-based row number in the file
+	Row int64
+	// PK is the primary key of the row, e.g. `id=1, name=foo`
+	PK string
+	// Column is empty if the whole row exceeds the row size limit
+	Column string
+	Size   int64
+	Limit  int64
+}
+
+func (v Violation) String() string {
+	target := fmt.Sprintf("column %s", v.Column)
+	if v.Column == "" {
+		target = "row"
+	}
+	return fmt.Sprintf("table %s, file %s, row %d (%s): %s size %d bytes exceeds the limit %d bytes",
+		v.Table, v.File, v.Row, v.PK, target, v.Size, v.Limit)
+}
+
+// Checker scans the CSV files before they are loaded into the data warehouse,
+// reports the fields and rows exceeding the limits and applies the policy on them.
+type Checker struct {
+	// rawStorage is the workspace, extStorage is the same with compression applied.
+	rawStorage  storage.ExternalStorage
+	extStorage  storage.ExternalStorage
+	dir         string
+	compression utils.Compression
+	limits      Limits
+	policy      Policy
+}
+
+// NewChecker creates a checker on the root of the workspace
+func NewChecker(workspace storage.ExternalStorage, limits Limits, policy Policy) *Checker {
+	return &Checker{
+		rawStorage:  workspace,
+		extStorage:  workspace,
+		compression: utils.CompressionNone,
+		limits:      limits,
+		policy:      policy,
+	}
+}
+
+// Sub returns a checker of the files under dir which are compressed by compression,
+// dead-letter rows are still written to DeadLetterDir of the workspace.
+func (c *Checker) Sub(dir string, compression utils.Compression) *Checker {
+	sub := *c
+	sub.dir = path.Join(c.dir, dir)
+	sub.compression = compression
+	sub.extStorage = storage.WithCompression(c.rawStorage, compression.CompressType())
+	return &sub
+}
+
+// CSVFileExtension returns the extension of the files checked by the checker
+func (c *Checker) CSVFileExtension() string {
+	return c.compression.CSVFileExtension()
+}
+
+// Check scans the CSV file and applies the policy on the fields and rows exceeding the limits.
+// filePath is relative to the directory of the checker, columns are the columns in the file.
+// If the file is rewritten, its new size is returned.
+func (c *Checker) Check(ctx context.Context, table, filePath string, columns []cloudstorage.TableCol) (rewritten bool, size int64, err error) {
+	fullPath := path.Join(c.dir, filePath)
+	violations, err := c.process(ctx, table, fullPath, columns, nil, nil)
+	if err != nil {
+		return false, 0, errors.Annotatef(err, "Failed to check field limits of file %s", fullPath)
+	}
+	if len(violations) == 0 {
+		return false, 0, nil
+	}
+	for _, v := range violations {
+		log.Warn("Field limit exceeded", zap.String("violation", v.String()), zap.String("policy", string(c.policy)))
+	}
+
+	rowViolation := false
+	for _, v := range violations {
+		rowViolation = rowViolation || v.Column == ""
+	}
+	if c.policy == PolicyError || (rowViolation && c.policy != PolicyDeadLetter) {
+		reported := make([]string, 0, maxReportedViolations)
+		for i := 0; i < len(violations) && i < maxReportedViolations; i++ {
+			reported = append(reported, violations[i].String())
+		}
+		return false, 0, errors.Errorf("%d fields or rows exceed the limits of the data warehouse under policy %s:\n%s",
+			len(violations), c.policy, strings.Join(reported, "\n"))
+	}
+
+	if err = c.rewrite(ctx, table, fullPath, columns); err != nil {
+		return false, 0, errors.Annotatef(err, "Failed to apply field limit policy %s on file %s", c.policy, fullPath)
+	}
+	size, err = c.fileSize(ctx, fullPath)
+	if err != nil {
+		return false, 0, errors.Trace(err)
+	}
+	log.Info("Applied field limit policy", zap.String("file", fullPath), zap.String("policy", string(c.policy)), zap.Int("violations", len(violations)))
+	return true, size, nil
+}
+
+// rewrite writes the file with the policy applied into a temporary file and renames it back
+func (c *Checker) rewrite(ctx context.Context, table, fullPath string, columns []cloudstorage.TableCol) error {
+	tmpPath := fullPath + ".tidb2dw.tmp"
+	writer, err := c.extStorage.Create(ctx, tmpPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// dead-letter rows are expected to be rare, so they are kept in memory
+	var deadLetter bytes.Buffer
+	_, err = c.process(ctx, table, fullPath, columns, &fileWriter{ctx: ctx, w: writer}, &deadLetter)
+	if closeErr := writer.Close(ctx); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if deadLetter.Len() > 0 {
+		if err = c.extStorage.WriteFile(ctx, path.Join(DeadLetterDir, fullPath), deadLetter.Bytes()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// rename overwrites the original file
+	return errors.Trace(c.rawStorage.Rename(ctx, tmpPath, fullPath))
+}
+
+// process reads the file row by row and returns the violations. If writer is not nil, the rows with
+// the policy applied are written into it, and the dropped rows are written into deadLetter.
+func (c *Checker) process(
+	ctx context.Context,
+	table, fullPath string,
+	columns []cloudstorage.TableCol,
+	writer, deadLetter io.Writer,
+) ([]Violation, error) {
+	reader, err := c.extStorage.Open(ctx, fullPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+
+	var violations []Violation
+	csvReader := newCSVReader(reader)
+	for row := int64(1); ; row++ {
+		fields, err := csvReader.read()
+		if err == io.EOF {
+			return violations, nil
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "row %d", row)
+		}
+
+		var rowViolations []Violation
+		var rowSize int64
+		for i, field := range fields {
+			size := fieldSize(field)
+			if c.limits.MaxFieldSize > 0 && size > c.limits.MaxFieldSize {
+				rowViolations = append(rowViolations, c.newViolation(table, fullPath, row, fields, columns, columnName(columns, i), size, c.limits.MaxFieldSize))
+				switch c.policy {
+				case PolicyTruncate:
+					fields[i] = truncateField(field, c.limits.MaxFieldSize)
+					size = fieldSize(fields[i])
+				case PolicyNull:
+					fields[i] = nullField
+					size = 0
+				}
+			}
+			rowSize += size
+		}
+		if c.limits.MaxRowSize > 0 && rowSize > c.limits.MaxRowSize {
+			rowViolations = append(rowViolations, c.newViolation(table, fullPath, row, fields, columns, "", rowSize, c.limits.MaxRowSize))
+		}
+		violations = append(violations, rowViolations...)
+
+		if writer == nil {
+			continue
+		}
+		target := writer
+		if len(rowViolations) > 0 && c.policy == PolicyDeadLetter {
+			target = deadLetter
+		}
+		if err = writeRow(target, fields); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+func (c *Checker) newViolation(table, fullPath string, row int64, fields [][]byte, columns []cloudstorage.TableCol, column string, size, limit int64) Violation {
+	pk := make([]string, 0, 1)
+	for i, col := range columns {
+		if col.IsPK == "true" && i < len(fields) {
+			pk = append(pk, fmt.Sprintf("%s=%s", col.Name, displayValue(fields[i])))
+		}
+	}
+	return Violation{
+		Table:  table,
+		File:   fullPath,
+		Row:    row,
+		PK:     strings.Join(pk, ", "),
+		Column: column,
+		Size:   size,
+		Limit:  limit,
+	}
+}
+
+func (c *Checker) fileSize(ctx context.Context, fullPath string) (int64, error) {
+	reader, err := c.rawStorage.Open(ctx, fullPath)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	size, err := reader.Seek(0, io.SeekEnd)
+	return size, errors.Trace(err)
+}
+
+func columnName(columns []cloudstorage.TableCol, i int) string {
+	if i < len(columns) {
+		return columns[i].Name
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// fileWriter adapts storage.ExternalFileWriter to io.Writer
+type fileWriter struct {
+	ctx context.Context
+	w   storage.ExternalFileWriter
+}
+
+func (fw *fileWriter) Write(p []byte) (int, error) {
+	return fw.w.Write(fw.ctx, p)
+}
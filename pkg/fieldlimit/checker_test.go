@@ -0,0 +1,96 @@
+package fieldlimit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+var columns = []cloudstorage.TableCol{
+	{Name: "id", Tp: "INT", IsPK: "true"},
+	{Name: "v", Tp: "VARCHAR"},
+}
+
+// increment files are written by TiCDC without quotes, snapshot files are written by dumpling with quotes
+const content = "1,short\n" +
+	"2,0123456789\\,0123456789\n" +
+	"\"3\",\"ab\"\"cd\\\"efgh\"\n" +
+	"4,\\N\n"
+
+func prepare(t *testing.T, policy fieldlimit.Policy) (storage.ExternalStorage, *fieldlimit.Checker) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(ctx, "increment/test.csv", []byte(content)))
+	checker := fieldlimit.NewChecker(extStorage, fieldlimit.Limits{MaxFieldSize: 8}, policy)
+	return extStorage, checker.Sub("increment", utils.CompressionNone)
+}
+
+func TestCheckError(t *testing.T) {
+	_, checker := prepare(t, fieldlimit.PolicyError)
+	_, _, err := checker.Check(context.Background(), "db.t", "test.csv", columns)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "2 fields or rows exceed")
+	require.Contains(t, err.Error(), "table db.t, file increment/test.csv, row 2 (id=2): column v size 21 bytes exceeds the limit 8 bytes")
+	require.Contains(t, err.Error(), "row 3 (id=3): column v size 10 bytes")
+}
+
+func TestCheckTruncate(t *testing.T) {
+	extStorage, checker := prepare(t, fieldlimit.PolicyTruncate)
+	rewritten, size, err := checker.Check(context.Background(), "db.t", "test.csv", columns)
+	require.NoError(t, err)
+	require.True(t, rewritten)
+	expected := "1,short\n" +
+		"2,01234567\n" +
+		"\"3\",\"ab\"\"cd\\\"ef\"\n" +
+		"4,\\N\n"
+	result, err := extStorage.ReadFile(context.Background(), "increment/test.csv")
+	require.NoError(t, err)
+	require.Equal(t, expected, string(result))
+	require.Equal(t, int64(len(expected)), size)
+}
+
+func TestCheckNull(t *testing.T) {
+	extStorage, checker := prepare(t, fieldlimit.PolicyNull)
+	rewritten, _, err := checker.Check(context.Background(), "db.t", "test.csv", columns)
+	require.NoError(t, err)
+	require.True(t, rewritten)
+	result, err := extStorage.ReadFile(context.Background(), "increment/test.csv")
+	require.NoError(t, err)
+	require.Equal(t, "1,short\n2,\\N\n\"3\",\\N\n4,\\N\n", string(result))
+}
+
+func TestCheckDeadLetter(t *testing.T) {
+	extStorage, checker := prepare(t, fieldlimit.PolicyDeadLetter)
+	rewritten, _, err := checker.Check(context.Background(), "db.t", "test.csv", columns)
+	require.NoError(t, err)
+	require.True(t, rewritten)
+	result, err := extStorage.ReadFile(context.Background(), "increment/test.csv")
+	require.NoError(t, err)
+	require.Equal(t, "1,short\n4,\\N\n", string(result))
+	deadLetter, err := extStorage.ReadFile(context.Background(), "dead-letter/increment/test.csv")
+	require.NoError(t, err)
+	require.Equal(t, "2,0123456789\\,0123456789\n\"3\",\"ab\"\"cd\\\"efgh\"\n", string(deadLetter))
+}
+
+func TestCheckWithinLimits(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(ctx, "snapshot/test.csv", []byte(content)))
+	checker := fieldlimit.NewChecker(extStorage, fieldlimit.Limits{MaxFieldSize: 64, MaxRowSize: 64}, fieldlimit.PolicyError)
+	rewritten, _, err := checker.Sub("snapshot", utils.CompressionNone).Check(ctx, "db.t", "test.csv", columns)
+	require.NoError(t, err)
+	require.False(t, rewritten)
+
+	// the row limit can not be satisfied by truncating fields
+	checker = fieldlimit.NewChecker(extStorage, fieldlimit.Limits{MaxFieldSize: 64, MaxRowSize: 16}, fieldlimit.PolicyTruncate)
+	_, _, err = checker.Sub("snapshot", utils.CompressionNone).Check(ctx, "db.t", "test.csv", columns)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "row 2 (id=2): row size 22 bytes exceeds the limit 16 bytes")
+}
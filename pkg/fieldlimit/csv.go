@@ -0,0 +1,165 @@
+package fieldlimit
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+)
+
+// nullField is how both dumpling and TiCDC write NULL
+var nullField = []byte(`\N`)
+
+// csvReader reads the CSV files written by dumpling and TiCDC. Fields are kept as the raw
+// bytes in the file, including the quotes and escapes, so that untouched rows are written
+// back byte for byte. Both `\x` escapes and doubled quotes inside quoted fields are handled.
+type csvReader struct {
+	r *bufio.Reader
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	return &csvReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// read returns the fields of the next row, io.EOF is returned if there is no more row
+func (cr *csvReader) read() ([][]byte, error) {
+	var (
+		fields  [][]byte
+		field   []byte
+		inQuote bool
+		sawAny  bool
+	)
+	for {
+		b, err := cr.r.ReadByte()
+		if err == io.EOF {
+			if !sawAny {
+				return nil, io.EOF
+			}
+			if inQuote {
+				return nil, errors.New("unexpected EOF in quoted field")
+			}
+			return append(fields, field), nil
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sawAny = true
+		switch {
+		case b == '\\':
+			field = append(field, b)
+			next, err := cr.r.ReadByte()
+			if err != nil && err != io.EOF {
+				return nil, errors.Trace(err)
+			}
+			if err == nil {
+				field = append(field, next)
+			}
+		case b == '"':
+			field = append(field, b)
+			if !inQuote {
+				inQuote = len(field) == 1
+				continue
+			}
+			if next, err := cr.r.Peek(1); err == nil && next[0] == '"' {
+				_, _ = cr.r.ReadByte()
+				field = append(field, '"')
+			} else {
+				inQuote = false
+			}
+		case b == ',' && !inQuote:
+			fields = append(fields, field)
+			field = nil
+		case b == '\n' && !inQuote:
+			return append(fields, bytes.TrimSuffix(field, []byte{'\r'})), nil
+		default:
+			field = append(field, b)
+		}
+	}
+}
+
+func writeRow(w io.Writer, fields [][]byte) error {
+	row := bytes.Join(fields, []byte{','})
+	row = append(row, '\n')
+	_, err := w.Write(row)
+	return errors.Trace(err)
+}
+
+func isQuoted(field []byte) bool {
+	return len(field) >= 2 && field[0] == '"' && field[len(field)-1] == '"'
+}
+
+// nextToken returns the length of the next token in the raw field and the number of bytes it decodes to,
+// an escape sequence or a doubled quote is a single token so that it is never split.
+func nextToken(raw []byte, quoted bool) (int, int) {
+	switch {
+	case raw[0] == '\\' && len(raw) > 1:
+		return 2, 1
+	case quoted && raw[0] == '"' && len(raw) > 1 && raw[1] == '"':
+		return 2, 1
+	default:
+		_, size := utf8.DecodeRune(raw)
+		return size, size
+	}
+}
+
+// fieldSize returns the size in bytes of the decoded field
+func fieldSize(field []byte) int64 {
+	if bytes.Equal(field, nullField) {
+		return 0
+	}
+	quoted := isQuoted(field)
+	if quoted {
+		field = field[1 : len(field)-1]
+	}
+	var size int64
+	for len(field) > 0 {
+		n, decoded := nextToken(field, quoted)
+		field = field[n:]
+		size += int64(decoded)
+	}
+	return size
+}
+
+// truncateField truncates the field to at most limit decoded bytes without
+// splitting escape sequences or multi-byte characters.
+func truncateField(field []byte, limit int64) []byte {
+	quoted := isQuoted(field)
+	inner := field
+	if quoted {
+		inner = field[1 : len(field)-1]
+	}
+	var size int64
+	end := 0
+	for end < len(inner) {
+		n, decoded := nextToken(inner[end:], quoted)
+		if size+int64(decoded) > limit {
+			break
+		}
+		size += int64(decoded)
+		end += n
+	}
+	if !quoted {
+		return append([]byte{}, inner[:end]...)
+	}
+	truncated := make([]byte, 0, end+2)
+	truncated = append(truncated, '"')
+	truncated = append(truncated, inner[:end]...)
+	return append(truncated, '"')
+}
+
+// displayValue returns the decoded-ish value of the field for logging, long values are cut
+func displayValue(field []byte) string {
+	if bytes.Equal(field, nullField) {
+		return "NULL"
+	}
+	if isQuoted(field) {
+		field = field[1 : len(field)-1]
+	}
+	const maxLen = 64
+	if len(field) > maxLen {
+		return string(truncateField(field, maxLen)) + "..."
+	}
+	return string(field)
+}
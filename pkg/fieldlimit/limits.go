@@ -0,0 +1,56 @@
+package fieldlimit
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// Limits are the maximum sizes in bytes accepted by a data warehouse, zero means unlimited.
+type Limits struct {
+	MaxFieldSize int64
+	MaxRowSize   int64
+}
+
+// WarehouseLimits are the documented limits of each data warehouse
+var WarehouseLimits = map[string]Limits{
+	// VARCHAR is at most 16 MB
+	"snowflake": {MaxFieldSize: 16 * 1024 * 1024},
+	// VARCHAR is at most 65535 bytes and COPY accepts rows of at most 4 MB
+	"redshift": {MaxFieldSize: 65535, MaxRowSize: 4 * 1024 * 1024},
+	// rows and cells of CSV files are at most 100 MB
+	"bigquery": {MaxFieldSize: 100 * 1024 * 1024, MaxRowSize: 100 * 1024 * 1024},
+	// STRING has no documented limit
+	"databricks": {},
+	// a field is at most 1 GB
+	"postgres": {MaxFieldSize: 1024 * 1024 * 1024},
+}
+
+// Config enables the check of field limits before the files are loaded
+type Config struct {
+	Limits Limits
+	Policy Policy
+}
+
+// Policy is the action applied on the field exceeding the limit
+type Policy string
+
+const (
+	// PolicyError fails the replication, which is the default
+	PolicyError Policy = "error"
+	// PolicyTruncate truncates the field to the limit
+	PolicyTruncate Policy = "truncate"
+	// PolicyNull replaces the field with NULL
+	PolicyNull Policy = "null"
+	// PolicyDeadLetter moves the whole row into the dead-letter directory of the workspace
+	PolicyDeadLetter Policy = "dead-letter"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch policy := Policy(strings.ToLower(s)); policy {
+	case PolicyError, PolicyTruncate, PolicyNull, PolicyDeadLetter:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown field limit policy %s, expected one of error, truncate, null, dead-letter", s)
+	}
+}
@@ -0,0 +1,44 @@
+package identcase
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// Case is the case the names of the tables and the columns are written in the data warehouse, it is the
+// --identifier-case flag. The names are always quoted, so the case written is the case stored.
+type Case string
+
+const (
+	// Preserve writes the names in the case of TiDB
+	Preserve Case = "preserve"
+	// Upper writes the names in upper case, as Snowflake stores the unquoted names
+	Upper Case = "upper"
+	// Lower writes the names in lower case, as Redshift and Databricks store the unquoted names
+	Lower Case = "lower"
+)
+
+// Parse parses the value of --identifier-case, case-insensitive, empty means the convention of the data warehouse
+func Parse(s string, convention Case) (Case, error) {
+	switch c := Case(strings.ToLower(s)); c {
+	case "":
+		return convention, nil
+	case Preserve, Upper, Lower:
+		return c, nil
+	default:
+		return "", errors.Errorf("unknown identifier case %s, expected one of preserve, upper, lower", s)
+	}
+}
+
+// Apply returns the name in the case, the zero Case preserves it
+func (c Case) Apply(name string) string {
+	switch c {
+	case Upper:
+		return strings.ToUpper(name)
+	case Lower:
+		return strings.ToLower(name)
+	default:
+		return name
+	}
+}
@@ -0,0 +1,30 @@
+package identcase_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	c, err := identcase.Parse("", identcase.Upper)
+	require.NoError(t, err)
+	require.Equal(t, identcase.Upper, c)
+	c, err = identcase.Parse("Preserve", identcase.Upper)
+	require.NoError(t, err)
+	require.Equal(t, identcase.Preserve, c)
+	_, err = identcase.Parse("title", identcase.Upper)
+	require.ErrorContains(t, err, "unknown identifier case title")
+}
+
+func TestApply(t *testing.T) {
+	for _, name := range []string{"UserID", "user_id", "名称"} {
+		require.Equal(t, name, identcase.Preserve.Apply(name))
+		require.Equal(t, name, identcase.Case("").Apply(name))
+	}
+	require.Equal(t, "USERID", identcase.Upper.Apply("UserID"))
+	require.Equal(t, "USER_ID", identcase.Upper.Apply("user_id"))
+	require.Equal(t, "userid", identcase.Lower.Apply("UserID"))
+	require.Equal(t, "名称", identcase.Lower.Apply("名称"))
+}
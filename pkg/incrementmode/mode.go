@@ -0,0 +1,67 @@
+package incrementmode
+
+import (
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Mode is how the increment files are applied to the data warehouse, it is the --increment-mode flag. The zero Mode
+// is Merge.
+type Mode string
+
+const (
+	// Merge merges the changes into the table, which mirrors the table in TiDB. It is the default.
+	Merge Mode = "merge"
+	// Append appends every change as a row to the changelog table of the table, the table keeps the snapshot
+	Append Mode = "append"
+)
+
+// ChangelogSuffix is appended to the name of the table to name its changelog table
+const ChangelogSuffix = "_changelog"
+
+// Parse parses the value of --increment-mode, case-insensitive
+func Parse(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(s)); mode {
+	case Merge, Append:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown increment mode %s, expected one of merge, append", s)
+	}
+}
+
+// ChangelogTable returns the name of the changelog table of the table
+func ChangelogTable(table string) string {
+	return table + ChangelogSuffix
+}
+
+// ChangelogColumns returns the columns of the changelog table: the flag of the change, I, U or D, and its commit ts
+// followed by the columns of the table. The changelog has no primary key since a row changes many times.
+// The columns are in the order of the fields of the increment file, see utils.GenIncrementTableColumns.
+func ChangelogColumns(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+	changelogColumns := []cloudstorage.TableCol{
+		{Name: utils.CDCFlagColumnName, Tp: "varchar", Precision: "1"},
+		{Name: utils.CDCCommitTsColumnName, Tp: "bigint"},
+	}
+	for _, column := range columns {
+		column.IsPK = ""
+		changelogColumns = append(changelogColumns, column)
+	}
+	return changelogColumns
+}
+
+// EvolvesChangelog tells whether the DDL is applied to the changelog table too. The DDLs of the columns are, while
+// the DDLs of the whole table are not, so the history survives a truncated or dropped table. A renamed table
+// continues its history in the changelog table of the new name.
+func EvolvesChangelog(tp timodel.ActionType) bool {
+	switch tp {
+	case timodel.ActionTruncateTable, timodel.ActionDropTable, timodel.ActionCreateTable,
+		timodel.ActionDropSchema, timodel.ActionCreateSchema:
+		return false
+	}
+	return !tidbsql.IsRenameTable(tp)
+}
@@ -0,0 +1,42 @@
+package incrementmode
+
+import (
+	"testing"
+
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	mode, err := Parse("APPEND")
+	require.NoError(t, err)
+	require.Equal(t, Append, mode)
+	mode, err = Parse("merge")
+	require.NoError(t, err)
+	require.Equal(t, Merge, mode)
+	_, err = Parse("upsert")
+	require.ErrorContains(t, err, "unknown increment mode upsert")
+}
+
+func TestChangelogColumns(t *testing.T) {
+	columns := ChangelogColumns([]cloudstorage.TableCol{
+		{Name: "id", Tp: "int", IsPK: "true"},
+		{Name: "v", Tp: "varchar", Precision: "10"},
+	})
+	require.Equal(t, []cloudstorage.TableCol{
+		{Name: "tidb2dw_flag", Tp: "varchar", Precision: "1"},
+		{Name: "tidb2dw_commit_ts", Tp: "bigint"},
+		{Name: "id", Tp: "int"},
+		{Name: "v", Tp: "varchar", Precision: "10"},
+	}, columns)
+	require.Equal(t, "t_changelog", ChangelogTable("t"))
+}
+
+func TestEvolvesChangelog(t *testing.T) {
+	require.True(t, EvolvesChangelog(timodel.ActionAddColumn))
+	require.True(t, EvolvesChangelog(timodel.ActionModifyColumn))
+	require.False(t, EvolvesChangelog(timodel.ActionTruncateTable))
+	require.False(t, EvolvesChangelog(timodel.ActionDropTable))
+	require.False(t, EvolvesChangelog(timodel.ActionRenameTable))
+}
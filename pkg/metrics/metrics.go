@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tidb2dw"
+
+// The operations of the connectors counted by ConnectorErrors
+const (
+	OpCopyTableSchema = "copy_table_schema"
+	OpLoadSnapshot    = "load_snapshot"
+	OpInitSchema      = "init_schema"
+	OpExecDDL         = "exec_ddl"
+	OpLoadIncrement   = "load_increment"
+	OpDiffSchema      = "diff_schema"
+	OpReconcileSchema = "reconcile_schema"
+)
+
+var (
+	SnapshotDumpedRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "snapshot",
+		Name:      "dumped_rows",
+		Help:      "Rows of the snapshot of the table dumped from TiDB",
+	}, []string{"schema", "table"})
+	SnapshotLoadedRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "snapshot",
+		Name:      "loaded_rows",
+		Help:      "Rows of the snapshot of the table loaded into the data warehouse as reported by it",
+	}, []string{"schema", "table"})
+	IncrementFiles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "files_total",
+		Help:      "Increment files of the table merged into the data warehouse",
+	}, []string{"schema", "table"})
+	IncrementBadRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "bad_rows_total",
+		Help:      "Rows of the increment files of the table rejected by the data warehouse and skipped by --max-bad-rows",
+	}, []string{"schema", "table"})
+	// IncrementRows is labeled by the operation of the rows in the files, I, U or D
+	IncrementRows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "rows_total",
+		Help:      "Rows of the increment files of the table merged into the data warehouse by operation",
+	}, []string{"schema", "table", "type"})
+	IncrementMergeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "merge_duration_seconds",
+		Help:      "Time of merging an increment file of the table into the data warehouse",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"schema", "table"})
+	// ReplicationLag is how far the data warehouse is behind the checkpoint of the changefeed, only known if the
+	// changefeed is managed by tidb2dw
+	ReplicationLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "lag_seconds",
+		Help:      "Seconds the table in the data warehouse is behind the checkpoint of the changefeed",
+	}, []string{"schema", "table"})
+	// ReplicationLagOverdue is the part of ReplicationLag beyond the files waiting for the next merge by design, so
+	// that it is above 0 only if the merges fall behind
+	ReplicationLagOverdue = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "increment",
+		Name:      "lag_overdue_seconds",
+		Help:      "Seconds the lag of the table exceeds the merge interval and the flush interval of the changefeed, 0 while the files wait for the next merge",
+	}, []string{"schema", "table"})
+	// ChangefeedState is 1 for the current state of the changefeed, the series of the former states are removed
+	ChangefeedState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "changefeed",
+		Name:      "state",
+		Help:      "State of the changefeed writing the increment files, 1 for the current state",
+	}, []string{"changefeed", "state"})
+	// StagingBytes is the bytes of the files downloaded into --staging-dir and not loaded yet
+	StagingBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "staging",
+		Name:      "bytes",
+		Help:      "Bytes of the files downloaded into the staging directory and not loaded yet",
+	})
+	StagingFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "staging",
+		Name:      "files",
+		Help:      "Files downloaded into the staging directory and not loaded yet",
+	})
+	ConnectorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "connector",
+		Name:      "errors_total",
+		Help:      "Errors of the operations of the data warehouse connector of the table",
+	}, []string{"schema", "table", "operation"})
+	// StorageUploadedBytes and StorageDownloadedBytes are the bytes written into and read from the external
+	// storages by the pipelines through their ratelimit.Limiters, the data warehouses loading the files from the
+	// storage are not counted
+	StorageUploadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "uploaded_bytes_total",
+		Help:      "Bytes written into the external storages",
+	})
+	StorageDownloadedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "downloaded_bytes_total",
+		Help:      "Bytes read from the external storages",
+	})
+	// StorageUploadRateLimit and StorageDownloadRateLimit are the limits last set by a pipeline
+	StorageUploadRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "upload_rate_limit_bytes",
+		Help:      "Bytes per second written into the external storages at most, 0 if unlimited",
+	})
+	StorageDownloadRateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "storage",
+		Name:      "download_rate_limit_bytes",
+		Help:      "Bytes per second read from the external storages at most, 0 if unlimited",
+	})
+)
+
+// Registry has the metrics of tidb2dw and the runtime, the metrics registered by the dependencies are not exposed
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		SnapshotDumpedRows,
+		SnapshotLoadedRows,
+		IncrementFiles,
+		IncrementBadRows,
+		IncrementRows,
+		IncrementMergeDuration,
+		ReplicationLag,
+		ReplicationLagOverdue,
+		ChangefeedState,
+		StagingBytes,
+		StagingFiles,
+		ConnectorErrors,
+		StorageUploadedBytes,
+		StorageDownloadedBytes,
+		StorageUploadRateLimit,
+		StorageDownloadRateLimit,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// TableLabels returns the schema and table labels of the table given by its FQN, e.g. db.t
+func TableLabels(tableFQN string) prometheus.Labels {
+	schema, table := utils.SplitTableFQN(tableFQN)
+	return prometheus.Labels{"schema": schema, "table": table}
+}
+
+// CountConnectorError counts the error of the operation of the connector of the table, nil is not counted.
+// It returns err, so that the call is wrapped around the operation.
+func CountConnectorError(tableFQN, operation string, err error) error {
+	if err != nil {
+		schema, table := utils.SplitTableFQN(tableFQN)
+		ConnectorErrors.WithLabelValues(schema, table, operation).Inc()
+	}
+	return err
+}
+
+// SetChangefeedState marks the state as the current state of the changefeed
+func SetChangefeedState(changefeed, state string) {
+	ChangefeedState.DeletePartialMatch(prometheus.Labels{"changefeed": changefeed})
+	ChangefeedState.WithLabelValues(changefeed, state).Set(1)
+}
@@ -0,0 +1,33 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountConnectorError(t *testing.T) {
+	require.NoError(t, metrics.CountConnectorError("db.t", metrics.OpExecDDL, nil))
+	err := errors.New("boom")
+	require.Equal(t, err, metrics.CountConnectorError("db.t", metrics.OpExecDDL, err))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ConnectorErrors.WithLabelValues("db", "t", metrics.OpExecDDL)))
+}
+
+func TestSetChangefeedState(t *testing.T) {
+	metrics.SetChangefeedState("cf", "normal")
+	metrics.SetChangefeedState("cf", "failed")
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.ChangefeedState))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.ChangefeedState.WithLabelValues("cf", "failed")))
+}
+
+func TestHandler(t *testing.T) {
+	metrics.IncrementRows.WithLabelValues("db", "t", "I").Add(3)
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	require.Contains(t, recorder.Body.String(), `tidb2dw_increment_rows_total{schema="db",table="t",type="I"} 3`)
+	require.Contains(t, recorder.Body.String(), "go_goroutines")
+}
@@ -0,0 +1,55 @@
+package notify
+
+import "time"
+
+// LagTracker finds the tables whose lag stays over the threshold for the duration, a table is found once until its
+// lag falls below the threshold again
+type LagTracker struct {
+	threshold time.Duration
+	duration  time.Duration
+	// over is since when the lag of each table is over the threshold
+	over map[string]time.Time
+	// reported are the tables found until their lag falls below the threshold
+	reported map[string]struct{}
+}
+
+// NewLagTracker returns the tracker of the threshold, which is never reached if it is 0
+func NewLagTracker(threshold, duration time.Duration) *LagTracker {
+	return &LagTracker{
+		threshold: threshold,
+		duration:  duration,
+		over:      make(map[string]time.Time),
+		reported:  make(map[string]struct{}),
+	}
+}
+
+// Observe records the lags of the tables in seconds and returns the tables over the threshold for the duration
+// since the last observation. A table whose lag is unknown is not over the threshold.
+func (t *LagTracker) Observe(lags map[string]float64, now time.Time) []string {
+	var found []string
+	for table, since := range t.over {
+		if lag, ok := lags[table]; !ok || !t.isOver(lag) {
+			delete(t.over, table)
+			delete(t.reported, table)
+			continue
+		}
+		if _, ok := t.reported[table]; !ok && now.Sub(since) >= t.duration {
+			t.reported[table] = struct{}{}
+			found = append(found, table)
+		}
+	}
+	for table, lag := range lags {
+		if _, ok := t.over[table]; !ok && t.isOver(lag) {
+			t.over[table] = now
+			if t.duration == 0 {
+				t.reported[table] = struct{}{}
+				found = append(found, table)
+			}
+		}
+	}
+	return found
+}
+
+func (t *LagTracker) isOver(lag float64) bool {
+	return t.threshold > 0 && lag > t.threshold.Seconds()
+}
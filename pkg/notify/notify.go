@@ -0,0 +1,279 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// Event is what a notification is sent for
+type Event string
+
+const (
+	// EventSnapshotDumped is sent once the snapshot of all tables is dumped
+	EventSnapshotDumped Event = "snapshot-dumped"
+	// EventSnapshotLoaded is sent once the snapshot of all tables is loaded into the data warehouse
+	EventSnapshotLoaded Event = "snapshot-loaded"
+	// EventLag is sent once the lag of a table stays over the threshold for a while
+	EventLag Event = "lag"
+	// EventChangefeedFailed is sent once the changefeed is found stopped or failed
+	EventChangefeedFailed Event = "changefeed-failed"
+	// EventFatalError is sent once the replication fails and the process exits
+	EventFatalError Event = "fatal-error"
+)
+
+// Events are all the events, in the order they usually happen
+var Events = []Event{EventSnapshotDumped, EventSnapshotLoaded, EventLag, EventChangefeedFailed, EventFatalError}
+
+// ParseEvents parses the events of --notify-events, all the events are sent if none is given
+func ParseEvents(names []string) ([]Event, error) {
+	if len(names) == 0 {
+		return Events, nil
+	}
+	events := make([]Event, 0, len(names))
+	for _, name := range names {
+		event := Event(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(Events, event) {
+			return nil, errors.Errorf("invalid notify event %s, expected some of %s", name, strings.Join(eventNames(), ", "))
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func eventNames() []string {
+	names := make([]string, 0, len(Events))
+	for _, event := range Events {
+		names = append(names, string(event))
+	}
+	return names
+}
+
+// Format is the payload POSTed to the webhook
+type Format string
+
+const (
+	// FormatJSON posts the Notification as it is
+	FormatJSON Format = "json"
+	// FormatSlack posts the text of the notification as the incoming webhooks of Slack expect, e.g. {"text": "..."}
+	FormatSlack Format = "slack"
+)
+
+// ParseFormat parses the format of --notify-format
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(s)); format {
+	case FormatJSON, FormatSlack:
+		return format, nil
+	}
+	return "", errors.Errorf("invalid notify format %s, expected json or slack", s)
+}
+
+// Notification is the payload of FormatJSON
+type Notification struct {
+	Event Event `json:"event"`
+	// Workspace is the storage path of the replication without secrets
+	Workspace string `json:"workspace"`
+	// Table is empty for the events of all tables
+	Table   string    `json:"table,omitempty"`
+	Stage   string    `json:"stage,omitempty"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// text is the notification as a message of FormatSlack
+func (n *Notification) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*tidb2dw %s*: %s\nworkspace: `%s`", n.Event, n.Message, n.Workspace)
+	if n.Table != "" {
+		fmt.Fprintf(&b, "\ntable: `%s`", n.Table)
+	}
+	if n.Stage != "" {
+		fmt.Fprintf(&b, "\nstage: `%s`", n.Stage)
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&b, "\n```%s```", n.Error)
+	}
+	return b.String()
+}
+
+// Config is how the notifications are sent
+type Config struct {
+	WebhookURL string
+	Format     Format
+	// Events are the events sent, the other events are dropped
+	Events []Event
+	// LagThreshold and LagDuration are how long the lag of a table must stay over the threshold before EventLag
+	LagThreshold time.Duration
+	LagDuration  time.Duration
+}
+
+const (
+	// queueSize is the notifications waiting to be sent at most, the later ones are dropped
+	queueSize = 64
+	// requestTimeout is the timeout of each POST to the webhook
+	requestTimeout = 10 * time.Second
+)
+
+// deliveryRetry is how a failed POST is retried, the notifications after it wait meanwhile
+var deliveryRetry = retry.Policy{MaxRetries: 3, MaxBackoff: 10 * time.Second}
+
+// Notifier POSTs the notifications to the webhook in the background, in the order they are sent. A failed
+// delivery is retried a few times and then logged, it never fails the replication. A nil Notifier sends nothing.
+type Notifier struct {
+	cfg    Config
+	client *http.Client
+	queue  chan Notification
+	// ctx is canceled once Close gives up waiting for the notifications queued
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// New starts the notifier of the config, nil if no webhook is set
+func New(cfg Config) *Notifier {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+	if len(cfg.Events) == 0 {
+		cfg.Events = Events
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: requestTimeout},
+		queue:  make(chan Notification, queueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Enabled tells whether the notifications of the event are sent
+func (n *Notifier) Enabled(event Event) bool {
+	return n != nil && slices.Contains(n.cfg.Events, event)
+}
+
+// Notify queues the notification without blocking, it is dropped if the event is not enabled, the notifier is
+// closed or too many notifications are waiting
+func (n *Notifier) Notify(notification Notification) {
+	if !n.Enabled(notification.Event) {
+		return
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+	notification.Error = diag.RedactSecrets(notification.Error)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- notification:
+	default:
+		log.Warn("Dropped notification since too many are waiting to be sent", zap.String("event", string(notification.Event)), zap.String("table", notification.Table))
+	}
+}
+
+// Close waits for the notifications queued to be sent until ctx is done, the rest are dropped
+func (n *Notifier) Close(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	select {
+	case <-n.done:
+	case <-ctx.Done():
+		n.cancel()
+		<-n.done
+		log.Warn("Dropped the notifications not sent before exit")
+	}
+}
+
+// NewLagTracker returns the tracker of the lags by LagThreshold and LagDuration
+func (n *Notifier) NewLagTracker() *LagTracker {
+	return NewLagTracker(n.cfg.LagThreshold, n.cfg.LagDuration)
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		if n.ctx.Err() != nil {
+			continue
+		}
+		if err := n.send(notification); err != nil {
+			log.Warn("Failed to send notification", zap.String("event", string(notification.Event)), zap.String("table", notification.Table), zap.Error(err))
+		}
+	}
+}
+
+// send POSTs the notification, retrying the network errors and the responses of 429 and 5xx
+func (n *Notifier) send(notification Notification) error {
+	var payload any = notification
+	if n.cfg.Format == FormatSlack {
+		payload = map[string]string{"text": notification.text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return retry.Do(n.ctx, deliveryRetry, isRetryable, func() error {
+		req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return &statusError{code: resp.StatusCode}
+		}
+		return nil
+	}, func(retry int, backoff time.Duration, err error) {
+		log.Info("Retrying notification", zap.String("event", string(notification.Event)), zap.Int("retry", retry+1), zap.Duration("backoff", backoff), zap.Error(err))
+	})
+}
+
+// statusError is the unexpected status of the webhook
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded %d %s", e.code, http.StatusText(e.code))
+}
+
+// isRetryable tells whether the POST may succeed if it is sent again, a request refused by the webhook is not
+func isRetryable(err error) bool {
+	if statusErr, ok := errors.Cause(err).(*statusError); ok {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+	return !stderrors.Is(err, context.Canceled)
+}
@@ -0,0 +1,133 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/notify"
+	"github.com/stretchr/testify/require"
+)
+
+// webhook records the bodies POSTed, the first failures requests are responded with status
+type webhook struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	failures int
+	status   int
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		rw.WriteHeader(w.status)
+		return
+	}
+	w.bodies = append(w.bodies, body)
+}
+
+func (w *webhook) received() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bodies
+}
+
+func TestParseEvents(t *testing.T) {
+	events, err := notify.ParseEvents(nil)
+	require.NoError(t, err)
+	require.Equal(t, notify.Events, events)
+	events, err = notify.ParseEvents([]string{"Lag", " fatal-error"})
+	require.NoError(t, err)
+	require.Equal(t, []notify.Event{notify.EventLag, notify.EventFatalError}, events)
+	_, err = notify.ParseEvents([]string{"snapshot-done"})
+	require.ErrorContains(t, err, "invalid notify event snapshot-done")
+
+	format, err := notify.ParseFormat("Slack")
+	require.NoError(t, err)
+	require.Equal(t, notify.FormatSlack, format)
+	_, err = notify.ParseFormat("xml")
+	require.Error(t, err)
+}
+
+func TestNotifier(t *testing.T) {
+	hook := &webhook{failures: 1, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	var nilNotifier *notify.Notifier
+	require.False(t, nilNotifier.Enabled(notify.EventLag))
+	nilNotifier.Notify(notify.Notification{Event: notify.EventLag})
+	nilNotifier.Close(context.Background())
+	require.Nil(t, notify.New(notify.Config{}))
+
+	notifier := notify.New(notify.Config{
+		WebhookURL: server.URL,
+		Format:     notify.FormatJSON,
+		Events:     []notify.Event{notify.EventSnapshotLoaded, notify.EventFatalError},
+	})
+	require.False(t, notifier.Enabled(notify.EventLag))
+	notifier.Notify(notify.Notification{Event: notify.EventLag, Message: "dropped"})
+	notifier.Notify(notify.Notification{Event: notify.EventSnapshotLoaded, Workspace: "s3://bucket/path", Message: "Snapshot of all tables is loaded"})
+	notifier.Notify(notify.Notification{Event: notify.EventFatalError, Stage: "snapshot-loaded", Error: "password=secret is wrong"})
+	notifier.Close(context.Background())
+	// the notifier closed drops the notifications
+	notifier.Notify(notify.Notification{Event: notify.EventFatalError})
+
+	// the first POST failed is retried
+	bodies := hook.received()
+	require.Len(t, bodies, 2)
+	var loaded, failed notify.Notification
+	require.NoError(t, json.Unmarshal(bodies[0], &loaded))
+	require.Equal(t, notify.EventSnapshotLoaded, loaded.Event)
+	require.Equal(t, "s3://bucket/path", loaded.Workspace)
+	require.False(t, loaded.Time.IsZero())
+	require.NoError(t, json.Unmarshal(bodies[1], &failed))
+	require.Equal(t, "snapshot-loaded", failed.Stage)
+	require.Equal(t, "password=xxxxx is wrong", failed.Error)
+}
+
+func TestNotifierSlack(t *testing.T) {
+	hook := &webhook{failures: 1, status: http.StatusNotFound}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	notifier := notify.New(notify.Config{WebhookURL: server.URL, Format: notify.FormatSlack})
+	// the request refused is not retried
+	notifier.Notify(notify.Notification{Event: notify.EventSnapshotDumped, Message: "dropped"})
+	notifier.Notify(notify.Notification{Event: notify.EventLag, Workspace: "gcs://bucket/path", Table: "test.t", Message: "Increment of the table lags 20m0s behind the changefeed"})
+	notifier.Close(context.Background())
+
+	bodies := hook.received()
+	require.Len(t, bodies, 1)
+	var payload map[string]string
+	require.NoError(t, json.Unmarshal(bodies[0], &payload))
+	require.Equal(t, "*tidb2dw lag*: Increment of the table lags 20m0s behind the changefeed\nworkspace: `gcs://bucket/path`\ntable: `test.t`", payload["text"])
+}
+
+func TestLagTracker(t *testing.T) {
+	start := time.Now()
+	tracker := notify.NewLagTracker(10*time.Minute, 5*time.Minute)
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 700, "test.b": 60}, start))
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 900, "test.b": 60}, start.Add(4*time.Minute)))
+	require.Equal(t, []string{"test.a"}, tracker.Observe(map[string]float64{"test.a": 900, "test.b": 60}, start.Add(5*time.Minute)))
+	// a table is found once until its lag falls below the threshold
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 1200, "test.b": 60}, start.Add(10*time.Minute)))
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 30, "test.b": 60}, start.Add(11*time.Minute)))
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 700}, start.Add(12*time.Minute)))
+	require.Equal(t, []string{"test.a"}, tracker.Observe(map[string]float64{"test.a": 700}, start.Add(17*time.Minute)))
+
+	// without a duration the lag over the threshold is found at once
+	tracker = notify.NewLagTracker(time.Minute, 0)
+	require.Equal(t, []string{"test.a"}, tracker.Observe(map[string]float64{"test.a": 61}, start))
+	// a threshold of 0 is never reached
+	tracker = notify.NewLagTracker(0, 0)
+	require.Empty(t, tracker.Observe(map[string]float64{"test.a": 3600}, start))
+}
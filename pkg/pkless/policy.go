@@ -0,0 +1,116 @@
+// Package pkless decides how the tables without a primary key are replicated. The increment files are merged into a
+// table by its primary key, so a table without one is refused unless a dedup key is nominated to merge by instead,
+// or its changes are appended to its changelog table like incrementmode.Append.
+package pkless
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Mode is what is done to a table without a primary key or a dedup key, it is the --pkless-mode flag. The zero Mode
+// is Error.
+type Mode string
+
+const (
+	// Error refuses to replicate the table, which is the default
+	Error Mode = "error"
+	// Append appends every change of the table as a row to its changelog table, the table keeps the snapshot
+	Append Mode = "append"
+)
+
+// Parse parses the value of --pkless-mode, case-insensitive
+func Parse(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(s)); mode {
+	case Error, Append:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown pkless mode %s, expected one of error, append", s)
+	}
+}
+
+// ParseDedupKeys parses the values of --dedup-key, <db>.<table>=<column>[,<column>...] for a table or
+// <column>[,<column>...] for all the tables without a primary key, which is keyed by "".
+func ParseDedupKeys(values []string) (map[string][]string, error) {
+	keys := make(map[string][]string, len(values))
+	for _, value := range values {
+		tableFQN, list, ok := strings.Cut(value, "=")
+		if !ok {
+			tableFQN, list = "", value
+		}
+		tableFQN = strings.TrimSpace(tableFQN)
+		if ok && strings.Count(tableFQN, ".") != 1 {
+			return nil, errors.Errorf("invalid --dedup-key %s, expect [<db>.<table>=]<column>[,<column>...]", value)
+		}
+		if _, ok := keys[tableFQN]; ok {
+			if tableFQN == "" {
+				return nil, errors.New("duplicated --dedup-key of all the tables")
+			}
+			return nil, errors.Errorf("duplicated --dedup-key of table %s", tableFQN)
+		}
+		var columns []string
+		for _, column := range strings.Split(list, ",") {
+			column = strings.TrimSpace(column)
+			if column == "" {
+				return nil, errors.Errorf("invalid --dedup-key %s, expect [<db>.<table>=]<column>[,<column>...]", value)
+			}
+			if !slices.Contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+		keys[tableFQN] = columns
+	}
+	return keys, nil
+}
+
+// Policy is how the tables without a primary key are replicated, the zero Policy refuses them
+type Policy struct {
+	Mode Mode
+	// DedupKeys are the columns the rows of the tables are merged by, see ParseDedupKeys
+	DedupKeys map[string][]string
+}
+
+// DedupKey returns the dedup key of the table, nil if there is none
+func (p Policy) DedupKey(tableFQN string) []string {
+	if key, ok := p.DedupKeys[tableFQN]; ok {
+		return key
+	}
+	return p.DedupKeys[""]
+}
+
+// Key returns the columns the rows of the table are merged by: the primary key, or the dedup key if it has none
+func (p Policy) Key(tableFQN string, pkColumns []string) []string {
+	if len(pkColumns) > 0 {
+		return pkColumns
+	}
+	return p.DedupKey(tableFQN)
+}
+
+// Resolve marks the dedup key of the table as its primary key if it has none, and tells whether the changes of the
+// table are appended instead of merged. It fails if the table has neither key and the mode is Error, or a column of
+// the dedup key is not in the table. The columns without any column, e.g. of a DROP TABLE, are returned as they are.
+func (p Policy) Resolve(tableFQN string, columns []cloudstorage.TableCol) ([]cloudstorage.TableCol, bool, error) {
+	if len(columns) == 0 || len(tidbsql.GetPKColumns(columns)) > 0 {
+		return columns, false, nil
+	}
+	key := p.DedupKey(tableFQN)
+	if len(key) == 0 {
+		if p.Mode == Append {
+			return columns, true, nil
+		}
+		return nil, false, errors.Errorf("table %s has no primary key, set --dedup-key to merge its rows by other columns or --pkless-mode=append to append its changes", tableFQN)
+	}
+	marked := slices.Clone(columns)
+	for _, name := range key {
+		i := slices.IndexFunc(marked, func(column cloudstorage.TableCol) bool { return column.Name == name })
+		if i < 0 {
+			return nil, false, errors.Errorf("column %s of the dedup key is not in table %s", name, tableFQN)
+		}
+		marked[i].IsPK = "true"
+	}
+	return marked, false, nil
+}
@@ -0,0 +1,61 @@
+package pkless
+
+import (
+	"testing"
+
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	mode, err := Parse("Append")
+	require.NoError(t, err)
+	require.Equal(t, Append, mode)
+	_, err = Parse("merge")
+	require.ErrorContains(t, err, "unknown pkless mode merge")
+}
+
+func TestParseDedupKeys(t *testing.T) {
+	keys, err := ParseDedupKeys([]string{"test.t1=a, b", "c,c"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"test.t1": {"a", "b"}, "": {"c"}}, keys)
+
+	for _, values := range [][]string{{"t1=a"}, {"test.t1="}, {"a,,b"}, {"test.t1=a", "test.t1=b"}, {"a", "b"}} {
+		_, err = ParseDedupKeys(values)
+		require.Error(t, err, values)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	columns := []cloudstorage.TableCol{{Name: "a", Tp: "int"}, {Name: "b", Tp: "varchar"}}
+	withPK := []cloudstorage.TableCol{{Name: "id", Tp: "int", IsPK: "true"}, {Name: "a", Tp: "int"}}
+
+	// the primary key is kept
+	policy := Policy{DedupKeys: map[string][]string{"": {"a"}}}
+	resolved, appends, err := policy.Resolve("test.t", withPK)
+	require.NoError(t, err)
+	require.False(t, appends)
+	require.Equal(t, withPK, resolved)
+	require.Equal(t, []string{"id"}, policy.Key("test.t", []string{"id"}))
+
+	// the dedup key of the table overrides the one of all the tables
+	policy = Policy{DedupKeys: map[string][]string{"": {"a"}, "test.t": {"b", "a"}}}
+	resolved, appends, err = policy.Resolve("test.t", columns)
+	require.NoError(t, err)
+	require.False(t, appends)
+	require.Equal(t, "true", resolved[0].IsPK)
+	require.Equal(t, "true", resolved[1].IsPK)
+	require.Empty(t, columns[0].IsPK)
+	require.Equal(t, []string{"b", "a"}, policy.Key("test.t", nil))
+
+	_, _, err = Policy{DedupKeys: map[string][]string{"": {"c"}}}.Resolve("test.t", columns)
+	require.ErrorContains(t, err, "column c of the dedup key is not in table test.t")
+
+	_, _, err = Policy{}.Resolve("test.t", columns)
+	require.ErrorContains(t, err, "table test.t has no primary key")
+
+	resolved, appends, err = Policy{Mode: Append}.Resolve("test.t", columns)
+	require.NoError(t, err)
+	require.True(t, appends)
+	require.Equal(t, columns, resolved)
+}
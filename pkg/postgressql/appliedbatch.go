@@ -0,0 +1,60 @@
+package postgressql
+
+import (
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+)
+
+// SetAppliedBatch sets the batch recorded by the next LoadIncrement, in the transaction of the merge or the append
+func (pc *PostgresConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	pc.appliedBatch = batch
+}
+
+// FindAppliedBatch returns the batch of the id recorded in the bookkeeping table, nil if it is not applied
+func (pc *PostgresConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if err := pc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFind(appliedbatch.TableName, id, quoteLiteral)
+	batch := &appliedbatch.Batch{ID: id}
+	if err := pc.db.QueryRow(query).Scan(&batch.LastFile, &batch.CommitTs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, diag.WrapSQL(err, query)
+	}
+	return batch, nil
+}
+
+// ensureAppliedBatchTable creates the bookkeeping table of the applied batches if it does not exist
+func (pc *PostgresConnector) ensureAppliedBatchTable() error {
+	if pc.appliedBatchTableCreated {
+		return nil
+	}
+	query := appliedbatch.GenCreateTable(appliedbatch.TableName, "TEXT", "BIGINT", "TIMESTAMPTZ")
+	if _, err := pc.db.Exec(query); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, query), "Failed to create applied batch table")
+	}
+	pc.appliedBatchTableCreated = true
+	return nil
+}
+
+// recordAppliedBatch records the batch set by SetAppliedBatch as applied to the table up to the file in the
+// transaction, it is a no-op if no batch is set
+func (pc *PostgresConnector) recordAppliedBatch(tx *sql.Tx, table, filePath string) error {
+	if pc.appliedBatch == nil {
+		return nil
+	}
+	batch := *pc.appliedBatch
+	batch.Table = table
+	batch.LastFile = filePath
+	for _, query := range appliedbatch.GenRecord(appliedbatch.TableName, batch, "CURRENT_TIMESTAMP", quoteLiteral) {
+		if _, err := tx.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to record the applied batch")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package postgressql
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppliedBatchBackslash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.sql")
+	recorder, err := dryrun.NewRecorder(path)
+	require.NoError(t, err)
+	// PostgreSQL reads the backslashes literally unless the string is prefixed by E
+	recorder.StubQuery(`SELECT last_file, commit_ts FROM _tidb2dw_applied_batches WHERE batch_id = E'a\\b\'c'`,
+		[]string{"last_file", "commit_ts"}, []driver.Value{"f.csv", int64(1)})
+	pc := &PostgresConnector{db: recorder.OpenDB(`db.a\b'c`), appliedBatchTableCreated: true}
+
+	batch, err := pc.FindAppliedBatch(`a\b'c`)
+	require.NoError(t, err)
+	require.Equal(t, &appliedbatch.Batch{ID: `a\b'c`, LastFile: "f.csv", CommitTs: 1}, batch)
+
+	pc.SetAppliedBatch(&appliedbatch.Batch{ID: `a\b'c`, CommitTs: 1})
+	tx, err := pc.db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, pc.recordAppliedBatch(tx, `a\b'c`, "f.csv"))
+	require.NoError(t, tx.Commit())
+	require.NoError(t, recorder.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `WHERE batch_id = E'a\\b\'c' OR (table_name = E'a\\b\'c'`)
+	require.Contains(t, string(data), `VALUES (E'a\\b\'c', E'a\\b\'c', E'f.csv', 1, CURRENT_TIMESTAMP)`)
+}
@@ -0,0 +1,72 @@
+package postgressql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+)
+
+type PostgresConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Pass     string
+	Database string
+	Schema   string
+	// CreateSchema creates the schema of the tables if it does not exist, otherwise a missing schema fails the
+	// connectors
+	CreateSchema bool
+	// SSLMode is the sslmode of lib/pq: disable, require, verify-ca or verify-full
+	SSLMode string
+	// SSLRootCert is the CA verifying the server with verify-ca or verify-full, SSLCert and SSLKey are the
+	// client certificate, empty means the defaults of lib/pq
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+}
+
+// Open a connection to PostgreSQL.
+// The schema is set as the search_path of every connection of the pool, quoted as the tables are.
+func (config *PostgresConfig) OpenDB() (*sql.DB, error) {
+	connStr := fmt.Sprintf(
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		quoteConnValue(config.Host), config.Port, quoteConnValue(config.User), quoteConnValue(config.Pass),
+		quoteConnValue(config.Database), quoteConnValue(config.SSLMode))
+	if config.Schema != "" {
+		connStr += fmt.Sprintf(" search_path=%s", quoteConnValue(QuoteIdent(config.Schema)))
+	}
+	connStr += sslConnParams(config.SSLRootCert, config.SSLCert, config.SSLKey)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open PostgreSQL connection")
+	}
+	// make sure the connection is available
+	if err = db.Ping(); err != nil {
+		return nil, errors.Annotate(utils.AnnotateTLSError(err, "PostgreSQL", "postgres"), "Failed to ping PostgreSQL")
+	}
+	log.Info("PostgreSQL connection established")
+	return db, nil
+}
+
+// sslConnParams returns the parameters of the connection string giving the files of TLS
+func sslConnParams(rootCert, cert, key string) string {
+	var params string
+	for _, param := range [][2]string{{"sslrootcert", rootCert}, {"sslcert", cert}, {"sslkey", key}} {
+		if param[1] != "" {
+			params += fmt.Sprintf(" %s=%s", param[0], quoteConnValue(param[1]))
+		}
+	}
+	return params
+}
+
+// quoteConnValue quotes a value of the connection string, e.g. a password containing spaces
+func quoteConnValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return fmt.Sprintf("'%s'", value)
+}
@@ -0,0 +1,617 @@
+package postgressql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/staging"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// A Wrapper of PostgreSQL connection.
+// It implements the coreinterfaces.Connector interface.
+// PostgreSQL can not read the storage, so the files are read by tidb2dw and streamed into COPY FROM STDIN
+// row by row, nothing is written to the local disk unless a staging area is set.
+type PostgresConnector struct {
+	// db is the connection to postgres.
+	db *sql.DB
+	// storageURI is the directory of the files loaded by the connector, opened on the first load
+	storageURI  *url.URL
+	compression utils.Compression
+	extStorage  storage.ExternalStorage
+	// rawStorage is the storage without decompression, the files are downloaded from it into the staging area
+	rawStorage storage.ExternalStorage
+	// staging is the local area the files are downloaded into before they are copied, nil if they are streamed
+	staging *staging.Area
+	// rateLimiters limit the files read from the storage, nil if unlimited
+	rateLimiters *ratelimit.Limiters
+	columns      []cloudstorage.TableCol
+	// columnTypes overrides the types of the columns, nil if the default mapping is used
+	columnTypes columnmapping.Columns
+	// columnFilter is the columns replicated, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows replicated, empty if all the rows are replicated
+	where string
+	// routedTable is the table the source table is replicated to, empty if it is replicated to the table of its name
+	routedTable string
+	// dedupKey is the key the table is created with if the source table has no primary key, nil if there is none
+	dedupKey []string
+	// skipComments leaves the tables and the columns without the comments of TiDB
+	skipComments bool
+	// incrementMode is how the increment files are applied, the zero Mode merges them into the table
+	incrementMode incrementmode.Mode
+	// changelogTable is the changelog table created in incrementmode.Append, empty until it is created
+	changelogTable string
+	// dryRun skips reading the files, the statements are recorded without rows
+	dryRun bool
+	// mergedRows is the total rows inserted or updated by the merges of the increment files, the deleted rows are not counted.
+	// In incrementmode.Append it is the total changes appended.
+	mergedRows int64
+	// appliedBatch is the batch recorded by the loads of the increment files, nil if they are not recorded
+	appliedBatch *appliedbatch.Batch
+	// appliedBatchTableCreated is true once the bookkeeping table of the applied batches is created
+	appliedBatchTableCreated bool
+}
+
+func NewPostgresConnector(db *sql.DB, schemaName string, storageURI *url.URL, compression utils.Compression, createSchema bool) (*PostgresConnector, error) {
+	if err := EnsureSchema(db, schemaName, createSchema); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &PostgresConnector{
+		db:          db,
+		storageURI:  storageURI,
+		compression: compression,
+		columns:     nil,
+	}, nil
+}
+
+func (pc *PostgresConnector) InitSchema(columns []cloudstorage.TableCol) error {
+	if len(pc.columns) != 0 {
+		return nil
+	}
+	if len(columns) == 0 {
+		return errors.New("Columns in schema is empty")
+	}
+	pc.columns = columns
+	log.Info("table columns initialized", zap.Any("Columns", columns))
+	return nil
+}
+
+func (pc *PostgresConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	// a table created after the changefeed starts has no columns before its CREATE TABLE
+	if len(pc.columns) == 0 && tableDef.Type != timodel.ActionCreateTable {
+		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
+	}
+	if pc.routedTable != "" && tidbsql.IsRenameTable(tableDef.Type) {
+		log.Info("Skip rename table ddl, the routed table keeps its name", zap.String("ddl", tableDef.Query), zap.String("table", pc.routedTable))
+		return nil
+	}
+	// the changes of the columns filtered out are ignored
+	ddls, err := GenDDLViaColumnsDiff(pc.columnFilter.Columns(pc.columns), pc.columnFilter.TableDef(pc.routeTableDef(tableDef)), pc.columnTypes, !pc.skipComments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if pc.incrementMode == incrementmode.Append && len(pc.columns) != 0 && incrementmode.EvolvesChangelog(tableDef.Type) {
+		// the changelog table evolves by the same diff, it is created by the columns before the DDL if not yet
+		changelogDef := pc.columnFilter.TableDef(pc.routeTableDef(tableDef))
+		if err = pc.ensureChangelog(changelogDef.Table, pc.columns); err != nil {
+			return errors.Trace(err)
+		}
+		changelogDef.Table = incrementmode.ChangelogTable(changelogDef.Table)
+		changelogDDLs, err := GenDDLViaColumnsDiff(pc.columnFilter.Columns(pc.columns), changelogDef, pc.columnTypes, !pc.skipComments)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		ddls = append(ddls, changelogDDLs...)
+	}
+	if len(ddls) == 0 {
+		log.Info("No need to execute this DDL in PostgreSQL", zap.String("ddl", tableDef.Query))
+		return nil
+	}
+	// One DDL may be rewritten to multiple DDLs, they are executed atomically as DDLs are transactional in PostgreSQL
+	err = pc.inTx(func(tx *sql.Tx) error {
+		for _, ddl := range ddls {
+			if _, err := tx.Exec(ddl); err != nil {
+				return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
+		return errors.Trace(err)
+	}
+	// update columns
+	pc.columns = tableDef.Columns
+	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// SetColumnTypes overrides the types of the columns of the table in PostgreSQL, nil means the default mapping
+func (pc *PostgresConnector) SetColumnTypes(columnTypes columnmapping.Columns) {
+	pc.columnTypes = columnTypes
+}
+
+// SetColumnFilter filters the columns of the table replicated to PostgreSQL, nil means all the columns
+func (pc *PostgresConnector) SetColumnFilter(columnFilter *columnfilter.Filter) {
+	pc.columnFilter = columnFilter
+}
+
+// SetWhere filters the rows of the table replicated to PostgreSQL by the predicate, empty means all the rows
+func (pc *PostgresConnector) SetWhere(where string) {
+	pc.where = where
+}
+
+// SetTargetTable replicates the table to another table in PostgreSQL, empty means the table of the source table name.
+// The table keeps its name when the source table is renamed.
+func (pc *PostgresConnector) SetTargetTable(table string) {
+	pc.routedTable = table
+}
+
+// SetDedupKey creates the table in PostgreSQL with the columns as its primary key if the source table has none, the
+// increment files of such a table are merged by them
+func (pc *PostgresConnector) SetDedupKey(columns []string) {
+	pc.dedupKey = columns
+}
+
+// SetAuditScope labels the statements of the connector in the SQL audit log, if it is enabled
+func (pc *PostgresConnector) SetAuditScope(batchID string, schemaVersion uint64) {
+	sqlaudit.SetScope(pc.db, batchID, schemaVersion)
+}
+
+// targetTableName returns the table in PostgreSQL the source table is replicated to
+func (pc *PostgresConnector) targetTableName(sourceTable string) string {
+	if pc.routedTable != "" {
+		return pc.routedTable
+	}
+	return sourceTable
+}
+
+// routeTableDef returns the table definition of the table in PostgreSQL
+func (pc *PostgresConnector) routeTableDef(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	tableDef.Table = pc.targetTableName(tableDef.Table)
+	return tableDef
+}
+
+func (pc *PostgresConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
+	err := DropTable(pc.targetTableName(sourceTable), pc.db)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	columns, err := CreateTable(sourceDatabase, sourceTable, pc.targetTableName(sourceTable), sourceTiDBConn, pc.db, pc.columnTypes, pc.columnFilter, pc.dedupKey, !pc.skipComments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the columns tell the binary columns of the snapshot files
+	pc.columns = columns
+	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
+	return nil
+}
+
+// EnableDryRun skips reading the files, for a connector whose db records the statements instead of executing them
+func (pc *PostgresConnector) EnableDryRun() {
+	pc.dryRun = true
+}
+
+// SetSyncComments sets whether the comments of the tables and the columns in TiDB are replicated into PostgreSQL,
+// they are replicated by default
+func (pc *PostgresConnector) SetSyncComments(sync bool) {
+	pc.skipComments = !sync
+}
+
+// SetIncrementMode sets how the increment files are applied, append appends the changes to the changelog table of
+// the table instead of merging them
+func (pc *PostgresConnector) SetIncrementMode(incrementMode incrementmode.Mode) {
+	pc.incrementMode = incrementMode
+}
+
+// ensureChangelog creates the changelog table of the table by the columns if it does not exist
+func (pc *PostgresConnector) ensureChangelog(table string, columns []cloudstorage.TableCol) error {
+	changelogTable := incrementmode.ChangelogTable(table)
+	if pc.changelogTable == changelogTable {
+		return nil
+	}
+	createSQL, err := GenCreateChangelogSQL(table, pc.columnFilter.Columns(columns), pc.columnTypes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = pc.db.Exec(createSQL); err != nil {
+		return diag.WrapSQL(err, createSQL)
+	}
+	pc.changelogTable = changelogTable
+	return nil
+}
+
+// SetStagingArea downloads the files into the area before copying them instead of streaming them from the storage,
+// the area is shared by the connectors so that the files downloaded are bounded across the tables
+func (pc *PostgresConnector) SetStagingArea(area *staging.Area) {
+	pc.staging = area
+}
+
+// SetRateLimiters limits the files read from the storage by the limiters of the pipeline, it must be called before
+// the first load
+func (pc *PostgresConnector) SetRateLimiters(limiters *ratelimit.Limiters) {
+	pc.rateLimiters = limiters
+}
+
+// LoadSnapshot copies the files one by one, each file is committed and reported loaded on its own
+func (pc *PostgresConnector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	if len(pc.columns) == 0 {
+		return errors.New("Columns not initialized, the table schema must be copied or initialized before loading the snapshot")
+	}
+	targetTable = pc.targetTableName(targetTable)
+	// the snapshot files have the columns replicated only
+	columns := pc.columnFilter.Columns(pc.columns)
+	copySQL := GenCopySQL(targetTable, columns)
+	var loadedRows int64
+	for _, file := range files {
+		var rows int64
+		err := pc.inTx(func(tx *sql.Tx) error {
+			var err error
+			// dumpling writes the binary values as they are
+			rows, err = pc.copyFile(tx, copySQL, file, columns, nil, false)
+			return errors.Trace(err)
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		loadedRows += rows
+		if onSnapshotLoadProgress != nil {
+			onSnapshotLoadProgress(loadedRows)
+		}
+		if err = onFilesLoaded([]string{file}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.Int("files", len(files)), zap.Int64("rows", loadedRows))
+	return nil
+}
+
+// LoadIncrement copies the file into a temporary table, then deletes the rows whose last change is a delete
+// and upserts the others, all in one transaction
+func (pc *PostgresConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+	tableDef = pc.routeTableDef(tableDef)
+	incrementTable := fmt.Sprintf("increment_%s", tableDef.Table)
+	fileColumns := utils.GenIncrementTableColumns(tableDef.Columns)
+	// the fields of the columns filtered out are dropped while copying, they never reach PostgreSQL
+	mergedTableDef := pc.columnFilter.TableDef(tableDef)
+	columns := utils.GenIncrementTableColumns(mergedTableDef.Columns)
+	createSQL, err := GenCreateIncrementTableSQL(incrementTable, columns, pc.columnTypes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if pc.appliedBatch != nil {
+		if err = pc.ensureAppliedBatchTable(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if pc.incrementMode == incrementmode.Append {
+		return pc.appendIncrement(mergedTableDef, incrementTable, createSQL, filePath, fileColumns, columns)
+	}
+	deleteSQL, err := GenDeleteSQL(mergedTableDef, incrementTable, pc.where)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	upsertSQL, err := GenUpsertSQL(mergedTableDef, incrementTable, pc.where)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var rows int64
+	err = pc.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createSQL); err != nil {
+			return diag.WrapSQL(err, createSQL)
+		}
+		// TiCDC encodes the binary values by base64
+		if _, err := pc.copyFile(tx, GenCopySQL(incrementTable, columns), filePath, fileColumns, copiedFields(fileColumns, columns), true); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("delete increment table from table", zap.String("query", deleteSQL))
+		if _, err := tx.Exec(deleteSQL); err != nil {
+			return diag.WrapSQL(err, deleteSQL)
+		}
+		log.Info("upsert increment table into table", zap.String("query", upsertSQL))
+		res, err := tx.Exec(upsertSQL)
+		if err != nil {
+			return diag.WrapSQL(err, upsertSQL)
+		}
+		rows = utils.RowsAffected(res)
+		return errors.Trace(pc.recordAppliedBatch(tx, tableDef.Table, filePath))
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pc.mergedRows += rows
+	log.Info("Successfully merge file", zap.String("file", filePath))
+	return nil
+}
+
+// appendIncrement copies the file into the temporary table, then appends its changes to the changelog table in one
+// transaction
+func (pc *PostgresConnector) appendIncrement(tableDef cloudstorage.TableDefinition, incrementTable, createSQL, filePath string, fileColumns, columns []cloudstorage.TableCol) error {
+	if err := pc.ensureChangelog(tableDef.Table, tableDef.Columns); err != nil {
+		return errors.Trace(err)
+	}
+	appendSQL := GenAppendSQL(tableDef, incrementTable, pc.where)
+	var rows int64
+	err := pc.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createSQL); err != nil {
+			return diag.WrapSQL(err, createSQL)
+		}
+		if _, err := pc.copyFile(tx, GenCopySQL(incrementTable, columns), filePath, fileColumns, copiedFields(fileColumns, columns), true); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("append increment table into changelog table", zap.String("query", appendSQL))
+		res, err := tx.Exec(appendSQL)
+		if err != nil {
+			return diag.WrapSQL(err, appendSQL)
+		}
+		rows = utils.RowsAffected(res)
+		return errors.Trace(pc.recordAppliedBatch(tx, tableDef.Table, filePath))
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pc.mergedRows += rows
+	log.Info("Successfully append file", zap.String("file", filePath))
+	return nil
+}
+
+// AggregateTable returns the row count and the sums of the columns of the table, for the validation of the snapshot
+func (pc *PostgresConnector) AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error) {
+	query := validation.GenAggregateQuery(QuoteIdent(pc.targetTableName(targetTable)), sumColumns, "NUMERIC", QuoteIdent)
+	aggregates, err := validation.QueryAggregates(context.Background(), pc.db, query, sumColumns)
+	return aggregates, errors.Trace(err)
+}
+
+// DiffSchema compares the table in PostgreSQL with the columns replicated to it, nil if the columns are not
+// initialized yet
+func (pc *PostgresConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	if len(pc.columns) == 0 {
+		return nil, nil
+	}
+	actual, err := GetWarehouseColumns(pc.db, pc.targetTableName(targetTable))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	drift, err := tidbsql.GetSchemaDrift(pc.columnFilter.Columns(pc.columns), actual, func(column cloudstorage.TableCol) (string, error) {
+		return getPostgresColumnType(column, pc.columnTypes)
+	})
+	return drift, errors.Trace(err)
+}
+
+// ReconcileSchema alters the table in PostgreSQL back to the columns replicated to it, the DDLs are executed atomically
+func (pc *PostgresConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	targetTable = pc.targetTableName(targetTable)
+	ddls, err := GenDDLViaColumnsDiff(drift.Columns, cloudstorage.TableDefinition{Table: targetTable, Columns: drift.Expected}, pc.columnTypes, !pc.skipComments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = pc.inTx(func(tx *sql.Tx) error {
+		for _, ddl := range ddls {
+			if _, err := tx.Exec(ddl); err != nil {
+				return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully reconciled table", zap.String("table", targetTable), zap.String("ddls", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// IsRetryable retries the statements failed by a lost connection, a serialization failure or deadlock, a lack of
+// resources or a restarting server
+func (pc *PostgresConnector) IsRetryable(err error) bool {
+	return IsRetryableError(err)
+}
+
+// DDLActionClasses returns the classes of the DDLs in PostgreSQL
+func (pc *PostgresConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	return ddlActionClasses
+}
+
+func (pc *PostgresConnector) MergedRows() int64 {
+	return pc.mergedRows
+}
+
+// copiedFields returns the indexes of the fields of the columns copied in the columns of the file, both are
+// in the order of the table
+func copiedFields(fileColumns, columns []cloudstorage.TableCol) []int {
+	indexes := make([]int, 0, len(columns))
+	for i, j := 0, 0; i < len(fileColumns) && j < len(columns); i++ {
+		if fileColumns[i].Name == columns[j].Name {
+			indexes = append(indexes, i)
+			j++
+		}
+	}
+	return indexes
+}
+
+// copyFile streams the rows of the CSV file into the COPY statement and returns the rows copied.
+// columns are the columns of the file, of which only the fields at copied are copied, nil means all of them.
+// base64Binary is true if the binary values are encoded by base64.
+func (pc *PostgresConnector) copyFile(tx *sql.Tx, copySQL, filePath string, columns []cloudstorage.TableCol, copied []int, base64Binary bool) (int64, error) {
+	stmt, err := tx.Prepare(copySQL)
+	if err != nil {
+		return 0, diag.WrapSQL(err, copySQL)
+	}
+	defer stmt.Close()
+
+	var rows int64
+	if !pc.dryRun {
+		if rows, err = pc.streamFile(stmt, filePath, columns, copied, base64Binary); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	// flush the rows
+	if _, err = stmt.Exec(); err != nil {
+		return 0, diag.WrapSQL(err, copySQL)
+	}
+	return rows, nil
+}
+
+func (pc *PostgresConnector) streamFile(stmt *sql.Stmt, filePath string, columns []cloudstorage.TableCol, copied []int, base64Binary bool) (int64, error) {
+	ctx := context.Background()
+	if pc.extStorage == nil {
+		extStorage, err := utils.GetExternalStorageFromURI(ctx, pc.storageURI.String())
+		if err != nil {
+			return 0, diag.Storage(errors.Trace(err))
+		}
+		extStorage = pc.rateLimiters.Wrap(extStorage)
+		pc.rawStorage = extStorage
+		pc.extStorage = storage.WithCompression(extStorage, pc.compression.CompressType())
+	}
+	reader, err := pc.openFile(ctx, filePath)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer reader.Close()
+
+	csvReader := utils.NewCSVReader(reader)
+	var rows int64
+	for {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return 0, diag.Storage(errors.Annotatef(err, "Failed to read %s", filePath))
+		}
+		values, err := decodeRow(fields, columns, pc.columnTypes, base64Binary)
+		if err != nil {
+			return 0, errors.Annotatef(err, "Invalid row %d of %s", rows+1, filePath)
+		}
+		if copied != nil && len(copied) < len(values) {
+			for i, index := range copied {
+				values[i] = values[index]
+			}
+			values = values[:len(copied)]
+		}
+		if _, err = stmt.Exec(values...); err != nil {
+			return 0, errors.Annotatef(err, "Failed to copy row %d of %s", rows+1, filePath)
+		}
+		rows++
+	}
+}
+
+// openFile opens the file of the storage, or the copy downloaded into the staging area which is removed once the
+// reader is closed
+func (pc *PostgresConnector) openFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	if pc.staging == nil {
+		reader, err := pc.extStorage.Open(ctx, filePath)
+		if err != nil {
+			return nil, diag.Storage(errors.Annotatef(err, "Failed to open %s", filePath))
+		}
+		return reader, nil
+	}
+	file, err := pc.staging.Download(ctx, pc.rawStorage, filePath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reader, err := file.Open(ctx, pc.compression.CompressType())
+	if err != nil {
+		file.Release()
+		return nil, diag.Storage(errors.Annotatef(err, "Failed to open the staging file of %s", filePath))
+	}
+	return &stagedReader{ReadCloser: reader, file: file}, nil
+}
+
+// stagedReader releases the staging file when it is closed
+type stagedReader struct {
+	io.ReadCloser
+	file *staging.File
+}
+
+func (r *stagedReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.file.Release()
+	return err
+}
+
+// decodeRow returns the values of the fields passed to COPY, the binary values are passed as bytes. The BIT values
+// longer than 1 are decoded to bytes unless the columns are overridden by columnTypes, BIT(1) is passed as 0 or 1.
+func decodeRow(fields []utils.CSVField, columns []cloudstorage.TableCol, columnTypes columnmapping.Columns, base64Binary bool) ([]any, error) {
+	if len(fields) != len(columns) {
+		return nil, errors.Errorf("%d fields in the row, expected %d columns", len(fields), len(columns))
+	}
+	values := make([]any, 0, len(fields))
+	for i, field := range fields {
+		switch {
+		case field.Null:
+			values = append(values, nil)
+		case tidbsql.BitLength(columns[i]) > 1 && !overridden(columnTypes, columns[i]):
+			decoded, err := decodeBit(string(field.Value), tidbsql.BitLength(columns[i]), !base64Binary)
+			if err != nil {
+				return nil, errors.Annotatef(err, "Failed to decode bit column %s", columns[i].Name)
+			}
+			values = append(values, decoded)
+		case isBinaryType(columns[i]) && base64Binary:
+			decoded, err := base64.StdEncoding.DecodeString(string(field.Value))
+			if err != nil {
+				return nil, errors.Annotatef(err, "Failed to decode binary column %s", columns[i].Name)
+			}
+			values = append(values, decoded)
+		case isBinaryType(columns[i]):
+			values = append(values, field.Value)
+		default:
+			values = append(values, string(field.Value))
+		}
+	}
+	return values, nil
+}
+
+// overridden returns whether the type of the column is given by columnTypes
+func overridden(columnTypes columnmapping.Columns, column cloudstorage.TableCol) bool {
+	_, ok := columnTypes.Lookup(column.Name)
+	return ok
+}
+
+func (pc *PostgresConnector) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := pc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return errors.Trace(tx.Commit())
+}
+
+// ExecStatements executes the statements of --post-snapshot-sql in order
+func (pc *PostgresConnector) ExecStatements(statements []string) error {
+	for _, statement := range statements {
+		log.Info("Executing statement", zap.String("query", statement))
+		if _, err := pc.db.Exec(statement); err != nil {
+			return diag.WrapSQL(err, statement)
+		}
+	}
+	return nil
+}
+
+func (pc *PostgresConnector) Close() {
+	pc.db.Close()
+}
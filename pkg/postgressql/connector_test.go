@@ -0,0 +1,109 @@
+package postgressql
+
+import (
+	"testing"
+
+	"context"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/staging"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+	"io"
+)
+
+func TestDecodeRow(t *testing.T) {
+	columns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "int"},
+		{Name: "data", Tp: "varbinary"},
+		{Name: "note", Tp: "text"},
+	}
+	fields := []utils.CSVField{{Value: []byte("1")}, {Value: []byte("aGk=")}, {Null: true}}
+
+	values, err := decodeRow(fields, columns, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []any{"1", []byte("hi"), nil}, values)
+
+	values, err = decodeRow(fields, columns, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, []any{"1", []byte("aGk="), nil}, values)
+
+	_, err = decodeRow(fields[:2], columns, nil, false)
+	require.ErrorContains(t, err, "2 fields in the row, expected 3 columns")
+
+	// BIT(1) is passed as 0 or 1, a longer BIT is decoded from the hex of the snapshot or the unsigned integer of TiCDC
+	columns = []cloudstorage.TableCol{{Name: "enabled", Tp: "BIT", Precision: "1"}, {Name: "mask", Tp: "BIT", Precision: "12"}}
+	values, err = decodeRow([]utils.CSVField{{Value: []byte("1")}, {Value: []byte("0ABC")}}, columns, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, []any{"1", []byte{0x0a, 0xbc}}, values)
+	values, err = decodeRow([]utils.CSVField{{Value: []byte("0")}, {Value: []byte("2748")}}, columns, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []any{"0", []byte{0x0a, 0xbc}}, values)
+	_, err = decodeRow([]utils.CSVField{{Value: []byte("0")}, {Value: []byte("x")}}, columns, nil, true)
+	require.ErrorContains(t, err, "Failed to decode bit column mask")
+	// a column overridden is passed as it is
+	values, err = decodeRow([]utils.CSVField{{Value: []byte("0")}, {Value: []byte("2748")}}, columns, columnmapping.Columns{"mask": "BIGINT"}, true)
+	require.NoError(t, err)
+	require.Equal(t, []any{"0", "2748"}, values)
+}
+
+func TestCopiedFields(t *testing.T) {
+	fileColumns := utils.GenIncrementTableColumns([]cloudstorage.TableCol{{Name: "id"}, {Name: "email"}, {Name: "note"}})
+	columns := utils.GenIncrementTableColumns([]cloudstorage.TableCol{{Name: "id"}, {Name: "note"}})
+	require.Equal(t, []int{0, 1, 2, 3, 4, 6}, copiedFields(fileColumns, columns))
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6}, copiedFields(fileColumns, fileColumns))
+}
+
+func TestLongComment(t *testing.T) {
+	limit := maxCommentBytes
+	maxCommentBytes = 7
+	defer func() { maxCommentBytes = limit }()
+
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "t",
+		Type:    timodel.ActionModifyTableComment,
+		Query:   "ALTER TABLE t COMMENT = '订单备注'",
+		Columns: columns,
+	}
+	// the character cut by the limit is dropped
+	ddls, err := GenDDLViaColumnsDiff(columns, tableDef, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{`COMMENT ON TABLE "t" IS E'订单';`}, ddls)
+	require.Equal(t, `COMMENT ON COLUMN "t"."id" IS E'the id';`, genColumnComment("t", "id", "the id"))
+}
+
+func TestDDLActionClasses(t *testing.T) {
+	connector := &PostgresConnector{}
+	require.Equal(t, ddltest.DefaultHandlings(nil), ddltest.Handlings(connector.DDLActionClasses()))
+}
+
+func TestOpenCompressedFile(t *testing.T) {
+	ctx := context.Background()
+	rawStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	content := "I,t,db,1,1,alice\n"
+	require.NoError(t, storage.WithCompression(rawStorage, storage.Zstd).WriteFile(ctx, "t/CDC000001.csv.zst", []byte(content)))
+	area, err := staging.NewArea(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	// the file is decompressed whether it is streamed or downloaded into the staging area
+	for _, area := range []*staging.Area{nil, area} {
+		pc := &PostgresConnector{
+			compression: utils.CompressionZstd,
+			rawStorage:  rawStorage,
+			extStorage:  storage.WithCompression(rawStorage, storage.Zstd),
+			staging:     area,
+		}
+		reader, err := pc.openFile(ctx, "t/CDC000001.csv.zst")
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.NoError(t, reader.Close())
+		require.Equal(t, content, string(data))
+	}
+	require.Zero(t, area.Used())
+}
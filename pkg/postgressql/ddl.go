@@ -0,0 +1,150 @@
+package postgressql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// GenCreateTableDDLs generates the DDLs of a table created after the changefeed starts, its columns are given
+// by the schema file.
+// ddlActionClasses are the classes of the DDLs in PostgreSQL, which applies all the translatable DDLs
+var ddlActionClasses = tidbsql.DDLActionClasses
+
+func GenCreateTableDDLs(tableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns) ([]string, error) {
+	ddl, err := GenCreateTableSQL(tableDef.Table, tableDef.Columns, tidbsql.GetPKColumns(tableDef.Columns), columnTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", QuoteIdent(tableDef.Table)), ddl}, nil
+}
+
+// GenDDLViaColumnsDiff returns the DDLs altering the table from the previous columns to the table definition, the
+// comments set by the DDL are applied only with syncComments
+func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns, syncComments bool) ([]string, error) {
+	table := QuoteIdent(curTableDef.Table)
+	if curTableDef.Type == timodel.ActionTruncateTable {
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s", table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionDropTable {
+		return []string{fmt.Sprintf("DROP TABLE %s", table)}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateTable {
+		return GenCreateTableDDLs(curTableDef, columnTypes)
+	}
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		_, oldTable, err := tidbsql.GetRenamedFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", QuoteIdent(oldTable), table)}, nil
+	}
+	// postgres: Default RESTRICT
+	if curTableDef.Type == timodel.ActionDropSchema {
+		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", QuoteIdent(curTableDef.Schema))}, nil
+	}
+	if curTableDef.Type == timodel.ActionCreateSchema {
+		return nil, tidbsql.NewUnsupportedDDLError("Received create schema ddl %s, which is not supported", curTableDef.Query)
+	}
+
+	columnDiff, err := tidbsql.GetColumnDiff(prevColumns, curTableDef.Columns)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ddls := make([]string, 0, len(columnDiff))
+	for _, item := range columnDiff {
+		switch item.Action {
+		case tidbsql.ADD_COLUMN:
+			// PostgreSQL fills the existing rows with the default of the column added
+			colStr, err := GetPostgresColumnString(tidbsql.WithAddedColumnDefault(curTableDef.Table, *item.After), columnTypes)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", table, colStr))
+		case tidbsql.DROP_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", table, QuoteIdent(item.Before.Name)))
+		case tidbsql.MODIFY_COLUMN:
+			modifyDDLs, err := genModifyColumnDDLs(curTableDef.Table, *item.After, columnTypes)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddls = append(ddls, modifyDDLs...)
+		case tidbsql.RENAME_COLUMN:
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", table, QuoteIdent(item.Before.Name), QuoteIdent(item.After.Name)))
+		default:
+			// UNCHANGE
+		}
+	}
+
+	changes := &tidbsql.CommentChanges{}
+	if syncComments {
+		changes = tidbsql.GetCommentChanges(curTableDef)
+	}
+	if changes.Table != nil {
+		ddls = append(ddls, genTableComment(curTableDef.Table, *changes.Table))
+	}
+	for _, column := range curTableDef.Columns {
+		if comment, ok := changes.Columns[column.Name]; ok {
+			ddls = append(ddls, genColumnComment(curTableDef.Table, column.Name, comment))
+		}
+	}
+
+	// the primary key is not changed here, the DDLs adding or dropping it pause the table, see tidbsql.DDLActionClasses
+	return ddls, nil
+}
+
+// genModifyColumnDDLs changes the type, the nullability and the default of the column in place,
+// the existing values are converted by the cast of PostgreSQL
+func genModifyColumnDDLs(tableName string, column cloudstorage.TableCol, columnTypes columnmapping.Columns) ([]string, error) {
+	typeStr, err := GetPostgresTypeString(column, columnTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	table, name := QuoteIdent(tableName), QuoteIdent(column.Name)
+	typeStr = strings.TrimPrefix(typeStr, name+" ")
+	ddls := []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s;", table, name, typeStr, name, typeStr)}
+	if column.Nullable == "false" {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", table, name))
+	} else {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", table, name))
+	}
+	if column.Default != nil {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", table, name, getDefaultString(column)))
+	} else {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;", table, name))
+	}
+	return ddls, nil
+}
+
+// getDefaultString returns the literal of the default value of the column
+func getDefaultString(column cloudstorage.TableCol) string {
+	return tidbsql.DefaultLiteral(column, quoteLiteral)
+}
+
+// GetPostgresColumnString returns a string describing the column in PostgreSQL, e.g.
+// `"id" INTEGER NOT NULL DEFAULT 0`
+// Refer to:
+// https://dev.mysql.com/doc/refman/8.0/en/data-types.html
+// https://www.postgresql.org/docs/current/datatype.html
+func GetPostgresColumnString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	var sb strings.Builder
+	typeStr, err := GetPostgresTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sb.WriteString(typeStr)
+	if column.Nullable == "false" {
+		sb.WriteString(" NOT NULL")
+	}
+	if column.Default != nil {
+		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column)))
+	} else if column.Nullable == "true" {
+		sb.WriteString(" DEFAULT NULL")
+	}
+	return sb.String(), nil
+}
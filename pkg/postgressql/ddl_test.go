@@ -0,0 +1,308 @@
+package postgressql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/typetest"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenDDLViaColumnsDiff(t *testing.T) {
+	prevColumns := []cloudstorage.TableCol{
+		{
+			ID:   "2",
+			Name: "name",
+			Tp:   "varchar",
+		},
+		{
+			ID:   "3",
+			Name: "age",
+			Tp:   "int",
+		},
+		{
+			ID:   "4",
+			Name: "birth",
+			Tp:   "date",
+		},
+		{
+			ID:       "5",
+			Name:     "score",
+			Tp:       "int",
+			Nullable: "true",
+		},
+	}
+	curTableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Columns: []cloudstorage.TableCol{
+			{
+				ID:   "2",
+				Name: "color",
+				Tp:   "varchar",
+			},
+			{
+				ID:   "4",
+				Name: "birth",
+				Tp:   "date",
+			},
+			{
+				ID:       "5",
+				Name:     "score",
+				Tp:       "bigint",
+				Nullable: "false",
+				Default:  "0",
+			},
+			{
+				ID:        "6",
+				Name:      "gender",
+				Tp:        "varchar",
+				Precision: "10",
+			},
+		},
+	}
+
+	expectedDDLs := []string{
+		`ALTER TABLE "test_table" RENAME COLUMN "name" TO "color";`,
+		`ALTER TABLE "test_table" DROP COLUMN "age";`,
+		`ALTER TABLE "test_table" ALTER COLUMN "score" TYPE BIGINT USING "score"::BIGINT;`,
+		`ALTER TABLE "test_table" ALTER COLUMN "score" SET NOT NULL;`,
+		`ALTER TABLE "test_table" ALTER COLUMN "score" SET DEFAULT 0;`,
+		`ALTER TABLE "test_table" ADD COLUMN "gender" VARCHAR(10);`,
+	}
+
+	ddl, err := postgressql.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expectedDDLs, ddl)
+}
+
+func TestGenDDLViaColumnsDiffSyncComments(t *testing.T) {
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "test_table",
+		Type:    timodel.ActionModifyTableComment,
+		Query:   "ALTER TABLE test_table COMMENT = 'orders'",
+		Columns: columns,
+	}
+	ddls, err := postgressql.GenDDLViaColumnsDiff(columns, tableDef, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{`COMMENT ON TABLE "test_table" IS E'orders';`}, ddls)
+	ddls, err = postgressql.GenDDLViaColumnsDiff(columns, tableDef, nil, false)
+	require.NoError(t, err)
+	require.Empty(t, ddls)
+}
+
+func TestGenDDLViaColumnsDiffCreateSchema(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateSchema,
+		Query:  "CREATE DATABASE test_schema",
+	}
+	_, err := postgressql.GenDDLViaColumnsDiff(nil, tableDef, nil, true)
+	require.True(t, tidbsql.IsUnsupportedDDL(err))
+}
+
+func TestGetPostgresColumnString(t *testing.T) {
+	for _, tc := range []struct {
+		column   cloudstorage.TableCol
+		expected string
+	}{
+		{cloudstorage.TableCol{Name: "id", Tp: "BIGINT UNSIGNED", Nullable: "false"}, `"id" NUMERIC(20) NOT NULL`},
+		{cloudstorage.TableCol{Name: "data", Tp: "blob", Nullable: "true"}, `"data" BYTEA DEFAULT NULL`},
+		{cloudstorage.TableCol{Name: "price", Tp: "decimal", Precision: "10", Scale: "2", Default: "1.5"}, `"price" NUMERIC(10, 2) DEFAULT 1.5`},
+		{cloudstorage.TableCol{Name: "ratio", Tp: "double"}, `"ratio" DOUBLE PRECISION`},
+		// a string default is escaped
+		{cloudstorage.TableCol{Name: "note", Tp: "varchar", Precision: "20", Default: `it's a\note`}, `"note" VARCHAR(20) DEFAULT E'it\'s a\\note'`},
+		// a string default looking like a number is quoted by the type of the column
+		{cloudstorage.TableCol{Name: "code", Tp: "varchar", Precision: "3", Default: "007"}, `"code" VARCHAR(3) DEFAULT E'007'`},
+	} {
+		actual, err := postgressql.GetPostgresColumnString(tc.column, nil)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, actual)
+	}
+	_, err := postgressql.GetPostgresColumnString(cloudstorage.TableCol{Name: "g", Tp: "geometry"}, nil)
+	require.ErrorContains(t, err, "Unsupported data type: geometry")
+}
+
+func TestGetPostgresTypeStringAllTypes(t *testing.T) {
+	expected := map[string]string{
+		"c_tinyint":            `"c_tinyint" SMALLINT`,
+		"c_tinyint_unsigned":   `"c_tinyint_unsigned" SMALLINT`,
+		"c_smallint":           `"c_smallint" SMALLINT`,
+		"c_smallint_unsigned":  `"c_smallint_unsigned" INTEGER`,
+		"c_mediumint":          `"c_mediumint" INTEGER`,
+		"c_mediumint_unsigned": `"c_mediumint_unsigned" INTEGER`,
+		"c_int":                `"c_int" INTEGER`,
+		"c_int_unsigned":       `"c_int_unsigned" BIGINT`,
+		"c_bigint":             `"c_bigint" BIGINT`,
+		"c_bigint_unsigned":    `"c_bigint_unsigned" NUMERIC(20)`,
+		"c_float":              `"c_float" REAL`,
+		"c_double":             `"c_double" DOUBLE PRECISION`,
+		"c_decimal":            `"c_decimal" NUMERIC(20, 6)`,
+		"c_decimal_wide":       `"c_decimal_wide" NUMERIC(40, 10)`,
+		"c_bit":                `"c_bit" BOOLEAN`,
+		"c_bit_long":           `"c_bit_long" BYTEA`,
+		"c_year":               `"c_year" SMALLINT`,
+		"c_date":               `"c_date" DATE`,
+		"c_datetime":           `"c_datetime" TIMESTAMP`,
+		"c_timestamp":          `"c_timestamp" TIMESTAMP`,
+		"c_time":               `"c_time" TIME`,
+		"c_char":               `"c_char" CHAR(10)`,
+		"c_varchar":            `"c_varchar" VARCHAR(255)`,
+		"c_binary":             `"c_binary" BYTEA`,
+		"c_varbinary":          `"c_varbinary" BYTEA`,
+		"c_tinytext":           `"c_tinytext" TEXT`,
+		"c_text":               `"c_text" TEXT`,
+		"c_mediumtext":         `"c_mediumtext" TEXT`,
+		"c_longtext":           `"c_longtext" TEXT`,
+		"c_tinyblob":           `"c_tinyblob" BYTEA`,
+		"c_blob":               `"c_blob" BYTEA`,
+		"c_mediumblob":         `"c_mediumblob" BYTEA`,
+		"c_longblob":           `"c_longblob" BYTEA`,
+		"c_enum":               `"c_enum" TEXT`,
+		"c_set":                `"c_set" TEXT`,
+		"c_json":               `"c_json" JSONB`,
+	}
+	// NUMERIC keeps up to 1000 digits, more than any DECIMAL of TiDB
+	for _, column := range typetest.Columns() {
+		actual, err := postgressql.GetPostgresTypeString(column, nil)
+		require.NoError(t, err, column.Name)
+		require.Equal(t, expected[column.Name], actual, column.Name)
+	}
+}
+
+func TestGenUpsertSQL(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table: "t",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "v", Tp: "varchar", Precision: "10"},
+		},
+	}
+	sql, err := postgressql.GenUpsertSQL(tableDef, "increment_t", "")
+	require.NoError(t, err)
+	require.Contains(t, sql, `INSERT INTO "t" ("id", "v")`)
+	require.Contains(t, sql, `SELECT DISTINCT ON ("id")`)
+	require.Contains(t, sql, `FROM "increment_t"`)
+	require.Contains(t, sql, `ORDER BY "id", "tidb2dw_commit_ts" DESC, "tidb2dw_row" DESC`)
+	require.Contains(t, sql, `S."tidb2dw_flag" != 'D'`)
+	require.Contains(t, sql, "ON CONFLICT (\"id\") DO UPDATE SET\n\t\t\"v\" = EXCLUDED.\"v\";")
+
+	sql, err = postgressql.GenDeleteSQL(tableDef, "increment_t", "")
+	require.NoError(t, err)
+	require.Contains(t, sql, `DELETE FROM "t" USING (`)
+	require.Contains(t, sql, `S."tidb2dw_flag" = 'D' AND "t"."id" = S."id";`)
+
+	// the rows whose last change does not match the predicate are deleted rather than upserted
+	sql, err = postgressql.GenUpsertSQL(tableDef, "increment_t", "v <> 'x'")
+	require.NoError(t, err)
+	require.Contains(t, sql, `COALESCE((v <> 'x'), FALSE) AS "tidb2dw_matched"`)
+	require.Contains(t, sql, `S."tidb2dw_flag" != 'D' AND S."tidb2dw_matched"`)
+	sql, err = postgressql.GenDeleteSQL(tableDef, "increment_t", "v <> 'x'")
+	require.NoError(t, err)
+	require.Contains(t, sql, `COALESCE((v <> 'x'), FALSE) AS "tidb2dw_matched"`)
+	require.Contains(t, sql, `(S."tidb2dw_flag" = 'D' OR NOT S."tidb2dw_matched") AND "t"."id" = S."id";`)
+
+	tableDef.Columns[0].IsPK = "false"
+	_, err = postgressql.GenUpsertSQL(tableDef, "increment_t", "")
+	require.ErrorContains(t, err, "Table t has no primary key")
+}
+
+func TestGenAppendSQL(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table: "t",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "v", Tp: "varchar", Precision: "10"},
+		},
+	}
+	require.Equal(t, "INSERT INTO \"t_changelog\" (\"tidb2dw_flag\", \"tidb2dw_commit_ts\", \"id\", \"v\")\n\tSELECT \"tidb2dw_flag\", \"tidb2dw_commit_ts\", \"id\", \"v\"\n\tFROM \"increment_t\"\n\tORDER BY \"tidb2dw_row\"",
+		postgressql.GenAppendSQL(tableDef, "increment_t", ""))
+	require.Contains(t, postgressql.GenAppendSQL(tableDef, "increment_t", "v <> 'x'"), "FROM \"increment_t\"\n\tWHERE COALESCE((v <> 'x'), FALSE)\n\tORDER BY \"tidb2dw_row\"")
+
+	// the changelog table has no primary key and is kept if it exists
+	sql, err := postgressql.GenCreateChangelogSQL(tableDef.Table, tableDef.Columns, nil)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE IF NOT EXISTS \"t_changelog\" (\n    \"tidb2dw_flag\" VARCHAR(1),\n    \"tidb2dw_commit_ts\" BIGINT,\n    \"id\" INTEGER,\n    \"v\" VARCHAR(10)\n)", sql)
+}
+
+func TestGenDDLViaColumnsDiffQuoteIdent(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "Order",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE `Order` (`select` INT PRIMARY KEY, `名称` VARCHAR(20), `a\"b` INT, `UserID` INT)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "select", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "名称", Tp: "varchar", Precision: "20"},
+			{ID: "3", Name: `a"b`, Tp: "int"},
+			{ID: "4", Name: "UserID", Tp: "int"},
+		},
+	}
+	// the names are kept in their case instead of being folded to lower case
+	ddls, err := postgressql.GenDDLViaColumnsDiff(nil, tableDef, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{`DROP TABLE IF EXISTS "Order"`, `CREATE TABLE "Order" (
+    "select" INTEGER NOT NULL,
+    "名称" VARCHAR(20),
+    "a""b" INTEGER,
+    "UserID" INTEGER,
+    PRIMARY KEY ("select")
+)`}, ddls)
+
+	altered := tableDef
+	altered.Type = timodel.ActionModifyColumn
+	altered.Columns = []cloudstorage.TableCol{
+		{ID: "1", Name: "select", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "名称", Tp: "varchar", Precision: "20"},
+		{ID: "3", Name: `a"b`, Tp: "bigint"},
+		{ID: "5", Name: "from", Tp: "int"},
+	}
+	ddls, err = postgressql.GenDDLViaColumnsDiff(tableDef.Columns, altered, nil, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		`ALTER TABLE "Order" ALTER COLUMN "a""b" TYPE BIGINT USING "a""b"::BIGINT;`,
+		`ALTER TABLE "Order" ALTER COLUMN "a""b" DROP NOT NULL;`,
+		`ALTER TABLE "Order" ALTER COLUMN "a""b" DROP DEFAULT;`,
+		`ALTER TABLE "Order" DROP COLUMN "UserID";`,
+		`ALTER TABLE "Order" ADD COLUMN "from" INTEGER;`,
+	}, ddls)
+
+	renamed := tableDef
+	renamed.Type = timodel.ActionRenameTable
+	renamed.Table = "Orders"
+	renamed.Query = "RENAME TABLE `Order` TO `Orders`"
+	ddls, err = postgressql.GenDDLViaColumnsDiff(tableDef.Columns, renamed, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "Order" RENAME TO "Orders"`}, ddls)
+}
+
+func TestGenSQLQuoteIdent(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table: "Order",
+		Columns: []cloudstorage.TableCol{
+			{Name: "select", Tp: "int", IsPK: "true"},
+			{Name: "名称", Tp: "varchar", Precision: "10"},
+			{Name: `a"b`, Tp: "int"},
+		},
+	}
+	require.Equal(t, `COPY "Order" ("select", "名称", "a""b") FROM STDIN`, postgressql.GenCopySQL(tableDef.Table, tableDef.Columns))
+	sql, err := postgressql.GenUpsertSQL(tableDef, "increment_Order", "")
+	require.NoError(t, err)
+	require.Contains(t, sql, `INSERT INTO "Order" ("select", "名称", "a""b")`)
+	require.Contains(t, sql, `FROM "increment_Order"`)
+	require.Contains(t, sql, `ON CONFLICT ("select") DO UPDATE SET`)
+	require.Contains(t, sql, `"名称" = EXCLUDED."名称"`)
+	require.Contains(t, sql, `"a""b" = EXCLUDED."a""b"`)
+	sql, err = postgressql.GenDeleteSQL(tableDef, "increment_Order", "")
+	require.NoError(t, err)
+	require.Contains(t, sql, `DELETE FROM "Order" USING (`)
+	require.Contains(t, sql, `"Order"."select" = S."select";`)
+	sql, err = postgressql.GenCreateIncrementTableSQL("increment_Order", tableDef.Columns, nil)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TEMPORARY TABLE \"increment_Order\" (\n    \"select\" INTEGER,\n    \"名称\" VARCHAR(10),\n    \"a\"\"b\" INTEGER,\n    \"tidb2dw_row\" BIGSERIAL\n) ON COMMIT DROP", sql)
+	require.Equal(t, `"my""schema"`, postgressql.QuoteIdent(`my"schema`))
+}
@@ -0,0 +1,106 @@
+package postgressql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// NormalizePostgresType returns the type by its canonical name in PostgreSQL, so that the synonyms are equal,
+// e.g. INTEGER for INT4 and VARCHAR(20) for CHARACTER VARYING(20)
+func NormalizePostgresType(tp string) string {
+	name, params := tidbsql.SplitType(tp)
+	if !slices.ContainsFunc(params, func(p string) bool { return p != "" }) {
+		// e.g. NUMERIC(, ) of a decimal column without precision
+		params = nil
+	}
+	switch name {
+	case "INT", "INTEGER", "INT4":
+		name = "INTEGER"
+	case "BIGINT", "INT8":
+		name = "BIGINT"
+	case "SMALLINT", "INT2":
+		name = "SMALLINT"
+	case "REAL", "FLOAT4":
+		name = "REAL"
+	case "DOUBLE PRECISION", "FLOAT8", "FLOAT":
+		name = "DOUBLE PRECISION"
+	case "NUMERIC", "DECIMAL":
+		name = "NUMERIC"
+		if len(params) == 1 {
+			params = append(params, "0")
+		}
+	case "VARCHAR", "CHARACTER VARYING":
+		name = "VARCHAR"
+	case "CHAR", "CHARACTER", "BPCHAR":
+		name = "CHAR"
+	case "BOOL", "BOOLEAN":
+		name = "BOOLEAN"
+	case "TIMESTAMP", "TIMESTAMP WITHOUT TIME ZONE":
+		name = "TIMESTAMP"
+	case "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE":
+		name = "TIMESTAMPTZ"
+	case "TIME", "TIME WITHOUT TIME ZONE":
+		name = "TIME"
+	}
+	if len(params) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// getPostgresColumnType returns the normalized type of the column in PostgreSQL
+func getPostgresColumnType(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	typeStr, err := GetPostgresTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return NormalizePostgresType(strings.TrimPrefix(typeStr, QuoteIdent(column.Name)+" ")), nil
+}
+
+// GetWarehouseColumns returns the columns of the table in the current schema of PostgreSQL with their normalized types
+func GetWarehouseColumns(db *sql.DB, tableName string) ([]tidbsql.WarehouseColumn, error) {
+	query := fmt.Sprintf(`SELECT column_name, data_type, character_maximum_length, numeric_precision, numeric_scale, is_nullable
+FROM information_schema.columns
+WHERE table_schema = current_schema() AND table_name = %s
+ORDER BY ordinal_position`, quoteLiteral(tableName))
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var columns []tidbsql.WarehouseColumn
+	for rows.Next() {
+		var name, dataType, isNullable string
+		var charLength, numPrecision, numScale sql.NullInt64
+		if err := rows.Scan(&name, &dataType, &charLength, &numPrecision, &numScale, &isNullable); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tp := dataType
+		switch {
+		case charLength.Valid:
+			tp = fmt.Sprintf("%s(%d)", dataType, charLength.Int64)
+		case strings.EqualFold(dataType, "numeric") && numPrecision.Valid:
+			tp = fmt.Sprintf("%s(%d,%d)", dataType, numPrecision.Int64, numScale.Int64)
+		}
+		columns = append(columns, tidbsql.WarehouseColumn{
+			Name:     name,
+			Type:     NormalizePostgresType(tp),
+			Nullable: isNullable == "YES",
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(columns) == 0 {
+		return nil, errors.Annotatef(tidbsql.ErrWarehouseTableNotFound, "table %s is not found in PostgreSQL", tableName)
+	}
+	return columns, nil
+}
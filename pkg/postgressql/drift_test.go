@@ -0,0 +1,25 @@
+package postgressql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePostgresType(t *testing.T) {
+	for _, synonyms := range [][]string{
+		{"INTEGER", "int4", "INT"},
+		{"DOUBLE PRECISION", "float8", "FLOAT"},
+		{"NUMERIC(20,0)", "NUMERIC(20)", "decimal(20, 0)"},
+		{"NUMERIC", "NUMERIC(, )"},
+		{"VARCHAR(20)", "character varying(20)"},
+		{"CHAR(1)", "bpchar(1)", "character(1)"},
+		{"TIMESTAMP", "timestamp without time zone"},
+		{"BOOLEAN", "bool"},
+	} {
+		for _, tp := range synonyms {
+			require.Equal(t, synonyms[0], postgressql.NormalizePostgresType(tp), tp)
+		}
+	}
+}
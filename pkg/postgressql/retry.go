@@ -0,0 +1,33 @@
+package postgressql
+
+import (
+	stderrors "errors"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+)
+
+// retryableClasses are the classes of SQLSTATE of the errors which are transient: the connection exceptions, the
+// transaction rollbacks and the insufficient resources
+var retryableClasses = map[pq.ErrorClass]bool{
+	"08": true,
+	"40": true,
+	"53": true,
+}
+
+// retryableCodes are the other SQLSTATE codes which are transient, the server is shut down or restarting
+var retryableCodes = map[pq.ErrorCode]bool{
+	"57P01": true,
+	"57P02": true,
+	"57P03": true,
+}
+
+// IsRetryableError tells whether the statement which failed with err may succeed if it is run again, e.g. a
+// serialization failure or a deadlock. The statements of a file are in a transaction, which is rolled back by the failure.
+func IsRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if stderrors.As(err, &pqErr) {
+		return retryableClasses[pqErr.Code.Class()] || retryableCodes[pqErr.Code]
+	}
+	return retry.IsTransient(err)
+}
@@ -0,0 +1,17 @@
+package postgressql_test
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/postgressql"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	require.True(t, postgressql.IsRetryableError(errors.Trace(&pq.Error{Code: "40001"})))
+	require.True(t, postgressql.IsRetryableError(&pq.Error{Code: "40P01"}))
+	require.True(t, postgressql.IsRetryableError(&pq.Error{Code: "57P01"}))
+	require.False(t, postgressql.IsRetryableError(&pq.Error{Code: "23502"}))
+}
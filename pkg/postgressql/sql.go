@@ -0,0 +1,317 @@
+package postgressql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"gitlab.com/tymonx/go-formatter/formatter"
+	"go.uber.org/zap"
+)
+
+// incrementRowColumnName numbers the rows of the increment table in the order of the file, so that the
+// last change of a row is found among the changes committed by the same transaction
+const incrementRowColumnName = "tidb2dw_row"
+
+// QuoteIdent quotes the name of a schema, a table or a column by double quotes, so that reserved words, mixed case
+// and unicode can be used as names. PostgreSQL stores a quoted name as is.
+func QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteIdents quotes the names
+func quoteIdents(names []string) []string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, QuoteIdent(name))
+	}
+	return quoted
+}
+
+// EnsureSchema creates the schema if create and it does not exist, otherwise it fails if the schema does not exist
+func EnsureSchema(db *sql.DB, schemaName string, create bool) error {
+	if create {
+		sql := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", QuoteIdent(schemaName))
+		_, err := db.Exec(sql)
+		return errors.Annotate(diag.WrapSQL(err, sql), "Failed to create schema")
+	}
+	// the cast takes the quoted name as CREATE SCHEMA does, it fails if the schema does not exist
+	sql := "SELECT $1::regnamespace"
+	if _, err := db.Exec(sql, QuoteIdent(schemaName)); err != nil {
+		return errors.Annotatef(diag.WrapSQL(err, sql), "PostgreSQL schema %s is not found, create it or set --create-target-schema", schemaName)
+	}
+	return nil
+}
+
+func DropTable(tableName string, db *sql.DB) error {
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s", QuoteIdent(tableName))
+	log.Info("Dropping table in PostgreSQL if exists", zap.String("query", sql))
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// CreateTable creates targetTable by the columns of the TiDB table retained by columnFilter, and returns all the columns.
+// The primary key is the dedup key if the TiDB table has none, which the upserts of the increment files conflict on.
+// The comments of the TiDB table are set only with syncComments.
+func CreateTable(sourceDatabase, sourceTable, targetTable string, sourceTiDBConn, pgConn *sql.DB, columnTypes columnmapping.Columns, columnFilter *columnfilter.Filter, dedupKey []string, syncComments bool) ([]cloudstorage.TableCol, error) {
+	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pkColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(pkColumns) == 0 {
+		pkColumns = dedupKey
+	}
+	query, err := GenCreateTableSQL(targetTable, columnFilter.Columns(tableColumns), pkColumns, columnTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("Creating table in PostgreSQL", zap.String("query", query))
+	if _, err = pgConn.Exec(query); err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+
+	comments := &tidbsql.TableComments{}
+	if syncComments {
+		if comments, err = tidbsql.GetTiDBTableComments(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	commentQueries := make([]string, 0, len(comments.Columns)+1)
+	if comments.Table != "" {
+		commentQueries = append(commentQueries, genTableComment(targetTable, comments.Table))
+	}
+	for _, column := range columnFilter.Columns(tableColumns) {
+		if comment, ok := comments.Columns[column.Name]; ok {
+			commentQueries = append(commentQueries, genColumnComment(targetTable, column.Name, comment))
+		}
+	}
+	for _, query := range commentQueries {
+		if _, err = pgConn.Exec(query); err != nil {
+			return nil, errors.Annotate(diag.WrapSQL(err, query), "Failed to set comment")
+		}
+	}
+	return tableColumns, nil
+}
+
+// GenCreateTableSQL generates the CREATE TABLE statement with the primary key of the TiDB table, which
+// is enforced by PostgreSQL and required to merge the increment files
+func GenCreateTableSQL(tableName string, tableColumns []cloudstorage.TableCol, pkColumns []string, columnTypes columnmapping.Columns) (string, error) {
+	columnRows := make([]string, 0, len(tableColumns))
+	for _, column := range tableColumns {
+		row, err := GetPostgresColumnString(column, columnTypes)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+
+	sqlRows := make([]string, 0, len(columnRows)+1)
+	sqlRows = append(sqlRows, columnRows...)
+	if len(pkColumns) > 0 {
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quoteIdents(pkColumns), ", ")))
+	}
+	// Add idents
+	for i := 0; i < len(sqlRows); i++ {
+		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
+	}
+
+	sql := []string{}
+	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, QuoteIdent(tableName)))
+	sql = append(sql, strings.Join(sqlRows, ",\n"))
+	sql = append(sql, ")")
+	return strings.Join(sql, "\n"), nil
+}
+
+// quoteLiteral returns the escape string constant of the string, PostgreSQL takes the backslash escapes of
+// utils.QuoteLiteral only in the constants prefixed by E
+func quoteLiteral(s string) string {
+	return "E" + utils.QuoteLiteral(s)
+}
+
+// maxCommentBytes is the limit of bytes of a comment, the largest value of a field
+var maxCommentBytes = 1<<30 - 1
+
+func genTableComment(tableName, comment string) string {
+	comment = tidbsql.TruncateCommentBytes(comment, maxCommentBytes, tableName)
+	return fmt.Sprintf("COMMENT ON TABLE %s IS %s;", QuoteIdent(tableName), quoteLiteral(comment))
+}
+
+func genColumnComment(tableName, columnName, comment string) string {
+	comment = tidbsql.TruncateCommentBytes(comment, maxCommentBytes, columnName)
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s;", QuoteIdent(tableName), QuoteIdent(columnName), quoteLiteral(comment))
+}
+
+// GenCopySQL generates the COPY statement streaming the rows of the columns into the table
+func GenCopySQL(tableName string, columns []cloudstorage.TableCol) string {
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, QuoteIdent(column.Name))
+	}
+	return fmt.Sprintf("COPY %s (%s) FROM STDIN", QuoteIdent(tableName), strings.Join(names, ", "))
+}
+
+// GenCreateIncrementTableSQL generates the temporary table the increment file is copied into, it is dropped
+// when the transaction merging the file commits. Its columns are those of the file, see utils.GenIncrementTableColumns.
+func GenCreateIncrementTableSQL(incrementTable string, columns []cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	columnRows := make([]string, 0, len(columns)+1)
+	for _, column := range columns {
+		row, err := GetPostgresTypeString(column, columnTypes)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+	columnRows = append(columnRows, fmt.Sprintf("%s BIGSERIAL", QuoteIdent(incrementRowColumnName)))
+	for i := 0; i < len(columnRows); i++ {
+		columnRows[i] = fmt.Sprintf("    %s", columnRows[i])
+	}
+	return fmt.Sprintf("CREATE TEMPORARY TABLE %s (\n%s\n) ON COMMIT DROP", QuoteIdent(incrementTable), strings.Join(columnRows, ",\n")), nil
+}
+
+// lastChangesQuery selects the last change of each row in the increment table, the fields selected and the primary
+// key columns are quoted by the caller
+func lastChangesQuery(incrementTable string, selectStat, pkColumns []string) (string, error) {
+	return formatter.Format(`
+		SELECT DISTINCT ON ({pkStat})
+		{selectStat}
+		FROM {incrementTable}
+		ORDER BY {pkStat}, {commitTs} DESC, {row} DESC`, formatter.Named{
+		"incrementTable": QuoteIdent(incrementTable),
+		"selectStat":     strings.Join(selectStat, ",\n"),
+		"pkStat":         strings.Join(pkColumns, ", "),
+		"commitTs":       QuoteIdent(utils.CDCCommitTsColumnName),
+		"row":            QuoteIdent(incrementRowColumnName),
+	})
+}
+
+// matchedStat selects whether the row matches the predicate of --where
+func matchedStat(where string) string {
+	return fmt.Sprintf("COALESCE((%s), FALSE) AS %s", where, QuoteIdent(utils.WhereMatchedColumnName))
+}
+
+// GenCreateChangelogSQL generates the changelog table of the table in --increment-mode=append, see
+// incrementmode.ChangelogColumns. An existing changelog table is kept with its history.
+func GenCreateChangelogSQL(tableName string, tableColumns []cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	ddl, err := GenCreateTableSQL(incrementmode.ChangelogTable(tableName), incrementmode.ChangelogColumns(tableColumns), nil, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Replace(ddl, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1), nil
+}
+
+// GenAppendSQL generates the statement appending every change of the increment table to the changelog table of the
+// table in the order of the file. If where is not empty, only the changes matching it are appended.
+func GenAppendSQL(tableDef cloudstorage.TableDefinition, incrementTable, where string) string {
+	columns := incrementmode.ChangelogColumns(tableDef.Columns)
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, QuoteIdent(col.Name))
+	}
+	whereStat := ""
+	if where != "" {
+		whereStat = fmt.Sprintf("\n\tWHERE COALESCE((%s), FALSE)", where)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s)\n\tSELECT %s\n\tFROM %s%s\n\tORDER BY %s",
+		QuoteIdent(incrementmode.ChangelogTable(tableDef.Table)), strings.Join(names, ", "), strings.Join(names, ", "), QuoteIdent(incrementTable), whereStat, QuoteIdent(incrementRowColumnName))
+}
+
+// GenDeleteSQL generates the statement deleting the rows whose last change in the increment table is a delete.
+// If where is not empty, the rows whose last change does not match it are deleted too.
+func GenDeleteSQL(tableDef cloudstorage.TableDefinition, incrementTable, where string) (string, error) {
+	pkColumns := tidbsql.GetPKColumns(tableDef.Columns)
+	if len(pkColumns) == 0 {
+		return "", errors.Errorf("Table %s has no primary key, which is required to merge the increment files", tableDef.Table)
+	}
+	pkStat := quoteIdents(pkColumns)
+	onStat := make([]string, 0, len(pkColumns))
+	for _, col := range pkStat {
+		onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, QuoteIdent(tableDef.Table), col, col))
+	}
+	selectStat := append([]string{QuoteIdent(utils.CDCFlagColumnName)}, pkStat...)
+	deleteCond := fmt.Sprintf("S.%s = 'D'", QuoteIdent(utils.CDCFlagColumnName))
+	if where != "" {
+		selectStat = append(selectStat, matchedStat(where))
+		deleteCond = fmt.Sprintf("(%s OR NOT S.%s)", deleteCond, QuoteIdent(utils.WhereMatchedColumnName))
+	}
+	lastChanges, err := lastChangesQuery(incrementTable, selectStat, pkStat)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sql, err := formatter.Format(`
+	DELETE FROM {tableName} USING ({lastChanges}
+	) AS S
+	WHERE
+		{deleteCond} AND {onStat};
+	`, formatter.Named{
+		"tableName":   QuoteIdent(tableDef.Table),
+		"lastChanges": lastChanges,
+		"deleteCond":  deleteCond,
+		"onStat":      strings.Join(onStat, " AND "),
+	})
+	return sql, errors.Trace(err)
+}
+
+// GenUpsertSQL generates the statement inserting or updating the rows whose last change in the increment table
+// is not a delete. If where is not empty, only the rows whose last change matches it are upserted.
+func GenUpsertSQL(tableDef cloudstorage.TableDefinition, incrementTable, where string) (string, error) {
+	pkColumns := tidbsql.GetPKColumns(tableDef.Columns)
+	if len(pkColumns) == 0 {
+		return "", errors.Errorf("Table %s has no primary key, which is required to merge the increment files", tableDef.Table)
+	}
+	selectStat := make([]string, 0, len(tableDef.Columns))
+	updateStat := make([]string, 0, len(tableDef.Columns))
+	for _, col := range tableDef.Columns {
+		name := QuoteIdent(col.Name)
+		selectStat = append(selectStat, name)
+		if col.IsPK != "true" {
+			updateStat = append(updateStat, fmt.Sprintf("%s = EXCLUDED.%s", name, name))
+		}
+	}
+	conflictAction := "DO NOTHING"
+	if len(updateStat) > 0 {
+		conflictAction = fmt.Sprintf("DO UPDATE SET\n\t\t%s", strings.Join(updateStat, ",\n\t\t"))
+	}
+	pkStat := quoteIdents(pkColumns)
+	lastChangesStat := append([]string{QuoteIdent(utils.CDCFlagColumnName)}, selectStat...)
+	upsertCond := fmt.Sprintf("S.%s != 'D'", QuoteIdent(utils.CDCFlagColumnName))
+	if where != "" {
+		lastChangesStat = append(lastChangesStat, matchedStat(where))
+		upsertCond += fmt.Sprintf(" AND S.%s", QuoteIdent(utils.WhereMatchedColumnName))
+	}
+	lastChanges, err := lastChangesQuery(incrementTable, lastChangesStat, pkStat)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	sql, err := formatter.Format(`
+	INSERT INTO {tableName} ({columns})
+	SELECT
+		{selectStat}
+	FROM ({lastChanges}
+	) AS S
+	WHERE
+		{upsertCond}
+	ON CONFLICT ({pkStat}) {conflictAction};
+	`, formatter.Named{
+		"tableName":      QuoteIdent(tableDef.Table),
+		"columns":        strings.Join(selectStat, ", "),
+		"selectStat":     strings.Join(selectStat, ",\n"),
+		"lastChanges":    lastChanges,
+		"upsertCond":     upsertCond,
+		"pkStat":         strings.Join(pkStat, ", "),
+		"conflictAction": conflictAction,
+	})
+	return sql, errors.Trace(err)
+}
@@ -0,0 +1,119 @@
+package postgressql
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/pkg/errors"
+)
+
+// TiDB2PostgresTypeMap is a map from TiDB type to PostgreSQL type.
+var TiDB2PostgresTypeMap map[string]string = map[string]string{
+	"text":       "TEXT",
+	"tinytext":   "TEXT",
+	"mediumtext": "TEXT",
+	"longtext":   "TEXT",
+	"blob":       "BYTEA",
+	"tinyblob":   "BYTEA",
+	"mediumblob": "BYTEA",
+	"longblob":   "BYTEA",
+	"varchar":    "VARCHAR",
+	"char":       "CHAR",
+	"binary":     "BYTEA",
+	"varbinary":  "BYTEA",
+	"int":        "INTEGER",
+	"mediumint":  "INTEGER",
+	"tinyint":    "SMALLINT",
+	"smallint":   "SMALLINT",
+	"bigint":     "BIGINT",
+	"float":      "REAL",
+	"double":     "DOUBLE PRECISION",
+	"decimal":    "NUMERIC",
+	"numeric":    "NUMERIC",
+	"bool":       "BOOLEAN",
+	"boolean":    "BOOLEAN",
+	"date":       "DATE",
+	"datetime":   "TIMESTAMP",
+	"timestamp":  "TIMESTAMP",
+	"time":       "TIME",
+	"year":       "SMALLINT",
+	"json":       "JSONB",
+	"enum":       "TEXT",
+	"set":        "TEXT",
+}
+
+// tiDB2PostgresUnsignedTypeMap widens the unsigned integer types, PostgreSQL has no unsigned types
+var tiDB2PostgresUnsignedTypeMap map[string]string = map[string]string{
+	"tinyint":   "SMALLINT",
+	"smallint":  "INTEGER",
+	"mediumint": "INTEGER",
+	"int":       "BIGINT",
+	"bigint":    "NUMERIC(20)",
+}
+
+// GetPostgresTypeString returns the quoted column with its PostgreSQL type, the type given by columnTypes takes
+// precedence over the default mapping
+func GetPostgresTypeString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	name := QuoteIdent(column.Name)
+	if tp, ok := columnTypes.Lookup(column.Name); ok {
+		return fmt.Sprintf("%s %s", name, tp), nil
+	}
+	tp := strings.ToLower(column.Tp)
+	if baseTp, ok := strings.CutSuffix(tp, " unsigned"); ok {
+		if pgTp, ok := tiDB2PostgresUnsignedTypeMap[baseTp]; ok {
+			return fmt.Sprintf("%s %s", name, pgTp), nil
+		}
+		tp = baseTp
+	}
+	switch tp {
+	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob", "binary", "varbinary":
+		return fmt.Sprintf("%s %s", name, TiDB2PostgresTypeMap[tp]), nil
+	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date", "year":
+		return fmt.Sprintf("%s %s", name, TiDB2PostgresTypeMap[tp]), nil
+	case "json", "enum", "set":
+		return fmt.Sprintf("%s %s", name, TiDB2PostgresTypeMap[tp]), nil
+	case "varchar", "char":
+		// the columns of the increment files have no length
+		if column.Precision == "" {
+			return fmt.Sprintf("%s %s", name, TiDB2PostgresTypeMap[tp]), nil
+		}
+		return fmt.Sprintf("%s %s(%s)", name, TiDB2PostgresTypeMap[tp], column.Precision), nil
+	case "bit":
+		// BIT(1) is loaded from 0 or 1, a longer BIT from its bytes
+		if tidbsql.BitLength(column) > 1 {
+			return fmt.Sprintf("%s BYTEA", name), nil
+		}
+		return fmt.Sprintf("%s BOOLEAN", name), nil
+	case "decimal", "numeric":
+		return fmt.Sprintf("%s %s(%s, %s)", name, TiDB2PostgresTypeMap[tp], column.Precision, column.Scale), nil
+	case "datetime", "timestamp", "time":
+		return fmt.Sprintf("%s %s", name, TiDB2PostgresTypeMap[tp]), nil
+	default:
+		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
+	}
+}
+
+// isBinaryType returns whether the column is stored as BYTEA
+func isBinaryType(column cloudstorage.TableCol) bool {
+	return TiDB2PostgresTypeMap[strings.ToLower(column.Tp)] == "BYTEA"
+}
+
+// decodeBit returns the bytes of a BIT value of length bits, the snapshot has the hex of the bytes while TiCDC
+// writes the unsigned integer
+func decodeBit(value string, length int, hexEncoded bool) ([]byte, error) {
+	if hexEncoded {
+		return hex.DecodeString(value)
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	buf := binary.BigEndian.AppendUint64(nil, n)
+	return buf[len(buf)-tidbsql.BitBytes(length):], nil
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// burst is the most bytes let through at once, a larger transfer waits for the bytes in chunks. It is fixed
+// so that a chunk waiting is never refused by a rate changed meanwhile.
+const burst = 1 << 20
+
+// Limiters are the limiters of the files written into and read from the external storages by a pipeline
+type Limiters struct {
+	// Upload limits the files written into the external storages, e.g. the snapshot dumped
+	Upload *Limiter
+	// Download limits the files read from the external storages, e.g. the increment files converted
+	Download *Limiter
+}
+
+// NewLimiters returns the limiters of the bytes per second uploaded and downloaded, 0 is unlimited. The bytes and
+// the rates are reported by the storage metrics.
+func NewLimiters(upload, download int64) *Limiters {
+	l := &Limiters{Upload: NewLimiter(0), Download: NewLimiter(0)}
+	l.Upload.transferredMetric, l.Upload.rateMetric = metrics.StorageUploadedBytes, metrics.StorageUploadRateLimit
+	l.Download.transferredMetric, l.Download.rateMetric = metrics.StorageDownloadedBytes, metrics.StorageDownloadRateLimit
+	l.Upload.SetRate(upload)
+	l.Download.SetRate(download)
+	return l
+}
+
+// Wrap limits the files written and read through the storage, nil limiters do not limit it
+func (l *Limiters) Wrap(extStorage storage.ExternalStorage) storage.ExternalStorage {
+	if l == nil {
+		return extStorage
+	}
+	return WrapStorage(extStorage, l.Upload, l.Download)
+}
+
+// Limiter caps the bytes per second transferred by all the goroutines sharing it, by a token bucket. The rate is
+// adjustable while the transfers wait on it, 0 is unlimited.
+type Limiter struct {
+	limiter     *rate.Limiter
+	transferred atomic.Int64
+	// transferredMetric and rateMetric report the limiter, nil if it is not of Limiters
+	transferredMetric prometheus.Counter
+	rateMetric        prometheus.Gauge
+}
+
+// NewLimiter returns the limiter of the bytes per second, 0 is unlimited
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	l := &Limiter{limiter: rate.NewLimiter(rate.Inf, burst)}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the bytes per second, the transfers waiting take it from their next chunk
+func (l *Limiter) SetRate(bytesPerSecond int64) {
+	if l.rateMetric != nil {
+		l.rateMetric.Set(float64(max(bytesPerSecond, 0)))
+	}
+	if bytesPerSecond <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+		return
+	}
+	l.limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// Rate returns the bytes per second, 0 if unlimited
+func (l *Limiter) Rate() int64 {
+	limit := l.limiter.Limit()
+	if limit == rate.Inf {
+		return 0
+	}
+	return int64(limit)
+}
+
+// Transferred returns the bytes transferred through the limiter
+func (l *Limiter) Transferred() int64 {
+	return l.transferred.Load()
+}
+
+// Wait counts the bytes transferred and blocks until the rate allows them
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.transferred.Add(int64(n))
+	if l.transferredMetric != nil {
+		l.transferredMetric.Add(float64(n))
+	}
+	for n > 0 {
+		chunk := min(n, burst)
+		if err := l.limiter.WaitN(ctx, chunk); err != nil {
+			return errors.Trace(err)
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// ParseRate parses the bytes per second given like 200MiB or 1GB, the units are binary. "" and 0 are unlimited.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	bytes, err := units.RAMInBytes(s)
+	if err != nil || bytes < 0 {
+		return 0, errors.Errorf("invalid rate limit %s, expected bytes per second like 200MiB", s)
+	}
+	return bytes, nil
+}
+
+// FormatRate formats the bytes per second like ParseRate parses them, "unlimited" for 0
+func FormatRate(bytesPerSecond int64) string {
+	if bytesPerSecond <= 0 {
+		return "unlimited"
+	}
+	return units.BytesSize(float64(bytesPerSecond))
+}
@@ -0,0 +1,113 @@
+package ratelimit_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRate(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"":       0,
+		"0":      0,
+		"1024":   1024,
+		"200MiB": 200 << 20,
+		"200MB":  200 << 20,
+		"1g":     1 << 30,
+	} {
+		rate, err := ratelimit.ParseRate(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, rate, s)
+	}
+	_, err := ratelimit.ParseRate("fast")
+	require.ErrorContains(t, err, "invalid rate limit fast")
+	_, err = ratelimit.ParseRate("-1MiB")
+	require.Error(t, err)
+
+	require.Equal(t, "unlimited", ratelimit.FormatRate(0))
+	require.Equal(t, "200MiB", ratelimit.FormatRate(200<<20))
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := ratelimit.NewLimiter(0)
+	require.Equal(t, int64(0), limiter.Rate())
+	// unlimited
+	require.NoError(t, limiter.Wait(ctx, 100<<20))
+	require.Equal(t, int64(100<<20), limiter.Transferred())
+
+	limiter.SetRate(1 << 20)
+	require.Equal(t, int64(1<<20), limiter.Rate())
+	// the burst is taken at once, the next MiB waits for a second
+	start := time.Now()
+	require.NoError(t, limiter.Wait(ctx, 1<<20))
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.Error(t, limiter.Wait(timeoutCtx, 1<<20))
+	require.Less(t, time.Since(start), time.Second)
+
+	// the transfers waiting are released once the limit is removed
+	limiter.SetRate(0)
+	require.NoError(t, limiter.Wait(ctx, 10<<20))
+}
+
+func TestWrapStorage(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	upload, download := ratelimit.NewLimiter(0), ratelimit.NewLimiter(0)
+	limited := ratelimit.WrapStorage(extStorage, upload, download)
+
+	require.NoError(t, limited.WriteFile(ctx, "a", []byte("hello")))
+	writer, err := limited.Create(ctx, "b")
+	require.NoError(t, err)
+	_, err = writer.Write(ctx, []byte("world!"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close(ctx))
+	require.Equal(t, int64(11), upload.Transferred())
+
+	data, err := limited.ReadFile(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+	reader, err := limited.Open(ctx, "b")
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "world!", string(data))
+	require.Equal(t, int64(11), download.Transferred())
+
+	// the storage not limited is returned as it is
+	require.Equal(t, extStorage, ratelimit.WrapStorage(extStorage, nil, nil))
+	limited = ratelimit.WrapStorage(extStorage, upload, nil)
+	_, err = limited.ReadFile(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, int64(11), download.Transferred())
+}
+
+func TestLimiters(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	var unlimited *ratelimit.Limiters
+	require.Equal(t, extStorage, unlimited.Wrap(extStorage))
+
+	// the limiters of a pipeline are not shared with another pipeline
+	first, second := ratelimit.NewLimiters(1<<30, 0), ratelimit.NewLimiters(0, 0)
+	require.Equal(t, int64(1<<30), first.Upload.Rate())
+	require.Equal(t, int64(0), second.Upload.Rate())
+	uploaded := testutil.ToFloat64(metrics.StorageUploadedBytes)
+	require.NoError(t, first.Wrap(extStorage).WriteFile(ctx, "a", []byte("hello")))
+	require.Equal(t, int64(5), first.Upload.Transferred())
+	require.Equal(t, int64(0), second.Upload.Transferred())
+	// the transfers of all the pipelines are counted by the metrics
+	require.NoError(t, second.Wrap(extStorage).WriteFile(ctx, "b", []byte("world!")))
+	require.Equal(t, uploaded+11, testutil.ToFloat64(metrics.StorageUploadedBytes))
+}
@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// limitedStorage limits the files written and read through the storage, the other operations are not limited
+type limitedStorage struct {
+	storage.ExternalStorage
+	// upload and download are nil if not limited
+	upload   *Limiter
+	download *Limiter
+}
+
+// WrapStorage limits the files written through the storage by upload and those read by download, either may be
+// nil. The limiters are shared with the other storages wrapped by them.
+func WrapStorage(extStorage storage.ExternalStorage, upload, download *Limiter) storage.ExternalStorage {
+	if upload == nil && download == nil {
+		return extStorage
+	}
+	return &limitedStorage{ExternalStorage: extStorage, upload: upload, download: download}
+}
+
+func (s *limitedStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	if s.upload != nil {
+		if err := s.upload.Wait(ctx, len(data)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return s.ExternalStorage.WriteFile(ctx, name, data)
+}
+
+// ReadFile waits for the bytes read after reading them, so that the following reads are delayed instead
+func (s *limitedStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.ExternalStorage.ReadFile(ctx, name)
+	if err != nil || s.download == nil {
+		return data, err
+	}
+	if err = s.download.Wait(ctx, len(data)); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+func (s *limitedStorage) Open(ctx context.Context, path string) (storage.ExternalFileReader, error) {
+	reader, err := s.ExternalStorage.Open(ctx, path)
+	if err != nil || s.download == nil {
+		return reader, err
+	}
+	return &limitedReader{ExternalFileReader: reader, ctx: ctx, limiter: s.download}, nil
+}
+
+func (s *limitedStorage) Create(ctx context.Context, path string) (storage.ExternalFileWriter, error) {
+	writer, err := s.ExternalStorage.Create(ctx, path)
+	if err != nil || s.upload == nil {
+		return writer, err
+	}
+	return &limitedWriter{ExternalFileWriter: writer, limiter: s.upload}, nil
+}
+
+// limitedReader waits for the bytes read by the context the file is opened with
+type limitedReader struct {
+	storage.ExternalFileReader
+	ctx     context.Context
+	limiter *Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.ExternalFileReader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.Wait(r.ctx, n); waitErr != nil {
+			return n, errors.Trace(waitErr)
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	storage.ExternalFileWriter
+	limiter *Limiter
+}
+
+func (w *limitedWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if err := w.limiter.Wait(ctx, len(p)); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return w.ExternalFileWriter.Write(ctx, p)
+}
@@ -0,0 +1,66 @@
+package redshiftsql
+
+import (
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// SetAppliedBatch sets the batch recorded by the next LoadIncrement, right after the file is merged, as the DELETE
+// and the INSERT of the merge strategy are not run in a transaction. The delete-insert strategy records it in the
+// transaction of the merge.
+func (rc *RedshiftConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	rc.appliedBatch = batch
+}
+
+// FindAppliedBatch returns the batch of the id recorded in the bookkeeping table, nil if it is not applied
+func (rc *RedshiftConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if err := rc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFind(rc.gen.QuoteIdent(appliedbatch.TableName), id, utils.QuoteLiteral)
+	batch := &appliedbatch.Batch{ID: id}
+	if err := rc.db.QueryRow(query).Scan(&batch.LastFile, &batch.CommitTs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, diag.WrapSQL(err, query)
+	}
+	return batch, nil
+}
+
+// ensureAppliedBatchTable creates the bookkeeping table of the applied batches if it does not exist
+func (rc *RedshiftConnector) ensureAppliedBatchTable() error {
+	if rc.appliedBatchTableCreated {
+		return nil
+	}
+	query := appliedbatch.GenCreateTable(rc.gen.QuoteIdent(appliedbatch.TableName), "VARCHAR(1024)", "BIGINT", "TIMESTAMPTZ")
+	if _, err := rc.db.Exec(query); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, query), "Failed to create applied batch table")
+	}
+	rc.appliedBatchTableCreated = true
+	return nil
+}
+
+// recordAppliedBatch records the batch set by SetAppliedBatch as applied to the table up to the file, it is a no-op
+// if no batch is set
+func (rc *RedshiftConnector) recordAppliedBatch(db execer, table, filePath string) error {
+	if rc.appliedBatch == nil {
+		return nil
+	}
+	if err := rc.ensureAppliedBatchTable(); err != nil {
+		return errors.Trace(err)
+	}
+	batch := *rc.appliedBatch
+	batch.Table = table
+	batch.LastFile = filePath
+	for _, query := range appliedbatch.GenRecord(rc.gen.QuoteIdent(appliedbatch.TableName), batch, "GETDATE()", utils.QuoteLiteral) {
+		if _, err := db.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to record the applied batch")
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	_ "github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 )
@@ -17,21 +18,35 @@ type RedshiftConfig struct {
 	Database string
 	Schema   string
 	Role     string
+	// CreateSchema creates the schema of the tables if it does not exist, otherwise a missing schema fails the
+	// connectors
+	CreateSchema bool
+	// SSLMode is the sslmode of lib/pq: disable, require, verify-ca or verify-full, SSLRootCert is the CA
+	// verifying the server with verify-ca or verify-full
+	SSLMode     string
+	SSLRootCert string
 }
 
 // Open a connection to Redshift.
 // can not specify one schema in redshift
 func (config *RedshiftConfig) OpenDB() (*sql.DB, error) {
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
 	var connStr = fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, config.User, config.Pass, config.Database)
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		config.Host, config.Port, config.User, config.Pass, config.Database, sslMode)
+	if config.SSLRootCert != "" {
+		connStr += fmt.Sprintf(" sslrootcert=%s", config.SSLRootCert)
+	}
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, errors.Annotate(err, "Failed to open Redshift connection")
 	}
 	// make sure the connection is available
 	if err = db.Ping(); err != nil {
-		return nil, errors.Annotate(err, "Failed to ping Redshift")
+		return nil, errors.Annotate(utils.AnnotateTLSError(err, "Redshift", "redshift"), "Failed to ping Redshift")
 	}
 	log.Info("Redshift connection established")
 	return db, nil
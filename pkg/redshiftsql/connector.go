@@ -1,52 +1,112 @@
 package redshiftsql
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
 
 type RedshiftConnector struct {
 	// db is the connection to redshift.
-	db            *sql.DB
+	db *sql.DB
+	// gen generates the statements, also of the schemas created by NewRedshiftConnector
+	gen           Generator
 	schemaName    string
 	tableName     string
 	storageUri    *url.URL
-	s3Credentials *credentials.Value
+	s3Credentials *credentials.Credentials
+	compression   utils.Compression
 	iamRole       string
 	columns       []cloudstorage.TableCol
+	// targetTable and tableProperties are set by SetTableProperties
+	targetTable     string
+	tableProperties *TableProperties
+	// columnTypes overrides the types of the columns, nil if the default mapping is used
+	columnTypes columnmapping.Columns
+	// columnFilter is the columns replicated, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows replicated, empty if all the rows are replicated
+	where string
+	// routedTable is the table the source table is replicated to, empty if it is replicated to the table of its name
+	routedTable string
+	// dedupKey is the key the table is created with if the source table has no primary key, nil if there is none
+	dedupKey []string
+	// deleteMode is how the rows deleted in TiDB are deleted in the table, the zero Mode deletes them
+	deleteMode deletemode.Mode
+	// dryRun skips writing the snapshot manifest into the storage
+	dryRun bool
+	// mergedRows is the total rows inserted by the merges of the increment files, the deleted rows are not counted
+	mergedRows int64
+	// appliedBatch is the batch recorded by the loads of the increment files, nil if they are not recorded
+	appliedBatch *appliedbatch.Batch
+	// appliedBatchTableCreated is true once the bookkeeping table of the applied batches is created
+	appliedBatchTableCreated bool
+	// incrementStrategy is how the increment files are merged, the external schema is created only for
+	// IncrementStrategyMerge
+	incrementStrategy IncrementStrategy
 }
 
-func NewRedshiftConnector(db *sql.DB, schemaName, externalTableName, iamRole string, storageURI *url.URL, s3Credentials *credentials.Value) (*RedshiftConnector, error) {
+func NewRedshiftConnector(db *sql.DB, identifierCase identcase.Case, schemaName, externalTableName, iamRole string, storageURI *url.URL, s3Credentials *credentials.Credentials, compression utils.Compression, incrementStrategy IncrementStrategy, createSchema bool) (*RedshiftConnector, error) {
 	var err error
-	// create schema
-	err = CreateSchema(db, schemaName)
-	if err != nil {
-		return nil, errors.Annotate(err, "Failed to create schema")
+	g := NewGenerator(identifierCase)
+	if err = g.UseSchema(db, schemaName, createSchema); err != nil {
+		return nil, errors.Trace(err)
 	}
-	// need iam role to create external schema
-	if err = CreateExternalSchema(db, fmt.Sprintf("%s_schema", externalTableName), fmt.Sprintf("%s_database", externalTableName), iamRole); err != nil {
-		return nil, errors.Annotate(err, "Failed to create external table")
+	// need iam role to create external schema, which is not used by the delete-insert strategy
+	if incrementStrategy != IncrementStrategyDeleteInsert {
+		if err = g.CreateExternalSchema(db, fmt.Sprintf("%s_schema", externalTableName), fmt.Sprintf("%s_database", externalTableName), iamRole); err != nil {
+			return nil, errors.Annotate(err, "Failed to create external table")
+		}
 	}
 	return &RedshiftConnector{
-		db:            db,
-		schemaName:    schemaName,
-		tableName:     externalTableName,
-		storageUri:    storageURI,
-		s3Credentials: s3Credentials,
-		iamRole:       iamRole,
-		columns:       nil,
+		db:                db,
+		gen:               g,
+		schemaName:        schemaName,
+		tableName:         externalTableName,
+		storageUri:        storageURI,
+		s3Credentials:     s3Credentials,
+		compression:       compression,
+		iamRole:           iamRole,
+		columns:           nil,
+		incrementStrategy: incrementStrategy,
 	}, nil
 }
 
+// NewRedshiftSchemaConnector returns a connector creating and altering the tables in the schema of Redshift only, e.g.
+// for `tidb2dw schema sync`, which loads no file and so creates no external schema
+func NewRedshiftSchemaConnector(db *sql.DB, identifierCase identcase.Case, schemaName string, createSchema bool) (*RedshiftConnector, error) {
+	g := NewGenerator(identifierCase)
+	if err := g.UseSchema(db, schemaName, createSchema); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &RedshiftConnector{db: db, gen: g, schemaName: schemaName}, nil
+}
+
 func (rc *RedshiftConnector) InitSchema(columns []cloudstorage.TableCol) error {
 	if len(rc.columns) != 0 {
 		return nil
@@ -56,14 +116,127 @@ func (rc *RedshiftConnector) InitSchema(columns []cloudstorage.TableCol) error {
 	}
 	rc.columns = columns
 	log.Info("table columns initialized", zap.Any("Columns", columns))
+	if rc.targetTable != "" {
+		columns = rc.columnFilter.Columns(columns)
+		pkColumns := tidbsql.GetPKColumns(columns)
+		props, err := ResolveTableProperties(columns, pkColumns, rc.tableProperties)
+		if err != nil {
+			return errors.Annotatef(err, "Failed to resolve table properties of %s", rc.targetTable)
+		}
+		if err = rc.gen.checkTableProperties(rc.db, rc.schemaName, rc.targetTable, props); err != nil {
+			log.Warn("Failed to check table properties", zap.String("table", rc.targetTable), zap.Error(err))
+		}
+	}
 	return nil
 }
 
+// SetTableProperties overrides the distribution and sort keys of the target table, nil means the defaults.
+// The properties are applied when the table is created, and compared with the existing table when the schema is initialized.
+func (rc *RedshiftConnector) SetTableProperties(targetTable string, override *TableProperties) {
+	rc.targetTable = targetTable
+	rc.tableProperties = override
+}
+
+// SetTargetTable replicates the table to another table in Redshift, empty means the table of the source table name.
+// The table keeps its name when the source table is renamed.
+func (rc *RedshiftConnector) SetTargetTable(table string) {
+	rc.routedTable = table
+}
+
+// SetDedupKey creates the table in Redshift with the columns as its primary key if the source table has none, the
+// increment files of such a table are merged by them
+func (rc *RedshiftConnector) SetDedupKey(columns []string) {
+	rc.dedupKey = columns
+}
+
+// SetAuditScope labels the statements of the connector in the SQL audit log, if it is enabled
+func (rc *RedshiftConnector) SetAuditScope(batchID string, schemaVersion uint64) {
+	sqlaudit.SetScope(rc.db, batchID, schemaVersion)
+}
+
+// targetTableName returns the table in Redshift the source table is replicated to
+func (rc *RedshiftConnector) targetTableName(sourceTable string) string {
+	if rc.routedTable != "" {
+		return rc.routedTable
+	}
+	return sourceTable
+}
+
+// routeTableDef returns the table definition of the table in Redshift
+func (rc *RedshiftConnector) routeTableDef(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	tableDef.Table = rc.targetTableName(tableDef.Table)
+	return tableDef
+}
+
+// SetColumnTypes overrides the types of the columns of the table in Redshift, nil means the default mapping
+func (rc *RedshiftConnector) SetColumnTypes(columnTypes columnmapping.Columns) {
+	rc.columnTypes = columnTypes
+}
+
+// SetColumnFilter filters the columns of the table replicated to Redshift, nil means all the columns
+func (rc *RedshiftConnector) SetColumnFilter(columnFilter *columnfilter.Filter) {
+	rc.columnFilter = columnFilter
+}
+
+// SetWhere filters the rows of the table replicated to Redshift by the predicate, empty means all the rows
+func (rc *RedshiftConnector) SetWhere(where string) {
+	rc.where = where
+}
+
+// SetDeleteMode sets how the rows deleted in TiDB are deleted in the table in Redshift, soft keeps them with a
+// tombstone
+func (rc *RedshiftConnector) SetDeleteMode(deleteMode deletemode.Mode) {
+	rc.deleteMode = deleteMode
+}
+
+// SetSyncComments sets whether the comments of the tables and the columns in TiDB are replicated into Redshift,
+// they are replicated by default
+func (rc *RedshiftConnector) SetSyncComments(sync bool) {
+	rc.gen = rc.gen.WithSyncComments(sync)
+}
+
+// CheckDeleteMode fails if the table in Redshift is created in another delete mode, a table not created yet passes
+func (rc *RedshiftConnector) CheckDeleteMode(targetTable string) error {
+	targetTable = rc.targetTableName(targetTable)
+	columns, err := rc.gen.GetTableColumns(rc.db, rc.schemaName, targetTable)
+	if err != nil || len(columns) == 0 {
+		return errors.Trace(err)
+	}
+	return rc.deleteMode.CheckTable(targetTable, slices.Contains(columns, rc.gen.identifierCase.Apply(deletemode.DeletedColumn)))
+}
+
+// snapshotColumns returns the columns the fields of the snapshot files are copied into, nil if they are all the
+// columns of the table. The tombstone columns of the soft delete mode are left to their defaults.
+func (rc *RedshiftConnector) snapshotColumns(targetTable string) ([]string, error) {
+	if rc.deleteMode != deletemode.Soft {
+		return nil, nil
+	}
+	columns, err := rc.gen.GetTableColumns(rc.db, rc.schemaName, targetTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return slices.DeleteFunc(columns, deletemode.IsTombstoneColumn), nil
+}
+
 func (rc *RedshiftConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(rc.columns) == 0 {
+	// a table created after the changefeed starts has no columns before its CREATE TABLE
+	if len(rc.columns) == 0 && tableDef.Type != timodel.ActionCreateTable {
 		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
 	}
-	ddls, err := GenDDLViaColumnsDiff(rc.columns, tableDef)
+	if rc.routedTable != "" && tidbsql.IsRenameTable(tableDef.Type) {
+		log.Info("Skip rename table ddl, the routed table keeps its name", zap.String("ddl", tableDef.Query), zap.String("table", rc.routedTable))
+		return nil
+	}
+	var (
+		ddls []string
+		err  error
+	)
+	// the changes of the columns filtered out are ignored
+	if tableDef.Type == timodel.ActionCreateTable {
+		ddls, err = rc.gen.GenCreateTableDDLs(rc.columnFilter.TableDef(rc.routeTableDef(tableDef)), rc.tableProperties, rc.columnTypes, rc.deleteMode)
+	} else {
+		ddls, err = rc.gen.GenDDLViaColumnsDiff(rc.columnFilter.Columns(rc.columns), rc.columnFilter.TableDef(rc.routeTableDef(tableDef)), rc.columnTypes, rc.deleteMode)
+	}
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -75,8 +248,12 @@ func (rc *RedshiftConnector) ExecDDL(tableDef cloudstorage.TableDefinition) erro
 	for _, ddl := range ddls {
 		_, err := rc.db.Exec(ddl)
 		if err != nil {
+			if appliedDDLErrors.IsApplied(ddl, err) {
+				log.Warn("Skip DDL whose effect is already present, it is executed before a failure", zap.String("ddl", ddl), zap.Error(err))
+				continue
+			}
 			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
-			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
 		}
 	}
 	// update columns
@@ -86,11 +263,11 @@ func (rc *RedshiftConnector) ExecDDL(tableDef cloudstorage.TableDefinition) erro
 }
 
 func (rc *RedshiftConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
-	err := DropTable(sourceTable, rc.db)
+	err := rc.gen.DropTable(rc.targetTableName(sourceTable), rc.db)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	err = CreateTable(sourceDatabase, sourceTable, sourceTiDBConn, rc.db)
+	err = rc.gen.CreateTable(sourceDatabase, sourceTable, rc.targetTableName(sourceTable), sourceTiDBConn, rc.db, rc.tableProperties, rc.columnTypes, rc.columnFilter, rc.deleteMode, rc.dedupKey)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -98,38 +275,178 @@ func (rc *RedshiftConnector) CopyTableSchema(sourceDatabase string, sourceTable
 	return nil
 }
 
-// filePrefix should be
-func (rc *RedshiftConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	if err := LoadSnapshotFromS3(rc.db, targetTable, rc.storageUri.String(), filePrefix, rc.s3Credentials, onSnapshotLoadProgress); err != nil {
+// EnableDryRun skips writing the snapshot manifest, for a connector whose db records the statements instead of executing them
+func (rc *RedshiftConnector) EnableDryRun() {
+	rc.dryRun = true
+}
+
+// snapshotCopyRetries is the number of times the files missing in stl_load_commits after a COPY are copied again
+const snapshotCopyRetries = 3
+
+// LoadSnapshot writes a manifest listing the files into the storage and copies the files by the manifest.
+// The files committed by the COPY are checked in stl_load_commits, the missing files are copied again by a
+// manifest listing them only.
+func (rc *RedshiftConnector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	targetTable = rc.targetTableName(targetTable)
+	ctx := context.Background()
+	storageUrl := fmt.Sprintf("%s://%s%s", rc.storageUri.Scheme, rc.storageUri.Host, rc.storageUri.Path)
+	region := rc.storageUri.Query().Get("region")
+	manifestFileName := fmt.Sprintf("%s.snapshot.manifest", targetTable)
+	manifestUrl := fmt.Sprintf("%s/%s", storageUrl, manifestFileName)
+	urls := make([]string, 0, len(files))
+	for _, file := range files {
+		urls = append(urls, fmt.Sprintf("%s/%s", storageUrl, file))
+	}
+
+	columns, err := rc.snapshotColumns(targetTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// pg_last_copy_id is of the session, the COPY and the check share a connection
+	conn, err := rc.db.Conn(ctx)
+	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	defer conn.Close()
+	format := stagingformat.CSV
+	if len(files) > 0 {
+		format = stagingformat.FileFormat(files[0])
+	}
+	for attempt := 0; ; attempt++ {
+		if !rc.dryRun {
+			if err := writeSnapshotManifest(rc.storageUri, manifestFileName, urls); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		authorization, err := CopyAuthorization(rc.iamRole, rc.s3Credentials)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err := rc.gen.LoadSnapshotFromS3(ctx, conn, targetTable, columns, manifestUrl, region, rc.compression, format, authorization, onSnapshotLoadProgress); err != nil {
+			return errors.Trace(err)
+		}
+		if rc.dryRun {
+			break
+		}
+		committed, err := GetCopyCommittedFiles(ctx, conn)
+		if err != nil {
+			return errors.Annotate(err, "Failed to check the files committed by COPY")
+		}
+		missing := MissingCommittedFiles(urls, committed)
+		if len(missing) == 0 {
+			break
+		}
+		if attempt >= snapshotCopyRetries {
+			return errors.Errorf("%d files of the manifest are not committed by COPY after %d retries, e.g. %s", len(missing), attempt, missing[0])
+		}
+		log.Warn("Files of the manifest are not committed by COPY, copying them again",
+			zap.String("table", targetTable), zap.Int("attempt", attempt+1), zap.Strings("files", missing))
+		urls = missing
+	}
+	if err := onFilesLoaded(files); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.Int("files", len(files)))
 	return nil
 }
 
+type manifestEntry struct {
+	URL       string `json:"url"`
+	Mandatory bool   `json:"mandatory"`
+	// Meta is required by the Parquet files
+	Meta *manifestMeta `json:"meta,omitempty"`
+}
+
+type manifestMeta struct {
+	ContentLength int64 `json:"content_length"`
+}
+
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+// writeSnapshotManifest writes the manifest listing the urls of the files, all of them are mandatory. COPY requires
+// the size of each Parquet file, which is read from the storage.
+func writeSnapshotManifest(storageUri *url.URL, manifestFileName string, urls []string) error {
+	ctx := context.Background()
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, storageUri.String())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	storageUrl := fmt.Sprintf("%s://%s%s/", storageUri.Scheme, storageUri.Host, storageUri.Path)
+	content := manifest{Entries: make([]manifestEntry, 0, len(urls))}
+	for _, fileUrl := range urls {
+		entry := manifestEntry{URL: fileUrl, Mandatory: true}
+		if stagingformat.FileFormat(fileUrl) == stagingformat.Parquet {
+			size, err := fileSize(ctx, extStorage, strings.TrimPrefix(fileUrl, storageUrl))
+			if err != nil {
+				return errors.Annotatef(err, "Failed to read the size of %s", fileUrl)
+			}
+			entry.Meta = &manifestMeta{ContentLength: size}
+		}
+		content.Entries = append(content.Entries, entry)
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(extStorage.WriteFile(ctx, manifestFileName, data), "Failed to write snapshot manifest")
+}
+
+// fileSize returns the size of the file in the storage
+func fileSize(ctx context.Context, extStorage storage.ExternalStorage, path string) (int64, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	size, err := reader.Seek(0, io.SeekEnd)
+	return size, errors.Trace(err)
+}
+
 func (rc *RedshiftConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+	if rc.incrementStrategy == IncrementStrategyDeleteInsert {
+		return rc.loadIncrementByTempTable(tableDef, uri, filePath)
+	}
 	// create external table, need S3 manifest file location
 	externalTableName := rc.tableName
 	externalTableSchema := fmt.Sprintf("%s_schema", rc.tableName)
 	fileSuffix := filepath.Ext(filePath)
 	manifestFilePath := fmt.Sprintf("%s://%s%s/%s.manifest", uri.Scheme, uri.Host, uri.Path, strings.TrimSuffix(filePath, fileSuffix))
-	err := CreateExternalTable(rc.db, tableDef.Columns, externalTableName, externalTableSchema, manifestFilePath)
+	// the external table of a failed attempt is left, the file is loaded again from the start
+	if err := rc.gen.DeleteTable(rc.db, externalTableSchema, externalTableName); err != nil {
+		return errors.Trace(err)
+	}
+	format := stagingformat.FileFormat(filePath)
+	err := rc.gen.CreateExternalTable(rc.db, tableDef.Columns, externalTableName, externalTableSchema, manifestFilePath, rc.columnTypes, format)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	// merge external table file into table
-	err = DeleteQuery(rc.db, tableDef, rc.tableName)
+	// merge external table file into table, the external table has all the columns of the file
+	source := rc.gen.ExternalTableRef(rc.tableName)
+	mergedTableDef := rc.columnFilter.TableDef(rc.routeTableDef(tableDef))
+	if rc.deleteMode == deletemode.Soft {
+		if err = rc.gen.MarkDeletedQuery(rc.db, mergedTableDef, source, rc.columnTypes, rc.where, format); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	err = rc.gen.DeleteQuery(rc.db, mergedTableDef, source, rc.columnTypes, rc.where, rc.deleteMode, format)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	err = InsertQuery(rc.db, tableDef, rc.tableName)
+	rows, err := rc.gen.InsertQuery(rc.db, mergedTableDef, source, rc.columnTypes, rc.where, rc.deleteMode, format)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	rc.mergedRows += rows
+	if err = rc.recordAppliedBatch(rc.db, mergedTableDef.Table, filePath); err != nil {
+		return errors.Trace(err)
+	}
 
-	err = DeleteTable(rc.db, externalTableSchema, externalTableName)
+	err = rc.gen.DeleteTable(rc.db, externalTableSchema, externalTableName)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -137,11 +454,84 @@ func (rc *RedshiftConnector) LoadIncrement(tableDef cloudstorage.TableDefinition
 	return nil
 }
 
+// AggregateTable returns the row count and the sums of the columns of the table, for the validation of the snapshot
+func (rc *RedshiftConnector) AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error) {
+	query := validation.GenAggregateQuery(rc.gen.QuoteIdent(rc.targetTableName(targetTable)), sumColumns, "DECIMAL", rc.gen.QuoteIdent)
+	aggregates, err := validation.QueryAggregates(context.Background(), rc.db, query, sumColumns)
+	return aggregates, errors.Trace(err)
+}
+
+// DiffSchema compares the table in Redshift with the columns replicated to it, nil if the columns are not
+// initialized yet
+func (rc *RedshiftConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	if len(rc.columns) == 0 {
+		return nil, nil
+	}
+	columns, err := rc.gen.GetWarehouseColumns(rc.db, rc.schemaName, rc.targetTableName(targetTable))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the tombstone columns of the soft delete mode are not replicated from TiDB
+	actual := slices.DeleteFunc(columns, func(column tidbsql.WarehouseColumn) bool { return deletemode.IsTombstoneColumn(column.Name) })
+	drift, err := tidbsql.GetSchemaDrift(rc.columnFilter.Columns(rc.columns), actual, func(column cloudstorage.TableCol) (string, error) {
+		return rc.gen.getRedshiftColumnType(column, rc.columnTypes)
+	})
+	return drift, errors.Trace(err)
+}
+
+// ReconcileSchema alters the table in Redshift back to the columns replicated to it
+func (rc *RedshiftConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	targetTable = rc.targetTableName(targetTable)
+	prevColumns, err := DriftedColumns(drift)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	ddls, err := rc.gen.GenDDLViaColumnsDiff(prevColumns, cloudstorage.TableDefinition{Table: targetTable, Columns: drift.Expected}, rc.columnTypes, rc.deleteMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ddl := range ddls {
+		if _, err := rc.db.Exec(ddl); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	log.Info("Successfully reconciled table", zap.String("table", targetTable), zap.String("ddls", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// IsRetryable retries the statements failed by a lost connection, a serializable isolation violation or a lack of
+// resources of the cluster
+func (rc *RedshiftConnector) IsRetryable(err error) bool {
+	return IsRetryableError(err)
+}
+
+// DDLActionClasses returns the classes of the DDLs in Redshift
+func (rc *RedshiftConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	return ddlActionClasses
+}
+
+func (rc *RedshiftConnector) MergedRows() int64 {
+	return rc.mergedRows
+}
+
+// ExecStatements executes the statements of --post-snapshot-sql in order
+func (rc *RedshiftConnector) ExecStatements(statements []string) error {
+	for _, statement := range statements {
+		log.Info("Executing statement", zap.String("query", statement))
+		if _, err := rc.db.Exec(statement); err != nil {
+			return diag.WrapSQL(err, statement)
+		}
+	}
+	return nil
+}
+
 func (rc *RedshiftConnector) Close() {
-	// drop schema
-	schemaName := fmt.Sprintf("%s_schema", rc.tableName)
-	if err := DropExternalSchema(rc.db, schemaName); err != nil {
-		log.Error("fail to drop schema", zap.Error(err))
+	// drop schema, which is not created by NewRedshiftSchemaConnector
+	if rc.tableName != "" && rc.incrementStrategy != IncrementStrategyDeleteInsert {
+		schemaName := fmt.Sprintf("%s_schema", rc.tableName)
+		if err := rc.gen.DropExternalSchema(rc.db, schemaName); err != nil {
+			log.Error("fail to drop schema", zap.Error(err))
+		}
 	}
 	rc.db.Close()
 }
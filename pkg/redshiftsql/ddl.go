@@ -5,29 +5,62 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 )
 
-func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+// GenCreateTableDDLs generates the DDLs of a table created after the changefeed starts, its columns are given
+// by the schema file. The distribution and sort keys are resolved like the tables copied from TiDB.
+func (g Generator) GenCreateTableDDLs(tableDef cloudstorage.TableDefinition, override *TableProperties, columnTypes columnmapping.Columns, deleteMode deletemode.Mode) ([]string, error) {
+	pkColumns := tidbsql.GetPKColumns(tableDef.Columns)
+	props, err := ResolveTableProperties(tableDef.Columns, pkColumns, override)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to resolve table properties of %s", tableDef.Table)
+	}
+	ddl, err := g.GenCreateTableSQL(tableDef.Table, tableDef.Columns, pkColumns, props, columnTypes, deleteMode)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []string{fmt.Sprintf("DROP TABLE IF EXISTS %s", g.QuoteIdent(tableDef.Table)), ddl}, nil
+}
+
+// appliedDDLErrors are the errors of Redshift telling the effect of a column DDL is already present
+var appliedDDLErrors = tidbsql.AppliedDDLErrors{
+	ColumnExists:  []string{"already exists"},
+	ColumnMissing: []string{"does not exist"},
+}
+
+// ddlActionClasses are the classes of the DDLs in Redshift, which does not change the default of a column
+var ddlActionClasses = tidbsql.WithDDLActionClasses(map[timodel.ActionType]tidbsql.DDLActionClass{
+	timodel.ActionSetDefaultValue: tidbsql.DDLActionNoop,
+})
+
+func (g Generator) GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns, deleteMode deletemode.Mode) ([]string, error) {
+	table := g.QuoteIdent(curTableDef.Table)
 	if curTableDef.Type == timodel.ActionTruncateTable {
-		return []string{fmt.Sprintf("TRUNCATE TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropTable {
-		return []string{fmt.Sprintf("DROP TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("DROP TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
+		return g.GenCreateTableDDLs(curTableDef, nil, columnTypes, deleteMode)
 	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		_, oldTable, err := tidbsql.GetRenamedFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", g.QuoteIdent(oldTable), table)}, nil
 	}
 	// snowflake: Default CASCADE, redshift: Default RESTRICT
 	if curTableDef.Type == timodel.ActionDropSchema {
-		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", curTableDef.Schema)}, nil
+		return []string{fmt.Sprintf("DROP SCHEMA %s CASCADE", g.QuoteIdent(curTableDef.Schema))}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateSchema {
 		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
@@ -42,19 +75,22 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		ddl := ""
 		switch item.Action {
 		case tidbsql.ADD_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", curTableDef.Table)
-			colStr, err := GetRedshiftColumnString(*item.After)
+			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", table)
+			colStr, err := g.GetRedshiftColumnString(tidbsql.WithAddedColumnDefault(curTableDef.Table, *item.After), columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			ddl += colStr
 		case tidbsql.DROP_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", curTableDef.Table, item.Before.Name)
-		// redshift does not support direct data type modify
+			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, g.QuoteIdent(item.Before.Name))
 		case tidbsql.MODIFY_COLUMN:
-			return nil, errors.New("Received modify column ddl, which is not supported by redshift yet")
+			modifyDDL, err := g.genModifyColumnDDL(table, item, columnTypes)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddl += modifyDDL
 		case tidbsql.RENAME_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", curTableDef.Table, item.Before.Name, item.After.Name)
+			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, g.QuoteIdent(item.Before.Name), g.QuoteIdent(item.After.Name))
 		default:
 			// UNCHANGE
 		}
@@ -64,26 +100,79 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		}
 	}
 
+	changes := &tidbsql.CommentChanges{}
+	if !g.skipComments {
+		changes = tidbsql.GetCommentChanges(curTableDef)
+	}
+	if changes.Table != nil {
+		ddls = append(ddls, g.genTableComment(curTableDef.Table, *changes.Table))
+	}
+	for _, column := range curTableDef.Columns {
+		if comment, ok := changes.Columns[column.Name]; ok {
+			ddls = append(ddls, g.genColumnComment(curTableDef.Table, column.Name, comment))
+		}
+	}
+
 	// TODO: handle primary key
 	return ddls, nil
 }
 
-func getDefaultString(val interface{}) string {
-	_, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
+// genModifyColumnDDL returns the DDL of a modified column, empty if the column in Redshift is not changed.
+// Redshift can only change the type of a column by widening a VARCHAR, any other type change fails with the
+// column and the types. The nullability and the default of a column can not be changed, a column becoming
+// NOT NULL is kept nullable, and a column becoming nullable fails since its NULLs could not be loaded.
+// An overridden column keeps its type. tableName is quoted.
+func (g Generator) genModifyColumnDDL(tableName string, diff tidbsql.ColumnDiff, columnTypes columnmapping.Columns) (string, error) {
+	before, after := diff.Before, diff.After
+	beforeType, err := g.GetRedshiftTypeString(*before, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	afterType, err := g.GetRedshiftTypeString(*after, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if before.Nullable == "false" && after.Nullable != "false" {
+		return "", tidbsql.NewUnsupportedDDLError("Received modify column ddl of column %s dropping NOT NULL, "+
+			"which is not supported by Redshift", after.Name)
+	}
+	if beforeType == afterType {
+		return "", nil
+	}
+	typePrefix := g.QuoteIdent(after.Name) + " "
+	if !isWideningVarchar(*before, *after) {
+		return "", tidbsql.NewUnsupportedDDLError("Received modify column ddl of column %s from %s to %s, which is not supported by Redshift, "+
+			"it can only widen a VARCHAR column", after.Name, strings.TrimPrefix(beforeType, typePrefix), strings.TrimPrefix(afterType, typePrefix))
+	}
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", tableName, g.QuoteIdent(after.Name), strings.TrimPrefix(afterType, typePrefix)), nil
+}
+
+// isWideningVarchar tells whether the column stays a VARCHAR with a greater length
+func isWideningVarchar(before, after cloudstorage.TableCol) bool {
+	if !strings.EqualFold(before.Tp, "varchar") || !strings.EqualFold(after.Tp, "varchar") {
+		return false
+	}
+	beforeLength, err := strconv.Atoi(before.Precision)
 	if err != nil {
-		return fmt.Sprintf("'%v'", val) // FIXME: escape
+		return false
 	}
-	return fmt.Sprintf("%v", val)
+	afterLength, err := strconv.Atoi(after.Precision)
+	return err == nil && afterLength > beforeLength
+}
+
+// getDefaultString returns the literal of the default value of the column
+func getDefaultString(column cloudstorage.TableCol) string {
+	return tidbsql.DefaultLiteral(column, utils.QuoteLiteral)
 }
 
 // GetRedshiftColumnString returns a string describing the column in Redshift, e.g.
-// "id INT NOT NULL DEFAULT '0'"
+// `"id" INT NOT NULL DEFAULT '0'`
 // Refer to:
 // https://dev.mysql.com/doc/refman/8.0/en/data-types.html
 // https://docs.aws.amazon.com/redshift/latest/dg/c_Supported_data_types.html
-func GetRedshiftColumnString(column cloudstorage.TableCol) (string, error) {
+func (g Generator) GetRedshiftColumnString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
 	var sb strings.Builder
-	typeStr, err := GetRedshiftTypeString(column)
+	typeStr, err := g.GetRedshiftTypeString(column, columnTypes)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -92,7 +181,7 @@ func GetRedshiftColumnString(column cloudstorage.TableCol) (string, error) {
 		sb.WriteString(" NOT NULL")
 	}
 	if column.Default != nil {
-		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column.Default)))
+		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column)))
 	} else if column.Nullable == "true" {
 		sb.WriteString(" DEFAULT NULL")
 	}
@@ -1,14 +1,25 @@
 package redshiftsql_test
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
 	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"github.com/stretchr/testify/require"
 )
 
 func TestGenDDLViaColumnsDiff(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
 	prevColumns := []cloudstorage.TableCol{
 		{
 			ID:   "2",
@@ -50,12 +61,209 @@ func TestGenDDLViaColumnsDiff(t *testing.T) {
 	}
 
 	expectedDDLs := []string{
-		"ALTER TABLE test_table RENAME COLUMN name TO color;",
-		"ALTER TABLE test_table DROP COLUMN age;",
-		"ALTER TABLE test_table ADD COLUMN gender VARCHAR(10);",
+		`ALTER TABLE "test_table" RENAME COLUMN "name" TO "color";`,
+		`ALTER TABLE "test_table" DROP COLUMN "age";`,
+		`ALTER TABLE "test_table" ADD COLUMN "gender" VARCHAR(10);`,
 	}
 
-	ddl, err := redshiftsql.GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	ddl, err := gen.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil, deletemode.Hard)
 	require.NoError(t, err)
 	require.ElementsMatch(t, expectedDDLs, ddl)
 }
+
+func TestGenDDLViaColumnsDiffQuoteIdent(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "order",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE `order` (`select` INT PRIMARY KEY, `名称` VARCHAR(20), `a\"b` INT)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "select", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "名称", Tp: "varchar", Precision: "20"},
+			{ID: "3", Name: `a"b`, Tp: "int"},
+		},
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`DROP TABLE IF EXISTS "order"`, `CREATE TABLE "order" (
+    "select" INT NOT NULL,
+    "名称" VARCHAR(20),
+    "a""b" INT,
+    PRIMARY KEY ("select")
+)
+DISTSTYLE KEY DISTKEY ("select") COMPOUND SORTKEY ("select")`}, ddls)
+
+	prevColumns := tableDef.Columns
+	tableDef.Type = timodel.ActionModifyColumn
+	tableDef.Query = "ALTER TABLE `order` RENAME COLUMN `名称` TO `group`"
+	tableDef.Columns = slices.Clone(prevColumns)
+	tableDef.Columns[1].Name = "group"
+	ddls, err = gen.GenDDLViaColumnsDiff(prevColumns, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "order" RENAME COLUMN "名称" TO "group";`}, ddls)
+}
+
+func TestGetRedshiftTypeStringUnsigned(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	for tp, expected := range map[string]string{
+		"TINYINT UNSIGNED":   `"c" SMALLINT`,
+		"smallint unsigned":  `"c" INT`,
+		"MEDIUMINT UNSIGNED": `"c" INT`,
+		"INT UNSIGNED":       `"c" BIGINT`,
+		// 18446744073709551615, the max of BIGINT UNSIGNED, has 20 digits
+		"BIGINT UNSIGNED": `"c" DECIMAL(20, 0)`,
+		"DOUBLE UNSIGNED": `"c" FLOAT`,
+		"BIGINT":          `"c" BIGINT`,
+	} {
+		actual, err := gen.GetRedshiftTypeString(cloudstorage.TableCol{Name: "c", Tp: tp}, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, tp)
+	}
+}
+
+func TestGenDDLViaColumnsDiffChanges(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	expected := map[string]struct {
+		ddls []string
+		err  string
+	}{
+		"add column":              {ddls: []string{`ALTER TABLE "t" ADD COLUMN "email" VARCHAR(64);`}},
+		"add not null column":     {ddls: []string{`ALTER TABLE "t" ADD COLUMN "score" INT NOT NULL DEFAULT 0;`}},
+		"add column with default": {ddls: []string{`ALTER TABLE "t" ADD COLUMN "level" INT NOT NULL DEFAULT 1;`}},
+		// the zero value of DATE in TiDB is not a date of Redshift
+		"add not null date column":               {ddls: []string{`ALTER TABLE "t" ADD COLUMN "born" DATE DEFAULT NULL;`}},
+		"add column with quoted default":         {ddls: []string{`ALTER TABLE "t" ADD COLUMN "note" VARCHAR(20) NOT NULL DEFAULT 'it\'s \\ ok';`}},
+		"add column with numeric string default": {ddls: []string{`ALTER TABLE "t" ADD COLUMN "code" VARCHAR(3) NOT NULL DEFAULT '007';`}},
+		"drop column":                            {ddls: []string{`ALTER TABLE "t" DROP COLUMN "age";`}},
+		"rename column":                          {ddls: []string{`ALTER TABLE "t" RENAME COLUMN "name" TO "nickname";`}},
+		"widen varchar": {ddls: []string{
+			`ALTER TABLE "t" ALTER COLUMN "name" TYPE VARCHAR(100);`,
+			// MODIFY COLUMN without COMMENT clears the comment
+			`COMMENT ON COLUMN "t"."name" IS '';`,
+		}},
+		"int to bigint":  {err: "column age from INT to BIGINT, which is not supported by Redshift"},
+		"varchar to int": {err: "column name from VARCHAR(20) to INT, which is not supported by Redshift"},
+		"truncate table": {ddls: []string{`TRUNCATE TABLE "t"`}},
+		"drop table":     {ddls: []string{`DROP TABLE "t"`}},
+	}
+	for _, change := range ddltest.Changes() {
+		t.Run(change.Name, func(t *testing.T) {
+			ddls, err := gen.GenDDLViaColumnsDiff(change.PrevColumns, change.TableDef, nil, deletemode.Hard)
+			if expected[change.Name].err != "" {
+				require.ErrorContains(t, err, expected[change.Name].err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, expected[change.Name].ddls, ddls)
+		})
+	}
+}
+
+func TestGenDDLViaColumnsDiffModifyNullability(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	prevColumns := []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "varchar", Precision: "10"}}
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "t",
+		Type:    timodel.ActionModifyColumn,
+		Query:   "ALTER TABLE t MODIFY COLUMN v VARCHAR(10) NOT NULL COMMENT 'v'",
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "v", Tp: "varchar", Precision: "10", Nullable: "false"}},
+	}
+	// the column stays nullable in Redshift
+	ddls, err := gen.GenDDLViaColumnsDiff(prevColumns, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`COMMENT ON COLUMN "t"."v" IS 'v';`}, ddls)
+
+	_, err = gen.GenDDLViaColumnsDiff(tableDef.Columns, cloudstorage.TableDefinition{Table: "t", Type: timodel.ActionModifyColumn, Columns: prevColumns}, nil, deletemode.Hard)
+	require.ErrorContains(t, err, "column v dropping NOT NULL")
+}
+
+// recordingExecer records the statements instead of executing them
+type recordingExecer struct {
+	queries []string
+}
+
+func (e *recordingExecer) Exec(query string, _ ...any) (sql.Result, error) {
+	e.queries = append(e.queries, query)
+	return driver.RowsAffected(0), nil
+}
+
+func TestIdentifierCase(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "UserEvents",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "UserID", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "event_name", Tp: "varchar", Precision: "20"},
+		},
+	}
+	merge := func() string {
+		db := &recordingExecer{}
+		source := gen.ExternalTableRef("increment_external_UserEvents")
+		require.NoError(t, gen.DeleteQuery(db, tableDef, source, nil, "", deletemode.Hard, stagingformat.CSV))
+		_, err := gen.InsertQuery(db, tableDef, source, nil, "", deletemode.Hard, stagingformat.CSV)
+		require.NoError(t, err)
+		return strings.Join(db.queries, "\n")
+	}
+
+	// the names are lowercased by default, as Redshift folds them
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`DROP TABLE IF EXISTS "userevents"`, `CREATE TABLE "userevents" (
+    "userid" INT NOT NULL,
+    "event_name" VARCHAR(20),
+    PRIMARY KEY ("userid")
+)
+DISTSTYLE KEY DISTKEY ("userid") COMPOUND SORTKEY ("userid")`}, ddls)
+	query := merge()
+	require.Contains(t, query, `DELETE FROM "userevents" USING`)
+	require.Contains(t, query, `FROM "increment_external_userevents_schema"."increment_external_userevents"`)
+	require.Contains(t, query, `"userevents"."userid" = `)
+	require.NotContains(t, query, "UserID")
+
+	// the names are kept with enable_case_sensitive_identifier
+	gen = redshiftsql.NewGenerator(identcase.Preserve)
+	ddls, err = gen.GenDDLViaColumnsDiff(nil, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "UserEvents" (
+    "UserID" INT NOT NULL,
+    "event_name" VARCHAR(20),
+    PRIMARY KEY ("UserID")
+)
+DISTSTYLE KEY DISTKEY ("UserID") COMPOUND SORTKEY ("UserID")`, ddls[1])
+	prevColumns := tableDef.Columns
+	curTableDef := tableDef
+	curTableDef.Type = timodel.ActionAddColumn
+	curTableDef.Columns = append(slices.Clone(prevColumns), cloudstorage.TableCol{ID: "3", Name: "CreatedAt", Tp: "int"})
+	ddls, err = gen.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "UserEvents" ADD COLUMN "CreatedAt" INT;`}, ddls)
+	query = merge()
+	require.Contains(t, query, `"UserEvents"."UserID" = `)
+	require.Contains(t, query, `INSERT INTO "UserEvents"`)
+	require.Contains(t, query, `PARTITION BY "UserID"`)
+}
+
+func TestGenDDLViaColumnsDiffLongComment(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	// 21846 characters of 3 bytes exceed the 65535 bytes of a comment, the last character is dropped
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "t",
+		Type:    timodel.ActionModifyTableComment,
+		Query:   "ALTER TABLE t COMMENT = '" + strings.Repeat("订", 21846) + "'",
+		Columns: columns,
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(columns, tableDef, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`COMMENT ON TABLE "t" IS '` + strings.Repeat("订", 21845) + `';`}, ddls)
+}
+
+func TestDDLActionClasses(t *testing.T) {
+	connector := &redshiftsql.RedshiftConnector{}
+	require.Equal(t, ddltest.DefaultHandlings(map[string]tidbsql.DDLHandling{
+		"ALTER TABLE t ALTER COLUMN age SET DEFAULT 18": tidbsql.DDLHandlingSkip,
+	}), ddltest.Handlings(connector.DDLActionClasses()))
+}
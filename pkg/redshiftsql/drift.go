@@ -0,0 +1,138 @@
+package redshiftsql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// NormalizeRedshiftType returns the type by its canonical name in Redshift, so that the synonyms are equal,
+// e.g. INTEGER for INT and VARCHAR(256) for TEXT. The parameters omitted are the defaults of Redshift.
+func NormalizeRedshiftType(tp string) string {
+	name, params := tidbsql.SplitType(tp)
+	if !slices.ContainsFunc(params, func(p string) bool { return p != "" }) {
+		params = nil
+	}
+	param := func(i int, def string) string {
+		if i < len(params) {
+			return params[i]
+		}
+		return def
+	}
+	switch name {
+	case "INT", "INTEGER", "INT4":
+		return "INTEGER"
+	case "BIGINT", "INT8":
+		return "BIGINT"
+	case "SMALLINT", "INT2":
+		return "SMALLINT"
+	case "REAL", "FLOAT4":
+		return "REAL"
+	case "DOUBLE PRECISION", "FLOAT8", "FLOAT":
+		return "DOUBLE PRECISION"
+	case "NUMERIC", "DECIMAL":
+		return fmt.Sprintf("NUMERIC(%s,%s)", param(0, "18"), param(1, "0"))
+	case "VARCHAR", "CHARACTER VARYING", "NVARCHAR", "TEXT":
+		return fmt.Sprintf("VARCHAR(%s)", param(0, "256"))
+	case "CHAR", "CHARACTER", "NCHAR", "BPCHAR":
+		return fmt.Sprintf("CHAR(%s)", param(0, "1"))
+	case "VARBYTE", "VARBINARY", "BINARY VARYING":
+		return fmt.Sprintf("VARBYTE(%s)", param(0, "64000"))
+	case "BOOL", "BOOLEAN":
+		return "BOOLEAN"
+	case "TIMESTAMP", "TIMESTAMP WITHOUT TIME ZONE":
+		return "TIMESTAMP"
+	case "TIMESTAMPTZ", "TIMESTAMP WITH TIME ZONE":
+		return "TIMESTAMPTZ"
+	case "TIME", "TIME WITHOUT TIME ZONE":
+		return "TIME"
+	}
+	if len(params) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// getRedshiftColumnType returns the normalized type of the column in Redshift, empty if the type is not compared
+func (g Generator) getRedshiftColumnType(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	tp := strings.ToLower(column.Tp)
+	if _, ok := columnTypes.Lookup(column.Name); !ok && (tp == "enum" || tp == "set") && column.Precision == "" {
+		// the schema files do not give the length of ENUM and SET, which is known when the table is copied
+		return "", nil
+	}
+	typeStr, err := g.GetRedshiftTypeString(column, columnTypes)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return NormalizeRedshiftType(strings.TrimPrefix(typeStr, g.QuoteIdent(column.Name)+" ")), nil
+}
+
+// DriftedColumns returns the columns of the table in Redshift of the drift as TiDB columns, the previous columns of
+// GenDDLViaColumnsDiff. Redshift can only widen a VARCHAR, so a column drifted to another type fails.
+func DriftedColumns(drift *tidbsql.SchemaDrift) ([]cloudstorage.TableCol, error) {
+	expected := make(map[string]cloudstorage.TableCol, len(drift.Expected))
+	for _, column := range drift.Expected {
+		expected[column.ID] = column
+	}
+	columns := make([]cloudstorage.TableCol, 0, len(drift.Columns))
+	for _, column := range drift.Columns {
+		if after, ok := expected[column.ID]; ok && column.Tp != after.Tp {
+			// the column drifted in type has the type of Redshift
+			name, params := tidbsql.SplitType(column.Tp)
+			if name != "VARCHAR" || len(params) != 1 || !strings.EqualFold(after.Tp, "varchar") {
+				return nil, tidbsql.NewUnsupportedDDLError("column %s is %s in Redshift, which can not be altered to %s, "+
+					"Redshift can only widen a VARCHAR column", column.Name, column.Tp, after.Tp)
+			}
+			column.Tp, column.Precision = "varchar", params[0]
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// GetWarehouseColumns returns the columns of the table in the schema of Redshift with their normalized types
+func (g Generator) GetWarehouseColumns(db *sql.DB, schemaName, tableName string) ([]tidbsql.WarehouseColumn, error) {
+	query := `SELECT column_name, data_type, character_maximum_length, numeric_precision, numeric_scale, is_nullable
+FROM information_schema.columns
+WHERE table_schema = $1 AND table_name = $2
+ORDER BY ordinal_position`
+	rows, err := db.Query(query, g.identifierCase.Apply(schemaName), g.identifierCase.Apply(tableName))
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var columns []tidbsql.WarehouseColumn
+	for rows.Next() {
+		var name, dataType, isNullable string
+		var charLength, numPrecision, numScale sql.NullInt64
+		if err := rows.Scan(&name, &dataType, &charLength, &numPrecision, &numScale, &isNullable); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tp := dataType
+		switch {
+		case charLength.Valid:
+			tp = fmt.Sprintf("%s(%d)", dataType, charLength.Int64)
+		case strings.EqualFold(dataType, "numeric") && numPrecision.Valid:
+			tp = fmt.Sprintf("%s(%d,%d)", dataType, numPrecision.Int64, numScale.Int64)
+		}
+		columns = append(columns, tidbsql.WarehouseColumn{
+			Name:     name,
+			Type:     NormalizeRedshiftType(tp),
+			Nullable: isNullable == "YES",
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(columns) == 0 {
+		return nil, errors.Annotatef(tidbsql.ErrWarehouseTableNotFound, "table %s is not found in Redshift", tableName)
+	}
+	return columns, nil
+}
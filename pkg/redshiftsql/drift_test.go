@@ -0,0 +1,73 @@
+package redshiftsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeRedshiftType(t *testing.T) {
+	for _, synonyms := range [][]string{
+		{"INTEGER", "int4", "INT"},
+		{"DOUBLE PRECISION", "float8", "FLOAT"},
+		{"NUMERIC(20,0)", "NUMERIC(20)", "decimal(20, 0)"},
+		{"NUMERIC(18,0)", "NUMERIC", "DECIMAL(, )"},
+		{"VARCHAR(256)", "TEXT", "character varying"},
+		{"VARCHAR(20)", "character varying(20)", "NVARCHAR(20)"},
+		{"CHAR(1)", "bpchar", "character(1)"},
+		{"VARBYTE(16)", "binary varying(16)", "VARBINARY(16)"},
+		{"TIMESTAMP", "timestamp without time zone"},
+		{"BOOLEAN", "bool"},
+	} {
+		for _, tp := range synonyms {
+			require.Equal(t, synonyms[0], redshiftsql.NormalizeRedshiftType(tp), tp)
+		}
+	}
+}
+
+func TestReconcileSchemaDDLs(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "BIGINT", Nullable: "false"},
+		{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "20"},
+		{ID: "3", Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}
+	actual := []tidbsql.WarehouseColumn{
+		{Name: "id", Type: "BIGINT"},
+		{Name: "name", Type: "INTEGER", Nullable: true},
+		{Name: "indexed", Type: "VARCHAR(256)", Nullable: true},
+	}
+	drift, err := tidbsql.GetSchemaDrift(expected, actual, func(column cloudstorage.TableCol) (string, error) {
+		tp, err := gen.GetRedshiftTypeString(column, nil)
+		return redshiftsql.NormalizeRedshiftType(tp[len(gen.QuoteIdent(column.Name))+1:]), err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "column amount: missing in the data warehouse, expected NUMERIC(10,2)\n"+
+		"column indexed: VARCHAR(256) in the data warehouse, not expected\n"+
+		"column name: INTEGER in the data warehouse, expected VARCHAR(20)", drift.String())
+	_, err = redshiftsql.DriftedColumns(drift)
+	require.ErrorContains(t, err, "column name is INTEGER in Redshift, which can not be altered to VARCHAR")
+	require.True(t, tidbsql.IsUnsupportedDDL(err))
+
+	// a VARCHAR is widened
+	actual[1].Type = "VARCHAR(10)"
+	drift, err = tidbsql.GetSchemaDrift(expected, actual, func(column cloudstorage.TableCol) (string, error) {
+		tp, err := gen.GetRedshiftTypeString(column, nil)
+		return redshiftsql.NormalizeRedshiftType(tp[len(gen.QuoteIdent(column.Name))+1:]), err
+	})
+	require.NoError(t, err)
+	prevColumns, err := redshiftsql.DriftedColumns(drift)
+	require.NoError(t, err)
+	ddls, err := gen.GenDDLViaColumnsDiff(prevColumns, cloudstorage.TableDefinition{Table: "t", Columns: drift.Expected}, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		`ALTER TABLE "t" ADD COLUMN "amount" DECIMAL(10, 2);`,
+		`ALTER TABLE "t" DROP COLUMN "indexed";`,
+		`ALTER TABLE "t" ALTER COLUMN "name" TYPE VARCHAR(20);`,
+	}, ddls)
+}
@@ -0,0 +1,28 @@
+package redshiftsql
+
+import (
+	stderrors "errors"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+)
+
+// serializableViolation is the message of the error 1023 of Redshift, two transactions writing the same table
+// concurrently, which has no SQLSTATE of its own
+const serializableViolation = "serializable isolation violation"
+
+// IsRetryableError tells whether the statement which failed with err may succeed if it is run again, e.g. a
+// serializable isolation violation with a concurrent transaction on the table
+func IsRetryableError(err error) bool {
+	var pqErr *pq.Error
+	if stderrors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "40", "53":
+			// the connection exceptions, the transaction rollbacks and the insufficient resources
+			return true
+		}
+		return pqErr.Code == "57P01" || strings.Contains(strings.ToLower(pqErr.Message), serializableViolation)
+	}
+	return retry.IsTransient(err)
+}
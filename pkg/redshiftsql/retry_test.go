@@ -0,0 +1,18 @@
+package redshiftsql_test
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	violation := &pq.Error{Code: "XX000", Message: "1023: Serializable isolation violation on table - 123, transactions forming the cycle are: 1, 2"}
+	require.True(t, redshiftsql.IsRetryableError(errors.Trace(diag.WrapSQL(violation, "INSERT INTO t"))))
+	require.True(t, redshiftsql.IsRetryableError(&pq.Error{Code: "40001"}))
+	require.False(t, redshiftsql.IsRetryableError(&pq.Error{Code: "42703", Message: `column "c" does not exist`}))
+}
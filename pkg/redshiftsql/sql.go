@@ -4,6 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"strings"
 
@@ -16,72 +22,251 @@ import (
 	"go.uber.org/zap"
 )
 
-func CreateSchema(db *sql.DB, schemaName string) error {
-	sql := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", schemaName)
-	_, err := db.Exec(sql)
-	if err != nil {
-		return errors.Trace(err)
+// Generator generates the statements of Redshift, the names of the schemas, the tables and the columns are quoted in
+// its identifier case
+type Generator struct {
+	identifierCase identcase.Case
+	// skipComments leaves the tables and the columns without comments
+	skipComments bool
+}
+
+// NewGenerator returns the generator writing the names in the case, identcase.Lower by default
+func NewGenerator(identifierCase identcase.Case) Generator {
+	return Generator{identifierCase: identifierCase}
+}
+
+// WithSyncComments returns the generator replicating the comments of the tables and the columns or not
+func (g Generator) WithSyncComments(sync bool) Generator {
+	g.skipComments = !sync
+	return g
+}
+
+// QuoteIdent quotes the name of a schema, a table or a column by double quotes in the identifier case, the double
+// quotes in the name are escaped. Redshift folds the quoted names to lowercase unless enable_case_sensitive_identifier
+// is set, so the names are kept in upper case or as is only with it.
+func (g Generator) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(g.identifierCase.Apply(name), `"`, `""`) + `"`
+}
+
+// quoteIdents quotes the names and joins them by commas
+func (g Generator) quoteIdents(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, g.QuoteIdent(name))
 	}
-	sql = fmt.Sprintf("SET search_path TO %s", schemaName)
-	_, err = db.Exec(sql)
-	return err
+	return strings.Join(quoted, ", ")
+}
+
+// UseSchema creates the schema if create and it does not exist, otherwise it fails if the schema does not exist,
+// and sets it as the search path
+func (g Generator) UseSchema(db *sql.DB, schemaName string, create bool) error {
+	if create {
+		sql := fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", g.QuoteIdent(schemaName))
+		if _, err := db.Exec(sql); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, sql), "Failed to create schema")
+		}
+	} else {
+		// HAS_SCHEMA_PRIVILEGE fails if the schema does not exist
+		sql := "SELECT HAS_SCHEMA_PRIVILEGE($1, 'USAGE')"
+		if _, err := db.Exec(sql, g.identifierCase.Apply(schemaName)); err != nil {
+			return errors.Annotatef(diag.WrapSQL(err, sql), "Redshift schema %s is not found, create it or set --create-target-schema", schemaName)
+		}
+	}
+	sql := fmt.Sprintf("SET search_path TO %s", g.QuoteIdent(schemaName))
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
 }
 
 // LoadSnapshotFromS3 redshift currently can not support ROWS_PRODUCED function
-// use csv file path for storageUri, like s3://tidbbucket/snapshot/stock.csv
-func LoadSnapshotFromS3(db *sql.DB, targetTable, storageUri, filePrefix string, credential *credentials.Value, onSnapshotLoadProgress func(loadedRows int64)) error {
+// manifestUrl is the manifest listing the csv files, like s3://tidbbucket/snapshot/stock.snapshot.manifest
+// region is required if the bucket is not in the same region as the cluster, empty means the same region.
+// The COPY is run on conn so that the files committed by it can be queried by GetCopyCommittedFiles. The fields are
+// copied into the columns in order, all the columns of the table if columns is empty, the other columns get their
+// default values. The files of the manifest are of the format, the Parquet files are never compressed as a whole.
+// authorization is the clause of CopyAuthorization.
+func (g Generator) LoadSnapshotFromS3(ctx context.Context, conn *sql.Conn, targetTable string, columns []string, manifestUrl, region string, compression utils.Compression, format stagingformat.Format, authorization string, onSnapshotLoadProgress func(loadedRows int64)) error {
+	regionClause := ""
+	if region != "" {
+		regionClause = fmt.Sprintf("\n\tREGION '%s'", utils.EscapeString(region))
+	}
+	target := g.QuoteIdent(targetTable)
+	if len(columns) > 0 {
+		target += fmt.Sprintf(" (%s)", g.quoteIdents(columns))
+	}
 	sql, err := formatter.Format(`
 	COPY {targetTable}
-	FROM '{storageUrl}/{filePrefix}'
-	CREDENTIALS 'aws_access_key_id={accessId};aws_secret_access_key={accessKey}'
-	FORMAT AS CSV DELIMITER ',' QUOTE '"';
+	FROM '{manifestUrl}'
+	{authorization}{region}
+	MANIFEST
+	FORMAT AS {format};
 	`, formatter.Named{
-		"targetTable": utils.EscapeString(targetTable),
-		"storageUrl":  utils.EscapeString(storageUri),
-		"filePrefix":  utils.EscapeString(filePrefix), // TODO: Verify
-		"accessId":    credential.AccessKeyID,
-		"accessKey":   credential.SecretAccessKey,
+		"targetTable":   target,
+		"manifestUrl":   utils.EscapeString(manifestUrl),
+		"authorization": authorization,
+		"region":        regionClause,
+		"format":        copyFormatClause(compression, format),
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Loading snapshot data from external table", zap.String("query", sql))
-	_, err = db.Exec(sql)
-	return err
+	log.Info("Loading snapshot data from external table", zap.String("query", diag.RedactSecrets(sql)))
+	_, err = conn.ExecContext(ctx, sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// CopyAuthorization returns the clause COPY reads the files of S3 by: the IAM role of --redshift.role if it is
+// given, so that no key is embedded in the statement, or the credentials resolved by the provider, including the
+// session token of temporary credentials. The credentials are resolved for each COPY so that they are rotated.
+func CopyAuthorization(iamRole string, provider *credentials.Credentials) (string, error) {
+	if iamRole != "" {
+		return fmt.Sprintf("IAM_ROLE '%s'", utils.EscapeString(iamRole)), nil
+	}
+	if provider == nil {
+		return "", errors.New("no IAM role or AWS credentials to copy the files of S3 by")
+	}
+	value, err := provider.Get()
+	if err != nil {
+		return "", diag.Storage(errors.Annotate(err, "Failed to resolve the AWS credentials to copy the files of S3 by"))
+	}
+	clause := fmt.Sprintf("aws_access_key_id=%s;aws_secret_access_key=%s", value.AccessKeyID, value.SecretAccessKey)
+	if value.SessionToken != "" {
+		clause += ";token=" + value.SessionToken
+	}
+	return fmt.Sprintf("CREDENTIALS '%s'", utils.EscapeString(clause)), nil
+}
+
+// copyFormatClause returns the format of the files copied by COPY, the Parquet files are never compressed as a whole
+func copyFormatClause(compression utils.Compression, format stagingformat.Format) string {
+	if format == stagingformat.Parquet {
+		return "PARQUET"
+	}
+	formatClause := `CSV DELIMITER ',' QUOTE '"'`
+	if compression != utils.CompressionNone {
+		formatClause += " " + strings.ToUpper(string(compression))
+	}
+	return formatClause
+}
+
+// GetCopyCommittedFiles returns the files committed by the last COPY of the connection as recorded by stl_load_commits
+func GetCopyCommittedFiles(ctx context.Context, conn *sql.Conn) ([]string, error) {
+	query := "SELECT TRIM(filename) FROM stl_load_commits WHERE query = pg_last_copy_id()"
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var files []string
+	for rows.Next() {
+		var file string
+		if err = rows.Scan(&file); err != nil {
+			return nil, diag.WrapSQL(err, query)
+		}
+		files = append(files, file)
+	}
+	return files, diag.WrapSQL(rows.Err(), query)
 }
 
-func DropTable(sourceTable string, db *sql.DB) error {
-	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s", sourceTable)
+// maxLoadCommitFilename is the length of stl_load_commits.filename, longer names are truncated
+const maxLoadCommitFilename = 256
+
+// MissingCommittedFiles returns the urls not committed, committed are the file names of stl_load_commits
+func MissingCommittedFiles(urls, committed []string) []string {
+	committedSet := make(map[string]struct{}, len(committed))
+	for _, file := range committed {
+		committedSet[file] = struct{}{}
+	}
+	var missing []string
+	for _, url := range urls {
+		name := url
+		if len(name) > maxLoadCommitFilename {
+			name = name[:maxLoadCommitFilename]
+		}
+		if _, ok := committedSet[name]; !ok {
+			missing = append(missing, url)
+		}
+	}
+	return missing
+}
+
+func (g Generator) DropTable(tableName string, db *sql.DB) error {
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s", g.QuoteIdent(tableName))
 	log.Info("Dropping table in Redshift if exists", zap.String("query", sql))
 	_, err := db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
-func CreateTable(sourceDatabase string, sourceTable string, sourceTiDBConn, redConn *sql.DB) error {
+// CreateTable creates targetTable by the columns of the TiDB table retained by columnFilter and the tombstone
+// columns of the delete mode. The primary key is the dedup key if the TiDB table has none.
+func (g Generator) CreateTable(sourceDatabase, sourceTable, targetTable string, sourceTiDBConn, redConn *sql.DB, override *TableProperties, columnTypes columnmapping.Columns, columnFilter *columnfilter.Filter, deleteMode deletemode.Mode, dedupKey []string) error {
 	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	tableColumns = columnFilter.Columns(tableColumns)
+	redshiftPKColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(redshiftPKColumns) == 0 {
+		redshiftPKColumns = dedupKey
+	}
+	props, err := ResolveTableProperties(tableColumns, redshiftPKColumns, override)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to resolve table properties of %s.%s", sourceDatabase, sourceTable)
+	}
+
+	query, err := g.GenCreateTableSQL(targetTable, tableColumns, redshiftPKColumns, props, columnTypes, deleteMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Creating table in Redshift", zap.String("query", query))
+	if _, err = redConn.Exec(query); err != nil {
+		return diag.WrapSQL(err, query)
+	}
+
+	// Redshift does not support comments in CREATE TABLE
+	comments := &tidbsql.TableComments{}
+	if !g.skipComments {
+		if comments, err = tidbsql.GetTiDBTableComments(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	commentQueries := make([]string, 0, len(comments.Columns)+1)
+	if comments.Table != "" {
+		commentQueries = append(commentQueries, g.genTableComment(targetTable, comments.Table))
+	}
+	for _, column := range tableColumns {
+		if comment, ok := comments.Columns[column.Name]; ok {
+			commentQueries = append(commentQueries, g.genColumnComment(targetTable, column.Name, comment))
+		}
+	}
+	for _, query := range commentQueries {
+		if _, err = redConn.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to set comment")
+		}
+	}
+	return nil
+}
+
+// GenCreateTableSQL generates the CREATE TABLE statement with the distribution and sort keys, the tombstone columns
+// of the delete mode follow the columns
+func (g Generator) GenCreateTableSQL(tableName string, tableColumns []cloudstorage.TableCol, pkColumns []string, props TableProperties, columnTypes columnmapping.Columns, deleteMode deletemode.Mode) (string, error) {
 	columnRows := make([]string, 0, len(tableColumns))
 	for _, column := range tableColumns {
-		row, err := GetRedshiftColumnString(column)
+		row, err := g.GetRedshiftColumnString(column, columnTypes)
 		if err != nil {
-			return errors.Trace(err)
+			return "", errors.Trace(err)
 		}
 		columnRows = append(columnRows, row)
 	}
-
-	redshiftPKColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
-	if err != nil {
-		return errors.Trace(err)
-	}
+	columnRows = append(columnRows, deleteMode.ColumnDefs(g.QuoteIdent, "BOOLEAN DEFAULT FALSE", "TIMESTAMP")...)
 
 	// TODO: Support unique key
 
 	sqlRows := make([]string, 0, len(columnRows)+1)
 	sqlRows = append(sqlRows, columnRows...)
-	if len(redshiftPKColumns) > 0 {
-		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(redshiftPKColumns, ", ")))
+	if len(pkColumns) > 0 {
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", g.quoteIdents(pkColumns)))
 	}
 	// Add idents
 	for i := 0; i < len(sqlRows); i++ {
@@ -89,17 +274,27 @@ func CreateTable(sourceDatabase string, sourceTable string, sourceTiDBConn, redC
 	}
 
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, sourceTable)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE TABLE %s (`, g.QuoteIdent(tableName)))
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
 	sql = append(sql, ")")
+	sql = append(sql, g.genTablePropertiesClause(props))
+	return strings.Join(sql, "\n"), nil
+}
 
-	query := strings.Join(sql, "\n")
-	log.Info("Creating table in Redshift", zap.String("query", query))
-	_, err = redConn.Exec(query)
-	return err
+// maxCommentBytes is the limit of bytes of a comment, the length of the longest VARCHAR
+const maxCommentBytes = redshiftMaxVarcharLength
+
+func (g Generator) genTableComment(tableName, comment string) string {
+	comment = tidbsql.TruncateCommentBytes(comment, maxCommentBytes, tableName)
+	return fmt.Sprintf("COMMENT ON TABLE %s IS %s;", g.QuoteIdent(tableName), utils.QuoteLiteral(comment))
+}
+
+func (g Generator) genColumnComment(tableName, columnName, comment string) string {
+	comment = tidbsql.TruncateCommentBytes(comment, maxCommentBytes, columnName)
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s;", g.QuoteIdent(tableName), g.QuoteIdent(columnName), utils.QuoteLiteral(comment))
 }
 
-func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string) error {
+func (g Generator) CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string) error {
 	sql, err := formatter.Format(`
 	CREATE EXTERNAL SCHEMA IF NOT EXISTS {schemaName}
 	FROM DATA CATALOG
@@ -107,7 +302,7 @@ func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string)
 	IAM_ROLE '{iamRole}'
 	CREATE EXTERNAL DATABASE IF NOT EXISTS;
 	`, formatter.Named{
-		"schemaName":   utils.EscapeString(schemaName),
+		"schemaName":   g.QuoteIdent(schemaName),
 		"databaseName": utils.EscapeString(databaseName),
 		"iamRole":      utils.EscapeString(iamRole),
 	})
@@ -118,38 +313,33 @@ func CreateExternalSchema(db *sql.DB, schemaName, databaseName, iamRole string)
 	ctx := context.Background()
 	_, err = db.ExecContext(ctx, sql)
 
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
 // Redshift external table does not support NOT NULL or PRIMARY KEY
-func CreateExternalTable(db *sql.DB, columns []cloudstorage.TableCol, tableName, schemaName, manifestFile string) error {
-	columnRows := make([]string, 0, len(columns))
-	for _, column := range columns {
-		row, err := GetRedshiftTypeString(column)
-		if err != nil {
-			return errors.Trace(err)
-		}
-		columnRows = append(columnRows, row)
+// The columns are typed as the table so that the values are inserted without casting, except the BIT columns of the
+// CSV files read as text and converted by castField. The files of the manifest are of the format, the commit ts of
+// the Parquet files is a BIGINT.
+func (g Generator) CreateExternalTable(db *sql.DB, columns []cloudstorage.TableCol, tableName, schemaName, manifestFile string, columnTypes columnmapping.Columns, format stagingformat.Format) error {
+	columnRows, err := g.incrementFileColumns(columns, columnTypes, format)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fileFormat := "ROW FORMAT DELIMITED\n\tFIELDS TERMINATED by ','\n\tLINES TERMINATED BY '\\n'"
+	if format == stagingformat.Parquet {
+		fileFormat = "STORED AS PARQUET"
 	}
-	sqlRows := make([]string, 0, len(columnRows)+1)
-	sqlRows = append(sqlRows, columnRows...)
-
 	sql, err := formatter.Format(`
 	CREATE EXTERNAL TABLE {schemaName}.{tableName} (
-		FLAG VARCHAR(10),
-		TABLENAME VARCHAR(255),
-		SCHEMANAME VARCHAR(255),
-		TIMESTAMP VARCHAR(255),
 		{columns}
 	)
-	ROW FORMAT DELIMITED
-	FIELDS TERMINATED by ','
-	LINES TERMINATED BY '\n'
+	{fileFormat}
 	LOCATION '{manifestFile}'
 	`, formatter.Named{
-		"tableName":    utils.EscapeString(tableName),
-		"schemaName":   utils.EscapeString(schemaName),
-		"columns":      strings.Join(sqlRows, ",\n"),
+		"tableName":    g.QuoteIdent(tableName),
+		"schemaName":   g.QuoteIdent(schemaName),
+		"columns":      strings.Join(columnRows, ",\n"),
+		"fileFormat":   fileFormat,
 		"manifestFile": utils.EscapeString(manifestFile),
 	})
 	if err != nil {
@@ -157,60 +347,191 @@ func CreateExternalTable(db *sql.DB, columns []cloudstorage.TableCol, tableName,
 	}
 	log.Info("Creating external table", zap.String("query", sql))
 	_, err = db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
-func DeleteQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, externalTableName string) error {
+// incrementFileColumns returns the definitions of the fields of the increment files of the format, the flag, the
+// table, the schema and the commit ts of the change followed by the columns
+func (g Generator) incrementFileColumns(columns []cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) ([]string, error) {
+	commitTsType := "VARCHAR(255)"
+	if format == stagingformat.Parquet {
+		commitTsType = "BIGINT"
+	}
+	columnRows := make([]string, 0, len(columns)+4)
+	columnRows = append(columnRows, "FLAG VARCHAR(10)", "TABLENAME VARCHAR(255)", "SCHEMANAME VARCHAR(255)", "TIMESTAMP "+commitTsType)
+	for _, column := range columns {
+		if _, ok := columnTypes.Lookup(column.Name); !ok && tidbsql.BitLength(column) > 0 && format != stagingformat.Parquet {
+			columnRows = append(columnRows, fmt.Sprintf("%s VARCHAR(20)", g.QuoteIdent(column.Name)))
+			continue
+		}
+		row, err := g.GetRedshiftTypeString(column, columnTypes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		columnRows = append(columnRows, row)
+	}
+	return columnRows, nil
+}
+
+// castField converts the column of the external table to the column of the table. TiCDC writes BIT as an
+// unsigned integer, BIT(1) is converted to a boolean, and a longer BIT to its bytes by the hex of the high and
+// low 32 bits, since TO_HEX takes a BIGINT. The other columns and the columns overridden by columnTypes are typed
+// as the table, as are the BIT columns of the Parquet files of the format, which have their bytes.
+func (g Generator) castField(col cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) string {
+	name := g.QuoteIdent(col.Name)
+	_, overridden := columnTypes.Lookup(col.Name)
+	switch length := tidbsql.BitLength(col); {
+	case length == 0 || overridden || format == stagingformat.Parquet:
+		return name
+	case length == 1:
+		return fmt.Sprintf("(%s = '1') AS %s", name, name)
+	default:
+		value := fmt.Sprintf("CAST(%s AS DECIMAL(20, 0))", name)
+		hex := fmt.Sprintf("LPAD(TO_HEX(CAST(TRUNC(%s / 4294967296) AS BIGINT)), 8, '0') || LPAD(TO_HEX(CAST(MOD(%s, 4294967296) AS BIGINT)), 8, '0')", value, value)
+		return fmt.Sprintf("TO_VARBYTE(RIGHT(%s, %d), 'hex') AS %s", hex, 2*tidbsql.BitBytes(length), name)
+	}
+}
+
+// execer runs the statements of a merge on the DB, or in the transaction of IncrementStrategyDeleteInsert
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// ExternalTableRef returns the external table of the name in its external schema, which is read by the merges of
+// IncrementStrategyMerge
+func (g Generator) ExternalTableRef(externalTableName string) string {
+	return fmt.Sprintf("%s.%s", g.QuoteIdent(fmt.Sprintf("%s_schema", externalTableName)), g.QuoteIdent(externalTableName))
+}
+
+// latestChangeOrder orders the changes of a key in the external table from the latest, the commit ts of the CSV
+// files is read as a string. DeleteQuery and InsertQuery must pick the same change of a key.
+var latestChangeOrder = utils.LatestChangeOrder("CAST(timestamp AS BIGINT)", "flag")
+
+// DeleteQuery deletes the rows of the keys changed, the rows not deleted are inserted again by InsertQuery. The rows
+// deleted in the soft delete mode are kept and marked deleted by MarkDeletedQuery instead, as are the rows not
+// matching where. The source is the external table or the temporary table holding the files of the format.
+func (g Generator) DeleteQuery(db execer, tableDef cloudstorage.TableDefinition, source string, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) error {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
 	selectStat = append(selectStat, `flag`)
 	for _, col := range tableDef.Columns {
-		selectStat = append(selectStat, col.Name)
+		selectStat = append(selectStat, g.castField(col, columnTypes, format))
 	}
 	pkColumn := make([]string, 0)
 	onStat := make([]string, 0)
 	for _, col := range tableDef.Columns {
 		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, tableDef.Table, col.Name, col.Name))
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
+			onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, g.QuoteIdent(tableDef.Table), g.QuoteIdent(col.Name), g.QuoteIdent(col.Name)))
+		}
+	}
+	if deleteMode == deletemode.Soft {
+		onStat = append(onStat, "S.flag != 'D'")
+		if where != "" {
+			onStat = append(onStat, fmt.Sprintf("COALESCE((%s), FALSE)", where))
 		}
 	}
 	sql, err := formatter.Format(`
 	DELETE FROM {tableName} USING (
 		SELECT
 		{selectStat}
-		FROM {externalSchema}.{externalTable} WHERE tablename IS NOT NULL
-		QUALIFY row_number() OVER (PARTITION BY {pkStat} ORDER BY timestamp DESC) = 1
+		FROM {source} WHERE tablename IS NOT NULL
+		QUALIFY row_number() OVER (PARTITION BY {pkStat} ORDER BY {orderBy}) = 1
 	) AS S
 	WHERE 
 		{onStat};
 	`, formatter.Named{
-		"tableName":      tableDef.Table,
-		"externalSchema": fmt.Sprintf("%s_schema", externalTableName),
-		"externalTable":  externalTableName,
-		"selectStat":     strings.Join(selectStat, ",\n"),
-		"pkStat":         strings.Join(pkColumn, ", "),
-		"onStat":         strings.Join(onStat, " AND "),
+		"tableName":  g.QuoteIdent(tableDef.Table),
+		"source":     source,
+		"selectStat": strings.Join(selectStat, ",\n"),
+		"pkStat":     strings.Join(pkColumn, ", "),
+		"orderBy":    latestChangeOrder,
+		"onStat":     strings.Join(onStat, " AND "),
 	})
 	if err != nil {
 		return errors.Trace(err)
 	}
 	log.Info("delete external table into table", zap.String("query", sql))
 	_, err = db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
+}
+
+// MarkDeletedQuery marks the rows of the keys deleted, or changed to not match where, deleted in the soft delete
+// mode. The time they are deleted is the time of the merge.
+func (g Generator) MarkDeletedQuery(db execer, tableDef cloudstorage.TableDefinition, source string, columnTypes columnmapping.Columns, where string, format stagingformat.Format) error {
+	selectStat := make([]string, 0, len(tableDef.Columns)+1)
+	selectStat = append(selectStat, `flag`)
+	for _, col := range tableDef.Columns {
+		selectStat = append(selectStat, g.castField(col, columnTypes, format))
+	}
+	pkColumn := make([]string, 0)
+	onStat := make([]string, 0)
+	for _, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
+			onStat = append(onStat, fmt.Sprintf(`%s.%s = S.%s`, g.QuoteIdent(tableDef.Table), g.QuoteIdent(col.Name), g.QuoteIdent(col.Name)))
+		}
+	}
+	deleteCond := "S.flag = 'D'"
+	if where != "" {
+		deleteCond = fmt.Sprintf("(%s OR NOT COALESCE((%s), FALSE))", deleteCond, where)
+	}
+	onStat = append(onStat, deleteCond)
+	sql, err := formatter.Format(`
+	UPDATE {tableName} SET {deleted} = TRUE, {deletedAt} = GETDATE() FROM (
+		SELECT
+		{selectStat}
+		FROM {source} WHERE tablename IS NOT NULL
+		QUALIFY row_number() OVER (PARTITION BY {pkStat} ORDER BY {orderBy}) = 1
+	) AS S
+	WHERE
+		{onStat};
+	`, formatter.Named{
+		"tableName":  g.QuoteIdent(tableDef.Table),
+		"deleted":    g.QuoteIdent(deletemode.DeletedColumn),
+		"deletedAt":  g.QuoteIdent(deletemode.DeletedAtColumn),
+		"source":     source,
+		"selectStat": strings.Join(selectStat, ",\n"),
+		"pkStat":     strings.Join(pkColumn, ", "),
+		"orderBy":    latestChangeOrder,
+		"onStat":     strings.Join(onStat, " AND "),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("mark deleted external table into table", zap.String("query", sql))
+	_, err = db.Exec(sql)
+	return diag.WrapSQL(err, sql)
 }
 
-func InsertQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, externalTableName string) error {
+// InsertQuery inserts the last version of the rows not deleted and returns the rows inserted. If where is
+// not empty, only the rows matching it are inserted, the rows changed are already deleted by DeleteQuery.
+// The rows inserted in the soft delete mode are not deleted.
+func (g Generator) InsertQuery(db execer, tableDef cloudstorage.TableDefinition, source string, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) (int64, error) {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
+	externalSelectStat := make([]string, 0, len(tableDef.Columns)+1)
 	for _, col := range tableDef.Columns {
-		selectStat = append(selectStat, col.Name)
+		selectStat = append(selectStat, g.QuoteIdent(col.Name))
+		externalSelectStat = append(externalSelectStat, g.castField(col, columnTypes, format))
+	}
+	tableName := g.QuoteIdent(tableDef.Table)
+	if deleteMode == deletemode.Soft {
+		insertStat := make([]string, 0, len(tableDef.Columns)+2)
+		insertStat = append(insertStat, selectStat...)
+		insertStat = append(insertStat, g.QuoteIdent(deletemode.DeletedColumn), g.QuoteIdent(deletemode.DeletedAtColumn))
+		tableName += fmt.Sprintf(" (%s)", strings.Join(insertStat, ", "))
+		selectStat = append(selectStat, "FALSE", "NULL")
 	}
 	pkColumn := make([]string, 0)
 
 	for _, col := range tableDef.Columns {
 		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
 		}
 	}
+	whereStat := "S.flag != 'D'"
+	if where != "" {
+		whereStat += fmt.Sprintf(" AND COALESCE((%s), FALSE)", where)
+	}
 	sql, err := formatter.Format(`
 	INSERT INTO {tableName}  
 	SELECT
@@ -218,36 +539,61 @@ func InsertQuery(db *sql.DB, tableDef cloudstorage.TableDefinition, externalTabl
 	FROM (
 	SELECT
 		flag, 
-		{selectStat}
-		FROM {externalSchema}.{externalTable} WHERE tablename IS NOT NULL
-		QUALIFY row_number() OVER (PARTITION BY {pkStat} ORDER BY timestamp DESC) = 1
+		{externalSelectStat}
+		FROM {source} WHERE tablename IS NOT NULL
+		QUALIFY row_number() OVER (PARTITION BY {pkStat} ORDER BY {orderBy}) = 1
 	) AS S
 	WHERE
-		S.flag != 'D'
+		{whereStat}
 	`, formatter.Named{
-		"tableName":      tableDef.Table,
-		"externalSchema": fmt.Sprintf("%s_schema", externalTableName),
-		"externalTable":  externalTableName,
-		"selectStat":     strings.Join(selectStat, ",\n"),
-		"pkStat":         strings.Join(pkColumn, ", "),
+		"tableName":          tableName,
+		"source":             source,
+		"selectStat":         strings.Join(selectStat, ",\n"),
+		"externalSelectStat": strings.Join(externalSelectStat, ",\n"),
+		"pkStat":             strings.Join(pkColumn, ", "),
+		"orderBy":            latestChangeOrder,
+		"whereStat":          whereStat,
 	})
 	if err != nil {
-		return errors.Trace(err)
+		return 0, errors.Trace(err)
 	}
 	log.Info("insert external table into table", zap.String("query", sql))
-	_, err = db.Exec(sql)
-	return err
+	res, err := db.Exec(sql)
+	if err != nil {
+		return 0, diag.WrapSQL(err, sql)
+	}
+	return utils.RowsAffected(res), nil
+}
+
+// GetTableColumns returns the names of the columns of the table in the schema in order, none if the table does
+// not exist
+func (g Generator) GetTableColumns(db *sql.DB, schemaName, tableName string) ([]string, error) {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position"
+	rows, err := db.Query(query, g.identifierCase.Apply(schemaName), g.identifierCase.Apply(tableName))
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return nil, errors.Trace(err)
+		}
+		columns = append(columns, column)
+	}
+	return columns, errors.Trace(rows.Err())
 }
 
-func DeleteTable(db *sql.DB, tableName, schemaName string) error {
-	sql := fmt.Sprintf("DROP TABLE %s.%s", tableName, schemaName)
+func (g Generator) DeleteTable(db *sql.DB, tableName, schemaName string) error {
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", g.QuoteIdent(tableName), g.QuoteIdent(schemaName))
 	log.Info("delete table", zap.String("query", sql))
 	_, err := db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
-func DropExternalSchema(db *sql.DB, schemaName string) error {
-	sql := fmt.Sprintf("DROP SCHEMA IF EXISTS %s DROP EXTERNAL DATABASE CASCADE", schemaName)
+func (g Generator) DropExternalSchema(db *sql.DB, schemaName string) error {
+	sql := fmt.Sprintf("DROP SCHEMA IF EXISTS %s DROP EXTERNAL DATABASE CASCADE", g.QuoteIdent(schemaName))
 	_, err := db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
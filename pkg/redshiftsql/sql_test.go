@@ -0,0 +1,74 @@
+package redshiftsql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingCommittedFiles(t *testing.T) {
+	longUrl := "s3://bucket/snapshot/" + strings.Repeat("a", 300) + ".csv"
+	urls := []string{"s3://bucket/snapshot/test.t.000000000.csv", "s3://bucket/snapshot/test.t.000000001.csv", longUrl}
+
+	// stl_load_commits truncates the file names to 256 characters
+	committed := []string{"s3://bucket/snapshot/test.t.000000000.csv", longUrl[:256]}
+	require.Equal(t, []string{"s3://bucket/snapshot/test.t.000000001.csv"}, redshiftsql.MissingCommittedFiles(urls, committed))
+	require.Empty(t, redshiftsql.MissingCommittedFiles(urls, append(committed, urls[1])))
+	require.Equal(t, urls, redshiftsql.MissingCommittedFiles(urls, nil))
+}
+
+func TestParseIncrementStrategy(t *testing.T) {
+	strategy, err := redshiftsql.ParseIncrementStrategy("")
+	require.NoError(t, err)
+	require.Equal(t, redshiftsql.IncrementStrategyMerge, strategy)
+	strategy, err = redshiftsql.ParseIncrementStrategy("Delete-Insert")
+	require.NoError(t, err)
+	require.Equal(t, redshiftsql.IncrementStrategyDeleteInsert, strategy)
+	_, err = redshiftsql.ParseIncrementStrategy("upsert")
+	require.ErrorContains(t, err, "invalid increment strategy upsert")
+}
+
+func TestCopyAuthorization(t *testing.T) {
+	provider := credentials.NewStaticCredentials("AKIA", "secret", "")
+	clause, err := redshiftsql.CopyAuthorization("arn:aws:iam::123456789012:role/copy", provider)
+	require.NoError(t, err)
+	require.Equal(t, "IAM_ROLE 'arn:aws:iam::123456789012:role/copy'", clause)
+
+	clause, err = redshiftsql.CopyAuthorization("", provider)
+	require.NoError(t, err)
+	require.Equal(t, "CREDENTIALS 'aws_access_key_id=AKIA;aws_secret_access_key=secret'", clause)
+
+	clause, err = redshiftsql.CopyAuthorization("", credentials.NewStaticCredentials("ASIA", "secret", "token"))
+	require.NoError(t, err)
+	require.Equal(t, "CREDENTIALS 'aws_access_key_id=ASIA;aws_secret_access_key=secret;token=token'", clause)
+
+	_, err = redshiftsql.CopyAuthorization("", nil)
+	require.Error(t, err)
+}
+
+func TestCopyIncrementFromS3Compression(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Preserve)
+	cases := []struct {
+		compression utils.Compression
+		format      stagingformat.Format
+		expected    string
+	}{
+		{utils.CompressionNone, stagingformat.CSV, `FORMAT AS CSV DELIMITER ',' QUOTE '"' NULL AS '\\N';`},
+		{utils.CompressionGzip, stagingformat.CSV, `FORMAT AS CSV DELIMITER ',' QUOTE '"' GZIP NULL AS '\\N';`},
+		{utils.CompressionZstd, stagingformat.CSV, `FORMAT AS CSV DELIMITER ',' QUOTE '"' ZSTD NULL AS '\\N';`},
+		// the Parquet files converted from the compressed CSV files are not compressed as a whole
+		{utils.CompressionGzip, stagingformat.Parquet, "FORMAT AS PARQUET;"},
+	}
+	for _, c := range cases {
+		execer := &recordingExecer{}
+		require.NoError(t, gen.CopyIncrementFromS3(execer, "increment_t", "s3://bucket/t.manifest", "", c.compression, c.format, "IAM_ROLE 'arn'"))
+		require.Len(t, execer.queries, 1)
+		require.Contains(t, execer.queries[0], c.expected, "%s %s", c.compression, c.format)
+	}
+}
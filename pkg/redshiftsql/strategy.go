@@ -0,0 +1,157 @@
+package redshiftsql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"gitlab.com/tymonx/go-formatter/formatter"
+	"go.uber.org/zap"
+)
+
+// IncrementStrategy is how the increment files are merged into the tables
+type IncrementStrategy string
+
+const (
+	// IncrementStrategyMerge reads each file by an external table, which requires Redshift Spectrum
+	IncrementStrategyMerge IncrementStrategy = "merge"
+	// IncrementStrategyDeleteInsert copies each file into a temporary table and merges it by DELETE and INSERT in a
+	// transaction, without Redshift Spectrum
+	IncrementStrategyDeleteInsert IncrementStrategy = "delete-insert"
+)
+
+// ParseIncrementStrategy parses the value of --redshift.increment-strategy, empty means IncrementStrategyMerge
+func ParseIncrementStrategy(s string) (IncrementStrategy, error) {
+	switch strategy := IncrementStrategy(strings.ToLower(s)); strategy {
+	case "":
+		return IncrementStrategyMerge, nil
+	case IncrementStrategyMerge, IncrementStrategyDeleteInsert:
+		return strategy, nil
+	}
+	return "", errors.Errorf("invalid increment strategy %s, expected merge or delete-insert", s)
+}
+
+// incrementTempTable is the temporary table an increment file is copied into by IncrementStrategyDeleteInsert,
+// a temporary table is of the session so the tables of different connectors do not collide
+const incrementTempTable = "tidb2dw_increment"
+
+// CreateIncrementTempTable creates the temporary table the increment file of the format is copied into, its columns
+// are those of the external table of IncrementStrategyMerge
+func (g Generator) CreateIncrementTempTable(db execer, columns []cloudstorage.TableCol, tableName string, columnTypes columnmapping.Columns, format stagingformat.Format) error {
+	columnRows, err := g.incrementFileColumns(columns, columnTypes, format)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sql := fmt.Sprintf("CREATE TEMP TABLE %s (\n\t%s\n)", g.QuoteIdent(tableName), strings.Join(columnRows, ",\n\t"))
+	log.Info("Creating increment temporary table", zap.String("query", sql))
+	_, err = db.Exec(sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// CopyIncrementFromS3 copies the increment file listed by the manifest into the table, TiCDC writes NULL as \N.
+// region is required if the bucket is not in the same region as the cluster, empty means the same region.
+// authorization is the clause of CopyAuthorization.
+func (g Generator) CopyIncrementFromS3(db execer, tableName, manifestUrl, region string, compression utils.Compression, format stagingformat.Format, authorization string) error {
+	regionClause := ""
+	if region != "" {
+		regionClause = fmt.Sprintf("\n\tREGION '%s'", utils.EscapeString(region))
+	}
+	formatClause := copyFormatClause(compression, format)
+	if format != stagingformat.Parquet {
+		formatClause += ` NULL AS '\\N'`
+	}
+	sql, err := formatter.Format(`
+	COPY {tableName}
+	FROM '{manifestUrl}'
+	{authorization}{region}
+	MANIFEST
+	FORMAT AS {format};
+	`, formatter.Named{
+		"tableName":     g.QuoteIdent(tableName),
+		"manifestUrl":   utils.EscapeString(manifestUrl),
+		"authorization": authorization,
+		"region":        regionClause,
+		"format":        formatClause,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Copying increment file into temporary table", zap.String("manifest", manifestUrl))
+	_, err = db.Exec(sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// loadIncrementByTempTable merges the file by IncrementStrategyDeleteInsert: the file is copied into a temporary
+// table, then the rows of the keys changed are deleted and the latest changes not deleted are inserted. All of them
+// and the applied batch are in one transaction, so a failed attempt leaves nothing behind, not even the temporary
+// table, and a replay finds the batch recorded exactly when the file is merged.
+func (rc *RedshiftConnector) loadIncrementByTempTable(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+	manifestFilePath := fmt.Sprintf("%s://%s%s/%s.manifest", uri.Scheme, uri.Host, uri.Path, strings.TrimSuffix(filePath, filepath.Ext(filePath)))
+	format := stagingformat.FileFormat(filePath)
+	mergedTableDef := rc.columnFilter.TableDef(rc.routeTableDef(tableDef))
+	if rc.appliedBatch != nil {
+		if err := rc.ensureAppliedBatchTable(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	source := rc.gen.QuoteIdent(incrementTempTable)
+	var rows int64
+	authorization, err := CopyAuthorization(rc.iamRole, rc.s3Credentials)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = rc.inTx(func(tx *sql.Tx) error {
+		if err := rc.gen.CreateIncrementTempTable(tx, tableDef.Columns, incrementTempTable, rc.columnTypes, format); err != nil {
+			return errors.Trace(err)
+		}
+		if err := rc.gen.CopyIncrementFromS3(tx, incrementTempTable, manifestFilePath, uri.Query().Get("region"), rc.compression, format, authorization); err != nil {
+			return errors.Trace(err)
+		}
+		if rc.deleteMode == deletemode.Soft {
+			if err := rc.gen.MarkDeletedQuery(tx, mergedTableDef, source, rc.columnTypes, rc.where, format); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if err := rc.gen.DeleteQuery(tx, mergedTableDef, source, rc.columnTypes, rc.where, rc.deleteMode, format); err != nil {
+			return errors.Trace(err)
+		}
+		var err error
+		if rows, err = rc.gen.InsertQuery(tx, mergedTableDef, source, rc.columnTypes, rc.where, rc.deleteMode, format); err != nil {
+			return errors.Trace(err)
+		}
+		if err = rc.recordAppliedBatch(tx, mergedTableDef.Table, filePath); err != nil {
+			return errors.Trace(err)
+		}
+		query := fmt.Sprintf("DROP TABLE %s", source)
+		_, err = tx.Exec(query)
+		return diag.WrapSQL(err, query)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rc.mergedRows += rows
+	log.Info("Successfully merge file", zap.String("file", filePath))
+	return nil
+}
+
+func (rc *RedshiftConnector) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := rc.db.Begin()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return errors.Trace(tx.Commit())
+}
@@ -0,0 +1,236 @@
+package redshiftsql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+const (
+	DistStyleAuto = "AUTO"
+	DistStyleEven = "EVEN"
+	DistStyleAll  = "ALL"
+	DistStyleKey  = "KEY"
+)
+
+// TableProperties are the distribution and sort keys of a table created in Redshift.
+// As an override, the empty fields are chosen by default.
+type TableProperties struct {
+	// DistStyle is one of AUTO, EVEN, ALL and KEY
+	DistStyle string
+	// DistKey is the distribution column of DISTSTYLE KEY
+	DistKey string
+	// SortKey is the columns of the compound sort key, empty means no sort key
+	SortKey []string
+}
+
+// ParseTablePropertiesOverrides parses the values of --redshift.table-properties, e.g.
+// `db.t:distkey=user_id,sortkey=(created_at)`, into the overrides keyed by the table FQN
+func ParseTablePropertiesOverrides(values []string) (map[string]*TableProperties, error) {
+	overrides := make(map[string]*TableProperties, len(values))
+	for _, value := range values {
+		tableFQN, props, ok := strings.Cut(value, ":")
+		if !ok || tableFQN == "" {
+			return nil, errors.Errorf("invalid table properties %s, expect <db>.<table>:<key>=<value>,...", value)
+		}
+		if _, ok := overrides[tableFQN]; ok {
+			return nil, errors.Errorf("duplicated table properties of table %s", tableFQN)
+		}
+		override := &TableProperties{}
+		for _, prop := range splitOutsideParentheses(props) {
+			key, val, ok := strings.Cut(prop, "=")
+			key, val = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(val)
+			if !ok || val == "" {
+				return nil, errors.Errorf("invalid table property %s of table %s", prop, tableFQN)
+			}
+			switch key {
+			case "distkey":
+				override.DistKey = val
+			case "diststyle":
+				override.DistStyle = strings.ToUpper(val)
+				switch override.DistStyle {
+				case DistStyleAuto, DistStyleEven, DistStyleAll, DistStyleKey:
+				default:
+					return nil, errors.Errorf("invalid diststyle %s of table %s, expect auto, even, all or key", val, tableFQN)
+				}
+			case "sortkey":
+				override.SortKey = parseColumnList(val)
+				if len(override.SortKey) == 0 {
+					return nil, errors.Errorf("invalid sortkey %s of table %s", val, tableFQN)
+				}
+			default:
+				return nil, errors.Errorf("unknown table property %s of table %s, expect distkey, diststyle or sortkey", key, tableFQN)
+			}
+		}
+		if override.DistKey != "" && override.DistStyle != "" && override.DistStyle != DistStyleKey {
+			return nil, errors.Errorf("distkey conflicts with diststyle %s of table %s", override.DistStyle, tableFQN)
+		}
+		overrides[tableFQN] = override
+	}
+	return overrides, nil
+}
+
+// splitOutsideParentheses splits the properties by the commas not in a column list
+func splitOutsideParentheses(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseColumnList parses `(a, b)` or `a` into the column names
+func parseColumnList(s string) []string {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "("), ")")
+	var columns []string
+	for _, column := range strings.Split(s, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// ResolveTableProperties chooses the properties of a table: DISTKEY on the first primary key column, or
+// DISTSTYLE AUTO without primary key, and compound SORTKEY on the primary key, or on the first timestamp
+// column without primary key. The fields given by override replace the defaults.
+func ResolveTableProperties(columns []cloudstorage.TableCol, pkColumns []string, override *TableProperties) (TableProperties, error) {
+	props := TableProperties{DistStyle: DistStyleAuto}
+	if len(pkColumns) > 0 {
+		props.DistStyle = DistStyleKey
+		props.DistKey = pkColumns[0]
+		props.SortKey = pkColumns
+	} else if column, ok := findTimestampColumn(columns); ok {
+		props.SortKey = []string{column}
+	}
+	if override == nil {
+		return props, nil
+	}
+
+	if override.DistKey != "" {
+		props.DistStyle = DistStyleKey
+		props.DistKey = override.DistKey
+	} else if override.DistStyle != "" {
+		props.DistStyle = override.DistStyle
+		if props.DistStyle != DistStyleKey {
+			props.DistKey = ""
+		}
+	}
+	if len(override.SortKey) > 0 {
+		props.SortKey = override.SortKey
+	}
+
+	if props.DistStyle == DistStyleKey {
+		if props.DistKey == "" {
+			return props, errors.New("diststyle key requires a distkey since the table has no primary key")
+		}
+		column, err := findColumn(columns, props.DistKey)
+		if err != nil {
+			return props, errors.Annotate(err, "invalid distkey")
+		}
+		props.DistKey = column
+	}
+	sortKey := make([]string, 0, len(props.SortKey))
+	for _, name := range props.SortKey {
+		column, err := findColumn(columns, name)
+		if err != nil {
+			return props, errors.Annotate(err, "invalid sortkey")
+		}
+		sortKey = append(sortKey, column)
+	}
+	props.SortKey = sortKey
+	return props, nil
+}
+
+func findTimestampColumn(columns []cloudstorage.TableCol) (string, bool) {
+	for _, column := range columns {
+		switch strings.ToUpper(column.Tp) {
+		case "TIMESTAMP", "DATETIME":
+			return column.Name, true
+		}
+	}
+	return "", false
+}
+
+// findColumn returns the name of the column in the table, column names are case-insensitive in TiDB
+func findColumn(columns []cloudstorage.TableCol, name string) (string, error) {
+	for _, column := range columns {
+		if strings.EqualFold(column.Name, name) {
+			return column.Name, nil
+		}
+	}
+	return "", errors.Errorf("column %s does not exist", name)
+}
+
+// genTablePropertiesClause generates the clause following the column definitions of CREATE TABLE
+func (g Generator) genTablePropertiesClause(props TableProperties) string {
+	clause := fmt.Sprintf("DISTSTYLE %s", props.DistStyle)
+	if props.DistStyle == DistStyleKey {
+		clause += fmt.Sprintf(" DISTKEY (%s)", g.QuoteIdent(props.DistKey))
+	}
+	if len(props.SortKey) > 0 {
+		clause += fmt.Sprintf(" COMPOUND SORTKEY (%s)", g.quoteIdents(props.SortKey))
+	}
+	return clause
+}
+
+// DiffTableProperties compares the properties reported by SVV_TABLE_INFO with the expected ones,
+// diststyle is like `KEY(id)` or `AUTO(ALL)`, sortKey1 is the first sort key column.
+func DiffTableProperties(expected TableProperties, diststyle, sortKey1 string, sortKeyNum int) []string {
+	var diffs []string
+	expectedDistStyle := expected.DistStyle
+	if expected.DistStyle == DistStyleKey {
+		expectedDistStyle = fmt.Sprintf("KEY(%s)", expected.DistKey)
+	}
+	// AUTO is reported with the style chosen by Redshift, e.g. AUTO(EVEN)
+	matched := strings.EqualFold(diststyle, expectedDistStyle) ||
+		(expected.DistStyle == DistStyleAuto && strings.HasPrefix(strings.ToUpper(diststyle), DistStyleAuto))
+	if !matched {
+		diffs = append(diffs, fmt.Sprintf("diststyle is %s, expected %s", diststyle, expectedDistStyle))
+	}
+	expectedSortKey1 := ""
+	if len(expected.SortKey) > 0 {
+		expectedSortKey1 = expected.SortKey[0]
+	}
+	if !strings.EqualFold(sortKey1, expectedSortKey1) || sortKeyNum != len(expected.SortKey) {
+		diffs = append(diffs, fmt.Sprintf("sortkey has %d columns starting with %q, expected (%s)", sortKeyNum, sortKey1, strings.Join(expected.SortKey, ", ")))
+	}
+	return diffs
+}
+
+// checkTableProperties logs the difference between the properties of an existing table and the expected ones.
+// The properties only apply when the table is created, an existing table is never altered.
+func (g Generator) checkTableProperties(db *sql.DB, schemaName, tableName string, expected TableProperties) error {
+	var diststyle, sortKey1 sql.NullString
+	var sortKeyNum sql.NullInt64
+	err := db.QueryRow(`SELECT diststyle, sortkey1, sortkey_num FROM svv_table_info WHERE "schema" = $1 AND "table" = $2`,
+		g.identifierCase.Apply(schemaName), g.identifierCase.Apply(tableName)).Scan(&diststyle, &sortKey1, &sortKeyNum)
+	if err == sql.ErrNoRows {
+		// svv_table_info omits the empty tables
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if diffs := DiffTableProperties(expected, diststyle.String, sortKey1.String, int(sortKeyNum.Int64)); len(diffs) > 0 {
+		log.Warn("Table properties in Redshift differ from the expected ones, the table is not altered",
+			zap.String("table", tableName), zap.Strings("differences", diffs))
+	}
+	return nil
+}
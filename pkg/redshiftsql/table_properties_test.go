@@ -0,0 +1,145 @@
+package redshiftsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/redshiftsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+var eventColumns = []cloudstorage.TableCol{
+	{Name: "id", Tp: "bigint", Nullable: "false", IsPK: "true"},
+	{Name: "user_id", Tp: "bigint"},
+	{Name: "created_at", Tp: "timestamp"},
+}
+
+func TestGenCreateTableSQLWithDefaultProperties(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	props, err := redshiftsql.ResolveTableProperties(eventColumns, []string{"id"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, redshiftsql.TableProperties{DistStyle: "KEY", DistKey: "id", SortKey: []string{"id"}}, props)
+	query, err := gen.GenCreateTableSQL("events", eventColumns, []string{"id"}, props, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT,
+    "created_at" TIMESTAMP,
+    PRIMARY KEY ("id")
+)
+DISTSTYLE KEY DISTKEY ("id") COMPOUND SORTKEY ("id")`, query)
+
+	// the tombstone columns of the soft delete mode follow the columns
+	query, err = gen.GenCreateTableSQL("events", eventColumns, []string{"id"}, props, nil, deletemode.Soft)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT,
+    "created_at" TIMESTAMP,
+    "_tidb_deleted" BOOLEAN DEFAULT FALSE,
+    "_tidb_deleted_at" TIMESTAMP,
+    PRIMARY KEY ("id")
+)
+DISTSTYLE KEY DISTKEY ("id") COMPOUND SORTKEY ("id")`, query)
+}
+
+func TestGenCreateTableSQLWithoutPK(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	props, err := redshiftsql.ResolveTableProperties(eventColumns, nil, nil)
+	require.NoError(t, err)
+	query, err := gen.GenCreateTableSQL("events", eventColumns, nil, props, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT,
+    "created_at" TIMESTAMP
+)
+DISTSTYLE AUTO COMPOUND SORTKEY ("created_at")`, query)
+
+	// neither primary key nor timestamp column
+	props, err = redshiftsql.ResolveTableProperties(eventColumns[:2], nil, nil)
+	require.NoError(t, err)
+	query, err = gen.GenCreateTableSQL("events", eventColumns[:2], nil, props, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT
+)
+DISTSTYLE AUTO`, query)
+}
+
+func TestGenCreateTableSQLWithOverride(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	overrides, err := redshiftsql.ParseTablePropertiesOverrides([]string{
+		"db.events:distkey=User_ID,sortkey=(created_at, id)",
+		"db.users:diststyle=all",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &redshiftsql.TableProperties{DistKey: "User_ID", SortKey: []string{"created_at", "id"}}, overrides["db.events"])
+
+	props, err := redshiftsql.ResolveTableProperties(eventColumns, []string{"id"}, overrides["db.events"])
+	require.NoError(t, err)
+	query, err := gen.GenCreateTableSQL("events", eventColumns, []string{"id"}, props, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT,
+    "created_at" TIMESTAMP,
+    PRIMARY KEY ("id")
+)
+DISTSTYLE KEY DISTKEY ("user_id") COMPOUND SORTKEY ("created_at", "id")`, query)
+
+	// the sort key on the primary key is kept
+	props, err = redshiftsql.ResolveTableProperties(eventColumns, []string{"id"}, overrides["db.users"])
+	require.NoError(t, err)
+	require.Equal(t, redshiftsql.TableProperties{DistStyle: "ALL", SortKey: []string{"id"}}, props)
+
+	_, err = redshiftsql.ResolveTableProperties(eventColumns, []string{"id"}, &redshiftsql.TableProperties{SortKey: []string{"updated_at"}})
+	require.ErrorContains(t, err, "invalid sortkey: column updated_at does not exist")
+	_, err = redshiftsql.ResolveTableProperties(eventColumns, nil, &redshiftsql.TableProperties{DistStyle: "KEY"})
+	require.ErrorContains(t, err, "diststyle key requires a distkey")
+}
+
+func TestParseTablePropertiesOverridesInvalid(t *testing.T) {
+	for value, expected := range map[string]string{
+		"db.events":                         "invalid table properties db.events",
+		"db.events:distkey":                 "invalid table property distkey of table db.events",
+		"db.events:partition=id":            "unknown table property partition of table db.events",
+		"db.events:diststyle=hash":          "invalid diststyle hash of table db.events",
+		"db.events:sortkey=()":              "invalid sortkey () of table db.events",
+		"db.events:diststyle=all,distkey=a": "distkey conflicts with diststyle ALL of table db.events",
+	} {
+		_, err := redshiftsql.ParseTablePropertiesOverrides([]string{value})
+		require.ErrorContains(t, err, expected, value)
+	}
+	_, err := redshiftsql.ParseTablePropertiesOverrides([]string{"db.events:distkey=id", "db.events:sortkey=id"})
+	require.ErrorContains(t, err, "duplicated table properties of table db.events")
+}
+
+func TestDiffTableProperties(t *testing.T) {
+	expected := redshiftsql.TableProperties{DistStyle: "KEY", DistKey: "id", SortKey: []string{"id"}}
+	require.Empty(t, redshiftsql.DiffTableProperties(expected, "KEY(id)", "id", 1))
+	require.Equal(t, []string{
+		"diststyle is AUTO(EVEN), expected KEY(id)",
+		`sortkey has 0 columns starting with "", expected (id)`,
+	}, redshiftsql.DiffTableProperties(expected, "AUTO(EVEN)", "", 0))
+
+	// AUTO matches the style chosen by Redshift
+	require.Empty(t, redshiftsql.DiffTableProperties(redshiftsql.TableProperties{DistStyle: "AUTO"}, "AUTO(ALL)", "", 0))
+}
+
+func TestGenCreateTableDDLs(t *testing.T) {
+	gen := redshiftsql.NewGenerator(identcase.Lower)
+	tableDef := cloudstorage.TableDefinition{Table: "events", Columns: eventColumns}
+	ddls, err := gen.GenCreateTableDDLs(tableDef, &redshiftsql.TableProperties{DistStyle: "EVEN", SortKey: []string{"created_at"}}, nil, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`DROP TABLE IF EXISTS "events"`, `CREATE TABLE "events" (
+    "id" BIGINT NOT NULL,
+    "user_id" BIGINT,
+    "created_at" TIMESTAMP,
+    PRIMARY KEY ("id")
+)
+DISTSTYLE EVEN COMPOUND SORTKEY ("created_at")`}, ddls)
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"github.com/pkg/errors"
 )
@@ -37,21 +39,65 @@ var TiDB2RedshiftTypeMap map[string]string = map[string]string{
 	"datetime":   "TIMESTAMP",
 	"timestamp":  "TIMESTAMP",
 	"time":       "TIME",
+	"enum":       "VARCHAR",
+	"set":        "VARCHAR",
 }
 
-func GetRedshiftTypeString(column cloudstorage.TableCol) (string, error) {
+// redshiftMaxDecimalPrecision is the max precision of DECIMAL
+const redshiftMaxDecimalPrecision = 38
+
+// redshiftMaxVarcharLength is the max length of VARCHAR, which is also the max of the external tables
+const redshiftMaxVarcharLength = 65535
+
+// tiDB2RedshiftUnsignedTypeMap widens the unsigned integer types, Redshift has no unsigned types
+var tiDB2RedshiftUnsignedTypeMap map[string]string = map[string]string{
+	"tinyint":   "SMALLINT",
+	"smallint":  "INT",
+	"mediumint": "INT",
+	"int":       "BIGINT",
+	"bigint":    "DECIMAL(20, 0)",
+}
+
+// GetRedshiftTypeString returns the column with its Redshift type, the type given by columnTypes takes
+// precedence over the default mapping
+func (g Generator) GetRedshiftTypeString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	if tp, ok := columnTypes.Lookup(column.Name); ok {
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), tp), nil
+	}
 	tp := strings.ToLower(column.Tp)
+	if baseTp, ok := strings.CutSuffix(tp, " unsigned"); ok {
+		if redshiftTp, ok := tiDB2RedshiftUnsignedTypeMap[baseTp]; ok {
+			return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), redshiftTp), nil
+		}
+		tp = baseTp
+	}
 	switch tp {
 	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp]), nil
 	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp]), nil
 	case "varchar", "char", "binary", "varbinary":
-		return fmt.Sprintf("%s %s(%s)", column.Name, TiDB2RedshiftTypeMap[tp], column.Precision), nil
+		return fmt.Sprintf("%s %s(%s)", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp], column.Precision), nil
+	case "enum", "set":
+		// the length of the longest value is known when the table is copied from TiDB, not by the schema files
+		length := column.Precision
+		if length == "" {
+			length = fmt.Sprint(redshiftMaxVarcharLength)
+		}
+		return fmt.Sprintf("%s %s(%s)", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp], length), nil
+	case "bit":
+		// BIT(1) is loaded from 0 or 1, a longer BIT from the hex of its bytes
+		if length := tidbsql.BitLength(column); length > 1 {
+			return fmt.Sprintf("%s VARBYTE(%d)", g.QuoteIdent(column.Name), tidbsql.BitBytes(length)), nil
+		}
+		return fmt.Sprintf("%s BOOLEAN", g.QuoteIdent(column.Name)), nil
 	case "decimal", "numeric":
-		return fmt.Sprintf("%s %s(%s, %s)", column.Name, TiDB2RedshiftTypeMap[tp], column.Precision, column.Scale), nil
+		if err := tidbsql.CheckDecimalPrecision(column, redshiftMaxDecimalPrecision, "Redshift"); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s(%s, %s)", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp], column.Precision, column.Scale), nil
 	case "datetime", "timestamp", "time":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2RedshiftTypeMap[tp]), nil
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2RedshiftTypeMap[tp]), nil
 	default:
 		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
 	}
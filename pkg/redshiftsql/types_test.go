@@ -0,0 +1,89 @@
+package redshiftsql
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/typetest"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRedshiftTypeStringAllTypes(t *testing.T) {
+	gen := NewGenerator(identcase.Lower)
+	expected := map[string]string{
+		"c_tinyint":            `"c_tinyint" SMALLINT`,
+		"c_tinyint_unsigned":   `"c_tinyint_unsigned" SMALLINT`,
+		"c_smallint":           `"c_smallint" SMALLINT`,
+		"c_smallint_unsigned":  `"c_smallint_unsigned" INT`,
+		"c_mediumint":          `"c_mediumint" INT`,
+		"c_mediumint_unsigned": `"c_mediumint_unsigned" INT`,
+		"c_int":                `"c_int" INT`,
+		"c_int_unsigned":       `"c_int_unsigned" BIGINT`,
+		"c_bigint":             `"c_bigint" BIGINT`,
+		"c_bigint_unsigned":    `"c_bigint_unsigned" DECIMAL(20, 0)`,
+		"c_float":              `"c_float" FLOAT`,
+		"c_double":             `"c_double" FLOAT`,
+		"c_decimal":            `"c_decimal" DECIMAL(20, 6)`,
+		"c_bit":                `"c_bit" BOOLEAN`,
+		"c_bit_long":           `"c_bit_long" VARBYTE(2)`,
+		"c_date":               `"c_date" DATE`,
+		"c_datetime":           `"c_datetime" TIMESTAMP`,
+		"c_timestamp":          `"c_timestamp" TIMESTAMP`,
+		"c_time":               `"c_time" TIME`,
+		"c_char":               `"c_char" CHAR(10)`,
+		"c_varchar":            `"c_varchar" VARCHAR(255)`,
+		"c_binary":             `"c_binary" VARBYTE(16)`,
+		"c_varbinary":          `"c_varbinary" VARBYTE(255)`,
+		"c_tinytext":           `"c_tinytext" TEXT`,
+		"c_text":               `"c_text" TEXT`,
+		"c_mediumtext":         `"c_mediumtext" TEXT`,
+		"c_longtext":           `"c_longtext" TEXT`,
+		"c_tinyblob":           `"c_tinyblob" TEXT`,
+		"c_blob":               `"c_blob" TEXT`,
+		"c_mediumblob":         `"c_mediumblob" TEXT`,
+		"c_longblob":           `"c_longblob" TEXT`,
+		"c_enum":               `"c_enum" VARCHAR(65535)`,
+		"c_set":                `"c_set" VARCHAR(65535)`,
+	}
+	for _, column := range typetest.Columns() {
+		actual, err := gen.GetRedshiftTypeString(column, nil)
+		switch column.Name {
+		case "c_decimal_wide":
+			require.ErrorContains(t, err, "Column c_decimal_wide of DECIMAL(40, 10) exceeds the max precision 38 of Redshift")
+		case "c_year", "c_json":
+			require.ErrorContains(t, err, "Unsupported data type")
+		default:
+			require.NoError(t, err, column.Name)
+			require.Equal(t, expected[column.Name], actual, column.Name)
+		}
+	}
+	// the length of ENUM and SET is known when the table is copied from TiDB
+	for _, column := range typetest.CopiedColumns()[1:] {
+		actual, err := gen.GetRedshiftTypeString(column, nil)
+		require.NoError(t, err)
+		require.Contains(t, actual, "VARCHAR("+column.Precision+")")
+	}
+}
+
+func TestLatestChangeOrder(t *testing.T) {
+	// DeleteQuery and InsertQuery pick the same change of a key, the commit ts of the CSV files is a string
+	require.Equal(t, "CAST(timestamp AS BIGINT) desc, CASE WHEN flag = 'D' THEN 0 ELSE 1 END desc", latestChangeOrder)
+}
+
+func TestCastField(t *testing.T) {
+	gen := NewGenerator(identcase.Lower)
+	require.Equal(t, `"id"`, gen.castField(cloudstorage.TableCol{Name: "id", Tp: "INT"}, nil, stagingformat.CSV))
+	require.Equal(t, `("enabled" = '1') AS "enabled"`, gen.castField(cloudstorage.TableCol{Name: "enabled", Tp: "BIT", Precision: "1"}, nil, stagingformat.CSV))
+	require.Equal(t, `TO_VARBYTE(RIGHT(`+
+		`LPAD(TO_HEX(CAST(TRUNC(CAST("mask" AS DECIMAL(20, 0)) / 4294967296) AS BIGINT)), 8, '0') || `+
+		`LPAD(TO_HEX(CAST(MOD(CAST("mask" AS DECIMAL(20, 0)), 4294967296) AS BIGINT)), 8, '0'), 4), 'hex') AS "mask"`,
+		gen.castField(cloudstorage.TableCol{Name: "mask", Tp: "BIT", Precision: "12"}, nil, stagingformat.CSV))
+	// a column overridden is typed as the table in the external table
+	require.Equal(t, `"mask"`, gen.castField(cloudstorage.TableCol{Name: "mask", Tp: "BIT", Precision: "12"}, columnmapping.Columns{"mask": "BIGINT"}, stagingformat.CSV))
+	// the Parquet files have the BIT values typed as the table
+	require.Equal(t, `"enabled"`, gen.castField(cloudstorage.TableCol{Name: "enabled", Tp: "BIT", Precision: "1"}, nil, stagingformat.Parquet))
+	require.Equal(t, `"mask"`, gen.castField(cloudstorage.TableCol{Name: "mask", Tp: "BIT", Precision: "12"}, nil, stagingformat.Parquet))
+}
@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"database/sql/driver"
+	stderrors "errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// baseBackoff is the backoff before the first retry, it doubles by every retry up to Policy.MaxBackoff
+const baseBackoff = time.Second
+
+// Policy is how a failed operation of the data warehouse is retried
+type Policy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables the retry
+	MaxRetries int
+	// MaxBackoff caps the backoff between two attempts
+	MaxBackoff time.Duration
+}
+
+// DefaultPolicy is the policy of --max-retries and --max-backoff by default
+var DefaultPolicy = Policy{MaxRetries: 5, MaxBackoff: 2 * time.Minute}
+
+// Validate checks the retries and the backoff are not negative, and the backoff is set if retried
+func (p Policy) Validate() error {
+	if p.MaxRetries < 0 {
+		return errors.Errorf("invalid --max-retries %d", p.MaxRetries)
+	}
+	if p.MaxBackoff < 0 || (p.MaxRetries > 0 && p.MaxBackoff == 0) {
+		return errors.Errorf("invalid --max-backoff %s, a positive duration is required with --max-retries", p.MaxBackoff)
+	}
+	return nil
+}
+
+// Backoff returns the backoff before the retry, 0 for the first retry. It doubles from a second up to MaxBackoff,
+// half of it is random so that the tables failed at the same time do not retry at the same time.
+func (p Policy) Backoff(retry int) time.Duration {
+	backoff := p.MaxBackoff
+	if retry < 32 && baseBackoff<<retry < backoff {
+		backoff = baseBackoff << retry
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Classifier tells whether the operation failed with the error may succeed if it is run again
+type Classifier func(err error) bool
+
+// Do runs op until it succeeds, it fails with an error not retryable, or the retries are used up. onRetry is called
+// before each backoff with the error of the attempt. op must be safe to run again after a failure, including one
+// whose effect is applied but not acknowledged, e.g. the connection is lost while committing.
+func Do(ctx context.Context, p Policy, retryable Classifier, op func() error, onRetry func(retry int, backoff time.Duration, err error)) error {
+	for retry := 0; ; retry++ {
+		err := op()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if retry >= p.MaxRetries {
+			if p.MaxRetries == 0 {
+				return err
+			}
+			return errors.Annotatef(err, "Failed after %d retries", retry)
+		}
+		backoff := p.Backoff(retry)
+		if onRetry != nil {
+			onRetry(retry, backoff, err)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// transientMessages are the messages of the network errors not typed by the drivers
+var transientMessages = []string{
+	"connection reset by peer",
+	"broken pipe",
+	"i/o timeout",
+	"tls handshake timeout",
+	"unexpected eof",
+}
+
+// IsTransient tells whether the error is of the connection to the data warehouse, e.g. it is reset or timed out,
+// rather than of the statement. The classifiers of the data warehouses fall back to it. A canceled context is not
+// transient.
+func IsTransient(err error) bool {
+	if err == nil || stderrors.Is(err, context.Canceled) {
+		return false
+	}
+	if stderrors.Is(err, driver.ErrBadConn) || stderrors.Is(err, io.ErrUnexpectedEOF) ||
+		stderrors.Is(err, syscall.ECONNRESET) || stderrors.Is(err, syscall.ECONNREFUSED) || stderrors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if stderrors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
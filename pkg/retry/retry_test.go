@@ -0,0 +1,95 @@
+package retry_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return errors.Cause(err) == errTransient
+}
+
+func TestPolicy(t *testing.T) {
+	require.NoError(t, retry.DefaultPolicy.Validate())
+	require.NoError(t, retry.Policy{}.Validate())
+	require.Error(t, retry.Policy{MaxRetries: -1, MaxBackoff: time.Second}.Validate())
+	require.Error(t, retry.Policy{MaxRetries: 3}.Validate())
+
+	p := retry.Policy{MaxRetries: 10, MaxBackoff: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		backoff := p.Backoff(0)
+		require.True(t, backoff >= 500*time.Millisecond && backoff <= time.Second, backoff)
+		backoff = p.Backoff(2)
+		require.True(t, backoff >= 2*time.Second && backoff <= 4*time.Second, backoff)
+		// capped by the max backoff
+		backoff = p.Backoff(40)
+		require.True(t, backoff >= 5*time.Second && backoff <= 10*time.Second, backoff)
+	}
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	p := retry.Policy{MaxRetries: 3, MaxBackoff: time.Millisecond}
+
+	// the transient errors are retried until the operation succeeds
+	calls, retries := 0, 0
+	err := retry.Do(ctx, p, isTransient, func() error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	}, func(retry int, backoff time.Duration, err error) {
+		require.Equal(t, retries, retry)
+		retries++
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, 2, retries)
+
+	// a fatal error surfaces at once with the failing statement
+	calls = 0
+	err = retry.Do(ctx, p, isTransient, func() error {
+		calls++
+		return diag.WrapSQL(errors.New("syntax error"), "MERGE INTO t")
+	}, nil)
+	require.Equal(t, 1, calls)
+	require.Equal(t, "MERGE INTO t", diag.FailingSQL(err))
+
+	// the retries are used up
+	calls = 0
+	err = retry.Do(ctx, p, isTransient, func() error {
+		calls++
+		return errTransient
+	}, nil)
+	require.Equal(t, 4, calls)
+	require.ErrorContains(t, err, "Failed after 3 retries")
+	require.Equal(t, errTransient, errors.Cause(err))
+
+	// the backoff is interrupted by the context
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = retry.Do(canceled, retry.Policy{MaxRetries: 3, MaxBackoff: time.Hour}, isTransient, func() error {
+		return errTransient
+	}, nil)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestIsTransient(t *testing.T) {
+	require.False(t, retry.IsTransient(nil))
+	require.True(t, retry.IsTransient(errors.Trace(driver.ErrBadConn)))
+	require.True(t, retry.IsTransient(errors.Annotate(io.ErrUnexpectedEOF, "Failed to read")))
+	require.True(t, retry.IsTransient(errors.New("read tcp 10.0.0.1:5439: connection reset by peer")))
+	require.False(t, retry.IsTransient(errors.Trace(context.Canceled)))
+	require.False(t, retry.IsTransient(errors.New("column \"c\" does not exist")))
+}
@@ -0,0 +1,261 @@
+package routing
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+const routeSeparator = "=>"
+
+// Target is the database, schema and table in the data warehouse a table is replicated to, empty fields fall back
+// to the database and schema of the connection and to the name of the source table. The schema is the dataset of
+// BigQuery, and the database is the catalog of Databricks.
+type Target struct {
+	Database string
+	Schema   string
+	Table    string
+}
+
+func (t Target) String() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{t.Database, t.Schema, t.Table} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Route is the resolved target of a table and the rule it is resolved by
+type Route struct {
+	Table  string
+	Target Target
+	// Rule is the route or schema route matching the table, empty if the default target is used
+	Rule string
+}
+
+// rule routes the tables matching the glob patterns to the target template
+type rule struct {
+	raw            string
+	dbPattern      string
+	tablePattern   string
+	targetTemplate string
+}
+
+// Router resolves the target of tables by explicit routes first, then by schema routes in the given order
+type Router struct {
+	routes       map[string]rule
+	schemaRoutes []rule
+	// namespaces is the number of the levels above the tables in the data warehouse
+	namespaces int
+}
+
+var variablePattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// variables are the variables available in the target template of a schema route
+var variables = map[string]func(db, table string) string{
+	"source_db":          func(db, _ string) string { return db },
+	"source_db_lower":    func(db, _ string) string { return strings.ToLower(db) },
+	"source_db_upper":    func(db, _ string) string { return strings.ToUpper(db) },
+	"source_table":       func(_, table string) string { return table },
+	"source_table_lower": func(_, table string) string { return strings.ToLower(table) },
+	"source_table_upper": func(_, table string) string { return strings.ToUpper(table) },
+}
+
+// NewRouter parses the explicit routes, e.g. `app.orders=>ANALYTICS.ORDERS_SCHEMA`,
+// and the schema routes, e.g. `{source_db}=>ANALYTICS.{source_db_upper}` or `billing_*=>BILLING`.
+// The source of a schema route is a glob of `<db>` or `<db>.<table>`, where `{source_db}` and
+// `{source_table}` are the same as `*`. namespaces is the number of the levels above the tables in the data
+// warehouse, 2 for `<database>.<schema>` and 1 for `<schema>`. The target of a schema route is the schema with
+// up to namespaces levels, e.g. `<database>.<schema>` or `<schema>` for 2, while the target of an explicit route
+// may also end with the table after all the levels, e.g. `<database>.<schema>.<table>` for 2.
+func NewRouter(routes, schemaRoutes []string, namespaces int) (*Router, error) {
+	router := &Router{routes: make(map[string]rule, len(routes)), namespaces: namespaces}
+	for _, raw := range routes {
+		r, err := parseRule(raw, namespaces, true)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Invalid route %s", raw)
+		}
+		if strings.ContainsAny(r.dbPattern+r.tablePattern, "*?[") || r.tablePattern == "*" {
+			return nil, errors.Errorf("Invalid route %s, the source must be a table full qualified name, use --schema-route for patterns", raw)
+		}
+		router.routes[fmt.Sprintf("%s.%s", r.dbPattern, r.tablePattern)] = r
+	}
+	for _, raw := range schemaRoutes {
+		r, err := parseRule(raw, namespaces, false)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Invalid schema route %s", raw)
+		}
+		router.schemaRoutes = append(router.schemaRoutes, r)
+	}
+	return router, nil
+}
+
+// parseRule parses the rule whose target has up to namespaces levels, and the table if withTable
+func parseRule(raw string, namespaces int, withTable bool) (rule, error) {
+	source, target, ok := strings.Cut(raw, routeSeparator)
+	source, target = strings.TrimSpace(source), strings.TrimSpace(target)
+	if !ok || source == "" || target == "" {
+		return rule{}, errors.Errorf("expected <source>%s<target>", routeSeparator)
+	}
+	source = strings.NewReplacer("{source_db}", "*", "{source_table}", "*").Replace(source)
+	dbPattern, tablePattern, ok := strings.Cut(source, ".")
+	if !ok {
+		tablePattern = "*"
+	}
+	for _, pattern := range []string{dbPattern, tablePattern} {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return rule{}, errors.Errorf("invalid source pattern %s", source)
+		}
+	}
+	for _, match := range variablePattern.FindAllStringSubmatch(target, -1) {
+		if _, ok := variables[match[1]]; !ok {
+			return rule{}, errors.Errorf("unknown variable {%s}, expected one of source_db, source_table with optional _lower or _upper suffix", match[1])
+		}
+	}
+	levels := namespaces
+	if withTable {
+		levels++
+	}
+	if parts := strings.Split(target, "."); len(parts) > levels || slices.Contains(parts, "") {
+		return rule{}, errors.Errorf("invalid target %s, expected %s", target, targetFormat(namespaces, withTable))
+	}
+	return rule{raw: raw, dbPattern: dbPattern, tablePattern: tablePattern, targetTemplate: target}, nil
+}
+
+func (r rule) match(db, table string) bool {
+	dbMatched, _ := path.Match(r.dbPattern, db)
+	tableMatched, _ := path.Match(r.tablePattern, table)
+	return dbMatched && tableMatched
+}
+
+// targetFormat describes the targets of the rules, e.g. `<database>.<schema> or <schema>`
+func targetFormat(namespaces int, withTable bool) string {
+	levels := []string{"<schema>", "<database>.<schema>"}[:namespaces]
+	formats := make([]string, 0, namespaces+1)
+	if withTable {
+		formats = append(formats, levels[namespaces-1]+".<table>")
+	}
+	for i := namespaces - 1; i >= 0; i-- {
+		formats = append(formats, levels[i])
+	}
+	if len(formats) == 1 {
+		return formats[0]
+	}
+	return strings.Join(formats[:len(formats)-1], ", ") + " or " + formats[len(formats)-1]
+}
+
+// target returns the target of the table, the parts of the target are the levels from the schema up to the
+// namespaces, and then the table
+func (r rule) target(db, table string, namespaces int) Target {
+	expanded := variablePattern.ReplaceAllStringFunc(r.targetTemplate, func(variable string) string {
+		return variables[strings.Trim(variable, "{}")](db, table)
+	})
+	parts := strings.Split(expanded, ".")
+	var target Target
+	if len(parts) > namespaces {
+		target.Table, parts = parts[len(parts)-1], parts[:len(parts)-1]
+	}
+	target.Schema = parts[len(parts)-1]
+	if len(parts) > 1 {
+		target.Database = parts[0]
+	}
+	return target
+}
+
+// Resolve returns the route of the table, the target is empty if no rule matches
+func (router *Router) Resolve(tableFQN string) Route {
+	db, table := utils.SplitTableFQN(tableFQN)
+	if r, ok := router.routes[tableFQN]; ok {
+		return Route{Table: tableFQN, Target: r.target(db, table, router.namespaces), Rule: r.raw}
+	}
+	for _, r := range router.schemaRoutes {
+		if r.match(db, table) {
+			return Route{Table: tableFQN, Target: r.target(db, table, router.namespaces), Rule: r.raw}
+		}
+	}
+	return Route{Table: tableFQN}
+}
+
+// CheckCollisions fails if two tables are replicated to the same table in the data warehouse. The targets must
+// have the database and schema of the connection filled in, a target without a table is the source table.
+func CheckCollisions(targets map[string]Target) error {
+	tables := make([]string, 0, len(targets))
+	for table := range targets {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+	set := NewTargetSet()
+	for _, table := range tables {
+		if err := set.Add(table, targets[table]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// TargetSet is the tables replicated to each table of the data warehouse, the tables found after the replication
+// starts, e.g. by a pattern or by CREATE TABLE, are added to it and checked against the tables routed before
+type TargetSet struct {
+	mu     sync.Mutex
+	routed map[Target]string
+	// targets are the targets of the tables as they are added, a target without a table follows the source table
+	targets map[string]Target
+}
+
+func NewTargetSet() *TargetSet {
+	return &TargetSet{routed: make(map[Target]string), targets: make(map[string]Target)}
+}
+
+// Add records that the table is replicated to the target, it fails if another table is already replicated to the
+// target. The target must have the database and schema of the connection filled in, a target without a table is
+// the source table. Adding a table again to the same target is a no-op.
+func (s *TargetSet) Add(table string, target Target) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(table, target)
+}
+
+func (s *TargetSet) add(table string, target Target) error {
+	routed := target
+	if routed.Table == "" {
+		_, routed.Table = utils.SplitTableFQN(table)
+	}
+	if other, ok := s.routed[routed]; ok && other != table {
+		return errors.Errorf("Tables %s and %s are both replicated to %s, route one of them to another table by --route", other, table, routed)
+	}
+	s.routed[routed] = table
+	s.targets[table] = target
+	return nil
+}
+
+// Rename moves a table renamed in the source to its new name. A table routed to a table of the data warehouse
+// keeps it, while the table of a table following its source name is renamed too, which fails if another table
+// is already replicated to the new table. A table not added before is not checked.
+func (s *TargetSet) Rename(from, to string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target, ok := s.targets[from]
+	if !ok || from == to {
+		return nil
+	}
+	if target.Table != "" {
+		s.routed[target], s.targets[to] = to, target
+		delete(s.targets, from)
+		return nil
+	}
+	if err := s.add(to, target); err != nil {
+		return errors.Trace(err)
+	}
+	delete(s.targets, from)
+	_, target.Table = utils.SplitTableFQN(from)
+	delete(s.routed, target)
+	return nil
+}
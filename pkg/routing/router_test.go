@@ -0,0 +1,145 @@
+package routing_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	router, err := routing.NewRouter(
+		[]string{"app.orders => OPS.ORDERS"},
+		[]string{"billing_*=>BILLING", "{source_db}=>ANALYTICS.src_{source_db_lower}_{source_table_upper}"},
+		2,
+	)
+	require.NoError(t, err)
+
+	// explicit routes take precedence
+	route := router.Resolve("app.orders")
+	require.Equal(t, routing.Target{Database: "OPS", Schema: "ORDERS"}, route.Target)
+	require.Equal(t, "app.orders => OPS.ORDERS", route.Rule)
+
+	// schema routes are matched in order
+	route = router.Resolve("billing_eu.invoices")
+	require.Equal(t, routing.Target{Schema: "BILLING"}, route.Target)
+	require.Equal(t, "BILLING", route.Target.String())
+
+	route = router.Resolve("Auth.users")
+	require.Equal(t, routing.Target{Database: "ANALYTICS", Schema: "src_auth_USERS"}, route.Target)
+	require.Equal(t, "ANALYTICS.src_auth_USERS", route.Target.String())
+
+	router, err = routing.NewRouter(nil, []string{"app.{source_table}=>{source_db_upper}"}, 2)
+	require.NoError(t, err)
+	require.Equal(t, routing.Target{Schema: "APP"}, router.Resolve("app.orders").Target)
+	// no rule matches, the default target is used
+	require.Equal(t, routing.Route{Table: "auth.users"}, router.Resolve("auth.users"))
+}
+
+func TestNewRouterError(t *testing.T) {
+	for _, tc := range []struct {
+		routes       []string
+		schemaRoutes []string
+		err          string
+	}{
+		{routes: []string{"app.orders"}, err: "expected <source>=><target>"},
+		{routes: []string{"app=>OPS"}, err: "the source must be a table full qualified name"},
+		{routes: []string{"app.*=>OPS"}, err: "the source must be a table full qualified name"},
+		{schemaRoutes: []string{"{source_db}=>{db}"}, err: "unknown variable {db}"},
+		{schemaRoutes: []string{"{source_db}=>A.B.C"}, err: "invalid target A.B.C, expected <database>.<schema> or <schema>"},
+		{routes: []string{"app.orders=>A.B.C.D"}, err: "expected <database>.<schema>.<table>, <database>.<schema> or <schema>"},
+		{schemaRoutes: []string{"{source_db}=>A."}, err: "invalid target A."},
+		{schemaRoutes: []string{"[=>A"}, err: "invalid source pattern ["},
+	} {
+		_, err := routing.NewRouter(tc.routes, tc.schemaRoutes, 2)
+		require.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestResolveTable(t *testing.T) {
+	// the table follows all the levels of the data warehouse
+	router, err := routing.NewRouter([]string{"app.user_profile=>RAW.ANALYTICS.RAW_USER_PROFILE", "app.orders=>RAW.ANALYTICS"}, nil, 2)
+	require.NoError(t, err)
+	require.Equal(t, routing.Target{Database: "RAW", Schema: "ANALYTICS", Table: "RAW_USER_PROFILE"}, router.Resolve("app.user_profile").Target)
+	require.Equal(t, "RAW.ANALYTICS.RAW_USER_PROFILE", router.Resolve("app.user_profile").Target.String())
+	require.Equal(t, routing.Target{Database: "RAW", Schema: "ANALYTICS"}, router.Resolve("app.orders").Target)
+
+	// a data warehouse of schemas or datasets only
+	router, err = routing.NewRouter([]string{"app.user_profile=>raw_app.raw_user_profile", "app.orders=>raw_app"}, []string{"{source_db}=>src_{source_db}"}, 1)
+	require.NoError(t, err)
+	require.Equal(t, routing.Target{Schema: "raw_app", Table: "raw_user_profile"}, router.Resolve("app.user_profile").Target)
+	require.Equal(t, routing.Target{Schema: "raw_app"}, router.Resolve("app.orders").Target)
+	require.Equal(t, routing.Target{Schema: "src_auth"}, router.Resolve("auth.users").Target)
+
+	_, err = routing.NewRouter(nil, []string{"{source_db}=>A.B"}, 1)
+	require.ErrorContains(t, err, "invalid target A.B, expected <schema>")
+	_, err = routing.NewRouter([]string{"app.orders=>A.B.C"}, nil, 1)
+	require.ErrorContains(t, err, "invalid target A.B.C, expected <schema>.<table> or <schema>")
+}
+
+func TestCheckCollisions(t *testing.T) {
+	targets := map[string]routing.Target{
+		"app.user_profile": {Schema: "raw", Table: "user_profile"},
+		"app.orders":       {Schema: "raw"},
+		"crm.orders":       {Schema: "crm"},
+	}
+	require.NoError(t, routing.CheckCollisions(targets))
+
+	// a table routed to the name of another table
+	targets["crm.user_profile"] = routing.Target{Schema: "raw"}
+	require.ErrorContains(t, routing.CheckCollisions(targets), "Tables app.user_profile and crm.user_profile are both replicated to raw.user_profile")
+	delete(targets, "crm.user_profile")
+	targets["crm.orders"] = routing.Target{Schema: "raw", Table: "orders"}
+	require.ErrorContains(t, routing.CheckCollisions(targets), "Tables app.orders and crm.orders are both replicated to raw.orders")
+}
+
+func TestTargetSet(t *testing.T) {
+	set := routing.NewTargetSet()
+	require.NoError(t, set.Add("app.orders", routing.Target{Schema: "raw"}))
+	require.NoError(t, set.Add("crm.orders", routing.Target{Schema: "crm"}))
+	// the snapshot and the increment of a table found later are routed twice
+	require.NoError(t, set.Add("app.orders", routing.Target{Schema: "raw", Table: "orders"}))
+
+	// a table found after the replication starts is checked against the tables routed before
+	require.ErrorContains(t, set.Add("billing.orders", routing.Target{Schema: "raw"}), "Tables app.orders and billing.orders are both replicated to raw.orders")
+	require.NoError(t, set.Add("billing.orders", routing.Target{Schema: "raw", Table: "billing_orders"}))
+}
+
+func TestTargetSetRename(t *testing.T) {
+	set := routing.NewTargetSet()
+	require.NoError(t, set.Add("app.orders", routing.Target{Schema: "raw"}))
+	require.NoError(t, set.Add("app.users", routing.Target{Schema: "raw", Table: "customers"}))
+	require.NoError(t, set.Add("crm.orders", routing.Target{Schema: "raw", Table: "orders_v2"}))
+
+	// the table following its source name is renamed onto the table of another table
+	require.ErrorContains(t, set.Rename("app.orders", "app.orders_v2"), "Tables crm.orders and app.orders_v2 are both replicated to raw.orders_v2")
+	require.NoError(t, set.Rename("app.orders", "app.orders_old"))
+	require.NoError(t, set.Add("app.orders", routing.Target{Schema: "raw"}))
+	require.ErrorContains(t, set.Add("billing.orders_old", routing.Target{Schema: "raw"}), "Tables app.orders_old and billing.orders_old are both replicated to raw.orders_old")
+
+	// the routed table keeps its table
+	require.NoError(t, set.Rename("app.users", "app.members"))
+	require.ErrorContains(t, set.Add("crm.customers", routing.Target{Schema: "raw"}), "Tables app.members and crm.customers are both replicated to raw.customers")
+	// a table not routed before is not checked
+	require.NoError(t, set.Rename("app.unknown", "app.orders_v2"))
+}
+
+func TestDiscoveredTableCollision(t *testing.T) {
+	router, err := routing.NewRouter([]string{"crm.accounts=>RAW.CRM_ACCOUNTS"}, []string{"app=>RAW", "crm=>RAW"}, 1)
+	require.NoError(t, err)
+	set := routing.NewTargetSet()
+	for _, table := range []string{"app.orders", "crm.accounts"} {
+		require.NoError(t, set.Add(table, router.Resolve(table).Target))
+	}
+
+	// a table created in a database replicated by --database is routed by the rules onto the table of app.orders
+	target := router.Resolve("crm.orders").Target
+	require.Equal(t, routing.Target{Schema: "RAW"}, target)
+	require.ErrorContains(t, set.Add("crm.orders", target), "Tables app.orders and crm.orders are both replicated to RAW.orders")
+	// and onto the table crm.accounts is routed to by --route
+	require.ErrorContains(t, set.Add("app.CRM_ACCOUNTS", router.Resolve("app.CRM_ACCOUNTS").Target), "Tables crm.accounts and app.CRM_ACCOUNTS are both replicated to RAW.CRM_ACCOUNTS")
+
+	// a table found again, e.g. its snapshot and then its increment, is not refused
+	require.NoError(t, set.Add("app.orders", router.Resolve("app.orders").Target))
+	require.NoError(t, set.Add("crm.invoices", router.Resolve("crm.invoices").Target))
+}
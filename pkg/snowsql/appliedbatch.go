@@ -0,0 +1,110 @@
+package snowsql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// SetAppliedBatch sets the batch recorded by the next LoadIncrement or LoadIncrementBatch, in the transaction of
+// the MERGE or the append. The files ingested by Snowpipe are recorded right after they are merged.
+func (sc *SnowflakeConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	sc.appliedBatch = batch
+}
+
+// FindAppliedBatch returns the batch of the id recorded in the bookkeeping table, nil if it is not applied
+func (sc *SnowflakeConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if err := sc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	query := appliedbatch.GenFind(sc.gen.QuoteIdent(appliedbatch.TableName), id, utils.QuoteLiteral)
+	batch := &appliedbatch.Batch{ID: id}
+	if err := sc.db.QueryRow(query).Scan(&batch.LastFile, &batch.CommitTs); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, diag.WrapSQL(err, query)
+	}
+	return batch, nil
+}
+
+// ensureAppliedBatchTable creates the bookkeeping table of the applied batches if it does not exist
+func (sc *SnowflakeConnector) ensureAppliedBatchTable() error {
+	if sc.appliedBatchTableCreated {
+		return nil
+	}
+	query := appliedbatch.GenCreateTable(sc.gen.QuoteIdent(appliedbatch.TableName), "VARCHAR", "NUMBER", "TIMESTAMP_LTZ")
+	if _, err := sc.db.Exec(query); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, query), "Failed to create applied batch table")
+	}
+	sc.appliedBatchTableCreated = true
+	return nil
+}
+
+// appliedBatchQueries returns the statements recording the batch set by SetAppliedBatch as applied to the table up
+// to the file, nil if no batch is set
+func (sc *SnowflakeConnector) appliedBatchQueries(table, filePath string) ([]string, error) {
+	batch, err := sc.recordedBatch(table, filePath)
+	if err != nil || batch == nil {
+		return nil, errors.Trace(err)
+	}
+	return sc.gen.genRecordAppliedBatch(*batch), nil
+}
+
+// recordedBatch returns the batch set by SetAppliedBatch as applied to the table up to the file, nil if no batch is
+// set. The bookkeeping table is created for it.
+func (sc *SnowflakeConnector) recordedBatch(table, filePath string) (*appliedbatch.Batch, error) {
+	if sc.appliedBatch == nil {
+		return nil, nil
+	}
+	if err := sc.ensureAppliedBatchTable(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	batch := *sc.appliedBatch
+	batch.Table = table
+	batch.LastFile = filePath
+	return &batch, nil
+}
+
+// genRecordAppliedBatch returns the statements recording the batch in the bookkeeping table
+func (g Generator) genRecordAppliedBatch(batch appliedbatch.Batch) []string {
+	return appliedbatch.GenRecord(g.QuoteIdent(appliedbatch.TableName), batch, "CURRENT_TIMESTAMP()", utils.QuoteLiteral)
+}
+
+// execRecorded runs the query and the statements recording the applied batch in one transaction, the query is run
+// alone if there is nothing to record
+func (sc *SnowflakeConnector) execRecorded(query string, recordQueries []string) (res sql.Result, err error) {
+	if len(recordQueries) == 0 {
+		res, err = sc.db.Exec(query)
+		return res, diag.WrapSQL(err, query)
+	}
+	tx, err := sc.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to begin transaction")
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Warn("Failed to roll back the batch", zap.Error(rollbackErr))
+			}
+		}
+	}()
+	if res, err = tx.Exec(query); err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	for _, recordQuery := range recordQueries {
+		if _, err = tx.Exec(recordQuery); err != nil {
+			return nil, errors.Annotate(diag.WrapSQL(err, recordQuery), "Failed to record the applied batch")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, errors.Annotate(err, "Failed to commit the batch")
+	}
+	return res, nil
+}
@@ -0,0 +1,255 @@
+package snowsql
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql/driver"
+	"encoding/asn1"
+	"encoding/pem"
+	"hash"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/snowflakedb/gosnowflake"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// oauthConnMaxLifetime is how long a connection authenticated by OAuth is kept. The token is only checked
+// at login, after which the session is renewed by Snowflake for 4 hours, so the connections are replaced
+// long before with the token read again.
+const oauthConnMaxLifetime = time.Hour
+
+// CheckAuth checks that at most one auth method is given: the password, which is the default, the key pair
+// or the OAuth token.
+func (config *SnowflakeConfig) CheckAuth() error {
+	var methods []string
+	if config.Pass != "" {
+		methods = append(methods, "--snowflake.pass")
+	}
+	if config.PrivateKeyPath != "" {
+		methods = append(methods, "--snowflake.private-key-path")
+	}
+	if config.OAuthToken != "" {
+		methods = append(methods, "--snowflake.oauth-token")
+	}
+	if config.OAuthTokenFile != "" {
+		methods = append(methods, "--snowflake.oauth-token-file")
+	}
+	if len(methods) > 1 {
+		return errors.Errorf("%s are given, but only one Snowflake auth method can be used: "+
+			"the password by default, the key pair if --snowflake.private-key-path is given, "+
+			"or OAuth if --snowflake.oauth-token or --snowflake.oauth-token-file is given", strings.Join(methods, " and "))
+	}
+	if config.PrivateKeyPassphrase != "" && config.PrivateKeyPath == "" {
+		return errors.New("--snowflake.private-key-passphrase is only available with --snowflake.private-key-path")
+	}
+	return nil
+}
+
+// applyAuth sets the auth method of sfConfig
+func (config *SnowflakeConfig) applyAuth(sfConfig *gosnowflake.Config) error {
+	if err := config.CheckAuth(); err != nil {
+		return errors.Trace(err)
+	}
+	switch {
+	case config.PrivateKeyPath != "":
+		privateKey, err := LoadPrivateKey(config.PrivateKeyPath, config.PrivateKeyPassphrase)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sfConfig.Authenticator = gosnowflake.AuthTypeJwt
+		sfConfig.PrivateKey = privateKey
+	case config.OAuthToken != "" || config.OAuthTokenFile != "":
+		sfConfig.Authenticator = gosnowflake.AuthTypeOAuth
+		sfConfig.Token = config.OAuthToken
+	default:
+		sfConfig.Password = config.Pass
+	}
+	return nil
+}
+
+// newConnector creates the connector of sfConfig, the token of OAuthTokenFile is read for each new connection
+func (config *SnowflakeConfig) newConnector(sfConfig gosnowflake.Config) driver.Connector {
+	connector := gosnowflake.NewConnector(gosnowflake.SnowflakeDriver{}, sfConfig)
+	if config.OAuthTokenFile == "" {
+		return connector
+	}
+	return &tokenFileConnector{Connector: connector, config: sfConfig, tokenFile: config.OAuthTokenFile}
+}
+
+// tokenFileConnector logs in with the OAuth token in tokenFile, which is refreshed by another process,
+// e.g. a sidecar of the identity provider
+type tokenFileConnector struct {
+	gosnowflake.Connector
+	config    gosnowflake.Config
+	tokenFile string
+}
+
+func (c *tokenFileConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to read Snowflake OAuth token")
+	}
+	config := c.config
+	config.Token = strings.TrimSpace(string(token))
+	return gosnowflake.NewConnector(gosnowflake.SnowflakeDriver{}, config).Connect(ctx)
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// LoadPrivateKey reads the RSA private key of key-pair authentication from the PEM file, in PKCS#8 as generated
+// by `openssl pkcs8 -topk8`, encrypted by PBES2 if passphrase is not empty, or in PKCS#1.
+func LoadPrivateKey(path, passphrase string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to read Snowflake private key")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("No PEM block in Snowflake private key %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "ENCRYPTED PRIVATE KEY":
+		if passphrase == "" {
+			return nil, errors.Errorf("Snowflake private key %s is encrypted, --snowflake.private-key-passphrase is required", path)
+		}
+		der, err := decryptPKCS8(block.Bytes, []byte(passphrase))
+		if err != nil {
+			return nil, errors.Annotatef(err, "Failed to decrypt Snowflake private key %s", path)
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			// a wrong passphrase may be padded correctly by chance
+			return nil, errors.Annotatef(err, "Failed to decrypt Snowflake private key %s, the passphrase may be wrong", path)
+		}
+	case "PRIVATE KEY", "RSA PRIVATE KEY":
+		if passphrase != "" {
+			return nil, errors.Errorf("Snowflake private key %s is not encrypted, but --snowflake.private-key-passphrase is given", path)
+		}
+		if block.Type == "RSA PRIVATE KEY" {
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		} else {
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "Failed to parse Snowflake private key %s", path)
+		}
+	default:
+		return nil, errors.Errorf("Snowflake private key %s is a %s, which is not supported", path, block.Type)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("Snowflake private key %s is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+// decryptPKCS8 decrypts the EncryptedPrivateKeyInfo of PKCS#8 by PBES2 with PBKDF2, the only scheme of
+// PKCS#5 v2 generated by OpenSSL by default. github.com/youmark/pkcs8 is not used since it is not in the module
+// graph, the version of gosnowflake required does not depend on it; the keys of `openssl pkcs8 -topk8 -v2` in
+// testdata are decrypted by the tests.
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errors.Errorf("Unsupported encryption %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errors.Errorf("Unsupported key derivation %s, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdfParams.PRF.Algorithm) == 0 || kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, errors.Errorf("Unsupported PBKDF2 PRF %s", kdfParams.PRF.Algorithm)
+	}
+
+	var keyLen int
+	var newCipher func(key []byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, errors.Errorf("Unsupported cipher %s", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	key := pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 || len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, errors.New("Malformed encrypted private key")
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+
+	// PKCS#7 padding, which is wrong if the passphrase is wrong
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("Wrong passphrase")
+	}
+	return plain[:len(plain)-padding], nil
+}
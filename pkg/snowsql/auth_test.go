@@ -0,0 +1,135 @@
+package snowsql_test
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestCheckAuth(t *testing.T) {
+	require.NoError(t, (&snowsql.SnowflakeConfig{Pass: "pass"}).CheckAuth())
+	require.NoError(t, (&snowsql.SnowflakeConfig{PrivateKeyPath: "key.p8", PrivateKeyPassphrase: "secret"}).CheckAuth())
+	require.NoError(t, (&snowsql.SnowflakeConfig{OAuthTokenFile: "token"}).CheckAuth())
+
+	err := (&snowsql.SnowflakeConfig{Pass: "pass", PrivateKeyPath: "key.p8"}).CheckAuth()
+	require.ErrorContains(t, err, "--snowflake.pass and --snowflake.private-key-path are given, but only one Snowflake auth method can be used")
+	err = (&snowsql.SnowflakeConfig{OAuthToken: "token", OAuthTokenFile: "token"}).CheckAuth()
+	require.ErrorContains(t, err, "only one Snowflake auth method can be used")
+	err = (&snowsql.SnowflakeConfig{Pass: "pass", PrivateKeyPassphrase: "secret"}).CheckAuth()
+	require.ErrorContains(t, err, "--snowflake.private-key-passphrase is only available with --snowflake.private-key-path")
+}
+
+// encryptPKCS8 encrypts the key by PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC, as `openssl pkcs8 -topk8 -v2 aes256`
+func encryptPKCS8(t *testing.T, der []byte, passphrase string) []byte {
+	salt, iv := make([]byte, 8), make([]byte, aes.BlockSize)
+	_, err := rand.Read(salt)
+	require.NoError(t, err)
+	_, err = rand.Read(iv)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, 2048, 32, sha256.New))
+	require.NoError(t, err)
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, make([]byte, padding)...)
+	for i := len(der); i < len(plain); i++ {
+		plain[i] = byte(padding)
+	}
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	marshal := func(v any) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		require.NoError(t, err)
+		return asn1.RawValue{FullBytes: b}
+	}
+	kdf := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12},
+		Parameters: marshal(struct {
+			Salt           []byte
+			IterationCount int
+			PRF            pkix.AlgorithmIdentifier
+		}{salt, 2048, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}, Parameters: asn1.NullRawValue}}),
+	}
+	scheme := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}, Parameters: marshal(iv)}
+	info, err := asn1.Marshal(struct {
+		Algorithm     pkix.AlgorithmIdentifier
+		EncryptedData []byte
+	}{
+		pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13},
+			Parameters: marshal(struct {
+				KeyDerivationFunc pkix.AlgorithmIdentifier
+				EncryptionScheme  pkix.AlgorithmIdentifier
+			}{kdf, scheme}),
+		},
+		encrypted,
+	})
+	require.NoError(t, err)
+	return info
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeKey := func(name, blockType string, bytes []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0o600))
+		return path
+	}
+	plainPath := writeKey("key.p8", "PRIVATE KEY", der)
+	pkcs1Path := writeKey("key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	encryptedPath := writeKey("key_encrypted.p8", "ENCRYPTED PRIVATE KEY", encryptPKCS8(t, der, "secret"))
+
+	for _, path := range []string{plainPath, pkcs1Path} {
+		loaded, err := snowsql.LoadPrivateKey(path, "")
+		require.NoError(t, err)
+		require.True(t, key.Equal(loaded))
+	}
+	loaded, err := snowsql.LoadPrivateKey(encryptedPath, "secret")
+	require.NoError(t, err)
+	require.True(t, key.Equal(loaded))
+
+	_, err = snowsql.LoadPrivateKey(encryptedPath, "")
+	require.ErrorContains(t, err, "--snowflake.private-key-passphrase is required")
+	_, err = snowsql.LoadPrivateKey(encryptedPath, "wrong")
+	require.ErrorContains(t, err, "Failed to decrypt Snowflake private key")
+	_, err = snowsql.LoadPrivateKey(plainPath, "secret")
+	require.ErrorContains(t, err, "is not encrypted")
+	_, err = snowsql.LoadPrivateKey(filepath.Join(dir, "missing.p8"), "")
+	require.ErrorContains(t, err, "Failed to read Snowflake private key")
+}
+
+// the keys of testdata are encrypted by OpenSSL 3:
+// openssl pkcs8 -topk8 -in rsa_key.p8 -v2 <cipher> [-v2prf <prf>] -passout pass:secret
+func TestLoadPrivateKeyOpenSSL(t *testing.T) {
+	key, err := snowsql.LoadPrivateKey(filepath.Join("testdata", "rsa_key.p8"), "")
+	require.NoError(t, err)
+
+	for _, name := range []string{"rsa_key_aes256.p8", "rsa_key_aes128_sha1.p8", "rsa_key_des3.p8"} {
+		loaded, err := snowsql.LoadPrivateKey(filepath.Join("testdata", name), "secret")
+		require.NoError(t, err, name)
+		require.True(t, key.Equal(loaded), name)
+		_, err = snowsql.LoadPrivateKey(filepath.Join("testdata", name), "wrong")
+		require.ErrorContains(t, err, "Failed to decrypt Snowflake private key", name)
+	}
+	// PBES1 of `openssl pkcs8 -v1` is not supported
+	_, err = snowsql.LoadPrivateKey(filepath.Join("testdata", "rsa_key_pbes1.p8"), "secret")
+	require.ErrorContains(t, err, "only PBES2 is supported")
+}
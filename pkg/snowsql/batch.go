@@ -0,0 +1,399 @@
+package snowsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// batchLoader loads the new increment files of a table from the stage together. The files are copied into a
+// transient staging table with the columns FILE_NAME, FILE_ROW_NUMBER and C1..Cn as Snowpipe does, and merged
+// into the table by one MERGE. The COPY, the MERGE and the cleanup of the staging table are in one transaction,
+// so the staging table is empty unless a batch is being loaded, or a batch merged by partitions is interrupted.
+type batchLoader struct {
+	db           *sql.DB
+	gen          Generator
+	stageName    string
+	stagingTable string
+	// batchTable has the id of the last batch merged, recorded by the transaction of the batch, so that a batch
+	// retried after its commit is not acknowledged, e.g. the session expires, is not merged again
+	batchTable string
+	// width is the number of columns C1..Cn of the staging table, 0 until it is created
+	width int
+}
+
+func newBatchLoader(db *sql.DB, gen Generator, stageName string) *batchLoader {
+	return &batchLoader{
+		db:           db,
+		gen:          gen,
+		stageName:    stageName,
+		stagingTable: stageName + "_staging",
+		batchTable:   stageName + "_batch",
+	}
+}
+
+// setup creates the staging table with at least the given number of table columns
+func (l *batchLoader) setup(tableColumns int) error {
+	width := snowpipeMetaColumns + tableColumns
+	if l.width == 0 {
+		// the staging rows need no Fail-safe, they are rolled back with the batch
+		query := fmt.Sprintf("CREATE TRANSIENT TABLE IF NOT EXISTS %s (FILE_NAME VARCHAR, FILE_ROW_NUMBER NUMBER)", l.gen.QuoteIdent(l.stagingTable))
+		if _, err := l.db.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to create staging table")
+		}
+		// the ids of a previous process are forgotten, so a workspace started over never skips new files of the
+		// same paths, and a batch replayed from the checkpoint after a restart is merged again by the same MERGE
+		query = fmt.Sprintf("CREATE OR REPLACE TRANSIENT TABLE %s (BATCH_ID VARCHAR)", l.gen.QuoteIdent(l.batchTable))
+		if _, err := l.db.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to create batch table")
+		}
+	}
+	for i := l.width + 1; i <= width; i++ {
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS C%d VARCHAR", l.gen.QuoteIdent(l.stagingTable), i)
+		if _, err := l.db.Exec(query); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, query), "Failed to add column to staging table")
+		}
+	}
+	l.width = max(l.width, width)
+	return nil
+}
+
+// load copies the files of the stage into the staging table and merges them into the table, the rows changed in
+// the table are returned. A file may be copied before, e.g. by a batch rolled back, so the COPY is forced. The
+// applied batch is recorded in the transaction of the MERGE if it is not nil. Up to maxBadRows rows failing to be
+// copied are skipped and returned, the batch is rolled back if there are more. A batch of more than maxMergeRows
+// rows is merged by partitions, see mergePartitions, 0 merges every batch at once.
+func (l *batchLoader) load(tableDef cloudstorage.TableDefinition, stagePaths []string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, applied *appliedbatch.Batch, maxBadRows, maxMergeRows int64) (int64, badrows.Rejects, error) {
+	var rejects badrows.Rejects
+	if err := l.setup(len(tableDef.Columns)); err != nil {
+		return 0, rejects, errors.Trace(err)
+	}
+	batchID := BatchID(tableDef.TableVersion, stagePaths)
+	checkQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE BATCH_ID = %s", l.gen.QuoteIdent(l.batchTable), utils.QuoteLiteral(batchID))
+	var merged int
+	if err := l.db.QueryRow(checkQuery).Scan(&merged); err != nil {
+		return 0, rejects, diag.WrapSQL(err, checkQuery)
+	}
+	if merged > 0 {
+		log.Info("Skip the batch merged before", zap.String("batchID", batchID), zap.Int("files", len(stagePaths)))
+		return 0, rejects, nil
+	}
+	var recordQueries []string
+	if applied != nil {
+		recordQueries = l.gen.genRecordAppliedBatch(*applied)
+	}
+	format := stagingformat.FileFormat(stagePaths[0])
+	fields := make([]string, 0, snowpipeMetaColumns+len(tableDef.Columns))
+	for _, column := range utils.GenIncrementTableColumns(tableDef.Columns) {
+		fields = append(fields, column.Name)
+	}
+	var rows int64
+	partitions := 1
+	err := l.inTx(func(tx *sql.Tx) error {
+		// the rows of a batch merged by partitions are committed before they are merged, they are left if it fails
+		clearQuery := fmt.Sprintf("DELETE FROM %s", l.gen.QuoteIdent(l.stagingTable))
+		if _, err := tx.Exec(clearQuery); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, clearQuery), "Failed to clear staging table")
+		}
+		for start := 0; start < len(stagePaths); start += maxFilesPerCopy {
+			copyQuery := l.gen.GenCopyIntoStaging(l.stagingTable, l.stageName, fields, stagePaths[start:min(start+maxFilesPerCopy, len(stagePaths))], maxBadRows > 0)
+			if maxBadRows == 0 {
+				if _, err := tx.Exec(copyQuery); err != nil {
+					return diag.WrapSQL(err, copyQuery)
+				}
+				continue
+			}
+			results, err := queryCopyResults(tx, copyQuery)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			rejects.Add(CopyRejects(results, tableDef))
+			if err = rejects.Check(maxBadRows); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if maxMergeRows > 0 && hasPK(tableDef) {
+			countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", l.gen.QuoteIdent(l.stagingTable))
+			var staged int64
+			if err := tx.QueryRow(countQuery).Scan(&staged); err != nil {
+				return diag.WrapSQL(err, countQuery)
+			}
+			if partitions = int((staged + maxMergeRows - 1) / maxMergeRows); partitions > 1 {
+				log.Info("Merge the batch by partitions", zap.String("batchID", batchID), zap.Int64("rows", staged), zap.Int("partitions", partitions))
+				return nil
+			}
+		}
+		mergeQuery := l.gen.GenMergeIntoFromBatch(tableDef, l.stagingTable, columnFilter, columnTypes, where, deleteMode, format)
+		res, err := tx.Exec(mergeQuery)
+		if err != nil {
+			return diag.WrapSQL(err, mergeQuery)
+		}
+		rows = utils.RowsAffected(res)
+		return l.recordBatch(tx, batchID, recordQueries)
+	})
+	if err != nil || partitions <= 1 {
+		return rows, rejects, errors.Trace(err)
+	}
+	rows, err = l.mergePartitions(tableDef, batchID, columnFilter, columnTypes, where, deleteMode, format, applied, partitions)
+	return rows, rejects, errors.Trace(err)
+}
+
+// mergePartitions merges the rows of the staging table by one MERGE per partition of the keys, each in its own
+// transaction, so that no MERGE of a very large batch runs into the timeout of the warehouse. The partitions are
+// recorded in the bookkeeping table of the applied batches as they are merged, and a batch of the same files
+// retried, e.g. after a crash, is resumed from the partitions not merged yet. The last partition clears the
+// staging table and records the batch.
+func (l *batchLoader) mergePartitions(tableDef cloudstorage.TableDefinition, batchID string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format, applied *appliedbatch.Batch, partitions int) (int64, error) {
+	merged := make(map[int]bool)
+	var recordQueries []string
+	if applied != nil {
+		recordQueries = l.gen.genRecordAppliedBatch(*applied)
+		query := appliedbatch.GenFindPartitions(l.gen.QuoteIdent(appliedbatch.TableName), batchID, utils.QuoteLiteral)
+		ids, err := l.db.Query(query)
+		if err != nil {
+			return 0, diag.WrapSQL(err, query)
+		}
+		defer ids.Close()
+		for ids.Next() {
+			var id string
+			if err = ids.Scan(&id); err != nil {
+				return 0, errors.Trace(err)
+			}
+			// the partitions of another number of partitions, e.g. --max-merge-rows is changed, are merged again
+			if partition, n, ok := appliedbatch.ParsePartitionID(batchID, id); ok && n == partitions {
+				merged[partition] = true
+			}
+		}
+		if err = ids.Err(); err != nil {
+			return 0, diag.WrapSQL(err, query)
+		}
+	}
+	var rows int64
+	for partition := 0; partition < partitions; partition++ {
+		if merged[partition] {
+			log.Info("Skip the partition merged before", zap.String("batchID", batchID), zap.Int("partition", partition))
+			continue
+		}
+		err := l.inTx(func(tx *sql.Tx) error {
+			mergeQuery := l.gen.GenMergeIntoFromBatchPartition(tableDef, l.stagingTable, columnFilter, columnTypes, where, deleteMode, format, partition, partitions)
+			res, err := tx.Exec(mergeQuery)
+			if err != nil {
+				return diag.WrapSQL(err, mergeQuery)
+			}
+			rows += utils.RowsAffected(res)
+			if partition == partitions-1 {
+				return l.recordBatch(tx, batchID, recordQueries)
+			}
+			if applied == nil {
+				return nil
+			}
+			record := *applied
+			record.ID = appliedbatch.PartitionID(batchID, partition, partitions)
+			for _, recordQuery := range l.gen.genRecordAppliedBatch(record) {
+				if _, err = tx.Exec(recordQuery); err != nil {
+					return errors.Annotate(diag.WrapSQL(err, recordQuery), "Failed to record the partition")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, errors.Annotatef(err, "Failed to merge partition %d of %d", partition, partitions)
+		}
+	}
+	return rows, nil
+}
+
+// recordBatch clears the staging table and records the batch merged by the transaction, with the recordQueries of
+// the applied batch
+func (l *batchLoader) recordBatch(tx *sql.Tx, batchID string, recordQueries []string) error {
+	clearQuery := fmt.Sprintf("DELETE FROM %s", l.gen.QuoteIdent(l.stagingTable))
+	if _, err := tx.Exec(clearQuery); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, clearQuery), "Failed to clear staging table")
+	}
+	for _, recordQuery := range append([]string{
+		fmt.Sprintf("DELETE FROM %s", l.gen.QuoteIdent(l.batchTable)),
+		fmt.Sprintf("INSERT INTO %s (BATCH_ID) VALUES (%s)", l.gen.QuoteIdent(l.batchTable), utils.QuoteLiteral(batchID)),
+	}, recordQueries...) {
+		if _, err := tx.Exec(recordQuery); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, recordQuery), "Failed to record the batch")
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise
+func (l *batchLoader) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := l.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return errors.Annotate(err, "Failed to begin transaction")
+	}
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			log.Warn("Failed to roll back the batch", zap.Error(rollbackErr))
+		}
+		return err
+	}
+	return errors.Annotate(tx.Commit(), "Failed to commit the batch")
+}
+
+// hasPK returns whether the table has a primary key, the keys of a table are hashed into the partitions
+func hasPK(tableDef cloudstorage.TableDefinition) bool {
+	for _, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *batchLoader) close() {
+	for _, table := range []string{l.stagingTable, l.batchTable} {
+		if _, err := l.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", l.gen.QuoteIdent(table))); err != nil {
+			log.Error("fail to drop staging table", zap.String("table", table), zap.Error(err))
+		}
+	}
+}
+
+// BatchID identifies the batch of the files in the stage merged by the table version, the files of a table are
+// never merged again by another batch
+func BatchID(tableVersion uint64, stagePaths []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s", tableVersion, strings.Join(stagePaths, "\n"))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// GenCopyIntoStaging copies every field of the files in the stage as VARCHAR into the staging table, fields are the
+// names of the fields of the files. The files are of the same format. The rows failing to be copied are skipped if
+// continueOnError is set.
+func (g Generator) GenCopyIntoStaging(stagingTable, stageName string, fields []string, stagePaths []string, continueOnError bool) string {
+	format := stagingformat.FileFormat(stagePaths[0])
+	stagingColumns := make([]string, 0, len(fields))
+	fileColumns := make([]string, 0, len(fields))
+	for i, field := range fields {
+		stagingColumns = append(stagingColumns, fmt.Sprintf("C%d", i+1))
+		fileColumns = append(fileColumns, fileField(format, i+1, field))
+	}
+	quotedFiles := make([]string, 0, len(stagePaths))
+	for _, file := range stagePaths {
+		quotedFiles = append(quotedFiles, utils.QuoteLiteral(file))
+	}
+	onError := ""
+	if continueOnError {
+		onError = "\nON_ERROR = CONTINUE"
+	}
+	// the file format of the CSV files is inherited from the stage
+	fileFormat := ""
+	if format == stagingformat.Parquet {
+		fileFormat = fmt.Sprintf("\nFILE_FORMAT = (FORMAT_NAME = '%s')", utils.EscapeString(ParquetFileFormatName(stageName)))
+	}
+	return fmt.Sprintf(`COPY INTO %s (FILE_NAME, FILE_ROW_NUMBER, %s)
+FROM (SELECT METADATA$FILENAME, METADATA$FILE_ROW_NUMBER, %s FROM @%s)
+FILES = (%s)%s
+FORCE = TRUE%s;`,
+		g.QuoteIdent(stagingTable),
+		strings.Join(stagingColumns, ", "),
+		strings.Join(fileColumns, ", "),
+		utils.EscapeString(stageName),
+		strings.Join(quotedFiles, ", "),
+		fileFormat,
+		onError)
+}
+
+// CopyFileResult is the row of a file in the result of a COPY
+type CopyFileResult struct {
+	File       string
+	ErrorsSeen int64
+	// FirstError is the reason of the first row of the file failing to be copied, at FirstErrorLine of the file and
+	// in FirstErrorColumn of the table copied into, e.g. "ORDERS_STAGING"["C5":5]
+	FirstError       string
+	FirstErrorLine   int64
+	FirstErrorColumn string
+}
+
+// queryCopyResults runs the COPY and reads the rows of the files from its result, the result of a COPY finding no
+// file has no row of a file
+func queryCopyResults(tx *sql.Tx, copyQuery string) ([]CopyFileResult, error) {
+	rows, err := tx.Query(copyQuery)
+	if err != nil {
+		return nil, diag.WrapSQL(err, copyQuery)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var results []CopyFileResult
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, errors.Trace(err)
+		}
+		fields := make(map[string]string, len(columns))
+		for i, column := range columns {
+			fields[strings.ToLower(column)] = values[i].String
+		}
+		if fields["file"] == "" {
+			continue
+		}
+		result := CopyFileResult{
+			File:             fields["file"],
+			FirstError:       fields["first_error"],
+			FirstErrorColumn: fields["first_error_column_name"],
+		}
+		result.ErrorsSeen, _ = strconv.ParseInt(fields["errors_seen"], 10, 64)
+		result.FirstErrorLine, _ = strconv.ParseInt(fields["first_error_line"], 10, 64)
+		results = append(results, result)
+	}
+	return results, errors.Trace(rows.Err())
+}
+
+// stagingColumnPattern matches the column of the staging table in the column name of a COPY error
+var stagingColumnPattern = regexp.MustCompile(`\["C(\d+)":`)
+
+// CopyRejects returns the rows of the files rejected by a COPY into the staging table with ON_ERROR = CONTINUE,
+// Snowflake only tells the first of them in each file. The columns are named by the columns of the table.
+func CopyRejects(results []CopyFileResult, tableDef cloudstorage.TableDefinition) badrows.Rejects {
+	var rejects badrows.Rejects
+	for _, result := range results {
+		if result.ErrorsSeen == 0 {
+			continue
+		}
+		rejects.Count += result.ErrorsSeen
+		column := result.FirstErrorColumn
+		if match := stagingColumnPattern.FindStringSubmatch(column); match != nil {
+			// C1..C4 are the meta columns of the increment files
+			if i, err := strconv.Atoi(match[1]); err == nil && i > snowpipeMetaColumns && i-snowpipeMetaColumns <= len(tableDef.Columns) {
+				column = tableDef.Columns[i-snowpipeMetaColumns-1].Name
+			}
+		}
+		rejects.Rows = append(rejects.Rows, badrows.Row{
+			File:   result.File,
+			Line:   result.FirstErrorLine,
+			Column: column,
+			Reason: result.FirstError,
+		})
+	}
+	return rejects
+}
@@ -0,0 +1,206 @@
+package snowsql_test
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenCopyIntoStaging(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	fields := []string{"tidb2dw_flag", "tidb2dw_tablename", "tidb2dw_schemaname", "tidb2dw_commit_ts", "id", "note"}
+	query := gen.GenCopyIntoStaging("increment_external_orders_staging", "increment_external_orders", fields,
+		[]string{"app/orders/1/CDC000001.csv", "app/orders/1/CDC000002.csv"}, false)
+	require.Contains(t, query, `COPY INTO "INCREMENT_EXTERNAL_ORDERS_STAGING" (FILE_NAME, FILE_ROW_NUMBER, C1, C2, C3, C4, C5, C6)`)
+	require.Contains(t, query, "SELECT METADATA$FILENAME, METADATA$FILE_ROW_NUMBER, $1, $2, $3, $4, $5, $6 FROM @increment_external_orders")
+	require.Contains(t, query, "FILES = ('app/orders/1/CDC000001.csv', 'app/orders/1/CDC000002.csv')")
+	// the files of a batch rolled back are copied again
+	require.Contains(t, query, "FORCE = TRUE;")
+
+	query = gen.GenCopyIntoStaging("increment_external_orders_staging", "increment_external_orders", fields,
+		[]string{"app/orders/1/CDC000001.csv"}, true)
+	require.Contains(t, query, "FORCE = TRUE\nON_ERROR = CONTINUE;")
+
+	// the fields of the Parquet files are read by their names with the Parquet file format
+	query = gen.GenCopyIntoStaging("increment_external_orders_staging", "increment_external_orders", fields,
+		[]string{"app/orders/1/CDC000001.parquet"}, false)
+	require.Contains(t, query, `$1:"id", $1:"note" FROM @increment_external_orders`)
+	require.Contains(t, query, "FILE_FORMAT = (FORMAT_NAME = 'increment_external_orders_parquet')")
+}
+
+func TestCopyRejects(t *testing.T) {
+	tableDef := cloudstorage.TableDefinition{
+		Table: "orders",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "note", Tp: "varchar"},
+		},
+	}
+	rejects := snowsql.CopyRejects([]snowsql.CopyFileResult{
+		{File: "s3://bucket/increment/app/orders/1/CDC000001.csv"},
+		{
+			File:             "s3://bucket/increment/app/orders/1/CDC000002.csv",
+			ErrorsSeen:       3,
+			FirstError:       "Invalid UTF8 detected in string '0xFF'",
+			FirstErrorLine:   7,
+			FirstErrorColumn: `"INCREMENT_EXTERNAL_ORDERS_STAGING"["C6":8]`,
+		},
+		{
+			File:             "s3://bucket/increment/app/orders/1/CDC000003.csv",
+			ErrorsSeen:       1,
+			FirstError:       "Number of columns in file (5) does not match that of the corresponding table (6)",
+			FirstErrorLine:   2,
+			FirstErrorColumn: `"INCREMENT_EXTERNAL_ORDERS_STAGING"["FILE_NAME":1]`,
+		},
+	}, tableDef)
+	// only the first error of a file is detailed
+	require.Equal(t, int64(4), rejects.Count)
+	require.Len(t, rejects.Rows, 2)
+	require.Equal(t, "s3://bucket/increment/app/orders/1/CDC000002.csv", rejects.Rows[0].File)
+	require.Equal(t, int64(7), rejects.Rows[0].Line)
+	require.Equal(t, "note", rejects.Rows[0].Column)
+	require.Equal(t, "Invalid UTF8 detected in string '0xFF'", rejects.Rows[0].Reason)
+	require.Equal(t, `"INCREMENT_EXTERNAL_ORDERS_STAGING"["FILE_NAME":1]`, rejects.Rows[1].Column)
+}
+
+func TestGenMergeIntoFromBatch(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "orders",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "amount", Tp: "decimal"},
+		},
+	}
+	query := gen.GenMergeIntoFromBatch(tableDef, "increment_external_orders_staging", nil, nil, "", deletemode.Hard, stagingformat.CSV)
+	require.Contains(t, query, `C5 AS "ID"`)
+	require.Contains(t, query, `C6 AS "AMOUNT"`)
+	require.Contains(t, query, `FROM "INCREMENT_EXTERNAL_ORDERS_STAGING"`)
+	// the latest row of a key in all the files is merged
+	require.Contains(t, query, `QUALIFY row_number() over (partition by "ID" order by TO_NUMBER(C4) desc, CASE WHEN C1 = 'D' THEN 0 ELSE 1 END desc, FILE_NAME desc, FILE_ROW_NUMBER desc) = 1`)
+	require.Contains(t, query, `MERGE INTO "ORDERS" AS T USING`)
+}
+
+func TestGenMergeIntoFromBatchPartition(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "order_items",
+		Columns: []cloudstorage.TableCol{
+			{Name: "order_id", Tp: "int", IsPK: "true"},
+			{Name: "note", Tp: "varchar"},
+			{Name: "item_id", Tp: "int", IsPK: "true"},
+		},
+	}
+	query := gen.GenMergeIntoFromBatchPartition(tableDef, "increment_external_order_items_staging", nil, nil, "", deletemode.Hard, stagingformat.CSV, 2, 3)
+	// the rows are filtered by the fields of the key before the latest row of each key is chosen
+	require.Contains(t, query, `FROM "INCREMENT_EXTERNAL_ORDER_ITEMS_STAGING"
+			WHERE MOD(ABS(HASH(C5, C7)), 3) = 2
+			QUALIFY row_number() over (partition by "ORDER_ID", "ITEM_ID" order by TO_NUMBER(C4) desc, CASE WHEN C1 = 'D' THEN 0 ELSE 1 END desc, FILE_NAME desc, FILE_ROW_NUMBER desc) = 1`)
+	require.Contains(t, query, `MERGE INTO "ORDER_ITEMS" AS T USING`)
+}
+
+// change is a row of the increment files of a key, with the fields the merges order the changes by
+type change struct {
+	commitTs int
+	flag     string
+	fileName string
+	row      int
+}
+
+// latestChange returns the change of a key the merge keeps, by the order of QUALIFY in the query
+func latestChange(t *testing.T, query string, changes []change) change {
+	matches := regexp.MustCompile(`order by (.+)\) = 1`).FindStringSubmatch(query)
+	require.Len(t, matches, 2, query)
+	terms := strings.Split(matches[1], ", ")
+	sorted := slices.Clone(changes)
+	slices.SortStableFunc(sorted, func(a, b change) int {
+		for _, term := range terms {
+			expr, ok := strings.CutSuffix(term, " desc")
+			require.True(t, ok, term)
+			var c int
+			switch expr {
+			case "TO_NUMBER(C4)", "$4", `$1:"tidb2dw_commit_ts"`:
+				c = cmp.Compare(a.commitTs, b.commitTs)
+			case "CASE WHEN C1 = 'D' THEN 0 ELSE 1 END", "CASE WHEN $1 = 'D' THEN 0 ELSE 1 END", `CASE WHEN $1:"tidb2dw_flag" = 'D' THEN 0 ELSE 1 END`:
+				c = cmp.Compare(boolToInt(a.flag != "D"), boolToInt(b.flag != "D"))
+			case "FILE_NAME":
+				c = cmp.Compare(a.fileName, b.fileName)
+			case "FILE_ROW_NUMBER", "METADATA$FILE_ROW_NUMBER":
+				c = cmp.Compare(a.row, b.row)
+			default:
+				require.FailNow(t, "unknown order", expr)
+			}
+			if c != 0 {
+				return -c
+			}
+		}
+		return 0
+	})
+	return sorted[0]
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestGenMergeIntoLatestChange(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "orders",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "code", Tp: "varchar"},
+		},
+	}
+	// a key inserted, then its unique key updated, which TiCDC splits into a delete and an insert of the same
+	// commit ts written in either order, and at last deleted
+	updated := []change{
+		{commitTs: 100, flag: "I", fileName: "app/orders/1/CDC000001.csv", row: 1},
+		{commitTs: 200, flag: "I", fileName: "app/orders/1/CDC000001.csv", row: 2},
+		{commitTs: 200, flag: "D", fileName: "app/orders/1/CDC000001.csv", row: 3},
+	}
+	deleted := append(slices.Clone(updated), change{commitTs: 300, flag: "D", fileName: "app/orders/1/CDC000001.csv", row: 4})
+	// the change of the same commit ts and flag in a later file wins, e.g. a file written again by TiCDC
+	rewritten := append(slices.Clone(updated), change{commitTs: 200, flag: "I", fileName: "app/orders/1/CDC000002.csv", row: 1})
+
+	queries := map[string]string{
+		"stage":   gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", nil, nil, "", deletemode.Hard),
+		"parquet": gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.parquet", "increment_external_orders", nil, nil, "", deletemode.Hard),
+		"staging": gen.GenMergeIntoFromStaging(tableDef, "INCREMENT_STAGING_APP_ORDERS", "app/orders/1/CDC000001.csv", nil, nil, "", deletemode.Hard),
+		"batch":   gen.GenMergeIntoFromBatch(tableDef, "INCREMENT_EXTERNAL_ORDERS_STAGING", nil, nil, "", deletemode.Hard, stagingformat.CSV),
+	}
+	for name, query := range queries {
+		// the insert of the update wins over the delete of the same commit ts
+		require.Equal(t, updated[1], latestChange(t, query, updated), name)
+		require.Equal(t, updated[1], latestChange(t, query, []change{updated[2], updated[1], updated[0]}), name)
+		require.Equal(t, deleted[3], latestChange(t, query, deleted), name)
+		// the merged change is either upserted or deleted
+		require.Equal(t, 1, strings.Count(query, "WHEN MATCHED AND S.METADATA$FLAG != 'D' THEN"), name)
+		require.Equal(t, 1, strings.Count(query, "WHEN MATCHED AND S.METADATA$FLAG = 'D' THEN"), name)
+		if name == "batch" || name == "staging" {
+			require.Equal(t, rewritten[3], latestChange(t, query, rewritten), name)
+		}
+	}
+}
+
+func TestBatchID(t *testing.T) {
+	paths := []string{"app/orders/1/CDC000001.csv", "app/orders/1/CDC000002.csv"}
+	id := snowsql.BatchID(1, paths)
+	require.Len(t, id, 32)
+	// a retried batch has the same id
+	require.Equal(t, id, snowsql.BatchID(1, []string{"app/orders/1/CDC000001.csv", "app/orders/1/CDC000002.csv"}))
+	require.NotEqual(t, id, snowsql.BatchID(2, paths))
+	require.NotEqual(t, id, snowsql.BatchID(1, paths[:1]))
+}
@@ -14,21 +14,29 @@ type SnowflakeConfig struct {
 	Warehouse string
 	User      string
 	Pass      string
-	Database  string
-	Schema    string
+	// PrivateKeyPath is the PEM file of the RSA private key of key-pair authentication, it is encrypted
+	// if PrivateKeyPassphrase is set
+	PrivateKeyPath       string
+	PrivateKeyPassphrase string
+	// OAuthToken is the access token of external OAuth, OAuthTokenFile is read again for each new connection
+	// so that the token can be refreshed while the replication is running
+	OAuthToken     string
+	OAuthTokenFile string
+	Database       string
+	Schema         string
+	// CreateSchema creates the database and the schema if they do not exist, otherwise a missing schema fails
+	// OpenDB
+	CreateSchema bool
 }
 
-func testSnowflakeConnection(sfConfig *gosnowflake.Config) (*sql.DB, error) {
-	dsn, err := gosnowflake.DSN(sfConfig)
-	if err != nil {
-		return nil, errors.Annotate(err, "Failed to generate Snowflake DSN")
-	}
-	db, err := sql.Open("snowflake", dsn)
-	if err != nil {
-		return nil, errors.Annotate(err, "Failed to open Snowflake connection")
+func (config *SnowflakeConfig) testSnowflakeConnection(sfConfig gosnowflake.Config) (*sql.DB, error) {
+	db := sql.OpenDB(config.newConnector(sfConfig))
+	if sfConfig.Authenticator == gosnowflake.AuthTypeOAuth {
+		db.SetConnMaxLifetime(oauthConnMaxLifetime)
 	}
 	// make sure the connection is available
-	if err = db.Ping(); err != nil {
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, errors.Annotate(err, "Failed to open Snowflake connection")
 	}
 	return db, nil
@@ -39,28 +47,36 @@ func testSnowflakeConnection(sfConfig *gosnowflake.Config) (*sql.DB, error) {
 // Open a connection to Snowflake.
 func (config *SnowflakeConfig) OpenDB() (*sql.DB, error) {
 	sfConfig := gosnowflake.Config{
-		Account:  config.AccountId,
-		User:     config.User,
-		Password: config.Pass,
+		Account: config.AccountId,
+		User:    config.User,
 	}
-	db, err := testSnowflakeConnection(&sfConfig)
-	if err != nil {
-		return nil, err
+	if err := config.applyAuth(&sfConfig); err != nil {
+		return nil, errors.Trace(err)
 	}
-	// make sure database exists, if not then create
-	_, err = db.Exec("CREATE DATABASE IF NOT EXISTS IDENTIFIER(?)", config.Database)
+	db, err := config.testSnowflakeConnection(sfConfig)
 	if err != nil {
-		return nil, errors.Annotate(err, "Failed to create database")
+		return nil, err
 	}
-	// make sure schema exists, if not then create
-	_, err = db.Exec("CREATE SCHEMA IF NOT EXISTS IDENTIFIER(?)", fmt.Sprintf("%s.%s", config.Database, config.Schema))
-	if err != nil {
-		return nil, errors.Annotate(err, "Failed to create schema")
+	schema := fmt.Sprintf("%s.%s", config.Database, config.Schema)
+	if config.CreateSchema {
+		// make sure database exists, if not then create
+		_, err = db.Exec("CREATE DATABASE IF NOT EXISTS IDENTIFIER(?)", config.Database)
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to create database")
+		}
+		// make sure schema exists, if not then create
+		_, err = db.Exec("CREATE SCHEMA IF NOT EXISTS IDENTIFIER(?)", schema)
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to create schema")
+		}
+	} else if _, err = db.Exec("DESCRIBE SCHEMA IDENTIFIER(?)", schema); err != nil {
+		db.Close()
+		return nil, errors.Annotatef(err, "Snowflake schema %s is not found, create it or set --create-target-schema", schema)
 	}
 	sfConfig.Database = config.Database
 	sfConfig.Schema = config.Schema
 	sfConfig.Warehouse = config.Warehouse
-	db, err = testSnowflakeConnection(&sfConfig)
+	db, err = config.testSnowflakeConnection(sfConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -7,8 +7,23 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/sqlaudit"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
@@ -18,33 +33,144 @@ import (
 type SnowflakeConnector struct {
 	// db is the connection to snowflake.
 	db *sql.DB
+	// gen generates the statements, the names are uppercased unless another identifier case is set
+	gen Generator
 
 	stageName string
 
-	s3Credentials *credentials.Value
+	// s3Credentials provides the credentials of the external stage, they are resolved again before each load so
+	// that the stage is recreated once temporary credentials are rotated
+	s3Credentials *credentials.Credentials
+	// stageURL and stageEndpoint are of the external stage, empty for the internal stage
+	stageURL      string
+	stageEndpoint string
+	// stageCredentials are the credentials the external stage is created with
+	stageCredentials credentials.Value
+
+	// compression is the codec of the CSV files in the stage
+	compression utils.Compression
+
+	// internalStage is true if the files are uploaded to an internal stage by PUT
+	internalStage bool
+	// storagePath is the path of the storage the stage is created for, the files under its sub paths, e.g. of the
+	// shards of the increment, are in the sub paths of the stage
+	storagePath string
+
+	// snowpipe loads the increment files in LoadModeSnowpipe, nil in LoadModeCopy
+	snowpipe *snowpipeLoader
+	// batch loads the increment files of a round together in LoadModeCopy, nil until the first batch
+	batch *batchLoader
+	// parquetFileFormat is true once the file format of the Parquet files of the stage is created
+	parquetFileFormat bool
 
 	columns []cloudstorage.TableCol
+	// columnTypes overrides the types of the columns, nil if the default mapping is used
+	columnTypes columnmapping.Columns
+	// columnFilter is the columns replicated, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows replicated, empty if all the rows are replicated
+	where string
+	// layout is the clustering of the table created, the zero Layout if the table is not clustered
+	layout tablelayout.Layout
+	// routedTable is the table the source table is replicated to, empty if it is replicated to the table of its name
+	routedTable string
+	// dedupKey is the key the table is created with if the source table has no primary key, nil if there is none
+	dedupKey []string
+	// deleteMode is how the rows deleted in TiDB are deleted in the table, the zero Mode deletes them
+	deleteMode deletemode.Mode
+	// incrementMode is how the increment files are applied, the zero Mode merges them into the table
+	incrementMode incrementmode.Mode
+	// changelogTable is the changelog table created in incrementmode.Append, empty until it is created
+	changelogTable string
+	// mergedRows is the total rows changed by the merges of the increment files, or appended in incrementmode.Append
+	mergedRows int64
+	// maxBadRows is the rows of a batch of increment files skipped if they fail to be copied, 0 fails the batch
+	maxBadRows int64
+	// maxMergeRows is the rows of a batch of increment files merged by one MERGE, 0 merges every batch at once
+	maxMergeRows int64
+	// badRows are the rows skipped by the last batch
+	badRows badrows.Rejects
+	// appliedBatch is the batch recorded by the loads of the increment files, nil if they are not recorded
+	appliedBatch *appliedbatch.Batch
+	// appliedBatchTableCreated is true once the bookkeeping table of the applied batches is created
+	appliedBatchTableCreated bool
 }
 
-func NewSnowflakeConnector(db *sql.DB, stageName string, storageURI *url.URL, credentials *credentials.Value) (*SnowflakeConnector, error) {
+func NewSnowflakeConnector(db *sql.DB, identifierCase identcase.Case, stageName string, storageURI *url.URL, credentials *credentials.Credentials, compression utils.Compression) (*SnowflakeConnector, error) {
+	sc := &SnowflakeConnector{
+		db:            db,
+		gen:           NewGenerator(identifierCase),
+		stageName:     stageName,
+		s3Credentials: credentials,
+		compression:   compression,
+		internalStage: storageURI.Host == "",
+		storagePath:   storageURI.Path,
+		columns:       nil,
+	}
 	// create stage
-	var err error
-	if storageURI.Host == "" {
-		err = CreateInternalStage(db, stageName)
-	} else {
-		stageUrl := fmt.Sprintf("%s://%s%s", storageURI.Scheme, storageURI.Host, storageURI.Path)
-		err = CreateExternalStage(db, stageName, stageUrl, credentials)
+	if sc.internalStage {
+		if err := CreateInternalStage(db, stageName, compression); err != nil {
+			return nil, errors.Annotate(err, "Failed to create stage")
+		}
+		return sc, nil
 	}
-	if err != nil {
+	scheme := storageURI.Scheme
+	if endpointURL := storageURI.Query().Get("endpoint"); endpointURL != "" {
+		// S3-compatible storage is referenced by s3compat:// and the endpoint host
+		parsed, err := url.Parse(endpointURL)
+		if err != nil {
+			return nil, errors.Annotate(err, "Failed to parse S3 endpoint")
+		}
+		scheme, sc.stageEndpoint = "s3compat", parsed.Host
+	}
+	sc.stageURL = fmt.Sprintf("%s://%s%s", scheme, storageURI.Host, storageURI.Path)
+	if err := sc.refreshStage(true); err != nil {
 		return nil, errors.Annotate(err, "Failed to create stage")
 	}
+	return sc, nil
+}
 
-	return &SnowflakeConnector{
-		db:            db,
-		stageName:     stageName,
-		s3Credentials: credentials,
-		columns:       nil,
-	}, nil
+// refreshStage creates the external stage with the credentials resolved, it is recreated only if the credentials
+// are rotated since it was created unless force is set
+func (sc *SnowflakeConnector) refreshStage(force bool) error {
+	if sc.internalStage || sc.stageURL == "" {
+		return nil
+	}
+	value, err := sc.s3Credentials.Get()
+	if err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to resolve the AWS credentials of the stage"))
+	}
+	if !force && value == sc.stageCredentials {
+		return nil
+	}
+	if err = CreateExternalStage(sc.db, sc.stageName, sc.stageURL, sc.stageEndpoint, value, sc.compression); err != nil {
+		return errors.Trace(err)
+	}
+	if !force {
+		log.Info("Recreated the stage with the AWS credentials rotated", zap.String("stage", sc.stageName))
+	}
+	sc.stageCredentials = value
+	return nil
+}
+
+// NewSnowflakeSchemaConnector returns a connector creating and altering the tables only, it has no stage to load
+// the files from
+func NewSnowflakeSchemaConnector(db *sql.DB, identifierCase identcase.Case) *SnowflakeConnector {
+	return &SnowflakeConnector{db: db, gen: NewGenerator(identifierCase)}
+}
+
+// EnableSnowpipe loads the increment files by Snowpipe auto-ingest instead of COPY, the pipe is
+// created when the schema is initialized. It falls back to COPY if the privileges are missing.
+func (sc *SnowflakeConnector) EnableSnowpipe(config *SnowflakeConfig, sourceDatabase, sourceTable string) error {
+	if sc.internalStage {
+		return errors.New("Snowpipe auto-ingest requires an external stage on S3")
+	}
+	client, err := newSnowpipeClient(config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc.snowpipe = newSnowpipeLoader(sc.db, sc.gen, client, config, sc.stageName, sourceDatabase, sourceTable, sc.compression)
+	return nil
 }
 
 func (sc *SnowflakeConnector) InitSchema(columns []cloudstorage.TableCol) error {
@@ -56,17 +182,49 @@ func (sc *SnowflakeConnector) InitSchema(columns []cloudstorage.TableCol) error
 	}
 	sc.columns = columns
 	log.Info("table columns initialized", zap.Any("Columns", columns))
+	if sc.snowpipe != nil {
+		if err := sc.snowpipe.setup(len(columns)); err != nil {
+			if !isInsufficientPrivileges(err) {
+				return errors.Trace(err)
+			}
+			log.Warn("Insufficient privileges to create Snowpipe, fall back to COPY", zap.Error(err))
+			sc.snowpipe = nil
+		}
+	}
 	return nil
 }
 
 func (sc *SnowflakeConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
-	if len(sc.columns) == 0 {
+	// a table created after the changefeed starts has no columns before its CREATE TABLE
+	if len(sc.columns) == 0 && tableDef.Type != timodel.ActionCreateTable {
 		return errors.New("Columns not initialized. Maybe you execute a DDL before all DMLs, which is not supported now.")
 	}
-	ddls, err := GenDDLViaColumnsDiff(sc.columns, tableDef)
+	if sc.routedTable != "" && tidbsql.IsRenameTable(tableDef.Type) {
+		log.Info("Skip rename table ddl, the routed table keeps its name", zap.String("ddl", tableDef.Query), zap.String("table", sc.routedTable))
+		return nil
+	}
+	if sc.snowpipe != nil && tidbsql.IsRenameTable(tableDef.Type) {
+		// the pipe only ingests the files of the table by its name when the pipe is created
+		return tidbsql.NewUnsupportedDDLError("Received rename table ddl %s, which is not supported with Snowpipe", tableDef.Query)
+	}
+	// the changes of the columns filtered out are ignored
+	ddls, err := sc.gen.GenDDLViaColumnsDiff(sc.columnFilter.Columns(sc.columns), sc.columnFilter.TableDef(sc.routeTableDef(tableDef)), sc.columnTypes, sc.layout, sc.deleteMode)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if sc.incrementMode == incrementmode.Append && len(sc.columns) != 0 && incrementmode.EvolvesChangelog(tableDef.Type) {
+		// the changelog table evolves by the same diff, it is created by the columns before the DDL if not yet
+		changelogDef := sc.columnFilter.TableDef(sc.routeTableDef(tableDef))
+		if err = sc.ensureChangelog(changelogDef.Table, sc.columns); err != nil {
+			return errors.Trace(err)
+		}
+		changelogDef.Table = incrementmode.ChangelogTable(changelogDef.Table)
+		changelogDDLs, err := sc.gen.GenDDLViaColumnsDiff(sc.columnFilter.Columns(sc.columns), changelogDef, sc.columnTypes, tablelayout.Layout{}, deletemode.Hard)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		ddls = append(ddls, changelogDDLs...)
+	}
 	if len(ddls) == 0 {
 		log.Info("No need to execute this DDL in Snowflake", zap.String("ddl", tableDef.Query))
 		return nil
@@ -75,76 +233,497 @@ func (sc *SnowflakeConnector) ExecDDL(tableDef cloudstorage.TableDefinition) err
 	for _, ddl := range ddls {
 		_, err := sc.db.Exec(ddl)
 		if err != nil {
+			if appliedDDLErrors.IsApplied(ddl, err) {
+				log.Warn("Skip DDL whose effect is already present, it is executed before a failure", zap.String("ddl", ddl), zap.Error(err))
+				continue
+			}
 			log.Error("Failed to executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
-			return errors.Annotate(err, fmt.Sprint("failed to execute", ddl))
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
 		}
 	}
 	// update columns
 	sc.columns = tableDef.Columns
+	if sc.snowpipe != nil && snowpipeMetaColumns+len(sc.columns) > sc.snowpipe.width {
+		// the pipe is recreated to ingest the new columns
+		if err := sc.snowpipe.setup(len(sc.columns)); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	log.Info("Successfully executed DDL", zap.String("received", tableDef.Query), zap.String("rewritten", strings.Join(ddls, "\n")))
 	return nil
 }
 
+// SetColumnTypes overrides the types of the columns of the table in Snowflake, nil means the default mapping
+func (sc *SnowflakeConnector) SetColumnTypes(columnTypes columnmapping.Columns) {
+	sc.columnTypes = columnTypes
+}
+
+// SetColumnFilter filters the columns of the table replicated to Snowflake, nil means all the columns
+func (sc *SnowflakeConnector) SetColumnFilter(columnFilter *columnfilter.Filter) {
+	sc.columnFilter = columnFilter
+}
+
+// SetWhere filters the rows of the table replicated to Snowflake by the predicate, empty means all the rows
+func (sc *SnowflakeConnector) SetWhere(where string) {
+	sc.where = where
+}
+
+// SetTableLayout clusters the table created in Snowflake by the clustering columns of the layout
+func (sc *SnowflakeConnector) SetTableLayout(layout tablelayout.Layout) {
+	sc.layout = layout
+}
+
+// SetTargetTable replicates the table to another table in Snowflake, empty means the table of the source table name.
+// The table keeps its name when the source table is renamed.
+func (sc *SnowflakeConnector) SetTargetTable(table string) {
+	sc.routedTable = table
+}
+
+// SetDedupKey creates the table in Snowflake with the columns as its primary key if the source table has none, the
+// increment files of such a table are merged by them
+func (sc *SnowflakeConnector) SetDedupKey(columns []string) {
+	sc.dedupKey = columns
+}
+
+// SetMaxBadRows skips up to n rows of a batch of increment files failing to be copied by COPY ON_ERROR = CONTINUE,
+// the files are copied by the staging table of the batches even if they are loaded one by one
+func (sc *SnowflakeConnector) SetMaxBadRows(n int64) {
+	sc.maxBadRows = n
+}
+
+// SetMaxMergeRows merges a batch of increment files of more than n rows by one MERGE per partition of the primary
+// key, each of at most about n rows, 0 merges every batch by one MERGE. The files are copied by the staging table of
+// the batches even if they are loaded one by one.
+func (sc *SnowflakeConnector) SetMaxMergeRows(n int64) {
+	sc.maxMergeRows = n
+}
+
+// TakeBadRows returns the rows skipped by the last batch of increment files
+func (sc *SnowflakeConnector) TakeBadRows() badrows.Rejects {
+	rejects := sc.badRows
+	sc.badRows = badrows.Rejects{}
+	return rejects
+}
+
+// SetAuditScope labels the statements of the connector in the SQL audit log, if it is enabled
+func (sc *SnowflakeConnector) SetAuditScope(batchID string, schemaVersion uint64) {
+	sqlaudit.SetScope(sc.db, batchID, schemaVersion)
+}
+
+// SetDeleteMode sets how the rows deleted in TiDB are deleted in the table in Snowflake, soft keeps them with a
+// tombstone
+func (sc *SnowflakeConnector) SetDeleteMode(deleteMode deletemode.Mode) {
+	sc.deleteMode = deleteMode
+}
+
+// SetSyncComments sets whether the comments of the tables and the columns in TiDB are replicated into Snowflake,
+// they are replicated by default
+func (sc *SnowflakeConnector) SetSyncComments(sync bool) {
+	sc.gen = sc.gen.WithSyncComments(sync)
+}
+
+// SetIncrementMode sets how the increment files are applied, append appends the changes to the changelog table of
+// the table instead of merging them. It is not supported with Snowpipe.
+func (sc *SnowflakeConnector) SetIncrementMode(incrementMode incrementmode.Mode) {
+	sc.incrementMode = incrementMode
+}
+
+// ensureChangelog creates the changelog table of the table by the columns if it does not exist
+func (sc *SnowflakeConnector) ensureChangelog(table string, columns []cloudstorage.TableCol) error {
+	changelogTable := incrementmode.ChangelogTable(table)
+	if sc.changelogTable == changelogTable {
+		return nil
+	}
+	createQuery, err := sc.gen.GenCreateChangelogSQL(table, sc.columnFilter.Columns(columns), sc.columnTypes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = sc.db.Exec(createQuery); err != nil {
+		return diag.WrapSQL(err, createQuery)
+	}
+	sc.changelogTable = changelogTable
+	return nil
+}
+
+// CheckDeleteMode fails if the table in Snowflake is created in another delete mode, a table not created yet passes
+func (sc *SnowflakeConnector) CheckDeleteMode(targetTable string) error {
+	targetTable = sc.targetTableName(targetTable)
+	columns, err := sc.gen.GetWarehouseColumns(sc.db, targetTable)
+	if err != nil || len(columns) == 0 {
+		return errors.Trace(err)
+	}
+	hasTombstone := false
+	for _, column := range columns {
+		hasTombstone = hasTombstone || strings.EqualFold(column.Name, deletemode.DeletedColumn)
+	}
+	return sc.deleteMode.CheckTable(targetTable, hasTombstone)
+}
+
+// ensureParquetFileFormat creates the file format the Parquet files are read by, once
+func (sc *SnowflakeConnector) ensureParquetFileFormat() error {
+	if sc.parquetFileFormat {
+		return nil
+	}
+	if err := CreateParquetFileFormat(sc.db, sc.stageName); err != nil {
+		return errors.Annotate(err, "Failed to create Parquet file format")
+	}
+	sc.parquetFileFormat = true
+	return nil
+}
+
+// snapshotColumns returns the columns the fields of the snapshot files are copied into, nil if they are all the
+// columns of the table. The tombstone columns of the soft delete mode are left to their defaults.
+func (sc *SnowflakeConnector) snapshotColumns(targetTable string) ([]string, error) {
+	if sc.deleteMode != deletemode.Soft {
+		return nil, nil
+	}
+	columns, err := sc.gen.GetWarehouseColumns(sc.db, targetTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if !deletemode.IsTombstoneColumn(column.Name) {
+			names = append(names, column.Name)
+		}
+	}
+	return names, nil
+}
+
+// targetTableName returns the table in Snowflake the source table is replicated to
+func (sc *SnowflakeConnector) targetTableName(sourceTable string) string {
+	if sc.routedTable != "" {
+		return sc.routedTable
+	}
+	return sourceTable
+}
+
+// routeTableDef returns the table definition of the table in Snowflake
+func (sc *SnowflakeConnector) routeTableDef(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	tableDef.Table = sc.targetTableName(tableDef.Table)
+	return tableDef
+}
+
 func (sc *SnowflakeConnector) CopyTableSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) error {
-	createTableQuery, err := GenCreateSchema(sourceDatabase, sourceTable, sourceTiDBConn)
+	createTableQuery, err := sc.gen.GenCreateSchema(sourceDatabase, sourceTable, sc.targetTableName(sourceTable), sourceTiDBConn, sc.columnTypes, sc.columnFilter, sc.layout, sc.deleteMode, sc.dedupKey)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	log.Info("Creating table in Snowflake", zap.String("query", createTableQuery))
 	_, err = sc.db.Exec(createTableQuery)
 	if err != nil {
-		return errors.Trace(err)
+		return diag.WrapSQL(err, createTableQuery)
+	}
+	// the fields of the Parquet snapshot files are copied by the names of the columns
+	if len(sc.columns) == 0 {
+		if sc.columns, err = tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return errors.Trace(err)
+		}
 	}
 
 	log.Info("Successfully copying table scheme", zap.String("database", sourceDatabase), zap.String("table", sourceTable))
 	return nil
 }
 
-func (sc *SnowflakeConnector) LoadSnapshot(targetTable, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
-	if err := LoadSnapshotFromStage(sc.db, targetTable, sc.stageName, filePrefix, onSnapshotLoadProgress); err != nil {
+func (sc *SnowflakeConnector) LoadSnapshot(targetTable string, files []string, onSnapshotLoadProgress func(loadedRows int64), onFilesLoaded func(files []string) error) error {
+	targetTable = sc.targetTableName(targetTable)
+	if err := sc.refreshStage(false); err != nil {
 		return errors.Trace(err)
 	}
-	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.String("filePrefix", filePrefix))
+	columns, err := sc.snapshotColumns(targetTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(files) > 0 && stagingformat.FileFormat(files[0]) == stagingformat.Parquet {
+		if err = sc.ensureParquetFileFormat(); err != nil {
+			return errors.Trace(err)
+		}
+		if len(sc.columns) == 0 {
+			return errors.New("Columns not initialized, the Parquet files are copied by the names of the columns")
+		}
+		columns = nil
+		for _, column := range sc.columnFilter.Columns(sc.columns) {
+			columns = append(columns, column.Name)
+		}
+	}
+	var loadedRows int64
+	for start := 0; start < len(files); start += maxFilesPerCopy {
+		batch := files[start:min(start+maxFilesPerCopy, len(files))]
+		rows, err := sc.gen.LoadSnapshotFromStage(sc.db, targetTable, columns, sc.stageName, batch, sc.compression, func(rows int64) {
+			if onSnapshotLoadProgress != nil {
+				onSnapshotLoadProgress(loadedRows + rows)
+			}
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		loadedRows += rows
+		if err = onFilesLoaded(batch); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("Successfully load snapshot", zap.String("table", targetTable), zap.Int("files", len(files)))
 	return nil
 }
 
 func (sc *SnowflakeConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
-	if uri.Scheme == "file" {
-		// if the file is local, we need to upload it to stage first
-		putQuery := fmt.Sprintf(`PUT file://%s/%s '@%s/%s';`, uri.Path, filePath, sc.stageName, filePath)
-		_, err := sc.db.Exec(putQuery)
+	tableDef = sc.routeTableDef(tableDef)
+	if sc.snowpipe != nil {
+		rows, err := sc.snowpipe.load(tableDef, filePath, sc.columnFilter, sc.columnTypes, sc.where, sc.deleteMode)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		log.Debug("put file to stage", zap.String("query", putQuery))
+		sc.mergedRows += rows
+		// the MERGE of Snowpipe is not in a transaction, the batch is recorded right after it
+		recordQueries, err := sc.appliedBatchQueries(tableDef.Table, filePath)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, recordQuery := range recordQueries {
+			if _, err = sc.db.Exec(recordQuery); err != nil {
+				return errors.Annotate(diag.WrapSQL(err, recordQuery), "Failed to record the applied batch")
+			}
+		}
+		log.Info("Successfully merge file ingested by Snowpipe", zap.String("file", filePath))
+		return nil
+	}
+
+	if (sc.maxBadRows > 0 || sc.maxMergeRows > 0) && sc.incrementMode != incrementmode.Append {
+		// only COPY skips the bad rows, and only the rows of the staging table are counted
+		return sc.LoadIncrementBatch(tableDef, uri, []string{filePath})
+	}
+
+	if err := sc.refreshStage(false); err != nil {
+		return errors.Trace(err)
+	}
+	stagePath, err := sc.stageFile(uri, filePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if stagingformat.FileFormat(filePath) == stagingformat.Parquet {
+		if err = sc.ensureParquetFileFormat(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	recordQueries, err := sc.appliedBatchQueries(tableDef.Table, filePath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if sc.incrementMode == incrementmode.Append {
+		if err = sc.appendFile(tableDef, stagePath, recordQueries); err != nil {
+			return errors.Trace(err)
+		}
+		if err = sc.unstageFile(uri, stagePath); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("Successfully append file", zap.String("file", filePath))
+		return nil
 	}
 
 	// merge staged file into table
-	mergeQuery := GenMergeInto(tableDef, filePath, sc.stageName)
-	_, err := sc.db.Exec(mergeQuery)
+	mergeQuery := sc.gen.GenMergeInto(tableDef, stagePath, sc.stageName, sc.columnFilter, sc.columnTypes, sc.where, sc.deleteMode)
+	res, err := sc.execRecorded(mergeQuery, recordQueries)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	sc.mergedRows += utils.RowsAffected(res)
 	log.Debug("merge staged file into table", zap.String("query", mergeQuery))
 
-	if uri.Scheme == "file" {
-		// if the file is local, we need to remove it from stage
-		removeQuery := fmt.Sprintf(`REMOVE '@%s/%s';`, sc.stageName, filePath)
-		_, err = sc.db.Exec(removeQuery)
+	if err = sc.unstageFile(uri, stagePath); err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Successfully merge file", zap.String("file", filePath))
+	return nil
+}
+
+// LoadIncrementBatch merges the files by one COPY into a staging table and one MERGE, instead of a MERGE from the
+// stage per file. The files are merged one by one with Snowpipe, which ingests each of them, and appended one by
+// one in incrementmode.Append.
+func (sc *SnowflakeConnector) LoadIncrementBatch(tableDef cloudstorage.TableDefinition, uri *url.URL, filePaths []string) error {
+	if sc.snowpipe != nil || sc.incrementMode == incrementmode.Append {
+		for _, filePath := range filePaths {
+			if err := sc.LoadIncrement(tableDef, uri, filePath); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
+	tableDef = sc.routeTableDef(tableDef)
+	sc.badRows = badrows.Rejects{}
+	if err := sc.refreshStage(false); err != nil {
+		return errors.Trace(err)
+	}
+	stagePaths := make([]string, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		stagePath, err := sc.stageFile(uri, filePath)
 		if err != nil {
 			return errors.Trace(err)
 		}
-		log.Debug("remove file from stage", zap.String("query", removeQuery))
+		stagePaths = append(stagePaths, stagePath)
+	}
+	applied, err := sc.recordedBatch(tableDef.Table, filePaths[len(filePaths)-1])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if stagingformat.FileFormat(filePaths[0]) == stagingformat.Parquet {
+		if err = sc.ensureParquetFileFormat(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if sc.batch == nil {
+		sc.batch = newBatchLoader(sc.db, sc.gen, sc.stageName)
+	}
+	rows, rejects, err := sc.batch.load(tableDef, stagePaths, sc.columnFilter, sc.columnTypes, sc.where, sc.deleteMode, applied, sc.maxBadRows, sc.maxMergeRows)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc.mergedRows += rows
+	sc.badRows = rejects
+	for _, stagePath := range stagePaths {
+		if err = sc.unstageFile(uri, stagePath); err != nil {
+			return errors.Trace(err)
+		}
 	}
+	log.Info("Successfully merge files", zap.Int("files", len(filePaths)),
+		zap.String("first", filePaths[0]), zap.String("last", filePaths[len(filePaths)-1]))
+	return nil
+}
 
-	log.Info("Successfully merge file", zap.String("file", filePath))
+// appendFile appends the rows of the staged file to the changelog table of the table, the applied batch is recorded
+// by the recordQueries in the same transaction
+func (sc *SnowflakeConnector) appendFile(tableDef cloudstorage.TableDefinition, stagePath string, recordQueries []string) error {
+	if err := sc.ensureChangelog(tableDef.Table, tableDef.Columns); err != nil {
+		return errors.Trace(err)
+	}
+	appendQuery := sc.gen.GenAppendInto(tableDef, stagePath, sc.stageName, sc.columnFilter, sc.columnTypes, sc.where)
+	res, err := sc.execRecorded(appendQuery, recordQueries)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	sc.mergedRows += utils.RowsAffected(res)
+	log.Debug("append staged file into changelog table", zap.String("query", appendQuery))
+	return nil
+}
+
+// stageFile returns the path of the increment file in the stage, a local file is uploaded to the internal stage
+func (sc *SnowflakeConnector) stageFile(uri *url.URL, filePath string) (string, error) {
+	stagePath := filePath
+	if subPath := strings.Trim(strings.TrimPrefix(uri.Path, sc.storagePath), "/"); subPath != "" {
+		stagePath = subPath + "/" + filePath
+	}
+	if uri.Scheme == "file" {
+		// if the file is local, we need to upload it to stage first
+		putQuery := fmt.Sprintf(`PUT file://%s/%s '@%s/%s';`, uri.Path, filePath, sc.stageName, stagePath)
+		_, err := sc.db.Exec(putQuery)
+		if err != nil {
+			return "", diag.WrapSQL(err, putQuery)
+		}
+		log.Debug("put file to stage", zap.String("query", putQuery))
+	}
+	return stagePath, nil
+}
+
+// unstageFile removes the local file uploaded to the internal stage once merged
+func (sc *SnowflakeConnector) unstageFile(uri *url.URL, stagePath string) error {
+	if uri.Scheme != "file" {
+		return nil
+	}
+	removeQuery := fmt.Sprintf(`REMOVE '@%s/%s';`, sc.stageName, stagePath)
+	if _, err := sc.db.Exec(removeQuery); err != nil {
+		return diag.WrapSQL(err, removeQuery)
+	}
+	log.Debug("remove file from stage", zap.String("query", removeQuery))
+	return nil
+}
+
+// AggregateTable returns the row count and the sums of the columns of the table, for the validation of the snapshot
+func (sc *SnowflakeConnector) AggregateTable(targetTable string, sumColumns []validation.SumColumn) (*validation.Aggregates, error) {
+	return (&TableVerifier{db: sc.db, gen: sc.gen}).AggregateRange(sc.targetTableName(targetTable), sumColumns, validation.KeyRange{})
+}
+
+// DiffSchema compares the table in Snowflake with the columns replicated to it, nil if the columns are not
+// initialized yet
+func (sc *SnowflakeConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	if len(sc.columns) == 0 {
+		return nil, nil
+	}
+	columns, err := sc.gen.GetWarehouseColumns(sc.db, sc.targetTableName(targetTable))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the tombstone columns of the soft delete mode are not replicated from TiDB
+	actual := make([]tidbsql.WarehouseColumn, 0, len(columns))
+	for _, column := range columns {
+		if !deletemode.IsTombstoneColumn(column.Name) {
+			actual = append(actual, column)
+		}
+	}
+	drift, err := tidbsql.GetSchemaDrift(sc.columnFilter.Columns(sc.columns), actual, func(column cloudstorage.TableCol) (string, error) {
+		return sc.gen.getSnowflakeColumnType(column, sc.columnTypes)
+	})
+	return drift, errors.Trace(err)
+}
+
+// ReconcileSchema alters the table in Snowflake back to the columns replicated to it
+func (sc *SnowflakeConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	targetTable = sc.targetTableName(targetTable)
+	ddls, err := sc.gen.GenDDLViaColumnsDiff(drift.Columns, cloudstorage.TableDefinition{Table: targetTable, Columns: drift.Expected}, sc.columnTypes, sc.layout, sc.deleteMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, ddl := range ddls {
+		if _, err := sc.db.Exec(ddl); err != nil {
+			return errors.Annotate(diag.WrapSQL(err, ddl), fmt.Sprint("failed to execute", ddl))
+		}
+	}
+	log.Info("Successfully reconciled table", zap.String("table", targetTable), zap.String("ddls", strings.Join(ddls, "\n")))
+	return nil
+}
+
+// IsRetryable retries the statements failed by an expired session or token, or by Snowflake being unavailable
+func (sc *SnowflakeConnector) IsRetryable(err error) bool {
+	return IsRetryableError(err)
+}
+
+// DDLActionClasses returns the classes of the DDLs in Snowflake
+func (sc *SnowflakeConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	return ddlActionClasses
+}
+
+func (sc *SnowflakeConnector) MergedRows() int64 {
+	return sc.mergedRows
+}
+
+// ExecStatements executes the statements of --post-snapshot-sql in order
+func (sc *SnowflakeConnector) ExecStatements(statements []string) error {
+	for _, statement := range statements {
+		log.Info("Executing statement", zap.String("query", statement))
+		if _, err := sc.db.Exec(statement); err != nil {
+			return diag.WrapSQL(err, statement)
+		}
+	}
 	return nil
 }
 
 func (sc *SnowflakeConnector) Close() {
+	if sc.snowpipe != nil {
+		sc.snowpipe.close()
+	}
+	if sc.batch != nil {
+		sc.batch.close()
+	}
+	if sc.parquetFileFormat {
+		if err := DropParquetFileFormat(sc.db, sc.stageName); err != nil {
+			log.Error("fail to drop file format", zap.Error(err))
+		}
+	}
 	// drop stage
-	if err := DropStage(sc.db, sc.stageName); err != nil {
-		log.Error("fail to drop stage", zap.Error(err))
+	if sc.stageName != "" {
+		if err := DropStage(sc.db, sc.stageName); err != nil {
+			log.Error("fail to drop stage", zap.Error(err))
+		}
 	}
 	sc.db.Close()
 }
@@ -2,10 +2,13 @@ package snowsql
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	timodel "github.com/pingcap/tidb/parser/model"
@@ -13,10 +16,10 @@ import (
 	"go.uber.org/zap"
 )
 
-func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
+func (g Generator) GetColumnModifyString(diff *tidbsql.ColumnDiff, columnTypes columnmapping.Columns) (string, error) {
 	strs := make([]string, 0, 3)
 	if diff.Before.Tp != diff.After.Tp || diff.Before.Precision != diff.After.Precision || diff.Before.Scale != diff.After.Scale {
-		colStr, err := GetSnowflakeTypeString(*diff.After)
+		colStr, err := g.GetSnowflakeTypeString(*diff.After, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
@@ -24,37 +27,59 @@ func GetColumnModifyString(diff *tidbsql.ColumnDiff) (string, error) {
 	}
 	if diff.Before.Default != diff.After.Default {
 		if diff.After.Default == nil {
-			strs = append(strs, fmt.Sprintf("COLUMN %s DROP DEFAULT", diff.After.Name))
+			strs = append(strs, fmt.Sprintf("COLUMN %s DROP DEFAULT", g.QuoteIdent(diff.After.Name)))
 		} else {
 			log.Warn("Snowflake does not support update column default value", zap.String("column", diff.After.Name), zap.Any("before", diff.Before.Default), zap.Any("after", diff.After.Default))
 		}
 	}
 	if diff.Before.Nullable != diff.After.Nullable {
 		if diff.After.Nullable == "true" {
-			strs = append(strs, fmt.Sprintf("COLUMN %s DROP NOT NULL", diff.After.Name))
+			strs = append(strs, fmt.Sprintf("COLUMN %s DROP NOT NULL", g.QuoteIdent(diff.After.Name)))
 		} else {
-			strs = append(strs, fmt.Sprintf("COLUMN %s SET NOT NULL", diff.After.Name))
+			strs = append(strs, fmt.Sprintf("COLUMN %s SET NOT NULL", g.QuoteIdent(diff.After.Name)))
 		}
 	}
 	return strings.Join(strs, ", "), nil
 }
 
-func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition) ([]string, error) {
+// appliedDDLErrors are the errors of Snowflake telling the effect of a column DDL is already present
+var appliedDDLErrors = tidbsql.AppliedDDLErrors{
+	ColumnExists:  []string{"already exists"},
+	ColumnMissing: []string{"invalid identifier"},
+}
+
+// ddlActionClasses are the classes of the DDLs in Snowflake, which applies all the translatable DDLs, a new
+// default of a column is only logged as Snowflake does not change it
+var ddlActionClasses = tidbsql.DDLActionClasses
+
+// GenDDLViaColumnsDiff generates the DDLs changing the columns of the table from prevColumns to the columns of
+// curTableDef, a column clustering the table by layout is not dropped. A table created has the tombstone columns of
+// the delete mode.
+func (g Generator) GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloudstorage.TableDefinition, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) ([]string, error) {
+	table := g.QuoteIdent(curTableDef.Table)
 	if curTableDef.Type == timodel.ActionTruncateTable {
-		return []string{fmt.Sprintf("TRUNCATE TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("TRUNCATE TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropTable {
-		return []string{fmt.Sprintf("DROP TABLE %s", curTableDef.Table)}, nil
+		return []string{fmt.Sprintf("DROP TABLE %s", table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateTable {
-		return nil, errors.New("Received create table ddl, which should not happen") // FIXME: drop table and create table
+		// the table is created after the changefeed starts, its columns are given by the schema file
+		ddl, err := g.GenCreateTableSQL(curTableDef.Table, curTableDef.Columns, tidbsql.GetPKColumns(curTableDef.Columns), nil, columnTypes, layout, deleteMode)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{ddl}, nil
 	}
-	if curTableDef.Type == timodel.ActionRenameTables {
-		return nil, errors.New("Received rename table ddl, new change data can not be capture by TiCDC any more." +
-			"If you want to rename table, please start a new task to capture the new table") // FIXME: rename table to new table and rename back
+	if tidbsql.IsRenameTable(curTableDef.Type) {
+		_, oldTable, err := tidbsql.GetRenamedFrom(curTableDef)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s", g.QuoteIdent(oldTable), table)}, nil
 	}
 	if curTableDef.Type == timodel.ActionDropSchema {
-		return []string{fmt.Sprintf("DROP SCHEMA %s", curTableDef.Schema)}, nil
+		return []string{fmt.Sprintf("DROP SCHEMA %s", g.QuoteIdent(curTableDef.Schema))}, nil
 	}
 	if curTableDef.Type == timodel.ActionCreateSchema {
 		return nil, errors.New("Received create schema ddl, which should not happen") // FIXME: drop schema and create schema
@@ -69,23 +94,27 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		ddl := ""
 		switch item.Action {
 		case tidbsql.ADD_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", curTableDef.Table)
-			colStr, err := GetSnowflakeColumnString(*item.After)
+			ddl += fmt.Sprintf("ALTER TABLE %s ADD COLUMN ", table)
+			// Snowflake fills the existing rows with the default of the column added
+			colStr, err := g.GetSnowflakeColumnString(tidbsql.WithAddedColumnDefault(curTableDef.Table, *item.After), columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			ddl += colStr
 		case tidbsql.DROP_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", curTableDef.Table, item.Before.Name)
+			if err := layout.CheckDropColumn(item.Before.Name); err != nil {
+				return nil, errors.Trace(err)
+			}
+			ddl += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, g.QuoteIdent(item.Before.Name))
 		case tidbsql.MODIFY_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s MODIFY ", curTableDef.Table)
-			modifyStr, err := GetColumnModifyString(&item)
+			ddl += fmt.Sprintf("ALTER TABLE %s MODIFY ", table)
+			modifyStr, err := g.GetColumnModifyString(&item, columnTypes)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
 			ddl += modifyStr
 		case tidbsql.RENAME_COLUMN:
-			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", curTableDef.Table, item.Before.Name, item.After.Name)
+			ddl += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, g.QuoteIdent(item.Before.Name), g.QuoteIdent(item.After.Name))
 		default:
 			// UNCHANGE
 		}
@@ -95,26 +124,43 @@ func GenDDLViaColumnsDiff(prevColumns []cloudstorage.TableCol, curTableDef cloud
 		}
 	}
 
+	ddls = append(ddls, g.genCommentDDLs(curTableDef)...)
+
 	// TODO: handle primary key
 	return ddls, nil
 }
 
-func getDefaultString(val interface{}) string {
-	_, err := strconv.ParseFloat(fmt.Sprintf("%v", val), 64)
-	if err != nil {
-		return fmt.Sprintf("'%v'", val) // FIXME: escape
+// genCommentDDLs returns the DDLs applying the comments set by the DDL, none if the comments are not replicated
+func (g Generator) genCommentDDLs(tableDef cloudstorage.TableDefinition) []string {
+	if g.skipComments {
+		return nil
+	}
+	changes := tidbsql.GetCommentChanges(tableDef)
+	ddls := make([]string, 0, len(changes.Columns)+1)
+	if changes.Table != nil {
+		ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s SET COMMENT = %s;", g.QuoteIdent(tableDef.Table), genComment(*changes.Table, tableDef.Table)))
 	}
-	return fmt.Sprintf("%v", val)
+	for _, column := range tableDef.Columns {
+		if comment, ok := changes.Columns[column.Name]; ok {
+			ddls = append(ddls, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s COMMENT %s;", g.QuoteIdent(tableDef.Table), g.QuoteIdent(column.Name), genComment(comment, column.Name)))
+		}
+	}
+	return ddls
+}
+
+// getDefaultString returns the literal of the default value of the column
+func getDefaultString(column cloudstorage.TableCol) string {
+	return tidbsql.DefaultLiteral(column, utils.QuoteLiteral)
 }
 
 // GetSnowflakeColumnString returns a string describing the column in Snowflake, e.g.
-// "id INT NOT NULL DEFAULT '0'"
+// `"ID" INT NOT NULL DEFAULT '0'`
 // Refer to:
 // https://dev.mysql.com/doc/refman/8.0/en/data-types.html
 // https://docs.snowflake.com/en/sql-reference/intro-summary-data-types
-func GetSnowflakeColumnString(column cloudstorage.TableCol) (string, error) {
+func (g Generator) GetSnowflakeColumnString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
 	var sb strings.Builder
-	typeStr, err := GetSnowflakeTypeString(column)
+	typeStr, err := g.GetSnowflakeTypeString(column, columnTypes)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -123,7 +169,7 @@ func GetSnowflakeColumnString(column cloudstorage.TableCol) (string, error) {
 		sb.WriteString(" NOT NULL")
 	}
 	if column.Default != nil {
-		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column.Default)))
+		sb.WriteString(fmt.Sprintf(` DEFAULT %s`, getDefaultString(column)))
 	} else if column.Nullable == "true" {
 		sb.WriteString(" DEFAULT NULL")
 	}
@@ -1,14 +1,25 @@
 package snowsql_test
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
 	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/typetest"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"github.com/stretchr/testify/require"
 )
 
 func TestGenDDLViaColumnsDiff(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
 	prevColumns := []cloudstorage.TableCol{
 		{
 			ID:        "1",
@@ -62,13 +73,346 @@ func TestGenDDLViaColumnsDiff(t *testing.T) {
 	}
 
 	expectedDDLs := []string{
-		"ALTER TABLE test_table MODIFY COLUMN id CHAR(10);",
-		"ALTER TABLE test_table RENAME COLUMN name TO color;",
-		"ALTER TABLE test_table DROP COLUMN age;",
-		"ALTER TABLE test_table ADD COLUMN gender VARCHAR(10);",
+		`ALTER TABLE "TEST_TABLE" MODIFY COLUMN "ID" CHAR(10);`,
+		`ALTER TABLE "TEST_TABLE" RENAME COLUMN "NAME" TO "COLOR";`,
+		`ALTER TABLE "TEST_TABLE" DROP COLUMN "AGE";`,
+		`ALTER TABLE "TEST_TABLE" ADD COLUMN "GENDER" VARCHAR(10);`,
 	}
 
-	ddl, err := snowsql.GenDDLViaColumnsDiff(prevColumns, curTableDef)
+	ddl, err := gen.GenDDLViaColumnsDiff(prevColumns, curTableDef, nil, tablelayout.Layout{}, deletemode.Hard)
 	require.NoError(t, err)
 	require.ElementsMatch(t, expectedDDLs, ddl)
 }
+
+func TestGenDDLViaColumnsDiffWithComment(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", IsPK: "true"},
+		{ID: "2", Name: "note", Tp: "varchar", Precision: "20"},
+	}
+	// a comment-only MODIFY COLUMN does not change the type
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "test_table",
+		Schema:  "test_schema",
+		Type:    timodel.ActionModifyColumn,
+		Query:   "ALTER TABLE test_table MODIFY COLUMN note VARCHAR(20) COMMENT 'the customer''s note'",
+		Columns: columns,
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "TEST_TABLE" ALTER COLUMN "NOTE" COMMENT 'the customer\'s note';`}, ddls)
+
+	tableDef.Type = timodel.ActionModifyTableComment
+	tableDef.Query = "ALTER TABLE test_table COMMENT = 'orders'"
+	ddls, err = gen.GenDDLViaColumnsDiff(columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "TEST_TABLE" SET COMMENT = 'orders';`}, ddls)
+
+	// the comments are not replicated with --sync-comments=false, the generator copied is not changed
+	ddls, err = gen.WithSyncComments(false).GenDDLViaColumnsDiff(columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Empty(t, ddls)
+	ddls, err = gen.GenDDLViaColumnsDiff(columns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Len(t, ddls, 1)
+}
+
+func TestGenDDLViaColumnsDiffCreateTable(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE test_table (id INT PRIMARY KEY, note VARCHAR(20))",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "note", Tp: "varchar", Precision: "20"},
+		},
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "TEST_TABLE" (
+    "ID" INT NOT NULL,
+    "NOTE" VARCHAR(20),
+    PRIMARY KEY ("ID")
+)`}, ddls)
+
+	// the tombstone columns of the soft delete mode follow the columns
+	ddls, err = gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{}, deletemode.Soft)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "TEST_TABLE" (
+    "ID" INT NOT NULL,
+    "NOTE" VARCHAR(20),
+    "_TIDB_DELETED" BOOLEAN DEFAULT FALSE,
+    "_TIDB_DELETED_AT" TIMESTAMP,
+    PRIMARY KEY ("ID")
+)`}, ddls)
+}
+
+func TestGenDDLViaColumnsDiffWithLayout(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	columns := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "int", IsPK: "true", Nullable: "false"},
+		{ID: "2", Name: "tenant_id", Tp: "int"},
+		{ID: "3", Name: "created_at", Tp: "date"},
+	}
+	tableDef := cloudstorage.TableDefinition{
+		Table:   "test_table",
+		Schema:  "test_schema",
+		Type:    timodel.ActionCreateTable,
+		Query:   "CREATE TABLE test_table (id INT PRIMARY KEY, tenant_id INT, created_at DATE)",
+		Columns: columns,
+	}
+	layout := tablelayout.Layout{ClusterBy: []string{"tenant_id", "created_at"}}
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, layout, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "TEST_TABLE" (
+    "ID" INT NOT NULL,
+    "TENANT_ID" INT,
+    "CREATED_AT" DATE,
+    PRIMARY KEY ("ID")
+) CLUSTER BY ("TENANT_ID", "CREATED_AT")`}, ddls)
+
+	_, err = gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{ClusterBy: []string{"kind"}}, deletemode.Hard)
+	require.ErrorContains(t, err, "Column kind partitioning or clustering the table is not a column of the table")
+
+	// a clustering column is not dropped
+	tableDef.Type = timodel.ActionDropColumn
+	tableDef.Query = "ALTER TABLE test_table DROP COLUMN tenant_id"
+	tableDef.Columns = []cloudstorage.TableCol{columns[0], columns[2]}
+	_, err = gen.GenDDLViaColumnsDiff(columns, tableDef, nil, layout, deletemode.Hard)
+	require.ErrorContains(t, err, "Can not drop column tenant_id which partitions or clusters the table")
+}
+
+func TestGenDDLViaColumnsDiffWithColumnTypes(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "test_table",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE test_table (id BIGINT UNSIGNED PRIMARY KEY, payload JSON)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "bigint unsigned", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "payload", Tp: "json"},
+		},
+	}
+	columnTypes := columnmapping.Columns{"ID": "NUMBER(20, 0)", "payload": "STRING"}
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, columnTypes, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "TEST_TABLE" (
+    "ID" NUMBER(20, 0) NOT NULL,
+    "PAYLOAD" STRING,
+    PRIMARY KEY ("ID")
+)`}, ddls)
+
+	// the added column is overridden as well
+	tableDef.Type = timodel.ActionAddColumn
+	tableDef.Query = "ALTER TABLE test_table ADD COLUMN extra JSON"
+	prevColumns := tableDef.Columns
+	tableDef.Columns = append(slices.Clone(prevColumns), cloudstorage.TableCol{ID: "3", Name: "extra", Tp: "json"})
+	ddls, err = gen.GenDDLViaColumnsDiff(prevColumns, tableDef, columnmapping.Columns{"extra": "VARCHAR"}, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "TEST_TABLE" ADD COLUMN "EXTRA" VARCHAR;`}, ddls)
+}
+
+func TestGenDDLViaColumnsDiffQuoteIdent(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "order",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Query:  "CREATE TABLE `order` (`select` INT PRIMARY KEY, `名称` VARCHAR(20), `a\"b` INT)",
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "select", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "名称", Tp: "varchar", Precision: "20"},
+			{ID: "3", Name: `a"b`, Tp: "int"},
+		},
+	}
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "ORDER" (
+    "SELECT" INT NOT NULL,
+    "名称" VARCHAR(20),
+    "A""B" INT,
+    PRIMARY KEY ("SELECT")
+)`}, ddls)
+
+	prevColumns := tableDef.Columns
+	tableDef.Type = timodel.ActionModifyColumn
+	tableDef.Query = "ALTER TABLE `order` RENAME COLUMN `名称` TO `group`"
+	tableDef.Columns = slices.Clone(prevColumns)
+	tableDef.Columns[1].Name = "group"
+	ddls, err = gen.GenDDLViaColumnsDiff(prevColumns, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`ALTER TABLE "ORDER" RENAME COLUMN "名称" TO "GROUP";`}, ddls)
+}
+
+func TestGenDDLViaColumnsDiffIdentifierCase(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table:  "UserEvents",
+		Schema: "test_schema",
+		Type:   timodel.ActionCreateTable,
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "UserID", Tp: "int", IsPK: "true", Nullable: "false"},
+			{ID: "2", Name: "event_name", Tp: "varchar", Precision: "20"},
+		},
+	}
+	addColumn := func() []string {
+		curTableDef := tableDef
+		curTableDef.Type = timodel.ActionAddColumn
+		curTableDef.Columns = append(slices.Clone(tableDef.Columns), cloudstorage.TableCol{ID: "3", Name: "CreatedAt", Tp: "datetime"})
+		ddls, err := gen.GenDDLViaColumnsDiff(tableDef.Columns, curTableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+		require.NoError(t, err)
+		return ddls
+	}
+
+	// the names are uppercased by default, as the unquoted names are stored
+	ddls, err := gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "USEREVENTS" (
+    "USERID" INT NOT NULL,
+    "EVENT_NAME" VARCHAR(20),
+    PRIMARY KEY ("USERID")
+)`}, ddls)
+	require.Equal(t, []string{`ALTER TABLE "USEREVENTS" ADD COLUMN "CREATEDAT" DATETIME;`}, addColumn())
+	merge := gen.GenMergeInto(tableDef, "test/UserEvents/1/CDC000001.csv", "increment_external_userevents", nil, nil, "", deletemode.Hard)
+	require.Contains(t, merge, `T."USERID" = S."USERID"`)
+	require.NotContains(t, merge, `"UserID"`)
+
+	gen = snowsql.NewGenerator(identcase.Preserve)
+	ddls, err = gen.GenDDLViaColumnsDiff(nil, tableDef, nil, tablelayout.Layout{}, deletemode.Soft)
+	require.NoError(t, err)
+	require.Equal(t, []string{`CREATE OR REPLACE TABLE "UserEvents" (
+    "UserID" INT NOT NULL,
+    "event_name" VARCHAR(20),
+    "_tidb_deleted" BOOLEAN DEFAULT FALSE,
+    "_tidb_deleted_at" TIMESTAMP,
+    PRIMARY KEY ("UserID")
+)`}, ddls)
+	require.Equal(t, []string{`ALTER TABLE "UserEvents" ADD COLUMN "CreatedAt" DATETIME;`}, addColumn())
+	merge = gen.GenMergeInto(tableDef, "test/UserEvents/1/CDC000001.csv", "increment_external_userevents", nil, nil, "", deletemode.Hard)
+	require.Contains(t, merge, `MERGE INTO "UserEvents" AS T`)
+	require.Contains(t, merge, `T."UserID" = S."UserID"`)
+	require.Contains(t, merge, `"event_name" = S."event_name"`)
+}
+
+func TestGenDDLViaColumnsDiffAddColumn(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	// Snowflake fills the existing rows with the default of the column added
+	expected := map[string][]string{
+		"add column":                             {`ALTER TABLE "T" ADD COLUMN "EMAIL" VARCHAR(64);`},
+		"add not null column":                    {`ALTER TABLE "T" ADD COLUMN "SCORE" INT NOT NULL DEFAULT 0;`},
+		"add column with default":                {`ALTER TABLE "T" ADD COLUMN "LEVEL" INT NOT NULL DEFAULT 1;`},
+		"add not null date column":               {`ALTER TABLE "T" ADD COLUMN "BORN" DATE DEFAULT NULL;`},
+		"add column with quoted default":         {`ALTER TABLE "T" ADD COLUMN "NOTE" VARCHAR(20) NOT NULL DEFAULT 'it\'s \\ ok';`},
+		"add column with numeric string default": {`ALTER TABLE "T" ADD COLUMN "CODE" VARCHAR(3) NOT NULL DEFAULT '007';`},
+	}
+	for _, change := range ddltest.Changes() {
+		if _, ok := expected[change.Name]; !ok {
+			continue
+		}
+		t.Run(change.Name, func(t *testing.T) {
+			ddls, err := gen.GenDDLViaColumnsDiff(change.PrevColumns, change.TableDef, nil, tablelayout.Layout{}, deletemode.Hard)
+			require.NoError(t, err)
+			require.Equal(t, expected[change.Name], ddls)
+		})
+	}
+}
+
+func TestGetSnowflakeTypeStringUnsigned(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	// the integers of Snowflake are NUMBER(38,0), which keeps 18446744073709551615, the max of BIGINT UNSIGNED
+	for tp, expected := range map[string]string{
+		"TINYINT UNSIGNED": `"C" TINYINT`,
+		"int unsigned":     `"C" INT`,
+		"BIGINT UNSIGNED":  `"C" BIGINT`,
+	} {
+		actual, err := gen.GetSnowflakeTypeString(cloudstorage.TableCol{Name: "c", Tp: tp}, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual, tp)
+	}
+	require.Equal(t, "NUMBER(38,0)", snowsql.NormalizeSnowflakeType("BIGINT"))
+}
+
+func TestGetSnowflakeTypeStringAllTypes(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	expected := map[string]string{
+		"c_tinyint":            `"C_TINYINT" TINYINT`,
+		"c_tinyint_unsigned":   `"C_TINYINT_UNSIGNED" TINYINT`,
+		"c_smallint":           `"C_SMALLINT" SMALLINT`,
+		"c_smallint_unsigned":  `"C_SMALLINT_UNSIGNED" SMALLINT`,
+		"c_mediumint":          `"C_MEDIUMINT" INT`,
+		"c_mediumint_unsigned": `"C_MEDIUMINT_UNSIGNED" INT`,
+		"c_int":                `"C_INT" INT`,
+		"c_int_unsigned":       `"C_INT_UNSIGNED" INT`,
+		"c_bigint":             `"C_BIGINT" BIGINT`,
+		"c_bigint_unsigned":    `"C_BIGINT_UNSIGNED" BIGINT`,
+		"c_float":              `"C_FLOAT" FLOAT`,
+		"c_double":             `"C_DOUBLE" DOUBLE`,
+		"c_decimal":            `"C_DECIMAL" DECIMAL(20, 6)`,
+		"c_bit":                `"C_BIT" BOOLEAN`,
+		"c_bit_long":           `"C_BIT_LONG" BINARY(2)`,
+		"c_date":               `"C_DATE" DATE`,
+		"c_datetime":           `"C_DATETIME" DATETIME(6)`,
+		"c_timestamp":          `"C_TIMESTAMP" TIMESTAMP`,
+		"c_time":               `"C_TIME" TIME`,
+		"c_char":               `"C_CHAR" CHAR(10)`,
+		"c_varchar":            `"C_VARCHAR" VARCHAR(255)`,
+		"c_binary":             `"C_BINARY" BINARY(16)`,
+		"c_varbinary":          `"C_VARBINARY" VARBINARY(255)`,
+		"c_tinytext":           `"C_TINYTEXT" TEXT`,
+		"c_text":               `"C_TEXT" TEXT`,
+		"c_mediumtext":         `"C_MEDIUMTEXT" TEXT`,
+		"c_longtext":           `"C_LONGTEXT" TEXT`,
+		"c_tinyblob":           `"C_TINYBLOB" TEXT`,
+		"c_blob":               `"C_BLOB" TEXT`,
+		"c_mediumblob":         `"C_MEDIUMBLOB" TEXT`,
+		"c_longblob":           `"C_LONGBLOB" TEXT`,
+		"c_enum":               `"C_ENUM" VARCHAR`,
+		"c_set":                `"C_SET" VARCHAR`,
+	}
+	for _, column := range typetest.Columns() {
+		actual, err := gen.GetSnowflakeTypeString(column, nil)
+		switch column.Name {
+		case "c_decimal_wide":
+			require.ErrorContains(t, err, "Column c_decimal_wide of DECIMAL(40, 10) exceeds the max precision 38 of Snowflake")
+		case "c_year", "c_json":
+			require.ErrorContains(t, err, "Unsupported data type")
+		default:
+			require.NoError(t, err, column.Name)
+			require.Equal(t, expected[column.Name], actual, column.Name)
+		}
+	}
+	// the types copied from TiDB have the length of ENUM and SET and the fractional seconds in the precision
+	for column, expected := range map[string]string{
+		"c_datetime": `"C_DATETIME" DATETIME(6)`,
+		"c_enum":     `"C_ENUM" VARCHAR(8)`,
+		"c_set":      `"C_SET" VARCHAR(17)`,
+	} {
+		idx := slices.IndexFunc(typetest.CopiedColumns(), func(c cloudstorage.TableCol) bool { return c.Name == column })
+		actual, err := gen.GetSnowflakeTypeString(typetest.CopiedColumns()[idx], nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	}
+	// the type of a DECIMAL too wide is given by the column mapping
+	actual, err := gen.GetSnowflakeTypeString(typetest.Columns()[13], columnmapping.Columns{"c_decimal_wide": "VARCHAR"})
+	require.NoError(t, err)
+	require.Equal(t, `"C_DECIMAL_WIDE" VARCHAR`, actual)
+}
+
+func TestGenCreateTableSQLLongComment(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	// the comment is truncated to the length of the VARCHAR Snowflake stores it in
+	comments := &tidbsql.TableComments{Table: strings.Repeat("a", 16777216+10), Columns: map[string]string{"id": "the id"}}
+	sql, err := gen.GenCreateTableSQL("orders", columns, []string{"id"}, comments, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(sql, ") COMMENT = '"+strings.Repeat("a", 16777216)+"'"))
+	require.Contains(t, sql, `"ID" INT COMMENT 'the id'`)
+}
+
+func TestDDLActionClasses(t *testing.T) {
+	connector := &snowsql.SnowflakeConnector{}
+	require.Equal(t, ddltest.DefaultHandlings(nil), ddltest.Handlings(connector.DDLActionClasses()))
+}
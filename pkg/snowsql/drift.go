@@ -0,0 +1,112 @@
+package snowsql
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// NormalizeSnowflakeType returns the type as Snowflake stores it, so that the synonyms are equal, e.g. NUMBER(38,0)
+// for BIGINT and TEXT(16777216) for VARCHAR. The parameters omitted are the defaults of Snowflake, except for the
+// fractional seconds of the time types, which are left out.
+func NormalizeSnowflakeType(tp string) string {
+	name, params := tidbsql.SplitType(tp)
+	param := func(i int, def string) string {
+		if i < len(params) && params[i] != "" {
+			return params[i]
+		}
+		return def
+	}
+	switch name {
+	case "NUMBER", "DECIMAL", "NUMERIC":
+		return fmt.Sprintf("NUMBER(%s,%s)", param(0, "38"), param(1, "0"))
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "BYTEINT":
+		return "NUMBER(38,0)"
+	case "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "DOUBLE PRECISION", "REAL":
+		return "FLOAT"
+	case "TEXT", "VARCHAR", "STRING", "NVARCHAR", "NVARCHAR2", "CHAR VARYING", "NCHAR VARYING":
+		return fmt.Sprintf("TEXT(%s)", param(0, "16777216"))
+	case "CHAR", "CHARACTER", "NCHAR":
+		return fmt.Sprintf("TEXT(%s)", param(0, "1"))
+	case "BINARY", "VARBINARY":
+		return fmt.Sprintf("BINARY(%s)", param(0, "8388608"))
+	case "DATETIME", "TIMESTAMP", "TIMESTAMP_NTZ", "TIMESTAMPNTZ", "TIMESTAMP WITHOUT TIME ZONE":
+		name = "TIMESTAMP_NTZ"
+	}
+	if !slices.ContainsFunc(params, func(p string) bool { return p != "" }) {
+		// e.g. the fractional seconds of a datetime column are not given by the schema file
+		params = nil
+	}
+	if len(params) == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ","))
+}
+
+// getSnowflakeColumnType returns the normalized type of the column in Snowflake, empty if the type is not mapped by
+// tidb2dw, e.g. the table is created by the snapshot with a type not supported by the DDLs
+func (g Generator) getSnowflakeColumnType(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	if _, ok := columnTypes.Lookup(column.Name); !ok && isEnumOrSet(column) && column.Precision == "" {
+		// the schema files do not give the length of ENUM and SET, which is known when the table is copied
+		return "", nil
+	}
+	typeStr, err := g.GetSnowflakeTypeString(column, columnTypes)
+	if err != nil {
+		return "", nil
+	}
+	return NormalizeSnowflakeType(strings.TrimPrefix(typeStr, g.QuoteIdent(column.Name)+" ")), nil
+}
+
+func isEnumOrSet(column cloudstorage.TableCol) bool {
+	return strings.EqualFold(column.Tp, "enum") || strings.EqualFold(column.Tp, "set")
+}
+
+// GetWarehouseColumns returns the columns of the table in the current schema of Snowflake with their normalized types
+func (g Generator) GetWarehouseColumns(db *sql.DB, tableName string) ([]tidbsql.WarehouseColumn, error) {
+	query := fmt.Sprintf(`SELECT COLUMN_NAME, DATA_TYPE, CHARACTER_MAXIMUM_LENGTH, NUMERIC_PRECISION, NUMERIC_SCALE, DATETIME_PRECISION, IS_NULLABLE
+FROM INFORMATION_SCHEMA.COLUMNS
+WHERE TABLE_SCHEMA = CURRENT_SCHEMA() AND TABLE_NAME = %s
+ORDER BY ORDINAL_POSITION`, utils.QuoteLiteral(g.identifierCase.Apply(tableName)))
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	var columns []tidbsql.WarehouseColumn
+	for rows.Next() {
+		var name, dataType, isNullable string
+		var charLength, numPrecision, numScale, datetimePrecision sql.NullInt64
+		if err := rows.Scan(&name, &dataType, &charLength, &numPrecision, &numScale, &datetimePrecision, &isNullable); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tp := dataType
+		switch {
+		case charLength.Valid:
+			tp = fmt.Sprintf("%s(%d)", dataType, charLength.Int64)
+		case strings.EqualFold(dataType, "NUMBER") && numPrecision.Valid:
+			tp = fmt.Sprintf("NUMBER(%d,%d)", numPrecision.Int64, numScale.Int64)
+		case datetimePrecision.Valid:
+			tp = fmt.Sprintf("%s(%d)", dataType, datetimePrecision.Int64)
+		}
+		columns = append(columns, tidbsql.WarehouseColumn{
+			Name:     name,
+			Type:     NormalizeSnowflakeType(tp),
+			Nullable: isNullable == "YES",
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(columns) == 0 {
+		return nil, errors.Annotatef(tidbsql.ErrWarehouseTableNotFound, "table %s is not found in Snowflake", tableName)
+	}
+	return columns, nil
+}
@@ -0,0 +1,59 @@
+package snowsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSnowflakeType(t *testing.T) {
+	for _, synonyms := range [][]string{
+		{"NUMBER(38,0)", "BIGINT", "int", "TINYINT", "NUMBER", "DECIMAL(38, 0)"},
+		{"NUMBER(10,2)", "decimal(10,2)", "NUMERIC(10, 2)"},
+		{"FLOAT", "DOUBLE", "REAL", "FLOAT8"},
+		{"TEXT(16777216)", "TEXT", "VARCHAR", "STRING"},
+		{"TEXT(20)", "VARCHAR(20)", "CHAR(20)"},
+		{"BINARY(16)", "VARBINARY(16)"},
+		{"TIMESTAMP_NTZ(6)", "DATETIME(6)", "TIMESTAMP(6)"},
+		{"TIMESTAMP_NTZ", "DATETIME()"},
+	} {
+		for _, tp := range synonyms {
+			require.Equal(t, synonyms[0], snowsql.NormalizeSnowflakeType(tp), tp)
+		}
+	}
+}
+
+func TestReconcileSchemaDDLs(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "BIGINT", Nullable: "false"},
+		{ID: "2", Name: "name", Tp: "VARCHAR", Precision: "20"},
+		{ID: "3", Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+	}
+	actual := []tidbsql.WarehouseColumn{
+		{Name: "ID", Type: "NUMBER(38,0)"},
+		{Name: "NAME", Type: "NUMBER(38,0)", Nullable: true},
+		{Name: "INDEXED", Type: "TEXT(16777216)", Nullable: true},
+	}
+	drift, err := tidbsql.GetSchemaDrift(expected, actual, func(column cloudstorage.TableCol) (string, error) {
+		tp, err := gen.GetSnowflakeTypeString(column, nil)
+		return snowsql.NormalizeSnowflakeType(tp[len(gen.QuoteIdent(column.Name))+1:]), err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "column amount: missing in the data warehouse, expected NUMBER(10,2)\n"+
+		"column INDEXED: TEXT(16777216) in the data warehouse, not expected\n"+
+		"column name: NUMBER(38,0) in the data warehouse, expected TEXT(20)", drift.String())
+	ddls, err := gen.GenDDLViaColumnsDiff(drift.Columns, cloudstorage.TableDefinition{Table: "t", Columns: drift.Expected}, nil, tablelayout.Layout{}, deletemode.Hard)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		`ALTER TABLE "T" ADD COLUMN "AMOUNT" DECIMAL(10, 2);`,
+		`ALTER TABLE "T" DROP COLUMN "INDEXED";`,
+		`ALTER TABLE "T" MODIFY COLUMN "NAME" VARCHAR(20);`,
+	}, ddls)
+}
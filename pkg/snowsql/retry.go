@@ -0,0 +1,28 @@
+package snowsql
+
+import (
+	stderrors "errors"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/snowflakedb/gosnowflake"
+)
+
+// retryableErrNos are the error numbers of Snowflake of the session or the service rather than the statement
+var retryableErrNos = map[int]bool{
+	gosnowflake.ErrSessionGone:            true,
+	390112:                                true, // the session has expired
+	390114:                                true, // the authentication token has expired
+	gosnowflake.ErrCodeServiceUnavailable: true,
+	gosnowflake.ErrFailedToPostQuery:      true,
+	gosnowflake.ErrQueryStatus:            true,
+}
+
+// IsRetryableError tells whether the statement which failed with err may succeed if it is run again, e.g. the session
+// has expired, which the driver renews with a new connection
+func IsRetryableError(err error) bool {
+	var sfErr *gosnowflake.SnowflakeError
+	if stderrors.As(err, &sfErr) {
+		return retryableErrNos[sfErr.Number]
+	}
+	return retry.IsTransient(err)
+}
@@ -0,0 +1,20 @@
+package snowsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap/errors"
+	"github.com/snowflakedb/gosnowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRetryableError(t *testing.T) {
+	expired := &gosnowflake.SnowflakeError{Number: 390114, Message: "Authentication token has expired."}
+	require.True(t, snowsql.IsRetryableError(errors.Annotate(diag.WrapSQL(expired, "MERGE INTO t"), "Failed to merge")))
+	require.True(t, snowsql.IsRetryableError(&gosnowflake.SnowflakeError{Number: gosnowflake.ErrSessionGone}))
+	// a compilation error of the statement is fatal
+	require.False(t, snowsql.IsRetryableError(&gosnowflake.SnowflakeError{Number: 2003, Message: "does not exist or not authorized"}))
+	require.False(t, snowsql.IsRetryableError(errors.New("invalid identifier")))
+}
@@ -0,0 +1,324 @@
+package snowsql
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/snowflakedb/gosnowflake"
+	"gitlab.com/tymonx/go-formatter/formatter"
+	"go.uber.org/zap"
+)
+
+// LoadMode is how the increment files are loaded into Snowflake
+type LoadMode string
+
+const (
+	// LoadModeCopy merges the files from the stage directly, which is the default
+	LoadModeCopy LoadMode = "copy"
+	// LoadModeSnowpipe ingests the files into a staging table by Snowpipe auto-ingest,
+	// and merges the staging table
+	LoadModeSnowpipe LoadMode = "snowpipe"
+)
+
+func ParseLoadMode(s string) (LoadMode, error) {
+	switch mode := LoadMode(strings.ToLower(s)); mode {
+	case LoadModeCopy, LoadModeSnowpipe:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown load mode %s, expected one of copy, snowpipe", s)
+	}
+}
+
+const (
+	// snowpipeMetaColumns is the number of leading columns of the increment files: op, table, schema, commit-ts
+	snowpipeMetaColumns = 4
+	// snowpipePollInterval is the interval of checking whether a file is ingested
+	snowpipePollInterval = 5 * time.Second
+	// snowpipeLoadTimeout is how long to wait for a file to be ingested before failing
+	snowpipeLoadTimeout = 10 * time.Minute
+	// snowpipeHistoryWindow is how long before the pipe is set up the load history is scanned for the files
+	// ingested before, e.g. by the refresh or before a restart, since insertReport only has the last 10 minutes
+	snowpipeHistoryWindow = 24 * time.Hour
+	// snowpipeRedeliveryWindow is how long the files merged are remembered, so that their rows delivered again
+	// by the pipe are pruned
+	snowpipeRedeliveryWindow = time.Hour
+	// insufficientPrivilegesErrNo is the error number of Snowflake for the missing privileges
+	insufficientPrivilegesErrNo = 3001
+)
+
+// snowpipeLoader loads the increment files of a table by an auto-ingest pipe. The pipe copies every
+// field of the files as VARCHAR into the staging table, which has the columns FILE_NAME, FILE_ROW_NUMBER
+// and C1..Cn. A file is merged once the insertReport or the loadHistoryScan of the Snowpipe REST API reports
+// it loaded, and its rows are pruned from the staging table after the merge. Snowpipe delivers a file at least
+// once, so the rows of a file are deduplicated when merging, and the rows of a file delivered again after its
+// merge are pruned with the next merge.
+type snowpipeLoader struct {
+	db           *sql.DB
+	gen          Generator
+	client       *snowpipeClient
+	stageName    string
+	pipeName     string
+	stagingTable string
+	// restPipeName is the fully qualified name of the pipe in the REST API
+	restPipeName string
+	// pattern matches the files of the table in the stage
+	pattern string
+	// width is the number of columns C1..Cn of the staging table
+	width int
+	// beginMark is where the next insertReport starts, empty until the first report
+	beginMark string
+	// historyFrom is where the load history is scanned from for the files not in the insertReport
+	historyFrom time.Time
+	// reported are the files reported by the pipe by their paths in the stage, until they are merged
+	reported map[string]ingestedFile
+	// merged are the files merged by their paths in the stage and when they are merged
+	merged map[string]time.Time
+	// redelivered are the files reported again after they are merged, whose rows are pruned by the next merge
+	redelivered []string
+}
+
+func newSnowpipeLoader(db *sql.DB, gen Generator, client *snowpipeClient, config *SnowflakeConfig, stageName, sourceDatabase, sourceTable string, compression utils.Compression) *snowpipeLoader {
+	// the names are in upper case, which the REST API takes as they are
+	pipeName := strings.ToUpper(fmt.Sprintf("increment_pipe_%s_%s", sourceDatabase, sourceTable))
+	return &snowpipeLoader{
+		db:           db,
+		gen:          gen,
+		client:       client,
+		stageName:    stageName,
+		pipeName:     pipeName,
+		stagingTable: strings.ToUpper(fmt.Sprintf("increment_staging_%s_%s", sourceDatabase, sourceTable)),
+		restPipeName: strings.ToUpper(config.Database) + "." + strings.ToUpper(config.Schema) + "." + pipeName,
+		pattern: fmt.Sprintf("(.*/)?%s/%s/.*%s",
+			regexp.QuoteMeta(sourceDatabase), regexp.QuoteMeta(sourceTable), regexp.QuoteMeta(compression.CSVFileExtension())),
+		reported: make(map[string]ingestedFile),
+		merged:   make(map[string]time.Time),
+	}
+}
+
+// setup creates the staging table with at least the given number of table columns, creates the pipe,
+// and refreshes the pipe to ingest the files staged while no pipe was listening
+func (l *snowpipeLoader) setup(tableColumns int) error {
+	width := snowpipeMetaColumns + tableColumns
+	if l.historyFrom.IsZero() {
+		l.historyFrom = time.Now().Add(-snowpipeHistoryWindow)
+	}
+	if _, err := l.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (FILE_NAME VARCHAR, FILE_ROW_NUMBER NUMBER)", quoteName(l.stagingTable))); err != nil {
+		return errors.Annotate(err, "Failed to create staging table")
+	}
+	stagingColumns := make([]string, 0, width)
+	fileColumns := make([]string, 0, width)
+	for i := 1; i <= width; i++ {
+		if i > l.width {
+			if _, err := l.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS C%d VARCHAR", quoteName(l.stagingTable), i)); err != nil {
+				return errors.Annotate(err, "Failed to add column to staging table")
+			}
+		}
+		stagingColumns = append(stagingColumns, fmt.Sprintf("C%d", i))
+		fileColumns = append(fileColumns, fmt.Sprintf("$%d", i))
+	}
+	l.width = width
+
+	// the file format is inherited from the stage
+	createPipe, err := formatter.Format(`
+CREATE OR REPLACE PIPE {pipeName}
+AUTO_INGEST = TRUE
+AS COPY INTO {stagingTable} (FILE_NAME, FILE_ROW_NUMBER, {stagingColumns})
+FROM (SELECT METADATA$FILENAME, METADATA$FILE_ROW_NUMBER, {fileColumns} FROM @{stageName})
+PATTERN = '{pattern}';
+`, formatter.Named{
+		"pipeName":       quoteName(l.pipeName),
+		"stagingTable":   quoteName(l.stagingTable),
+		"stagingColumns": strings.Join(stagingColumns, ", "),
+		"fileColumns":    strings.Join(fileColumns, ", "),
+		"stageName":      utils.EscapeString(l.stageName),
+		"pattern":        utils.EscapeString(l.pattern),
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = l.db.Exec(createPipe); err != nil {
+		return errors.Annotate(diag.WrapSQL(err, createPipe), "Failed to create pipe")
+	}
+	if _, err = l.db.Exec(fmt.Sprintf("ALTER PIPE %s REFRESH", quoteName(l.pipeName))); err != nil {
+		return errors.Annotate(err, "Failed to refresh pipe")
+	}
+
+	channel, err := l.notificationChannel()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	log.Info("Snowpipe created, the S3 event notifications of the storage must be sent to its notification channel",
+		zap.String("pipe", l.pipeName), zap.String("stagingTable", l.stagingTable), zap.String("notificationChannel", channel))
+	return nil
+}
+
+// notificationChannel returns the SQS queue the pipe is subscribed to
+func (l *snowpipeLoader) notificationChannel() (string, error) {
+	rows, err := l.db.Query(fmt.Sprintf("SHOW PIPES LIKE '%s'", utils.EscapeString(l.pipeName)))
+	if err != nil {
+		return "", errors.Annotate(err, "Failed to show pipe")
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if !rows.Next() {
+		return "", errors.Errorf("Pipe %s not found", l.pipeName)
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err = rows.Scan(dest...); err != nil {
+		return "", errors.Trace(err)
+	}
+	for i, column := range columns {
+		if strings.EqualFold(column, "notification_channel") && values[i].String != "" {
+			return values[i].String, nil
+		}
+	}
+	return "", errors.Errorf("Pipe %s has no notification channel, auto-ingest is not available", l.pipeName)
+}
+
+// waitIngested waits for the pipe to report the file loaded and returns its path in the stage. A file whose
+// loading failed fails the merge, since its rows are skipped by the pipe.
+func (l *snowpipeLoader) waitIngested(filePath string) (string, error) {
+	deadline := time.Now().Add(snowpipeLoadTimeout)
+	scanned := false
+	for {
+		if err := l.pollInsertReport(); err != nil {
+			return "", errors.Trace(err)
+		}
+		file, ok := l.lookup(filePath)
+		if !ok && !scanned {
+			// the file may be ingested before the reports polled, e.g. before a restart
+			files, err := l.client.loadHistoryScan(l.restPipeName, l.historyFrom)
+			if err != nil {
+				return "", errors.Trace(err)
+			}
+			l.record(files)
+			scanned = true
+			file, ok = l.lookup(filePath)
+		}
+		if ok {
+			switch file.Status {
+			case ingestStatusLoaded:
+				return file.Path, nil
+			case ingestStatusLoadFailed, ingestStatusPartiallyLoaded:
+				return "", diag.Warehouse(errors.Errorf("File %s is %s by pipe %s with %d errors, the first is: %s",
+					file.Path, file.Status, l.pipeName, file.ErrorsSeen, file.FirstError))
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", errors.Errorf("File %s is not ingested by pipe %s after %s, "+
+				"please check the S3 event notifications are sent to the notification channel of the pipe, "+
+				"and check the errors by SYSTEM$PIPE_STATUS and COPY_HISTORY", filePath, l.pipeName, snowpipeLoadTimeout)
+		}
+		time.Sleep(snowpipePollInterval)
+	}
+}
+
+// pollInsertReport records the files reported since the last report
+func (l *snowpipeLoader) pollInsertReport() error {
+	report, err := l.client.insertReport(l.restPipeName, l.beginMark)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if report.NextBeginMark != "" {
+		l.beginMark = report.NextBeginMark
+	}
+	l.record(report.Files)
+	return nil
+}
+
+// record keeps the latest report of each file, a file reported again after its merge is delivered again
+func (l *snowpipeLoader) record(files []ingestedFile) {
+	for _, file := range files {
+		if _, ok := l.merged[file.Path]; ok {
+			if file.Status == ingestStatusLoaded && !slices.Contains(l.redelivered, file.Path) {
+				l.redelivered = append(l.redelivered, file.Path)
+			}
+			continue
+		}
+		l.reported[file.Path] = file
+	}
+}
+
+// lookup returns the report of the file by its path relative to the increment path, which is a sub path of the
+// stage for the shards of the increment
+func (l *snowpipeLoader) lookup(filePath string) (ingestedFile, bool) {
+	if file, ok := l.reported[filePath]; ok {
+		return file, true
+	}
+	for path, file := range l.reported {
+		if strings.HasSuffix(path, "/"+filePath) {
+			return file, true
+		}
+	}
+	return ingestedFile{}, false
+}
+
+// load merges the ingested rows of the file into the table and prunes them from the staging table,
+// the rows changed in the table are returned
+func (l *snowpipeLoader) load(tableDef cloudstorage.TableDefinition, filePath string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode) (int64, error) {
+	stagePath, err := l.waitIngested(filePath)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	mergeQuery := l.gen.GenMergeIntoFromStaging(tableDef, l.stagingTable, stagePath, columnFilter, columnTypes, where, deleteMode)
+	res, err := l.db.Exec(mergeQuery)
+	if err != nil {
+		return 0, diag.WrapSQL(err, mergeQuery)
+	}
+	log.Debug("merge staging table into table", zap.String("query", mergeQuery))
+
+	now := time.Now()
+	delete(l.reported, stagePath)
+	l.merged[stagePath] = now
+	for path, mergedAt := range l.merged {
+		if now.Sub(mergedAt) > snowpipeRedeliveryWindow {
+			delete(l.merged, path)
+		}
+	}
+	pruned := append([]string{stagePath}, l.redelivered...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(pruned)), ", ")
+	args := make([]any, 0, len(pruned))
+	for _, path := range pruned {
+		args = append(args, path)
+	}
+	if _, err = l.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE FILE_NAME IN (%s)", quoteName(l.stagingTable), placeholders), args...); err != nil {
+		return 0, errors.Annotate(err, "Failed to prune staging table")
+	}
+	l.redelivered = nil
+	return utils.RowsAffected(res), nil
+}
+
+func (l *snowpipeLoader) close() {
+	if _, err := l.db.Exec(fmt.Sprintf("DROP PIPE IF EXISTS %s", quoteName(l.pipeName))); err != nil {
+		log.Error("fail to drop pipe", zap.Error(err))
+	}
+}
+
+func isInsufficientPrivileges(err error) bool {
+	sfErr, ok := errors.Cause(err).(*gosnowflake.SnowflakeError)
+	return ok && sfErr.Number == insufficientPrivilegesErrNo
+}
+
+// quoteName quotes the name of the pipe or the staging table, which is in upper case whatever the identifier case
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
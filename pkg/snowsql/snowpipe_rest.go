@@ -0,0 +1,187 @@
+package snowsql
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// snowpipeRequestTimeout is the timeout of a request of the Snowpipe REST API
+	snowpipeRequestTimeout = 30 * time.Second
+	// snowpipeJWTLifetime is how long a JWT of key-pair authentication is valid, at most an hour by Snowflake
+	snowpipeJWTLifetime = 59 * time.Minute
+)
+
+// The statuses of a file in the reports of the Snowpipe REST API
+const (
+	ingestStatusLoaded          = "LOADED"
+	ingestStatusLoadFailed      = "LOAD_FAILED"
+	ingestStatusPartiallyLoaded = "PARTIALLY_LOADED"
+)
+
+// ingestedFile is a file in the insertReport or the loadHistoryScan of a pipe
+type ingestedFile struct {
+	// Path is the path of the file relative to the stage, the same as METADATA$FILENAME
+	Path         string `json:"path"`
+	Status       string `json:"status"`
+	RowsInserted int64  `json:"rowsInserted"`
+	ErrorsSeen   int64  `json:"errorsSeen"`
+	FirstError   string `json:"firstError"`
+}
+
+type insertReportResponse struct {
+	NextBeginMark string         `json:"nextBeginMark"`
+	Files         []ingestedFile `json:"files"`
+}
+
+type loadHistoryScanResponse struct {
+	Files []ingestedFile `json:"files"`
+}
+
+// snowpipeClient requests the Snowpipe REST API, which authenticates by the key pair or the OAuth token of the
+// Snowflake config but not by the password
+type snowpipeClient struct {
+	baseURL    string
+	config     *SnowflakeConfig
+	privateKey *rsa.PrivateKey
+	httpClient *http.Client
+}
+
+func newSnowpipeClient(config *SnowflakeConfig) (*snowpipeClient, error) {
+	client := &snowpipeClient{
+		baseURL:    fmt.Sprintf("https://%s.snowflakecomputing.com", config.AccountId),
+		config:     config,
+		httpClient: &http.Client{Timeout: snowpipeRequestTimeout},
+	}
+	switch {
+	case config.PrivateKeyPath != "":
+		privateKey, err := LoadPrivateKey(config.PrivateKeyPath, config.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		client.privateKey = privateKey
+	case config.OAuthToken != "" || config.OAuthTokenFile != "":
+	default:
+		return nil, errors.New("the Snowpipe REST API tracking the files ingested requires --snowflake.private-key-path, " +
+			"--snowflake.oauth-token or --snowflake.oauth-token-file")
+	}
+	return client, nil
+}
+
+// insertReport returns the files ingested by the pipe since the mark, an empty mark for the last 10 minutes
+func (c *snowpipeClient) insertReport(pipe, beginMark string) (*insertReportResponse, error) {
+	query := url.Values{}
+	if beginMark != "" {
+		query.Set("beginMark", beginMark)
+	}
+	var report insertReportResponse
+	if err := c.get(fmt.Sprintf("/v1/data/pipes/%s/insertReport", pipe), query, &report); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &report, nil
+}
+
+// loadHistoryScan returns the files ingested by the pipe since start, which are older than the insertReport
+func (c *snowpipeClient) loadHistoryScan(pipe string, start time.Time) ([]ingestedFile, error) {
+	query := url.Values{}
+	query.Set("startTimeInclusive", start.UTC().Format(time.RFC3339))
+	var history loadHistoryScanResponse
+	if err := c.get(fmt.Sprintf("/v1/data/pipes/%s/loadHistoryScan", pipe), query, &history); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return history.Files, nil
+}
+
+func (c *snowpipeClient) get(path string, query url.Values, out any) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if err = c.authorize(req); err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Annotate(err, "Failed to request the Snowpipe REST API")
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("Snowpipe REST API %s failed, status code: %d, body: %s", path, resp.StatusCode, body)
+	}
+	return errors.Annotate(json.Unmarshal(body, out), "Failed to parse the response of the Snowpipe REST API")
+}
+
+// authorize sets the JWT signed by the private key, or the OAuth token read again from the token file
+func (c *snowpipeClient) authorize(req *http.Request) error {
+	if c.privateKey != nil {
+		token, err := c.jwt(time.Now())
+		if err != nil {
+			return errors.Trace(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+		return nil
+	}
+	token := c.config.OAuthToken
+	if c.config.OAuthTokenFile != "" {
+		content, err := os.ReadFile(c.config.OAuthTokenFile)
+		if err != nil {
+			return errors.Annotate(err, "Failed to read Snowflake OAuth token")
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "OAUTH")
+	return nil
+}
+
+// jwt returns the JWT of key-pair authentication, issued by the fingerprint of the public key of the user
+func (c *snowpipeClient) jwt(now time.Time) (string, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(&c.privateKey.PublicKey)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	fingerprint := sha256.Sum256(publicKey)
+	// the account locator is without its region, e.g. xy12345 of xy12345.us-east-2.aws
+	account := strings.ToUpper(strings.SplitN(c.config.AccountId, ".", 2)[0])
+	subject := account + "." + strings.ToUpper(c.config.User)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss": subject + ".SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(snowpipeJWTLifetime).Unix(),
+	})
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signing))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,89 @@
+package snowsql
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnowpipeJWT(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	client := &snowpipeClient{config: &SnowflakeConfig{AccountId: "xy12345.us-east-2.aws", User: "loader"}, privateKey: privateKey}
+	now := time.Unix(1700000000, 0)
+	token, err := client.jwt(now)
+	require.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signature))
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	require.Equal(t, "XY12345.LOADER", claims["sub"])
+	require.True(t, strings.HasPrefix(claims["iss"].(string), "XY12345.LOADER.SHA256:"))
+	require.EqualValues(t, now.Add(snowpipeJWTLifetime).Unix(), claims["exp"])
+}
+
+func TestSnowpipeWaitIngested(t *testing.T) {
+	var beginMarks []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/data/pipes/APP.PUBLIC.INCREMENT_PIPE_DB_ORDERS/insertReport", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.Equal(t, "OAUTH", r.Header.Get("X-Snowflake-Authorization-Token-Type"))
+		beginMarks = append(beginMarks, r.URL.Query().Get("beginMark"))
+		_, _ = w.Write([]byte(`{"nextBeginMark":"1_2","files":[
+			{"path":"db/orders/1/2024-01-02/CDC000002.csv","status":"LOADED","rowsInserted":3},
+			{"path":"db/orders/1/2024-01-02/CDC000003.csv","status":"PARTIALLY_LOADED","errorsSeen":1,"firstError":"Numeric value 'x' is not recognized"}]}`))
+	})
+	mux.HandleFunc("/v1/data/pipes/APP.PUBLIC.INCREMENT_PIPE_DB_ORDERS/loadHistoryScan", func(w http.ResponseWriter, r *http.Request) {
+		require.NotEmpty(t, r.URL.Query().Get("startTimeInclusive"))
+		_, _ = w.Write([]byte(`{"files":[{"path":"db/orders/1/2024-01-02/CDC000001.csv","status":"LOADED","rowsInserted":2}]}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := &SnowflakeConfig{AccountId: "xy12345", User: "loader", OAuthToken: "token", Database: "app", Schema: "public"}
+	client, err := newSnowpipeClient(config)
+	require.NoError(t, err)
+	client.baseURL = server.URL
+	loader := newSnowpipeLoader(nil, NewGenerator(identcase.Upper), client, config, "increment_external_orders", "db", "orders", "")
+	require.Equal(t, "INCREMENT_STAGING_DB_ORDERS", loader.stagingTable)
+
+	// a file in the insertReport, whatever the commit ts of its rows
+	path, err := loader.waitIngested("1/2024-01-02/CDC000002.csv")
+	require.NoError(t, err)
+	require.Equal(t, "db/orders/1/2024-01-02/CDC000002.csv", path)
+	// a file ingested before the reports polled is found in the load history
+	path, err = loader.waitIngested("1/2024-01-02/CDC000001.csv")
+	require.NoError(t, err)
+	require.Equal(t, "db/orders/1/2024-01-02/CDC000001.csv", path)
+	require.Equal(t, []string{"", "1_2"}, beginMarks)
+	// the rows of a file failed are skipped by the pipe
+	_, err = loader.waitIngested("1/2024-01-02/CDC000003.csv")
+	require.ErrorContains(t, err, "is PARTIALLY_LOADED by pipe INCREMENT_PIPE_DB_ORDERS with 1 errors")
+
+	// a file reported again after its merge is delivered again
+	loader.merged["db/orders/1/2024-01-02/CDC000002.csv"] = time.Now()
+	delete(loader.reported, "db/orders/1/2024-01-02/CDC000002.csv")
+	require.NoError(t, loader.pollInsertReport())
+	require.Equal(t, []string{"db/orders/1/2024-01-02/CDC000002.csv"}, loader.redelivered)
+
+	_, err = newSnowpipeClient(&SnowflakeConfig{AccountId: "xy12345", User: "loader", Pass: "pass"})
+	require.ErrorContains(t, err, "requires --snowflake.private-key-path")
+}
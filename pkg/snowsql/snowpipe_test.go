@@ -0,0 +1,132 @@
+package snowsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadMode(t *testing.T) {
+	mode, err := snowsql.ParseLoadMode("Snowpipe")
+	require.NoError(t, err)
+	require.Equal(t, snowsql.LoadModeSnowpipe, mode)
+	_, err = snowsql.ParseLoadMode("stream")
+	require.Error(t, err)
+}
+
+func TestGenMergeIntoFromStaging(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "orders",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "int", IsPK: "true"},
+			{Name: "amount", Tp: "decimal"},
+		},
+	}
+	query := gen.GenMergeIntoFromStaging(tableDef, "INCREMENT_STAGING_APP_ORDERS", "app/orders/1/CDC000001.csv", nil, nil, "", deletemode.Hard)
+	require.Contains(t, query, `C1 AS "METADATA$FLAG"`)
+	require.Contains(t, query, `C5 AS "ID"`)
+	require.Contains(t, query, `C6 AS "AMOUNT"`)
+	require.Contains(t, query, `FROM "INCREMENT_STAGING_APP_ORDERS"`)
+	// only the rows of the file are merged, whatever their commit ts
+	require.Contains(t, query, "WHERE FILE_NAME = 'app/orders/1/CDC000001.csv'")
+	require.NotContains(t, query, "TO_NUMBER(C4) >")
+	// the rows delivered more than once are deduplicated
+	require.Contains(t, query, `QUALIFY row_number() over (partition by "ID" order by TO_NUMBER(C4) desc, CASE WHEN C1 = 'D' THEN 0 ELSE 1 END desc, FILE_NAME desc, FILE_ROW_NUMBER desc) = 1`)
+
+	// the merge from the stage is unchanged
+	query = gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", nil, nil, "", deletemode.Hard)
+	require.Contains(t, query, "FROM '@increment_external_orders/app/orders/1/CDC000001.csv'")
+	require.Contains(t, query, `QUALIFY row_number() over (partition by "ID" order by $4 desc, CASE WHEN $1 = 'D' THEN 0 ELSE 1 END desc, METADATA$FILE_ROW_NUMBER desc) = 1`)
+
+	// the fields of the columns filtered out are skipped
+	tableDef.Columns = append(tableDef.Columns[:1], cloudstorage.TableCol{Name: "email", Tp: "varchar"}, tableDef.Columns[1])
+	columnFilter := &columnfilter.Filter{Exclude: []string{"email"}}
+	query = gen.GenMergeIntoFromStaging(tableDef, "INCREMENT_STAGING_APP_ORDERS", "app/orders/1/CDC000001.csv", columnFilter, nil, "", deletemode.Hard)
+	require.Contains(t, query, `C5 AS "ID"`)
+	require.Contains(t, query, `C7 AS "AMOUNT"`)
+	require.NotContains(t, query, "EMAIL")
+	query = gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", columnFilter, nil, "", deletemode.Hard)
+	require.Contains(t, query, `$5 AS "ID"`)
+	require.Contains(t, query, `$7 AS "AMOUNT"`)
+	require.Contains(t, query, `INSERT ("ID", "AMOUNT") VALUES (S."ID", S."AMOUNT")`)
+	require.NotContains(t, query, "EMAIL")
+
+	// the rows not matching the predicate are not inserted, and deleted if they were
+	query = gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", nil, nil, "amount > 100", deletemode.Hard)
+	require.Contains(t, query, `SELECT *, COALESCE((amount > 100), FALSE) AS "METADATA$MATCHED" FROM (`)
+	require.Contains(t, query, "WHEN MATCHED AND S.METADATA$FLAG != 'D' AND S.METADATA$MATCHED THEN UPDATE")
+	require.Contains(t, query, "WHEN MATCHED AND (S.METADATA$FLAG = 'D' OR NOT S.METADATA$MATCHED) THEN DELETE")
+	require.Contains(t, query, "WHEN NOT MATCHED AND S.METADATA$FLAG != 'D' AND S.METADATA$MATCHED THEN INSERT")
+
+	// the rows deleted are marked deleted in the soft delete mode, and unmarked if inserted again
+	query = gen.GenMergeInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", columnFilter, nil, "", deletemode.Soft)
+	require.Contains(t, query, `WHEN MATCHED AND S.METADATA$FLAG = 'D' THEN UPDATE SET "_TIDB_DELETED" = TRUE, "_TIDB_DELETED_AT" = CURRENT_TIMESTAMP()`)
+	require.Contains(t, query, `UPDATE SET "ID" = S."ID", "AMOUNT" = S."AMOUNT", "_TIDB_DELETED" = FALSE, "_TIDB_DELETED_AT" = NULL`)
+	require.Contains(t, query, `INSERT ("ID", "AMOUNT", "_TIDB_DELETED", "_TIDB_DELETED_AT") VALUES (S."ID", S."AMOUNT", FALSE, NULL)`)
+	require.NotContains(t, query, "THEN DELETE")
+}
+
+func TestGenMergeIntoBit(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "flags",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "INT", IsPK: "true"},
+			{Name: "enabled", Tp: "BIT", Precision: "1"},
+			{Name: "mask", Tp: "BIT", Precision: "12"},
+		},
+	}
+	// BIT(1) is cast from 0 or 1 implicitly, a longer BIT is converted from the unsigned integer to its bytes
+	query := gen.GenMergeInto(tableDef, "app/flags/1/CDC000001.csv", "increment_external_flags", nil, nil, "", deletemode.Hard)
+	require.Contains(t, query, `$6 AS "ENABLED"`)
+	require.Contains(t, query, `TO_BINARY(LPAD(TRIM(TO_CHAR(TO_NUMBER($7), 'XXXX')), 4, '0'), 'HEX') AS "MASK"`)
+	query = gen.GenMergeIntoFromStaging(tableDef, "INCREMENT_STAGING_APP_FLAGS", "app/flags/1/CDC000001.csv", nil, nil, "", deletemode.Hard)
+	require.Contains(t, query, `TO_BINARY(LPAD(TRIM(TO_CHAR(TO_NUMBER(C7), 'XXXX')), 4, '0'), 'HEX') AS "MASK"`)
+	// the field of a column overridden is cast implicitly
+	query = gen.GenMergeInto(tableDef, "app/flags/1/CDC000001.csv", "increment_external_flags", nil, columnmapping.Columns{"mask": "NUMBER"}, "", deletemode.Hard)
+	require.Contains(t, query, `$7 AS "MASK"`)
+}
+
+func TestGenAppendInto(t *testing.T) {
+	gen := snowsql.NewGenerator(identcase.Upper)
+	tableDef := cloudstorage.TableDefinition{
+		Table: "orders",
+		Columns: []cloudstorage.TableCol{
+			{Name: "id", Tp: "INT", IsPK: "true"},
+			{Name: "email", Tp: "VARCHAR", Precision: "64"},
+			{Name: "amount", Tp: "DECIMAL", Precision: "10", Scale: "2"},
+		},
+	}
+	columnFilter := &columnfilter.Filter{Exclude: []string{"email"}}
+	query := gen.GenAppendInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", columnFilter, nil, "")
+	require.Contains(t, query, `INSERT INTO "ORDERS_CHANGELOG" ("TIDB2DW_FLAG", "TIDB2DW_COMMIT_TS", "ID", "AMOUNT")`)
+	require.Contains(t, query, `$1 AS "TIDB2DW_FLAG",`)
+	require.Contains(t, query, `$4 AS "TIDB2DW_COMMIT_TS",`)
+	require.Contains(t, query, `$5 AS "ID",`)
+	require.Contains(t, query, `$7 AS "AMOUNT"`)
+	require.Contains(t, query, "FROM '@increment_external_orders/app/orders/1/CDC000001.csv'")
+	require.NotContains(t, query, "EMAIL")
+	require.NotContains(t, query, "MERGE")
+	require.NotContains(t, query, "WHERE")
+
+	// the changes of the rows not matching the predicate are not appended
+	query = gen.GenAppendInto(tableDef, "app/orders/1/CDC000001.csv", "increment_external_orders", nil, nil, "amount > 100")
+	require.Contains(t, query, "WHERE COALESCE((amount > 100), FALSE);")
+
+	// the changelog table has no primary key and is kept if it exists
+	query, err := gen.GenCreateChangelogSQL(tableDef.Table, columnFilter.Columns(tableDef.Columns), nil)
+	require.NoError(t, err)
+	require.Equal(t, `CREATE TABLE IF NOT EXISTS "ORDERS_CHANGELOG" (
+    "TIDB2DW_FLAG" VARCHAR(1),
+    "TIDB2DW_COMMIT_TS" BIGINT,
+    "ID" INT,
+    "AMOUNT" DECIMAL(10, 2)
+)`, query)
+}
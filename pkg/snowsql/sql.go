@@ -4,8 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,38 +28,47 @@ import (
 	"go.uber.org/zap"
 )
 
-func CreateExternalStage(db *sql.DB, stageName, s3WorkspaceURL string, cred *credentials.Value) error {
+// CreateExternalStage creates the stage on s3WorkspaceURL, endpoint is the host of S3-compatible storage
+// and should be empty for AWS S3.
+func CreateExternalStage(db *sql.DB, stageName, s3WorkspaceURL, endpoint string, cred credentials.Value, compression utils.Compression) error {
+	endpointClause := ""
+	if endpoint != "" {
+		endpointClause = fmt.Sprintf("\nENDPOINT = '%s'", utils.EscapeString(endpoint))
+	}
 	sql, err := formatter.Format(`
 CREATE OR REPLACE STAGE {stageName}
-URL = '{url}'
+URL = '{url}'{endpoint}
 CREDENTIALS = (AWS_KEY_ID = '{awsKeyId}' AWS_SECRET_KEY = '{awsSecretKey}' AWS_TOKEN = '{awsToken}')
-FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
+FILE_FORMAT = ({fileFormat});
 	`, formatter.Named{
 		"stageName":    utils.EscapeString(stageName),
 		"url":          utils.EscapeString(s3WorkspaceURL),
+		"endpoint":     endpointClause,
 		"awsKeyId":     utils.EscapeString(cred.AccessKeyID),
 		"awsSecretKey": utils.EscapeString(cred.SecretAccessKey),
 		"awsToken":     utils.EscapeString(cred.SessionToken),
+		"fileFormat":   CSVFileFormat(compression),
 	})
 	if err != nil {
 		return err
 	}
 	_, err = db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
-func CreateInternalStage(db *sql.DB, stageName string) error {
+func CreateInternalStage(db *sql.DB, stageName string, compression utils.Compression) error {
 	sql, err := formatter.Format(`
 CREATE OR REPLACE STAGE {stageName}
-FILE_FORMAT = (type = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"');
+FILE_FORMAT = ({fileFormat});
 `, formatter.Named{
-		"stageName": utils.EscapeString(stageName),
+		"stageName":  utils.EscapeString(stageName),
+		"fileFormat": CSVFileFormat(compression),
 	})
 	if err != nil {
 		return err
 	}
 	_, err = db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
 }
 
 func DropStage(db *sql.DB, stageName string) error {
@@ -65,7 +81,56 @@ DROP STAGE IF EXISTS {stageName};
 		return errors.Trace(err)
 	}
 	_, err = db.Exec(sql)
-	return err
+	return diag.WrapSQL(err, sql)
+}
+
+// parquetFormatOptions are the options of the Parquet files converted by stagingformat.Converter, whose binary
+// values have no logical type and whose DATETIME values have a logical type only
+const parquetFormatOptions = "TYPE = 'PARQUET' BINARY_AS_TEXT = FALSE USE_LOGICAL_TYPE = TRUE"
+
+// ParquetFileFormatName returns the name of the file format the Parquet files of the stage are read by
+func ParquetFileFormatName(stageName string) string {
+	return stageName + "_parquet"
+}
+
+// CreateParquetFileFormat creates the file format of the Parquet files of the stage, the stage reads the CSV files
+func CreateParquetFileFormat(db *sql.DB, stageName string) error {
+	sql := fmt.Sprintf("CREATE FILE FORMAT IF NOT EXISTS %s %s", utils.EscapeString(ParquetFileFormatName(stageName)), parquetFormatOptions)
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// DropParquetFileFormat drops the file format of the Parquet files of the stage if it exists
+func DropParquetFileFormat(db *sql.DB, stageName string) error {
+	sql := fmt.Sprintf("DROP FILE FORMAT IF EXISTS %s", utils.EscapeString(ParquetFileFormatName(stageName)))
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
+}
+
+// fileField returns the reference of a field of a staged file of the format, the i-th field of a CSV file counted
+// from 1, or the field of the column name of a Parquet file
+func fileField(format stagingformat.Format, i int, name string) string {
+	if format == stagingformat.Parquet {
+		return fmt.Sprintf(`$1:"%s"`, strings.ReplaceAll(name, `"`, `""`))
+	}
+	return fmt.Sprintf("$%d", i)
+}
+
+// stagedFile returns the staged file selected from, the Parquet files are read by the file format of the stage
+// created by CreateParquetFileFormat
+func stagedFile(stageName, filePath string) string {
+	source := fmt.Sprintf("'@%s/%s'", stageName, filePath)
+	if stagingformat.FileFormat(filePath) == stagingformat.Parquet {
+		source += fmt.Sprintf(" (FILE_FORMAT => '%s')", utils.EscapeString(ParquetFileFormatName(stageName)))
+	}
+	return source
+}
+
+// DropTable drops the table in the database and schema of db if it exists
+func (g Generator) DropTable(db *sql.DB, tableName string) error {
+	sql := fmt.Sprintf("DROP TABLE IF EXISTS %s", g.QuoteIdent(tableName))
+	_, err := db.Exec(sql)
+	return diag.WrapSQL(err, sql)
 }
 
 func GetServerSideTimestamp(db *sql.DB) (string, error) {
@@ -77,29 +142,105 @@ func GetServerSideTimestamp(db *sql.DB) (string, error) {
 	return result, nil
 }
 
-func LoadSnapshotFromStage(db *sql.DB, targetTable, stageName, filePrefix string, onSnapshotLoadProgress func(loadedRows int64)) error {
+// maxCommentLength is the limit of characters of a comment, the length of the VARCHAR it is stored in
+const maxCommentLength = 16777216
+
+// genComment returns the literal of the comment, object is the table or column logged when the comment is truncated
+func genComment(comment string, object string) string {
+	return utils.QuoteLiteral(tidbsql.TruncateComment(comment, maxCommentLength, object))
+}
+
+// Generator generates the statements of Snowflake, the names of the tables and the columns are quoted in its
+// identifier case. Each connector has its own, so the pipelines of a process may write the names differently.
+type Generator struct {
+	identifierCase identcase.Case
+	// skipComments leaves the tables and the columns without comments
+	skipComments bool
+}
+
+// NewGenerator returns the generator writing the names of the tables and the columns in the case, identcase.Upper
+// by default as Snowflake stores the unquoted names in upper case
+func NewGenerator(identifierCase identcase.Case) Generator {
+	return Generator{identifierCase: identifierCase}
+}
+
+// WithSyncComments returns the generator replicating the comments of the tables and the columns or not
+func (g Generator) WithSyncComments(sync bool) Generator {
+	g.skipComments = !sync
+	return g
+}
+
+// QuoteIdent quotes the name of a table or a column by double quotes in the identifier case, the double quotes in
+// the name are escaped. The name is uppercased by default to keep referring to the tables created before the names
+// were quoted.
+func (g Generator) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(g.identifierCase.Apply(name), `"`, `""`) + `"`
+}
+
+// CSVFileFormat returns the options of the file format of the CSV files compressed by compression, the COMPRESSION
+// option is omitted for the files not compressed, which means AUTO
+func CSVFileFormat(compression utils.Compression) string {
+	format := `TYPE = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"'`
+	if compression == utils.CompressionNone {
+		return format
+	}
+	return fmt.Sprintf("%s COMPRESSION = '%s'", format, strings.ToUpper(string(compression)))
+}
+
+// maxFilesPerCopy is the max number of files listed by the FILES option of COPY
+const maxFilesPerCopy = 1000
+
+// LoadSnapshotFromStage copies the files in the stage into the table and returns the number of loaded rows,
+// at most maxFilesPerCopy files can be loaded at once. The fields of the CSV files are copied into the columns in
+// order, all the columns of the table if columns is empty, the other columns get their default values. The fields
+// of the Parquet files are copied by their names, columns must be the columns of the files.
+func (g Generator) LoadSnapshotFromStage(db *sql.DB, targetTable string, columns []string, stageName string, files []string, compression utils.Compression, onSnapshotLoadProgress func(loadedRows int64)) (int64, error) {
 	// The timestamp and reqId is used to monitor the progress of COPY INTO query.
 	ts, err := GetServerSideTimestamp(db)
 	if err != nil {
-		return errors.Trace(err)
+		return 0, errors.Trace(err)
 	}
 	reqId := gosnowflake.NewUUID()
 
+	quotedFiles := make([]string, 0, len(files))
+	for _, file := range files {
+		quotedFiles = append(quotedFiles, utils.QuoteLiteral(file))
+	}
+	source := "@" + utils.EscapeString(stageName)
+	fileFormat := CSVFileFormat(compression)
+	if len(files) > 0 && stagingformat.FileFormat(files[0]) == stagingformat.Parquet {
+		fields := make([]string, 0, len(columns))
+		for _, column := range columns {
+			fields = append(fields, fileField(stagingformat.Parquet, 0, column))
+		}
+		source = fmt.Sprintf("(SELECT %s FROM %s)", strings.Join(fields, ", "), source)
+		fileFormat = parquetFormatOptions
+	}
+	if len(columns) > 0 {
+		quotedColumns := make([]string, 0, len(columns))
+		for _, column := range columns {
+			quotedColumns = append(quotedColumns, g.QuoteIdent(column))
+		}
+		targetTable = fmt.Sprintf("%s (%s)", g.QuoteIdent(targetTable), strings.Join(quotedColumns, ", "))
+	} else {
+		targetTable = g.QuoteIdent(targetTable)
+	}
 	sql, err := formatter.Format(`
 COPY INTO {targetTable}
 -- tidb2dw-reqid={reqId}
-FROM @{stageName}
-FILE_FORMAT = (TYPE = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"')
-PATTERN = '.*{filePrefix}.*\.csv'
+FROM {source}
+FILE_FORMAT = ({fileFormat})
+FILES = ({files})
 ON_ERROR = CONTINUE;
 `, formatter.Named{
 		"reqId":       utils.EscapeString(reqId.String()),
-		"targetTable": utils.EscapeString(targetTable),
-		"stageName":   utils.EscapeString(stageName),
-		"filePrefix":  utils.EscapeString(regexp.QuoteMeta(filePrefix)),
+		"targetTable": targetTable,
+		"source":      source,
+		"fileFormat":  fileFormat,
+		"files":       strings.Join(quotedFiles, ", "),
 	})
 	if err != nil {
-		return errors.Trace(err)
+		return 0, errors.Trace(err)
 	}
 
 	ctx := gosnowflake.WithRequestID(context.Background(), reqId)
@@ -147,115 +288,322 @@ ON_ERROR = CONTINUE;
 		}
 	}()
 
-	_, err = db.ExecContext(ctx, sql)
+	loadedRows, err := execCopy(ctx, db, sql)
 	copyFinished <- struct{}{}
 
 	wg.Wait()
 
-	return err
+	return loadedRows, err
 }
 
-func GetDefaultValueString(val string) string {
-	_, err := strconv.ParseFloat(fmt.Sprint(val), 64)
+// execCopy executes the COPY statement and returns the total rows_loaded of the files
+func execCopy(ctx context.Context, db *sql.DB, query string) (int64, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return 0, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
 	if err != nil {
-		return fmt.Sprintf("'%s'", val) // FIXME: escape
+		return 0, errors.Trace(err)
+	}
+	var loadedRows int64
+	values := make([]sql.NullString, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
 	}
-	return fmt.Sprint(val)
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return 0, diag.WrapSQL(err, query)
+		}
+		for i, column := range columns {
+			if strings.EqualFold(column, "rows_loaded") {
+				n, _ := strconv.ParseInt(values[i].String, 10, 64)
+				loadedRows += n
+			}
+		}
+	}
+	return loadedRows, errors.Trace(rows.Err())
 }
 
-func GenCreateSchema(sourceDatabase string, sourceTable string, sourceTiDBConn *sql.DB) (string, error) {
+// GenCreateSchema generates the DDL of the table by the TiDB table, whose primary key is the dedup key if it has none
+func (g Generator) GenCreateSchema(sourceDatabase, sourceTable, targetTable string, sourceTiDBConn *sql.DB, columnTypes columnmapping.Columns, columnFilter *columnfilter.Filter, layout tablelayout.Layout, deleteMode deletemode.Mode, dedupKey []string) (string, error) {
 	tableColumns, err := tidbsql.GetTiDBTableColumn(sourceTiDBConn, sourceDatabase, sourceTable)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
+	tableColumns = columnFilter.Columns(tableColumns)
+	var comments *tidbsql.TableComments
+	if !g.skipComments {
+		if comments, err = tidbsql.GetTiDBTableComments(sourceTiDBConn, sourceDatabase, sourceTable); err != nil {
+			return "", errors.Trace(err)
+		}
+	}
+	snowflakePKColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if len(snowflakePKColumns) == 0 {
+		snowflakePKColumns = dedupKey
+	}
+	return g.GenCreateTableSQL(targetTable, tableColumns, snowflakePKColumns, comments, columnTypes, layout, deleteMode)
+}
+
+// GenCreateTableSQL generates the DDL of the table, comments are omitted if nil. The table is clustered by the
+// clustering columns of the layout, Snowflake has no partitioning. The tombstone columns of the delete mode
+// follow the columns.
+func (g Generator) GenCreateTableSQL(tableName string, tableColumns []cloudstorage.TableCol, pkColumns []string, comments *tidbsql.TableComments, columnTypes columnmapping.Columns, layout tablelayout.Layout, deleteMode deletemode.Mode) (string, error) {
+	if comments == nil {
+		comments = &tidbsql.TableComments{}
+	}
 	columnRows := make([]string, 0, len(tableColumns))
 	for _, column := range tableColumns {
-		row, err := GetSnowflakeColumnString(column)
+		row, err := g.GetSnowflakeColumnString(column, columnTypes)
 		if err != nil {
 			return "", errors.Trace(err)
 		}
+		if comment, ok := comments.Columns[column.Name]; ok {
+			row += fmt.Sprintf(" COMMENT %s", genComment(comment, column.Name))
+		}
 		columnRows = append(columnRows, row)
 	}
 
-	snowflakePKColumns, err := tidbsql.GetTiDBTablePKColumns(sourceTiDBConn, sourceDatabase, sourceTable)
-	if err != nil {
-		return "", errors.Trace(err)
-	}
+	columnRows = append(columnRows, deleteMode.ColumnDefs(g.QuoteIdent, "BOOLEAN DEFAULT FALSE", "TIMESTAMP")...)
 
 	// TODO: Support unique key
 
 	sqlRows := make([]string, 0, len(columnRows)+1)
 	sqlRows = append(sqlRows, columnRows...)
-	if len(snowflakePKColumns) > 0 {
-		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(snowflakePKColumns, ", ")))
+	if len(pkColumns) > 0 {
+		quotedPKColumns := make([]string, 0, len(pkColumns))
+		for _, column := range pkColumns {
+			quotedPKColumns = append(quotedPKColumns, g.QuoteIdent(column))
+		}
+		sqlRows = append(sqlRows, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(quotedPKColumns, ", ")))
 	}
 	// Add idents
 	for i := 0; i < len(sqlRows); i++ {
 		sqlRows[i] = fmt.Sprintf("    %s", sqlRows[i])
 	}
 
+	clusterColumns, err := tablelayout.Lookup(layout.ClusterBy, tableColumns)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
 	sql := []string{}
-	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, sourceTable)) // TODO: Escape
+	sql = append(sql, fmt.Sprintf(`CREATE OR REPLACE TABLE %s (`, g.QuoteIdent(tableName)))
 	sql = append(sql, strings.Join(sqlRows, ",\n"))
-	sql = append(sql, ")")
+	closing := ")"
+	if len(clusterColumns) > 0 {
+		quotedClusterColumns := make([]string, 0, len(clusterColumns))
+		for _, column := range clusterColumns {
+			quotedClusterColumns = append(quotedClusterColumns, g.QuoteIdent(column.Name))
+		}
+		closing += fmt.Sprintf(" CLUSTER BY (%s)", strings.Join(quotedClusterColumns, ", "))
+	}
+	if comments.Table != "" {
+		closing += fmt.Sprintf(" COMMENT = %s", genComment(comments.Table, tableName))
+	}
+	sql = append(sql, closing)
 
 	return strings.Join(sql, "\n"), nil
 }
 
-func GenMergeInto(tableDef cloudstorage.TableDefinition, filePath string, stageName string) string {
+// GenCreateChangelogSQL generates the DDL of the changelog table of the table in --increment-mode=append, see
+// incrementmode.ChangelogColumns. Unlike the table, an existing changelog table is kept with its history.
+func (g Generator) GenCreateChangelogSQL(tableName string, tableColumns []cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	ddl, err := g.GenCreateTableSQL(incrementmode.ChangelogTable(tableName), incrementmode.ChangelogColumns(tableColumns), nil, nil, columnTypes, tablelayout.Layout{}, deletemode.Hard)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return strings.Replace(ddl, "CREATE OR REPLACE TABLE", "CREATE TABLE IF NOT EXISTS", 1), nil
+}
+
+// GenAppendInto appends every row of the staged file to the changelog table of the table with its flag and commit
+// ts, the fields are converted as GenMergeInto does. If where is not empty, only the rows matching it are appended.
+func (g Generator) GenAppendInto(tableDef cloudstorage.TableDefinition, filePath string, stageName string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string) string {
+	format := stagingformat.FileFormat(filePath)
+	columns := incrementmode.ChangelogColumns(columnFilter.Columns(tableDef.Columns))
+	names := make([]string, 0, len(columns))
+	for _, col := range columns {
+		names = append(names, g.QuoteIdent(col.Name))
+	}
+	selectStat := make([]string, 0, len(columns))
+	selectStat = append(selectStat,
+		fmt.Sprintf("%s AS %s", fileField(format, 1, utils.CDCFlagColumnName), g.QuoteIdent(utils.CDCFlagColumnName)),
+		fmt.Sprintf("%s AS %s", fileField(format, 4, utils.CDCCommitTsColumnName), g.QuoteIdent(utils.CDCCommitTsColumnName)))
+	for i, col := range tableDef.Columns {
+		if columnFilter.Retains(col.Name) {
+			selectStat = append(selectStat, fmt.Sprintf(`%s AS %s`, castField(fileField(format, i+5, col.Name), col, columnTypes, format), g.QuoteIdent(col.Name)))
+		}
+	}
+	whereStat := ""
+	if where != "" {
+		whereStat = fmt.Sprintf("\n\t\tWHERE COALESCE((%s), FALSE)", where)
+	}
+	return fmt.Sprintf(
+		`INSERT INTO %s (%s)
+		SELECT %s FROM (
+			SELECT
+				%s
+			FROM %s
+		)%s;`,
+		g.QuoteIdent(incrementmode.ChangelogTable(tableDef.Table)),
+		strings.Join(names, ", "),
+		strings.Join(names, ", "),
+		strings.Join(selectStat, ",\n"),
+		stagedFile(stageName, filePath),
+		whereStat)
+}
+
+// GenMergeInto merges the rows of the staged file into the table. The file has all the columns of the
+// table in TiDB, the fields of the columns filtered out by columnFilter are skipped. The fields of the columns
+// overridden by columnTypes are not converted. If where is not empty, only the rows matching it are kept in the table.
+// The rows deleted are deleted or marked deleted by the delete mode.
+func (g Generator) GenMergeInto(tableDef cloudstorage.TableDefinition, filePath string, stageName string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode) string {
+	format := stagingformat.FileFormat(filePath)
+	selectStat := make([]string, 0, len(tableDef.Columns)+1)
+	selectStat = append(selectStat, fmt.Sprintf(`%s AS "METADATA$FLAG"`, fileField(format, 1, utils.CDCFlagColumnName)))
+	for i, col := range tableDef.Columns {
+		if columnFilter.Retains(col.Name) {
+			selectStat = append(selectStat, fmt.Sprintf(`%s AS %s`, castField(fileField(format, i+5, col.Name), col, columnTypes, format), g.QuoteIdent(col.Name)))
+		}
+	}
+	// the changes of the same commit ts and flag are ordered as they are written
+	orderBy := utils.LatestChangeOrder(fileField(format, 4, utils.CDCCommitTsColumnName), fileField(format, 1, utils.CDCFlagColumnName)) +
+		", METADATA$FILE_ROW_NUMBER desc"
+	return g.genMerge(columnFilter.TableDef(tableDef), selectStat, stagedFile(stageName, filePath), orderBy, where, deleteMode)
+}
+
+// stagingChangeOrder orders the changes of a key in a staging table from the latest, the changes of the same commit
+// ts and flag are ordered by the files and the rows they are written in
+var stagingChangeOrder = utils.LatestChangeOrder("TO_NUMBER(C4)", "C1") + ", FILE_NAME desc, FILE_ROW_NUMBER desc"
+
+// GenMergeIntoFromStaging merges the rows of the file from the staging table of Snowpipe, filePath is its path in
+// the stage. The file may be delivered more than once so the latest row of each key is used.
+func (g Generator) GenMergeIntoFromStaging(tableDef cloudstorage.TableDefinition, stagingTable, filePath string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode) string {
+	source := fmt.Sprintf("%s\n\t\t\tWHERE FILE_NAME = '%s'", quoteName(stagingTable), utils.EscapeString(filePath))
+	return g.genMerge(columnFilter.TableDef(tableDef), g.stagingSelectStat(tableDef, columnFilter, columnTypes, stagingformat.CSV), source, stagingChangeOrder, where, deleteMode)
+}
+
+// GenMergeIntoFromBatch merges the rows of all the files copied into the staging table of a batch, the latest row
+// of each key is used. The files of a batch are of the same path and their names have the index zero padded,
+// so a later file has a greater name. The files of a batch are of the same format.
+func (g Generator) GenMergeIntoFromBatch(tableDef cloudstorage.TableDefinition, stagingTable string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format) string {
+	return g.genMerge(columnFilter.TableDef(tableDef), g.stagingSelectStat(tableDef, columnFilter, columnTypes, format), g.QuoteIdent(stagingTable),
+		stagingChangeOrder, where, deleteMode)
+}
+
+// GenMergeIntoFromBatchPartition merges the rows of the staging table of a batch like GenMergeIntoFromBatch, but
+// only of the keys whose hash falls into the partition of the given number of partitions. The rows of a key are all
+// in the same partition, so the latest row of each key is the same as the one of the whole batch.
+func (g Generator) GenMergeIntoFromBatchPartition(tableDef cloudstorage.TableDefinition, stagingTable string, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, where string, deleteMode deletemode.Mode, format stagingformat.Format, partition, partitions int) string {
+	// the fields of the key are hashed as they are copied, a key is written the same by every change of it
+	pkFields := make([]string, 0, 1)
+	for i, col := range tableDef.Columns {
+		if col.IsPK == "true" {
+			pkFields = append(pkFields, fmt.Sprintf("C%d", i+5))
+		}
+	}
+	source := fmt.Sprintf("%s\n\t\t\tWHERE MOD(ABS(HASH(%s)), %d) = %d", g.QuoteIdent(stagingTable), strings.Join(pkFields, ", "), partitions, partition)
+	return g.genMerge(columnFilter.TableDef(tableDef), g.stagingSelectStat(tableDef, columnFilter, columnTypes, format), source,
+		stagingChangeOrder, where, deleteMode)
+}
+
+// stagingSelectStat selects the columns of the table from the fields C1..Cn of a staging table, copied from the
+// files of the format
+func (g Generator) stagingSelectStat(tableDef cloudstorage.TableDefinition, columnFilter *columnfilter.Filter, columnTypes columnmapping.Columns, format stagingformat.Format) []string {
 	selectStat := make([]string, 0, len(tableDef.Columns)+1)
-	selectStat = append(selectStat, `$1 AS "METADATA$FLAG"`)
+	selectStat = append(selectStat, `C1 AS "METADATA$FLAG"`)
 	for i, col := range tableDef.Columns {
-		selectStat = append(selectStat, fmt.Sprintf(`$%d AS %s`, i+5, col.Name))
+		if columnFilter.Retains(col.Name) {
+			selectStat = append(selectStat, fmt.Sprintf(`%s AS %s`, castField(fmt.Sprintf("C%d", i+5), col, columnTypes, format), g.QuoteIdent(col.Name)))
+		}
 	}
+	return selectStat
+}
+
+// castField converts the field of an increment file of the format to the column where Snowflake does not cast it
+// implicitly: TiCDC writes a BIT longer than 1 as an unsigned integer, which is converted to its bytes, while the
+// Parquet files have its bytes. The field of a column overridden by columnTypes is cast implicitly to the type given.
+func castField(field string, col cloudstorage.TableCol, columnTypes columnmapping.Columns, format stagingformat.Format) string {
+	length := tidbsql.BitLength(col)
+	if _, ok := columnTypes.Lookup(col.Name); ok || length <= 1 || format == stagingformat.Parquet {
+		return field
+	}
+	digits := 2 * tidbsql.BitBytes(length)
+	return fmt.Sprintf("TO_BINARY(LPAD(TRIM(TO_CHAR(TO_NUMBER(%s), '%s')), %d, '0'), 'HEX')", field, strings.Repeat("X", digits), digits)
+}
+
+func (g Generator) genMerge(tableDef cloudstorage.TableDefinition, selectStat []string, source, orderBy, where string, deleteMode deletemode.Mode) string {
+	clauses := deleteMode.MergeClauses(g.QuoteIdent, "CURRENT_TIMESTAMP()")
 
 	pkColumn := make([]string, 0)
 	onStat := make([]string, 0)
 	for _, col := range tableDef.Columns {
 		if col.IsPK == "true" {
-			pkColumn = append(pkColumn, col.Name)
-			onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, col.Name, col.Name))
+			pkColumn = append(pkColumn, g.QuoteIdent(col.Name))
+			onStat = append(onStat, fmt.Sprintf(`T.%s = S.%s`, g.QuoteIdent(col.Name), g.QuoteIdent(col.Name)))
 		}
 	}
 
 	updateStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		updateStat = append(updateStat, fmt.Sprintf(`%s = S.%s`, col.Name, col.Name))
+		updateStat = append(updateStat, fmt.Sprintf(`%s = S.%s`, g.QuoteIdent(col.Name), g.QuoteIdent(col.Name)))
 	}
+	updateStat = append(updateStat, clauses.UpdateSets...)
 
 	insertStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		insertStat = append(insertStat, col.Name)
+		insertStat = append(insertStat, g.QuoteIdent(col.Name))
 	}
+	insertStat = append(insertStat, clauses.InsertColumns...)
 
 	valuesStat := make([]string, 0, len(tableDef.Columns))
 	for _, col := range tableDef.Columns {
-		valuesStat = append(valuesStat, fmt.Sprintf(`S.%s`, col.Name))
+		valuesStat = append(valuesStat, fmt.Sprintf(`S.%s`, g.QuoteIdent(col.Name)))
 	}
+	valuesStat = append(valuesStat, clauses.InsertValues...)
 
 	// TODO: Remove QUALIFY row_number() after cdc support merge dml or snowflake support deterministic merge
+	sourceQuery := fmt.Sprintf(
+		`SELECT
+				%s
+			FROM %s
+			QUALIFY row_number() over (partition by %s order by %s) = 1`,
+		strings.Join(selectStat, ",\n"),
+		source,
+		strings.Join(pkColumn, ", "),
+		orderBy)
+	upsertCond, deleteCond := "S.METADATA$FLAG != 'D'", "S.METADATA$FLAG = 'D'"
+	if where != "" {
+		// the latest row of a key is matched, a row updated to not match any more is deleted
+		sourceQuery = fmt.Sprintf("SELECT *, COALESCE((%s), FALSE) AS \"METADATA$MATCHED\" FROM (\n\t\t\t%s\n\t\t\t)", where, sourceQuery)
+		upsertCond += " AND S.METADATA$MATCHED"
+		deleteCond = fmt.Sprintf("(%s OR NOT S.METADATA$MATCHED)", deleteCond)
+	}
 	mergeQuery := fmt.Sprintf(
 		`MERGE INTO %s AS T USING
 		(
-			SELECT
-				%s
-			FROM '@%s/%s'
-			QUALIFY row_number() over (partition by %s order by $4 desc) = 1
+			%s
 		) AS S
 		ON
 		(
 			%s
 		)
-		WHEN MATCHED AND S.METADATA$FLAG != 'D' THEN UPDATE SET %s
-		WHEN MATCHED AND S.METADATA$FLAG = 'D' THEN DELETE
-		WHEN NOT MATCHED AND S.METADATA$FLAG != 'D' THEN INSERT (%s) VALUES (%s);`,
-		tableDef.Table,
-		strings.Join(selectStat, ",\n"),
-		stageName,
-		filePath,
-		strings.Join(pkColumn, ", "),
+		WHEN MATCHED AND %s THEN UPDATE SET %s
+		WHEN MATCHED AND %s THEN %s
+		WHEN NOT MATCHED AND %s THEN INSERT (%s) VALUES (%s);`,
+		g.QuoteIdent(tableDef.Table),
+		sourceQuery,
 		strings.Join(onStat, " AND "),
+		upsertCond,
 		strings.Join(updateStat, ", "),
+		deleteCond,
+		clauses.DeleteAction,
+		upsertCond,
 		strings.Join(insertStat, ", "),
 		strings.Join(valuesStat, ", "))
 
@@ -0,0 +1,17 @@
+package snowsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVFileFormat(t *testing.T) {
+	format := `TYPE = 'CSV' EMPTY_FIELD_AS_NULL = FALSE NULL_IF=('\\N') FIELD_OPTIONALLY_ENCLOSED_BY='"'`
+	// the compression of the files not compressed is detected by Snowflake
+	require.Equal(t, format, snowsql.CSVFileFormat(utils.CompressionNone))
+	require.Equal(t, format+" COMPRESSION = 'GZIP'", snowsql.CSVFileFormat(utils.CompressionGzip))
+	require.Equal(t, format+" COMPRESSION = 'ZSTD'", snowsql.CSVFileFormat(utils.CompressionZstd))
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/columnmapping"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 )
@@ -37,21 +39,57 @@ var TiDB2SnowflakeTypeMap map[string]string = map[string]string{
 	"datetime":   "DATETIME",
 	"timestamp":  "TIMESTAMP",
 	"time":       "TIME",
+	"enum":       "VARCHAR",
+	"set":        "VARCHAR",
 }
 
-func GetSnowflakeTypeString(column cloudstorage.TableCol) (string, error) {
+// snowflakeMaxDecimalPrecision is the max precision of NUMBER
+const snowflakeMaxDecimalPrecision = 38
+
+// GetSnowflakeTypeString returns the column with its Snowflake type, the type given by columnTypes takes
+// precedence over the default mapping
+func (g Generator) GetSnowflakeTypeString(column cloudstorage.TableCol, columnTypes columnmapping.Columns) (string, error) {
+	if tp, ok := columnTypes.Lookup(column.Name); ok {
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), tp), nil
+	}
 	tp := strings.ToLower(column.Tp)
+	// the integer types of Snowflake are NUMBER(38,0), which keeps the unsigned values up to 2^64-1
+	tp = strings.TrimSuffix(tp, " unsigned")
 	switch tp {
 	case "text", "longtext", "mediumtext", "tinytext", "blob", "longblob", "mediumblob", "tinyblob":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp]), nil
 	case "int", "mediumint", "bigint", "tinyint", "smallint", "float", "double", "bool", "boolean", "date":
-		return fmt.Sprintf("%s %s", column.Name, TiDB2SnowflakeTypeMap[tp]), nil
+		return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp]), nil
 	case "varchar", "char", "binary", "varbinary":
-		return fmt.Sprintf("%s %s(%s)", column.Name, TiDB2SnowflakeTypeMap[tp], column.Precision), nil
+		return fmt.Sprintf("%s %s(%s)", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp], column.Precision), nil
+	case "enum", "set":
+		// the length of the longest value is known when the table is copied from TiDB, not by the schema files
+		if column.Precision == "" {
+			return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp]), nil
+		}
+		return fmt.Sprintf("%s %s(%s)", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp], column.Precision), nil
+	case "bit":
+		// BIT(1) is loaded from 0 or 1, a longer BIT from the hex of its bytes
+		if length := tidbsql.BitLength(column); length > 1 {
+			return fmt.Sprintf("%s BINARY(%d)", g.QuoteIdent(column.Name), tidbsql.BitBytes(length)), nil
+		}
+		return fmt.Sprintf("%s BOOLEAN", g.QuoteIdent(column.Name)), nil
 	case "decimal", "numeric":
-		return fmt.Sprintf("%s %s(%s, %s)", column.Name, TiDB2SnowflakeTypeMap[tp], column.Precision, column.Scale), nil
+		if err := tidbsql.CheckDecimalPrecision(column, snowflakeMaxDecimalPrecision, "Snowflake"); err != nil {
+			return "", errors.Trace(err)
+		}
+		return fmt.Sprintf("%s %s(%s, %s)", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp], column.Precision, column.Scale), nil
 	case "datetime", "timestamp", "time":
-		return fmt.Sprintf("%s %s(%s)", column.Name, TiDB2SnowflakeTypeMap[tp], column.Precision), nil
+		// the fractional seconds are the precision when the table is copied from TiDB, and the scale in the
+		// schema files
+		fsp := column.Precision
+		if fsp == "" {
+			fsp = column.Scale
+		}
+		if fsp == "" {
+			return fmt.Sprintf("%s %s", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp]), nil
+		}
+		return fmt.Sprintf("%s %s(%s)", g.QuoteIdent(column.Name), TiDB2SnowflakeTypeMap[tp], fsp), nil
 	default:
 		return "", errors.Errorf("Unsupported data type: %s", column.Tp)
 	}
@@ -0,0 +1,38 @@
+package snowsql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/errors"
+)
+
+// TableVerifier reads the tables in Snowflake for `tidb2dw verify` and the validation of the snapshot
+type TableVerifier struct {
+	db  *sql.DB
+	gen Generator
+}
+
+func NewTableVerifier(db *sql.DB, identifierCase identcase.Case) *TableVerifier {
+	return &TableVerifier{db: db, gen: NewGenerator(identifierCase)}
+}
+
+// AggregateRange returns the row count and the sums of the columns of the rows in the range
+func (v *TableVerifier) AggregateRange(targetTable string, sumColumns []validation.SumColumn, keyRange validation.KeyRange) (*validation.Aggregates, error) {
+	query := validation.AppendWhere(validation.GenAggregateQuery(v.gen.QuoteIdent(targetTable), sumColumns, "NUMBER", v.gen.QuoteIdent), keyRange.Predicate(v.gen.QuoteIdent))
+	aggregates, err := validation.QueryAggregates(context.Background(), v.db, query, sumColumns)
+	return aggregates, errors.Trace(err)
+}
+
+// ScanRange returns the values of the columns of the rows in the range as strings
+func (v *TableVerifier) ScanRange(targetTable string, columns, pkColumns []string, keyRange validation.KeyRange, limit int) ([]validation.Row, error) {
+	query := validation.GenScanQuery(v.gen.QuoteIdent(targetTable), columns, pkColumns, "VARCHAR", limit, v.gen.QuoteIdent, keyRange.Predicate(v.gen.QuoteIdent))
+	rows, err := validation.QueryRows(context.Background(), v.db, query)
+	return rows, errors.Trace(err)
+}
+
+func (v *TableVerifier) Close() {
+	v.db.Close()
+}
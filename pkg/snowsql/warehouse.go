@@ -0,0 +1,47 @@
+package snowsql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// WarehouseSuspender suspends and resumes the virtual warehouse the tables are loaded by, it implements
+// coreinterfaces.WarehouseSuspender
+type WarehouseSuspender struct {
+	db        *sql.DB
+	gen       Generator
+	warehouse string
+}
+
+func NewWarehouseSuspender(db *sql.DB, identifierCase identcase.Case, warehouse string) *WarehouseSuspender {
+	return &WarehouseSuspender{db: db, gen: NewGenerator(identifierCase), warehouse: warehouse}
+}
+
+// SuspendWarehouse suspends the warehouse, the statements running are finished before it is suspended
+func (w *WarehouseSuspender) SuspendWarehouse() error {
+	query := w.gen.GenAlterWarehouseSQL(w.warehouse, "SUSPEND")
+	_, err := w.db.Exec(query)
+	return diag.WrapSQL(err, query)
+}
+
+func (w *WarehouseSuspender) ResumeWarehouse() error {
+	query := w.gen.GenAlterWarehouseSQL(w.warehouse, "RESUME IF SUSPENDED")
+	_, err := w.db.Exec(query)
+	return diag.WrapSQL(err, query)
+}
+
+func (w *WarehouseSuspender) Close() {
+	if err := w.db.Close(); err != nil {
+		log.Warn("Failed to close the connection of the warehouse", zap.String("warehouse", w.warehouse), zap.Error(err))
+	}
+}
+
+// GenAlterWarehouseSQL returns the statement changing the state of the warehouse, e.g. SUSPEND
+func (g Generator) GenAlterWarehouseSQL(warehouse, action string) string {
+	return fmt.Sprintf("ALTER WAREHOUSE %s %s", g.QuoteIdent(warehouse), action)
+}
@@ -0,0 +1,259 @@
+package sqlaudit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// DirName is the directory of the storage path the audit log is written to, an object of each day (UTC)
+const DirName = "_tidb2dw_audit"
+
+// flushInterval is how often the audit log of the day is written into the storage
+const flushInterval = 10 * time.Second
+
+// Entry is a statement executed in the data warehouse. The arguments of the placeholders are not logged, they
+// may carry credentials.
+type Entry struct {
+	Time  time.Time `json:"time"`
+	Table string    `json:"table"`
+	// BatchID identifies the increment files loaded by the statement, see appliedbatch.ID. SchemaVersion is the
+	// table version of the files, or of the DDL applied.
+	BatchID       string `json:"batch_id,omitempty"`
+	SchemaVersion uint64 `json:"schema_version,omitempty"`
+	Statement     string `json:"statement"`
+	// RowsAffected is -1 if the data warehouse does not report it
+	RowsAffected int64   `json:"rows_affected"`
+	DurationMs   float64 `json:"duration_ms"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// Logger writes the statements executed in the data warehouse as newline-delimited JSON, the credentials in the
+// statements are masked
+type Logger struct {
+	mu     sync.Mutex
+	sink   sink
+	broken error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Scope labels the statements of a table, e.g. with the batch of increment files loaded
+type Scope struct {
+	mu            sync.Mutex
+	table         string
+	batchID       string
+	schemaVersion uint64
+}
+
+// NewScope returns the scope of the table
+func NewScope(table string) *Scope {
+	return &Scope{table: table}
+}
+
+// Set labels the statements from now on with the batch and the schema version, the batch is empty for a DDL
+func (s *Scope) Set(batchID string, schemaVersion uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batchID, s.schemaVersion = batchID, schemaVersion
+}
+
+type sink interface {
+	write(entry *Entry, line []byte) error
+	flush() error
+	close() error
+}
+
+// NewFileLogger returns a logger appending to the local file
+func NewFileLogger(path string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to open SQL audit log")
+	}
+	return &Logger{sink: &fileSink{file: file}}, nil
+}
+
+// NewStorageLogger returns a logger writing an object of each day under DirName of the storage. The object is
+// written again with the new statements every flushInterval, so at most flushInterval of the log is lost if the
+// process crashes.
+func NewStorageLogger(ctx context.Context, storage storage.ExternalStorage) *Logger {
+	l := &Logger{
+		sink: &storageSink{ctx: ctx, storage: storage},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go l.flushLoop()
+	return l
+}
+
+// Log writes the entry, the statement is trimmed and its credentials are masked. An error of writing is
+// returned by Close, the statements are not failed by the audit log.
+func (l *Logger) Log(entry Entry) {
+	entry.Statement = diag.RedactSecrets(strings.TrimSpace(entry.Statement))
+	entry.Error = diag.RedactSecrets(entry.Error)
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		l.fail(errors.Trace(err))
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sink == nil {
+		return
+	}
+	if err := l.sink.write(&entry, append(line, '\n')); err != nil {
+		l.failLocked(err)
+	}
+}
+
+// LogStatement logs the statement started at start under the scope, rowsAffected is -1 if it is unknown
+func (l *Logger) LogStatement(scope *Scope, start time.Time, statement string, rowsAffected int64, err error) {
+	scope.mu.Lock()
+	entry := Entry{
+		Time:          start,
+		Table:         scope.table,
+		BatchID:       scope.batchID,
+		SchemaVersion: scope.schemaVersion,
+		Statement:     statement,
+		RowsAffected:  rowsAffected,
+		DurationMs:    float64(time.Since(start).Microseconds()) / 1000,
+	}
+	scope.mu.Unlock()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.Log(entry)
+}
+
+func (l *Logger) flushLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.sink != nil {
+				if err := l.sink.flush(); err != nil {
+					l.failLocked(err)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (l *Logger) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failLocked(err)
+}
+
+func (l *Logger) failLocked(err error) {
+	if l.broken == nil {
+		log.Error("Failed to write SQL audit log", zap.Error(err))
+		l.broken = err
+	}
+}
+
+// Close writes the rest of the log, the first error of writing it is returned
+func (l *Logger) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sink != nil {
+		if err := l.sink.close(); err != nil && l.broken == nil {
+			l.broken = err
+		}
+		l.sink = nil
+	}
+	return l.broken
+}
+
+type fileSink struct {
+	file *os.File
+}
+
+func (s *fileSink) write(_ *Entry, line []byte) error {
+	_, err := s.file.Write(line)
+	return errors.Annotate(err, "Failed to write SQL audit log")
+}
+
+func (s *fileSink) flush() error {
+	return nil
+}
+
+func (s *fileSink) close() error {
+	return errors.Trace(s.file.Close())
+}
+
+// storageSink keeps the log of the day in memory, the storage can not append to an object
+type storageSink struct {
+	ctx     context.Context
+	storage storage.ExternalStorage
+	day     string
+	buf     bytes.Buffer
+	dirty   bool
+}
+
+// ObjectName returns the name of the object of the day under the storage path
+func ObjectName(t time.Time) string {
+	return fmt.Sprintf("%s/%s.jsonl", DirName, t.UTC().Format(time.DateOnly))
+}
+
+func (s *storageSink) write(entry *Entry, line []byte) error {
+	if day := ObjectName(entry.Time); day != s.day {
+		if err := s.flush(); err != nil {
+			return errors.Trace(err)
+		}
+		s.buf.Reset()
+		s.day = day
+		// the log of the day written before the process restarts is kept
+		exists, err := s.storage.FileExists(s.ctx, day)
+		if err != nil {
+			return errors.Annotatef(err, "Failed to check SQL audit log %s", day)
+		}
+		if exists {
+			data, err := s.storage.ReadFile(s.ctx, day)
+			if err != nil {
+				return errors.Annotatef(err, "Failed to read SQL audit log %s", day)
+			}
+			s.buf.Write(data)
+		}
+	}
+	s.buf.Write(line)
+	s.dirty = true
+	return nil
+}
+
+func (s *storageSink) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := s.storage.WriteFile(s.ctx, s.day, s.buf.Bytes()); err != nil {
+		return errors.Annotatef(err, "Failed to write SQL audit log %s", s.day)
+	}
+	s.dirty = false
+	return nil
+}
+
+func (s *storageSink) close() error {
+	return s.flush()
+}
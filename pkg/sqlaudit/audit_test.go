@@ -0,0 +1,139 @@
+package sqlaudit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/dryrun"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func readEntries(t *testing.T, data []byte) []Entry {
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var entry Entry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestWrapDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := NewFileLogger(path)
+	require.NoError(t, err)
+
+	recorder, err := dryrun.NewRecorder(filepath.Join(t.TempDir(), "dryrun.sql"))
+	require.NoError(t, err)
+	defer recorder.Close()
+	db := logger.WrapDB(recorder.OpenDB("test.t"), "test.t")
+
+	_, err = db.Exec("CREATE TABLE t (id INT)")
+	require.NoError(t, err)
+	SetScope(db, "batch1", 3)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("COPY t FROM 's3://bucket/a.csv' CREDENTIALS 'aws_access_key_id=AKIA;aws_secret_access_key=secret'")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	// the read-only queries are not logged
+	rows, err := db.Query("SELECT 1")
+	require.NoError(t, err)
+	require.NoError(t, rows.Close())
+	require.NoError(t, db.Close())
+	require.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	entries := readEntries(t, data)
+	require.Len(t, entries, 4)
+	require.Equal(t, "CREATE TABLE t (id INT)", entries[0].Statement)
+	require.Equal(t, "test.t", entries[0].Table)
+	require.Empty(t, entries[0].BatchID)
+	require.Equal(t, int64(0), entries[0].RowsAffected)
+	require.Equal(t, "BEGIN", entries[1].Statement)
+	require.Equal(t, "COPY t FROM 's3://bucket/a.csv' CREDENTIALS 'aws_access_key_id=xxxxx;aws_secret_access_key=xxxxx'", entries[2].Statement)
+	require.Equal(t, "batch1", entries[2].BatchID)
+	require.Equal(t, uint64(3), entries[2].SchemaVersion)
+	require.Equal(t, "COMMIT", entries[3].Statement)
+	require.Equal(t, int64(-1), entries[3].RowsAffected)
+}
+
+func TestWrapDBScopes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := NewFileLogger(path)
+	require.NoError(t, err)
+	recorder, err := dryrun.NewRecorder(filepath.Join(t.TempDir(), "dryrun.sql"))
+	require.NoError(t, err)
+	defer recorder.Close()
+
+	// the scope of a database is not seen by the database of another pipeline
+	db1 := logger.WrapDB(recorder.OpenDB("test.t1"), "test.t1")
+	db2 := logger.WrapDB(recorder.OpenDB("test.t2"), "test.t2")
+	SetScope(db1, "batch1", 1)
+	SetScope(db2, "batch2", 2)
+	_, err = db1.Exec("DELETE FROM t1")
+	require.NoError(t, err)
+	_, err = db2.Exec("DELETE FROM t2")
+	require.NoError(t, err)
+	// a database not returned by WrapDB has no scope
+	plain := recorder.OpenDB("test.t3")
+	SetScope(plain, "batch3", 3)
+	require.NoError(t, plain.Close())
+	require.NoError(t, db1.Close())
+	require.NoError(t, db2.Close())
+	require.NoError(t, logger.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	entries := readEntries(t, data)
+	require.Len(t, entries, 2)
+	require.Equal(t, "test.t1", entries[0].Table)
+	require.Equal(t, "batch1", entries[0].BatchID)
+	require.Equal(t, uint64(1), entries[0].SchemaVersion)
+	require.Equal(t, "test.t2", entries[1].Table)
+	require.Equal(t, "batch2", entries[1].BatchID)
+	require.Equal(t, uint64(2), entries[1].SchemaVersion)
+}
+
+func TestStorageLogger(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	day := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	scope := NewScope("test.t")
+
+	logger := NewStorageLogger(ctx, extStorage)
+	logger.LogStatement(scope, day, "DELETE FROM t", 2, nil)
+	require.NoError(t, logger.Close())
+
+	// the log of the day is appended to after a restart, the next day is written into another object
+	logger = NewStorageLogger(ctx, extStorage)
+	logger.LogStatement(scope, day.Add(30*time.Second), "INSERT INTO t VALUES (1)", 1, nil)
+	logger.LogStatement(scope, day.Add(time.Minute), "DROP TABLE t", -1, os.ErrNotExist)
+	require.NoError(t, logger.Close())
+
+	data, err := extStorage.ReadFile(ctx, "_tidb2dw_audit/2024-05-01.jsonl")
+	require.NoError(t, err)
+	entries := readEntries(t, data)
+	require.Len(t, entries, 2)
+	require.Equal(t, "DELETE FROM t", entries[0].Statement)
+	require.Equal(t, int64(2), entries[0].RowsAffected)
+	require.Equal(t, "INSERT INTO t VALUES (1)", entries[1].Statement)
+
+	data, err = extStorage.ReadFile(ctx, ObjectName(day.Add(time.Minute)))
+	require.NoError(t, err)
+	entries = readEntries(t, data)
+	require.Len(t, entries, 1)
+	require.Equal(t, "DROP TABLE t", entries[0].Statement)
+	require.Equal(t, os.ErrNotExist.Error(), entries[0].Error)
+}
@@ -0,0 +1,261 @@
+package sqlaudit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// connMaxLifetime is how long a connection of the audited database is kept, its connection of the wrapped database
+// is returned on close so that the lifetime of the wrapped database, e.g. of Snowflake OAuth, is still respected
+const connMaxLifetime = 10 * time.Minute
+
+// readOnlyPattern matches the queries which are not logged, the other queries are logged like Exec, e.g. the
+// MERGE of some drivers run by Query
+var readOnlyPattern = regexp.MustCompile(`(?is)^\s*(SELECT|SHOW|DESCRIBE|DESC|EXPLAIN|WITH)\b`)
+
+// WrapDB returns a database logging the statements executed by db under the table, closing it closes db
+func (l *Logger) WrapDB(db *sql.DB, table string) *sql.DB {
+	audited := sql.OpenDB(&connector{logger: l, db: db, scope: NewScope(table)})
+	audited.SetConnMaxLifetime(connMaxLifetime)
+	return audited
+}
+
+// SetScope sets the scope of the statements executed by db, it does nothing if db is not returned by WrapDB. The
+// scope is kept by the driver of db, so it goes away with db and is never shared with another database.
+func SetScope(db *sql.DB, batchID string, schemaVersion uint64) {
+	if d, ok := db.Driver().(auditDriver); ok {
+		d.scope.Set(batchID, schemaVersion)
+	}
+}
+
+func (l *Logger) logResult(scope *Scope, start time.Time, query string, result sql.Result, err error) {
+	rowsAffected := int64(-1)
+	if err == nil && result != nil {
+		if rows, err := result.RowsAffected(); err == nil {
+			rowsAffected = rows
+		}
+	}
+	l.LogStatement(scope, start, query, rowsAffected, err)
+}
+
+type connector struct {
+	logger *Logger
+	db     *sql.DB
+	scope  *Scope
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	sqlConn, err := c.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{connector: c, conn: sqlConn}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return auditDriver{scope: c.scope}
+}
+
+// Close is called by sql.DB.Close
+func (c *connector) Close() error {
+	return c.db.Close()
+}
+
+// auditDriver is the driver of a database returned by WrapDB, it carries the scope of the database for SetScope
+type auditDriver struct {
+	scope *Scope
+}
+
+func (auditDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("audited connections are opened by Logger.WrapDB")
+}
+
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// conn keeps a connection of the wrapped database, so that the statements depending on the session, e.g.
+// pg_last_copy_id of Redshift, share it like they do without the audit log
+type conn struct {
+	connector *connector
+	conn      *sql.Conn
+	tx        *sql.Tx
+}
+
+// target is the transaction if there is one, the connection otherwise
+func (c *conn) target() execQueryer {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.conn
+}
+
+func (c *conn) log(start time.Time, query string, result sql.Result, err error) {
+	c.connector.logger.logResult(c.connector.scope, start, query, result, err)
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		_ = c.tx.Rollback()
+		c.tx = nil
+	}
+	return c.conn.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	tx, err := c.conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.IsolationLevel(opts.Isolation), ReadOnly: opts.ReadOnly})
+	c.log(start, "BEGIN", nil, err)
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return &auditTx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	result, err := c.target().ExecContext(ctx, query, namedValues(args)...)
+	c.log(start, query, result, err)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	sqlRows, err := c.target().QueryContext(ctx, query, namedValues(args)...)
+	if err != nil || !readOnlyPattern.MatchString(query) {
+		c.log(start, query, nil, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newRows(sqlRows)
+}
+
+// CheckNamedValue passes the arguments as they are, they are converted by the driver of the wrapped database
+func (c *conn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func namedValues(args []driver.NamedValue) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			values = append(values, sql.Named(arg.Name, arg.Value))
+		} else {
+			values = append(values, arg.Value)
+		}
+	}
+	return values
+}
+
+type auditTx struct {
+	conn *conn
+}
+
+func (t *auditTx) Commit() error {
+	return t.end("COMMIT", t.conn.tx.Commit)
+}
+
+func (t *auditTx) Rollback() error {
+	return t.end("ROLLBACK", t.conn.tx.Rollback)
+}
+
+func (t *auditTx) end(statement string, end func() error) error {
+	start := time.Now()
+	err := end()
+	t.conn.tx = nil
+	t.conn.log(start, statement, nil, err)
+	return err
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, ordinalValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, ordinalValues(args))
+}
+
+func ordinalValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		named = append(named, driver.NamedValue{Ordinal: i + 1, Value: arg})
+	}
+	return named
+}
+
+// rows passes the rows of the wrapped database
+type rows struct {
+	rows    *sql.Rows
+	columns []string
+}
+
+func newRows(sqlRows *sql.Rows) (*rows, error) {
+	columns, err := sqlRows.Columns()
+	if err != nil {
+		sqlRows.Close()
+		return nil, err
+	}
+	return &rows{rows: sqlRows, columns: columns}, nil
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	values := make([]any, len(dest))
+	ptrs := make([]any, len(dest))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := r.rows.Scan(ptrs...); err != nil {
+		return err
+	}
+	for i, v := range values {
+		dest[i] = v
+	}
+	return nil
+}
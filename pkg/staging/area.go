@@ -0,0 +1,185 @@
+package staging
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// filePrefix is the prefix of the files downloaded into the area, the files of the prefix left in the directory
+// are removed when the area is created
+const filePrefix = "tidb2dw-staging-"
+
+// Area is a local directory the files of the storage are downloaded into before they are loaded, for the data
+// warehouses which can not read the storage. The bytes of the files downloaded and not released yet are bounded by
+// maxBytes, a download waits until enough files are released.
+type Area struct {
+	dir      string
+	maxBytes int64
+	local    storage.ExternalStorage
+
+	mu   sync.Mutex
+	used int64
+	// released is closed and replaced whenever a file is released, waking up the downloads waiting for the budget
+	released chan struct{}
+}
+
+// NewArea creates the directory if it does not exist and removes the files left in it by a previous run
+func NewArea(dir string, maxBytes int64) (*Area, error) {
+	if maxBytes <= 0 {
+		return nil, errors.Errorf("Invalid max staging bytes %d, it must be positive", maxBytes)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Annotatef(err, "Failed to create staging directory %s", dir)
+	}
+	if err := removeOrphans(dir); err != nil {
+		return nil, errors.Trace(err)
+	}
+	local, err := storage.NewLocalStorage(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	metrics.StagingBytes.Set(0)
+	metrics.StagingFiles.Set(0)
+	return &Area{dir: dir, maxBytes: maxBytes, local: local, released: make(chan struct{})}, nil
+}
+
+func removeOrphans(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to list staging directory %s", dir)
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), filePrefix) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return errors.Annotatef(err, "Failed to remove orphaned staging file %s", entry.Name())
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Info("Removed orphaned staging files", zap.String("dir", dir), zap.Int("files", removed))
+	}
+	return nil
+}
+
+// Used returns the bytes of the files downloaded and not released yet
+func (a *Area) Used() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.used
+}
+
+// Download copies the file of the storage into the area as it is, compressed or not, waiting until the file fits in
+// the budget. A file larger than the whole budget can never be downloaded and fails immediately.
+// The file must be released once it is loaded.
+func (a *Area) Download(ctx context.Context, extStorage storage.ExternalStorage, path string) (*File, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, diag.Storage(errors.Annotatef(err, "Failed to open %s", path))
+	}
+	defer reader.Close()
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = reader.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, diag.Storage(errors.Annotatef(err, "Failed to get the size of %s", path))
+	}
+	if size > a.maxBytes {
+		return nil, diag.Storage(errors.Errorf("%s is %d bytes, larger than the max staging bytes %d, raise --max-staging-bytes to load it", path, size, a.maxBytes))
+	}
+	if err = a.acquire(ctx, size); err != nil {
+		return nil, errors.Trace(err)
+	}
+	file := &File{area: a, size: size}
+	if err = file.download(reader); err != nil {
+		file.Release()
+		return nil, diag.Storage(errors.Annotatef(err, "Failed to download %s", path))
+	}
+	return file, nil
+}
+
+func (a *Area) acquire(ctx context.Context, size int64) error {
+	for {
+		a.mu.Lock()
+		if a.used+size <= a.maxBytes {
+			a.used += size
+			metrics.StagingBytes.Set(float64(a.used))
+			metrics.StagingFiles.Inc()
+			a.mu.Unlock()
+			return nil
+		}
+		released := a.released
+		a.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (a *Area) release(size int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.used -= size
+	metrics.StagingBytes.Set(float64(a.used))
+	metrics.StagingFiles.Dec()
+	close(a.released)
+	a.released = make(chan struct{})
+}
+
+// File is a file downloaded into the area
+type File struct {
+	area *Area
+	// name is the name of the file in the area, empty until it is created
+	name     string
+	size     int64
+	released bool
+}
+
+func (f *File) download(reader io.Reader) error {
+	local, err := os.CreateTemp(f.area.dir, filePrefix+"*")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	f.name = filepath.Base(local.Name())
+	if _, err = io.Copy(local, reader); err != nil {
+		local.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(local.Close())
+}
+
+// Open opens the file downloaded, decompressed by the compression of the storage it is downloaded from
+func (f *File) Open(ctx context.Context, compressType storage.CompressType) (io.ReadCloser, error) {
+	reader, err := storage.WithCompression(f.area.local, compressType).Open(ctx, f.name)
+	return reader, errors.Trace(err)
+}
+
+// Release removes the file and returns its bytes to the budget of the area, releasing it again is a no-op
+func (f *File) Release() {
+	if f.released {
+		return
+	}
+	f.released = true
+	if f.name != "" {
+		if err := os.Remove(filepath.Join(f.area.dir, f.name)); err != nil {
+			log.Warn("Failed to remove staging file", zap.String("file", f.name), zap.Error(err))
+		}
+	}
+	f.area.release(f.size)
+}
@@ -0,0 +1,119 @@
+package staging
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func newSource(t *testing.T, files map[string]string) storage.ExternalStorage {
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	source, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	return source
+}
+
+func TestNewAreaRemovesOrphans(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, filePrefix+"1"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other"), []byte("b"), 0o644))
+	_, err := NewArea(dir, 10)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "other", entries[0].Name())
+
+	_, err = NewArea(dir, 0)
+	require.Error(t, err)
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	area, err := NewArea(t.TempDir(), 10)
+	require.NoError(t, err)
+	source := newSource(t, map[string]string{"a.csv": "hello", "big.csv": "hello world"})
+
+	file, err := area.Download(ctx, source, "a.csv")
+	require.NoError(t, err)
+	require.EqualValues(t, 5, area.Used())
+	reader, err := file.Open(ctx, storage.NoCompression)
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, "hello", string(content))
+
+	file.Release()
+	file.Release()
+	require.EqualValues(t, 0, area.Used())
+	entries, err := os.ReadDir(area.dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = area.Download(ctx, source, "big.csv")
+	require.ErrorContains(t, err, "raise --max-staging-bytes")
+}
+
+func TestDownloadWaitsForBudget(t *testing.T) {
+	ctx := context.Background()
+	area, err := NewArea(t.TempDir(), 8)
+	require.NoError(t, err)
+	source := newSource(t, map[string]string{"a.csv": "hello", "b.csv": "world"})
+
+	first, err := area.Download(ctx, source, "a.csv")
+	require.NoError(t, err)
+
+	// the budget is used up, the download waits until the context is canceled
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = area.Download(timeoutCtx, source, "b.csv")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the download resumes once the first file is released
+	downloaded := make(chan *File)
+	go func() {
+		file, err := area.Download(ctx, source, "b.csv")
+		require.NoError(t, err)
+		downloaded <- file
+	}()
+	select {
+	case <-downloaded:
+		t.Fatal("the download must wait for the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Release()
+	second := <-downloaded
+	require.EqualValues(t, 5, area.Used())
+	second.Release()
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	ctx := context.Background()
+	source := newSource(t, nil)
+	content := "1,alice\n2,bob\n"
+	require.NoError(t, storage.WithCompression(source, storage.Gzip).WriteFile(ctx, "a.csv.gz", []byte(content)))
+	area, err := NewArea(t.TempDir(), 1024)
+	require.NoError(t, err)
+
+	// the file is downloaded as it is and decompressed when it is read
+	file, err := area.Download(ctx, source, "a.csv.gz")
+	require.NoError(t, err)
+	defer file.Release()
+	require.Less(t, area.Used(), int64(1024))
+	reader, err := file.Open(ctx, storage.Gzip)
+	require.NoError(t, err)
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, content, string(decompressed))
+}
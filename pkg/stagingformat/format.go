@@ -0,0 +1,48 @@
+package stagingformat
+
+import (
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// Format is the format of the snapshot and increment files loaded into the data warehouse
+type Format string
+
+const (
+	// CSV loads the CSV files written by dumpling and TiCDC as they are
+	CSV Format = "csv"
+	// Parquet converts the CSV files into Parquet files before they are loaded, see Converter
+	Parquet Format = "parquet"
+)
+
+// ParquetFileExtension is the extension of the Parquet files, which are never compressed as a whole
+const ParquetFileExtension = ".parquet"
+
+// ParseFormat parses the name of the staging format, an empty name is CSV
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "csv":
+		return CSV, nil
+	case "parquet":
+		return Parquet, nil
+	default:
+		return CSV, errors.Errorf("unknown staging format %s, expected one of csv, parquet", s)
+	}
+}
+
+// FileFormat returns the format of the file loaded by its extension, the connectors load the files of both formats
+// so that the files staged before the format is changed are still loaded
+func FileFormat(path string) Format {
+	if strings.HasSuffix(path, ParquetFileExtension) {
+		return Parquet
+	}
+	return CSV
+}
+
+// ParquetPath returns the path of the Parquet file converted from the CSV file compressed by compression, which is
+// next to the CSV file
+func ParquetPath(csvPath string, compression utils.Compression) string {
+	return strings.TrimSuffix(csvPath, compression.CSVFileExtension()) + ParquetFileExtension
+}
@@ -0,0 +1,25 @@
+package stagingformat
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	for s, expected := range map[string]Format{"": CSV, "csv": CSV, "Parquet": Parquet} {
+		format, err := ParseFormat(s)
+		require.NoError(t, err)
+		require.Equal(t, expected, format)
+	}
+	_, err := ParseFormat("orc")
+	require.ErrorContains(t, err, "unknown staging format orc")
+}
+
+func TestParquetPath(t *testing.T) {
+	require.Equal(t, "db/t/400/CDC000001.parquet", ParquetPath("db/t/400/CDC000001.csv", utils.CompressionNone))
+	require.Equal(t, "db.t.000000000.parquet", ParquetPath("db.t.000000000.csv.gz", utils.CompressionGzip))
+	require.Equal(t, Parquet, FileFormat("db.t.000000000.parquet"))
+	require.Equal(t, CSV, FileFormat("db.t.000000000.csv.gz"))
+}
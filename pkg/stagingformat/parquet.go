@@ -0,0 +1,437 @@
+package stagingformat
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/xitongsys/parquet-go/marshal"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/schema"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// rowGroupSize bounds the rows buffered in memory by a conversion, the files of several tables are converted
+// concurrently
+const rowGroupSize = 32 * 1024 * 1024
+
+// Converter converts the CSV files of dumpling and TiCDC into Parquet files, the columns are typed by their TiDB
+// types:
+//
+//   - the integer types are INT32 or INT64 of their width, BIGINT UNSIGNED is DECIMAL(20, 0)
+//   - FLOAT and DOUBLE are FLOAT and DOUBLE, DECIMAL is DECIMAL of its precision and scale
+//   - DATE is DATE, DATETIME is TIMESTAMP in microseconds not adjusted to UTC, TIMESTAMP is TIMESTAMP in
+//     microseconds adjusted to UTC from the time zone the values are written in
+//   - BINARY, VARBINARY and the BLOB types are BYTE_ARRAY of their bytes
+//   - BIT(1) is BOOLEAN, a longer BIT is BYTE_ARRAY of its bytes
+//   - the other types, e.g. TIME, JSON, ENUM and the strings, are UTF8 strings as in the CSV files
+type Converter struct {
+	// location is the time zone the TIMESTAMP values are written in by dumpling and TiCDC
+	location *time.Location
+}
+
+// NewConverter returns the converter of the files whose TIMESTAMP values are written in the time zone, which is the
+// --tz of TiDB and TiCDC. The time zone must be set, the time zone of TiDB is unknown.
+func NewConverter(timeZone string) (*Converter, error) {
+	if timeZone == "" {
+		return nil, errors.New("--staging-format=parquet requires --tz, the TIMESTAMP values are converted from the time zone they are written in")
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Converter{location: location}, nil
+}
+
+// parquetColumn is a column of the Parquet files, encode converts a field of the CSV files to its value
+type parquetColumn struct {
+	element *parquet.SchemaElement
+	encode  func(field []byte) (any, error)
+}
+
+// columnOf returns how the column is written. increment is true for the files of TiCDC, whose binary values are
+// encoded by base64 and BIT values are unsigned integers, while dumpling writes the bytes and the hex.
+func (c *Converter) columnOf(column cloudstorage.TableCol, increment bool) parquetColumn {
+	tp := strings.ToLower(column.Tp)
+	baseTp, unsigned := strings.CutSuffix(tp, " unsigned")
+	switch {
+	case baseTp == "tinyint" && !unsigned:
+		return intColumn(parquet.Type_INT32, parquet.ConvertedType_INT_8, 8)
+	case baseTp == "smallint" && !unsigned, baseTp == "tinyint":
+		return intColumn(parquet.Type_INT32, parquet.ConvertedType_INT_16, 16)
+	case baseTp == "int" && !unsigned, baseTp == "mediumint", baseTp == "smallint":
+		return intColumn(parquet.Type_INT32, parquet.ConvertedType_INT_32, 32)
+	case baseTp == "year":
+		return intColumn(parquet.Type_INT32, parquet.ConvertedType_INT_16, 16)
+	case baseTp == "bigint" && !unsigned, baseTp == "int":
+		return intColumn(parquet.Type_INT64, parquet.ConvertedType_INT_64, 64)
+	case baseTp == "bigint":
+		return decimalColumn(20, 0)
+	case baseTp == "decimal", baseTp == "numeric":
+		precision, err := strconv.Atoi(column.Precision)
+		if err != nil {
+			// the precision and scale of DECIMAL without them
+			precision = 10
+		}
+		scale, _ := strconv.Atoi(column.Scale)
+		return decimalColumn(precision, scale)
+	case baseTp == "float":
+		return parquetColumn{
+			element: &parquet.SchemaElement{Type: parquet.TypePtr(parquet.Type_FLOAT)},
+			encode: func(field []byte) (any, error) {
+				value, err := strconv.ParseFloat(string(field), 32)
+				return float32(value), err
+			},
+		}
+	case baseTp == "double":
+		return parquetColumn{
+			element: &parquet.SchemaElement{Type: parquet.TypePtr(parquet.Type_DOUBLE)},
+			encode: func(field []byte) (any, error) {
+				return strconv.ParseFloat(string(field), 64)
+			},
+		}
+	case baseTp == "date":
+		return parquetColumn{
+			element: &parquet.SchemaElement{
+				Type:          parquet.TypePtr(parquet.Type_INT32),
+				ConvertedType: parquet.ConvertedTypePtr(parquet.ConvertedType_DATE),
+				LogicalType:   &parquet.LogicalType{DATE: parquet.NewDateType()},
+			},
+			encode: func(field []byte) (any, error) {
+				date, err := time.Parse(time.DateOnly, string(field))
+				if err != nil {
+					return nil, err
+				}
+				return int32(date.Unix() / (24 * 60 * 60)), nil
+			},
+		}
+	case baseTp == "datetime":
+		// a DATETIME is a wall clock, which has no converted type
+		return timestampColumn(time.UTC, false)
+	case baseTp == "timestamp":
+		return timestampColumn(c.location, true)
+	case baseTp == "binary", baseTp == "varbinary", strings.HasSuffix(baseTp, "blob"):
+		return parquetColumn{
+			element: &parquet.SchemaElement{Type: parquet.TypePtr(parquet.Type_BYTE_ARRAY)},
+			encode: func(field []byte) (any, error) {
+				if !increment {
+					return string(field), nil
+				}
+				value, err := base64.StdEncoding.DecodeString(string(field))
+				return string(value), err
+			},
+		}
+	case baseTp == "bit" && tidbsql.BitLength(column) == 1:
+		return parquetColumn{
+			element: &parquet.SchemaElement{Type: parquet.TypePtr(parquet.Type_BOOLEAN)},
+			encode: func(field []byte) (any, error) {
+				return strconv.ParseBool(string(field))
+			},
+		}
+	case baseTp == "bit":
+		length := tidbsql.BitLength(column)
+		return parquetColumn{
+			element: &parquet.SchemaElement{Type: parquet.TypePtr(parquet.Type_BYTE_ARRAY)},
+			encode: func(field []byte) (any, error) {
+				value, err := decodeBit(string(field), length, increment)
+				return string(value), err
+			},
+		}
+	default:
+		return parquetColumn{
+			element: &parquet.SchemaElement{
+				Type:          parquet.TypePtr(parquet.Type_BYTE_ARRAY),
+				ConvertedType: parquet.ConvertedTypePtr(parquet.ConvertedType_UTF8),
+				LogicalType:   &parquet.LogicalType{STRING: parquet.NewStringType()},
+			},
+			encode: func(field []byte) (any, error) {
+				return string(field), nil
+			},
+		}
+	}
+}
+
+func intColumn(tp parquet.Type, convertedType parquet.ConvertedType, bitWidth int) parquetColumn {
+	return parquetColumn{
+		element: &parquet.SchemaElement{
+			Type:          parquet.TypePtr(tp),
+			ConvertedType: parquet.ConvertedTypePtr(convertedType),
+			LogicalType:   &parquet.LogicalType{INTEGER: &parquet.IntType{BitWidth: int8(bitWidth), IsSigned: true}},
+		},
+		encode: func(field []byte) (any, error) {
+			value, err := strconv.ParseInt(string(field), 10, bitWidth)
+			if tp == parquet.Type_INT32 {
+				return int32(value), err
+			}
+			return value, err
+		},
+	}
+}
+
+func decimalColumn(precision, scale int) parquetColumn {
+	return parquetColumn{
+		element: &parquet.SchemaElement{
+			Type:          parquet.TypePtr(parquet.Type_BYTE_ARRAY),
+			ConvertedType: parquet.ConvertedTypePtr(parquet.ConvertedType_DECIMAL),
+			LogicalType:   &parquet.LogicalType{DECIMAL: &parquet.DecimalType{Precision: int32(precision), Scale: int32(scale)}},
+			Precision:     int32Ptr(int32(precision)),
+			Scale:         int32Ptr(int32(scale)),
+		},
+		encode: func(field []byte) (any, error) {
+			value, err := encodeDecimal(string(field), scale)
+			return string(value), err
+		},
+	}
+}
+
+// timestampColumn returns the column of the times in microseconds, which are parsed in the location
+func timestampColumn(location *time.Location, adjustedToUTC bool) parquetColumn {
+	element := &parquet.SchemaElement{
+		Type: parquet.TypePtr(parquet.Type_INT64),
+		LogicalType: &parquet.LogicalType{TIMESTAMP: &parquet.TimestampType{
+			IsAdjustedToUTC: adjustedToUTC,
+			Unit:            &parquet.TimeUnit{MICROS: parquet.NewMicroSeconds()},
+		}},
+	}
+	if adjustedToUTC {
+		element.ConvertedType = parquet.ConvertedTypePtr(parquet.ConvertedType_TIMESTAMP_MICROS)
+	}
+	return parquetColumn{
+		element: element,
+		encode: func(field []byte) (any, error) {
+			value, err := time.ParseInLocation("2006-01-02 15:04:05.999999", string(field), location)
+			if err != nil {
+				return nil, err
+			}
+			return value.UnixMicro(), nil
+		},
+	}
+}
+
+// encodeDecimal returns the unscaled value of the decimal as a big-endian two's complement integer
+func encodeDecimal(value string, scale int) ([]byte, error) {
+	digits, negative := strings.CutPrefix(value, "-")
+	integer, fraction, _ := strings.Cut(digits, ".")
+	if len(fraction) > scale {
+		return nil, errors.Errorf("decimal %s has more than %d digits after the decimal point", value, scale)
+	}
+	unscaled, ok := new(big.Int).SetString(integer+fraction+strings.Repeat("0", scale-len(fraction)), 10)
+	if !ok {
+		return nil, errors.Errorf("invalid decimal %s", value)
+	}
+	if negative {
+		unscaled.Neg(unscaled)
+	}
+	// the bytes have room for the sign bit, a negative value is offset by 2^(8*size)
+	magnitude := unscaled
+	if unscaled.Sign() < 0 {
+		magnitude = new(big.Int).Not(unscaled)
+	}
+	size := magnitude.BitLen()/8 + 1
+	if unscaled.Sign() < 0 {
+		unscaled.Add(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*size)))
+	}
+	return unscaled.FillBytes(make([]byte, size)), nil
+}
+
+// decodeBit returns the bytes of a BIT value of length bits, dumpling writes the hex of the bytes while TiCDC
+// writes the unsigned integer
+func decodeBit(value string, length int, increment bool) ([]byte, error) {
+	if !increment {
+		return hex.DecodeString(value)
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	buf := binary.BigEndian.AppendUint64(nil, n)
+	return buf[len(buf)-tidbsql.BitBytes(length):], nil
+}
+
+// schemaHandler returns the schema of the Parquet files of the columns, whose fields are named by the columns. The
+// names of the columns may be any string, so they are named by their positions internally.
+func schemaHandler(columns []cloudstorage.TableCol, parquetColumns []parquetColumn) *schema.SchemaHandler {
+	elements := make([]*parquet.SchemaElement, 0, len(columns)+1)
+	elements = append(elements, &parquet.SchemaElement{
+		Name:           "Schema",
+		RepetitionType: parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_REQUIRED),
+		NumChildren:    int32Ptr(int32(len(columns))),
+	})
+	for i, column := range parquetColumns {
+		element := *column.element
+		element.Name = fmt.Sprintf("C%d", i+1)
+		element.RepetitionType = parquet.FieldRepetitionTypePtr(parquet.FieldRepetitionType_OPTIONAL)
+		elements = append(elements, &element)
+	}
+	handler := schema.NewSchemaHandlerFromSchemaList(elements)
+	// the fields are written by their internal names and renamed to the columns in the footer
+	for i, column := range columns {
+		handler.Infos[i+1].ExName = column.Name
+	}
+	handler.CreateInExMap()
+	return handler
+}
+
+// Convert converts the CSV file compressed by compression into the Parquet file next to it, see ParquetPath. The
+// columns are the columns of the file, e.g. utils.GenIncrementTableColumns of the table for the files of TiCDC, and
+// increment is true for the files of TiCDC. It returns the path and the size of the Parquet file.
+func (c *Converter) Convert(ctx context.Context, extStorage storage.ExternalStorage, csvPath string, columns []cloudstorage.TableCol, compression utils.Compression, increment bool) (string, int64, error) {
+	parquetColumns := make([]parquetColumn, 0, len(columns))
+	for _, column := range columns {
+		parquetColumns = append(parquetColumns, c.columnOf(column, increment))
+	}
+	compressed := extStorage
+	if compression.CompressType() != storage.NoCompression {
+		compressed = storage.WithCompression(extStorage, compression.CompressType())
+	}
+	csvReader, err := compressed.Open(ctx, csvPath)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	defer csvReader.Close()
+	parquetPath := ParquetPath(csvPath, compression)
+	fileWriter, err := extStorage.Create(ctx, parquetPath)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	counter := &writerFile{ctx: ctx, w: fileWriter}
+	err = writeParquet(utils.NewCSVReader(csvReader), counter, columns, parquetColumns)
+	if closeErr := fileWriter.Close(ctx); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, errors.Annotatef(err, "Failed to convert %s into Parquet", csvPath)
+	}
+	return parquetPath, counter.size, nil
+}
+
+func writeParquet(csvReader *utils.CSVReader, w source.ParquetFile, columns []cloudstorage.TableCol, parquetColumns []parquetColumn) error {
+	pw, err := writer.NewParquetWriter(w, nil, 1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pw.SchemaHandler = schemaHandler(columns, parquetColumns)
+	pw.Footer.Schema = pw.SchemaHandler.SchemaElements
+	pw.MarshalFunc = marshal.MarshalCSV
+	pw.RowGroupSize = rowGroupSize
+	for row := 1; ; row++ {
+		fields, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(fields) != len(columns) {
+			return errors.Errorf("%d fields in row %d, expected %d columns", len(fields), row, len(columns))
+		}
+		values := make([]any, len(fields))
+		for i, field := range fields {
+			if field.Null {
+				continue
+			}
+			if values[i], err = parquetColumns[i].encode(field.Value); err != nil {
+				return errors.Annotatef(err, "Failed to convert column %s of row %d", columns[i].Name, row)
+			}
+		}
+		if err = pw.Write(values); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(pw.WriteStop())
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
+
+// writerFile adapts storage.ExternalFileWriter to source.ParquetFile to write the file sequentially, and counts the
+// bytes written. The writer is closed by the caller.
+type writerFile struct {
+	ctx  context.Context
+	w    storage.ExternalFileWriter
+	size int64
+}
+
+func (f *writerFile) Write(p []byte) (int, error) {
+	n, err := f.w.Write(f.ctx, p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *writerFile) Read([]byte) (int, error) {
+	return 0, errors.New("the Parquet file is write only")
+}
+
+func (f *writerFile) Seek(int64, int) (int64, error) {
+	return 0, errors.New("the Parquet file is written sequentially")
+}
+
+func (f *writerFile) Close() error {
+	return nil
+}
+
+func (f *writerFile) Open(string) (source.ParquetFile, error) {
+	return nil, errors.New("the Parquet file is write only")
+}
+
+func (f *writerFile) Create(string) (source.ParquetFile, error) {
+	return nil, errors.New("the Parquet file can not be created again")
+}
+
+// CountRows returns the number of rows of the Parquet file by its footer
+func CountRows(ctx context.Context, extStorage storage.ExternalStorage, path string) (int64, error) {
+	file, err := openReaderFile(ctx, extStorage, path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer file.Close()
+	pr := &reader.ParquetReader{PFile: file}
+	if err = pr.ReadFooter(); err != nil {
+		return 0, errors.Annotatef(err, "Failed to read the footer of %s", path)
+	}
+	return pr.Footer.NumRows, nil
+}
+
+// readerFile adapts storage.ExternalFileReader to source.ParquetFile, the file is opened again by each reader of
+// its columns
+type readerFile struct {
+	storage.ExternalFileReader
+	ctx        context.Context
+	extStorage storage.ExternalStorage
+	path       string
+}
+
+func openReaderFile(ctx context.Context, extStorage storage.ExternalStorage, path string) (*readerFile, error) {
+	fileReader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &readerFile{ExternalFileReader: fileReader, ctx: ctx, extStorage: extStorage, path: path}, nil
+}
+
+func (f *readerFile) Write([]byte) (int, error) {
+	return 0, errors.New("the Parquet file is read only")
+}
+
+func (f *readerFile) Open(string) (source.ParquetFile, error) {
+	return openReaderFile(f.ctx, f.extStorage, f.path)
+}
+
+func (f *readerFile) Create(string) (source.ParquetFile, error) {
+	return nil, errors.New("the Parquet file is read only")
+}
@@ -0,0 +1,140 @@
+package stagingformat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var testColumns = []cloudstorage.TableCol{
+	{Name: "id", Tp: "bigint"},
+	{Name: "small", Tp: "tinyint unsigned"},
+	{Name: "big", Tp: "bigint unsigned"},
+	{Name: "price", Tp: "decimal", Precision: "10", Scale: "2"},
+	{Name: "ratio", Tp: "double"},
+	{Name: "birthday", Tp: "date"},
+	{Name: "created at", Tp: "datetime"},
+	{Name: "updated_at", Tp: "timestamp"},
+	{Name: "data", Tp: "varbinary"},
+	{Name: "enabled", Tp: "bit", Precision: "1"},
+	{Name: "mask", Tp: "bit", Precision: "12"},
+	{Name: "note", Tp: "text"},
+}
+
+// readColumns reads the footer and the values of the columns of the Parquet file
+func readColumns(t *testing.T, extStorage storage.ExternalStorage, path string) (*parquet.FileMetaData, [][]any) {
+	file, err := openReaderFile(context.Background(), extStorage, path)
+	require.NoError(t, err)
+	defer file.Close()
+	// the reader renames the fields in its footer
+	footerReader := &reader.ParquetReader{PFile: file}
+	require.NoError(t, footerReader.ReadFooter())
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	var columns [][]any
+	for i := range pr.SchemaHandler.ValueColumns {
+		values, _, _, err := pr.ReadColumnByIndex(int64(i), pr.GetNumRows())
+		require.NoError(t, err)
+		columns = append(columns, values)
+	}
+	return footerReader.Footer, columns
+}
+
+func TestConvert(t *testing.T) {
+	ctx := context.Background()
+	converter, err := NewConverter("Asia/Shanghai")
+	require.NoError(t, err)
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	compressed := storage.WithCompression(extStorage, utils.CompressionGzip.CompressType())
+
+	// a snapshot file of dumpling has the raw bytes of binary values and the hex of BIT values
+	snapshot := "1,255,18446744073709551615,-12.5,0.25,1970-01-02,2024-01-01 08:00:00.5,2024-01-01 08:00:00,\"a\\\"b\",1,0ABC,\"x,y\"\n" +
+		"2,\\N,\\N,\\N,\\N,\\N,\\N,\\N,\\N,\\N,\\N,\\N\n"
+	require.NoError(t, compressed.WriteFile(ctx, "db.t.000000000.csv.gz", []byte(snapshot)))
+	path, size, err := converter.Convert(ctx, extStorage, "db.t.000000000.csv.gz", testColumns, utils.CompressionGzip, false)
+	require.NoError(t, err)
+	require.Equal(t, "db.t.000000000.parquet", path)
+	raw, err := extStorage.ReadFile(ctx, path)
+	require.NoError(t, err)
+	require.EqualValues(t, len(raw), size)
+	rows, err := CountRows(ctx, extStorage, path)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, rows)
+
+	footer, values := readColumns(t, extStorage, path)
+	names := make([]string, 0, len(footer.Schema)-1)
+	for _, element := range footer.Schema[1:] {
+		names = append(names, element.Name)
+	}
+	require.Equal(t, []string{"id", "small", "big", "price", "ratio", "birthday", "created at", "updated_at", "data", "enabled", "mask", "note"}, names)
+	require.Equal(t, parquet.ConvertedType_DECIMAL, footer.Schema[3].GetConvertedType())
+	require.EqualValues(t, 20, footer.Schema[3].GetPrecision())
+	require.False(t, footer.Schema[7].LogicalType.TIMESTAMP.IsAdjustedToUTC)
+	require.True(t, footer.Schema[8].LogicalType.TIMESTAMP.IsAdjustedToUTC)
+	require.Equal(t, [][]any{
+		{int64(1), int64(2)},
+		{int32(255), nil},
+		{string([]byte{0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}), nil},
+		{string([]byte{0xfb, 0x1e}), nil},
+		{0.25, nil},
+		{int32(1), nil},
+		{int64(1704096000500000), nil},
+		{int64(1704067200000000), nil},
+		{`a"b`, nil},
+		{true, nil},
+		{string([]byte{0x0a, 0xbc}), nil},
+		{"x,y", nil},
+	}, values)
+
+	// an increment file of TiCDC has the base64 of binary values and the unsigned integer of BIT values
+	columns := utils.GenIncrementTableColumns([]cloudstorage.TableCol{testColumns[0], testColumns[8], testColumns[10]})
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/400/CDC000001.csv", []byte("I,t,db,401,1,aGk=,2748\r\nD,t,db,402,1,\\N,\\N\r\n")))
+	path, _, err = converter.Convert(ctx, extStorage, "db/t/400/CDC000001.csv", columns, utils.CompressionNone, true)
+	require.NoError(t, err)
+	_, values = readColumns(t, extStorage, path)
+	require.Equal(t, [][]any{
+		{"I", "D"},
+		{"t", "t"},
+		{"db", "db"},
+		{int64(401), int64(402)},
+		{int64(1), int64(1)},
+		{"hi", nil},
+		{string([]byte{0x0a, 0xbc}), nil},
+	}, values)
+
+	// a value failing to convert fails the file
+	require.NoError(t, extStorage.WriteFile(ctx, "bad.csv", []byte("1,x\n")))
+	_, _, err = converter.Convert(ctx, extStorage, "bad.csv", testColumns[:2], utils.CompressionNone, false)
+	require.ErrorContains(t, err, "Failed to convert column small of row 1")
+	_, _, err = converter.Convert(ctx, extStorage, "bad.csv", testColumns[:3], utils.CompressionNone, false)
+	require.ErrorContains(t, err, "2 fields in row 1, expected 3 columns")
+
+	_, err = NewConverter("")
+	require.ErrorContains(t, err, "--staging-format=parquet requires --tz")
+}
+
+func TestEncodeDecimal(t *testing.T) {
+	for value, expected := range map[string][]byte{
+		"0":       {0},
+		"1.5":     {0, 150},
+		"-1.5":    {0xff, 0x6a},
+		"-1.28":   {0x80},
+		"1.28":    {0, 0x80},
+		"-0.01":   {0xff},
+		"1270.00": {0x01, 0xf0, 0x18},
+	} {
+		encoded, err := encodeDecimal(value, 2)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, encoded, value)
+	}
+	_, err := encodeDecimal("1.234", 2)
+	require.ErrorContains(t, err, "more than 2 digits after the decimal point")
+}
@@ -0,0 +1,119 @@
+package tablelayout
+
+import (
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Layout is the columns partitioning and clustering a table created in the data warehouse, the zero Layout
+// creates the table without partitioning or clustering. A partitioning column may be followed by the parameters
+// of the partitioning separated by colons, e.g. id:0:1000000:1000 of an integer range of BigQuery.
+type Layout struct {
+	// PartitionBy are the columns partitioning the table, e.g. by --bq.partition-by and --databricks.partition-by
+	PartitionBy []string
+	// ClusterBy are the columns clustering the table, e.g. by --bq.cluster-by and --snowflake.cluster-by
+	ClusterBy []string
+}
+
+// ColumnName returns the name of the column of a partitioning column with its parameters
+func ColumnName(column string) string {
+	name, _, _ := strings.Cut(column, ":")
+	return name
+}
+
+// Lookup returns the columns of the table by their names, the names are case-insensitive as in TiDB.
+// A name not of a column of the table fails, as the table could not be created.
+func Lookup(names []string, columns []cloudstorage.TableCol) ([]cloudstorage.TableCol, error) {
+	found := make([]cloudstorage.TableCol, 0, len(names))
+	for _, name := range names {
+		name = ColumnName(name)
+		i := -1
+		for j, column := range columns {
+			if strings.EqualFold(column.Name, name) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, errors.Errorf("Column %s partitioning or clustering the table is not a column of the table", name)
+		}
+		found = append(found, columns[i])
+	}
+	return found, nil
+}
+
+// Contains returns whether the column partitions or clusters the table
+func (l Layout) Contains(column string) bool {
+	for _, names := range [][]string{l.PartitionBy, l.ClusterBy} {
+		for _, name := range names {
+			if strings.EqualFold(ColumnName(name), column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckDropColumn returns an error if the column dropped partitions or clusters the table, the data warehouse
+// refuses to drop it
+func (l Layout) CheckDropColumn(column string) error {
+	if l.Contains(column) {
+		return diag.Schema(tidbsql.NewUnsupportedDDLError("Can not drop column %s which partitions or clusters the table, "+
+			"recreate the table in the data warehouse without it and restart the program", column))
+	}
+	return nil
+}
+
+// Layouts are the layouts of the tables by the table full qualified name
+type Layouts map[string]Layout
+
+// Table returns the layout of the table, the zero Layout if the table is neither partitioned nor clustered
+func (l Layouts) Table(tableFQN string) Layout {
+	return l[tableFQN]
+}
+
+// NewLayouts returns the layouts of the tables by their partitioning and clustering columns, either may be nil
+func NewLayouts(partitionBy, clusterBy map[string][]string) Layouts {
+	layouts := make(Layouts, len(partitionBy)+len(clusterBy))
+	for tableFQN, columns := range partitionBy {
+		layout := layouts[tableFQN]
+		layout.PartitionBy = columns
+		layouts[tableFQN] = layout
+	}
+	for tableFQN, columns := range clusterBy {
+		layout := layouts[tableFQN]
+		layout.ClusterBy = columns
+		layouts[tableFQN] = layout
+	}
+	return layouts
+}
+
+// ParseColumns parses the values of the flag, each of which is <db>.<table>=<column>[,<column>...], into the columns
+// by the table full qualified name
+func ParseColumns(flag string, values []string) (map[string][]string, error) {
+	tables := make(map[string][]string, len(values))
+	for _, value := range values {
+		tableFQN, list, ok := strings.Cut(value, "=")
+		tableFQN = strings.TrimSpace(tableFQN)
+		if !ok || strings.Count(tableFQN, ".") != 1 {
+			return nil, errors.Errorf("invalid --%s %s, expect <db>.<table>=<column>[,<column>...]", flag, value)
+		}
+		if _, ok := tables[tableFQN]; ok {
+			return nil, errors.Errorf("duplicated --%s of table %s", flag, tableFQN)
+		}
+		var columns []string
+		for _, column := range strings.Split(list, ",") {
+			column = strings.TrimSpace(column)
+			if column == "" {
+				return nil, errors.Errorf("invalid --%s %s, expect <db>.<table>=<column>[,<column>...]", flag, value)
+			}
+			columns = append(columns, column)
+		}
+		tables[tableFQN] = columns
+	}
+	return tables, nil
+}
@@ -0,0 +1,43 @@
+package tablelayout_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tablelayout"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColumns(t *testing.T) {
+	partitionBy, err := tablelayout.ParseColumns("bq.partition-by", []string{"db.events=created_at", "db.orders = id:0:1000000:1000"})
+	require.NoError(t, err)
+	clusterBy, err := tablelayout.ParseColumns("bq.cluster-by", []string{"db.events=tenant_id, kind"})
+	require.NoError(t, err)
+	layouts := tablelayout.NewLayouts(partitionBy, clusterBy)
+	require.Equal(t, tablelayout.Layout{PartitionBy: []string{"created_at"}, ClusterBy: []string{"tenant_id", "kind"}}, layouts.Table("db.events"))
+	require.Equal(t, tablelayout.Layout{PartitionBy: []string{"id:0:1000000:1000"}}, layouts.Table("db.orders"))
+	require.Equal(t, tablelayout.Layout{}, layouts.Table("db.users"))
+
+	_, err = tablelayout.ParseColumns("bq.cluster-by", []string{"events=a"})
+	require.ErrorContains(t, err, "invalid --bq.cluster-by events=a")
+	_, err = tablelayout.ParseColumns("bq.cluster-by", []string{"db.events=a,,b"})
+	require.ErrorContains(t, err, "invalid --bq.cluster-by")
+	_, err = tablelayout.ParseColumns("bq.cluster-by", []string{"db.events=a", "db.events=b"})
+	require.ErrorContains(t, err, "duplicated --bq.cluster-by of table db.events")
+}
+
+func TestLayoutColumns(t *testing.T) {
+	columns := []cloudstorage.TableCol{{Name: "id", Tp: "INT"}, {Name: "Created_At", Tp: "DATE"}}
+	found, err := tablelayout.Lookup([]string{"created_at", "id:0:100:10"}, columns)
+	require.NoError(t, err)
+	require.Equal(t, []cloudstorage.TableCol{columns[1], columns[0]}, found)
+	_, err = tablelayout.Lookup([]string{"kind"}, columns)
+	require.ErrorContains(t, err, "Column kind partitioning or clustering the table is not a column of the table")
+
+	layout := tablelayout.Layout{PartitionBy: []string{"id:0:100:10"}, ClusterBy: []string{"Kind"}}
+	require.NoError(t, layout.CheckDropColumn("created_at"))
+	require.ErrorContains(t, layout.CheckDropColumn("ID"), "Can not drop column ID")
+	require.ErrorContains(t, layout.CheckDropColumn("kind"), "Can not drop column kind")
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(layout.CheckDropColumn("kind")))
+}
@@ -0,0 +1,108 @@
+package tidbcloud
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// ChangefeedStateRunning is the state of a changefeed writing the changes
+	ChangefeedStateRunning = "RUNNING"
+	// ChangefeedStateFailed is the state of a changefeed stopped by an error
+	ChangefeedStateFailed = "FAILED"
+)
+
+// ChangefeedSpec is the changefeed created to write the changes of the tables into the cloud storage as CSV
+type ChangefeedSpec struct {
+	DisplayName string `json:"displayName"`
+	// StartTSO is where the changefeed starts, 0 for now
+	StartTSO uint64           `json:"startTso,omitempty"`
+	Sink     ChangefeedSink   `json:"sink"`
+	Filter   ChangefeedFilter `json:"filter"`
+}
+
+// ChangefeedSink is the cloud storage written by the changefeed, the URI takes the parameters of the TiCDC
+// cloud storage sink, e.g. protocol=csv and flush-interval
+type ChangefeedSink struct {
+	Type            string `json:"type"`
+	URI             string `json:"uri"`
+	IncludeCommitTS bool   `json:"includeCommitTs"`
+	OutputColumnID  bool   `json:"outputColumnId"`
+	DateSeparator   string `json:"dateSeparator"`
+}
+
+// ChangefeedFilter is the tables replicated by the changefeed, in the filter rules of TiCDC
+type ChangefeedFilter struct {
+	Rules []string `json:"rules"`
+}
+
+// Changefeed is a changefeed of the cluster
+type Changefeed struct {
+	ID            string `json:"changefeedId"`
+	State         string `json:"state"`
+	CheckpointTSO uint64 `json:"checkpointTso,string,omitempty"`
+	StartTSO      uint64 `json:"startTso,string,omitempty"`
+	SinkURI       string `json:"sinkUri,omitempty"`
+	// Error is why the changefeed failed, empty if it did not
+	Error string `json:"errorMessage,omitempty"`
+}
+
+// CreateChangefeed creates the changefeed, TiDB Cloud generates its ID
+func (c *Client) CreateChangefeed(ctx context.Context, spec *ChangefeedSpec) (*Changefeed, error) {
+	var changefeed Changefeed
+	if err := c.do(ctx, "POST", "changefeeds", spec, &changefeed); err != nil {
+		return nil, errors.Annotate(err, "create changefeed failed")
+	}
+	if changefeed.ID == "" {
+		return nil, errors.New("create changefeed failed, no changefeed ID is returned")
+	}
+	return &changefeed, nil
+}
+
+// GetChangefeed returns the changefeed, found is false if it no longer exists
+func (c *Client) GetChangefeed(ctx context.Context, id string) (changefeed *Changefeed, found bool, err error) {
+	changefeed = new(Changefeed)
+	if err = c.do(ctx, "GET", "changefeeds/"+url.PathEscape(id), nil, changefeed); err != nil {
+		var apiErr *APIError
+		if stderrors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, errors.Annotatef(err, "get changefeed %s failed", id)
+	}
+	return changefeed, true, nil
+}
+
+// DeleteChangefeed deletes the changefeed, the files written by it are kept
+func (c *Client) DeleteChangefeed(ctx context.Context, id string) error {
+	err := c.do(ctx, "DELETE", "changefeeds/"+url.PathEscape(id), nil, nil)
+	return errors.Annotatef(err, "delete changefeed %s failed", id)
+}
+
+// WaitChangefeedRunning polls the changefeed until it is running, it fails once the changefeed is failed or deleted
+func (c *Client) WaitChangefeedRunning(ctx context.Context, id string, interval time.Duration) (*Changefeed, error) {
+	for {
+		changefeed, found, err := c.GetChangefeed(ctx, id)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !found {
+			return nil, errors.Errorf("changefeed %s is deleted before it runs", id)
+		}
+		switch changefeed.State {
+		case ChangefeedStateRunning:
+			return changefeed, nil
+		case ChangefeedStateFailed:
+			return nil, errors.Errorf("changefeed %s failed: %s", id, changefeed.Error)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Trace(ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
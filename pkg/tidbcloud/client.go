@@ -0,0 +1,241 @@
+package tidbcloud
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap/errors"
+)
+
+// DefaultAPIURL is the base URL of the TiDB Cloud OpenAPI
+const DefaultAPIURL = "https://dedicated.tidbapi.com/v1beta1"
+
+// requestTimeout bounds each request of the API
+const requestTimeout = 30 * time.Second
+
+// Config is how the TiDB Cloud OpenAPI is requested for the cluster replicated
+type Config struct {
+	PublicKey  string
+	PrivateKey string
+	ClusterID  string
+	// APIURL is the base URL of the API, DefaultAPIURL if empty
+	APIURL string
+}
+
+// Validate checks the keys and the cluster are all set
+func (c *Config) Validate() error {
+	if c.PublicKey == "" || c.PrivateKey == "" || c.ClusterID == "" {
+		return errors.New("--tidbcloud.public-key, --tidbcloud.private-key and --tidbcloud.cluster-id are required together")
+	}
+	if c.APIURL != "" {
+		if _, err := url.Parse(c.APIURL); err != nil {
+			return errors.Annotate(err, "invalid --tidbcloud.api-url")
+		}
+	}
+	return nil
+}
+
+// APIError is a response of the API other than 2xx
+type APIError struct {
+	StatusCode int
+	// Message is the message in the body of the response, the body itself if it is not JSON
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("TiDB Cloud API status code: %d, %s", e.StatusCode, e.Message)
+}
+
+// IsRetryable tells whether the request failed with the error may succeed if it is made again, i.e. it is rate limited,
+// the API is unavailable or the connection failed. The other errors of the API, e.g. a wrong key, fail at once.
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if stderrors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	return retry.IsTransient(err)
+}
+
+// Client requests the TiDB Cloud OpenAPI of the cluster, authenticated by HTTP digest with the API keys
+type Client struct {
+	cfg     Config
+	baseURL string
+	http    *http.Client
+	policy  retry.Policy
+}
+
+// NewClient returns the client of the cluster, the requests failed with a retryable error are retried by the policy
+func NewClient(cfg Config, policy retry.Policy) *Client {
+	baseURL := cfg.APIURL
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &Client{
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: requestTimeout},
+		policy:  policy,
+	}
+}
+
+// ClusterID returns the ID of the cluster requested
+func (c *Client) ClusterID() string {
+	return c.cfg.ClusterID
+}
+
+// do requests the path under the cluster and decodes the response into out unless it is nil
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	u := fmt.Sprintf("%s/clusters/%s/%s", c.baseURL, url.PathEscape(c.cfg.ClusterID), path)
+	return retry.Do(ctx, c.policy, IsRetryable, func() error {
+		resp, err := c.send(ctx, method, u, body, "")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			authorization, err := c.digestAuthorization(challenge, method, u)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if resp, err = c.send(ctx, method, u, body, authorization); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return &APIError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+		}
+		if out == nil || len(data) == 0 {
+			return nil
+		}
+		return errors.Annotatef(json.Unmarshal(data, out), "invalid response of %s %s", method, path)
+	}, nil)
+}
+
+func (c *Client) send(ctx context.Context, method, u string, body []byte, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := c.http.Do(req)
+	return resp, errors.Trace(err)
+}
+
+// digestAuthorization answers the digest challenge of the API with the keys, RFC 7616 with MD5 and qop=auth
+func (c *Client) digestAuthorization(challenge, method, u string) (string, error) {
+	scheme, params, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return "", &APIError{StatusCode: http.StatusUnauthorized, Message: "no digest challenge, check --tidbcloud.public-key and --tidbcloud.private-key"}
+	}
+	fields := parseChallenge(params)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	uri := parsed.RequestURI()
+	cnonceBytes := make([]byte, 8)
+	if _, err = rand.Read(cnonceBytes); err != nil {
+		return "", errors.Trace(err)
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	const nc = "00000001"
+	ha1 := md5Hex(c.cfg.PublicKey + ":" + fields["realm"] + ":" + c.cfg.PrivateKey)
+	ha2 := md5Hex(method + ":" + uri)
+	qop := ""
+	for _, option := range strings.Split(fields["qop"], ",") {
+		if strings.TrimSpace(option) == "auth" {
+			qop = "auth"
+		}
+	}
+	var response string
+	if qop == "" {
+		response = md5Hex(ha1 + ":" + fields["nonce"] + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + fields["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	}
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		c.cfg.PublicKey, fields["realm"], fields["nonce"], uri, response)
+	if qop != "" {
+		authorization += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s"`, nc, cnonce)
+	}
+	if fields["opaque"] != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, fields["opaque"])
+	}
+	if fields["algorithm"] != "" {
+		authorization += ", algorithm=" + fields["algorithm"]
+	}
+	return authorization, nil
+}
+
+// parseChallenge parses the comma separated key="value" parameters of a digest challenge, a quoted value may
+// hold commas, e.g. qop="auth,auth-int"
+func parseChallenge(params string) map[string]string {
+	fields := make(map[string]string)
+	for params != "" {
+		key, rest, ok := strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		params = rest
+	}
+	return fields
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// errorMessage returns the message of the error response, the body itself if it has none
+func errorMessage(body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Message != "" {
+		return resp.Message
+	}
+	return strings.TrimSpace(string(body))
+}
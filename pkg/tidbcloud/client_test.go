@@ -0,0 +1,130 @@
+package tidbcloud_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbcloud"
+	"github.com/stretchr/testify/require"
+)
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// digestServer checks the digest authorization of the keys before serving the handler
+func digestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if authorization == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="tidb.cloud", qop="auth,auth-int", nonce="abc", opaque="xyz"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fields := make(map[string]string)
+		for _, part := range strings.Split(strings.TrimPrefix(authorization, "Digest "), ", ") {
+			key, value, _ := strings.Cut(part, "=")
+			fields[key] = strings.Trim(value, `"`)
+		}
+		require.Equal(t, "public", fields["username"])
+		require.Equal(t, "xyz", fields["opaque"])
+		require.Equal(t, r.URL.RequestURI(), fields["uri"])
+		ha1 := md5Hex("public:tidb.cloud:private")
+		ha2 := md5Hex(r.Method + ":" + fields["uri"])
+		expected := md5Hex(fmt.Sprintf("%s:abc:%s:%s:auth:%s", ha1, fields["nc"], fields["cnonce"], ha2))
+		if fields["response"] != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"invalid key"}`))
+			return
+		}
+		handler(w, r)
+	}))
+}
+
+func newTestClient(server *httptest.Server) *tidbcloud.Client {
+	cfg := tidbcloud.Config{PublicKey: "public", PrivateKey: "private", ClusterID: "c1", APIURL: server.URL + "/v1beta1"}
+	return tidbcloud.NewClient(cfg, retry.Policy{MaxRetries: 2, MaxBackoff: 10 * time.Millisecond})
+}
+
+func TestChangefeed(t *testing.T) {
+	var polled atomic.Int32
+	server := digestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1beta1/clusters/c1/changefeeds":
+			var spec tidbcloud.ChangefeedSpec
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&spec))
+			require.Equal(t, uint64(440000000000000000), spec.StartTSO)
+			require.Equal(t, []string{"db.t"}, spec.Filter.Rules)
+			_, _ = w.Write([]byte(`{"changefeedId":"cf1","state":"CREATING"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1beta1/clusters/c1/changefeeds/cf1":
+			state := "CREATING"
+			if polled.Add(1) > 1 {
+				state = "RUNNING"
+			}
+			_, _ = fmt.Fprintf(w, `{"changefeedId":"cf1","state":"%s","checkpointTso":"440000000000000001"}`, state)
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1beta1/clusters/c1/changefeeds/cf1":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"changefeed not found"}`))
+		}
+	})
+	defer server.Close()
+	client := newTestClient(server)
+	ctx := context.Background()
+
+	changefeed, err := client.CreateChangefeed(ctx, &tidbcloud.ChangefeedSpec{StartTSO: 440000000000000000, Filter: tidbcloud.ChangefeedFilter{Rules: []string{"db.t"}}})
+	require.NoError(t, err)
+	require.Equal(t, "cf1", changefeed.ID)
+	changefeed, err = client.WaitChangefeedRunning(ctx, "cf1", time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, tidbcloud.ChangefeedStateRunning, changefeed.State)
+	require.Equal(t, uint64(440000000000000001), changefeed.CheckpointTSO)
+	require.NoError(t, client.DeleteChangefeed(ctx, "cf1"))
+
+	_, found, err := client.GetChangefeed(ctx, "missing")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestRetry(t *testing.T) {
+	var requests atomic.Int32
+	server := digestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1beta1/clusters/c1/exports" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"invalid target"}`))
+			return
+		}
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"exportId":"e1","state":"SUCCEEDED"}`))
+	})
+	defer server.Close()
+	client := newTestClient(server)
+	ctx := context.Background()
+
+	// rate limited once
+	require.NoError(t, client.WaitExport(ctx, "e1", time.Millisecond))
+	require.Equal(t, int32(2), requests.Load())
+
+	_, err := client.CreateExport(ctx, &tidbcloud.ExportSpec{})
+	require.ErrorContains(t, err, "status code: 400, invalid target")
+	require.False(t, tidbcloud.IsRetryable(err))
+
+	wrongKey := tidbcloud.NewClient(tidbcloud.Config{PublicKey: "public", PrivateKey: "wrong", ClusterID: "c1", APIURL: server.URL + "/v1beta1"}, retry.DefaultPolicy)
+	_, _, err = wrongKey.GetChangefeed(ctx, "cf1")
+	require.ErrorContains(t, err, "status code: 401, invalid key")
+}
@@ -0,0 +1,80 @@
+package tidbcloud
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// ExportStateSucceeded is the state of an export whose files are all written
+	ExportStateSucceeded = "SUCCEEDED"
+	// ExportStateFailed is the state of an export stopped by an error
+	ExportStateFailed = "FAILED"
+	// ExportStateCanceled is the state of an export canceled by hand
+	ExportStateCanceled = "CANCELED"
+)
+
+// ExportSpec is the export of the tables at a snapshot into the cloud storage as the CSV files of dumpling
+type ExportSpec struct {
+	DisplayName string `json:"displayName"`
+	// SnapshotTSO is the TSO the tables are exported at, the start of the changefeed
+	SnapshotTSO uint64        `json:"snapshotTso,string"`
+	Target      ExportTarget  `json:"target"`
+	Options     ExportOptions `json:"exportOptions"`
+}
+
+// ExportTarget is the cloud storage written by the export
+type ExportTarget struct {
+	URI string `json:"uri"`
+}
+
+// ExportOptions is the format and the tables of the export
+type ExportOptions struct {
+	FileType    string   `json:"fileType"`
+	Compression string   `json:"compression"`
+	Tables      []string `json:"tables"`
+}
+
+// Export is an export of the cluster
+type Export struct {
+	ID    string `json:"exportId"`
+	State string `json:"state"`
+	// Reason is why the export failed, empty if it did not
+	Reason string `json:"reason,omitempty"`
+}
+
+// CreateExport starts the export, TiDB Cloud generates its ID
+func (c *Client) CreateExport(ctx context.Context, spec *ExportSpec) (*Export, error) {
+	var export Export
+	if err := c.do(ctx, "POST", "exports", spec, &export); err != nil {
+		return nil, errors.Annotate(err, "create export failed")
+	}
+	if export.ID == "" {
+		return nil, errors.New("create export failed, no export ID is returned")
+	}
+	return &export, nil
+}
+
+// WaitExport polls the export until it succeeds, it fails once the export is failed or canceled
+func (c *Client) WaitExport(ctx context.Context, id string, interval time.Duration) error {
+	for {
+		var export Export
+		if err := c.do(ctx, "GET", "exports/"+url.PathEscape(id), nil, &export); err != nil {
+			return errors.Annotatef(err, "get export %s failed", id)
+		}
+		switch export.State {
+		case ExportStateSucceeded:
+			return nil
+		case ExportStateFailed, ExportStateCanceled:
+			return errors.Errorf("export %s is %s: %s", id, export.State, export.Reason)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
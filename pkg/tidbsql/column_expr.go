@@ -0,0 +1,238 @@
+package tidbsql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tidb/parser/mysql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// ColumnExprs are the columns of a table defined by expressions, which are not translated to the data warehouse
+// as they are. The names are in lowercase since the column names are case-insensitive in TiDB.
+type ColumnExprs struct {
+	// Virtual are the virtual generated columns, they are neither exported by dumpling nor replicated by TiCDC
+	// so they are skipped. The stored generated columns are replicated as ordinary columns.
+	Virtual map[string]struct{}
+	// Generated is whether the table has any generated column, which dumpling skips unless the columns are selected
+	Generated bool
+	// Defaults are the columns with an expression default, e.g. DEFAULT uuid(), the defaults are dropped
+	Defaults map[string]struct{}
+	// Dumped are the names of the columns dumped, in the order of the table
+	Dumped []string
+	// Bits are the lengths of the BIT columns, which are dumped by BitDumpExpr
+	Bits map[string]int
+	// Elems are the elements of the ENUM and SET columns, whose values are written as their indexes by canal-json
+	Elems map[string][]string
+}
+
+// NewColumnExprs returns the columns of a table without any generated column or expression default
+func NewColumnExprs() *ColumnExprs {
+	return &ColumnExprs{
+		Virtual:  make(map[string]struct{}),
+		Defaults: make(map[string]struct{}),
+		Bits:     make(map[string]int),
+		Elems:    make(map[string][]string),
+	}
+}
+
+// isVirtualColumn returns whether the EXTRA of information_schema.columns is of a virtual generated column
+func isVirtualColumn(extra string) bool {
+	return strings.Contains(strings.ToUpper(extra), "VIRTUAL GENERATED")
+}
+
+// isGeneratedColumn returns whether the EXTRA of information_schema.columns is of a generated column
+func isGeneratedColumn(extra string) bool {
+	extra = strings.ToUpper(extra)
+	return strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED")
+}
+
+// isExprDefault returns whether the COLUMN_DEFAULT and EXTRA of information_schema.columns are of an expression
+// default, CURRENT_TIMESTAMP is not marked DEFAULT_GENERATED by TiDB
+func isExprDefault(columnDefault *string, extra string) bool {
+	if columnDefault == nil {
+		return false
+	}
+	return strings.Contains(strings.ToUpper(extra), "DEFAULT_GENERATED") ||
+		strings.HasPrefix(strings.ToUpper(*columnDefault), "CURRENT_TIMESTAMP")
+}
+
+// GetTiDBColumnExprs returns the generated columns and the columns with an expression default of the table
+func GetTiDBColumnExprs(db *sql.DB, sourceDatabase, sourceTable string) (*ColumnExprs, error) {
+	rows, err := db.Query("SELECT COLUMN_NAME, COLUMN_DEFAULT, EXTRA, DATA_TYPE, COLUMN_TYPE, NUMERIC_PRECISION FROM information_schema.columns "+
+		"WHERE table_schema = ? AND table_name = ? ORDER BY ORDINAL_POSITION", sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer rows.Close()
+	exprs := NewColumnExprs()
+	for rows.Next() {
+		var name, extra, dataType, columnType string
+		var columnDefault *string
+		var precision sql.NullInt64
+		if err = rows.Scan(&name, &columnDefault, &extra, &dataType, &columnType, &precision); err != nil {
+			return nil, diag.Source(errors.Trace(err))
+		}
+		exprs.Generated = exprs.Generated || isGeneratedColumn(extra)
+		if isVirtualColumn(extra) {
+			exprs.Virtual[strings.ToLower(name)] = struct{}{}
+			continue
+		}
+		if isExprDefault(columnDefault, extra) {
+			exprs.Defaults[strings.ToLower(name)] = struct{}{}
+		}
+		if strings.EqualFold(dataType, "bit") {
+			exprs.Bits[strings.ToLower(name)] = max(int(precision.Int64), 1)
+		}
+		if strings.EqualFold(dataType, "enum") || strings.EqualFold(dataType, "set") {
+			exprs.Elems[strings.ToLower(name)] = parseElems(columnType)
+		}
+		exprs.Dumped = append(exprs.Dumped, name)
+	}
+	return exprs, diag.Source(errors.Trace(rows.Err()))
+}
+
+// Update updates the columns by the DDL of the schema file. The schema files of TiCDC do not tell the
+// generated columns and the expression defaults, so they are parsed from the query. A query failing to
+// parse is logged and treated as no change.
+func (e *ColumnExprs) Update(tableDef cloudstorage.TableDefinition) {
+	switch tableDef.Type {
+	case timodel.ActionCreateTable, timodel.ActionAddColumn, timodel.ActionAddColumns, timodel.ActionModifyColumn,
+		timodel.ActionDropColumn, timodel.ActionDropColumns, timodel.ActionMultiSchemaChange:
+	default:
+		return
+	}
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		log.Warn("Failed to parse DDL, generated columns and expression defaults are not changed",
+			zap.String("query", tableDef.Query), zap.Error(err))
+		return
+	}
+	switch stmt := stmt.(type) {
+	case *ast.CreateTableStmt:
+		*e = *NewColumnExprs()
+		for _, column := range stmt.Cols {
+			e.define(column)
+		}
+	case *ast.AlterTableStmt:
+		for _, spec := range stmt.Specs {
+			switch spec.Tp {
+			case ast.AlterTableAddColumns:
+				for _, column := range spec.NewColumns {
+					e.define(column)
+				}
+			case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+				if spec.OldColumnName != nil {
+					e.drop(spec.OldColumnName.Name.L)
+				}
+				for _, column := range spec.NewColumns {
+					e.drop(column.Name.Name.L)
+					e.define(column)
+				}
+			case ast.AlterTableDropColumn:
+				e.drop(spec.OldColumnName.Name.L)
+			case ast.AlterTableRenameColumn:
+				_, virtual := e.Virtual[spec.OldColumnName.Name.L]
+				_, exprDefault := e.Defaults[spec.OldColumnName.Name.L]
+				bits, bit := e.Bits[spec.OldColumnName.Name.L]
+				elems, enum := e.Elems[spec.OldColumnName.Name.L]
+				e.drop(spec.OldColumnName.Name.L)
+				if virtual {
+					e.Virtual[spec.NewColumnName.Name.L] = struct{}{}
+				}
+				if exprDefault {
+					e.Defaults[spec.NewColumnName.Name.L] = struct{}{}
+				}
+				if bit {
+					e.Bits[spec.NewColumnName.Name.L] = bits
+				}
+				if enum {
+					e.Elems[spec.NewColumnName.Name.L] = elems
+				}
+			}
+		}
+	}
+}
+
+func (e *ColumnExprs) define(column *ast.ColumnDef) {
+	if column.Tp != nil && column.Tp.GetType() == mysql.TypeBit {
+		e.Bits[column.Name.Name.L] = max(column.Tp.GetFlen(), 1)
+	}
+	if column.Tp != nil && (column.Tp.GetType() == mysql.TypeEnum || column.Tp.GetType() == mysql.TypeSet) {
+		e.Elems[column.Name.Name.L] = column.Tp.GetElems()
+	}
+	for _, option := range column.Options {
+		switch option.Tp {
+		case ast.ColumnOptionGenerated:
+			e.Generated = true
+			if !option.Stored {
+				e.Virtual[column.Name.Name.L] = struct{}{}
+			}
+		case ast.ColumnOptionDefaultValue:
+			if isExprNode(option.Expr) {
+				e.Defaults[column.Name.Name.L] = struct{}{}
+			}
+		}
+	}
+}
+
+func (e *ColumnExprs) drop(name string) {
+	delete(e.Virtual, name)
+	delete(e.Defaults, name)
+	delete(e.Bits, name)
+	delete(e.Elems, name)
+}
+
+// parseElems returns the elements of the COLUMN_TYPE of an ENUM or SET column, e.g. enum('a','b'), nil if it
+// fails to parse
+func parseElems(columnType string) []string {
+	stmt, err := parser.New().ParseOneStmt("CREATE TABLE t (c "+columnType+")", "", "")
+	if err != nil {
+		log.Warn("Failed to parse the column type", zap.String("type", columnType), zap.Error(err))
+		return nil
+	}
+	return stmt.(*ast.CreateTableStmt).Cols[0].Tp.GetElems()
+}
+
+// isExprNode returns whether the default value is an expression rather than a literal, e.g. -1
+func isExprNode(expr ast.ExprNode) bool {
+	switch expr := expr.(type) {
+	case ast.ValueExpr:
+		return false
+	case *ast.UnaryOperationExpr:
+		return isExprNode(expr.V)
+	default:
+		return true
+	}
+}
+
+// Apply returns the table definition translated to the data warehouse, the virtual generated columns are
+// skipped so that the columns are aligned with the CSV files, and the expression defaults are dropped.
+func (e *ColumnExprs) Apply(tableDef cloudstorage.TableDefinition) cloudstorage.TableDefinition {
+	if len(e.Virtual) == 0 && len(e.Defaults) == 0 {
+		return tableDef
+	}
+	columns := make([]cloudstorage.TableCol, 0, len(tableDef.Columns))
+	for _, column := range tableDef.Columns {
+		name := strings.ToLower(column.Name)
+		if _, ok := e.Virtual[name]; ok {
+			continue
+		}
+		if _, ok := e.Defaults[name]; ok && column.Default != nil {
+			log.Warn("Dropped the expression default of the column, which can not be translated to the data warehouse",
+				zap.String("table", tableDef.Table), zap.String("column", column.Name), zap.Any("default", column.Default))
+			column.Default = nil
+		}
+		columns = append(columns, column)
+	}
+	tableDef.Columns = columns
+	tableDef.TotalColumns = len(columns)
+	return tableDef
+}
@@ -0,0 +1,120 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnExprs(t *testing.T) {
+	exprs := tidbsql.NewColumnExprs()
+	createTable := cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionCreateTable,
+		Query: "CREATE TABLE t (a INT PRIMARY KEY, b INT DEFAULT -1, c INT AS (a + b) VIRTUAL, d INT AS (a * 2) STORED, " +
+			"e VARCHAR(36) DEFAULT (uuid()), f DATETIME DEFAULT CURRENT_TIMESTAMP)",
+		Columns: []cloudstorage.TableCol{
+			{Name: "a", Tp: "INT"}, {Name: "b", Tp: "INT", Default: "-1"}, {Name: "c", Tp: "INT"},
+			{Name: "d", Tp: "INT"}, {Name: "e", Tp: "VARCHAR", Default: "uuid()"},
+			{Name: "f", Tp: "DATETIME", Default: "CURRENT_TIMESTAMP"},
+		},
+		TotalColumns: 6,
+	}
+	exprs.Update(createTable)
+	require.True(t, exprs.Generated)
+	applied := exprs.Apply(createTable)
+	// the virtual column is skipped, the stored one is kept and the expression defaults are dropped
+	require.Equal(t, []cloudstorage.TableCol{
+		{Name: "a", Tp: "INT"}, {Name: "b", Tp: "INT", Default: "-1"}, {Name: "d", Tp: "INT"},
+		{Name: "e", Tp: "VARCHAR"}, {Name: "f", Tp: "DATETIME"},
+	}, applied.Columns)
+	require.Equal(t, 5, applied.TotalColumns)
+	// the table definition of the schema file is not changed
+	require.Len(t, createTable.Columns, 6)
+
+	addColumn := cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionAddColumn,
+		Query: "ALTER TABLE t ADD COLUMN g INT AS (a - 1) VIRTUAL",
+		Columns: append(createTable.Columns[:6:6],
+			cloudstorage.TableCol{Name: "g", Tp: "INT"}),
+		TotalColumns: 7,
+	}
+	exprs.Update(addColumn)
+	require.Len(t, exprs.Apply(addColumn).Columns, 5)
+
+	dropColumn := cloudstorage.TableDefinition{
+		Table:        "t",
+		Type:         timodel.ActionDropColumn,
+		Query:        "ALTER TABLE t DROP COLUMN c",
+		Columns:      append(createTable.Columns[:2:2], addColumn.Columns[3:]...),
+		TotalColumns: 6,
+	}
+	exprs.Update(dropColumn)
+	require.Len(t, exprs.Apply(dropColumn).Columns, 5)
+
+	// a column modified to a literal default keeps it
+	modifyColumn := cloudstorage.TableDefinition{
+		Table:        "t",
+		Type:         timodel.ActionModifyColumn,
+		Query:        "ALTER TABLE t MODIFY COLUMN e VARCHAR(36) DEFAULT 'none'",
+		Columns:      dropColumn.Columns,
+		TotalColumns: 6,
+	}
+	modifyColumn.Columns[3].Default = "none"
+	exprs.Update(modifyColumn)
+	require.Equal(t, "none", exprs.Apply(modifyColumn).Columns[3].Default)
+}
+
+func TestColumnExprsWithoutExprs(t *testing.T) {
+	exprs := tidbsql.NewColumnExprs()
+	tableDef := cloudstorage.TableDefinition{
+		Table:        "t",
+		Type:         timodel.ActionCreateTable,
+		Query:        "CREATE TABLE t (a INT PRIMARY KEY, b INT DEFAULT 1)",
+		Columns:      []cloudstorage.TableCol{{Name: "a", Tp: "INT"}, {Name: "b", Tp: "INT", Default: "1"}},
+		TotalColumns: 2,
+	}
+	exprs.Update(tableDef)
+	require.False(t, exprs.Generated)
+	require.Equal(t, tableDef, exprs.Apply(tableDef))
+}
+
+func TestColumnExprsBits(t *testing.T) {
+	exprs := tidbsql.NewColumnExprs()
+	exprs.Update(cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionCreateTable,
+		Query: "CREATE TABLE t (a INT PRIMARY KEY, b BIT, c BIT(12))",
+	})
+	require.Equal(t, map[string]int{"b": 1, "c": 12}, exprs.Bits)
+	exprs.Update(cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionModifyColumn,
+		Query: "ALTER TABLE t MODIFY COLUMN b TINYINT",
+	})
+	require.Equal(t, map[string]int{"c": 12}, exprs.Bits)
+
+	// BIT(1) is dumped as 0 or 1, a longer BIT as the hex of its bytes
+	require.Equal(t, "CAST(`b` AS UNSIGNED)", tidbsql.BitDumpExpr("b", 1))
+	require.Equal(t, "LPAD(HEX(`c`), 4, '0')", tidbsql.BitDumpExpr("c", 12))
+}
+
+func TestColumnExprsElems(t *testing.T) {
+	exprs := tidbsql.NewColumnExprs()
+	exprs.Update(cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionCreateTable,
+		Query: "CREATE TABLE t (a INT PRIMARY KEY, e ENUM('x', 'y'), s SET('p', 'q', 'r'))",
+	})
+	require.Equal(t, map[string][]string{"e": {"x", "y"}, "s": {"p", "q", "r"}}, exprs.Elems)
+	exprs.Update(cloudstorage.TableDefinition{
+		Table: "t",
+		Type:  timodel.ActionMultiSchemaChange,
+		Query: "ALTER TABLE t RENAME COLUMN e TO e2, DROP COLUMN s",
+	})
+	require.Equal(t, map[string][]string{"e2": {"x", "y"}}, exprs.Elems)
+}
@@ -0,0 +1,140 @@
+package tidbsql
+
+import (
+	"database/sql"
+	"unicode/utf8"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	timodel "github.com/pingcap/tidb/parser/model"
+	_ "github.com/pingcap/tidb/types/parser_driver"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// TableComments are the comments of a table and its columns
+type TableComments struct {
+	Table string
+	// Columns maps the column name to its comment, the columns without comment are omitted
+	Columns map[string]string
+}
+
+// GetTiDBTableComments reads the comments of the table and its columns. The connectors replicating no comments,
+// by --sync-comments=false, do not read them.
+func GetTiDBTableComments(db *sql.DB, sourceDatabase, sourceTable string) (*TableComments, error) {
+	comments := &TableComments{Columns: make(map[string]string)}
+	err := db.QueryRow("SELECT TABLE_COMMENT FROM information_schema.tables WHERE table_schema = ? AND table_name = ?",
+		sourceDatabase, sourceTable).Scan(&comments.Table)
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	rows, err := db.Query("SELECT COLUMN_NAME, COLUMN_COMMENT FROM information_schema.columns WHERE table_schema = ? AND table_name = ?",
+		sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, comment string
+		if err = rows.Scan(&name, &comment); err != nil {
+			return nil, diag.Source(errors.Trace(err))
+		}
+		if comment != "" {
+			comments.Columns[name] = comment
+		}
+	}
+	return comments, diag.Source(errors.Trace(rows.Err()))
+}
+
+// CommentChanges are the comments set by a DDL
+type CommentChanges struct {
+	// Table is the new comment of the table, nil if not changed
+	Table *string
+	// Columns maps the name of the columns defined by the DDL to their comments. A modified
+	// column without COMMENT has an empty comment, since the whole definition is replaced.
+	Columns map[string]string
+}
+
+// GetCommentChanges parses the comments set by the DDL. The schema files of TiCDC do not
+// carry comments, so they are parsed from the query. A query failing to parse is logged and
+// treated as no change, since comments should not block the replication.
+func GetCommentChanges(tableDef cloudstorage.TableDefinition) *CommentChanges {
+	changes := &CommentChanges{Columns: make(map[string]string)}
+	switch tableDef.Type {
+	case timodel.ActionAddColumn, timodel.ActionAddColumns, timodel.ActionModifyColumn,
+		timodel.ActionModifyTableComment, timodel.ActionMultiSchemaChange:
+	default:
+		return changes
+	}
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		log.Warn("Failed to parse DDL, comments are not changed", zap.String("query", tableDef.Query), zap.Error(err))
+		return changes
+	}
+	alterStmt, ok := stmt.(*ast.AlterTableStmt)
+	if !ok {
+		return changes
+	}
+	for _, spec := range alterStmt.Specs {
+		switch spec.Tp {
+		case ast.AlterTableOption:
+			for _, option := range spec.Options {
+				if option.Tp == ast.TableOptionComment {
+					comment := option.StrValue
+					changes.Table = &comment
+				}
+			}
+		case ast.AlterTableAddColumns:
+			for _, column := range spec.NewColumns {
+				if comment := columnComment(column); comment != "" {
+					changes.Columns[column.Name.Name.O] = comment
+				}
+			}
+		case ast.AlterTableModifyColumn, ast.AlterTableChangeColumn:
+			for _, column := range spec.NewColumns {
+				changes.Columns[column.Name.Name.O] = columnComment(column)
+			}
+		}
+	}
+	return changes
+}
+
+func columnComment(column *ast.ColumnDef) string {
+	for _, option := range column.Options {
+		if option.Tp != ast.ColumnOptionComment {
+			continue
+		}
+		if value, ok := option.Expr.(ast.ValueExpr); ok {
+			return value.GetString()
+		}
+	}
+	return ""
+}
+
+// TruncateComment truncates the comment to the limit of characters of the data warehouse, 0 means no limit
+func TruncateComment(comment string, limit int, object string) string {
+	if limit <= 0 || utf8.RuneCountInString(comment) <= limit {
+		return comment
+	}
+	log.Warn("Comment exceeds the limit of the data warehouse, truncated",
+		zap.String("object", object), zap.Int("length", utf8.RuneCountInString(comment)), zap.Int("limit", limit))
+	return string([]rune(comment)[:limit])
+}
+
+// TruncateCommentBytes truncates the comment to the limit of bytes of the data warehouse at a character boundary,
+// 0 means no limit
+func TruncateCommentBytes(comment string, limit int, object string) string {
+	if limit <= 0 || len(comment) <= limit {
+		return comment
+	}
+	log.Warn("Comment exceeds the limit of the data warehouse, truncated",
+		zap.String("object", object), zap.Int("bytes", len(comment)), zap.Int("limit", limit))
+	end := limit
+	for end > 0 && !utf8.RuneStart(comment[end]) {
+		end--
+	}
+	return comment[:end]
+}
@@ -0,0 +1,51 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCommentChanges(t *testing.T) {
+	changes := tidbsql.GetCommentChanges(cloudstorage.TableDefinition{
+		Type:  timodel.ActionMultiSchemaChange,
+		Query: "ALTER TABLE t ADD COLUMN a INT COMMENT 'it''s a', ADD COLUMN b INT, CHANGE COLUMN c d INT, MODIFY COLUMN e INT COMMENT '', COMMENT = 'table t'",
+	})
+	require.NotNil(t, changes.Table)
+	require.Equal(t, "table t", *changes.Table)
+	// the modified columns without COMMENT have their comments cleared
+	require.Equal(t, map[string]string{"a": "it's a", "d": "", "e": ""}, changes.Columns)
+
+	// other action types do not change comments
+	changes = tidbsql.GetCommentChanges(cloudstorage.TableDefinition{
+		Type:  timodel.ActionTruncateTable,
+		Query: "TRUNCATE TABLE t",
+	})
+	require.Nil(t, changes.Table)
+	require.Empty(t, changes.Columns)
+
+	// the query failing to parse is treated as no change
+	changes = tidbsql.GetCommentChanges(cloudstorage.TableDefinition{
+		Type:  timodel.ActionModifyColumn,
+		Query: "ALTER TABLE t MODIFY",
+	})
+	require.Empty(t, changes.Columns)
+}
+
+func TestTruncateComment(t *testing.T) {
+	require.Equal(t, "订单", tidbsql.TruncateComment("订单备注", 2, "t.c"))
+	require.Equal(t, "订单备注", tidbsql.TruncateComment("订单备注", 4, "t.c"))
+	require.Equal(t, "订单备注", tidbsql.TruncateComment("订单备注", 0, "t.c"))
+}
+
+func TestTruncateCommentBytes(t *testing.T) {
+	// each character takes 3 bytes, the character cut in the middle is dropped
+	require.Equal(t, "订", tidbsql.TruncateCommentBytes("订单备注", 5, "t.c"))
+	require.Equal(t, "订单", tidbsql.TruncateCommentBytes("订单备注", 6, "t.c"))
+	require.Equal(t, "", tidbsql.TruncateCommentBytes("订单备注", 2, "t.c"))
+	require.Equal(t, "订单备注", tidbsql.TruncateCommentBytes("订单备注", 12, "t.c"))
+	require.Equal(t, "订单备注", tidbsql.TruncateCommentBytes("订单备注", 0, "t.c"))
+}
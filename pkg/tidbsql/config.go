@@ -1,13 +1,14 @@
 package tidbsql
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"database/sql"
-	"fmt"
-	"os"
+	"net"
+	"strconv"
+	"strings"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 )
@@ -18,40 +19,56 @@ type TiDBConfig struct {
 	User  string
 	Pass  string
 	SSLCA string
+	// SSLCert and SSLKey are the client certificate of TLS, required by TiDB with REQUIRE X509
+	SSLCert string
+	SSLKey  string
+	// TimeZone is the IANA time zone of the sessions, which the TIMESTAMP values are dumped in, empty for the
+	// time zone of TiDB
+	TimeZone string
 }
 
 /// implement the Config interface
 
+// QuoteIdent quotes the name of a database, a table or a column of TiDB by backticks
+func QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (config *TiDBConfig) tlsConfig() utils.TLSConfig {
+	return utils.TLSConfig{CA: config.SSLCA, Cert: config.SSLCert, Key: config.SSLKey}
+}
+
 // func Open opens a connection to TiDB
 func (config *TiDBConfig) OpenDB() (*sql.DB, error) {
 	tidbConfig := mysql.NewConfig()
 	tidbConfig.User = config.User
 	tidbConfig.Passwd = config.Pass
 	tidbConfig.Net = "tcp"
-	tidbConfig.Addr = fmt.Sprintf("%s:%d", config.Host, config.Port)
-	if config.SSLCA != "" {
-		rootCertPool := x509.NewCertPool()
-		pem, err := os.ReadFile(config.SSLCA)
+	tidbConfig.Addr = net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	if tlsConfig := config.tlsConfig(); tlsConfig.Enabled() {
+		clientTLS, err := tlsConfig.Build(config.Host)
 		if err != nil {
-			return nil, err
+			return nil, diag.Source(errors.Annotate(err, "Failed to configure TLS of TiDB connection"))
 		}
-		if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
-			return nil, errors.Errorf("Failed to append PEM.")
+		// the TLS configs are registered by name globally, the TiDB clusters of the pipelines may differ
+		tlsName := "tidb-" + tidbConfig.Addr
+		if err = mysql.RegisterTLSConfig(tlsName, clientTLS); err != nil {
+			return nil, diag.Source(errors.Trace(err))
 		}
-		mysql.RegisterTLSConfig("tidb", &tls.Config{
-			RootCAs:    rootCertPool,
-			MinVersion: tls.VersionTLS12,
-			ServerName: config.Host,
-		})
-		tidbConfig.TLSConfig = "tidb"
+		tidbConfig.TLSConfig = tlsName
+	}
+	if config.TimeZone != "" {
+		// the name is checked by utils.ParseTimeZone, it has no quote
+		tidbConfig.Params = map[string]string{"time_zone": "'" + config.TimeZone + "'"}
 	}
 	db, err := sql.Open("mysql", tidbConfig.FormatDSN())
 	if err != nil {
-		return nil, errors.Annotate(err, "Failed to open TiDB connection")
+		return nil, diag.Source(errors.Annotate(err, "Failed to open TiDB connection"))
 	}
 	// make sure the connection is available
 	if err = db.Ping(); err != nil {
-		return nil, errors.Annotate(err, "Failed to open TiDB connection")
+		err = utils.AnnotateTLSError(err, "TiDB", "tidb")
+		return nil, diag.Source(errors.Annotate(err, "Failed to open TiDB connection"))
 	}
 	log.Info("TiDB connection established")
 	return db, nil
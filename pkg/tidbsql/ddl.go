@@ -5,10 +5,15 @@ import (
 	"database/sql"
 	"fmt"
 	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/dumpling/export"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
 )
 
 type columnAction int8
@@ -43,6 +48,15 @@ func CompareColumn(lhs, rhs *cloudstorage.TableCol) (columnAction, error) {
 	return UNCHANGE, nil
 }
 
+// columnKey pairs the columns across the schema files by ID, the columns have no ID unless the changefeed is created
+// with output-column-id, and are paired by name then, so that a renamed column is dropped and added
+func columnKey(col cloudstorage.TableCol) string {
+	if col.ID == "" {
+		return "name:" + col.Name
+	}
+	return col.ID
+}
+
 func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) ([]ColumnDiff, error) {
 	// name -> column
 	prevNameMap := make(map[string]*cloudstorage.TableCol, len(prev))
@@ -50,19 +64,19 @@ func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) (
 	prevIDMap := make(map[string]*cloudstorage.TableCol, len(prev))
 	for i, item := range prev {
 		prevNameMap[item.Name] = &prev[i]
-		prevIDMap[item.ID] = &prev[i]
+		prevIDMap[columnKey(item)] = &prev[i]
 	}
 	currNameMap := make(map[string]*cloudstorage.TableCol, len(curr))
 	currIDMap := make(map[string]*cloudstorage.TableCol, len(curr))
 	for i, item := range curr {
 		currNameMap[item.Name] = &curr[i]
-		currIDMap[item.ID] = &curr[i]
+		currIDMap[columnKey(item)] = &curr[i]
 	}
 	columnDiff := make([]ColumnDiff, 0, len(curr))
 	// When prevItem and currItem have the same name, they must be the same column.
 	for i, prevItem := range prev {
 		if currItem, ok := currNameMap[prevItem.Name]; ok {
-			if prevItem.ID != currItem.ID {
+			if columnKey(prevItem) != columnKey(*currItem) {
 				// In TiDB, for `ALTER TABLE t MODIFY COLUMN a char(10);` where a is int(11) before,
 				// TiDB will add a tmp column a_$, fill the data, delete the column a, and then rename the column a_$ to a.
 				// In this case, the column id will be different.
@@ -72,12 +86,18 @@ func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) (
 					After:  currItem,
 				})
 				// delete prevItem and currItem from IDMap to avoid duplicate processing
-				delete(prevIDMap, prevItem.ID)
-				delete(currIDMap, currItem.ID)
+				delete(prevIDMap, columnKey(prevItem))
+				delete(currIDMap, columnKey(*currItem))
 			}
 		}
 	}
-	for id, prevItem := range prevIDMap {
+	// the columns are walked in their order rather than the order of the maps, so that the DDLs are in a stable order
+	for _, item := range prev {
+		id := columnKey(item)
+		prevItem, ok := prevIDMap[id]
+		if !ok {
+			continue
+		}
 		if currItem, ok := currIDMap[id]; !ok {
 			// If the column is in the prevMap, and the column is not in the currMap, it means that the column is deleted.
 			columnDiff = append(columnDiff, ColumnDiff{
@@ -101,7 +121,12 @@ func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) (
 		delete(prevIDMap, id)
 	}
 	// Add the remaining columns in currMap are not in prevMap, which means that the columns are added.
-	for _, currItem := range currIDMap {
+	for _, item := range curr {
+		currItem, ok := currIDMap[columnKey(item)]
+		if !ok {
+			continue
+		}
+		delete(currIDMap, columnKey(item))
 		columnDiff = append(columnDiff, ColumnDiff{
 			Action: ADD_COLUMN,
 			Before: nil,
@@ -112,15 +137,14 @@ func GetColumnDiff(prev []cloudstorage.TableCol, curr []cloudstorage.TableCol) (
 }
 
 func GetTiDBTableColumn(db *sql.DB, sourceDatabase, sourceTable string) ([]cloudstorage.TableCol, error) {
-	columnQuery := fmt.Sprintf(`SELECT COLUMN_NAME, COLUMN_DEFAULT, IS_NULLABLE, DATA_TYPE, 
-CHARACTER_MAXIMUM_LENGTH, NUMERIC_PRECISION, NUMERIC_SCALE, DATETIME_PRECISION
+	columnQuery := fmt.Sprintf(`SELECT COLUMN_NAME, COLUMN_DEFAULT, IS_NULLABLE, DATA_TYPE, COLUMN_TYPE,
+CHARACTER_MAXIMUM_LENGTH, NUMERIC_PRECISION, NUMERIC_SCALE, DATETIME_PRECISION, EXTRA
 FROM information_schema.columns
 WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) // FIXME: Escape
 	rows, err := db.Query(columnQuery)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, diag.Source(errors.Trace(err))
 	}
-	// TODO: Confirm with generated column, sequence.
 	defer rows.Close()
 	tableColumns := make([]cloudstorage.TableCol, 0)
 	for rows.Next() {
@@ -129,23 +153,27 @@ WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) /
 			ColumnDefault *string
 			IsNullable    string
 			DataType      string
+			ColumnType    string
 			CharMaxLength *int
 			NumPrecision  *int
 			NumScale      *int
 			DateTimePrec  *int
+			Extra         string
 		}
 		err = rows.Scan(
 			&column.ColumnName,
 			&column.ColumnDefault,
 			&column.IsNullable,
 			&column.DataType,
+			&column.ColumnType,
 			&column.CharMaxLength,
 			&column.NumPrecision,
 			&column.NumScale,
 			&column.DateTimePrec,
+			&column.Extra,
 		)
 		if err != nil {
-			return nil, errors.Trace(err)
+			return nil, diag.Source(errors.Trace(err))
 		}
 		var precision, scale, nullable string
 		if column.NumPrecision != nil {
@@ -163,13 +191,20 @@ WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) /
 		} else {
 			nullable = "false"
 		}
+		// the virtual generated columns are not dumped, the stored ones are replicated as ordinary columns
+		if isVirtualColumn(column.Extra) {
+			continue
+		}
 		var defaultVal interface{}
-		if column.ColumnDefault != nil {
+		if isExprDefault(column.ColumnDefault, column.Extra) {
+			log.Warn("Dropped the expression default of the column, which can not be translated to the data warehouse",
+				zap.String("table", sourceTable), zap.String("column", column.ColumnName), zap.String("default", *column.ColumnDefault))
+		} else if column.ColumnDefault != nil {
 			defaultVal = *column.ColumnDefault
 		}
 		tableCol := cloudstorage.TableCol{
 			Name:      column.ColumnName,
-			Tp:        column.DataType,
+			Tp:        columnDataType(column.DataType, column.ColumnType),
 			Default:   defaultVal,
 			Precision: precision,
 			Scale:     scale,
@@ -180,15 +215,24 @@ WHERE table_schema = "%s" AND table_name = "%s"`, sourceDatabase, sourceTable) /
 	return tableColumns, nil
 }
 
+// columnDataType returns the data type of the column with the unsigned attribute like the schema files of TiCDC,
+// e.g. bigint unsigned, so that the unsigned integers are widened in the data warehouse
+func columnDataType(dataType, columnType string) string {
+	if strings.Contains(strings.ToLower(columnType), "unsigned") {
+		return dataType + " unsigned"
+	}
+	return dataType
+}
+
 func GetTiDBTablePKColumns(db *sql.DB, sourceDatabase, sourceTable string) ([]string, error) {
 	indexQuery := fmt.Sprintf("SHOW INDEX FROM `%s`.`%s`", sourceDatabase, sourceTable) // FIXME: Escape
 	indexRows, err := db.Query(indexQuery)
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, diag.Source(errors.Trace(err))
 	}
 	indexResults, err := export.GetSpecifiedColumnValuesAndClose(indexRows, "KEY_NAME", "COLUMN_NAME", "SEQ_IN_INDEX")
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, diag.Source(errors.Trace(err))
 	}
 	// Sort by key_name, seq_in_index
 	slices.SortStableFunc(indexResults, func(i, j []string) int {
@@ -206,3 +250,51 @@ func GetTiDBTablePKColumns(db *sql.DB, sourceDatabase, sourceTable string) ([]st
 	}
 	return pkColumns, nil
 }
+
+// GetPKColumns returns the primary key columns of the table definition in TiCDC schema files, in the
+// order of the columns rather than of the key
+func GetPKColumns(columns []cloudstorage.TableCol) []string {
+	pkColumns := make([]string, 0, 1)
+	for _, column := range columns {
+		if column.IsPK == "true" {
+			pkColumns = append(pkColumns, column.Name)
+		}
+	}
+	return pkColumns
+}
+
+// WithAddedColumnDefault returns the column added with the value TiDB fills the existing rows with as its default.
+// TiDB adds a NOT NULL column without a default by filling the zero value of its type, while a data warehouse
+// either rejects the column on a table with rows or fills NULL. A NOT NULL column whose type has no zero value
+// in the data warehouse, e.g. DATE, is added as nullable with a warning.
+func WithAddedColumnDefault(table string, column cloudstorage.TableCol) cloudstorage.TableCol {
+	if column.Nullable != "false" || column.Default != nil {
+		return column
+	}
+	switch strings.TrimSuffix(strings.ToLower(column.Tp), " unsigned") {
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "float", "double", "decimal", "numeric":
+		column.Default = 0
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		column.Default = ""
+	default:
+		log.Warn("The NOT NULL column is added as nullable, the zero value filling the existing rows in TiDB is unknown to the data warehouse",
+			zap.String("table", table), zap.String("column", column.Name), zap.String("type", column.Tp))
+		column.Nullable = "true"
+	}
+	return column
+}
+
+// DefaultLiteral returns the default value of the column as a literal of the data warehouse, quote escapes a string
+// literal. The column type decides the quoting: the default of a numeric column is a number, the other defaults are
+// strings even if they look like numbers, e.g. '007' of a VARCHAR.
+func DefaultLiteral(column cloudstorage.TableCol, quote func(string) string) string {
+	str := fmt.Sprint(column.Default)
+	switch strings.TrimSuffix(strings.ToLower(column.Tp), " unsigned") {
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "float", "double", "decimal", "numeric", "year":
+		// a default not parsed as a number is still quoted instead of being written into the SQL as it is
+		if _, err := strconv.ParseFloat(str, 64); err == nil {
+			return str
+		}
+	}
+	return quote(str)
+}
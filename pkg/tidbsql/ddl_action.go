@@ -0,0 +1,206 @@
+package tidbsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+)
+
+// DDLActionClass is how a kind of DDL is handled by a data warehouse
+type DDLActionClass string
+
+const (
+	// DDLActionNoop does not change the table in the data warehouse, it is logged and skipped
+	DDLActionNoop DDLActionClass = "no-op"
+	// DDLActionTranslatable is translated into the dialect of the data warehouse by the connector
+	DDLActionTranslatable DDLActionClass = "translatable"
+	// DDLActionMustPause changes the data without row events, e.g. exchange partition,
+	// the replication of the table is paused until it is handled manually
+	DDLActionMustPause DDLActionClass = "must-pause"
+)
+
+// DDLActionClasses classifies all action types known by the TiDB parser, it is the classification of the data
+// warehouses not implementing coreinterfaces.DDLClassifier, the others override it by WithDDLActionClasses. The
+// connectors may still reject a translatable DDL which is not supported by the data warehouse.
+var DDLActionClasses = map[timodel.ActionType]DDLActionClass{
+	timodel.ActionCreateSchema:                  DDLActionTranslatable,
+	timodel.ActionDropSchema:                    DDLActionTranslatable,
+	timodel.ActionCreateTable:                   DDLActionTranslatable,
+	timodel.ActionDropTable:                     DDLActionTranslatable,
+	timodel.ActionAddColumn:                     DDLActionTranslatable,
+	timodel.ActionDropColumn:                    DDLActionTranslatable,
+	timodel.ActionAddIndex:                      DDLActionNoop,
+	timodel.ActionDropIndex:                     DDLActionNoop,
+	timodel.ActionAddForeignKey:                 DDLActionNoop,
+	timodel.ActionDropForeignKey:                DDLActionNoop,
+	timodel.ActionTruncateTable:                 DDLActionTranslatable,
+	timodel.ActionModifyColumn:                  DDLActionTranslatable,
+	timodel.ActionRebaseAutoID:                  DDLActionNoop,
+	timodel.ActionRenameTable:                   DDLActionTranslatable,
+	timodel.ActionSetDefaultValue:               DDLActionTranslatable,
+	timodel.ActionShardRowID:                    DDLActionNoop,
+	timodel.ActionModifyTableComment:            DDLActionTranslatable,
+	timodel.ActionRenameIndex:                   DDLActionNoop,
+	timodel.ActionAddTablePartition:             DDLActionNoop,
+	timodel.ActionDropTablePartition:            DDLActionMustPause,
+	timodel.ActionCreateView:                    DDLActionNoop,
+	timodel.ActionModifyTableCharsetAndCollate:  DDLActionNoop,
+	timodel.ActionTruncateTablePartition:        DDLActionMustPause,
+	timodel.ActionDropView:                      DDLActionNoop,
+	timodel.ActionRecoverTable:                  DDLActionMustPause,
+	timodel.ActionModifySchemaCharsetAndCollate: DDLActionNoop,
+	timodel.ActionLockTable:                     DDLActionNoop,
+	timodel.ActionUnlockTable:                   DDLActionNoop,
+	timodel.ActionRepairTable:                   DDLActionMustPause,
+	timodel.ActionSetTiFlashReplica:             DDLActionNoop,
+	timodel.ActionUpdateTiFlashReplicaStatus:    DDLActionNoop,
+	timodel.ActionAddPrimaryKey:                 DDLActionMustPause, // the primary key is the merge key in the data warehouse
+	timodel.ActionDropPrimaryKey:                DDLActionMustPause,
+	timodel.ActionCreateSequence:                DDLActionNoop,
+	timodel.ActionAlterSequence:                 DDLActionNoop,
+	timodel.ActionDropSequence:                  DDLActionNoop,
+	timodel.ActionAddColumns:                    DDLActionTranslatable,
+	timodel.ActionDropColumns:                   DDLActionTranslatable,
+	timodel.ActionModifyTableAutoIdCache:        DDLActionNoop,
+	timodel.ActionRebaseAutoRandomBase:          DDLActionNoop,
+	timodel.ActionAlterIndexVisibility:          DDLActionNoop,
+	timodel.ActionExchangeTablePartition:        DDLActionMustPause,
+	timodel.ActionAddCheckConstraint:            DDLActionNoop,
+	timodel.ActionDropCheckConstraint:           DDLActionNoop,
+	timodel.ActionAlterCheckConstraint:          DDLActionNoop,
+	timodel.ActionType(46):                      DDLActionNoop, // __DEPRECATED_ActionAlterTableAlterPartition
+	timodel.ActionRenameTables:                  DDLActionTranslatable,
+	timodel.ActionDropIndexes:                   DDLActionNoop,
+	timodel.ActionAlterTableAttributes:          DDLActionNoop,
+	timodel.ActionAlterTablePartitionAttributes: DDLActionNoop,
+	timodel.ActionCreatePlacementPolicy:         DDLActionNoop,
+	timodel.ActionAlterPlacementPolicy:          DDLActionNoop,
+	timodel.ActionDropPlacementPolicy:           DDLActionNoop,
+	timodel.ActionAlterTablePartitionPlacement:  DDLActionNoop,
+	timodel.ActionModifySchemaDefaultPlacement:  DDLActionNoop,
+	timodel.ActionAlterTablePlacement:           DDLActionNoop,
+	timodel.ActionAlterCacheTable:               DDLActionNoop,
+	timodel.ActionAlterTableStatsOptions:        DDLActionNoop,
+	timodel.ActionAlterNoCacheTable:             DDLActionNoop,
+	timodel.ActionCreateTables:                  DDLActionMustPause,
+	timodel.ActionMultiSchemaChange:             DDLActionTranslatable,
+	timodel.ActionFlashbackCluster:              DDLActionMustPause,
+	timodel.ActionRecoverSchema:                 DDLActionMustPause,
+	timodel.ActionReorganizePartition:           DDLActionNoop,
+	timodel.ActionAlterTTLInfo:                  DDLActionNoop,
+	timodel.ActionAlterTTLRemove:                DDLActionNoop,
+	timodel.ActionCreateResourceGroup:           DDLActionNoop,
+	timodel.ActionAlterResourceGroup:            DDLActionNoop,
+	timodel.ActionDropResourceGroup:             DDLActionNoop,
+}
+
+// WithDDLActionClasses returns a copy of DDLActionClasses with the classes of the action types overridden, for a
+// data warehouse handling these DDLs differently
+func WithDDLActionClasses(overrides map[timodel.ActionType]DDLActionClass) map[timodel.ActionType]DDLActionClass {
+	classes := make(map[timodel.ActionType]DDLActionClass, len(DDLActionClasses))
+	for tp, class := range DDLActionClasses {
+		classes[tp] = class
+	}
+	for tp, class := range overrides {
+		classes[tp] = class
+	}
+	return classes
+}
+
+// UnknownDDLPolicy is how the DDL not in DDLActionClasses is handled, e.g. introduced by a newer TiDB
+type UnknownDDLPolicy string
+
+const (
+	// UnknownDDLPause pauses the replication of the table, which is the default
+	UnknownDDLPause UnknownDDLPolicy = "pause"
+	// UnknownDDLSkip handles the DDL as a no-op
+	UnknownDDLSkip UnknownDDLPolicy = "skip"
+	// UnknownDDLError fails the replication
+	UnknownDDLError UnknownDDLPolicy = "error"
+)
+
+func ParseUnknownDDLPolicy(s string) (UnknownDDLPolicy, error) {
+	switch policy := UnknownDDLPolicy(strings.ToLower(s)); policy {
+	case UnknownDDLPause, UnknownDDLSkip, UnknownDDLError:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown DDL policy %s, expected one of pause, skip, error", s)
+	}
+}
+
+// UnsupportedDDLPolicy is how a translatable DDL which the connector can not apply to the data warehouse is
+// handled, e.g. a MODIFY COLUMN losing data on Databricks
+type UnsupportedDDLPolicy string
+
+const (
+	// UnsupportedDDLError fails the replication, which is the default
+	UnsupportedDDLError UnsupportedDDLPolicy = "error"
+	// UnsupportedDDLSkip records the DDL as skipped and replicates the data by the new columns, the table in the
+	// data warehouse may diverge from TiDB
+	UnsupportedDDLSkip UnsupportedDDLPolicy = "skip"
+	// UnsupportedDDLPause pauses the replication of the table until the DDL is handled by hand and the table resumed
+	UnsupportedDDLPause UnsupportedDDLPolicy = "pause"
+)
+
+func ParseUnsupportedDDLPolicy(s string) (UnsupportedDDLPolicy, error) {
+	switch policy := UnsupportedDDLPolicy(strings.ToLower(s)); policy {
+	case UnsupportedDDLError, UnsupportedDDLSkip, UnsupportedDDLPause:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown unsupported DDL policy %s, expected one of error, skip, pause", s)
+	}
+}
+
+// unsupportedDDLError is returned by a connector for a DDL which can not be applied to the data warehouse,
+// it is handled by the UnsupportedDDLPolicy instead of failing the replication
+type unsupportedDDLError struct {
+	msg string
+}
+
+func (e *unsupportedDDLError) Error() string {
+	return e.msg
+}
+
+// NewUnsupportedDDLError returns the error of a DDL not supported by the data warehouse
+func NewUnsupportedDDLError(format string, args ...any) error {
+	return errors.WithStack(&unsupportedDDLError{msg: fmt.Sprintf(format, args...)})
+}
+
+// IsUnsupportedDDL tells whether the error is caused by a DDL not supported by the data warehouse
+func IsUnsupportedDDL(err error) bool {
+	_, ok := errors.Cause(err).(*unsupportedDDLError)
+	return ok
+}
+
+// DDLHandling is what to do with a DDL
+type DDLHandling int
+
+const (
+	DDLHandlingApply DDLHandling = iota
+	DDLHandlingSkip
+	DDLHandlingPause
+	DDLHandlingError
+)
+
+// GetDDLHandling returns how the DDL of the action type is handled by the data warehouse classifying the action
+// types by classes
+func GetDDLHandling(classes map[timodel.ActionType]DDLActionClass, tp timodel.ActionType, unknownDDLPolicy UnknownDDLPolicy) DDLHandling {
+	switch classes[tp] {
+	case DDLActionTranslatable:
+		return DDLHandlingApply
+	case DDLActionNoop:
+		return DDLHandlingSkip
+	case DDLActionMustPause:
+		return DDLHandlingPause
+	}
+	switch unknownDDLPolicy {
+	case UnknownDDLSkip:
+		return DDLHandlingSkip
+	case UnknownDDLError:
+		return DDLHandlingError
+	default:
+		return DDLHandlingPause
+	}
+}
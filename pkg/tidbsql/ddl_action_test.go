@@ -0,0 +1,84 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql/ddltest"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDDLActionClassesCoverAllActions fails when a newer TiDB parser introduces an action type
+// which is not classified yet, so that it is audited rather than handled by --unknown-ddl.
+func TestDDLActionClassesCoverAllActions(t *testing.T) {
+	for i := 1; i < 256; i++ {
+		tp := timodel.ActionType(i)
+		if tp.String() == "none" {
+			continue
+		}
+		_, ok := tidbsql.DDLActionClasses[tp]
+		require.True(t, ok, "action type %d (%s) is not classified", tp, tp)
+	}
+}
+
+func TestGetDDLHandling(t *testing.T) {
+	for tp, class := range tidbsql.DDLActionClasses {
+		expected := map[tidbsql.DDLActionClass]tidbsql.DDLHandling{
+			tidbsql.DDLActionTranslatable: tidbsql.DDLHandlingApply,
+			tidbsql.DDLActionNoop:         tidbsql.DDLHandlingSkip,
+			tidbsql.DDLActionMustPause:    tidbsql.DDLHandlingPause,
+		}[class]
+		// the policy only applies on unknown action types
+		for _, policy := range []tidbsql.UnknownDDLPolicy{tidbsql.UnknownDDLPause, tidbsql.UnknownDDLSkip, tidbsql.UnknownDDLError} {
+			require.Equal(t, expected, tidbsql.GetDDLHandling(tidbsql.DDLActionClasses, tp, policy), "action type %s", tp)
+		}
+	}
+
+	unknown := timodel.ActionType(255)
+	require.Equal(t, tidbsql.DDLHandlingPause, tidbsql.GetDDLHandling(tidbsql.DDLActionClasses, unknown, tidbsql.UnknownDDLPause))
+	require.Equal(t, tidbsql.DDLHandlingSkip, tidbsql.GetDDLHandling(tidbsql.DDLActionClasses, unknown, tidbsql.UnknownDDLSkip))
+	require.Equal(t, tidbsql.DDLHandlingError, tidbsql.GetDDLHandling(tidbsql.DDLActionClasses, unknown, tidbsql.UnknownDDLError))
+
+	_, err := tidbsql.ParseUnknownDDLPolicy("ignore")
+	require.Error(t, err)
+	policy, err := tidbsql.ParseUnknownDDLPolicy("Skip")
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.UnknownDDLSkip, policy)
+}
+
+func TestDDLStatements(t *testing.T) {
+	for _, statement := range ddltest.Statements() {
+		_, err := parser.New().ParseOneStmt(statement.Query, "", "")
+		require.NoError(t, err, statement.Query)
+	}
+	require.Equal(t, ddltest.DefaultHandlings(nil), ddltest.Handlings(tidbsql.DDLActionClasses))
+}
+
+func TestWithDDLActionClasses(t *testing.T) {
+	classes := tidbsql.WithDDLActionClasses(map[timodel.ActionType]tidbsql.DDLActionClass{
+		timodel.ActionDropSchema: tidbsql.DDLActionMustPause,
+	})
+	require.Equal(t, ddltest.DefaultHandlings(map[string]tidbsql.DDLHandling{
+		"DROP DATABASE test": tidbsql.DDLHandlingPause,
+	}), ddltest.Handlings(classes))
+	// the default classes are not changed
+	require.Equal(t, tidbsql.DDLActionTranslatable, tidbsql.DDLActionClasses[timodel.ActionDropSchema])
+}
+
+func TestUnsupportedDDL(t *testing.T) {
+	err := tidbsql.NewUnsupportedDDLError("Received modify column ddl of column %s, which is not supported", "c")
+	require.EqualError(t, err, "Received modify column ddl of column c, which is not supported")
+	// the error is still found once wrapped by the connector and the categories
+	require.True(t, tidbsql.IsUnsupportedDDL(errors.Annotate(diag.Schema(errors.Trace(err)), "Failed to change column c")))
+	require.False(t, tidbsql.IsUnsupportedDDL(errors.New("connection reset by peer")))
+
+	_, err = tidbsql.ParseUnsupportedDDLPolicy("ignore")
+	require.Error(t, err)
+	policy, err := tidbsql.ParseUnsupportedDDLPolicy("Pause")
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.UnsupportedDDLPause, policy)
+}
@@ -0,0 +1,37 @@
+package tidbsql
+
+import (
+	"strings"
+)
+
+// AppliedDDLErrors are the messages of the errors of a data warehouse telling the effect of a column DDL is already
+// present. A DDL rewritten into multiple statements is not atomic in most data warehouses, so the statements
+// executed before a failure are executed again by the retry.
+type AppliedDDLErrors struct {
+	// ColumnExists are the messages of adding a column which exists
+	ColumnExists []string
+	// ColumnMissing are the messages of dropping or renaming a column which does not exist
+	ColumnMissing []string
+}
+
+// IsApplied tells whether the DDL failed with err because its effect is already present, e.g. the column added
+// exists. Only adding, dropping and renaming a column are recognized, the other DDLs are not skipped.
+func (e AppliedDDLErrors) IsApplied(ddl string, err error) bool {
+	if err == nil {
+		return false
+	}
+	var messages []string
+	switch ddl := strings.ToUpper(ddl); {
+	case strings.Contains(ddl, " ADD COLUMN "):
+		messages = e.ColumnExists
+	case strings.Contains(ddl, " DROP COLUMN "), strings.Contains(ddl, " RENAME COLUMN "):
+		messages = e.ColumnMissing
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range messages {
+		if strings.Contains(msg, strings.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package tidbsql_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppliedDDLErrors(t *testing.T) {
+	applied := tidbsql.AppliedDDLErrors{ColumnExists: []string{"already exists"}, ColumnMissing: []string{"does not exist"}}
+	exists := errors.New(`pq: column "c" of relation "t" already exists`)
+	missing := errors.New(`pq: column "c" of relation "t" does not exist`)
+
+	require.True(t, applied.IsApplied(`ALTER TABLE "t" ADD COLUMN "c" INT`, exists))
+	require.False(t, applied.IsApplied(`ALTER TABLE "t" ADD COLUMN "c" INT`, missing))
+	require.True(t, applied.IsApplied(`alter table "t" drop column "c"`, missing))
+	require.True(t, applied.IsApplied(`ALTER TABLE "t" RENAME COLUMN "c" TO "d"`, missing))
+	require.False(t, applied.IsApplied(`ALTER TABLE "t" RENAME TO "u"`, exists))
+	require.False(t, applied.IsApplied(`ALTER TABLE "t" ADD COLUMN "c" INT`, nil))
+}
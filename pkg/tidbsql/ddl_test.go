@@ -1,6 +1,7 @@
 package tidbsql_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
@@ -86,3 +87,44 @@ func TestGetColumnDiff(t *testing.T) {
 	require.NoError(t, err)
 	require.ElementsMatch(t, expected, columnDiff)
 }
+
+func TestGetColumnDiffWithoutColumnIDs(t *testing.T) {
+	// the changefeed is not created with output-column-id, the columns are paired by name
+	prev := []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "name", Tp: "VARCHAR", Precision: "64"},
+		{Name: "age", Tp: "INT"},
+	}
+	curr := []cloudstorage.TableCol{
+		{Name: "id", Tp: "INT", IsPK: "true"},
+		{Name: "name", Tp: "VARCHAR", Precision: "128"},
+		{Name: "birth", Tp: "DATE"},
+	}
+	expected := []tidbsql.ColumnDiff{
+		{Action: tidbsql.UNCHANGE, Before: &prev[0], After: &curr[0]},
+		{Action: tidbsql.MODIFY_COLUMN, Before: &prev[1], After: &curr[1]},
+		{Action: tidbsql.DROP_COLUMN, Before: &prev[2]},
+		{Action: tidbsql.ADD_COLUMN, After: &curr[2]},
+	}
+	columnDiff, err := tidbsql.GetColumnDiff(prev, curr)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, columnDiff)
+}
+
+func TestDefaultLiteral(t *testing.T) {
+	quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+	for _, c := range []struct {
+		column   cloudstorage.TableCol
+		expected string
+	}{
+		{cloudstorage.TableCol{Tp: "int", Default: "1"}, "1"},
+		{cloudstorage.TableCol{Tp: "BIGINT UNSIGNED", Default: 0}, "0"},
+		{cloudstorage.TableCol{Tp: "decimal", Default: "-1.50"}, "-1.50"},
+		// the type decides the quoting, not the value
+		{cloudstorage.TableCol{Tp: "varchar", Default: "007"}, "'007'"},
+		{cloudstorage.TableCol{Tp: "varchar", Default: "it's"}, "'it''s'"},
+		{cloudstorage.TableCol{Tp: "int", Default: "1; DROP TABLE t"}, "'1; DROP TABLE t'"},
+	} {
+		require.Equal(t, c.expected, tidbsql.DefaultLiteral(c.column, quote), c.column.Default)
+	}
+}
@@ -0,0 +1,94 @@
+// Package ddltest provides the schema changes of a table shared by the DDL tests of the data warehouses, so that
+// every data warehouse is tested against the same diffs of tidbsql.GetColumnDiff.
+package ddltest
+
+import (
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Change is a DDL of table `t` given as its columns before the DDL and its schema file after the DDL
+type Change struct {
+	Name        string
+	PrevColumns []cloudstorage.TableCol
+	TableDef    cloudstorage.TableDefinition
+}
+
+// Table returns the columns of table `t` before every change
+func Table() []cloudstorage.TableCol {
+	return []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "bigint", Nullable: "false", IsPK: "true"},
+		{ID: "2", Name: "name", Tp: "varchar", Precision: "20"},
+		{ID: "3", Name: "age", Tp: "int"},
+	}
+}
+
+// Changes returns the changes of table `t`, named by the DDL
+func Changes() []Change {
+	change := func(name string, tp timodel.ActionType, query string, modify func(columns []cloudstorage.TableCol) []cloudstorage.TableCol) Change {
+		return Change{
+			Name:        name,
+			PrevColumns: Table(),
+			TableDef: cloudstorage.TableDefinition{
+				Table:   "t",
+				Schema:  "test",
+				Type:    tp,
+				Query:   query,
+				Columns: modify(Table()),
+			},
+		}
+	}
+	unchanged := func(columns []cloudstorage.TableCol) []cloudstorage.TableCol { return columns }
+	return []Change{
+		change("add column", timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN email VARCHAR(64)",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "email", Tp: "varchar", Precision: "64"})
+			}),
+		change("add not null column", timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN score INT NOT NULL",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "score", Tp: "int", Nullable: "false"})
+			}),
+		change("add column with default", timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN level INT NOT NULL DEFAULT 1",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "level", Tp: "int", Nullable: "false", Default: "1"})
+			}),
+		change("add column with quoted default", timodel.ActionAddColumn, `ALTER TABLE t ADD COLUMN note VARCHAR(20) NOT NULL DEFAULT 'it''s \\ ok'`,
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "note", Tp: "varchar", Precision: "20", Nullable: "false", Default: `it's \ ok`})
+			}),
+		// the default is a string of the VARCHAR, not the number 7
+		change("add column with numeric string default", timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN code VARCHAR(3) NOT NULL DEFAULT '007'",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "code", Tp: "varchar", Precision: "3", Nullable: "false", Default: "007"})
+			}),
+		change("add not null date column", timodel.ActionAddColumn, "ALTER TABLE t ADD COLUMN born DATE NOT NULL",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				return append(columns, cloudstorage.TableCol{ID: "4", Name: "born", Tp: "date", Nullable: "false"})
+			}),
+		change("drop column", timodel.ActionDropColumn, "ALTER TABLE t DROP COLUMN age",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol { return columns[:2] }),
+		change("rename column", timodel.ActionModifyColumn, "ALTER TABLE t RENAME COLUMN name TO nickname",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				columns[1].Name = "nickname"
+				return columns
+			}),
+		change("widen varchar", timodel.ActionModifyColumn, "ALTER TABLE t MODIFY COLUMN name VARCHAR(100)",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				columns[1].Precision = "100"
+				return columns
+			}),
+		change("int to bigint", timodel.ActionModifyColumn, "ALTER TABLE t MODIFY COLUMN age BIGINT",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				// TiDB recreates a column whose type is changed, so it gets a new ID
+				columns[2] = cloudstorage.TableCol{ID: "4", Name: "age", Tp: "bigint"}
+				return columns
+			}),
+		change("varchar to int", timodel.ActionModifyColumn, "ALTER TABLE t MODIFY COLUMN name INT",
+			func(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
+				columns[1] = cloudstorage.TableCol{ID: "4", Name: "name", Tp: "int"}
+				return columns
+			}),
+		change("truncate table", timodel.ActionTruncateTable, "TRUNCATE TABLE t", unchanged),
+		change("drop table", timodel.ActionDropTable, "DROP TABLE t", unchanged),
+	}
+}
@@ -0,0 +1,52 @@
+package ddltest
+
+import (
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+)
+
+// Statement is a DDL of TiDB on table `t` with the action type TiDB runs it as, and how it is handled by
+// tidbsql.DDLActionClasses
+type Statement struct {
+	Query    string
+	Type     timodel.ActionType
+	Handling tidbsql.DDLHandling
+}
+
+// Statements returns the DDLs of table `t` of every class
+func Statements() []Statement {
+	return []Statement{
+		{"ALTER TABLE t ADD COLUMN email VARCHAR(64)", timodel.ActionAddColumn, tidbsql.DDLHandlingApply},
+		{"ALTER TABLE t ALTER COLUMN age SET DEFAULT 18", timodel.ActionSetDefaultValue, tidbsql.DDLHandlingApply},
+		{"ALTER TABLE t COMMENT = 'the users'", timodel.ActionModifyTableComment, tidbsql.DDLHandlingApply},
+		{"TRUNCATE TABLE t", timodel.ActionTruncateTable, tidbsql.DDLHandlingApply},
+		{"DROP DATABASE test", timodel.ActionDropSchema, tidbsql.DDLHandlingApply},
+		{"ALTER TABLE t ADD INDEX idx_name (name)", timodel.ActionAddIndex, tidbsql.DDLHandlingSkip},
+		{"ALTER TABLE t SET TIFLASH REPLICA 1", timodel.ActionSetTiFlashReplica, tidbsql.DDLHandlingSkip},
+		{"ALTER TABLE t ADD PRIMARY KEY (id)", timodel.ActionAddPrimaryKey, tidbsql.DDLHandlingPause},
+		{"ALTER TABLE t TRUNCATE PARTITION p0", timodel.ActionTruncateTablePartition, tidbsql.DDLHandlingPause},
+		{"ALTER TABLE t EXCHANGE PARTITION p0 WITH TABLE t0", timodel.ActionExchangeTablePartition, tidbsql.DDLHandlingPause},
+	}
+}
+
+// Handlings returns how the statements are handled by the classes of a data warehouse, by their queries
+func Handlings(classes map[timodel.ActionType]tidbsql.DDLActionClass) map[string]tidbsql.DDLHandling {
+	handlings := make(map[string]tidbsql.DDLHandling)
+	for _, statement := range Statements() {
+		handlings[statement.Query] = tidbsql.GetDDLHandling(classes, statement.Type, tidbsql.UnknownDDLError)
+	}
+	return handlings
+}
+
+// DefaultHandlings returns how the statements are handled by tidbsql.DDLActionClasses, by their queries, with the
+// handlings of the queries overridden
+func DefaultHandlings(overrides map[string]tidbsql.DDLHandling) map[string]tidbsql.DDLHandling {
+	handlings := make(map[string]tidbsql.DDLHandling)
+	for _, statement := range Statements() {
+		handlings[statement.Query] = statement.Handling
+	}
+	for query, handling := range overrides {
+		handlings[query] = handling
+	}
+	return handlings
+}
@@ -0,0 +1,180 @@
+package tidbsql
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// ErrWarehouseTableNotFound is returned by the connectors reading the columns of a table missing in the data warehouse
+var ErrWarehouseTableNotFound = errors.New("table is not found in the data warehouse")
+
+// WarehouseColumn is a column of the table in the data warehouse
+type WarehouseColumn struct {
+	Name string
+	// Type is the type normalized by the data warehouse, so that the synonyms are equal, e.g. NUMBER(38,0) for BIGINT
+	Type     string
+	Nullable bool
+}
+
+// SchemaDrift is the difference of the table in the data warehouse from the columns expected by the replication,
+// e.g. the table is altered by hand
+type SchemaDrift struct {
+	// Columns are the columns in the data warehouse as TiDB columns, a column drifted in type has the type of the
+	// data warehouse. They are the previous columns of the DDLs reconciling the table.
+	Columns []cloudstorage.TableCol
+	// Expected are the columns expected by the replication
+	Expected []cloudstorage.TableCol
+	// Diffs are the changes from Columns to Expected, sorted by column
+	Diffs []ColumnDiff
+	// expectedTypes are the normalized types of the expected columns in the data warehouse by name
+	expectedTypes map[string]string
+}
+
+// Empty returns whether the table in the data warehouse has the expected columns
+func (d *SchemaDrift) Empty() bool {
+	return d == nil || len(d.Diffs) == 0
+}
+
+// String describes the columns drifted, a line per column
+func (d *SchemaDrift) String() string {
+	if d.Empty() {
+		return "no drift"
+	}
+	lines := make([]string, 0, len(d.Diffs))
+	for _, diff := range d.Diffs {
+		switch diff.Action {
+		case ADD_COLUMN:
+			lines = append(lines, fmt.Sprintf("column %s: missing in the data warehouse, expected %s", diff.After.Name, d.expectedTypes[diff.After.Name]))
+		case DROP_COLUMN:
+			lines = append(lines, fmt.Sprintf("column %s: %s in the data warehouse, not expected", diff.Before.Name, diff.Before.Tp))
+		case MODIFY_COLUMN:
+			if diff.Before.Tp != diff.After.Tp {
+				lines = append(lines, fmt.Sprintf("column %s: %s in the data warehouse, expected %s", diff.After.Name, diff.Before.Tp, d.expectedTypes[diff.After.Name]))
+			}
+			if diff.Before.Nullable != diff.After.Nullable {
+				lines = append(lines, fmt.Sprintf("column %s: %s in the data warehouse, expected %s", diff.After.Name, nullability(diff.Before.Nullable), nullability(diff.After.Nullable)))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func nullability(nullable string) string {
+	if nullable == "false" {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+// GetSchemaDrift compares the columns of the table in the data warehouse with the expected columns by GetColumnDiff.
+// typeOf returns the type of the expected column in the data warehouse normalized as WarehouseColumn.Type, empty if
+// the type is not compared. A type without parameters matches the type with any parameters, e.g. VARCHAR matches
+// VARCHAR(255), as the increment schema files may not have them. The columns are matched by name case-insensitively
+// and the defaults are ignored.
+func GetSchemaDrift(expected []cloudstorage.TableCol, actual []WarehouseColumn, typeOf func(cloudstorage.TableCol) (string, error)) (*SchemaDrift, error) {
+	drift := &SchemaDrift{
+		Columns:       make([]cloudstorage.TableCol, 0, len(actual)),
+		Expected:      make([]cloudstorage.TableCol, 0, len(expected)),
+		expectedTypes: make(map[string]string, len(expected)),
+	}
+	matched := make([]bool, len(actual))
+	for i, column := range expected {
+		if column.ID == "" {
+			// the columns are paired by ID
+			column.ID = fmt.Sprintf("expected-%d", i)
+		}
+		drift.Expected = append(drift.Expected, column)
+		tp, err := typeOf(column)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		drift.expectedTypes[column.Name] = tp
+		if tp == "" {
+			drift.expectedTypes[column.Name] = column.Tp
+		}
+		idx := slices.IndexFunc(actual, func(c WarehouseColumn) bool { return strings.EqualFold(c.Name, column.Name) })
+		if idx < 0 {
+			continue
+		}
+		matched[idx] = true
+		prev := column
+		if tp != "" && !typeMatches(tp, actual[idx].Type) {
+			prev.Tp = actual[idx].Type
+		}
+		if actual[idx].Nullable != (column.Nullable != "false") {
+			prev.Nullable = "false"
+			if actual[idx].Nullable {
+				prev.Nullable = "true"
+			}
+		}
+		drift.Columns = append(drift.Columns, prev)
+	}
+	for i, column := range actual {
+		if !matched[i] {
+			drift.Columns = append(drift.Columns, cloudstorage.TableCol{ID: "warehouse-" + column.Name, Name: column.Name, Tp: column.Type})
+		}
+	}
+	diffs, err := GetColumnDiff(drift.Columns, drift.Expected)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, diff := range diffs {
+		if diff.Action != UNCHANGE {
+			drift.Diffs = append(drift.Diffs, diff)
+		}
+	}
+	slices.SortFunc(drift.Diffs, func(x, y ColumnDiff) int {
+		return strings.Compare(strings.ToLower(diffColumnName(x)), strings.ToLower(diffColumnName(y)))
+	})
+	return drift, nil
+}
+
+func diffColumnName(diff ColumnDiff) string {
+	if diff.After != nil {
+		return diff.After.Name
+	}
+	return diff.Before.Name
+}
+
+// typeMatches returns whether the normalized type in the data warehouse is the expected one
+func typeMatches(expected, actual string) bool {
+	if strings.EqualFold(expected, actual) {
+		return true
+	}
+	return !strings.Contains(expected, "(") && strings.EqualFold(expected, baseType(actual))
+}
+
+// baseType returns the type without its parameters, e.g. VARCHAR for VARCHAR(255)
+func baseType(tp string) string {
+	if idx := strings.Index(tp, "("); idx >= 0 {
+		return strings.TrimSpace(tp[:idx])
+	}
+	return tp
+}
+
+// SplitType splits the type into its name in upper case and its parameters, e.g. NUMBER and [10 2] for number(10, 2)
+func SplitType(tp string) (string, []string) {
+	tp = strings.ToUpper(strings.TrimSpace(tp))
+	start := strings.Index(tp, "(")
+	if start < 0 {
+		return strings.Join(strings.Fields(tp), " "), nil
+	}
+	name := strings.Join(strings.Fields(tp[:start]), " ")
+	end := strings.LastIndex(tp, ")")
+	if end < start {
+		end = len(tp)
+	}
+	params := strings.Split(tp[start+1:end], ",")
+	for i := range params {
+		params[i] = strings.TrimSpace(params[i])
+	}
+	// e.g. TIMESTAMP(6) WITHOUT TIME ZONE
+	if suffix := strings.Join(strings.Fields(tp[min(end+1, len(tp)):]), " "); suffix != "" {
+		name += " " + suffix
+	}
+	return name, params
+}
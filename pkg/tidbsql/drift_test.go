@@ -0,0 +1,48 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitType(t *testing.T) {
+	name, params := tidbsql.SplitType("number(10, 2)")
+	require.Equal(t, "NUMBER", name)
+	require.Equal(t, []string{"10", "2"}, params)
+	name, params = tidbsql.SplitType("timestamp(6)  without time zone")
+	require.Equal(t, "TIMESTAMP WITHOUT TIME ZONE", name)
+	require.Equal(t, []string{"6"}, params)
+	name, params = tidbsql.SplitType("double  precision")
+	require.Equal(t, "DOUBLE PRECISION", name)
+	require.Nil(t, params)
+}
+
+func TestGetSchemaDrift(t *testing.T) {
+	typeOf := func(column cloudstorage.TableCol) (string, error) { return column.Tp, nil }
+	expected := []cloudstorage.TableCol{
+		{ID: "1", Name: "id", Tp: "INT", Nullable: "false"},
+		{ID: "2", Name: "v", Tp: "VARCHAR"},
+		{ID: "3", Name: "j", Tp: ""},
+	}
+	// the names are matched case-insensitively, a type without parameters matches any and an empty type is not compared
+	drift, err := tidbsql.GetSchemaDrift(expected, []tidbsql.WarehouseColumn{
+		{Name: "ID", Type: "INT"}, {Name: "V", Type: "VARCHAR(10)", Nullable: true}, {Name: "J", Type: "JSONB", Nullable: true},
+	}, typeOf)
+	require.NoError(t, err)
+	require.True(t, drift.Empty())
+	require.Equal(t, "no drift", drift.String())
+
+	drift, err = tidbsql.GetSchemaDrift(expected, []tidbsql.WarehouseColumn{
+		{Name: "id", Type: "BIGINT", Nullable: true}, {Name: "j", Type: "JSONB", Nullable: true}, {Name: "x", Type: "INT", Nullable: true},
+	}, typeOf)
+	require.NoError(t, err)
+	require.Equal(t, "column id: BIGINT in the data warehouse, expected INT\n"+
+		"column id: NULL in the data warehouse, expected NOT NULL\n"+
+		"column v: missing in the data warehouse, expected VARCHAR\n"+
+		"column x: INT in the data warehouse, not expected", drift.String())
+	require.Len(t, drift.Diffs, 3)
+	require.Equal(t, expected, drift.Expected)
+}
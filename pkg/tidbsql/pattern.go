@@ -0,0 +1,80 @@
+package tidbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+)
+
+// TablePattern matches the tables by a glob of the database and a glob of the table name, e.g. mydb.events_*,
+// which is also a rule of the table filter of TiCDC
+type TablePattern struct {
+	Database string
+	Table    string
+}
+
+// ParseTablePattern parses <db glob>.<table glob>, the globs are of path.Match
+func ParseTablePattern(s string) (TablePattern, error) {
+	database, table, ok := strings.Cut(strings.TrimSpace(s), ".")
+	if !ok || database == "" || table == "" || strings.Contains(table, ".") {
+		return TablePattern{}, errors.Errorf("invalid table pattern %s, expected <db>.<table> with wildcards, e.g. mydb.events_*", s)
+	}
+	for _, glob := range []string{database, table} {
+		if _, err := path.Match(glob, ""); err != nil {
+			return TablePattern{}, errors.Annotatef(err, "invalid table pattern %s", s)
+		}
+	}
+	return TablePattern{Database: database, Table: table}, nil
+}
+
+func (p TablePattern) String() string {
+	return fmt.Sprintf("%s.%s", p.Database, p.Table)
+}
+
+// Match tells whether the table in <db>.<table> matches the pattern
+func (p TablePattern) Match(tableFQN string) bool {
+	database, table, ok := strings.Cut(tableFQN, ".")
+	if !ok {
+		return false
+	}
+	// the patterns are checked by ParseTablePattern
+	dbMatched, _ := path.Match(p.Database, database)
+	tableMatched, _ := path.Match(p.Table, table)
+	return dbMatched && tableMatched
+}
+
+// MatchAnyTablePattern tells whether the table matches one of the patterns
+func MatchAnyTablePattern(patterns []TablePattern, tableFQN string) bool {
+	for _, p := range patterns {
+		if p.Match(tableFQN) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTiDBTablesMatching returns the base tables of TiDB matching one of the patterns in <db>.<table>, in the
+// order of the name. The views and the system tables are excluded.
+func GetTiDBTablesMatching(db *sql.DB, patterns []TablePattern) ([]string, error) {
+	rows, err := db.Query("SELECT TABLE_SCHEMA, TABLE_NAME FROM information_schema.tables WHERE TABLE_TYPE = 'BASE TABLE' " +
+		"AND TABLE_SCHEMA NOT IN ('mysql', 'INFORMATION_SCHEMA', 'PERFORMANCE_SCHEMA', 'METRICS_SCHEMA') ORDER BY TABLE_SCHEMA, TABLE_NAME")
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	defer rows.Close()
+	tables := make([]string, 0)
+	for rows.Next() {
+		var database, table string
+		if err = rows.Scan(&database, &table); err != nil {
+			return nil, diag.Source(errors.Trace(err))
+		}
+		if tableFQN := fmt.Sprintf("%s.%s", database, table); MatchAnyTablePattern(patterns, tableFQN) {
+			tables = append(tables, tableFQN)
+		}
+	}
+	return tables, diag.Source(errors.Trace(rows.Err()))
+}
@@ -0,0 +1,34 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTablePattern(t *testing.T) {
+	p, err := tidbsql.ParseTablePattern(" mydb.events_* ")
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.TablePattern{Database: "mydb", Table: "events_*"}, p)
+	require.Equal(t, "mydb.events_*", p.String())
+	require.True(t, p.Match("mydb.events_202410"))
+	require.False(t, p.Match("mydb.orders"))
+	require.False(t, p.Match("otherdb.events_202410"))
+	require.False(t, p.Match("mydb"))
+
+	p, err = tidbsql.ParseTablePattern("shard_?.t[0-9]")
+	require.NoError(t, err)
+	require.True(t, p.Match("shard_1.t2"))
+	require.False(t, p.Match("shard_10.t2"))
+
+	for _, s := range []string{"mydb", "mydb.", ".t", "a.b.c", "mydb.[events"} {
+		_, err = tidbsql.ParseTablePattern(s)
+		require.Error(t, err, s)
+	}
+
+	patterns := []tidbsql.TablePattern{{Database: "a", Table: "x_*"}, {Database: "b", Table: "*"}}
+	require.True(t, tidbsql.MatchAnyTablePattern(patterns, "a.x_1"))
+	require.True(t, tidbsql.MatchAnyTablePattern(patterns, "b.y"))
+	require.False(t, tidbsql.MatchAnyTablePattern(patterns, "a.y"))
+}
@@ -0,0 +1,99 @@
+package tidbsql
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser"
+	"github.com/pingcap/tidb/parser/ast"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// RenamePolicy is how a renamed table is handled
+type RenamePolicy string
+
+const (
+	// RenameFollow renames the table in the data warehouse and replicates the table by its new name,
+	// which is the default
+	RenameFollow RenamePolicy = "follow"
+	// RenameError fails the replication of the table
+	RenameError RenamePolicy = "error"
+)
+
+func ParseRenamePolicy(s string) (RenamePolicy, error) {
+	switch policy := RenamePolicy(strings.ToLower(s)); policy {
+	case RenameFollow, RenameError:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown rename policy %s, expected one of follow, error", s)
+	}
+}
+
+// IsRenameTable tells whether the DDL renames a table
+func IsRenameTable(tp timodel.ActionType) bool {
+	return tp == timodel.ActionRenameTable || tp == timodel.ActionRenameTables
+}
+
+// GetRenamedFrom returns the database and the name of the table before the rename DDL, the table
+// definition is of the table after it. Both RENAME TABLE and ALTER TABLE ... RENAME TO are parsed.
+func GetRenamedFrom(tableDef cloudstorage.TableDefinition) (string, string, error) {
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		return "", "", errors.Annotatef(err, "Failed to parse rename DDL %s", tableDef.Query)
+	}
+	qualify := func(name *ast.TableName) (string, string) {
+		if name.Schema.O == "" {
+			return tableDef.Schema, name.Name.O
+		}
+		return name.Schema.O, name.Name.O
+	}
+	isRenamed := func(name *ast.TableName) bool {
+		schema, table := qualify(name)
+		return schema == tableDef.Schema && table == tableDef.Table
+	}
+	switch stmt := stmt.(type) {
+	case *ast.RenameTableStmt:
+		for _, t2t := range stmt.TableToTables {
+			if isRenamed(t2t.NewTable) {
+				schema, table := qualify(t2t.OldTable)
+				return schema, table, nil
+			}
+		}
+	case *ast.AlterTableStmt:
+		for _, spec := range stmt.Specs {
+			if spec.Tp == ast.AlterTableRenameTable && isRenamed(spec.NewTable) {
+				schema, table := qualify(stmt.Table)
+				return schema, table, nil
+			}
+		}
+	}
+	return "", "", errors.Errorf("DDL %s does not rename table %s.%s", tableDef.Query, tableDef.Schema, tableDef.Table)
+}
+
+// IsRenameColumn tells whether the DDL renames a column, by RENAME COLUMN or by CHANGE COLUMN to another name, which
+// TiDB executes as modifying the column
+func IsRenameColumn(tableDef cloudstorage.TableDefinition) bool {
+	if tableDef.Type != timodel.ActionModifyColumn {
+		return false
+	}
+	stmt, err := parser.New().ParseOneStmt(tableDef.Query, "", "")
+	if err != nil {
+		return false
+	}
+	alter, ok := stmt.(*ast.AlterTableStmt)
+	if !ok {
+		return false
+	}
+	for _, spec := range alter.Specs {
+		switch spec.Tp {
+		case ast.AlterTableRenameColumn:
+			return spec.OldColumnName.Name.L != spec.NewColumnName.Name.L
+		case ast.AlterTableChangeColumn:
+			if len(spec.NewColumns) > 0 && spec.OldColumnName.Name.L != spec.NewColumns[0].Name.Name.L {
+				return true
+			}
+		}
+	}
+	return false
+}
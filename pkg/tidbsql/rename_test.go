@@ -0,0 +1,56 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRenamedFrom(t *testing.T) {
+	cases := []struct {
+		query  string
+		schema string
+		table  string
+	}{
+		{"RENAME TABLE `test`.`old` TO `test`.`new`", "test", "old"},
+		{"RENAME TABLE old TO new", "test", "old"},
+		{"RENAME TABLE `other`.`old` TO `test`.`new`", "other", "old"},
+		{"RENAME TABLE a TO b, old TO new", "test", "old"},
+		{"ALTER TABLE old RENAME TO new", "test", "old"},
+	}
+	for _, c := range cases {
+		tableDef := cloudstorage.TableDefinition{Schema: "test", Table: "new", Query: c.query}
+		schema, table, err := tidbsql.GetRenamedFrom(tableDef)
+		require.NoError(t, err, c.query)
+		require.Equal(t, c.schema, schema, c.query)
+		require.Equal(t, c.table, table, c.query)
+	}
+
+	_, _, err := tidbsql.GetRenamedFrom(cloudstorage.TableDefinition{Schema: "test", Table: "new", Query: "RENAME TABLE a TO b"})
+	require.ErrorContains(t, err, "does not rename table test.new")
+
+	policy, err := tidbsql.ParseRenamePolicy("Follow")
+	require.NoError(t, err)
+	require.Equal(t, tidbsql.RenameFollow, policy)
+	_, err = tidbsql.ParseRenamePolicy("ignore")
+	require.Error(t, err)
+}
+
+func TestIsRenameColumn(t *testing.T) {
+	cases := map[string]bool{
+		"ALTER TABLE `t` RENAME COLUMN `name` TO `full_name`":           true,
+		"ALTER TABLE `t` CHANGE COLUMN `name` `full_name` VARCHAR(255)": true,
+		"ALTER TABLE `t` CHANGE COLUMN `name` `name` VARCHAR(255)":      false,
+		"ALTER TABLE `t` MODIFY COLUMN `name` VARCHAR(255)":             false,
+		"ALTER TABLE `t` RENAME COLUMN `name` TO `NAME`":                false,
+	}
+	for query, expected := range cases {
+		tableDef := cloudstorage.TableDefinition{Schema: "db", Table: "t", Type: timodel.ActionModifyColumn, Query: query}
+		require.Equal(t, expected, tidbsql.IsRenameColumn(tableDef), query)
+	}
+	tableDef := cloudstorage.TableDefinition{Schema: "db", Table: "t", Type: timodel.ActionAddColumn, Query: "ALTER TABLE `t` ADD COLUMN `c` INT"}
+	require.False(t, tidbsql.IsRenameColumn(tableDef))
+}
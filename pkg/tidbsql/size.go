@@ -0,0 +1,22 @@
+package tidbsql
+
+import (
+	"database/sql"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+)
+
+// GetTiDBTableSize returns the bytes of the data of the table estimated by the statistics of TiDB, 0 if the table
+// is not analyzed
+func GetTiDBTableSize(db *sql.DB, tableFQN string) (int64, error) {
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	var size int64
+	err := db.QueryRow("SELECT COALESCE(DATA_LENGTH, 0) FROM information_schema.tables WHERE table_schema = ? AND table_name = ?",
+		sourceDatabase, sourceTable).Scan(&size)
+	if err != nil {
+		return 0, diag.Source(errors.Annotatef(err, "Failed to get the size of table %s", tableFQN))
+	}
+	return size, nil
+}
@@ -1,6 +1,10 @@
 package tidbsql
 
 import (
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
@@ -9,15 +13,60 @@ import (
 func GetCurrentTSO(config *TiDBConfig) (uint64, error) {
 	db, err := config.OpenDB()
 	if err != nil {
-		return 0, errors.Trace(err)
+		return 0, diag.Source(errors.Trace(err))
 	}
 	defer db.Close()
 	row := db.QueryRow("SELECT @@tidb_current_ts")
 	var tso uint64
 	err = row.Scan(&tso)
 	if err != nil {
-		return 0, errors.Annotate(err, "failed to get current tso")
+		return 0, diag.Source(errors.Annotate(err, "failed to get current tso"))
 	}
 	log.Info("Successfully get current tso", zap.Uint64("tso", tso))
 	return tso, nil
 }
+
+// gcTimeFormat is the format of tikv_gc_safe_point in mysql.tidb
+const gcTimeFormat = "20060102-15:04:05.999 -0700"
+
+// ParseGCSafePoint parses the value of tikv_gc_safe_point, e.g. `20240102-03:04:05.678 +0800`
+func ParseGCSafePoint(value string) (time.Time, error) {
+	t, err := time.Parse(gcTimeFormat, value)
+	if err != nil {
+		return time.Time{}, errors.Annotatef(err, "invalid GC safe point %q", value)
+	}
+	return t, nil
+}
+
+// CheckTSOAfterGCSafePoint returns an error if the data at the TSO may have been garbage collected,
+// i.e. the TSO is not after the GC safe point of the cluster
+func CheckTSOAfterGCSafePoint(tso uint64, safePoint time.Time) error {
+	if physical := utils.TSOPhysicalTime(tso); !physical.After(safePoint) {
+		return errors.Errorf("TSO %d (%s) is not after the GC safe point %s, the data may have been garbage collected",
+			tso, physical.UTC().Format(time.RFC3339Nano), safePoint.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// CheckGCSafePoint queries the GC safe point of the cluster and checks the TSO is after it
+func CheckGCSafePoint(config *TiDBConfig, tso uint64) error {
+	db, err := config.OpenDB()
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	defer db.Close()
+	var value string
+	row := db.QueryRow("SELECT VARIABLE_VALUE FROM mysql.tidb WHERE VARIABLE_NAME = 'tikv_gc_safe_point'")
+	if err = row.Scan(&value); err != nil {
+		return diag.Source(errors.Annotate(err, "failed to get GC safe point"))
+	}
+	safePoint, err := ParseGCSafePoint(value)
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	if err = CheckTSOAfterGCSafePoint(tso, safePoint); err != nil {
+		return diag.Source(err)
+	}
+	log.Info("TSO is after GC safe point", zap.Uint64("tso", tso), zap.Time("gcSafePoint", safePoint))
+	return nil
+}
@@ -0,0 +1,24 @@
+package tidbsql_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTSOAfterGCSafePoint(t *testing.T) {
+	safePoint, err := tidbsql.ParseGCSafePoint("20240102-03:04:05.678 +0800")
+	require.NoError(t, err)
+	require.True(t, safePoint.Equal(time.Date(2024, 1, 1, 19, 4, 5, 678000000, time.UTC)))
+	_, err = tidbsql.ParseGCSafePoint("2024-01-02 03:04:05")
+	require.Error(t, err)
+
+	tsoAt := func(t time.Time) uint64 { return uint64(t.UnixMilli())<<18 | 7 }
+	require.True(t, utils.TSOPhysicalTime(tsoAt(safePoint)).Equal(safePoint))
+	require.NoError(t, tidbsql.CheckTSOAfterGCSafePoint(tsoAt(safePoint.Add(time.Second)), safePoint))
+	require.Error(t, tidbsql.CheckTSOAfterGCSafePoint(tsoAt(safePoint), safePoint))
+	require.Error(t, tidbsql.CheckTSOAfterGCSafePoint(tsoAt(safePoint.Add(-time.Hour)), safePoint))
+}
@@ -0,0 +1,49 @@
+package tidbsql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// BitLength returns the number of bits of a BIT column, 0 if the column is not BIT. A BIT column without length
+// is BIT(1).
+func BitLength(column cloudstorage.TableCol) int {
+	if !strings.EqualFold(column.Tp, "bit") {
+		return 0
+	}
+	length, err := strconv.Atoi(column.Precision)
+	if err != nil || length < 1 {
+		return 1
+	}
+	return length
+}
+
+// BitBytes returns the number of bytes of the values of a BIT column of length bits
+func BitBytes(length int) int {
+	return (length + 7) / 8
+}
+
+// BitDumpExpr returns the expression a BIT column is dumped by. BIT(1) is dumped as 0 or 1 like the files of TiCDC,
+// which the data warehouses load as a boolean. A longer BIT is dumped as the hex of its bytes, which the data
+// warehouses load as binary, while TiCDC writes it as an unsigned integer and the merges convert it.
+func BitDumpExpr(name string, length int) string {
+	if length == 1 {
+		return fmt.Sprintf("CAST(%s AS UNSIGNED)", QuoteIdent(name))
+	}
+	return fmt.Sprintf("LPAD(HEX(%s), %d, '0')", QuoteIdent(name), 2*BitBytes(length))
+}
+
+// CheckDecimalPrecision fails if the DECIMAL column has more digits than the max precision of the data warehouse,
+// so that the table is not created with a type failing the loads
+func CheckDecimalPrecision(column cloudstorage.TableCol, maxPrecision int, warehouse string) error {
+	precision, err := strconv.Atoi(column.Precision)
+	if err != nil || precision <= maxPrecision {
+		return nil
+	}
+	return errors.Errorf("Column %s of DECIMAL(%s, %s) exceeds the max precision %d of %s, override its type by --column-mapping",
+		column.Name, column.Precision, column.Scale, maxPrecision, warehouse)
+}
@@ -0,0 +1,62 @@
+// Package typetest provides a column of every TiDB type shared by the type mapping tests of the data warehouses,
+// so that every data warehouse is tested against the same columns.
+package typetest
+
+import (
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// Columns returns a column of every TiDB type as TiCDC writes it into the schema files, named by the type,
+// e.g. c_decimal of DECIMAL(20, 6). The columns of the types with a length are given the length, the
+// fractional seconds of the time types are in the scale.
+func Columns() []cloudstorage.TableCol {
+	return []cloudstorage.TableCol{
+		{Name: "c_tinyint", Tp: "TINYINT", Precision: "4"},
+		{Name: "c_tinyint_unsigned", Tp: "TINYINT UNSIGNED", Precision: "3"},
+		{Name: "c_smallint", Tp: "SMALLINT", Precision: "6"},
+		{Name: "c_smallint_unsigned", Tp: "SMALLINT UNSIGNED", Precision: "5"},
+		{Name: "c_mediumint", Tp: "MEDIUMINT", Precision: "9"},
+		{Name: "c_mediumint_unsigned", Tp: "MEDIUMINT UNSIGNED", Precision: "8"},
+		{Name: "c_int", Tp: "INT", Precision: "11"},
+		{Name: "c_int_unsigned", Tp: "INT UNSIGNED", Precision: "10"},
+		{Name: "c_bigint", Tp: "BIGINT", Precision: "20"},
+		{Name: "c_bigint_unsigned", Tp: "BIGINT UNSIGNED", Precision: "20"},
+		{Name: "c_float", Tp: "FLOAT", Precision: "12"},
+		{Name: "c_double", Tp: "DOUBLE", Precision: "22"},
+		{Name: "c_decimal", Tp: "DECIMAL", Precision: "20", Scale: "6"},
+		{Name: "c_decimal_wide", Tp: "DECIMAL", Precision: "40", Scale: "10"},
+		{Name: "c_bit", Tp: "BIT", Precision: "1"},
+		{Name: "c_bit_long", Tp: "BIT", Precision: "12"},
+		{Name: "c_year", Tp: "YEAR", Precision: "4"},
+		{Name: "c_date", Tp: "DATE"},
+		{Name: "c_datetime", Tp: "DATETIME", Scale: "6"},
+		{Name: "c_timestamp", Tp: "TIMESTAMP"},
+		{Name: "c_time", Tp: "TIME"},
+		{Name: "c_char", Tp: "CHAR", Precision: "10"},
+		{Name: "c_varchar", Tp: "VARCHAR", Precision: "255"},
+		{Name: "c_binary", Tp: "BINARY", Precision: "16"},
+		{Name: "c_varbinary", Tp: "VARBINARY", Precision: "255"},
+		{Name: "c_tinytext", Tp: "TINYTEXT", Precision: "255"},
+		{Name: "c_text", Tp: "TEXT", Precision: "65535"},
+		{Name: "c_mediumtext", Tp: "MEDIUMTEXT", Precision: "16777215"},
+		{Name: "c_longtext", Tp: "LONGTEXT", Precision: "4294967295"},
+		{Name: "c_tinyblob", Tp: "TINYBLOB", Precision: "255"},
+		{Name: "c_blob", Tp: "BLOB", Precision: "65535"},
+		{Name: "c_mediumblob", Tp: "MEDIUMBLOB", Precision: "16777215"},
+		{Name: "c_longblob", Tp: "LONGBLOB", Precision: "4294967295"},
+		{Name: "c_enum", Tp: "ENUM"},
+		{Name: "c_set", Tp: "SET"},
+		{Name: "c_json", Tp: "JSON"},
+	}
+}
+
+// CopiedColumns returns the columns of Columns whose types have a length as they are read from
+// information_schema.columns by CopyTableSchema: the fractional seconds of the time types are in the precision,
+// and ENUM and SET have the length of their longest value.
+func CopiedColumns() []cloudstorage.TableCol {
+	return []cloudstorage.TableCol{
+		{Name: "c_datetime", Tp: "datetime", Precision: "6"},
+		{Name: "c_enum", Tp: "enum", Precision: "8"},
+		{Name: "c_set", Tp: "set", Precision: "17"},
+	}
+}
@@ -0,0 +1,66 @@
+package tidbsql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/parser"
+)
+
+// ParseWhere parses the values of --where, e.g. `db.t=status <> 'deleted'`, into the predicates keyed
+// by the table FQN. A predicate must be a single expression, it is checked by parsing a SELECT of it.
+func ParseWhere(values []string) (map[string]string, error) {
+	predicates := make(map[string]string, len(values))
+	for _, value := range values {
+		tableFQN, predicate, ok := strings.Cut(value, "=")
+		tableFQN, predicate = strings.TrimSpace(tableFQN), strings.TrimSpace(predicate)
+		if !ok || strings.Count(tableFQN, ".") != 1 || predicate == "" {
+			return nil, errors.Errorf("invalid where %s, expect <db>.<table>=<predicate>", value)
+		}
+		if _, ok := predicates[tableFQN]; ok {
+			return nil, errors.Errorf("duplicated where of table %s", tableFQN)
+		}
+		if _, err := parser.New().ParseOneStmt(selectWhere("t", "t", predicate), "", ""); err != nil {
+			return nil, errors.Annotatef(err, "Invalid where of table %s", tableFQN)
+		}
+		if hasDoubleQuote(predicate) {
+			return nil, errors.Errorf("invalid where of table %s, a double-quoted name is a string in TiDB, which matches no row of the snapshot, write the columns unquoted: %s", tableFQN, predicate)
+		}
+		predicates[tableFQN] = predicate
+	}
+	return predicates, nil
+}
+
+// hasDoubleQuote tells whether the predicate has a double quote outside of the single-quoted strings. TiDB reads
+// "USERID" as a string while the data warehouses read it as a column, so the predicate would be valid in both but
+// select different rows.
+func hasDoubleQuote(predicate string) bool {
+	inString := false
+	for i := 0; i < len(predicate); i++ {
+		switch c := predicate[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '\'':
+			inString = !inString
+		case !inString && c == '"':
+			return true
+		}
+	}
+	return false
+}
+
+func selectWhere(sourceDatabase, sourceTable, predicate string) string {
+	return fmt.Sprintf("SELECT 1 FROM %s.%s WHERE %s", QuoteIdent(sourceDatabase), QuoteIdent(sourceTable), predicate)
+}
+
+// CheckWhere checks the predicate can be evaluated on the rows of the table by explaining a SELECT of it,
+// e.g. the columns referenced exist
+func CheckWhere(db *sql.DB, sourceDatabase, sourceTable, predicate string) error {
+	rows, err := db.Query("EXPLAIN " + selectWhere(sourceDatabase, sourceTable, predicate))
+	if err != nil {
+		return errors.Annotatef(err, "Failed to explain where %s of table %s.%s", predicate, sourceDatabase, sourceTable)
+	}
+	return errors.Trace(rows.Close())
+}
@@ -0,0 +1,35 @@
+package tidbsql_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWhere(t *testing.T) {
+	predicates, err := tidbsql.ParseWhere([]string{"app.users=status <> 'deleted'", "app.orders = amount >= 100 AND region IN ('eu', 'us')"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"app.users":  "status <> 'deleted'",
+		"app.orders": "amount >= 100 AND region IN ('eu', 'us')",
+	}, predicates)
+
+	_, err = tidbsql.ParseWhere([]string{"users=status <> 'deleted'"})
+	require.ErrorContains(t, err, "expect <db>.<table>=<predicate>")
+	_, err = tidbsql.ParseWhere([]string{"app.users="})
+	require.ErrorContains(t, err, "expect <db>.<table>=<predicate>")
+	_, err = tidbsql.ParseWhere([]string{"app.users=id > 1", "app.users=id < 10"})
+	require.ErrorContains(t, err, "duplicated where of table app.users")
+	// a predicate is a single expression
+	_, err = tidbsql.ParseWhere([]string{"app.users=1; DROP TABLE app.users"})
+	require.ErrorContains(t, err, "Invalid where of table app.users")
+	_, err = tidbsql.ParseWhere([]string{"app.users=id >"})
+	require.ErrorContains(t, err, "Invalid where of table app.users")
+	// a double-quoted column is a string in TiDB, a double quote in a string is not
+	_, err = tidbsql.ParseWhere([]string{`app.users="USERID" > 0`})
+	require.ErrorContains(t, err, "a double-quoted name is a string in TiDB")
+	predicates, err = tidbsql.ParseWhere([]string{`app.users=note <> 'say "hi"' AND name <> 'it''s' AND path <> 'C:\\'`})
+	require.NoError(t, err)
+	require.Equal(t, `note <> 'say "hi"' AND name <> 'it''s' AND path <> 'C:\\'`, predicates["app.users"])
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// Compression is the codec of the CSV files in the storage
+type Compression string
+
+const (
+	CompressionNone   Compression = "none"
+	CompressionGzip   Compression = "gzip"
+	CompressionSnappy Compression = "snappy"
+	CompressionZstd   Compression = "zstd"
+)
+
+// ParseCompression parses the codec name, the aliases accepted by dumpling are also accepted
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "no-compression":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "zstd", "zst":
+		return CompressionZstd, nil
+	default:
+		return CompressionNone, errors.Errorf("unknown compression %s, expected one of none, gzip, snappy, zstd", s)
+	}
+}
+
+// FileExtension returns the extension appended to the CSV file name, e.g. `.gz` for `xxx.csv.gz`
+func (c Compression) FileExtension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionSnappy:
+		return ".snappy"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// CSVFileExtension returns the full extension of the CSV files compressed by the codec
+func (c Compression) CSVFileExtension() string {
+	return ".csv" + c.FileExtension()
+}
+
+// CompressType returns the compression type used by BR storage to read and write the files
+func (c Compression) CompressType() storage.CompressType {
+	switch c {
+	case CompressionGzip:
+		return storage.Gzip
+	case CompressionSnappy:
+		return storage.Snappy
+	case CompressionZstd:
+		return storage.Zstd
+	default:
+		return storage.NoCompression
+	}
+}
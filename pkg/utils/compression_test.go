@@ -0,0 +1,50 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCompression(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected utils.Compression
+	}{
+		{"", utils.CompressionNone},
+		{"none", utils.CompressionNone},
+		{"no-compression", utils.CompressionNone},
+		{"gzip", utils.CompressionGzip},
+		{"GZ", utils.CompressionGzip},
+		{"snappy", utils.CompressionSnappy},
+		{"Zstd", utils.CompressionZstd},
+		{"zst", utils.CompressionZstd},
+	}
+	for _, c := range cases {
+		compression, err := utils.ParseCompression(c.name)
+		require.NoError(t, err, c.name)
+		require.Equal(t, c.expected, compression, c.name)
+	}
+
+	_, err := utils.ParseCompression("lz4")
+	require.ErrorContains(t, err, "unknown compression lz4")
+}
+
+func TestCompressionFileExtension(t *testing.T) {
+	cases := []struct {
+		compression  utils.Compression
+		extension    string
+		compressType storage.CompressType
+	}{
+		{utils.CompressionNone, ".csv", storage.NoCompression},
+		{utils.CompressionGzip, ".csv.gz", storage.Gzip},
+		{utils.CompressionSnappy, ".csv.snappy", storage.Snappy},
+		{utils.CompressionZstd, ".csv.zst", storage.Zstd},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.extension, c.compression.CSVFileExtension(), c.compression)
+		require.Equal(t, c.compressType, c.compression.CompressType(), c.compression)
+	}
+}
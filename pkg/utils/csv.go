@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/pingcap/errors"
+)
+
+// CSVField is a decoded field, Null is true if the field is NULL
+type CSVField struct {
+	Value []byte
+	Null  bool
+}
+
+// CSVReader decodes the CSV files written by dumpling and TiCDC: a field may be quoted by `"`, `\` escapes
+// the next character and an unquoted `\N` is NULL. Rows are terminated by `\n` or `\r\n`.
+type CSVReader struct {
+	r *bufio.Reader
+}
+
+// NewCSVReader returns a CSVReader reading the CSV file from r
+func NewCSVReader(r io.Reader) *CSVReader {
+	return &CSVReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// unescape returns the character escaped by `\`, following the escapes of dumpling and TiCDC
+func unescape(b byte) byte {
+	switch b {
+	case '0':
+		return 0
+	case 'b':
+		return '\b'
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	default:
+		return b
+	}
+}
+
+// Read returns the fields of the next row, io.EOF is returned if there is no more row
+func (cr *CSVReader) Read() ([]CSVField, error) {
+	var (
+		fields []CSVField
+		field  CSVField
+		// rawLen is the length of the field in the file, quoted is true if the field starts with a quote
+		rawLen  int
+		quoted  bool
+		inQuote bool
+		sawAny  bool
+	)
+	finish := func() {
+		field.Null = !quoted && field.Null && rawLen == 2
+		fields = append(fields, field)
+		field, rawLen, quoted = CSVField{}, 0, false
+	}
+	for {
+		b, err := cr.r.ReadByte()
+		if err == io.EOF {
+			if !sawAny {
+				return nil, io.EOF
+			}
+			if inQuote {
+				return nil, errors.New("unexpected EOF in quoted field")
+			}
+			finish()
+			return fields, nil
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sawAny = true
+		switch {
+		case b == '\\':
+			next, err := cr.r.ReadByte()
+			if err == io.EOF {
+				field.Value = append(field.Value, b)
+				rawLen++
+				continue
+			}
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			field.Null = rawLen == 0 && next == 'N'
+			field.Value = append(field.Value, unescape(next))
+			rawLen += 2
+		case b == '"' && inQuote:
+			rawLen++
+			if next, err := cr.r.Peek(1); err == nil && next[0] == '"' {
+				_, _ = cr.r.ReadByte()
+				field.Value = append(field.Value, '"')
+				rawLen++
+			} else {
+				inQuote = false
+			}
+		case b == '"' && rawLen == 0:
+			inQuote, quoted = true, true
+			rawLen++
+		case b == ',' && !inQuote:
+			finish()
+		case b == '\r' && !inQuote:
+			if next, err := cr.r.Peek(1); err == nil && next[0] == '\n' {
+				continue
+			}
+			field.Value = append(field.Value, b)
+			rawLen++
+		case b == '\n' && !inQuote:
+			finish()
+			return fields, nil
+		default:
+			field.Value = append(field.Value, b)
+			rawLen++
+		}
+	}
+}
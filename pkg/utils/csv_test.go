@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVReader(t *testing.T) {
+	// a dumpling row with quoted strings, and TiCDC rows without quotes
+	data := "1,\"a \\\"quoted\\\" \\\\ value\",\\N,\"\\N\"\r\n" +
+		"I,t,db,450000000000000000,multi\\nline\\, with comma,\n" +
+		"D,t,db,450000000000000001,\"\"\"doubled\"\"\",\\Nx"
+	reader := NewCSVReader(strings.NewReader(data))
+
+	fields, err := reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, []CSVField{
+		{Value: []byte("1")},
+		{Value: []byte(`a "quoted" \ value`)},
+		{Value: []byte("N"), Null: true},
+		{Value: []byte("N")},
+	}, fields)
+
+	fields, err = reader.Read()
+	require.NoError(t, err)
+	require.Len(t, fields, 6)
+	require.Equal(t, "multi\nline, with comma", string(fields[4].Value))
+	require.Equal(t, CSVField{}, fields[5])
+
+	fields, err = reader.Read()
+	require.NoError(t, err)
+	require.Equal(t, `"doubled"`, string(fields[4].Value))
+	require.False(t, fields[5].Null)
+	require.Equal(t, "Nx", string(fields[5].Value))
+
+	_, err = reader.Read()
+	require.Equal(t, io.EOF, err)
+}
@@ -40,3 +40,11 @@ func EscapeString(s string) string {
 	}
 	return sb.String()
 }
+
+// QuoteLiteral returns the single-quoted string literal with backslash escapes,
+// which are accepted by Snowflake, Redshift, BigQuery and Databricks.
+func QuoteLiteral(s string) string {
+	return "'" + literalReplacer.Replace(s) + "'"
+}
+
+var literalReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
@@ -1,14 +1,29 @@
 package utils
 
-import "github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+import (
+	"fmt"
+
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
 
 var (
 	CDCFlagColumnName       = "tidb2dw_flag"
 	CDCTablenameColumnName  = "tidb2dw_tablename"
 	CDCSchemanameColumnName = "tidb2dw_schemaname"
 	CDCCommitTsColumnName   = "tidb2dw_commit_ts"
+	// WhereMatchedColumnName is whether a row of the increment matches the predicate of --where,
+	// which is computed when merging the increment
+	WhereMatchedColumnName = "tidb2dw_matched"
 )
 
+// LatestChangeOrder orders the changes of a row from the latest by the commit ts, and an insert or update before
+// a delete of the same commit ts: TiCDC splits the update of a unique key into a delete and an insert of the same
+// commit ts and key, the insert being the result. The merges keeping the first change of each key thus do not
+// depend on the order the data warehouse reads the rows in.
+func LatestChangeOrder(commitTs, flag string) string {
+	return fmt.Sprintf("%s desc, CASE WHEN %s = 'D' THEN 0 ELSE 1 END desc", commitTs, flag)
+}
+
 func GenIncrementTableColumns(columns []cloudstorage.TableCol) []cloudstorage.TableCol {
 	return append([]cloudstorage.TableCol{
 		{
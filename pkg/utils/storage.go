@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	putil "github.com/pingcap/tiflow/pkg/util"
+	"google.golang.org/api/googleapi"
+)
+
+// InsecureSkipTLSParam is the query parameter of the storage URI to skip TLS certificate verification
+// of the S3-compatible endpoint. Unlike `endpoint`, `region` and `force-path-style` it is not a BR / TiCDC
+// option, so it is only honored by tidb2dw itself and removed by StripTiDB2DWParams before the URI is
+// handed to other components.
+const InsecureSkipTLSParam = "insecure-skip-tls"
+
+// StripTiDB2DWParams returns a copy of the storage URI without tidb2dw specific query parameters
+func StripTiDB2DWParams(uri *url.URL) *url.URL {
+	stripped := *uri
+	query := stripped.Query()
+	query.Del(InsecureSkipTLSParam)
+	stripped.RawQuery = query.Encode()
+	return &stripped
+}
+
+// GetExternalStorageFromURI creates the external storage from the storage URI, it behaves the same as
+// the one from TiCDC except that InsecureSkipTLSParam is honored. The files are not rate limited, the storages
+// transferring the files of a pipeline are wrapped by its ratelimit.Limiters.
+func GetExternalStorageFromURI(ctx context.Context, uri string) (storage.ExternalStorage, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	skipTLS := false
+	if value := parsed.Query().Get(InsecureSkipTLSParam); value != "" {
+		if skipTLS, err = strconv.ParseBool(value); err != nil {
+			return nil, errors.Annotatef(err, "invalid %s in storage path", InsecureSkipTLSParam)
+		}
+	}
+	stripped := StripTiDB2DWParams(parsed).String()
+	if !skipTLS {
+		return putil.GetExternalStorageFromURI(ctx, stripped)
+	}
+
+	backend, err := storage.ParseBackend(stripped, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} // #nosec G402 -- explicitly requested for lab certificates
+	extStorage, err := storage.New(ctx, backend, &storage.ExternalStorageOptions{
+		HTTPClient: &http.Client{Transport: transport},
+		S3Retryer:  putil.DefaultS3Retryer(),
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "Failed to create external storage")
+	}
+	return extStorage, nil
+}
+
+// transientStorageMessages are the messages of the throttled or unavailable object storages not typed by the SDKs
+var transientStorageMessages = []string{
+	"slowdown",
+	"serviceunavailable",
+	"internalerror",
+	"requesttimeout",
+	"status code: 5",
+}
+
+// IsTransientStorageError tells whether the request to the external storage may succeed if it is sent again,
+// e.g. it is throttled, the service is unavailable or the connection is reset. A missing file is not an error.
+func IsTransientStorageError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if retry.IsTransient(err) {
+		return true
+	}
+	cause := errors.Cause(err)
+	var requestErr awserr.RequestFailure
+	if stderrors.As(cause, &requestErr) && (requestErr.StatusCode() == http.StatusTooManyRequests || requestErr.StatusCode() >= 500) {
+		return true
+	}
+	// the SDK takes any error not of AWS as retryable, so only the codes of AWS are checked
+	var awsErr awserr.Error
+	if stderrors.As(cause, &awsErr) && (request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)) {
+		return true
+	}
+	var gcsErr *googleapi.Error
+	if stderrors.As(cause, &gcsErr) && (gcsErr.Code == http.StatusTooManyRequests || gcsErr.Code >= 500) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientStorageMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
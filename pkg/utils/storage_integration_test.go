@@ -0,0 +1,95 @@
+//go:build integration
+
+package utils_test
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	minioImage  = "minio/minio:latest"
+	minioUser   = "minioadmin"
+	minioPass   = "minioadmin"
+	minioBucket = "tidb2dw"
+)
+
+// startMinIO starts a MinIO container and returns its endpoint, the container is removed when the test finishes.
+func startMinIO(t *testing.T) string {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-p", "127.0.0.1::9000",
+		"-e", "MINIO_ROOT_USER="+minioUser, "-e", "MINIO_ROOT_PASSWORD="+minioPass,
+		minioImage, "server", "/data").CombinedOutput()
+	require.NoError(t, err, string(out))
+	containerID := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "-f", containerID).Run()
+	})
+
+	out, err = exec.Command("docker", "port", containerID, "9000/tcp").CombinedOutput()
+	require.NoError(t, err, string(out))
+	endpoint := "http://" + strings.TrimSpace(strings.Split(string(out), "\n")[0])
+
+	client := s3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials(minioUser, minioPass, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+	require.Eventually(t, func() bool {
+		_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(minioBucket)})
+		return err == nil
+	}, 30*time.Second, 500*time.Millisecond, "MinIO is not ready")
+	return endpoint
+}
+
+func TestMinIOStorage(t *testing.T) {
+	endpoint := startMinIO(t)
+	ctx := context.Background()
+
+	uri, err := utils.NormalizeStorageURI(
+		fmt.Sprintf("s3://%s/task//?endpoint=%s&force-path-style=true&region=us-east-1", minioBucket, endpoint), "s3")
+	require.NoError(t, err)
+	query := uri.Query()
+	query.Set("access-key", minioUser)
+	query.Set("secret-access-key", minioPass)
+	uri.RawQuery = query.Encode()
+
+	extStorage, err := utils.GetExternalStorageFromURI(ctx, uri.String())
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(ctx, "increment/metadata", []byte(`{"checkpoint-ts":1}`)))
+	exist, err := extStorage.FileExists(ctx, "increment/metadata")
+	require.NoError(t, err)
+	require.True(t, exist)
+
+	var files []string
+	require.NoError(t, extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		files = append(files, path)
+		return nil
+	}))
+	require.Equal(t, []string{"increment/metadata"}, files)
+
+	// the sink URI handed to TiCDC must not carry tidb2dw specific parameters
+	query.Set(utils.InsecureSkipTLSParam, "true")
+	uri.RawQuery = query.Encode()
+	extStorage, err = utils.GetExternalStorageFromURI(ctx, uri.String())
+	require.NoError(t, err)
+	content, err := extStorage.ReadFile(ctx, "increment/metadata")
+	require.NoError(t, err)
+	require.Equal(t, `{"checkpoint-ts":1}`, string(content))
+	require.False(t, utils.StripTiDB2DWParams(uri).Query().Has(utils.InsecureSkipTLSParam))
+}
@@ -0,0 +1,23 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsTransientStorageError(t *testing.T) {
+	require.False(t, utils.IsTransientStorageError(nil))
+	require.True(t, utils.IsTransientStorageError(errors.Annotate(awserr.NewRequestFailure(
+		awserr.New("ServiceUnavailable", "unavailable", nil), 503, "id"), "wrapped")))
+	require.False(t, utils.IsTransientStorageError(awserr.NewRequestFailure(
+		awserr.New("AccessDenied", "denied", nil), 403, "id")))
+	require.True(t, utils.IsTransientStorageError(&googleapi.Error{Code: 429}))
+	require.True(t, utils.IsTransientStorageError(errors.New("SlowDown: Please reduce your request rate")))
+	require.False(t, utils.IsTransientStorageError(errors.New("AccessDenied: Access Denied")))
+	require.False(t, utils.IsTransientStorageError(&googleapi.Error{Code: 404}))
+}
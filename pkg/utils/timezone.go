@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"strings"
+	"time"
+	// the time zones are validated without the tzdata of the host, e.g. in a minimal container
+	_ "time/tzdata"
+
+	"github.com/pingcap/errors"
+)
+
+// TimeZoneSystem keeps the time zones of TiDB and the data warehouse sessions unchanged
+const TimeZoneSystem = "System"
+
+// ParseTimeZone checks the time zone is in the IANA database, e.g. `UTC` or `Asia/Shanghai`, and returns its name.
+// An empty name is returned for TimeZoneSystem.
+func ParseTimeZone(name string) (string, error) {
+	if name == "" || strings.EqualFold(name, TimeZoneSystem) {
+		return "", nil
+	}
+	// Local is the time zone of the host running tidb2dw, which differs from those of TiDB and TiCDC
+	if name == "Local" {
+		return "", errors.New("invalid --tz Local, set an IANA time zone, e.g. UTC or Asia/Shanghai")
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return "", errors.Annotatef(err, "invalid --tz %s, set an IANA time zone, e.g. UTC or Asia/Shanghai", name)
+	}
+	return name, nil
+}
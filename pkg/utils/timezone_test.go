@@ -0,0 +1,25 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	for _, name := range []string{"", "System", "system"} {
+		tz, err := utils.ParseTimeZone(name)
+		require.NoError(t, err)
+		require.Empty(t, tz)
+	}
+	for _, name := range []string{"UTC", "Asia/Shanghai", "America/New_York"} {
+		tz, err := utils.ParseTimeZone(name)
+		require.NoError(t, err)
+		require.Equal(t, name, tz)
+	}
+	for _, name := range []string{"Local", "Mars/Olympus", "+08:00"} {
+		_, err := utils.ParseTimeZone(name)
+		require.ErrorContains(t, err, "invalid --tz "+name)
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
+	"os"
+
+	"github.com/pingcap/errors"
+)
+
+// TLSConfig is how a client connection is secured by TLS, the zero value means plaintext
+type TLSConfig struct {
+	// CA is the PEM file of the CA verifying the server, empty means the CAs of the system
+	CA string
+	// Cert and Key are the PEM files of the client certificate, both or none are given
+	Cert string
+	Key  string
+	// SkipVerify skips verifying the certificate of the server, e.g. for a test cluster
+	SkipVerify bool
+}
+
+// Enabled returns whether the connection uses TLS
+func (c TLSConfig) Enabled() bool {
+	return c.CA != "" || c.Cert != "" || c.Key != "" || c.SkipVerify
+}
+
+// Build returns the TLS config of the client connecting to the server, serverName is the host verified
+// by the certificate of the server
+func (c TLSConfig) Build(serverName string) (*tls.Config, error) {
+	if (c.Cert == "") != (c.Key == "") {
+		return nil, errors.New("the client certificate and key must be given together")
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if c.CA != "" {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Failed to read CA %s", c.CA)
+		}
+		rootCertPool := x509.NewCertPool()
+		if ok := rootCertPool.AppendCertsFromPEM(pem); !ok {
+			return nil, errors.Errorf("Failed to append PEM of CA %s", c.CA)
+		}
+		tlsConfig.RootCAs = rootCertPool
+	}
+	if c.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, errors.Annotatef(err, "Failed to load client certificate %s and key %s", c.Cert, c.Key)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// AnnotateTLSError explains the failure of verifying the certificate of the server of the connection,
+// flagPrefix is the prefix of the flags configuring it, e.g. tidb. Other errors are returned as is.
+func AnnotateTLSError(err error, connection, flagPrefix string) error {
+	if err == nil {
+		return nil
+	}
+	var hostnameErr x509.HostnameError
+	if stderrors.As(err, &hostnameErr) {
+		return errors.Annotatef(err, "TLS hostname verification of the %s connection failed, --%s.host must be a name the certificate of the server is valid for", connection, flagPrefix)
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if stderrors.As(err, &unknownAuthorityErr) {
+		return errors.Annotatef(err, "TLS verification of the %s connection failed, the certificate of the server is not signed by --%s.ssl-ca or the CAs of the system", connection, flagPrefix)
+	}
+	var invalidErr x509.CertificateInvalidError
+	if stderrors.As(err, &invalidErr) {
+		return errors.Annotatef(err, "TLS verification of the %s connection failed, the certificate of the server is invalid", connection)
+	}
+	return err
+}
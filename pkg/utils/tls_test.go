@@ -0,0 +1,34 @@
+package utils_test
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig(t *testing.T) {
+	require.False(t, utils.TLSConfig{}.Enabled())
+	require.True(t, utils.TLSConfig{SkipVerify: true}.Enabled())
+
+	_, err := utils.TLSConfig{Cert: "client.pem"}.Build("tidb")
+	require.ErrorContains(t, err, "must be given together")
+	_, err = utils.TLSConfig{CA: "missing.pem"}.Build("tidb")
+	require.ErrorContains(t, err, "Failed to read CA missing.pem")
+	tlsConfig, err := utils.TLSConfig{SkipVerify: true}.Build("tidb")
+	require.NoError(t, err)
+	require.Equal(t, "tidb", tlsConfig.ServerName)
+	require.True(t, tlsConfig.InsecureSkipVerify)
+}
+
+func TestAnnotateTLSError(t *testing.T) {
+	require.NoError(t, utils.AnnotateTLSError(nil, "TiDB", "tidb"))
+	err := errors.Trace(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "tidb.internal"})
+	require.ErrorContains(t, utils.AnnotateTLSError(err, "TiDB", "tidb"), "TLS hostname verification of the TiDB connection failed, --tidb.host")
+	err = errors.Trace(x509.UnknownAuthorityError{})
+	require.ErrorContains(t, utils.AnnotateTLSError(err, "TiDB", "tidb"), "--tidb.ssl-ca")
+	err = errors.New("connection refused")
+	require.Equal(t, err, utils.AnnotateTLSError(err, "TiDB", "tidb"))
+}
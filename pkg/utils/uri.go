@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+// query parameters which carry secrets and must not be printed
+var sensitiveQueryParams = []string{"access-key", "secret-access-key", "session-token", "account-key", "sas-token"}
+
+var duplicateSlashes = regexp.MustCompile(`/{2,}`)
+
+// NormalizeStorageURI parses the storage path given by user and returns its canonical form,
+// which is the only form used afterwards for both the changefeed sink and tidb2dw itself:
+//   - `https://bucket.s3.<region>.amazonaws.com/prefix` is translated into `s3://bucket/prefix?region=<region>`
+//   - `https://storage.googleapis.com/bucket/prefix` is translated into `gs://bucket/prefix`
+//   - `gcs://` is translated into `gs://`
+//   - `azblob://` is translated into `azure://`, and `https://<account>.blob.core.windows.net/<container>/prefix`
+//     and `abfss://<container>@<account>.dfs.core.windows.net/prefix` are translated into
+//     `azure://<container>/prefix?account-name=<account>`
+//   - duplicate and trailing slashes of the prefix are removed
+//
+// Query parameters such as `?endpoint=` are kept as is. An error is returned if the scheme is not
+// one of supportedSchemes, the bucket is missing or the prefix is empty.
+func NormalizeStorageURI(storagePath string, supportedSchemes ...string) (*url.URL, error) {
+	storagePath = strings.TrimSpace(storagePath)
+	if storagePath == "" {
+		return nil, errors.New("storage path is required")
+	}
+	uri, err := url.Parse(storagePath)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse storage path %s", storagePath)
+	}
+	if uri.Fragment != "" {
+		return nil, errors.Errorf("storage path %s must not contain a fragment", storagePath)
+	}
+	uri.Scheme = strings.ToLower(uri.Scheme)
+	if uri.Scheme == "abfs" || uri.Scheme == "abfss" {
+		// the container of abfss:// is carried by the user info
+		if err = translateABFSStorageURI(uri); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if uri.User != nil {
+		return nil, errors.Errorf("storage path %s must not contain user info, pass credentials by flags instead", storagePath)
+	}
+
+	switch uri.Scheme {
+	case "http", "https":
+		if err = translateHTTPStorageURI(uri); err != nil {
+			return nil, errors.Trace(err)
+		}
+	case "gcs":
+		uri.Scheme = "gs"
+	case "azblob":
+		uri.Scheme = "azure"
+	case "":
+		return nil, errors.Errorf("storage path %s has no scheme, expected %s", storagePath, formatSchemes(supportedSchemes))
+	}
+
+	supported := false
+	for _, scheme := range supportedSchemes {
+		switch scheme {
+		case "gcs":
+			scheme = "gs"
+		case "azblob":
+			scheme = "azure"
+		}
+		if uri.Scheme == scheme {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, errors.Errorf("storage scheme %s:// is not supported by this warehouse, expected %s", uri.Scheme, formatSchemes(supportedSchemes))
+	}
+
+	if uri.Host == "" {
+		return nil, errors.Errorf("storage path %s has no bucket, expected %s://<bucket>/<prefix>", storagePath, uri.Scheme)
+	}
+
+	uri.Path = strings.TrimRight(duplicateSlashes.ReplaceAllString(uri.Path, "/"), "/")
+	uri.RawPath = ""
+	if uri.Path == "" {
+		return nil, errors.Errorf(
+			"storage path %s has no prefix, please use a dedicated prefix per task, e.g. %s://%s/tidb2dw/<task-name>",
+			storagePath, uri.Scheme, uri.Host)
+	}
+	if !strings.HasPrefix(uri.Path, "/") {
+		uri.Path = "/" + uri.Path
+	}
+
+	query := uri.Query()
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		endpointURI, err := url.Parse(endpoint)
+		if err != nil || (endpointURI.Scheme != "http" && endpointURI.Scheme != "https") || endpointURI.Host == "" {
+			return nil, errors.Errorf("invalid endpoint %s in storage path, expected http(s)://<host>[:<port>]", endpoint)
+		}
+	}
+	uri.RawQuery = query.Encode()
+	return uri, nil
+}
+
+// translateHTTPStorageURI translates virtual-hosted and path-style HTTPS URLs of S3 and GCS into s3:// and gs:// form
+func translateHTTPStorageURI(uri *url.URL) error {
+	host := strings.ToLower(uri.Hostname())
+	bucket, region := "", ""
+	switch {
+	case host == "storage.googleapis.com":
+		uri.Scheme = "gs"
+		bucket, uri.Path = splitBucketFromPath(uri.Path)
+	case strings.HasSuffix(host, ".storage.googleapis.com"):
+		uri.Scheme = "gs"
+		bucket = strings.TrimSuffix(host, ".storage.googleapis.com")
+	case strings.HasSuffix(host, ".blob.core.windows.net") || strings.HasSuffix(host, ".dfs.core.windows.net"):
+		uri.Scheme = "azure"
+		setAzureAccountName(uri, host[:strings.Index(host, ".")])
+		bucket, uri.Path = splitBucketFromPath(uri.Path)
+	case strings.HasSuffix(host, ".amazonaws.com") || strings.HasSuffix(host, ".amazonaws.com.cn"):
+		uri.Scheme = "s3"
+		service := host[:strings.Index(host, ".amazonaws.com")]
+		if service == "s3" || strings.HasPrefix(service, "s3.") || strings.HasPrefix(service, "s3-") {
+			// path-style: s3.<region>.amazonaws.com/<bucket>/<prefix>
+			bucket, uri.Path = splitBucketFromPath(uri.Path)
+		} else if idx := strings.LastIndex(service, ".s3"); idx > 0 {
+			// virtual-hosted-style: <bucket>.s3.<region>.amazonaws.com/<prefix>
+			bucket, service = service[:idx], service[idx+1:]
+		} else {
+			return errors.Errorf("%s is not an S3 endpoint, expected https://<bucket>.s3.<region>.amazonaws.com/<prefix>", uri.Host)
+		}
+		region = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(service, "s3"), "."), "-")
+		region = strings.TrimPrefix(region, "dualstack.")
+	default:
+		return errors.Errorf(
+			"unsupported storage URL %s://%s, use s3://<bucket>/<prefix>, gs://<bucket>/<prefix> or azure://<container>/<prefix>; "+
+				"for S3-compatible storage such as MinIO, use s3://<bucket>/<prefix>?endpoint=%s://%s",
+			uri.Scheme, uri.Host, uri.Scheme, uri.Host)
+	}
+	if bucket == "" {
+		return errors.Errorf("storage URL %s://%s%s has no bucket", uri.Scheme, uri.Host, uri.Path)
+	}
+	uri.Host = bucket
+	if region != "" {
+		query := uri.Query()
+		if query.Get("region") == "" {
+			query.Set("region", region)
+		}
+		uri.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// translateABFSStorageURI translates `abfss://<container>@<account>.dfs.core.windows.net/prefix` into azure:// form
+func translateABFSStorageURI(uri *url.URL) error {
+	host := strings.ToLower(uri.Hostname())
+	if uri.User == nil || !strings.HasSuffix(host, ".dfs.core.windows.net") {
+		return errors.Errorf("unsupported storage URL %s://%s, expected %s://<container>@<account>.dfs.core.windows.net/<prefix>",
+			uri.Scheme, uri.Host, uri.Scheme)
+	}
+	uri.Scheme = "azure"
+	setAzureAccountName(uri, strings.TrimSuffix(host, ".dfs.core.windows.net"))
+	uri.Host = uri.User.Username()
+	uri.User = nil
+	return nil
+}
+
+// setAzureAccountName sets the storage account of azure:// unless it is given by the query string
+func setAzureAccountName(uri *url.URL, account string) {
+	query := uri.Query()
+	if query.Get("account-name") == "" {
+		query.Set("account-name", account)
+	}
+	uri.RawQuery = query.Encode()
+}
+
+func splitBucketFromPath(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimLeft(path, "/"), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], "/" + parts[1]
+}
+
+func formatSchemes(schemes []string) string {
+	formatted := make([]string, 0, len(schemes))
+	for _, scheme := range schemes {
+		bucket := "<bucket>"
+		if scheme == "azure" || scheme == "azblob" {
+			bucket = "<container>"
+		}
+		formatted = append(formatted, fmt.Sprintf("%s://%s/<prefix>", scheme, bucket))
+	}
+	return strings.Join(formatted, " or ")
+}
+
+// StripStorageCredentials returns a copy of the storage URI without the secrets in the query string, the storage is
+// accessed by the credentials of the environment instead
+func StripStorageCredentials(uri *url.URL) *url.URL {
+	stripped := *uri
+	query := stripped.Query()
+	for _, param := range sensitiveQueryParams {
+		query.Del(param)
+	}
+	stripped.RawQuery = query.Encode()
+	return &stripped
+}
+
+// RedactStorageURI returns the string form of the storage URI with secrets in the query string masked
+func RedactStorageURI(uri *url.URL) string {
+	redacted := *uri
+	query := redacted.Query()
+	for _, param := range sensitiveQueryParams {
+		if query.Has(param) {
+			query.Set(param, "xxxxx")
+		}
+	}
+	redacted.RawQuery = query.Encode()
+	return redacted.String()
+}
@@ -0,0 +1,90 @@
+package utils_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeStorageURI(t *testing.T) {
+	cases := []struct {
+		input    string
+		schemes  []string
+		expected string
+	}{
+		{"s3://bucket/path", []string{"s3"}, "s3://bucket/path"},
+		{"s3://bucket/path/", []string{"s3"}, "s3://bucket/path"},
+		{" s3://bucket//nested///path// ", []string{"s3"}, "s3://bucket/nested/path"},
+		{"S3://bucket/path", []string{"s3"}, "s3://bucket/path"},
+		{"s3://bucket/path?endpoint=http://minio:9000", []string{"s3"}, "s3://bucket/path?endpoint=http%3A%2F%2Fminio%3A9000"},
+		{"s3://bucket/path/?endpoint=https%3A%2F%2Fminio.local&force-path-style=true", []string{"s3"}, "s3://bucket/path?endpoint=https%3A%2F%2Fminio.local&force-path-style=true"},
+		{"https://bucket.s3.amazonaws.com/path/", []string{"s3"}, "s3://bucket/path"},
+		{"https://bucket.s3.us-west-2.amazonaws.com/path", []string{"s3"}, "s3://bucket/path?region=us-west-2"},
+		{"https://bucket.s3-us-west-2.amazonaws.com/path", []string{"s3"}, "s3://bucket/path?region=us-west-2"},
+		{"https://my.bucket.s3.dualstack.eu-west-1.amazonaws.com/path", []string{"s3"}, "s3://my.bucket/path?region=eu-west-1"},
+		{"https://s3.us-east-2.amazonaws.com/bucket/path", []string{"s3"}, "s3://bucket/path?region=us-east-2"},
+		{"https://s3.amazonaws.com/bucket/a/b/", []string{"s3"}, "s3://bucket/a/b"},
+		{"https://bucket.s3.cn-north-1.amazonaws.com.cn/path", []string{"s3"}, "s3://bucket/path?region=cn-north-1"},
+		{"https://bucket.s3.us-west-2.amazonaws.com/path?region=us-east-1", []string{"s3"}, "s3://bucket/path?region=us-east-1"},
+		{"gs://bucket/path/", []string{"gs", "gcs"}, "gs://bucket/path"},
+		{"gcs://bucket/path", []string{"gs", "gcs"}, "gs://bucket/path"},
+		{"https://storage.googleapis.com/bucket/path", []string{"gs"}, "gs://bucket/path"},
+		{"https://bucket.storage.googleapis.com/path/", []string{"gs"}, "gs://bucket/path"},
+		{"azure://container/path/", []string{"azure"}, "azure://container/path"},
+		{"azblob://container/path?account-name=acct", []string{"azure"}, "azure://container/path?account-name=acct"},
+		{"https://acct.blob.core.windows.net/container/path", []string{"azure"}, "azure://container/path?account-name=acct"},
+		{"abfss://container@acct.dfs.core.windows.net/path/", []string{"azure"}, "azure://container/path?account-name=acct"},
+	}
+	for _, c := range cases {
+		uri, err := utils.NormalizeStorageURI(c.input, c.schemes...)
+		require.NoError(t, err, c.input)
+		require.Equal(t, c.expected, uri.String(), c.input)
+	}
+}
+
+func TestNormalizeStorageURIError(t *testing.T) {
+	cases := []struct {
+		input   string
+		schemes []string
+		errMsg  string
+	}{
+		{"", []string{"s3"}, "storage path is required"},
+		{"bucket/path", []string{"s3"}, "has no scheme"},
+		{"s3://bucket", []string{"s3"}, "has no prefix, please use a dedicated prefix per task"},
+		{"s3://bucket/", []string{"s3"}, "has no prefix"},
+		{"s3://bucket///", []string{"s3"}, "has no prefix"},
+		{"s3:///path", []string{"s3"}, "has no bucket"},
+		{"gs://bucket/path", []string{"s3"}, "gs:// is not supported by this warehouse"},
+		{"s3://bucket/path", []string{"gs", "gcs"}, "s3:// is not supported by this warehouse"},
+		{"https://bucket.s3.amazonaws.com/path", []string{"gs"}, "s3:// is not supported by this warehouse"},
+		{"https://bucket.s3.amazonaws.com", []string{"s3"}, "has no prefix"},
+		{"https://s3.amazonaws.com/", []string{"s3"}, "has no bucket"},
+		{"https://minio.local:9000/bucket/path", []string{"s3"}, "?endpoint=https://minio.local:9000"},
+		{"https://ec2.amazonaws.com/bucket/path", []string{"s3"}, "is not an S3 endpoint"},
+		{"s3://bucket/path?endpoint=minio:9000", []string{"s3"}, "invalid endpoint"},
+		{"s3://key:secret@bucket/path", []string{"s3"}, "must not contain user info"},
+		{"s3://bucket/path#frag", []string{"s3"}, "must not contain a fragment"},
+		{"azure://container/path", []string{"s3"}, "azure:// is not supported by this warehouse, expected s3://<bucket>/<prefix>"},
+		{"gs://bucket/path", []string{"s3", "azure"}, "expected s3://<bucket>/<prefix> or azure://<container>/<prefix>"},
+		{"abfss://acct.dfs.core.windows.net/path", []string{"azure"}, "expected abfss://<container>@<account>.dfs.core.windows.net/<prefix>"},
+	}
+	for _, c := range cases {
+		_, err := utils.NormalizeStorageURI(c.input, c.schemes...)
+		require.Error(t, err, c.input)
+		require.Contains(t, err.Error(), c.errMsg, c.input)
+	}
+}
+
+func TestRedactStorageURI(t *testing.T) {
+	uri, err := url.Parse("s3://bucket/path?access-key=AK&secret-access-key=SK&session-token=ST&endpoint=http%3A%2F%2Fminio%3A9000")
+	require.NoError(t, err)
+	redacted := utils.RedactStorageURI(uri)
+	require.NotContains(t, redacted, "AK")
+	require.NotContains(t, redacted, "SK")
+	require.NotContains(t, redacted, "ST")
+	require.Contains(t, redacted, "endpoint=")
+	// the original uri is untouched
+	require.Contains(t, uri.RawQuery, "access-key=AK")
+}
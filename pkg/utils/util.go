@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"database/sql"
 	"strings"
+	"time"
 )
 
 // SplitTableFQN splits a full-qualified table name into database and table name
@@ -14,3 +16,17 @@ func SplitTableFQN(tableFQN string) (string, string) {
 	}
 	return parts[0], parts[1]
 }
+
+// TSOPhysicalTime returns the physical time of the TSO, which is its milliseconds shifted by 18 logical bits
+func TSOPhysicalTime(tso uint64) time.Time {
+	return time.UnixMilli(int64(tso >> 18))
+}
+
+// RowsAffected returns the rows affected by the statement, 0 if the driver does not report them
+func RowsAffected(result sql.Result) int64 {
+	rows, err := result.RowsAffected()
+	if err != nil || rows < 0 {
+		return 0
+	}
+	return rows
+}
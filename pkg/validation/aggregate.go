@@ -0,0 +1,153 @@
+package validation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// DefaultChecksumColumns is the max number of columns summed by the checksum
+const DefaultChecksumColumns = 4
+
+// maxSumPrecision is the precision the sums are computed in, the widest decimal of all data warehouses
+const maxSumPrecision = 38
+
+// sumHeadroom is the digits left for the sum of a decimal column, a column wider than
+// maxSumPrecision - sumHeadroom is not summed as its sum may overflow
+const sumHeadroom = 10
+
+// SumColumn is a column summed by the checksum, the sum is exact as the column is an integer or a decimal
+type SumColumn struct {
+	Name string
+	// Scale is the digits after the decimal point, 0 for the integer columns
+	Scale int
+}
+
+// Aggregates is the row count and the sums of a table, the sums are decimal strings in the order of the summed columns
+type Aggregates struct {
+	Rows int64    `json:"rows"`
+	Sums []string `json:"sums,omitempty"`
+}
+
+// ChecksumColumns returns the columns summed by the checksum, the primary key columns first and then the
+// other integer and decimal columns, up to max columns. Floating point columns are never summed as their
+// sums depend on the order of the rows.
+func ChecksumColumns(columns []cloudstorage.TableCol, max int) []SumColumn {
+	sumColumns := make([]SumColumn, 0, max)
+	for _, pk := range []bool{true, false} {
+		for _, column := range columns {
+			if len(sumColumns) >= max {
+				return sumColumns
+			}
+			if (column.IsPK == "true") != pk {
+				continue
+			}
+			if scale, ok := summableScale(column); ok {
+				sumColumns = append(sumColumns, SumColumn{Name: column.Name, Scale: scale})
+			}
+		}
+	}
+	return sumColumns
+}
+
+func summableScale(column cloudstorage.TableCol) (int, bool) {
+	tp := strings.TrimSpace(strings.TrimSuffix(strings.ToLower(column.Tp), " unsigned"))
+	switch tp {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return 0, true
+	case "decimal", "numeric":
+		precision, err := strconv.Atoi(column.Precision)
+		if err != nil || precision > maxSumPrecision-sumHeadroom {
+			return 0, false
+		}
+		scale, _ := strconv.Atoi(column.Scale)
+		return scale, true
+	}
+	return 0, false
+}
+
+// GenAggregateQuery returns the query of the row count and the sums of the table. The columns are summed as
+// decimalType(38, scale) so that the sums do not overflow, they are summed as they are if decimalType is empty.
+// The column names are quoted by quoteIdent of the database, they are used as they are if it is nil.
+func GenAggregateQuery(table string, sumColumns []SumColumn, decimalType string, quoteIdent func(string) string) string {
+	fields := []string{"COUNT(*)"}
+	for _, column := range sumColumns {
+		name := column.Name
+		if quoteIdent != nil {
+			name = quoteIdent(name)
+		}
+		if decimalType == "" {
+			fields = append(fields, fmt.Sprintf("SUM(%s)", name))
+		} else {
+			fields = append(fields, fmt.Sprintf("SUM(CAST(%s AS %s(%d, %d)))", name, decimalType, maxSumPrecision, column.Scale))
+		}
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(fields, ", "), table)
+}
+
+// Queryer is a *sql.DB or a *sql.Conn
+type Queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// QueryAggregates runs the query generated by GenAggregateQuery, the sums of an empty table are 0
+func QueryAggregates(ctx context.Context, db Queryer, query string, sumColumns []SumColumn) (*Aggregates, error) {
+	var rows int64
+	sums := make([]sql.NullString, len(sumColumns))
+	dest := []any{&rows}
+	for i := range sums {
+		dest = append(dest, &sums[i])
+	}
+	if err := db.QueryRowContext(ctx, query).Scan(dest...); err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	aggregates := &Aggregates{Rows: rows}
+	for _, sum := range sums {
+		if sum.Valid {
+			aggregates.Sums = append(aggregates.Sums, sum.String)
+		} else {
+			aggregates.Sums = append(aggregates.Sums, "0")
+		}
+	}
+	return aggregates, nil
+}
+
+// Compare returns the differences of the aggregates of the target from the aggregates of the source,
+// the sums are compared as numbers since the data warehouses format the decimals differently.
+func Compare(source, target *Aggregates, sumColumns []SumColumn) []string {
+	var diffs []string
+	if source.Rows != target.Rows {
+		diffs = append(diffs, fmt.Sprintf("row count: source %d, target %d", source.Rows, target.Rows))
+	}
+	for i, column := range sumColumns {
+		var sourceSum, targetSum string
+		if i < len(source.Sums) {
+			sourceSum = source.Sums[i]
+		}
+		if i < len(target.Sums) {
+			targetSum = target.Sums[i]
+		}
+		if !equalNumbers(sourceSum, targetSum) {
+			diffs = append(diffs, fmt.Sprintf("sum of %s: source %s, target %s", column.Name, sourceSum, targetSum))
+		}
+	}
+	return diffs
+}
+
+func equalNumbers(a, b string) bool {
+	x, ok := new(big.Rat).SetString(a)
+	if !ok {
+		return a == b
+	}
+	y, ok := new(big.Rat).SetString(b)
+	if !ok {
+		return false
+	}
+	return x.Cmp(y) == 0
+}
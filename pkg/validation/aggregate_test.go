@@ -0,0 +1,50 @@
+package validation_test
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumColumns(t *testing.T) {
+	columns := []cloudstorage.TableCol{
+		{Name: "name", Tp: "varchar", Precision: "20"},
+		{Name: "amount", Tp: "decimal", Precision: "12", Scale: "2"},
+		{Name: "ratio", Tp: "double"},
+		{Name: "huge", Tp: "decimal", Precision: "65", Scale: "30"},
+		{Name: "id", Tp: "BIGINT UNSIGNED", IsPK: "true"},
+		{Name: "qty", Tp: "int"},
+		{Name: "age", Tp: "tinyint"},
+	}
+	require.Equal(t, []validation.SumColumn{
+		{Name: "id"},
+		{Name: "amount", Scale: 2},
+		{Name: "qty"},
+	}, validation.ChecksumColumns(columns, 3))
+	require.Len(t, validation.ChecksumColumns(columns, validation.DefaultChecksumColumns), 4)
+	require.Empty(t, validation.ChecksumColumns(columns, 0))
+}
+
+func TestGenAggregateQuery(t *testing.T) {
+	sumColumns := []validation.SumColumn{{Name: "id"}, {Name: "amount", Scale: 2}}
+	require.Equal(t, "SELECT COUNT(*), SUM(id), SUM(amount) FROM `test`.`t`",
+		validation.GenAggregateQuery("`test`.`t`", sumColumns, "", nil))
+	require.Equal(t, "SELECT COUNT(*), SUM(CAST(id AS NUMERIC(38, 0))), SUM(CAST(amount AS NUMERIC(38, 2))) FROM t",
+		validation.GenAggregateQuery("t", sumColumns, "NUMERIC", nil))
+	require.Equal(t, "SELECT COUNT(*) FROM t", validation.GenAggregateQuery("t", nil, "NUMERIC", nil))
+	quote := func(name string) string { return `"` + name + `"` }
+	require.Equal(t, `SELECT COUNT(*), SUM("id"), SUM("amount") FROM t`, validation.GenAggregateQuery("t", sumColumns, "", quote))
+}
+
+func TestCompare(t *testing.T) {
+	sumColumns := []validation.SumColumn{{Name: "id"}, {Name: "amount", Scale: 2}}
+	source := &validation.Aggregates{Rows: 3, Sums: []string{"6", "10.50"}}
+
+	require.Empty(t, validation.Compare(source, &validation.Aggregates{Rows: 3, Sums: []string{"6", "10.5"}}, sumColumns))
+	require.Equal(t, []string{
+		"row count: source 3, target 2",
+		"sum of amount: source 10.50, target 7.25",
+	}, validation.Compare(source, &validation.Aggregates{Rows: 2, Sums: []string{"6.000", "7.25"}}, sumColumns))
+}
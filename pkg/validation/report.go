@@ -0,0 +1,58 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// ReportFile is the file in the snapshot storage recording the validation of the loaded snapshot
+const ReportFile = "validation.json"
+
+// Report is the validation of the snapshot of the tables, the tables are validated once they are loaded
+type Report struct {
+	SnapshotTSO uint64                  `json:"snapshot_tso"`
+	Tables      map[string]*TableReport `json:"tables"`
+}
+
+// TableReport is the comparison of the aggregates of a table in TiDB at the snapshot TSO and in the data warehouse
+type TableReport struct {
+	ValidatedAt time.Time   `json:"validated_at"`
+	SumColumns  []string    `json:"sum_columns,omitempty"`
+	Source      *Aggregates `json:"source"`
+	Target      *Aggregates `json:"target"`
+	Mismatches  []string    `json:"mismatches,omitempty"`
+	Passed      bool        `json:"passed"`
+}
+
+// ReadReport returns the report in the storage, an empty report if it does not exist
+func ReadReport(ctx context.Context, extStorage storage.ExternalStorage) (*Report, error) {
+	report := &Report{Tables: make(map[string]*TableReport)}
+	exist, err := extStorage.FileExists(ctx, ReportFile)
+	if err != nil || !exist {
+		return report, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, ReportFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(data, report); err != nil {
+		return nil, errors.Annotatef(err, "Invalid %s", ReportFile)
+	}
+	if report.Tables == nil {
+		report.Tables = make(map[string]*TableReport)
+	}
+	return report, nil
+}
+
+// Write writes the report into the storage
+func (r *Report) Write(ctx context.Context, extStorage storage.ExternalStorage) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, ReportFile, data))
+}
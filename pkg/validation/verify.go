@@ -0,0 +1,306 @@
+package validation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// KeyRange is a range of the first primary key column, the buckets of a table are verified one by one.
+// Lower is inclusive and Upper is exclusive, an empty bound is unbounded.
+type KeyRange struct {
+	Column string `json:"column,omitempty"`
+	Lower  string `json:"lower,omitempty"`
+	Upper  string `json:"upper,omitempty"`
+}
+
+// Predicate returns the condition of the rows in the range, empty if the range is unbounded.
+// The bounds are integers, so they are written as they are.
+func (r KeyRange) Predicate(quoteIdent func(string) string) string {
+	var conds []string
+	if r.Lower != "" {
+		conds = append(conds, fmt.Sprintf("%s >= %s", quoteIdent(r.Column), r.Lower))
+	}
+	if r.Upper != "" {
+		conds = append(conds, fmt.Sprintf("%s < %s", quoteIdent(r.Column), r.Upper))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// SplitKeyRange splits the integer column between min and max into at most buckets ranges of the same width.
+// The first and the last ranges are unbounded, so the rows out of [min, max] in the data warehouse are verified too.
+func SplitKeyRange(column, min, max string, buckets int) ([]KeyRange, error) {
+	lower, ok := new(big.Int).SetString(min, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid integer %s of %s", min, column)
+	}
+	upper, ok := new(big.Int).SetString(max, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid integer %s of %s", max, column)
+	}
+	width := new(big.Int).Sub(upper, lower)
+	width.Add(width, big.NewInt(1))
+	if width.Cmp(big.NewInt(int64(buckets))) < 0 {
+		buckets = int(width.Int64())
+	}
+	if buckets <= 1 {
+		return []KeyRange{{Column: column}}, nil
+	}
+	width.Div(width, big.NewInt(int64(buckets)))
+	ranges := make([]KeyRange, 0, buckets)
+	bound := lower
+	for i := 0; i < buckets; i++ {
+		r := KeyRange{Column: column}
+		if i > 0 {
+			r.Lower = bound.String()
+		}
+		bound = new(big.Int).Add(bound, width)
+		if i < buckets-1 {
+			r.Upper = bound.String()
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// AppendWhere appends the conditions to the query, the empty conditions are skipped
+func AppendWhere(query string, conds ...string) string {
+	var nonEmpty []string
+	for _, cond := range conds {
+		if cond != "" {
+			nonEmpty = append(nonEmpty, "("+cond+")")
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return query
+	}
+	return fmt.Sprintf("%s WHERE %s", query, strings.Join(nonEmpty, " AND "))
+}
+
+// VerifyColumn is a column whose values are compared row by row, the values are read as strings
+type VerifyColumn struct {
+	Name string `json:"name"`
+	// Numeric columns are compared as numbers since the data warehouses format the decimals differently
+	Numeric bool `json:"numeric,omitempty"`
+}
+
+// VerifyColumns returns the columns compared row by row, the integer, decimal and character columns whose
+// values are read back from the data warehouses as they are in TiDB. ok is false if a primary key column
+// can not be compared, so the rows can not be matched.
+func VerifyColumns(columns []cloudstorage.TableCol) (verifyColumns []VerifyColumn, ok bool) {
+	ok = true
+	for _, column := range columns {
+		verifyColumn, comparable := verifyColumn(column)
+		if comparable {
+			verifyColumns = append(verifyColumns, verifyColumn)
+		} else if column.IsPK == "true" {
+			ok = false
+		}
+	}
+	return verifyColumns, ok
+}
+
+func verifyColumn(column cloudstorage.TableCol) (VerifyColumn, bool) {
+	if _, ok := summableScale(column); ok {
+		return VerifyColumn{Name: column.Name, Numeric: true}, true
+	}
+	switch strings.TrimSpace(strings.TrimSuffix(strings.ToLower(column.Tp), " unsigned")) {
+	case "decimal", "numeric":
+		// the decimals too wide to be summed are still compared
+		return VerifyColumn{Name: column.Name, Numeric: true}, true
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return VerifyColumn{Name: column.Name}, true
+	}
+	return VerifyColumn{}, false
+}
+
+// IsIntegerColumn tells whether the table can be split into buckets by ranges of the column
+func IsIntegerColumn(column cloudstorage.TableCol) bool {
+	switch strings.TrimSpace(strings.TrimSuffix(strings.ToLower(column.Tp), " unsigned")) {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		return true
+	}
+	return false
+}
+
+// GenScanQuery returns the query of the values of the columns of the rows matching the conditions as strings,
+// ordered by the primary key and up to limit rows. The values are cast to stringType of the database.
+func GenScanQuery(table string, columns []string, pkColumns []string, stringType string, limit int, quoteIdent func(string) string, conds ...string) string {
+	fields := make([]string, 0, len(columns))
+	for _, column := range columns {
+		fields = append(fields, fmt.Sprintf("CAST(%s AS %s)", quoteIdent(column), stringType))
+	}
+	orderBy := make([]string, 0, len(pkColumns))
+	for _, column := range pkColumns {
+		orderBy = append(orderBy, quoteIdent(column))
+	}
+	query := AppendWhere(fmt.Sprintf("SELECT %s FROM %s", strings.Join(fields, ", "), table), conds...)
+	return fmt.Sprintf("%s ORDER BY %s LIMIT %d", query, strings.Join(orderBy, ", "), limit)
+}
+
+// Row is the values of the columns of a row read as strings, a NULL is not valid
+type Row []sql.NullString
+
+// RowsQueryer is a *sql.DB or a *sql.Conn
+type RowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// QueryRows runs the query generated by GenScanQuery
+func QueryRows(ctx context.Context, db RowsQueryer, query string) ([]Row, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, diag.WrapSQL(err, query)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var result []Row
+	for rows.Next() {
+		row := make(Row, len(columns))
+		dest := make([]any, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, diag.WrapSQL(err, query)
+		}
+		result = append(result, row)
+	}
+	return result, diag.WrapSQL(rows.Err(), query)
+}
+
+// RowMismatch is a row differing between TiDB and the data warehouse, identified by its primary key
+type RowMismatch struct {
+	Key []string `json:"key"`
+	// MissingInTarget is a row in TiDB but not in the data warehouse, MissingInSource is the opposite
+	MissingInTarget bool                 `json:"missing_in_target,omitempty"`
+	MissingInSource bool                 `json:"missing_in_source,omitempty"`
+	Columns         map[string]ValueDiff `json:"columns,omitempty"`
+}
+
+// ValueDiff is a value differing between TiDB and the data warehouse, a NULL is nil
+type ValueDiff struct {
+	Source *string `json:"source"`
+	Target *string `json:"target"`
+}
+
+// DiffRows returns the rows differing between the rows of TiDB and the data warehouse read by GenScanQuery with
+// the same columns, the first pkCount columns are the primary key. The rows are matched by their primary key
+// and the result is in the order of the rows of TiDB then the rows only in the data warehouse.
+func DiffRows(columns []VerifyColumn, pkCount int, source, target []Row) []RowMismatch {
+	key := func(row Row) string {
+		parts := make([]string, 0, pkCount)
+		for i := 0; i < pkCount; i++ {
+			parts = append(parts, normalizeValue(columns[i], row[i]))
+		}
+		return strings.Join(parts, "\x00")
+	}
+	targetRows := make(map[string]Row, len(target))
+	for _, row := range target {
+		targetRows[key(row)] = row
+	}
+	var mismatches []RowMismatch
+	for _, row := range source {
+		k := key(row)
+		targetRow, ok := targetRows[k]
+		if !ok {
+			mismatches = append(mismatches, RowMismatch{Key: rowKey(row, pkCount), MissingInTarget: true})
+			continue
+		}
+		delete(targetRows, k)
+		var diffs map[string]ValueDiff
+		for i := pkCount; i < len(columns); i++ {
+			if normalizeValue(columns[i], row[i]) == normalizeValue(columns[i], targetRow[i]) {
+				continue
+			}
+			if diffs == nil {
+				diffs = make(map[string]ValueDiff)
+			}
+			diffs[columns[i].Name] = ValueDiff{Source: nullableValue(row[i]), Target: nullableValue(targetRow[i])}
+		}
+		if diffs != nil {
+			mismatches = append(mismatches, RowMismatch{Key: rowKey(row, pkCount), Columns: diffs})
+		}
+	}
+	for _, row := range target {
+		if _, ok := targetRows[key(row)]; ok {
+			mismatches = append(mismatches, RowMismatch{Key: rowKey(row, pkCount), MissingInSource: true})
+		}
+	}
+	return mismatches
+}
+
+// normalizeValue returns the value compared, the numbers are normalized so that 10.50 and 10.5 are equal
+func normalizeValue(column VerifyColumn, value sql.NullString) string {
+	if !value.Valid {
+		return "\x00NULL"
+	}
+	if column.Numeric {
+		if x, ok := new(big.Rat).SetString(strings.TrimSpace(value.String)); ok {
+			return x.RatString()
+		}
+	}
+	return value.String
+}
+
+func nullableValue(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func rowKey(row Row, pkCount int) []string {
+	key := make([]string, 0, pkCount)
+	for i := 0; i < pkCount; i++ {
+		key = append(key, row[i].String)
+	}
+	return key
+}
+
+// VerifyReportFile is the default file of the report of `tidb2dw verify`
+const VerifyReportFile = "verify.json"
+
+// VerifyReport is the comparison of the tables in TiDB at a TSO and in the data warehouse
+type VerifyReport struct {
+	TSO        uint64                        `json:"tso"`
+	StartedAt  time.Time                     `json:"started_at"`
+	FinishedAt time.Time                     `json:"finished_at"`
+	Tables     map[string]*TableVerification `json:"tables"`
+	Passed     bool                          `json:"passed"`
+}
+
+// TableVerification is the comparison of a table, bucket by bucket of its primary key
+type TableVerification struct {
+	SumColumns []string    `json:"sum_columns,omitempty"`
+	Source     *Aggregates `json:"source,omitempty"`
+	Target     *Aggregates `json:"target,omitempty"`
+	// KeyColumn is the column the table is split into buckets by, empty if the table is verified as one bucket
+	KeyColumn         string           `json:"key_column,omitempty"`
+	Buckets           int              `json:"buckets"`
+	MismatchedBuckets []BucketMismatch `json:"mismatched_buckets,omitempty"`
+	// Mismatches are a sample of the rows differing in the mismatched buckets
+	Mismatches []RowMismatch `json:"mismatches,omitempty"`
+	// SampleSkipped tells why the rows differing are not sampled
+	SampleSkipped string `json:"sample_skipped,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Passed        bool   `json:"passed"`
+}
+
+// BucketMismatch is a bucket whose aggregates differ between TiDB and the data warehouse
+type BucketMismatch struct {
+	Range       KeyRange    `json:"range"`
+	Source      *Aggregates `json:"source"`
+	Target      *Aggregates `json:"target"`
+	Differences []string    `json:"differences"`
+}
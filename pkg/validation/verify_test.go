@@ -0,0 +1,98 @@
+package validation_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitKeyRange(t *testing.T) {
+	ranges, err := validation.SplitKeyRange("id", "1", "100", 4)
+	require.NoError(t, err)
+	require.Equal(t, []validation.KeyRange{
+		{Column: "id", Upper: "26"},
+		{Column: "id", Lower: "26", Upper: "51"},
+		{Column: "id", Lower: "51", Upper: "76"},
+		{Column: "id", Lower: "76"},
+	}, ranges)
+
+	// no more buckets than the keys
+	ranges, err = validation.SplitKeyRange("id", "-1", "0", 4)
+	require.NoError(t, err)
+	require.Equal(t, []validation.KeyRange{{Column: "id", Upper: "0"}, {Column: "id", Lower: "0"}}, ranges)
+
+	ranges, err = validation.SplitKeyRange("id", "18446744073709551615", "18446744073709551615", 4)
+	require.NoError(t, err)
+	require.Equal(t, []validation.KeyRange{{Column: "id"}}, ranges)
+
+	_, err = validation.SplitKeyRange("id", "a", "1", 4)
+	require.ErrorContains(t, err, "invalid integer a of id")
+}
+
+func TestAppendWhere(t *testing.T) {
+	quote := func(name string) string { return `"` + name + `"` }
+	require.Equal(t, "", validation.KeyRange{Column: "id"}.Predicate(quote))
+	require.Equal(t, `"id" >= 5 AND "id" < 10`, validation.KeyRange{Column: "id", Lower: "5", Upper: "10"}.Predicate(quote))
+
+	require.Equal(t, "SELECT 1 FROM t", validation.AppendWhere("SELECT 1 FROM t", "", ""))
+	require.Equal(t, `SELECT 1 FROM t WHERE (a = 1 OR b = 2) AND ("id" < 10)`,
+		validation.AppendWhere("SELECT 1 FROM t", "a = 1 OR b = 2", "", validation.KeyRange{Column: "id", Upper: "10"}.Predicate(quote)))
+}
+
+func TestGenScanQuery(t *testing.T) {
+	quote := func(name string) string { return `"` + name + `"` }
+	require.Equal(t, `SELECT CAST("id" AS VARCHAR), CAST("name" AS VARCHAR) FROM t WHERE ("id" >= 5) ORDER BY "id" LIMIT 100`,
+		validation.GenScanQuery("t", []string{"id", "name"}, []string{"id"}, "VARCHAR", 100, quote, `"id" >= 5`))
+}
+
+func TestVerifyColumns(t *testing.T) {
+	columns := []cloudstorage.TableCol{
+		{Name: "id", Tp: "BIGINT UNSIGNED", IsPK: "true"},
+		{Name: "name", Tp: "varchar", Precision: "20"},
+		{Name: "amount", Tp: "decimal", Precision: "12", Scale: "2"},
+		{Name: "huge", Tp: "decimal", Precision: "65", Scale: "30"},
+		{Name: "ratio", Tp: "double"},
+		{Name: "created", Tp: "datetime"},
+	}
+	verifyColumns, ok := validation.VerifyColumns(columns)
+	require.True(t, ok)
+	require.Equal(t, []validation.VerifyColumn{
+		{Name: "id", Numeric: true},
+		{Name: "name"},
+		{Name: "amount", Numeric: true},
+		{Name: "huge", Numeric: true},
+	}, verifyColumns)
+	require.True(t, validation.IsIntegerColumn(columns[0]))
+	require.False(t, validation.IsIntegerColumn(columns[2]))
+
+	columns[5].IsPK = "true"
+	_, ok = validation.VerifyColumns(columns)
+	require.False(t, ok)
+}
+
+func TestDiffRows(t *testing.T) {
+	value := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	columns := []validation.VerifyColumn{{Name: "id", Numeric: true}, {Name: "name"}, {Name: "amount", Numeric: true}}
+	source := []validation.Row{
+		{value("1"), value("a"), value("10.50")},
+		{value("2"), value("b"), value("1")},
+		{value("3"), value("c"), {}},
+		{value("4"), value("d"), value("4")},
+	}
+	target := []validation.Row{
+		{value("1"), value("a"), value("10.5")},
+		{value("2"), value("B"), value("1")},
+		{value("3"), value("c"), value("0")},
+		{value("5"), value("e"), value("5")},
+	}
+	b, c, zero := "b", "B", "0"
+	require.Equal(t, []validation.RowMismatch{
+		{Key: []string{"2"}, Columns: map[string]validation.ValueDiff{"name": {Source: &b, Target: &c}}},
+		{Key: []string{"3"}, Columns: map[string]validation.ValueDiff{"amount": {Target: &zero}}},
+		{Key: []string{"4"}, MissingInTarget: true},
+		{Key: []string{"5"}, MissingInSource: true},
+	}, validation.DiffRows(columns, 1, source, target))
+}
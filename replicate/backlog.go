@@ -0,0 +1,72 @@
+package replicate
+
+import (
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+)
+
+const (
+	// backlogWindow is the number of recent batches the throughput is observed over
+	backlogWindow = 10
+	// backlogLogInterval is the interval of the backlog summary log
+	backlogLogInterval = time.Minute
+)
+
+type backlogSample struct {
+	at    time.Time
+	files int
+	bytes int64
+	// merged is the total bytes merged before the sample
+	merged int64
+}
+
+// backlogTracker estimates when the increment files are caught up. Files are deleted once
+// merged, so the backlog is every DML file found by the LIST of a round.
+type backlogTracker struct {
+	samples []backlogSample
+	merged  int64
+}
+
+// observe records the backlog found by the LIST at the beginning of a round
+func (t *backlogTracker) observe(at time.Time, files int, bytes int64) {
+	t.samples = append(t.samples, backlogSample{at: at, files: files, bytes: bytes, merged: t.merged})
+	if len(t.samples) > backlogWindow+1 {
+		t.samples = t.samples[1:]
+	}
+}
+
+// onMerged records a file merged into the data warehouse
+func (t *backlogTracker) onMerged(bytes int64) {
+	t.merged += bytes
+}
+
+// info returns the latest backlog and the ETA. New files arriving while merging are accounted
+// by using the net change rate of the backlog rather than the merge throughput alone.
+func (t *backlogTracker) info() apiservice.BacklogInfo {
+	if len(t.samples) == 0 {
+		return apiservice.BacklogInfo{ETASeconds: -1}
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	info := apiservice.BacklogInfo{
+		Files:      last.files,
+		Bytes:      last.bytes,
+		ETASeconds: -1,
+		UpdatedAt:  last.at,
+	}
+	if last.bytes == 0 {
+		info.ETASeconds = 0
+	}
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return info
+	}
+	info.MergeBytesPerSecond = float64(last.merged-first.merged) / elapsed
+	drainRate := float64(first.bytes-last.bytes) / elapsed
+	// the rates are sampled at different moments of a round, so the difference may be slightly negative
+	info.ArrivalBytesPerSecond = max(info.MergeBytesPerSecond-drainRate, 0)
+	if last.bytes > 0 && drainRate > 0 {
+		info.ETASeconds = int64(float64(last.bytes) / drainRate)
+	}
+	return info
+}
@@ -0,0 +1,44 @@
+package replicate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBacklogTracker(t *testing.T) {
+	var tracker backlogTracker
+	require.Equal(t, int64(-1), tracker.info().ETASeconds)
+
+	start := time.Now()
+	// 1000 bytes are merged and 400 bytes arrive every 10 seconds
+	backlog := int64(6000)
+	for i := 0; i < 5; i++ {
+		tracker.observe(start.Add(time.Duration(i)*10*time.Second), 6, backlog)
+		tracker.onMerged(1000)
+		backlog += 400 - 1000
+	}
+	info := tracker.info()
+	require.Equal(t, 6, info.Files)
+	require.Equal(t, int64(3600), info.Bytes)
+	require.InDelta(t, 100, info.MergeBytesPerSecond, 0.001)
+	require.InDelta(t, 40, info.ArrivalBytesPerSecond, 0.001)
+	// the backlog shrinks by 60 bytes per second
+	require.Equal(t, int64(60), info.ETASeconds)
+
+	// the backlog is growing
+	tracker.observe(start.Add(50*time.Second), 7, 10000)
+	require.Equal(t, int64(-1), tracker.info().ETASeconds)
+
+	// caught up
+	tracker.observe(start.Add(60*time.Second), 0, 0)
+	require.Equal(t, int64(0), tracker.info().ETASeconds)
+
+	// only the recent batches are observed
+	for i := 0; i < 2*backlogWindow; i++ {
+		tracker.observe(start.Add(time.Duration(70+i)*time.Second), 0, 0)
+	}
+	require.Len(t, tracker.samples, backlogWindow+1)
+	require.Zero(t, tracker.info().MergeBytesPerSecond)
+}
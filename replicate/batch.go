@@ -0,0 +1,191 @@
+package replicate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// BatchPolicy is how the new increment files of a table are accumulated before they are merged, so that the
+// data warehouse is not woken up by every flush of TiCDC. The zero value merges the new files of every round.
+type BatchPolicy struct {
+	// MinRows is the rows of the new files to merge them, 0 means the rows are not counted
+	MinRows int64
+	// MaxInterval is the longest time the new files wait since they are found, 0 means they wait for MinRows only
+	MaxInterval time.Duration
+}
+
+// Enabled returns whether the new files may wait for more files
+func (p BatchPolicy) Enabled() bool {
+	return p.MinRows > 0 || p.MaxInterval > 0
+}
+
+// Validate checks the files do not wait forever for rows which may never arrive
+func (p BatchPolicy) Validate() error {
+	if p.MinRows < 0 {
+		return errors.Errorf("invalid --min-batch-rows %d", p.MinRows)
+	}
+	if p.MaxInterval < 0 {
+		return errors.Errorf("invalid --max-batch-interval %s", p.MaxInterval)
+	}
+	if p.MinRows > 0 && p.MaxInterval == 0 {
+		return errors.New("--min-batch-rows requires --max-batch-interval, so that a few rows are not held forever")
+	}
+	return nil
+}
+
+// batchTracker holds the state of the files waiting for the batch of a table
+type batchTracker struct {
+	// pendingSince is when the files waiting are found first, zero if no file is waiting
+	pendingSince time.Time
+	// rows are the rows counted of the files waiting by path, a file is counted once
+	rows  map[string]int64
+	stats apiservice.BatchStats
+}
+
+// batchReady returns whether the new files are merged by this round, the files waiting are listed again by the
+// next round. The files are merged once either threshold of the policy is hit, a DDL is never held.
+func (sess *IncrementReplicateSession) batchReady(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange, now time.Time) (bool, error) {
+	policy := sess.scheduler.BatchPolicy()
+	if !policy.Enabled() || len(dmlFileMap) == 0 {
+		return true, nil
+	}
+	for key := range dmlFileMap {
+		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
+			return true, nil
+		}
+	}
+	if sess.batch.pendingSince.IsZero() {
+		sess.batch.pendingSince = now
+	}
+	if policy.MaxInterval > 0 && now.Sub(sess.batch.pendingSince) >= policy.MaxInterval {
+		return true, nil
+	}
+	if policy.MinRows > 0 {
+		rows, err := sess.countBatchRows(dmlFileMap)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if rows >= policy.MinRows {
+			return true, nil
+		}
+		sess.logger.Debug("Increment files wait for more rows", zap.Int64("rows", rows), zap.Int64("minBatchRows", policy.MinRows))
+	}
+	return false, nil
+}
+
+// deferBatch forgets the new files found by the round, so that they are found again by the next round
+func (sess *IncrementReplicateSession) deferBatch(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange) {
+	sess.forgetFiles(dmlFileMap)
+	sess.batch.stats.Deferred++
+	sess.status.SetTableBatch(sess.tableFQN, sess.batch.stats)
+}
+
+// forgetFiles forgets the new files found by the round without merging them
+func (sess *IncrementReplicateSession) forgetFiles(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange) {
+	for key, fileRange := range dmlFileMap {
+		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
+			// a schema file is parsed once per table version, its DDL is applied after it is parsed again
+			delete(sess.tableDefMap, key.TableVersion)
+		}
+		if fileRange.start > 1 {
+			sess.tableDMLIdxMap[key] = fileRange.start - 1
+		} else {
+			delete(sess.tableDMLIdxMap, key)
+		}
+	}
+}
+
+// onBatchMerged records the new files merged by the round
+func (sess *IncrementReplicateSession) onBatchMerged(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange, now time.Time) {
+	paths := sess.batchFilePaths(dmlFileMap)
+	if len(paths) > 0 {
+		stats := &sess.batch.stats
+		stats.Merges++
+		stats.LastFiles, stats.LastBytes, stats.LastRows = len(paths), 0, 0
+		for _, path := range paths {
+			stats.LastBytes += sess.dmlFileSizes[path]
+			stats.LastRows += sess.batch.rows[path]
+		}
+		stats.LastMergedAt = now
+		sess.status.SetTableBatch(sess.tableFQN, *stats)
+	}
+	sess.batch.pendingSince = time.Time{}
+	sess.batch.rows = nil
+}
+
+// countBatchRows returns the rows of the new files, the files counted by the former rounds are not read again
+func (sess *IncrementReplicateSession) countBatchRows(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange) (int64, error) {
+	if sess.batch.rows == nil {
+		sess.batch.rows = make(map[string]int64)
+	}
+	var total int64
+	for _, path := range sess.batchFilePaths(dmlFileMap) {
+		rows, ok := sess.batch.rows[path]
+		if !ok {
+			var err error
+			rowsByType, err := countRowsByType(sess.ctx, sess.externalStorage, path, sess.compression)
+			if err != nil {
+				return 0, errors.Annotatef(err, "Failed to count rows of file %s", path)
+			}
+			for _, n := range rowsByType {
+				rows += n
+			}
+			sess.batch.rows[path] = rows
+		}
+		total += rows
+	}
+	return total, nil
+}
+
+// batchFilePaths returns the paths of the dml files of the ranges found by the last LIST
+func (sess *IncrementReplicateSession) batchFilePaths(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange) []string {
+	var paths []string
+	for path := range sess.dmlFileSizes {
+		key, fileIdx, err := sess.parseDMLFilePath(path)
+		if err != nil {
+			continue
+		}
+		if fileRange, ok := dmlFileMap[key]; ok && fileIdx >= fileRange.start && fileIdx <= fileRange.end {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// countRowsByType returns the rows of the increment file by their operation, e.g. I, U or D. TiCDC writes a row per
+// line, led by the operation.
+func countRowsByType(ctx context.Context, extStorage storage.ExternalStorage, path string, compression utils.Compression) (map[string]int64, error) {
+	if compression != utils.CompressionNone {
+		extStorage = storage.WithCompression(extStorage, compression.CompressType())
+	}
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	rows := make(map[string]int64, 3)
+	br := bufio.NewReader(reader)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			op, _, _ := bytes.Cut(line, []byte(","))
+			rows[string(bytes.Trim(op, `"`))]++
+		}
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
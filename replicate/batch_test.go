@@ -0,0 +1,256 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchPolicy(t *testing.T) {
+	require.False(t, BatchPolicy{}.Enabled())
+	require.NoError(t, BatchPolicy{}.Validate())
+	require.NoError(t, BatchPolicy{MaxInterval: time.Minute}.Validate())
+	require.NoError(t, BatchPolicy{MinRows: 100, MaxInterval: time.Minute}.Validate())
+	require.Error(t, BatchPolicy{MinRows: 100}.Validate())
+	require.Error(t, BatchPolicy{MaxInterval: -time.Minute}.Validate())
+}
+
+func TestBatchReady(t *testing.T) {
+	ctx := context.Background()
+	status := apiservice.NewAPIInfo()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{MinRows: 3, MaxInterval: time.Hour}, []string{"db.t"}, nil, status)
+	require.NoError(t, err)
+	sess := newTestSession(t, nil, withScheduler(scheduler))
+	extStorage := sess.externalStorage
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE t (id INT)",
+	})
+	// the DDL is never held
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	now := time.Now()
+	ready, err := sess.batchReady(files, now)
+	require.NoError(t, err)
+	require.True(t, ready)
+	sess.onBatchMerged(files, now)
+
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/100/2024-01-01/CDC000001.csv", []byte("\"I\",\"t\",\"db\",1,1\n\"I\",\"t\",\"db\",2,2\n")))
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	ready, err = sess.batchReady(files, now)
+	require.NoError(t, err)
+	require.False(t, ready)
+	sess.deferBatch(files)
+
+	// the file held is found again with the next file
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/100/2024-01-01/CDC000002.csv", []byte("\"I\",\"t\",\"db\",3,3\n")))
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	key := cloudstorage.DmlPathKey{SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100}, Date: "2024-01-01"}
+	require.Equal(t, map[cloudstorage.DmlPathKey]fileIndexRange{key: {start: 1, end: 2}}, files)
+	ready, err = sess.batchReady(files, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, ready)
+	sess.onBatchMerged(files, now.Add(time.Minute))
+	batch := status.Status().TablesInfo["db.t"].Batch
+	require.Equal(t, int64(1), batch.Merges)
+	require.Equal(t, int64(1), batch.Deferred)
+	require.Equal(t, 2, batch.LastFiles)
+	require.Equal(t, int64(3), batch.LastRows)
+
+	// the files waiting for longer than the max interval are merged however few rows they have
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/100/2024-01-01/CDC000003.csv", []byte("\"I\",\"t\",\"db\",4,4\n")))
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	ready, err = sess.batchReady(files, now)
+	require.NoError(t, err)
+	require.False(t, ready)
+	sess.deferBatch(files)
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	ready, err = sess.batchReady(files, now.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, ready)
+}
+
+func TestCountRowsByType(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	content := "I,t,db,440000000000000001,1\n\"U\",t,db,440000000000000002,1\nD,t,db,440000000000000003,1\r\n\nI,t,db,440000000000000004,2"
+	require.NoError(t, extStorage.WriteFile(ctx, "a.csv", []byte(content)))
+	rows, err := countRowsByType(ctx, extStorage, "a.csv", utils.CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"I": 2, "U": 1, "D": 1}, rows)
+}
+
+func TestLoadIncrementBatch(t *testing.T) {
+	ctx := context.Background()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	connector := &fakeConnector{}
+	sess := newTestSession(t, fakeBatchLoader{connector}, withScheduler(scheduler))
+	extStorage, status := sess.externalStorage, sess.status
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+	})
+	filePath := func(i int) string {
+		return fmt.Sprintf("db/t/100/2024-01-01/CDC%020d.csv", i)
+	}
+	for i := 1; i <= 3; i++ {
+		row := fmt.Sprintf("\"I\",\"t\",\"db\",%d,%d\n", 400+i, i)
+		require.NoError(t, extStorage.WriteFile(ctx, filePath(i), []byte(row)))
+	}
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	// the files are loaded at once, whatever the workers preparing them
+	require.NoError(t, sess.handleNewFiles(files, 2))
+	require.Equal(t, [][]string{{filePath(1), filePath(2), filePath(3)}}, connector.loads)
+
+	// the checkpoint is advanced to the last file
+	key := cloudstorage.DmlPathKey{SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100}, Date: "2024-01-01"}
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 3}, sess.checkpoint.mergedFiles("db", "t"))
+	require.Equal(t, int64(3), sess.loadStats.FilesLoaded)
+	require.Equal(t, int64(3), status.Status().IncrementLoad.FilesLoaded)
+	require.Equal(t, uint64(403), status.LoadedCommitTs()["db.t"].LastLoadedCommitTs)
+
+	// a single file is loaded by itself
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(4), []byte("\"I\",\"t\",\"db\",404,4\n")))
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 2))
+	require.Equal(t, []string{filePath(4)}, connector.loads[1])
+
+	// the files of the dates found since the last merge are loaded at once, and the checkpoint of each date advanced
+	nextDayPath := "db/t/100/2024-01-02/CDC00000000000000000001.csv"
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(5), []byte("\"I\",\"t\",\"db\",405,5\n")))
+	require.NoError(t, extStorage.WriteFile(ctx, nextDayPath, []byte("\"I\",\"t\",\"db\",406,6\n")))
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 2))
+	require.Equal(t, []string{filePath(5), nextDayPath}, connector.loads[2])
+	nextDay := key
+	nextDay.Date = "2024-01-02"
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 5, nextDay: 1}, sess.checkpoint.mergedFiles("db", "t"))
+	require.Equal(t, uint64(406), status.LoadedCommitTs()["db.t"].LastLoadedCommitTs)
+}
+
+func TestSkipAppliedBatch(t *testing.T) {
+	ctx := context.Background()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	connector := &fakeConnector{applied: make(map[string]appliedbatch.Batch)}
+	sess := newTestSession(t, fakeBatchLoader{connector}, withScheduler(scheduler))
+	extStorage, status := sess.externalStorage, sess.status
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+	})
+	filePath := func(i int) string {
+		return fmt.Sprintf("db/t/100/2024-01-01/CDC%020d.csv", i)
+	}
+	for i := 1; i <= 3; i++ {
+		row := fmt.Sprintf("\"I\",\"t\",\"db\",%d,%d\n", 400+i, i)
+		require.NoError(t, extStorage.WriteFile(ctx, filePath(i), []byte(row)))
+	}
+	// the batch of the first two files is applied before the program crashes, the checkpoint is not advanced
+	connector.applied[appliedbatch.ID(filePath(1), 401)] = appliedbatch.Batch{ID: appliedbatch.ID(filePath(1), 401), LastFile: filePath(2), CommitTs: 402}
+
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 2))
+	// the replayed files are skipped, the rest is applied as a batch of its own
+	require.Equal(t, [][]string{{filePath(3)}}, connector.loads)
+	require.Equal(t, appliedbatch.Batch{ID: appliedbatch.ID(filePath(3), 403), LastFile: filePath(3), CommitTs: 403}, connector.applied[appliedbatch.ID(filePath(3), 403)])
+	key := cloudstorage.DmlPathKey{SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100}, Date: "2024-01-01"}
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 3}, sess.checkpoint.mergedFiles("db", "t"))
+	require.Equal(t, int64(1), sess.loadStats.FilesLoaded)
+	require.Equal(t, uint64(403), status.LoadedCommitTs()["db.t"].LastLoadedCommitTs)
+
+	// a batch applied as a whole only advances the checkpoint
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(4), []byte("\"I\",\"t\",\"db\",404,4\n")))
+	connector.applied[appliedbatch.ID(filePath(4), 404)] = appliedbatch.Batch{ID: appliedbatch.ID(filePath(4), 404), LastFile: filePath(4), CommitTs: 404}
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 2))
+	require.Len(t, connector.loads, 1)
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 4}, sess.checkpoint.mergedFiles("db", "t"))
+	require.Equal(t, uint64(404), status.LoadedCommitTs()["db.t"].LastLoadedCommitTs)
+}
+
+func TestReportBadRows(t *testing.T) {
+	ctx := context.Background()
+	workspace, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	scheduler.SetBadRowsWorkspace(workspace)
+	connector := &fakeConnector{}
+	// a row of each load is rejected
+	connector.onLoad = func(filePaths []string) error {
+		connector.rejects = badrows.Rejects{Count: 1, Rows: []badrows.Row{{File: filePaths[0], Line: 1, Column: "v", Reason: "too long"}}}
+		return nil
+	}
+	sess := newTestSession(t, fakeBatchLoader{connector}, withScheduler(scheduler))
+	extStorage, status := sess.externalStorage, sess.status
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns:      []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}, {ID: "2", Name: "v", Tp: "varchar"}},
+		TotalColumns: 2,
+	})
+	for i := 1; i <= 2; i++ {
+		row := fmt.Sprintf("\"I\",\"t\",\"db\",%d,%d,\"v\"\n", 400+i, i)
+		require.NoError(t, extStorage.WriteFile(ctx, fmt.Sprintf("db/t/100/2024-01-01/CDC%020d.csv", i), []byte(row)))
+	}
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 1))
+	require.Len(t, connector.loads, 1)
+
+	// the batch is loaded and its rejected rows are counted and written into the workspace
+	require.Equal(t, int64(1), sess.loadStats.BadRows)
+	require.Equal(t, int64(1), status.Status().IncrementLoad.BadRows)
+	require.Equal(t, int64(1), status.Status().TablesInfo["db.t"].IncrementLoad.BadRows)
+	batchID := appliedbatch.ID(connector.loads[0][0], 401)
+	data, err := workspace.ReadFile(ctx, badrows.ReportPath(batchID))
+	require.NoError(t, err)
+	var report badrows.Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, "db.t", report.Table)
+	require.Equal(t, batchID, report.BatchID)
+	require.Equal(t, connector.loads[0][0], report.Rows[0].File)
+	require.Equal(t, "v", report.Rows[0].Column)
+}
+
+func TestMergeDeferred(t *testing.T) {
+	connector := &fakeConnector{staged: 5}
+	sess := newTestSession(t, connector)
+	status := sess.status
+	// the rows are merged on a round without new files, and counted with the bytes billed
+	require.NoError(t, sess.mergeDeferred())
+	require.Equal(t, int64(5), sess.loadStats.RowsMerged)
+	require.Equal(t, int64(1<<20), sess.loadStats.BytesBilled)
+	load := status.Status().TablesInfo["db.t"].IncrementLoad
+	require.Equal(t, int64(5), load.RowsMerged)
+	require.Equal(t, int64(1<<20), load.BytesBilled)
+	require.Equal(t, int64(1<<20), status.Status().IncrementLoad.BytesBilled)
+
+	require.NoError(t, sess.mergeDeferred())
+	require.Equal(t, int64(5), sess.loadStats.RowsMerged)
+	require.Equal(t, int64(1<<20), status.Status().IncrementLoad.BytesBilled)
+}
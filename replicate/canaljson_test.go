@@ -0,0 +1,62 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanalJSONFiles(t *testing.T) {
+	ctx := context.Background()
+	sess := newTestSession(t, nil, func(sess *IncrementReplicateSession) {
+		sess.protocol = cdcreader.ProtocolCanalJSON
+	})
+	extStorage := sess.externalStorage
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns: []cloudstorage.TableCol{
+			{ID: "1", Name: "id", Tp: "INT", IsPK: "true"},
+			{ID: "2", Name: "e", Tp: "ENUM"},
+		},
+		TotalColumns: 2,
+		Type:         timodel.ActionCreateTable, Query: "CREATE TABLE t (id INT PRIMARY KEY, e ENUM('x', 'y'))",
+	})
+	require.NoError(t, extStorage.WriteFile(ctx, "db/t/100/2024-01-01/CDC00000000000000000001.json",
+		[]byte(`{"database":"db","table":"t","isDdl":false,"type":"INSERT","mysqlType":{"id":"int","e":"enum"},`+
+			`"data":[{"id":"1","e":"2"}],"_tidb":{"commitTs":440000000000000001}}`+"\r\n")))
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	key := cloudstorage.DmlPathKey{SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100}, Date: "2024-01-01"}
+	require.Equal(t, fileIndexRange{start: 1, end: 1}, files[key])
+	require.Contains(t, sess.dmlFileSizes, "db/t/100/2024-01-01/CDC00000000000000000001.csv")
+
+	file := sess.prepareDMLFile(sess.getTableDef(100), key, 1, sess.dmlFileSizes["db/t/100/2024-01-01/CDC00000000000000000001.csv"])
+	require.NoError(t, file.err)
+	require.True(t, file.exists)
+	require.Equal(t, "db/t/100/2024-01-01/CDC00000000000000000001.csv", file.path)
+	require.Equal(t, uint64(440000000000000001), file.commitTs)
+	require.Equal(t, map[string]int64{"I": 1}, file.rowsByType)
+	data, err := extStorage.ReadFile(ctx, file.path)
+	require.NoError(t, err)
+	require.Equal(t, "I,t,db,440000000000000001,1,y\r\n", string(data))
+	require.EqualValues(t, len(data), file.size)
+	exist, err := extStorage.FileExists(ctx, "db/t/100/2024-01-01/CDC00000000000000000001.manifest")
+	require.NoError(t, err)
+	require.True(t, exist)
+
+	// the converted files are not found as new files
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	require.NoError(t, sess.deleteDMLFile(file.path))
+	for _, path := range []string{"CDC00000000000000000001.json", "CDC00000000000000000001.csv", "CDC00000000000000000001.manifest"} {
+		exist, err = extStorage.FileExists(ctx, "db/t/100/2024-01-01/"+path)
+		require.NoError(t, err)
+		require.False(t, exist, path)
+	}
+}
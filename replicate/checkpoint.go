@@ -0,0 +1,226 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// IncrementCheckpointFile is the file in the increment storage recording the last file merged into the data
+// warehouse of each table, partition and date, so that a restart continues right after it.
+const IncrementCheckpointFile = "checkpoint"
+
+// IncrementCheckpoint is the checkpoint shared by the increment sessions of all tables, it is written
+// after each file is merged and before the file is deleted.
+type IncrementCheckpoint struct {
+	mu         sync.Mutex
+	extStorage storage.ExternalStorage
+	data       incrementCheckpointData
+}
+
+type incrementCheckpointData struct {
+	Tables map[string][]checkpointPosition `json:"tables"`
+	// Renamed maps a table given by --table to its current name if it is renamed
+	Renamed map[string]string `json:"renamed,omitempty"`
+	// Created are the tables created after the changefeed starts and replicated by --allow-new-tables
+	Created []string `json:"created,omitempty"`
+	// SchemaVersions is the table version of the last schema file whose DDL is fully applied to each table
+	SchemaVersions map[string]uint64 `json:"schema_versions,omitempty"`
+}
+
+// checkpointPosition is the last merged file of a dml path, which is <table version>/<partition>/<date>
+type checkpointPosition struct {
+	TableVersion uint64 `json:"table_version"`
+	PartitionNum int64  `json:"partition_num"`
+	Date         string `json:"date"`
+	FileIndex    uint64 `json:"file_index"`
+	// CommitTs is the commit ts of the last row of the file, 0 if the file is empty
+	CommitTs uint64 `json:"commit_ts,omitempty"`
+	// Format is the staging format the file is loaded in, empty if recorded by an older version, which loads CSV only
+	Format stagingformat.Format `json:"format,omitempty"`
+}
+
+func (p checkpointPosition) matches(key cloudstorage.DmlPathKey) bool {
+	return p.TableVersion == key.TableVersion && p.PartitionNum == key.PartitionNum && p.Date == key.Date
+}
+
+// NewIncrementCheckpoint returns an empty checkpoint, the previous one in the storage is overwritten
+func NewIncrementCheckpoint(extStorage storage.ExternalStorage) *IncrementCheckpoint {
+	return &IncrementCheckpoint{
+		extStorage: extStorage,
+		data:       incrementCheckpointData{Tables: make(map[string][]checkpointPosition)},
+	}
+}
+
+// LoadIncrementCheckpoint reads the checkpoint written before the restart, it is empty if there is none
+func LoadIncrementCheckpoint(ctx context.Context, extStorage storage.ExternalStorage) (*IncrementCheckpoint, error) {
+	checkpoint := NewIncrementCheckpoint(extStorage)
+	exists, err := extStorage.FileExists(ctx, IncrementCheckpointFile)
+	if err != nil || !exists {
+		return checkpoint, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, IncrementCheckpointFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(data, &checkpoint.data); err != nil {
+		return nil, errors.Annotatef(err, "invalid increment checkpoint %s", IncrementCheckpointFile)
+	}
+	if checkpoint.data.Tables == nil {
+		checkpoint.data.Tables = make(map[string][]checkpointPosition)
+	}
+	return checkpoint, nil
+}
+
+// mergedFiles returns the index of the last merged file of each dml path of the table
+func (c *IncrementCheckpoint) mergedFiles(sourceDatabase, sourceTable string) map[cloudstorage.DmlPathKey]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	positions := c.data.Tables[sourceDatabase+"."+sourceTable]
+	merged := make(map[cloudstorage.DmlPathKey]uint64, len(positions))
+	for _, p := range positions {
+		key := cloudstorage.DmlPathKey{
+			SchemaPathKey: cloudstorage.SchemaPathKey{Schema: sourceDatabase, Table: sourceTable, TableVersion: p.TableVersion},
+			PartitionNum:  p.PartitionNum,
+			Date:          p.Date,
+		}
+		merged[key] = p.FileIndex
+	}
+	return merged
+}
+
+// isMerged returns whether the file of the dml path is merged
+func (c *IncrementCheckpoint) isMerged(key cloudstorage.DmlPathKey, fileIdx uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, p := range c.data.Tables[key.Schema+"."+key.Table] {
+		if p.matches(key) {
+			return fileIdx <= p.FileIndex
+		}
+	}
+	return false
+}
+
+// renamedTo returns the current name of the table, "" if it is not renamed
+func (c *IncrementCheckpoint) renamedTo(table string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.data.Renamed[table]
+}
+
+// rename records the table is replicated by the new name from now on
+func (c *IncrementCheckpoint) rename(ctx context.Context, table, renamedTo string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.data.Renamed == nil {
+		c.data.Renamed = make(map[string]string)
+	}
+	c.data.Renamed[table] = renamedTo
+	return errors.Trace(c.write(ctx))
+}
+
+// CreatedTables returns the tables created after the changefeed starts which are replicated before the restart
+func (c *IncrementCheckpoint) CreatedTables() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return slices.Clone(c.data.Created)
+}
+
+// AddCreatedTable records the table created after the changefeed starts is replicated, so that it is
+// replicated again after restart, when the query of its CREATE TABLE is cleared from the schema file
+func (c *IncrementCheckpoint) AddCreatedTable(ctx context.Context, table string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if slices.Contains(c.data.Created, table) {
+		return nil
+	}
+	c.data.Created = append(c.data.Created, table)
+	return errors.Trace(c.write(ctx))
+}
+
+// appliedSchemaVersion returns the table version of the last schema file whose DDL is fully applied to the table,
+// 0 if none is recorded
+func (c *IncrementCheckpoint) appliedSchemaVersion(table string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.data.SchemaVersions[table]
+}
+
+// applySchemaVersion records the DDL of the schema file of the table version is fully applied to the table, so that
+// it is not executed again if the program restarts before the query of the schema file is cleared
+func (c *IncrementCheckpoint) applySchemaVersion(ctx context.Context, table string, tableVersion uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.data.SchemaVersions == nil {
+		c.data.SchemaVersions = make(map[string]uint64)
+	}
+	c.data.SchemaVersions[table] = tableVersion
+	return errors.Trace(c.write(ctx))
+}
+
+// mergedFile is the last file of a key merged by a batch
+type mergedFile struct {
+	key      cloudstorage.DmlPathKey
+	fileIdx  uint64
+	commitTs uint64
+	format   stagingformat.Format
+}
+
+// advance records the file is merged in the staging format and writes the checkpoint
+func (c *IncrementCheckpoint) advance(ctx context.Context, key cloudstorage.DmlPathKey, fileIdx uint64, commitTs uint64, format stagingformat.Format) error {
+	return c.advanceBatch(ctx, []mergedFile{{key: key, fileIdx: fileIdx, commitTs: commitTs, format: format}})
+}
+
+// advanceBatch records the last files of the keys merged by a batch and writes the checkpoint once, so that a batch
+// of the files of several keys is never recorded in part
+func (c *IncrementCheckpoint) advanceBatch(ctx context.Context, files []mergedFile) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, file := range files {
+		c.setPosition(file.key, checkpointPosition{
+			TableVersion: file.key.TableVersion,
+			PartitionNum: file.key.PartitionNum,
+			Date:         file.key.Date,
+			FileIndex:    file.fileIdx,
+			CommitTs:     file.commitTs,
+			Format:       file.format,
+		})
+	}
+	return errors.Trace(c.write(ctx))
+}
+
+// setPosition replaces the position of the key, it is called with mu held
+func (c *IncrementCheckpoint) setPosition(key cloudstorage.DmlPathKey, position checkpointPosition) {
+	table := key.Schema + "." + key.Table
+	positions := c.data.Tables[table]
+	for i := range positions {
+		if positions[i].matches(key) {
+			positions[i] = position
+			return
+		}
+	}
+	c.data.Tables[table] = append(positions, position)
+}
+
+func (c *IncrementCheckpoint) write(ctx context.Context) error {
+	data, err := json.MarshalIndent(c.data, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.extStorage.WriteFile(ctx, IncrementCheckpointFile, data))
+}
@@ -0,0 +1,172 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	checkpoint, err := LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+	require.Empty(t, checkpoint.mergedFiles("db", "t"))
+
+	key := func(table string, partition int64, date string) cloudstorage.DmlPathKey {
+		return cloudstorage.DmlPathKey{
+			SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: table, TableVersion: 100},
+			PartitionNum:  partition,
+			Date:          date,
+		}
+	}
+	require.NoError(t, checkpoint.advance(ctx, key("t", 0, "2024-01-01"), 1, 10, stagingformat.CSV))
+	require.NoError(t, checkpoint.advance(ctx, key("t", 0, "2024-01-01"), 2, 20, stagingformat.CSV))
+	require.NoError(t, checkpoint.advance(ctx, key("t", 0, "2024-01-02"), 1, 30, stagingformat.Parquet))
+	require.NoError(t, checkpoint.advance(ctx, key("other", 0, "2024-01-01"), 5, 40, stagingformat.CSV))
+
+	// restart
+	checkpoint, err = LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{
+		key("t", 0, "2024-01-01"): 2,
+		key("t", 0, "2024-01-02"): 1,
+	}, checkpoint.mergedFiles("db", "t"))
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{
+		key("other", 0, "2024-01-01"): 5,
+	}, checkpoint.mergedFiles("db", "other"))
+	require.Equal(t, stagingformat.CSV, checkpoint.data.Tables["db.t"][0].Format)
+	require.Equal(t, stagingformat.Parquet, checkpoint.data.Tables["db.t"][1].Format)
+
+	// a new replication overwrites the checkpoint
+	checkpoint = NewIncrementCheckpoint(extStorage)
+	require.NoError(t, checkpoint.advance(ctx, key("t", 1, "2024-01-03"), 1, 50, stagingformat.CSV))
+	checkpoint, err = LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+	require.Empty(t, checkpoint.mergedFiles("db", "other"))
+}
+
+func TestAppliedSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	newSession := func(connector *fakeConnector) *IncrementReplicateSession {
+		checkpoint, err := LoadIncrementCheckpoint(ctx, extStorage)
+		require.NoError(t, err)
+		return newTestSession(t, connector, withStorage(extStorage), func(sess *IncrementReplicateSession) {
+			sess.checkpoint = checkpoint
+		})
+	}
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 200, Version: 1, TotalColumns: 2,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}, {ID: "2", Name: "v", Tp: "int"}},
+		Type:    timodel.ActionAddColumn, Query: "ALTER TABLE `db`.`t` ADD COLUMN `v` INT",
+	}
+	connector := &fakeConnector{}
+	require.NoError(t, newSession(connector).syncExecDDLEvents(tableDef))
+	require.Equal(t, []string{tableDef.Query}, connector.executed)
+
+	// the program restarts before the query of the schema file is cleared
+	connector = &fakeConnector{}
+	sess := newSession(connector)
+	require.Equal(t, uint64(200), sess.checkpoint.appliedSchemaVersion("db.t"))
+	require.NoError(t, sess.syncExecDDLEvents(tableDef))
+	require.Empty(t, connector.executed)
+	require.Len(t, connector.initialized, 1)
+
+	tableDef.TableVersion = 300
+	require.NoError(t, sess.syncExecDDLEvents(tableDef))
+	require.Equal(t, []string{tableDef.Query}, connector.executed)
+}
+
+func TestSchemaVersionsInterleaved(t *testing.T) {
+	ctx := context.Background()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	connector := &fakeConnector{}
+	sess := newTestSession(t, connector, withScheduler(scheduler))
+	extStorage := sess.externalStorage
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "t", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+	columns = append(columns, cloudstorage.TableCol{ID: "2", Name: "c", Tp: "int"})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 2,
+		Type: timodel.ActionAddColumn, Query: "ALTER TABLE `db`.`t` ADD COLUMN `c` INT",
+	})
+	columns = append(columns, cloudstorage.TableCol{ID: "3", Name: "d", Tp: "int"})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 300, Version: 1, Columns: columns, TotalColumns: 3,
+		Type: timodel.ActionAddColumn, Query: "ALTER TABLE `db`.`t` ADD COLUMN `d` INT",
+	})
+	filePath := func(tableVersion, i int) string {
+		return fmt.Sprintf("db/t/%d/2024-01-01/CDC%020d.csv", tableVersion, i)
+	}
+	for _, path := range []string{filePath(300, 1), filePath(100, 2), filePath(200, 1), filePath(100, 1)} {
+		require.NoError(t, extStorage.WriteFile(ctx, path, []byte("\"I\",\"t\",\"db\",1,1\n")))
+	}
+
+	// the DDL of a table version is applied right after the files of the table version before it
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(files, 1))
+	require.Equal(t, []string{
+		filePath(100, 1), filePath(100, 2),
+		"ALTER TABLE `db`.`t` ADD COLUMN `c` INT", filePath(200, 1),
+		"ALTER TABLE `db`.`t` ADD COLUMN `d` INT", filePath(300, 1),
+	}, connector.executed)
+	require.Equal(t, uint64(300), sess.checkpoint.appliedSchemaVersion("db.t"))
+
+	// a file of a table version found after the next DDL is applied is refused
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(200, 2), []byte("\"I\",\"t\",\"db\",2,2\n")))
+	_, err = sess.getNewFiles()
+	require.ErrorContains(t, err, "Found file 2 of table version 200")
+	require.Len(t, connector.executed, 6)
+}
+
+func TestPausedRound(t *testing.T) {
+	ctx := context.Background()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	connector := &fakeConnector{}
+	sess := newTestSession(t, connector, withScheduler(scheduler))
+	extStorage, status := sess.externalStorage, sess.status
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "t", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+	filePath := func(tableVersion, i int) string {
+		return fmt.Sprintf("db/t/%d/2024-01-01/CDC%020d.csv", tableVersion, i)
+	}
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(100, 1), []byte("\"I\",\"t\",\"db\",1,1\n")))
+	status.SetTableStage("db.t", apiservice.TableStageLoadingIncremental)
+
+	// the new files are tracked but not merged while paused
+	scheduler.SetPaused(true)
+	require.Equal(t, apiservice.LoaderStatePaused, status.Status().TablesInfo["db.t"].Loader)
+	require.True(t, status.Status().IncrementPaused)
+	require.NoError(t, sess.runRound(1))
+	columns = append(columns, cloudstorage.TableCol{ID: "2", Name: "c", Tp: "int"})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 2,
+		Type: timodel.ActionAddColumn, Query: "ALTER TABLE `db`.`t` ADD COLUMN `c` INT",
+	})
+	require.NoError(t, extStorage.WriteFile(ctx, filePath(200, 1), []byte("\"I\",\"t\",\"db\",2,2\n")))
+	require.NoError(t, sess.runRound(1))
+	require.Empty(t, connector.executed)
+	require.Equal(t, 2, status.Status().TablesInfo["db.t"].Backlog.Files)
+
+	// the files and the DDL found while paused are merged once resumed
+	scheduler.SetPaused(false)
+	require.Equal(t, apiservice.LoaderStateRunning, status.Status().TablesInfo["db.t"].Loader)
+	require.Nil(t, status.Status().IncrementPausedAt)
+	require.NoError(t, sess.runRound(1))
+	require.Equal(t, []string{filePath(100, 1), "ALTER TABLE `db`.`t` ADD COLUMN `c` INT", filePath(200, 1)}, connector.executed)
+}
@@ -0,0 +1,93 @@
+package replicate
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/config"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// CleanupPolicy is how the increment files are deleted from the storage after they are merged into the data warehouse
+type CleanupPolicy struct {
+	// Enabled deletes the merged files, they are kept in the storage otherwise
+	Enabled bool
+	// Retain keeps the merged files for the duration before deleting them, 0 deletes them once merged.
+	// The files merged before a restart are retained again from the restart.
+	Retain time.Duration
+}
+
+// consumedFile is a file merged into the data warehouse and waiting to be deleted
+type consumedFile struct {
+	path     string
+	mergedAt time.Time
+}
+
+// consume queues the merged file to be deleted after the retention, it is called only after the
+// checkpoint is advanced past the file
+func (sess *IncrementReplicateSession) consume(path string, mergedAt time.Time) {
+	if !sess.cleanup.Enabled {
+		return
+	}
+	if sess.consumedPaths == nil {
+		sess.consumedPaths = make(map[string]struct{})
+	}
+	if _, ok := sess.consumedPaths[path]; ok {
+		return
+	}
+	sess.consumedPaths[path] = struct{}{}
+	sess.consumedFiles = append(sess.consumedFiles, consumedFile{path: path, mergedAt: mergedAt})
+}
+
+// cleanupConsumedFiles deletes the files whose retention has elapsed. A failed deletion does not fail the
+// replication, it is logged and the file is deleted again later.
+func (sess *IncrementReplicateSession) cleanupConsumedFiles(now time.Time) {
+	remaining := sess.consumedFiles[:0]
+	for i, file := range sess.consumedFiles {
+		if now.Sub(file.mergedAt) < sess.cleanup.Retain {
+			remaining = append(remaining, sess.consumedFiles[i:]...)
+			break
+		}
+		if err := sess.deleteDMLFile(file.path); err != nil {
+			sess.logger.Warn("Failed to delete merged increment file, will retry later", zap.String("path", file.path), zap.Error(err))
+			remaining = append(remaining, file)
+			continue
+		}
+		delete(sess.consumedPaths, file.path)
+	}
+	sess.consumedFiles = remaining
+}
+
+// CleanupMergedFiles deletes the increment files and their manifests merged into the data warehouse as recorded
+// by the checkpoint, the files not merged yet are never deleted. It returns the number of files deleted.
+func CleanupMergedFiles(ctx context.Context, extStorage storage.ExternalStorage, checkpoint *IncrementCheckpoint) (int, error) {
+	var merged []string
+	err := extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+		if cloudstorage.IsSchemaFile(path) {
+			return nil
+		}
+		var key cloudstorage.DmlPathKey
+		fileIdx, err := key.ParseDMLFilePath(config.DateSeparatorDay.String(), path)
+		// the pattern is not anchored, e.g. it also matches the files under the dead-letter directory
+		if err != nil || !strings.HasPrefix(path, key.Schema+"/"+key.Table+"/") {
+			return nil
+		}
+		if checkpoint.isMerged(key, fileIdx) {
+			merged = append(merged, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	for i, path := range merged {
+		if err = extStorage.DeleteFile(ctx, path); err != nil {
+			return i, errors.Annotatef(err, "Failed to delete merged increment file %s", path)
+		}
+	}
+	return len(merged), nil
+}
@@ -0,0 +1,96 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupConsumedFiles(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, path := range []string{
+		"db/t/100/2024-01-01/CDC000001.csv", "db/t/100/2024-01-01/CDC000001.manifest",
+		"db/t/100/2024-01-01/CDC000002.csv", "db/t/100/2024-01-01/CDC000002.manifest",
+	} {
+		require.NoError(t, extStorage.WriteFile(ctx, path, []byte("x")))
+	}
+	sess := newTestSession(t, nil, withStorage(extStorage), func(sess *IncrementReplicateSession) {
+		sess.cleanup = CleanupPolicy{Enabled: true, Retain: time.Hour}
+	})
+	exists := func(path string) bool {
+		exist, err := extStorage.FileExists(ctx, path)
+		require.NoError(t, err)
+		return exist
+	}
+
+	start := time.Now()
+	sess.consume("db/t/100/2024-01-01/CDC000001.csv", start)
+	// the file is not deleted yet, so it is found again by the next round
+	sess.consume("db/t/100/2024-01-01/CDC000001.csv", start.Add(time.Minute))
+	// the deletion of a file already gone fails and is retried
+	sess.consume("db/t/100/2024-01-01/CDC000003.csv", start.Add(time.Minute))
+	sess.consume("db/t/100/2024-01-01/CDC000002.csv", start.Add(30*time.Minute))
+	require.Len(t, sess.consumedFiles, 3)
+
+	sess.cleanupConsumedFiles(start.Add(59 * time.Minute))
+	require.True(t, exists("db/t/100/2024-01-01/CDC000001.csv"))
+
+	sess.cleanupConsumedFiles(start.Add(61 * time.Minute))
+	require.False(t, exists("db/t/100/2024-01-01/CDC000001.csv"))
+	require.False(t, exists("db/t/100/2024-01-01/CDC000001.manifest"))
+	require.True(t, exists("db/t/100/2024-01-01/CDC000002.csv"))
+	require.Equal(t, []consumedFile{
+		{path: "db/t/100/2024-01-01/CDC000003.csv", mergedAt: start.Add(time.Minute)},
+		{path: "db/t/100/2024-01-01/CDC000002.csv", mergedAt: start.Add(30 * time.Minute)},
+	}, sess.consumedFiles)
+
+	sess.cleanupConsumedFiles(start.Add(2 * time.Hour))
+	require.False(t, exists("db/t/100/2024-01-01/CDC000002.csv"))
+	require.False(t, exists("db/t/100/2024-01-01/CDC000002.manifest"))
+	require.Len(t, sess.consumedFiles, 1)
+
+	// the merged files are kept if the cleanup is disabled
+	sess = newTestSession(t, nil)
+	sess.consume("db/t/100/2024-01-01/CDC000004.csv", start)
+	require.Empty(t, sess.consumedFiles)
+}
+
+func TestCleanupMergedFiles(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	files := []string{
+		"db/t/meta/schema_100_0000000000.json",
+		"db/t/100/2024-01-01/CDC000001.csv",
+		"db/t/100/2024-01-01/CDC000001.manifest",
+		"db/t/100/2024-01-01/CDC000002.csv",
+		"db/t/100/2024-01-02/CDC000001.csv",
+		"db/t/100/2/2024-01-01/CDC000001.csv",
+		"dead-letter/db/t/100/2024-01-01/CDC000001.csv",
+	}
+	for _, path := range files {
+		require.NoError(t, extStorage.WriteFile(ctx, path, []byte("x")))
+	}
+	checkpoint := NewIncrementCheckpoint(extStorage)
+	key := cloudstorage.DmlPathKey{
+		SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100},
+		Date:          "2024-01-01",
+	}
+	require.NoError(t, checkpoint.advance(ctx, key, 1, 10, stagingformat.CSV))
+
+	deleted, err := CleanupMergedFiles(ctx, extStorage, checkpoint)
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	for _, path := range files {
+		exist, err := extStorage.FileExists(ctx, path)
+		require.NoError(t, err)
+		require.Equal(t, path != "db/t/100/2024-01-01/CDC000001.csv" && path != "db/t/100/2024-01-01/CDC000001.manifest", exist, path)
+	}
+}
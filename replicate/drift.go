@@ -0,0 +1,115 @@
+package replicate
+
+import (
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+)
+
+// SchemaDriftPolicy is how the table in the data warehouse is checked against the columns replicated to it,
+// so that a table altered by hand fails the replication with the columns drifted instead of a confusing MERGE.
+// The zero value never checks the table.
+type SchemaDriftPolicy struct {
+	// Interval is the shortest time between two checks of a table, 0 disables the check
+	Interval time.Duration
+	// AutoReconcile alters the drifted table back to the columns replicated instead of failing the replication
+	AutoReconcile bool
+}
+
+// Validate checks the policy is complete
+func (p SchemaDriftPolicy) Validate() error {
+	if p.Interval < 0 {
+		return errors.Errorf("invalid --schema-check-interval %s", p.Interval)
+	}
+	if p.AutoReconcile && p.Interval == 0 {
+		return errors.New("--auto-reconcile requires --schema-check-interval")
+	}
+	return nil
+}
+
+// driftTracker holds the state of the checks of the schema drift of a table
+type driftTracker struct {
+	// unsupported is true once the connector is found not to detect the drift
+	unsupported bool
+	// deleteModeChecked is true once the table is checked to be created in the delete mode of the connector
+	deleteModeChecked bool
+	info              apiservice.SchemaDriftInfo
+}
+
+// checkSchemaDrift compares the table in the data warehouse with the columns replicated to it once the interval of
+// the policy passes. It is checked before the new files are merged, so that the files are not merged into a drifted
+// table. The data warehouse is resumed for the check like for a load.
+func (sess *IncrementReplicateSession) checkSchemaDrift(now time.Time) error {
+	policy := sess.scheduler.SchemaDriftPolicy()
+	if policy.Interval == 0 || sess.drift.unsupported || now.Sub(sess.drift.info.LastCheckedAt) < policy.Interval {
+		return nil
+	}
+	reconciler, ok := sess.dwConnector.(coreinterfaces.SchemaReconciler)
+	if !ok {
+		sess.logger.Warn("The data warehouse does not support detecting the schema drift, --schema-check-interval is ignored")
+		sess.drift.unsupported = true
+		return nil
+	}
+	release, err := sess.scheduler.acquireLoad(sess.stopCtx, sess.tableFQN)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer release()
+	drift, err := reconciler.DiffSchema(sess.sourceTable)
+	metrics.CountConnectorError(sess.tableFQN, metrics.OpDiffSchema, err)
+	if err != nil {
+		return diag.Warehouse(errors.Annotate(err, "Failed to check the schema drift of the table in the data warehouse"))
+	}
+	if drift == nil {
+		// the columns are not initialized by the schema file yet
+		return nil
+	}
+	info := &sess.drift.info
+	info.Checks++
+	info.LastCheckedAt = now
+	defer func() { sess.status.SetTableSchemaDrift(sess.tableFQN, *info) }()
+	if drift.Empty() {
+		return nil
+	}
+	info.LastDrift = drift.String()
+	if !policy.AutoReconcile {
+		return diag.Schema(errors.Errorf("The table %s in the data warehouse drifts from the columns replicated, "+
+			"e.g. it is altered by hand, alter it back or set --auto-reconcile:\n%s", sess.sourceTable, info.LastDrift))
+	}
+	sess.logger.Warn("Reconciling the table drifted in the data warehouse", zap.String("drift", info.LastDrift))
+	if err := metrics.CountConnectorError(sess.tableFQN, metrics.OpReconcileSchema, reconciler.ReconcileSchema(sess.sourceTable, drift)); err != nil {
+		return diag.Warehouse(errors.Annotatef(err, "Failed to reconcile the table drifted in the data warehouse:\n%s", info.LastDrift))
+	}
+	info.Reconciles++
+	return nil
+}
+
+// checkDeleteMode checks the table in the data warehouse is created in the delete mode of the connector before the
+// first files of the session are merged, so that a table replicated with another --delete-mode is not merged into
+// with the wrong columns. It is checked once, a table created later is created in the mode.
+func (sess *IncrementReplicateSession) checkDeleteMode() error {
+	if sess.drift.deleteModeChecked {
+		return nil
+	}
+	checker, ok := sess.dwConnector.(coreinterfaces.DeleteModeChecker)
+	if !ok {
+		sess.drift.deleteModeChecked = true
+		return nil
+	}
+	release, err := sess.scheduler.acquireLoad(sess.stopCtx, sess.tableFQN)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer release()
+	// the table created in another mode fails with a schema error, which keeps its category
+	if err = checker.CheckDeleteMode(sess.sourceTable); err != nil {
+		return diag.Warehouse(errors.Annotate(err, "Failed to check the delete mode of the table in the data warehouse"))
+	}
+	sess.drift.deleteModeChecked = true
+	return nil
+}
@@ -0,0 +1,93 @@
+package replicate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaDriftPolicy(t *testing.T) {
+	require.NoError(t, SchemaDriftPolicy{}.Validate())
+	require.NoError(t, SchemaDriftPolicy{Interval: time.Minute, AutoReconcile: true}.Validate())
+	require.Error(t, SchemaDriftPolicy{AutoReconcile: true}.Validate())
+	require.Error(t, SchemaDriftPolicy{Interval: -time.Minute}.Validate())
+}
+
+func TestCheckSchemaDrift(t *testing.T) {
+	newSession := func(connector coreinterfaces.Connector, policy SchemaDriftPolicy) (*IncrementReplicateSession, *apiservice.APIInfo) {
+		status := apiservice.NewAPIInfo()
+		scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, status)
+		require.NoError(t, err)
+		require.NoError(t, scheduler.SetSchemaDriftPolicy(policy))
+		return newTestSession(t, connector, withScheduler(scheduler)), status
+	}
+	connector := &fakeConnector{
+		expected: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "BIGINT", Nullable: "false"}, {ID: "2", Name: "v", Tp: "VARCHAR"}},
+		actual:   []tidbsql.WarehouseColumn{{Name: "ID", Type: "BIGINT", Nullable: true}, {Name: "v", Type: "VARCHAR(20)", Nullable: true}},
+	}
+	now := time.Now()
+
+	// a type without parameters matches the type with any, the nullability is compared
+	sess, status := newSession(connector, SchemaDriftPolicy{Interval: time.Minute})
+	err := sess.checkSchemaDrift(now)
+	require.Error(t, err)
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(err))
+	require.Contains(t, err.Error(), "column id: NULL in the data warehouse, expected NOT NULL")
+	require.Equal(t, int64(1), status.Status().TablesInfo["db.t"].SchemaDrift.Checks)
+
+	// the table is checked again after the interval
+	connector.actual[0].Nullable = false
+	connector.actual = append(connector.actual, tidbsql.WarehouseColumn{Name: "extra", Type: "INT", Nullable: true})
+	require.NoError(t, sess.checkSchemaDrift(now.Add(time.Second)))
+	require.Equal(t, 1, connector.diffs)
+	err = sess.checkSchemaDrift(now.Add(time.Minute))
+	require.ErrorContains(t, err, "column extra: INT in the data warehouse, not expected")
+
+	// the drift is altered back with --auto-reconcile
+	sess, status = newSession(connector, SchemaDriftPolicy{Interval: time.Minute, AutoReconcile: true})
+	require.NoError(t, sess.checkSchemaDrift(now))
+	require.Len(t, connector.reconciled, 1)
+	require.Len(t, connector.reconciled[0].Diffs, 1)
+	require.Equal(t, tidbsql.DROP_COLUMN, connector.reconciled[0].Diffs[0].Action)
+	require.Equal(t, int64(1), status.Status().TablesInfo["db.t"].SchemaDrift.Reconciles)
+
+	// disabled by default
+	sess, _ = newSession(connector, SchemaDriftPolicy{})
+	require.NoError(t, sess.checkSchemaDrift(now))
+	require.Equal(t, 3, connector.diffs)
+}
+
+func TestCheckDeleteMode(t *testing.T) {
+	newSession := func(connector coreinterfaces.Connector) *IncrementReplicateSession {
+		scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+		require.NoError(t, err)
+		return newTestSession(t, connector, withScheduler(scheduler))
+	}
+
+	// a table replicated with another mode is not merged into
+	connector := &fakeConnector{deleteMode: deletemode.Soft}
+	sess := newSession(connector)
+	err := sess.checkDeleteMode()
+	require.Error(t, err)
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(err))
+	require.Contains(t, err.Error(), "--delete-mode=hard")
+	connector.deleteMode = deletemode.Hard
+	connector.hasTombstone = true
+	require.ErrorContains(t, sess.checkDeleteMode(), "--delete-mode=soft")
+
+	// the table is checked once per session
+	connector.deleteMode = deletemode.Soft
+	require.NoError(t, sess.checkDeleteMode())
+	require.NoError(t, sess.checkDeleteMode())
+	require.Equal(t, 3, connector.checks)
+
+	// the connectors not supporting the mode are not checked
+	require.NoError(t, newSession(&struct{ coreinterfaces.Connector }{}).checkDeleteMode())
+}
@@ -0,0 +1,104 @@
+package replicate
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tiflow/pkg/config"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// The storage is not read in a dry run, the files of the table are stood for by a placeholder
+// named like the first file dumped by dumpling or written by TiCDC.
+const dryRunFileIndex = 1
+
+// DryRunSnapshot calls the connector as StartReplicateSnapshot does for a table not loaded yet, so that a
+// connector recording the statements renders them. The schema of the table is read from TiDB. The placeholder is
+// the Parquet file converted from the dumped file if the format is Parquet.
+func DryRunSnapshot(
+	dwConnector coreinterfaces.Connector,
+	tableFQN string,
+	tidbConfig *tidbsql.TiDBConfig,
+	compression utils.Compression,
+	format stagingformat.Format,
+) error {
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	tidbPool, err := tidbConfig.OpenDB()
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+
+	if err = dwConnector.CopyTableSchema(sourceDatabase, sourceTable, tidbPool); err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	files := []string{fmt.Sprintf("%s.%s.%09d%s", sourceDatabase, sourceTable, dryRunFileIndex, compression.CSVFileExtension())}
+	if format == stagingformat.Parquet {
+		files[0] = stagingformat.ParquetPath(files[0], compression)
+	}
+	err = dwConnector.LoadSnapshot(sourceTable, files, nil, func([]string) error { return nil })
+	if err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	log.Info("Rendered snapshot load", zap.String("table", tableFQN), zap.Strings("files", files))
+	return nil
+}
+
+// DryRunIncrement calls the connector as StartReplicateIncrement does for an increment file of the current
+// schema of the table, so that a connector recording the statements renders them.
+func DryRunIncrement(
+	dwConnector coreinterfaces.Connector,
+	tableFQN string,
+	tidbConfig *tidbsql.TiDBConfig,
+	storageUri *url.URL,
+	compression utils.Compression,
+	format stagingformat.Format,
+	pklessPolicy pkless.Policy,
+) error {
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	tidbPool, err := tidbConfig.OpenDB()
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	defer tidbPool.Close()
+
+	columns, err := getTableColumns(tidbPool, sourceDatabase, sourceTable)
+	if err != nil {
+		return diag.Source(errors.Trace(err))
+	}
+	if columns, _, err = resolvePKLess(dwConnector, pklessPolicy, tableFQN, columns); err != nil {
+		return errors.Trace(err)
+	}
+	tableDef := cloudstorage.TableDefinition{
+		Schema:       sourceDatabase,
+		Table:        sourceTable,
+		Columns:      columns,
+		TotalColumns: len(columns),
+	}
+	if err = dwConnector.InitSchema(columns); err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	key := cloudstorage.DmlPathKey{
+		SchemaPathKey: cloudstorage.SchemaPathKey{Schema: sourceDatabase, Table: sourceTable},
+		Date:          time.Now().Format("2006-01-02"),
+	}
+	file := key.GenerateDMLFilePath(dryRunFileIndex, compression.CSVFileExtension(), config.DefaultFileIndexWidth)
+	if format == stagingformat.Parquet {
+		file = stagingformat.ParquetPath(file, compression)
+	}
+	if err = dwConnector.LoadIncrement(tableDef, storageUri, file); err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	log.Info("Rendered increment load", zap.String("table", tableFQN), zap.String("file", file))
+	return nil
+}
@@ -0,0 +1,99 @@
+package replicate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// maxIdleCheckInterval caps the interval of checking whether the data warehouse is idle
+const maxIdleCheckInterval = time.Minute
+
+// WarehouseIdler suspends the data warehouse once no increment file is loaded into it for a while, and
+// resumes it before the next file is loaded. The data warehouse is shared by the tables, so the loads of
+// all tables are tracked.
+type WarehouseIdler struct {
+	suspender coreinterfaces.WarehouseSuspender
+	idle      time.Duration
+	status    *apiservice.APIInfo
+
+	mu sync.Mutex
+	// loading is the number of files being loaded
+	loading int
+	info    apiservice.WarehouseIdleInfo
+}
+
+// NewWarehouseIdler creates the idler suspending the data warehouse after it is idle for the duration
+func NewWarehouseIdler(suspender coreinterfaces.WarehouseSuspender, idle time.Duration, status *apiservice.APIInfo) *WarehouseIdler {
+	w := &WarehouseIdler{
+		suspender: suspender,
+		idle:      idle,
+		status:    status,
+		info:      apiservice.WarehouseIdleInfo{SuspendAfter: idle.String(), LastActiveAt: time.Now()},
+	}
+	w.status.SetWarehouseIdle(w.info)
+	return w
+}
+
+// Run checks whether the data warehouse is idle until the context is canceled. A failed suspension is
+// only logged and tried again by the next check, e.g. the data warehouse is suspended by itself.
+func (w *WarehouseIdler) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(w.idle/10+time.Second, maxIdleCheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.suspendIfIdle(time.Now())
+		}
+	}
+}
+
+func (w *WarehouseIdler) suspendIfIdle(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.info.Suspended || w.loading > 0 || now.Sub(w.info.LastActiveAt) < w.idle {
+		return
+	}
+	if err := w.suspender.SuspendWarehouse(); err != nil {
+		log.Warn("Failed to suspend the idle data warehouse", zap.Error(err))
+		return
+	}
+	log.Info("Suspended the idle data warehouse", zap.Duration("idle", now.Sub(w.info.LastActiveAt)))
+	w.info.Suspended = true
+	w.info.Suspends++
+	w.status.SetWarehouseIdle(w.info)
+}
+
+// acquire resumes the data warehouse if it is suspended, release must be called after the file is loaded
+func (w *WarehouseIdler) acquire() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.info.Suspended {
+		if err := w.suspender.ResumeWarehouse(); err != nil {
+			return diag.Warehouse(errors.Annotate(err, "Failed to resume the data warehouse"))
+		}
+		log.Info("Resumed the data warehouse to load increment files")
+		w.info.Suspended = false
+		w.info.Resumes++
+		w.status.SetWarehouseIdle(w.info)
+	}
+	w.loading++
+	return nil
+}
+
+func (w *WarehouseIdler) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loading--
+	w.info.LastActiveAt = time.Now()
+	w.status.SetWarehouseIdle(w.info)
+}
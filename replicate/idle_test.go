@@ -0,0 +1,65 @@
+package replicate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSuspender struct {
+	suspends, resumes int
+	err               error
+}
+
+func (s *fakeSuspender) SuspendWarehouse() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.suspends++
+	return nil
+}
+
+func (s *fakeSuspender) ResumeWarehouse() error {
+	s.resumes++
+	return nil
+}
+
+func TestWarehouseIdler(t *testing.T) {
+	suspender := &fakeSuspender{}
+	status := apiservice.NewAPIInfo()
+	idler := NewWarehouseIdler(suspender, 10*time.Minute, status)
+	start := time.Now()
+
+	// not idle for long enough
+	idler.suspendIfIdle(start.Add(5 * time.Minute))
+	require.Zero(t, suspender.suspends)
+	// a file being loaded keeps the warehouse running
+	require.NoError(t, idler.acquire())
+	idler.suspendIfIdle(start.Add(time.Hour))
+	require.Zero(t, suspender.suspends)
+	idler.release()
+
+	idler.suspendIfIdle(time.Now().Add(11 * time.Minute))
+	require.Equal(t, 1, suspender.suspends)
+	require.True(t, status.Status().Warehouse.Suspended)
+	// suspended only once
+	idler.suspendIfIdle(time.Now().Add(time.Hour))
+	require.Equal(t, 1, suspender.suspends)
+
+	// resumed before the next file is loaded
+	require.NoError(t, idler.acquire())
+	idler.release()
+	require.Equal(t, 1, suspender.resumes)
+	info := status.Status().Warehouse
+	require.False(t, info.Suspended)
+	require.Equal(t, 1, info.Suspends)
+	require.Equal(t, 1, info.Resumes)
+
+	// a failed suspension is tried again later
+	suspender.err = errors.New("warehouse is already suspended")
+	idler.suspendIfIdle(time.Now().Add(time.Hour))
+	require.False(t, status.Status().Warehouse.Suspended)
+}
@@ -2,22 +2,35 @@ package replicate
 
 import (
 	"context"
-	"encoding/json"
+	stderrors "errors"
 	"fmt"
 	"net/url"
-	"os/signal"
 	"strings"
-	"syscall"
+	"sync"
 	"time"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tiflow/pkg/config"
 	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
-	putil "github.com/pingcap/tiflow/pkg/util"
 	"go.uber.org/zap"
 	"golang.org/x/exp/slices"
 )
@@ -36,58 +49,161 @@ type fileIndexRange struct {
 type IncrementReplicateSession struct {
 	dwConnector     coreinterfaces.Connector
 	externalStorage storage.ExternalStorage
-	ctx             context.Context
+	// ctx is not canceled on shutdown so that the file being merged is finished, the session stops
+	// before the next file once stopCtx is canceled
+	ctx     context.Context
+	stopCtx context.Context
+	// checkpoint records the last merged file of each dml path, mergedFileIdx is read from it on start
+	checkpoint    *IncrementCheckpoint
+	mergedFileIdx map[cloudstorage.DmlPathKey]uint64
 	// tableDMLIdxMap maintains a map of <dmlPathKey, max file index>
 	tableDMLIdxMap map[cloudstorage.DmlPathKey]uint64
 	// tableDefMap maintains a map of <tableVersion, tableDef>
-	tableDefMap    map[uint64]*cloudstorage.TableDefinition
-	fileExtension  string
+	tableDefMap   map[uint64]*cloudstorage.TableDefinition
+	compression   utils.Compression
+	fileExtension string
+	// protocol is the protocol of the files written by TiCDC, the canal-json files are converted into the CSV files
+	// next to them before they are loaded. It is set by Run from the scheduler, "" is CSV.
+	protocol cdcreader.Protocol
+	// converter converts the CSV files into the Parquet files next to them before they are loaded, it is set by Run
+	// from the scheduler, nil if the CSV files are loaded
+	converter *stagingformat.Converter
+	// tableFQN is the table given by --table, while sourceDatabase and sourceTable are its current name
+	tableFQN       string
 	sourceDatabase string
 	sourceTable    string
 	storageURI     *url.URL
-	logger         *zap.Logger
+	// fieldLimitChecker checks the files before loading, nil if the check is disabled
+	fieldLimitChecker *fieldlimit.Checker
+	unknownDDLPolicy  tidbsql.UnknownDDLPolicy
+	renamePolicy      tidbsql.RenamePolicy
+	// unsupportedDDLPolicy is how the DDL the data warehouse can not apply is handled, it is set by Run from the
+	// scheduler, "" fails the replication
+	unsupportedDDLPolicy tidbsql.UnsupportedDDLPolicy
+	// columnExprs are the generated columns and the expression defaults of the table, updated by the DDLs
+	columnExprs *tidbsql.ColumnExprs
+	// allowNewTables creates the table in the data warehouse on its CREATE TABLE DDL
+	allowNewTables bool
+	// renamedTo is the new name of the table found by the last round, the table is followed once
+	// the files before the rename are merged
+	renamedTo string
+	// checkedSchemaFiles are the schema files of the other tables checked for the rename of the table
+	checkedSchemaFiles map[string]struct{}
+	// scheduler bounds the files loaded concurrently across the tables, it is set by Run
+	scheduler *IncrementScheduler
+	// retryPolicy is how the failed operations of the connector are retried, it is set by Run from the scheduler
+	retryPolicy retry.Policy
+	// pkless is how the table is replicated if it has no primary key, it is set by Run from the scheduler
+	pkless pkless.Policy
+	// appendsChanges is set once the connector appends the changes of the table without a primary key
+	appendsChanges bool
+	// removedTablePolicy is how the table matching --table-pattern is handled once it is dropped in TiDB, it is set
+	// by Run from the scheduler, "" if the table is not managed
+	removedTablePolicy RemovedTablePolicy
+	// loadStats are the counters of the files loaded by the session, logged with the backlog
+	loadStats apiservice.LoadStats
+	// status receives the progress of the session
+	status *apiservice.APIInfo
+	// cleanup is how the merged files are deleted, consumedFiles are the merged files waiting to be deleted
+	// in the order of merging and consumedPaths are their paths
+	cleanup       CleanupPolicy
+	consumedFiles []consumedFile
+	consumedPaths map[string]struct{}
+	// cdcVersion is the version of the TiCDC server writing the files, empty if unknown
+	cdcVersion string
+	// dmlFileSizes maintains a map of <path, size> of the dml files found by the last LIST
+	dmlFileSizes   map[string]int64
+	backlog        backlogTracker
+	lastBacklogLog time.Time
+	// batch holds the new files until the batch policy of the scheduler lets them merge
+	batch batchTracker
+	// drift holds the checks of the table in the data warehouse against the columns replicated to it
+	drift driftTracker
+	// shards coordinates the sessions of the shards of the table, nil if the table is not sharded,
+	// shard is the index of the session in them
+	shards *shardGroup
+	shard  int
+	logger *zap.Logger
 }
 
 func NewIncrementReplicateSession(
 	ctx context.Context,
 	dwConnector coreinterfaces.Connector,
-	fileExtension string,
+	compression utils.Compression,
 	storageURI *url.URL,
 	sourceDatabase string,
 	sourceTable string,
+	fieldLimitChecker *fieldlimit.Checker,
+	unknownDDLPolicy tidbsql.UnknownDDLPolicy,
+	renamePolicy tidbsql.RenamePolicy,
+	columnExprs *tidbsql.ColumnExprs,
+	allowNewTables bool,
+	cdcVersion string,
+	checkpoint *IncrementCheckpoint,
+	cleanup CleanupPolicy,
+	status *apiservice.APIInfo,
 	logger *zap.Logger,
 ) (*IncrementReplicateSession, error) {
-	externalStorage, err := putil.GetExternalStorageFromURI(ctx, storageURI.String())
+	externalStorage, err := utils.GetExternalStorageFromURI(ctx, storageURI.String())
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, diag.Storage(errors.Trace(err))
+	}
+	tableFQN := fmt.Sprintf("%s.%s", sourceDatabase, sourceTable)
+	if renamedTo := checkpoint.renamedTo(tableFQN); renamedTo != "" {
+		logger.Info("Replicating the renamed table by its new name", zap.String("renamedTo", renamedTo))
+		sourceDatabase, sourceTable = utils.SplitTableFQN(renamedTo)
+	}
+	mergedFileIdx := checkpoint.mergedFiles(sourceDatabase, sourceTable)
+	// the files up to the checkpoint are not loaded again
+	tableDMLIdxMap := make(map[cloudstorage.DmlPathKey]uint64, len(mergedFileIdx))
+	for key, fileIdx := range mergedFileIdx {
+		tableDMLIdxMap[key] = fileIdx
+	}
+	if columnExprs == nil {
+		columnExprs = tidbsql.NewColumnExprs()
 	}
 	return &IncrementReplicateSession{
-		dwConnector:     dwConnector,
-		externalStorage: externalStorage,
-		ctx:             ctx,
-		tableDMLIdxMap:  make(map[cloudstorage.DmlPathKey]uint64),
-		tableDefMap:     make(map[uint64]*cloudstorage.TableDefinition),
-		fileExtension:   fileExtension,
-		sourceDatabase:  sourceDatabase,
-		sourceTable:     sourceTable,
-		storageURI:      storageURI,
-		logger:          logger,
+		dwConnector:        dwConnector,
+		externalStorage:    externalStorage,
+		ctx:                context.WithoutCancel(ctx),
+		stopCtx:            ctx,
+		checkpoint:         checkpoint,
+		mergedFileIdx:      mergedFileIdx,
+		tableDMLIdxMap:     tableDMLIdxMap,
+		tableDefMap:        make(map[uint64]*cloudstorage.TableDefinition),
+		compression:        compression,
+		fileExtension:      CSVFileExtension + compression.FileExtension(),
+		tableFQN:           tableFQN,
+		sourceDatabase:     sourceDatabase,
+		sourceTable:        sourceTable,
+		storageURI:         storageURI,
+		fieldLimitChecker:  fieldLimitChecker,
+		unknownDDLPolicy:   unknownDDLPolicy,
+		renamePolicy:       renamePolicy,
+		columnExprs:        columnExprs,
+		allowNewTables:     allowNewTables,
+		checkedSchemaFiles: make(map[string]struct{}),
+		cdcVersion:         cdcVersion,
+		cleanup:            cleanup,
+		status:             status,
+		dmlFileSizes:       make(map[string]int64),
+		logger:             logger,
 	}, nil
 }
 
-func (sess *IncrementReplicateSession) parseDMLFilePath(path string) error {
+func (sess *IncrementReplicateSession) parseDMLFilePath(path string) (cloudstorage.DmlPathKey, uint64, error) {
 	var dmlkey cloudstorage.DmlPathKey
 	fileIdx, err := dmlkey.ParseDMLFilePath(
 		config.DateSeparatorDay.String(),
 		path,
 	)
 	if err != nil {
-		return errors.Trace(err)
+		return dmlkey, 0, errors.Trace(err)
 	}
 	if _, ok := sess.tableDMLIdxMap[dmlkey]; !ok || fileIdx >= sess.tableDMLIdxMap[dmlkey] {
 		sess.tableDMLIdxMap[dmlkey] = fileIdx
 	}
-	return nil
+	return dmlkey, fileIdx, nil
 }
 
 func (sess *IncrementReplicateSession) parseSchemaFilePath(path string) error {
@@ -108,13 +224,13 @@ func (sess *IncrementReplicateSession) parseSchemaFilePath(path string) error {
 	}
 
 	// Read tableDef from schema file and check checksum.
-	var tableDef cloudstorage.TableDefinition
 	schemaContent, err := sess.externalStorage.ReadFile(sess.ctx, path)
 	if err != nil {
-		return errors.Trace(err)
+		return diag.Storage(errors.Trace(err))
 	}
-	if err = json.Unmarshal(schemaContent, &tableDef); err != nil {
-		return errors.Trace(err)
+	tableDef, err := cdc.ParseTableDefinition(schemaContent, sess.cdcVersion)
+	if err != nil {
+		return diag.Schema(errors.Annotatef(err, "Failed to parse schema file %s", path))
 	}
 	checksumInMem, err := tableDef.Sum32(nil)
 	if err != nil {
@@ -127,7 +243,13 @@ func (sess *IncrementReplicateSession) parseSchemaFilePath(path string) error {
 			zap.Uint64("tableversionInMem", schemaKey.TableVersion),
 			zap.Uint64("tableversionInFile", tableDef.TableVersion),
 			zap.String("path", path))
-		return errors.Errorf("checksum mismatch")
+		return diag.Schema(errors.Errorf("checksum mismatch"))
+	}
+	// the columns are aligned with the CSV files, which have no virtual generated columns
+	sess.columnExprs.Update(tableDef)
+	tableDef = sess.columnExprs.Apply(tableDef)
+	if err = sess.resolveKey(&tableDef); err != nil {
+		return errors.Trace(err)
 	}
 
 	// Update tableDefMap.
@@ -164,13 +286,35 @@ func (sess *IncrementReplicateSession) parseSchemaFilePath(path string) error {
 	return nil
 }
 
+// GenManifestFile writes the manifest listing the file only, the data warehouse reads exactly the file by the manifest
+// instead of listing the storage
 func (sess *IncrementReplicateSession) GenManifestFile(path string, size int64) error {
+	return sess.genManifestFile(path, path, size)
+}
+
+// genManifestFile writes the manifest of the file listing the file loaded in its place of the size
+func (sess *IncrementReplicateSession) genManifestFile(path, loadPath string, size int64) error {
 	fileName := strings.TrimSuffix(path, sess.fileExtension) + ".manifest"
-	content := fmt.Sprintf("{\"entries\":[{\"url\":\"%s%s\",\"mandatory\":true, \"meta\": { \"content_length\": %d } }]}", sess.externalStorage.URI(), path, size)
-	sess.externalStorage.WriteFile(sess.ctx, fileName, []byte(content))
+	content := fmt.Sprintf("{\"entries\":[{\"url\":\"%s%s\",\"mandatory\":true, \"meta\": { \"content_length\": %d } }]}", sess.externalStorage.URI(), loadPath, size)
+	if err := sess.externalStorage.WriteFile(sess.ctx, fileName, []byte(content)); err != nil {
+		return diag.Storage(errors.Annotatef(err, "Failed to write manifest %s", fileName))
+	}
 	return nil
 }
 
+// convertsFiles returns true if the files written by TiCDC are converted into the CSV files before they are loaded
+func (sess *IncrementReplicateSession) convertsFiles() bool {
+	return sess.protocol == cdcreader.ProtocolCanalJSON
+}
+
+// sourceFilePath returns the path of the file written by TiCDC of the CSV file loaded
+func (sess *IncrementReplicateSession) sourceFilePath(path string) string {
+	if !sess.convertsFiles() {
+		return path
+	}
+	return strings.TrimSuffix(path, sess.fileExtension) + sess.protocol.FileExtension() + sess.compression.FileExtension()
+}
+
 // map1 - map2
 func diffDMLMaps(
 	map1, map2 map[cloudstorage.DmlPathKey]uint64,
@@ -202,6 +346,9 @@ func (sess *IncrementReplicateSession) getNewFiles() (map[cloudstorage.DmlPathKe
 		origDMLIdxMap[k] = v
 	}
 
+	// the backlog is computed from the same LIST, the merged files waiting to be deleted are skipped
+	sess.dmlFileSizes = make(map[string]int64, len(sess.dmlFileSizes))
+	var backlogBytes int64
 	err := sess.externalStorage.WalkDir(sess.ctx, opt, func(path string, size int64) error {
 		if cloudstorage.IsSchemaFile(path) {
 			if err := sess.parseSchemaFilePath(path); err != nil {
@@ -209,21 +356,44 @@ func (sess *IncrementReplicateSession) getNewFiles() (map[cloudstorage.DmlPathKe
 				// skip handling this file
 				return nil
 			}
-		} else if strings.HasSuffix(path, sess.fileExtension) {
-			if err := sess.parseDMLFilePath(path); err != nil {
+		} else if sourceExtension := sess.protocol.FileExtension() + sess.compression.FileExtension(); sess.convertsFiles() && strings.HasSuffix(path, sourceExtension) {
+			key, fileIdx, err := sess.parseDMLFilePath(path)
+			if err != nil {
 				sess.logger.Error("failed to parse dml file path", zap.Error(err))
 				// skip handling this file
 				return nil
 			}
+			// the file is converted into the CSV file and its manifest is written when it is loaded
+			path = strings.TrimSuffix(path, sourceExtension) + sess.fileExtension
+			if fileIdx <= sess.mergedFileIdx[key] {
+				sess.consume(path, time.Now())
+				return nil
+			}
+			sess.dmlFileSizes[path] = size
+			backlogBytes += size
+		} else if strings.HasSuffix(path, sess.fileExtension) && !sess.convertsFiles() {
+			key, fileIdx, err := sess.parseDMLFilePath(path)
+			if err != nil {
+				sess.logger.Error("failed to parse dml file path", zap.Error(err))
+				// skip handling this file
+				return nil
+			}
+			if fileIdx <= sess.mergedFileIdx[key] {
+				// merged before, but not deleted yet
+				sess.consume(path, time.Now())
+				return nil
+			}
+			sess.dmlFileSizes[path] = size
+			backlogBytes += size
 			// generate manifest file for each dml file
 			manifestFileName := strings.TrimSuffix(path, sess.fileExtension) + ".manifest"
 			exist, err := sess.externalStorage.FileExists(sess.ctx, manifestFileName)
 			if err != nil {
-				return err
+				return diag.Storage(err)
 			}
 			if !exist {
 				if err = sess.GenManifestFile(path, size); err != nil {
-					return nil
+					return errors.Trace(err)
 				}
 			}
 		} else {
@@ -232,8 +402,35 @@ func (sess *IncrementReplicateSession) getNewFiles() (map[cloudstorage.DmlPathKe
 		return nil
 	})
 	if err != nil {
-		return tableDMLMap, err
+		return tableDMLMap, diag.Storage(err)
 	}
+	// TiCDC writes the files of a table version before the schema file of the next DDL, so the files of a table
+	// version are all found before its DDL is applied. A file older than the DDL applied is out of order, merging
+	// it would load the rows of the old columns after the DDL.
+	applied := sess.appliedSchemaVersion()
+	for key, fileIdx := range sess.tableDMLIdxMap {
+		if key.PartitionNum == fakePartitionNumForSchemaFile || key.TableVersion >= applied || fileIdx == origDMLIdxMap[key] {
+			continue
+		}
+		return tableDMLMap, diag.Schema(errors.Errorf("Found file %d of table version %d, partition %d and date %s "+
+			"after the DDL of table version %d is applied, the files of a table version must be written before the next DDL",
+			fileIdx, key.TableVersion, key.PartitionNum, key.Date, applied))
+	}
+	// the files of a table version may be listed before its schema file, e.g. of a table just created,
+	// they are loaded in a later round after the schema file
+	for key, fileIdx := range sess.tableDMLIdxMap {
+		if _, ok := sess.tableDefMap[key.TableVersion]; ok || fileIdx == origDMLIdxMap[key] {
+			continue
+		}
+		sess.logger.Info("Schema file of the table version is not found yet, wait for it",
+			zap.Uint64("tableVersion", key.TableVersion), zap.Int64("partition", key.PartitionNum), zap.String("date", key.Date))
+		if origFileIdx, ok := origDMLIdxMap[key]; ok {
+			sess.tableDMLIdxMap[key] = origFileIdx
+		} else {
+			delete(sess.tableDMLIdxMap, key)
+		}
+	}
+	sess.backlog.observe(time.Now(), len(sess.dmlFileSizes), backlogBytes)
 
 	tableDMLMap = diffDMLMaps(sess.tableDMLIdxMap, origDMLIdxMap)
 	return tableDMLMap, err
@@ -248,57 +445,575 @@ func (sess *IncrementReplicateSession) getTableDef(tableVersion uint64) cloudsto
 	}
 }
 
-func (sess *IncrementReplicateSession) syncExecDMLEvents(
+// preparedFile is a dml file checked before it is loaded
+type preparedFile struct {
+	key     cloudstorage.DmlPathKey
+	fileIdx uint64
+	path    string
+	// loadPath is the file loaded in place of the file, the Parquet file converted from it with
+	// --staging-format=parquet
+	loadPath string
+	// exists is false if the file has been merged and deleted before the program restarts
+	exists bool
+	size   int64
+	// commitTs is the commit ts of the last row, 0 if the file is empty
+	commitTs uint64
+	// rowsByType is the rows of the file by their operation, I, U or D
+	rowsByType map[string]int64
+	err        error
+}
+
+// prepareDMLFile checks the file exists and applies the field limits, it does not depend on the
+// files before it, so the following files of a table are prepared concurrently.
+func (sess *IncrementReplicateSession) prepareDMLFile(
 	tableDef cloudstorage.TableDefinition,
 	key cloudstorage.DmlPathKey,
 	fileIdx uint64,
-) error {
+	fileSize int64,
+) preparedFile {
 	filePath := key.GenerateDMLFilePath(fileIdx, sess.fileExtension, config.DefaultFileIndexWidth)
-	exist, err := sess.externalStorage.FileExists(sess.ctx, filePath)
+	file := preparedFile{key: key, fileIdx: fileIdx, path: filePath, loadPath: filePath, size: fileSize}
+	exist, err := sess.externalStorage.FileExists(sess.ctx, sess.sourceFilePath(filePath))
 	if err != nil {
-		return errors.Trace(err)
+		file.err = diag.Storage(errors.Trace(err))
+		return file
 	}
 	// We will remove the file after flush complete, so if the program restarts,
 	// the file range will start from 1 again, but the file may not exist.
 	// So we just ignore the non-exist file.
 	if !exist {
-		sess.logger.Warn("file not exists", zap.String("path", filePath))
-		return nil
+		sess.logger.Warn("file not exists", zap.String("path", sess.sourceFilePath(filePath)))
+		return file
 	}
+	file.exists = true
 
-	// merge file into data warehouse
-	if err := sess.dwConnector.LoadIncrement(tableDef, sess.storageURI, filePath); err != nil {
-		return errors.Trace(err)
+	if sess.convertsFiles() {
+		// the file is converted again if the program restarts before it is loaded
+		encoder := cdcreader.NewCSVEncoder(tableDef.Columns, sess.columnExprs.Elems)
+		if file.size, err = cdcreader.ConvertToCSV(sess.ctx, sess.externalStorage, sess.sourceFilePath(filePath), filePath, encoder, sess.compression); err != nil {
+			file.err = diag.Storage(errors.Trace(err))
+			return file
+		}
+		if err = sess.GenManifestFile(filePath, file.size); err != nil {
+			file.err = errors.Trace(err)
+			return file
+		}
 	}
 
-	// delete file after merge complete in order to avoid duplicate merge when program restarts
-	if err = sess.externalStorage.DeleteFile(sess.ctx, filePath); err != nil {
+	if sess.fieldLimitChecker != nil {
+		columns := utils.GenIncrementTableColumns(tableDef.Columns)
+		rewritten, size, err := sess.fieldLimitChecker.Check(sess.ctx, sess.tableFQN, filePath, columns)
+		if err != nil {
+			file.err = errors.Trace(err)
+			return file
+		}
+		if rewritten {
+			file.size = size
+			// the manifest records the size of the file
+			if err = sess.GenManifestFile(filePath, size); err != nil {
+				file.err = errors.Trace(err)
+				return file
+			}
+		}
+	}
+	if file.commitTs, err = readLastCommitTs(sess.ctx, sess.externalStorage, filePath, file.size, sess.compression); err != nil {
+		file.err = diag.Storage(errors.Annotatef(err, "Failed to read commit ts of file %s", filePath))
+		return file
+	}
+	if file.rowsByType, err = countRowsByType(sess.ctx, sess.externalStorage, filePath, sess.compression); err != nil {
+		file.err = diag.Storage(errors.Annotatef(err, "Failed to count rows of file %s", filePath))
+		return file
+	}
+	if sess.converter != nil {
+		// the file is converted again if the program restarts before it is loaded
+		parquetPath, size, err := sess.converter.Convert(sess.ctx, sess.externalStorage, filePath, utils.GenIncrementTableColumns(tableDef.Columns), sess.compression, true)
+		if err != nil {
+			file.err = diag.Storage(errors.Trace(err))
+			return file
+		}
+		file.loadPath = parquetPath
+		// the manifest lists the Parquet file instead
+		if err = sess.genManifestFile(filePath, parquetPath, size); err != nil {
+			file.err = errors.Trace(err)
+		}
+	}
+	return file
+}
+
+// syncExecDMLEvents loads the files of the ranges of the keys in order, up to workers files are prepared
+// concurrently ahead of the loads, so that the following files are prepared while a file is merged. The
+// connectors implementing coreinterfaces.IncrementBatchLoader load all the files of the keys at once, i.e. every
+// file of the table version found since the last merge.
+func (sess *IncrementReplicateSession) syncExecDMLEvents(
+	tableDef cloudstorage.TableDefinition,
+	keys []cloudstorage.DmlPathKey,
+	dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange,
+	workers int,
+) error {
+	_, loadsBatch := sess.dwConnector.(coreinterfaces.IncrementBatchLoader)
+	var files []preparedFile
+	for _, key := range keys {
+		fileRange := dmlFileMap[key]
+		for fileIdx := fileRange.start; fileIdx <= fileRange.end; fileIdx++ {
+			filePath := key.GenerateDMLFilePath(fileIdx, sess.fileExtension, config.DefaultFileIndexWidth)
+			files = append(files, preparedFile{key: key, fileIdx: fileIdx, size: sess.dmlFileSizes[filePath]})
+		}
+	}
+	stop := make(chan struct{})
+	prepared, wait := sess.prepareDMLFiles(tableDef, files, workers, stop)
+	// the files being prepared are waited for, they write the converted files and the manifests
+	defer wait()
+	defer close(stop)
+	var batch []preparedFile
+	for result := range prepared {
+		file := <-result
+		if file.err != nil {
+			return file.err
+		}
+		if !file.exists {
+			continue
+		}
+		if loadsBatch {
+			batch = append(batch, file)
+			continue
+		}
+		// the prepared files are not loaded after shutdown, they are loaded again after restart
+		if err := sess.stopCtx.Err(); err != nil {
+			return errors.Trace(err)
+		}
+		if err := sess.loadDMLFiles(tableDef, []preparedFile{file}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := sess.stopCtx.Err(); err != nil {
 		return errors.Trace(err)
 	}
-	// delete manifest file after merge complete
-	manifestFilePath := strings.TrimSuffix(filePath, sess.fileExtension) + ".manifest"
-	if err = sess.externalStorage.DeleteFile(sess.ctx, manifestFilePath); err != nil {
+	return errors.Trace(sess.loadDMLFiles(tableDef, batch))
+}
+
+// prepareDMLFiles prepares the files on up to workers goroutines, the result of each file is sent in the order of
+// the files, and a file is prepared only once fewer than workers files are prepared or waiting to be taken. It
+// stops preparing once stop is closed, wait returns once the files being prepared are done.
+func (sess *IncrementReplicateSession) prepareDMLFiles(tableDef cloudstorage.TableDefinition, files []preparedFile, workers int, stop <-chan struct{}) (prepared <-chan chan preparedFile, wait func()) {
+	ordered := make(chan chan preparedFile, max(workers, 1)-1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ordered)
+		for _, file := range files {
+			result := make(chan preparedFile, 1)
+			select {
+			case <-stop:
+				return
+			case ordered <- result:
+			}
+			wg.Add(1)
+			go func(file preparedFile) {
+				defer wg.Done()
+				result <- sess.prepareDMLFile(tableDef, file.key, file.fileIdx, file.size)
+			}(file)
+		}
+	}()
+	return ordered, wg.Wait
+}
+
+// loadDMLFiles loads the files of a table version in order, they are loaded at once if there are more than one, and
+// the checkpoint is advanced to the last of them of each key. The files applied before by the batch recorded in the data warehouse
+// are skipped, see coreinterfaces.AppliedBatchRecorder.
+func (sess *IncrementReplicateSession) loadDMLFiles(tableDef cloudstorage.TableDefinition, files []preparedFile) error {
+	recorder, recordsBatches := sess.dwConnector.(coreinterfaces.AppliedBatchRecorder)
+	if recordsBatches {
+		applied, err := sess.countAppliedFiles(recorder, files)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if applied > 0 {
+			if err = sess.advanceDMLFiles(files[:applied]); err != nil {
+				return errors.Trace(err)
+			}
+			if files = files[applied:]; len(files) == 0 {
+				return nil
+			}
+		}
+	}
+	filePaths := make([]string, 0, len(files))
+	for _, file := range files {
+		filePaths = append(filePaths, file.loadPath)
+	}
+	release, err := sess.scheduler.acquireLoad(sess.stopCtx, sess.tableFQN)
+	if err != nil {
 		return errors.Trace(err)
 	}
+	counters := sess.warehouseCounters()
+	batchID := appliedbatch.ID(files[0].path, files[0].commitTs)
+	start := time.Now()
+	// merge files into data warehouse, the load slot is kept by the retries
+	err = sess.retryConnector(metrics.OpLoadIncrement, func() error {
+		sess.setAuditScope(batchID, tableDef.TableVersion)
+		if recordsBatches {
+			recorder.SetAppliedBatch(&appliedbatch.Batch{ID: batchID, CommitTs: lastCommitTs(files)})
+		}
+		if len(filePaths) == 1 {
+			return sess.dwConnector.LoadIncrement(tableDef, sess.storageURI, filePaths[0])
+		}
+		return sess.dwConnector.(coreinterfaces.IncrementBatchLoader).LoadIncrementBatch(tableDef, sess.storageURI, filePaths)
+	})
+	elapsed := time.Since(start)
+	release()
+	sess.setAuditScope("", tableDef.TableVersion)
+	if err != nil {
+		if len(filePaths) > 1 {
+			return diag.Warehouse(errors.Annotatef(err, "Failed to load increment files %s/%s to %s",
+				sess.externalStorage.URI(), filePaths[0], filePaths[len(filePaths)-1]))
+		}
+		return diag.Warehouse(errors.Annotatef(err, "Failed to load increment file %s/%s", sess.externalStorage.URI(), filePaths[0]))
+	}
+	labels := metrics.TableLabels(sess.tableFQN)
+	metrics.IncrementFiles.With(labels).Add(float64(len(files)))
+	metrics.IncrementMergeDuration.With(labels).Observe(elapsed.Seconds())
+	for _, file := range files {
+		for tp, rows := range file.rowsByType {
+			metrics.IncrementRows.WithLabelValues(labels["schema"], labels["table"], tp).Add(float64(rows))
+		}
+	}
+	mergedRows := sess.reportWarehouseCounters(counters)
+	sess.loadStats.FilesLoaded += int64(len(files))
+	sess.loadStats.RowsMerged += mergedRows
+	sess.loadStats.LoadSeconds += elapsed.Seconds()
+	sess.status.AddTableIncrementLoad(sess.tableFQN, len(files), mergedRows, elapsed)
+	sess.status.PublishEvent(apiservice.Event{
+		Kind:         apiservice.EventKindBatchMerged,
+		Table:        sess.tableFQN,
+		Message:      fmt.Sprintf("Merged increment files in %s", elapsed.Round(time.Millisecond)),
+		Files:        len(files),
+		Rows:         mergedRows,
+		TableVersion: tableDef.TableVersion,
+	})
+	if badRowsReporter, ok := sess.dwConnector.(coreinterfaces.BadRowsReporter); ok {
+		sess.reportBadRows(batchID, badRowsReporter.TakeBadRows())
+	}
+	return errors.Trace(sess.advanceDMLFiles(files))
+}
+
+// warehouseCounters are the totals reported by the connector, 0 if the connector does not report them
+type warehouseCounters struct {
+	mergedRows  int64
+	bytesBilled int64
+}
+
+func (sess *IncrementReplicateSession) warehouseCounters() warehouseCounters {
+	var counters warehouseCounters
+	if reporter, ok := sess.dwConnector.(coreinterfaces.MergedRowsReporter); ok {
+		counters.mergedRows = reporter.MergedRows()
+	}
+	if reporter, ok := sess.dwConnector.(coreinterfaces.BytesBilledReporter); ok {
+		counters.bytesBilled = reporter.BytesBilled()
+	}
+	return counters
+}
+
+// reportWarehouseCounters counts the bytes billed since the counters before, and returns the rows merged since then
+func (sess *IncrementReplicateSession) reportWarehouseCounters(before warehouseCounters) (mergedRows int64) {
+	after := sess.warehouseCounters()
+	if billed := after.bytesBilled - before.bytesBilled; billed > 0 {
+		sess.loadStats.BytesBilled += billed
+		sess.status.AddTableBytesBilled(sess.tableFQN, billed)
+	}
+	return after.mergedRows - before.mergedRows
+}
+
+// mergeDeferred merges the rows the connector defers the merge of, so that they are merged on a round without new
+// files too, see coreinterfaces.DeferredMerger
+func (sess *IncrementReplicateSession) mergeDeferred() error {
+	merger, ok := sess.dwConnector.(coreinterfaces.DeferredMerger)
+	if !ok {
+		return nil
+	}
+	counters := sess.warehouseCounters()
+	start := time.Now()
+	if err := sess.retryConnector(metrics.OpLoadIncrement, merger.MergeDeferred); err != nil {
+		return diag.Warehouse(errors.Annotate(err, "Failed to merge the deferred increment rows"))
+	}
+	if mergedRows := sess.reportWarehouseCounters(counters); mergedRows > 0 {
+		sess.loadStats.RowsMerged += mergedRows
+		sess.status.AddTableIncrementLoad(sess.tableFQN, 0, mergedRows, time.Since(start))
+	}
+	return nil
+}
+
+// reportBadRows counts the rows of the loaded batch skipped by --max-bad-rows and writes them into the workspace of
+// the scheduler. The rows are already skipped, so a failure to write them is only logged.
+func (sess *IncrementReplicateSession) reportBadRows(batchID string, rejects badrows.Rejects) {
+	if rejects.Count == 0 {
+		return
+	}
+	log.Warn("Rows are rejected by the data warehouse and skipped",
+		zap.String("table", sess.tableFQN),
+		zap.String("batch", batchID),
+		zap.Int64("rows", rejects.Count),
+		zap.Stringers("detailed", rejects.Rows))
+	metrics.IncrementBadRows.With(metrics.TableLabels(sess.tableFQN)).Add(float64(rejects.Count))
+	sess.loadStats.BadRows += rejects.Count
+	sess.status.AddTableBadRows(sess.tableFQN, rejects.Count)
+	workspace := sess.scheduler.BadRowsWorkspace()
+	if workspace == nil {
+		return
+	}
+	report := &badrows.Report{Table: sess.tableFQN, BatchID: batchID, Time: time.Now(), Rejects: rejects}
+	if err := badrows.WriteReport(sess.ctx, workspace, report); err != nil {
+		log.Error("Failed to write the rejected rows into the workspace",
+			zap.String("table", sess.tableFQN),
+			zap.String("path", badrows.ReportPath(batchID)),
+			zap.Error(err))
+	}
+}
+
+// resolveKey marks the dedup key of the table as its primary key if it has none, or switches the connector to append
+// the changes of the table by --pkless-mode=append
+func (sess *IncrementReplicateSession) resolveKey(tableDef *cloudstorage.TableDefinition) error {
+	columns, appends, err := resolvePKLess(sess.dwConnector, sess.pkless, sess.tableFQN, tableDef.Columns)
+	if err != nil {
+		return errors.Annotatef(err, "Failed to replicate table version %d", tableDef.TableVersion)
+	}
+	tableDef.Columns = columns
+	if appends && !sess.appendsChanges {
+		sess.appendsChanges = true
+		sess.logger.Warn("The table has no primary key, its changes are appended to its changelog table")
+	}
+	return nil
+}
+
+// resolvePKLess resolves the columns of the table by the policy, see pkless.Policy.Resolve. The connector appends the
+// changes of the table from now on if they are appended.
+func resolvePKLess(dwConnector coreinterfaces.Connector, policy pkless.Policy, tableFQN string, columns []cloudstorage.TableCol) ([]cloudstorage.TableCol, bool, error) {
+	columns, appends, err := policy.Resolve(tableFQN, columns)
+	if err != nil {
+		return nil, false, diag.Schema(errors.Trace(err))
+	}
+	if !appends {
+		return columns, false, nil
+	}
+	setter, ok := dwConnector.(coreinterfaces.IncrementModeSetter)
+	if !ok {
+		return nil, false, diag.Schema(errors.Errorf("table %s has no primary key, and --pkless-mode=append is not supported by the data warehouse", tableFQN))
+	}
+	setter.SetIncrementMode(incrementmode.Append)
+	return columns, true, nil
+}
+
+// setAuditScope labels the statements of the connector in the SQL audit log, if the connector logs them
+func (sess *IncrementReplicateSession) setAuditScope(batchID string, schemaVersion uint64) {
+	if scoper, ok := sess.dwConnector.(coreinterfaces.AuditScoper); ok {
+		scoper.SetAuditScope(batchID, schemaVersion)
+	}
+}
+
+// countAppliedFiles returns the number of the files at the start of the batch applied before, by the batch recorded
+// in the data warehouse, e.g. the program crashes after the files are applied and before the checkpoint is advanced.
+// A batch interrupted in the middle is applied up to the last file recorded.
+func (sess *IncrementReplicateSession) countAppliedFiles(recorder coreinterfaces.AppliedBatchRecorder, files []preparedFile) (int, error) {
+	id := appliedbatch.ID(files[0].path, files[0].commitTs)
+	var batch *appliedbatch.Batch
+	err := sess.retryConnector(metrics.OpLoadIncrement, func() error {
+		var err error
+		batch, err = recorder.FindAppliedBatch(id)
+		return err
+	})
+	if err != nil {
+		return 0, diag.Warehouse(errors.Annotatef(err, "Failed to find the applied batch of increment file %s/%s", sess.externalStorage.URI(), files[0].path))
+	}
+	if batch == nil {
+		return 0, nil
+	}
+	for i, file := range files {
+		if file.path == batch.LastFile {
+			sess.logger.Warn("Replay detected, skip the increment files applied before",
+				zap.String("batchID", id), zap.String("first", files[0].path), zap.String("last", file.path))
+			return i + 1, nil
+		}
+	}
+	// the files of the batch are deleted only after the checkpoint is advanced, so the last file is always found
+	sess.logger.Warn("Replay detected, but the last file applied is not found, apply the files again",
+		zap.String("batchID", id), zap.String("first", files[0].path), zap.String("lastApplied", batch.LastFile))
+	return 0, nil
+}
+
+// lastCommitTs returns the commit ts of the last row of the files, 0 if they are all empty
+func lastCommitTs(files []preparedFile) uint64 {
+	var commitTs uint64
+	for _, file := range files {
+		commitTs = max(commitTs, file.commitTs)
+	}
+	return commitTs
+}
 
+// advanceDMLFiles advances the checkpoint to the last of the files of each key applied to the data warehouse, and
+// consumes them
+func (sess *IncrementReplicateSession) advanceDMLFiles(files []preparedFile) error {
+	// the checkpoint avoids duplicate merge when program restarts before the files are deleted
+	var merged []mergedFile
+	first := 0
+	for i, file := range files {
+		if i+1 < len(files) && files[i+1].key == file.key {
+			continue
+		}
+		merged = append(merged, mergedFile{key: file.key, fileIdx: file.fileIdx, commitTs: lastCommitTs(files[first : i+1]), format: stagingformat.FileFormat(file.loadPath)})
+		first = i + 1
+	}
+	if err := sess.checkpoint.advanceBatch(sess.ctx, merged); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to write increment checkpoint"))
+	}
+	for _, file := range merged {
+		sess.mergedFileIdx[file.key] = file.fileIdx
+	}
+	if commitTs := lastCommitTs(files); commitTs != 0 {
+		sess.setLoadedCommitTs(commitTs)
+	}
+	for _, file := range files {
+		sess.backlog.onMerged(file.size)
+		sess.consume(file.path, time.Now())
+	}
+	sess.cleanupConsumedFiles(time.Now())
+	return nil
+}
+
+// deleteDMLFile deletes the merged file, its manifest file and the Parquet file converted from it. The file written
+// by TiCDC is deleted last if the file is converted from it, and the converted files may be missing if the deletion
+// is retried.
+func (sess *IncrementReplicateSession) deleteDMLFile(filePath string) error {
+	manifestFilePath := strings.TrimSuffix(filePath, sess.fileExtension) + ".manifest"
+	paths := []string{filePath, manifestFilePath}
+	if sess.converter != nil {
+		paths = append([]string{stagingformat.ParquetPath(filePath, sess.compression)}, paths...)
+	}
+	for _, path := range paths {
+		if sess.convertsFiles() || stagingformat.FileFormat(path) == stagingformat.Parquet {
+			exist, err := sess.externalStorage.FileExists(sess.ctx, path)
+			if err != nil {
+				return diag.Storage(errors.Trace(err))
+			}
+			if !exist {
+				continue
+			}
+		}
+		if err := sess.externalStorage.DeleteFile(sess.ctx, path); err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+	}
+	if sess.convertsFiles() {
+		if err := sess.externalStorage.DeleteFile(sess.ctx, sess.sourceFilePath(filePath)); err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+	}
 	return nil
 }
 
 func (sess *IncrementReplicateSession) syncExecDDLEvents(tableDef cloudstorage.TableDefinition) error {
 	if len(tableDef.Query) == 0 {
 		// schema.json file without query is used to initialize the schema.
-		err := sess.dwConnector.InitSchema(tableDef.Columns)
-		return errors.Wrap(err, "failed to init schema")
+		err := sess.retryConnector(metrics.OpInitSchema, func() error { return sess.dwConnector.InitSchema(tableDef.Columns) })
+		return diag.Warehouse(errors.Wrap(err, "failed to init schema"))
 	}
 
-	if err := sess.dwConnector.ExecDDL(tableDef); err != nil {
-		// FIXME: if there is a DDL before all the DMLs, will return error here.
-		return errors.Annotate(err,
-			fmt.Sprintf("Please check the DDL query, "+
-				"if necessary, please manually execute the DDL query in data warehouse, "+
-				"update the `query` of the %s/%s/%s/meta/schema_%d_{hash}.json to empty, "+
-				"and restart the program",
-				sess.externalStorage.URI(), tableDef.Schema, tableDef.Table, tableDef.TableVersion))
+	if tableDef.Type == timodel.ActionDropTable && sess.removedTablePolicy != "" {
+		return sess.removeTable(tableDef)
+	}
+	if tableDef.Type == timodel.ActionCreateTable && !sess.allowNewTables {
+		return diag.Schema(errors.Errorf("Received create table DDL %s, set --allow-new-tables to create the table in the data warehouse", tableDef.Query))
+	}
+	if tidbsql.IsRenameTable(tableDef.Type) && sess.renamePolicy == tidbsql.RenameError {
+		return diag.Schema(errors.Errorf("Received rename table DDL %s, set --on-rename=follow to replicate the table by the new name", tableDef.Query))
+	}
+	classes := tidbsql.DDLActionClasses
+	if classifier, ok := sess.dwConnector.(coreinterfaces.DDLClassifier); ok {
+		classes = classifier.DDLActionClasses()
+	}
+	switch tidbsql.GetDDLHandling(classes, tableDef.Type, sess.unknownDDLPolicy) {
+	case tidbsql.DDLHandlingSkip:
+		sess.logger.Info("Skip DDL which does not change the table in data warehouse",
+			zap.String("type", tableDef.Type.String()), zap.String("query", tableDef.Query))
+	case tidbsql.DDLHandlingPause:
+		if tableDef.TableVersion > sess.appliedSchemaVersion() {
+			return &ddlPausedError{tableDef: tableDef, storageURI: sess.externalStorage.URI()}
+		}
+		// the table is resumed after the DDL is handled by hand
+		sess.logger.Info("Skip DDL which is handled by hand", zap.String("query", tableDef.Query), zap.Uint64("tableVersion", tableDef.TableVersion))
+		if err := sess.markDDLApplied(tableDef); err != nil {
+			return errors.Trace(err)
+		}
+	case tidbsql.DDLHandlingError:
+		return diag.Schema(errors.Errorf("Received unknown DDL %s of type %d, set --unknown-ddl to pause or skip it", tableDef.Query, tableDef.Type))
+	default:
+		if tableDef.TableVersion <= sess.appliedSchemaVersion() {
+			// the program restarts after the DDL is applied and before the query of the schema file is cleared,
+			// or the DDL is applied by another shard of the table
+			sess.logger.Info("Skip DDL which is applied before", zap.String("query", tableDef.Query), zap.Uint64("tableVersion", tableDef.TableVersion))
+			if err := sess.markDDLApplied(tableDef); err != nil {
+				return errors.Trace(err)
+			}
+			break
+		}
+		if tidbsql.IsRenameColumn(tableDef) && !cdc.HasColumnIDs(tableDef) {
+			// the columns are paired by name without the ids, the renamed column would be dropped with its data
+			return &ddlPausedError{tableDef: tableDef, storageURI: sess.externalStorage.URI(),
+				reason: errors.New("the schema files have no column ids, the changefeed is not created with output-column-id")}
+		}
+		if tidbsql.IsRenameTable(tableDef.Type) {
+			// the renamed table must not be replicated to the table of another source table
+			from, to := fmt.Sprintf("%s.%s", sess.sourceDatabase, sess.sourceTable), fmt.Sprintf("%s.%s", sess.sourceDatabase, tableDef.Table)
+			if err := sess.scheduler.checkRename(from, to); err != nil {
+				return diag.Schema(errors.Trace(err))
+			}
+		}
+		sess.setAuditScope("", tableDef.TableVersion)
+		err := sess.retryConnector(metrics.OpExecDDL, func() error { return sess.dwConnector.ExecDDL(tableDef) })
+		// a DDL skipped by --on-unsupported-ddl is recorded as applied too, but it is not applied
+		applied := err == nil
+		if err != nil && tidbsql.IsUnsupportedDDL(err) {
+			switch sess.unsupportedDDLPolicy {
+			case tidbsql.UnsupportedDDLSkip:
+				// the DDL is recorded as applied below, so that it is not skipped again
+				if err = sess.skipUnsupportedDDL(tableDef, err); err != nil {
+					return errors.Trace(err)
+				}
+			case tidbsql.UnsupportedDDLPause:
+				return &ddlPausedError{tableDef: tableDef, storageURI: sess.externalStorage.URI(), reason: err}
+			default:
+				return diag.Warehouse(errors.Annotatef(err,
+					"The DDL is not supported by the data warehouse, set --on-unsupported-ddl to skip or pause it, "+
+						"or execute it in data warehouse by hand, update the `query` of the %s/%s/%s/meta/schema_%d_{hash}.json to empty, "+
+						"and restart the program",
+					sess.externalStorage.URI(), tableDef.Schema, tableDef.Table, tableDef.TableVersion))
+			}
+		}
+		if err != nil {
+			// FIXME: if there is a DDL before all the DMLs, will return error here.
+			return diag.Warehouse(errors.Annotate(err,
+				fmt.Sprintf("Please check the DDL query, "+
+					"if necessary, please manually execute the DDL query in data warehouse, "+
+					"update the `query` of the %s/%s/%s/meta/schema_%d_{hash}.json to empty, "+
+					"and restart the program",
+					sess.externalStorage.URI(), tableDef.Schema, tableDef.Table, tableDef.TableVersion)))
+		}
+		if err := sess.checkpoint.applySchemaVersion(sess.ctx, sess.tableFQN, tableDef.TableVersion); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to write increment checkpoint"))
+		}
+		if sess.shards != nil {
+			sess.shards.applied = tableDef.TableVersion
+		}
+		if applied {
+			sess.status.PublishEvent(apiservice.Event{
+				Kind:         apiservice.EventKindDDLApplied,
+				Table:        sess.tableFQN,
+				Message:      fmt.Sprintf("Applied DDL of type %s", tableDef.Type),
+				Query:        tableDef.Query,
+				TableVersion: tableDef.TableVersion,
+			})
+		}
 	}
 
 	// The following logic is used to handle pause and resume.
@@ -312,7 +1027,7 @@ func (sess *IncrementReplicateSession) syncExecDDLEvents(tableDef cloudstorage.T
 				return errors.Trace(err)
 			}
 			if err = sess.externalStorage.DeleteFile(sess.ctx, filePath); err != nil {
-				return errors.Trace(err)
+				return diag.Storage(errors.Trace(err))
 			}
 			delete(sess.tableDefMap, item.TableVersion)
 		}
@@ -328,10 +1043,69 @@ func (sess *IncrementReplicateSession) syncExecDDLEvents(tableDef cloudstorage.T
 		return errors.Trace(err)
 	}
 	// update the current table definition file.
-	return sess.externalStorage.WriteFile(sess.ctx, filePath, data)
+	return diag.Storage(sess.externalStorage.WriteFile(sess.ctx, filePath, data))
+}
+
+// markDDLApplied initializes the columns of the connector by the schema file of the DDL and records the DDL is
+// applied, e.g. it is applied before the restart or handled by hand
+func (sess *IncrementReplicateSession) markDDLApplied(tableDef cloudstorage.TableDefinition) error {
+	err := sess.retryConnector(metrics.OpInitSchema, func() error { return sess.dwConnector.InitSchema(tableDef.Columns) })
+	if err != nil {
+		return diag.Warehouse(errors.Wrap(err, "failed to init schema"))
+	}
+	if tableDef.TableVersion > sess.checkpoint.appliedSchemaVersion(sess.tableFQN) {
+		if err := sess.checkpoint.applySchemaVersion(sess.ctx, sess.tableFQN, tableDef.TableVersion); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to write increment checkpoint"))
+		}
+	}
+	return nil
 }
 
-func (sess *IncrementReplicateSession) handleNewFiles(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange) error {
+// skipUnsupportedDDL records the DDL not supported by the data warehouse in SkippedDDLFile and replicates the files
+// after it by its columns. The table in the data warehouse may diverge from them, so the schema drift is checked by
+// the next round if --schema-check-interval is set.
+func (sess *IncrementReplicateSession) skipUnsupportedDDL(tableDef cloudstorage.TableDefinition, reason error) error {
+	sess.logger.Warn("Skip DDL which is not supported by the data warehouse, the table in the data warehouse may diverge from TiDB",
+		zap.String("query", tableDef.Query), zap.Uint64("tableVersion", tableDef.TableVersion), zap.Error(reason))
+	if err := sess.checkpoint.recordSkippedDDL(sess.ctx, SkippedDDL{
+		Table:        sess.tableFQN,
+		TableVersion: tableDef.TableVersion,
+		Type:         tableDef.Type.String(),
+		Query:        tableDef.Query,
+		Reason:       reason.Error(),
+		SkippedAt:    time.Now(),
+	}); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to record the skipped DDL"))
+	}
+	err := sess.retryConnector(metrics.OpInitSchema, func() error { return sess.dwConnector.InitSchema(tableDef.Columns) })
+	if err != nil {
+		return diag.Warehouse(errors.Wrap(err, "failed to init schema"))
+	}
+	sess.drift.info.LastCheckedAt = time.Time{}
+	return nil
+}
+
+// ddlPausedError is returned when the replication of the table is paused by a DDL
+type ddlPausedError struct {
+	tableDef   cloudstorage.TableDefinition
+	storageURI string
+	// reason is why the data warehouse can not apply the DDL, nil if the DDL always pauses the table
+	reason error
+}
+
+func (e *ddlPausedError) Error() string {
+	reason := ""
+	if e.reason != nil {
+		reason = fmt.Sprintf(" (%s)", e.reason)
+	}
+	return fmt.Sprintf("Replication is paused by DDL %s of type %s%s, "+
+		"please handle it in data warehouse manually, then POST /api/v1/tables/%s.%s/resume-after-ddl, "+
+		"or update the `query` of the %s/%s/%s/meta/schema_%d_{hash}.json to empty and restart the program",
+		e.tableDef.Query, e.tableDef.Type, reason, e.tableDef.Schema, e.tableDef.Table,
+		e.storageURI, e.tableDef.Schema, e.tableDef.Table, e.tableDef.TableVersion)
+}
+
+func (sess *IncrementReplicateSession) handleNewFiles(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange, workers int) error {
 	keys := make([]cloudstorage.DmlPathKey, 0, len(dmlFileMap))
 	for k := range dmlFileMap {
 		keys = append(keys, k)
@@ -351,45 +1125,272 @@ func (sess *IncrementReplicateSession) handleNewFiles(dmlFileMap map[cloudstorag
 	})
 	sess.logger.Info("new files found since last round", zap.Any("keys", keys))
 
-	for _, key := range keys {
-		tableDef := sess.getTableDef(key.SchemaPathKey.TableVersion)
+	// the dml files of a table version are merged by one batch, up to the next schema file
+	var batch []cloudstorage.DmlPathKey
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		tableDef := sess.getTableDef(batch[0].TableVersion)
+		err := sess.syncExecDMLEvents(tableDef, batch, dmlFileMap, workers)
+		batch = nil
+		return errors.Trace(err)
+	}
+	for i, key := range keys {
+		if err := sess.stopCtx.Err(); err != nil {
+			return errors.Trace(err)
+		}
 		// if the key is a fake dml path key which is mainly used for
 		// sorting schema.json file before the dml files, which means it is a schema.json file.
 		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
+			if err := flush(); err != nil {
+				return errors.Trace(err)
+			}
+			tableDef := sess.getTableDef(key.SchemaPathKey.TableVersion)
+			if sess.waitShards(tableDef) {
+				sess.logger.Info("DDL waits for the other shards to merge the files before it",
+					zap.String("query", tableDef.Query), zap.Uint64("tableVersion", tableDef.TableVersion))
+				sess.deferKeys(dmlFileMap, keys[i:])
+				return nil
+			}
 			if err := sess.syncExecDDLEvents(tableDef); err != nil {
+				if _, ok := errors.Cause(err).(*ddlPausedError); ok {
+					// the DDL and the files after it are found again once the table is resumed
+					sess.deferKeys(dmlFileMap, keys[i:])
+				}
 				return errors.Trace(err)
 			}
 			continue
 		}
-
-		fileRange := dmlFileMap[key]
-		for i := fileRange.start; i <= fileRange.end; i++ {
-			if err := sess.syncExecDMLEvents(tableDef, key, i); err != nil {
+		if len(batch) > 0 && batch[0].TableVersion != key.TableVersion {
+			if err := flush(); err != nil {
 				return errors.Trace(err)
 			}
 		}
+		batch = append(batch, key)
 	}
 
-	return nil
+	return errors.Trace(flush())
 }
 
-func (sess *IncrementReplicateSession) Run(flushInterval time.Duration) error {
-	ticker := time.NewTicker(flushInterval)
+// Run merges the new files of the table in rounds, the interval and workers of a round are given by
+// the scheduler, and an updated interval takes effect immediately.
+func (sess *IncrementReplicateSession) Run(scheduler *IncrementScheduler) error {
+	tableFQN := sess.tableFQN
+	sess.scheduler = scheduler
+	sess.retryPolicy = scheduler.RetryPolicy()
+	sess.pkless = scheduler.PKLessPolicy()
+	sess.protocol = scheduler.CDCProtocol()
+	sess.converter = scheduler.StagingConverter()
+	sess.unsupportedDDLPolicy = scheduler.UnsupportedDDLPolicy()
+	sess.removedTablePolicy = scheduler.removedTablePolicy(tableFQN)
+	sess.externalStorage = ratelimit.WrapStorage(scheduler.RateLimiters().Wrap(sess.externalStorage), nil, scheduler.DownloadLimiter(tableFQN))
+	lastRound := time.Now()
 	for {
+		interval, reconfigured := scheduler.nextRound(tableFQN)
+		timer := time.NewTimer(time.Until(lastRound.Add(interval)))
 		select {
-		case <-sess.ctx.Done():
-			return sess.ctx.Err()
-		case <-ticker.C:
+		case <-sess.stopCtx.Done():
+			timer.Stop()
+			return sess.stopCtx.Err()
+		case <-reconfigured:
+			timer.Stop()
+			continue
+		case <-timer.C:
 		}
-		dmlFileMap, err := sess.getNewFiles()
+		workers, release, err := scheduler.acquire(sess.stopCtx, tableFQN)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		lastRound = time.Now()
+		err = sess.runRound(workers)
+		release()
+		if err != nil {
+			if pausedErr, ok := errors.Cause(err).(*ddlPausedError); ok {
+				if err = sess.pause(pausedErr); err != nil {
+					return errors.Trace(err)
+				}
+				// the files after the DDL are merged at once
+				lastRound = time.Time{}
+				continue
+			}
+			if errors.Cause(err) == errTableRemoved {
+				sess.logger.Info("Replication of the table removed in TiDB finished")
+				return nil
+			}
+			return errors.Trace(err)
+		}
+	}
+}
 
-		if err = sess.handleNewFiles(dmlFileMap); err != nil {
+func (sess *IncrementReplicateSession) runRound(workers int) error {
+	if sess.shards != nil {
+		// the shards share the connector of the table
+		sess.shards.mu.Lock()
+		defer sess.shards.mu.Unlock()
+	}
+	// the rename is found before listing the files, so the files written before it are all listed
+	renamedTo := sess.renamedTo
+	dmlFileMap, err := sess.getNewFiles()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if sess.scheduler.Paused() {
+		// the files are found again once resumed, no SQL is executed in the data warehouse meanwhile
+		sess.forgetFiles(dmlFileMap)
+		sess.reportBacklog()
+		return nil
+	}
+	ready, err := sess.batchReady(dmlFileMap, time.Now())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !ready {
+		sess.deferBatch(dmlFileMap)
+		sess.cleanupConsumedFiles(time.Now())
+		sess.reportBacklog()
+		return nil
+	}
+	if len(dmlFileMap) > 0 {
+		if err = sess.checkDeleteMode(); err != nil {
 			return errors.Trace(err)
 		}
+		if err = sess.checkSchemaDrift(time.Now()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err = sess.handleNewFiles(dmlFileMap, workers); err != nil {
+		return errors.Trace(err)
+	}
+	sess.onBatchMerged(dmlFileMap, time.Now())
+	sess.cleanupConsumedFiles(time.Now())
+	sess.reportBacklog()
+	if len(dmlFileMap) > 0 {
+		return nil
+	}
+	if err = sess.mergeDeferred(); err != nil {
+		return errors.Trace(err)
+	}
+	if renamedTo != "" {
+		return sess.followRename(renamedTo)
 	}
+	// TiCDC writes no more files of the table after it is renamed
+	sess.renamedTo, err = sess.findRename()
+	return errors.Trace(err)
+}
+
+// findRename returns the new name of the table if it is renamed within the database, "" otherwise.
+// TiCDC writes the schema file of the rename DDL and the files after it under the new name.
+func (sess *IncrementReplicateSession) findRename() (string, error) {
+	renamedTo := ""
+	opt := &storage.WalkOption{SubDir: sess.sourceDatabase}
+	err := sess.externalStorage.WalkDir(sess.ctx, opt, func(path string, size int64) error {
+		if renamedTo != "" || !cloudstorage.IsSchemaFile(path) {
+			return nil
+		}
+		if _, ok := sess.checkedSchemaFiles[path]; ok {
+			return nil
+		}
+		var schemaKey cloudstorage.SchemaPathKey
+		if _, err := schemaKey.ParseSchemaFilePath(path); err != nil || schemaKey.Table == sess.sourceTable {
+			return nil
+		}
+		content, err := sess.externalStorage.ReadFile(sess.ctx, path)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sess.checkedSchemaFiles[path] = struct{}{}
+		tableDef, err := cdc.ParseTableDefinition(content, sess.cdcVersion)
+		if err != nil || !tidbsql.IsRenameTable(tableDef.Type) || tableDef.Query == "" {
+			return nil
+		}
+		schema, table, err := tidbsql.GetRenamedFrom(tableDef)
+		if err == nil && schema == sess.sourceDatabase && table == sess.sourceTable {
+			renamedTo = tableDef.Table
+		}
+		return nil
+	})
+	return renamedTo, diag.Storage(err)
+}
+
+// followRename replicates the table by its new name, the rename DDL is applied on the data warehouse
+// as the first schema file under the new name.
+func (sess *IncrementReplicateSession) followRename(renamedTo string) error {
+	if sess.renamePolicy == tidbsql.RenameError {
+		return diag.Schema(errors.Errorf("Table %s.%s is renamed to %s.%s, set --on-rename=follow to replicate it by the new name",
+			sess.sourceDatabase, sess.sourceTable, sess.sourceDatabase, renamedTo))
+	}
+	if err := sess.checkpoint.rename(sess.ctx, sess.tableFQN, fmt.Sprintf("%s.%s", sess.sourceDatabase, renamedTo)); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to write increment checkpoint"))
+	}
+	sess.logger.Info("Table is renamed, replicating it by the new name",
+		zap.String("from", sess.sourceTable), zap.String("to", renamedTo))
+	sess.sourceTable = renamedTo
+	sess.renamedTo = ""
+	sess.mergedFileIdx = sess.checkpoint.mergedFiles(sess.sourceDatabase, sess.sourceTable)
+	sess.tableDMLIdxMap = make(map[cloudstorage.DmlPathKey]uint64, len(sess.mergedFileIdx))
+	for key, fileIdx := range sess.mergedFileIdx {
+		sess.tableDMLIdxMap[key] = fileIdx
+	}
+	sess.tableDefMap = make(map[uint64]*cloudstorage.TableDefinition)
+	sess.dmlFileSizes = make(map[string]int64)
+	return nil
+}
+
+// pause stops loading the files of the table until it is resumed by the API service after the DDL is handled by
+// hand, the DDL is then recorded as applied. The files after the DDL are kept in the storage, and loaded after
+// restart if the program exits meanwhile.
+func (sess *IncrementReplicateSession) pause(pausedErr *ddlPausedError) error {
+	// the table is resumable once it is reported paused
+	resumed := sess.scheduler.pauseForDDL(sess.tableFQN)
+	sess.logger.Error("Replication paused", zap.Error(pausedErr))
+	sess.status.SetTablePaused(sess.tableFQN, pausedErr)
+	select {
+	case <-sess.stopCtx.Done():
+		return sess.stopCtx.Err()
+	case <-resumed:
+	}
+	tableVersion := pausedErr.tableDef.TableVersion
+	if err := sess.checkpoint.applySchemaVersion(sess.ctx, sess.tableFQN, tableVersion); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to write increment checkpoint"))
+	}
+	if sess.shards != nil {
+		sess.shards.mu.Lock()
+		sess.shards.applied = max(sess.shards.applied, tableVersion)
+		sess.shards.mu.Unlock()
+	}
+	sess.logger.Info("Replication resumed after the DDL is handled by hand",
+		zap.String("query", pausedErr.tableDef.Query), zap.Uint64("tableVersion", tableVersion))
+	sess.status.SetTableResumed(sess.tableFQN)
+	return nil
+}
+
+// reportBacklog exposes the backlog via the API service and logs a summary periodically
+func (sess *IncrementReplicateSession) reportBacklog() {
+	info := sess.backlog.info()
+	if sess.shards != nil {
+		info = sess.shards.backlog(sess.shard, info)
+	}
+	sess.status.SetTableBacklog(sess.tableFQN, info)
+	if time.Since(sess.lastBacklogLog) < backlogLogInterval {
+		return
+	}
+	sess.lastBacklogLog = time.Now()
+	eta := "unknown"
+	if info.ETASeconds >= 0 {
+		eta = (time.Duration(info.ETASeconds) * time.Second).String()
+	}
+	sess.logger.Info("Increment backlog",
+		zap.Int("files", info.Files),
+		zap.Int64("bytes", info.Bytes),
+		zap.Float64("mergeBytesPerSecond", info.MergeBytesPerSecond),
+		zap.Float64("arrivalBytesPerSecond", info.ArrivalBytesPerSecond),
+		zap.String("eta", eta),
+		zap.Int64("filesLoaded", sess.loadStats.FilesLoaded),
+		zap.Int64("rowsMerged", sess.loadStats.RowsMerged),
+		zap.Float64("loadSeconds", sess.loadStats.LoadSeconds),
+		zap.Int64("badRows", sess.loadStats.BadRows),
+		zap.Int64("bytesBilled", sess.loadStats.BytesBilled))
 }
 
 func (sess *IncrementReplicateSession) Close() {
@@ -402,23 +1403,35 @@ func StartReplicateIncrement(
 	ctx context.Context,
 	dwConnector coreinterfaces.Connector,
 	tableFQN string,
-	storageURI *url.URL,
-	flushInterval time.Duration,
+	storageURIs []*url.URL,
+	scheduler *IncrementScheduler,
+	compression utils.Compression,
+	fieldLimitChecker *fieldlimit.Checker,
+	unknownDDLPolicy tidbsql.UnknownDDLPolicy,
+	renamePolicy tidbsql.RenamePolicy,
+	columnExprs *tidbsql.ColumnExprs,
+	allowNewTables bool,
+	cdcVersion string,
+	checkpoints []*IncrementCheckpoint,
+	cleanup CleanupPolicy,
+	status *apiservice.APIInfo,
 ) error {
-	fileExtension := CSVFileExtension
-
-	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
-
 	logger := log.L().With(zap.String("table", tableFQN))
+	if len(storageURIs) > 1 {
+		return startShardedReplicateIncrement(ctx, dwConnector, tableFQN, storageURIs, scheduler, compression, fieldLimitChecker, unknownDDLPolicy, renamePolicy, columnExprs, cdcVersion, checkpoints, cleanup, status, logger)
+	}
 	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
-	session, err := NewIncrementReplicateSession(ctx, dwConnector, fileExtension, storageURI, sourceDatabase, sourceTable, logger)
+	session, err := NewIncrementReplicateSession(ctx, dwConnector, compression, storageURIs[0], sourceDatabase, sourceTable, fieldLimitChecker, unknownDDLPolicy, renamePolicy, columnExprs, allowNewTables, cdcVersion, checkpoints[0], cleanup, status, logger)
 	if err != nil {
 		logger.Error("error occurred while creating increment replicate session", zap.Error(err), zap.String("tableFQN", tableFQN))
 		return errors.Trace(err)
 	}
 	defer session.Close()
-	if err = session.Run(flushInterval); err != nil {
+	if err = session.Run(scheduler); err != nil {
+		if ctx.Err() != nil && stderrors.Is(err, ctx.Err()) {
+			logger.Info("Increment replication stopped, the merged files are recorded in the checkpoint")
+			return errors.Trace(err)
+		}
 		logger.Error("error occurred while running increment replicate session", zap.Error(err), zap.String("tableFQN", tableFQN))
 		return errors.Trace(err)
 	}
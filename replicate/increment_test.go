@@ -0,0 +1,266 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/appliedbatch"
+	"github.com/pingcap-inc/tidb2dw/pkg/badrows"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/deletemode"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConnector is the data warehouse of the tests of the sessions. It records what the session runs in it, and
+// implements the optional interfaces of the connectors so that they change nothing until the test configures them,
+// except coreinterfaces.IncrementBatchLoader, see fakeBatchLoader.
+type fakeConnector struct {
+	coreinterfaces.Connector
+	// executed are the queries of the DDLs and the files loaded in order, a DDL failed by ddlErr included
+	executed []string
+	// loads are the files of each load
+	loads [][]string
+	// initialized are the columns of each InitSchema
+	initialized [][]cloudstorage.TableCol
+	// ddlErr fails every DDL
+	ddlErr error
+	// onLoad is called before the files of a load are recorded, the load fails with its error
+	onLoad func(filePaths []string) error
+	// retryable is the error retried besides the transient errors
+	retryable error
+	// ddlClasses classify the DDLs instead of tidbsql.DDLActionClasses if set
+	ddlClasses map[timodel.ActionType]tidbsql.DDLActionClass
+	// batch is the batch recorded by the next load, applied are the batches recorded by the loads
+	batch   *appliedbatch.Batch
+	applied map[string]appliedbatch.Batch
+	// rejects are the bad rows taken after the next load
+	rejects badrows.Rejects
+	// staged rows are merged by MergeDeferred, which bills 1 MiB
+	staged      int64
+	mergedRows  int64
+	bytesBilled int64
+	// expected and actual are the columns replicated and the columns of the table in the data warehouse compared by
+	// DiffSchema, drift and diffErr are returned instead if set
+	expected   []cloudstorage.TableCol
+	actual     []tidbsql.WarehouseColumn
+	drift      *tidbsql.SchemaDrift
+	diffErr    error
+	diffs      int
+	reconciled []*tidbsql.SchemaDrift
+	// created are the tables copied from TiDB
+	created []string
+	// deleteMode is the mode the table is checked in, hasTombstone tells whether the table has the tombstone, the table
+	// is not checked if deleteMode is empty
+	deleteMode   deletemode.Mode
+	hasTombstone bool
+	checks       int
+	// incrementMode is set by the session replicating a table without a primary key
+	incrementMode incrementmode.Mode
+}
+
+func (c *fakeConnector) InitSchema(columns []cloudstorage.TableCol) error {
+	c.initialized = append(c.initialized, columns)
+	return nil
+}
+
+func (c *fakeConnector) CopyTableSchema(sourceDatabase, sourceTable string, _ *sql.DB) error {
+	c.created = append(c.created, sourceDatabase+"."+sourceTable)
+	return nil
+}
+
+func (c *fakeConnector) ExecDDL(tableDef cloudstorage.TableDefinition) error {
+	c.executed = append(c.executed, tableDef.Query)
+	return c.ddlErr
+}
+
+func (c *fakeConnector) LoadIncrement(tableDef cloudstorage.TableDefinition, uri *url.URL, filePath string) error {
+	return c.load([]string{filePath})
+}
+
+func (c *fakeConnector) load(filePaths []string) error {
+	if c.onLoad != nil {
+		if err := c.onLoad(filePaths); err != nil {
+			return err
+		}
+	}
+	c.loads = append(c.loads, filePaths)
+	c.executed = append(c.executed, filePaths...)
+	if c.batch != nil {
+		if c.applied == nil {
+			c.applied = make(map[string]appliedbatch.Batch)
+		}
+		batch := *c.batch
+		batch.LastFile = filePaths[len(filePaths)-1]
+		c.applied[batch.ID] = batch
+	}
+	return nil
+}
+
+func (c *fakeConnector) IsRetryable(err error) bool {
+	return (c.retryable != nil && errors.Cause(err) == c.retryable) || retry.IsTransient(err)
+}
+
+func (c *fakeConnector) DDLActionClasses() map[timodel.ActionType]tidbsql.DDLActionClass {
+	if c.ddlClasses != nil {
+		return c.ddlClasses
+	}
+	return tidbsql.DDLActionClasses
+}
+
+func (c *fakeConnector) FindAppliedBatch(id string) (*appliedbatch.Batch, error) {
+	if batch, ok := c.applied[id]; ok {
+		return &batch, nil
+	}
+	return nil, nil
+}
+
+func (c *fakeConnector) SetAppliedBatch(batch *appliedbatch.Batch) {
+	c.batch = batch
+}
+
+func (c *fakeConnector) TakeBadRows() badrows.Rejects {
+	rejects := c.rejects
+	c.rejects = badrows.Rejects{}
+	return rejects
+}
+
+func (c *fakeConnector) MergeDeferred() error {
+	if c.staged > 0 {
+		c.mergedRows += c.staged
+		c.bytesBilled += 1 << 20
+		c.staged = 0
+	}
+	return nil
+}
+
+func (c *fakeConnector) MergedRows() int64 {
+	return c.mergedRows
+}
+
+func (c *fakeConnector) BytesBilled() int64 {
+	return c.bytesBilled
+}
+
+func (c *fakeConnector) DiffSchema(targetTable string) (*tidbsql.SchemaDrift, error) {
+	c.diffs++
+	if c.drift != nil || c.diffErr != nil {
+		return c.drift, c.diffErr
+	}
+	return tidbsql.GetSchemaDrift(c.expected, c.actual, func(column cloudstorage.TableCol) (string, error) {
+		return column.Tp, nil
+	})
+}
+
+func (c *fakeConnector) ReconcileSchema(targetTable string, drift *tidbsql.SchemaDrift) error {
+	c.reconciled = append(c.reconciled, drift)
+	return nil
+}
+
+func (c *fakeConnector) CheckDeleteMode(targetTable string) error {
+	if c.deleteMode == "" {
+		return nil
+	}
+	c.checks++
+	return c.deleteMode.CheckTable(targetTable, c.hasTombstone)
+}
+
+func (c *fakeConnector) SetIncrementMode(mode incrementmode.Mode) {
+	c.incrementMode = mode
+}
+
+// fakeBatchLoader loads the new files of a table at once, which changes how the session loads them
+type fakeBatchLoader struct {
+	*fakeConnector
+}
+
+func (c fakeBatchLoader) LoadIncrementBatch(tableDef cloudstorage.TableDefinition, uri *url.URL, filePaths []string) error {
+	return c.load(filePaths)
+}
+
+// newTestSession returns the session replicating db.t into the connector from a temporary storage. The options
+// change the session before the defaults depending on them are set: the checkpoint of the storage, and the
+// scheduler of the table with its status.
+func newTestSession(t *testing.T, connector coreinterfaces.Connector, opts ...func(sess *IncrementReplicateSession)) *IncrementReplicateSession {
+	ctx := context.Background()
+	sess := &IncrementReplicateSession{
+		dwConnector:        connector,
+		ctx:                ctx,
+		stopCtx:            ctx,
+		mergedFileIdx:      make(map[cloudstorage.DmlPathKey]uint64),
+		tableDMLIdxMap:     make(map[cloudstorage.DmlPathKey]uint64),
+		tableDefMap:        make(map[uint64]*cloudstorage.TableDefinition),
+		fileExtension:      CSVFileExtension,
+		tableFQN:           "db.t",
+		sourceDatabase:     "db",
+		sourceTable:        "t",
+		columnExprs:        tidbsql.NewColumnExprs(),
+		checkedSchemaFiles: make(map[string]struct{}),
+		dmlFileSizes:       make(map[string]int64),
+		logger:             log.L(),
+	}
+	for _, opt := range opts {
+		opt(sess)
+	}
+	if sess.externalStorage == nil {
+		extStorage, err := storage.NewLocalStorage(t.TempDir())
+		require.NoError(t, err)
+		sess.externalStorage = extStorage
+	}
+	if sess.checkpoint == nil {
+		sess.checkpoint = NewIncrementCheckpoint(sess.externalStorage)
+	}
+	if sess.scheduler == nil {
+		if sess.status == nil {
+			sess.status = apiservice.NewAPIInfo()
+		}
+		scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{sess.tableFQN}, nil, sess.status)
+		require.NoError(t, err)
+		sess.scheduler = scheduler
+	}
+	return sess
+}
+
+// withStorage replicates the files of the storage
+func withStorage(extStorage storage.ExternalStorage) func(sess *IncrementReplicateSession) {
+	return func(sess *IncrementReplicateSession) {
+		sess.externalStorage = extStorage
+	}
+}
+
+// withTable replicates the table instead of db.t
+func withTable(tableFQN string) func(sess *IncrementReplicateSession) {
+	return func(sess *IncrementReplicateSession) {
+		sess.tableFQN = tableFQN
+		sess.sourceDatabase, sess.sourceTable = utils.SplitTableFQN(tableFQN)
+	}
+}
+
+// withScheduler runs the session by the scheduler as Run does, the status of the session is the one of the scheduler
+func withScheduler(scheduler *IncrementScheduler) func(sess *IncrementReplicateSession) {
+	return func(sess *IncrementReplicateSession) {
+		sess.scheduler = scheduler
+		sess.status = scheduler.status
+		sess.retryPolicy = scheduler.RetryPolicy()
+	}
+}
+
+func writeSchemaFile(t *testing.T, extStorage storage.ExternalStorage, tableDef cloudstorage.TableDefinition) {
+	data, err := tableDef.MarshalWithQuery()
+	require.NoError(t, err)
+	path, err := tableDef.GenerateSchemaFilePath()
+	require.NoError(t, err)
+	require.NoError(t, extStorage.WriteFile(context.Background(), path, data))
+}
@@ -0,0 +1,121 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// SnapshotLoadProgressFile is the file in the snapshot storage recording which dumped files of the table are
+// loaded, so that a load interrupted halfway continues with the files not loaded yet.
+func SnapshotLoadProgressFile(sourceDatabase, sourceTable string) string {
+	return fmt.Sprintf("%s.%s.loadinfo.json", sourceDatabase, sourceTable)
+}
+
+// snapshotLoadProgress is the load state of the dumped files of a table. It is written before the first file
+// is loaded and after each batch of files is confirmed by the data warehouse.
+type snapshotLoadProgress struct {
+	// Compression is the codec of the dumped files, empty if recorded by an older version
+	Compression utils.Compression   `json:"compression"`
+	Files       []snapshotFileState `json:"files"`
+}
+
+type snapshotFileState struct {
+	Path   string `json:"path"`
+	Loaded bool   `json:"loaded"`
+	// Format is the staging format the file is loaded in, empty until it is loaded or if recorded by an older
+	// version, which loads CSV only. The pending files are loaded in the current format, so that a load resumed
+	// after --staging-format is changed mixes the formats.
+	Format stagingformat.Format `json:"format,omitempty"`
+}
+
+func newSnapshotLoadProgress(files []string, compression utils.Compression) *snapshotLoadProgress {
+	progress := &snapshotLoadProgress{Compression: compression, Files: make([]snapshotFileState, 0, len(files))}
+	for _, file := range files {
+		progress.Files = append(progress.Files, snapshotFileState{Path: file})
+	}
+	return progress
+}
+
+// readSnapshotLoadProgress returns nil if the load of the table has not started
+func readSnapshotLoadProgress(ctx context.Context, extStorage storage.ExternalStorage, path string) (*snapshotLoadProgress, error) {
+	exists, err := extStorage.FileExists(ctx, path)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var progress snapshotLoadProgress
+	if err = json.Unmarshal(data, &progress); err != nil {
+		return nil, errors.Annotatef(err, "invalid snapshot load progress %s", path)
+	}
+	return &progress, nil
+}
+
+func (p *snapshotLoadProgress) write(ctx context.Context, extStorage storage.ExternalStorage, path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(extStorage.WriteFile(ctx, path, data))
+}
+
+// started tells whether any file is loaded
+func (p *snapshotLoadProgress) started() bool {
+	return slices.ContainsFunc(p.Files, func(f snapshotFileState) bool { return f.Loaded })
+}
+
+// pending returns the files not loaded yet, in the order they are dumped
+func (p *snapshotLoadProgress) pending() []string {
+	files := make([]string, 0, len(p.Files))
+	for _, f := range p.Files {
+		if !f.Loaded {
+			files = append(files, f.Path)
+		}
+	}
+	return files
+}
+
+// add appends the files dumped after the recorded ones, they are not loaded yet
+func (p *snapshotLoadProgress) add(files []string) {
+	for _, file := range files {
+		p.Files = append(p.Files, snapshotFileState{Path: file})
+	}
+}
+
+// markLoaded marks the dumped files loaded in the format
+func (p *snapshotLoadProgress) markLoaded(files []string, format stagingformat.Format) {
+	loaded := make(map[string]struct{}, len(files))
+	for _, file := range files {
+		loaded[file] = struct{}{}
+	}
+	for i := range p.Files {
+		if _, ok := loaded[p.Files[i].Path]; ok {
+			p.Files[i].Loaded = true
+			p.Files[i].Format = format
+		}
+	}
+}
+
+// matches tells whether the progress is of the same dumped files, regardless of the order
+func (p *snapshotLoadProgress) matches(files []string) bool {
+	if len(p.Files) != len(files) {
+		return false
+	}
+	recorded := make([]string, 0, len(p.Files))
+	for _, f := range p.Files {
+		recorded = append(recorded, f.Path)
+	}
+	slices.Sort(recorded)
+	sorted := slices.Clone(files)
+	slices.Sort(sorted)
+	return slices.Equal(recorded, sorted)
+}
@@ -0,0 +1,54 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotLoadProgress(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	path := SnapshotLoadProgressFile("db", "t")
+	require.Equal(t, "db.t.loadinfo.json", path)
+
+	progress, err := readSnapshotLoadProgress(ctx, extStorage, path)
+	require.NoError(t, err)
+	require.Nil(t, progress)
+
+	files := []string{"db.t.000000000.csv", "db.t.000000001.csv", "db.t.000000002.csv"}
+	progress = newSnapshotLoadProgress(files, utils.CompressionGzip)
+	require.False(t, progress.started())
+	require.Equal(t, files, progress.pending())
+	require.NoError(t, progress.write(ctx, extStorage, path))
+
+	progress.markLoaded(files[:1], stagingformat.CSV)
+	progress.markLoaded(files[1:2], stagingformat.Parquet)
+	require.True(t, progress.started())
+	require.NoError(t, progress.write(ctx, extStorage, path))
+
+	restored, err := readSnapshotLoadProgress(ctx, extStorage, path)
+	require.NoError(t, err)
+	require.Equal(t, progress, restored)
+	require.Equal(t, utils.CompressionGzip, restored.Compression)
+	require.Equal(t, files[2:], restored.pending())
+	require.Equal(t, stagingformat.CSV, restored.Files[0].Format)
+	require.Equal(t, stagingformat.Parquet, restored.Files[1].Format)
+	require.Empty(t, restored.Files[2].Format)
+	require.True(t, restored.matches([]string{files[2], files[0], files[1]}))
+	require.False(t, restored.matches(files[:2]))
+	require.False(t, restored.matches([]string{files[0], files[1], "db.t.000000003.csv"}))
+
+	// the files dumped later with --pipelined-snapshot
+	restored.add([]string{"db.t.000000003.csv"})
+	require.Equal(t, []string{files[2], "db.t.000000003.csv"}, restored.pending())
+
+	require.NoError(t, extStorage.WriteFile(ctx, path, []byte("not json")))
+	_, err = readSnapshotLoadProgress(ctx, extStorage, path)
+	require.Error(t, err)
+}
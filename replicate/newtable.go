@@ -0,0 +1,115 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// CreatedTableFinder finds the tables created in the databases after the changefeed starts, TiCDC writes
+// the CREATE TABLE DDL as the first schema file of such a table if the changefeed filter matches it.
+type CreatedTableFinder struct {
+	extStorage storage.ExternalStorage
+	databases  []string
+	cdcVersion string
+	// excluded are the patterns of the tables never replicated
+	excluded []tidbsql.TablePattern
+	// checkedSchemaFiles are the schema files read before, they are not read again
+	checkedSchemaFiles map[string]struct{}
+}
+
+func NewCreatedTableFinder(extStorage storage.ExternalStorage, databases []string, cdcVersion string) *CreatedTableFinder {
+	return &CreatedTableFinder{
+		extStorage:         extStorage,
+		databases:          databases,
+		cdcVersion:         cdcVersion,
+		checkedSchemaFiles: make(map[string]struct{}),
+	}
+}
+
+// SetExcludedTables skips the tables matching the patterns, their files are found if the changefeed filter matches
+// them anyway
+func (f *CreatedTableFinder) SetExcludedTables(patterns []tidbsql.TablePattern) {
+	f.excluded = patterns
+}
+
+// Find returns the tables created since the last call which are not replicated yet, in <db>.<table>
+func (f *CreatedTableFinder) Find(ctx context.Context, replicated func(table string) bool) ([]string, error) {
+	created := make([]string, 0)
+	for _, database := range f.databases {
+		opt := &storage.WalkOption{SubDir: database}
+		err := f.extStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+			if !cloudstorage.IsSchemaFile(path) {
+				return nil
+			}
+			if _, ok := f.checkedSchemaFiles[path]; ok {
+				return nil
+			}
+			var schemaKey cloudstorage.SchemaPathKey
+			if _, err := schemaKey.ParseSchemaFilePath(path); err != nil {
+				return nil
+			}
+			table := fmt.Sprintf("%s.%s", schemaKey.Schema, schemaKey.Table)
+			if replicated(table) {
+				f.checkedSchemaFiles[path] = struct{}{}
+				return nil
+			}
+			if tidbsql.MatchAnyTablePattern(f.excluded, table) {
+				log.Warn("Skip the files of a table excluded by --exclude-tables, the changefeed filter matches it", zap.String("table", table), zap.String("path", path))
+				f.checkedSchemaFiles[path] = struct{}{}
+				return nil
+			}
+			content, err := f.extStorage.ReadFile(ctx, path)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			tableDef, err := cdc.ParseTableDefinition(content, f.cdcVersion)
+			if err != nil || tableDef.Type != timodel.ActionCreateTable || tableDef.Query == "" {
+				// the tables existing before the changefeed starts are not replicated unless given by --table
+				f.checkedSchemaFiles[path] = struct{}{}
+				return nil
+			}
+			// checked once the table is replicated, it is found again if the caller fails to replicate it
+			created = append(created, table)
+			return nil
+		})
+		if err != nil {
+			return nil, diag.Storage(errors.Trace(err))
+		}
+	}
+	return created, nil
+}
+
+// IsCreatedTable tells whether TiCDC wrote the CREATE TABLE DDL of the table in <db>.<table>, i.e. the table is
+// created after the changefeed starts, so its rows are all in the increment files
+func IsCreatedTable(ctx context.Context, extStorage storage.ExternalStorage, table string, cdcVersion string) (bool, error) {
+	created := false
+	opt := &storage.WalkOption{SubDir: strings.Replace(table, ".", "/", 1)}
+	err := extStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+		if created || !cloudstorage.IsSchemaFile(path) {
+			return nil
+		}
+		var schemaKey cloudstorage.SchemaPathKey
+		if _, err := schemaKey.ParseSchemaFilePath(path); err != nil || fmt.Sprintf("%s.%s", schemaKey.Schema, schemaKey.Table) != table {
+			return nil
+		}
+		content, err := extStorage.ReadFile(ctx, path)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tableDef, err := cdc.ParseTableDefinition(content, cdcVersion)
+		created = err == nil && tableDef.Type == timodel.ActionCreateTable
+		return nil
+	})
+	return created, diag.Storage(errors.Trace(err))
+}
@@ -0,0 +1,106 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatedTableFinder(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	// existing before the changefeed starts
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "existing", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "created", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE created (id INT)",
+	})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "replicated", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE replicated (id INT)",
+	})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "other", Table: "created", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE created (id INT)",
+	})
+
+	finder := NewCreatedTableFinder(extStorage, []string{"db"}, "")
+	replicated := map[string]bool{"db.replicated": true}
+	isReplicated := func(table string) bool { return replicated[table] }
+	created, err := finder.Find(ctx, isReplicated)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.created"}, created)
+	// found again until it is replicated
+	created, err = finder.Find(ctx, isReplicated)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.created"}, created)
+	replicated["db.created"] = true
+	created, err = finder.Find(ctx, isReplicated)
+	require.NoError(t, err)
+	require.Empty(t, created)
+
+	// a table excluded is skipped even if the changefeed writes it
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "tmp_1", TableVersion: 300, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE tmp_1 (id INT)",
+	})
+	finder.SetExcludedTables([]tidbsql.TablePattern{{Database: "db", Table: "tmp_*"}})
+	created, err = finder.Find(ctx, isReplicated)
+	require.NoError(t, err)
+	require.Empty(t, created)
+
+	checkpoint := NewIncrementCheckpoint(extStorage)
+	require.NoError(t, checkpoint.AddCreatedTable(ctx, "db.created"))
+	require.NoError(t, checkpoint.AddCreatedTable(ctx, "db.created"))
+	checkpoint, err = LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db.created"}, checkpoint.CreatedTables())
+}
+
+func TestDMLFilesBeforeSchemaFile(t *testing.T) {
+	ctx := context.Background()
+	sess := newTestSession(t, nil, withTable("db.created"))
+	extStorage := sess.externalStorage
+	require.NoError(t, extStorage.WriteFile(ctx, "db/created/200/2024-01-01/CDC000001.csv", []byte(`"I","created","db",1,1`+"\n")))
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "created", TableVersion: 200, Version: 1,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE created (id INT)",
+	})
+	files, err = sess.getNewFiles()
+	require.NoError(t, err)
+	schemaKey := cloudstorage.SchemaPathKey{Schema: "db", Table: "created", TableVersion: 200}
+	require.Equal(t, map[cloudstorage.DmlPathKey]fileIndexRange{
+		{SchemaPathKey: schemaKey, PartitionNum: fakePartitionNumForSchemaFile}: {start: 1, end: 0},
+		{SchemaPathKey: schemaKey, Date: "2024-01-01"}:                          {start: 1, end: 1},
+	}, files)
+}
+
+func TestIsCreatedTable(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "events_1", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "events_10", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionCreateTable, Query: "CREATE TABLE events_10 (id INT)",
+	})
+
+	for table, expected := range map[string]bool{"db.events_1": false, "db.events_10": true, "db.events_2": false} {
+		created, err := IsCreatedTable(ctx, extStorage, table, "")
+		require.NoError(t, err)
+		require.Equal(t, expected, created, table)
+	}
+}
@@ -0,0 +1,40 @@
+package replicate
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/incrementmode"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePKLess(t *testing.T) {
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "a", Tp: "int"}, {ID: "2", Name: "b", Tp: "int"}}
+
+	// refused by default
+	_, _, err := resolvePKLess(&fakeConnector{}, pkless.Policy{Mode: pkless.Error}, "db.t", columns)
+	require.ErrorContains(t, err, "table db.t has no primary key")
+	require.Equal(t, diag.CategorySchema, diag.CategoryOf(err))
+
+	// merged by the dedup key
+	policy := pkless.Policy{Mode: pkless.Error, DedupKeys: map[string][]string{"db.t": {"b"}}}
+	resolved, appends, err := resolvePKLess(&fakeConnector{}, policy, "db.t", columns)
+	require.NoError(t, err)
+	require.False(t, appends)
+	require.Equal(t, "", resolved[0].IsPK)
+	require.Equal(t, "true", resolved[1].IsPK)
+	require.Equal(t, "", columns[1].IsPK)
+
+	// appended by a connector supporting the changelog table only
+	policy = pkless.Policy{Mode: pkless.Append}
+	_, _, err = resolvePKLess(&struct{ coreinterfaces.Connector }{}, policy, "db.t", columns)
+	require.ErrorContains(t, err, "not supported by the data warehouse")
+	connector := &fakeConnector{}
+	_, appends, err = resolvePKLess(connector, policy, "db.t", columns)
+	require.NoError(t, err)
+	require.True(t, appends)
+	require.Equal(t, incrementmode.Append, connector.incrementMode)
+}
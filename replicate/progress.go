@@ -0,0 +1,96 @@
+package replicate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+const (
+	// commitTsField is the index of the commit ts in the rows written by TiCDC, after the op, table and schema
+	commitTsField = 3
+	// tailReadSize is the size read from the end of a file to find its last row
+	tailReadSize = 64 * 1024
+)
+
+// readLastCommitTs returns the commit ts of the last row of the increment file, TiCDC writes the rows of a
+// file in commit ts order. The end of an uncompressed file is read only, a compressed file is read through.
+// 0 is returned for an empty file.
+func readLastCommitTs(ctx context.Context, extStorage storage.ExternalStorage, path string, size int64, compression utils.Compression) (uint64, error) {
+	var lastRow []byte
+	var err error
+	if compression == utils.CompressionNone {
+		lastRow, err = readLastRowFromTail(ctx, extStorage, path, size)
+	} else {
+		lastRow, err = readLastRow(ctx, storage.WithCompression(extStorage, compression.CompressType()), path)
+	}
+	if err != nil || len(lastRow) == 0 {
+		return 0, errors.Trace(err)
+	}
+	return parseCommitTs(lastRow)
+}
+
+func readLastRowFromTail(ctx context.Context, extStorage storage.ExternalStorage, path string, size int64) ([]byte, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	for readSize := int64(tailReadSize); ; readSize *= 2 {
+		offset := max(size-readSize, 0)
+		if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tail, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tail = bytes.TrimRight(tail, "\r\n")
+		if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+			return tail[i+1:], nil
+		}
+		if offset == 0 {
+			return tail, nil
+		}
+	}
+}
+
+func readLastRow(ctx context.Context, extStorage storage.ExternalStorage, path string) ([]byte, error) {
+	reader, err := extStorage.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer reader.Close()
+	var lastRow []byte
+	br := bufio.NewReader(reader)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			lastRow = line
+		}
+		if err == io.EOF {
+			return lastRow, nil
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+}
+
+func parseCommitTs(row []byte) (uint64, error) {
+	fields := bytes.SplitN(row, []byte(","), commitTsField+2)
+	if len(fields) <= commitTsField {
+		return 0, errors.Errorf("row has no commit ts: %q", row)
+	}
+	commitTs, err := strconv.ParseUint(string(bytes.Trim(fields[commitTsField], `"`)), 10, 64)
+	if err != nil {
+		return 0, errors.Annotatef(err, "invalid commit ts of row %q", row)
+	}
+	return commitTs, nil
+}
@@ -0,0 +1,48 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLastCommitTs(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	var content strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&content, "I,t,db,%d,%d,%s\n", 440000000000000000+i, i, strings.Repeat("x", 20))
+	}
+	require.NoError(t, extStorage.WriteFile(ctx, "a.csv", []byte(content.String())))
+	commitTs, err := readLastCommitTs(ctx, extStorage, "a.csv", int64(content.Len()), utils.CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, uint64(440000000000005000), commitTs)
+
+	// the last row is longer than the tail read at first
+	row := fmt.Sprintf("U,t,db,440000000000000001,1,%s", strings.Repeat("y", 3*tailReadSize))
+	require.NoError(t, extStorage.WriteFile(ctx, "b.csv", []byte(row)))
+	commitTs, err = readLastCommitTs(ctx, extStorage, "b.csv", int64(len(row)), utils.CompressionNone)
+	require.NoError(t, err)
+	require.Equal(t, uint64(440000000000000001), commitTs)
+
+	gzStorage := storage.WithCompression(extStorage, utils.CompressionGzip.CompressType())
+	require.NoError(t, gzStorage.WriteFile(ctx, "c.csv.gz", []byte(content.String())))
+	commitTs, err = readLastCommitTs(ctx, extStorage, "c.csv.gz", 0, utils.CompressionGzip)
+	require.NoError(t, err)
+	require.Equal(t, uint64(440000000000005000), commitTs)
+
+	require.NoError(t, extStorage.WriteFile(ctx, "empty.csv", nil))
+	commitTs, err = readLastCommitTs(ctx, extStorage, "empty.csv", 0, utils.CompressionNone)
+	require.NoError(t, err)
+	require.Zero(t, commitTs)
+
+	_, err = parseCommitTs([]byte("I,t,db"))
+	require.Error(t, err)
+}
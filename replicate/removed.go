@@ -0,0 +1,61 @@
+package replicate
+
+import (
+	"strings"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// RemovedTablePolicy is what is done to the table in the data warehouse when a table matching --table-pattern is
+// dropped in TiDB, the replication of the table stops either way
+type RemovedTablePolicy string
+
+const (
+	// RemovedTableKeep keeps the table in the data warehouse with the rows merged before the drop, which is the default
+	RemovedTableKeep RemovedTablePolicy = "keep"
+	// RemovedTableDrop drops the table in the data warehouse
+	RemovedTableDrop RemovedTablePolicy = "drop"
+)
+
+func ParseRemovedTablePolicy(s string) (RemovedTablePolicy, error) {
+	switch policy := RemovedTablePolicy(strings.ToLower(s)); policy {
+	case RemovedTableKeep, RemovedTableDrop:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown removed table policy %s, expected one of keep, drop", s)
+	}
+}
+
+// errTableRemoved stops the session of a managed table once its DROP TABLE is handled
+var errTableRemoved = errors.New("table removed")
+
+// removeTable handles the DROP TABLE of a table managed by --table-pattern, all files before it are merged. The
+// schema files of the table are deleted, so a table created later by the same name starts from its CREATE TABLE.
+func (sess *IncrementReplicateSession) removeTable(tableDef cloudstorage.TableDefinition) error {
+	if sess.removedTablePolicy == RemovedTableDrop {
+		if err := sess.retryConnector(metrics.OpExecDDL, func() error { return sess.dwConnector.ExecDDL(tableDef) }); err != nil {
+			return diag.Warehouse(errors.Annotate(err, "Failed to drop the table removed in TiDB"))
+		}
+		sess.logger.Info("Table is dropped in TiDB, dropped it in the data warehouse", zap.String("query", tableDef.Query))
+	} else {
+		sess.logger.Info("Table is dropped in TiDB, kept it in the data warehouse", zap.String("query", tableDef.Query))
+	}
+	for _, item := range sess.tableDefMap {
+		if item.TableVersion > tableDef.TableVersion {
+			continue
+		}
+		filePath, err := item.GenerateSchemaFilePath()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = sess.externalStorage.DeleteFile(sess.ctx, filePath); err != nil {
+			return diag.Storage(errors.Trace(err))
+		}
+		delete(sess.tableDefMap, item.TableVersion)
+	}
+	return errTableRemoved
+}
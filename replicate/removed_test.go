@@ -0,0 +1,65 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveTable(t *testing.T) {
+	ctx := context.Background()
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	created := cloudstorage.TableDefinition{Schema: "db", Table: "events_1", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1}
+	dropped := cloudstorage.TableDefinition{
+		Schema: "db", Table: "events_1", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionDropTable, Query: "DROP TABLE `db`.`events_1`",
+	}
+	run := func(policy RemovedTablePolicy) (*fakeConnector, storage.ExternalStorage, error) {
+		connector := &fakeConnector{}
+		sess := newTestSession(t, connector, withTable("db.events_1"), func(sess *IncrementReplicateSession) {
+			sess.tableDefMap = map[uint64]*cloudstorage.TableDefinition{100: &created, 200: &dropped}
+			sess.removedTablePolicy = policy
+		})
+		extStorage := sess.externalStorage
+		writeSchemaFile(t, extStorage, created)
+		writeSchemaFile(t, extStorage, dropped)
+		return connector, extStorage, sess.syncExecDDLEvents(dropped)
+	}
+	schemaFilesLeft := func(extStorage storage.ExternalStorage) int {
+		files := 0
+		require.NoError(t, extStorage.WalkDir(ctx, &storage.WalkOption{}, func(path string, _ int64) error {
+			if cloudstorage.IsSchemaFile(path) {
+				files++
+			}
+			return nil
+		}))
+		return files
+	}
+
+	// the table is kept in the data warehouse, the replication stops and the schema files are deleted
+	connector, extStorage, err := run(RemovedTableKeep)
+	require.Equal(t, errTableRemoved, errors.Cause(err))
+	require.Empty(t, connector.executed)
+	require.Zero(t, schemaFilesLeft(extStorage))
+
+	connector, extStorage, err = run(RemovedTableDrop)
+	require.Equal(t, errTableRemoved, errors.Cause(err))
+	require.Equal(t, []string{dropped.Query}, connector.executed)
+	require.Zero(t, schemaFilesLeft(extStorage))
+
+	// the DROP TABLE of a table not managed is replicated as the other DDLs
+	connector, _, err = run("")
+	require.NoError(t, err)
+	require.Equal(t, []string{dropped.Query}, connector.executed)
+
+	policy, err := ParseRemovedTablePolicy("DROP")
+	require.NoError(t, err)
+	require.Equal(t, RemovedTableDrop, policy)
+	_, err = ParseRemovedTablePolicy("ignore")
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/routing"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowRename(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "old", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "other", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+
+	newSession := func(policy tidbsql.RenamePolicy) *IncrementReplicateSession {
+		return newTestSession(t, nil, withStorage(extStorage), withTable("db.old"), func(sess *IncrementReplicateSession) {
+			sess.renamePolicy = policy
+		})
+	}
+	sess := newSession(tidbsql.RenameFollow)
+	renamedTo, err := sess.findRename()
+	require.NoError(t, err)
+	require.Empty(t, renamedTo)
+
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "new", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionRenameTable, Query: "RENAME TABLE `db`.`old` TO `db`.`new`",
+	})
+	renamedTo, err = sess.findRename()
+	require.NoError(t, err)
+	require.Equal(t, "new", renamedTo)
+
+	require.NoError(t, sess.followRename(renamedTo))
+	require.Equal(t, "new", sess.sourceTable)
+	require.Equal(t, "db.old", sess.tableFQN)
+	// the rename is kept after restart
+	checkpoint, err := LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+	require.Equal(t, "db.new", checkpoint.renamedTo("db.old"))
+
+	sess = newSession(tidbsql.RenameError)
+	renamedTo, err = sess.findRename()
+	require.NoError(t, err)
+	require.ErrorContains(t, sess.followRename(renamedTo), "Table db.old is renamed to db.new")
+}
+
+func TestRenameCheck(t *testing.T) {
+	targets := routing.NewTargetSet()
+	require.NoError(t, targets.Add("db.old", routing.Target{Schema: "raw"}))
+	require.NoError(t, targets.Add("crm.orders", routing.Target{Schema: "raw", Table: "new"}))
+	scheduler := &IncrementScheduler{}
+	scheduler.SetRenameCheck(targets.Rename)
+
+	connector := &fakeConnector{}
+	sess := newTestSession(t, connector, withTable("db.old"), withScheduler(scheduler), func(sess *IncrementReplicateSession) {
+		sess.renamePolicy = tidbsql.RenameFollow
+	})
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "db", Table: "new", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+		Type: timodel.ActionRenameTable, Query: "RENAME TABLE `db`.`old` TO `db`.`new`",
+	}
+	// raw.new is the table of crm.orders, the rename DDL is not executed
+	require.ErrorContains(t, sess.syncExecDDLEvents(tableDef), "Tables crm.orders and db.new are both replicated to raw.new")
+	require.Empty(t, connector.executed)
+
+	tableDef.Table, tableDef.Query = "renamed", "RENAME TABLE `db`.`old` TO `db`.`renamed`"
+	require.NoError(t, sess.syncExecDDLEvents(tableDef))
+	require.Equal(t, []string{tableDef.Query}, connector.executed)
+	// the table of the old name is free again
+	require.NoError(t, targets.Add("db.other", routing.Target{Schema: "raw", Table: "old"}))
+}
+
+func TestRenameColumnWithoutColumnIDs(t *testing.T) {
+	connector := &fakeConnector{}
+	sess := newTestSession(t, connector)
+	tableDef := cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 200, Version: 1, TotalColumns: 2,
+		Columns: []cloudstorage.TableCol{{Name: "id", Tp: "INT", IsPK: "true"}, {Name: "full_name", Tp: "VARCHAR"}},
+		Type:    timodel.ActionModifyColumn, Query: "ALTER TABLE `t` RENAME COLUMN `name` TO `full_name`",
+	}
+	// the rename can not be told from dropping the column, the table is paused
+	err := sess.syncExecDDLEvents(tableDef)
+	_, paused := errors.Cause(err).(*ddlPausedError)
+	require.True(t, paused, "%v", err)
+	require.ErrorContains(t, err, "the schema files have no column ids")
+	require.Empty(t, connector.executed)
+
+	tableDef.Columns[0].ID, tableDef.Columns[1].ID = "1", "2"
+	require.NoError(t, sess.syncExecDDLEvents(tableDef))
+	require.Equal(t, []string{tableDef.Query}, connector.executed)
+}
@@ -0,0 +1,44 @@
+package replicate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"go.uber.org/zap"
+)
+
+// retryClassifier returns how the errors of the connector are classified, only the errors of the connection are
+// retried if the connector does not classify its errors
+func retryClassifier(dwConnector coreinterfaces.Connector) retry.Classifier {
+	if classifier, ok := dwConnector.(coreinterfaces.ErrorClassifier); ok {
+		return classifier.IsRetryable
+	}
+	return retry.IsTransient
+}
+
+// retryConnector runs the operation of the connector by the retry policy, each failed attempt is counted. The
+// operations are safe to run again: a file is merged by the latest row of each key, and a DDL rewritten into
+// multiple statements skips the statements applied by the failed attempt. A stop interrupts the backoff, the
+// files not merged are merged after restart.
+func (sess *IncrementReplicateSession) retryConnector(operation string, op func() error) error {
+	return retry.Do(sess.stopCtx, sess.retryPolicy, retryClassifier(sess.dwConnector), func() error {
+		return metrics.CountConnectorError(sess.tableFQN, operation, op())
+	}, func(n int, backoff time.Duration, err error) {
+		sess.logger.Warn("Data warehouse operation failed, retrying",
+			zap.String("operation", operation), zap.Int("retry", n+1), zap.Duration("backoff", backoff), zap.Error(err))
+		sess.status.PublishEvent(apiservice.Event{
+			Kind:      apiservice.EventKindRetry,
+			Table:     sess.tableFQN,
+			Message:   fmt.Sprintf("Data warehouse operation failed, retrying in %s", backoff),
+			Operation: operation,
+			Attempt:   n + 1,
+			Error:     diag.RedactSecrets(err.Error()),
+			Category:  diag.CategoryOf(err),
+		})
+	})
+}
@@ -0,0 +1,76 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+var errSessionExpired = errors.New("session expired")
+
+func TestRetryLoadIncrement(t *testing.T) {
+	ctx := context.Background()
+	scheduler, err := NewIncrementScheduler(0, 0, time.Minute, BatchPolicy{}, []string{"db.t"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	require.Error(t, scheduler.SetRetryPolicy(retry.Policy{MaxRetries: 3}))
+	require.NoError(t, scheduler.SetRetryPolicy(retry.Policy{MaxRetries: 2, MaxBackoff: time.Millisecond}))
+	// the loads fail with the errors in order before loading the files
+	var errs []error
+	connector := &fakeConnector{retryable: errSessionExpired, onLoad: func(filePaths []string) error {
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}}
+	sess := newTestSession(t, connector, withScheduler(scheduler))
+	extStorage := sess.externalStorage
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 100, Version: 1,
+		Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+	})
+	filePath := func(i int) string {
+		return fmt.Sprintf("db/t/100/2024-01-01/CDC%020d.csv", i)
+	}
+	key := cloudstorage.DmlPathKey{SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100}, Date: "2024-01-01"}
+	round := func(i int) error {
+		require.NoError(t, extStorage.WriteFile(ctx, filePath(i), []byte(fmt.Sprintf("\"I\",\"t\",\"db\",%d,%d\n", 400+i, i))))
+		files, err := sess.getNewFiles()
+		require.NoError(t, err)
+		return sess.handleNewFiles(files, 1)
+	}
+
+	// the transient errors are retried until the file is loaded
+	errs = []error{errSessionExpired, errors.Trace(errSessionExpired)}
+	require.NoError(t, round(1))
+	require.Equal(t, [][]string{{filePath(1)}}, connector.loads)
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 1}, sess.checkpoint.mergedFiles("db", "t"))
+
+	// a fatal error surfaces at once with the failing statement, the file is not recorded merged
+	errs = []error{diag.WrapSQL(errors.New("invalid identifier 'C'"), "MERGE INTO t"), errSessionExpired}
+	err = round(2)
+	require.Error(t, err)
+	require.Equal(t, "MERGE INTO t", diag.FailingSQL(err))
+	require.Equal(t, diag.CategoryWarehouse, diag.CategoryOf(err))
+	require.Len(t, errs, 1)
+	require.Equal(t, map[cloudstorage.DmlPathKey]uint64{key: 1}, sess.checkpoint.mergedFiles("db", "t"))
+
+	// the retries are used up
+	errs = []error{errSessionExpired, errSessionExpired, errSessionExpired}
+	sess.tableDMLIdxMap[key] = 1
+	files, err := sess.getNewFiles()
+	require.NoError(t, err)
+	err = sess.handleNewFiles(files, 1)
+	require.ErrorContains(t, err, "Failed after 2 retries")
+	require.Empty(t, errs)
+	require.Equal(t, [][]string{{filePath(1)}}, connector.loads)
+}
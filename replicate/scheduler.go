@@ -0,0 +1,617 @@
+package replicate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdcreader"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// TableConfig overrides the global settings of the increment replication for a table, zero values inherit them
+type TableConfig struct {
+	// IncrementWorkers is the number of workers dedicated to the table, 0 means the table shares the pool
+	IncrementWorkers int `toml:"increment_workers"`
+	// MergeInterval is the interval between two rounds of merging the new files of the table
+	MergeInterval time.Duration `toml:"merge_interval"`
+	// DownloadRateLimit caps the bytes per second of the increment files of the table read, e.g. 20MiB, under
+	// --download-rate-limit
+	DownloadRateLimit string `toml:"download_rate_limit"`
+}
+
+func (c TableConfig) validate(table string) error {
+	if c.IncrementWorkers < 0 {
+		return errors.Errorf("invalid increment_workers %d of table %s", c.IncrementWorkers, table)
+	}
+	if c.MergeInterval < 0 {
+		return errors.Errorf("invalid merge_interval %s of table %s", c.MergeInterval, table)
+	}
+	if _, err := ratelimit.ParseRate(c.DownloadRateLimit); err != nil {
+		return errors.Annotatef(err, "invalid download_rate_limit of table %s", table)
+	}
+	return nil
+}
+
+// downloadRate returns the bytes per second of the files of the table read, 0 if only the global limit applies
+func (c TableConfig) downloadRate() int64 {
+	// validated already
+	rate, _ := ratelimit.ParseRate(c.DownloadRateLimit)
+	return rate
+}
+
+// configFile is the config file given by --config
+type configFile struct {
+	Tables map[string]TableConfig `toml:"tables"`
+}
+
+// LoadTableConfigs reads the per-table overrides from the config file, e.g.
+//
+//	[tables."db.events"]
+//	increment_workers = 4
+//	merge_interval = "30s"
+//	download_rate_limit = "20MiB"
+func LoadTableConfigs(path string) (map[string]TableConfig, error) {
+	var cfg configFile
+	meta, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return nil, errors.Annotatef(err, "Failed to parse config file %s", path)
+	}
+	// the other keys are the flags given by the same file
+	var unknown []toml.Key
+	for _, key := range meta.Undecoded() {
+		if key[0] == "tables" {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, errors.Errorf("unknown keys %v in config file %s", unknown, path)
+	}
+	for table, tableConfig := range cfg.Tables {
+		if err = tableConfig.validate(table); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return cfg.Tables, nil
+}
+
+// IncrementScheduler decides when the increment files of each table are merged and by how many workers.
+// The workers are capped by maxWorkers: the tables with IncrementWorkers own their dedicated workers, and
+// the other tables share the rest, a table merges only after it gets a worker from the pool. The files of
+// a table are always loaded in order, the extra workers of a table prepare the following files meanwhile.
+// The files loaded into the data warehouse at the same time are capped by loadConcurrency, so the tables are
+// loaded in parallel up to it.
+type IncrementScheduler struct {
+	mu sync.Mutex
+	// maxWorkers is the total number of workers, 0 means the pool is unlimited
+	maxWorkers int
+	// loadSlots has a slot for each file being loaded, nil means the loads are unlimited
+	loadSlots     chan struct{}
+	mergeInterval time.Duration
+	// batch is how the new files of every table are accumulated before they are merged
+	batch BatchPolicy
+	// schemaDrift is how the tables in the data warehouse are checked against the columns replicated
+	schemaDrift SchemaDriftPolicy
+	// retryPolicy is how the failed operations of the connectors are retried, zero never retries
+	retryPolicy retry.Policy
+	// pkless is how the tables without a primary key are replicated, the zero Policy refuses them
+	pkless pkless.Policy
+	// unsupportedDDL is how the DDLs the data warehouse can not apply are handled, "" fails the replication
+	unsupportedDDL tidbsql.UnsupportedDDLPolicy
+	// cdcProtocol is the protocol of the increment files written by the changefeeds, "" is CSV
+	cdcProtocol cdcreader.Protocol
+	// stagingConverter converts the increment files into the Parquet files loaded with --staging-format=parquet, nil
+	// if the files are loaded as they are
+	stagingConverter *stagingformat.Converter
+	// badRowsWorkspace is the workspace the rows skipped by --max-bad-rows are written into, nil if they are only
+	// counted
+	badRowsWorkspace storage.ExternalStorage
+	// paused is set while no increment file is merged, the new files are still listed for the backlog
+	paused bool
+	// idler suspends the data warehouse when no file is loaded for a while, nil if it is never suspended
+	idler  *WarehouseIdler
+	tables map[string]TableConfig
+	// rateLimiters limit the files of the storage transferred by the pipeline, nil if unlimited
+	rateLimiters *ratelimit.Limiters
+	// renameCheck checks the table a source table is renamed to before the rename DDL is executed, nil if the
+	// renames are not checked
+	renameCheck func(from, to string) error
+	// downloadLimiters limit the increment files read by the workers of each table, by download_rate_limit
+	downloadLimiters map[string]*ratelimit.Limiter
+	// removals are how the tables matching --table-pattern are handled once they are dropped in TiDB
+	removals map[string]RemovedTablePolicy
+	// ddlPaused are the tables paused by a DDL, the channel of a table is closed when it is resumed
+	ddlPaused   map[string]chan struct{}
+	sharedInUse int
+	// released is closed and replaced when a worker of the pool is released
+	released chan struct{}
+	// reconfigured is closed and replaced when the config of a table is updated
+	reconfigured chan struct{}
+	// status receives the effective config of the tables
+	status *apiservice.APIInfo
+}
+
+// NewIncrementScheduler creates the scheduler of the tables, overrides of the tables not replicated are ignored.
+// loadConcurrency caps the files loaded at the same time, 0 means no cap.
+func NewIncrementScheduler(maxWorkers, loadConcurrency int, mergeInterval time.Duration, batch BatchPolicy, tables []string, overrides map[string]TableConfig, status *apiservice.APIInfo) (*IncrementScheduler, error) {
+	if maxWorkers < 0 {
+		return nil, errors.Errorf("invalid number of increment workers %d", maxWorkers)
+	}
+	if loadConcurrency < 0 {
+		return nil, errors.Errorf("invalid increment concurrency %d", loadConcurrency)
+	}
+	if err := batch.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &IncrementScheduler{
+		maxWorkers:       maxWorkers,
+		mergeInterval:    mergeInterval,
+		batch:            batch,
+		tables:           make(map[string]TableConfig, len(tables)),
+		downloadLimiters: make(map[string]*ratelimit.Limiter, len(tables)),
+		removals:         make(map[string]RemovedTablePolicy),
+		ddlPaused:        make(map[string]chan struct{}),
+		released:         make(chan struct{}),
+		reconfigured:     make(chan struct{}),
+		status:           status,
+	}
+	if loadConcurrency > 0 {
+		s.loadSlots = make(chan struct{}, loadConcurrency)
+	}
+	for _, table := range tables {
+		s.tables[table] = overrides[table]
+	}
+	for table := range overrides {
+		if _, ok := s.tables[table]; !ok {
+			log.Warn("Ignored the config of a table not replicated", zap.String("table", table))
+		}
+	}
+	if err := s.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for table := range s.tables {
+		s.reportConfig(table)
+		s.downloadLimiter(table).SetRate(s.tables[table].downloadRate())
+	}
+	return s, nil
+}
+
+// validate checks the dedicated workers leave at least one worker to the tables sharing the pool
+func (s *IncrementScheduler) validate() error {
+	if s.maxWorkers == 0 {
+		return nil
+	}
+	dedicated, shared := 0, 0
+	for _, cfg := range s.tables {
+		if cfg.IncrementWorkers > 0 {
+			dedicated += cfg.IncrementWorkers
+		} else {
+			shared++
+		}
+	}
+	required := dedicated
+	if shared > 0 {
+		required++
+	}
+	if required > s.maxWorkers {
+		return errors.Errorf("%d dedicated increment workers exceed the cap of %d workers", dedicated, s.maxWorkers)
+	}
+	return nil
+}
+
+// AddTable schedules a table created after the replication starts, it shares the pool of workers
+func (s *IncrementScheduler) AddTable(table string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tables[table]; ok {
+		return nil
+	}
+	s.tables[table] = TableConfig{}
+	if err := s.validate(); err != nil {
+		delete(s.tables, table)
+		return errors.Annotatef(err, "Failed to schedule table %s", table)
+	}
+	s.reportConfig(table)
+	return nil
+}
+
+// MergeInterval returns the global interval between two rounds of merging a table
+func (s *IncrementScheduler) MergeInterval() time.Duration {
+	return s.mergeInterval
+}
+
+// BatchPolicy returns how the new files of the tables are accumulated before they are merged
+func (s *IncrementScheduler) BatchPolicy() BatchPolicy {
+	return s.batch
+}
+
+// SchemaDriftPolicy returns how the tables in the data warehouse are checked against the columns replicated
+func (s *IncrementScheduler) SchemaDriftPolicy() SchemaDriftPolicy {
+	return s.schemaDrift
+}
+
+// SetSchemaDriftPolicy checks the tables in the data warehouse by the policy, it must be called before the
+// tables are started
+func (s *IncrementScheduler) SetSchemaDriftPolicy(policy SchemaDriftPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	s.schemaDrift = policy
+	return nil
+}
+
+// RetryPolicy returns how the failed operations of the connectors of the tables are retried
+func (s *IncrementScheduler) RetryPolicy() retry.Policy {
+	return s.retryPolicy
+}
+
+// SetRetryPolicy retries the failed operations of the connectors by the policy, it must be called before the
+// tables are started
+func (s *IncrementScheduler) SetRetryPolicy(policy retry.Policy) error {
+	if err := policy.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	s.retryPolicy = policy
+	return nil
+}
+
+// PKLessPolicy returns how the tables without a primary key are replicated
+func (s *IncrementScheduler) PKLessPolicy() pkless.Policy {
+	return s.pkless
+}
+
+// SetPKLessPolicy replicates the tables without a primary key by the policy, it must be called before the tables
+// are started
+func (s *IncrementScheduler) SetPKLessPolicy(policy pkless.Policy) {
+	s.pkless = policy
+}
+
+// RateLimiters returns the limiters of the files of the storage transferred by the pipeline, nil if unlimited
+func (s *IncrementScheduler) RateLimiters() *ratelimit.Limiters {
+	return s.rateLimiters
+}
+
+// SetRateLimiters limits the increment files read by the limiters of the pipeline besides the limiters of the tables,
+// it must be called before the tables are started
+func (s *IncrementScheduler) SetRateLimiters(limiters *ratelimit.Limiters) {
+	s.rateLimiters = limiters
+}
+
+// SetRenameCheck sets the check of the renames of the tables followed by --on-rename=follow, it is called with the
+// source tables before and after the rename, and the rename DDL fails the table if it fails. It must be called
+// before the tables are started.
+func (s *IncrementScheduler) SetRenameCheck(check func(from, to string) error) {
+	s.renameCheck = check
+}
+
+// checkRename checks the rename of a source table, a scheduler without a check allows any rename
+func (s *IncrementScheduler) checkRename(from, to string) error {
+	if s == nil || s.renameCheck == nil {
+		return nil
+	}
+	return s.renameCheck(from, to)
+}
+
+// UnsupportedDDLPolicy returns how the DDLs the data warehouse can not apply are handled
+func (s *IncrementScheduler) UnsupportedDDLPolicy() tidbsql.UnsupportedDDLPolicy {
+	if s.unsupportedDDL == "" {
+		return tidbsql.UnsupportedDDLError
+	}
+	return s.unsupportedDDL
+}
+
+// SetUnsupportedDDLPolicy handles the DDLs the data warehouse can not apply by the policy, it must be called before
+// the tables are started
+func (s *IncrementScheduler) SetUnsupportedDDLPolicy(policy tidbsql.UnsupportedDDLPolicy) {
+	s.unsupportedDDL = policy
+}
+
+// CDCProtocol returns the protocol of the increment files written by the changefeeds
+func (s *IncrementScheduler) CDCProtocol() cdcreader.Protocol {
+	if s.cdcProtocol == "" {
+		return cdcreader.ProtocolCSV
+	}
+	return s.cdcProtocol
+}
+
+// SetCDCProtocol reads the increment files in the protocol, it must be called before the tables are started
+func (s *IncrementScheduler) SetCDCProtocol(protocol cdcreader.Protocol) {
+	s.cdcProtocol = protocol
+}
+
+// StagingConverter returns the converter of the increment files into the Parquet files loaded, nil if the files
+// are loaded as they are
+func (s *IncrementScheduler) StagingConverter() *stagingformat.Converter {
+	return s.stagingConverter
+}
+
+// SetStagingConverter loads the increment files converted by the converter, it must be called before the tables
+// are started
+func (s *IncrementScheduler) SetStagingConverter(converter *stagingformat.Converter) {
+	s.stagingConverter = converter
+}
+
+// BadRowsWorkspace returns the workspace the rows of the increment files rejected by the data warehouse are
+// written into
+func (s *IncrementScheduler) BadRowsWorkspace() storage.ExternalStorage {
+	return s.badRowsWorkspace
+}
+
+// SetBadRowsWorkspace writes the rows of the increment files rejected by the data warehouse into badrows.Dir of the
+// workspace, it must be called before the tables are started
+func (s *IncrementScheduler) SetBadRowsWorkspace(workspace storage.ExternalStorage) {
+	s.badRowsWorkspace = workspace
+}
+
+// ManageTable stops the replication of the table once it is dropped in TiDB, the table in the data warehouse is
+// kept or dropped by the policy. It must be called before the table is started.
+func (s *IncrementScheduler) ManageTable(table string, policy RemovedTablePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removals[table] = policy
+}
+
+// removedTablePolicy returns how the table is handled once it is dropped in TiDB, "" if the table is not managed
+// and its DROP TABLE is replicated as the other DDLs
+func (s *IncrementScheduler) removedTablePolicy(table string) RemovedTablePolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removals[table]
+}
+
+// pauseForDDL records the table is paused by a DDL, the channel returned is closed once it is resumed by
+// ResumeAfterDDL. The shards of a table share the pause.
+func (s *IncrementScheduler) pauseForDDL(table string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resumed, ok := s.ddlPaused[table]
+	if !ok {
+		resumed = make(chan struct{})
+		s.ddlPaused[table] = resumed
+	}
+	return resumed
+}
+
+// ResumeAfterDDL resumes the table paused by a DDL, which is handled in the data warehouse by hand
+func (s *IncrementScheduler) ResumeAfterDDL(table string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tables[table]; !ok {
+		return errors.Annotatef(apiservice.ErrTableNotFound, "table %s", table)
+	}
+	resumed, ok := s.ddlPaused[table]
+	if !ok {
+		return errors.Annotatef(apiservice.ErrTableNotPaused, "table %s", table)
+	}
+	log.Info("Resuming the table paused by a DDL", zap.String("table", table))
+	close(resumed)
+	delete(s.ddlPaused, table)
+	return nil
+}
+
+// Paused returns whether merging the increment files is paused
+func (s *IncrementScheduler) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// SetPaused pauses or resumes merging the increment files of all tables. The rounds being merged are finished
+// first, and the rounds resumed continue from the files merged last.
+func (s *IncrementScheduler) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == paused {
+		return
+	}
+	s.paused = paused
+	if paused {
+		log.Info("Paused merging the increment files")
+	} else {
+		log.Info("Resumed merging the increment files")
+	}
+	s.status.SetIncrementPaused(paused, time.Now())
+	// the rounds are started again
+	close(s.reconfigured)
+	s.reconfigured = make(chan struct{})
+}
+
+// SetWarehouseIdler suspends the data warehouse by the idler when no file is loaded for a while, the loads
+// resume it first. It must be called before the tables are started.
+func (s *IncrementScheduler) SetWarehouseIdler(idler *WarehouseIdler) {
+	s.idler = idler
+}
+
+// sharedWorkers returns the size of the pool shared by the tables without dedicated workers
+func (s *IncrementScheduler) sharedWorkers() int {
+	shared := s.maxWorkers
+	for _, cfg := range s.tables {
+		shared -= cfg.IncrementWorkers
+	}
+	return shared
+}
+
+// UpdateTableConfig replaces the overrides of the table, it takes effect from the next round of the table
+func (s *IncrementScheduler) UpdateTableConfig(table string, cfg TableConfig) error {
+	return s.updateTableConfig(table, func(c *TableConfig) error {
+		*c = cfg
+		return nil
+	})
+}
+
+// UpdateTableConfigFromAPI applies the update posted to the API service, the fields not posted are unchanged
+func (s *IncrementScheduler) UpdateTableConfigFromAPI(table string, update apiservice.TableConfigUpdate) error {
+	return s.updateTableConfig(table, func(c *TableConfig) error {
+		if update.IncrementWorkers != nil {
+			c.IncrementWorkers = *update.IncrementWorkers
+		}
+		if update.MergeInterval != nil {
+			interval, err := time.ParseDuration(*update.MergeInterval)
+			if err != nil {
+				return errors.Annotatef(err, "invalid merge_interval %s", *update.MergeInterval)
+			}
+			c.MergeInterval = interval
+		}
+		if update.DownloadRateLimit != nil {
+			c.DownloadRateLimit = *update.DownloadRateLimit
+		}
+		return nil
+	})
+}
+
+func (s *IncrementScheduler) updateTableConfig(table string, update func(c *TableConfig) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.tables[table]
+	if !ok {
+		return errors.Annotatef(apiservice.ErrTableNotFound, "table %s", table)
+	}
+	cfg := prev
+	if err := update(&cfg); err != nil {
+		return errors.Trace(err)
+	}
+	if err := cfg.validate(table); err != nil {
+		return errors.Trace(err)
+	}
+	s.tables[table] = cfg
+	if err := s.validate(); err != nil {
+		s.tables[table] = prev
+		return errors.Trace(err)
+	}
+	log.Info("Updated table config", zap.String("table", table),
+		zap.Int("incrementWorkers", cfg.IncrementWorkers), zap.Duration("mergeInterval", cfg.MergeInterval),
+		zap.String("downloadRateLimit", cfg.DownloadRateLimit))
+	s.reportConfig(table)
+	// the files being read take the rate from their next chunk
+	s.downloadLimiter(table).SetRate(cfg.downloadRate())
+	// the pool may be resized
+	close(s.released)
+	s.released = make(chan struct{})
+	close(s.reconfigured)
+	s.reconfigured = make(chan struct{})
+	return nil
+}
+
+// reportConfig exposes the effective config of the table via the API service
+func (s *IncrementScheduler) reportConfig(table string) {
+	cfg := s.tables[table]
+	effective := apiservice.TableConfig{
+		IncrementWorkers: cfg.IncrementWorkers,
+		Dedicated:        cfg.IncrementWorkers > 0,
+		MergeInterval:    s.effectiveMergeInterval(cfg).String(),
+		MinBatchRows:     s.batch.MinRows,
+	}
+	if s.batch.MaxInterval > 0 {
+		effective.MaxBatchInterval = s.batch.MaxInterval.String()
+	}
+	if !effective.Dedicated {
+		effective.IncrementWorkers = 1
+	}
+	if rate := cfg.downloadRate(); rate > 0 {
+		effective.DownloadRateLimit = ratelimit.FormatRate(rate)
+	}
+	s.status.SetTableConfig(table, effective)
+}
+
+// downloadLimiter returns the limiter of the increment files of the table read, unlimited until download_rate_limit
+// of the table is set. The caller holds s.mu or owns s exclusively.
+func (s *IncrementScheduler) downloadLimiter(table string) *ratelimit.Limiter {
+	limiter, ok := s.downloadLimiters[table]
+	if !ok {
+		limiter = ratelimit.NewLimiter(0)
+		s.downloadLimiters[table] = limiter
+	}
+	return limiter
+}
+
+// DownloadLimiter returns the limiter shared by the workers of the table reading its increment files
+func (s *IncrementScheduler) DownloadLimiter(table string) *ratelimit.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloadLimiter(table)
+}
+
+func (s *IncrementScheduler) effectiveMergeInterval(cfg TableConfig) time.Duration {
+	if cfg.MergeInterval > 0 {
+		return cfg.MergeInterval
+	}
+	return s.mergeInterval
+}
+
+// nextRound returns the merge interval of the table and a channel closed when the config is updated
+func (s *IncrementScheduler) nextRound(table string) (time.Duration, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.effectiveMergeInterval(s.tables[table]), s.reconfigured
+}
+
+// acquire waits for the workers of a round of the table, release must be called after the round
+func (s *IncrementScheduler) acquire(ctx context.Context, table string) (workers int, release func(), err error) {
+	for {
+		s.mu.Lock()
+		if dedicated := s.tables[table].IncrementWorkers; dedicated > 0 {
+			s.mu.Unlock()
+			return dedicated, func() {}, nil
+		}
+		if s.maxWorkers == 0 || s.sharedInUse < s.sharedWorkers() {
+			s.sharedInUse++
+			s.mu.Unlock()
+			return 1, s.releaseShared, nil
+		}
+		released := s.released
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (s *IncrementScheduler) releaseShared() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sharedInUse--
+	close(s.released)
+	s.released = make(chan struct{})
+}
+
+// acquireLoad waits for a slot to load a file of the table into the data warehouse, release must be called after
+// the load. A table with dedicated workers loads on them instead of the slots shared by the other tables, the files
+// of a table are loaded one by one in their order by its session. The data warehouse suspended by the idler is
+// resumed first.
+func (s *IncrementScheduler) acquireLoad(ctx context.Context, table string) (release func(), err error) {
+	s.mu.Lock()
+	dedicated := s.tables[table].IncrementWorkers > 0
+	s.mu.Unlock()
+	releaseSlot := func() {}
+	if s.loadSlots != nil && !dedicated {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case s.loadSlots <- struct{}{}:
+			releaseSlot = func() { <-s.loadSlots }
+		}
+	}
+	if s.idler == nil {
+		return releaseSlot, nil
+	}
+	if err = s.idler.acquire(); err != nil {
+		releaseSlot()
+		return nil, errors.Trace(err)
+	}
+	return func() {
+		s.idler.release()
+		releaseSlot()
+	}, nil
+}
@@ -0,0 +1,249 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTableConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tidb2dw.toml")
+	// the flags given by the same file are ignored
+	require.NoError(t, os.WriteFile(path, []byte(`
+mode = "full"
+
+[tidb]
+host = "127.0.0.1"
+
+[tables."db.events"]
+increment_workers = 4
+merge_interval = "30s"
+
+[tables."db.users"]
+merge_interval = "10m"
+download_rate_limit = "20MiB"
+`), 0o644))
+	configs, err := LoadTableConfigs(path)
+	require.NoError(t, err)
+	require.Equal(t, map[string]TableConfig{
+		"db.events": {IncrementWorkers: 4, MergeInterval: 30 * time.Second},
+		"db.users":  {MergeInterval: 10 * time.Minute, DownloadRateLimit: "20MiB"},
+	}, configs)
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+[tables."db.events"]
+increment_worker = 4
+`), 0o644))
+	_, err = LoadTableConfigs(path)
+	require.ErrorContains(t, err, "unknown keys")
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+[tables."db.events"]
+increment_workers = -1
+`), 0o644))
+	_, err = LoadTableConfigs(path)
+	require.ErrorContains(t, err, "invalid increment_workers -1 of table db.events")
+
+	require.NoError(t, os.WriteFile(path, []byte(`
+[tables."db.events"]
+download_rate_limit = "fast"
+`), 0o644))
+	_, err = LoadTableConfigs(path)
+	require.ErrorContains(t, err, "invalid download_rate_limit of table db.events")
+}
+
+func TestIncrementScheduler(t *testing.T) {
+	ctx := context.Background()
+	tables := []string{"db.events", "db.users", "db.orders"}
+	overrides := map[string]TableConfig{"db.events": {IncrementWorkers: 4, MergeInterval: 30 * time.Second}}
+	_, err := NewIncrementScheduler(4, 0, 10*time.Minute, BatchPolicy{}, tables, overrides, apiservice.NewAPIInfo())
+	require.ErrorContains(t, err, "4 dedicated increment workers exceed the cap of 4 workers")
+
+	scheduler, err := NewIncrementScheduler(5, 0, 10*time.Minute, BatchPolicy{}, tables, overrides, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	interval, _ := scheduler.nextRound("db.events")
+	require.Equal(t, 30*time.Second, interval)
+	interval, _ = scheduler.nextRound("db.users")
+	require.Equal(t, 10*time.Minute, interval)
+
+	// the dedicated workers are not taken from the pool
+	workers, releaseEvents, err := scheduler.acquire(ctx, "db.events")
+	require.NoError(t, err)
+	require.Equal(t, 4, workers)
+	workers, releaseUsers, err := scheduler.acquire(ctx, "db.users")
+	require.NoError(t, err)
+	require.Equal(t, 1, workers)
+
+	// the pool of 1 worker is taken by db.users
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err = scheduler.acquire(timeoutCtx, "db.orders")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan struct{})
+	go func() {
+		_, release, err := scheduler.acquire(ctx, "db.orders")
+		require.NoError(t, err)
+		release()
+		close(acquired)
+	}()
+	releaseUsers()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "db.orders is not scheduled after db.users released the worker")
+	}
+	releaseEvents()
+}
+
+func TestIncrementSchedulerUpdateTableConfig(t *testing.T) {
+	scheduler, err := NewIncrementScheduler(6, 0, time.Minute, BatchPolicy{}, []string{"db.events", "db.users"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	_, reconfigured := scheduler.nextRound("db.events")
+
+	workers, interval := 4, "30s"
+	err = scheduler.UpdateTableConfigFromAPI("db.events", apiservice.TableConfigUpdate{IncrementWorkers: &workers, MergeInterval: &interval})
+	require.NoError(t, err)
+	select {
+	case <-reconfigured:
+	default:
+		require.FailNow(t, "the session is not notified of the update")
+	}
+	newInterval, _ := scheduler.nextRound("db.events")
+	require.Equal(t, 30*time.Second, newInterval)
+	workers, release, err := scheduler.acquire(context.Background(), "db.events")
+	require.NoError(t, err)
+	require.Equal(t, 4, workers)
+	release()
+
+	// db.users needs a worker of the pool
+	workers = 6
+	err = scheduler.UpdateTableConfigFromAPI("db.events", apiservice.TableConfigUpdate{IncrementWorkers: &workers})
+	require.ErrorContains(t, err, "6 dedicated increment workers exceed the cap of 6 workers")
+	// the rejected update is not applied
+	require.Equal(t, 4, scheduler.tables["db.events"].IncrementWorkers)
+
+	interval = "soon"
+	err = scheduler.UpdateTableConfigFromAPI("db.events", apiservice.TableConfigUpdate{MergeInterval: &interval})
+	require.ErrorContains(t, err, "invalid merge_interval soon")
+
+	// the limiter taken by the session follows the updates
+	limiter := scheduler.DownloadLimiter("db.users")
+	require.Equal(t, int64(0), limiter.Rate())
+	rateLimit := "20MiB"
+	err = scheduler.UpdateTableConfigFromAPI("db.users", apiservice.TableConfigUpdate{DownloadRateLimit: &rateLimit})
+	require.NoError(t, err)
+	require.Equal(t, int64(20<<20), limiter.Rate())
+	require.Equal(t, "20MiB", scheduler.status.Status().TablesInfo["db.users"].Config.DownloadRateLimit)
+	rateLimit = "0"
+	err = scheduler.UpdateTableConfigFromAPI("db.users", apiservice.TableConfigUpdate{DownloadRateLimit: &rateLimit})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), limiter.Rate())
+
+	err = scheduler.UpdateTableConfig("db.unknown", TableConfig{})
+	require.Equal(t, apiservice.ErrTableNotFound, errors.Cause(err))
+}
+
+func TestIncrementSchedulerLoadConcurrency(t *testing.T) {
+	scheduler, err := NewIncrementScheduler(0, 2, time.Minute, BatchPolicy{}, []string{"db.events", "db.users", "db.orders"}, nil, apiservice.NewAPIInfo())
+	require.NoError(t, err)
+	releaseEvents, err := scheduler.acquireLoad(context.Background(), "db.events")
+	require.NoError(t, err)
+	releaseUsers, err := scheduler.acquireLoad(context.Background(), "db.users")
+	require.NoError(t, err)
+
+	// the third load waits for a slot
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = scheduler.acquireLoad(ctx, "db.orders")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan func())
+	go func() {
+		release, err := scheduler.acquireLoad(context.Background(), "db.orders")
+		require.NoError(t, err)
+		acquired <- release
+	}()
+	releaseUsers()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the load is not started after a slot is released")
+	}
+	releaseEvents()
+
+	_, err = NewIncrementScheduler(0, -1, time.Minute, BatchPolicy{}, nil, nil, apiservice.NewAPIInfo())
+	require.ErrorContains(t, err, "invalid increment concurrency -1")
+}
+
+func TestConcurrentMerges(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	status := apiservice.NewAPIInfo()
+	// db.users takes the only shared slot, db.events merges on its dedicated workers
+	overrides := map[string]TableConfig{"db.events": {IncrementWorkers: 2}}
+	scheduler, err := NewIncrementScheduler(0, 1, time.Minute, BatchPolicy{}, []string{"db.events", "db.users"}, overrides, status)
+	require.NoError(t, err)
+
+	entered, proceed := make(chan string, 6), make(chan struct{})
+	filePath := func(table string, i int) string {
+		return fmt.Sprintf("db/%s/100/2024-01-01/CDC%020d.csv", table, i)
+	}
+	connectors := make(map[string]*fakeConnector)
+	errCh := make(chan error, 2)
+	for _, table := range []string{"events", "users"} {
+		writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+			Schema: "db", Table: table, TableVersion: 100, Version: 1,
+			Columns: []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}, TotalColumns: 1,
+		})
+		for i := 1; i <= 3; i++ {
+			row := fmt.Sprintf("\"I\",\"%s\",\"db\",%d,%d\n", table, 400+i, i)
+			require.NoError(t, extStorage.WriteFile(ctx, filePath(table, i), []byte(row)))
+		}
+		// every load is held until proceed is closed, the tables entering a load are sent to entered
+		table := table
+		connector := &fakeConnector{onLoad: func(filePaths []string) error {
+			entered <- table
+			<-proceed
+			return nil
+		}}
+		connectors[table] = connector
+		sess := newTestSession(t, connector, withStorage(extStorage), withTable("db."+table), withScheduler(scheduler))
+		files, err := sess.getNewFiles()
+		require.NoError(t, err)
+		workers := max(overrides[sess.tableFQN].IncrementWorkers, 1)
+		go func() {
+			errCh <- sess.handleNewFiles(files, workers)
+		}()
+	}
+
+	// both tables are merging at the same time
+	merging := make(map[string]bool)
+	for len(merging) < 2 {
+		select {
+		case table := <-entered:
+			merging[table] = true
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the tables are not merged concurrently", "merging: %v", merging)
+		}
+	}
+	close(proceed)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, <-errCh)
+	}
+	// the files of each table are merged in their order
+	for table, connector := range connectors {
+		require.Equal(t, [][]string{{filePath(table, 1)}, {filePath(table, 2)}, {filePath(table, 3)}}, connector.loads)
+	}
+}
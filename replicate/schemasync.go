@@ -0,0 +1,74 @@
+package replicate
+
+import (
+	"database/sql"
+	stderrors "errors"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+)
+
+// SchemaSyncAction is what SyncTableSchema does to the table in the data warehouse
+type SchemaSyncAction string
+
+const (
+	SchemaSyncCreated   SchemaSyncAction = "created"
+	SchemaSyncAltered   SchemaSyncAction = "altered"
+	SchemaSyncUnchanged SchemaSyncAction = "unchanged"
+)
+
+// SyncTableSchema creates the table in the data warehouse from its schema in TiDB as the snapshot does, or alters the
+// table existing to the columns of TiDB as --auto-reconcile does. The connector must read the table in the data
+// warehouse by coreinterfaces.SchemaReconciler, so that a table existing is never recreated. The drift altered is
+// returned with SchemaSyncAltered.
+func SyncTableSchema(dwConnector coreinterfaces.Connector, tableFQN string, tidbPool *sql.DB, pklessPolicy pkless.Policy) (SchemaSyncAction, *tidbsql.SchemaDrift, error) {
+	reconciler, ok := dwConnector.(coreinterfaces.SchemaReconciler)
+	if !ok {
+		return "", nil, errors.New("the data warehouse does not support reading the columns of its tables")
+	}
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	columns, err := getTableColumns(tidbPool, sourceDatabase, sourceTable)
+	if err != nil {
+		return "", nil, diag.Source(errors.Trace(err))
+	}
+	if len(columns) == 0 {
+		return "", nil, diag.Source(errors.Errorf("table %s is not found in TiDB", tableFQN))
+	}
+	return syncTableSchema(dwConnector, reconciler, tableFQN, columns, tidbPool, pklessPolicy)
+}
+
+// syncTableSchema creates or alters the table in the data warehouse to the columns of TiDB
+func syncTableSchema(dwConnector coreinterfaces.Connector, reconciler coreinterfaces.SchemaReconciler, tableFQN string,
+	columns []cloudstorage.TableCol, tidbPool *sql.DB, pklessPolicy pkless.Policy) (SchemaSyncAction, *tidbsql.SchemaDrift, error) {
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	var err error
+	if columns, _, err = resolvePKLess(dwConnector, pklessPolicy, tableFQN, columns); err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	if err = dwConnector.InitSchema(columns); err != nil {
+		return "", nil, diag.Warehouse(errors.Trace(err))
+	}
+	drift, err := reconciler.DiffSchema(sourceTable)
+	if err != nil && !stderrors.Is(err, tidbsql.ErrWarehouseTableNotFound) {
+		return "", nil, diag.Warehouse(errors.Annotate(err, "Failed to read the table in the data warehouse"))
+	}
+	if err != nil || len(drift.Columns) == 0 {
+		// a table has at least one column, so it does not exist
+		if err = dwConnector.CopyTableSchema(sourceDatabase, sourceTable, tidbPool); err != nil {
+			return "", nil, diag.Warehouse(errors.Trace(err))
+		}
+		return SchemaSyncCreated, nil, nil
+	}
+	if drift.Empty() {
+		return SchemaSyncUnchanged, nil, nil
+	}
+	if err = reconciler.ReconcileSchema(sourceTable, drift); err != nil {
+		return "", nil, diag.Warehouse(errors.Annotate(err, "Failed to alter the table in the data warehouse"))
+	}
+	return SchemaSyncAltered, drift, nil
+}
@@ -0,0 +1,61 @@
+package replicate
+
+import (
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/pkless"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTableSchema(t *testing.T) {
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}, {ID: "2", Name: "v", Tp: "int"}}
+	sync := func(connector *fakeConnector) (SchemaSyncAction, *tidbsql.SchemaDrift, error) {
+		return syncTableSchema(connector, connector, "db.t", columns, nil, pkless.Policy{Mode: pkless.Error})
+	}
+
+	// the table does not exist in the data warehouse
+	connector := &fakeConnector{drift: &tidbsql.SchemaDrift{}}
+	action, _, err := sync(connector)
+	require.NoError(t, err)
+	require.Equal(t, SchemaSyncCreated, action)
+	require.Equal(t, []string{"db.t"}, connector.created)
+	require.Len(t, connector.initialized, 1)
+
+	// the table is not found by the data warehouse reading its columns
+	connector = &fakeConnector{diffErr: errors.Annotate(tidbsql.ErrWarehouseTableNotFound, "table t is not found")}
+	action, _, err = sync(connector)
+	require.NoError(t, err)
+	require.Equal(t, SchemaSyncCreated, action)
+	require.Equal(t, []string{"db.t"}, connector.created)
+
+	// the table fails to be read
+	connector = &fakeConnector{diffErr: errors.New("permission denied")}
+	_, _, err = sync(connector)
+	require.ErrorContains(t, err, "permission denied")
+	require.Empty(t, connector.created)
+
+	// the table has the columns of TiDB
+	connector = &fakeConnector{drift: &tidbsql.SchemaDrift{Columns: columns, Expected: columns}}
+	action, _, err = sync(connector)
+	require.NoError(t, err)
+	require.Equal(t, SchemaSyncUnchanged, action)
+	require.Empty(t, connector.created)
+	require.Empty(t, connector.reconciled)
+
+	// the table misses a column
+	drift := &tidbsql.SchemaDrift{Columns: columns[:1], Expected: columns, Diffs: []tidbsql.ColumnDiff{{Action: tidbsql.ADD_COLUMN, After: &columns[1]}}}
+	connector = &fakeConnector{drift: drift}
+	action, altered, err := sync(connector)
+	require.NoError(t, err)
+	require.Equal(t, SchemaSyncAltered, action)
+	require.Same(t, drift, altered)
+	require.Equal(t, []*tidbsql.SchemaDrift{drift}, connector.reconciled)
+	require.Empty(t, connector.created)
+
+	// a table without a primary key is refused as by the replication
+	_, _, err = syncTableSchema(connector, connector, "db.t", columns[1:], nil, pkless.Policy{Mode: pkless.Error})
+	require.ErrorContains(t, err, "has no primary key")
+}
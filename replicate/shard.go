@@ -0,0 +1,202 @@
+package replicate
+
+import (
+	"context"
+	stderrors "errors"
+	"net/url"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// shardGroup coordinates the sessions of the shards of a table, whose changes are written by a changefeed per shard
+// into its own prefix of the increment storage. A primary key always belongs to the same shard, so the files of
+// different shards are merged in any order. The sessions share the connector of the table, so their rounds are
+// serialized by mu, and a DDL is applied once all shards have merged the files before it.
+type shardGroup struct {
+	mu sync.Mutex
+	// reached is the table version of the last DDL each shard arrived at, TiCDC writes the schema file of a DDL
+	// after the files before it
+	reached []uint64
+	// applied is the table version of the last DDL applied to the table by any shard
+	applied uint64
+	// commitTs is the commit ts of the last file merged of each shard, 0 if none is merged
+	commitTs []uint64
+	// backlogs are the backlogs of the shards, which are reported together
+	backlogs []apiservice.BacklogInfo
+}
+
+func newShardGroup(shards int, applied uint64) *shardGroup {
+	backlogs := make([]apiservice.BacklogInfo, shards)
+	for i := range backlogs {
+		backlogs[i].ETASeconds = -1
+	}
+	return &shardGroup{
+		reached:  make([]uint64, shards),
+		applied:  applied,
+		commitTs: make([]uint64, shards),
+		backlogs: backlogs,
+	}
+}
+
+// reach records the shard arrives at the DDL of the table version and returns whether all shards have arrived
+func (g *shardGroup) reach(shard int, tableVersion uint64) bool {
+	g.reached[shard] = max(g.reached[shard], tableVersion)
+	for _, reached := range g.reached {
+		if reached < tableVersion {
+			return false
+		}
+	}
+	return true
+}
+
+// loadedCommitTs records the commit ts merged by the shard and returns the commit ts merged by all shards, the
+// shards which have merged no file yet are not counted
+func (g *shardGroup) loadedCommitTs(shard int, commitTs uint64) uint64 {
+	g.commitTs[shard] = commitTs
+	loaded := uint64(0)
+	for _, ts := range g.commitTs {
+		if ts != 0 && (loaded == 0 || ts < loaded) {
+			loaded = ts
+		}
+	}
+	return loaded
+}
+
+// backlog records the backlog of the shard and returns the backlog of all shards, the table catches up once the
+// slowest shard does
+func (g *shardGroup) backlog(shard int, info apiservice.BacklogInfo) apiservice.BacklogInfo {
+	g.backlogs[shard] = info
+	total := apiservice.BacklogInfo{}
+	for _, b := range g.backlogs {
+		total.Files += b.Files
+		total.Bytes += b.Bytes
+		total.MergeBytesPerSecond += b.MergeBytesPerSecond
+		total.ArrivalBytesPerSecond += b.ArrivalBytesPerSecond
+		if b.ETASeconds < 0 || total.ETASeconds < 0 {
+			total.ETASeconds = -1
+		} else {
+			total.ETASeconds = max(total.ETASeconds, b.ETASeconds)
+		}
+		if b.UpdatedAt.After(total.UpdatedAt) {
+			total.UpdatedAt = b.UpdatedAt
+		}
+	}
+	return total
+}
+
+// waitShards returns whether the DDL of the table version is applied by the round, a DDL waits for the shards which
+// have not merged the files before it. The session of a table which is not sharded never waits.
+func (sess *IncrementReplicateSession) waitShards(tableDef cloudstorage.TableDefinition) bool {
+	if sess.shards == nil || len(tableDef.Query) == 0 {
+		return false
+	}
+	return !sess.shards.reach(sess.shard, tableDef.TableVersion)
+}
+
+// deferKeys forgets the files of the keys, so that they are found again by the next round. The schema files are
+// parsed again, which puts the DDL in front of the files after it.
+func (sess *IncrementReplicateSession) deferKeys(dmlFileMap map[cloudstorage.DmlPathKey]fileIndexRange, keys []cloudstorage.DmlPathKey) {
+	for _, key := range keys {
+		if fileRange := dmlFileMap[key]; fileRange.start > 1 {
+			sess.tableDMLIdxMap[key] = fileRange.start - 1
+		} else {
+			delete(sess.tableDMLIdxMap, key)
+		}
+		if key.PartitionNum == fakePartitionNumForSchemaFile && len(key.Date) == 0 {
+			delete(sess.tableDefMap, key.TableVersion)
+		}
+	}
+}
+
+// appliedSchemaVersion returns the table version of the last DDL applied to the table, by any shard if sharded
+func (sess *IncrementReplicateSession) appliedSchemaVersion() uint64 {
+	applied := sess.checkpoint.appliedSchemaVersion(sess.tableFQN)
+	if sess.shards != nil {
+		applied = max(applied, sess.shards.applied)
+	}
+	return applied
+}
+
+// setLoadedCommitTs reports the commit ts merged of the table, which is merged by all shards if sharded
+func (sess *IncrementReplicateSession) setLoadedCommitTs(commitTs uint64) {
+	if sess.shards != nil {
+		commitTs = sess.shards.loadedCommitTs(sess.shard, commitTs)
+	}
+	sess.status.SetTableLoadedCommitTs(sess.tableFQN, commitTs)
+}
+
+// startShardedReplicateIncrement runs a session per shard of the table until any of them fails, the storage URIs
+// and the checkpoints are of the shards in order. The connector is closed once all sessions stop.
+func startShardedReplicateIncrement(
+	ctx context.Context,
+	dwConnector coreinterfaces.Connector,
+	tableFQN string,
+	storageURIs []*url.URL,
+	scheduler *IncrementScheduler,
+	compression utils.Compression,
+	fieldLimitChecker *fieldlimit.Checker,
+	unknownDDLPolicy tidbsql.UnknownDDLPolicy,
+	renamePolicy tidbsql.RenamePolicy,
+	columnExprs *tidbsql.ColumnExprs,
+	cdcVersion string,
+	checkpoints []*IncrementCheckpoint,
+	cleanup CleanupPolicy,
+	status *apiservice.APIInfo,
+	logger *zap.Logger,
+) error {
+	defer dwConnector.Close()
+	applied := uint64(0)
+	for _, checkpoint := range checkpoints {
+		applied = max(applied, checkpoint.appliedSchemaVersion(tableFQN))
+	}
+	group := newShardGroup(len(storageURIs), applied)
+	if columnExprs == nil {
+		columnExprs = tidbsql.NewColumnExprs()
+	}
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	shardsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sessions := make([]*IncrementReplicateSession, 0, len(storageURIs))
+	for i, storageURI := range storageURIs {
+		shardLogger := logger.With(zap.Int("shard", i))
+		session, err := NewIncrementReplicateSession(shardsCtx, dwConnector, compression, storageURI, sourceDatabase, sourceTable, fieldLimitChecker, unknownDDLPolicy, renamePolicy, columnExprs, false, cdcVersion, checkpoints[i], cleanup, status, shardLogger)
+		if err != nil {
+			shardLogger.Error("error occurred while creating increment replicate session", zap.Error(err))
+			return errors.Trace(err)
+		}
+		session.shards, session.shard = group, i
+		sessions = append(sessions, session)
+	}
+
+	errCh := make(chan error, len(sessions))
+	for _, session := range sessions {
+		go func(session *IncrementReplicateSession) {
+			errCh <- session.Run(scheduler)
+		}(session)
+	}
+	// the first error stops the other shards
+	var firstErr error
+	for range sessions {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	if firstErr != nil {
+		if ctx.Err() != nil && stderrors.Is(firstErr, ctx.Err()) {
+			logger.Info("Increment replication stopped, the merged files are recorded in the checkpoints")
+			return errors.Trace(firstErr)
+		}
+		logger.Error("error occurred while running increment replicate session", zap.Error(firstErr), zap.String("tableFQN", tableFQN))
+		return errors.Trace(firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package replicate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardGroupDDLBarrier(t *testing.T) {
+	connector := &fakeConnector{}
+	group := newShardGroup(2, 0)
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}}
+	sessions := make([]*IncrementReplicateSession, 0, 2)
+	for i := 0; i < 2; i++ {
+		extStorage, err := storage.NewLocalStorage(t.TempDir())
+		require.NoError(t, err)
+		writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "t", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 1})
+		writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+			Schema: "db", Table: "t", TableVersion: 200, Version: 1, Columns: columns, TotalColumns: 1,
+			Type: timodel.ActionAddColumn, Query: "ALTER TABLE `db`.`t` ADD COLUMN `c` INT",
+		})
+		sessions = append(sessions, newTestSession(t, connector, withStorage(extStorage), func(sess *IncrementReplicateSession) {
+			sess.shards, sess.shard = group, i
+		}))
+	}
+	round := func(sess *IncrementReplicateSession) {
+		dmlFileMap, err := sess.getNewFiles()
+		require.NoError(t, err)
+		require.NoError(t, sess.handleNewFiles(dmlFileMap, 1))
+	}
+
+	// the DDL waits for the second shard, whose files before it may not be merged yet
+	round(sessions[0])
+	require.Empty(t, connector.executed)
+	round(sessions[0])
+	require.Empty(t, connector.executed)
+
+	// the DDL is applied once by the last shard arriving at it, and skipped by the others
+	round(sessions[1])
+	require.Equal(t, []string{"ALTER TABLE `db`.`t` ADD COLUMN `c` INT"}, connector.executed)
+	round(sessions[0])
+	require.Len(t, connector.executed, 1)
+	for _, sess := range sessions {
+		require.Equal(t, uint64(200), sess.checkpoint.appliedSchemaVersion("db.t"))
+		// the query is cleared once applied
+		dmlFileMap, err := sess.getNewFiles()
+		require.NoError(t, err)
+		require.Empty(t, dmlFileMap)
+	}
+}
+
+func TestShardGroupProgress(t *testing.T) {
+	group := newShardGroup(2, 0)
+	// the shards which have merged no file yet are not counted
+	require.Equal(t, uint64(300), group.loadedCommitTs(0, 300))
+	require.Equal(t, uint64(200), group.loadedCommitTs(1, 200))
+	require.Equal(t, uint64(200), group.loadedCommitTs(0, 400))
+
+	now := time.Now()
+	info := group.backlog(0, apiservice.BacklogInfo{Files: 2, Bytes: 100, MergeBytesPerSecond: 10, ETASeconds: 10, UpdatedAt: now})
+	// the backlog of the other shard is unknown
+	require.Equal(t, int64(-1), info.ETASeconds)
+	info = group.backlog(1, apiservice.BacklogInfo{Files: 1, Bytes: 50, MergeBytesPerSecond: 5, ETASeconds: 20, UpdatedAt: now})
+	require.Equal(t, apiservice.BacklogInfo{Files: 3, Bytes: 150, MergeBytesPerSecond: 15, ETASeconds: 20, UpdatedAt: now}, info)
+}
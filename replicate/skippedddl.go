@@ -0,0 +1,62 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+)
+
+// SkippedDDLFile is the file in the increment storage recording the DDLs skipped by --on-unsupported-ddl=skip,
+// the tables in the data warehouse may diverge from TiDB since them until they are altered by hand.
+const SkippedDDLFile = "skipped-ddl.json"
+
+// SkippedDDL is a DDL not applied to the data warehouse since the data warehouse does not support it
+type SkippedDDL struct {
+	Table        string    `json:"table"`
+	TableVersion uint64    `json:"table_version"`
+	Type         string    `json:"type"`
+	Query        string    `json:"query"`
+	Reason       string    `json:"reason"`
+	SkippedAt    time.Time `json:"skipped_at"`
+}
+
+// ReadSkippedDDLs returns the DDLs skipped in the increment storage, in the order they are skipped
+func ReadSkippedDDLs(ctx context.Context, extStorage storage.ExternalStorage) ([]SkippedDDL, error) {
+	exists, err := extStorage.FileExists(ctx, SkippedDDLFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := extStorage.ReadFile(ctx, SkippedDDLFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ddls []SkippedDDL
+	if err = json.Unmarshal(data, &ddls); err != nil {
+		return nil, errors.Annotatef(err, "invalid skipped DDLs %s", SkippedDDLFile)
+	}
+	return ddls, nil
+}
+
+// recordSkippedDDL appends the DDL to the skipped DDLs next to the checkpoint, the sessions sharing the checkpoint
+// record them in turn. A DDL skipped again after restart is recorded once.
+func (c *IncrementCheckpoint) recordSkippedDDL(ctx context.Context, ddl SkippedDDL) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ddls, err := ReadSkippedDDLs(ctx, c.extStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if slices.ContainsFunc(ddls, func(d SkippedDDL) bool { return d.Table == ddl.Table && d.TableVersion == ddl.TableVersion }) {
+		return nil
+	}
+	data, err := json.MarshalIndent(append(ddls, ddl), "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.extStorage.WriteFile(ctx, SkippedDDLFile, data))
+}
@@ -0,0 +1,107 @@
+package replicate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	timodel "github.com/pingcap/tidb/parser/model"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func newUnsupportedDDLSession(t *testing.T, policy tidbsql.UnsupportedDDLPolicy) (*IncrementReplicateSession, *fakeConnector) {
+	connector := &fakeConnector{ddlErr: tidbsql.NewUnsupportedDDLError("Received modify column ddl of column v, which is not supported")}
+	sess := newTestSession(t, connector, func(sess *IncrementReplicateSession) {
+		sess.unsupportedDDLPolicy = policy
+	})
+	extStorage := sess.externalStorage
+	columns := []cloudstorage.TableCol{{ID: "1", Name: "id", Tp: "int", IsPK: "true"}, {ID: "2", Name: "v", Tp: "int"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{Schema: "db", Table: "t", TableVersion: 100, Version: 1, Columns: columns, TotalColumns: 2})
+	modified := []cloudstorage.TableCol{columns[0], {ID: "2", Name: "v", Tp: "varchar", Precision: "10"}}
+	writeSchemaFile(t, extStorage, cloudstorage.TableDefinition{
+		Schema: "db", Table: "t", TableVersion: 200, Version: 1, Columns: modified, TotalColumns: 2,
+		Type: timodel.ActionModifyColumn, Query: "ALTER TABLE `db`.`t` MODIFY COLUMN `v` VARCHAR(10)",
+	})
+	return sess, connector
+}
+
+func TestSkipUnsupportedDDL(t *testing.T) {
+	sess, connector := newUnsupportedDDLSession(t, tidbsql.UnsupportedDDLSkip)
+	dmlFileMap, err := sess.getNewFiles()
+	require.NoError(t, err)
+	require.NoError(t, sess.handleNewFiles(dmlFileMap, 1))
+	require.Len(t, connector.executed, 1)
+	// the files after the DDL are merged by its columns
+	require.Equal(t, "varchar", connector.initialized[len(connector.initialized)-1][1].Tp)
+	require.Equal(t, uint64(200), sess.checkpoint.appliedSchemaVersion("db.t"))
+
+	ddls, err := ReadSkippedDDLs(sess.ctx, sess.externalStorage)
+	require.NoError(t, err)
+	require.Len(t, ddls, 1)
+	require.Equal(t, "db.t", ddls[0].Table)
+	require.Equal(t, uint64(200), ddls[0].TableVersion)
+	require.Equal(t, "ALTER TABLE `db`.`t` MODIFY COLUMN `v` VARCHAR(10)", ddls[0].Query)
+	require.Contains(t, ddls[0].Reason, "not supported")
+
+	// a DDL skipped again is recorded once
+	require.NoError(t, sess.checkpoint.recordSkippedDDL(sess.ctx, ddls[0]))
+	ddls, err = ReadSkippedDDLs(sess.ctx, sess.externalStorage)
+	require.NoError(t, err)
+	require.Len(t, ddls, 1)
+
+	// the error policy fails the replication
+	sess, _ = newUnsupportedDDLSession(t, tidbsql.UnsupportedDDLError)
+	dmlFileMap, err = sess.getNewFiles()
+	require.NoError(t, err)
+	err = sess.handleNewFiles(dmlFileMap, 1)
+	require.ErrorContains(t, err, "set --on-unsupported-ddl to skip or pause it")
+}
+
+func TestPauseUnsupportedDDL(t *testing.T) {
+	sess, connector := newUnsupportedDDLSession(t, tidbsql.UnsupportedDDLPause)
+	scheduler, err := NewIncrementScheduler(0, 0, time.Second, BatchPolicy{}, []string{"db.t"}, nil, sess.status)
+	require.NoError(t, err)
+	sess.scheduler = scheduler
+	require.Equal(t, apiservice.ErrTableNotPaused, errors.Cause(scheduler.ResumeAfterDDL("db.t")))
+	require.Equal(t, apiservice.ErrTableNotFound, errors.Cause(scheduler.ResumeAfterDDL("db.other")))
+
+	dmlFileMap, err := sess.getNewFiles()
+	require.NoError(t, err)
+	err = sess.handleNewFiles(dmlFileMap, 1)
+	pausedErr, ok := errors.Cause(err).(*ddlPausedError)
+	require.True(t, ok)
+	require.ErrorContains(t, pausedErr, "POST /api/v1/tables/db.t/resume-after-ddl")
+
+	paused := make(chan error)
+	go func() { paused <- sess.pause(pausedErr) }()
+	require.Eventually(t, func() bool { return scheduler.ResumeAfterDDL("db.t") == nil }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, <-paused)
+	require.Equal(t, apiservice.TableStatusNormal, sess.status.Status().TablesInfo["db.t"].Status)
+
+	// the DDL handled by hand is found again and not executed
+	dmlFileMap, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.NotEmpty(t, dmlFileMap)
+	require.NoError(t, sess.handleNewFiles(dmlFileMap, 1))
+	require.Len(t, connector.executed, 1)
+	dmlFileMap, err = sess.getNewFiles()
+	require.NoError(t, err)
+	require.Empty(t, dmlFileMap)
+}
+
+func TestDDLClassifiedByConnector(t *testing.T) {
+	// the connector pauses the MODIFY COLUMN DDLs by its classes instead of refusing them
+	sess, connector := newUnsupportedDDLSession(t, tidbsql.UnsupportedDDLError)
+	connector.ddlClasses = tidbsql.WithDDLActionClasses(map[timodel.ActionType]tidbsql.DDLActionClass{
+		timodel.ActionModifyColumn: tidbsql.DDLActionMustPause,
+	})
+	dmlFileMap, err := sess.getNewFiles()
+	require.NoError(t, err)
+	err = sess.handleNewFiles(dmlFileMap, 1)
+	_, paused := errors.Cause(err).(*ddlPausedError)
+	require.True(t, paused, "%v", err)
+	require.Empty(t, connector.executed)
+}
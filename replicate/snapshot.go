@@ -3,17 +3,28 @@ package replicate
 import (
 	"context"
 	"database/sql"
+	stderrors "errors"
 	"fmt"
 	"net/url"
+	"slices"
 	"time"
 
+	"github.com/pingcap-inc/tidb2dw/pkg/apiservice"
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
 	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/fieldlimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/metrics"
+	"github.com/pingcap-inc/tidb2dw/pkg/ratelimit"
+	"github.com/pingcap-inc/tidb2dw/pkg/retry"
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
 	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
 	"github.com/pingcap-inc/tidb2dw/pkg/utils"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	"github.com/pingcap/tidb/br/pkg/storage"
-	putil "github.com/pingcap/tiflow/pkg/util"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
 	"go.uber.org/zap"
 )
 
@@ -30,6 +41,30 @@ type SnapshotReplicateSession struct {
 
 	StorageWorkspaceUri url.URL
 	externalStorage     storage.ExternalStorage
+	// compression is the codec of the dumped data files
+	compression utils.Compression
+	// fileExtension is the extension of the dumped data files, e.g. .csv.gz
+	fileExtension string
+	// converter converts the dumped files into the Parquet files loaded with --staging-format=parquet, nil if the
+	// dumped files are loaded as they are
+	converter *stagingformat.Converter
+
+	// fieldLimitChecker checks the dumped files before loading, nil if the check is disabled
+	fieldLimitChecker *fieldlimit.Checker
+	// columnFilter is the columns dumped and loaded, nil if all the columns are replicated
+	columnFilter *columnfilter.Filter
+	// where is the predicate of the rows dumped and loaded, empty if all the rows are replicated
+	where string
+	// feed streams the files being dumped with --pipelined-snapshot, nil if the snapshot is already dumped
+	feed *dumpling.FileFeed
+	// validator validates the loaded snapshot before it is recorded loaded, nil if the validation is disabled
+	validator *SnapshotValidator
+	// retryPolicy is how a failed load of the files is retried
+	retryPolicy retry.Policy
+	// loadedRows is the rows loaded by the finished calls of LoadSnapshot
+	loadedRows int64
+	// status receives the progress of the session
+	status *apiservice.APIInfo
 
 	ctx    context.Context
 	logger *zap.Logger
@@ -41,6 +76,16 @@ func NewSnapshotReplicateSession(
 	tidbConfig *tidbsql.TiDBConfig,
 	sourceDatabase, sourceTable string,
 	storageUri *url.URL,
+	compression utils.Compression,
+	converter *stagingformat.Converter,
+	fieldLimitChecker *fieldlimit.Checker,
+	columnFilter *columnfilter.Filter,
+	where string,
+	feed *dumpling.FileFeed,
+	validator *SnapshotValidator,
+	retryPolicy retry.Policy,
+	limiters *ratelimit.Limiters,
+	status *apiservice.APIInfo,
 	logger *zap.Logger,
 ) (*SnapshotReplicateSession, error) {
 	sess := &SnapshotReplicateSession{
@@ -49,6 +94,16 @@ func NewSnapshotReplicateSession(
 		SourceDatabase:      sourceDatabase,
 		SourceTable:         sourceTable,
 		StorageWorkspaceUri: *storageUri,
+		compression:         compression,
+		fileExtension:       compression.CSVFileExtension(),
+		converter:           converter,
+		fieldLimitChecker:   fieldLimitChecker,
+		columnFilter:        columnFilter,
+		where:               where,
+		feed:                feed,
+		validator:           validator,
+		retryPolicy:         retryPolicy,
+		status:              status,
 		ctx:                 ctx,
 		logger:              logger,
 	}
@@ -66,14 +121,15 @@ func NewSnapshotReplicateSession(
 		// Setup progress reporters
 		sess.OnSnapshotLoadProgress = func(loadedRows int64) {
 			sess.logger.Info("Snapshot load progress", zap.Int64("loadedRows", loadedRows))
+			sess.status.SetTableSnapshotLoadedRows(fmt.Sprintf("%s.%s", sourceDatabase, sourceTable), loadedRows)
 		}
 	}
 	{
-		externalStorage, err := putil.GetExternalStorageFromURI(sess.ctx, storageUri.String())
+		externalStorage, err := utils.GetExternalStorageFromURI(sess.ctx, storageUri.String())
 		if err != nil {
-			return nil, errors.Trace(err)
+			return nil, diag.Storage(errors.Trace(err))
 		}
-		sess.externalStorage = externalStorage
+		sess.externalStorage = limiters.Wrap(externalStorage)
 	}
 	return sess, nil
 }
@@ -86,10 +142,7 @@ func (sess *SnapshotReplicateSession) Close() {
 
 func (sess *SnapshotReplicateSession) Run() error {
 	switch sess.StorageWorkspaceUri.Scheme {
-	case "s3", "gcs", "gs":
-		if err := sess.DataWarehousePool.CopyTableSchema(sess.SourceDatabase, sess.SourceTable, sess.TiDBPool); err != nil {
-			return errors.Trace(err)
-		}
+	case "s3", "gcs", "gs", "azure":
 	default:
 		return errors.Errorf("%s does not supprt data warehouse connector now...", sess.StorageWorkspaceUri.Scheme)
 	}
@@ -100,34 +153,276 @@ func (sess *SnapshotReplicateSession) Run() error {
 	}
 	endTime := time.Now()
 
+	// the table is not recorded loaded if the validation fails, it is validated again after the program restarts
+	if sess.validator != nil {
+		if err := sess.validator.Validate(sess.ctx, sess.DataWarehousePool, sess.TiDBPool, sess.SourceDatabase, sess.SourceTable, sess.columnFilter, sess.where); err != nil {
+			return errors.Annotate(err, "Failed to validate snapshot")
+		}
+	}
+
 	// Write load info to workspace to record the status of load,
-	// loadinfo exists means the data has been all loaded into data warehouse.
+	// loadinfo exists means the data of the table has been all loaded into data warehouse.
 	loadinfo := fmt.Sprintf("Copy to data warehouse start time: %s\nCopy to data warehouse end time: %s\n", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
-	if err := sess.externalStorage.WriteFile(sess.ctx, "loadinfo", []byte(loadinfo)); err != nil {
-		sess.logger.Error("Failed to upload loadinfo", zap.Error(err))
+	// the snapshot is loaded again after the restart if the loadinfo is not written
+	if err := sess.externalStorage.WriteFile(sess.ctx, SnapshotLoadInfoFile(sess.SourceDatabase, sess.SourceTable), []byte(loadinfo)); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to upload loadinfo"))
 	}
 	sess.logger.Info("Successfully upload loadinfo", zap.String("loadinfo", loadinfo))
 	return nil
 }
 
+// SnapshotLoadInfoFile is the file in the snapshot storage recording the snapshot of the table is loaded,
+// each table has its own file so that a table is not reloaded after the process restarts.
+func SnapshotLoadInfoFile(sourceDatabase, sourceTable string) string {
+	return fmt.Sprintf("%s.%s.loadinfo", sourceDatabase, sourceTable)
+}
+
 func (sess *SnapshotReplicateSession) loadSnapshotDataIntoDataWarehouse() error {
-	dumpFilePrefix := fmt.Sprintf("%s.%s.", sess.SourceDatabase, sess.SourceTable)
-	if err := sess.DataWarehousePool.LoadSnapshot(sess.SourceTable, dumpFilePrefix, sess.OnSnapshotLoadProgress); err != nil {
+	if sess.feed != nil {
+		return sess.loadPipelinedSnapshot()
+	}
+	tableFQN := fmt.Sprintf("%s.%s", sess.SourceDatabase, sess.SourceTable)
+	files, err := dumpling.GetDumpedFiles(sess.ctx, sess.externalStorage, tableFQN, sess.fileExtension)
+	if err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to get dumped files"))
+	}
+	progressFile := SnapshotLoadProgressFile(sess.SourceDatabase, sess.SourceTable)
+	progress, err := readSnapshotLoadProgress(sess.ctx, sess.externalStorage, progressFile)
+	if err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to read snapshot load progress"))
+	}
+
+	if progress != nil && progress.started() {
+		if progress.Compression != "" && progress.Compression != sess.compression {
+			return diag.Storage(errors.Errorf("The snapshot of %s is dumped with %s compression recorded by %s/%s, "+
+				"but --snapshot-compression is %s, please set --snapshot-compression=%s to resume the load",
+				tableFQN, progress.Compression, sess.externalStorage.URI(), progressFile, sess.compression, progress.Compression))
+		}
+		if !progress.matches(files) {
+			return diag.Storage(errors.Errorf("The dumped files of %s do not match the files recorded by %s/%s, "+
+				"please drop the table in data warehouse, delete the record and restart the program",
+				tableFQN, sess.externalStorage.URI(), progressFile))
+		}
+		// the table is partially loaded, it must not be recreated
+		columns, err := sess.getTableColumns()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = metrics.CountConnectorError(tableFQN, metrics.OpInitSchema, sess.DataWarehousePool.InitSchema(columns)); err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+		sess.logger.Info("Resuming snapshot load", zap.Int("files", len(files)), zap.Int("pendingFiles", len(progress.pending())))
+	} else {
+		err = sess.DataWarehousePool.CopyTableSchema(sess.SourceDatabase, sess.SourceTable, sess.TiDBPool)
+		if err = metrics.CountConnectorError(tableFQN, metrics.OpCopyTableSchema, err); err != nil {
+			return diag.Warehouse(errors.Trace(err))
+		}
+		progress = newSnapshotLoadProgress(files, sess.compression)
+		if err = progress.write(sess.ctx, sess.externalStorage, progressFile); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to write snapshot load progress"))
+		}
+		sess.logger.Info("Loading dumped files", zap.Int("files", len(files)))
+	}
+
+	pending := progress.pending()
+	if sess.fieldLimitChecker != nil {
+		if err := sess.checkFieldLimits(pending); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return sess.loadFiles(progress, progressFile, pending)
+}
+
+// loadPipelinedSnapshot loads the files of the table as they are dumped until the dump is finished. The dump
+// of an interrupted process starts over, so does the load, the table is recreated.
+func (sess *SnapshotReplicateSession) loadPipelinedSnapshot() error {
+	tableFQN := fmt.Sprintf("%s.%s", sess.SourceDatabase, sess.SourceTable)
+	err := sess.DataWarehousePool.CopyTableSchema(sess.SourceDatabase, sess.SourceTable, sess.TiDBPool)
+	if err = metrics.CountConnectorError(tableFQN, metrics.OpCopyTableSchema, err); err != nil {
+		return diag.Warehouse(errors.Trace(err))
+	}
+	progressFile := SnapshotLoadProgressFile(sess.SourceDatabase, sess.SourceTable)
+	progress := newSnapshotLoadProgress(nil, sess.compression)
+	for done := false; !done; {
+		var files []string
+		files, done, err = sess.feed.Next(sess.ctx, tableFQN, len(progress.Files))
+		if err != nil {
+			if sess.ctx.Err() != nil && stderrors.Is(err, sess.ctx.Err()) {
+				return errors.Trace(err)
+			}
+			return diag.Source(errors.Annotate(err, "Failed to dump snapshot"))
+		}
+		if len(files) == 0 {
+			continue
+		}
+		progress.add(files)
+		if err = progress.write(sess.ctx, sess.externalStorage, progressFile); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to write snapshot load progress"))
+		}
+		if sess.fieldLimitChecker != nil {
+			if err = sess.checkFieldLimits(files); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		sess.logger.Info("Loading dumped files while dumping", zap.Int("files", len(files)), zap.Bool("dumpFinished", done))
+		if err = sess.loadFiles(progress, progressFile, files); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// loadFiles loads the pending files, the files not loaded yet are retried by the retry policy after a load failed
+// with a retryable error, the files confirmed loaded are never loaded again
+func (sess *SnapshotReplicateSession) loadFiles(progress *snapshotLoadProgress, progressFile string, pending []string) error {
+	tableFQN := fmt.Sprintf("%s.%s", sess.SourceDatabase, sess.SourceTable)
+	staged, format, err := sess.stageFiles(pending)
+	if err != nil {
 		return errors.Trace(err)
 	}
+	onFilesLoaded := func(loaded []string) error {
+		dumped := make([]string, 0, len(loaded))
+		for _, file := range loaded {
+			dumped = append(dumped, staged.dumped[file])
+		}
+		progress.markLoaded(dumped, format)
+		if err := progress.write(sess.ctx, sess.externalStorage, progressFile); err != nil {
+			return diag.Storage(errors.Annotate(err, "Failed to write snapshot load progress"))
+		}
+		sess.logger.Info("Snapshot files loaded", zap.Int("files", len(loaded)), zap.Int("pendingFiles", len(progress.pending())))
+		return nil
+	}
+	connectorRetryable := retryClassifier(sess.DataWarehousePool)
+	// a failure of recording the progress is not retried, the files would be loaded again
+	retryable := func(err error) bool {
+		return diag.CategoryOf(err) == diag.CategoryWarehouse && connectorRetryable(err)
+	}
+	return retry.Do(sess.ctx, sess.retryPolicy, retryable, func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		// the rows reported by a call start from 0
+		var callRows int64
+		err := sess.DataWarehousePool.LoadSnapshot(sess.SourceTable, staged.paths(pending), func(loadedRows int64) {
+			callRows = loadedRows
+			sess.OnSnapshotLoadProgress(sess.loadedRows + loadedRows)
+		}, onFilesLoaded)
+		sess.loadedRows += callRows
+		if err == nil {
+			return nil
+		}
+		metrics.CountConnectorError(tableFQN, metrics.OpLoadSnapshot, err)
+		pending = progress.pending()
+		return diag.Warehouse(errors.Annotatef(err, "Failed to load snapshot files of %s in %s", tableFQN, sess.externalStorage.URI()))
+	}, func(n int, backoff time.Duration, err error) {
+		sess.logger.Warn("Failed to load snapshot files, retrying the files not loaded yet",
+			zap.Int("retry", n+1), zap.Int("pendingFiles", len(pending)), zap.Duration("backoff", backoff), zap.Error(err))
+	})
+}
+
+// stagedFiles maps the dumped files to the files loaded in their place
+type stagedFiles struct {
+	staged map[string]string
+	dumped map[string]string
+}
+
+// paths returns the files loaded of the dumped files
+func (s stagedFiles) paths(files []string) []string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, s.staged[file])
+	}
+	return paths
+}
+
+// stageFiles returns the files loaded of the dumped files and their format. The dumped files are converted into the
+// Parquet files by the converter if it is set, and loaded as they are otherwise.
+func (sess *SnapshotReplicateSession) stageFiles(files []string) (stagedFiles, stagingformat.Format, error) {
+	staged := stagedFiles{staged: make(map[string]string, len(files)), dumped: make(map[string]string, len(files))}
+	if sess.converter == nil {
+		for _, file := range files {
+			staged.staged[file], staged.dumped[file] = file, file
+		}
+		return staged, stagingformat.CSV, nil
+	}
+	columns, err := sess.getTableColumns()
+	if err != nil {
+		return staged, stagingformat.Parquet, errors.Trace(err)
+	}
+	// the dumped files have the columns replicated only
+	columns = sess.columnFilter.Columns(columns)
+	for _, file := range files {
+		// the file is converted again if the program restarts before it is loaded
+		parquetPath, _, err := sess.converter.Convert(sess.ctx, sess.externalStorage, file, columns, sess.compression, false)
+		if err != nil {
+			return staged, stagingformat.Parquet, diag.Storage(errors.Trace(err))
+		}
+		staged.staged[file], staged.dumped[parquetPath] = parquetPath, file
+	}
+	sess.logger.Info("Converted dumped files into Parquet", zap.Int("files", len(files)))
+	return staged, stagingformat.Parquet, nil
+}
+
+// checkFieldLimits scans the dumped files of the table before they are loaded
+func (sess *SnapshotReplicateSession) checkFieldLimits(files []string) error {
+	columns, err := sess.getTableColumns()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the dumped files have the columns replicated only
+	columns = sess.columnFilter.Columns(columns)
+
+	tableFQN := fmt.Sprintf("%s.%s", sess.SourceDatabase, sess.SourceTable)
+	for _, file := range files {
+		if _, _, err := sess.fieldLimitChecker.Check(sess.ctx, tableFQN, file, columns); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
 
+func (sess *SnapshotReplicateSession) getTableColumns() ([]cloudstorage.TableCol, error) {
+	return getTableColumns(sess.TiDBPool, sess.SourceDatabase, sess.SourceTable)
+}
+
+// getTableColumns returns the columns of the source table, with the primary key columns marked
+func getTableColumns(tidbPool *sql.DB, sourceDatabase, sourceTable string) ([]cloudstorage.TableCol, error) {
+	columns, err := tidbsql.GetTiDBTableColumn(tidbPool, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pkColumns, err := tidbsql.GetTiDBTablePKColumns(tidbPool, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for i := range columns {
+		if slices.Contains(pkColumns, columns[i].Name) {
+			columns[i].IsPK = "true"
+		}
+	}
+	return columns, nil
+}
+
 func StartReplicateSnapshot(
 	ctx context.Context,
 	dwConnector coreinterfaces.Connector,
 	tableFQN string,
 	tidbConfig *tidbsql.TiDBConfig,
 	storageUri *url.URL,
+	compression utils.Compression,
+	converter *stagingformat.Converter,
+	fieldLimitChecker *fieldlimit.Checker,
+	columnFilter *columnfilter.Filter,
+	where string,
+	feed *dumpling.FileFeed,
+	validator *SnapshotValidator,
+	retryPolicy retry.Policy,
+	limiters *ratelimit.Limiters,
+	status *apiservice.APIInfo,
 ) error {
 	logger := log.L().With(zap.String("table", tableFQN))
 	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
-	session, err := NewSnapshotReplicateSession(ctx, dwConnector, tidbConfig, sourceDatabase, sourceTable, storageUri, logger)
+	session, err := NewSnapshotReplicateSession(ctx, dwConnector, tidbConfig, sourceDatabase, sourceTable, storageUri, compression, converter, fieldLimitChecker, columnFilter, where, feed, validator, retryPolicy, limiters, status, logger)
 	if err != nil {
 		logger.Error("Failed to create snapshot replicate session", zap.Error(err), zap.String("tableFQN", tableFQN))
 		return errors.Trace(err)
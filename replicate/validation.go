@@ -0,0 +1,164 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/dumpling"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// SnapshotValidator validates the snapshot of each table once it is loaded, the row count and the sums of a
+// few integer and decimal columns of the table in the data warehouse are compared with TiDB at the snapshot TSO.
+// The results of all tables are recorded in validation.json of the snapshot storage.
+type SnapshotValidator struct {
+	// storage is the snapshot storage
+	storage storage.ExternalStorage
+	// checksumColumns is the max number of columns summed, 0 if only the rows are counted
+	checksumColumns int
+
+	mu sync.Mutex
+	// snapshotTSO is read from the dumpling metadata by the first validation, the metadata is written
+	// at the end of the dump which may be still running with --pipelined-snapshot
+	snapshotTSO uint64
+	report      *validation.Report
+}
+
+func NewSnapshotValidator(ctx context.Context, snapshotStorage storage.ExternalStorage, checksumColumns int) (*SnapshotValidator, error) {
+	report, err := validation.ReadReport(ctx, snapshotStorage)
+	if err != nil {
+		return nil, diag.Storage(errors.Annotate(err, "Failed to read snapshot validation report"))
+	}
+	return &SnapshotValidator{
+		storage:         snapshotStorage,
+		checksumColumns: checksumColumns,
+		report:          report,
+	}, nil
+}
+
+func (v *SnapshotValidator) getSnapshotTSO(ctx context.Context) (uint64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.snapshotTSO != 0 {
+		return v.snapshotTSO, nil
+	}
+	tso, err := dumpling.ReadSnapshotTSO(ctx, v.storage)
+	if err != nil {
+		return 0, diag.Storage(errors.Trace(err))
+	}
+	// the results of a previous snapshot are stale
+	if v.report.SnapshotTSO != tso {
+		v.report.SnapshotTSO = tso
+		v.report.Tables = make(map[string]*validation.TableReport)
+	}
+	v.snapshotTSO = tso
+	return tso, nil
+}
+
+// Validate compares the table loaded by the connector with the source table at the snapshot TSO, an error
+// listing the differences is returned if they do not match. Only the columns retained by columnFilter are summed,
+// and only the rows matching where are aggregated in TiDB if it is not empty.
+func (v *SnapshotValidator) Validate(ctx context.Context, dwConnector coreinterfaces.Connector, tidbPool *sql.DB, sourceDatabase, sourceTable string, columnFilter *columnfilter.Filter, where string) error {
+	tableFQN := fmt.Sprintf("%s.%s", sourceDatabase, sourceTable)
+	aggregator, ok := dwConnector.(coreinterfaces.TableAggregator)
+	if !ok {
+		return errors.Errorf("The data warehouse connector of %s does not support validating the snapshot", tableFQN)
+	}
+	tso, err := v.getSnapshotTSO(ctx)
+	if err != nil {
+		return errors.Annotate(err, "Failed to get the snapshot TSO")
+	}
+
+	var sumColumns []validation.SumColumn
+	if v.checksumColumns > 0 {
+		columns, err := getTableColumns(tidbPool, sourceDatabase, sourceTable)
+		if err != nil {
+			return diag.Source(errors.Trace(err))
+		}
+		sumColumns = validation.ChecksumColumns(columnFilter.Columns(columns), v.checksumColumns)
+	}
+	source, err := aggregateSnapshot(ctx, tidbPool, tso, sourceDatabase, sourceTable, where, sumColumns, validation.KeyRange{})
+	if err != nil {
+		return diag.Source(errors.Annotatef(err, "Failed to aggregate %s at the snapshot TSO %d", tableFQN, tso))
+	}
+	target, err := aggregator.AggregateTable(sourceTable, sumColumns)
+	if err != nil {
+		return diag.Warehouse(errors.Annotatef(err, "Failed to aggregate %s in data warehouse", tableFQN))
+	}
+
+	tableReport := &validation.TableReport{
+		ValidatedAt: time.Now(),
+		Source:      source,
+		Target:      target,
+		Mismatches:  validation.Compare(source, target, sumColumns),
+	}
+	for _, column := range sumColumns {
+		tableReport.SumColumns = append(tableReport.SumColumns, column.Name)
+	}
+	tableReport.Passed = len(tableReport.Mismatches) == 0
+	if err = v.record(ctx, tableFQN, tableReport); err != nil {
+		return diag.Storage(errors.Annotate(err, "Failed to write snapshot validation report"))
+	}
+	if !tableReport.Passed {
+		return diag.Warehouse(errors.Errorf("The snapshot of %s loaded into data warehouse does not match TiDB at TSO %d: %s, see %s/%s",
+			tableFQN, tso, strings.Join(tableReport.Mismatches, "; "), v.storage.URI(), validation.ReportFile))
+	}
+	log.Info("Snapshot validated", zap.String("table", tableFQN), zap.Uint64("snapshotTSO", tso),
+		zap.Int64("rows", source.Rows), zap.Strings("sumColumns", tableReport.SumColumns))
+	return nil
+}
+
+func (v *SnapshotValidator) record(ctx context.Context, tableFQN string, tableReport *validation.TableReport) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.report.Tables[tableFQN] = tableReport
+	return errors.Trace(v.report.Write(ctx, v.storage))
+}
+
+// withSnapshot runs f on a connection of its own reading TiDB as of the TSO
+func withSnapshot(ctx context.Context, tidbPool *sql.DB, tso uint64, f func(conn *sql.Conn) error) error {
+	conn, err := tidbPool.Conn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+
+	setSnapshot := fmt.Sprintf("SET @@tidb_snapshot = '%d'", tso)
+	if _, err = conn.ExecContext(ctx, setSnapshot); err != nil {
+		return diag.WrapSQL(err, setSnapshot)
+	}
+	// the connection is returned to the pool, it must not keep reading the snapshot
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SET @@tidb_snapshot = ''"); err != nil {
+			log.Warn("Failed to reset tidb_snapshot", zap.Error(err))
+		}
+	}()
+	return f(conn)
+}
+
+// aggregateSnapshot aggregates the rows of the source table in the range as of the snapshot TSO
+func aggregateSnapshot(ctx context.Context, tidbPool *sql.DB, tso uint64, sourceDatabase, sourceTable, where string, sumColumns []validation.SumColumn, keyRange validation.KeyRange) (*validation.Aggregates, error) {
+	query := validation.GenAggregateQuery(fmt.Sprintf("%s.%s", tidbsql.QuoteIdent(sourceDatabase), tidbsql.QuoteIdent(sourceTable)), sumColumns, "", tidbsql.QuoteIdent)
+	query = validation.AppendWhere(query, where, keyRange.Predicate(tidbsql.QuoteIdent))
+	var aggregates *validation.Aggregates
+	err := withSnapshot(ctx, tidbPool, tso, func(conn *sql.Conn) error {
+		var err error
+		aggregates, err = validation.QueryAggregates(ctx, conn, query, sumColumns)
+		return errors.Trace(err)
+	})
+	return aggregates, errors.Trace(err)
+}
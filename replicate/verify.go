@@ -0,0 +1,327 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/columnfilter"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/diag"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/config"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"go.uber.org/zap"
+)
+
+// VerifyOptions is how the tables are compared by `tidb2dw verify`
+type VerifyOptions struct {
+	// Concurrency is the number of buckets compared at the same time
+	Concurrency int
+	// Buckets is the max number of ranges of the primary key a table is split into
+	Buckets int
+	// ChecksumColumns is the max number of columns summed, 0 if only the rows are counted
+	ChecksumColumns int
+	// SampleRows is the max number of rows differing reported for a table
+	SampleRows int
+	// ScanRows is the max number of rows read from each side to find the rows differing in a bucket
+	ScanRows int
+}
+
+// Verifier compares the tables in TiDB at a TSO with the tables in the data warehouse. A table is split into buckets
+// by ranges of its first primary key column if it is an integer, the row count and the sums of a few integer and
+// decimal columns of each bucket are compared, and the rows of the buckets differing are read to find a sample of
+// the rows differing.
+type Verifier struct {
+	tidbPool *sql.DB
+	tso      uint64
+	opts     VerifyOptions
+}
+
+func NewVerifier(tidbPool *sql.DB, tso uint64, opts VerifyOptions) *Verifier {
+	return &Verifier{tidbPool: tidbPool, tso: tso, opts: opts}
+}
+
+// VerifyTable compares the table in TiDB with targetTable read by the verifier of the data warehouse. Only the columns
+// retained by columnFilter are compared, and only the rows matching where are read from TiDB if it is not empty.
+func (v *Verifier) VerifyTable(ctx context.Context, dwVerifier coreinterfaces.TableVerifier, tableFQN, targetTable string, columnFilter *columnfilter.Filter, where string) (*validation.TableVerification, error) {
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	columns, err := getTableColumns(v.tidbPool, sourceDatabase, sourceTable)
+	if err != nil {
+		return nil, diag.Source(errors.Trace(err))
+	}
+	columns = columnFilter.Columns(columns)
+	var pkColumns []cloudstorage.TableCol
+	for _, column := range columns {
+		if column.IsPK == "true" {
+			pkColumns = append(pkColumns, column)
+		}
+	}
+
+	result := &validation.TableVerification{}
+	sumColumns := validation.ChecksumColumns(columns, v.opts.ChecksumColumns)
+	for _, column := range sumColumns {
+		result.SumColumns = append(result.SumColumns, column.Name)
+	}
+	ranges := []validation.KeyRange{{}}
+	if len(pkColumns) > 0 && validation.IsIntegerColumn(pkColumns[0]) {
+		result.KeyColumn = pkColumns[0].Name
+		if ranges, err = v.splitTable(ctx, sourceDatabase, sourceTable, result.KeyColumn, where); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	result.Buckets = len(ranges)
+
+	sources := make([]*validation.Aggregates, len(ranges))
+	targets := make([]*validation.Aggregates, len(ranges))
+	err = runConcurrently(ctx, len(ranges), v.opts.Concurrency, func(i int) error {
+		var err error
+		if sources[i], err = aggregateSnapshot(ctx, v.tidbPool, v.tso, sourceDatabase, sourceTable, where, sumColumns, ranges[i]); err != nil {
+			return diag.Source(errors.Annotatef(err, "Failed to aggregate %s at TSO %d", tableFQN, v.tso))
+		}
+		if targets[i], err = dwVerifier.AggregateRange(targetTable, sumColumns, ranges[i]); err != nil {
+			return diag.Warehouse(errors.Annotatef(err, "Failed to aggregate %s in data warehouse", tableFQN))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	result.Source, result.Target = &validation.Aggregates{}, &validation.Aggregates{}
+	for i := range ranges {
+		addAggregates(result.Source, sources[i])
+		addAggregates(result.Target, targets[i])
+		if diffs := validation.Compare(sources[i], targets[i], sumColumns); len(diffs) > 0 {
+			result.MismatchedBuckets = append(result.MismatchedBuckets, validation.BucketMismatch{
+				Range:       ranges[i],
+				Source:      sources[i],
+				Target:      targets[i],
+				Differences: diffs,
+			})
+		}
+	}
+	result.Passed = len(result.MismatchedBuckets) == 0
+	if result.Passed || v.opts.SampleRows <= 0 {
+		return result, nil
+	}
+	return result, errors.Trace(v.sampleMismatches(ctx, dwVerifier, tableFQN, targetTable, columns, pkColumns, where, result))
+}
+
+// splitTable splits the table into ranges of the integer column between its min and max at the TSO
+func (v *Verifier) splitTable(ctx context.Context, sourceDatabase, sourceTable, keyColumn, where string) ([]validation.KeyRange, error) {
+	query := validation.AppendWhere(fmt.Sprintf("SELECT CAST(MIN(%[1]s) AS CHAR), CAST(MAX(%[1]s) AS CHAR) FROM %s.%s", tidbsql.QuoteIdent(keyColumn),
+		tidbsql.QuoteIdent(sourceDatabase), tidbsql.QuoteIdent(sourceTable)), where)
+	var lower, upper sql.NullString
+	err := withSnapshot(ctx, v.tidbPool, v.tso, func(conn *sql.Conn) error {
+		return diag.WrapSQL(conn.QueryRowContext(ctx, query).Scan(&lower, &upper), query)
+	})
+	if err != nil {
+		return nil, diag.Source(errors.Annotatef(err, "Failed to get the range of %s", keyColumn))
+	}
+	// an empty table is one bucket
+	if !lower.Valid || !upper.Valid {
+		return []validation.KeyRange{{Column: keyColumn}}, nil
+	}
+	ranges, err := validation.SplitKeyRange(keyColumn, lower.String, upper.String, v.opts.Buckets)
+	return ranges, errors.Trace(err)
+}
+
+// sampleMismatches reads the rows of the buckets differing from both sides, until SampleRows rows differing are found
+func (v *Verifier) sampleMismatches(
+	ctx context.Context,
+	dwVerifier coreinterfaces.TableVerifier,
+	tableFQN, targetTable string,
+	columns, pkColumns []cloudstorage.TableCol,
+	where string,
+	result *validation.TableVerification,
+) error {
+	if len(pkColumns) == 0 {
+		result.SampleSkipped = "the table has no primary key"
+		return nil
+	}
+	// the primary key columns are read first
+	ordered := append(append([]cloudstorage.TableCol{}, pkColumns...), columns...)
+	verifyColumns, ok := validation.VerifyColumns(ordered)
+	if !ok {
+		result.SampleSkipped = "the primary key has a column compared neither as a number nor as a string"
+		return nil
+	}
+	verifyColumns = dedupVerifyColumns(verifyColumns)
+	names := make([]string, 0, len(verifyColumns))
+	for _, column := range verifyColumns {
+		names = append(names, column.Name)
+	}
+	pkNames := names[:len(pkColumns)]
+
+	sourceDatabase, sourceTable := utils.SplitTableFQN(tableFQN)
+	sourceTableName := fmt.Sprintf("%s.%s", tidbsql.QuoteIdent(sourceDatabase), tidbsql.QuoteIdent(sourceTable))
+	for _, bucket := range result.MismatchedBuckets {
+		// the rows of a bucket are matched only if they are read completely from both sides, or if the rows
+		// after the limit can be told by the integer key
+		if result.KeyColumn == "" && max(bucket.Source.Rows, bucket.Target.Rows) > int64(v.opts.ScanRows) {
+			result.SampleSkipped = fmt.Sprintf("the table has more than %d rows and no integer primary key to read them by ranges", v.opts.ScanRows)
+			return nil
+		}
+		query := validation.GenScanQuery(sourceTableName, names, pkNames, "CHAR", v.opts.ScanRows, tidbsql.QuoteIdent, where, bucket.Range.Predicate(tidbsql.QuoteIdent))
+		var source []validation.Row
+		err := withSnapshot(ctx, v.tidbPool, v.tso, func(conn *sql.Conn) error {
+			var err error
+			source, err = validation.QueryRows(ctx, conn, query)
+			return errors.Trace(err)
+		})
+		if err != nil {
+			return diag.Source(errors.Annotatef(err, "Failed to read %s at TSO %d", tableFQN, v.tso))
+		}
+		target, err := dwVerifier.ScanRange(targetTable, names, pkNames, bucket.Range, v.opts.ScanRows)
+		if err != nil {
+			return diag.Warehouse(errors.Annotatef(err, "Failed to read %s in data warehouse", tableFQN))
+		}
+		source, target = completeRows(source, target, v.opts.ScanRows)
+		for _, mismatch := range validation.DiffRows(verifyColumns, len(pkNames), source, target) {
+			if len(result.Mismatches) >= v.opts.SampleRows {
+				return nil
+			}
+			result.Mismatches = append(result.Mismatches, mismatch)
+		}
+	}
+	return nil
+}
+
+// completeRows returns the rows read completely from both sides. A side returning limit rows may have more, the
+// rows from the last key of the first column it returns are dropped from both sides.
+func completeRows(source, target []validation.Row, limit int) ([]validation.Row, []validation.Row) {
+	var cutoff *big.Int
+	for _, rows := range [][]validation.Row{source, target} {
+		if len(rows) < limit || len(rows) == 0 {
+			continue
+		}
+		last, ok := new(big.Int).SetString(rows[len(rows)-1][0].String, 10)
+		if ok && (cutoff == nil || last.Cmp(cutoff) < 0) {
+			cutoff = last
+		}
+	}
+	if cutoff == nil {
+		return source, target
+	}
+	before := func(rows []validation.Row) []validation.Row {
+		var kept []validation.Row
+		for _, row := range rows {
+			if key, ok := new(big.Int).SetString(row[0].String, 10); ok && key.Cmp(cutoff) < 0 {
+				kept = append(kept, row)
+			}
+		}
+		return kept
+	}
+	return before(source), before(target)
+}
+
+func dedupVerifyColumns(columns []validation.VerifyColumn) []validation.VerifyColumn {
+	seen := make(map[string]struct{}, len(columns))
+	deduped := columns[:0]
+	for _, column := range columns {
+		if _, ok := seen[column.Name]; ok {
+			continue
+		}
+		seen[column.Name] = struct{}{}
+		deduped = append(deduped, column)
+	}
+	return deduped
+}
+
+func addAggregates(total, aggregates *validation.Aggregates) {
+	total.Rows += aggregates.Rows
+	for i, sum := range aggregates.Sums {
+		if i >= len(total.Sums) {
+			total.Sums = append(total.Sums, "0")
+		}
+		x, ok1 := new(big.Rat).SetString(total.Sums[i])
+		y, ok2 := new(big.Rat).SetString(sum)
+		if ok1 && ok2 {
+			total.Sums[i] = x.Add(x, y).FloatString(max(scaleOf(total.Sums[i]), scaleOf(sum)))
+		}
+	}
+}
+
+// scaleOf returns the digits after the decimal point of the number
+func scaleOf(number string) int {
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		return len(number) - i - 1
+	}
+	return 0
+}
+
+// runConcurrently runs f for 0 to n-1 by up to concurrency workers, the first error is returned once all are done
+func runConcurrently(ctx context.Context, n, concurrency int, f func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	indexes := make(chan int)
+	for w := 0; w < max(concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := f(i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// PendingIncrementFiles returns the number of the increment files of the table written by TiCDC but not merged into
+// the data warehouse as recorded by the checkpoint
+func PendingIncrementFiles(ctx context.Context, extStorage storage.ExternalStorage, checkpoint *IncrementCheckpoint, tableFQN string) (int, error) {
+	// the manifest and the converted CSV file of a file have the same index as it
+	type file struct {
+		key     cloudstorage.DmlPathKey
+		fileIdx uint64
+	}
+	pending := make(map[file]struct{})
+	opt := &storage.WalkOption{SubDir: strings.Replace(tableFQN, ".", "/", 1)}
+	err := extStorage.WalkDir(ctx, opt, func(path string, _ int64) error {
+		if cloudstorage.IsSchemaFile(path) {
+			return nil
+		}
+		var key cloudstorage.DmlPathKey
+		fileIdx, err := key.ParseDMLFilePath(config.DateSeparatorDay.String(), path)
+		if err != nil || fmt.Sprintf("%s.%s", key.Schema, key.Table) != tableFQN {
+			return nil
+		}
+		if !checkpoint.isMerged(key, fileIdx) {
+			pending[file{key: key, fileIdx: fileIdx}] = struct{}{}
+			log.Debug("Increment file not merged", zap.String("path", path))
+		}
+		return nil
+	})
+	return len(pending), errors.Trace(err)
+}
@@ -0,0 +1,102 @@
+package replicate
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pingcap-inc/tidb2dw/pkg/stagingformat"
+	"github.com/pingcap-inc/tidb2dw/pkg/validation"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/br/pkg/storage"
+	"github.com/pingcap/tiflow/pkg/sink/cloudstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteRows(t *testing.T) {
+	rows := func(keys ...string) []validation.Row {
+		var result []validation.Row
+		for _, key := range keys {
+			result = append(result, validation.Row{{String: key, Valid: true}})
+		}
+		return result
+	}
+
+	// both sides read completely
+	source, target := completeRows(rows("1", "2"), rows("1"), 3)
+	require.Equal(t, rows("1", "2"), source)
+	require.Equal(t, rows("1"), target)
+
+	// the rows from the smallest last key of a side truncated are dropped
+	source, target = completeRows(rows("1", "2", "5"), rows("1", "3", "4", "6"), 3)
+	require.Equal(t, rows("1", "2"), source)
+	require.Equal(t, rows("1", "3", "4"), target)
+}
+
+func TestRunConcurrently(t *testing.T) {
+	ctx := context.Background()
+	var (
+		running, maxRunning atomic.Int32
+		done                [10]bool
+	)
+	require.NoError(t, runConcurrently(ctx, len(done), 3, func(i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		done[i] = true
+		return nil
+	}))
+	require.LessOrEqual(t, maxRunning.Load(), int32(3))
+	for i := range done {
+		require.True(t, done[i])
+	}
+
+	err := runConcurrently(ctx, 5, 2, func(i int) error {
+		if i == 1 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	require.ErrorContains(t, err, "failed")
+}
+
+func TestPendingIncrementFiles(t *testing.T) {
+	ctx := context.Background()
+	extStorage, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	for _, path := range []string{
+		"db/t/meta/schema_100_0000000000.json",
+		"db/t/100/2024-01-01/CDC000001.csv",
+		"db/t/100/2024-01-01/CDC000001.manifest",
+		"db/t/100/2024-01-01/CDC000002.csv",
+		"db/t/100/2024-01-02/CDC000001.json",
+		"db/t/100/2024-01-02/CDC000001.csv",
+		"db/t2/100/2024-01-01/CDC000001.csv",
+	} {
+		require.NoError(t, extStorage.WriteFile(ctx, path, []byte("x")))
+	}
+	checkpoint, err := LoadIncrementCheckpoint(ctx, extStorage)
+	require.NoError(t, err)
+
+	pending, err := PendingIncrementFiles(ctx, extStorage, checkpoint, "db.t")
+	require.NoError(t, err)
+	require.Equal(t, 3, pending)
+
+	key := cloudstorage.DmlPathKey{
+		SchemaPathKey: cloudstorage.SchemaPathKey{Schema: "db", Table: "t", TableVersion: 100},
+		Date:          "2024-01-01",
+	}
+	require.NoError(t, checkpoint.advance(ctx, key, 2, 10, stagingformat.CSV))
+	pending, err = PendingIncrementFiles(ctx, extStorage, checkpoint, "db.t")
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+
+	pending, err = PendingIncrementFiles(ctx, extStorage, checkpoint, "db.missing")
+	require.NoError(t, err)
+	require.Zero(t, pending)
+}
@@ -0,0 +1,282 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pingcap-inc/tidb2dw/pkg/tidbsql"
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// The containers run on a network of their own and publish their ports on ephemeral host ports, so that
+// several runs can share a host. MinIO is published on the gateway of the network, which is reachable from
+// both TiCDC and the test process, so that the storage URI handed to TiCDC is the one the test reads.
+// All containers and the network carry the label of the run and are removed when the tests end or are
+// interrupted.
+const (
+	labelKey     = "tidb2dw-it"
+	tidbVersion  = "v7.5.0"
+	minioImage   = "minio/minio:latest"
+	minioUser    = "minioadmin"
+	minioPass    = "minioadmin"
+	minioBucket  = "tidb2dw"
+	startTimeout = 3 * time.Minute
+)
+
+type container struct {
+	name    string
+	image   string
+	env     []string
+	args    []string
+	publish string
+	// onGateway publishes the port on the gateway of the network instead of the loopback
+	onGateway bool
+}
+
+var containers = []container{
+	{
+		name:  "pd",
+		image: "pingcap/pd:" + tidbVersion,
+		args: []string{"--name=pd", "--data-dir=/data",
+			"--client-urls=http://0.0.0.0:2379", "--advertise-client-urls=http://pd:2379",
+			"--peer-urls=http://0.0.0.0:2380", "--advertise-peer-urls=http://pd:2380"},
+	},
+	{
+		name:  "tikv",
+		image: "pingcap/tikv:" + tidbVersion,
+		args: []string{"--addr=0.0.0.0:20160", "--advertise-addr=tikv:20160",
+			"--status-addr=0.0.0.0:20180", "--advertise-status-addr=tikv:20180", "--pd=pd:2379", "--data-dir=/data"},
+	},
+	{
+		name:    "tidb",
+		image:   "pingcap/tidb:" + tidbVersion,
+		args:    []string{"--store=tikv", "--path=pd:2379", "-P=4000", "--status=10080", "--advertise-address=tidb"},
+		publish: "4000",
+	},
+	{
+		name:    "ticdc",
+		image:   "pingcap/ticdc:" + tidbVersion,
+		args:    []string{"/cdc", "server", "--pd=http://pd:2379", "--addr=0.0.0.0:8300", "--advertise-addr=ticdc:8300", "--tz=" + cdcTimeZone},
+		publish: "8300",
+	},
+	{
+		name:      "minio",
+		image:     minioImage,
+		env:       []string{"MINIO_ROOT_USER=" + minioUser, "MINIO_ROOT_PASSWORD=" + minioPass},
+		args:      []string{"server", "/data", "--address=:9000", "--console-address=:9001"},
+		publish:   "9000",
+		onGateway: true,
+	},
+}
+
+// cluster is the TiDB cluster, TiCDC and MinIO shared by all tests in the package
+type cluster struct {
+	TiDBConfig *tidbsql.TiDBConfig
+	CDCHost    string
+	CDCPort    int
+}
+
+var (
+	clusterOnce sync.Once
+	clusterErr  error
+	shared      *cluster
+	// minioEndpoint is set once MinIO is started
+	minioEndpoint string
+	// runID tells the containers of this run from those of other runs on the host
+	runID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+)
+
+func TestMain(m *testing.M) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		reap()
+		os.Exit(1)
+	}()
+	code := m.Run()
+	reap()
+	os.Exit(code)
+}
+
+// setupCluster starts the cluster on first use, the test is skipped if docker is not available
+func setupCluster(t *testing.T) *cluster {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is not available")
+	}
+	clusterOnce.Do(func() {
+		shared, clusterErr = startCluster()
+	})
+	require.NoError(t, clusterErr)
+	c := *shared
+	tidbConfig := *shared.TiDBConfig
+	c.TiDBConfig = &tidbConfig
+	return &c
+}
+
+func label() string {
+	return labelKey + "=" + runID
+}
+
+func networkName() string {
+	return labelKey + "-" + runID
+}
+
+// reap removes the containers and the network of this run
+func reap() {
+	out, _ := exec.Command("docker", "ps", "-aq", "--filter", "label="+label()).Output()
+	if ids := strings.Fields(string(out)); len(ids) > 0 {
+		_ = exec.Command("docker", append([]string{"rm", "-f", "-v"}, ids...)...).Run()
+	}
+	_ = exec.Command("docker", "network", "rm", networkName()).Run()
+}
+
+func docker(args ...string) (string, error) {
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		return "", errors.Annotatef(err, "docker %s: %s", args[0], strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// mappedPort returns the host and port the port of the container is published on
+func mappedPort(name, port string) (string, int, error) {
+	out, err := docker("port", name, port+"/tcp")
+	if err != nil {
+		return "", 0, err
+	}
+	host, p, err := net.SplitHostPort(strings.Fields(out)[0])
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	mapped, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, errors.Trace(err)
+	}
+	return host, mapped, nil
+}
+
+func startCluster() (*cluster, error) {
+	network := networkName()
+	if _, err := docker("network", "create", "--label", label(), network); err != nil {
+		return nil, err
+	}
+	gateway, err := docker("network", "inspect", "-f", "{{range .IPAM.Config}}{{.Gateway}}{{end}}", network)
+	if err != nil {
+		return nil, err
+	}
+	if gateway == "" {
+		return nil, errors.Errorf("network %s has no gateway", network)
+	}
+
+	ports := make(map[string]int)
+	for _, c := range containers {
+		name := network + "-" + c.name
+		args := []string{"run", "-d", "--name", name, "--label", label(), "--network", network, "--network-alias", c.name}
+		if c.publish != "" {
+			bind := "127.0.0.1"
+			if c.onGateway {
+				bind = gateway
+			}
+			args = append(args, "-p", fmt.Sprintf("%s::%s", bind, c.publish))
+		}
+		for _, env := range c.env {
+			args = append(args, "-e", env)
+		}
+		args = append(args, c.image)
+		args = append(args, c.args...)
+		if _, err := docker(args...); err != nil {
+			return nil, errors.Annotatef(err, "Failed to start %s", name)
+		}
+		if c.publish != "" {
+			_, port, err := mappedPort(name, c.publish)
+			if err != nil {
+				return nil, err
+			}
+			ports[c.name] = port
+		}
+	}
+
+	c := &cluster{
+		TiDBConfig: &tidbsql.TiDBConfig{Host: "127.0.0.1", Port: ports["tidb"], User: "root"},
+		CDCHost:    "127.0.0.1",
+		CDCPort:    ports["ticdc"],
+	}
+	minioEndpoint = fmt.Sprintf("http://%s", net.JoinHostPort(gateway, strconv.Itoa(ports["minio"])))
+
+	if err := waitFor("TiDB", func() error {
+		db, err := c.TiDBConfig.OpenDB()
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}); err != nil {
+		return nil, err
+	}
+	if err := waitFor("TiCDC", func() error {
+		resp, err := http.Get(fmt.Sprintf("http://%s/status", net.JoinHostPort(c.CDCHost, strconv.Itoa(c.CDCPort))))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	client := s3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(minioEndpoint),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials(minioUser, minioPass, ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+	if err := waitFor("MinIO", func() error {
+		_, err := client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(minioBucket)})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func waitFor(component string, ready func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	for {
+		err := ready()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Annotatef(err, "%s is not ready after %s", component, startTimeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// minioStorageURI returns a dedicated workspace in MinIO for the test
+func minioStorageURI(t *testing.T) string {
+	return fmt.Sprintf("s3://%s/%s/%d?endpoint=%s&force-path-style=true&region=us-east-1&access-key=%s&secret-access-key=%s",
+		minioBucket, t.Name(), time.Now().UnixNano(), minioEndpoint, minioUser, minioPass)
+}
@@ -0,0 +1,327 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pingcap-inc/tidb2dw/pkg/cdc"
+	"github.com/pingcap-inc/tidb2dw/pkg/coreinterfaces"
+	"github.com/pingcap-inc/tidb2dw/pkg/databrickssql"
+	"github.com/pingcap-inc/tidb2dw/pkg/engine"
+	"github.com/pingcap-inc/tidb2dw/pkg/fakewarehouse"
+	"github.com/pingcap-inc/tidb2dw/pkg/identcase"
+	"github.com/pingcap-inc/tidb2dw/pkg/snowsql"
+	"github.com/pingcap-inc/tidb2dw/pkg/utils"
+	"github.com/pingcap-inc/tidb2dw/replicate"
+	"github.com/stretchr/testify/require"
+)
+
+// newConnectorsFunc creates the snapshot and increment connectors of a table
+type newConnectorsFunc func(t *testing.T, snapshotURI, incrementURI *url.URL) (coreinterfaces.Connector, coreinterfaces.Connector)
+
+// replication is a pipeline running in full mode
+type replication struct {
+	pipeline *engine.Pipeline
+	done     chan error
+}
+
+func startReplication(t *testing.T, c *cluster, storagePath string, tableFQN string, newConnectors newConnectorsFunc) *replication {
+	storageURI, err := utils.NormalizeStorageURI(storagePath, "s3")
+	require.NoError(t, err)
+	snapshotURI, incrementURI, err := engine.GenSnapshotAndIncrementURIs(storageURI)
+	require.NoError(t, err)
+
+	snapConnector, increConnector := newConnectors(t, snapshotURI, incrementURI)
+	t.Cleanup(func() {
+		snapConnector.Close()
+		increConnector.Close()
+	})
+
+	cdcClient, err := cdc.NewClient(c.CDCHost, c.CDCPort, nil)
+	require.NoError(t, err)
+	pipeline, err := engine.NewPipeline(engine.PipelineConfig{
+		TiDBConfig:           c.TiDBConfig,
+		Tables:               []string{tableFQN},
+		StorageURI:           storageURI,
+		SnapshotConcurrency:  4,
+		CDC:                  cdcClient,
+		CDCFlushInterval:     5 * time.Second,
+		CDCFileSize:          64 * 1024 * 1024,
+		SnapshotCompression:  utils.CompressionNone,
+		IncrementCompression: utils.CompressionNone,
+		IncrementOptions:     engine.IncrementOptions{Cleanup: replicate.CleanupPolicy{Enabled: true}},
+		SnapConnectorMap:     map[string]coreinterfaces.Connector{tableFQN: snapConnector},
+		IncreConnectorMap:    map[string]coreinterfaces.Connector{tableFQN: increConnector},
+		Mode:                 engine.RunModeFull,
+	})
+	require.NoError(t, err)
+	r := &replication{pipeline: pipeline, done: make(chan error, 1)}
+	go func() {
+		r.done <- pipeline.Run(context.Background())
+	}()
+	t.Cleanup(func() { r.stop(t) })
+	return r
+}
+
+// stop stops the pipeline and waits for it to return, it can be called more than once
+func (r *replication) stop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(t, r.pipeline.Stop(ctx), "replication does not stop after one minute")
+	select {
+	case err, ok := <-r.done:
+		if ok {
+			require.NoError(t, err)
+			close(r.done)
+		}
+	case <-time.After(time.Minute):
+		require.Fail(t, "replication does not stop after one minute")
+	}
+}
+
+// waitForStage waits until the replication reaches the stage or a later one
+func (r *replication) waitForStage(t *testing.T, stage engine.Stage) {
+	target := slices.Index(stages, stage)
+	require.Eventually(t, func() bool {
+		return slices.Index(stages, r.pipeline.Stage()) >= target
+	}, equivalenceTimeout, 10*time.Millisecond, "replication does not reach stage %s", stage)
+}
+
+// stages are the stages of the replication in order
+var stages = []engine.Stage{engine.StageInit, engine.StageChangefeedCreated, engine.StageSnapshotDumped, engine.StageSnapshotLoaded}
+
+// memoryConnectors returns the connectors of the tables in the memory warehouse
+func memoryConnectors(warehouse *fakewarehouse.Warehouse) newConnectorsFunc {
+	return func(t *testing.T, snapshotURI, incrementURI *url.URL) (coreinterfaces.Connector, coreinterfaces.Connector) {
+		snapConnector, err := fakewarehouse.NewConnector(context.Background(), warehouse, snapshotURI)
+		require.NoError(t, err)
+		increConnector, err := fakewarehouse.NewConnector(context.Background(), warehouse, incrementURI)
+		require.NoError(t, err)
+		return snapConnector, increConnector
+	}
+}
+
+// runWorkload replicates the initial rows by the snapshot, then the DML, a batch of mostly deletes and the DDL by
+// the increment. A new connector is tested by running it with the connectors of the data warehouse and a query of
+// its rows.
+func runWorkload(t *testing.T, c *cluster, storagePath string, newConnectors newConnectorsFunc, warehouseRows func() ([][]*string, error)) {
+	tidb, err := c.TiDBConfig.OpenDB()
+	require.NoError(t, err)
+	defer tidb.Close()
+
+	w := newWorkload(t, tidb, 200)
+	r := startReplication(t, c, storagePath, w.tableFQN(), newConnectors)
+	query := fmt.Sprintf("SELECT * FROM %s", w.tableFQN())
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	w.mutate(t, 300)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	w.deleteMost(t)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	w.addColumn(t, "quantity")
+	w.mutate(t, 100)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	r.stop(t)
+}
+
+// runRestartWorkload stops the replication once it reaches the stage, changes the rows while it is stopped, and
+// replicates them by a new replication of the same workspace as a restarted process does
+func runRestartWorkload(t *testing.T, c *cluster, storagePath string, stage engine.Stage, newConnectors newConnectorsFunc, warehouseRows func() ([][]*string, error)) {
+	tidb, err := c.TiDBConfig.OpenDB()
+	require.NoError(t, err)
+	defer tidb.Close()
+
+	w := newWorkload(t, tidb, 2000)
+	r := startReplication(t, c, storagePath, w.tableFQN(), newConnectors)
+	r.waitForStage(t, stage)
+	r.stop(t)
+	w.mutate(t, 100)
+
+	r = startReplication(t, c, storagePath, w.tableFQN(), newConnectors)
+	query := fmt.Sprintf("SELECT * FROM %s", w.tableFQN())
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	w.addColumn(t, "quantity")
+	w.mutate(t, 100)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	r.stop(t)
+}
+
+func TestReplicateFullToMemoryWarehouse(t *testing.T) {
+	c := setupCluster(t)
+	warehouse := fakewarehouse.New()
+	runWorkload(t, c, minioStorageURI(t), memoryConnectors(warehouse), func() ([][]*string, error) {
+		return warehouse.Rows(), nil
+	})
+	require.Equal(t, 1, warehouse.CountStatements("CREATE TABLE"))
+	require.Positive(t, warehouse.CountStatements("LOAD SNAPSHOT"))
+	require.Positive(t, warehouse.CountStatements("MERGE"))
+}
+
+// TestReplicateRestart restarts the replication at each stage, the stages left are resumed from the storage
+func TestReplicateRestart(t *testing.T) {
+	c := setupCluster(t)
+	for _, stage := range stages {
+		t.Run(string(stage), func(t *testing.T) {
+			warehouse := fakewarehouse.New()
+			runRestartWorkload(t, c, minioStorageURI(t), stage, memoryConnectors(warehouse), func() ([][]*string, error) {
+				return warehouse.Rows(), nil
+			})
+			// the snapshot files loaded before the restart are not loaded again
+			loaded := make(map[string]bool)
+			for _, statement := range warehouse.Statements() {
+				if strings.HasPrefix(statement, "LOAD SNAPSHOT") {
+					require.False(t, loaded[statement], "%s is executed twice", statement)
+					loaded[statement] = true
+				}
+			}
+		})
+	}
+}
+
+// TestReplicateTimeZone replicates DATETIME and TIMESTAMP values around the DST changes of the time zone of TiCDC,
+// the TIMESTAMP values in the data warehouse must be the wall clock of the TiDB sessions in --tz
+func TestReplicateTimeZone(t *testing.T) {
+	c := setupCluster(t)
+	tidbConfig := *c.TiDBConfig
+	tidbConfig.TimeZone = cdcTimeZone
+	c.TiDBConfig = &tidbConfig
+	tidb, err := c.TiDBConfig.OpenDB()
+	require.NoError(t, err)
+	defer tidb.Close()
+
+	tableFQN := fmt.Sprintf("%s.%s", workloadDatabase, "times")
+	insert := fmt.Sprintf("INSERT INTO %s (id, dt, ts) VALUES (?, ?, ?)", tableFQN)
+	for _, query := range []string{
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", workloadDatabase),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s", tableFQN),
+		fmt.Sprintf("CREATE TABLE %s (id INT PRIMARY KEY, dt DATETIME, ts TIMESTAMP NULL)", tableFQN),
+	} {
+		_, err = tidb.Exec(query)
+		require.NoError(t, err, query)
+	}
+	// the clocks skip from 02:00 to 03:00 on 2023-03-12, and repeat 01:00 to 02:00 on 2023-11-05
+	values := []string{"2023-03-12 01:59:59", "2023-03-12 03:00:00", "2023-11-05 00:59:59", "2023-11-05 02:00:00", "2023-07-01 12:00:00"}
+	for i, value := range values {
+		_, err = tidb.Exec(insert, i+1, value, value)
+		require.NoError(t, err)
+	}
+
+	warehouse := fakewarehouse.New()
+	newConnectors := memoryConnectors(warehouse)
+	warehouseRows := func() ([][]*string, error) {
+		return warehouse.Rows(), nil
+	}
+	r := startReplication(t, c, minioStorageURI(t), tableFQN, newConnectors)
+	query := fmt.Sprintf("SELECT * FROM %s", tableFQN)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	for i, value := range values {
+		_, err = tidb.Exec(insert, len(values)+i+1, value, value)
+		require.NoError(t, err)
+	}
+	_, err = tidb.Exec(fmt.Sprintf("UPDATE %s SET ts = NULL WHERE id = 1", tableFQN))
+	require.NoError(t, err)
+	requireEquivalent(t, tidb, query, warehouseRows)
+
+	r.stop(t)
+}
+
+// realStorageURI returns the S3 workspace read by a real data warehouse, which can not reach MinIO.
+// The URI must carry access-key and secret-access-key.
+func realStorageURI(t *testing.T) string {
+	storagePath := os.Getenv("TIDB2DW_IT_STORAGE")
+	if storagePath == "" {
+		t.Skip("TIDB2DW_IT_STORAGE is not set")
+	}
+	uri, err := url.Parse(storagePath)
+	require.NoError(t, err)
+	uri = uri.JoinPath(t.Name(), fmt.Sprint(time.Now().UnixNano()))
+	return uri.String()
+}
+
+func requireEnv(t *testing.T, names ...string) map[string]string {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if values[name] = os.Getenv(name); values[name] == "" {
+			t.Skipf("%s is not set", name)
+		}
+	}
+	return values
+}
+
+func TestReplicateFullToSnowflake(t *testing.T) {
+	env := requireEnv(t, "SNOWFLAKE_ACCOUNT_ID", "SNOWFLAKE_WAREHOUSE", "SNOWFLAKE_USER", "SNOWFLAKE_PASS", "SNOWFLAKE_DATABASE", "SNOWFLAKE_SCHEMA")
+	storagePath := realStorageURI(t)
+	c := setupCluster(t)
+
+	config := &snowsql.SnowflakeConfig{
+		AccountId: env["SNOWFLAKE_ACCOUNT_ID"],
+		Warehouse: env["SNOWFLAKE_WAREHOUSE"],
+		User:      env["SNOWFLAKE_USER"],
+		Pass:      env["SNOWFLAKE_PASS"],
+		Database:  env["SNOWFLAKE_DATABASE"],
+		Schema:    env["SNOWFLAKE_SCHEMA"],
+	}
+	db, err := config.OpenDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	newConnectors := func(t *testing.T, snapshotURI, incrementURI *url.URL) (coreinterfaces.Connector, coreinterfaces.Connector) {
+		cred := credentials.NewStaticCredentials(snapshotURI.Query().Get("access-key"), snapshotURI.Query().Get("secret-access-key"), "")
+		snapConnector, err := snowsql.NewSnowflakeConnector(db, identcase.Upper, "snapshot_external_"+workloadTable, snapshotURI, cred, utils.CompressionNone)
+		require.NoError(t, err)
+		increConnector, err := snowsql.NewSnowflakeConnector(db, identcase.Upper, "increment_external_"+workloadTable, incrementURI, cred, utils.CompressionNone)
+		require.NoError(t, err)
+		return snapConnector, increConnector
+	}
+	runWorkload(t, c, storagePath, newConnectors, func() ([][]*string, error) {
+		return queryRows(db, "SELECT * FROM "+workloadTable)
+	})
+}
+
+func TestReplicateFullToDatabricks(t *testing.T) {
+	env := requireEnv(t, "DATABRICKS_HOST", "DATABRICKS_TOKEN", "DATABRICKS_ENDPOINT", "DATABRICKS_CATALOG", "DATABRICKS_SCHEMA", "DATABRICKS_CREDENTIAL")
+	storagePath := realStorageURI(t)
+	c := setupCluster(t)
+
+	config := &databrickssql.DataBricksConfig{
+		Host:     env["DATABRICKS_HOST"],
+		Port:     443,
+		Token:    env["DATABRICKS_TOKEN"],
+		Endpoint: env["DATABRICKS_ENDPOINT"],
+		Catalog:  env["DATABRICKS_CATALOG"],
+		Schema:   env["DATABRICKS_SCHEMA"],
+		// the names are lowercased as Unity Catalog stores them
+		IdentifierCase: identcase.Lower,
+	}
+	db, err := config.OpenDB()
+	require.NoError(t, err)
+	defer db.Close()
+
+	newConnectors := func(t *testing.T, snapshotURI, incrementURI *url.URL) (coreinterfaces.Connector, coreinterfaces.Connector) {
+		snapConnector, err := databrickssql.NewDatabricksConnector(db, config, env["DATABRICKS_CREDENTIAL"], snapshotURI, utils.CompressionNone)
+		require.NoError(t, err)
+		snapConnector.SetNamespace(config.Namespace())
+		increConnector, err := databrickssql.NewDatabricksConnector(db, config, env["DATABRICKS_CREDENTIAL"], incrementURI, utils.CompressionNone)
+		require.NoError(t, err)
+		increConnector.SetNamespace(config.Namespace())
+		return snapConnector, increConnector
+	}
+	runWorkload(t, c, storagePath, newConnectors, func() ([][]*string, error) {
+		return queryRows(db, "SELECT * FROM "+workloadTable)
+	})
+}
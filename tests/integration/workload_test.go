@@ -0,0 +1,193 @@
+//go:build integration
+
+package integration_test
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	workloadDatabase = "tidb2dw_it"
+	workloadTable    = "orders"
+
+	equivalenceTimeout = 5 * time.Minute
+
+	// cdcTimeZone is the time zone of TiCDC, which has daylight saving time
+	cdcTimeZone = "America/New_York"
+)
+
+// awkwardStrings are written by the workload to exercise quoting and escaping in the CSV files
+var awkwardStrings = []string{"", "N", `\N`, "comma,inside", `quote"inside`, `back\slash`, "new\nline", "tab\tand\rreturn", "中文"}
+
+// workload generates DML and DDL on a single table of TiDB
+type workload struct {
+	db     *sql.DB
+	rand   *rand.Rand
+	nextID int
+}
+
+// newWorkload recreates the table and inserts the initial rows which are replicated by the snapshot
+func newWorkload(t *testing.T, db *sql.DB, initialRows int) *workload {
+	w := &workload{db: db, rand: rand.New(rand.NewSource(time.Now().UnixNano())), nextID: 1}
+	w.exec(t, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", workloadDatabase))
+	w.exec(t, fmt.Sprintf("DROP TABLE IF EXISTS %s", w.tableFQN()))
+	w.exec(t, fmt.Sprintf(`CREATE TABLE %s (
+		id INT PRIMARY KEY,
+		name VARCHAR(64) NOT NULL,
+		price DECIMAL(10, 2),
+		note TEXT
+	)`, w.tableFQN()))
+	w.insert(t, initialRows)
+	return w
+}
+
+func (w *workload) tableFQN() string {
+	return fmt.Sprintf("%s.%s", workloadDatabase, workloadTable)
+}
+
+func (w *workload) exec(t *testing.T, query string, args ...any) {
+	_, err := w.db.Exec(query, args...)
+	require.NoError(t, err, query)
+}
+
+func (w *workload) insert(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		w.insertRow(t, w.nextID)
+		w.nextID++
+	}
+}
+
+func (w *workload) insertRow(t *testing.T, id int) {
+	var note any
+	if w.rand.Intn(4) > 0 {
+		note = awkwardStrings[w.rand.Intn(len(awkwardStrings))]
+	}
+	w.exec(t, fmt.Sprintf("INSERT INTO %s (id, name, price, note) VALUES (?, ?, ?, ?)", w.tableFQN()),
+		id, fmt.Sprintf("name-%d", id), float64(w.rand.Intn(100000))/100, note)
+}
+
+// mutate runs a mix of inserts, updates and deletes on the existing rows
+func (w *workload) mutate(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		id := w.rand.Intn(w.nextID) + 1
+		switch w.rand.Intn(3) {
+		case 0:
+			w.insert(t, 1)
+		case 1:
+			w.exec(t, fmt.Sprintf("UPDATE %s SET price = price + 1, note = ? WHERE id = ?", w.tableFQN()),
+				awkwardStrings[w.rand.Intn(len(awkwardStrings))], id)
+		case 2:
+			w.exec(t, fmt.Sprintf("DELETE FROM %s WHERE id = ?", w.tableFQN()), id)
+		}
+	}
+}
+
+// deleteMost deletes 3 of every 4 rows by one statement, so that the increment files are mostly deletes, then
+// inserts some of the rows deleted again, which must not be deleted by the merge of the deletes
+func (w *workload) deleteMost(t *testing.T) {
+	w.exec(t, fmt.Sprintf("DELETE FROM %s WHERE id %% 4 <> 0", w.tableFQN()))
+	for id := 1; id <= 20 && id < w.nextID; id++ {
+		if id%4 != 0 {
+			w.insertRow(t, id)
+		}
+	}
+}
+
+// addColumn adds a nullable column, the rows written before have NULL in it
+func (w *workload) addColumn(t *testing.T, name string) {
+	w.exec(t, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s INT", w.tableFQN(), name))
+	w.exec(t, fmt.Sprintf("UPDATE %s SET %s = id WHERE id %% 2 = 0", w.tableFQN(), name))
+}
+
+// queryRows returns the rows of the query as strings, NULL is represented by nil
+func queryRows(db *sql.DB, query string) ([][]*string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var result [][]*string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, errors.Trace(err)
+		}
+		row := make([]*string, len(columns))
+		for i, v := range values {
+			if v.Valid {
+				s := v.String
+				row[i] = &s
+			}
+		}
+		result = append(result, row)
+	}
+	return result, errors.Trace(rows.Err())
+}
+
+// canonicalRows formats the rows into sorted strings so that rows sets from different sources are comparable
+func canonicalRows(rows [][]*string) []string {
+	result := make([]string, 0, len(rows))
+	for _, row := range rows {
+		fields := make([]string, 0, len(row))
+		for _, field := range row {
+			if field == nil {
+				fields = append(fields, "NULL")
+			} else {
+				fields = append(fields, fmt.Sprintf("%q", *field))
+			}
+		}
+		result = append(result, strings.Join(fields, ","))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// requireEquivalent waits until the rows in the data warehouse are the same as the rows in TiDB
+func requireEquivalent(t *testing.T, tidb *sql.DB, query string, warehouseRows func() ([][]*string, error)) {
+	var (
+		mu               sync.Mutex
+		expected, actual []string
+	)
+	equivalent := assert.Eventually(t, func() bool {
+		source, err := queryRows(tidb, query)
+		if err != nil {
+			t.Logf("Failed to query TiDB: %v", err)
+			return false
+		}
+		target, err := warehouseRows()
+		if err != nil {
+			t.Logf("Failed to query data warehouse: %v", err)
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		expected, actual = canonicalRows(source), canonicalRows(target)
+		return slices.Equal(expected, actual)
+	}, equivalenceTimeout, 2*time.Second)
+	if !equivalent {
+		// show the difference
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, expected, actual)
+	}
+}